@@ -320,7 +320,10 @@ module.exports = grammar({
 
     enum_variant: $ => seq(
       $.identifier,
-      optional(seq('=', $.number_literal))
+      optional(choice(
+        seq('=', $.number_literal),
+        seq('(', $.type, repeat(seq(',', $.type)), ')'),  // Payload: Circle(u8)
+      )),
     ),
 
     visibility: $ => 'pub',
@@ -410,6 +413,7 @@ module.exports = grammar({
       $.case_statement,
       $.asm_block,
       $.compile_time_asm,
+      $.compile_time_for,
       $.mir_block,
       $.minz_block,
       $.target_block,
@@ -538,6 +542,12 @@ module.exports = grammar({
       field('type', $.identifier),
       '.',
       field('variant', $.identifier),
+      optional(seq(  // Payload bindings, _ included: Shape.Rect(w, _)
+        '(',
+        field('binding', $.identifier),
+        repeat(seq(',', field('binding', $.identifier))),
+        ')',
+      )),
     )),
 
     literal_pattern: $ => choice(
@@ -850,6 +860,15 @@ module.exports = grammar({
       '}',
     ),
 
+    // @for f in @fields(Point) { ... } - unrolled at compile time
+    compile_time_for: $ => seq(
+      '@for',
+      $.identifier,
+      'in',
+      $.expression,
+      $.block,
+    ),
+
     attribute: $ => prec.right(seq(
       '@',
       $.identifier,
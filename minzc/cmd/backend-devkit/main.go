@@ -199,10 +199,14 @@ func getBackend(name string) codegen.Backend {
 		return codegen.NewZ80Backend(options)
 	case "6502":
 		return codegen.NewM6502Backend(options)
+	case "65c02":
+		return codegen.NewM65C02Backend(options)
 	case "68000", "68k":
 		return codegen.NewM68kBackend(options)
 	case "i8080":
 		return codegen.NewI8080Backend(options)
+	case "i8085":
+		return codegen.NewI8085Backend(options)
 	case "gb":
 		return codegen.NewGBBackend(options)
 	case "c":
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/minz/minzc/pkg/z80asm"
+)
+
+// With --com the CP/M build goes all the way to a .COM file: the generated
+// assembly, which starts at $0100 and leaves through BDOS function 0, is
+// assembled by the built-in assembler (the one behind mza) and written as a
+// raw CP/M program. The assembly is still written next to it.
+
+// checkCOMOptions reports whether --com can be used with the other options
+func checkCOMOptions() error {
+	if !isZ80Backend(backend) || target != "cpm" || splitOutput {
+		return fmt.Errorf("--com needs the z80 backend with -t cpm and without --split-output")
+	}
+	return nil
+}
+
+// comOutputFiles returns the assembly and .COM file names for an output
+// name: -o prog.com writes prog.a80 beside prog.com
+func comOutputFiles(output string) (asmFile, comFile string) {
+	base := output[:len(output)-len(filepath.Ext(output))]
+	if strings.EqualFold(filepath.Ext(output), ".com") {
+		return base + ".a80", output
+	}
+	return output, base + ".com"
+}
+
+// writeCOMFile assembles the generated CP/M assembly into a .COM file
+func writeCOMFile(filename, assembly string) error {
+	return writeAssembled(filename, assembly, z80asm.TargetCPM)
+}
+
+// writeAssembled assembles generated assembly for a target and writes it
+// in the target's output format
+func writeAssembled(filename, assembly string, target z80asm.Target) error {
+	asm := z80asm.NewAssembler()
+	if err := asm.SetTarget(target); err != nil {
+		return err
+	}
+	result, err := asm.AssembleString(assembly)
+	if err != nil {
+		return err
+	}
+	if len(result.Errors) > 0 {
+		return assemblyErrors(result.Errors)
+	}
+	data, err := z80asm.GetTargetConfig(target).OutputFormat.Generator(result)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// assemblyErrors joins the assembler's errors, each reported once
+func assemblyErrors(errs []z80asm.AssemblerError) error {
+	var lines []string
+	seen := make(map[string]bool)
+	for _, e := range errs {
+		line := fmt.Sprintf("line %d: %s", e.Line, e.Message)
+		if !seen[line] {
+			seen[line] = true
+			lines = append(lines, "  "+line)
+		}
+	}
+	return fmt.Errorf("the generated assembly does not assemble:\n%s", strings.Join(lines, "\n"))
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/minz/minzc/pkg/z80asm"
+)
+
+// The gb backend builds a whole cartridge: the generated assembly is
+// written as usual and assembled by the built-in assembler for the
+// gameboy target into a 32K .gb ROM, with the Nintendo logo and checksums
+// the boot ROM checks, ready for an emulator or a flash cartridge.
+
+// gbOutputFiles returns the assembly and ROM file names for an output
+// name: game.gb.s and -o game.gb both write game.gb.s and game.gb
+func gbOutputFiles(output string) (asmFile, romFile string) {
+	if strings.EqualFold(filepath.Ext(output), ".gb") {
+		return output + ".s", output
+	}
+	base := output[:len(output)-len(filepath.Ext(output))]
+	if !strings.EqualFold(filepath.Ext(base), ".gb") {
+		base += ".gb"
+	}
+	return output, base
+}
+
+// writeGBFile assembles the generated Game Boy assembly into a .gb ROM
+func writeGBFile(filename, assembly string) error {
+	return writeAssembled(filename, assembly, z80asm.TargetGameBoy)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/minz/minzc/pkg/codegen"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// The --emit-listing file shows, function by function, each source line
+// followed by the MIR it lowered to and the Z80 code generated for it:
+//
+//	== main.add$u8$u8 ==
+//	(entry)
+//	        z80  add$u8$u8:
+//	add.minz:2: return a + b;
+//	        mir  r3 = r1 + r2
+//	        z80      ADD A, B
+//
+// The semantic analyzer records the line of every instruction, and the Z80
+// backend marks where the code of each line begins (see
+// codegen.SourceLineMarker).
+
+// writeListing writes the listing of a module compiled to assembly, which
+// must have been generated with source line markers
+func writeListing(filename string, module *ir.Module, assembly string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	code := functionAssembly(assembly)
+	sources := make(map[string][]string)
+	fmt.Fprintf(w, "; MinZ listing of %s\n", module.Name)
+	fmt.Fprintf(w, "; Each source line is followed by its MIR (mir) and Z80 code (z80)\n")
+	for _, fn := range module.Functions {
+		fmt.Fprintf(w, "\n== %s ==\n", fn.Name)
+		chunks := code[fn.Name]
+		runs := fn.SourceRuns()
+		// The prologue, when the first instruction already has a line
+		if len(chunks[-1]) > 0 && (len(runs) == 0 || runs[0].Line > 0) {
+			fmt.Fprintf(w, "(entry)\n")
+			writeListingCode(w, chunks[-1])
+		}
+		for _, run := range runs {
+			chunk := chunks[run.Start]
+			if run.Line == 0 {
+				fmt.Fprintf(w, "(entry)\n")
+				chunk = chunks[-1]
+			} else {
+				fmt.Fprintf(w, "%s:%d: %s\n", filepath.Base(run.File), run.Line, sourceLine(sources, run.File, run.Line))
+			}
+			for _, inst := range fn.Instructions[run.Start:run.End] {
+				fmt.Fprintf(w, "        mir  %s\n", inst.String())
+			}
+			writeListingCode(w, chunk)
+		}
+	}
+	return w.Flush()
+}
+
+// writeListingCode writes assembly lines of the listing
+func writeListingCode(w *bufio.Writer, lines []string) {
+	for _, line := range lines {
+		fmt.Fprintf(w, "        z80  %s\n", line)
+	}
+}
+
+// functionAssembly splits the code of each function by source line marker.
+// Code before the first marker (the prologue) is under index -1; comments
+// and blank lines are left out.
+func functionAssembly(assembly string) map[string]map[int][]string {
+	code := make(map[string]map[int][]string)
+	var chunks map[int][]string
+	index := -1
+	for _, line := range strings.Split(assembly, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if name, ok := strings.CutPrefix(trimmed, "; Function: "); ok {
+			name, _, _ = strings.Cut(name, " ")
+			chunks = make(map[int][]string)
+			code[name] = chunks
+			index = -1
+			continue
+		}
+		if strings.HasPrefix(trimmed, "; End of function: ") {
+			chunks = nil
+			continue
+		}
+		if chunks == nil {
+			continue
+		}
+		if i, ok := codegen.ParseSourceLineMarker(line); ok {
+			index = i
+			continue
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, ";") {
+			continue
+		}
+		chunks[index] = append(chunks[index], strings.TrimRight(line, " \t"))
+	}
+	return code
+}
+
+// sourceLine returns a line of a source file, reading the file on first use
+func sourceLine(sources map[string][]string, path string, line int) string {
+	lines, ok := sources[path]
+	if !ok {
+		if data, err := os.ReadFile(path); err == nil {
+			lines = strings.Split(string(data), "\n")
+		}
+		sources[path] = lines
+	}
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[line-1])
+}
+
+// stripSourceLineMarkers removes the markers from assembly generated for a
+// listing, so the assembly file is the same as without --emit-listing
+func stripSourceLineMarkers(assembly string) string {
+	lines := strings.Split(assembly, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if _, ok := codegen.ParseSourceLineMarker(line); !ok {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
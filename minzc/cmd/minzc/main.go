@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/minz/minzc/pkg/ast"
 	"github.com/minz/minzc/pkg/codegen"
 	"github.com/minz/minzc/pkg/ctie"
+	"github.com/minz/minzc/pkg/diagnostics"
 	"github.com/minz/minzc/pkg/ir"
 	"github.com/minz/minzc/pkg/mir"
 	"github.com/minz/minzc/pkg/module"
@@ -33,6 +36,7 @@ var (
 	target       string  // Target platform (zxspectrum, cpm, etc.)
 	listBackends bool
 	visualizeMIR string // Output file for MIR visualization
+	callGraph    string // Output file for the call graph
 	showVersion  bool
 	showVersionFull bool
 	dumpAST      bool   // Dump AST in JSON format
@@ -41,10 +45,26 @@ var (
 	// PGO (Profile-Guided Optimization) - Quick Win flags
 	pgoProfile   string  // Path to .tas profile file for PGO compilation
 	pgoDebug     bool    // Debug PGO decisions
+	
+	codeOrg      string   // Origin of the code section
+	sectionOrgs  []string // Origins for @section blocks (name=addr)
+	emitSections bool     // SECTION directives instead of ORG
+	splitOutput  bool     // One assembly file per function
+	relocCalls   bool     // Route calls through a call-thunk table
+	annotateSource bool   // Bracket loops, ifs and functions with source comments
+	deterministic  bool   // Reproducible output: no generation timestamp
+	warnLargeFunctions int // Warn about functions larger than this many bytes (0 = off)
+	optOptions   []string // -O sub-options, e.g. no-peephole
+	emitListing  bool     // Write a source+MIR+assembly listing
+	emitCOM      bool     // Also assemble a CP/M .COM file
+	mirBinary    bool     // Write the .mir side file in the binary format
+	errorFormat  string   // How to report errors: text or json
+	cABI         string   // C backend calling convention: default or sdcc
+	defines      []string // Compile-time constants (-D NAME=value)
 )
 
 var rootCmd = &cobra.Command{
-	Use:   "mz [source file]",
+	Use:   "mz [source file] [module files...]",
 	Short: "MinZ Multi-Platform Compiler " + version.GetVersion(),
 	Long:  `MinZ - Modern Programming Language for Retro Platforms
 ━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
@@ -53,9 +73,12 @@ Write once, run on any Z80, 6502, or modern platform!
 BACKENDS:
   z80     - Z80 assembly (default)
   6502    - 6502 assembly  
+  65c02   - 65C02 assembly (PHX/PHY, STZ, BRA, (zp) addressing)
   68000   - Motorola 68000 assembly
+  6809    - Motorola 6809 assembly (Tandy CoCo / Dragon)
   i8080   - Intel 8080 assembly
-  gb      - Game Boy (SM83/LR35902)
+  i8085   - Intel 8085 assembly (RIM/SIM, vectored interrupts; SDK-85)
+  gb      - Game Boy (SM83), assembled to a bootable .gb cartridge
   wasm    - WebAssembly
   c       - C99 source code
   crystal - Crystal source code (Ruby-style dev workflow!)
@@ -78,8 +101,9 @@ LANGUAGE FEATURES:
 EXAMPLES:
   mz hello.minz                      # Compile for ZX Spectrum
   mz hello.minz -t cpm               # Target CP/M systems
+  mz hello.minz -t cpm --com         # CP/M build assembled to hello.com
   mz hello.minz -t msx               # MSX build (optimized by default)
-  mz game.minz -b gb                 # Compile for Game Boy
+  mz game.minz -b gb                 # Game Boy: game.gb.s and the game.gb ROM
   mz app.minz -b c -o app.c          # Generate C code
   mz app.minz -b crystal -o app.cr   # Generate Crystal code (Ruby-style!)
   mz demo.minz --disable-smc         # Disable self-modifying code
@@ -87,12 +111,17 @@ EXAMPLES:
 
 OPTIMIZATION FLAGS:
   --disable-optimize  Disable optimizations (enabled by default)
-  --disable-smc       Disable self-modifying code (enabled by default, Z80 only)
+  --disable-smc       Disable self-modifying code (enabled by default, Z80 only;
+                      functions the target places in ROM, such as -t msx
+                      cartridge code, never use it and are listed)
+  -O no-peephole      Skip the peephole pass over generated Z80 assembly
+  -O size             Prefer size: call the multiply/divide runtime instead of inlining
 
 DEBUGGING:
   -d, --debug         Show compilation details
   --dump-ast          Output AST in JSON format
   --viz file.dot      Generate MIR visualization
+  --call-graph file.dot  Generate the call graph between functions
 
 CHARACTER LITERALS IN ASSEMBLY:
   asm { LD A, 'H' }   # Single quotes
@@ -104,7 +133,7 @@ For documentation and examples, see:
   
 Platform Independence Guide:
   docs/150_Platform_Independence_Achievement.md`,
-	Args:  cobra.MaximumNArgs(1),
+	Args:  cobra.ArbitraryArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Handle version flags
 		if showVersion {
@@ -134,9 +163,18 @@ Platform Independence Guide:
 			os.Exit(0)
 		}
 		
+		if errorFormat != "text" && errorFormat != "json" {
+			fmt.Fprintf(os.Stderr, "Error: unknown --error-format %q (want text or json)\n", errorFormat)
+			os.Exit(1)
+		}
+		
 		sourceFile := args[0]
-		if err := compile(sourceFile); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if err := compile(sourceFile, args[1:]); err != nil {
+			if errorFormat == "json" {
+				diagnostics.WriteJSON(os.Stderr, diagnostics.FromError(err))
+			} else {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
 			os.Exit(1)
 		}
 	},
@@ -156,6 +194,7 @@ func init() {
 	// Compilation flags
 	rootCmd.Flags().StringVarP(&outputFile, "output", "o", "", "output file (default: input.<ext> based on backend)")
 	rootCmd.Flags().BoolVar(&disableOptimize, "disable-optimize", false, "disable optimizations (enabled by default)")
+	rootCmd.Flags().StringSliceVarP(&optOptions, "opt", "O", nil, "optimization sub-options: no-peephole skips the assembly peephole pass, size prefers smaller code (repeatable or comma-separated)")
 	rootCmd.Flags().BoolVarP(&debug, "debug", "d", false, "enable debug output")
 	rootCmd.Flags().BoolVar(&disableSMC, "disable-smc", false, "disable all self-modifying code optimizations (enabled by default)")
 	rootCmd.Flags().BoolVar(&enableTAS, "tas", false, "enable TAS debugging with time-travel and cycle-perfect recording")
@@ -165,14 +204,159 @@ func init() {
 	// PGO flags (Quick Win integration)
 	rootCmd.Flags().StringVar(&pgoProfile, "pgo", "", "use profile-guided optimization with .tas profile file")
 	rootCmd.Flags().BoolVar(&pgoDebug, "pgo-debug", false, "show PGO optimization decisions and hot/cold analysis")
-	rootCmd.Flags().StringVarP(&backend, "backend", "b", defaultBackend, "target backend (z80, 6502, wasm, c, crystal, llvm)")
+	rootCmd.Flags().StringVarP(&backend, "backend", "b", defaultBackend, "target backend (z80, znext, 6502, wasm, c, crystal, llvm)")
 	rootCmd.Flags().StringVarP(&target, "target", "t", "zxspectrum", "target platform (zxspectrum, cpm, msx, cpc, amstrad)")
 	rootCmd.Flags().BoolVar(&listBackends, "list-backends", false, "list available backends")
 	rootCmd.Flags().StringVar(&visualizeMIR, "viz", "", "generate MIR visualization in DOT format")
+	rootCmd.Flags().StringVar(&callGraph, "call-graph", "", "generate the inter-function call graph in DOT format")
 	rootCmd.Flags().BoolVar(&dumpAST, "dump-ast", false, "dump AST in JSON format to stdout")
 	rootCmd.Flags().BoolVar(&dumpMIR, "dump-mir", false, "dump MIR (intermediate representation) to stdout")
 	rootCmd.Flags().BoolVar(&disableCTIE, "disable-ctie", false, "disable Compile-Time Interface Execution (enabled by default - functions execute at compile-time)")
 	rootCmd.Flags().BoolVar(&ctieDebug, "ctie-debug", false, "show CTIE optimization decisions and statistics")
+	rootCmd.Flags().StringVar(&codeOrg, "org", "", "origin of the code section, e.g. 0x6000 (default $8000; the IM2 vector table is aligned from it)")
+	rootCmd.Flags().StringArrayVar(&sectionOrgs, "section-org", nil, "origin for a @section, e.g. bank1=0xC000 (repeatable)")
+	rootCmd.Flags().BoolVar(&emitSections, "sections", false, "emit SECTION directives instead of ORG; mza places @sections without --section-org after the code and data (Z80)")
+	rootCmd.Flags().BoolVar(&splitOutput, "split-output", false, "write one assembly file per function plus globals and a manifest into <output>/")
+	rootCmd.Flags().BoolVar(&relocCalls, "relocatable-calls", false, "route calls through a table of JP thunks so functions can be relocated by patching their thunk (Z80)")
+	rootCmd.Flags().BoolVar(&deterministic, "deterministic", false, "omit the generation timestamp so identical input gives byte-identical output (SOURCE_DATE_EPOCH fixes it instead)")
+	rootCmd.Flags().BoolVar(&annotateSource, "annotate-source", false, "bracket loops, ifs and functions in the output with '; --- begin while loop (file:line) ---' comments")
+	rootCmd.Flags().BoolVar(&emitListing, "emit-listing", false, "write <output>.lst interleaving each source line with its MIR and Z80 code (Z80)")
+	rootCmd.Flags().BoolVar(&emitCOM, "com", false, "with -t cpm, also assemble the output into a ready-to-run CP/M .COM file (-o prog.com writes prog.a80 beside it)")
+	rootCmd.Flags().BoolVar(&mirBinary, "mir-binary", false, "write <output>.mir in the binary MIR format, which keeps comments and SMC metadata, for separate compilation")
+	rootCmd.Flags().StringVar(&errorFormat, "error-format", "text", "report errors as text or as json diagnostics (file, line, column, severity, code, message, hint) on stderr")
+	rootCmd.Flags().StringArrayVarP(&defines, "define", "D", nil, "define a compile-time constant NAME=value (a number, true, false or a string; NAME alone is 1) for @if and constant folding, overriding @define (repeatable)")
+	rootCmd.Flags().StringVar(&cABI, "c-abi", "default", "calling convention of the C backend: default (C99) or sdcc (SDCC's Z80 ABI, to link with SDCC-compiled code)")
+	rootCmd.Flags().IntVar(&warnLargeFunctions, "warn-large-functions", 0, "warn about functions whose assembled size exceeds this many bytes (Z80)")
+}
+
+// writeSplitOutput generates one file per function into a directory named after the output file
+func writeSplitOutput(backendInst codegen.Backend, irModule *ir.Module) error {
+	splitter, ok := backendInst.(interface {
+		GenerateSplit(*ir.Module) (*codegen.SplitOutput, error)
+	})
+	if !ok {
+		return fmt.Errorf("--split-output is not supported by the %s backend", backendInst.Name())
+	}
+	
+	out, err := splitter.GenerateSplit(irModule)
+	if err != nil {
+		return fmt.Errorf("code generation error: %w", err)
+	}
+	
+	dir := outputFile[:len(outputFile)-len(filepath.Ext(outputFile))]
+	if err := out.Write(dir); err != nil {
+		return fmt.Errorf("failed to write split output: %w", err)
+	}
+	
+	if debug {
+		fmt.Printf("Wrote %d function files and %s to %s/\n", len(out.Functions), codegen.SplitSharedFile, dir)
+	}
+	return nil
+}
+
+// isZ80Backend reports whether a backend generates Z80 assembly, which the
+// listing and size reports assemble
+func isZ80Backend(name string) bool {
+	return name == "z80" || name == "znext" || name == "zxnext"
+}
+
+// reportLargeFunctions warns about functions over the --warn-large-functions
+// size. It is diagnostic only and never fails the compilation.
+func reportLargeFunctions(generatedCode string) {
+	if !isZ80Backend(backend) {
+		fmt.Fprintf(os.Stderr, "Warning: --warn-large-functions is not supported by the %s backend\n", backend)
+		return
+	}
+	sizes, err := codegen.MeasureFunctions(generatedCode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot measure function sizes: %v\n", err)
+		return
+	}
+	for _, fn := range codegen.LargeFunctions(sizes, warnLargeFunctions) {
+		fmt.Fprintf(os.Stderr, "Warning: function %s is %d bytes (limit %d); consider splitting it or moving rarely used paths into a @cold function\n",
+			fn.Name, fn.Bytes, warnLargeFunctions)
+	}
+}
+
+// parseSectionOrigins parses --section-org name=addr values
+func parseSectionOrigins(specs []string) (map[string]uint16, error) {
+	origins := make(map[string]uint16)
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid --section-org %q (expected name=addr)", spec)
+		}
+		addr, err := parseOrigin(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid origin in --section-org %q: %v", spec, err)
+		}
+		origins[parts[0]] = addr
+	}
+	return origins, nil
+}
+
+// parseOrigin parses an address given as decimal, 0x hex or $ hex
+func parseOrigin(text string) (uint16, error) {
+	addrStr := strings.TrimPrefix(text, "$")
+	base := 0
+	if addrStr != text {
+		base = 16
+	}
+	addr, err := strconv.ParseUint(addrStr, base, 16)
+	return uint16(addr), err
+}
+
+// parseCodeOrigin parses --org, returning 0 (the backend's default) when
+// it is not given
+func parseCodeOrigin() (uint16, error) {
+	if codeOrg == "" {
+		return 0, nil
+	}
+	addr, err := parseOrigin(codeOrg)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --org %q: %v", codeOrg, err)
+	}
+	return addr, nil
+}
+
+// parseDefines parses -D NAME=value values: numbers as in the source
+// (0x1F, 0b101), true and false as bools, anything else as a string
+func parseDefines(specs []string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for _, spec := range specs {
+		name, value, hasValue := strings.Cut(spec, "=")
+		if name == "" || strings.ContainsAny(name, " .()") {
+			return nil, fmt.Errorf("invalid -D %q (expected NAME=value)", spec)
+		}
+		switch {
+		case !hasValue:
+			values[name] = int64(1)
+		case value == "true" || value == "false":
+			values[name] = value == "true"
+		default:
+			if n, err := strconv.ParseInt(value, 0, 64); err == nil {
+				values[name] = n
+			} else {
+				values[name] = value
+			}
+		}
+	}
+	return values, nil
+}
+
+// parseOptOptions applies the -O sub-options to the backend options
+func parseOptOptions(options []string, backendOptions *codegen.BackendOptions) error {
+	for _, option := range options {
+		switch option {
+		case "no-peephole":
+			backendOptions.DisablePeephole = true
+		case "size":
+			backendOptions.OptimizeSize = true
+		default:
+			return fmt.Errorf("unknown -O option %q (known: no-peephole, size)", option)
+		}
+	}
+	return nil
 }
 
 func main() {
@@ -182,22 +366,37 @@ func main() {
 	}
 }
 
-func compile(sourceFile string) error {
+// compile compiles sourceFile together with moduleFiles, each of which is
+// compiled as the module its path names relative to the project root
+// (util/math.minz is util.math), as if sourceFile imported it
+func compile(sourceFile string, moduleFiles []string) error {
 	// Silent by default (like Go compiler)
 	if debug {
 		fmt.Printf("Compiling %s...\n", sourceFile)
 	}
 	
+	if emitCOM {
+		if err := checkCOMOptions(); err != nil {
+			return err
+		}
+	}
+	
 	// Check if input is a MIR file
 	if filepath.Ext(sourceFile) == ".mir" {
+		if len(moduleFiles) > 0 {
+			return fmt.Errorf("module files cannot be added to a MIR file")
+		}
+		if emitListing {
+			return fmt.Errorf("--emit-listing needs a MinZ source file, not MIR")
+		}
 		return compileFromMIR(sourceFile)
 	}
+	if emitListing && (!isZ80Backend(backend) || splitOutput) {
+		return fmt.Errorf("--emit-listing needs the z80 backend without --split-output")
+	}
 
-	// Find project root (directory containing the source file or its parent)
+	// Imports resolve relative to the project root: the source file's directory
 	projectRoot := filepath.Dir(sourceFile)
-	
-	// Create module manager
-	_ = module.NewModuleManager(projectRoot)
 
 	// Parse the source file
 	parser := parser.New()
@@ -237,7 +436,25 @@ func compile(sourceFile string) error {
 	analyzer := semantic.NewAnalyzer()
 	analyzer.SetTargetBackend(backend)
 	analyzer.SetTargetPlatform(target)
-	// TODO: Set module resolver on analyzer
+	analyzer.SetAnnotateSource(annotateSource)
+	analyzer.SetProjectRoot(projectRoot)
+	if err := addDependencyPaths(analyzer, projectRoot); err != nil {
+		return err
+	}
+	defineValues, err := parseDefines(defines)
+	if err != nil {
+		return err
+	}
+	for name, value := range defineValues {
+		analyzer.SetDefine(name, value)
+	}
+	for _, file := range moduleFiles {
+		rel, err := filepath.Rel(projectRoot, file)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("module file %s is outside the project root %s", file, projectRoot)
+		}
+		analyzer.AddModuleFile(module.ExtractModuleName(rel), file)
+	}
 	irModule, err := analyzer.Analyze(astFile)
 	if err != nil {
 		return fmt.Errorf("semantic error: %w", err)
@@ -268,11 +485,21 @@ func compile(sourceFile string) error {
 				fmt.Println("SMC disabled via --disable-smc flag")
 			}
 		}
-	} else if !supportsSMC && !disableSMC {
-		if debug {
+	} else if !supportsSMC {
+		// Keep the optimizer's SMC passes away from backends that cannot patch code
+		for _, fn := range irModule.Functions {
+			fn.IsSMCEnabled = false
+			fn.IsSMCDefault = false
+		}
+		if debug && !disableSMC {
 			fmt.Printf("Warning: Backend %s does not support self-modifying code (using --disable-smc to silence)\n", backend)
 		}
 	}
+	if supportsSMC && !disableSMC {
+		if err := checkSMCSafety(irModule); err != nil {
+			return err
+		}
+	}
 
 	// Run CTIE pass (enabled by default, disabled with --disable-ctie)
 	if !disableCTIE {
@@ -299,7 +526,7 @@ func compile(sourceFile string) error {
 		level := optimizer.OptLevelFull  // Full optimization by default
 		
 		// Use TRUE SMC unless disabled
-		useTrueSMC := !disableSMC
+		useTrueSMC := !disableSMC && supportsSMC
 		
 		opt := optimizer.NewOptimizerWithOptions(level, useTrueSMC)
 		if err := opt.Optimize(irModule); err != nil {
@@ -307,6 +534,7 @@ func compile(sourceFile string) error {
 		}
 		
 		if debug {
+			reportRemoved(opt.Removed())
 			fmt.Println("Optimization completed")
 		}
 		
@@ -334,17 +562,35 @@ func compile(sourceFile string) error {
 	}
 
 	// Create backend options
+	sectionOrigins, err := parseSectionOrigins(sectionOrgs)
+	if err != nil {
+		return err
+	}
+	codeOrigin, err := parseCodeOrigin()
+	if err != nil {
+		return err
+	}
 	backendOptions := &codegen.BackendOptions{
 		OptimizationLevel: 0,
-		EnableSMC:         !disableSMC,
-		EnableTrueSMC:     !disableSMC,
+		EnableSMC:         !disableSMC && supportsSMC,
+		EnableTrueSMC:     !disableSMC && supportsSMC,
 		Debug:             debug,
 		Target:            target,
+		TargetAddress:     codeOrigin,
+		SectionOrigins:    sectionOrigins,
+		Sections:          emitSections,
+		RelocatableCalls:  relocCalls,
+		Deterministic:     deterministic,
+		CABI:              cABI,
+		SourceLines:       emitListing,
 	}
 	
 	if !disableOptimize {
 		backendOptions.OptimizationLevel = 2
 	}
+	if err := parseOptOptions(optOptions, backendOptions); err != nil {
+		return err
+	}
 
 	// Get the backend
 	backendInst := codegen.GetBackend(backend, backendOptions)
@@ -369,6 +615,14 @@ func compile(sourceFile string) error {
 		ext := filepath.Ext(base)
 		outputFile = base[:len(base)-len(ext)] + backendInst.GetFileExtension()
 	}
+	comFile := ""
+	if emitCOM {
+		outputFile, comFile = comOutputFiles(outputFile)
+	}
+	romFile := ""
+	if backend == "gb" && !splitOutput {
+		outputFile, romFile = gbOutputFiles(outputFile)
+	}
 
 	// Dump MIR if requested
 	if dumpMIR {
@@ -396,7 +650,11 @@ func compile(sourceFile string) error {
 	
 	// Save IR to .mir file
 	mirFile := outputFile[:len(outputFile)-len(filepath.Ext(outputFile))] + ".mir"
-	if err := saveIRModule(irModule, mirFile); err != nil {
+	saveMIR := saveIRModule
+	if mirBinary {
+		saveMIR = saveIRModuleBinary
+	}
+	if err := saveMIR(irModule, mirFile); err != nil {
 		if debug {
 			fmt.Printf("Warning: failed to save MIR file: %v\n", err)
 		}
@@ -412,16 +670,49 @@ func compile(sourceFile string) error {
 		fmt.Printf("Generated MIR visualization: %s\n", visualizeMIR)
 	}
 
+	if callGraph != "" {
+		if err := generateCallGraph(irModule, callGraph); err != nil {
+			return fmt.Errorf("call graph error: %w", err)
+		}
+		fmt.Printf("Generated call graph: %s\n", callGraph)
+	}
+
+	if splitOutput {
+		return writeSplitOutput(backendInst, irModule)
+	}
+	
 	// Generate code using the backend
 	generatedCode, err := backendInst.Generate(irModule)
 	if err != nil {
 		return fmt.Errorf("code generation error: %w", err)
 	}
 	
+	if emitListing {
+		listingFile := outputFile[:len(outputFile)-len(filepath.Ext(outputFile))] + ".lst"
+		if err := writeListing(listingFile, irModule, generatedCode); err != nil {
+			return fmt.Errorf("failed to write listing: %w", err)
+		}
+		generatedCode = stripSourceLineMarkers(generatedCode)
+	}
+	
 	// Write output file
 	if err := os.WriteFile(outputFile, []byte(generatedCode), 0644); err != nil {
 		return fmt.Errorf("failed to write output file: %w", err)
 	}
+	if emitCOM {
+		if err := writeCOMFile(comFile, generatedCode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", comFile, err)
+		}
+	}
+	if romFile != "" {
+		if err := writeGBFile(romFile, generatedCode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", romFile, err)
+		}
+	}
+	
+	if warnLargeFunctions > 0 {
+		reportLargeFunctions(generatedCode)
+	}
 	
 	// Add TAS debugging support if enabled
 	if enableTAS {
@@ -446,15 +737,28 @@ func compile(sourceFile string) error {
 	return nil
 }
 
+// reportRemoved prints the functions and globals dead code elimination
+// removed, and the space that saved
+func reportRemoved(stats optimizer.ReachabilityStats) {
+	if len(stats.Functions) == 0 && len(stats.Globals) == 0 {
+		return
+	}
+	fmt.Printf("Dead code: removed %d functions and %d globals, saving about %d bytes\n",
+		len(stats.Functions), len(stats.Globals), stats.BytesSaved)
+	for _, name := range stats.Functions {
+		fmt.Printf("  - function %s\n", name)
+	}
+	for _, name := range stats.Globals {
+		fmt.Printf("  - global %s\n", name)
+	}
+}
+
 // compileFromMIR compiles a .mir file directly to the target backend
 func compileFromMIR(mirFile string) error {
 	fmt.Printf("Compiling from MIR: %s...\n", mirFile)
 	
-	// Import the MIR parser
-	mirParser := mir.ParseMIRFile
-	
-	// Parse the MIR file
-	irModule, err := mirParser(mirFile)
+	// Load the MIR file, text or binary
+	irModule, err := mir.LoadMIRFile(mirFile)
 	if err != nil {
 		return fmt.Errorf("MIR parse error: %w", err)
 	}
@@ -487,18 +791,28 @@ func compileFromMIR(mirFile string) error {
 				fmt.Println("SMC disabled via --disable-smc flag")
 			}
 		}
-	} else if !supportsSMC && !disableSMC {
-		if debug {
+	} else if !supportsSMC {
+		// Keep the optimizer's SMC passes away from backends that cannot patch code
+		for _, fn := range irModule.Functions {
+			fn.IsSMCEnabled = false
+			fn.IsSMCDefault = false
+		}
+		if debug && !disableSMC {
 			fmt.Printf("Warning: Backend %s does not support self-modifying code (using --disable-smc to silence)\n", backend)
 		}
 	}
+	if supportsSMC && !disableSMC {
+		if err := checkSMCSafety(irModule); err != nil {
+			return err
+		}
+	}
 
 	// Run optimization passes (enabled by default)
 	if !disableOptimize {
 		level := optimizer.OptLevelFull  // Full optimization by default
 		
 		// Use TRUE SMC unless disabled
-		useTrueSMC := !disableSMC
+		useTrueSMC := !disableSMC && supportsSMC
 		
 		opt := optimizer.NewOptimizerWithOptions(level, useTrueSMC)
 		if err := opt.Optimize(irModule); err != nil {
@@ -506,22 +820,40 @@ func compileFromMIR(mirFile string) error {
 		}
 		
 		if debug {
+			reportRemoved(opt.Removed())
 			fmt.Println("Optimization completed")
 		}
 	}
 
 	// Create backend options
+	sectionOrigins, err := parseSectionOrigins(sectionOrgs)
+	if err != nil {
+		return err
+	}
+	codeOrigin, err := parseCodeOrigin()
+	if err != nil {
+		return err
+	}
 	backendOptions := &codegen.BackendOptions{
 		OptimizationLevel: 0,
-		EnableSMC:         !disableSMC,
-		EnableTrueSMC:     !disableSMC,
+		EnableSMC:         !disableSMC && supportsSMC,
+		EnableTrueSMC:     !disableSMC && supportsSMC,
 		Debug:             debug,
 		Target:            target,
+		TargetAddress:     codeOrigin,
+		SectionOrigins:    sectionOrigins,
+		Sections:          emitSections,
+		RelocatableCalls:  relocCalls,
+		Deterministic:     deterministic,
+		CABI:              cABI,
 	}
 
 	if !disableOptimize {
 		backendOptions.OptimizationLevel = 2
 	}
+	if err := parseOptOptions(optOptions, backendOptions); err != nil {
+		return err
+	}
 
 	// Get the backend
 	backendInst := codegen.GetBackend(backend, backendOptions)
@@ -546,7 +878,19 @@ func compileFromMIR(mirFile string) error {
 		ext := filepath.Ext(base)
 		outputFile = base[:len(base)-len(ext)] + backendInst.GetFileExtension()
 	}
+	comFile := ""
+	if emitCOM {
+		outputFile, comFile = comOutputFiles(outputFile)
+	}
+	romFile := ""
+	if backend == "gb" && !splitOutput {
+		outputFile, romFile = gbOutputFiles(outputFile)
+	}
 
+	if splitOutput {
+		return writeSplitOutput(backendInst, irModule)
+	}
+	
 	// Generate code using the backend
 	generatedCode, err := backendInst.Generate(irModule)
 	if err != nil {
@@ -557,6 +901,20 @@ func compileFromMIR(mirFile string) error {
 	if err := os.WriteFile(outputFile, []byte(generatedCode), 0644); err != nil {
 		return fmt.Errorf("failed to write output file: %w", err)
 	}
+	if emitCOM {
+		if err := writeCOMFile(comFile, generatedCode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", comFile, err)
+		}
+	}
+	if romFile != "" {
+		if err := writeGBFile(romFile, generatedCode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", romFile, err)
+		}
+	}
+
+	if warnLargeFunctions > 0 {
+		reportLargeFunctions(generatedCode)
+	}
 
 	// Add TAS debugging support if enabled
 	if enableTAS {
@@ -600,6 +958,30 @@ func generateVisualization(module *ir.Module, filename string) error {
 	return visualizer.Visualize(module)
 }
 
+// generateCallGraph writes the call graph of module in DOT format
+func generateCallGraph(module *ir.Module, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return mir.BuildCallGraph(module).WriteDOT(file)
+}
+
+// saveIRModuleBinary saves the IR module to a binary .mir file
+func saveIRModuleBinary(module *ir.Module, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := mir.WriteMIRBinary(file, module); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
 // saveIRModule saves the IR module to a .mir file
 func saveIRModule(module *ir.Module, filename string) error {
 	file, err := os.Create(filename)
@@ -639,9 +1021,17 @@ func saveIRModule(module *ir.Module, filename string) error {
 		if fn.IsRecursive {
 			fmt.Fprintf(file, "  @recursive\n")
 		}
-		if fn.IsInterrupt {
+		if fn.InterruptMode == 2 {
+			fmt.Fprintf(file, "  @interrupt(im2)\n")
+		} else if fn.IsInterrupt {
 			fmt.Fprintf(file, "  @interrupt\n")
 		}
+		if fn.IsExported {
+			fmt.Fprintf(file, "  @export\n")
+		}
+		if fn.Section != "" {
+			fmt.Fprintf(file, "  @section(\"%s\")\n", fn.Section)
+		}
 
 		// Locals
 		if len(fn.Locals) > 0 {
@@ -695,7 +1085,11 @@ func saveIRModule(module *ir.Module, filename string) error {
 			case ir.OpGe:
 				fmt.Fprintf(file, "r%d = r%d >= r%d", inst.Dest, inst.Src1, inst.Src2)
 			case ir.OpCall:
-				fmt.Fprintf(file, "r%d = call %s", inst.Dest, inst.Symbol)
+				if inst.TailCall {
+					fmt.Fprintf(file, "r%d = tail call %s", inst.Dest, inst.Symbol)
+				} else {
+					fmt.Fprintf(file, "r%d = call %s", inst.Dest, inst.Symbol)
+				}
 			case ir.OpCallIndirect:
 				if len(inst.Args) > 0 {
 					fmt.Fprintf(file, "r%d = call_indirect r%d (args:", inst.Dest, inst.Src1)
@@ -717,6 +1111,8 @@ func saveIRModule(module *ir.Module, filename string) error {
 				}
 			case ir.OpJump:
 				fmt.Fprintf(file, "jump %s", inst.Label)
+			case ir.OpJumpIndirect:
+				fmt.Fprintf(file, "jump_indirect r%d", inst.Src1)
 			case ir.OpJumpIfNot:
 				fmt.Fprintf(file, "jump_if_not r%d, %s", inst.Src1, inst.Label)
 			case ir.OpLabel:
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/minz/minzc/pkg/module"
+	"github.com/minz/minzc/pkg/semantic"
+	"github.com/spf13/cobra"
+)
+
+var modCmd = &cobra.Command{
+	Use:   "mod",
+	Short: "Manage the libraries a project depends on (minz.toml)",
+	Long: `Manage the libraries a project depends on.
+
+A project's minz.toml lists libraries fetched from git repositories at a
+tag or branch into a shared cache (~/.minz/pkg, or $MINZ_CACHE). Imports
+are looked for in each library's checkout after the project itself, so
+import sprites.draw can load sprites/draw.minz from a library.
+
+EXAMPLES:
+  mz mod init mygame
+  mz mod add https://github.com/someone/sprites@v1.2.0
+  mz mod tidy`,
+}
+
+var modInitCmd = &cobra.Command{
+	Use:   "init [name]",
+	Short: "Create minz.toml in the current directory",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := os.Stat(module.ManifestFile); err == nil {
+			return fmt.Errorf("%s already exists", module.ManifestFile)
+		}
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		manifest := &module.Manifest{Name: filepath.Base(dir), Version: "0.1.0"}
+		if len(args) > 0 {
+			manifest.Name = args[0]
+		}
+		if err := manifest.Save(module.ManifestFile); err != nil {
+			return err
+		}
+		fmt.Printf("Created %s for %s\n", module.ManifestFile, manifest.Name)
+		return nil
+	},
+}
+
+var modAddCmd = &cobra.Command{
+	Use:   "add <git-url>@<version> [name]",
+	Short: "Fetch a library and add it to minz.toml",
+	Long: `Fetch a library at a tag or branch and add it to minz.toml.
+
+The name defaults to the last element of the repository path.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, manifest, err := loadProjectManifest()
+		if err != nil {
+			return err
+		}
+		dep, err := parseDependencySpec(args[0])
+		if err != nil {
+			return err
+		}
+		if len(args) > 1 {
+			dep.Name = args[1]
+		}
+		cache, err := module.CacheDir()
+		if err != nil {
+			return err
+		}
+		if err := dep.Fetch(cache); err != nil {
+			return err
+		}
+		manifest.AddDependency(dep)
+		if _, err := module.FetchAll(manifest, cache); err != nil {
+			return err
+		}
+		if err := manifest.Save(path); err != nil {
+			return err
+		}
+		fmt.Printf("Added %s %s\n", dep.Name, dep.Version)
+		return nil
+	},
+}
+
+var modTidyCmd = &cobra.Command{
+	Use:   "tidy",
+	Short: "Fetch missing libraries and drop the ones no import uses",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, manifest, err := loadProjectManifest()
+		if err != nil {
+			return err
+		}
+		cache, err := module.CacheDir()
+		if err != nil {
+			return err
+		}
+		fetched, dropped, err := module.Tidy(manifest, filepath.Dir(path), cache)
+		for _, dep := range fetched {
+			fmt.Printf("Fetched %s %s\n", dep.Name, dep.Version)
+		}
+		if err != nil {
+			return err
+		}
+		for _, dep := range dropped {
+			fmt.Printf("Dropped %s %s: not imported\n", dep.Name, dep.Version)
+		}
+		return manifest.Save(path)
+	},
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{modInitCmd, modAddCmd, modTidyCmd} {
+		// main reports the error; a failed fetch is not a usage error
+		cmd.SilenceErrors, cmd.SilenceUsage = true, true
+		modCmd.AddCommand(cmd)
+	}
+	rootCmd.AddCommand(modCmd)
+}
+
+// loadProjectManifest loads the minz.toml of the project the current
+// directory is in
+func loadProjectManifest() (string, *module.Manifest, error) {
+	path := module.FindManifest(".")
+	if path == "" {
+		return "", nil, fmt.Errorf("no %s here or in any parent directory: run mz mod init", module.ManifestFile)
+	}
+	manifest, err := module.LoadManifest(path)
+	return path, manifest, err
+}
+
+// parseDependencySpec parses <git-url>@<version>; the @ must follow the
+// last path element, as git@host:path URLs have one of their own
+func parseDependencySpec(spec string) (module.Dependency, error) {
+	at := strings.LastIndex(spec, "@")
+	if at < 0 || at < strings.LastIndexAny(spec, "/:") || at == len(spec)-1 {
+		return module.Dependency{}, fmt.Errorf("%q needs a version: <git-url>@<tag or branch>", spec)
+	}
+	url := spec[:at]
+	name := strings.TrimSuffix(url[strings.LastIndexAny(url, "/:")+1:], ".git")
+	if name == "" {
+		return module.Dependency{}, fmt.Errorf("cannot name a library from %q: give a name", url)
+	}
+	return module.Dependency{Name: name, Git: url, Version: spec[at+1:]}, nil
+}
+
+// addDependencyPaths makes imports resolve in the cached libraries of the
+// project's minz.toml, if the source is in a project that has one
+func addDependencyPaths(analyzer *semantic.Analyzer, sourceDir string) error {
+	path := module.FindManifest(sourceDir)
+	if path == "" {
+		return nil
+	}
+	manifest, err := module.LoadManifest(path)
+	if err != nil {
+		return err
+	}
+	if len(manifest.Dependencies) == 0 {
+		return nil
+	}
+	cache, err := module.CacheDir()
+	if err != nil {
+		return err
+	}
+	paths, err := module.SearchPaths(manifest, cache)
+	if err != nil {
+		return err
+	}
+	for _, dir := range paths {
+		analyzer.AddSearchPath(dir)
+	}
+	return nil
+}
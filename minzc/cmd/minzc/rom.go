@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/minz/minzc/pkg/codegen"
+	"github.com/minz/minzc/pkg/ir"
+	"github.com/minz/minzc/pkg/optimizer"
+	"github.com/minz/minzc/pkg/z80asm"
+)
+
+// SMC patches code, so it is only used where the code ends up in RAM. The
+// target's memory map (the one mza uses) says what is ROM: the machine's
+// own ROM, and for cartridge targets such as -t msx the whole code
+// section. Functions placed there pass their parameters in registers or
+// on the stack instead, and the compiler lists the ones that had SMC
+// parameters. A @section given a RAM origin (--section-org) keeps SMC.
+
+// checkSMCSafety turns SMC off for the Z80 functions of module that the
+// target places in ROM, reporting those downgraded
+func checkSMCSafety(module *ir.Module) error {
+	config := z80asm.GetTargetConfig(z80asm.Target(target))
+	if !isZ80Backend(backend) || config == nil {
+		return nil
+	}
+	placement, err := smcPlacement(module, config)
+	if err != nil {
+		return err
+	}
+	downgrades, err := optimizer.CheckSMCSafety(module, placement)
+	if err != nil {
+		return fmt.Errorf("SMC error: %w", err)
+	}
+	if len(downgrades) > 0 {
+		fmt.Printf("SMC: %d functions are in ROM on %s and pass parameters in registers or on the stack:\n",
+			len(downgrades), config.Name)
+		for _, d := range downgrades {
+			fmt.Printf("  - %s\n", d)
+		}
+	}
+	return nil
+}
+
+// smcPlacement returns where the code generator will put the functions of
+// module, and the target's ROM
+func smcPlacement(module *ir.Module, config *z80asm.TargetConfig) (optimizer.SMCPlacement, error) {
+	codeOrigin, err := parseCodeOrigin()
+	if err != nil {
+		return optimizer.SMCPlacement{}, err
+	}
+	switch {
+	case target == "cpm":
+		codeOrigin = 0x0100
+	case codeOrigin == 0:
+		codeOrigin = codegen.DefaultCodeOrigin
+	}
+	sectionOrigins, err := parseSectionOrigins(sectionOrgs)
+	if err != nil {
+		return optimizer.SMCPlacement{}, err
+	}
+	for _, fn := range module.Functions {
+		if _, ok := sectionOrigins[fn.Section]; !ok && fn.Section != "" {
+			sectionOrigins[fn.Section] = codegen.DefaultSectionOrigin
+		}
+	}
+
+	placement := optimizer.SMCPlacement{CodeOrigin: codeOrigin, SectionOrigins: sectionOrigins}
+	for _, r := range config.ReadOnly() {
+		placement.ROM = append(placement.ROM, optimizer.AddressRange{Start: r[0], End: r[1]})
+	}
+	return placement, nil
+}
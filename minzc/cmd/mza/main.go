@@ -14,16 +14,29 @@ var (
 	outputFile    string
 	listingFile   string
 	symbolFile    string
+	symbolFormat  string
 	targetFlag    string
 	formatFlag    string
 	allowUndoc    bool
+	allowZ80N     bool
 	strict        bool
 	caseSensitive bool
 	verbose       bool
+	crcVerify     string
+	binaryDiff    string
+	warnSMC       bool
+	deterministic bool
+	tapAutorun    bool
+	listMacros    bool
+	compileOnly   bool
+	linkMode      bool
+	linkOrigin    uint16
+	defines       []string
+	sections      []string
 )
 
 var rootCmd = &cobra.Command{
-	Use:   "mza [input.a80]",
+	Use:   "mza [input.a80] | mza --link [a.obj b.obj ...]",
 	Short: "MinZ Z80 Assembler v1.1 with Macro Support",
 	Long: `mza - MinZ Z80 Assembler v1.1 with Macro Support
 ━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
@@ -45,6 +58,11 @@ MACRO FEATURES:
   ENDM                          End macro definition
   
   name arg1, arg2               Invoke macro
+
+  MACRO log msg...              The last parameter takes the remaining
+                                arguments, separated by commas
+  LOCAL loop, done              In a macro body: labels unique to each
+                                expansion
   
   Built-in macros:
     PUSH_ALL                    Save all registers
@@ -56,29 +74,104 @@ MACRO FEATURES:
 
 DIRECTIVES:
   ORG $8000           Set origin address
+  SECTION name[, base] Assemble into a section (CODE, DATA, BSS or any name)
   DB/DEFB             Define bytes
   DW/DEFW             Define words (16-bit)
   DS/DEFS             Define space
   EQU                 Define constant
   MACRO/ENDM          Define macro
+  IF/ELIF/ELSE/ENDIF  Assemble a block if an expression is non-zero
+                      (or a comparison: =, !=, <, <=, >, >=)
+  IFDEF/IFNDEF name   Assemble a block if a symbol is (not) defined
+  REPT n/ENDR         Assemble a block n times
+  PUBLIC name, ...    Export symbols from an object module
+  EXTERN name, ...    Import symbols from another object module
   END                 End of source
 
+SECTIONS:
+  Instead of ORG, code and data can go in sections, which the assembler
+  places: CODE, DATA, then BSS (reserved with DS, not written to the
+  output). Each target sets their bases and limits (zxspectrum puts CODE
+  at $8000 and stops it at $BFFF); --section NAME=BASE[:LIMIT] or
+  NAME=auto moves one, and a section past its limit is an error.
+
+OUTPUT FORMATS:
+  -f picks the output file format whatever the target: bin (raw binary),
+  sna (Spectrum snapshot), tap (Spectrum tape: a BASIC loader, then the
+  code, run with RANDOMIZE USR), hex (Intel HEX, 16 bytes per record),
+  com (CP/M), rom (MSX cartridge), nex (ZX Spectrum Next, loading the
+  code's 16K banks) or gb (Game Boy cartridge). auto, the default, is the
+  target's.
+
+ZX SPECTRUM NEXT:
+  -t zxnext (or the TARGET zxnext directive) enables the Z80N instructions:
+  MUL D,E, ADD HL/DE/BC,A, ADD HL/DE/BC,nn, PUSH nn, NEXTREG, PIXELAD,
+  PIXELDN, SETAE, TEST n, SWAPNIB, MIRROR, the barrel shifts (BSLA...) and
+  LDIX/LDIRX/LDDX/LDDRX/LDPIRX/LDWS, OUTINB and JP (C). Its output is .nex.
+
+GAME BOY:
+  -t gameboy (or TARGET gameboy) assembles for the SM83: LD (HL+)/(HL-),A
+  and back (LDI/LDD), LDH (n)/(C), LD (nn),A, LD (nn),SP, ADD SP,e,
+  LD HL,SP+e, SWAP, STOP and RETI take the opcodes the Z80 uses otherwise,
+  and the Z80 instructions the SM83 lacks (IX/IY, ED-prefixed, EX, EXX,
+  DJNZ, IN/OUT, PO/PE/P/M conditions) are errors. CODE starts at $0150,
+  BSS is in work RAM at $C000. The .gb output is a 32K ROM with the
+  Nintendo logo and the header and global checksums filled in, and
+  NOP, JP origin at $0100 unless the program puts its own header there.
+
+OBJECT MODULES:
+  mza -c assembles a source into a relocatable object module (no ORG).
+  mza --link places the modules one after another from --origin (default:
+  the target's origin), relocates their addresses and resolves each EXTERN
+  against the PUBLIC symbols of the others. Only 16-bit addresses can be
+  relocated or imported; a lone high or low byte of one is an error.
+
 EXAMPLES:
   mza program.a80                     # Assemble to program.bin
   mza -o game.rom program.a80         # Custom output file
-  mza -l program.lst program.a80      # Generate listing
+  mza -l program.lst program.a80      # Listing with T-states and macro expansions
+  mza -l p.lst --list-macros p.a80    # Tag macro-expanded lines in the listing
   mza --no-macros program.a80         # Disable macro processing
   mza -s symbols.sym program.a80      # Generate symbol table
+  mza -s game.labels game.a80         # Symbols for sjasmplus-aware debuggers
+  mza -s game.map --sym-format map g.a80  # z88dk-style map file
+  mza -D PLATFORM=2 -D DEBUG prog.a80 # Define symbols for IF/IFDEF
+  mza --crc-verify 1A2B3C4D prog.a80  # Fail unless output CRC32 matches
+  mza --binary-diff prog.bin prog.a80 # Fail unless output matches prog.bin
+  mza --warn-self-modifying prog.a80  # Flag stores into code
+  mza --deterministic -s p.sym p.a80  # Reproducible listing/symbols
+  mza --tap-autorun program.a80       # Self-running program.tap with BASIC loader
+  mza -f tap program.a80              # The same, on any target
+  mza -f hex program.a80              # Intel HEX for an EPROM programmer
+  mza -t zxnext program.a80           # program.nex for the Spectrum Next
+  mza -t gameboy game.a80             # Bootable game.gb cartridge
+  mza -c -o main.obj main.a80         # Assemble an object module
+  mza --link main.obj lib.obj -o game.bin  # Link object modules at $8000
+  mza --link --origin 0x6000 -t zxtap a.obj b.obj  # Link to a tape at $6000
   mza -v program.a80                  # Verbose output`,
-	Args: cobra.ExactArgs(1),
+	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		inputFile := args[0]
 		
+		if compileOnly && linkMode {
+			fmt.Fprintf(os.Stderr, "Error: -c and --link cannot be combined\n")
+			os.Exit(1)
+		}
+		if !linkMode && len(args) > 1 {
+			fmt.Fprintf(os.Stderr, "Error: only one source file can be assembled (use --link to link object files)\n")
+			os.Exit(1)
+		}
+		
 		// Validate input file extension
-		if !strings.HasSuffix(strings.ToLower(inputFile), ".a80") {
+		if !linkMode && !strings.HasSuffix(strings.ToLower(inputFile), ".a80") {
 			fmt.Fprintf(os.Stderr, "Warning: Input file doesn't have .a80 extension\n")
 		}
 		
+		// An autorun TAP is a Spectrum tape
+		if tapAutorun && targetFlag == "generic" {
+			targetFlag = string(z80asm.TargetZXTap)
+		}
+		
 		// Parse target
 		target, err := z80asm.ParseTarget(targetFlag)
 		if err != nil {
@@ -89,29 +182,54 @@ EXAMPLES:
 		
 		targetConfig := z80asm.GetTargetConfig(target)
 		
+		// -f overrides the target's own output format
+		format := &targetConfig.OutputFormat
+		if formatFlag != "auto" {
+			format, err = z80asm.ParseOutputFormat(formatFlag)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				fmt.Fprintf(os.Stderr, "Available formats: %s\n", strings.Join(z80asm.ListOutputFormats(), ", "))
+				os.Exit(1)
+			}
+		}
+		generator := format.Generator
+		if tapAutorun {
+			if target != z80asm.TargetZXTap && target != z80asm.TargetZXSpectrum {
+				fmt.Fprintf(os.Stderr, "Error: --tap-autorun needs a ZX Spectrum target, not %s\n", target)
+				os.Exit(1)
+			}
+			generator = z80asm.GenerateAutorunTAP
+		}
+		
 		// Determine output file name and format
 		if outputFile == "" {
 			ext := filepath.Ext(inputFile)
 			base := strings.TrimSuffix(inputFile, ext)
 			
 			// Use target-specific extension if no format specified
-			if formatFlag == "auto" {
-				outputFile = base + targetConfig.OutputFormat.Extension
+			if compileOnly {
+				outputFile = base + ".obj"
+			} else if tapAutorun {
+				outputFile = base + ".tap"
 			} else {
-				outputFile = base + "." + formatFlag
+				outputFile = base + format.Extension
 			}
 		}
 		
 		if verbose {
 			fmt.Printf("MinZ Z80 Assembler v1.1\n")
 			fmt.Printf("Target: %s (%s)\n", targetConfig.Name, targetConfig.Description)
-			fmt.Printf("Input:  %s\n", inputFile)
-			fmt.Printf("Output: %s (%s)\n", outputFile, targetConfig.OutputFormat.Description)
+			fmt.Printf("Input:  %s\n", strings.Join(args, ", "))
+			if compileOnly {
+				fmt.Printf("Output: %s (object module)\n", outputFile)
+			} else {
+				fmt.Printf("Output: %s (%s)\n", outputFile, format.Description)
+			}
 			if listingFile != "" {
 				fmt.Printf("Listing: %s\n", listingFile)
 			}
 			if symbolFile != "" {
-				fmt.Printf("Symbols: %s\n", symbolFile)
+				fmt.Printf("Symbols: %s (%s)\n", symbolFile, symbolFormat)
 			}
 			fmt.Println()
 		}
@@ -119,20 +237,55 @@ EXAMPLES:
 		// Create assembler with configuration
 		assembler := z80asm.NewAssembler()
 		assembler.AllowUndocumented = allowUndoc
+		assembler.Z80N = allowZ80N
 		assembler.Strict = strict
 		assembler.CaseSensitive = caseSensitive
+		assembler.WarnSelfModifying = warnSMC
+		for _, def := range defines {
+			if err := assembler.Define(def); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
 		
 		// Set target platform
 		if err := assembler.SetTarget(target); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to set target: %v\n", err)
 			os.Exit(1)
 		}
+		for _, text := range sections {
+			spec, err := z80asm.ParseSectionSpec(text)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			assembler.ConfigureSection(spec)
+		}
 		
-		// Assemble the file
-		result, err := assembler.AssembleFile(inputFile)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Assembly failed: %v\n", err)
-			os.Exit(1)
+		// Assemble an object module for the linker
+		if compileOnly {
+			writeObject(assembler, inputFile)
+			return
+		}
+		
+		// Assemble the file, or link the object files
+		var result *z80asm.Result
+		if linkMode {
+			origin := targetConfig.MemoryLayout.DefaultOrigin
+			if cmd.Flags().Changed("origin") {
+				origin = linkOrigin
+			}
+			result, err = linkObjects(args, origin)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Link failed: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			result, err = assembler.AssembleFile(inputFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Assembly failed: %v\n", err)
+				os.Exit(1)
+			}
 		}
 		
 		// Check for assembly errors
@@ -146,44 +299,77 @@ EXAMPLES:
 		
 		// Generate target-specific output
 		var outputData []byte
-		if targetConfig.OutputFormat.Generator != nil {
-			outputData, err = targetConfig.OutputFormat.Generator(result)
+		if generator != nil {
+			outputData, err = generator(result)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to generate %s output: %v\n", targetConfig.Name, err)
+				fmt.Fprintf(os.Stderr, "Failed to generate %s output: %v\n", format.Description, err)
 				os.Exit(1)
 			}
 		} else {
 			outputData = result.Binary
 		}
 		
-		// Write output file
-		if err := os.WriteFile(outputFile, outputData, 0644); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to write output file %s: %v\n", outputFile, err)
-			os.Exit(1)
-		}
-		
-		// Generate listing file if requested
-		if listingFile != "" {
-			if err := generateListingFile(listingFile, result); err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to write listing file %s: %v\n", listingFile, err)
+		// Verify output checksum if requested
+		if crcVerify != "" {
+			crc, err := z80asm.VerifyCRC(outputData, crcVerify)
+			if err != nil {
+				if mismatch, ok := err.(*z80asm.CRCMismatchError); ok {
+					fmt.Fprintf(os.Stderr, "CRC verification failed:\n")
+					fmt.Fprintf(os.Stderr, "  Expected: $%08X\n", mismatch.Expected)
+					fmt.Fprintf(os.Stderr, "  Actual:   $%08X\n", mismatch.Actual)
+				} else {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				}
 				os.Exit(1)
 			}
+			if verbose {
+				fmt.Printf("CRC32 verified: $%08X\n", crc)
+			}
 		}
 		
-		// Generate symbol file if requested
-		if symbolFile != "" {
-			if err := generateSymbolFile(symbolFile, result); err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to write symbol file %s: %v\n", symbolFile, err)
+		// Compare against an expected image if requested
+		if binaryDiff != "" {
+			expected, err := os.ReadFile(binaryDiff)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 				os.Exit(1)
 			}
+			if err := z80asm.CompareBinary(outputData, expected); err != nil {
+				fmt.Fprintf(os.Stderr, "Binary diff against %s failed: %v\n", binaryDiff, err)
+				if mismatch, ok := err.(*z80asm.BinaryMismatchError); ok {
+					fmt.Fprint(os.Stderr, mismatch.Context())
+				}
+				os.Exit(1)
+			}
+			if verbose {
+				fmt.Printf("Output matches %s (%d bytes)\n", binaryDiff, len(expected))
+			}
 		}
 		
+		// Write output file
+		if err := os.WriteFile(outputFile, outputData, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write output file %s: %v\n", outputFile, err)
+			os.Exit(1)
+		}
+		
+		writeListingAndSymbols(result)
+		
 		// Print summary
 		if verbose || len(result.Warnings) > 0 {
 			fmt.Printf("Assembly completed successfully:\n")
 			fmt.Printf("  Origin: $%04X\n", result.Origin)
 			fmt.Printf("  Size: %d bytes ($%04X)\n", result.Size, result.Size)
 			fmt.Printf("  Symbols: %d\n", len(result.Symbols))
+			if len(result.Sections) > 0 {
+				fmt.Printf("  Sections:\n")
+				for _, section := range result.Sections {
+					fmt.Printf("    %-8s $%04X %5d bytes", section.Name, section.Start, section.Size)
+					if section.BSS {
+						fmt.Printf(" (reserved)")
+					}
+					fmt.Println()
+				}
+			}
 			
 			if len(result.Warnings) > 0 {
 				fmt.Printf("  Warnings:\n")
@@ -199,16 +385,31 @@ func init() {
 	// Output options
 	rootCmd.Flags().StringVarP(&outputFile, "output", "o", "", "output file (default: input.ext based on target)")
 	rootCmd.Flags().StringVarP(&listingFile, "listing", "l", "", "generate listing file")
+	rootCmd.Flags().BoolVar(&listMacros, "list-macros", false, "tag macro-expanded listing lines with their macro and invocation line, and list all macros")
 	rootCmd.Flags().StringVarP(&symbolFile, "symbols", "s", "", "generate symbol file")
+	rootCmd.Flags().StringVar(&symbolFormat, "sym-format", "auto", "symbol file format (auto, "+strings.Join(z80asm.ListSymbolFormats(), ", ")+"); auto goes by the file extension")
 	
 	// Target options
-	rootCmd.Flags().StringVarP(&targetFlag, "target", "t", "generic", "target platform (generic, zxspectrum, zxtap, cpm, msx, gameboy)")
-	rootCmd.Flags().StringVarP(&formatFlag, "format", "f", "auto", "output format (auto, bin, sna, tap, com, rom)")
+	rootCmd.Flags().StringVarP(&targetFlag, "target", "t", "generic", "target platform (generic, zxspectrum, zxtap, zxnext, cpm, msx, gameboy)")
+	rootCmd.Flags().StringVarP(&formatFlag, "format", "f", "auto", "output format (auto, bin, sna, tap, hex, com, rom, nex, gb); auto uses the target's")
+	rootCmd.Flags().BoolVar(&tapAutorun, "tap-autorun", false, "write a TAP whose BASIC loader CLEARs below the code, loads it and runs it with RANDOMIZE USR")
+	
+	// Object modules
+	rootCmd.Flags().BoolVarP(&compileOnly, "compile", "c", false, "assemble to a relocatable object module (default output: input.obj)")
+	rootCmd.Flags().BoolVar(&linkMode, "link", false, "link the object files given as arguments into one program")
+	rootCmd.Flags().Uint16Var(&linkOrigin, "origin", 0x8000, "address the linked program starts at (default: the target's origin)")
 	
 	// Assembly options
 	rootCmd.Flags().BoolVarP(&allowUndoc, "undocumented", "u", true, "allow undocumented Z80 instructions")
+	rootCmd.Flags().BoolVar(&allowZ80N, "z80n", false, "allow the ZX Spectrum Next's Z80N instructions on any target (-t zxnext allows them)")
 	rootCmd.Flags().BoolVar(&strict, "strict", false, "strict assembly mode")
-	rootCmd.Flags().BoolVarP(&caseSensitive, "case-sensitive", "c", false, "case-sensitive labels")
+	rootCmd.Flags().BoolVar(&caseSensitive, "case-sensitive", false, "case-sensitive labels")
+	rootCmd.Flags().StringArrayVar(&sections, "section", nil, "place a section: NAME=BASE, NAME=BASE:LIMIT or NAME=auto (repeatable)")
+	rootCmd.Flags().StringArrayVarP(&defines, "define", "D", nil, "define a symbol for conditional assembly: NAME=value, or NAME for 1 (repeatable)")
+	rootCmd.Flags().StringVar(&crcVerify, "crc-verify", "", "fail unless the output CRC32 matches this value (hex)")
+	rootCmd.Flags().StringVar(&binaryDiff, "binary-diff", "", "fail unless the output matches this file byte for byte, showing the first difference")
+	rootCmd.Flags().BoolVar(&warnSMC, "warn-self-modifying", false, "warn about constant-address stores into code outside SMC functions")
+	rootCmd.Flags().BoolVar(&deterministic, "deterministic", false, "byte-identical listing and symbol files for identical input (implied by SOURCE_DATE_EPOCH)")
 	
 	// General options
 	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
@@ -221,48 +422,168 @@ func main() {
 	}
 }
 
-// generateListingFile creates a listing file with addresses and machine code
-func generateListingFile(filename string, result *z80asm.Result) error {
-	var lines []string
+// writeObject assembles inputFile into a relocatable object module and
+// writes it to outputFile
+func writeObject(assembler *z80asm.Assembler, inputFile string) {
+	object, result, err := assembler.AssembleObjectFile(inputFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Assembly failed: %v\n", err)
+		os.Exit(1)
+	}
+	if len(result.Errors) > 0 {
+		fmt.Fprintf(os.Stderr, "Assembly errors:\n")
+		for _, err := range result.Errors {
+			fmt.Fprintf(os.Stderr, "  %v\n", err)
+		}
+		os.Exit(1)
+	}
 	
-	lines = append(lines, "MinZ Z80 Assembler Listing")
-	lines = append(lines, "==========================")
-	lines = append(lines, "")
+	if err := object.WriteFile(outputFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write object file %s: %v\n", outputFile, err)
+		os.Exit(1)
+	}
+	writeListingAndSymbols(result)
 	
-	for _, line := range result.Listing {
-		if len(line.Bytes) > 0 {
-			// Format: "8000  21 34 12    LD HL,$1234"
-			codeHex := ""
-			for i, b := range line.Bytes {
-				if i > 0 {
-					codeHex += " "
-				}
-				codeHex += fmt.Sprintf("%02X", b)
+	if verbose || len(result.Warnings) > 0 {
+		fmt.Printf("Object module %s written:\n", object.Module)
+		fmt.Printf("  Size: %d bytes ($%04X)\n", len(object.Code), len(object.Code))
+		fmt.Printf("  Relocations: %d\n", len(object.Relocations))
+		fmt.Printf("  Public symbols: %d\n", len(object.Publics))
+		fmt.Printf("  External references: %d\n", len(object.Externs))
+		
+		if len(result.Warnings) > 0 {
+			fmt.Printf("  Warnings:\n")
+			for _, warning := range result.Warnings {
+				fmt.Printf("    %s\n", warning)
 			}
-			lines = append(lines, fmt.Sprintf("%04X  %-12s %s", 
-				line.Address, codeHex, line.SourceLine))
-		} else {
-			// Format: "             ; comment or directive"
-			lines = append(lines, fmt.Sprintf("              %s", line.SourceLine))
+		}
+	}
+}
+
+// linkObjects reads the object files and links them into one program
+// starting at origin
+func linkObjects(filenames []string, origin uint16) (*z80asm.Result, error) {
+	var objects []*z80asm.Object
+	for _, filename := range filenames {
+		object, err := z80asm.ReadObjectFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+	return z80asm.Link(objects, origin)
+}
+
+// writeListingAndSymbols writes the listing and symbol files, if requested
+func writeListingAndSymbols(result *z80asm.Result) {
+	if listingFile != "" {
+		if err := generateListingFile(listingFile, result, listMacros); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write listing file %s: %v\n", listingFile, err)
+			os.Exit(1)
 		}
 	}
 	
-	content := strings.Join(lines, "\n")
-	return os.WriteFile(filename, []byte(content), 0644)
+	if symbolFile != "" {
+		sorted := deterministic || os.Getenv("SOURCE_DATE_EPOCH") != ""
+		if err := generateSymbolFile(symbolFile, result, sorted); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write symbol file %s: %v\n", symbolFile, err)
+			os.Exit(1)
+		}
+	}
 }
 
-// generateSymbolFile creates a symbol file with label definitions
-func generateSymbolFile(filename string, result *z80asm.Result) error {
+// generateListingFile creates a listing file with addresses, machine code
+// and the T-states of each instruction, with a running total that starts
+// again at every label; "7/12" is a branch not taken and taken. Macro
+// invocations are listed before their expansion, whose lines are marked
+// with +. With macros set, those lines are also tagged with the macro name
+// and invocation line, and a summary of the defined macros follows.
+func generateListingFile(filename string, result *z80asm.Result, macros bool) error {
 	var lines []string
 	
-	lines = append(lines, "MinZ Z80 Assembler Symbol Table")
-	lines = append(lines, "==============================")
+	lines = append(lines, "MinZ Z80 Assembler Listing")
+	lines = append(lines, "==========================")
 	lines = append(lines, "")
+	lines = append(lines, fmt.Sprintf("%-4s  %-12s %5s %9s  %s", "ADDR", "CODE", "T", "TOTAL", "SOURCE"))
+	
+	blank := strings.Repeat(" ", 36)
+	total, totalTaken := 0, 0
+	call, callLine := "", 0
+	for _, line := range result.Listing {
+		for _, label := range line.Labels {
+			if label != line.Label {
+				lines = append(lines, blank+label+":")
+			}
+		}
+		if len(line.Labels) > 0 {
+			total, totalTaken = 0, 0
+		}
+		
+		source := line.SourceLine
+		if line.MacroCall != "" {
+			if line.MacroCall != call || line.LineNumber != callLine {
+				lines = append(lines, fmt.Sprintf("%s%-24s ; line %d", blank, line.MacroCall, line.LineNumber))
+			}
+			source = "+ " + source
+		}
+		call, callLine = line.MacroCall, line.LineNumber
+		if macros && line.Macro != "" {
+			source = fmt.Sprintf("%-24s ; [%s @ line %d]", source, line.Macro, line.LineNumber)
+		}
+		
+		if len(line.Bytes) == 0 {
+			// Format: "                                    ; comment or directive"
+			lines = append(lines, blank+source)
+			continue
+		}
+		// Format: "8000  21 34 12          10        10  LD HL,$1234"
+		codeHex := ""
+		for i, b := range line.Bytes {
+			if i > 0 {
+				codeHex += " "
+			}
+			codeHex += fmt.Sprintf("%02X", b)
+		}
+		tstates, running := "", ""
+		if line.Tstates > 0 {
+			total += line.Tstates
+			totalTaken += line.TstatesTaken
+			tstates = formatTstates(line.Tstates, line.TstatesTaken)
+			running = formatTstates(total, totalTaken)
+		}
+		lines = append(lines, fmt.Sprintf("%04X  %-12s %5s %9s  %s",
+			line.Address, codeHex, tstates, running, source))
+	}
 	
-	for name, addr := range result.Symbols {
-		lines = append(lines, fmt.Sprintf("%-20s = $%04X (%d)", name, addr, addr))
+	if macros {
+		lines = append(lines, "", "Macros:")
+		for _, macro := range result.Macros {
+			lines = append(lines, fmt.Sprintf("  %-32s %d lines", macro.Signature(), len(macro.Body)))
+		}
 	}
 	
 	content := strings.Join(lines, "\n")
 	return os.WriteFile(filename, []byte(content), 0644)
+}
+
+// formatTstates formats a T-state count, with the count when branches are
+// taken after a slash if it differs
+func formatTstates(tstates, taken int) string {
+	if taken == tstates {
+		return fmt.Sprint(tstates)
+	}
+	return fmt.Sprintf("%d/%d", tstates, taken)
+}
+
+// generateSymbolFile creates a symbol file with label definitions in the
+// --sym-format format, in alphabetical order when sorted is set
+func generateSymbolFile(filename string, result *z80asm.Result, sorted bool) error {
+	format := z80asm.SymbolFormatForFile(filename)
+	if symbolFormat != "auto" {
+		var err error
+		if format, err = z80asm.ParseSymbolFormat(symbolFormat); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(filename, z80asm.GenerateSymbols(result, format, sorted), 0644)
 }
\ No newline at end of file
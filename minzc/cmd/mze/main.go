@@ -1,23 +1,45 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"os"
+	"github.com/minz/minzc/pkg/debugger"
 	"github.com/minz/minzc/pkg/emulator"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 var (
-	loadAddr     uint
-	startAddr    uint
-	target       string
-	verbose      bool
-	cycles       bool
-	timeout      uint
+	loadAddr       uint
+	startAddr      uint
+	target         string
+	verbose        bool
+	cycles         bool
+	timeout        uint
+	coverageFile   string
+	dbgFile        string
+	traceFile      string
+	traceFormat    string
+	snapshotFile   string
+	callAddr       uint
+	registerList   string
+	expectList     string
+	debugMode      bool
+	accurateTiming bool
+	cpmDir         string
+	audioFile      string
+	machine        string
+	romFile        string
+	assertList     []string
+	assertRegList  []string
+	maxCycles      uint64
+	keyList        string
+	tuiMode        bool
 )
 
 var rootCmd = &cobra.Command{
-	Use:   "mze [binary file]",
+	Use:   "mze [binary or snapshot file] [CP/M program arguments...]",
 	Short: "MinZ Z80 Multi-Platform Emulator v2.0 - 100% Coverage!",
 	Long: `mze - MinZ Z80 Multi-Platform Emulator v2.0
 ━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
@@ -34,10 +56,72 @@ FEATURES:
 SUPPORTED PLATFORMS (-t/--target):
   spectrum - ZX Spectrum (default)
   cpm - CP/M 2.2 BDOS  
-  cpc - Amstrad CPC`,
-	Args: cobra.ExactArgs(1),
+  cpc - Amstrad CPC
+
+CP/M (.COM programs load and start at $0100):
+  mze -t cpm copy.com in.txt out.txt                 # arguments fill the command tail and FCBs
+  mze -t cpm --cpm-dir data report.com               # files of drive A: come from ./data
+
+COVERAGE:
+  mze --coverage cov.txt program.bin                 # per-address coverage report
+  mze --coverage cov.txt --dbg program.sym program.bin  # resolve to functions
+
+TRACING (one record per instruction: PC, opcode, registers, T-states):
+  mze --trace - program.bin                          # text: $PC bytes mnemonic registers T=instr/total
+  mze --trace run.jsonl --trace-format json program.bin  # JSON lines: pc, bytes, mnemonic, registers, tstates, cycles
+  mze --trace run.fuse --trace-format fuse program.bin   # Fuse tests.expected state lines, to diff against Fuse
+
+SNAPSHOTS:
+  mze game.sna                                       # run a 48K .sna or .z80 snapshot
+  mze --debug --start 0x8000 game.z80                # debug it from another address
+  mze --snapshot-out state.sna program.bin           # save 48K .sna when execution stops
+
+SOUND (AY-3-8912 on ports $FFFD/$BFFD, as on the 128K):
+  mze --accurate-timing --audio music.wav player.sna  # record the music driver's output
+
+128K SPECTRUM (port $7FFD: 8 RAM pages at $C000, shadow screen, 2 ROMs):
+  mze --machine 128 banked.bin                       # page data sets in and out while testing
+  mze --machine 128 --rom 128.rom banked.bin         # 32K image: editor ROM then 48K BASIC
+
+ACCURATE TIMING (ZX Spectrum 48K):
+  mze --accurate-timing --cycles demo.sna            # contended memory, floating bus,
+                                                     # an interrupt every 69888 T-states
+
+DEBUGGING:
+  mze --debug --dbg program.sym program.bin          # breakpoints, stepping, registers, memory
+
+TESTING SUBROUTINES:
+  mze --call 0x8100 --registers A=5,L=7 --expect A=12 program.bin
+                                                     # call one routine, stop at its RET
+
+KEYBOARD (the 48K key matrix on the even ports, as IN A, ($FE) reads it):
+  mze --keys "SPACE@100000,Q@200000" game.bin        # press keys at T-states, held 2 frames
+  mze --keys "CAPS+5@70000:140000" game.bin          # keys together, held 140000 T-states
+  mze --tui game.bin                                 # play in the terminal in real time
+
+ASSERTIONS (checked when execution stops; any failure exits with status 1):
+  mze --assert '$8000=$42' --assert-reg A=0x42 program.bin
+  mze --dbg program.sym --assert 'result=$1234' program.bin   # word at a symbol
+  mze --assert-cycles-max 50000 program.bin          # performance budget`,
+	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		binaryFile := args[0]
+		isSnapshot := emulator.IsSnapshotFile(binaryFile)
+		if len(args) > 1 && target != "cpm" {
+			fmt.Fprintf(os.Stderr, "Error: program arguments are only supported with --target cpm\n")
+			os.Exit(1)
+		}
+		if target == "cpm" && isSnapshot {
+			fmt.Fprintf(os.Stderr, "Error: --target cpm runs .COM programs, not snapshots\n")
+			os.Exit(1)
+		}
+		if target == "cpm" && !cmd.Flags().Changed("load") {
+			loadAddr = uint(emulator.CPMLoadAddr)
+		}
+		if isSnapshot && cmd.Flags().Changed("load") {
+			fmt.Fprintf(os.Stderr, "Error: --load cannot be used with a snapshot\n")
+			os.Exit(1)
+		}
 		
 		// Parse addresses
 		loadAddress := uint16(loadAddr)
@@ -46,10 +130,13 @@ SUPPORTED PLATFORMS (-t/--target):
 			startAddress = loadAddress
 		}
 
-		if verbose {
+		if verbose && !isSnapshot {
 			fmt.Printf("🎮 mze - MinZ Z80 Multi-Platform Emulator v2.0\n")
 			fmt.Printf("🚀 100% Z80 Instruction Coverage Enabled!\n")
 			fmt.Printf("🎯 Target: %s\n", target)
+			if machine == "128" {
+				fmt.Printf("💾 Memory: 128K, paged on $7FFD\n")
+			}
 			fmt.Printf("📁 Binary: %s\n", binaryFile)
 			fmt.Printf("📍 Load:   $%04X (%d)\n", loadAddress, loadAddress)
 			fmt.Printf("🚀 Start:  $%04X (%d)\n", startAddress, startAddress)
@@ -59,31 +146,225 @@ SUPPORTED PLATFORMS (-t/--target):
 			fmt.Println()
 		}
 
-		// Read the binary file
-		binary, err := os.ReadFile(binaryFile)
+		format, err := emulator.ParseTraceFormat(traceFormat)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading binary file: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if (cmd.Flags().Changed("trace-format") || cmd.Flags().Changed("exec-trace-format")) && traceFile == "" {
+			fmt.Fprintf(os.Stderr, "Error: --trace-format requires --trace\n")
 			os.Exit(1)
 		}
 
-		if verbose {
-			fmt.Printf("📦 Loaded %d bytes\n", len(binary))
+		registers, err := emulator.ParseRegisters(registerList)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --registers: %v\n", err)
+			os.Exit(1)
+		}
+		expected, err := emulator.ParseRegisters(expectList)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --expect: %v\n", err)
+			os.Exit(1)
+		}
+		calling := cmd.Flags().Changed("call")
+		if calling && cmd.Flags().Changed("start") {
+			fmt.Fprintf(os.Stderr, "Error: --call and --start cannot be used together\n")
+			os.Exit(1)
+		}
+		if calling && debugMode {
+			fmt.Fprintf(os.Stderr, "Error: --call and --debug cannot be used together\n")
+			os.Exit(1)
+		}
+		if audioFile != "" && target != "spectrum" {
+			fmt.Fprintf(os.Stderr, "Error: --audio records the ZX Spectrum's AY chip and needs --target spectrum\n")
+			os.Exit(1)
+		}
+		if accurateTiming && target != "spectrum" {
+			fmt.Fprintf(os.Stderr, "Error: --accurate-timing models the ZX Spectrum ULA and needs --target spectrum\n")
+			os.Exit(1)
+		}
+		if (keyList != "" || tuiMode) && target != "spectrum" {
+			fmt.Fprintf(os.Stderr, "Error: --keys and --tui emulate the ZX Spectrum keyboard and need --target spectrum\n")
+			os.Exit(1)
+		}
+		if tuiMode && (calling || debugMode) {
+			fmt.Fprintf(os.Stderr, "Error: --tui cannot be used with --call or --debug\n")
+			os.Exit(1)
+		}
+		if tuiMode && !term.IsTerminal(int(os.Stdin.Fd())) {
+			fmt.Fprintf(os.Stderr, "Error: --tui needs a terminal on standard input\n")
+			os.Exit(1)
+		}
+		presses, err := emulator.ParseKeyPresses(keyList)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --keys: %v\n", err)
+			os.Exit(1)
+		}
+		if machine != "48" && machine != "128" {
+			fmt.Fprintf(os.Stderr, "Error: --machine must be 48 or 128\n")
+			os.Exit(1)
+		}
+		if (machine != "48" || romFile != "") && target != "spectrum" {
+			fmt.Fprintf(os.Stderr, "Error: --machine and --rom need --target spectrum\n")
+			os.Exit(1)
+		}
+		symbols, err := readSymbols()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading symbol file: %v\n", err)
+			os.Exit(1)
+		}
+		assertions, err := parseAssertions(symbols, cmd.Flags().Changed("assert-cycles-max"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
 		}
 
 		// Create Z80 emulator with 100% coverage
 		z80 := emulator.NewRemogattoZ80WithScreen()
+		var ula *emulator.ULA
+		if accurateTiming || tuiMode {
+			// The TUI runs in real time, with an interrupt every frame
+			ula = z80.EnableAccurateTiming()
+		}
+		var ay *emulator.AY
+		if target == "spectrum" {
+			ay = z80.EnableAY(audioFile != "")
+		}
+		if machine == "128" {
+			z80.Enable128K()
+		}
+		if romFile != "" {
+			if err := loadROM(z80.RemogattoZ80, romFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Error loading ROM: %v\n", err)
+				os.Exit(1)
+			}
+		}
 		
-		// Load binary into memory at specified address
-		z80.LoadAt(loadAddress, binary)
+		// Load a snapshot with its registers, or the binary at the load address
+		var binary []byte
+		if isSnapshot {
+			snapshot, err := readSnapshot(binaryFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading snapshot: %v\n", err)
+				os.Exit(1)
+			}
+			z80.RestoreSnapshot(snapshot)
+			loadAddress, binary = 0x4000, snapshot.RAM[:]
+			startAddress = snapshot.PC
+			if cmd.Flags().Changed("start") {
+				startAddress = uint16(startAddr)
+			}
+			if verbose {
+				interrupts := "off"
+				if snapshot.IFF1 != 0 {
+					interrupts = "on"
+				}
+				fmt.Printf("🎮 mze - MinZ Z80 Multi-Platform Emulator v2.0\n")
+				fmt.Printf("🎯 Target:   %s\n", target)
+				fmt.Printf("📸 Snapshot: %s\n", binaryFile)
+				fmt.Printf("🚀 Start:    $%04X (SP=$%04X, IM %d, interrupts %s, border %d)\n",
+					startAddress, snapshot.SP, snapshot.IM, interrupts, snapshot.Border)
+				fmt.Println()
+			}
+		} else {
+			binary, err = os.ReadFile(binaryFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading binary file: %v\n", err)
+				os.Exit(1)
+			}
+			if verbose {
+				fmt.Printf("📦 Loaded %d bytes\n", len(binary))
+			}
+			z80.LoadAt(loadAddress, binary)
+		}
+		if target == "cpm" {
+			cpm := z80.EnableCPM(cpmDir, os.Stdin, os.Stdout)
+			cpm.SetCommandLine(args[1:])
+			defer cpm.Close()
+		}
+		var keyboard *emulator.Keyboard
+		if keyList != "" || tuiMode {
+			keyboard = z80.EnableKeyboard()
+			keyboard.Schedule(presses...)
+		}
 		z80.SetPC(startAddress)
+		for _, r := range registers {
+			z80.SetRegister(r.Name, r.Value)
+		}
 		
-		if verbose {
+		var coverage *emulator.Coverage
+		if coverageFile != "" {
+			coverage = z80.EnableCoverage()
+		}
+		
+		var tracer *emulator.Tracer
+		var traceOut *bufio.Writer
+		if traceFile != "" {
+			out := os.Stdout
+			if traceFile != "-" {
+				f, err := os.Create(traceFile)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error creating trace file: %v\n", err)
+					os.Exit(1)
+				}
+				defer f.Close()
+				out = f
+			}
+			traceOut = bufio.NewWriter(out)
+			tracer = emulator.NewTracer(traceOut, format)
+			z80.SetTracer(tracer)
+		}
+		
+		if verbose && calling {
+			fmt.Printf("▶️  Calling $%04X with 100%% coverage...\n", callAddr)
+			fmt.Println("----------------------------------------")
+		} else if verbose {
 			fmt.Printf("▶️  Starting execution at $%04X with 100%% coverage...\n", startAddress)
 			fmt.Println("----------------------------------------")
 		}
 
-		// Execute the program
-		err = z80.Execute()
+		// Execute the program, just the routine under test, or under the debugger
+		if calling {
+			err = z80.Call(uint16(callAddr))
+		} else if debugMode {
+			err = debugger.NewRemogattoDebugger(z80.RemogattoZ80, symbols, nil).Run()
+		} else if tuiMode {
+			err = runTUI(z80, keyboard)
+		} else {
+			err = z80.Execute()
+		}
+		
+		// Flush the trace first so it covers a failing run too
+		if tracer != nil {
+			traceErr := tracer.Err()
+			if traceErr == nil {
+				traceErr = traceOut.Flush()
+			}
+			if traceErr != nil {
+				fmt.Fprintf(os.Stderr, "Error writing trace: %v\n", traceErr)
+				os.Exit(1)
+			}
+		}
+		
+		// Save the snapshot before reporting errors, so a failed run can be inspected
+		if snapshotFile != "" {
+			if err := writeSnapshot(z80.Snapshot()); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing snapshot: %v\n", err)
+				os.Exit(1)
+			}
+			if verbose {
+				fmt.Printf("💾 Snapshot saved to %s\n", snapshotFile)
+			}
+		}
+		if audioFile != "" {
+			if err := writeAudio(ay); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing audio: %v\n", err)
+				os.Exit(1)
+			}
+			if verbose {
+				fmt.Printf("🔊 Audio saved to %s (%d samples at %d Hz)\n", audioFile, len(ay.Samples()), emulator.AudioSampleRate)
+			}
+		}
 		if err != nil {
 			fmt.Printf("❌ Execution error: %v\n", err)
 			os.Exit(1)
@@ -92,6 +373,13 @@ SUPPORTED PLATFORMS (-t/--target):
 		exitCode := z80.GetExitCode()
 		totalCycles := z80.GetCycles()
 		
+		if coverage != nil && len(binary) > 0 {
+			if err := writeCoverage(coverage, loadAddress, len(binary), symbols); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing coverage report: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		
 		if verbose {
 			fmt.Println("----------------------------------------")
 			fmt.Printf("🏁 Program completed with exit code: %d\n", exitCode)
@@ -99,6 +387,23 @@ SUPPORTED PLATFORMS (-t/--target):
 		
 		if cycles {
 			fmt.Printf("⏱️  Total execution: %d T-states\n", totalCycles)
+			if ula != nil {
+				fmt.Printf("🖥️  Frame %d, T-state %d of %d\n", ula.Frame(), ula.FramePosition(), emulator.FrameTstates)
+			}
+		}
+		
+		if calling {
+			regs := z80.GetRegisters()
+			fmt.Printf("↩️  Returned from $%04X: A=$%02X F=$%02X BC=$%04X DE=$%04X HL=$%04X IX=$%04X IY=$%04X SP=$%04X\n",
+				callAddr, regs.A, regs.F, regs.BC, regs.DE, regs.HL, regs.IX, regs.IY, regs.SP)
+		}
+		registersOK := checkRegisters(z80.GetRegisters(), expected)
+		if failures := z80.CheckAssertions(assertions); len(failures) > 0 {
+			emulator.WriteAssertionReport(os.Stderr, failures, len(assertions))
+			os.Exit(1)
+		}
+		if !registersOK {
+			os.Exit(1)
 		}
 		
 		if verbose {
@@ -121,15 +426,201 @@ SUPPORTED PLATFORMS (-t/--target):
 func init() {
 	// Memory options
 	rootCmd.Flags().UintVar(&loadAddr, "load", 0x8000, "load address for binary (default: 0x8000)")
-	rootCmd.Flags().UintVar(&startAddr, "start", 0, "start address (default: same as load address)")
-	
+	rootCmd.Flags().UintVar(&startAddr, "start", 0, "start address (default: same as load address, or the PC of a snapshot)")
+
 	// Platform options
 	rootCmd.Flags().StringVarP(&target, "target", "t", "spectrum", "target platform (spectrum, cpm, cpc)")
-	
+	rootCmd.Flags().StringVar(&machine, "machine", "48", "ZX Spectrum model: 48, or 128 for $7FFD memory paging")
+	rootCmd.Flags().StringVar(&romFile, "rom", "", "ROM image to load at $0000: 16K, or 32K for both 128K ROMs")
+	rootCmd.Flags().StringVar(&cpmDir, "cpm-dir", ".", "host directory holding the files of CP/M drive A: (--target cpm)")
+
 	// Execution options
 	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose execution info")
 	rootCmd.Flags().BoolVarP(&cycles, "cycles", "c", false, "show T-state cycle count")
 	rootCmd.Flags().UintVar(&timeout, "timeout", 0, "execution timeout in cycles (0 = no timeout)")
+	rootCmd.Flags().BoolVar(&accurateTiming, "accurate-timing", false, "model ZX Spectrum 48K ULA timing: contended memory and I/O, floating bus, frame interrupts")
+	rootCmd.Flags().StringVar(&audioFile, "audio", "", "write the AY-3-8912 sound chip output as a 44.1 kHz WAV file")
+
+	// Coverage options
+	rootCmd.Flags().StringVar(&coverageFile, "coverage", "", "write per-address execution coverage report to file")
+	rootCmd.Flags().StringVar(&dbgFile, "dbg", "", "symbol file (mza -s output) for resolving coverage and --debug addresses to functions")
+
+	// Keyboard options
+	rootCmd.Flags().StringVar(&keyList, "keys", "", "press keys at T-states, e.g. SPACE@100000,Q@200000 or CAPS+5@70000:140000 (KEYS@TSTATE[:HOLD])")
+	rootCmd.Flags().BoolVar(&tuiMode, "tui", false, "run in real time in the terminal: host keys type on the Spectrum keyboard and the screen is drawn every frame")
+
+	// Debugger options
+	rootCmd.Flags().BoolVar(&debugMode, "debug", false, "run the program under an interactive debugger")
+
+	// Trace options
+	rootCmd.Flags().StringVar(&traceFile, "trace", "", "write an instruction trace to file (- for stdout)")
+	rootCmd.Flags().StringVar(&traceFormat, "trace-format", "text", "trace format: text, json (JSON lines) or fuse")
+	rootCmd.Flags().StringVar(&traceFormat, "exec-trace-format", "text", "trace format: text, json (JSON lines) or fuse")
+	rootCmd.Flags().MarkDeprecated("exec-trace-format", "use --trace-format")
+
+	// Subroutine testing options
+	rootCmd.Flags().UintVar(&callAddr, "call", 0, "call the routine at this address and stop when it returns")
+	rootCmd.Flags().StringVar(&registerList, "registers", "", "set registers before running, e.g. A=5,HL=$9000")
+	rootCmd.Flags().StringVar(&expectList, "expect", "", "fail unless registers hold these values when execution stops, e.g. A=12")
+
+	// Assertion options
+	rootCmd.Flags().StringArrayVar(&assertList, "assert", nil, "fail unless memory holds a value when execution stops, e.g. $8000=$42 or sym=$1234 (repeatable)")
+	rootCmd.Flags().StringArrayVar(&assertRegList, "assert-reg", nil, "fail unless registers hold these values when execution stops, e.g. A=0x42 (repeatable)")
+	rootCmd.Flags().Uint64Var(&maxCycles, "assert-cycles-max", 0, "fail if execution takes more than this many T-states")
+
+	// Snapshot options
+	rootCmd.Flags().StringVar(&snapshotFile, "snapshot-out", "", "save registers and 48K RAM as a .sna snapshot when execution stops")
+}
+
+// loadROM loads a ROM image: 16K for the ROM at $0000, or on a 128K 32K
+// holding ROM 0 (the editor) and then ROM 1 (48K BASIC)
+func loadROM(z80 *emulator.RemogattoZ80, filename string) error {
+	rom, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	paging := z80.Paging()
+	switch {
+	case len(rom) == emulator.PageSize && paging != nil:
+		paging.LoadROM(0, rom)
+	case len(rom) == emulator.PageSize:
+		return z80.LoadMemory(0, rom)
+	case len(rom) == 2*emulator.PageSize && paging != nil:
+		paging.LoadROM(0, rom[:emulator.PageSize])
+		paging.LoadROM(1, rom[emulator.PageSize:])
+	case len(rom) == 2*emulator.PageSize:
+		return fmt.Errorf("%s is a 32K 128K ROM; use it with --machine 128", filename)
+	default:
+		return fmt.Errorf("%s is %d bytes; a ROM image is 16K, or 32K for the 128K", filename, len(rom))
+	}
+	return nil
+}
+
+// checkRegisters reports each register that does not hold its expected
+// value and returns whether all of them do
+func checkRegisters(regs emulator.Registers, expected []emulator.RegisterValue) bool {
+	ok := true
+	for _, want := range expected {
+		got, _ := regs.Register(want.Name)
+		if got != want.Value {
+			fmt.Fprintf(os.Stderr, "❌ Expected %s=$%02X, got $%02X\n", want.Name, want.Value, got)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// parseAssertions parses the --assert, --assert-reg and --assert-cycles-max
+// post-conditions
+func parseAssertions(symbols map[string]uint16, checkCycles bool) ([]emulator.Assertion, error) {
+	var assertions []emulator.Assertion
+	for _, spec := range assertList {
+		a, err := emulator.ParseMemoryAssertion(spec, symbols)
+		if err != nil {
+			return nil, fmt.Errorf("--assert: %v", err)
+		}
+		assertions = append(assertions, a)
+	}
+	for _, spec := range assertRegList {
+		regs, err := emulator.ParseRegisterAssertions(spec)
+		if err != nil {
+			return nil, fmt.Errorf("--assert-reg: %v", err)
+		}
+		assertions = append(assertions, regs...)
+	}
+	if checkCycles {
+		assertions = append(assertions, emulator.CyclesMaxAssertion(maxCycles))
+	}
+	return assertions, nil
+}
+
+// writeSnapshot saves the machine state to the --snapshot-out file
+func writeSnapshot(snapshot *emulator.Snapshot) error {
+	out, err := os.Create(snapshotFile)
+	if err != nil {
+		return err
+	}
+	if err := emulator.WriteSNA(out, snapshot); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writeAudio saves the AY chip's output to the --audio file
+func writeAudio(ay *emulator.AY) error {
+	out, err := os.Create(audioFile)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	if err := ay.WriteWAV(w); err != nil {
+		out.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// readSnapshot reads a .sna or .z80 snapshot file
+func readSnapshot(filename string) (*emulator.Snapshot, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return emulator.ReadSnapshot(filename, f)
+}
+
+// readSymbols reads the --dbg symbol file, if any
+func readSymbols() (map[string]uint16, error) {
+	if dbgFile == "" {
+		return nil, nil
+	}
+	f, err := os.Open(dbgFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	symbols, err := emulator.ParseSymbols(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", dbgFile, err)
+	}
+	return symbols, nil
+}
+
+// writeCoverage writes the coverage report for the loaded binary and prints a summary
+func writeCoverage(coverage *emulator.Coverage, loadAddress uint16, size int, symbols map[string]uint16) error {
+	start := loadAddress
+	end := uint16(int(loadAddress) + size - 1)
+	if int(loadAddress)+size > 0x10000 {
+		end = 0xFFFF
+	}
+
+	out, err := os.Create(coverageFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := coverage.WriteReport(out, start, end, symbols); err != nil {
+		return err
+	}
+
+	fmt.Printf("📈 Coverage: %.1f%% (%d/%d bytes) → %s\n",
+		coverage.Percent(start, end), coverage.Count(start, end), int(end)-int(start)+1, coverageFile)
+	if symbols != nil {
+		unexecuted := coverage.UnexecutedSymbols(start, end, symbols)
+		if len(unexecuted) > 0 {
+			fmt.Printf("   Unexecuted functions:\n")
+			for _, name := range unexecuted {
+				fmt.Printf("     $%04X  %s\n", symbols[name], name)
+			}
+		}
+	}
+	return nil
 }
 
 func main() {
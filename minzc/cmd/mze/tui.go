@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/minz/minzc/pkg/emulator"
+	"golang.org/x/term"
+)
+
+// frameTime is how long a 50 Hz frame takes on the real machine
+const frameTime = 20 * time.Millisecond
+
+// runTUI runs the program in real time in the terminal: keys typed on the
+// host are pressed on the Spectrum keyboard, and the screen at $4000 is
+// drawn with braille characters once a frame. Ctrl-C stops it. It returns
+// when the program ends, like Execute.
+func runTUI(z80 *emulator.RemogattoZ80WithScreen, keyboard *emulator.Keyboard) error {
+	fd := int(os.Stdin.Fd())
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, oldState)
+
+	out := bufio.NewWriter(os.Stdout)
+	fmt.Fprint(out, "\x1b[?25l\x1b[2J")
+	defer func() {
+		fmt.Fprint(out, "\x1b[?25h\r\n")
+		out.Flush()
+	}()
+
+	input := make(chan byte, 64)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(input)
+				return
+			}
+			for _, b := range buf[:n] {
+				input <- b
+			}
+		}
+	}()
+
+	z80.SetCycleLimit(0)
+	next := time.Now()
+	for {
+		frameEnd := z80.GetCycles() + emulator.FrameTstates
+		stopped, err := z80.RunUntil(func(pc uint16) bool { return z80.GetCycles() >= frameEnd })
+		if err != nil || !stopped {
+			return err
+		}
+		if !typeKeys(keyboard, input) {
+			return nil
+		}
+
+		drawScreen(out, z80.RemogattoZ80)
+		if err := out.Flush(); err != nil {
+			return err
+		}
+		next = next.Add(frameTime)
+		if wait := time.Until(next); wait > 0 {
+			time.Sleep(wait)
+		} else {
+			next = time.Now()
+		}
+	}
+}
+
+// cursorKeys are the Spectrum keys for the host's cursor keys, by the last
+// byte of their escape sequence
+var cursorKeys = map[byte]string{'A': "7", 'B': "6", 'C': "8", 'D': "5"}
+
+// typeKeys presses the Spectrum keys for the host keys typed since the last
+// frame, and reports false once Ctrl-C is typed or input ends
+func typeKeys(keyboard *emulator.Keyboard, input <-chan byte) bool {
+	var typed []byte
+drain:
+	for {
+		select {
+		case b, ok := <-input:
+			if !ok {
+				return false
+			}
+			typed = append(typed, b)
+		default:
+			break drain
+		}
+	}
+	for i := 0; i < len(typed); i++ {
+		ch := typed[i]
+		if ch == 0x03 {
+			return false
+		}
+		// Cursor keys arrive as ESC [ A-D: CAPS SHIFT with 5-8
+		if ch == 0x1B && i+2 < len(typed) && typed[i+1] == '[' {
+			if key, ok := cursorKeys[typed[i+2]]; ok {
+				keyboard.Press("CAPS", key)
+			}
+			i += 2
+			continue
+		}
+		if keys, ok := emulator.HostKeys(ch); ok {
+			keyboard.Press(keys...)
+		}
+	}
+	return true
+}
+
+// drawScreen draws the 256x192 bitmap as 128x48 braille characters, each
+// 2 pixels wide and 4 high, then a status line
+func drawScreen(out *bufio.Writer, z80 *emulator.RemogattoZ80) {
+	// Braille dot bits for each pixel of a 2x4 cell
+	dots := [4][2]rune{{0x01, 0x08}, {0x02, 0x10}, {0x04, 0x20}, {0x40, 0x80}}
+	fmt.Fprint(out, "\x1b[H")
+	var line strings.Builder
+	for row := 0; row < 192; row += 4 {
+		line.Reset()
+		for col := 0; col < 256; col += 2 {
+			cell := rune(0x2800)
+			for dy := 0; dy < 4; dy++ {
+				for dx := 0; dx < 2; dx++ {
+					if pixel(z80, col+dx, row+dy) {
+						cell |= dots[dy][dx]
+					}
+				}
+			}
+			line.WriteRune(cell)
+		}
+		fmt.Fprintf(out, "%s\r\n", line.String())
+	}
+	regs := z80.GetRegisters()
+	fmt.Fprintf(out, "PC=$%04X  T-states %d  Ctrl-C quits\x1b[K", regs.PC, z80.GetCycles())
+}
+
+// pixel reports whether a pixel of the bitmap is set; the colours in the
+// attributes are not drawn
+func pixel(z80 *emulator.RemogattoZ80, x, y int) bool {
+	address := 0x4000 | (y&0xC0)<<5 | (y&0x07)<<8 | (y&0x38)<<2 | x>>3
+	return z80.GetMemory(uint16(address))&(0x80>>(x&7)) != 0
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/minz/minzc/pkg/codegen"
+)
+
+// Code typed at the prompt always runs on the emulated Z80. /compile shows
+// what any registered backend generates for it instead, in the context of
+// the session's functions and variables, and /mir the MIR they all share.
+
+// setBackend shows or changes the backend /compile uses
+func (r *REPL) setBackend(args []string) {
+	if len(args) == 0 {
+		fmt.Printf("Current backend: %s\n", r.backend)
+		return
+	}
+	name := strings.ToLower(args[0])
+	if codegen.GetBackend(name, nil) == nil {
+		fmt.Printf("Unknown backend: %s\n", args[0])
+		fmt.Printf("Available: %s\n", strings.Join(backendNames(), ", "))
+		return
+	}
+	r.backend = name
+	fmt.Printf("/compile now shows %s code\n", name)
+}
+
+// showBackends lists the registered backends
+func (r *REPL) showBackends() {
+	fmt.Println("Available backends:")
+	for _, name := range backendNames() {
+		marker := " "
+		if name == r.backend {
+			marker = "*"
+		}
+		ext := codegen.GetBackend(name, nil).GetFileExtension()
+		fmt.Printf(" %s %-10s (%s)\n", marker, name, ext)
+	}
+}
+
+// showCompiled prints the code the current backend generates for input
+func (r *REPL) showCompiled(input string) {
+	code, err := r.compiler.Generate(input, r.context, r.backend)
+	if err != nil {
+		fmt.Printf("Compilation error: %v\n", err)
+		return
+	}
+	fmt.Print(code)
+	if !strings.HasSuffix(code, "\n") {
+		fmt.Println()
+	}
+}
+
+// showMIR prints the optimized MIR of the function input is wrapped in,
+// or of the functions it defines
+func (r *REPL) showMIR(input string) {
+	module, err := r.compiler.MIR(input, r.context)
+	if err != nil {
+		fmt.Printf("Compilation error: %v\n", err)
+		return
+	}
+
+	// Only what this input adds, not the session's earlier functions
+	for _, fn := range module.Functions {
+		name := sourceName(fn.Name)
+		if _, known := r.context.functions[name]; known {
+			continue
+		}
+		fmt.Printf("%s -> %s\n", fn.Name, fn.ReturnType)
+		for i, inst := range fn.Instructions {
+			fmt.Printf("  %3d: %s\n", i, inst.String())
+		}
+	}
+}
+
+// backendNames returns the registered backends in order
+func backendNames() []string {
+	names := codegen.ListBackends()
+	sort.Strings(names)
+	return names
+}
@@ -9,18 +9,28 @@ import (
 	"strings"
 
 	"github.com/minz/minzc/pkg/codegen"
+	"github.com/minz/minzc/pkg/ir"
 	"github.com/minz/minzc/pkg/optimizer"
 	"github.com/minz/minzc/pkg/parser"
 	"github.com/minz/minzc/pkg/semantic"
 	"github.com/minz/minzc/pkg/z80asm"
 )
 
+// The functions expressions and statements are wrapped in, which the REPL
+// calls to evaluate them
+const (
+	replEval = "__repl_eval"
+	replExec = "__repl_exec"
+)
+
 // CompileResult holds the result of compilation
 type CompileResult struct {
 	MachineCode []byte
-	EntryPoint  uint16
+	Origin      uint16 // Where MachineCode is loaded
+	EntryPoint  uint16 // The wrapper function to call, 0 for a function definition
 	DataSize    uint16
 	Functions   map[string]uint16 // Function name -> address
+	Sizes       map[string]uint16 // Function name -> code size
 	Variables   map[string]uint16 // Variable name -> address
 	Errors      []string
 }
@@ -83,7 +93,7 @@ func (c *REPLCompiler) wrapExpression(expr string, ctx *Context) string {
 	}
 	
 	// Create evaluation function
-	sb.WriteString("fun __repl_eval() -> u16 {\n")
+	sb.WriteString("fun " + replEval + "() -> u16 {\n")
 	sb.WriteString(fmt.Sprintf("    let __result = %s;\n", expr))
 	sb.WriteString("    return __result;\n")
 	sb.WriteString("}\n")
@@ -106,8 +116,12 @@ func (c *REPLCompiler) wrapStatement(stmt string, ctx *Context) string {
 	}
 	
 	// Wrap in function
-	sb.WriteString("fun __repl_exec() -> void {\n")
-	sb.WriteString("    " + stmt + "\n")
+	sb.WriteString("fun " + replExec + "() -> void {\n")
+	sb.WriteString("    " + stmt)
+	if !strings.HasSuffix(stmt, ";") && !strings.HasSuffix(stmt, "}") {
+		sb.WriteString(";")
+	}
+	sb.WriteString("\n")
 	sb.WriteString("}\n")
 	
 	return sb.String()
@@ -134,14 +148,40 @@ func (c *REPLCompiler) wrapFunction(funcDef string, ctx *Context) string {
 	return sb.String()
 }
 
-// compile performs the actual compilation
-func (c *REPLCompiler) compile(source string, ctx *Context) (*CompileResult, error) {
-	result := &CompileResult{
-		Functions: make(map[string]uint16),
-		Variables: make(map[string]uint16),
-		Errors:    []string{},
+// Wrap wraps input, classified by ClassifyInput, with the session's
+// variables and functions into a complete source file
+func (c *REPLCompiler) Wrap(input string, ctx *Context) string {
+	switch ClassifyInput(input) {
+	case "expression":
+		return c.wrapExpression(input, ctx)
+	case "function":
+		return c.wrapFunction(input, ctx)
+	default:
+		return c.wrapStatement(input, ctx)
 	}
-	
+}
+
+// Generate compiles input in the session's context with the named backend
+// and returns the generated code
+func (c *REPLCompiler) Generate(input string, ctx *Context, backend string) (string, error) {
+	gen := codegen.GetBackend(backend, &codegen.BackendOptions{OptimizationLevel: 1})
+	if gen == nil {
+		return "", fmt.Errorf("unknown backend: %s", backend)
+	}
+	module, err := c.analyze(c.Wrap(input, ctx))
+	if err != nil {
+		return "", err
+	}
+	return gen.Generate(module)
+}
+
+// MIR compiles input in the session's context to optimized MIR
+func (c *REPLCompiler) MIR(input string, ctx *Context) (*ir.Module, error) {
+	return c.analyze(c.Wrap(input, ctx))
+}
+
+// analyze parses, analyzes and optimizes a source file
+func (c *REPLCompiler) analyze(source string) (*ir.Module, error) {
 	// Write source to temp file
 	sourceFile := filepath.Join(c.tempDir, "repl_input.minz")
 	if err := ioutil.WriteFile(sourceFile, []byte(source), 0644); err != nil {
@@ -152,22 +192,39 @@ func (c *REPLCompiler) compile(source string, ctx *Context) (*CompileResult, err
 	p := parser.New()
 	astFile, err := p.ParseFile(sourceFile)
 	if err != nil {
-		result.Errors = append(result.Errors, fmt.Sprintf("parse error: %v", err))
-		return result, err
+		return nil, fmt.Errorf("parse error: %w", err)
 	}
 	
 	// Semantic analysis
 	analyzer := semantic.NewAnalyzer()
+	defer analyzer.Close()
 	irModule, err := analyzer.Analyze(astFile)
 	if err != nil {
-		result.Errors = append(result.Errors, fmt.Sprintf("semantic error: %v", err))
-		return result, err
+		return nil, fmt.Errorf("semantic error: %w", err)
 	}
-	defer analyzer.Close()
 	
 	// Optimization (basic for REPL)
 	opt := optimizer.NewOptimizer(optimizer.OptLevelBasic)
-	opt.Optimize(irModule)
+	if err := opt.Optimize(irModule); err != nil {
+		return nil, fmt.Errorf("optimization error: %w", err)
+	}
+	return irModule, nil
+}
+
+// compile performs the actual compilation
+func (c *REPLCompiler) compile(source string, ctx *Context) (*CompileResult, error) {
+	result := &CompileResult{
+		Functions: make(map[string]uint16),
+		Sizes:     make(map[string]uint16),
+		Variables: make(map[string]uint16),
+		Errors:    []string{},
+	}
+	
+	irModule, err := c.analyze(source)
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result, err
+	}
 	
 	// Code generation
 	var asmBuf bytes.Buffer
@@ -195,20 +252,23 @@ func (c *REPLCompiler) compile(source string, ctx *Context) (*CompileResult, err
 	machineCode := asmResult.Binary
 	
 	result.MachineCode = machineCode
-	result.EntryPoint = asmResult.Origin
+	result.Origin = asmResult.Origin
 	
 	// Update next code position
 	c.nextCode += uint16(len(machineCode))
 	
-	// Extract function addresses from assembly symbols
-	for name, addr := range asmResult.Symbols {
-		result.Functions[name] = addr
+	// Find where each function's code went
+	end := asmResult.Origin + uint16(len(machineCode))
+	for name, r := range functionRanges(assembly, asmResult.Listing, end) {
+		name = sourceName(name)
+		if _, exists := result.Functions[name]; !exists {
+			result.Functions[name] = r[0]
+			result.Sizes[name] = r[1] - r[0]
+		}
 	}
-	
-	// Extract function addresses from IR as fallback
-	for _, fn := range irModule.Functions {
-		if _, exists := result.Functions[fn.Name]; !exists {
-			result.Functions[fn.Name] = c.nextCode
+	for _, entry := range []string{replEval, replExec} {
+		if addr, ok := result.Functions[entry]; ok {
+			result.EntryPoint = addr
 		}
 	}
 	
@@ -217,6 +277,49 @@ func (c *REPLCompiler) compile(source string, ctx *Context) (*CompileResult, err
 	return result, nil
 }
 
+// functionRanges returns the start and end address of each function in the
+// assembled code, found from the "; Function:" and "; End of function:"
+// comments the code generator writes around it
+func functionRanges(assembly string, listing []z80asm.ListingLine, end uint16) map[string][2]uint16 {
+	// The address of the first instruction after a source line
+	addressAfter := func(lineNumber int) uint16 {
+		for _, l := range listing {
+			if l.LineNumber > lineNumber {
+				return l.Address
+			}
+		}
+		return end
+	}
+	
+	ranges := make(map[string][2]uint16)
+	starts := make(map[string]uint16)
+	for i, line := range strings.Split(assembly, "\n") {
+		line = strings.TrimSpace(line)
+		if name, ok := strings.CutPrefix(line, "; Function: "); ok {
+			starts[name] = addressAfter(i + 1)
+		} else if name, ok := strings.CutPrefix(line, "; End of function: "); ok {
+			if start, found := starts[name]; found {
+				if _, seen := ranges[name]; !seen {
+					ranges[name] = [2]uint16{start, addressAfter(i + 1)}
+				}
+			}
+		}
+	}
+	return ranges
+}
+
+// sourceName turns a compiled function name such as repl_input.add$u8$u8
+// back into the name it was defined with
+func sourceName(name string) string {
+	if i := strings.Index(name, "$"); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
 // Reset resets the compiler state
 func (c *REPLCompiler) Reset() {
 	c.nextCode = c.codeBase
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxHistory is how many lines are kept in the history file
+const maxHistory = 1000
+
+// defaultHistoryFile returns ~/.minz_history, or "" without a home directory
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".minz_history")
+}
+
+// loadHistory reads the lines typed in earlier sessions
+func (r *REPL) loadHistory() {
+	if r.historyFile == "" {
+		return
+	}
+	data, err := os.ReadFile(r.historyFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			r.history = append(r.history, line)
+		}
+	}
+	if len(r.history) > maxHistory {
+		r.history = r.history[len(r.history)-maxHistory:]
+	}
+}
+
+// addHistory adds a line unless it repeats the last one, and appends it to
+// the history file
+func (r *REPL) addHistory(line string) {
+	if len(r.history) > 0 && r.history[len(r.history)-1] == line {
+		return
+	}
+	r.history = append(r.history, line)
+	if r.historyFile == "" {
+		return
+	}
+	f, err := os.OpenFile(r.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+// showHistory lists the last n lines of history, 20 by default
+func (r *REPL) showHistory(args []string) {
+	n := 20
+	if len(args) > 0 {
+		count, err := strconv.Atoi(args[0])
+		if err != nil || count < 1 {
+			fmt.Printf("Invalid count: %s\n", args[0])
+			return
+		}
+		n = count
+	}
+	start := len(r.history) - n
+	if start < 0 {
+		start = 0
+	}
+	for i := start; i < len(r.history); i++ {
+		fmt.Printf("%5d  %s\n", i+1, r.history[i])
+	}
+}
+
+// searchHistory lists the history lines containing text, ignoring case
+func (r *REPL) searchHistory(text string) {
+	query := strings.ToLower(text)
+	found := 0
+	for i, line := range r.history {
+		if strings.Contains(strings.ToLower(line), query) {
+			fmt.Printf("%5d  %s\n", i+1, line)
+			found++
+		}
+	}
+	if found == 0 {
+		fmt.Printf("No history lines contain %q\n", text)
+	}
+}
@@ -3,424 +3,818 @@ package main
 import (
 	"bufio"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
-
-	"github.com/minz/minzc/pkg/parser"
-	"github.com/minz/minzc/pkg/ir"
-	"github.com/minz/minzc/pkg/optimizer"
-	"github.com/minz/minzc/pkg/codegen"
-	"github.com/minz/minzc/pkg/readline"
+	
+	"github.com/minz/minzc/pkg/emulator"
+	"github.com/minz/minzc/pkg/tas"
+	"golang.org/x/term"
 )
 
 // REPL represents the MinZ Read-Eval-Print-Loop
 type REPL struct {
-	reader      *readline.Reader
-	codeBase    uint16
-	dataBase    uint16
-	modules     map[string]*ir.Module
-	currentMod  *ir.Module
+	emulator  *emulator.REPLCompatibleZ80  // Now with 100% Z80 coverage!
+	context   *Context
+	compiler  *REPLCompiler
+	reader    *bufio.Reader
+	history   []string
+	historyIndex int    // Current position in history
+	historyFile  string // Where history is kept between sessions, "" for none
+	autoShowScreen bool // Show ZX Spectrum screen after execution
+	backend   string    // Backend /compile shows code for; code always runs on the Z80
+	watches   []Watch   // Memory checked for changes after each evaluation
 	
-	// Current backend
-	currentBackend string
+	// TAS debugging support
+	tasDebugger *tas.TASDebugger
+	tasEnabled  bool
+	tasUI       *tas.TASUI
 	
-	// Backend options
-	backendOpts *codegen.BackendOptions
+	// Terminal state for raw mode
+	oldTermState *term.State
+}
+
+// Context maintains REPL state between commands
+type Context struct {
+	variables map[string]Variable
+	functions map[string]Function
+	codeBase  uint16 // Where to place next code
+	dataBase  uint16 // Where to place next data
+}
+
+type Variable struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+	Addr  uint16      `json:"addr"`
+}
+
+type Function struct {
+	Name    string   `json:"name"`
+	Params  []string `json:"params,omitempty"`
+	Body    string   `json:"body,omitempty"`
+	Address uint16   `json:"address"`
+	Size    uint16   `json:"size"`
+	Source  string   `json:"source"` // MinZ source code
 }
 
 // New creates a new REPL instance
 func New() *REPL {
-	// Get history file path
-	homeDir, _ := os.UserHomeDir()
-	historyFile := filepath.Join(homeDir, ".minz_history")
+	ctx := &Context{
+		variables: make(map[string]Variable),
+		functions: make(map[string]Function),
+		codeBase:  0x8000, // Start of user RAM
+		dataBase:  0xC000, // Data segment
+	}
+	r := &REPL{
+		emulator:   emulator.NewREPLCompatibleZ80(),  // 100% Z80 coverage!
+		context:    ctx,
+		compiler:   NewREPLCompiler(ctx.codeBase, ctx.dataBase),
+		reader:     bufio.NewReader(os.Stdin),
+		history:    []string{},
+		historyFile: defaultHistoryFile(),
+		autoShowScreen: false, // Off by default
+		backend:    "z80",
+	}
+	r.loadHistory()
+	return r
+}
+
+// Run starts the REPL main loop
+func (r *REPL) Run() {
+	r.printBanner()
 	
-	// Create readline reader with history support
-	reader := readline.NewReader(&readline.Config{
-		Prompt:      "", // We'll set this dynamically
-		HistoryFile: historyFile,
-		MaxHistory:  1000,
-	})
+	// Set up terminal for raw mode if supported
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+		if err == nil {
+			r.oldTermState = oldState
+			defer r.restoreTerminal()
+		}
+	}
 	
-	return &REPL{
-		reader:    reader,
-		codeBase:  0x8000,
-		dataBase:  0xF000,
-		modules:   make(map[string]*ir.Module),
-		currentBackend: "z80", // Default to Z80
-		backendOpts: &codegen.BackendOptions{
-			OptimizationLevel: 1,
-			EnableSMC: false,
-		},
+	for {
+		input := r.readLineWithHistory()
+		if input == nil {
+			// EOF or quit
+			r.quit()
+			return
+		}
+		
+		line := strings.TrimSpace(*input)
+		if line == "" {
+			continue
+		}
+		
+		r.addHistory(line)
+		
+		if r.isCommand(line) {
+			r.executeCommand(line)
+		} else {
+			r.evaluate(line)
+		}
 	}
 }
 
-func (r *REPL) printBanner() {
-	fmt.Println("🚀 MinZ REPL v0.10.1 \"History Enhanced\" - Interactive Development Environment")
-	fmt.Println("🎊 Now with COMMAND HISTORY! Use ↑/↓ arrows to navigate (where supported)")
-	fmt.Println()
-	fmt.Println("Commands:")
-	fmt.Println("  :load <file>     - Load MinZ module from file")
-	fmt.Println("  :compile <expr>  - Compile MinZ expression")
-	fmt.Println("  :mir <expr>      - Show MIR for expression") 
-	fmt.Println("  :backend <name>  - Set backend (z80, c, llvm, wasm)")
-	fmt.Println("  :backends        - List available backends")
-	fmt.Println("  :run <expr>      - Compile and execute (Z80 only)")
-	fmt.Println("  :history         - Show command history")
-	fmt.Println("  :search <text>   - Search history")
-	fmt.Println("  :clear           - Clear screen")
-	fmt.Println("  :help            - Show this help")
-	fmt.Println("  :exit            - Exit REPL")
-	fmt.Println()
-	fmt.Println("🎯 History is automatically saved to ~/.minz_history")
-	fmt.Println("🎯 Try some lambda iterators:")
-	fmt.Println("  :compile [1,2,3,4,5].iter().map(|x| x * 2).filter(|x| x > 5)")
-	fmt.Println()
+// restoreTerminal restores the terminal to its original state
+func (r *REPL) restoreTerminal() {
+	if r.oldTermState != nil {
+		term.Restore(int(os.Stdin.Fd()), r.oldTermState)
+	}
 }
 
-func (r *REPL) Run() {
-	r.printBanner()
+// readLineWithHistory reads a line with arrow key history support
+func (r *REPL) readLineWithHistory() *string {
+	fmt.Print("minz> ")
+	
+	// If not a terminal, fall back to simple reading
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		input, err := r.reader.ReadString('\n')
+		if err != nil {
+			return nil
+		}
+		result := strings.TrimSpace(input)
+		return &result
+	}
+	
+	var line []rune
+	cursorPos := 0
+	r.historyIndex = len(r.history)
 	
 	for {
-		// Set dynamic prompt
-		prompt := fmt.Sprintf("minz[%s]> ", r.currentBackend)
-		r.reader.SetPrompt(prompt)
-		
-		// Read line with history support
-		input, err := r.reader.ReadLine()
+		// Read a single character
+		var buf [3]byte
+		n, err := os.Stdin.Read(buf[:])
 		if err != nil {
-			if err.Error() == "EOF" {
-				fmt.Println("\nGoodbye!")
-				return
+			if err == io.EOF {
+				return nil
 			}
-			fmt.Printf("Error reading input: %v\n", err)
 			continue
 		}
 		
-		input = strings.TrimSpace(input)
-		if input == "" {
+		if n == 0 {
 			continue
 		}
 		
-		// Handle commands
-		if strings.HasPrefix(input, ":") {
-			r.handleCommand(input)
-			continue
+		// Handle special keys
+		if buf[0] == 27 && n > 1 { // ESC sequence
+			if n == 3 && buf[1] == '[' {
+				switch buf[2] {
+				case 'A': // Up arrow
+					if r.historyIndex > 0 {
+						// Clear current line
+						r.clearLine(len(line), cursorPos)
+						r.historyIndex--
+						line = []rune(r.history[r.historyIndex])
+						cursorPos = len(line)
+						fmt.Print(string(line))
+					}
+				case 'B': // Down arrow
+					if r.historyIndex < len(r.history)-1 {
+						// Clear current line
+						r.clearLine(len(line), cursorPos)
+						r.historyIndex++
+						line = []rune(r.history[r.historyIndex])
+						cursorPos = len(line)
+						fmt.Print(string(line))
+					} else if r.historyIndex == len(r.history)-1 {
+						// Clear to empty line
+						r.clearLine(len(line), cursorPos)
+						r.historyIndex = len(r.history)
+						line = []rune{}
+						cursorPos = 0
+					}
+				case 'C': // Right arrow
+					if cursorPos < len(line) {
+						fmt.Print("\033[1C")
+						cursorPos++
+					}
+				case 'D': // Left arrow
+					if cursorPos > 0 {
+						fmt.Print("\033[1D")
+						cursorPos--
+					}
+				}
+			}
+		} else if buf[0] == 13 || buf[0] == 10 { // Enter
+			fmt.Println()
+			result := string(line)
+			return &result
+		} else if buf[0] == 3 { // Ctrl+C
+			fmt.Println("^C")
+			return nil
+		} else if buf[0] == 4 { // Ctrl+D
+			if len(line) == 0 {
+				return nil
+			}
+		} else if buf[0] == 127 || buf[0] == 8 { // Backspace
+			if cursorPos > 0 && len(line) > 0 {
+				// Remove character before cursor
+				line = append(line[:cursorPos-1], line[cursorPos:]...)
+				cursorPos--
+				// Redraw line from cursor position
+				fmt.Print("\033[1D\033[K") // Move back and clear to end
+				fmt.Print(string(line[cursorPos:]))
+				// Move cursor back to correct position
+				if len(line) > cursorPos {
+					fmt.Printf("\033[%dD", len(line)-cursorPos)
+				}
+			}
+		} else if buf[0] >= 32 && buf[0] < 127 { // Printable character
+			// Insert character at cursor position
+			ch := rune(buf[0])
+			if cursorPos == len(line) {
+				line = append(line, ch)
+			} else {
+				line = append(line[:cursorPos+1], line[cursorPos:]...)
+				line[cursorPos] = ch
+			}
+			// Print the character and everything after it
+			fmt.Print(string(line[cursorPos:]))
+			cursorPos++
+			// Move cursor back if needed
+			if len(line) > cursorPos {
+				fmt.Printf("\033[%dD", len(line)-cursorPos)
+			}
 		}
-		
-		// Handle MinZ code
-		r.handleMinZCode(input)
 	}
 }
 
-func (r *REPL) handleCommand(cmd string) {
-	parts := strings.Fields(cmd)
+// clearLine clears the current line in the terminal
+func (r *REPL) clearLine(lineLen, cursorPos int) {
+	// Move cursor to beginning of line
+	if cursorPos > 0 {
+		fmt.Printf("\033[%dD", cursorPos)
+	}
+	// Clear to end of line
+	fmt.Print("\033[K")
+}
+
+// printBanner prints the REPL welcome message
+func (r *REPL) printBanner() {
+	fmt.Println("╔══════════════════════════════════════════════════════════════╗")
+	fmt.Println("║              mzr - MinZ REPL v1.0                           ║")
+	fmt.Println("║         Interactive Z80 Development Environment              ║")
+	fmt.Println("║              With ZX Spectrum Screen Emulation              ║")
+	fmt.Println("╚══════════════════════════════════════════════════════════════╝")
+	fmt.Println()
+	fmt.Println("🚀 Quick Start:")
+	fmt.Println("  • Type expressions:     2 + 3 * 4")
+	fmt.Println("  • Define variables:     let x: u8 = 42")
+	fmt.Println("  • Create functions:     fun add(a: u8, b: u8) -> u8 { a + b }")
+	fmt.Println("  • Call functions:       add(5, 3)")
+	fmt.Println("  • Code for a backend:   /backend 6502, then /compile add(5, 3)")
+	fmt.Println("  • See help:            /h or /help")
+	fmt.Println()
+	fmt.Println("Type /h for full command list, /q to quit")
+	fmt.Println()
+}
+
+// isCommand checks if input is a REPL command
+func (r *REPL) isCommand(input string) bool {
+	return strings.HasPrefix(input, "/")
+}
+
+// executeCommand handles REPL commands
+func (r *REPL) executeCommand(input string) {
+	parts := strings.Fields(input)
 	if len(parts) == 0 {
 		return
 	}
 	
-	switch parts[0] {
-	case ":exit", ":quit", ":q":
-		fmt.Println("🎊 Thanks for using MinZ REPL! Lambda revolution continues!")
-		os.Exit(0)
-		
-	case ":help", ":h":
-		r.printBanner()
-		
-	case ":clear", ":cls":
-		fmt.Print("\033[2J\033[H") // ANSI clear screen
-		
-	case ":history":
-		history := r.reader.GetHistory()
-		fmt.Println("Command history:")
-		for i, h := range history {
-			fmt.Printf("  %d: %s\n", i+1, h)
-		}
-		fmt.Printf("\nTotal: %d commands (saved to ~/.minz_history)\n", len(history))
+	cmd := parts[0]
+	args := parts[1:]
 	
-	case ":search":
-		if len(parts) < 2 {
-			fmt.Println("Usage: :search <text>")
-			return
-		}
-		query := strings.Join(parts[1:], " ")
-		results := r.reader.SearchHistory(query)
-		if len(results) == 0 {
-			fmt.Printf("No history entries matching '%s'\n", query)
+	switch cmd {
+	case "/help", "/h", "/?":
+		r.showHelp()
+	case "/quit", "/q", "/exit":
+		r.quit()
+	case "/reset":
+		r.reset()
+	case "/asm":
+		if len(args) > 0 {
+			r.showAssembly(args[0])
 		} else {
-			fmt.Printf("History entries matching '%s':\n", query)
-			for i, result := range results {
-				fmt.Printf("  %d: %s\n", i+1, result)
-			}
+			fmt.Println("Usage: /asm <function>")
 		}
-		
-	case ":backends":
-		fmt.Println("Available backends:")
-		fmt.Println("  z80 (current) - Full support")
-		fmt.Println("  c - Stub only")
-		fmt.Println("  llvm - Stub only")
-		fmt.Println("  wasm - Stub only")
-		
-	case ":backend":
-		if len(parts) < 2 {
-			fmt.Printf("Current backend: %s\n", r.currentBackend)
-			fmt.Println("Usage: :backend <name>")
-			return
+	case "/reg", "/r":
+		if len(args) > 0 && args[0] == "compact" {
+			r.showRegistersCompact()
+		} else {
+			r.showRegisters()
 		}
-		backend := parts[1]
-		switch backend {
-		case "z80", "c", "llvm", "wasm":
-			r.currentBackend = backend
-			fmt.Printf("Switched to backend: %s\n", r.currentBackend)
-			if backend != "z80" {
-				fmt.Println("⚠️  Note: Only Z80 backend is fully implemented")
-			}
+	case "/regc", "/rc":
+		r.showRegistersCompact()
+	case "/screen", "/s":
+		r.showScreen()
+	case "/screens", "/ss":
+		r.toggleScreen()
+	case "/cls", "/clear":
+		r.clearScreen()
+	case "/vars", "/v":
+		r.showVariables()
+	case "/funcs", "/f":
+		r.showFunctions()
+	case "/mem", "/m":
+		switch len(args) {
+		case 1:
+			r.showMemory(args[0], "")
+		case 2:
+			r.showMemory(args[0], args[1])
 		default:
-			fmt.Printf("Unknown backend: %s\n", backend)
-			fmt.Println("Available: z80, c, llvm, wasm")
+			fmt.Println("Usage: /mem <address> [length]")
 		}
-		
-	case ":load":
-		if len(parts) < 2 {
-			fmt.Println("Usage: :load <filename>")
-			return
+	case "/watch", "/w":
+		r.addWatch(args)
+	case "/unwatch":
+		r.removeWatch(args)
+	case "/save":
+		if len(args) > 0 {
+			r.saveSession(args[0])
+		} else {
+			fmt.Println("Usage: /save <filename>")
 		}
-		r.loadModule(parts[1])
-		
-	case ":compile":
-		if len(parts) < 2 {
-			fmt.Println("Usage: :compile <expression>")
-			return
+	case "/load":
+		if len(args) > 0 {
+			r.loadFile(args[0])
+		} else {
+			fmt.Println("Usage: /load <filename>")
 		}
-		code := strings.Join(parts[1:], " ")
-		r.compileCode(code, false)
-		
-	case ":mir":
-		if len(parts) < 2 {
-			fmt.Println("Usage: :mir <expression>")
-			return
+	case "/history":
+		r.showHistory(args)
+	case "/search":
+		if len(args) > 0 {
+			r.searchHistory(strings.Join(args, " "))
+		} else {
+			fmt.Println("Usage: /search <text>")
 		}
-		code := strings.Join(parts[1:], " ")
-		r.showMIR(code)
-		
-	case ":run":
-		if len(parts) < 2 {
-			fmt.Println("Usage: :run <expression>")
-			return
+	
+	// Compilation for any backend
+	case "/backend", "/b":
+		r.setBackend(args)
+	case "/backends":
+		r.showBackends()
+	case "/compile", "/c":
+		if len(args) > 0 {
+			r.showCompiled(strings.TrimSpace(strings.TrimPrefix(input, cmd)))
+		} else {
+			fmt.Println("Usage: /compile <code>")
 		}
-		if r.currentBackend != "z80" {
-			fmt.Println("Execution only supported for Z80 backend")
-			return
+	case "/mir":
+		if len(args) > 0 {
+			r.showMIR(strings.TrimSpace(strings.TrimPrefix(input, cmd)))
+		} else {
+			fmt.Println("Usage: /mir <code>")
 		}
-		code := strings.Join(parts[1:], " ")
-		r.runCode(code)
-		
+	
+	// TAS debugging commands
+	case "/tas":
+		r.toggleTAS()
+	case "/record":
+		r.startTASRecording()
+	case "/stop":
+		r.stopTASRecording()
+	case "/rewind":
+		r.tasRewind(args)
+	case "/forward":
+		r.tasForward(args)
+	case "/savestate":
+		r.tasSaveState(args)
+	case "/loadstate":
+		r.tasLoadState(args)
+	case "/timeline":
+		r.showTASTimeline()
+	case "/export":
+		if len(args) > 0 {
+			r.exportTAS(args[0])
+		} else {
+			fmt.Println("Usage: /export <filename.tas>")
+		}
+	case "/import":
+		if len(args) > 0 {
+			r.importTAS(args[0])
+		} else {
+			fmt.Println("Usage: /import <filename.tas>")
+		}
+	case "/replay":
+		if len(args) > 0 {
+			r.replayTAS(args[0])
+		} else {
+			fmt.Println("Usage: /replay <filename.tas>")
+		}
+	case "/strategy":
+		if len(args) > 0 {
+			r.setTASStrategy(args[0])
+		} else {
+			fmt.Println("Usage: /strategy <auto|deterministic|snapshot|hybrid|paranoid>")
+		}
+	case "/stats":
+		r.showTASStats()
+	case "/profile":
+		r.profilePerformance()
+	case "/report":
+		r.showTASReport()
 	default:
-		fmt.Printf("Unknown command: %s\n", parts[0])
-		fmt.Println("Type :help for available commands")
+		fmt.Printf("Unknown command: %s\n", cmd)
+		fmt.Println("Type /help for available commands")
 	}
 }
 
-func (r *REPL) handleMinZCode(code string) {
-	r.compileCode(code, true)
-}
-
-func (r *REPL) loadModule(filename string) {
-	fmt.Printf("📂 Loading module: %s\n", filename)
+// evaluate compiles and executes MinZ code
+func (r *REPL) evaluate(input string) {
+	inputType := ClassifyInput(input)
+	var result *CompileResult
+	var err error
 	
-	// Check if file exists
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		fmt.Printf("❌ File not found: %s\n", filename)
+	switch inputType {
+	case "expression":
+		result, err = r.compiler.CompileExpression(input, r.context)
+	case "declaration", "assignment", "statement":
+		result, err = r.compiler.CompileStatement(input, r.context)
+	case "function":
+		result, err = r.compiler.CompileFunction(input, r.context)
+	default:
+		fmt.Printf("Unknown input type: %s\n", inputType)
 		return
 	}
 	
-	// Read file content
-	content, err := ioutil.ReadFile(filename)
 	if err != nil {
-		fmt.Printf("❌ Error reading file: %v\n", err)
+		fmt.Printf("Compilation error: %v\n", err)
 		return
 	}
 	
-	// Parse and compile the module
-	module, err := r.compileModule(string(content), filename)
-	if err != nil {
-		fmt.Printf("❌ Compilation error: %v\n", err)
+	if len(result.Errors) > 0 {
+		fmt.Printf("Errors: %s\n", strings.Join(result.Errors, "; "))
 		return
 	}
 	
-	// Store the module
-	moduleName := strings.TrimSuffix(filepath.Base(filename), ".minz")
-	r.modules[moduleName] = module
-	r.currentMod = module
+	// Record the state before the code is loaded, so a rewind undoes it all
+	r.recordTASFrame()
 	
-	fmt.Printf("✅ Module '%s' loaded successfully\n", moduleName)
-	fmt.Printf("   Functions: %d\n", len(module.Functions))
-	fmt.Printf("   Globals: %d\n", len(module.Globals))
-}
-
-func (r *REPL) compileModule(source, filename string) (*ir.Module, error) {
-	// Parse the source code
-	p := parser.New()
-	ast, err := p.ParseString(source, filename)
-	if err != nil {
-		return nil, fmt.Errorf("parse error: %w", err)
-	}
+	// Load machine code into emulator
+	r.emulator.LoadAt(result.Origin, result.MachineCode)
+	defer r.checkWatches()
 	
-	// Create a synthetic file structure for the analyzer
-	// Since we can't import ast.File, we'll work around it
-	// by creating a minimal wrapper
-	type fileWrapper struct {
-		Name         string
-		Declarations interface{}
+	// Call the wrapper with screen hooks; a definition only loads its code
+	if result.EntryPoint != 0 {
+		output, cycleCount, err := r.emulator.CallWithHooks(result.EntryPoint)
+		
+		// If there was output, print it
+		if len(output) > 0 {
+			fmt.Print(string(output))
+		}
+		if err != nil {
+			fmt.Printf("Execution error: %v\n", err)
+			return
+		}
+		
+		// Show execution stats in verbose mode
+		if r.isVerbose() {
+			fmt.Printf("[%d T-states]\n", cycleCount)
+		}
+		
+		// Show screen if enabled
+		if r.autoShowScreen {
+			fmt.Println("\n--- ZX Spectrum Screen ---")
+			r.emulator.PrintCompactScreen()
+			fmt.Println("-------------------------")
+		}
+		
+		// For expressions, show the result (in HL register)
+		if inputType == "expression" {
+			result := uint16(r.emulator.H)<<8 | uint16(r.emulator.L)
+			fmt.Printf("%d\n", result)
+		}
 	}
 	
-	file := &fileWrapper{
-		Name:         filename,
-		Declarations: ast,
+	// Update context with new functions/variables. Every compile places all
+	// functions again, so known ones move to where their code now is.
+	for name, addr := range result.Functions {
+		if strings.HasPrefix(name, "__repl") {
+			continue
+		}
+		f, exists := r.context.functions[name]
+		if !exists {
+			f = Function{Name: name, Source: input}
+			if inputType == "function" {
+				fmt.Printf("Function '%s' defined at 0x%04X\n", name, addr)
+			}
+		}
+		f.Address, f.Size = addr, result.Sizes[name]
+		r.context.functions[name] = f
 	}
 	
-	// Semantic analysis
-	// For now, we'll skip semantic analysis and just return an empty module
-	// This is a temporary workaround until we fix the AST import issue
-	module := &ir.Module{
-		Name:      filename,
-		Functions: []*ir.Function{},
-		Globals:   []ir.Global{},
+	// For declarations, update variables
+	if inputType == "declaration" {
+		// Extract variable name from input (simple parsing)
+		parts := strings.Fields(input)
+		if len(parts) >= 2 && (parts[0] == "let" || parts[0] == "var") {
+			varName := strings.TrimSuffix(parts[1], ":")
+			if idx := strings.Index(varName, ":"); idx > 0 {
+				varName = varName[:idx]
+			}
+			fmt.Printf("Variable '%s' defined\n", varName)
+		}
 	}
-	
-	// TODO: Fix semantic analysis once AST import is resolved
-	_ = file // Silence unused variable warning
-	
-	return module, nil
 }
 
-func (r *REPL) compileCode(code string, showResult bool) {
-	// Wrap single expressions in a main function for compilation
-	wrappedCode := fmt.Sprintf(`
-fun main() -> u8 {
-    %s;
-    return 42;
-}`, code)
-	
-	// Compile to module
-	module, err := r.compileModule(wrappedCode, "<repl>")
-	if err != nil {
-		fmt.Printf("❌ %v\n", err)
-		return
+// Helper functions
+
+func (r *REPL) showHelp() {
+	fmt.Println("╔══════════════════════════════════════════════════════════════╗")
+	fmt.Println("║           mzr - MinZ Interactive REPL v1.0                   ║")
+	fmt.Println("║     Real-time Z80 Development with Time-Travel Debugging     ║")
+	fmt.Println("╠══════════════════════════════════════════════════════════════╣")
+	fmt.Println("║ 🎯 BASIC COMMANDS                                            ║")
+	fmt.Println("╟──────────────────────────────────────────────────────────────╢")
+	fmt.Println("║ /help    /h  /?   - Show this help                          ║")
+	fmt.Println("║ /quit    /q       - Exit REPL                               ║")
+	fmt.Println("║ /reset            - Reset emulator and context              ║")
+	fmt.Println("╟──────────────────────────────────────────────────────────────╢")
+	fmt.Println("║ 📊 DEBUGGING & INSPECTION                                    ║")
+	fmt.Println("╟──────────────────────────────────────────────────────────────╢")
+	fmt.Println("║ /reg     /r       - Show Z80 registers (with shadows)       ║")
+	fmt.Println("║ /regc    /rc      - Compact register view                   ║")
+	fmt.Println("║ /mem     /m <a> [n] - Dump n bytes at a (hex and ASCII)     ║")
+	fmt.Println("║ /watch   /w [a] [word] - Report when memory at a changes    ║")
+	fmt.Println("║ /unwatch <a|all>  - Stop watching memory                    ║")
+	fmt.Println("║ /asm <func>       - Show assembly for function              ║")
+	fmt.Println("║ /vars    /v       - Show defined variables                  ║")
+	fmt.Println("║ /funcs   /f       - Show defined functions                  ║")
+	fmt.Println("╟──────────────────────────────────────────────────────────────╢")
+	fmt.Println("║ 🖥️  ZX SPECTRUM SCREEN EMULATION                             ║")
+	fmt.Println("╟──────────────────────────────────────────────────────────────╢")
+	fmt.Println("║ /screen  /s       - Show current screen (32x24 chars)       ║")
+	fmt.Println("║ /screens /ss      - Toggle auto-show after execution        ║")
+	fmt.Println("║ /cls              - Clear screen memory                     ║")
+	fmt.Println("╟──────────────────────────────────────────────────────────────╢")
+	fmt.Println("║ 💾 SESSION MANAGEMENT                                        ║")
+	fmt.Println("╟──────────────────────────────────────────────────────────────╢")
+	fmt.Println("║ /save <file>      - Save functions, variables and memory    ║")
+	fmt.Println("║ /load <file>      - Restore a session saved with /save      ║")
+	fmt.Println("║ /history [n]      - Show the last n lines (default: 20)     ║")
+	fmt.Println("║ /search <text>    - Search history (kept in ~/.minz_history)║")
+	fmt.Println("╟──────────────────────────────────────────────────────────────╢")
+	fmt.Println("║ 🔧 BACKENDS                                                  ║")
+	fmt.Println("╟──────────────────────────────────────────────────────────────╢")
+	fmt.Println("║ /backend /b [name] - Show or set the /compile backend       ║")
+	fmt.Println("║ /backends         - List available backends                 ║")
+	fmt.Println("║ /compile /c <code> - Show generated code for the backend    ║")
+	fmt.Println("║ /mir <code>       - Show the optimized MIR                  ║")
+	fmt.Println("╟──────────────────────────────────────────────────────────────╢")
+	fmt.Println("║ 🎮 TAS TIME-TRAVEL DEBUGGING                                 ║")
+	fmt.Println("╟──────────────────────────────────────────────────────────────╢")
+	fmt.Println("║ /tas              - Enable/disable TAS mode                 ║")
+	fmt.Println("║ /record           - Record the state before each evaluation ║")
+	fmt.Println("║ /stop             - Stop recording                          ║")
+	fmt.Println("║ /rewind [n]       - Go back n evaluations (default: 1)      ║")
+	fmt.Println("║ /forward [n]      - Go forward n evaluations (default: 1)   ║")
+	fmt.Println("║ /savestate [name] - Save the state (default name: quick)    ║")
+	fmt.Println("║ /loadstate [name] - Restore a saved state                   ║")
+	fmt.Println("║ /timeline         - Show execution timeline                 ║")
+	fmt.Println("╟──────────────────────────────────────────────────────────────╢")
+	fmt.Println("║ 📼 TAS RECORDING & REPLAY                                    ║")
+	fmt.Println("╟──────────────────────────────────────────────────────────────╢")
+	fmt.Println("║ /export <file>    - Export to .tas file                     ║")
+	fmt.Println("║ /import <file>    - Import from .tas file                   ║")
+	fmt.Println("║ /replay <file>    - Import and go to its first frame        ║")
+	fmt.Println("║ /strategy <mode>  - Set strategy (auto/deterministic/...)   ║")
+	fmt.Println("║ /stats            - Show recording statistics               ║")
+	fmt.Println("║ /profile          - Performance analysis                    ║")
+	fmt.Println("║ /report           - Comprehensive TAS report                ║")
+	fmt.Println("╚══════════════════════════════════════════════════════════════╝")
+	fmt.Println()
+	fmt.Println("📝 MINZ CODE EXAMPLES:")
+	fmt.Println("  Simple expression:      2 + 3 * 4")
+	fmt.Println("  Variable declaration:   let x: u8 = 42")
+	fmt.Println("  Function definition:    fun add(a: u8, b: u8) -> u8 { a + b }")
+	fmt.Println("  Function call:          add(5, 3)")
+	fmt.Println("  Print to screen:        @print(\"Hello!\")")
+	fmt.Println("  Inline assembly:        asm { LD A, 'X'; RST 16 }")
+	fmt.Println()
+	fmt.Println("💡 TIPS:")
+	fmt.Println("  • Expressions are evaluated and printed automatically")
+	fmt.Println("  • Functions persist across commands")
+	fmt.Println("  • Use /tas for frame-perfect debugging")
+	fmt.Println("  • Memory starts at $8000, data at $C000")
+	fmt.Println("  • Character output uses RST 16 (ZX Spectrum)")
+}
+
+func (r *REPL) quit() {
+	r.restoreTerminal()
+	fmt.Println("Goodbye! Happy coding!")
+	os.Exit(0)
+}
+
+func (r *REPL) reset() {
+	r.emulator.Reset()
+	r.context = &Context{
+		variables: make(map[string]Variable),
+		functions: make(map[string]Function),
+		codeBase:  0x8000,
+		dataBase:  0xC000,
 	}
+	r.compiler.Reset()
+	fmt.Println("Emulator, compiler and context reset")
+}
+
+func (r *REPL) showRegisters() {
+	// Get all register values
+	fmt.Println("╔══════════════════════════════════════════════════════════════╗")
+	fmt.Println("║                    Z80 Register State                        ║")
+	fmt.Println("╠══════════════════════════════════════════════════════════════╣")
 	
-	// Optimize
-	opt := optimizer.NewOptimizer(optimizer.OptimizationLevel(1))
-	opt.Optimize(module)
+	// Main registers as pairs - all names exactly 3 chars
+	fmt.Printf("║ AF =%04X  BC =%04X  DE =%04X  HL =%04X                    ║\n",
+		uint16(r.emulator.A)<<8|uint16(r.emulator.F),
+		uint16(r.emulator.B)<<8|uint16(r.emulator.C),
+		uint16(r.emulator.D)<<8|uint16(r.emulator.E),
+		uint16(r.emulator.H)<<8|uint16(r.emulator.L))
 	
-	// Generate code for the selected backend
-	var output string
+	// Shadow registers as pairs - all names exactly 3 chars
+	fmt.Printf("║ AF'=%04X  BC'=%04X  DE'=%04X  HL'=%04X                    ║\n",
+		uint16(r.emulator.A_)<<8|uint16(r.emulator.F_),
+		uint16(r.emulator.B_)<<8|uint16(r.emulator.C_),
+		uint16(r.emulator.D_)<<8|uint16(r.emulator.E_),
+		uint16(r.emulator.H_)<<8|uint16(r.emulator.L_))
 	
-	switch r.currentBackend {
-	case "z80":
-		z80Backend := codegen.NewZ80Backend(r.backendOpts)
-		output, err = z80Backend.Generate(module)
-	case "c":
-		// Stub for C backend
-		output = "// C backend stub - not implemented\n"
-		fmt.Println("⚠️  C backend is a stub - showing placeholder output")
-	case "llvm":
-		// Stub for LLVM backend
-		output = "; LLVM backend stub - not implemented\n"
-		fmt.Println("⚠️  LLVM backend is a stub - showing placeholder output")
-	case "wasm":
-		// Stub for WASM backend
-		output = ";; WASM backend stub - not implemented\n"
-		fmt.Println("⚠️  WASM backend is a stub - showing placeholder output")
-	default:
-		fmt.Printf("❌ Unknown backend: %s\n", r.currentBackend)
-		return
+	// Index and special registers - all names exactly 3 chars
+	fmt.Printf("║ IX =%04X  IY =%04X  SP =%04X  PC =%04X                    ║\n",
+		r.emulator.IX, r.emulator.IY, r.emulator.SP, r.emulator.PC)
+	
+	// I and R registers
+	fmt.Printf("║ I  =%02X    R  =%02X    IFF1=%v  IFF2=%v  IM=%d            ║\n",
+		r.emulator.I, r.emulator.R, 
+		r.emulator.GetIFF1(), r.emulator.GetIFF2(), r.emulator.GetIM())
+	
+	// Flags breakdown
+	fmt.Println("╠══════════════════════════════════════════════════════════════╣")
+	fmt.Printf("║ Flags: S=%d Z=%d H=%d P/V=%d N=%d C=%d                      ║\n",
+		boolToInt(r.emulator.F&0x80 != 0), // Sign
+		boolToInt(r.emulator.F&0x40 != 0), // Zero
+		boolToInt(r.emulator.F&0x10 != 0), // Half-carry
+		boolToInt(r.emulator.F&0x04 != 0), // Parity/Overflow
+		boolToInt(r.emulator.F&0x02 != 0), // Add/Subtract
+		boolToInt(r.emulator.F&0x01 != 0)) // Carry
+	
+	fmt.Println("╚══════════════════════════════════════════════════════════════╝")
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
 	}
-	if err != nil {
-		fmt.Printf("❌ Code generation error: %v\n", err)
+	return 0
+}
+
+func (r *REPL) showRegistersCompact() {
+	// Compact one-line view
+	fmt.Printf("AF=%04X BC=%04X DE=%04X HL=%04X IX=%04X IY=%04X SP=%04X PC=%04X ",
+		uint16(r.emulator.A)<<8|uint16(r.emulator.F),
+		uint16(r.emulator.B)<<8|uint16(r.emulator.C),
+		uint16(r.emulator.D)<<8|uint16(r.emulator.E),
+		uint16(r.emulator.H)<<8|uint16(r.emulator.L),
+		r.emulator.IX, r.emulator.IY, r.emulator.SP, r.emulator.PC)
+	
+	// Show shadow registers if any are non-zero
+	if r.emulator.A_ != 0 || r.emulator.B_ != 0 || r.emulator.C_ != 0 ||
+		r.emulator.D_ != 0 || r.emulator.E_ != 0 || r.emulator.H_ != 0 || r.emulator.L_ != 0 {
+		fmt.Printf("(AF'=%04X BC'=%04X DE'=%04X HL'=%04X)",
+			uint16(r.emulator.A_)<<8|uint16(r.emulator.F_),
+			uint16(r.emulator.B_)<<8|uint16(r.emulator.C_),
+			uint16(r.emulator.D_)<<8|uint16(r.emulator.E_),
+			uint16(r.emulator.H_)<<8|uint16(r.emulator.L_))
+	}
+	fmt.Println()
+}
+
+func (r *REPL) showVariables() {
+	if len(r.context.variables) == 0 {
+		fmt.Println("No variables defined")
 		return
 	}
 	
-	if showResult {
-		fmt.Printf("✅ Compiled to %s:\n", r.currentBackend)
-		// Show first 20 lines of output
-		lines := strings.Split(output, "\n")
-		maxLines := 20
-		if len(lines) > maxLines {
-			for _, line := range lines[:maxLines] {
-				fmt.Println(line)
-			}
-			fmt.Printf("... (%d more lines)\n", len(lines)-maxLines)
-		} else {
-			fmt.Print(output)
-		}
+	fmt.Println("Variables:")
+	for name, v := range r.context.variables {
+		fmt.Printf("  %s: %s = %v (at 0x%04X)\n", 
+			name, v.Type, v.Value, v.Addr)
 	}
 }
 
-func (r *REPL) showMIR(code string) {
-	fmt.Printf("🔍 Generating MIR for: %s\n", code)
-	
-	// Wrap in main function
-	wrappedCode := fmt.Sprintf(`
-fun main() -> u8 {
-    %s;
-    return 42;
-}`, code)
-	
-	// Compile to get MIR
-	module, err := r.compileModule(wrappedCode, "<repl>")
-	if err != nil {
-		fmt.Printf("❌ %v\n", err)
+func (r *REPL) showFunctions() {
+	if len(r.context.functions) == 0 {
+		fmt.Println("No functions defined")
 		return
 	}
 	
-	fmt.Println("📋 MIR Instructions:")
-	for _, function := range module.Functions {
-		if function.Name == "main" {
-			fmt.Printf("Function: %s\n", function.Name)
-			for i, instr := range function.Instructions {
-				fmt.Printf("  %3d: %s\n", i, formatMIRInstruction(instr))
-			}
-			break
-		}
+	fmt.Println("Functions:")
+	for name, f := range r.context.functions {
+		params := strings.Join(f.Params, ", ")
+		fmt.Printf("  %s(%s) at 0x%04X (%d bytes)\n",
+			name, params, f.Address, f.Size)
 	}
 }
 
-func formatMIRInstruction(instr ir.Instruction) string {
-	parts := []string{instr.Op.String()}
-	
-	if instr.Dest != 0 {
-		parts = append(parts, fmt.Sprintf("r%d", instr.Dest))
+func (r *REPL) isVerbose() bool {
+	// Could be configurable
+	return false
+}
+
+// Screen-related functions
+func (r *REPL) showScreen() {
+	fmt.Println("\n--- ZX Spectrum Screen (32x24) ---")
+	fmt.Print(r.emulator.Screen.GetScreen())
+	// Cursor position would be shown if we had access
+	// fmt.Printf("Cursor at (%d, %d)\n", r.emulator.Screen.CursorX, r.emulator.Screen.CursorY)
+}
+
+func (r *REPL) toggleScreen() {
+	r.autoShowScreen = !r.autoShowScreen
+	if r.autoShowScreen {
+		fmt.Println("Auto-show screen: ON")
+	} else {
+		fmt.Println("Auto-show screen: OFF")
 	}
-	if instr.Src1 != 0 {
-		parts = append(parts, fmt.Sprintf("r%d", instr.Src1))
+}
+
+func (r *REPL) clearScreen() {
+	r.emulator.Screen.Clear()
+	fmt.Println("ZX Spectrum screen cleared")
+}
+
+// showAssembly disassembles the machine code of a function defined in the
+// session, as it is loaded in the emulator
+func (r *REPL) showAssembly(function string) {
+	f, ok := r.context.functions[function]
+	if !ok {
+		fmt.Printf("Unknown function: %s (see /funcs)\n", function)
+		return
+	}
+	if f.Size == 0 {
+		fmt.Printf("No code for %s\n", function)
+		return
 	}
-	if instr.Src2 != 0 {
-		parts = append(parts, fmt.Sprintf("r%d", instr.Src2))
+	
+	fmt.Printf("%s at $%04X (%d bytes):\n", f.Name, f.Address, f.Size)
+	end := uint32(f.Address) + uint32(f.Size)
+	for addr := uint32(f.Address); addr < end; {
+		mnemonic, length := r.emulator.Disassemble(uint16(addr))
+		var bytes strings.Builder
+		for i := uint16(0); i < length; i++ {
+			fmt.Fprintf(&bytes, "%02X ", r.emulator.GetMemory(uint16(addr)+i))
+		}
+		fmt.Printf("  $%04X  %-12s %s\n", addr, bytes.String(), mnemonic)
+		addr += uint32(length)
 	}
-	if instr.Imm != 0 {
-		parts = append(parts, fmt.Sprintf("#%d", instr.Imm))
+}
+
+// showMemory dumps length bytes (memDefaultLength if "") from addr, 16 to
+// a line. addr can name a function of the session (see resolveAddress).
+func (r *REPL) showMemory(addr, length string) {
+	start, err := r.resolveAddress(addr)
+	if err != nil {
+		fmt.Println(err)
+		return
 	}
-	if instr.Symbol != "" {
-		parts = append(parts, instr.Symbol)
+	n := uint16(memDefaultLength)
+	if length != "" {
+		n, err = parseAddress(length)
+		if err != nil || n == 0 {
+			fmt.Printf("Invalid length: %s\n", length)
+			return
+		}
 	}
 	
-	result := strings.Join(parts, " ")
-	if instr.Comment != "" {
-		result += fmt.Sprintf(" ; %s", instr.Comment)
+	for row := uint32(0); row < uint32(n); row += 16 {
+		var hex, text strings.Builder
+		for i := row; i < row+16 && i < uint32(n); i++ {
+			b := r.emulator.GetMemory(uint16(uint32(start) + i))
+			fmt.Fprintf(&hex, "%02X ", b)
+			if b >= 32 && b < 127 {
+				text.WriteByte(b)
+			} else {
+				text.WriteByte('.')
+			}
+		}
+		fmt.Printf("$%04X  %-48s %s\n", uint16(uint32(start)+row), hex.String(), text.String())
 	}
-	
-	return result
 }
 
-func (r *REPL) runCode(code string) {
-	fmt.Printf("⚡ Compiling and executing: %s\n", code)
-	
-	// TODO: Implement Z80 execution with emulator
-	// For now, just show that we would execute
-	fmt.Println("🔧 Execution support coming soon!")
-	fmt.Println("   Will compile to Z80, assemble, and run in emulator")
+// parseAddress parses a number written as $8000, 0x8000, 8000h or 32768
+func parseAddress(s string) (uint16, error) {
+	base := 10
+	switch lower := strings.ToLower(s); {
+	case strings.HasPrefix(lower, "$"):
+		s, base = s[1:], 16
+	case strings.HasPrefix(lower, "0x"):
+		s, base = s[2:], 16
+	case strings.HasSuffix(lower, "h"):
+		s, base = s[:len(s)-1], 16
+	}
+	n, err := strconv.ParseUint(s, base, 16)
+	return uint16(n), err
 }
 
 func main() {
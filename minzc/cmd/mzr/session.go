@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// sessionFormat identifies a file written by /save
+const sessionFormat = "minz-repl-session"
+
+// Session is everything /save writes and /load restores: the functions and
+// variables defined so far and the RAM they live in
+type Session struct {
+	Format    string        `json:"format"`
+	Version   int           `json:"version"`
+	CodeBase  uint16        `json:"code_base"`
+	DataBase  uint16        `json:"data_base"`
+	NextCode  uint16        `json:"next_code"`
+	NextData  uint16        `json:"next_data"`
+	Functions []Function    `json:"functions"`
+	Variables []Variable    `json:"variables"`
+	Memory    []MemoryBlock `json:"memory"` // Non-zero runs of RAM ($4000-$FFFF)
+}
+
+// MemoryBlock is a run of memory starting at Addr
+type MemoryBlock struct {
+	Addr uint16 `json:"addr"`
+	Data []byte `json:"data"` // Base64 in the JSON file
+}
+
+// ramStart is where the RAM saved with a session begins
+const ramStart = 0x4000
+
+// memoryGap is the longest run of zeros kept inside a block rather than
+// splitting it in two
+const memoryGap = 16
+
+func (r *REPL) saveSession(filename string) {
+	session := Session{
+		Format:   sessionFormat,
+		Version:  1,
+		CodeBase: r.context.codeBase,
+		DataBase: r.context.dataBase,
+		NextCode: r.compiler.nextCode,
+		NextData: r.compiler.nextData,
+	}
+	for _, f := range r.context.functions {
+		session.Functions = append(session.Functions, f)
+	}
+	sort.Slice(session.Functions, func(i, j int) bool {
+		return session.Functions[i].Address < session.Functions[j].Address
+	})
+	for _, v := range r.context.variables {
+		session.Variables = append(session.Variables, v)
+	}
+	sort.Slice(session.Variables, func(i, j int) bool {
+		return session.Variables[i].Name < session.Variables[j].Name
+	})
+	session.Memory = memoryBlocks(r.emulator.Snapshot().RAM[:], ramStart)
+
+	data, err := json.MarshalIndent(&session, "", "  ")
+	if err != nil {
+		fmt.Printf("Error saving session: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		fmt.Printf("Error saving session: %v\n", err)
+		return
+	}
+
+	size := 0
+	for _, b := range session.Memory {
+		size += len(b.Data)
+	}
+	fmt.Printf("Session saved to %s (%d functions, %d variables, %d bytes of memory)\n",
+		filename, len(session.Functions), len(session.Variables), size)
+}
+
+func (r *REPL) loadFile(filename string) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Printf("Error loading session: %v\n", err)
+		return
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil || session.Format != sessionFormat {
+		fmt.Printf("Error loading session: %s is not a saved REPL session\n", filename)
+		return
+	}
+	if session.Version != 1 {
+		fmt.Printf("Error loading session: unsupported session version %d\n", session.Version)
+		return
+	}
+
+	// Rebuild the RAM image first, so a bad block leaves the session untouched
+	ram := make([]byte, 0x10000-ramStart)
+	for _, b := range session.Memory {
+		if int(b.Addr) < ramStart || int(b.Addr)+len(b.Data) > 0x10000 {
+			fmt.Printf("Error loading session: memory block at $%04X is outside RAM\n", b.Addr)
+			return
+		}
+		copy(ram[int(b.Addr)-ramStart:], b.Data)
+	}
+
+	r.emulator.Reset()
+	r.emulator.LoadAt(ramStart, ram)
+	r.context = &Context{
+		variables: make(map[string]Variable),
+		functions: make(map[string]Function),
+		codeBase:  session.CodeBase,
+		dataBase:  session.DataBase,
+	}
+	for _, f := range session.Functions {
+		r.context.functions[f.Name] = f
+	}
+	for _, v := range session.Variables {
+		// JSON numbers come back as float64; the compiler prints values with %d
+		if n, ok := v.Value.(float64); ok && n == float64(int64(n)) {
+			v.Value = int64(n)
+		}
+		r.context.variables[v.Name] = v
+	}
+	r.compiler.Reset()
+	r.compiler.nextCode = session.NextCode
+	r.compiler.nextData = session.NextData
+
+	fmt.Printf("Session restored from %s (%d functions, %d variables)\n",
+		filename, len(session.Functions), len(session.Variables))
+}
+
+// memoryBlocks splits mem, which starts at base, into its non-zero runs
+func memoryBlocks(mem []byte, base uint16) []MemoryBlock {
+	var blocks []MemoryBlock
+	for i := 0; i < len(mem); {
+		if mem[i] == 0 {
+			i++
+			continue
+		}
+		start, end := i, i+1
+		for j := end; j < len(mem) && j-end <= memoryGap; j++ {
+			if mem[j] != 0 {
+				end = j + 1
+			}
+		}
+		blocks = append(blocks, MemoryBlock{
+			Addr: base + uint16(start),
+			Data: append([]byte(nil), mem[start:end]...),
+		})
+		i = end
+	}
+	return blocks
+}
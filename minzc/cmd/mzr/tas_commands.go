@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/minz/minzc/pkg/tas"
+)
+
+// The TAS debugger records the machine state before every evaluation, so
+// each frame of the timeline is one line typed at the prompt: /rewind 1
+// undoes the last evaluation and /forward 1 redoes it. Evaluating after a
+// rewind starts a new timeline from there.
+
+// toggleTAS enables/disables TAS debugging
+func (r *REPL) toggleTAS() {
+	if r.tasEnabled {
+		r.tasEnabled = false
+		if r.tasDebugger != nil {
+			r.tasDebugger.StopRecording()
+		}
+		fmt.Println("TAS debugging disabled")
+	} else {
+		r.tasEnabled = true
+		if r.tasDebugger == nil {
+			// Create TAS debugger wrapping emulator
+			r.tasDebugger = tas.NewTASDebugger(tasMachine{r.emulator})
+			r.tasUI = tas.NewTASUI(r.tasDebugger)
+		}
+		fmt.Println("TAS debugging enabled - time travel activated!")
+		fmt.Println("Commands: /record, /stop, /rewind, /forward, /savestate, /loadstate")
+	}
+}
+
+// tasActive reports whether TAS debugging is on, telling the user if not
+func (r *REPL) tasActive() bool {
+	if !r.tasEnabled || r.tasDebugger == nil {
+		fmt.Println("TAS debugging not active. Use /tas to enable")
+		return false
+	}
+	return true
+}
+
+// recordTASFrame records the machine state before an evaluation
+func (r *REPL) recordTASFrame() {
+	if r.tasEnabled && r.tasDebugger != nil {
+		r.tasDebugger.RecordFrame()
+	}
+}
+
+// startTASRecording begins recording execution
+func (r *REPL) startTASRecording() {
+	if !r.tasActive() {
+		return
+	}
+
+	r.tasDebugger.StartRecording()
+	fmt.Println("🔴 TAS recording started - the machine state is recorded before every evaluation")
+}
+
+// stopTASRecording stops recording
+func (r *REPL) stopTASRecording() {
+	if !r.tasActive() {
+		return
+	}
+
+	r.tasDebugger.StopRecording()
+	fmt.Printf("⏹ TAS recording stopped - %d frames recorded\n", r.tasDebugger.FrameCount())
+}
+
+// tasFrames parses the optional frame count of /rewind and /forward
+func tasFrames(args []string) (int, bool) {
+	if len(args) == 0 {
+		return 1, true
+	}
+	frames, err := strconv.Atoi(args[0])
+	if err != nil || frames < 1 {
+		fmt.Printf("Invalid frame count: %s\n", args[0])
+		return 0, false
+	}
+	return frames, true
+}
+
+// tasRewind rewinds execution by N frames
+func (r *REPL) tasRewind(args []string) {
+	if !r.tasActive() {
+		return
+	}
+	frames, ok := tasFrames(args)
+	if !ok {
+		return
+	}
+
+	if err := r.tasDebugger.Rewind(frames); err != nil {
+		fmt.Printf("Rewind failed: %v\n", err)
+		return
+	}
+
+	fmt.Printf("⏪ Rewound to frame %d\n", r.tasDebugger.CurrentFrame())
+	r.showRegistersCompact()
+}
+
+// tasForward goes forward again by N frames after a rewind
+func (r *REPL) tasForward(args []string) {
+	if !r.tasActive() {
+		return
+	}
+	frames, ok := tasFrames(args)
+	if !ok {
+		return
+	}
+
+	if err := r.tasDebugger.Forward(frames); err != nil {
+		fmt.Printf("Forward failed: %v\n", err)
+		return
+	}
+
+	fmt.Printf("⏩ Advanced to frame %d\n", r.tasDebugger.CurrentFrame())
+	r.showRegistersCompact()
+}
+
+// tasSaveState creates a named save state
+func (r *REPL) tasSaveState(args []string) {
+	if !r.tasActive() {
+		return
+	}
+	name := "quick"
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	r.tasDebugger.SaveState(name)
+}
+
+// tasLoadState restores a named save state
+func (r *REPL) tasLoadState(args []string) {
+	if !r.tasActive() {
+		return
+	}
+	name := "quick"
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	if err := r.tasDebugger.LoadState(name); err != nil {
+		fmt.Printf("Failed to load state: %v\n", err)
+		return
+	}
+	r.showRegistersCompact()
+}
+
+// showTASTimeline displays visual timeline
+func (r *REPL) showTASTimeline() {
+	if !r.tasActive() {
+		return
+	}
+
+	fmt.Println(r.tasDebugger.GetTimeline())
+}
+
+// exportTAS exports current recording to file
+func (r *REPL) exportTAS(filename string) {
+	if !r.tasActive() {
+		return
+	}
+
+	// The format follows the extension: .json, .tasb uncompressed binary,
+	// anything else (.tas) compressed binary
+	if err := r.tasDebugger.ExportReplay(filename); err != nil {
+		fmt.Printf("Failed to export TAS: %v\n", err)
+		return
+	}
+
+	fmt.Printf("📼 TAS recording exported to %s (%d frames)\n", filename, r.tasDebugger.FrameCount())
+}
+
+// importTAS imports recording from file
+func (r *REPL) importTAS(filename string) bool {
+	// Enable TAS if not already
+	if !r.tasEnabled {
+		r.toggleTAS()
+	}
+
+	if err := r.tasDebugger.ImportReplay(filename); err != nil {
+		fmt.Printf("Failed to import TAS: %v\n", err)
+		return false
+	}
+
+	fmt.Printf("📼 TAS recording imported from %s (%d frames)\n", filename, r.tasDebugger.FrameCount())
+	return true
+}
+
+// replayTAS imports a recording and goes to its first frame, from which
+// /forward steps through it
+func (r *REPL) replayTAS(filename string) {
+	if !r.importTAS(filename) {
+		return
+	}
+	frames := r.tasDebugger.FrameCount()
+	if frames == 0 {
+		fmt.Println("The recording has no frames")
+		return
+	}
+
+	if err := r.tasDebugger.Rewind(frames); err != nil {
+		fmt.Printf("Replay failed: %v\n", err)
+		return
+	}
+
+	fmt.Printf("🎬 At frame 0 of %d - use /forward to step through\n", frames)
+	r.showRegistersCompact()
+}
+
+// setTASStrategy changes the TAS recording strategy
+func (r *REPL) setTASStrategy(strategyName string) {
+	if !r.tasActive() {
+		return
+	}
+
+	var strategy tas.RecordingStrategy
+	switch strings.ToLower(strategyName) {
+	case "auto", "automatic":
+		strategy = tas.StrategyAutomatic
+	case "det", "deterministic":
+		strategy = tas.StrategyDeterministic
+	case "snap", "snapshot":
+		strategy = tas.StrategySnapshot
+	case "hybrid":
+		strategy = tas.StrategyHybrid
+	case "paranoid":
+		strategy = tas.StrategyParanoid
+	default:
+		fmt.Printf("Unknown strategy: %s\n", strategyName)
+		fmt.Println("Available: automatic, deterministic, snapshot, hybrid, paranoid")
+		return
+	}
+
+	r.tasDebugger.SetRecordingStrategy(strategy)
+}
+
+// showTASStats displays TAS recording statistics
+func (r *REPL) showTASStats() {
+	if !r.tasActive() {
+		return
+	}
+
+	fmt.Println(r.tasDebugger.GetRecordingStats())
+}
+
+// profilePerformance runs performance analysis on recording
+func (r *REPL) profilePerformance() {
+	if !r.tasActive() {
+		return
+	}
+
+	fmt.Println("🔍 Analyzing performance...")
+
+	profiler := tas.NewPerformanceProfiler(r.tasDebugger)
+	report := profiler.Analyze()
+	report.PrintReport()
+}
+
+// showTASReport displays comprehensive TAS report
+func (r *REPL) showTASReport() {
+	if !r.tasActive() {
+		return
+	}
+
+	r.tasDebugger.PrintDetailedReport()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"github.com/minz/minzc/pkg/emulator"
+	"github.com/minz/minzc/pkg/tas"
+)
+
+// tasMachine lets the TAS debugger record and restore the REPL's emulator.
+// Between evaluations the emulator's register fields hold the machine
+// state; ExecuteWithHooks loads them into the CPU, so restored registers
+// take effect on the next evaluation.
+type tasMachine struct {
+	z *emulator.REPLCompatibleZ80
+}
+
+func (m tasMachine) GetPC() uint16         { return m.z.PC }
+func (m tasMachine) SetPC(v uint16)        { m.z.PC = v }
+func (m tasMachine) GetSP() uint16         { return m.z.SP }
+func (m tasMachine) SetSP(v uint16)        { m.z.SP = v }
+func (m tasMachine) GetA() byte            { return m.z.A }
+func (m tasMachine) SetA(v byte)           { m.z.A = v }
+func (m tasMachine) GetB() byte            { return m.z.B }
+func (m tasMachine) SetB(v byte)           { m.z.B = v }
+func (m tasMachine) GetC() byte            { return m.z.C }
+func (m tasMachine) SetC(v byte)           { m.z.C = v }
+func (m tasMachine) GetD() byte            { return m.z.D }
+func (m tasMachine) SetD(v byte)           { m.z.D = v }
+func (m tasMachine) GetE() byte            { return m.z.E }
+func (m tasMachine) SetE(v byte)           { m.z.E = v }
+func (m tasMachine) GetF() byte            { return m.z.F }
+func (m tasMachine) SetF(v byte)           { m.z.F = v }
+func (m tasMachine) GetH() byte            { return m.z.H }
+func (m tasMachine) SetH(v byte)           { m.z.H = v }
+func (m tasMachine) GetL() byte            { return m.z.L }
+func (m tasMachine) SetL(v byte)           { m.z.L = v }
+func (m tasMachine) GetIX() uint16         { return m.z.IX }
+func (m tasMachine) SetIX(v uint16)        { m.z.IX = v }
+func (m tasMachine) GetIY() uint16         { return m.z.IY }
+func (m tasMachine) SetIY(v uint16)        { m.z.IY = v }
+func (m tasMachine) GetI() byte            { return m.z.I }
+func (m tasMachine) SetI(v byte)           { m.z.I = v }
+func (m tasMachine) GetR() byte            { return m.z.R }
+func (m tasMachine) SetR(v byte)           { m.z.R = v }
+func (m tasMachine) GetIFF1() bool         { return m.z.GetIFF1() }
+func (m tasMachine) SetIFF1(v bool)        { m.z.SetIFF1(v) }
+func (m tasMachine) GetIFF2() bool         { return m.z.GetIFF2() }
+func (m tasMachine) SetIFF2(v bool)        { m.z.SetIFF2(v) }
+func (m tasMachine) GetShadowA() byte      { return m.z.A_ }
+func (m tasMachine) SetShadowA(v byte)     { m.z.A_ = v }
+func (m tasMachine) GetShadowB() byte      { return m.z.B_ }
+func (m tasMachine) SetShadowB(v byte)     { m.z.B_ = v }
+func (m tasMachine) GetShadowC() byte      { return m.z.C_ }
+func (m tasMachine) SetShadowC(v byte)     { m.z.C_ = v }
+func (m tasMachine) GetShadowD() byte      { return m.z.D_ }
+func (m tasMachine) SetShadowD(v byte)     { m.z.D_ = v }
+func (m tasMachine) GetShadowE() byte      { return m.z.E_ }
+func (m tasMachine) SetShadowE(v byte)     { m.z.E_ = v }
+func (m tasMachine) GetShadowF() byte      { return m.z.F_ }
+func (m tasMachine) SetShadowF(v byte)     { m.z.F_ = v }
+func (m tasMachine) GetShadowH() byte      { return m.z.H_ }
+func (m tasMachine) SetShadowH(v byte)     { m.z.H_ = v }
+func (m tasMachine) GetShadowL() byte      { return m.z.L_ }
+func (m tasMachine) SetShadowL(v byte)     { m.z.L_ = v }
+func (m tasMachine) GetCycles() uint64     { return uint64(m.z.GetCycles()) }
+func (m tasMachine) GetTStates() uint64    { return uint64(m.z.GetCycles()) }
+func (m tasMachine) Peek(a uint16) byte    { return m.z.GetMemory(a) }
+func (m tasMachine) Poke(a uint16, v byte) { m.z.SetMemory(a, v) }
+func (m tasMachine) GetBorder() byte       { return m.z.Border() }
+
+// The cycle counter keeps running across a rewind
+func (m tasMachine) SetCycles(uint64)  {}
+func (m tasMachine) SetTStates(uint64) {}
+
+func (m tasMachine) GetMemory() []byte {
+	mem := make([]byte, 0x10000)
+	for addr := range mem {
+		mem[addr] = m.z.GetMemory(uint16(addr))
+	}
+	return mem
+}
+
+func (m tasMachine) SetMemory(mem []byte) {
+	for addr, v := range mem {
+		m.z.SetMemory(uint16(addr), v)
+	}
+}
+
+func (m tasMachine) GetLastOpcode() string {
+	text, _ := m.z.Disassemble(m.z.PC)
+	return text
+}
+
+func (m tasMachine) GetRegisters() *tas.CPURegisters {
+	z := m.z
+	return &tas.CPURegisters{
+		PC: z.PC, SP: z.SP,
+		A: z.A, B: z.B, C: z.C, D: z.D, E: z.E, F: z.F, H: z.H, L: z.L,
+		A_: z.A_, B_: z.B_, C_: z.C_, D_: z.D_, E_: z.E_, F_: z.F_, H_: z.H_, L_: z.L_,
+		IX: z.IX, IY: z.IY, I: z.I, R: z.R,
+		IFF1: z.GetIFF1(), IFF2: z.GetIFF2(),
+	}
+}
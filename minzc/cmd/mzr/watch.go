@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Watch is a byte or word of memory checked after every evaluation, so
+// that code patching itself (SMC) or a store through a pointer shows up
+// as it happens
+type Watch struct {
+	Addr  uint16
+	Word  bool   // Watch Addr and Addr+1, little-endian
+	Label string // What the address was given as
+	Value uint16 // Value at the last check
+}
+
+// memDefaultLength is how much /mem shows without a length
+const memDefaultLength = 64
+
+// read returns the watched value as it is in memory now
+func (w *Watch) read(r *REPL) uint16 {
+	value := uint16(r.emulator.GetMemory(w.Addr))
+	if w.Word {
+		value |= uint16(r.emulator.GetMemory(w.Addr+1)) << 8
+	}
+	return value
+}
+
+// format prints a watched value as hex and decimal
+func (w *Watch) format(value uint16) string {
+	if w.Word {
+		return fmt.Sprintf("$%04X (%d)", value, value)
+	}
+	return fmt.Sprintf("$%02X (%d)", value, value)
+}
+
+// resolveAddress parses an address given as a number (see parseAddress),
+// a function or variable of the session, or either plus an offset
+// ("add+3")
+func (r *REPL) resolveAddress(s string) (uint16, error) {
+	if addr, err := parseAddress(s); err == nil {
+		return addr, nil
+	}
+	name, offset := s, uint16(0)
+	if i := strings.IndexByte(s, '+'); i > 0 {
+		n, err := parseAddress(s[i+1:])
+		if err != nil {
+			return 0, fmt.Errorf("invalid offset in %s", s)
+		}
+		name, offset = s[:i], n
+	}
+	if f, ok := r.context.functions[name]; ok {
+		return f.Address + offset, nil
+	}
+	if v, ok := r.context.variables[name]; ok && v.Addr != 0 {
+		return v.Addr + offset, nil
+	}
+	return 0, fmt.Errorf("invalid address: %s", s)
+}
+
+// addWatch handles /watch: with no arguments it lists the watches,
+// otherwise it watches the byte (or with "word" the word) at an address
+func (r *REPL) addWatch(args []string) {
+	if len(args) == 0 {
+		r.showWatches()
+		return
+	}
+	addr, err := r.resolveAddress(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	w := Watch{Addr: addr, Label: args[0]}
+	if len(args) > 1 {
+		switch strings.ToLower(args[1]) {
+		case "byte", "b":
+		case "word", "w":
+			w.Word = true
+		default:
+			fmt.Println("Usage: /watch <address> [byte|word]")
+			return
+		}
+	}
+	w.Value = w.read(r)
+
+	for i, old := range r.watches {
+		if old.Addr == addr {
+			r.watches[i] = w
+			fmt.Printf("Watching $%04X = %s\n", addr, w.format(w.Value))
+			return
+		}
+	}
+	r.watches = append(r.watches, w)
+	fmt.Printf("Watching $%04X = %s\n", addr, w.format(w.Value))
+}
+
+// removeWatch handles /unwatch <address|all>
+func (r *REPL) removeWatch(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: /unwatch <address|all>")
+		return
+	}
+	if args[0] == "all" {
+		r.watches = nil
+		fmt.Println("All watches removed")
+		return
+	}
+	addr, err := r.resolveAddress(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for i, w := range r.watches {
+		if w.Addr == addr {
+			r.watches = append(r.watches[:i], r.watches[i+1:]...)
+			fmt.Printf("No longer watching $%04X\n", addr)
+			return
+		}
+	}
+	fmt.Printf("$%04X is not watched\n", addr)
+}
+
+// showWatches lists the watches with their current values
+func (r *REPL) showWatches() {
+	if len(r.watches) == 0 {
+		fmt.Println("No watches (see /watch <address>)")
+		return
+	}
+	fmt.Println("Watches:")
+	for _, w := range r.watches {
+		size := "byte"
+		if w.Word {
+			size = "word"
+		}
+		fmt.Printf("  $%04X %-12s %s  %s\n", w.Addr, w.Label, size, w.format(w.read(r)))
+	}
+}
+
+// checkWatches reports each watch whose value changed since the last
+// check and remembers the new values
+func (r *REPL) checkWatches() {
+	for i := range r.watches {
+		w := &r.watches[i]
+		value := w.read(r)
+		if value == w.Value {
+			continue
+		}
+		fmt.Printf("👁  $%04X %s: %s -> %s\n", w.Addr, w.Label, w.format(w.Value), w.format(value))
+		w.Value = value
+	}
+}
@@ -0,0 +1,141 @@
+// mztas - inspect MinZ TAS recordings (.tas files exported by mzr)
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/minz/minzc/pkg/tas"
+)
+
+// stateImageSize is the size of the memory and screen images of a state,
+// which is most of what an uncompressed recording holds
+const stateImageSize = 65536 + 6912
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "mztas - MinZ TAS recording tool\n")
+	fmt.Fprintf(os.Stderr, "Usage: mztas <command> [arguments]\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  info <file.tas>...   Show what a recording holds\n")
+	fmt.Fprintf(os.Stderr, "\nRecordings are made in mzr with /record and saved with /export <file.tas>.\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "info":
+		if len(os.Args) < 3 {
+			fmt.Fprintf(os.Stderr, "Usage: mztas info <file.tas>...\n")
+			os.Exit(2)
+		}
+		failed := false
+		for i, filename := range os.Args[2:] {
+			if i > 0 {
+				fmt.Println()
+			}
+			if err := info(filename); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", filename, err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	case "help", "-h", "--help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+}
+
+// info prints the header, metadata, events and keyframes of a recording
+func info(filename string) error {
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	f, err := tas.LoadFromFile(filename)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(filename)
+	fmt.Printf("  Format:      %s (version %d)\n", formatName(f.Header.Format), f.Header.Version)
+	if !f.Header.Created.IsZero() {
+		fmt.Printf("  Created:     %s\n", f.Header.Created.Format("2006-01-02 15:04:05"))
+	}
+
+	meta := f.Metadata
+	program := strings.TrimSpace(meta.ProgramName + " " + meta.ProgramVersion)
+	if program != "" {
+		fmt.Printf("  Program:     %s\n", program)
+	}
+	if meta.Author != "" {
+		fmt.Printf("  Author:      %s\n", meta.Author)
+	}
+	if meta.Description != "" {
+		fmt.Printf("  Description: %s\n", meta.Description)
+	}
+	if len(meta.Tags) > 0 {
+		fmt.Printf("  Tags:        %s\n", strings.Join(meta.Tags, ", "))
+	}
+	if len(meta.Properties) > 0 {
+		keys := make([]string, 0, len(meta.Properties))
+		for k := range meta.Properties {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("  %-12s %s\n", k+":", meta.Properties[k])
+		}
+	}
+	fmt.Printf("  Frames:      %d (%d cycles)\n", meta.TotalFrames, meta.TotalCycles)
+
+	events := f.Events
+	fmt.Printf("  Events:      %d inputs, %d I/O, %d SMC\n",
+		len(events.Inputs), len(events.IOEvents), len(events.SMCEvents))
+	if len(events.Inputs) > 0 {
+		fmt.Printf("               inputs from cycle %d to %d\n",
+			events.Inputs[0].Cycle, events.Inputs[len(events.Inputs)-1].Cycle)
+	}
+
+	if len(f.States) == 0 {
+		fmt.Printf("  States:      none\n")
+	} else {
+		first := f.States[0]
+		fmt.Printf("  Start:       cycle %d, PC=$%04X SP=$%04X\n", first.Cycle, first.PC, first.SP)
+		if keyframes := f.States[1:]; len(keyframes) > 0 {
+			last := keyframes[len(keyframes)-1]
+			fmt.Printf("  Keyframes:   %d, up to cycle %d (PC=$%04X)\n", len(keyframes), last.Cycle, last.PC)
+		}
+	}
+
+	raw := int64(len(f.States)) * stateImageSize
+	if raw > 0 && f.Header.Format == tas.TASFormatCompressed {
+		fmt.Printf("  Size:        %d bytes (states alone %d bytes uncompressed, %.1fx)\n",
+			stat.Size(), raw, float64(raw)/float64(stat.Size()))
+	} else {
+		fmt.Printf("  Size:        %d bytes\n", stat.Size())
+	}
+	return nil
+}
+
+func formatName(format uint8) string {
+	switch format {
+	case tas.TASFormatJSON:
+		return "JSON"
+	case tas.TASFormatBinary:
+		return "binary"
+	case tas.TASFormatCompressed:
+		return "compressed binary"
+	}
+	return fmt.Sprintf("unknown (%d)", format)
+}
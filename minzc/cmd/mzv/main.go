@@ -3,13 +3,18 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/minz/minzc/pkg/ir"
+	"github.com/minz/minzc/pkg/mir"
 	"github.com/minz/minzc/pkg/mirvm"
 )
 
@@ -20,10 +25,16 @@ func main() {
 		debug       = flag.Bool("d", false, "Enable debug output")
 		trace       = flag.Bool("trace", false, "Trace execution")
 		breakpoints = flag.String("bp", "", "Comma-separated list of breakpoints (e.g., main:5,helper:10)")
+		watchpoints = flag.String("watch", "", "Comma-separated memory addresses to watch, with an optional size (e.g., 0x1000,0x1002:2)")
+		interactive = flag.Bool("interactive", false, "Debug interactively: stop at the start, at breakpoints and watchpoints (-i is the input file)")
 		maxSteps    = flag.Int("max-steps", 1000000, "Maximum execution steps (prevent infinite loops)")
 		memSize     = flag.Int("mem", 65536, "Memory size in bytes")
+		limitMem    = flag.Int("limit-mem", 0, "Fail on memory accesses at or above this address (0 = whole memory)")
 		stackSize   = flag.Int("stack", 4096, "Stack size in bytes")
 		verbose     = flag.Bool("v", false, "Verbose output")
+		dumpCov     = flag.Bool("dump-coverage", false, "Report per-function MIR instruction coverage after the run")
+		cover       = flag.Bool("cover", false, "Record executed instructions and branch outcomes, and write an annotated MIR coverage report")
+		coverOut    = flag.String("cover-out", "", "Annotated coverage report file (default: the input name with .cover.mir)")
 	)
 
 	flag.Usage = func() {
@@ -35,7 +46,12 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  %s -i program.mir              # Run MIR program\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -i program.mir -trace       # Trace execution\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -i program.mir -bp main:5   # Set breakpoint\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i program.mir -interactive # Step through the program (h for commands)\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i program.mir -watch 0x1000:2   # Report changes of the word at 0x1000\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -i program.mir -d           # Debug mode\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i program.mir -limit-mem 16384  # Trap accesses above 16K\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i program.mir -dump-coverage    # List unexecuted MIR instructions\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i program.mir -cover            # Write program.cover.mir with execution counts\n", os.Args[0])
 	}
 
 	flag.Parse()
@@ -58,8 +74,13 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Parse MIR
-	module, err := ir.ParseMIR(string(mirData))
+	// Parse MIR, text or binary
+	var module *ir.Module
+	if mir.IsMIRBinary(mirData) {
+		module, err = mir.ReadMIRBinary(bytes.NewReader(mirData))
+	} else {
+		module, err = ir.ParseMIR(string(mirData))
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error parsing MIR: %v\n", err)
 		os.Exit(1)
@@ -68,6 +89,7 @@ func main() {
 	// Create VM configuration
 	config := mirvm.Config{
 		MemorySize:  *memSize,
+		MemoryLimit: *limitMem,
 		StackSize:   *stackSize,
 		Debug:       *debug,
 		Trace:       *trace,
@@ -90,8 +112,49 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *watchpoints != "" {
+		for _, w := range strings.Split(*watchpoints, ",") {
+			addr, size, err := parseWatchpoint(w)
+			if err == nil {
+				err = vm.AddWatchpoint(addr, size)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid watchpoint %s: %v\n", w, err)
+				os.Exit(1)
+			}
+		}
+	}
+	if *interactive {
+		vm.EnableInteractive(os.Stdin)
+	}
+
+	var coverage *mirvm.Coverage
+	if *dumpCov || *cover {
+		coverage = vm.EnableCoverage()
+	}
+
 	// Run the program (starts from main function)
 	exitCode, err := vm.Run()
+	// Coverage is also useful when the run failed: it shows how far
+	// execution got
+	if *dumpCov {
+		fmt.Fprintln(os.Stderr)
+		coverage.WriteReport(os.Stderr, module)
+	}
+	if *cover {
+		path := *coverOut
+		if path == "" {
+			path = strings.TrimSuffix(*input, filepath.Ext(*input)) + ".cover.mir"
+		}
+		if werr := writeCoverage(path, coverage, module); werr != nil {
+			fmt.Fprintf(os.Stderr, "Error writing coverage report: %v\n", werr)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Coverage report written to %s\n", path)
+	}
+	if errors.Is(err, mirvm.ErrQuit) {
+		os.Exit(exitCode)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Runtime error: %v\n", err)
 		os.Exit(1)
@@ -120,6 +183,19 @@ func main() {
 	os.Exit(exitCode)
 }
 
+// writeCoverage writes the annotated MIR coverage report to path
+func writeCoverage(path string, coverage *mirvm.Coverage, module *ir.Module) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := coverage.WriteAnnotated(file, module); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
 // parseBreakpoints parses comma-separated breakpoint specifications
 // Format: function:line or function:instruction_index
 func parseBreakpoints(spec string) map[string][]int {
@@ -143,4 +219,21 @@ func parseBreakpoints(spec string) map[string][]int {
 	}
 	
 	return breakpoints
-}
\ No newline at end of file
+}
+
+// parseWatchpoint parses a watchpoint specification: address[:size], the
+// size in bytes defaulting to 1
+func parseWatchpoint(spec string) (int64, int, error) {
+	addrSpec, sizeSpec, hasSize := strings.Cut(strings.TrimSpace(spec), ":")
+	addr, err := strconv.ParseInt(addrSpec, 0, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	size := 1
+	if hasSize {
+		if size, err = strconv.Atoi(sizeSpec); err != nil {
+			return 0, 0, err
+		}
+	}
+	return addr, size, nil
+}
@@ -19,7 +19,7 @@ echo "Building compiler..."
 make build
 
 echo "Building REPL (optional)..."
-make repl || echo "⚠️  REPL build failed, but compiler is ready!"
+make mzr || echo "⚠️  REPL build failed, but compiler is ready!"
 
 # Copy executables to ~/.local/bin
 echo "📂 Installing to ~/.local/bin..."
@@ -260,12 +260,13 @@ func (a *AsmBlockStmt) stmtNode()    {}
 
 // VarDecl represents a variable declaration
 type VarDecl struct {
-	Name      string
-	Type      Type
-	Value     Expression
-	IsMutable bool
-	IsPublic  bool
-	StartPos  Position
+	Name       string
+	Type       Type
+	Value      Expression
+	IsMutable  bool
+	IsPublic   bool
+	Attributes []*Attribute
+	StartPos   Position
 	EndPos    Position
 }
 
@@ -317,11 +318,14 @@ func (s *StructDecl) declNode()    {}
 
 // EnumDecl represents an enum declaration
 type EnumDecl struct {
-	Name     string
-	Variants []string
-	IsPublic bool
-	StartPos Position
-	EndPos   Position
+	Name       string
+	Variants   []string
+	Payloads   map[string][]Type // Data carried by variants, e.g. Circle(u8)
+	Values     map[string]Expression // Explicit discriminants, e.g. Red = 2
+	IsPublic   bool
+	Attributes []*Attribute
+	StartPos   Position
+	EndPos     Position
 }
 
 func (e *EnumDecl) Pos() Position { return e.StartPos }
@@ -433,6 +437,18 @@ func (r *ReturnStmt) Pos() Position { return r.StartPos }
 func (r *ReturnStmt) End() Position { return r.EndPos }
 func (r *ReturnStmt) stmtNode()    {}
 
+// DeferStmt represents defer <statement>: the statement runs when control
+// leaves the enclosing block, at its end or at a return
+type DeferStmt struct {
+	Body     Statement
+	StartPos Position
+	EndPos   Position
+}
+
+func (d *DeferStmt) Pos() Position { return d.StartPos }
+func (d *DeferStmt) End() Position { return d.EndPos }
+func (d *DeferStmt) stmtNode()    {}
+
 // IfStmt represents an if statement
 type IfStmt struct {
 	Condition Expression
@@ -707,6 +723,7 @@ func (r *RangePattern) patternNode()  {}
 type EnumPattern struct {
 	EnumType string   // The enum type name
 	Variant  string   // The variant name
+	Bindings []string // Names bound to the variant's payload; "_" skips one
 	StartPos Position
 	EndPos   Position
 }
@@ -764,7 +781,9 @@ func (i *Identifier) exprNode()    {}
 
 // NumberLiteral represents a number literal
 type NumberLiteral struct {
-	Value    int64
+	Value    int64   // Integer value; the integer part of a fractional literal
+	IsFloat  bool    // Written with a fractional part, e.g. 3.5
+	Float    float64 // Exact value of a fractional literal
 	StartPos Position
 	EndPos   Position
 }
@@ -899,6 +918,8 @@ type CompileTimeIf struct {
 func (c *CompileTimeIf) Pos() Position { return c.StartPos }
 func (c *CompileTimeIf) End() Position { return c.EndPos }
 func (c *CompileTimeIf) exprNode()    {}
+func (c *CompileTimeIf) stmtNode()    {}
+func (c *CompileTimeIf) declNode()    {} // @if can be a top-level declaration
 
 // CompileTimePrint represents @print compile-time output
 type CompileTimePrint struct {
@@ -934,6 +955,20 @@ func (c *CompileTimeError) Pos() Position { return c.StartPos }
 func (c *CompileTimeError) End() Position { return c.EndPos }
 func (c *CompileTimeError) exprNode()     {}
 
+// CompileTimeFor represents @for, which repeats its body at compile time
+// once per item of a reflection metafunction such as @fields(T)
+type CompileTimeFor struct {
+	Iterator string            // Name bound to each item
+	Items    *MetafunctionCall // @fields(T) or @variants(T)
+	Body     *BlockStmt
+	StartPos Position
+	EndPos   Position
+}
+
+func (c *CompileTimeFor) Pos() Position { return c.StartPos }
+func (c *CompileTimeFor) End() Position { return c.EndPos }
+func (c *CompileTimeFor) stmtNode()     {}
+
 // Attribute represents @attribute declarations
 type Attribute struct {
 	Name      string
@@ -40,6 +40,7 @@ const (
 	IterOpSkipWhile
 	IterOpPeek
 	IterOpInspect
+	IterOpFind
 )
 
 // IteratorMethodExpr represents iterator method calls
@@ -33,6 +33,33 @@ type BackendOptions struct {
 	// TargetAddress is the origin address for code
 	TargetAddress uint16
 	
+	// SectionOrigins maps @section names to their origin addresses
+	SectionOrigins map[string]uint16
+	
+	// Sections emits SECTION directives instead of ORG, so the assembler
+	// places the code and data (Z80 specific, --sections)
+	Sections bool
+	
+	// RelocatableCalls routes calls through a call-thunk table (Z80 specific)
+	RelocatableCalls bool
+	
+	// Deterministic leaves timestamps out of generated output
+	Deterministic bool
+	
+	// DisablePeephole skips the peephole pass over generated assembly (-O no-peephole)
+	DisablePeephole bool
+	
+	// OptimizeSize prefers smaller code to faster code (-O size): for
+	// example multiplication and division always call runtime routines
+	OptimizeSize bool
+	
+	// SourceLines marks where the code of each source line begins (Z80 specific, --emit-listing)
+	SourceLines bool
+	
+	// CABI selects the calling convention of C output (C specific,
+	// --c-abi): "" or "default" for portable C99, "sdcc" for SDCC's Z80 ABI
+	CABI string
+	
 	// Target specifies the target system (e.g., "spectrum", "cpm", "amstrad")
 	// This affects standard library selection and conditional compilation
 	Target string
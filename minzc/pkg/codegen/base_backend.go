@@ -59,10 +59,10 @@ func (b *BaseBackend) PreprocessModule(module *ir.Module) error {
 		}
 	}
 	
-	// Apply SMC options if supported
+	// Apply SMC options if supported, leaving code in ROM alone
 	if b.options != nil && b.options.EnableSMC && b.features[FeatureSelfModifyingCode] {
 		for _, fn := range module.Functions {
-			fn.IsSMCEnabled = true
+			fn.IsSMCEnabled = !fn.InROM
 		}
 	}
 	
@@ -17,13 +17,18 @@ type CGenerator struct {
 	varTypes   map[string]string // Track variable types
 	timestamp  string
 	tempCounter int
+	sdcc       bool // SDCC's Z80 ABI (--c-abi sdcc)
 }
 
 func (g *CGenerator) Generate() error {
 	// Generate header
 	g.emit("// MinZ C generated code")
 	g.emit("// Generated: %s", g.timestamp)
-	g.emit("// Target: Standard C (C99)")
+	if g.sdcc {
+		g.emit("// Target: SDCC Z80 (sdcc -mz80, __sdcccall(1) calling convention)")
+	} else {
+		g.emit("// Target: Standard C (C99)")
+	}
 	g.emit("")
 	g.emit("#include <stdio.h>")
 	g.emit("#include <stdint.h>")
@@ -59,6 +64,13 @@ func (g *CGenerator) Generate() error {
 	g.emit("#define F8_16_SHIFT 16")
 	g.emit("")
 	
+	if g.sdcc {
+		// SDCC has 16-bit ints and pointers and never pads structs on the
+		// Z80, so these types have the layout of MinZ's own Z80 code
+		g.emit("// SDCC Z80 data layout: int and pointers are 16-bit, structs are unpadded")
+		g.emit("")
+	}
+	
 	// Generate string type
 	g.emit("// String type (length-prefixed)")
 	g.emit("typedef struct {")
@@ -110,40 +122,51 @@ func (g *CGenerator) Generate() error {
 }
 
 func (g *CGenerator) generatePrintHelpers() {
+	// With SDCC the helpers are static inline so that several generated
+	// files and SDCC libraries link together, and unused ones cost nothing
+	linkage := ""
+	if g.sdcc {
+		linkage = "static inline "
+	}
+	
 	g.emit("// Print helper functions")
-	g.emit("void print_char(u8 ch) {")
+	g.emit("%svoid print_char(u8 ch) {", linkage)
 	g.emit("    putchar(ch);")
 	g.emit("}")
 	g.emit("")
-	g.emit("void print_u8(u8 value) {")
+	g.emit("%svoid print_u8(u8 value) {", linkage)
 	g.emit("    printf(\"%%u\", value);")
 	g.emit("}")
 	g.emit("")
-	g.emit("void print_u8_decimal(u8 value) {")
+	g.emit("%svoid print_u8_decimal(u8 value) {", linkage)
 	g.emit("    printf(\"%%u\", value);")
 	g.emit("}")
 	g.emit("")
-	g.emit("void print_u16(u16 value) {")
+	g.emit("%svoid print_u16(u16 value) {", linkage)
 	g.emit("    printf(\"%%u\", value);")
 	g.emit("}")
 	g.emit("")
-	g.emit("void print_u24(u24 value) {")
-	g.emit("    printf(\"%%u\", value);")
+	g.emit("%svoid print_u24(u24 value) {", linkage)
+	if g.sdcc {
+		g.emit("    printf(\"%%lu\", (unsigned long)value);")
+	} else {
+		g.emit("    printf(\"%%u\", value);")
+	}
 	g.emit("}")
 	g.emit("")
-	g.emit("void print_i8(i8 value) {")
+	g.emit("%svoid print_i8(i8 value) {", linkage)
 	g.emit("    printf(\"%%d\", value);")
 	g.emit("}")
 	g.emit("")
-	g.emit("void print_i16(i16 value) {")
+	g.emit("%svoid print_i16(i16 value) {", linkage)
 	g.emit("    printf(\"%%d\", value);")
 	g.emit("}")
 	g.emit("")
-	g.emit("void print_newline() {")
+	g.emit("%svoid print_newline(void) {", linkage)
 	g.emit("    printf(\"\\n\");")
 	g.emit("}")
 	g.emit("")
-	g.emit("void print_string(String* str) {")
+	g.emit("%svoid print_string(String* str) {", linkage)
 	g.emit("    if (str && str->data) {")
 	g.emit("        printf(\"%%.*s\", str->len, str->data);")
 	g.emit("    }")
@@ -153,7 +176,7 @@ func (g *CGenerator) generatePrintHelpers() {
 
 func (g *CGenerator) generateFunctionDeclaration(fn *ir.Function) {
 	returnType := g.getCType(fn.ReturnType)
-	g.emit("%s %s(%s);", returnType, g.sanitizeName(fn.Name), g.getParameterList(fn))
+	g.emit("%s %s(%s)%s;", returnType, g.sanitizeName(fn.Name), g.getParameterList(fn), g.callingConvention())
 }
 
 func (g *CGenerator) generateFunction(fn *ir.Function) error {
@@ -161,7 +184,7 @@ func (g *CGenerator) generateFunction(fn *ir.Function) error {
 	returnType := g.getCType(fn.ReturnType)
 	
 	// Function signature
-	g.emit("%s %s(%s) {", returnType, g.sanitizeName(fn.Name), g.getParameterList(fn))
+	g.emit("%s %s(%s)%s {", returnType, g.sanitizeName(fn.Name), g.getParameterList(fn), g.callingConvention())
 	g.indent++
 	
 	// Declare locals based on virtual registers used
@@ -300,7 +323,7 @@ func (g *CGenerator) generateInstruction(inst *ir.Instruction) error {
 		}
 		
 	case ir.OpPrint, ir.OpPrintU8, ir.OpPrintU16, ir.OpPrintI8, ir.OpPrintI16,
-	     ir.OpPrintBool, ir.OpPrintString, ir.OpPrintStringDirect:
+	     ir.OpPrintFixed, ir.OpPrintBool, ir.OpPrintString, ir.OpPrintStringDirect:
 		g.generatePrint(inst)
 		
 	case ir.OpLoadString:
@@ -316,6 +339,9 @@ func (g *CGenerator) generateInstruction(inst *ir.Instruction) error {
 		g.emit("// Inline assembly not supported in C backend")
 		g.emit("// %s", inst.AsmCode)
 		
+	case ir.OpSourceMark:
+		g.emit("// %s", inst.String())
+		
 	case ir.OpInc:
 		// Increment optimization for C
 		varName := g.getVarName(inst.Dest)
@@ -357,7 +383,7 @@ func (g *CGenerator) generateInstruction(inst *ir.Instruction) error {
 		dest := g.getVarName(inst.Dest)
 		if inst.Symbol != "" {
 			// Load address of named variable/array
-			g.emit("%s = (u32)&%s;", dest, inst.Symbol)
+			g.emit("%s = (%s)&%s;", dest, g.addressType(), inst.Symbol)
 		} else {
 			// Load address from register (for nested arrays)
 			src := g.getVarName(inst.Src1)
@@ -383,6 +409,11 @@ func (g *CGenerator) generateBinaryOp(inst *ir.Instruction) {
 	case ir.OpSub:
 		op = "-"
 	case ir.OpMul:
+		if frac := ir.FixedFracBits(inst.Type); frac > 0 {
+			// Fixed-point product rescaled by the fractional bits
+			g.emit("%s = (%s)(((uint32_t)%s * %s) >> %d);", dest, g.getCType(inst.Type), src1, src2, frac)
+			return
+		}
 		op = "*"
 	case ir.OpDiv:
 		op = "/"
@@ -500,6 +531,18 @@ func (g *CGenerator) generatePrint(inst *ir.Instruction) {
 		value := g.getVarName(inst.Src1)
 		g.emit("printf(\"%%d\", (int)%s);", value)
 		
+	case ir.OpPrintFixed:
+		value := g.getVarName(inst.Src1)
+		mask := uint32(1)<<(8*inst.Type.Size()) - 1
+		if g.sdcc {
+			// SDCC's printf has no floating point: print two decimals
+			frac := ir.FixedFracBits(inst.Type)
+			g.emit("printf(\"%%lu.%%02lu\", (unsigned long)((%s & 0x%X) >> %d), (unsigned long)(((%s & 0x%X) * 100UL) >> %d));",
+				value, mask, frac, value, uint32(1)<<frac-1, frac)
+			break
+		}
+		g.emit("printf(\"%%g\", (%s & 0x%X) / %d.0);", value, mask, 1<<ir.FixedFracBits(inst.Type))
+		
 	case ir.OpPrintBool:
 		value := g.getVarName(inst.Src1)
 		g.emit("printf(\"%%s\", %s ? \"true\" : \"false\");", value)
@@ -559,7 +602,11 @@ func (g *CGenerator) generateMainWrapper() {
 	for _, fn := range g.module.Functions {
 		if fn.Name == "main" || strings.HasSuffix(fn.Name, ".main") {
 			g.emit("// C main wrapper")
-			g.emit("int main(int argc, char** argv) {")
+			if g.sdcc {
+				g.emit("int main(void) {")
+			} else {
+				g.emit("int main(int argc, char** argv) {")
+			}
 			g.indent++
 			// Check if return type is void
 			isVoid := false
@@ -638,6 +685,24 @@ func (g *CGenerator) getCType(t ir.Type) string {
 	return "void*" // Unknown type
 }
 
+// callingConvention returns the attribute that follows a function's
+// parameter list: with SDCC, __sdcccall(1) passes the first arguments in
+// A/HL and L/DE and returns in A/DE, whatever --sdcccall the C side uses
+func (g *CGenerator) callingConvention() string {
+	if g.sdcc {
+		return " __sdcccall(1)"
+	}
+	return ""
+}
+
+// addressType is the integer type addresses are taken as
+func (g *CGenerator) addressType() string {
+	if g.sdcc {
+		return "uintptr_t"
+	}
+	return "u32"
+}
+
 func (g *CGenerator) getVarName(reg ir.Register) string {
 	if reg == 0 {
 		return ""
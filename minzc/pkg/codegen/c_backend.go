@@ -2,6 +2,7 @@ package codegen
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/minz/minzc/pkg/ir"
 	"time"
 )
@@ -25,6 +26,17 @@ func (b *CBackend) Name() string {
 
 // Generate generates C code for the given IR module
 func (b *CBackend) Generate(module *ir.Module) (string, error) {
+	sdcc := false
+	if b.options != nil {
+		switch b.options.CABI {
+		case "", "default":
+		case "sdcc":
+			sdcc = true
+		default:
+			return "", fmt.Errorf("unknown C ABI %q (known: default, sdcc)", b.options.CABI)
+		}
+	}
+	
 	// C doesn't support SMC - use standard calling conventions
 	for _, fn := range module.Functions {
 		fn.IsSMCEnabled = false
@@ -38,6 +50,7 @@ func (b *CBackend) Generate(module *ir.Module) (string, error) {
 		indent:  0,
 		varTypes: make(map[string]string),
 		timestamp: time.Now().Format("2006-01-02 15:04:05"),
+		sdcc:     sdcc,
 	}
 	
 	if err := gen.Generate(); err != nil {
@@ -0,0 +1,67 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+func TestCBackendSDCCABI(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	u16 := &ir.BasicType{Kind: ir.TypeU16}
+	module := func() *ir.Module {
+		add := ir.NewFunction("main.add", u16)
+		add.Params = []ir.Parameter{{Name: "a", Type: u8}, {Name: "b", Type: u16}}
+		add.Instructions = []ir.Instruction{
+			{Op: ir.OpLoadParam, Dest: 1, Symbol: "a", Type: u8},
+			{Op: ir.OpLoadParam, Dest: 2, Symbol: "b", Type: u16},
+			{Op: ir.OpAdd, Dest: 3, Src1: 1, Src2: 2, Type: u16},
+			{Op: ir.OpReturn, Src1: 3},
+		}
+		main := ir.NewFunction("main.main", &ir.BasicType{Kind: ir.TypeVoid})
+		main.Instructions = []ir.Instruction{
+			{Op: ir.OpLoadAddr, Dest: 1, Symbol: "counter", Type: u16},
+			{Op: ir.OpReturn},
+		}
+		return &ir.Module{Name: "main", Functions: []*ir.Function{add, main},
+			Globals: []ir.Global{{Name: "counter", Type: u16}}}
+	}
+
+	generate := func(abi string) (string, error) {
+		return GetBackend("c", &BackendOptions{CABI: abi}).Generate(module())
+	}
+
+	c, err := generate("sdcc")
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, want := range []string{
+		"u16 main_add(u8 a, u16 b) __sdcccall(1);\n",
+		"u16 main_add(u8 a, u16 b) __sdcccall(1) {\n",
+		"void main_main(void) __sdcccall(1);\n",
+		"static inline void print_u8(u8 value) {\n",
+		"(uintptr_t)&counter;",
+		"int main(void) {\n",
+	} {
+		if !strings.Contains(c, want) {
+			t.Errorf("sdcc output is missing %q:\n%s", want, c)
+		}
+	}
+
+	// The default ABI stays plain C99
+	c, err = generate("")
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if strings.Contains(c, "__sdcccall") || strings.Contains(c, "static inline void print_") {
+		t.Errorf("default output uses the SDCC ABI:\n%s", c)
+	}
+	if !strings.Contains(c, "u16 main_add(u8 a, u16 b);\n") {
+		t.Errorf("default output is missing the plain prototype:\n%s", c)
+	}
+
+	if _, err := generate("cdecl"); err == nil {
+		t.Error("unknown C ABI was accepted")
+	}
+}
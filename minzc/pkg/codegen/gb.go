@@ -3,329 +3,1446 @@ package codegen
 import (
 	"fmt"
 	"io"
+	"sort"
 	"strings"
-	"time"
 
 	"github.com/minz/minzc/pkg/ir"
 )
 
-// GBGenerator generates Game Boy (LR35902) assembly from IR
+// GBGenerator generates Game Boy assembly for mza -t gameboy from IR.
+//
+// The Game Boy's SM83 has no IX, IY or ED-prefixed instructions (no SBC HL
+// or 16-bit loads from absolute addresses), so variables live in a stack
+// frame reached through SP instead:
+//
+//	SP+frame+2+2i  parameter i (pushed by the caller, last parameter first)
+//	SP+frame       return address
+//	SP+2(r-1)      virtual register r, one 16-bit slot each (little-endian)
+//	above them     locals larger than a word (arrays, structs)
+//
+// LD HL,SP+e addresses a slot, and values are worked on in DE (the second
+// operand in BC). 8-bit values live zero- or sign-extended in their slot,
+// so comparisons work on 16 bits. Results are returned in DE.
+//
+// The output is a whole cartridge: the header at $0100 (the ROM generator
+// fills in the logo and checksums), code from $0150, strings and the font
+// in ROM after it, and globals in work RAM, which the startup code clears
+// and initializes before calling main with the LCD on.
 type GBGenerator struct {
 	writer        io.Writer
 	module        *ir.Module
 	currentFunc   *ir.Function
-	
-	// Register allocation - GB has no shadow registers or IX/IY
-	regAlloc      *RegisterAllocator
-	
-	stackOffset   int
 	labelCounter  int
-	localVarBase  uint16 // Base address for local variables
-	emittedParams map[string]bool // Track which SMC parameters have been emitted
+	deterministic bool
+
+	functions   map[string]bool // Module functions, by IR name
+	frame       int             // Bytes of the current frame
+	saved       int             // Bytes of registers an interrupt handler saves
+	pushed      int             // Bytes pushed since the frame was set up
+	bigLocals   map[string]int  // Frame offsets of locals larger than a word
+	usedHelpers map[string]bool // Runtime routines to append
 }
 
 // NewGBGenerator creates a new Game Boy code generator
 func NewGBGenerator(w io.Writer) *GBGenerator {
 	return &GBGenerator{
-		writer:          w,
-		regAlloc:        NewRegisterAllocator(),
-		localVarBase:    0xC000, // WRAM starts at 0xC000 on GB
-		emittedParams:   make(map[string]bool),
+		writer:      w,
+		functions:   make(map[string]bool),
+		usedHelpers: make(map[string]bool),
 	}
 }
 
+// gbRuntime lists the runtime routines the analyzer calls by name. They
+// take their argument in DE (E for 8-bit values, HL for strings) instead
+// of on the stack.
+var gbRuntime = map[string]bool{
+	"print_u8_decimal":  true,
+	"print_u16_decimal": true,
+	"print_i8_decimal":  true,
+	"print_i16_decimal": true,
+	"print_hex_u8":      true,
+	"print_bool":        true,
+	"print_string":      true,
+	"print_newline":     true,
+	"print_char":        true,
+	"wait_vblank":       true,
+	"lcd_off":           true,
+	"lcd_on":            true,
+}
+
+// gbStackTop is where the stack starts: the top of work RAM, as HRAM only
+// holds 127 bytes
+const gbStackTop = 0xE000
+
 // Generate generates Game Boy assembly for an IR module
 func (g *GBGenerator) Generate(module *ir.Module) error {
 	g.module = module
+	for _, fn := range module.Functions {
+		g.functions[fn.Name] = true
+	}
 
-	// Write header
 	g.writeHeader()
 
-	// Generate data section in WRAM
-	if len(module.Globals) > 0 || len(module.Strings) > 0 {
-		g.emit("\n; Data section (WRAM)")
-		g.emit("SECTION \"Variables\", WRAM0[$C000]")
-		g.emit("")
-		for _, global := range module.Globals {
-			g.generateGlobal(global)
-		}
-		
-		// String literals go in ROM
-		if len(module.Strings) > 0 {
-			g.emit("\nSECTION \"StringData\", ROM0")
-			for _, str := range module.Strings {
-				g.generateString(*str)
-			}
+	// Fixed-address globals become equates
+	for _, global := range module.Globals {
+		if global.Address != nil {
+			g.emit("%s EQU $%04X", g.symbol(global.Name), *global.Address)
 		}
 	}
 
-	// Generate code section
-	g.emit("\n; Code section")
-	g.emit("SECTION \"Code\", ROM0[$0150]") // After GB header
-	g.emit("")
+	g.generateCartridgeHeader()
 
-	// Generate functions
+	g.emit("\n    SECTION CODE")
 	for _, fn := range module.Functions {
 		if err := g.generateFunction(fn); err != nil {
 			return err
 		}
 	}
 
-	// Generate runtime helper functions
-	g.generatePrintHelpers()
-	
+	g.generateStartup()
+	g.generateHelpers()
+
+	// Read-only data stays in ROM
+	text := g.usedHelpers["print_char"]
+	if len(module.Strings) > 0 || text {
+		g.emit("\n; Read-only data (ROM)")
+		g.emit("    SECTION DATA")
+		for _, str := range module.Strings {
+			g.generateString(str)
+		}
+		if text {
+			g.generateFont()
+		}
+	}
+
+	// Variables go in work RAM
+	g.emit("\n; Variables (work RAM)")
+	g.emit("    SECTION BSS")
+	for _, global := range module.Globals {
+		g.generateGlobal(global)
+	}
+	if text {
+		g.emit("gb_cursor:")
+		g.emit("    DS 2 ; Next character's address in the background map")
+	}
 	return nil
 }
 
 // writeHeader writes the assembly file header
 func (g *GBGenerator) writeHeader() {
 	g.emit("; MinZ Game Boy generated code")
-	g.emit("; Generated: %s", time.Now().Format("2006-01-02 15:04:05"))
-	g.emit("; Target: Sharp LR35902 (Game Boy CPU)")
-	g.emit("; Note: No shadow registers or IX/IY on GB")
-	g.emit("")
-	g.emit("; Using RGBDS assembler syntax")
+	if stamp := generatedTimestamp(g.deterministic); stamp != "" {
+		g.emit("; Generated: %s", stamp)
+	}
+	g.emit("; Target: Game Boy (SM83)")
+	g.emit("; Assemble with: mza -t gameboy -o program.gb program.gb.s")
 	g.emit("")
 }
 
-// generateGlobal generates a global variable
+// generateCartridgeHeader emits the cartridge header at $0100: the entry
+// point, room for the logo, the title and a 32K ROM-only cartridge
+func (g *GBGenerator) generateCartridgeHeader() {
+	title := g.title()
+	g.emit("; Cartridge header: mza fills in the logo and checksums")
+	g.emit("    SECTION HEADER, $0100")
+	g.emit("    NOP")
+	g.emit("    JP start")
+	g.emit("    DS 48 ; Nintendo logo")
+	if title != "" {
+		g.emit("    DB \"%s\" ; Title", title)
+	}
+	g.emit("    DS %d", 16-len(title))
+	g.emit("    DB 0, 0 ; Licensee")
+	g.emit("    DB 0 ; No Super Game Boy functions")
+	g.emit("    DB 0 ; ROM only")
+	g.emit("    DB 0 ; 32K ROM")
+	g.emit("    DB 0 ; No cartridge RAM")
+	g.emit("    DB 1 ; Not for Japan")
+	g.emit("    DB $33 ; Licensee in the new field")
+	g.emit("    DB 0 ; Version")
+	g.emit("    DB 0 ; Header checksum")
+	g.emit("    DW 0 ; Global checksum")
+}
+
+// title returns the cartridge title: the name of the module with main
+// (game for game.main) in capitals, at most 15 characters so the Color
+// Game Boy flag stays clear
+func (g *GBGenerator) title() string {
+	name := g.module.Name
+	if main := g.findMain(); strings.HasSuffix(main, ".main") {
+		name = strings.TrimSuffix(main, ".main")
+	}
+	var title strings.Builder
+	for _, c := range strings.ToUpper(name) {
+		if title.Len() == 15 {
+			break
+		}
+		if c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == ' ' {
+			title.WriteRune(c)
+		} else if c == '_' || c == '-' {
+			title.WriteRune(' ')
+		}
+	}
+	return title.String()
+}
+
+// generateStartup emits the code the header jumps to: it clears work RAM,
+// sets up the globals and the screen, then calls main and halts when it
+// returns
+func (g *GBGenerator) generateStartup() {
+	g.resolveHelpers()
+	text := g.usedHelpers["print_char"]
+
+	g.emit("\n; Entry point")
+	g.emit("start:")
+	g.emit("    DI")
+	g.emit("    LD SP, $%04X ; Top of work RAM", gbStackTop)
+	g.emit("    LD HL, $C000")
+	g.emit("gb_clear_wram:")
+	g.emit("    XOR A")
+	g.emit("    LD (HL+), A")
+	g.emit("    LD A, H")
+	g.emit("    CP $%02X", gbStackTop>>8)
+	g.emit("    JR NZ, gb_clear_wram")
+	for _, global := range g.module.Globals {
+		g.initGlobal(global)
+	}
+	g.callHelper("lcd_off")
+	if text {
+		g.callHelper("gb_init_text")
+	}
+	g.emit("    LD A, $E4 ; Shades 3, 2, 1, 0")
+	g.emit("    LDH (BGP), A")
+	g.callHelper("lcd_on")
+	if main := g.findMain(); main != "" {
+		g.emit("    CALL %s", g.symbol(main))
+	}
+	g.emit("gb_halt:")
+	g.emit("    HALT")
+	g.emit("    NOP")
+	g.emit("    JR gb_halt")
+}
+
+// findMain returns the IR name of the main function, or ""
+func (g *GBGenerator) findMain() string {
+	for _, fn := range g.module.Functions {
+		if fn.Name == "main" || strings.HasSuffix(fn.Name, ".main") {
+			return fn.Name
+		}
+	}
+	return ""
+}
+
+// globalInit returns the initial value of a global
+func globalInit(global ir.Global) int64 {
+	switch v := global.Init.(type) {
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case bool:
+		if v {
+			return 1
+		}
+	}
+	return 0
+}
+
+// initGlobal stores the initial value of a global, which is in RAM and
+// cleared
+func (g *GBGenerator) initGlobal(global ir.Global) {
+	value := globalInit(global)
+	if global.Address != nil || value == 0 || global.Type == nil || global.Type.Size() > 2 {
+		return
+	}
+	g.emit("    LD A, %d", value&0xFF)
+	g.emit("    LD (%s), A", g.symbol(global.Name))
+	if global.Type.Size() == 2 {
+		g.emit("    LD A, %d", (value>>8)&0xFF)
+		g.emit("    LD (%s+1), A", g.symbol(global.Name))
+	}
+}
+
+// generateGlobal reserves a global variable in work RAM
 func (g *GBGenerator) generateGlobal(global ir.Global) {
-	g.emit("%s:", global.Name)
-	size := global.Type.Size()
-	// For now, just reserve space
-	// TODO: Handle initialization values
-	g.emit("    DS %d ; %s", size, global.Type.String())
+	if global.Address != nil {
+		return // Declared as an equate
+	}
+	size := 2
+	if global.Type != nil {
+		size = global.Type.Size()
+	}
+	g.emit("%s:", g.symbol(global.Name))
+	if global.Type != nil {
+		g.emit("    DS %d ; %s", size, global.Type.String())
+	} else {
+		g.emit("    DS %d", size)
+	}
 }
 
-// generateString generates a string literal
-func (g *GBGenerator) generateString(str ir.String) {
-	g.emit("%s:", str.Label)
-	// Length-prefixed string
-	g.emit("    DB %d ; Length", len(str.Value))
-	if len(str.Value) > 0 {
-		// Escape special characters
-		escaped := strings.ReplaceAll(str.Value, "\"", "\\\"")
-		g.emit("    DB \"%s\"", escaped)
+// generateString generates a length-prefixed string literal
+func (g *GBGenerator) generateString(str *ir.String) {
+	g.emit("%s:", g.symbol(str.Label))
+	if str.IsLong {
+		g.emit("    DB 255 ; LString marker")
+		g.emit("    DW %d ; Length", len(str.Value))
+	} else {
+		g.emit("    DB %d ; Length", len(str.Value))
+	}
+	if len(str.Value) == 0 {
+		return
+	}
+	bytes := make([]string, 0, len(str.Value))
+	for i := 0; i < len(str.Value); i++ {
+		bytes = append(bytes, fmt.Sprintf("$%02X", str.Value[i]))
 	}
+	g.emit("    DB %s", strings.Join(bytes, ", "))
 }
 
 // generateFunction generates a function
 func (g *GBGenerator) generateFunction(fn *ir.Function) error {
 	g.currentFunc = fn
-	g.stackOffset = 0
-	g.regAlloc.Reset()
+	g.pushed = 0
+	g.saved = 0
+	if fn.IsInterrupt {
+		g.saved = 8
+	}
+
+	// Registers take the first slots, larger locals go above them
+	g.frame = 2 * int(g.maxRegister(fn))
+	g.bigLocals = make(map[string]int)
+	for _, local := range fn.Locals {
+		if local.Type != nil && local.Type.Size() > 2 {
+			g.bigLocals[local.Name] = g.frame
+			g.frame += local.Type.Size()
+		}
+	}
 
-	// Function label
 	g.emit("\n; Function: %s", fn.Name)
-	if fn.IsSMCEnabled {
-		g.emit("; SMC enabled")
+	if fn.IsInterrupt {
+		g.emit("; Interrupt handler: saves the registers and returns with RETI")
 	}
-	g.emit("%s:", fn.Name)
+	g.emit("%s:", g.symbol(fn.Name))
 
 	// Prologue
-	g.generatePrologue()
+	if fn.IsInterrupt {
+		g.emit("    PUSH AF")
+		g.emit("    PUSH BC")
+		g.emit("    PUSH DE")
+		g.emit("    PUSH HL")
+	}
+	g.adjustSP(-g.frame)
 
-	// Generate instructions
-	for i, inst := range fn.Instructions {
-		if err := g.generateInstruction(&inst, i); err != nil {
-			return err
+	for i := range fn.Instructions {
+		if err := g.generateInstruction(&fn.Instructions[i]); err != nil {
+			return fmt.Errorf("function %s: %w", fn.Name, err)
 		}
 	}
 
 	// Epilogue (if not already returned)
-	if len(fn.Instructions) == 0 || fn.Instructions[len(fn.Instructions)-1].Op != ir.OpReturn {
+	if !fn.EndsWithReturn() {
 		g.generateEpilogue()
 	}
-
 	return nil
 }
 
-// generatePrologue generates function prologue
-func (g *GBGenerator) generatePrologue() {
-	// Save registers if needed
-	if g.currentFunc.UsedRegisters != 0 {
-		g.emit("    PUSH BC")
-		g.emit("    PUSH DE")
-		g.emit("    PUSH HL")
-		g.stackOffset += 6
+// maxRegister returns the highest virtual register fn uses
+func (g *GBGenerator) maxRegister(fn *ir.Function) ir.Register {
+	max := fn.NextReg
+	see := func(r ir.Register) {
+		if r > max {
+			max = r
+		}
 	}
+	for _, p := range fn.Params {
+		see(p.Reg)
+	}
+	for _, l := range fn.Locals {
+		see(l.Reg)
+	}
+	for _, inst := range fn.Instructions {
+		see(inst.Dest)
+		see(inst.Src1)
+		see(inst.Src2)
+		see(inst.Src3)
+		for _, a := range inst.Args {
+			see(a)
+		}
+	}
+	return max
 }
 
-// generateEpilogue generates function epilogue
+// adjustSP moves SP by n bytes without touching DE, which holds results
+func (g *GBGenerator) adjustSP(n int) {
+	switch {
+	case n == 0:
+	case n >= -128 && n <= 127:
+		g.emit("    ADD SP, %d", n)
+	default:
+		g.emit("    LD HL, %d", n)
+		g.emit("    ADD HL, SP")
+		g.emit("    LD SP, HL")
+	}
+}
+
+// generateEpilogue drops the frame and returns; DE holds the result
 func (g *GBGenerator) generateEpilogue() {
-	// Restore registers if needed
-	if g.currentFunc.UsedRegisters != 0 {
+	g.adjustSP(g.frame)
+	if g.currentFunc.IsInterrupt {
 		g.emit("    POP HL")
 		g.emit("    POP DE")
 		g.emit("    POP BC")
+		g.emit("    POP AF")
+		g.emit("    RETI")
+		return
 	}
 	g.emit("    RET")
 }
 
 // generateInstruction generates code for a single instruction
-func (g *GBGenerator) generateInstruction(inst *ir.Instruction, index int) error {
+func (g *GBGenerator) generateInstruction(inst *ir.Instruction) error {
 	switch inst.Op {
-	case ir.OpLoadConst:
-		return g.generateLoadConst(inst)
+	case ir.OpNop:
+		return nil
+	case ir.OpSourceMark:
+		g.emit("; %s", inst.String())
+	case ir.OpLabel:
+		g.emit("%s:", g.label(inst.Label))
+
+	case ir.OpLoadConst, ir.OpSMCLoadConst:
+		g.emit("    LD DE, %d", inst.Imm&0xFFFF)
+		g.store(inst.Dest)
+	case ir.OpMove:
+		g.load(inst.Src1)
+		g.store(inst.Dest)
 	case ir.OpLoadVar:
-		return g.generateLoadVar(inst)
+		g.loadSymbol(inst.Symbol)
+		g.store(inst.Dest)
 	case ir.OpStoreVar:
-		return g.generateStoreVar(inst)
+		g.load(inst.Src1)
+		if inst.Symbol == "" {
+			g.store(inst.Dest) // Local by register
+		} else {
+			g.storeSymbol(inst.Symbol)
+		}
+	case ir.OpLoadParam:
+		g.address(g.paramOffset(int(inst.Src1)))
+		g.emit("    LD E, (HL) ; %s", inst.Symbol)
+		g.emit("    INC HL")
+		g.emit("    LD D, (HL)")
+		g.store(inst.Dest)
+	case ir.OpLoadAddr:
+		g.loadAddress(inst.Symbol)
+		g.emit("    LD D, H")
+		g.emit("    LD E, L")
+		g.store(inst.Dest)
+	case ir.OpLoadLabel, ir.OpLoadString:
+		g.emit("    LD DE, %s", g.symbol(inst.Symbol))
+		g.store(inst.Dest)
+
 	case ir.OpAdd:
-		return g.generateAdd(inst)
+		g.loadOperands(inst.Src1, inst.Src2)
+		g.emit("    LD H, D")
+		g.emit("    LD L, E")
+		g.emit("    ADD HL, BC")
+		g.emit("    LD D, H")
+		g.emit("    LD E, L")
+		g.narrow(inst.Type)
+		g.store(inst.Dest)
 	case ir.OpSub:
-		return g.generateSub(inst)
+		// No SBC HL,rr: subtract a byte at a time
+		g.loadOperands(inst.Src1, inst.Src2)
+		g.bytewise("SUB", "SBC A,")
+		g.narrow(inst.Type)
+		g.store(inst.Dest)
+	case ir.OpMul:
+		g.loadOperands(inst.Src1, inst.Src2)
+		g.callHelper("__mul16")
+		g.narrow(inst.Type)
+		g.store(inst.Dest)
+	case ir.OpDiv, ir.OpMod:
+		g.loadOperands(inst.Src1, inst.Src2)
+		g.callHelper("__div16")
+		if inst.Op == ir.OpMod {
+			g.emit("    LD D, B")
+			g.emit("    LD E, C")
+		}
+		g.narrow(inst.Type)
+		g.store(inst.Dest)
+	case ir.OpInc:
+		g.load(inst.Src1)
+		g.emit("    INC DE")
+		g.narrow(inst.Type)
+		g.store(inst.Dest)
+	case ir.OpDec:
+		g.load(inst.Src1)
+		g.emit("    DEC DE")
+		g.narrow(inst.Type)
+		g.store(inst.Dest)
+	case ir.OpNeg:
+		g.load(inst.Src1)
+		g.emit("    XOR A")
+		g.emit("    SUB E")
+		g.emit("    LD E, A")
+		g.emit("    LD A, 0")
+		g.emit("    SBC A, D")
+		g.emit("    LD D, A")
+		g.narrow(inst.Type)
+		g.store(inst.Dest)
+
+	case ir.OpAnd, ir.OpOr, ir.OpXor:
+		mnemonic := map[ir.Opcode]string{ir.OpAnd: "AND", ir.OpOr: "OR", ir.OpXor: "XOR"}[inst.Op]
+		g.loadOperands(inst.Src1, inst.Src2)
+		g.bytewise(mnemonic, mnemonic)
+		g.store(inst.Dest)
+	case ir.OpNot:
+		g.load(inst.Src1)
+		g.emit("    LD A, E")
+		g.emit("    CPL")
+		g.emit("    LD E, A")
+		g.emit("    LD A, D")
+		g.emit("    CPL")
+		g.emit("    LD D, A")
+		g.narrow(inst.Type)
+		g.store(inst.Dest)
+	case ir.OpShl, ir.OpShr:
+		g.generateShift(inst)
+	case ir.OpLogicalAnd, ir.OpLogicalOr:
+		g.generateLogical(inst)
+
+	case ir.OpEq, ir.OpNe, ir.OpLt, ir.OpGt, ir.OpLe, ir.OpGe:
+		g.generateComparison(inst)
+
+	case ir.OpJump:
+		g.emit("    JP %s", g.label(inst.Label))
+	case ir.OpJumpIf, ir.OpJumpIfNotZero:
+		g.test(inst.Src1)
+		g.emit("    JP NZ, %s", g.label(jumpTarget(inst)))
+	case ir.OpJumpIfNot, ir.OpJumpIfZero:
+		g.test(inst.Src1)
+		g.emit("    JP Z, %s", g.label(jumpTarget(inst)))
+
 	case ir.OpCall:
-		return g.generateCall(inst)
+		g.generateCall(inst)
+	case ir.OpCallIndirect:
+		g.pushArgs(inst.Args)
+		g.load(inst.Src1)
+		g.emit("    LD H, D")
+		g.emit("    LD L, E")
+		g.callHelper("__call_hl")
+		g.popArgs(inst.Args)
+		if inst.Dest != 0 {
+			g.store(inst.Dest)
+		}
 	case ir.OpReturn:
-		return g.generateReturn(inst)
+		if inst.Src1 != 0 {
+			g.load(inst.Src1)
+		}
+		g.generateEpilogue()
+
+	case ir.OpLoadPtr, ir.OpLoad:
+		g.load(inst.Src1)
+		g.emit("    LD H, D")
+		g.emit("    LD L, E")
+		g.loadIndirect(inst.Type)
+		g.store(inst.Dest)
+	case ir.OpStorePtr, ir.OpStore:
+		g.loadOperands(inst.Src1, inst.Src2)
+		g.emit("    LD H, D")
+		g.emit("    LD L, E")
+		g.storeIndirect(inst.Type)
+	case ir.OpLoadField:
+		g.load(inst.Src1)
+		g.emit("    LD HL, %d", inst.Imm)
+		g.emit("    ADD HL, DE")
+		g.loadIndirect(inst.Type)
+		g.store(inst.Dest)
+	case ir.OpStoreField:
+		g.loadOperands(inst.Src1, inst.Src2)
+		g.emit("    LD HL, %d", inst.Imm)
+		g.emit("    ADD HL, DE")
+		g.storeIndirect(inst.Type)
+	case ir.OpLoadIndex:
+		g.elementAddress(inst)
+		g.loadIndirect(inst.Type)
+		g.store(inst.Dest)
+	case ir.OpStoreIndex:
+		g.elementAddress(inst)
+		g.emit("    PUSH HL")
+		g.pushed += 2
+		g.loadBC(inst.Src3)
+		g.emit("    POP HL")
+		g.pushed -= 2
+		g.storeIndirect(inst.Type)
+
 	case ir.OpPrint:
-		return g.generatePrint(inst)
+		g.load(inst.Src1)
+		g.emit("    LD A, E")
+		g.callHelper("print_char")
 	case ir.OpPrintU8:
-		return g.generatePrintU8(inst)
+		g.load(inst.Src1)
+		g.callHelper("print_u8_decimal")
+	case ir.OpPrintU16:
+		g.load(inst.Src1)
+		g.callHelper("print_u16_decimal")
+	case ir.OpPrintI8:
+		g.load(inst.Src1)
+		g.callHelper("print_i8_decimal")
+	case ir.OpPrintI16:
+		g.load(inst.Src1)
+		g.callHelper("print_i16_decimal")
+	case ir.OpPrintBool:
+		g.load(inst.Src1)
+		g.callHelper("print_bool")
+	case ir.OpPrintString:
+		g.load(inst.Src1)
+		g.emit("    LD H, D")
+		g.emit("    LD L, E")
+		g.callHelper("print_string")
 	case ir.OpPrintStringDirect:
-		return g.generatePrintStringDirect(inst)
+		if inst.Comment != "" {
+			g.emit("    ; %s", inst.Comment)
+		}
+		for i := 0; i < len(inst.Symbol); i++ {
+			g.emit("    LD A, $%02X", inst.Symbol[i])
+			g.callHelper("print_char")
+		}
+
 	case ir.OpAsm:
-		return g.generateAsm(inst)
+		if inst.AsmName != "" {
+			g.emit("%s:", inst.AsmName)
+		}
+		for _, line := range strings.Split(inst.AsmCode, "\n") {
+			if strings.TrimSpace(line) != "" {
+				g.emit("    %s", strings.TrimSpace(line))
+			}
+		}
+
+	case ir.OpPatchTemplate, ir.OpPatchTarget, ir.OpPatchParam:
+		// The code runs from ROM, so nothing can be patched; the call
+		// that follows passes its arguments on the stack instead
+		g.emit("    ; %s skipped (no SMC)", inst.Op)
+
 	default:
-		// For now, emit a comment for unsupported operations
-		g.emit("    ; TODO: %s", inst.Op)
-		return nil
+		return fmt.Errorf("unsupported operation on Game Boy: %s", inst.Op)
+	}
+	return nil
+}
+
+// bytewise combines BC into DE a byte at a time through A, with op on the
+// low bytes and carryOp on the high ones
+func (g *GBGenerator) bytewise(op, carryOp string) {
+	g.emit("    LD A, E")
+	g.emit("    %s C", op)
+	g.emit("    LD E, A")
+	g.emit("    LD A, D")
+	g.emit("    %s B", carryOp)
+	g.emit("    LD D, A")
+}
+
+// test sets Z when a virtual register is zero
+func (g *GBGenerator) test(reg ir.Register) {
+	g.load(reg)
+	g.emit("    LD A, E")
+	g.emit("    OR D")
+}
+
+// elementAddress loads HL with the address of element Src2 of the array
+// at Src1
+func (g *GBGenerator) elementAddress(inst *ir.Instruction) {
+	g.loadOperands(inst.Src1, inst.Src2)
+	if inst.Type != nil && inst.Type.Size() == 2 {
+		g.emit("    SLA C")
+		g.emit("    RL B")
+	}
+	g.emit("    LD H, D")
+	g.emit("    LD L, E")
+	g.emit("    ADD HL, BC")
+}
+
+// generateShift shifts Src1 by the count in Src2, one bit per loop
+func (g *GBGenerator) generateShift(inst *ir.Instruction) {
+	loop := g.newLabel("shift")
+	done := g.newLabel("shift_done")
+	g.loadOperands(inst.Src1, inst.Src2)
+	g.emit("    LD A, C")
+	g.emit("    OR A")
+	g.emit("    JR Z, %s", done)
+	g.emit("%s:", loop)
+	switch {
+	case inst.Op == ir.OpShl:
+		g.emit("    SLA E")
+		g.emit("    RL D")
+	case isSignedType(inst.Type):
+		// 8-bit values are sign-extended, so this works for both widths
+		g.emit("    SRA D")
+		g.emit("    RR E")
+	default:
+		g.emit("    SRL D")
+		g.emit("    RR E")
+	}
+	g.emit("    DEC C")
+	g.emit("    JR NZ, %s", loop)
+	g.emit("%s:", done)
+	g.narrow(inst.Type)
+	g.store(inst.Dest)
+}
+
+// generateLogical stores Src1 && Src2 or Src1 || Src2 as 0 or 1
+func (g *GBGenerator) generateLogical(inst *ir.Instruction) {
+	short := g.newLabel("logic_short")
+	done := g.newLabel("logic_done")
+	branch, result, other := "Z", 0, 1 // && short-circuits to false
+	if inst.Op == ir.OpLogicalOr {
+		branch, result, other = "NZ", 1, 0
 	}
+	g.test(inst.Src1)
+	g.emit("    JR %s, %s", branch, short)
+	g.test(inst.Src2)
+	g.emit("    JR %s, %s", branch, short)
+	g.emit("    LD DE, %d", other)
+	g.emit("    JR %s", done)
+	g.emit("%s:", short)
+	g.emit("    LD DE, %d", result)
+	g.emit("%s:", done)
+	g.store(inst.Dest)
 }
 
-// generateLoadConst generates code for loading a constant
-func (g *GBGenerator) generateLoadConst(inst *ir.Instruction) error {
-	// For now, use simple register allocation
-	value := inst.Imm
-	
-	if value <= 255 {
-		g.emit("    LD A, %d", value)
-		g.emit("    ; Store to r%d", inst.Dest)
+// generateComparison stores the result of a comparison as 0 or 1. Operands
+// are held extended to 16 bits, so one subtraction covers both widths;
+// flipping the sign bits makes a signed comparison unsigned.
+func (g *GBGenerator) generateComparison(inst *ir.Instruction) {
+	left, right := inst.Src1, inst.Src2
+	if inst.Op == ir.OpGt || inst.Op == ir.OpLe {
+		left, right = right, left // a > b is b < a
+	}
+	g.loadOperands(left, right)
+
+	var skip string // Condition that leaves the result 0
+	switch inst.Op {
+	case ir.OpEq, ir.OpNe:
+		g.emit("    LD A, E")
+		g.emit("    SUB C")
+		g.emit("    LD L, A")
+		g.emit("    LD A, D")
+		g.emit("    SBC A, B")
+		g.emit("    OR L")
+		skip = map[ir.Opcode]string{ir.OpEq: "NZ", ir.OpNe: "Z"}[inst.Op]
+	default:
+		if isSignedType(inst.Type) {
+			g.emit("    LD A, D")
+			g.emit("    XOR $80")
+			g.emit("    LD D, A")
+			g.emit("    LD A, B")
+			g.emit("    XOR $80")
+			g.emit("    LD B, A")
+		}
+		g.emit("    LD A, E")
+		g.emit("    SUB C")
+		g.emit("    LD A, D")
+		g.emit("    SBC A, B")
+		skip = "NC" // Carry: left < right
+		if inst.Op == ir.OpLe || inst.Op == ir.OpGe {
+			skip = "C"
+		}
+	}
+
+	done := g.newLabel("cmp_done")
+	g.emit("    LD DE, 0") // Leaves the flags alone
+	g.emit("    JR %s, %s", skip, done)
+	g.emit("    INC E")
+	g.emit("%s:", done)
+	g.store(inst.Dest)
+}
+
+// generateCall calls a module function with its arguments on the stack, or
+// a runtime routine with its argument in DE (HL for strings)
+func (g *GBGenerator) generateCall(inst *ir.Instruction) {
+	if !g.functions[inst.Symbol] && gbRuntime[inst.Symbol] {
+		if len(inst.Args) > 0 {
+			g.load(inst.Args[0])
+			switch inst.Symbol {
+			case "print_string":
+				g.emit("    LD H, D")
+				g.emit("    LD L, E")
+			case "print_char":
+				g.emit("    LD A, E")
+			}
+		}
+		g.callHelper(inst.Symbol)
+		return
+	}
+
+	g.pushArgs(inst.Args)
+	g.emit("    CALL %s", g.symbol(inst.Symbol))
+	g.popArgs(inst.Args)
+	if inst.Dest != 0 {
+		g.store(inst.Dest)
+	}
+}
+
+// pushArgs pushes call arguments last first, so parameter i is at
+// SP+frame+2+2i in the callee
+func (g *GBGenerator) pushArgs(args []ir.Register) {
+	for i := len(args) - 1; i >= 0; i-- {
+		g.load(args[i])
+		g.emit("    PUSH DE")
+		g.pushed += 2
+	}
+}
+
+// popArgs drops the arguments after a call without touching DE
+func (g *GBGenerator) popArgs(args []ir.Register) {
+	g.adjustSP(2 * len(args))
+	g.pushed -= 2 * len(args)
+}
+
+// callHelper calls a runtime routine and marks it for output
+func (g *GBGenerator) callHelper(name string) {
+	g.usedHelpers[name] = true
+	g.emit("    CALL %s", name)
+}
+
+// offset returns the frame offset of a virtual register's slot
+func (g *GBGenerator) offset(reg ir.Register) int {
+	return 2 * (int(reg) - 1)
+}
+
+// paramOffset returns the frame offset of parameter i
+func (g *GBGenerator) paramOffset(i int) int {
+	return g.frame + g.saved + 2 + 2*i
+}
+
+// address loads HL with the address of a frame offset
+func (g *GBGenerator) address(offset int) {
+	offset += g.pushed
+	if offset <= 127 {
+		g.emit("    LD HL, SP+%d", offset)
+		return
+	}
+	g.emit("    LD HL, %d", offset)
+	g.emit("    ADD HL, SP")
+}
+
+// load loads a virtual register into DE
+func (g *GBGenerator) load(reg ir.Register) {
+	if reg == ir.RegZero {
+		g.emit("    LD DE, 0")
+		return
+	}
+	g.address(g.offset(reg))
+	g.emit("    LD E, (HL)")
+	g.emit("    INC HL")
+	g.emit("    LD D, (HL)")
+}
+
+// loadBC loads a virtual register into BC
+func (g *GBGenerator) loadBC(reg ir.Register) {
+	if reg == ir.RegZero {
+		g.emit("    LD BC, 0")
+		return
+	}
+	g.address(g.offset(reg))
+	g.emit("    LD C, (HL)")
+	g.emit("    INC HL")
+	g.emit("    LD B, (HL)")
+}
+
+// loadOperands loads the first operand into DE and the second into BC
+func (g *GBGenerator) loadOperands(first, second ir.Register) {
+	g.loadBC(second)
+	g.load(first)
+}
+
+// store stores DE into a virtual register
+func (g *GBGenerator) store(reg ir.Register) {
+	if reg == ir.RegZero {
+		return
+	}
+	g.address(g.offset(reg))
+	g.emit("    LD (HL), E")
+	g.emit("    INC HL")
+	g.emit("    LD (HL), D")
+}
+
+// narrow truncates DE to an 8-bit type, extending it back to 16 bits
+func (g *GBGenerator) narrow(t ir.Type) {
+	if t == nil || t.Size() != 1 {
+		return
+	}
+	if isSignedType(t) {
+		g.emit("    LD A, E")
+		g.emit("    ADD A, A")
+		g.emit("    SBC A, A")
+		g.emit("    LD D, A")
 	} else {
-		g.emit("    LD HL, %d", value)
-		g.emit("    ; Store to r%d", inst.Dest)
+		g.emit("    LD D, 0")
 	}
-	
-	return nil
 }
 
-// generateAdd generates addition
-func (g *GBGenerator) generateAdd(inst *ir.Instruction) error {
-	// Simplified for now
-	g.emit("    ; ADD r%d + r%d -> r%d", inst.Src1, inst.Src2, inst.Dest)
-	g.emit("    ; TODO: Implement register allocation")
-	
-	return nil
+// loadIndirect loads a value of type t from (HL) into DE
+func (g *GBGenerator) loadIndirect(t ir.Type) {
+	g.emit("    LD E, (HL)")
+	if t != nil && t.Size() == 1 {
+		g.narrow(t)
+		return
+	}
+	g.emit("    INC HL")
+	g.emit("    LD D, (HL)")
 }
 
-// generatePrintHelpers generates GB-specific print routines
-func (g *GBGenerator) generatePrintHelpers() {
-	g.emit("\n; Print helpers for Game Boy")
-	
-	// Print character routine using GB's tile system
-	g.emit("print_char:")
-	g.emit("    ; Wait for VBlank")
-	g.emit("    LD HL, $FF44  ; LY register")
-	g.emit(".wait_vblank:")
-	g.emit("    LD A, [HL]")
-	g.emit("    CP 144")
-	g.emit("    JR C, .wait_vblank")
-	g.emit("    ; Character in A, write to tile map")
-	g.emit("    ; This is a simplified version")
-	g.emit("    RET")
-	
-	// Print hex byte
-	g.emit("\nprint_hex:")
-	g.emit("    PUSH AF")
-	g.emit("    SWAP A")
-	g.emit("    CALL print_nibble")
-	g.emit("    POP AF")
-	g.emit("    CALL print_nibble")
-	g.emit("    RET")
-	
-	g.emit("\nprint_nibble:")
-	g.emit("    AND $0F")
-	g.emit("    CP 10")
-	g.emit("    JR C, .digit")
-	g.emit("    ADD A, 'A' - 10")
-	g.emit("    JR print_char")
-	g.emit(".digit:")
-	g.emit("    ADD A, '0'")
-	g.emit("    JR print_char")
-}
-
-// Helper methods for other operations...
-
-func (g *GBGenerator) generateLoadVar(inst *ir.Instruction) error {
-	// Simplified for now
-	g.emit("    ; Load var %s to r%d", inst.Symbol, inst.Dest)
-	return nil
+// storeIndirect stores BC as a value of type t to (HL)
+func (g *GBGenerator) storeIndirect(t ir.Type) {
+	g.emit("    LD (HL), C")
+	if t != nil && t.Size() == 1 {
+		return
+	}
+	g.emit("    INC HL")
+	g.emit("    LD (HL), B")
 }
 
-func (g *GBGenerator) generateStoreVar(inst *ir.Instruction) error {
-	// Simplified for now
-	g.emit("    ; Store r%d to var %s", inst.Src1, inst.Symbol)
-	return nil
+// lookup resolves a variable name to its frame offset, or returns ok=false
+// for globals
+func (g *GBGenerator) lookup(name string) (offset int, t ir.Type, ok bool) {
+	for i, p := range g.currentFunc.Params {
+		if p.Name == name {
+			return g.paramOffset(i), p.Type, true
+		}
+	}
+	for _, l := range g.currentFunc.Locals {
+		if l.Name == name {
+			if off, big := g.bigLocals[name]; big {
+				return off, l.Type, true
+			}
+			return g.offset(l.Reg), l.Type, true
+		}
+	}
+	return 0, nil, false
 }
 
-func (g *GBGenerator) generateSub(inst *ir.Instruction) error {
-	// Similar to add but with SUB
-	g.emit("    ; SUB r%d - r%d -> r%d", inst.Src1, inst.Src2, inst.Dest)
+// globalType returns the type of a global, or nil if there is none
+func (g *GBGenerator) globalType(name string) ir.Type {
+	for _, global := range g.module.Globals {
+		if global.Name == name {
+			return global.Type
+		}
+	}
 	return nil
 }
 
-func (g *GBGenerator) generateCall(inst *ir.Instruction) error {
-	g.emit("    CALL %s", inst.Symbol)
-	return nil
+// loadSymbol loads a variable into DE. Locals larger than a word load their
+// address, as arrays do.
+func (g *GBGenerator) loadSymbol(name string) {
+	if offset, _, ok := g.lookup(name); ok {
+		g.address(offset)
+		if _, big := g.bigLocals[name]; big {
+			g.emit("    LD D, H")
+			g.emit("    LD E, L")
+			return
+		}
+		g.emit("    LD E, (HL)")
+		g.emit("    INC HL")
+		g.emit("    LD D, (HL)")
+		return
+	}
+	t := g.globalType(name)
+	if t != nil && t.Size() > 2 {
+		g.emit("    LD DE, %s", g.symbol(name))
+		return
+	}
+	g.emit("    LD HL, %s", g.symbol(name))
+	g.loadIndirect(t)
 }
 
-func (g *GBGenerator) generateReturn(inst *ir.Instruction) error {
-	g.generateEpilogue()
-	return nil
+// storeSymbol stores DE into a variable
+func (g *GBGenerator) storeSymbol(name string) {
+	if offset, _, ok := g.lookup(name); ok {
+		g.address(offset)
+		g.emit("    LD (HL), E")
+		g.emit("    INC HL")
+		g.emit("    LD (HL), D")
+		return
+	}
+	g.emit("    LD HL, %s", g.symbol(name))
+	g.emit("    LD (HL), E")
+	if t := g.globalType(name); t != nil && t.Size() == 1 {
+		return
+	}
+	g.emit("    INC HL")
+	g.emit("    LD (HL), D")
 }
 
-func (g *GBGenerator) generatePrint(inst *ir.Instruction) error {
-	g.emit("    ; Print character")
-	g.emit("    CALL print_char")
-	return nil
+// loadAddress loads the address of a variable into HL
+func (g *GBGenerator) loadAddress(name string) {
+	if offset, _, ok := g.lookup(name); ok {
+		g.address(offset)
+		return
+	}
+	g.emit("    LD HL, %s", g.symbol(name))
 }
 
-func (g *GBGenerator) generatePrintU8(inst *ir.Instruction) error {
-	g.emit("    ; Print u8 as hex")
-	g.emit("    CALL print_hex")
-	return nil
+// symbol makes an IR name assembler-friendly
+func (g *GBGenerator) symbol(name string) string {
+	name = strings.TrimLeft(name, ".")
+	name = strings.ReplaceAll(name, ".", "_")
+	return strings.ReplaceAll(name, "$", "_")
 }
 
-func (g *GBGenerator) generatePrintStringDirect(inst *ir.Instruction) error {
-	g.emit("    ; Print string direct")
-	return nil
+// label scopes an IR label to the current function
+func (g *GBGenerator) label(name string) string {
+	return g.symbol(g.currentFunc.Name + "_" + name)
 }
 
-func (g *GBGenerator) generateAsm(inst *ir.Instruction) error {
-	// Pass through inline assembly, converting Z80 syntax to GB where needed
-	asmCode := inst.Symbol
-	
-	// Replace Z80-specific instructions with GB equivalents
-	asmCode = strings.ReplaceAll(asmCode, "EXX", "; EXX not available on GB")
-	asmCode = strings.ReplaceAll(asmCode, "EX AF,AF'", "; No shadow registers on GB")
-	
-	// Emit the assembly
-	lines := strings.Split(asmCode, "\n")
-	for _, line := range lines {
-		if strings.TrimSpace(line) != "" {
-			g.emit("    %s", line)
+// newLabel returns a fresh label in the current function
+func (g *GBGenerator) newLabel(prefix string) string {
+	g.labelCounter++
+	return fmt.Sprintf("%s_%s_%d", g.symbol(g.currentFunc.Name), prefix, g.labelCounter)
+}
+
+// gbHelperDeps lists the routines each runtime routine calls
+var gbHelperDeps = map[string][]string{
+	"print_u8_decimal":  {"print_decimal"},
+	"print_u16_decimal": {"print_decimal"},
+	"print_i8_decimal":  {"print_decimal"},
+	"print_i16_decimal": {"print_decimal"},
+	"print_decimal":     {"__div16", "print_char"},
+	"print_hex_u8":      {"print_char"},
+	"print_bool":        {"print_string"},
+	"print_string":      {"print_char"},
+	"print_newline":     {"print_char"},
+	"lcd_off":           {"wait_vblank"},
+}
+
+// gbHelpers holds the runtime routines. Text goes to the background map
+// through the font gb_init_text loads into tiles 32-127, so a character's
+// tile is its ASCII code. The screen shows 20x18 of the 32x32 map; text
+// wraps back to the top, clearing each line it starts.
+var gbHelpers = map[string][]string{
+	"wait_vblank": {
+		"wait_vblank:            ; Waits for VBlank (lines 144-153)",
+		"    LDH A, (LY)",
+		"    CP 144",
+		"    JR C, wait_vblank",
+		"    RET",
+	},
+	"lcd_off": {
+		"lcd_off:                ; Turns the LCD off in VBlank, freeing VRAM",
+		"    LDH A, (LCDC)",
+		"    BIT 7, A",
+		"    RET Z               ; Already off (LY would stay 0)",
+		"    CALL wait_vblank",
+		"    LDH A, (LCDC)",
+		"    RES 7, A",
+		"    LDH (LCDC), A",
+		"    RET",
+	},
+	"lcd_on": {
+		"lcd_on:                 ; LCD and background on, tiles at $8000",
+		"    LD A, $91",
+		"    LDH (LCDC), A",
+		"    RET",
+	},
+	"gb_init_text": {
+		"gb_init_text:           ; LCD off: loads the font, blanks the map",
+		"    LD HL, $8200        ; Tile 32",
+		"    LD DE, gb_font",
+		"    LD BC, 768",
+		"gb_init_text_font:",
+		"    LD A, (DE)",
+		"    INC DE",
+		"    LD (HL+), A         ; Both bit planes: shade 3",
+		"    LD (HL+), A",
+		"    DEC BC",
+		"    LD A, B",
+		"    OR C",
+		"    JR NZ, gb_init_text_font",
+		"    LD HL, $9800",
+		"    LD BC, 1024",
+		"gb_init_text_map:",
+		"    LD A, $20",
+		"    LD (HL+), A",
+		"    DEC BC",
+		"    LD A, B",
+		"    OR C",
+		"    JR NZ, gb_init_text_map",
+		"    XOR A",
+		"    LDH (SCX), A",
+		"    LDH (SCY), A",
+		"    LD HL, gb_cursor",
+		"    LD (HL), $00",
+		"    INC HL",
+		"    LD (HL), $98",
+		"    RET",
+	},
+	"print_char": {
+		"print_char:             ; A = character",
+		"    PUSH BC",
+		"    PUSH DE",
+		"    PUSH HL",
+		"    LD B, A",
+		"    LD HL, gb_cursor",
+		"    LD E, (HL)",
+		"    INC HL",
+		"    LD D, (HL)",
+		"    CP 10",
+		"    JR Z, print_char_newline",
+		"    LD H, D",
+		"    LD L, E",
+		"print_char_wait:",
+		"    LDH A, (STAT)       ; VRAM is free outside mode 3",
+		"    AND 2",
+		"    JR NZ, print_char_wait",
+		"    LD (HL), B",
+		"    INC DE",
+		"    LD A, E",
+		"    AND 31",
+		"    CP 20",
+		"    JR NZ, print_char_done",
+		"print_char_newline:",
+		"    LD A, E",
+		"    AND $E0",
+		"    ADD A, 32",
+		"    LD E, A",
+		"    LD A, D",
+		"    ADC A, 0",
+		"    LD D, A",
+		"    CP $9A              ; Past line 17 ($9A40)?",
+		"    JR C, print_char_clear",
+		"    LD A, E",
+		"    CP $40",
+		"    JR C, print_char_clear",
+		"    LD DE, $9800",
+		"print_char_clear:",
+		"    LD H, D",
+		"    LD L, E",
+		"    LD C, 20",
+		"print_char_clear_loop:",
+		"    LDH A, (STAT)",
+		"    AND 2",
+		"    JR NZ, print_char_clear_loop",
+		"    LD (HL), $20",
+		"    INC HL",
+		"    DEC C",
+		"    JR NZ, print_char_clear_loop",
+		"print_char_done:",
+		"    LD HL, gb_cursor",
+		"    LD (HL), E",
+		"    INC HL",
+		"    LD (HL), D",
+		"    POP HL",
+		"    POP DE",
+		"    POP BC",
+		"    RET",
+	},
+	"print_newline": {
+		"print_newline:",
+		"    LD A, 10",
+		"    JP print_char",
+	},
+	"print_string": {
+		"print_string:           ; HL = length-prefixed string",
+		"    PUSH BC",
+		"    LD A, (HL+)         ; Length",
+		"    OR A",
+		"    JR Z, print_string_done",
+		"    LD B, A",
+		"print_string_loop:",
+		"    LD A, (HL+)",
+		"    CALL print_char",
+		"    DEC B",
+		"    JR NZ, print_string_loop",
+		"print_string_done:",
+		"    POP BC",
+		"    RET",
+	},
+	"print_bool": {
+		"print_bool:             ; E = bool",
+		"    LD HL, print_bool_true",
+		"    LD A, E",
+		"    OR A",
+		"    JR NZ, print_bool_out",
+		"    LD HL, print_bool_false",
+		"print_bool_out:",
+		"    JP print_string",
+		"print_bool_true:",
+		"    DB 4, \"true\"",
+		"print_bool_false:",
+		"    DB 5, \"false\"",
+	},
+	"print_hex_u8": {
+		"print_hex_u8:           ; E = value",
+		"    LD A, E",
+		"    SWAP A",
+		"    CALL print_hex_digit",
+		"    LD A, E",
+		"print_hex_digit:",
+		"    AND $0F",
+		"    ADD A, $30",
+		"    CP $3A",
+		"    JR C, print_hex_digit_out",
+		"    ADD A, 7",
+		"print_hex_digit_out:",
+		"    JP print_char",
+	},
+	"print_decimal": {
+		"print_i8_decimal:       ; E = value",
+		"    LD A, E",
+		"    ADD A, A",
+		"    SBC A, A",
+		"    LD D, A",
+		"print_i16_decimal:      ; DE = value",
+		"    BIT 7, D",
+		"    JR Z, print_u16_decimal",
+		"    LD A, $2D           ; '-'",
+		"    CALL print_char",
+		"    XOR A",
+		"    SUB E",
+		"    LD E, A",
+		"    LD A, 0",
+		"    SBC A, D",
+		"    LD D, A",
+		"    JR print_u16_decimal",
+		"print_u8_decimal:       ; E = value",
+		"    LD D, 0",
+		"print_u16_decimal:      ; DE = value",
+		"    PUSH BC",
+		"    PUSH HL",
+		"    LD BC, $FFFF",
+		"    PUSH BC             ; End of the digits",
+		"print_decimal_split:",
+		"    LD BC, 10",
+		"    CALL __div16        ; DE = DE / 10, BC = digit",
+		"    PUSH BC",
+		"    LD A, D",
+		"    OR E",
+		"    JR NZ, print_decimal_split",
+		"print_decimal_out:",
+		"    POP BC",
+		"    BIT 7, B",
+		"    JR NZ, print_decimal_done",
+		"    LD A, C",
+		"    ADD A, $30",
+		"    CALL print_char",
+		"    JR print_decimal_out",
+		"print_decimal_done:",
+		"    POP HL",
+		"    POP BC",
+		"    RET",
+	},
+	"__mul16": {
+		"__mul16:                ; DE = DE * BC (low 16 bits)",
+		"    LD HL, 0",
+		"    LD A, 16",
+		"__mul16_loop:",
+		"    ADD HL, HL",
+		"    SLA E",
+		"    RL D",
+		"    JR NC, __mul16_next",
+		"    ADD HL, BC",
+		"__mul16_next:",
+		"    DEC A",
+		"    JR NZ, __mul16_loop",
+		"    LD D, H",
+		"    LD E, L",
+		"    RET",
+	},
+	"__div16": {
+		"__div16:                ; DE = DE / BC, BC = DE % BC (unsigned)",
+		"    LD HL, 0            ; Remainder",
+		"    LD A, 16",
+		"__div16_loop:",
+		"    PUSH AF",
+		"    SLA E",
+		"    RL D",
+		"    RL L",
+		"    RL H",
+		"    JR C, __div16_sub   ; Past 16 bits: above any divisor",
+		"    LD A, L",
+		"    SUB C",
+		"    LD A, H",
+		"    SBC A, B",
+		"    JR C, __div16_next",
+		"__div16_sub:",
+		"    LD A, L",
+		"    SUB C",
+		"    LD L, A",
+		"    LD A, H",
+		"    SBC A, B",
+		"    LD H, A",
+		"    INC E",
+		"__div16_next:",
+		"    POP AF",
+		"    DEC A",
+		"    JR NZ, __div16_loop",
+		"    LD B, H",
+		"    LD C, L",
+		"    RET",
+	},
+	"__call_hl": {
+		"__call_hl:              ; Calls the routine at HL",
+		"    JP (HL)",
+	},
+}
+
+// resolveHelpers adds the routines the used ones call
+func (g *GBGenerator) resolveHelpers() {
+	var pending []string
+	for name := range g.usedHelpers {
+		pending = append(pending, name)
+	}
+	for len(pending) > 0 {
+		name := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, dep := range gbHelperDeps[name] {
+			if !g.usedHelpers[dep] {
+				g.usedHelpers[dep] = true
+				pending = append(pending, dep)
+			}
+		}
+	}
+}
+
+// generateHelpers appends the runtime routines the code calls
+func (g *GBGenerator) generateHelpers() {
+	g.resolveHelpers()
+	var names []string
+	for name := range g.usedHelpers {
+		if _, ok := gbHelpers[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	g.emit("\n; Runtime routines")
+	for _, name := range names {
+		g.emit("")
+		for _, line := range gbHelpers[name] {
+			g.emit("%s", line)
 		}
 	}
-	
-	return nil
+}
+
+// generateFont emits the font gb_init_text loads: 8 rows of one bit per
+// pixel for each of ASCII 32-127, drawn from a 5x7 font
+func (g *GBGenerator) generateFont() {
+	g.emit("gb_font:")
+	for i, glyph := range gbFont5x7 {
+		var rows [8]string
+		for y := range rows {
+			var row byte
+			for x, column := range glyph {
+				if column>>y&1 != 0 {
+					row |= 0x40 >> x // One pixel in from the left
+				}
+			}
+			rows[y] = fmt.Sprintf("$%02X", row)
+		}
+		g.emit("    DB %s ; %q", strings.Join(rows[:], ", "), rune(32+i))
+	}
+}
+
+// gbFont5x7 is a 5x7 font for ASCII 32-127, five columns per character
+// with the top row in bit 0
+var gbFont5x7 = [96][5]byte{
+	{0x00, 0x00, 0x00, 0x00, 0x00}, // space
+	{0x00, 0x00, 0x5F, 0x00, 0x00}, // !
+	{0x00, 0x07, 0x00, 0x07, 0x00}, // "
+	{0x14, 0x7F, 0x14, 0x7F, 0x14}, // #
+	{0x24, 0x2A, 0x7F, 0x2A, 0x12}, // $
+	{0x23, 0x13, 0x08, 0x64, 0x62}, // %
+	{0x36, 0x49, 0x55, 0x22, 0x50}, // &
+	{0x00, 0x05, 0x03, 0x00, 0x00}, // '
+	{0x00, 0x1C, 0x22, 0x41, 0x00}, // (
+	{0x00, 0x41, 0x22, 0x1C, 0x00}, // )
+	{0x08, 0x2A, 0x1C, 0x2A, 0x08}, // *
+	{0x08, 0x08, 0x3E, 0x08, 0x08}, // +
+	{0x00, 0x50, 0x30, 0x00, 0x00}, // ,
+	{0x08, 0x08, 0x08, 0x08, 0x08}, // -
+	{0x00, 0x60, 0x60, 0x00, 0x00}, // .
+	{0x20, 0x10, 0x08, 0x04, 0x02}, // /
+	{0x3E, 0x51, 0x49, 0x45, 0x3E}, // 0
+	{0x00, 0x42, 0x7F, 0x40, 0x00}, // 1
+	{0x42, 0x61, 0x51, 0x49, 0x46}, // 2
+	{0x21, 0x41, 0x45, 0x4B, 0x31}, // 3
+	{0x18, 0x14, 0x12, 0x7F, 0x10}, // 4
+	{0x27, 0x45, 0x45, 0x45, 0x39}, // 5
+	{0x3C, 0x4A, 0x49, 0x49, 0x30}, // 6
+	{0x01, 0x71, 0x09, 0x05, 0x03}, // 7
+	{0x36, 0x49, 0x49, 0x49, 0x36}, // 8
+	{0x06, 0x49, 0x49, 0x29, 0x1E}, // 9
+	{0x00, 0x36, 0x36, 0x00, 0x00}, // :
+	{0x00, 0x56, 0x36, 0x00, 0x00}, // ;
+	{0x08, 0x14, 0x22, 0x41, 0x00}, // <
+	{0x14, 0x14, 0x14, 0x14, 0x14}, // =
+	{0x00, 0x41, 0x22, 0x14, 0x08}, // >
+	{0x02, 0x01, 0x51, 0x09, 0x06}, // ?
+	{0x32, 0x49, 0x79, 0x41, 0x3E}, // @
+	{0x7E, 0x11, 0x11, 0x11, 0x7E}, // A
+	{0x7F, 0x49, 0x49, 0x49, 0x36}, // B
+	{0x3E, 0x41, 0x41, 0x41, 0x22}, // C
+	{0x7F, 0x41, 0x41, 0x22, 0x1C}, // D
+	{0x7F, 0x49, 0x49, 0x49, 0x41}, // E
+	{0x7F, 0x09, 0x09, 0x09, 0x01}, // F
+	{0x3E, 0x41, 0x49, 0x49, 0x7A}, // G
+	{0x7F, 0x08, 0x08, 0x08, 0x7F}, // H
+	{0x00, 0x41, 0x7F, 0x41, 0x00}, // I
+	{0x20, 0x40, 0x41, 0x3F, 0x01}, // J
+	{0x7F, 0x08, 0x14, 0x22, 0x41}, // K
+	{0x7F, 0x40, 0x40, 0x40, 0x40}, // L
+	{0x7F, 0x02, 0x0C, 0x02, 0x7F}, // M
+	{0x7F, 0x04, 0x08, 0x10, 0x7F}, // N
+	{0x3E, 0x41, 0x41, 0x41, 0x3E}, // O
+	{0x7F, 0x09, 0x09, 0x09, 0x06}, // P
+	{0x3E, 0x41, 0x51, 0x21, 0x5E}, // Q
+	{0x7F, 0x09, 0x19, 0x29, 0x46}, // R
+	{0x46, 0x49, 0x49, 0x49, 0x31}, // S
+	{0x01, 0x01, 0x7F, 0x01, 0x01}, // T
+	{0x3F, 0x40, 0x40, 0x40, 0x3F}, // U
+	{0x1F, 0x20, 0x40, 0x20, 0x1F}, // V
+	{0x3F, 0x40, 0x38, 0x40, 0x3F}, // W
+	{0x63, 0x14, 0x08, 0x14, 0x63}, // X
+	{0x07, 0x08, 0x70, 0x08, 0x07}, // Y
+	{0x61, 0x51, 0x49, 0x45, 0x43}, // Z
+	{0x00, 0x7F, 0x41, 0x41, 0x00}, // [
+	{0x02, 0x04, 0x08, 0x10, 0x20}, // backslash
+	{0x00, 0x41, 0x41, 0x7F, 0x00}, // ]
+	{0x04, 0x02, 0x01, 0x02, 0x04}, // ^
+	{0x40, 0x40, 0x40, 0x40, 0x40}, // _
+	{0x00, 0x01, 0x02, 0x04, 0x00}, // `
+	{0x20, 0x54, 0x54, 0x54, 0x78}, // a
+	{0x7F, 0x48, 0x44, 0x44, 0x38}, // b
+	{0x38, 0x44, 0x44, 0x44, 0x20}, // c
+	{0x38, 0x44, 0x44, 0x48, 0x7F}, // d
+	{0x38, 0x54, 0x54, 0x54, 0x18}, // e
+	{0x08, 0x7E, 0x09, 0x01, 0x02}, // f
+	{0x0C, 0x52, 0x52, 0x52, 0x3E}, // g
+	{0x7F, 0x08, 0x04, 0x04, 0x78}, // h
+	{0x00, 0x44, 0x7D, 0x40, 0x00}, // i
+	{0x20, 0x40, 0x44, 0x3D, 0x00}, // j
+	{0x7F, 0x10, 0x28, 0x44, 0x00}, // k
+	{0x00, 0x41, 0x7F, 0x40, 0x00}, // l
+	{0x7C, 0x04, 0x18, 0x04, 0x78}, // m
+	{0x7C, 0x08, 0x04, 0x04, 0x78}, // n
+	{0x38, 0x44, 0x44, 0x44, 0x38}, // o
+	{0x7C, 0x14, 0x14, 0x14, 0x08}, // p
+	{0x08, 0x14, 0x14, 0x18, 0x7C}, // q
+	{0x7C, 0x08, 0x04, 0x04, 0x08}, // r
+	{0x48, 0x54, 0x54, 0x54, 0x20}, // s
+	{0x04, 0x3F, 0x44, 0x40, 0x20}, // t
+	{0x3C, 0x40, 0x40, 0x20, 0x7C}, // u
+	{0x1C, 0x20, 0x40, 0x20, 0x1C}, // v
+	{0x3C, 0x40, 0x30, 0x40, 0x3C}, // w
+	{0x44, 0x28, 0x10, 0x28, 0x44}, // x
+	{0x0C, 0x50, 0x50, 0x50, 0x3C}, // y
+	{0x44, 0x64, 0x54, 0x4C, 0x44}, // z
+	{0x00, 0x08, 0x36, 0x41, 0x00}, // {
+	{0x00, 0x00, 0x7F, 0x00, 0x00}, // |
+	{0x00, 0x41, 0x36, 0x08, 0x00}, // }
+	{0x08, 0x04, 0x08, 0x10, 0x08}, // ~
+	{0x7F, 0x7F, 0x7F, 0x7F, 0x7F}, // DEL: a block
 }
 
 // emit writes a line to the output
 func (g *GBGenerator) emit(format string, args ...interface{}) {
-	if len(args) > 0 {
-		fmt.Fprintf(g.writer, format+"\n", args...)
-	} else {
-		fmt.Fprintf(g.writer, format+"\n")
-	}
-}
\ No newline at end of file
+	fmt.Fprintf(g.writer, format+"\n", args...)
+}
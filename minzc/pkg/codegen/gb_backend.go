@@ -2,10 +2,15 @@ package codegen
 
 import (
 	"bytes"
+
 	"github.com/minz/minzc/pkg/ir"
 )
 
-// GBBackend implements the Backend interface for Game Boy (LR35902) code generation
+// GBBackend implements the Backend interface for Game Boy (SM83) code generation.
+// The output assembles with mza -t gameboy into a bootable 32K cartridge:
+// - The code runs from ROM, so there is no SMC; variables are in stack frames
+// - No IX/IY, shadow registers or block instructions, but LD HL,SP+e
+// - Text is drawn on the background map with a built-in font
 type GBBackend struct {
 	BaseBackend
 }
@@ -17,13 +22,16 @@ func NewGBBackend(options *BackendOptions) Backend {
 	}
 	
 	// Configure GB-specific features
-	backend.SetFeature(FeatureSelfModifyingCode, true)
+	backend.SetFeature(FeatureSelfModifyingCode, false) // Code is in cartridge ROM
 	backend.SetFeature(FeatureInterrupts, true)
 	backend.SetFeature(FeatureShadowRegisters, false)  // No shadow registers on GB!
 	backend.SetFeature(Feature16BitPointers, true)
 	backend.SetFeature(Feature24BitPointers, false)
 	backend.SetFeature(FeatureFloatingPoint, false)
 	backend.SetFeature(FeatureFixedPoint, true)
+	backend.SetFeature(FeatureHardwareMultiply, false)
+	backend.SetFeature(FeatureHardwareDivide, false)
+	backend.SetFeature(FeatureIndirectCalls, true)
 	backend.SetFeature(FeatureInlineAssembly, true)
 	backend.SetFeature(FeatureBitManipulation, true)
 	backend.SetFeature(FeatureZeroPage, false)
@@ -49,11 +57,11 @@ func (b *GBBackend) Generate(module *ir.Module) (string, error) {
 		return "", err
 	}
 	
-	// Create a buffer to collect the generated code
 	var buf bytes.Buffer
-	
-	// Create the GB generator with the buffer
 	gen := NewGBGenerator(&buf)
+	if b.options != nil {
+		gen.deterministic = b.options.Deterministic
+	}
 	
 	// Generate the code
 	if err := gen.Generate(module); err != nil {
@@ -65,7 +73,7 @@ func (b *GBBackend) Generate(module *ir.Module) (string, error) {
 
 // GetFileExtension returns the file extension for Game Boy assembly
 func (b *GBBackend) GetFileExtension() string {
-	return ".gb.s"  // Use .gb.s to distinguish from regular assembly
+	return ".gb.s"  // mza source; the ROM is .gb
 }
 
 // SupportsFeature checks if the GB backend supports a specific feature
@@ -0,0 +1,117 @@
+package codegen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/parser"
+	"github.com/minz/minzc/pkg/semantic"
+	"github.com/minz/minzc/pkg/z80asm"
+)
+
+func TestGBBackend(t *testing.T) {
+	source := `fun mul(a: u8, b: u8) -> u8 {
+    return a * b;
+}
+
+fun scale(n: u16, k: u16) -> u16 {
+    return n * k / 3;
+}
+
+fun main() -> void {
+    let x: u8 = mul(6, 7);
+    let y: u16 = scale(300, 2);
+    if x > 40 {
+        print_u8(x);
+    }
+}
+`
+	path := filepath.Join(t.TempDir(), "game.minz")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := parser.NewAntlrParser().ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	module, err := semantic.NewAnalyzer().Analyze(file)
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	backend := GetBackend("gb", &BackendOptions{Deterministic: true})
+	if backend == nil {
+		t.Fatal("gb backend is not registered")
+	}
+	for feature, want := range map[string]bool{
+		FeatureSelfModifyingCode: false,
+		Feature16BitPointers:     true,
+		FeatureHardwareMultiply:  false,
+		FeatureHardwareDivide:    false,
+		FeatureShadowRegisters:   false,
+		FeatureIndirectCalls:     true,
+	} {
+		if got := backend.SupportsFeature(feature); got != want {
+			t.Errorf("SupportsFeature(%s) = %v, want %v", feature, got, want)
+		}
+	}
+
+	asm, err := backend.Generate(module)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, want := range []string{
+		"    SECTION HEADER, $0100",            // Cartridge entry point
+		"    LD HL, SP+",                       // Frame slots through SP
+		"    CALL __mul16\n",                   // No multiply instruction
+		"    CALL __div16\n",                   // or divide
+		"    PUSH DE\n",                        // Arguments on the stack
+		"    ADD SP, 4\n",                      // Caller drops them
+		"    CALL print_u8_decimal\n",          // Runtime call with DE
+		"print_u16_decimal:      ; DE = value", // and its routine
+		"    SECTION BSS",                      // Variables in work RAM
+	} {
+		if !strings.Contains(asm, want) {
+			t.Errorf("output is missing %q:\n%s", want, asm)
+		}
+	}
+	for _, unwanted := range []string{"; Generated:", "EX DE, HL", "(IX", "SMC enabled"} {
+		if strings.Contains(asm, unwanted) {
+			t.Errorf("output contains %q:\n%s", unwanted, asm)
+		}
+	}
+	for _, fn := range module.Functions {
+		if fn.IsSMCEnabled {
+			t.Errorf("function %s still has SMC enabled", fn.Name)
+		}
+	}
+
+	// The output assembles for the SM83 into a cartridge the boot ROM accepts
+	assembler := z80asm.NewAssembler()
+	if err := assembler.SetTarget(z80asm.TargetGameBoy); err != nil {
+		t.Fatal(err)
+	}
+	result, err := assembler.AssembleString(asm)
+	if err != nil || len(result.Errors) > 0 {
+		t.Fatalf("assembly failed: %v %v", err, result.Errors)
+	}
+	rom, err := z80asm.GetTargetConfig(z80asm.TargetGameBoy).OutputFormat.Generator(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rom) != 0x8000 || rom[0x104] != 0xCE || rom[0x133] != 0x3E {
+		t.Fatalf("got %d bytes without the logo", len(rom))
+	}
+	if title := strings.TrimRight(string(rom[0x134:0x143]), "\x00"); title != "GAME" {
+		t.Errorf("title = %q, want GAME", title)
+	}
+	var check byte
+	for _, b := range rom[0x134:0x14D] {
+		check = check - b - 1
+	}
+	if rom[0x14D] != check {
+		t.Errorf("header checksum = $%02X, want $%02X", rom[0x14D], check)
+	}
+}
@@ -28,6 +28,11 @@ type I8080Generator struct {
 	labelCounter  int
 	localVarBase  uint16
 	emittedParams map[string]bool
+	
+	// Variants of the CPU (the 8085) extend the generator through these
+	target     string                            // CPU name for the header
+	vectors    map[string]uint16                 // Interrupt handler name -> vector address
+	intrinsics map[string]func(*ir.Instruction)  // Calls that become instructions
 }
 
 // NewI8080Generator creates a new 8080 code generator
@@ -37,6 +42,7 @@ func NewI8080Generator(w io.Writer) *I8080Generator {
 		regAlloc:      NewRegisterAllocator(),
 		localVarBase:  0xF000, // Same as Z80
 		emittedParams: make(map[string]bool),
+		target:        "Intel 8080",
 	}
 }
 
@@ -62,6 +68,9 @@ func (g *I8080Generator) Generate(module *ir.Module) error {
 		}
 	}
 
+	// Jumps from the fixed vectors to the interrupt handlers
+	g.generateVectors()
+
 	// Generate code section
 	g.emit("\n; Code section")
 	g.emit("    ORG 08000H")
@@ -85,9 +94,9 @@ func (g *I8080Generator) Generate(module *ir.Module) error {
 
 // writeHeader writes the assembly file header
 func (g *I8080Generator) writeHeader() {
-	g.emit("; MinZ 8080 generated code")
+	g.emit("; MinZ %s generated code", strings.TrimPrefix(g.target, "Intel "))
 	g.emit("; Generated: %s", time.Now().Format("2006-01-02 15:04:05"))
-	g.emit("; Target: Intel 8080")
+	g.emit("; Target: %s", g.target)
 	g.emit("")
 }
 
@@ -97,6 +106,36 @@ func (g *I8080Generator) writeFooter() {
 	g.emit("    END")
 }
 
+// generateVectors places a jump to each interrupt handler at its vector
+func (g *I8080Generator) generateVectors() {
+	emitted := false
+	for _, fn := range g.module.Functions {
+		if !fn.IsInterrupt {
+			continue
+		}
+		addr, ok := g.vectors[interruptName(fn.Name)]
+		if !ok {
+			continue
+		}
+		if !emitted {
+			g.emit("\n; Interrupt vectors")
+			emitted = true
+		}
+		g.emit("    ORG %04XH", addr)
+		g.emit("    JMP %s", fn.Name)
+	}
+}
+
+// interruptName returns a function's name without module prefix and
+// parameter types, as matched against the vector names
+func interruptName(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	name, _, _ = strings.Cut(name, "$")
+	return name
+}
+
 // generateGlobal generates a global variable
 func (g *I8080Generator) generateGlobal(global *ir.Global) {
 	g.emit("%s:", global.Name)
@@ -139,6 +178,9 @@ func (g *I8080Generator) generateFunction(fn *ir.Function) error {
 		g.emit("; SMC enabled - parameters can be self-modified")
 	}
 	g.emit("%s:", fn.Name)
+	if fn.IsInterrupt {
+		g.emit("; Interrupt handler: saves every register, returns with EI")
+	}
 
 	// Generate SMC parameter anchors
 	if fn.IsSMCEnabled && len(fn.Params) > 0 {
@@ -161,7 +203,7 @@ func (g *I8080Generator) generateFunction(fn *ir.Function) error {
 	}
 
 	// Epilogue (if not already returned)
-	if len(fn.Instructions) == 0 || fn.Instructions[len(fn.Instructions)-1].Op != ir.OpReturn {
+	if !fn.EndsWithReturn() {
 		g.generateEpilogue()
 	}
 
@@ -172,6 +214,10 @@ func (g *I8080Generator) generateFunction(fn *ir.Function) error {
 func (g *I8080Generator) generatePrologue() {
 	// 8080 doesn't have PUSH HL etc, must use PUSH H
 	// For simplicity, always save BC, DE, HL
+	// (and A and the flags in an interrupt handler)
+	if g.currentFunc.IsInterrupt {
+		g.emit("    PUSH PSW")
+	}
 	g.emit("    PUSH B")
 	g.emit("    PUSH D")
 	g.emit("    PUSH H")
@@ -183,6 +229,11 @@ func (g *I8080Generator) generateEpilogue() {
 	g.emit("    POP H")
 	g.emit("    POP D")
 	g.emit("    POP B")
+	if g.currentFunc.IsInterrupt {
+		// Interrupts stay disabled until the handler returns
+		g.emit("    POP PSW")
+		g.emit("    EI")
+	}
 	g.emit("    RET")
 }
 
@@ -214,6 +265,9 @@ func (g *I8080Generator) generateInstruction(inst *ir.Instruction, index int) er
 	case ir.OpLabel:
 		g.emit("%s:", inst.Label)
 		return nil
+	case ir.OpSourceMark:
+		g.emit("; %s", inst.String())
+		return nil
 	case ir.OpLt, ir.OpGt, ir.OpLe, ir.OpGe, ir.OpEq, ir.OpNe:
 		return g.generateComparison(inst)
 	case ir.OpPrint:
@@ -228,6 +282,16 @@ func (g *I8080Generator) generateInstruction(inst *ir.Instruction, index int) er
 		return g.generateLoadIndex(inst)
 	case ir.OpLoadAddr:
 		return g.generateLoadAddr(inst)
+	case ir.OpAsm:
+		if inst.AsmName != "" {
+			g.emit("%s:", inst.AsmName)
+		}
+		for _, line := range strings.Split(inst.AsmCode, "\n") {
+			if strings.TrimSpace(line) != "" {
+				g.emit("    %s", strings.TrimSpace(line))
+			}
+		}
+		return nil
 	case ir.OpLoadString:
 		// Load string address into HL
 		if inst.Symbol != "" {
@@ -326,6 +390,11 @@ func (g *I8080Generator) generateAdd(inst *ir.Instruction) error {
 
 // generateCall generates a function call
 func (g *I8080Generator) generateCall(inst *ir.Instruction) error {
+	if intrinsic, ok := g.intrinsics[inst.Symbol]; ok {
+		intrinsic(inst)
+		return nil
+	}
+	
 	// Handle arguments
 	if inst.Args != nil && len(inst.Args) > 0 {
 		// For SMC, patch the first parameter
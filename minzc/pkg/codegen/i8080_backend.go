@@ -40,9 +40,9 @@ func (b *I8080Backend) Generate(module *ir.Module) (string, error) {
 	// Configure based on options
 	if b.options != nil {
 		if b.options.EnableSMC {
-			// Enable SMC for all functions
+			// Enable SMC for all functions but those in ROM
 			for _, fn := range module.Functions {
-				fn.IsSMCEnabled = true
+				fn.IsSMCEnabled = !fn.InROM
 			}
 		}
 	}
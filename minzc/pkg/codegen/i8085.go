@@ -0,0 +1,59 @@
+package codegen
+
+import (
+	"io"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// I8085Generator generates Intel 8085 assembly from IR. The 8085 runs
+// 8080 code unchanged and adds:
+//   - RIM and SIM, which read and set the interrupt masks, the pending
+//     interrupts and the serial input/output pins (SID/SOD)
+//   - Three maskable vectored interrupts (RST 5.5, 6.5 and 7.5) and the
+//     non-maskable TRAP
+//
+// Calls to rim() and sim(mask) become the instructions themselves, and
+// interrupt handlers named trap, rst5_5, rst6_5 or rst7_5 are installed
+// at their vectors.
+type I8085Generator struct {
+	*I8080Generator
+}
+
+// i8085Vectors are the fixed addresses of the 8085 interrupts
+var i8085Vectors = map[string]uint16{
+	"trap":   0x0024,
+	"rst5_5": 0x002C,
+	"rst6_5": 0x0034,
+	"rst7_5": 0x003C,
+}
+
+// NewI8085Generator creates a new 8085 code generator
+func NewI8085Generator(w io.Writer) *I8085Generator {
+	g := &I8085Generator{I8080Generator: NewI8080Generator(w)}
+	g.target = "Intel 8085"
+	g.vectors = i8085Vectors
+	g.intrinsics = map[string]func(*ir.Instruction){
+		"rim": g.generateRIM,
+		"sim": g.generateSIM,
+	}
+	return g
+}
+
+// generateRIM reads the interrupt masks, pending interrupts and SID into A
+func (g *I8085Generator) generateRIM(inst *ir.Instruction) {
+	g.emit("    RIM")
+	if inst.Dest != 0 {
+		g.emit("    STA %04XH", g.getMemoryAddr(inst.Dest))
+	}
+}
+
+// generateSIM sets the interrupt masks and SOD from its argument:
+// bits 0-2 mask RST 5.5/6.5/7.5 when bit 3 (MSE) is set, bit 4 resets
+// the RST 7.5 latch, bit 7 is output on SOD when bit 6 (SDE) is set
+func (g *I8085Generator) generateSIM(inst *ir.Instruction) {
+	if len(inst.Args) > 0 {
+		g.emit("    LDA %04XH", g.getMemoryAddr(inst.Args[0]))
+	}
+	g.emit("    SIM")
+}
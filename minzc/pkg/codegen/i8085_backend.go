@@ -0,0 +1,69 @@
+package codegen
+
+import (
+	"bytes"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// I8085Backend implements the Backend interface for Intel 8085 code generation
+// (SDK-85 and hobbyist 8085 boards). It generates 8080 code plus the
+// 8085's own:
+// - RIM/SIM for interrupt masks and the SID/SOD serial pins
+// - Vectored interrupts: TRAP, RST 5.5, RST 6.5 and RST 7.5
+type I8085Backend struct {
+	I8080Backend
+}
+
+// NewI8085Backend creates a new Intel 8085 backend
+func NewI8085Backend(options *BackendOptions) Backend {
+	return &I8085Backend{
+		I8080Backend: I8080Backend{options: options},
+	}
+}
+
+// Name returns the name of this backend
+func (b *I8085Backend) Name() string {
+	return "i8085"
+}
+
+// Generate generates 8085 assembly code for the given IR module
+func (b *I8085Backend) Generate(module *ir.Module) (string, error) {
+	var buf bytes.Buffer
+	gen := NewI8085Generator(&buf)
+
+	if b.options != nil && b.options.EnableSMC {
+		for _, fn := range module.Functions {
+			fn.IsSMCEnabled = !fn.InROM
+		}
+	}
+
+	if err := gen.Generate(module); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// SupportsFeature checks if the 8085 backend supports a specific feature
+func (b *I8085Backend) SupportsFeature(feature string) bool {
+	switch feature {
+	case "vectored_interrupts", "serial_io":
+		return true // RST 5.5/6.5/7.5, TRAP; SID/SOD through RIM/SIM
+	default:
+		return b.I8080Backend.SupportsFeature(feature)
+	}
+}
+
+// Register the 8085 backend
+func init() {
+	RegisterBackend("i8085", func(options *BackendOptions) Backend {
+		return NewI8085Backend(options)
+	})
+	RegisterBackend("8085", func(options *BackendOptions) Backend {
+		return NewI8085Backend(options)
+	})
+	RegisterBackend("intel8085", func(options *BackendOptions) Backend {
+		return NewI8085Backend(options)
+	})
+}
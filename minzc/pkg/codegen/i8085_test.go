@@ -0,0 +1,60 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+func TestI8085Backend(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	module := func() *ir.Module {
+		handler := ir.NewFunction("main.rst7_5", &ir.BasicType{Kind: ir.TypeVoid})
+		handler.IsInterrupt = true
+		handler.Instructions = []ir.Instruction{
+			{Op: ir.OpCall, Symbol: "rim", Dest: 1, Type: u8},
+			{Op: ir.OpLoadConst, Dest: 2, Imm: 0x18, Type: u8}, // Reset the RST 7.5 latch
+			{Op: ir.OpCall, Symbol: "sim", Args: []ir.Register{2}},
+			{Op: ir.OpReturn},
+		}
+		return &ir.Module{Name: "main", Functions: []*ir.Function{handler, newTestFunction("main.main", 0)}}
+	}
+
+	backend := GetBackend("8085", &BackendOptions{})
+	if backend == nil {
+		t.Fatal("8085 backend is not registered")
+	}
+	if !backend.SupportsFeature("vectored_interrupts") || backend.SupportsFeature("djnz") {
+		t.Error("8085 features are wrong")
+	}
+	asm, err := backend.Generate(module())
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, want := range []string{
+		"; Target: Intel 8085\n",
+		"    ORG 003CH\n    JMP main.rst7_5\n", // RST 7.5 vector
+		"main.rst7_5:\n",
+		"    PUSH PSW\n",
+		"    RIM\n    STA F002H\n",
+		"    LDA F004H\n    SIM\n",
+		"    POP PSW\n    EI\n    RET\n",
+	} {
+		if !strings.Contains(asm, want) {
+			t.Errorf("output is missing %q:\n%s", want, asm)
+		}
+	}
+	if strings.Contains(asm, "CALL rim") || strings.Contains(asm, "CALL sim") {
+		t.Errorf("rim/sim were called instead of generated:\n%s", asm)
+	}
+
+	// The 8080 has neither the instructions nor the vectors
+	asm, err = GetBackend("i8080", &BackendOptions{}).Generate(module())
+	if err != nil {
+		t.Fatalf("8080 Generate failed: %v", err)
+	}
+	if strings.Contains(asm, "RIM") || strings.Contains(asm, "ORG 003CH") {
+		t.Errorf("8080 output uses 8085 features:\n%s", asm)
+	}
+}
@@ -40,9 +40,15 @@ func (b *M6502Backend) Name() string {
 
 // Generate generates 6502 assembly code for the given IR module
 func (b *M6502Backend) Generate(module *ir.Module) (string, error) {
+	return b.generate(module, b)
+}
+
+// generate generates code for the CPU variant cpu, whose SupportsFeature
+// decides which instructions the optimized generator may use
+func (b *M6502Backend) generate(module *ir.Module, cpu Backend) (string, error) {
 	// Use optimized generator if SMC is enabled
 	if b.options != nil && (b.options.EnableSMC || b.options.OptimizationLevel > 0) {
-		gen := NewM6502Generator(b, module)
+		gen := NewM6502Generator(b, cpu, module)
 		return gen.Generate()
 	}
 	
@@ -50,7 +56,7 @@ func (b *M6502Backend) Generate(module *ir.Module) (string, error) {
 	var buf bytes.Buffer
 	
 	// Write header
-	buf.WriteString("; MinZ 6502 generated code\n")
+	buf.WriteString(fmt.Sprintf("; MinZ %s generated code\n", strings.ToUpper(cpu.Name())))
 	buf.WriteString(fmt.Sprintf("; Generated: %s\n", time.Now().Format("2006-01-02 15:04:05")))
 	buf.WriteString("\n")
 	
@@ -247,6 +253,8 @@ func (b *M6502Backend) SupportsFeature(feature string) bool {
 		return false
 	case FeatureFixedPoint:
 		return true // Can implement in software
+	case FeatureZeroPage:
+		return true
 	default:
 		return false
 	}
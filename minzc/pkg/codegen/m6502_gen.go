@@ -8,9 +8,12 @@ import (
 	"github.com/minz/minzc/pkg/ir"
 )
 
-// M6502Generator generates optimized 6502 code with zero-page SMC
+// M6502Generator generates optimized 6502 code with zero-page SMC. It
+// generates for a CPU variant, using 65C02 instructions when the variant
+// supports them.
 type M6502Generator struct {
 	backend     *M6502Backend
+	cpu         Backend // Variant whose SupportsFeature picks the instructions
 	module      *ir.Module
 	currentFunc *ir.Function
 	output      *bytes.Buffer
@@ -23,13 +26,15 @@ type M6502Generator struct {
 	yValue      ir.Register  // What's in Y register
 	
 	labelCounter int
+	pc           int // Index of the instruction being generated
 }
 
 // NewM6502Generator creates a new optimized 6502 code generator
-func NewM6502Generator(backend *M6502Backend, module *ir.Module) *M6502Generator {
+func NewM6502Generator(backend *M6502Backend, cpu Backend, module *ir.Module) *M6502Generator {
 	optimizer := NewM6502SMCOptimizer()
 	gen := &M6502Generator{
 		backend:     backend,
+		cpu:         cpu,
 		module:      module,
 		output:      &bytes.Buffer{},
 		optimizer:   optimizer,
@@ -41,7 +46,7 @@ func NewM6502Generator(backend *M6502Backend, module *ir.Module) *M6502Generator
 // Generate generates optimized 6502 assembly
 func (g *M6502Generator) Generate() (string, error) {
 	// Header
-	g.emit("; MinZ 6502 generated code with zero-page optimization")
+	g.emit("; MinZ %s generated code with zero-page optimization", strings.ToUpper(g.cpu.Name()))
 	g.emit("; SMC/TSMC optimizations enabled")
 	g.emit("")
 	
@@ -96,6 +101,9 @@ func (g *M6502Generator) generateFunction(fn *ir.Function) error {
 		g.emit("; Enhanced optimizations applied")
 	}
 	g.emit("%s:", g.sanitizeName(fn.Name))
+	if fn.IsInterrupt {
+		g.saveRegisters()
+	}
 	
 	// Generate SMC parameter anchors
 	if fn.IsSMCEnabled {
@@ -112,14 +120,18 @@ func (g *M6502Generator) generateFunction(fn *ir.Function) error {
 	}
 	
 	// Generate instructions
-	for _, inst := range fn.Instructions {
+	for i, inst := range fn.Instructions {
+		g.pc = i
 		if err := g.generateInstruction(&inst); err != nil {
 			return err
 		}
 	}
 	
 	// Return
-	if fn.Name != "main" && !strings.HasSuffix(fn.Name, ".main") {
+	if fn.IsInterrupt {
+		g.restoreRegisters()
+		g.emit("    rti")
+	} else if fn.Name != "main" && !strings.HasSuffix(fn.Name, ".main") {
 		g.emit("    rts")
 	} else {
 		g.emit("    brk        ; End program")
@@ -163,7 +175,7 @@ func (g *M6502Generator) generateInstruction(inst *ir.Instruction) error {
 		return g.genCompare(inst)
 		
 	case ir.OpJump:
-		g.emit("    jmp %s", inst.Label)
+		return g.genJump(inst)
 		
 	case ir.OpJumpIf:
 		return g.genJumpIf(inst)
@@ -177,6 +189,12 @@ func (g *M6502Generator) generateInstruction(inst *ir.Instruction) error {
 	case ir.OpCall:
 		return g.genCall(inst)
 		
+	case ir.OpLoadPtr:
+		return g.genLoadPtr(inst)
+		
+	case ir.OpStorePtr:
+		return g.genStorePtr(inst)
+		
 	case ir.OpReturn:
 		if inst.Src1 != 0 {
 			g.loadToA(inst.Src1)
@@ -212,14 +230,11 @@ func (g *M6502Generator) genLoadConst(inst *ir.Instruction) error {
 	// Check if this goes to zero page
 	if zpAddr, exists := g.optimizer.regToZeroPage[inst.Dest]; exists {
 		if value <= 255 {
-			g.emit("    lda #$%02X", value)
-			g.emit(g.optimizer.GenerateZeroPageAccess("store8", zpAddr, fmt.Sprintf("r%d = %d", inst.Dest, value)))
+			g.storeConst(value, zpAddr, fmt.Sprintf("r%d = %d", inst.Dest, value))
 		} else {
 			// 16-bit constant
-			g.emit("    lda #$%02X", value&0xFF)
-			g.emit(g.optimizer.GenerateZeroPageAccess("store8", zpAddr, fmt.Sprintf("r%d low", inst.Dest)))
-			g.emit("    lda #$%02X", (value>>8)&0xFF)
-			g.emit(g.optimizer.GenerateZeroPageAccess("store8", zpAddr+1, fmt.Sprintf("r%d high", inst.Dest)))
+			g.storeConst(value&0xFF, zpAddr, fmt.Sprintf("r%d low", inst.Dest))
+			g.storeConst((value>>8)&0xFF, zpAddr+1, fmt.Sprintf("r%d high", inst.Dest))
 		}
 	} else {
 		// Regular load
@@ -237,6 +252,17 @@ func (g *M6502Generator) genLoadConst(inst *ir.Instruction) error {
 	return nil
 }
 
+// storeConst stores a byte constant to zero page, with STZ for zero
+func (g *M6502Generator) storeConst(value int64, zpAddr byte, comment string) {
+	if value == 0 && g.cpu.SupportsFeature(FeatureStoreZero) {
+		g.emit("    stz $%02X        ; %s", zpAddr, comment)
+		return
+	}
+	g.emit("    lda #$%02X", value)
+	g.emit(g.optimizer.GenerateZeroPageAccess("store8", zpAddr, comment))
+	g.accValue = 0
+}
+
 func (g *M6502Generator) genLoadVar(inst *ir.Instruction) error {
 	// Check if dest is in zero page
 	if zpAddr, exists := g.optimizer.regToZeroPage[inst.Dest]; exists {
@@ -338,8 +364,12 @@ func (g *M6502Generator) genInc(inst *ir.Instruction) error {
 	} else {
 		// Regular increment
 		g.loadToA(inst.Src1)
-		g.emit("    clc")
-		g.emit("    adc #1")
+		if g.cpu.SupportsFeature(FeatureAccumulatorIncDec) {
+			g.emit("    inc a")
+		} else {
+			g.emit("    clc")
+			g.emit("    adc #1")
+		}
 		g.accValue = inst.Dest
 	}
 	
@@ -362,8 +392,12 @@ func (g *M6502Generator) genDec(inst *ir.Instruction) error {
 	} else {
 		// Regular decrement
 		g.loadToA(inst.Src1)
-		g.emit("    sec")
-		g.emit("    sbc #1")
+		if g.cpu.SupportsFeature(FeatureAccumulatorIncDec) {
+			g.emit("    dec a")
+		} else {
+			g.emit("    sec")
+			g.emit("    sbc #1")
+		}
 		g.accValue = inst.Dest
 	}
 	
@@ -407,6 +441,39 @@ func (g *M6502Generator) genCompare(inst *ir.Instruction) error {
 	return nil
 }
 
+// braReach is how many instructions away a label may be for BRA to reach
+// it: no instruction other than calls and inline assembly generates more
+// than 20 bytes, so six of them stay within BRA's 127-byte range
+const braReach = 6
+
+// genJump jumps with BRA when the label is near enough, JMP otherwise
+func (g *M6502Generator) genJump(inst *ir.Instruction) error {
+	if g.cpu.SupportsFeature(FeatureBranchAlways) && g.labelNear(inst.Label) {
+		g.emit("    bra %s", inst.Label)
+	} else {
+		g.emit("    jmp %s", inst.Label)
+	}
+	return nil
+}
+
+// labelNear reports whether label is within braReach instructions of the
+// current one, with no calls or inline assembly in between
+func (g *M6502Generator) labelNear(label string) bool {
+	insts := g.currentFunc.Instructions
+	for i := max(0, g.pc-braReach); i <= min(len(insts)-1, g.pc+braReach); i++ {
+		if insts[i].Op != ir.OpLabel || insts[i].Label != label {
+			continue
+		}
+		for j := min(i, g.pc); j < max(i, g.pc); j++ {
+			if op := insts[j].Op; op == ir.OpCall || op == ir.OpAsm {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
 func (g *M6502Generator) genJumpIf(inst *ir.Instruction) error {
 	// Load condition
 	g.loadToA(inst.Src1)
@@ -451,6 +518,82 @@ func (g *M6502Generator) genCall(inst *ir.Instruction) error {
 	return nil
 }
 
+// genLoadPtr loads a byte or word through a pointer held in zero page
+func (g *M6502Generator) genLoadPtr(inst *ir.Instruction) error {
+	ptrAddr, ok := g.optimizer.regToZeroPage[inst.Src1]
+	destAddr, destInZP := g.optimizer.regToZeroPage[inst.Dest]
+	word := inst.Type != nil && inst.Type.Size() > 1
+	if !ok || (word && !destInZP) {
+		g.emit("    ; TODO: %s", inst.Op)
+		return nil
+	}
+
+	g.emitIndirect("lda", ptrAddr, fmt.Sprintf("r%d = *r%d", inst.Dest, inst.Src1))
+	if destInZP {
+		g.emit(g.optimizer.GenerateZeroPageAccess("store8", destAddr, fmt.Sprintf("r%d", inst.Dest)))
+	}
+	if word {
+		g.emit("    ldy #1")
+		g.emit("    lda ($%02X),y    ; high byte", ptrAddr)
+		g.emit(g.optimizer.GenerateZeroPageAccess("store16_high", destAddr, fmt.Sprintf("r%d", inst.Dest)))
+		g.accValue = 0
+		return nil
+	}
+	g.accValue = inst.Dest
+	return nil
+}
+
+// genStorePtr stores a byte through a pointer held in zero page
+func (g *M6502Generator) genStorePtr(inst *ir.Instruction) error {
+	ptrAddr, ok := g.optimizer.regToZeroPage[inst.Src1]
+	if !ok || (inst.Type != nil && inst.Type.Size() > 1) {
+		g.emit("    ; TODO: %s", inst.Op)
+		return nil
+	}
+	g.loadToA(inst.Src2)
+	g.emitIndirect("sta", ptrAddr, fmt.Sprintf("*r%d = r%d", inst.Src1, inst.Src2))
+	return nil
+}
+
+// emitIndirect accesses the byte a zero-page pointer points to, with the
+// 65C02's (zp) mode or through Y = 0
+func (g *M6502Generator) emitIndirect(op string, ptrAddr byte, comment string) {
+	if g.cpu.SupportsFeature(FeatureZeroPageIndirect) {
+		g.emit("    %s ($%02X)      ; %s", op, ptrAddr, comment)
+		return
+	}
+	g.emit("    ldy #0")
+	g.emit("    %s ($%02X),y    ; %s", op, ptrAddr, comment)
+}
+
+// saveRegisters pushes A, X and Y on entry to an interrupt handler
+func (g *M6502Generator) saveRegisters() {
+	g.emit("    pha")
+	if g.cpu.SupportsFeature(FeatureIndexPushPull) {
+		g.emit("    phx")
+		g.emit("    phy")
+		return
+	}
+	g.emit("    txa")
+	g.emit("    pha")
+	g.emit("    tya")
+	g.emit("    pha")
+}
+
+// restoreRegisters pulls what saveRegisters pushed
+func (g *M6502Generator) restoreRegisters() {
+	if g.cpu.SupportsFeature(FeatureIndexPushPull) {
+		g.emit("    ply")
+		g.emit("    plx")
+	} else {
+		g.emit("    pla")
+		g.emit("    tay")
+		g.emit("    pla")
+		g.emit("    tax")
+	}
+	g.emit("    pla")
+}
+
 func (g *M6502Generator) loadToA(reg ir.Register) {
 	if g.accValue == reg {
 		return // Already in accumulator
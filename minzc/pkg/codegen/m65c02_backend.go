@@ -0,0 +1,69 @@
+package codegen
+
+import (
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// 65C02 features beyond the common ones. The 6502 generator asks the
+// backend for these and picks the shorter CMOS pattern where it can.
+const (
+	FeatureIndexPushPull     = "index_push_pull" // PHX, PHY, PLX, PLY
+	FeatureStoreZero         = "store_zero"      // STZ
+	FeatureBranchAlways      = "branch_always"   // BRA
+	FeatureZeroPageIndirect  = "zp_indirect"     // LDA (zp), STA (zp) without Y
+	FeatureAccumulatorIncDec = "inc_a"           // INC A, DEC A
+)
+
+// M65C02Backend implements the Backend interface for the CMOS 65C02
+// (Apple IIc/IIe enhanced, BBC Master, many SBCs). It generates 6502 code
+// plus the 65C02's own:
+// - PHX/PHY/PLX/PLY to save the index registers without going through A
+// - STZ to store zero without loading A
+// - BRA for short unconditional jumps
+// - (zp) indirect addressing without the Y index, INC A and DEC A
+// - TSB/TRB to set and reset bits in memory
+type M65C02Backend struct {
+	M6502Backend
+}
+
+// NewM65C02Backend creates a new 65C02 backend
+func NewM65C02Backend(options *BackendOptions) Backend {
+	backend := &M65C02Backend{
+		M6502Backend: *NewM6502Backend(options).(*M6502Backend),
+	}
+	backend.SetFeature(FeatureBitManipulation, true)
+	return backend
+}
+
+// Name returns the name of this backend
+func (b *M65C02Backend) Name() string {
+	return "65c02"
+}
+
+// Generate generates 65C02 assembly code for the given IR module
+func (b *M65C02Backend) Generate(module *ir.Module) (string, error) {
+	return b.generate(module, b)
+}
+
+// SupportsFeature checks if the 65C02 backend supports a specific feature
+func (b *M65C02Backend) SupportsFeature(feature string) bool {
+	switch feature {
+	case FeatureIndexPushPull, FeatureStoreZero, FeatureBranchAlways,
+		FeatureZeroPageIndirect, FeatureAccumulatorIncDec:
+		return true
+	case FeatureBitManipulation:
+		return true // TSB, TRB
+	default:
+		return b.M6502Backend.SupportsFeature(feature)
+	}
+}
+
+// Register the 65C02 backend
+func init() {
+	RegisterBackend("65c02", func(options *BackendOptions) Backend {
+		return NewM65C02Backend(options)
+	})
+	RegisterBackend("w65c02", func(options *BackendOptions) Backend {
+		return NewM65C02Backend(options)
+	})
+}
@@ -0,0 +1,80 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+func TestM65C02Backend(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	module := func() *ir.Module {
+		irq := ir.NewFunction("main.irq", &ir.BasicType{Kind: ir.TypeVoid})
+		irq.IsInterrupt = true
+		irq.Instructions = []ir.Instruction{{Op: ir.OpReturn}}
+
+		main := ir.NewFunction("main.main", &ir.BasicType{Kind: ir.TypeVoid})
+		main.Instructions = []ir.Instruction{
+			{Op: ir.OpLoadConst, Dest: 1, Imm: 0, Type: u8},                             // r1 at $00
+			{Op: ir.OpLoadConst, Dest: 2, Imm: 0x0400, Type: &ir.PointerType{Base: u8}}, // r2 at $01
+			{Op: ir.OpLabel, Label: "loop"},
+			{Op: ir.OpLoadPtr, Dest: 3, Src1: 2, Type: u8},
+			{Op: ir.OpStorePtr, Src1: 2, Src2: 1, Type: u8},
+			{Op: ir.OpInc, Dest: 4, Src1: 9, Type: u8}, // r9 is not in zero page
+			{Op: ir.OpJump, Label: "loop"},
+			{Op: ir.OpCall, Symbol: "main.irq"},
+			{Op: ir.OpJump, Label: "loop"}, // Across a call: too far to be sure
+			{Op: ir.OpReturn},
+		}
+		return &ir.Module{Name: "main", Functions: []*ir.Function{irq, main}}
+	}
+
+	backend := GetBackend("65c02", &BackendOptions{OptimizationLevel: 1})
+	if backend == nil {
+		t.Fatal("65c02 backend is not registered")
+	}
+	if !backend.SupportsFeature(FeatureStoreZero) || !backend.SupportsFeature(FeatureZeroPage) ||
+		GetBackend("6502", &BackendOptions{}).SupportsFeature(FeatureStoreZero) {
+		t.Error("65C02 features are wrong")
+	}
+	asm, err := backend.Generate(module())
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, want := range []string{
+		"; MinZ 65C02 generated code",
+		"main_irq:\n    pha\n    phx\n    phy\n",
+		"    ply\n    plx\n    pla\n    rti\n",
+		"    stz $00        ; r1 = 0\n    stz $01        ; r2 low\n    lda #$04\n",
+		"    lda ($01)      ; r3 = *r2\n",
+		"    sta ($01)      ; *r2 = r1\n",
+		"    lda temp_9     ; load r9\n    inc a\n    bra loop\n",
+		"    jsr main_irq\n    jmp loop\n",
+	} {
+		if !strings.Contains(asm, want) {
+			t.Errorf("output is missing %q:\n%s", want, asm)
+		}
+	}
+
+	// The 6502 has none of these instructions
+	asm, err = GetBackend("6502", &BackendOptions{OptimizationLevel: 1}).Generate(module())
+	if err != nil {
+		t.Fatalf("6502 Generate failed: %v", err)
+	}
+	for _, want := range []string{
+		"main_irq:\n    pha\n    txa\n    pha\n    tya\n    pha\n",
+		"    lda #$00\n    sta $00        ; r1 = 0\n",
+		"    ldy #0\n    lda ($01),y    ; r3 = *r2\n",
+		"    clc\n    adc #1\n    jmp loop\n",
+	} {
+		if !strings.Contains(asm, want) {
+			t.Errorf("6502 output is missing %q:\n%s", want, asm)
+		}
+	}
+	for _, cmos := range []string{"phx", "stz", "bra", "inc a", "($01)\n"} {
+		if strings.Contains(asm, cmos) {
+			t.Errorf("6502 output uses 65C02 %q:\n%s", cmos, asm)
+		}
+	}
+}
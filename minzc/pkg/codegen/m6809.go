@@ -0,0 +1,948 @@
+package codegen
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// M6809Generator generates Motorola 6809 assembly (lwasm syntax) from IR.
+//
+// Every function gets a stack frame addressed through U:
+//
+//	4+2i,U  parameter i (pushed by the caller, last parameter first)
+//	2,U     return address
+//	0,U     caller's U
+//	-2r,U   virtual register r, one 16-bit slot each (big-endian, so the
+//	        low byte of an 8-bit value is at -2r+1,U)
+//	below   locals larger than a word (arrays, structs)
+//
+// 8-bit values live zero- or sign-extended in their slot, so D always holds
+// the whole value and comparisons work on 16 bits. Results are returned in D.
+type M6809Generator struct {
+	writer        io.Writer
+	module        *ir.Module
+	currentFunc   *ir.Function
+	labelCounter  int
+	origin        uint16
+	deterministic bool
+
+	functions   map[string]bool // Module functions, by IR name
+	bigLocals   map[string]int  // Frame offsets of locals larger than a word
+	usedHelpers map[string]bool // Runtime routines to append
+}
+
+// NewM6809Generator creates a new 6809 code generator
+func NewM6809Generator(w io.Writer) *M6809Generator {
+	return &M6809Generator{
+		writer:      w,
+		origin:      0x3000, // Free RAM on a 32K CoCo or Dragon, above BASIC
+		functions:   make(map[string]bool),
+		usedHelpers: make(map[string]bool),
+	}
+}
+
+// m6809Runtime lists the runtime routines the analyzer calls by name. They
+// take their argument in D (X for strings) instead of on the stack.
+var m6809Runtime = map[string]bool{
+	"print_u8_decimal":  true,
+	"print_u16_decimal": true,
+	"print_i8_decimal":  true,
+	"print_i16_decimal": true,
+	"print_hex_u8":      true,
+	"print_bool":        true,
+	"print_string":      true,
+	"print_newline":     true,
+	"print_char":        true,
+}
+
+// Generate generates 6809 assembly for an IR module
+func (g *M6809Generator) Generate(module *ir.Module) error {
+	g.module = module
+	for _, fn := range module.Functions {
+		g.functions[fn.Name] = true
+	}
+
+	g.writeHeader()
+
+	// Fixed-address globals become equates
+	for _, global := range module.Globals {
+		if global.Address != nil {
+			g.emit("%s EQU $%04X", g.symbol(global.Name), *global.Address)
+		}
+	}
+
+	g.emit("    ORG $%04X", g.origin)
+	g.emit("")
+	g.emit("; Entry point: returns to BASIC when main returns")
+	g.emit("start:")
+	if main := g.findMain(); main != "" {
+		g.emit("    LBSR %s", g.symbol(main))
+	}
+	g.emit("    RTS")
+
+	// Generate functions
+	for _, fn := range module.Functions {
+		if err := g.generateFunction(fn); err != nil {
+			return err
+		}
+	}
+
+	// Runtime routines go before the data so they stay in branch range
+	g.generateHelpers()
+
+	// Data section
+	if len(module.Globals) > 0 || len(module.Strings) > 0 {
+		g.emit("\n; Data section")
+		for _, global := range module.Globals {
+			g.generateGlobal(global)
+		}
+		for _, str := range module.Strings {
+			g.generateString(str)
+		}
+	}
+
+	g.emit("\n    END start")
+	return nil
+}
+
+// writeHeader writes the assembly file header
+func (g *M6809Generator) writeHeader() {
+	g.emit("; MinZ 6809 generated code")
+	if stamp := generatedTimestamp(g.deterministic); stamp != "" {
+		g.emit("; Generated: %s", stamp)
+	}
+	g.emit("; Target: Motorola 6809 (Tandy CoCo / Dragon)")
+	g.emit("; Assemble with: lwasm --decb -o program.bin program.asm")
+	g.emit("")
+}
+
+// findMain returns the IR name of the main function, or ""
+func (g *M6809Generator) findMain() string {
+	for _, fn := range g.module.Functions {
+		if fn.Name == "main" || strings.HasSuffix(fn.Name, ".main") {
+			return fn.Name
+		}
+	}
+	return ""
+}
+
+// generateGlobal generates a global variable
+func (g *M6809Generator) generateGlobal(global ir.Global) {
+	if global.Address != nil {
+		return // Declared as an equate
+	}
+	size := 2
+	if global.Type != nil {
+		size = global.Type.Size()
+	}
+	value, hasValue := global.Init.(int64)
+	if !hasValue {
+		if v, ok := global.Init.(int); ok {
+			value, hasValue = int64(v), true
+		}
+	}
+
+	g.emit("%s:", g.symbol(global.Name))
+	switch {
+	case size == 1:
+		g.emit("    FCB %d", value&0xFF)
+	case size == 2:
+		g.emit("    FDB %d", value&0xFFFF)
+	default:
+		g.emit("    RMB %d ; %s", size, global.Type.String())
+	}
+}
+
+// generateString generates a length-prefixed string literal
+func (g *M6809Generator) generateString(str *ir.String) {
+	g.emit("%s:", g.symbol(str.Label))
+	if str.IsLong {
+		g.emit("    FCB 255 ; LString marker")
+		g.emit("    FDB %d ; Length", len(str.Value))
+	} else {
+		g.emit("    FCB %d ; Length", len(str.Value))
+	}
+	if len(str.Value) == 0 {
+		return
+	}
+	bytes := make([]string, 0, len(str.Value))
+	for i := 0; i < len(str.Value); i++ {
+		bytes = append(bytes, fmt.Sprintf("$%02X", str.Value[i]))
+	}
+	g.emit("    FCB %s", strings.Join(bytes, ","))
+}
+
+// generateFunction generates a function
+func (g *M6809Generator) generateFunction(fn *ir.Function) error {
+	g.currentFunc = fn
+
+	// Registers take the first slots below U, larger locals go under them
+	frame := 2 * int(g.maxRegister(fn))
+	g.bigLocals = make(map[string]int)
+	for _, local := range fn.Locals {
+		if local.Type != nil && local.Type.Size() > 2 {
+			frame += local.Type.Size()
+			g.bigLocals[local.Name] = -frame
+		}
+	}
+
+	g.emit("\n; Function: %s", fn.Name)
+	if fn.IsInterrupt {
+		g.emit("; Interrupt handler: the CPU has already stacked the registers")
+	}
+	g.emit("%s:", g.symbol(fn.Name))
+
+	// Prologue
+	g.emit("    PSHS U")
+	g.emit("    LEAU ,S")
+	if frame > 0 {
+		g.emit("    LEAS -%d,S", frame)
+	}
+
+	for i := range fn.Instructions {
+		if err := g.generateInstruction(&fn.Instructions[i]); err != nil {
+			return fmt.Errorf("function %s: %w", fn.Name, err)
+		}
+	}
+
+	// Epilogue (if not already returned)
+	if !fn.EndsWithReturn() {
+		g.generateEpilogue()
+	}
+	return nil
+}
+
+// maxRegister returns the highest virtual register fn uses
+func (g *M6809Generator) maxRegister(fn *ir.Function) ir.Register {
+	max := fn.NextReg
+	see := func(r ir.Register) {
+		if r > max {
+			max = r
+		}
+	}
+	for _, p := range fn.Params {
+		see(p.Reg)
+	}
+	for _, l := range fn.Locals {
+		see(l.Reg)
+	}
+	for _, inst := range fn.Instructions {
+		see(inst.Dest)
+		see(inst.Src1)
+		see(inst.Src2)
+		for _, a := range inst.Args {
+			see(a)
+		}
+	}
+	return max
+}
+
+// generateEpilogue tears down the frame and returns; D holds the result
+func (g *M6809Generator) generateEpilogue() {
+	g.emit("    LEAS ,U")
+	if g.currentFunc.IsInterrupt {
+		g.emit("    PULS U")
+		g.emit("    RTI")
+		return
+	}
+	g.emit("    PULS U,PC")
+}
+
+// generateInstruction generates code for a single instruction
+func (g *M6809Generator) generateInstruction(inst *ir.Instruction) error {
+	switch inst.Op {
+	case ir.OpNop:
+		return nil
+	case ir.OpSourceMark:
+		g.emit("; %s", inst.String())
+	case ir.OpLabel:
+		g.emit("%s:", g.label(inst.Label))
+
+	case ir.OpLoadConst, ir.OpSMCLoadConst:
+		g.emit("    LDD #%d", inst.Imm&0xFFFF)
+		g.store(inst.Dest)
+	case ir.OpMove:
+		g.load(inst.Src1)
+		g.store(inst.Dest)
+	case ir.OpLoadVar:
+		g.loadSymbol(inst.Symbol)
+		g.store(inst.Dest)
+	case ir.OpStoreVar:
+		g.load(inst.Src1)
+		if inst.Symbol == "" {
+			g.store(inst.Dest) // Local by register
+		} else {
+			g.storeSymbol(inst.Symbol)
+		}
+	case ir.OpLoadParam:
+		g.emit("    LDD %d,U ; %s", 4+2*int(inst.Src1), inst.Symbol)
+		g.store(inst.Dest)
+	case ir.OpLoadAddr:
+		g.loadAddress(inst.Symbol)
+		g.emit("    STX %s", g.slot(inst.Dest))
+	case ir.OpLoadLabel, ir.OpLoadString:
+		g.emit("    LEAX %s,PCR", g.symbol(inst.Symbol))
+		g.emit("    STX %s", g.slot(inst.Dest))
+
+	case ir.OpAdd:
+		g.load(inst.Src1)
+		g.emit("    ADDD %s", g.slot(inst.Src2))
+		g.narrow(inst.Type)
+		g.store(inst.Dest)
+	case ir.OpSub:
+		g.load(inst.Src1)
+		g.emit("    SUBD %s", g.slot(inst.Src2))
+		g.narrow(inst.Type)
+		g.store(inst.Dest)
+	case ir.OpMul:
+		g.generateMul(inst)
+	case ir.OpDiv, ir.OpMod:
+		g.generateDivMod(inst)
+	case ir.OpInc:
+		g.load(inst.Src1)
+		g.emit("    ADDD #1")
+		g.narrow(inst.Type)
+		g.store(inst.Dest)
+	case ir.OpDec:
+		g.load(inst.Src1)
+		g.emit("    SUBD #1")
+		g.narrow(inst.Type)
+		g.store(inst.Dest)
+	case ir.OpNeg:
+		g.load(inst.Src1)
+		g.emit("    COMA")
+		g.emit("    COMB")
+		g.emit("    ADDD #1")
+		g.narrow(inst.Type)
+		g.store(inst.Dest)
+
+	case ir.OpAnd, ir.OpOr, ir.OpXor:
+		mnemonic := map[ir.Opcode]string{ir.OpAnd: "AND", ir.OpOr: "OR", ir.OpXor: "EOR"}[inst.Op]
+		offset := g.offset(inst.Src2)
+		g.load(inst.Src1)
+		g.emit("    %sA %d,U", mnemonic, offset)
+		g.emit("    %sB %d,U", mnemonic, offset+1)
+		g.store(inst.Dest)
+	case ir.OpNot:
+		g.load(inst.Src1)
+		g.emit("    COMA")
+		g.emit("    COMB")
+		g.narrow(inst.Type)
+		g.store(inst.Dest)
+	case ir.OpShl, ir.OpShr:
+		g.generateShift(inst)
+	case ir.OpLogicalAnd, ir.OpLogicalOr:
+		g.generateLogical(inst)
+
+	case ir.OpEq, ir.OpNe, ir.OpLt, ir.OpGt, ir.OpLe, ir.OpGe:
+		g.generateComparison(inst)
+
+	case ir.OpJump:
+		g.emit("    LBRA %s", g.label(inst.Label))
+	case ir.OpJumpIf, ir.OpJumpIfNotZero:
+		g.load(inst.Src1) // LDD sets Z
+		g.emit("    LBNE %s", g.label(jumpTarget(inst)))
+	case ir.OpJumpIfNot, ir.OpJumpIfZero:
+		g.load(inst.Src1)
+		g.emit("    LBEQ %s", g.label(jumpTarget(inst)))
+
+	case ir.OpCall:
+		g.generateCall(inst)
+	case ir.OpCallIndirect:
+		g.pushArgs(inst.Args)
+		g.emit("    LDX %s", g.slot(inst.Src1))
+		g.emit("    JSR ,X")
+		g.popArgs(inst.Args)
+		if inst.Dest != 0 {
+			g.store(inst.Dest)
+		}
+	case ir.OpReturn:
+		if inst.Src1 != 0 {
+			g.load(inst.Src1)
+		}
+		g.generateEpilogue()
+
+	case ir.OpLoadPtr, ir.OpLoad:
+		g.emit("    LDX %s", g.slot(inst.Src1))
+		g.loadIndexed("0,X", inst.Type)
+		g.store(inst.Dest)
+	case ir.OpStorePtr, ir.OpStore:
+		g.emit("    LDX %s", g.slot(inst.Src1))
+		g.load(inst.Src2)
+		g.storeIndexed("0,X", inst.Type)
+	case ir.OpLoadField:
+		g.emit("    LDX %s", g.slot(inst.Src1))
+		g.loadIndexed(fmt.Sprintf("%d,X", inst.Imm), inst.Type)
+		g.store(inst.Dest)
+	case ir.OpStoreField:
+		g.emit("    LDX %s", g.slot(inst.Src1))
+		g.load(inst.Src2)
+		g.storeIndexed(fmt.Sprintf("%d,X", inst.Imm), inst.Type)
+	case ir.OpLoadIndex:
+		// Accumulator-offset indexing does the address arithmetic
+		g.emit("    LDX %s", g.slot(inst.Src1))
+		g.load(inst.Src2)
+		if inst.Type != nil && inst.Type.Size() == 2 {
+			g.emit("    LSLB")
+			g.emit("    ROLA")
+		}
+		g.loadIndexed("D,X", inst.Type)
+		g.store(inst.Dest)
+
+	case ir.OpPrint:
+		g.load(inst.Src1)
+		g.callHelper("print_char")
+	case ir.OpPrintU8:
+		g.load(inst.Src1)
+		g.callHelper("print_u8_decimal")
+	case ir.OpPrintU16:
+		g.load(inst.Src1)
+		g.callHelper("print_u16_decimal")
+	case ir.OpPrintI8:
+		g.load(inst.Src1)
+		g.callHelper("print_i8_decimal")
+	case ir.OpPrintI16:
+		g.load(inst.Src1)
+		g.callHelper("print_i16_decimal")
+	case ir.OpPrintBool:
+		g.load(inst.Src1)
+		g.callHelper("print_bool")
+	case ir.OpPrintString:
+		g.emit("    LDX %s", g.slot(inst.Src1))
+		g.callHelper("print_string")
+	case ir.OpPrintStringDirect:
+		if inst.Comment != "" {
+			g.emit("    ; %s", inst.Comment)
+		}
+		for i := 0; i < len(inst.Symbol); i++ {
+			g.emit("    LDB #$%02X", inst.Symbol[i])
+			g.callHelper("print_char")
+		}
+
+	case ir.OpAsm:
+		if inst.AsmName != "" {
+			g.emit("%s:", inst.AsmName)
+		}
+		for _, line := range strings.Split(inst.AsmCode, "\n") {
+			if strings.TrimSpace(line) != "" {
+				g.emit("    %s", strings.TrimSpace(line))
+			}
+		}
+
+	case ir.OpPatchTemplate, ir.OpPatchTarget, ir.OpPatchParam:
+		// Instruction patching needs SMC; the call that follows passes
+		// its arguments on the stack instead
+		g.emit("    ; %s skipped (no SMC)", inst.Op)
+
+	default:
+		return fmt.Errorf("unsupported operation on 6809: %s", inst.Op)
+	}
+	return nil
+}
+
+// jumpTarget returns the label a conditional jump goes to
+func jumpTarget(inst *ir.Instruction) string {
+	if inst.Label != "" {
+		return inst.Label
+	}
+	return inst.Symbol
+}
+
+// generateMul uses MUL directly for 8-bit operands and the __mul16 routine
+// (three MULs) for 16-bit ones
+func (g *M6809Generator) generateMul(inst *ir.Instruction) {
+	if inst.Type != nil && inst.Type.Size() == 1 {
+		g.emit("    LDA %d,U", g.offset(inst.Src1)+1)
+		g.emit("    LDB %d,U", g.offset(inst.Src2)+1)
+		g.emit("    MUL")
+		g.narrow(inst.Type)
+		g.store(inst.Dest)
+		return
+	}
+	g.load(inst.Src1)
+	g.emit("    LDX %s", g.slot(inst.Src2))
+	g.callHelper("__mul16")
+	g.store(inst.Dest)
+}
+
+// generateDivMod divides unsigned through __div16, which leaves the
+// quotient in D and the remainder in X
+func (g *M6809Generator) generateDivMod(inst *ir.Instruction) {
+	g.load(inst.Src1)
+	g.emit("    LDX %s", g.slot(inst.Src2))
+	g.callHelper("__div16")
+	if inst.Op == ir.OpMod {
+		g.emit("    TFR X,D")
+	}
+	g.narrow(inst.Type)
+	g.store(inst.Dest)
+}
+
+// generateShift shifts Src1 by the count in Src2, one bit per loop
+func (g *M6809Generator) generateShift(inst *ir.Instruction) {
+	loop := g.newLabel("shift")
+	done := g.newLabel("shift_done")
+	g.emit("    LDX %s", g.slot(inst.Src2))
+	g.load(inst.Src1) // LDD leaves X alone
+	g.emit("    LEAX ,X")
+	g.emit("    BEQ %s", done)
+	g.emit("%s:", loop)
+	switch {
+	case inst.Op == ir.OpShl:
+		g.emit("    LSLB")
+		g.emit("    ROLA")
+	case isSignedType(inst.Type) && inst.Type.Size() == 2:
+		g.emit("    ASRA")
+		g.emit("    RORB")
+	case isSignedType(inst.Type):
+		g.emit("    ASRB")
+	default:
+		g.emit("    LSRA")
+		g.emit("    RORB")
+	}
+	g.emit("    LEAX -1,X")
+	g.emit("    BNE %s", loop)
+	g.emit("%s:", done)
+	g.narrow(inst.Type)
+	g.store(inst.Dest)
+}
+
+// generateLogical stores Src1 && Src2 or Src1 || Src2 as 0 or 1
+func (g *M6809Generator) generateLogical(inst *ir.Instruction) {
+	short := g.newLabel("logic_short")
+	done := g.newLabel("logic_done")
+	branch, result, other := "BEQ", 0, 1 // && short-circuits to false
+	if inst.Op == ir.OpLogicalOr {
+		branch, result, other = "BNE", 1, 0
+	}
+	g.load(inst.Src1)
+	g.emit("    %s %s", branch, short)
+	g.load(inst.Src2)
+	g.emit("    %s %s", branch, short)
+	g.emit("    LDB #%d", other)
+	g.emit("    BRA %s", done)
+	g.emit("%s:", short)
+	g.emit("    LDB #%d", result)
+	g.emit("%s:", done)
+	g.emit("    CLRA")
+	g.store(inst.Dest)
+}
+
+// generateComparison stores the result of a comparison as 0 or 1. Operands
+// are held extended to 16 bits, so one CMPD covers both widths.
+func (g *M6809Generator) generateComparison(inst *ir.Instruction) {
+	branches := map[ir.Opcode][2]string{ // unsigned, signed
+		ir.OpEq: {"BEQ", "BEQ"},
+		ir.OpNe: {"BNE", "BNE"},
+		ir.OpLt: {"BLO", "BLT"},
+		ir.OpGt: {"BHI", "BGT"},
+		ir.OpLe: {"BLS", "BLE"},
+		ir.OpGe: {"BHS", "BGE"},
+	}[inst.Op]
+	branch := branches[0]
+	if isSignedType(inst.Type) {
+		branch = branches[1]
+	}
+
+	isTrue := g.newLabel("cmp_true")
+	done := g.newLabel("cmp_done")
+	g.load(inst.Src1)
+	g.emit("    CMPD %s", g.slot(inst.Src2))
+	g.emit("    %s %s", branch, isTrue)
+	g.emit("    CLRB")
+	g.emit("    BRA %s", done)
+	g.emit("%s:", isTrue)
+	g.emit("    LDB #1")
+	g.emit("%s:", done)
+	g.emit("    CLRA")
+	g.store(inst.Dest)
+}
+
+// generateCall calls a module function with its arguments on the stack, or
+// a runtime routine with its argument in D (X for strings)
+func (g *M6809Generator) generateCall(inst *ir.Instruction) {
+	if !g.functions[inst.Symbol] && m6809Runtime[inst.Symbol] {
+		if len(inst.Args) > 0 {
+			if inst.Symbol == "print_string" {
+				g.emit("    LDX %s", g.slot(inst.Args[0]))
+			} else {
+				g.load(inst.Args[0])
+			}
+		}
+		g.callHelper(inst.Symbol)
+		return
+	}
+
+	g.pushArgs(inst.Args)
+	g.emit("    JSR %s", g.symbol(inst.Symbol))
+	g.popArgs(inst.Args)
+	if inst.Dest != 0 {
+		g.store(inst.Dest)
+	}
+}
+
+// pushArgs pushes call arguments last first, so parameter i is at 4+2i,U
+// in the callee
+func (g *M6809Generator) pushArgs(args []ir.Register) {
+	for i := len(args) - 1; i >= 0; i-- {
+		g.load(args[i])
+		g.emit("    PSHS D")
+	}
+}
+
+// popArgs drops the arguments after a call without touching D
+func (g *M6809Generator) popArgs(args []ir.Register) {
+	if len(args) > 0 {
+		g.emit("    LEAS %d,S", 2*len(args))
+	}
+}
+
+// callHelper calls a runtime routine and marks it for output
+func (g *M6809Generator) callHelper(name string) {
+	g.usedHelpers[name] = true
+	g.emit("    JSR %s", name)
+}
+
+// offset returns the frame offset of a virtual register's slot
+func (g *M6809Generator) offset(reg ir.Register) int {
+	return -2 * int(reg)
+}
+
+// slot returns the operand addressing a virtual register's slot
+func (g *M6809Generator) slot(reg ir.Register) string {
+	return fmt.Sprintf("%d,U", g.offset(reg))
+}
+
+// load loads a virtual register into D
+func (g *M6809Generator) load(reg ir.Register) {
+	if reg == ir.RegZero {
+		g.emit("    LDD #0")
+		return
+	}
+	g.emit("    LDD %s", g.slot(reg))
+}
+
+// store stores D into a virtual register
+func (g *M6809Generator) store(reg ir.Register) {
+	if reg == ir.RegZero {
+		return
+	}
+	g.emit("    STD %s", g.slot(reg))
+}
+
+// narrow truncates D to an 8-bit type, extending it back to 16 bits
+func (g *M6809Generator) narrow(t ir.Type) {
+	if t == nil || t.Size() != 1 {
+		return
+	}
+	if isSignedType(t) {
+		g.emit("    SEX")
+	} else {
+		g.emit("    CLRA")
+	}
+}
+
+// loadIndexed loads a value of type t from an indexed operand into D
+func (g *M6809Generator) loadIndexed(operand string, t ir.Type) {
+	if t != nil && t.Size() == 1 {
+		g.emit("    LDB %s", operand)
+		g.narrow(t)
+		return
+	}
+	g.emit("    LDD %s", operand)
+}
+
+// storeIndexed stores D as a value of type t to an indexed operand
+func (g *M6809Generator) storeIndexed(operand string, t ir.Type) {
+	if t != nil && t.Size() == 1 {
+		g.emit("    STB %s", operand)
+		return
+	}
+	g.emit("    STD %s", operand)
+}
+
+// lookup resolves a variable name to its operand in the current frame, or
+// returns ok=false for globals
+func (g *M6809Generator) lookup(name string) (operand string, t ir.Type, ok bool) {
+	for i, p := range g.currentFunc.Params {
+		if p.Name == name {
+			return fmt.Sprintf("%d,U", 4+2*i), p.Type, true
+		}
+	}
+	for _, l := range g.currentFunc.Locals {
+		if l.Name == name {
+			if off, big := g.bigLocals[name]; big {
+				return fmt.Sprintf("%d,U", off), l.Type, true
+			}
+			return g.slot(l.Reg), l.Type, true
+		}
+	}
+	return "", nil, false
+}
+
+// globalType returns the type of a global, or nil if there is none
+func (g *M6809Generator) globalType(name string) ir.Type {
+	for _, global := range g.module.Globals {
+		if global.Name == name {
+			return global.Type
+		}
+	}
+	return nil
+}
+
+// loadSymbol loads a variable into D. Locals larger than a word load their
+// address, as arrays do.
+func (g *M6809Generator) loadSymbol(name string) {
+	if operand, _, ok := g.lookup(name); ok {
+		if _, big := g.bigLocals[name]; big {
+			g.emit("    LEAX %s", operand)
+			g.emit("    TFR X,D")
+			return
+		}
+		g.emit("    LDD %s", operand)
+		return
+	}
+	t := g.globalType(name)
+	if t != nil && t.Size() > 2 {
+		g.emit("    LDD #%s", g.symbol(name))
+		return
+	}
+	g.loadIndexed(g.symbol(name), t)
+}
+
+// storeSymbol stores D into a variable
+func (g *M6809Generator) storeSymbol(name string) {
+	if operand, _, ok := g.lookup(name); ok {
+		g.emit("    STD %s", operand)
+		return
+	}
+	g.storeIndexed(g.symbol(name), g.globalType(name))
+}
+
+// loadAddress loads the address of a variable into X
+func (g *M6809Generator) loadAddress(name string) {
+	if operand, _, ok := g.lookup(name); ok {
+		g.emit("    LEAX %s", operand)
+		return
+	}
+	g.emit("    LDX #%s", g.symbol(name))
+}
+
+// symbol makes an IR name assembler-friendly
+func (g *M6809Generator) symbol(name string) string {
+	name = strings.TrimLeft(name, ".")
+	name = strings.ReplaceAll(name, ".", "_")
+	return strings.ReplaceAll(name, "$", "_")
+}
+
+// label scopes an IR label to the current function
+func (g *M6809Generator) label(name string) string {
+	return g.symbol(g.currentFunc.Name + "_" + name)
+}
+
+// newLabel returns a fresh label in the current function
+func (g *M6809Generator) newLabel(prefix string) string {
+	g.labelCounter++
+	return fmt.Sprintf("%s_%s_%d", g.symbol(g.currentFunc.Name), prefix, g.labelCounter)
+}
+
+// m6809HelperDeps lists the routines each runtime routine calls
+var m6809HelperDeps = map[string][]string{
+	"print_u8_decimal":  {"print_decimal"},
+	"print_u16_decimal": {"print_decimal"},
+	"print_i8_decimal":  {"print_decimal"},
+	"print_i16_decimal": {"print_decimal"},
+	"print_decimal":     {"__div16", "print_char"},
+	"print_hex_u8":      {"print_char"},
+	"print_bool":        {"print_string"},
+	"print_string":      {"print_char"},
+	"print_newline":     {"print_char"},
+}
+
+// m6809Helpers holds the runtime routines. Characters go out through the
+// CHROUT vector at $A002, which the CoCo and Dragon ROMs share.
+var m6809Helpers = map[string][]string{
+	"print_char": {
+		"print_char:             ; B = character",
+		"    PSHS A",
+		"    TFR B,A",
+		"    JSR [$A002]         ; CHROUT",
+		"    PULS A,PC",
+	},
+	"print_newline": {
+		"print_newline:",
+		"    LDB #13",
+		"    LBRA print_char",
+	},
+	"print_string": {
+		"print_string:           ; X = length-prefixed string",
+		"    PSHS D,X",
+		"    LDA ,X+             ; Length",
+		"    BEQ print_string_done",
+		"print_string_loop:",
+		"    LDB ,X+",
+		"    LBSR print_char",
+		"    DECA",
+		"    BNE print_string_loop",
+		"print_string_done:",
+		"    PULS D,X,PC",
+	},
+	"print_bool": {
+		"print_bool:             ; B = bool",
+		"    PSHS X",
+		"    LEAX print_bool_true,PCR",
+		"    TSTB",
+		"    BNE print_bool_out",
+		"    LEAX print_bool_false,PCR",
+		"print_bool_out:",
+		"    LBSR print_string",
+		"    PULS X,PC",
+		"print_bool_true:",
+		"    FCB 4",
+		"    FCC \"true\"",
+		"print_bool_false:",
+		"    FCB 5",
+		"    FCC \"false\"",
+	},
+	"print_hex_u8": {
+		"print_hex_u8:           ; B = value",
+		"    PSHS B",
+		"    LSRB",
+		"    LSRB",
+		"    LSRB",
+		"    LSRB",
+		"    BSR print_hex_digit",
+		"    PULS B",
+		"print_hex_digit:",
+		"    ANDB #$0F",
+		"    ADDB #$30",
+		"    CMPB #$39",
+		"    BLS print_hex_digit_out",
+		"    ADDB #7",
+		"print_hex_digit_out:",
+		"    LBRA print_char",
+	},
+	"print_decimal": {
+		"print_i8_decimal:       ; B = value",
+		"    SEX",
+		"print_i16_decimal:      ; D = value",
+		"    TSTA",
+		"    BPL print_u16_decimal",
+		"    PSHS D",
+		"    LDB #$2D            ; '-'",
+		"    LBSR print_char",
+		"    PULS D",
+		"    COMA",
+		"    COMB",
+		"    ADDD #1",
+		"    BRA print_u16_decimal",
+		"print_u8_decimal:       ; B = value",
+		"    CLRA",
+		"print_u16_decimal:      ; D = value",
+		"    PSHS X,Y",
+		"    LDY #0              ; Digit count",
+		"print_decimal_split:",
+		"    LDX #10",
+		"    LBSR __div16        ; D = D / 10, X = digit",
+		"    PSHS X",
+		"    LEAY 1,Y",
+		"    CMPD #0",
+		"    BNE print_decimal_split",
+		"print_decimal_out:",
+		"    PULS D",
+		"    ADDB #$30",
+		"    LBSR print_char",
+		"    LEAY -1,Y",
+		"    BNE print_decimal_out",
+		"    PULS X,Y,PC",
+	},
+	"__mul16": {
+		"__mul16:                ; D = D * X (low 16 bits)",
+		"    PSHS D,X            ; 0,S = a, 2,S = b",
+		"    LDA 1,S",
+		"    LDB 3,S",
+		"    MUL                 ; alo * blo",
+		"    PSHS D              ; Offsets move up by 2",
+		"    LDA 2,S",
+		"    LDB 5,S",
+		"    MUL                 ; ahi * blo",
+		"    ADDB ,S",
+		"    STB ,S",
+		"    LDA 3,S",
+		"    LDB 4,S",
+		"    MUL                 ; alo * bhi",
+		"    ADDB ,S",
+		"    STB ,S",
+		"    PULS D",
+		"    LEAS 4,S",
+		"    RTS",
+	},
+	"__div16": {
+		"__div16:                ; D = D / X, X = D % X (unsigned)",
+		"    PSHS D,X            ; 0,S = dividend/quotient, 2,S = divisor",
+		"    LDD #0              ; Remainder",
+		"    LDX #16",
+		"__div16_loop:",
+		"    LSL 1,S",
+		"    ROL ,S",
+		"    ROLB",
+		"    ROLA",
+		"    CMPD 2,S",
+		"    BLO __div16_next",
+		"    SUBD 2,S",
+		"    INC 1,S",
+		"__div16_next:",
+		"    LEAX -1,X",
+		"    BNE __div16_loop",
+		"    TFR D,X",
+		"    PULS D",
+		"    LEAS 2,S",
+		"    RTS",
+	},
+}
+
+// generateHelpers appends the runtime routines the code calls
+func (g *M6809Generator) generateHelpers() {
+	var pending []string
+	for name := range g.usedHelpers {
+		pending = append(pending, name)
+	}
+	for len(pending) > 0 {
+		name := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, dep := range m6809HelperDeps[name] {
+			if !g.usedHelpers[dep] {
+				g.usedHelpers[dep] = true
+				pending = append(pending, dep)
+			}
+		}
+	}
+
+	var names []string
+	for name := range g.usedHelpers {
+		if _, ok := m6809Helpers[name]; ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+
+	g.emit("\n; Runtime routines")
+	for _, name := range names {
+		g.emit("")
+		for _, line := range m6809Helpers[name] {
+			g.emit("%s", line)
+		}
+	}
+}
+
+// emit writes a line to the output
+func (g *M6809Generator) emit(format string, args ...interface{}) {
+	fmt.Fprintf(g.writer, format+"\n", args...)
+}
@@ -0,0 +1,97 @@
+package codegen
+
+import (
+	"bytes"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// M6809Backend implements the Backend interface for Motorola 6809 code generation
+// (Tandy Color Computer, Dragon 32/64). The 6809 is a poor fit for SMC but
+// has what the Z80 lacks:
+// - Two stack pointers (S and U) and two index registers (X and Y)
+// - Indexed addressing with offsets, auto-increment and accumulator offsets
+// - Position-independent code (PC-relative addressing, long branches)
+// - Hardware 8x8 multiply (MUL)
+type M6809Backend struct {
+	BaseBackend
+}
+
+// NewM6809Backend creates a new 6809 backend
+func NewM6809Backend(options *BackendOptions) Backend {
+	backend := &M6809Backend{
+		BaseBackend: NewBaseBackend(options),
+	}
+
+	// Configure 6809-specific features
+	backend.SetFeature(FeatureSelfModifyingCode, false) // Stack frames make SMC unnecessary
+	backend.SetFeature(FeatureInterrupts, true)
+	backend.SetFeature(FeatureShadowRegisters, false)
+	backend.SetFeature(Feature16BitPointers, true)
+	backend.SetFeature(Feature24BitPointers, false)
+	backend.SetFeature(FeatureFloatingPoint, false)
+	backend.SetFeature(FeatureFixedPoint, true)
+	backend.SetFeature(FeatureHardwareMultiply, true) // MUL: A * B -> D
+	backend.SetFeature(FeatureHardwareDivide, false)
+	backend.SetFeature(FeatureIndirectCalls, true)
+	backend.SetFeature(FeatureInlineAssembly, true)
+	backend.SetFeature(FeatureBitManipulation, true)
+	backend.SetFeature(FeatureZeroPage, false) // Direct page exists but is not used
+	backend.SetFeature(FeatureBlockInstructions, false)
+	backend.SetFeature("indexed_addressing", true)
+
+	return backend
+}
+
+// Name returns the name of this backend
+func (b *M6809Backend) Name() string {
+	return "6809"
+}
+
+// Generate generates 6809 assembly code for the given IR module
+func (b *M6809Backend) Generate(module *ir.Module) (string, error) {
+	// Validate options
+	if err := b.ValidateOptions(); err != nil {
+		return "", err
+	}
+
+	// Preprocess module based on backend capabilities
+	if err := b.PreprocessModule(module); err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	gen := NewM6809Generator(&buf)
+	if b.options != nil {
+		gen.deterministic = b.options.Deterministic
+		if b.options.TargetAddress != 0 {
+			gen.origin = b.options.TargetAddress
+		}
+	}
+
+	if err := gen.Generate(module); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// GetFileExtension returns the file extension for 6809 assembly
+func (b *M6809Backend) GetFileExtension() string {
+	return ".asm" // lwasm source
+}
+
+// SupportsFeature checks if the 6809 backend supports a specific feature
+func (b *M6809Backend) SupportsFeature(feature string) bool {
+	return b.CheckFeature(feature)
+}
+
+// Register the 6809 backend
+func init() {
+	RegisterBackend("6809", func(options *BackendOptions) Backend {
+		return NewM6809Backend(options)
+	})
+	RegisterBackend("m6809", func(options *BackendOptions) Backend {
+		return NewM6809Backend(options)
+	})
+}
@@ -0,0 +1,90 @@
+package codegen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/parser"
+	"github.com/minz/minzc/pkg/semantic"
+)
+
+func TestM6809Backend(t *testing.T) {
+	source := `fun mul(a: u8, b: u8) -> u8 {
+    return a * b;
+}
+
+fun scale(n: u16, k: u16) -> u16 {
+    return n * k;
+}
+
+fun main() -> void {
+    let x: u8 = mul(6, 7);
+    let y: u16 = scale(300, 2);
+    if x > 40 {
+        print_u8(x);
+    }
+}
+`
+	path := filepath.Join(t.TempDir(), "coco.minz")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := parser.NewAntlrParser().ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	module, err := semantic.NewAnalyzer().Analyze(file)
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	backend := GetBackend("6809", &BackendOptions{Deterministic: true})
+	if backend == nil {
+		t.Fatal("6809 backend is not registered")
+	}
+	for feature, want := range map[string]bool{
+		FeatureSelfModifyingCode: false,
+		Feature16BitPointers:     true,
+		FeatureHardwareMultiply:  true,
+		FeatureHardwareDivide:    false,
+		FeatureShadowRegisters:   false,
+	} {
+		if got := backend.SupportsFeature(feature); got != want {
+			t.Errorf("SupportsFeature(%s) = %v, want %v", feature, got, want)
+		}
+	}
+
+	asm, err := backend.Generate(module)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, want := range []string{
+		"    LEAU ,S\n",                       // Frame pointer
+		"    LDD 4,U ; a\n",                   // First parameter
+		"    MUL\n",                           // 8-bit multiply in hardware
+		"    JSR __mul16\n",                   // 16-bit multiply from three MULs
+		"    PSHS D\n",                        // Arguments on the stack
+		"    LEAS 4,S\n",                      // Caller drops them
+		"    PULS U,PC\n",                     // Return
+		"    BHI ",                            // Unsigned x > 40
+		"    JSR print_u8_decimal\n",          // Runtime call with D
+		"print_u16_decimal:      ; D = value", // and its routine
+		"    END start",
+	} {
+		if !strings.Contains(asm, want) {
+			t.Errorf("output is missing %q:\n%s", want, asm)
+		}
+	}
+	for _, unwanted := range []string{"; Generated:", "CALL ", "SMC enabled"} {
+		if strings.Contains(asm, unwanted) {
+			t.Errorf("output contains %q:\n%s", unwanted, asm)
+		}
+	}
+	for _, fn := range module.Functions {
+		if fn.IsSMCEnabled {
+			t.Errorf("function %s still has SMC enabled", fn.Name)
+		}
+	}
+}
@@ -3,64 +3,101 @@ package codegen
 import (
 	"fmt"
 	"io"
+	"sort"
 	"strings"
-	"time"
 
 	"github.com/minz/minzc/pkg/ir"
 )
 
-// M68kGenerator generates Motorola 68000 assembly from IR
+// M68kGenerator generates Motorola 68000 assembly (gas/vasm syntax) from IR.
+//
+// Every function gets a LINK frame addressed through A6:
+//
+//	8+4i(a6)  parameter i (pushed by the caller, last parameter first)
+//	4(a6)     return address
+//	0(a6)     caller's A6
+//	-4r(a6)   virtual register r, one longword slot each
+//	below     locals larger than a longword (arrays, structs)
+//
+// The most used virtual registers live in D2-D7 instead of their slots.
+// The prologue saves the ones a function uses with MOVEM and the epilogue
+// restores them, so they survive calls. D0/D1 and A0/A1 are scratch, and
+// results are returned in D0.
+//
+// Values are held zero- or sign-extended to 32 bits, so all arithmetic and
+// comparisons are longword operations, and results are cut back to their
+// type's width afterwards. Pointers are 32-bit: the 68000's address space
+// is a flat 24 bits, with no banking or segments to track. Multi-byte
+// values in memory are big-endian, as the CPU wants them; a 24-bit value,
+// or a word at an odd field offset, is moved a byte at a time, since word
+// accesses to odd addresses raise an address error.
 type M68kGenerator struct {
 	writer        io.Writer
 	module        *ir.Module
 	currentFunc   *ir.Function
-	
-	// Register allocation
-	dataRegs      []string // D0-D7 for data
-	addrRegs      []string // A0-A6 for addresses (A7 is SP)
-	regAlloc      map[ir.Register]string // Virtual to physical mapping
-	
-	stackOffset   int
 	labelCounter  int
-	emittedParams map[string]bool
+	deterministic bool
+
+	functions   map[string]bool         // Module functions, by IR name
+	dataRegs    map[ir.Register]string  // Virtual registers kept in D2-D7
+	regTypes    map[ir.Register]ir.Type // Types of the values in virtual registers
+	bigLocals   map[string]int          // Frame offsets of locals larger than a longword
+	saved       string                  // MOVEM register list of the current function
+	usedHelpers map[string]bool         // Runtime routines to append
 }
 
 // NewM68kGenerator creates a new 68000 code generator
 func NewM68kGenerator(w io.Writer) *M68kGenerator {
 	return &M68kGenerator{
-		writer:        w,
-		dataRegs:      []string{"d0", "d1", "d2", "d3", "d4", "d5", "d6", "d7"},
-		addrRegs:      []string{"a0", "a1", "a2", "a3", "a4", "a5", "a6"}, // a7 is SP
-		regAlloc:      make(map[ir.Register]string),
-		emittedParams: make(map[string]bool),
+		writer:      w,
+		functions:   make(map[string]bool),
+		usedHelpers: make(map[string]bool),
 	}
 }
 
+// m68kDataRegs are the callee-saved registers virtual registers can live in
+var m68kDataRegs = []string{"d2", "d3", "d4", "d5", "d6", "d7"}
+
+// m68kRuntime lists the runtime routines the analyzer calls by name. They
+// take their argument in D0 (A0 for strings) instead of on the stack.
+var m68kRuntime = map[string]bool{
+	"print_u8_decimal":  true,
+	"print_u16_decimal": true,
+	"print_i8_decimal":  true,
+	"print_i16_decimal": true,
+	"print_hex_u8":      true,
+	"print_bool":        true,
+	"print_string":      true,
+	"print_newline":     true,
+	"print_char":        true,
+}
+
 // Generate generates 68000 assembly for an IR module
 func (g *M68kGenerator) Generate(module *ir.Module) error {
 	g.module = module
+	for _, fn := range module.Functions {
+		g.functions[fn.Name] = true
+	}
 
-	// Write header
 	g.writeHeader()
 
-	// Generate data section
-	if len(module.Globals) > 0 || len(module.Strings) > 0 {
-		g.emit("\t.data")
-		g.emit("")
-		for _, global := range module.Globals {
-			g.generateGlobal(global)
-		}
-		
-		// Generate string literals
-		for _, str := range module.Strings {
-			g.generateString(*str)
+	// Fixed-address globals become equates
+	for _, global := range module.Globals {
+		if global.Address != nil {
+			g.emit("\t.equ %s,$%04X", g.symbol(global.Name), *global.Address)
 		}
 	}
 
-	// Generate code section
-	g.emit("\n\t.text")
+	g.emit("\t.text")
 	g.emit("\t.global _start")
 	g.emit("")
+	g.emit("| Entry point: exits when main returns")
+	g.emit("_start:")
+	if main := g.findMain(); main != "" {
+		g.emit("\tjsr %s", g.symbol(main))
+	}
+	g.emit("\tmoveq #0,d0")
+	g.emit("\ttrap #0\t\t| Exit")
 
 	// Generate functions
 	for _, fn := range module.Functions {
@@ -69,635 +106,1117 @@ func (g *M68kGenerator) Generate(module *ir.Module) error {
 		}
 	}
 
-	// Generate runtime helpers
-	g.generatePrintHelpers()
-	
-	// Entry point
-	g.emit("\n_start:")
-	g.emit("\tjsr main")
-	g.emit("\tmove.l #0,d0")
-	g.emit("\ttrap #0\t\t| Exit")
+	g.generateHelpers()
 
+	// Data section
+	if len(module.Globals) > 0 || len(module.Strings) > 0 {
+		g.emit("\n\t.data")
+		for _, global := range module.Globals {
+			g.generateGlobal(global)
+		}
+		for _, str := range module.Strings {
+			g.generateString(str)
+		}
+		g.emit("\t.even")
+	}
 	return nil
 }
 
 // writeHeader writes the assembly file header
 func (g *M68kGenerator) writeHeader() {
 	g.emit("| MinZ 68000 generated code")
-	g.emit("| Generated: %s", time.Now().Format("2006-01-02 15:04:05"))
+	if stamp := generatedTimestamp(g.deterministic); stamp != "" {
+		g.emit("| Generated: %s", stamp)
+	}
 	g.emit("| Target: Motorola 68000/68010/68020/68030/68040/68060")
 	g.emit("| Assembler: vasm/gas compatible")
 	g.emit("")
 }
 
-// generateGlobal generates a global variable
+// findMain returns the IR name of the main function, or ""
+func (g *M68kGenerator) findMain() string {
+	for _, fn := range g.module.Functions {
+		if fn.Name == "main" || strings.HasSuffix(fn.Name, ".main") {
+			return fn.Name
+		}
+	}
+	return ""
+}
+
+// m68kSize returns the size of a value of type t in memory. Pointers take
+// a longword, and so do the elements of pointer arrays.
+func m68kSize(t ir.Type) int {
+	switch t := t.(type) {
+	case nil:
+		return 2
+	case *ir.PointerType:
+		return 4
+	case *ir.ArrayType:
+		return t.Length * m68kSize(t.Element)
+	}
+	return t.Size()
+}
+
+// generateGlobal generates a global variable, aligned to a word
 func (g *M68kGenerator) generateGlobal(global ir.Global) {
-	g.emit("%s:", global.Name)
-	size := global.Type.Size()
-	
-	switch size {
+	if global.Address != nil {
+		return // Declared as an equate
+	}
+	value, hasValue := global.Init.(int64)
+	if !hasValue {
+		if v, ok := global.Init.(int); ok {
+			value = int64(v)
+		}
+	}
+
+	g.emit("\t.even")
+	g.emit("%s:", g.symbol(global.Name))
+	switch size := m68kSize(global.Type); size {
 	case 1:
-		g.emit("\t.byte 0")
+		g.emit("\t.byte %d", value&0xFF)
 	case 2:
-		g.emit("\t.word 0")
+		g.emit("\t.word %d", value&0xFFFF)
+	case 3:
+		g.emit("\t.byte %d,%d,%d", value>>16&0xFF, value>>8&0xFF, value&0xFF)
 	case 4:
-		g.emit("\t.long 0")
+		g.emit("\t.long %d", value&0xFFFFFFFF)
 	default:
-		g.emit("\t.space %d", size)
+		g.emit("\t.space %d\t\t| %s", size, global.Type.String())
 	}
 }
 
-// generateString generates a string literal
-func (g *M68kGenerator) generateString(str ir.String) {
-	g.emit("%s:", str.Label)
-	// Length-prefixed string
-	g.emit("\t.byte %d\t\t| Length", len(str.Value))
-	if len(str.Value) > 0 {
-		escaped := strings.ReplaceAll(str.Value, "\"", "\\\"")
-		g.emit("\t.ascii \"%s\"", escaped)
+// generateString generates a length-prefixed string literal
+func (g *M68kGenerator) generateString(str *ir.String) {
+	g.emit("%s:", g.symbol(str.Label))
+	if str.IsLong {
+		g.emit("\t.byte 255\t\t| LString marker")
+		g.emit("\t.byte %d,%d\t\t| Length", len(str.Value)>>8, len(str.Value)&0xFF)
+	} else {
+		g.emit("\t.byte %d\t\t| Length", len(str.Value))
 	}
-	g.emit("\t.align 2\t\t| Word align")
+	if len(str.Value) == 0 {
+		return
+	}
+	bytes := make([]string, 0, len(str.Value))
+	for i := 0; i < len(str.Value); i++ {
+		bytes = append(bytes, fmt.Sprintf("$%02X", str.Value[i]))
+	}
+	g.emit("\t.byte %s", strings.Join(bytes, ","))
 }
 
 // generateFunction generates a function
 func (g *M68kGenerator) generateFunction(fn *ir.Function) error {
 	g.currentFunc = fn
-	g.stackOffset = 0
-	g.regAlloc = make(map[ir.Register]string)
+	g.allocateRegisters(fn)
+
+	// Registers take the first slots below A6, larger locals go under them
+	frame := 4 * int(g.maxRegister(fn))
+	g.bigLocals = make(map[string]int)
+	for _, local := range fn.Locals {
+		if size := m68kSize(local.Type); size > 4 {
+			frame += (size + 1) &^ 1
+			g.bigLocals[local.Name] = -frame
+		}
+	}
+	if frame > 0x8000 {
+		return fmt.Errorf("function %s: %d-byte frame is too large for LINK", fn.Name, frame)
+	}
 
-	// Function label
 	g.emit("\n| Function: %s", fn.Name)
-	if fn.IsSMCEnabled {
-		g.emit("| SMC enabled - parameters can be patched")
+	if fn.IsInterrupt {
+		g.emit("| Interrupt handler: saves the scratch registers too")
 	}
-	g.emit("%s:", fn.Name)
+	g.emit("%s:", g.symbol(fn.Name))
 
 	// Prologue
-	g.generatePrologue()
-
-	// Allocate registers for locals
-	g.allocateRegisters()
+	g.emit("\tlink a6,#-%d", frame)
+	if g.saved != "" {
+		g.emit("\tmovem.l %s,-(sp)", g.saved)
+	}
 
-	// Generate instructions
-	for i, inst := range fn.Instructions {
-		if err := g.generateInstruction(&inst, i); err != nil {
-			return err
+	for i := range fn.Instructions {
+		if err := g.generateInstruction(&fn.Instructions[i]); err != nil {
+			return fmt.Errorf("function %s: %w", fn.Name, err)
 		}
 	}
 
 	// Epilogue (if not already returned)
-	if len(fn.Instructions) == 0 || fn.Instructions[len(fn.Instructions)-1].Op != ir.OpReturn {
+	if !fn.EndsWithReturn() {
 		g.generateEpilogue()
 	}
-
 	return nil
 }
 
-// generatePrologue generates function prologue
-func (g *M68kGenerator) generatePrologue() {
-	// Save frame pointer and set up new frame
-	frameSize := 0
-	if g.currentFunc != nil {
-		// Reserve space for locals
-		frameSize = len(g.currentFunc.Locals) * 4
+// allocateRegisters keeps the most used virtual registers of fn in D2-D7.
+// Registers whose address is taken stay in their slots.
+func (g *M68kGenerator) allocateRegisters(fn *ir.Function) {
+	g.dataRegs = make(map[ir.Register]string)
+	g.regTypes = make(map[ir.Register]ir.Type)
+	for _, p := range fn.Params {
+		g.regTypes[p.Reg] = p.Type
 	}
-	
-	if frameSize > 0 {
-		g.emit("\tlink a6,#-%d", frameSize)
-	} else {
-		g.emit("\tlink a6,#0")
-	}
-	
-	// Save registers we'll use (d2-d7, a2-a5 are callee-saved)
-	g.emit("\tmovem.l d2-d7/a2-a5,-(sp)")
-	g.stackOffset = 40 // 10 registers * 4 bytes
-	
-	// For SMC-enabled functions, set up parameter anchors  
-	if g.currentFunc != nil && g.currentFunc.IsSMCEnabled {
-		for i, param := range g.currentFunc.Params {
-			if i == 0 {
-				// First parameter in d0
-				g.emit("%s$param_%s:", g.currentFunc.Name, param.Name)
-				g.emit("\tmove.l #0,d0\t\t| SMC anchor for %s", param.Name)
-			}
+	for _, l := range fn.Locals {
+		g.regTypes[l.Reg] = l.Type
+	}
+
+	uses := make(map[ir.Register]int)
+	pinned := make(map[ir.Register]bool)
+	addressed := make(map[string]bool)
+	for i := range fn.Instructions {
+		inst := &fn.Instructions[i]
+		for _, r := range []ir.Register{inst.Dest, inst.Src1, inst.Src2, inst.Src3} {
+			uses[r]++
+		}
+		if inst.Op == ir.OpLoadParam {
+			uses[inst.Src1]-- // The parameter's index
+		}
+		for _, r := range inst.Args {
+			uses[r]++
+		}
+		for _, op := range inst.AsmOperands {
+			uses[op.Reg]++
+		}
+		if inst.Dest != 0 && inst.Type != nil && g.regTypes[inst.Dest] == nil {
+			g.regTypes[inst.Dest] = inst.Type
+		}
+		switch inst.Op {
+		case ir.OpAddr:
+			pinned[inst.Src1] = true
+		case ir.OpLoadAddr:
+			addressed[inst.Symbol] = true
+		}
+	}
+	for _, l := range fn.Locals {
+		if addressed[l.Name] {
+			pinned[l.Reg] = true
 		}
 	}
-}
 
-// generateEpilogue generates function epilogue
-func (g *M68kGenerator) generateEpilogue() {
-	// Restore registers
-	g.emit("\tmovem.l (sp)+,d2-d7/a2-a5")
-	
-	// Restore frame pointer
-	g.emit("\tunlk a6")
-	g.emit("\trts")
+	var candidates []ir.Register
+	for r, n := range uses {
+		if r > 0 && n >= 2 && !pinned[r] {
+			candidates = append(candidates, r)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if uses[a] != uses[b] {
+			return uses[a] > uses[b]
+		}
+		return a < b
+	})
+	if len(candidates) > len(m68kDataRegs) {
+		candidates = candidates[:len(m68kDataRegs)]
+	}
+	for i, r := range candidates {
+		g.dataRegs[r] = m68kDataRegs[i]
+	}
+
+	// MOVEM list of the registers to save
+	g.saved = ""
+	if n := len(candidates); n == 1 {
+		g.saved = "d2"
+	} else if n > 1 {
+		g.saved = fmt.Sprintf("d2-d%d", n+1)
+	}
+	if fn.IsInterrupt {
+		if g.saved == "" {
+			g.saved = "d0-d1/a0-a1"
+		} else {
+			g.saved = fmt.Sprintf("d0-d%d/a0-a1", len(candidates)+1)
+		}
+	}
 }
 
-// allocateRegisters assigns physical registers to virtual ones
-func (g *M68kGenerator) allocateRegisters() {
-	// Simple allocation: use data registers for most things
-	regIndex := 0
-	
-	for _, inst := range g.currentFunc.Instructions {
-		// Allocate destination register
-		if inst.Dest != 0 && g.regAlloc[inst.Dest] == "" {
-			if regIndex < len(g.dataRegs) {
-				g.regAlloc[inst.Dest] = g.dataRegs[regIndex]
-				regIndex++
-			} else {
-				// Spill to stack
-				g.regAlloc[inst.Dest] = fmt.Sprintf("-%d(a6)", g.stackOffset)
-				g.stackOffset += 4
-			}
+// maxRegister returns the highest virtual register fn uses
+func (g *M68kGenerator) maxRegister(fn *ir.Function) ir.Register {
+	max := fn.NextReg
+	see := func(r ir.Register) {
+		if r > max {
+			max = r
 		}
 	}
+	for _, p := range fn.Params {
+		see(p.Reg)
+	}
+	for _, l := range fn.Locals {
+		see(l.Reg)
+	}
+	for _, inst := range fn.Instructions {
+		see(inst.Dest)
+		see(inst.Src1)
+		see(inst.Src2)
+		see(inst.Src3)
+		for _, a := range inst.Args {
+			see(a)
+		}
+	}
+	return max
+}
+
+// generateEpilogue restores the saved registers, tears down the frame and
+// returns; D0 holds the result
+func (g *M68kGenerator) generateEpilogue() {
+	if g.saved != "" {
+		g.emit("\tmovem.l (sp)+,%s", g.saved)
+	}
+	g.emit("\tunlk a6")
+	if g.currentFunc.IsInterrupt {
+		g.emit("\trte")
+		return
+	}
+	g.emit("\trts")
 }
 
 // generateInstruction generates code for a single instruction
-func (g *M68kGenerator) generateInstruction(inst *ir.Instruction, index int) error {
+func (g *M68kGenerator) generateInstruction(inst *ir.Instruction) error {
 	switch inst.Op {
-	case ir.OpLoadConst:
-		return g.generateLoadConst(inst)
+	case ir.OpNop:
+		return nil
+	case ir.OpSourceMark:
+		g.emit("| %s", inst.String())
+	case ir.OpLabel:
+		g.emit("%s:", g.label(inst.Label))
+
+	case ir.OpLoadConst, ir.OpLoadImm, ir.OpSMCLoadConst:
+		g.loadConst(inst.Imm, inst.Dest)
+	case ir.OpMove:
+		g.load(inst.Src1)
+		g.store(inst.Dest)
 	case ir.OpLoadVar:
-		return g.generateLoadVar(inst)
+		g.loadSymbol(inst.Symbol)
+		g.store(inst.Dest)
 	case ir.OpStoreVar:
-		return g.generateStoreVar(inst)
+		g.load(inst.Src1)
+		if inst.Symbol == "" {
+			g.store(inst.Dest) // Local by register
+		} else {
+			g.storeSymbol(inst.Symbol)
+		}
 	case ir.OpLoadParam:
-		return g.generateLoadParam(inst)
-	case ir.OpAdd:
-		return g.generateAdd(inst)
-	case ir.OpSub:
-		return g.generateSub(inst)
+		g.emit("\tmove.l %d(a6),d0\t\t| %s", 8+4*int(inst.Src1), inst.Symbol)
+		g.store(inst.Dest)
+	case ir.OpLoadAddr:
+		if inst.Symbol == "" {
+			g.load(inst.Src1) // Address already in a register
+		} else {
+			g.loadAddress(inst.Symbol)
+			g.emit("\tmove.l a0,d0")
+		}
+		g.store(inst.Dest)
+	case ir.OpAddr:
+		g.emit("\tlea %s,a0", g.valueAddress(-4*int(inst.Src1), g.regTypes[inst.Src1]))
+		g.emit("\tmove.l a0,d0")
+		g.store(inst.Dest)
+	case ir.OpLoadLabel, ir.OpLoadString:
+		g.emit("\tmove.l #%s,d0", g.symbol(inst.Symbol))
+		g.store(inst.Dest)
+
+	case ir.OpAdd, ir.OpSub, ir.OpAnd, ir.OpOr, ir.OpXor:
+		mnemonic := map[ir.Opcode]string{
+			ir.OpAdd: "add", ir.OpSub: "sub", ir.OpAnd: "and", ir.OpOr: "or", ir.OpXor: "eor",
+		}[inst.Op]
+		g.load(inst.Src1)
+		if inst.Op == ir.OpXor {
+			g.loadTo(inst.Src2, "d1") // EOR takes only a data register source
+			g.emit("\teor.l d1,d0")
+		} else {
+			g.emit("\t%s.l %s,d0", mnemonic, g.operand(inst.Src2))
+		}
+		g.narrow(inst.Type)
+		g.store(inst.Dest)
+	case ir.OpAddImm:
+		g.load(inst.Src1)
+		g.emit("\tadd.l #%d,d0", inst.Imm)
+		g.narrow(inst.Type)
+		g.store(inst.Dest)
+	case ir.OpInc, ir.OpDec, ir.OpNeg, ir.OpNot:
+		g.load(inst.Src1)
+		g.emit("\t%s", map[ir.Opcode]string{
+			ir.OpInc: "addq.l #1,d0", ir.OpDec: "subq.l #1,d0", ir.OpNeg: "neg.l d0", ir.OpNot: "not.l d0",
+		}[inst.Op])
+		g.narrow(inst.Type)
+		g.store(inst.Dest)
 	case ir.OpMul:
-		return g.generateMul(inst)
-	case ir.OpDiv:
-		return g.generateDiv(inst)
-	case ir.OpMod:
-		return g.generateMod(inst)
-	case ir.OpAnd:
-		return g.generateAnd(inst)
-	case ir.OpOr:
-		return g.generateOr(inst)
-	case ir.OpXor:
-		return g.generateXor(inst)
-	case ir.OpNot:
-		return g.generateNot(inst)
-	case ir.OpShl:
-		return g.generateShl(inst)
-	case ir.OpShr:
-		return g.generateShr(inst)
-	case ir.OpLt:
-		return g.generateLT(inst)
-	case ir.OpLe:
-		return g.generateLE(inst)
-	case ir.OpGt:
-		return g.generateGT(inst)
-	case ir.OpGe:
-		return g.generateGE(inst)
-	case ir.OpEq:
-		return g.generateEQ(inst)
-	case ir.OpNe:
-		return g.generateNE(inst)
+		g.generateMul(inst)
+	case ir.OpDiv, ir.OpMod:
+		g.generateDivMod(inst)
+	case ir.OpShl, ir.OpShr:
+		g.generateShift(inst)
+	case ir.OpLogicalAnd, ir.OpLogicalOr:
+		g.generateLogical(inst)
+
+	case ir.OpEq, ir.OpNe, ir.OpLt, ir.OpGt, ir.OpLe, ir.OpGe:
+		g.generateComparison(inst)
+
+	case ir.OpJump:
+		g.emit("\tbra %s", g.label(inst.Label))
+	case ir.OpJumpIf, ir.OpJumpIfNotZero:
+		g.emit("\ttst.l %s", g.operand(inst.Src1))
+		g.emit("\tbne %s", g.label(jumpTarget(inst)))
+	case ir.OpJumpIfNot, ir.OpJumpIfZero:
+		g.emit("\ttst.l %s", g.operand(inst.Src1))
+		g.emit("\tbeq %s", g.label(jumpTarget(inst)))
+	case ir.OpDJNZ:
+		g.emit("\tsubq.l #1,%s", g.operand(inst.Src1))
+		g.emit("\tbne %s", g.label(inst.Label))
+
 	case ir.OpCall:
-		return g.generateCall(inst)
+		g.generateCall(inst)
+	case ir.OpCallIndirect:
+		g.pushArgs(inst.Args)
+		g.emit("\tmovea.l %s,a0", g.operand(inst.Src1))
+		g.emit("\tjsr (a0)")
+		g.popArgs(inst.Args)
+		if inst.Dest != 0 {
+			g.store(inst.Dest)
+		}
 	case ir.OpReturn:
-		return g.generateReturn(inst)
-	case ir.OpJump:
-		return g.generateJump(inst)
-	case ir.OpJumpIf:
-		return g.generateJumpIf(inst)
-	case ir.OpJumpIfNot:
-		return g.generateJumpIfNot(inst)
-	case ir.OpLabel:
-		g.emit("%s:", inst.Label)
-		return nil
+		if inst.Src1 != 0 {
+			g.load(inst.Src1)
+		}
+		g.generateEpilogue()
+
+	case ir.OpLoadPtr, ir.OpLoad:
+		g.emit("\tmovea.l %s,a0", g.operand(inst.Src1))
+		g.loadMem("a0", 0, inst.Type)
+		g.store(inst.Dest)
+	case ir.OpStorePtr, ir.OpStore:
+		g.emit("\tmovea.l %s,a0", g.operand(inst.Src1))
+		g.load(inst.Src2)
+		g.storeMem("a0", 0, inst.Type)
+	case ir.OpLoadField:
+		g.emit("\tmovea.l %s,a0", g.operand(inst.Src1))
+		g.loadMem("a0", int(inst.Imm), inst.Type)
+		g.store(inst.Dest)
+	case ir.OpStoreField:
+		g.emit("\tmovea.l %s,a0", g.operand(inst.Src1))
+		g.load(inst.Src2)
+		g.storeMem("a0", int(inst.Imm), inst.Type)
+	case ir.OpLoadIndex:
+		g.elementAddress(inst)
+		g.loadMem("a0", 0, inst.Type)
+		g.store(inst.Dest)
+	case ir.OpStoreIndex:
+		g.elementAddress(inst)
+		g.load(inst.Src3)
+		g.storeMem("a0", 0, inst.Type)
+	case ir.OpLoadDirect:
+		g.loadMem("", int(inst.Imm), inst.Type)
+		g.store(inst.Dest)
+	case ir.OpStoreDirect:
+		g.load(inst.Src1)
+		g.storeMem("", int(inst.Imm), inst.Type)
+
 	case ir.OpPrint:
-		return g.generatePrint(inst)
+		g.load(inst.Src1)
+		g.callHelper("print_char")
 	case ir.OpPrintU8:
-		return g.generatePrintU8(inst)
+		g.load(inst.Src1)
+		g.callHelper("print_u8_decimal")
+	case ir.OpPrintU16:
+		g.load(inst.Src1)
+		g.callHelper("print_u16_decimal")
+	case ir.OpPrintI8:
+		g.load(inst.Src1)
+		g.callHelper("print_i8_decimal")
+	case ir.OpPrintI16:
+		g.load(inst.Src1)
+		g.callHelper("print_i16_decimal")
+	case ir.OpPrintBool:
+		g.load(inst.Src1)
+		g.callHelper("print_bool")
+	case ir.OpPrintString:
+		g.emit("\tmovea.l %s,a0", g.operand(inst.Src1))
+		g.callHelper("print_string")
 	case ir.OpPrintStringDirect:
-		return g.generatePrintStringDirect(inst)
+		if inst.Comment != "" {
+			g.emit("\t| %s", inst.Comment)
+		}
+		for i := 0; i < len(inst.Symbol); i++ {
+			g.emit("\tmoveq #$%02X,d0", inst.Symbol[i])
+			g.callHelper("print_char")
+		}
+
 	case ir.OpAsm:
 		return g.generateAsm(inst)
+
+	case ir.OpPatchTemplate, ir.OpPatchTarget, ir.OpPatchParam:
+		// Instruction patching needs SMC; the call that follows passes
+		// its arguments on the stack instead
+		g.emit("\t| %s skipped (no SMC)", inst.Op)
+
 	default:
-		// For now, emit a comment for unsupported operations
-		g.emit("\t| TODO: %s", inst.Op)
-		return nil
+		return fmt.Errorf("unsupported operation on 68000: %s", inst.Op)
 	}
+	return nil
 }
 
-// generateLoadConst generates code for loading a constant
-func (g *M68kGenerator) generateLoadConst(inst *ir.Instruction) error {
-	dest := g.getReg(inst.Dest)
-	value := inst.Imm
-	
-	if strings.HasPrefix(dest, "d") {
-		// Data register
-		if value >= -128 && value <= 127 {
-			g.emit("\tmoveq #%d,%s", value, dest)
+// generateMul uses MULU/MULS for operands up to 16 bits and the __mul32
+// routine for wider ones
+func (g *M68kGenerator) generateMul(inst *ir.Instruction) {
+	g.loadTo(inst.Src2, "d1")
+	g.load(inst.Src1)
+	switch {
+	case inst.Type == nil || inst.Type.Size() > 2:
+		g.callHelper("__mul32")
+	case isSignedType(inst.Type):
+		g.emit("\tmuls.w d1,d0")
+	default:
+		g.emit("\tmulu.w d1,d0")
+	}
+	g.narrow(inst.Type)
+	g.store(inst.Dest)
+}
+
+// generateDivMod divides with DIVU/DIVS, which leave the quotient in the
+// low word and the remainder in the high word, for operands up to 16 bits,
+// and through __divu32 or __divs32 for wider ones. The 68000 traps on
+// division by zero, so a zero divisor gives 0 instead.
+func (g *M68kGenerator) generateDivMod(inst *ir.Instruction) {
+	zero := g.newLabel("div_zero")
+	done := g.newLabel("div_done")
+
+	g.loadTo(inst.Src2, "d1") // MOVE sets Z
+	g.emit("\tbeq %s", zero)
+	g.load(inst.Src1)
+	if inst.Type != nil && inst.Type.Size() <= 2 {
+		if isSignedType(inst.Type) {
+			g.emit("\tdivs.w d1,d0")
 		} else {
-			g.emit("\tmove.l #%d,%s", value, dest)
+			g.emit("\tdivu.w d1,d0")
+		}
+		if inst.Op == ir.OpMod {
+			g.emit("\tswap d0")
 		}
 	} else {
-		// Memory location
-		g.emit("\tmove.l #%d,%s", value, dest)
+		if isSignedType(inst.Type) {
+			g.callHelper("__divs32")
+		} else {
+			g.callHelper("__divu32")
+		}
+		if inst.Op == ir.OpMod {
+			g.emit("\tmove.l d1,d0")
+		}
 	}
-	
-	return nil
+	g.narrow(inst.Type) // Also drops the other half of a DIVU/DIVS result
+	g.emit("\tbra %s", done)
+	g.emit("%s:", zero)
+	g.emit("\tmoveq #0,d0")
+	g.emit("%s:", done)
+	g.store(inst.Dest)
 }
 
-// generateAdd generates addition
-func (g *M68kGenerator) generateAdd(inst *ir.Instruction) error {
-	src1 := g.getReg(inst.Src1)
-	src2 := g.getReg(inst.Src2)
-	dest := g.getReg(inst.Dest)
-	
-	// 68k can add directly to destination
-	if dest == src1 {
-		g.emit("\tadd.l %s,%s", src2, dest)
-	} else if dest == src2 {
-		g.emit("\tadd.l %s,%s", src1, dest)
-	} else {
-		// Need to move first
-		g.emit("\tmove.l %s,%s", src1, dest)
-		g.emit("\tadd.l %s,%s", src2, dest)
+// generateShift shifts Src1 by the count in Src2. Signed values are already
+// sign-extended, so ASR works on every width.
+func (g *M68kGenerator) generateShift(inst *ir.Instruction) {
+	mnemonic := "lsl.l"
+	if inst.Op == ir.OpShr {
+		mnemonic = "lsr.l"
+		if isSignedType(inst.Type) {
+			mnemonic = "asr.l"
+		}
 	}
-	
-	return nil
+	g.loadTo(inst.Src2, "d1")
+	g.load(inst.Src1)
+	g.emit("\t%s d1,d0", mnemonic)
+	g.narrow(inst.Type)
+	g.store(inst.Dest)
 }
 
-// generatePrintHelpers generates print routines
-func (g *M68kGenerator) generatePrintHelpers() {
-	g.emit("\n| Print helpers")
-	
-	// Print character (platform-specific)
-	g.emit("print_char:")
-	g.emit("\t| Character in d0")
-	g.emit("\t| Platform-specific implementation needed")
-	g.emit("\t| Amiga: dos.library/Write")
-	g.emit("\t| Atari ST: GEMDOS Cconout")
-	g.emit("\t| Mac: _PBWrite trap")
-	g.emit("\trts")
-	
-	// Print hex byte
-	g.emit("\nprint_hex:")
-	g.emit("\tmove.b d0,d1")
-	g.emit("\tlsr.b #4,d0")
-	g.emit("\tbsr print_nibble")
-	g.emit("\tmove.b d1,d0")
-	g.emit("\tbsr print_nibble")
-	g.emit("\trts")
-	
-	g.emit("\nprint_nibble:")
-	g.emit("\tand.b #$0F,d0")
-	g.emit("\tcmp.b #10,d0")
-	g.emit("\tblt .digit")
-	g.emit("\tadd.b #'A'-10,d0")
-	g.emit("\tbra print_char")
-	g.emit(".digit:")
-	g.emit("\tadd.b #'0',d0")
-	g.emit("\tbra print_char")
+// generateLogical stores Src1 && Src2 or Src1 || Src2 as 0 or 1
+func (g *M68kGenerator) generateLogical(inst *ir.Instruction) {
+	short := g.newLabel("logic_short")
+	done := g.newLabel("logic_done")
+	branch, result, other := "beq", 0, 1 // && short-circuits to false
+	if inst.Op == ir.OpLogicalOr {
+		branch, result, other = "bne", 1, 0
+	}
+	g.emit("\ttst.l %s", g.operand(inst.Src1))
+	g.emit("\t%s %s", branch, short)
+	g.emit("\ttst.l %s", g.operand(inst.Src2))
+	g.emit("\t%s %s", branch, short)
+	g.emit("\tmoveq #%d,d0", other)
+	g.emit("\tbra %s", done)
+	g.emit("%s:", short)
+	g.emit("\tmoveq #%d,d0", result)
+	g.emit("%s:", done)
+	g.store(inst.Dest)
 }
 
-// Helper methods for other operations...
-
-func (g *M68kGenerator) getReg(reg ir.Register) string {
-	if reg == 0 {
-		return "d0" // Use d0 for temporary/return values
+// generateComparison stores the result of a comparison as 0 or 1. Operands
+// are held extended to 32 bits, so one CMP.L covers every width, and Scc
+// sets the low byte to $FF or 0 without a branch.
+func (g *M68kGenerator) generateComparison(inst *ir.Instruction) {
+	conditions := map[ir.Opcode][2]string{ // unsigned, signed
+		ir.OpEq: {"seq", "seq"},
+		ir.OpNe: {"sne", "sne"},
+		ir.OpLt: {"scs", "slt"},
+		ir.OpGt: {"shi", "sgt"},
+		ir.OpLe: {"sls", "sle"},
+		ir.OpGe: {"scc", "sge"},
+	}[inst.Op]
+	set := conditions[0]
+	if isSignedType(inst.Type) {
+		set = conditions[1]
 	}
-	if r, ok := g.regAlloc[reg]; ok {
-		return r
-	}
-	// Fallback to d0
-	return "d0"
-}
 
-func (g *M68kGenerator) generateLoadVar(inst *ir.Instruction) error {
-	dest := g.getReg(inst.Dest)
-	g.emit("\tmove.l %s,%s", inst.Symbol, dest)
-	return nil
+	g.load(inst.Src1)
+	g.emit("\tcmp.l %s,d0", g.operand(inst.Src2))
+	g.emit("\t%s d0", set)
+	g.emit("\tand.l #1,d0")
+	g.store(inst.Dest)
 }
 
-func (g *M68kGenerator) generateStoreVar(inst *ir.Instruction) error {
-	src := g.getReg(inst.Src1)
-	if inst.Symbol == "" {
-		// Local variable - use stack offset
-		g.emit("\tmove.l %s,-%d(a6)", src, g.stackOffset)
-		g.stackOffset += 4
-	} else {
-		// Global variable
-		g.emit("\tmove.l %s,%s", src, inst.Symbol)
+// generateCall calls a module function with its arguments on the stack, or
+// a runtime routine with its argument in D0 (A0 for strings)
+func (g *M68kGenerator) generateCall(inst *ir.Instruction) {
+	if !g.functions[inst.Symbol] && m68kRuntime[inst.Symbol] {
+		if len(inst.Args) > 0 {
+			if inst.Symbol == "print_string" {
+				g.emit("\tmovea.l %s,a0", g.operand(inst.Args[0]))
+			} else {
+				g.load(inst.Args[0])
+			}
+		}
+		g.callHelper(inst.Symbol)
+		return
+	}
+
+	g.pushArgs(inst.Args)
+	g.emit("\tjsr %s", g.symbol(inst.Symbol))
+	g.popArgs(inst.Args)
+	if inst.Dest != 0 {
+		g.store(inst.Dest)
 	}
-	return nil
 }
 
-func (g *M68kGenerator) generateSub(inst *ir.Instruction) error {
-	src1 := g.getReg(inst.Src1)
-	src2 := g.getReg(inst.Src2)
-	dest := g.getReg(inst.Dest)
-	
-	if dest != src1 {
-		g.emit("\tmove.l %s,%s", src1, dest)
+// pushArgs pushes call arguments last first, so parameter i is at 8+4i(a6)
+// in the callee
+func (g *M68kGenerator) pushArgs(args []ir.Register) {
+	for i := len(args) - 1; i >= 0; i-- {
+		g.emit("\tmove.l %s,-(sp)", g.operand(args[i]))
 	}
-	g.emit("\tsub.l %s,%s", src2, dest)
-	
-	return nil
 }
 
-func (g *M68kGenerator) generateMul(inst *ir.Instruction) error {
-	// 68000 multiply is signed 16x16->32
-	src1 := g.getReg(inst.Src1)
-	src2 := g.getReg(inst.Src2)
-	dest := g.getReg(inst.Dest)
-	
-	g.emit("\tmove.w %s,d0", src1)
-	g.emit("\tmuls.w %s,d0", src2)
-	g.emit("\tmove.l d0,%s", dest)
-	
-	return nil
+// popArgs drops the arguments after a call without touching D0
+func (g *M68kGenerator) popArgs(args []ir.Register) {
+	if len(args) > 0 {
+		g.emit("\tlea %d(sp),sp", 4*len(args))
+	}
 }
 
-func (g *M68kGenerator) generateCall(inst *ir.Instruction) error {
-	// Handle arguments - they should be in inst.Args
-	if inst.Args != nil {
-		for i, arg := range inst.Args {
-			src := g.getReg(arg)
-			if i < 4 {
-				// First 4 args in d0-d3
-				if src != fmt.Sprintf("d%d", i) {
-					g.emit("\tmove.l %s,d%d", src, i)
-				}
-			} else if i < 8 {
-				// Next 4 args in a0-a3
-				g.emit("\tmove.l %s,a%d", src, i-4)
+// generateAsm emits inline assembly. An operand {N} is replaced by where
+// its value lives, a data register or frame slot, which any instruction
+// accepts as an effective address. An operand constrained to a register
+// by name (d0, a1) is moved there before the code and, for outputs, back
+// after it.
+func (g *M68kGenerator) generateAsm(inst *ir.Instruction) error {
+	code := inst.AsmCode
+	var outputs []string
+	for n, op := range inst.AsmOperands {
+		location := g.operand(op.Reg)
+		switch reg := strings.ToLower(op.Constraint); {
+		case reg == "" || reg == "r" || reg == "g":
+		case len(reg) == 2 && (reg[0] == 'd' || reg[0] == 'a') && reg[1] >= '0' && reg[1] <= '7':
+			if !op.Output {
+				g.emit("\tmove.l %s,%s", location, reg)
 			} else {
-				// Rest on stack
-				g.emit("\tmove.l %s,-(sp)", src)
+				outputs = append(outputs, fmt.Sprintf("\tmove.l %s,%s", reg, location))
 			}
+			location = reg
+		default:
+			return fmt.Errorf("inline assembly operand {%d}: unknown register constraint %q", n, op.Constraint)
 		}
+		code = strings.ReplaceAll(code, fmt.Sprintf("{%d}", n), location)
 	}
-	
-	g.emit("\tjsr %s", inst.Symbol)
-	
-	// Clean up stack args if any
-	if inst.Args != nil && len(inst.Args) > 8 {
-		stackArgs := len(inst.Args) - 8
-		g.emit("\tadd.l #%d,sp", stackArgs*4)
+
+	if inst.AsmName != "" {
+		g.emit("%s:", inst.AsmName)
 	}
-	
-	if inst.Dest != 0 {
-		dest := g.getReg(inst.Dest)
-		if dest != "d0" {
-			g.emit("\tmove.l d0,%s", dest)
+	for _, line := range strings.Split(code, "\n") {
+		if strings.TrimSpace(line) != "" {
+			g.emit("\t%s", strings.TrimSpace(line))
 		}
 	}
+	for _, line := range outputs {
+		g.emit("%s", line)
+	}
 	return nil
 }
 
-func (g *M68kGenerator) generateReturn(inst *ir.Instruction) error {
-	if inst.Src1 != 0 {
-		src := g.getReg(inst.Src1)
-		if src != "d0" {
-			g.emit("\tmove.l %s,d0", src)
-		}
-	}
-	g.generateEpilogue()
-	return nil
+// callHelper calls a runtime routine and marks it for output
+func (g *M68kGenerator) callHelper(name string) {
+	g.usedHelpers[name] = true
+	g.emit("\tjsr %s", name)
 }
 
-func (g *M68kGenerator) generateJump(inst *ir.Instruction) error {
-	g.emit("\tbra %s", inst.Label)
-	return nil
+// slot returns the operand addressing a virtual register's frame slot
+func (g *M68kGenerator) slot(reg ir.Register) string {
+	return fmt.Sprintf("%d(a6)", -4*int(reg))
 }
 
-func (g *M68kGenerator) generateJumpIfNot(inst *ir.Instruction) error {
-	cond := g.getReg(inst.Src1)
-	g.emit("\ttst.l %s", cond)
-	g.emit("\tbeq %s", inst.Label)
-	return nil
+// operand returns where a virtual register lives: its data register, or
+// its slot. The zero register reads as an immediate.
+func (g *M68kGenerator) operand(reg ir.Register) string {
+	if reg == ir.RegZero {
+		return "#0"
+	}
+	if dn, ok := g.dataRegs[reg]; ok {
+		return dn
+	}
+	return g.slot(reg)
 }
 
-func (g *M68kGenerator) generatePrint(inst *ir.Instruction) error {
-	g.emit("\tbsr print_char")
-	return nil
+// load loads a virtual register into D0
+func (g *M68kGenerator) load(reg ir.Register) {
+	g.loadTo(reg, "d0")
 }
 
-func (g *M68kGenerator) generatePrintU8(inst *ir.Instruction) error {
-	src := g.getReg(inst.Src1)
-	if src != "d0" {
-		g.emit("\tmove.b %s,d0", src)
+// loadTo loads a virtual register into a scratch data register
+func (g *M68kGenerator) loadTo(reg ir.Register, dn string) {
+	if reg == ir.RegZero {
+		g.emit("\tmoveq #0,%s", dn)
+		return
 	}
-	g.emit("\tbsr print_hex")
-	return nil
+	g.emit("\tmove.l %s,%s", g.operand(reg), dn)
 }
 
-func (g *M68kGenerator) generatePrintStringDirect(inst *ir.Instruction) error {
-	g.emit("\t| TODO: Print string direct")
-	return nil
+// loadConst loads a constant into a virtual register
+func (g *M68kGenerator) loadConst(value int64, reg ir.Register) {
+	if reg == ir.RegZero {
+		return
+	}
+	dst := g.operand(reg)
+	switch {
+	case value >= -128 && value <= 127 && strings.HasPrefix(dst, "d"):
+		g.emit("\tmoveq #%d,%s", value, dst)
+	case value == 0:
+		g.emit("\tclr.l %s", dst)
+	default:
+		g.emit("\tmove.l #%d,%s", value, dst)
+	}
 }
 
-func (g *M68kGenerator) generateAsm(inst *ir.Instruction) error {
-	// Pass through inline assembly
-	lines := strings.Split(inst.Symbol, "\n")
-	for _, line := range lines {
-		if strings.TrimSpace(line) != "" {
-			g.emit("\t%s", line)
-		}
+// store stores D0 into a virtual register
+func (g *M68kGenerator) store(reg ir.Register) {
+	if reg == ir.RegZero {
+		return
 	}
-	return nil
+	g.emit("\tmove.l d0,%s", g.operand(reg))
 }
 
-// generateLoadParam loads a function parameter
-func (g *M68kGenerator) generateLoadParam(inst *ir.Instruction) error {
-	dest := g.getReg(inst.Dest)
-	// Parameters are passed in registers d0-d3 and a0-a3, then on stack
-	paramIndex := inst.Imm
-	
-	if paramIndex < 4 {
-		// First 4 params in d0-d3
-		g.emit("\tmove.l d%d,%s", paramIndex, dest)
-	} else if paramIndex < 8 {
-		// Next 4 params in a0-a3
-		g.emit("\tmove.l a%d,%s", paramIndex-4, dest)
-	} else {
-		// Rest on stack (after return address and old a6)
-		offset := 8 + (paramIndex-8)*4
-		g.emit("\tmove.l %d(a6),%s", offset, dest)
+// narrow cuts D0 to the width of type t, extending it back to 32 bits
+func (g *M68kGenerator) narrow(t ir.Type) {
+	basic, ok := t.(*ir.BasicType)
+	if !ok {
+		return // Pointers and unknown types use all 32 bits
+	}
+	switch basic.Kind {
+	case ir.TypeU8, ir.TypeBool:
+		g.emit("\tand.l #$FF,d0")
+	case ir.TypeI8:
+		g.emit("\text.w d0")
+		g.emit("\text.l d0")
+	case ir.TypeU16:
+		g.emit("\tand.l #$FFFF,d0")
+	case ir.TypeI16:
+		g.emit("\text.l d0")
+	case ir.TypeU24, ir.TypeF16_8, ir.TypeF8_16:
+		g.emit("\tand.l #$FFFFFF,d0")
+	case ir.TypeI24:
+		g.emit("\tlsl.l #8,d0")
+		g.emit("\tasr.l #8,d0")
 	}
-	return nil
 }
 
-// generateDiv generates division
-func (g *M68kGenerator) generateDiv(inst *ir.Instruction) error {
-	// 68000 division is 32/16->16q,16r
-	src1 := g.getReg(inst.Src1)
-	src2 := g.getReg(inst.Src2)
-	dest := g.getReg(inst.Dest)
-	
-	g.emit("\tmove.l %s,d0", src1)
-	g.emit("\tmove.w %s,d1", src2)
-	g.emit("\text.l d0\t\t| Sign extend for signed division")
-	g.emit("\tdivs.w d1,d0")
-	g.emit("\tmove.w d0,%s", dest)
-	
-	return nil
+// address formats the operand at offset off from base: an address
+// register, a symbol, or "" for an absolute address
+func (g *M68kGenerator) address(base string, off int) string {
+	switch {
+	case base == "":
+		return fmt.Sprintf("$%X", off)
+	case base == "a0" && off == 0:
+		return "(a0)"
+	case base == "a0":
+		return fmt.Sprintf("%d(a0)", off)
+	case off == 0:
+		return base
+	}
+	return fmt.Sprintf("%s+%d", base, off)
 }
 
-// generateMod generates modulo
-func (g *M68kGenerator) generateMod(inst *ir.Instruction) error {
-	src1 := g.getReg(inst.Src1)
-	src2 := g.getReg(inst.Src2)
-	dest := g.getReg(inst.Dest)
-	
-	g.emit("\tmove.l %s,d0", src1)
-	g.emit("\tmove.w %s,d1", src2)
-	g.emit("\text.l d0")
-	g.emit("\tdivs.w d1,d0")
-	g.emit("\tswap d0\t\t| Remainder is in upper word")
-	g.emit("\tmove.w d0,%s", dest)
-	
-	return nil
+// loadMem loads a value of type t at offset off from base into D0
+func (g *M68kGenerator) loadMem(base string, off int, t ir.Type) {
+	size := m68kSize(t)
+	if size == 1 || (size == 2 || size == 4) && off%2 == 0 {
+		g.emit("\tmove.%s %s,d0", map[int]string{1: "b", 2: "w", 4: "l"}[size], g.address(base, off))
+		g.narrow(t)
+		return
+	}
+	g.emit("\tmoveq #0,d0")
+	for i := 0; i < size && i < 4; i++ {
+		if i > 0 {
+			g.emit("\tlsl.l #8,d0")
+		}
+		g.emit("\tmove.b %s,d0", g.address(base, off+i))
+	}
+	g.narrow(t)
 }
 
-// Bitwise operations
-func (g *M68kGenerator) generateAnd(inst *ir.Instruction) error {
-	src1 := g.getReg(inst.Src1)
-	src2 := g.getReg(inst.Src2)
-	dest := g.getReg(inst.Dest)
-	
-	if dest != src1 {
-		g.emit("\tmove.l %s,%s", src1, dest)
+// storeMem stores D0 as a value of type t at offset off from base
+func (g *M68kGenerator) storeMem(base string, off int, t ir.Type) {
+	size := m68kSize(t)
+	if size == 1 || (size == 2 || size == 4) && off%2 == 0 {
+		g.emit("\tmove.%s d0,%s", map[int]string{1: "b", 2: "w", 4: "l"}[size], g.address(base, off))
+		return
+	}
+	g.emit("\tmove.l d0,d1")
+	for i := size - 1; i >= 0; i-- {
+		g.emit("\tmove.b d1,%s", g.address(base, off+i))
+		if i > 0 {
+			g.emit("\tlsr.l #8,d1")
+		}
 	}
-	g.emit("\tand.l %s,%s", src2, dest)
-	
-	return nil
 }
 
-func (g *M68kGenerator) generateOr(inst *ir.Instruction) error {
-	src1 := g.getReg(inst.Src1)
-	src2 := g.getReg(inst.Src2)
-	dest := g.getReg(inst.Dest)
-	
-	if dest != src1 {
-		g.emit("\tmove.l %s,%s", src1, dest)
+// elementAddress loads the address of element Src2 of the array at Src1
+// into A0
+func (g *M68kGenerator) elementAddress(inst *ir.Instruction) {
+	g.emit("\tmovea.l %s,a0", g.operand(inst.Src1))
+	g.loadTo(inst.Src2, "d1")
+	switch size := m68kSize(inst.Type); size {
+	case 1:
+	case 2:
+		g.emit("\tadd.l d1,d1")
+	case 4:
+		g.emit("\tlsl.l #2,d1")
+	default:
+		g.emit("\tmulu.w #%d,d1", size)
 	}
-	g.emit("\tor.l %s,%s", src2, dest)
-	
-	return nil
+	g.emit("\tadda.l d1,a0")
 }
 
-func (g *M68kGenerator) generateXor(inst *ir.Instruction) error {
-	src1 := g.getReg(inst.Src1)
-	src2 := g.getReg(inst.Src2)
-	dest := g.getReg(inst.Dest)
-	
-	if dest != src1 {
-		g.emit("\tmove.l %s,%s", src1, dest)
+// valueAddress returns the address of the value of type t in the longword
+// slot at off(a6), which is big-endian, so narrower values sit in its last
+// bytes
+func (g *M68kGenerator) valueAddress(off int, t ir.Type) string {
+	if size := m68kSize(t); size < 4 {
+		off += 4 - size
 	}
-	g.emit("\teor.l %s,%s", src2, dest)
-	
-	return nil
+	return fmt.Sprintf("%d(a6)", off)
 }
 
-func (g *M68kGenerator) generateNot(inst *ir.Instruction) error {
-	src := g.getReg(inst.Src1)
-	dest := g.getReg(inst.Dest)
-	
-	if dest != src {
-		g.emit("\tmove.l %s,%s", src, dest)
+// lookup resolves a variable name to its operand in the current frame, or
+// returns ok=false for globals
+func (g *M68kGenerator) lookup(name string) (operand string, t ir.Type, ok bool) {
+	for i, p := range g.currentFunc.Params {
+		if p.Name == name {
+			return fmt.Sprintf("%d(a6)", 8+4*i), p.Type, true
+		}
 	}
-	g.emit("\tnot.l %s", dest)
-	
-	return nil
+	for _, l := range g.currentFunc.Locals {
+		if l.Name == name {
+			if off, big := g.bigLocals[name]; big {
+				return fmt.Sprintf("%d(a6)", off), l.Type, true
+			}
+			return g.operand(l.Reg), l.Type, true
+		}
+	}
+	return "", nil, false
 }
 
-func (g *M68kGenerator) generateShl(inst *ir.Instruction) error {
-	src1 := g.getReg(inst.Src1)
-	src2 := g.getReg(inst.Src2)
-	dest := g.getReg(inst.Dest)
-	
-	g.emit("\tmove.l %s,%s", src1, dest)
-	g.emit("\tmove.b %s,d0", src2)
-	g.emit("\tlsl.l d0,%s", dest)
-	
+// globalType returns the type of a global, or nil if there is none
+func (g *M68kGenerator) globalType(name string) ir.Type {
+	for _, global := range g.module.Globals {
+		if global.Name == name {
+			return global.Type
+		}
+	}
 	return nil
 }
 
-func (g *M68kGenerator) generateShr(inst *ir.Instruction) error {
-	src1 := g.getReg(inst.Src1)
-	src2 := g.getReg(inst.Src2)
-	dest := g.getReg(inst.Dest)
-	
-	g.emit("\tmove.l %s,%s", src1, dest)
-	g.emit("\tmove.b %s,d0", src2)
-	g.emit("\tlsr.l d0,%s", dest)
-	
-	return nil
+// loadSymbol loads a variable into D0. Variables larger than a longword
+// load their address, as arrays do.
+func (g *M68kGenerator) loadSymbol(name string) {
+	if operand, _, ok := g.lookup(name); ok {
+		if _, big := g.bigLocals[name]; big {
+			g.emit("\tlea %s,a0", operand)
+			g.emit("\tmove.l a0,d0")
+			return
+		}
+		g.emit("\tmove.l %s,d0", operand)
+		return
+	}
+	t := g.globalType(name)
+	if m68kSize(t) > 4 {
+		g.emit("\tmove.l #%s,d0", g.symbol(name))
+		return
+	}
+	g.loadMem(g.symbol(name), 0, t)
 }
 
-// Comparison operations - set dest to 1 if true, 0 if false
-func (g *M68kGenerator) generateLT(inst *ir.Instruction) error {
-	return g.generateComparison(inst, "blt")
+// storeSymbol stores D0 into a variable
+func (g *M68kGenerator) storeSymbol(name string) {
+	if operand, _, ok := g.lookup(name); ok {
+		g.emit("\tmove.l d0,%s", operand)
+		return
+	}
+	g.storeMem(g.symbol(name), 0, g.globalType(name))
 }
 
-func (g *M68kGenerator) generateLE(inst *ir.Instruction) error {
-	return g.generateComparison(inst, "ble")
+// loadAddress loads the address of a variable into A0. Locals whose
+// address is taken are kept out of data registers.
+func (g *M68kGenerator) loadAddress(name string) {
+	for i, p := range g.currentFunc.Params {
+		if p.Name == name {
+			g.emit("\tlea %s,a0", g.valueAddress(8+4*i, p.Type))
+			return
+		}
+	}
+	for _, l := range g.currentFunc.Locals {
+		if l.Name == name {
+			if off, big := g.bigLocals[name]; big {
+				g.emit("\tlea %d(a6),a0", off)
+			} else {
+				g.emit("\tlea %s,a0", g.valueAddress(-4*int(l.Reg), l.Type))
+			}
+			return
+		}
+	}
+	g.emit("\tlea %s,a0", g.symbol(name))
 }
 
-func (g *M68kGenerator) generateGT(inst *ir.Instruction) error {
-	return g.generateComparison(inst, "bgt")
+// symbol makes an IR name assembler-friendly
+func (g *M68kGenerator) symbol(name string) string {
+	name = strings.TrimLeft(name, ".")
+	name = strings.ReplaceAll(name, ".", "_")
+	return strings.ReplaceAll(name, "$", "_")
 }
 
-func (g *M68kGenerator) generateGE(inst *ir.Instruction) error {
-	return g.generateComparison(inst, "bge")
+// label scopes an IR label to the current function
+func (g *M68kGenerator) label(name string) string {
+	return g.symbol(g.currentFunc.Name + "_" + name)
 }
 
-func (g *M68kGenerator) generateEQ(inst *ir.Instruction) error {
-	return g.generateComparison(inst, "beq")
+// newLabel returns a fresh label in the current function
+func (g *M68kGenerator) newLabel(prefix string) string {
+	g.labelCounter++
+	return fmt.Sprintf("%s_%s_%d", g.symbol(g.currentFunc.Name), prefix, g.labelCounter)
 }
 
-func (g *M68kGenerator) generateNE(inst *ir.Instruction) error {
-	return g.generateComparison(inst, "bne")
+// m68kHelperDeps lists the routines each runtime routine calls
+var m68kHelperDeps = map[string][]string{
+	"print_u8_decimal":  {"print_decimal"},
+	"print_u16_decimal": {"print_decimal"},
+	"print_i8_decimal":  {"print_decimal"},
+	"print_i16_decimal": {"print_decimal"},
+	"print_decimal":     {"print_char"},
+	"print_hex_u8":      {"print_char"},
+	"print_bool":        {"print_string"},
+	"print_string":      {"print_char"},
+	"print_newline":     {"print_char"},
+	"__divs32":          {"__divu32"},
 }
 
-// Helper for comparison operations
-func (g *M68kGenerator) generateComparison(inst *ir.Instruction, branch string) error {
-	src1 := g.getReg(inst.Src1)
-	src2 := g.getReg(inst.Src2)
-	dest := g.getReg(inst.Dest)
-	
-	label := g.newLabel()
-	
-	g.emit("\tcmp.l %s,%s", src2, src1)
-	g.emit("\t%s .true_%s", branch, label)
-	g.emit("\tmoveq #0,%s", dest)
-	g.emit("\tbra .end_%s", label)
-	g.emit(".true_%s:", label)
-	g.emit("\tmoveq #1,%s", dest)
-	g.emit(".end_%s:", label)
-	
-	return nil
+// m68kHelpers holds the runtime routines. Each saves the registers it uses
+// other than D0/D1 and A0/A1.
+var m68kHelpers = map[string][]string{
+	"print_char": {
+		"print_char:\t\t| d0 = character",
+		"\t| Platform-specific implementation needed",
+		"\t| Amiga: dos.library/Write",
+		"\t| Atari ST: GEMDOS Cconout",
+		"\t| Mac: _PBWrite trap",
+		"\trts",
+	},
+	"print_newline": {
+		"print_newline:",
+		"\tmoveq #10,d0",
+		"\tbra print_char",
+	},
+	"print_string": {
+		"print_string:\t\t| a0 = length-prefixed string",
+		"\tmovem.l d0-d2/a0,-(sp)",
+		"\tmoveq #0,d2",
+		"\tmove.b (a0)+,d2\t\t| Length",
+		"\tbra print_string_next",
+		"print_string_loop:",
+		"\tmove.b (a0)+,d0",
+		"\tjsr print_char",
+		"print_string_next:",
+		"\tdbra d2,print_string_loop",
+		"\tmovem.l (sp)+,d0-d2/a0",
+		"\trts",
+	},
+	"print_bool": {
+		"print_bool:\t\t| d0 = bool",
+		"\tlea print_bool_true,a0",
+		"\ttst.l d0",
+		"\tbne print_string",
+		"\tlea print_bool_false,a0",
+		"\tbra print_string",
+		"print_bool_true:",
+		"\t.byte 4",
+		"\t.ascii \"true\"",
+		"print_bool_false:",
+		"\t.byte 5",
+		"\t.ascii \"false\"",
+		"\t.even",
+	},
+	"print_hex_u8": {
+		"print_hex_u8:\t\t| d0 = value",
+		"\tmove.l d0,-(sp)",
+		"\tlsr.b #4,d0",
+		"\tbsr print_hex_digit",
+		"\tmove.l (sp)+,d0",
+		"print_hex_digit:",
+		"\tand.l #$0F,d0",
+		"\tadd.b #'0',d0",
+		"\tcmp.b #'9',d0",
+		"\tbls print_char",
+		"\taddq.b #7,d0",
+		"\tbra print_char",
+	},
+	"print_decimal": {
+		"print_i8_decimal:\t| d0 = value, sign-extended",
+		"print_i16_decimal:",
+		"\ttst.l d0",
+		"\tbpl print_u16_decimal",
+		"\tmove.l d0,-(sp)",
+		"\tmoveq #'-',d0",
+		"\tjsr print_char",
+		"\tmove.l (sp)+,d0",
+		"\tneg.l d0",
+		"print_u8_decimal:\t| d0 = value, zero-extended",
+		"print_u16_decimal:",
+		"\tmovem.l d2-d4,-(sp)",
+		"\tmoveq #0,d4\t\t| Digit count",
+		"print_decimal_split:",
+		"\tmove.l d0,d2\t\t| d0 / 10 in two DIVU steps",
+		"\tclr.w d2",
+		"\tswap d2",
+		"\tdivu.w #10,d2\t\t| High word",
+		"\tmove.w d2,d3",
+		"\tmove.w d0,d2",
+		"\tdivu.w #10,d2\t\t| Remainder:low word",
+		"\tswap d3",
+		"\tmove.w d2,d3\t\t| d3 = quotient",
+		"\tswap d2",
+		"\tmove.w d2,-(sp)\t\t| Digit",
+		"\taddq.w #1,d4",
+		"\tmove.l d3,d0",
+		"\tbne print_decimal_split",
+		"print_decimal_out:",
+		"\tmove.w (sp)+,d0",
+		"\tadd.b #'0',d0",
+		"\tjsr print_char",
+		"\tsubq.w #1,d4",
+		"\tbne print_decimal_out",
+		"\tmovem.l (sp)+,d2-d4",
+		"\trts",
+	},
+	"__mul32": {
+		"__mul32:\t\t| d0 = d0 * d1 (low 32 bits)",
+		"\tmovem.l d2-d3,-(sp)",
+		"\tmove.l d0,d2",
+		"\tswap d2",
+		"\tmulu.w d1,d2\t\t| ahi * blo",
+		"\tmove.l d1,d3",
+		"\tswap d3",
+		"\tmulu.w d0,d3\t\t| alo * bhi",
+		"\tadd.w d3,d2\t\t| Only the low words of these count",
+		"\tswap d2",
+		"\tclr.w d2",
+		"\tmulu.w d1,d0\t\t| alo * blo",
+		"\tadd.l d2,d0",
+		"\tmovem.l (sp)+,d2-d3",
+		"\trts",
+	},
+	"__divu32": {
+		"__divu32:\t\t| d0 = d0 / d1, d1 = d0 % d1 (unsigned)",
+		"\tmovem.l d2-d3,-(sp)",
+		"\tmoveq #0,d2\t\t| Remainder",
+		"\tmoveq #31,d3",
+		"__divu32_loop:",
+		"\tadd.l d0,d0\t\t| Next dividend bit into X",
+		"\taddx.l d2,d2\t\t| and into the remainder",
+		"\tcmp.l d1,d2",
+		"\tbcs __divu32_next",
+		"\tsub.l d1,d2",
+		"\taddq.l #1,d0\t\t| Quotient bit",
+		"__divu32_next:",
+		"\tdbra d3,__divu32_loop",
+		"\tmove.l d2,d1",
+		"\tmovem.l (sp)+,d2-d3",
+		"\trts",
+	},
+	"__divs32": {
+		"__divs32:\t\t| d0 = d0 / d1, d1 = d0 % d1 (signed)",
+		"\tmovem.l d2-d3,-(sp)",
+		"\tmove.l d0,d2\t\t| The remainder takes the dividend's sign",
+		"\tmove.l d0,d3",
+		"\teor.l d1,d3\t\t| and the quotient their product's",
+		"\ttst.l d0",
+		"\tbpl __divs32_divisor",
+		"\tneg.l d0",
+		"__divs32_divisor:",
+		"\ttst.l d1",
+		"\tbpl __divs32_divide",
+		"\tneg.l d1",
+		"__divs32_divide:",
+		"\tbsr __divu32",
+		"\ttst.l d3",
+		"\tbpl __divs32_remainder",
+		"\tneg.l d0",
+		"__divs32_remainder:",
+		"\ttst.l d2",
+		"\tbpl __divs32_done",
+		"\tneg.l d1",
+		"__divs32_done:",
+		"\tmovem.l (sp)+,d2-d3",
+		"\trts",
+	},
 }
 
-func (g *M68kGenerator) generateJumpIf(inst *ir.Instruction) error {
-	cond := g.getReg(inst.Src1)
-	g.emit("\ttst.l %s", cond)
-	g.emit("\tbne %s", inst.Label)
-	return nil
-}
+// generateHelpers appends the runtime routines the code calls
+func (g *M68kGenerator) generateHelpers() {
+	var pending []string
+	for name := range g.usedHelpers {
+		pending = append(pending, name)
+	}
+	for len(pending) > 0 {
+		name := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, dep := range m68kHelperDeps[name] {
+			if !g.usedHelpers[dep] {
+				g.usedHelpers[dep] = true
+				pending = append(pending, dep)
+			}
+		}
+	}
 
-// newLabel generates a unique label
-func (g *M68kGenerator) newLabel() string {
-	g.labelCounter++
-	return fmt.Sprintf("L%d", g.labelCounter)
+	var names []string
+	for name := range g.usedHelpers {
+		if _, ok := m68kHelpers[name]; ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+
+	g.emit("\n| Runtime routines")
+	for _, name := range names {
+		g.emit("")
+		for _, line := range m68kHelpers[name] {
+			g.emit("%s", line)
+		}
+	}
 }
 
 // emit writes a line to the output
 func (g *M68kGenerator) emit(format string, args ...interface{}) {
-	if len(args) > 0 {
-		fmt.Fprintf(g.writer, format+"\n", args...)
-	} else {
-		fmt.Fprintf(g.writer, format+"\n")
-	}
-}
\ No newline at end of file
+	fmt.Fprintf(g.writer, format+"\n", args...)
+}
@@ -2,19 +2,46 @@ package codegen
 
 import (
 	"bytes"
+
 	"github.com/minz/minzc/pkg/ir"
 )
 
 // M68kBackend implements the Backend interface for Motorola 68000 code generation
+// (Amiga, Atari ST, early Macintosh). Where the Z80 patches instructions, the
+// 68000 has what frames need:
+// - LINK/UNLK for frame pointers and MOVEM to save registers in one instruction
+// - Eight 32-bit data registers and a flat 24-bit address space
+// - Hardware 16x16 multiply and 32/16 divide (MULU/MULS, DIVU/DIVS)
 type M68kBackend struct {
-	options *BackendOptions
+	BaseBackend
 }
 
 // NewM68kBackend creates a new 68000 backend
 func NewM68kBackend(options *BackendOptions) Backend {
-	return &M68kBackend{
-		options: options,
+	backend := &M68kBackend{
+		BaseBackend: NewBaseBackend(options),
 	}
+
+	// Configure 68000-specific features
+	backend.SetFeature(FeatureSelfModifyingCode, false) // Stack frames make SMC unnecessary
+	backend.SetFeature(FeatureInterrupts, true)
+	backend.SetFeature(FeatureShadowRegisters, false) // No shadow registers like Z80
+	backend.SetFeature(Feature16BitPointers, false)
+	backend.SetFeature(Feature24BitPointers, true)  // Flat 24-bit address bus
+	backend.SetFeature(Feature32BitPointers, true)  // Held in full 32-bit registers
+	backend.SetFeature(FeatureFloatingPoint, false) // Base 68000 has no FPU (68881/68882 needed)
+	backend.SetFeature(FeatureFixedPoint, true)
+	backend.SetFeature(FeatureHardwareMultiply, true) // MULU/MULS: 16 x 16 -> 32
+	backend.SetFeature(FeatureHardwareDivide, true)   // DIVU/DIVS: 32 / 16 -> 16
+	backend.SetFeature(FeatureIndirectCalls, true)
+	backend.SetFeature(FeatureInlineAssembly, true)
+	backend.SetFeature(FeatureBitManipulation, true)
+	backend.SetFeature(FeatureZeroPage, false)
+	backend.SetFeature(FeatureBlockInstructions, false)
+	backend.SetFeature("32bit_registers", true)            // D0-D7, A0-A7 are all 32-bit
+	backend.SetFeature("orthogonal_instruction_set", true) // Very clean, orthogonal design
+
+	return backend
 }
 
 // Name returns the name of this backend
@@ -24,61 +51,37 @@ func (b *M68kBackend) Name() string {
 
 // Generate generates 68000 assembly code for the given IR module
 func (b *M68kBackend) Generate(module *ir.Module) (string, error) {
-	// Create a buffer to collect the generated code
+	// Validate options
+	if err := b.ValidateOptions(); err != nil {
+		return "", err
+	}
+
+	// Preprocess module based on backend capabilities
+	if err := b.PreprocessModule(module); err != nil {
+		return "", err
+	}
+
 	var buf bytes.Buffer
-	
-	// Create the 68k generator with the buffer
 	gen := NewM68kGenerator(&buf)
-	
-	// Configure based on options
 	if b.options != nil {
-		if b.options.EnableSMC {
-			// Enable SMC for all functions
-			for _, fn := range module.Functions {
-				fn.IsSMCEnabled = true
-			}
-		}
+		gen.deterministic = b.options.Deterministic
 	}
-	
-	// Generate the code
+
 	if err := gen.Generate(module); err != nil {
 		return "", err
 	}
-	
+
 	return buf.String(), nil
 }
 
 // GetFileExtension returns the file extension for 68000 assembly
 func (b *M68kBackend) GetFileExtension() string {
-	return ".s"  // Standard assembly extension
+	return ".s" // Standard assembly extension
 }
 
 // SupportsFeature checks if the 68000 backend supports a specific feature
 func (b *M68kBackend) SupportsFeature(feature string) bool {
-	switch feature {
-	case FeatureSelfModifyingCode:
-		return true  // 68000 supports SMC perfectly!
-	case FeatureInterrupts:
-		return true
-	case FeatureShadowRegisters:
-		return false // No shadow registers like Z80
-	case Feature16BitPointers:
-		return true  // Can use 16-bit addressing modes
-	case Feature24BitPointers:
-		return true  // 68000 has native 24-bit addressing!
-	case Feature32BitPointers:
-		return true  // Full 32-bit registers
-	case FeatureFloatingPoint:
-		return false // Base 68000 has no FPU (68881/68882 needed)
-	case FeatureFixedPoint:
-		return true  // We can implement in software
-	case "32bit_registers":
-		return true  // D0-D7, A0-A7 are all 32-bit!
-	case "orthogonal_instruction_set":
-		return true  // Very clean, orthogonal design
-	default:
-		return false
-	}
+	return b.CheckFeature(feature)
 }
 
 // Register the 68000 backend
@@ -86,7 +89,7 @@ func init() {
 	RegisterBackend("m68k", func(options *BackendOptions) Backend {
 		return NewM68kBackend(options)
 	})
-	
+
 	// Also register common aliases
 	RegisterBackend("68000", func(options *BackendOptions) Backend {
 		return NewM68kBackend(options)
@@ -94,4 +97,4 @@ func init() {
 	RegisterBackend("68k", func(options *BackendOptions) Backend {
 		return NewM68kBackend(options)
 	})
-}
\ No newline at end of file
+}
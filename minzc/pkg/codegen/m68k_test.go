@@ -0,0 +1,104 @@
+package codegen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/parser"
+	"github.com/minz/minzc/pkg/semantic"
+)
+
+func TestM68kBackend(t *testing.T) {
+	source := `global big: u24;
+
+fun mul(a: u8, b: u8) -> u8 {
+    return a * b;
+}
+
+fun wide(x: u24, y: u24) -> u24 {
+    return x * y / 3;
+}
+
+fun half(x: i16) -> i16 {
+    return x / 2;
+}
+
+fun peek(p: *u8) -> u8 {
+    return *p;
+}
+
+fun main() -> void {
+    let x: u8 = mul(6, 7);
+    let z: u24 = wide(big, big);
+    let q: i16 = half(x);
+    if x > 40 && q < 0 {
+        print_u8(x);
+    }
+}
+`
+	path := filepath.Join(t.TempDir(), "amiga.minz")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := parser.NewAntlrParser().ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	module, err := semantic.NewAnalyzer().Analyze(file)
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	backend := GetBackend("68000", &BackendOptions{Deterministic: true})
+	if backend == nil {
+		t.Fatal("68000 backend is not registered")
+	}
+	for feature, want := range map[string]bool{
+		FeatureSelfModifyingCode: false,
+		Feature24BitPointers:     true,
+		Feature32BitPointers:     true,
+		FeatureHardwareMultiply:  true,
+		FeatureHardwareDivide:    true,
+	} {
+		if got := backend.SupportsFeature(feature); got != want {
+			t.Errorf("SupportsFeature(%s) = %v, want %v", feature, got, want)
+		}
+	}
+
+	asm, err := backend.Generate(module)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, want := range []string{
+		"\tlink a6,#-",                // Frame
+		"\tmovem.l d2-",               // Saves only the registers it uses
+		"\tmovem.l (sp)+,d2-",         // and restores them
+		"\tunlk a6\n\trts\n",          // Return
+		"\tmove.l 8(a6),d0\t\t| a\n",  // First parameter
+		"\tmulu.w d1,d0\n",            // 8-bit multiply in hardware
+		"\tjsr __mul32\n",             // 24-bit multiply in longwords
+		"\tjsr __divu32\n",            // and divide
+		"\tand.l #$FFFFFF,d0\n",       // cut back to 24 bits
+		"\tdivs.w d1,d0\n",            // 16-bit signed divide in hardware
+		"\tlea 8(sp),sp\n",            // Caller drops the arguments
+		"\tshi d0\n",                  // Unsigned x > 40
+		"\tslt d0\n",                  // Signed q < 0
+		"\tmovea.l d2,a0\n",           // 32-bit pointer
+		"\tmove.b (a0),d0\n",          // dereferenced
+		"\tmove.b amiga_big+2,d0\n",   // 24-bit global a byte at a time
+		"\tjsr print_u8_decimal\n",    // Runtime call with D0
+		"print_u16_decimal:\n",        // and its routine
+		"__divu32:\t\t| d0 = d0 / d1", // Helpers are appended once
+	} {
+		if !strings.Contains(asm, want) {
+			t.Errorf("output is missing %q:\n%s", want, asm)
+		}
+	}
+	for _, unwanted := range []string{"| Generated:", "TODO", "SMC anchor", "movem.l d2-d7/a2-a5"} {
+		if strings.Contains(asm, unwanted) {
+			t.Errorf("output contains %q:\n%s", unwanted, asm)
+		}
+	}
+}
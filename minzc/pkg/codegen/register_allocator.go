@@ -24,6 +24,13 @@ type Z80RegisterAllocator struct {
 	
 	// Current function being processed
 	currentFunc *ir.Function
+	
+	// Registers each virtual register must not get, as inline asm live
+	// across its value changes them
+	avoid map[ir.Register][]PhysicalReg
+	
+	// Virtual registers left in memory, such as 24-bit values
+	inMemory map[ir.Register]bool
 }
 
 // PhysicalReg represents a physical Z80 register
@@ -114,6 +121,12 @@ func (ra *Z80RegisterAllocator) EnableShadowRegisters() {
 	ra.freeRegs.available[RegHL_Shadow] = true
 }
 
+// KeepInMemory leaves virtual registers in memory in the functions
+// allocated next
+func (ra *Z80RegisterAllocator) KeepInMemory(regs map[ir.Register]bool) {
+	ra.inMemory = regs
+}
+
 // AllocateFunction performs register allocation for a function
 func (ra *Z80RegisterAllocator) AllocateFunction(fn *ir.Function) {
 	ra.currentFunc = fn
@@ -136,6 +149,11 @@ func (ra *Z80RegisterAllocator) AllocateFunction(fn *ir.Function) {
 func (ra *Z80RegisterAllocator) linearScanAllocation(fn *ir.Function) {
 	// Build live intervals
 	liveIntervals := ra.computeLiveIntervals(fn)
+	ra.avoid = ra.computeAsmAvoidance(fn)
+	
+	for reg := range ra.inMemory {
+		ra.allocation[reg] = RegNone
+	}
 	
 	// Sort by start position
 	// For now, simple allocation in order
@@ -161,6 +179,12 @@ func (ra *Z80RegisterAllocator) linearScanAllocation(fn *ir.Function) {
 			}
 		}
 		
+		if inst.Src3 != 0 && inst.Src3 != ir.RegZero {
+			if _, allocated := ra.allocation[inst.Src3]; !allocated {
+				ra.allocateRegister(inst.Src3, &inst)
+			}
+		}
+		
 		// Free dead registers after this instruction
 		ra.freeDeadRegisters(&inst, liveIntervals)
 	}
@@ -169,7 +193,7 @@ func (ra *Z80RegisterAllocator) linearScanAllocation(fn *ir.Function) {
 // allocateRegister allocates a physical register for a virtual register
 func (ra *Z80RegisterAllocator) allocateRegister(virtReg ir.Register, inst *ir.Instruction) PhysicalReg {
 	// Try to get a free register
-	physReg := ra.getFreeRegister(inst)
+	physReg := ra.getFreeRegister(inst, ra.avoid[virtReg])
 	
 	if physReg != RegNone {
 		ra.allocation[virtReg] = physReg
@@ -177,6 +201,12 @@ func (ra *Z80RegisterAllocator) allocateRegister(virtReg ir.Register, inst *ir.I
 		return physReg
 	}
 	
+	// Spilling could hand over a register the value must avoid; leave it
+	// in memory instead
+	if len(ra.avoid[virtReg]) > 0 {
+		return RegNone
+	}
+	
 	// No free register - need to spill
 	spillReg := ra.selectSpillRegister()
 	ra.spillRegister(spillReg)
@@ -189,22 +219,32 @@ func (ra *Z80RegisterAllocator) allocateRegister(virtReg ir.Register, inst *ir.I
 }
 
 // getFreeRegister finds a free physical register suitable for the instruction
-func (ra *Z80RegisterAllocator) getFreeRegister(inst *ir.Instruction) PhysicalReg {
-	// For 16-bit operations, prefer register pairs
-	if inst.Type != nil && inst.Type.Size() > 1 {
-		if ra.freeRegs.available[RegHL] {
+func (ra *Z80RegisterAllocator) getFreeRegister(inst *ir.Instruction, avoid []PhysicalReg) PhysicalReg {
+	usable := func(reg PhysicalReg) bool {
+		for _, a := range avoid {
+			if regsOverlap(a, reg) {
+				return false
+			}
+		}
+		return ra.freeRegs.available[reg]
+	}
+
+	// For 16-bit operations, prefer register pairs. OpFormat's Type is the
+	// value written, but its result is always a 16-bit buffer cursor.
+	if inst.Type != nil && inst.Type.Size() > 1 || inst.Op == ir.OpFormat {
+		if usable(RegHL) {
 			ra.freeRegs.available[RegHL] = false
 			ra.freeRegs.available[RegH] = false
 			ra.freeRegs.available[RegL] = false
 			return RegHL
 		}
-		if ra.freeRegs.available[RegDE] {
+		if usable(RegDE) {
 			ra.freeRegs.available[RegDE] = false
 			ra.freeRegs.available[RegD] = false
 			ra.freeRegs.available[RegE] = false
 			return RegDE
 		}
-		if ra.freeRegs.available[RegBC] {
+		if usable(RegBC) {
 			ra.freeRegs.available[RegBC] = false
 			ra.freeRegs.available[RegB] = false
 			ra.freeRegs.available[RegC] = false
@@ -222,7 +262,7 @@ func (ra *Z80RegisterAllocator) getFreeRegister(inst *ir.Instruction) PhysicalRe
 	
 	// For 8-bit operations
 	for _, reg := range []PhysicalReg{RegA, RegB, RegC, RegD, RegE, RegH, RegL} {
-		if ra.freeRegs.available[reg] {
+		if usable(reg) {
 			ra.freeRegs.available[reg] = false
 			return reg
 		}
@@ -269,12 +309,15 @@ func (ra *Z80RegisterAllocator) selectSpillRegister() PhysicalReg {
 		return RegHL
 	}
 	
-	// Otherwise spill first allocated register
+	// Otherwise spill the lowest occupied register, so the choice does not
+	// depend on map iteration order
+	spill := RegNone
 	for physReg := range ra.regContents {
-		return physReg
+		if spill == RegNone || physReg < spill {
+			spill = physReg
+		}
 	}
-	
-	return RegNone
+	return spill
 }
 
 // freePhysicalRegister marks a physical register as free
@@ -339,11 +382,56 @@ func (ra *Z80RegisterAllocator) computeLiveIntervals(fn *ir.Function) map[ir.Reg
 				intervals[inst.Src2] = LiveInterval{Start: i, End: len(fn.Instructions)}
 			}
 		}
+		
+		if inst.Src3 != 0 {
+			live[inst.Src3] = true
+			if interval, exists := intervals[inst.Src3]; exists {
+				interval.Start = i
+				intervals[inst.Src3] = interval
+			} else {
+				intervals[inst.Src3] = LiveInterval{Start: i, End: len(fn.Instructions)}
+			}
+		}
 	}
 	
 	return intervals
 }
 
+// computeAsmAvoidance returns, for each virtual register an inline asm
+// with operands reads, writes or is live across, the registers that asm
+// changes. Asm without operands or clobbers is not considered.
+func (ra *Z80RegisterAllocator) computeAsmAvoidance(fn *ir.Function) map[ir.Register][]PhysicalReg {
+	avoid := make(map[ir.Register][]PhysicalReg)
+	var liveness *ir.Liveness
+	regs := make(map[ir.Register]bool) // Every register of fn
+	for i := range fn.Instructions {
+		inst := &fn.Instructions[i]
+		if inst.Op != ir.OpAsm || !hasAsmOperands(inst) {
+			continue
+		}
+		if liveness == nil {
+			liveness = ir.AnalyzeLiveness(fn)
+			for j := range fn.Instructions {
+				for _, reg := range append(fn.Instructions[j].Reads(), fn.Instructions[j].Dest) {
+					if reg > 0 {
+						regs[reg] = true
+					}
+				}
+			}
+		}
+		used := asmUsedRegisters(inst)
+		for _, op := range inst.AsmOperands {
+			avoid[op.Reg] = append(avoid[op.Reg], used...)
+		}
+		for reg := range regs {
+			if liveness.LiveAfter(i, reg) {
+				avoid[reg] = append(avoid[reg], used...)
+			}
+		}
+	}
+	return avoid
+}
+
 // freeDeadRegisters frees registers that are no longer live
 func (ra *Z80RegisterAllocator) freeDeadRegisters(inst *ir.Instruction, intervals map[ir.Register]LiveInterval) {
 	// Check each allocated register
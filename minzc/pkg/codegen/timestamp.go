@@ -0,0 +1,26 @@
+package codegen
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// timestampLayout is the format of the "; Generated:" header line
+const timestampLayout = "2006-01-02 15:04:05"
+
+// generatedTimestamp returns the time to stamp generated output with, or ""
+// to leave the stamp out. SOURCE_DATE_EPOCH, when set, fixes the time so
+// builds are reproducible (https://reproducible-builds.org/specs/source-date-epoch/);
+// otherwise deterministic output carries no timestamp at all.
+func generatedTimestamp(deterministic bool) string {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		if secs, err := strconv.ParseInt(epoch, 10, 64); err == nil {
+			return time.Unix(secs, 0).UTC().Format(timestampLayout)
+		}
+	}
+	if deterministic {
+		return ""
+	}
+	return time.Now().Format(timestampLayout)
+}
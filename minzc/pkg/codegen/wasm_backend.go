@@ -152,7 +152,7 @@ func (b *WASMBackend) generateFunction(buf *bytes.Buffer, fn *ir.Function) error
 	// Default return if needed
 	if fn.ReturnType != nil && fn.ReturnType.Size() > 0 {
 		// Only add default return if last instruction wasn't a return
-		if len(fn.Instructions) == 0 || fn.Instructions[len(fn.Instructions)-1].Op != ir.OpReturn {
+		if !fn.EndsWithReturn() {
 			buf.WriteString("    i32.const 0\n")
 		}
 	}
@@ -5,7 +5,6 @@ import (
 	"io"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/minz/minzc/pkg/ir"
 )
@@ -34,6 +33,7 @@ type Z80Generator struct {
 	currentFunc   *ir.Function
 	currentFunction *ir.Function // For DJNZ optimization
 	currentInstructionIndex int  // For DJNZ optimization
+	fusedInstructions int // Following IR instructions already generated with this one
 	
 	// Hierarchical register allocation system
 	regAlloc         *RegisterAllocator      // Simple memory-based allocator (fallback)
@@ -47,13 +47,40 @@ type Z80Generator struct {
 	useAbsoluteLocals bool // Whether to use absolute addressing for locals
 	emittedParams map[string]bool // Track which SMC parameters have been emitted
 	currentRegister ir.Register // Track which virtual register is currently in HL
+	deRegister      ir.Register // Track which virtual register is currently in DE
+	aRegister       ir.Register // Track which virtual register is currently in A
 	targetPlatform string // Target platform (zxspectrum, cpm, msx, etc.)
 	constantValues map[ir.Register]int64 // Track constant values in registers
 	usedFunctions  map[string]bool // Track which stdlib functions are actually used
 	dataBlocks     []DataBlock     // Array literal data blocks
 	structDataBlocks []StructDataBlock // Struct array literal data blocks
+	sectionOrigins map[string]uint16 // ORG for each named @section
+	codeOrigin     uint16            // ORG of the code section (--org)
+	sections       bool              // SECTION instead of ORG (--sections)
+	relocatableCalls bool            // Route calls through the call-thunk table
+	deterministic    bool            // Leave the generation timestamp out
+	sourceLines      bool            // Emit source line markers
+	z80n             bool            // Use the Z80N instructions of the ZX Spectrum Next
+	inlineArith      bool            // Inline u8 multiply and divide (see z80_arith.go)
+	sourceRunStarts  map[int]ir.SourceRun // Runs of the current function, by first instruction
+	
+	// Held-back stores of virtual registers (see z80_spill.go)
+	liveness    *ir.Liveness // Live registers of the current function
+	instIndex   int          // Instruction being generated
+	spill       []string     // Store of spillReg not emitted yet
+	spillReg    ir.Register
+	spillReader int // Instruction that reads spillReg
+	
+	wide map[ir.Register]bool // Registers holding 24-bit values (see z80_u24.go)
 }
 
+// DefaultCodeOrigin is the ORG of the code section unless --org gives one
+const DefaultCodeOrigin uint16 = 0x8000
+
+// DefaultSectionOrigin is the ORG used for a named section without a
+// configured origin ($C000 is the paged bank window on 128K machines)
+const DefaultSectionOrigin uint16 = 0xC000
+
 // NewZ80Generator creates a new Z80 code generator
 func NewZ80Generator(w io.Writer) *Z80Generator {
 	physicalAlloc := NewZ80RegisterAllocator()
@@ -69,6 +96,8 @@ func NewZ80Generator(w io.Writer) *Z80Generator {
 		targetPlatform:  "zxspectrum",            // Default to ZX Spectrum
 		constantValues:  make(map[ir.Register]int64),
 		usedFunctions:   make(map[string]bool),
+		sectionOrigins:  make(map[string]uint16),
+		codeOrigin:      DefaultCodeOrigin,
 	}
 }
 
@@ -77,6 +106,41 @@ func (g *Z80Generator) SetTargetPlatform(platform string) {
 	g.targetPlatform = platform
 }
 
+// SetCodeOrigin sets the ORG address of the code section. CP/M programs
+// always start at $0100.
+func (g *Z80Generator) SetCodeOrigin(origin uint16) {
+	g.codeOrigin = origin
+}
+
+// SetSectionOrigin sets the ORG address for a named section
+func (g *Z80Generator) SetSectionOrigin(section string, origin uint16) {
+	g.sectionOrigins[section] = origin
+}
+
+// SetSections emits SECTION directives instead of ORG, leaving named
+// sections without a configured origin for the assembler to place
+func (g *Z80Generator) SetSections(enabled bool) {
+	g.sections = enabled
+}
+
+// SetRelocatableCalls routes calls between module functions through a
+// table of JP thunks so a function can be moved by patching its thunk
+func (g *Z80Generator) SetRelocatableCalls(enabled bool) {
+	g.relocatableCalls = enabled
+}
+
+// SetDeterministic leaves the generation timestamp out of the header so
+// identical input yields byte-identical output
+func (g *Z80Generator) SetDeterministic(enabled bool) {
+	g.deterministic = enabled
+}
+
+// SetZ80N lets the generator use the Z80N extended instructions (MUL D,E,
+// ADD HL,A, PIXELAD...) of the ZX Spectrum Next
+func (g *Z80Generator) SetZ80N(enabled bool) {
+	g.z80n = enabled
+}
+
 // uniqueLabel generates a unique label with the given prefix
 func (g *Z80Generator) uniqueLabel(prefix string) string {
 	label := fmt.Sprintf("%s_%d", prefix, g.labelCounter)
@@ -281,16 +345,23 @@ func (g *Z80Generator) Generate(module *ir.Module) error {
 
 	// Write header
 	g.writeHeader()
+	g.generateFixedGlobals()
 
 	// Generate data section
 	if debug {
 		fmt.Printf("DEBUG: Globals=%d, Strings=%d, DataBlocks=%d\n", len(module.Globals), len(module.Strings), len(g.dataBlocks))
 	}
-	if len(module.Globals) > 0 || len(module.Strings) > 0 || len(g.dataBlocks) > 0 {
+	if g.hasDataGlobals() || len(module.Strings) > 0 || len(g.dataBlocks) > 0 {
 		g.emit("\n; Data section")
-		g.emit("    ORG $F000")  // Data section at $F000
+		g.generateOrigin("DATA", 0xF000, true) // Data section at $F000
 		g.emit("")
 		for _, global := range module.Globals {
+			if global.Section != "" || global.Address != nil {
+				continue // Emitted with its section, or fixed by @at
+			}
+			if global.Constant {
+				continue // Emitted after the code
+			}
 			g.generateGlobal(global)
 		}
 		
@@ -306,31 +377,21 @@ func (g *Z80Generator) Generate(module *ir.Module) error {
 		}
 		
 		// Generate array literal data blocks
-		if len(g.dataBlocks) > 0 {
-			g.emit("\n; Array literal data")
-			for _, block := range g.dataBlocks {
-				g.emit("%s:", block.Label)
-				if block.Comment != "" {
-					g.emit("    ; %s", block.Comment)
-				}
-				// Generate DB directive for u8 values
-				var values []string
-				for _, val := range block.Data {
-					values = append(values, fmt.Sprintf("%d", val))
-				}
-				g.emit("    DB %s", strings.Join(values, ", "))
-			}
-		}
+		g.generateDataBlocks()
 	}
 
 	// Generate code section
 	g.emit("\n; Code section")
-	g.emit("    ORG $8000")
+	g.generateCodeOrigin()
 	g.emit("")
+	g.generateCallThunks()
 
-	// Generate functions
-	for _, fn := range module.Functions {
+	// Generate functions, hot first and cold last
+	for _, fn := range orderFunctions(module.Functions) {
 		// fmt.Printf("DEBUG CodeGen: Function %s: IsSMCDefault=%v, IsSMCEnabled=%v, ptr=%p\n", fn.Name, fn.IsSMCDefault, fn.IsSMCEnabled, fn)
+		if fn.Section != "" {
+			continue // Emitted with its section
+		}
 		if err := g.generateFunction(fn); err != nil {
 			return err
 		}
@@ -346,78 +407,249 @@ func (g *Z80Generator) Generate(module *ir.Module) error {
 	
 	// Generate standard library routines
 	g.generateStdlibRoutines()
+	g.generateFixedPointRoutines()
+	g.generateArithmeticRoutines()
+	g.generateFormatRoutines()
+	g.generateStringRoutines()
 	
 	// Generate array literal data blocks (after functions are processed)
-	if len(g.dataBlocks) > 0 {
-		g.emit("\n; Array literal data")
-		for _, block := range g.dataBlocks {
-			g.emit("%s:", block.Label)
-			if block.Comment != "" {
-				g.emit("    ; %s", block.Comment)
-			}
-			// Generate DB directive for u8 values
-			var values []string
-			for _, val := range block.Data {
-				values = append(values, fmt.Sprintf("%d", val))
-			}
-			g.emit("    DB %s", strings.Join(values, ", "))
-		}
-	}
+	g.generateDataBlocks()
 	
 	// Generate struct array data blocks
-	if len(g.structDataBlocks) > 0 {
-		g.emit("\n; Struct array literal data")
-		for _, block := range g.structDataBlocks {
-			g.emit("%s:", block.Label)
-			if block.Comment != "" {
-				g.emit("    ; %s", block.Comment)
+	g.generateStructDataBlocks()
+	
+	// Generate read-only globals such as function tables
+	g.generateConstantGlobals()
+	g.generateIM2Table()
+	
+	// Generate named sections, each contiguous under its own ORG
+	if err := g.generateSections(); err != nil {
+		return err
+	}
+	
+	// Write footer
+	g.writeFooter()
+
+	return nil
+}
+
+// generateCodeOrigin opens the code section. CP/M loads a .COM file at
+// $0100 and runs it from its first byte, which jumps to main.
+func (g *Z80Generator) generateCodeOrigin() {
+	if g.targetPlatform != "cpm" {
+		g.generateOrigin("CODE", g.codeOrigin, true)
+		return
+	}
+	// A .COM file starts at $0100: call main, then leave through BDOS
+	// function 0 so the program exits cleanly whatever its stack holds
+	g.generateOrigin("CODE", 0x0100, true)
+	for _, fn := range g.module.Functions {
+		if isMainFunction(fn.Name) {
+			g.emit("    CALL %s", g.callTarget(fn.Name))
+			g.emit("    LD C, 0        ; BDOS 0: system reset")
+			g.emit("    JP $0005")
+			break
+		}
+	}
+}
+
+// orderFunctions returns functions in emission order: @hot functions first so
+// they sit together near the entry point and their jumps stay in JR range,
+// then unannotated functions, then @cold functions out of the way at the end.
+// Source order is kept within each group.
+func orderFunctions(funcs []*ir.Function) []*ir.Function {
+	ordered := make([]*ir.Function, 0, len(funcs))
+	for _, hint := range []ir.FunctionHint{ir.HintHot, ir.HintNone, ir.HintCold} {
+		for _, fn := range funcs {
+			if fn.Hint == hint {
+				ordered = append(ordered, fn)
 			}
+		}
+	}
+	return ordered
+}
+
+// generateDataBlocks emits array literal data collected during code generation
+func (g *Z80Generator) generateDataBlocks() {
+	if len(g.dataBlocks) == 0 {
+		return
+	}
+	g.emit("\n; Array literal data")
+	for _, block := range g.dataBlocks {
+		g.emit("%s:", block.Label)
+		if block.Comment != "" {
+			g.emit("    ; %s", block.Comment)
+		}
+		// Generate DB directive for u8 values
+		var values []string
+		for _, val := range block.Data {
+			values = append(values, fmt.Sprintf("%d", val))
+		}
+		g.emit("    DB %s", strings.Join(values, ", "))
+	}
+}
+
+// hasDataGlobals reports whether any global belongs in the data section
+func (g *Z80Generator) hasDataGlobals() bool {
+	for _, global := range g.module.Globals {
+		if global.Section == "" && !global.Constant && global.Address == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// generateFixedGlobals binds globals placed with @at to their addresses.
+// They take no space in the output.
+func (g *Z80Generator) generateFixedGlobals() {
+	first := true
+	for _, global := range g.module.Globals {
+		if global.Address == nil {
+			continue
+		}
+		if first {
+			g.emit("; Fixed-address globals")
+			first = false
+		}
+		g.emit("%s EQU $%04X", global.Name, *global.Address)
+	}
+}
+
+// generateConstantGlobals emits read-only globals with the code, clear of
+// the variable area at $F000
+func (g *Z80Generator) generateConstantGlobals() {
+	first := true
+	for _, global := range g.module.Globals {
+		if !global.Constant || global.Section != "" {
+			continue
+		}
+		if first {
+			g.emit("\n; Constant tables")
+			first = false
+		}
+		g.generateGlobal(global)
+	}
+}
+
+// generateStructDataBlocks emits struct array literal data collected during code generation
+func (g *Z80Generator) generateStructDataBlocks() {
+	if len(g.structDataBlocks) == 0 {
+		return
+	}
+	g.emit("\n; Struct array literal data")
+	for _, block := range g.structDataBlocks {
+		g.emit("%s:", block.Label)
+		if block.Comment != "" {
+			g.emit("    ; %s", block.Comment)
+		}
+		
+		// Get struct type from array type
+		structType := block.ArrayType.Element.(*ir.StructType)
+		
+		// Generate data for each struct
+		for i, structData := range block.StructData {
+			g.emit("    ; Element %d: %s", i, structData.TypeName)
 			
-			// Get struct type from array type
-			structType := block.ArrayType.Element.(*ir.StructType)
-			
-			// Generate data for each struct
-			for i, structData := range block.StructData {
-				g.emit("    ; Element %d: %s", i, structData.TypeName)
+			// Generate fields in order
+			for _, fieldName := range structType.FieldOrder {
+				fieldType := structType.Fields[fieldName]
+				value, exists := structData.Fields[fieldName]
+				if !exists {
+					value = 0 // Default to 0 if not specified
+				}
 				
-				// Generate fields in order
-				for _, fieldName := range structType.FieldOrder {
-					fieldType := structType.Fields[fieldName]
-					value, exists := structData.Fields[fieldName]
-					if !exists {
-						value = 0 // Default to 0 if not specified
-					}
-					
-					// Generate appropriate directive based on field size
-					switch fieldType.Size() {
-					case 1:
-						g.emit("    DB %d                ; %s", value, fieldName)
-					case 2:
-						// Little-endian for Z80
-						g.emit("    DW %d                ; %s", value, fieldName)
-					default:
-						// For larger types, emit multiple bytes
-						for j := 0; j < fieldType.Size(); j++ {
-							byteVal := (value >> (j * 8)) & 0xFF
-							g.emit("    DB %d                ; %s[%d]", byteVal, fieldName, j)
-						}
+				// Generate appropriate directive based on field size
+				switch fieldType.Size() {
+				case 1:
+					g.emit("    DB %d                ; %s", value, fieldName)
+				case 2:
+					// Little-endian for Z80
+					g.emit("    DW %d                ; %s", value, fieldName)
+				default:
+					// For larger types, emit multiple bytes
+					for j := 0; j < fieldType.Size(); j++ {
+						byteVal := (value >> (j * 8)) & 0xFF
+						g.emit("    DB %d                ; %s[%d]", byteVal, fieldName, j)
 					}
 				}
 			}
 		}
 	}
-	
-	// Write footer
-	g.writeFooter()
+}
+
+// sectionNames returns named sections in order of first appearance
+func (g *Z80Generator) sectionNames() []string {
+	var names []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, fn := range g.module.Functions {
+		add(fn.Section)
+	}
+	for _, global := range g.module.Globals {
+		add(global.Section)
+	}
+	return names
+}
 
+// generateOrigin places what follows: an ORG, or with --sections a
+// SECTION, which has no base when its origin is not fixed
+func (g *Z80Generator) generateOrigin(section string, origin uint16, fixed bool) {
+	switch {
+	case !g.sections:
+		g.emit("    ORG $%04X", origin)
+	case fixed:
+		g.emit("    SECTION %s, $%04X", section, origin)
+	default:
+		g.emit("    SECTION %s", section)
+	}
+}
+
+// generateSections emits functions and globals placed with @section
+func (g *Z80Generator) generateSections() error {
+	for _, name := range g.sectionNames() {
+		origin, ok := g.sectionOrigins[name]
+		if !ok {
+			origin = DefaultSectionOrigin
+		}
+		
+		g.emit("\n; Section: %s", name)
+		g.generateOrigin(name, origin, ok)
+		g.emit("")
+		
+		for _, fn := range orderFunctions(g.module.Functions) {
+			if fn.Section != name {
+				continue
+			}
+			if err := g.generateFunction(fn); err != nil {
+				return err
+			}
+		}
+		for _, global := range g.module.Globals {
+			if global.Section == name {
+				g.generateGlobal(global)
+			}
+		}
+	}
 	return nil
 }
 
 // writeHeader writes the assembly file header
 func (g *Z80Generator) writeHeader() {
 	g.emit("; MinZ generated code")
-	g.emit("; Generated: %s", time.Now().Format("2006-01-02 15:04:05"))
+	if stamp := generatedTimestamp(g.deterministic); stamp != "" {
+		g.emit("; Generated: %s", stamp)
+	}
 	g.emit("")
+	if g.z80n {
+		// Lets the assembler accept the Z80N instructions
+		g.emit("    TARGET zxnext")
+		g.emit("")
+	}
 }
 
 // writeFooter writes the assembly file footer
@@ -429,28 +661,30 @@ func (g *Z80Generator) writeFooter() {
 // generatePatchTable generates the PATCH-TABLE for TRUE SMC functions
 func (g *Z80Generator) generatePatchTable() {
 	// Collect all TRUE SMC functions and their anchors
-	var patchEntries []struct {
+	type patchEntry struct {
 		funcName string
 		paramName string
 		anchorSymbol string
 		size int
 	}
+	var patchEntries []patchEntry
 	
 	for _, fn := range g.module.Functions {
 		if fn.UsesTrueSMC {
 			for _, param := range fn.Params {
-				entry := struct {
-					funcName string
-					paramName string
-					anchorSymbol string
-					size int
-				}{
+				if is24Bit(param.Type) {
+					// Two anchors: the low word and the high byte
+					patchEntries = append(patchEntries,
+						patchEntry{fn.Name, param.Name, param.Name + "$immLO0", 2},
+						patchEntry{fn.Name, param.Name, param.Name + "$immHI0", 1})
+					continue
+				}
+				patchEntries = append(patchEntries, patchEntry{
 					funcName: fn.Name,
 					paramName: param.Name,
 					anchorSymbol: fmt.Sprintf("%s$imm0", param.Name),
 					size: param.Type.Size(),
-				}
-				patchEntries = append(patchEntries, entry)
+				})
 			}
 		}
 	}
@@ -478,6 +712,9 @@ func (g *Z80Generator) generatePatchTable() {
 
 // generateGlobal generates code for a global variable
 func (g *Z80Generator) generateGlobal(global ir.Global) {
+	if global.Align > 1 {
+		g.emit("    ALIGN %d", global.Align)
+	}
 	g.emit("%s:", global.Name)
 	
 	switch t := global.Type.(type) {
@@ -512,7 +749,20 @@ func (g *Z80Generator) generateGlobal(global ir.Global) {
 		}
 	case *ir.ArrayType:
 		// Handle array initialization
-		if global.Init != nil {
+		if entries, ok := global.Init.([]string); ok {
+			// Address table: function table entries are called through
+			// their call target, anything else (e.g. enum names) is a label
+			labels := entries
+			if _, isFunc := t.Element.(*ir.FunctionType); isFunc {
+				labels = make([]string, len(entries))
+				for i, entry := range entries {
+					labels[i] = g.callTarget(entry)
+				}
+			}
+			g.emit("    DW %s", strings.Join(labels, ", "))
+		} else if data, ok := global.Init.([]byte); ok {
+			g.generateBinary(data)
+		} else if global.Init != nil {
 			// TODO: Support array initializers
 			g.emit("    ; Array with initializer")
 			size := global.Type.Size()
@@ -525,11 +775,44 @@ func (g *Z80Generator) generateGlobal(global ir.Global) {
 		// Handle struct initialization
 		size := global.Type.Size()
 		g.emit("    DS %d", size)
+	case *ir.EnumType:
+		if t.HasPayloads() {
+			// Tagged union: the tag and room for the largest payload
+			g.emit("    DS %d", t.Size())
+			break
+		}
+		// Stored as its backing type
+		value := global.Init
+		if value == nil {
+			value = 0
+		}
+		if t.Size() == 1 {
+			g.emit("    DB %v", value)
+		} else {
+			g.emit("    DW %v", value)
+		}
 	default:
 		g.emit("    ; TODO: %s type", global.Type.String())
 	}
 }
 
+// generateBinary emits the bytes of an @include_bin file after their
+// 16-bit length
+func (g *Z80Generator) generateBinary(data []byte) {
+	g.emit("    DW %d    ; Length", len(data))
+	for start := 0; start < len(data); start += 16 {
+		end := start + 16
+		if end > len(data) {
+			end = len(data)
+		}
+		values := make([]string, 0, end-start)
+		for _, b := range data[start:end] {
+			values = append(values, fmt.Sprintf("$%02X", b))
+		}
+		g.emit("    DB %s", strings.Join(values, ", "))
+	}
+}
+
 // generateString generates a length-prefixed string literal
 func (g *Z80Generator) generateString(str *ir.String) {
 	g.emit("%s:", str.Label)
@@ -595,20 +878,31 @@ func (g *Z80Generator) generateFunction(fn *ir.Function) error {
 	g.currentInstructionIndex = 0
 	g.stackOffset = 0
 	g.regAlloc.Reset()
+	g.liveness = ir.AnalyzeLiveness(fn)
+	g.spill = nil
+	g.wide = g.findWideRegisters(fn)
+	g.assignWideSlots(fn)
 
 	// Perform hierarchical register allocation if enabled
 	if g.usePhysicalRegs {
+		g.physicalAlloc.KeepInMemory(g.wide)
 		g.physicalAlloc.AllocateFunction(fn)
 		g.emit("; Using hierarchical register allocation (physical → shadow → memory)")
 	}
 
 	// Function label
 	g.emit("")
-	g.emit("; Function: %s", fn.Name)
+	if fn.Hint != ir.HintNone {
+		g.emit("; Function: %s (%s)", fn.Name, fn.Hint)
+	} else {
+		g.emit("; Function: %s", fn.Name)
+	}
+	defer g.emit("; End of function: %s", fn.Name)
 	// g.emit("; IsSMCDefault=%v, IsSMCEnabled=%v", fn.IsSMCDefault, fn.IsSMCEnabled)
 	
-	// Check if this is an SMC function
-	if fn.IsSMCDefault || fn.IsSMCEnabled {
+	// Check if this is an SMC function; interrupt handlers take no
+	// parameters and need their own prologue
+	if (fn.IsSMCDefault || fn.IsSMCEnabled) && !fn.IsInterrupt {
 		return g.generateSMCFunction(fn)
 	}
 	
@@ -633,6 +927,10 @@ func (g *Z80Generator) generateFunction(fn *ir.Function) error {
 		localAddresses := make(map[string]uint16)
 		for _, local := range fn.Locals {
 			addr := g.localVarBase + localOffset
+			if fn.FrameSize > 0 {
+				// Laid out by the optimizer, sharing bytes between locals
+				addr = g.localVarBase + uint16(local.Offset)
+			}
 			localAddresses[local.Name] = addr
 			g.regAlloc.SetAddress(local.Reg, addr)
 			localOffset += uint16(local.Type.Size())
@@ -644,27 +942,43 @@ func (g *Z80Generator) generateFunction(fn *ir.Function) error {
 		for _, local := range fn.Locals {
 			localOffset += local.Type.Size()
 			// Store negative offset (locals grow downward)
-			g.regAlloc.SetAddress(local.Reg, uint16(localOffset))
+			if fn.FrameSize > 0 {
+				g.regAlloc.SetAddress(local.Reg, uint16(local.Offset+local.Type.Size()))
+			} else {
+				g.regAlloc.SetAddress(local.Reg, uint16(localOffset))
+			}
+		}
+		if fn.FrameSize > 0 {
+			localOffset = fn.FrameSize
 		}
 		g.stackOffset = localOffset
 	}
 	
 	// Function prologue
 	g.generatePrologue(fn)
+	g.generateIM2Setup(fn)
 
 	// Reset constant tracking for new function
 	g.constantValues = make(map[ir.Register]int64)
 	
 	// Generate instructions
+	g.beginSourceRuns(fn)
+	g.fusedInstructions = 0
 	for i, inst := range fn.Instructions {
+		if g.fusedInstructions > 0 {
+			g.fusedInstructions--
+			continue
+		}
 		g.currentInstructionIndex = i
+		g.startInstruction(i)
+		g.markSourceLine(i)
 		if err := g.generateInstruction(inst); err != nil {
 			return err
 		}
 	}
 
 	// Function epilogue (if not already returned)
-	if len(fn.Instructions) == 0 || fn.Instructions[len(fn.Instructions)-1].Op != ir.OpReturn {
+	if !fn.EndsWithReturn() {
 		g.generateEpilogue()
 	}
 
@@ -687,7 +1001,17 @@ func (g *Z80Generator) generateTrueSMCFunction(fn *ir.Function) error {
 	g.currentFunc = fn
 	
 	// Generate function body
-	for _, inst := range fn.Instructions {
+	g.beginSourceRuns(fn)
+	g.currentFunction = fn
+	g.fusedInstructions = 0
+	for i, inst := range fn.Instructions {
+		if g.fusedInstructions > 0 {
+			g.fusedInstructions--
+			continue
+		}
+		g.currentInstructionIndex = i
+		g.startInstruction(i)
+		g.markSourceLine(i)
 		// Check if this is first use of a parameter (could be OpTrueSMCLoad already)
 		if (inst.Op == ir.OpLoadParam || inst.Op == ir.OpTrueSMCLoad) && inst.Symbol != "" {
 			paramName := inst.Symbol
@@ -713,7 +1037,7 @@ func (g *Z80Generator) generateTrueSMCFunction(fn *ir.Function) error {
 	}
 	
 	// Add RET if not already present
-	if len(fn.Instructions) == 0 || fn.Instructions[len(fn.Instructions)-1].Op != ir.OpReturn {
+	if !fn.EndsWithReturn() {
 		g.emit("    RET")
 	}
 	
@@ -768,9 +1092,8 @@ func (g *Z80Generator) generateParameterAnchor(param *ir.Parameter, destReg ir.R
 		g.emit("    LD HL, 0       ; %s low 16 bits anchor (will be patched)", param.Name)
 		g.emit("%s EQU %s+1", anchorLow+"0", anchorLow)
 		
-		// For now, just store HL to destination (low 16 bits only)
-		// TODO: Need proper 24-bit register allocation
-		g.storeFromHL(destReg)
+		// The value is now in A:HL
+		g.store24(destReg)
 	}
 }
 
@@ -805,6 +1128,8 @@ func (g *Z80Generator) generateSMCFunction(fn *ir.Function) error {
 		g.emit("; Recursive context handled via stack push/pop of SMC parameters")
 	}
 	
+	g.generateIM2Setup(fn)
+	
 	// If this has tail recursion, add the start label
 	if fn.HasTailRecursion {
 		g.emit("%s_start:", fn.Name)
@@ -825,7 +1150,17 @@ func (g *Z80Generator) generateSMCFunction(fn *ir.Function) error {
 	g.constantValues = make(map[ir.Register]int64)
 	
 	// Generate instructions with SMC awareness
+	g.beginSourceRuns(fn)
+	g.currentFunction = fn
+	g.fusedInstructions = 0
 	for i, inst := range fn.Instructions {
+		if g.fusedInstructions > 0 {
+			g.fusedInstructions--
+			continue
+		}
+		g.currentInstructionIndex = i
+		g.startInstruction(i)
+		g.markSourceLine(i)
 		// Check if this is the last instruction and it's a return - replace with patch points if needed
 		isLastInst := i == len(fn.Instructions)-1
 		if isLastInst && inst.Op == ir.OpReturn && fn.NeedsPatchPoints {
@@ -839,7 +1174,7 @@ func (g *Z80Generator) generateSMCFunction(fn *ir.Function) error {
 	}
 	
 	// Epilogue if needed
-	if len(fn.Instructions) == 0 || fn.Instructions[len(fn.Instructions)-1].Op != ir.OpReturn {
+	if !fn.EndsWithReturn() {
 		if fn.UsedRegisters != 0 && !fn.IsRecursive {
 			if fn.ModifiedRegisters.Contains(ir.Z80_DE) {
 				g.emit("    POP DE")
@@ -904,6 +1239,12 @@ func (g *Z80Generator) generateSMCInstruction(inst ir.Instruction) error {
 		if inst.Type != nil && inst.Type.Size() == 1 {
 			g.emit("    LD A, (%s)    ; Reuse from anchor", anchorAddr)
 			g.storeFromA(inst.Dest)
+		} else if is24Bit(inst.Type) {
+			// 24-bit anchors are split: high byte in LD A, low word in LD HL
+			anchorBase := strings.TrimSuffix(anchorAddr, "$imm0")
+			g.emit("    LD HL, (%s$immLO0) ; Reuse from anchor", anchorBase)
+			g.emit("    LD A, (%s$immHI0)", anchorBase)
+			g.store24(inst.Dest)
 		} else {
 			g.emit("    LD HL, (%s)   ; Reuse from anchor", anchorAddr)
 			g.storeFromHL(inst.Dest)
@@ -1033,13 +1374,11 @@ func (g *Z80Generator) generateSMCInstruction(inst ir.Instruction) error {
 						}
 					}
 				} else if param.Type.Size() == 3 {
-					// For 24-bit types (u24/i24/f16.8/f8.16), use A+HL split
+					// For 24-bit types (u24/i24/f16.8/f8.16), use A+HL split:
+					// the high byte at the label, the low word 2 bytes on
 					g.emit("    LD A, #00      ; SMC parameter %s (high byte)", paramName)
 					g.emit("    LD HL, #0000   ; SMC parameter %s (low 16 bits)", paramName)
-					// Store the 24-bit value
-					// For now, store as 16-bit in HL (truncating high byte)
-					// TODO: Properly handle 24-bit storage
-					g.storeFromHL(inst.Dest)
+					g.store24(inst.Dest)
 				} else {
 					// 16-bit types
 					switch paramIndex {
@@ -1082,6 +1421,10 @@ func (g *Z80Generator) generateSMCInstruction(inst ir.Instruction) error {
 				if inst.Type != nil && inst.Type.Size() == 1 {
 					g.emit("    LD A, (%s)", paramLabel)
 					g.storeFromA(inst.Dest)
+				} else if is24Bit(param.Type) {
+					g.emit("    LD HL, (%s+2)", paramLabel)
+					g.emit("    LD A, (%s)", paramLabel)
+					g.store24(inst.Dest)
 				} else {
 					g.emit("    LD HL, (%s)", paramLabel)
 					g.storeFromHL(inst.Dest)
@@ -1109,6 +1452,11 @@ func (g *Z80Generator) generateSMCRecursiveCall(inst ir.Instruction) error {
 		if param.Type.Size() == 1 {
 			g.emit("    LD A, (%s)", paramLabel)
 			g.emit("    PUSH AF")
+		} else if is24Bit(param.Type) {
+			g.emit("    LD A, (%s)", paramLabel)
+			g.emit("    PUSH AF")
+			g.emit("    LD HL, (%s+2)", paramLabel)
+			g.emit("    PUSH HL")
 		} else {
 			g.emit("    LD HL, (%s)", paramLabel)
 			g.emit("    PUSH HL")
@@ -1121,6 +1469,11 @@ func (g *Z80Generator) generateSMCRecursiveCall(inst ir.Instruction) error {
 	
 	g.emit("    CALL %s", inst.Symbol)
 	
+	// Store the result before the restore overwrites HL and A
+	if inst.Dest != 0 {
+		g.store24(inst.Dest)
+	}
+	
 	g.emit("    ; === SMC Recursive Context Restore ===")
 	// Restore in reverse order
 	for i := len(fn.Params) - 1; i >= 0; i-- {
@@ -1130,17 +1483,17 @@ func (g *Z80Generator) generateSMCRecursiveCall(inst ir.Instruction) error {
 		if param.Type.Size() == 1 {
 			g.emit("    POP AF")
 			g.emit("    LD (%s), A", paramLabel)
+		} else if is24Bit(param.Type) {
+			g.emit("    POP HL")
+			g.emit("    LD (%s+2), HL", paramLabel)
+			g.emit("    POP AF")
+			g.emit("    LD (%s), A", paramLabel)
 		} else {
 			g.emit("    POP HL")
 			g.emit("    LD (%s), HL", paramLabel)
 		}
 	}
 	
-	// Store the result if needed
-	if inst.Dest != 0 {
-		g.storeFromHL(inst.Dest)
-	}
-	
 	return nil
 }
 
@@ -1171,7 +1524,8 @@ func (g *Z80Generator) generatePrologue(fn *ir.Function) {
 	// Setup stack frame if using stack-based locals
 	if !g.useAbsoluteLocals && (len(fn.Locals) > 0 || len(fn.Params) > 0) {
 		g.emit("    PUSH IX")
-		g.emit("    LD IX, SP")
+		g.emit("    LD IX, 0")
+		g.emit("    ADD IX, SP     ; No LD IX, SP on the Z80")
 		
 		// Allocate space for locals
 		if g.stackOffset > 0 {
@@ -1190,7 +1544,8 @@ func (g *Z80Generator) generatePrologue(fn *ir.Function) {
 	} else if len(fn.Locals) > 0 || len(fn.Params) > 0 {
 		// Even in absolute mode, we might need IX for parameters
 		g.emit("    PUSH IX")
-		g.emit("    LD IX, SP")
+		g.emit("    LD IX, 0")
+		g.emit("    ADD IX, SP     ; No LD IX, SP on the Z80")
 	}
 	
 	// Check if we should use shadow registers for this function
@@ -1238,6 +1593,23 @@ func (g *Z80Generator) generateEpilogue() {
 		return
 	}
 	
+	g.generateFrameTeardown()
+	g.emit("    RET")
+}
+
+// teardownRestoresHL reports whether the frame teardown pops HL
+func (g *Z80Generator) teardownRestoresHL() bool {
+	fn := g.currentFunc
+	if fn.IsSMCDefault || fn.IsSMCEnabled {
+		return false
+	}
+	return fn.ModifiedRegisters.Contains(ir.Z80_HL)
+}
+
+// generateFrameTeardown restores the caller's state without returning
+func (g *Z80Generator) generateFrameTeardown() {
+	fn := g.currentFunc
+	
 	// For SMC functions
 	if fn.IsSMCDefault || fn.IsSMCEnabled {
 		// No IX usage at all - even recursive functions don't need it!
@@ -1250,7 +1622,6 @@ func (g *Z80Generator) generateEpilogue() {
 				g.emit("    POP BC")
 			}
 		}
-		g.emit("    RET")
 		return
 	}
 	
@@ -1279,8 +1650,65 @@ func (g *Z80Generator) generateEpilogue() {
 	if fn.ModifiedRegisters.Contains(ir.Z80_AF) {
 		g.emit("    POP AF")
 	}
+}
+
+// isAddressTableElement reports whether an indexed load reads a 16-bit
+// address: an entry of a function table or of an enum name table
+func isAddressTableElement(t ir.Type) bool {
+	switch t.(type) {
+	case *ir.FunctionType, *ir.PointerType:
+		return true
+	}
+	return false
+}
+
+// generateLoadAddressTableEntry loads the address at index Src2 of the
+// table at Src1. Tables hold at most 256 entries, so the index is taken as
+// a byte.
+func (g *Z80Generator) generateLoadAddressTableEntry(inst ir.Instruction) {
+	g.loadToHL(inst.Src1)
+	g.emit("    PUSH HL")
+	g.loadToA(inst.Src2)
+	if g.z80n {
+		g.emit("    POP HL")
+		g.emit("    ADD HL, A")
+		g.emit("    ADD HL, A          ; 2 bytes per entry")
+	} else {
+		g.emit("    LD E, A")
+		g.emit("    LD D, 0")
+		g.emit("    POP HL")
+		g.emit("    ADD HL, DE")
+		g.emit("    ADD HL, DE         ; 2 bytes per entry")
+	}
+	g.emit("    LD E, (HL)")
+	g.emit("    INC HL")
+	g.emit("    LD D, (HL)")
+	g.emit("    EX DE, HL          ; HL = table entry")
+	g.storeFromHL(inst.Dest)
+}
+
+// generateJumpIndirect generates a computed goto through a register.
+// The target inherits our return address, so the frame is torn down first.
+func (g *Z80Generator) generateJumpIndirect(inst ir.Instruction) error {
+	if g.currentFunc.IsInterrupt {
+		return fmt.Errorf("computed goto is not allowed in interrupt handler %s", g.currentFunc.Name)
+	}
+	
+	g.loadToHL(inst.Src1)
+	
+	if g.teardownRestoresHL() {
+		// Teardown pops HL - park the target in the JP immediate instead
+		patchLabel := g.uniqueLabel("goto_ptr")
+		g.emit("    LD (%s+1), HL ; Patch computed target", patchLabel)
+		g.generateFrameTeardown()
+		g.emit("%s:", patchLabel)
+		g.emit("    JP 0          ; Computed goto (patched)")
+		return nil
+	}
 	
-	g.emit("    RET")
+	g.generateFrameTeardown()
+	g.emit("    JP (HL)       ; Computed goto")
+	return nil
 }
 
 // generatePatchPoint generates a patchable instruction sequence
@@ -1418,14 +1846,24 @@ func (g *Z80Generator) prepareCallArguments(args []ir.Register, targetFunc *ir.F
 		// Register-based parameter passing
 		g.emit("    ; Register-based parameter passing")
 		
-		// Map arguments to registers based on type and position
-		for i, arg := range args {
+		// Map arguments to registers based on type and position, the
+		// last first: loading one may go through A or HL
+		for i := len(args) - 1; i >= 0; i-- {
+			arg := args[i]
 			if i >= len(targetFunc.Params) {
-				break
+				continue
 			}
 			param := targetFunc.Params[i]
 			
-			if param.Type.Size() == 1 {
+			if is24Bit(param.Type) && i < 2 {
+				g.load24(arg, isSignedType(param.Type))
+				if i == 0 {
+					g.emit("    ; Parameter %s in A:HL", param.Name)
+				} else {
+					g.emit("    LD C, A")
+					g.emit("    EX DE, HL     ; Parameter %s in C:DE", param.Name)
+				}
+			} else if param.Type.Size() == 1 {
 				// 8-bit parameter
 				switch i {
 				case 0:
@@ -1476,7 +1914,14 @@ func (g *Z80Generator) loadParametersFromRegisters(fn *ir.Function) {
 	g.emit("    ; Load parameters from registers")
 	
 	for i, param := range fn.Params {
-		if param.Type.Size() == 1 {
+		if is24Bit(param.Type) && i < 2 {
+			// 24-bit parameter
+			if i == 1 {
+				g.emit("    EX DE, HL     ; Get parameter %s from C:DE", param.Name)
+				g.emit("    LD A, C")
+			}
+			g.store24(param.Reg)
+		} else if param.Type.Size() == 1 {
 			// 8-bit parameter
 			switch i {
 			case 0:
@@ -1509,6 +1954,11 @@ func (g *Z80Generator) loadParametersFromRegisters(fn *ir.Function) {
 
 // generateInterruptPrologue generates prologue for interrupt handlers
 func (g *Z80Generator) generateInterruptPrologue(fn *ir.Function) {
+	if fn.InterruptMode == 2 {
+		g.generateIM2Prologue()
+		return
+	}
+	
 	// Interrupt handlers must save ALL registers they modify
 	// Use EX and EXX for efficiency when possible
 	
@@ -1531,6 +1981,11 @@ func (g *Z80Generator) generateInterruptPrologue(fn *ir.Function) {
 
 // generateInterruptEpilogue generates epilogue for interrupt handlers
 func (g *Z80Generator) generateInterruptEpilogue(fn *ir.Function) {
+	if fn.InterruptMode == 2 {
+		g.generateIM2Epilogue()
+		return
+	}
+	
 	// Restore in reverse order
 	if fn.ModifiedRegisters.Contains(ir.Z80_IY) {
 		g.emit("    POP IY")
@@ -1553,9 +2008,16 @@ func (g *Z80Generator) generateInterruptEpilogue(fn *ir.Function) {
 
 // generateInstruction generates code for a single IR instruction
 func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
+	// Source annotations stand alone, without the per-instruction comment
+	if inst.Op == ir.OpSourceMark {
+		g.emit("; %s", inst.String())
+		return nil
+	}
+
 	// Add comment for instruction
 	if inst.Comment == "" {
-		g.emit("    ; %s", inst.String())
+		// One line, however many the asm code of an OpAsm has
+		g.emit("    ; %s", strings.ReplaceAll(inst.String(), "\n", "; "))
 	} else {
 		g.emit("    ; %s", inst.Comment)
 	}
@@ -1595,7 +2057,14 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 		g.emit("    JP NZ, %s", inst.Symbol)
 		
 	case ir.OpReturn:
-		if inst.Src1 != 0 {
+		if inst.Src1 != 0 && is24Bit(g.currentFunc.ReturnType) {
+			// 24-bit values are returned in A:HL
+			g.load24(inst.Src1, isSignedType(g.currentFunc.ReturnType))
+			if target, ok := g.currentFunc.GetMetadata("direct_return_target"); ok {
+				g.emit("    LD (%s), HL    ; Direct return optimization", target)
+				g.emit("    LD (%s+2), A", target)
+			}
+		} else if inst.Src1 != 0 {
 			// Check if this function has direct return optimization
 			if target, ok := g.currentFunc.GetMetadata("direct_return_target"); ok {
 				// Directly store to the target location instead of returning in HL
@@ -1616,8 +2085,10 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 			fmt.Printf("DEBUG: Current constants map: %v\n", g.constantValues)
 		}
 		
-		// Load constant to register
-		if inst.Imm < 256 {
+		// Load constant to register; 16-bit fixed-point needs both bytes
+		if g.wide[inst.Dest] {
+			g.generate24Const(inst)
+		} else if inst.Imm < 256 && !isFixed16(inst.Type) {
 			g.emit("    LD A, %d", inst.Imm)
 			g.storeFromA(inst.Dest)
 		} else {
@@ -1651,6 +2122,12 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 		}
 		
 	case ir.OpLoadVar:
+		if is24Bit(g.variableType(inst.Symbol, inst.Src1)) {
+			g.loadSlot24(g.variableSlot(inst.Symbol, inst.Src1))
+			g.store24(inst.Dest)
+			return nil
+		}
+		
 		// First, determine the type of the variable
 		var varType ir.Type
 		var localReg ir.Register
@@ -1749,6 +2226,12 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 		}
 		
 	case ir.OpStoreVar:
+		if varType := g.variableType(inst.Symbol, inst.Dest); is24Bit(varType) {
+			g.load24(inst.Src1, isSignedType(varType))
+			g.storeSlot24(g.variableSlot(inst.Symbol, inst.Dest))
+			return nil
+		}
+		
 		// Store to variable
 		// First, determine the type of the variable
 		var varType ir.Type
@@ -1866,23 +2349,37 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 		
 	case ir.OpMove:
 		// Move from source to destination register
+		if g.wide[inst.Dest] {
+			g.load24(inst.Src1, isSignedType(inst.Type))
+			g.store24(inst.Dest)
+			return nil
+		}
 		g.loadToHL(inst.Src1)
 		g.storeFromHL(inst.Dest)
 		
 	case ir.OpAdd:
-		// Load operands efficiently
-		g.loadToHL(inst.Src1)
-		g.emit("    LD D, H")
-		g.emit("    LD E, L")
-		g.loadToHL(inst.Src2)
+		if g.wide[inst.Dest] {
+			g.generate24Arith(inst)
+			return nil
+		}
+		// Addition commutes, so whichever operand is already in HL can
+		// be swapped into DE
+		if g.currentRegister == inst.Src2 {
+			g.loadToDEAndHL(inst.Src2, inst.Src1)
+		} else {
+			g.loadToDEAndHL(inst.Src1, inst.Src2)
+		}
 		g.emit("    ADD HL, DE")
 		g.storeFromHL(inst.Dest)
 		
 	case ir.OpSub:
+		if g.wide[inst.Dest] {
+			g.generate24Arith(inst)
+			return nil
+		}
 		// HL = Src1 - Src2
 		// Optimal: load Src1 to HL, Src2 to DE, then subtract
-		g.loadToHL(inst.Src1)
-		g.loadToDE(inst.Src2)
+		g.loadToDEAndHL(inst.Src2, inst.Src1)
 		g.emit("    OR A          ; Clear carry")
 		g.emit("    SBC HL, DE    ; HL = Src1 - Src2")
 		g.storeFromHL(inst.Dest)
@@ -1938,6 +2435,10 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 		g.storeFromHL(inst.Dest)
 		
 	case ir.OpMul:
+		if ir.FixedFracBits(inst.Type) > 0 {
+			return g.generateFixedMul(inst)
+		}
+
 		// Check for constant optimization opportunity
 		var constMultiplier int64
 		var hasConstant bool
@@ -1976,6 +2477,12 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 			}
 		}
 		
+		// MUL D,E beats any shift-add sequence but a plain shift
+		if g.z80n && !(hasConstant && isPowerOfTwo(constMultiplier)) {
+			g.generateZ80NMul(inst, is16bit)
+			break
+		}
+		
 		// Try optimization if we have a constant
 		if hasConstant && canOptimizeMultiplication(constMultiplier) {
 			// Load the variable operand
@@ -2008,107 +2515,11 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 			break
 		}
 		
-		// Fall back to original loop-based multiplication
-		if is16bit {
-			// 16-bit multiplication using repeated addition
-			g.emit("    ; 16-bit multiplication")
-			g.loadToHL(inst.Src1)
-			g.emit("    LD (mul_src1_%d), HL  ; Save multiplicand", g.labelCounter)
-			g.loadToHL(inst.Src2)
-			g.emit("    LD (mul_src2_%d), HL  ; Save multiplier", g.labelCounter)
-			g.emit("    LD HL, 0             ; Result = 0")
-			g.emit("    LD DE, (mul_src1_%d)  ; DE = multiplicand", g.labelCounter)
-			g.emit("    LD BC, (mul_src2_%d)  ; BC = multiplier", g.labelCounter)
-			g.emit("    LD A, B")
-			g.emit("    OR C                 ; Check if multiplier is 0")
-			g.emit("    JR Z, .mul16_done_%d", g.labelCounter)
-			g.emit("%s:", g.getFunctionLabel("mul16_loop"))
-			g.emit("    ADD HL, DE           ; Result += multiplicand")
-			g.emit("    DEC BC")
-			g.emit("    LD A, B")
-			g.emit("    OR C")
-			g.emit("    JR NZ, .mul16_loop_%d", g.labelCounter)
-			g.emit("%s:", g.getFunctionLabel("mul16_done"))
-			g.emit("mul_src1_%d: DW 0", g.labelCounter)
-			g.emit("mul_src2_%d: DW 0", g.labelCounter)
-			g.labelCounter++
-			g.storeFromHL(inst.Dest)
-		} else {
-			// Default 8-bit multiplication
-			g.emit("    ; 8-bit multiplication")
-			g.loadToA(inst.Src1)
-			g.emit("    LD B, A       ; B = multiplicand")
-			g.loadToA(inst.Src2)
-			g.emit("    LD C, A       ; C = multiplier")
-			g.emit("    LD HL, 0      ; HL = result")
-			g.emit("    LD A, C")
-			g.emit("    OR A          ; Check if multiplier is 0")
-			muldoneLabel := g.getFunctionLabel("mul_done")
-			g.emit("    JR Z, %s", muldoneLabel)
-			g.emit("%s:", g.getFunctionLabel("mul_loop"))
-			g.emit("    LD D, 0")
-			g.emit("    LD E, B")
-			g.emit("    ADD HL, DE    ; Add multiplicand to result")
-			g.emit("    DEC C")
-			g.emit("    JR NZ, %s", g.getFunctionLabel("mul_loop"))
-			g.emit("%s:", g.getFunctionLabel("mul_done"))
-			g.labelCounter++
-			g.storeFromHL(inst.Dest)
-		}
-		
-	case ir.OpDiv:
-		// 8-bit division using repeated subtraction
-		// Src1 / Src2 -> Dest
-		g.emit("    ; 8-bit division")
-		g.loadToA(inst.Src1)
-		g.emit("    LD D, A       ; D = dividend")
-		g.loadToA(inst.Src2)
-		g.emit("    LD E, A       ; E = divisor")
-		g.emit("    OR A          ; Check for divide by zero")
-		divbyzeroLabel := g.getFunctionLabel("div_by_zero")
-		g.emit("    JR Z, %s", divbyzeroLabel)
-		g.emit("    LD B, 0       ; B = quotient")
-		g.emit("    LD A, D       ; A = remainder")
-		g.emit("%s:", g.getFunctionLabel("div_loop"))
-		g.emit("    CP E          ; Compare remainder with divisor")
-		divdoneLabel := g.getFunctionLabel("div_done")
-		g.emit("    JR C, %s", divdoneLabel)
-		g.emit("    SUB E         ; Subtract divisor")
-		g.emit("    INC B         ; Increment quotient")
-		g.emit("    JR %s", g.getFunctionLabel("div_loop"))
-		g.emit("%s:", g.getFunctionLabel("div_by_zero"))
-		g.emit("    LD B, 0       ; Return 0 for divide by zero")
-		g.emit("%s:", divdoneLabel)
-		g.emit("    LD L, B       ; Result in L")
-		g.emit("    LD H, 0")
-		g.labelCounter++
-		g.storeFromHL(inst.Dest)
+		// Shift-and-add, inline or through the runtime library
+		g.generateMul(inst, is16bit)
 		
-	case ir.OpMod:
-		// Modulo operation - remainder after division
-		// Src1 % Src2 -> Dest
-		g.emit("    ; 8-bit modulo")
-		g.loadToA(inst.Src1)
-		g.emit("    LD D, A       ; D = dividend")
-		g.loadToA(inst.Src2)
-		g.emit("    LD E, A       ; E = divisor")
-		g.emit("    OR A          ; Check for divide by zero")
-		modbyzeroLabel := g.getFunctionLabel("mod_by_zero")
-		g.emit("    JR Z, %s", modbyzeroLabel)
-		g.emit("    LD A, D       ; A = dividend")
-		g.emit("%s:", g.getFunctionLabel("mod_loop"))
-		g.emit("    CP E          ; Compare with divisor")
-		moddoneLabel := g.getFunctionLabel("mod_done")
-		g.emit("    JR C, %s", moddoneLabel)
-		g.emit("    SUB E         ; Subtract divisor")
-		g.emit("    JR %s", g.getFunctionLabel("mod_loop"))
-		g.emit("%s:", g.getFunctionLabel("mod_by_zero"))
-		g.emit("    LD A, 0       ; Return 0 for modulo by zero")
-		g.emit("%s:", moddoneLabel)
-		g.emit("    LD L, A       ; Result (remainder) in L")
-		g.emit("    LD H, 0")
-		g.labelCounter++
-		g.storeFromHL(inst.Dest)
+	case ir.OpDiv, ir.OpMod:
+		g.generateDivMod(inst)
 		
 	case ir.OpInc:
 		// Increment register
@@ -2333,6 +2744,10 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 		g.labelCounter++
 		g.storeFromHL(inst.Dest)
 		
+	case ir.OpRotl, ir.OpRotr:
+		// Rotate builtins (rotl8, rotr8, rotl16, rotr16)
+		return g.generateRotate(inst)
+		
 	case ir.OpNot:
 		// Bitwise NOT (one's complement)
 		// Check if 16-bit or 8-bit based on type
@@ -2362,12 +2777,26 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 		}
 		
 	case ir.OpEq, ir.OpNe, ir.OpLt, ir.OpGt, ir.OpLe, ir.OpGe:
+		if value, next, ok := g.byteCompareJump(inst); ok {
+			g.loadToA(inst.Src1)
+			g.emit("    CP %d", value)
+			g.emit("    JP Z, %s", g.sanitizeLabel(next.Label))
+			g.fusedInstructions = 1
+			break
+		}
 		g.generateComparison(inst)
 		
 	case ir.OpCall:
 		// Check if calling a TRUE SMC function
 		g.emit("    ; Call to %s (args: %d)", inst.Symbol, len(inst.Args))
 		targetFunc := g.findFunction(inst.Symbol)
+		if inst.TailCall && targetFunc != nil {
+			if fused := g.tailCallReturn(inst, targetFunc); fused > 0 {
+				g.generateTailCall(inst, targetFunc)
+				g.fusedInstructions = fused
+				break
+			}
+		}
 		
 		// Prepare arguments before the call
 		if len(inst.Args) > 0 {
@@ -2381,8 +2810,7 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 				g.generateTrueSMCCall(inst, targetFunc)
 			} else {
 				// Use sanitized function name for assembler compatibility
-				cleanName := g.sanitizeFunctionName(targetFunc.Name)
-				g.emit("    CALL %s", cleanName)
+				g.emit("    CALL %s", g.callTarget(targetFunc.Name))
 				// Track function usage
 				g.usedFunctions[targetFunc.Name] = true
 			}
@@ -2393,8 +2821,8 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 			// Track stdlib function usage
 			g.usedFunctions[inst.Symbol] = true
 		}
-		// Result is in HL
-		g.storeFromHL(inst.Dest)
+		// Result is in HL, or A:HL for 24 bits
+		g.store24(inst.Dest)
 		
 	case ir.OpPatchPoint:
 		// Define a patchable instruction sequence
@@ -2470,6 +2898,11 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 			g.emit("    ADD HL, DE")
 		}
 		// Load value at offset
+		if inst.Type != nil && inst.Type.Size() == 1 {
+			g.emit("    LD A, (HL)")
+			g.storeFromA(inst.Dest)
+			break
+		}
 		g.emit("    LD E, (HL)")
 		g.emit("    INC HL")
 		g.emit("    LD D, (HL)")
@@ -2486,11 +2919,14 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 		}
 		g.emit("    PUSH HL")
 		g.loadToHL(inst.Src2)
-		g.emit("    POP DE")
+		g.emit("    EX DE, HL")
+		g.emit("    POP HL")
 		// Store value at offset
-		g.emit("    LD (DE), L")
-		g.emit("    INC DE")
-		g.emit("    LD (DE), H")
+		g.emit("    LD (HL), E")
+		if inst.Type == nil || inst.Type.Size() != 1 {
+			g.emit("    INC HL")
+			g.emit("    LD (HL), D")
+		}
 		
 	case ir.OpLoadBitField:
 		// Load bit field value
@@ -2559,6 +2995,9 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 		}
 		
 		// Process inline assembly code
+		if hasAsmOperands(&inst) {
+			return g.generateAsmWithOperands(&inst)
+		}
 		g.emitAsmBlock(inst.AsmCode)
 		
 	case ir.OpSetError:
@@ -2629,6 +3068,10 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 		g.emit("    CALL print_i16_decimal")
 		g.usedFunctions["print_i16_decimal"] = true
 		
+	case ir.OpPrintFixed:
+		// Print fixed-point as a decimal fraction
+		return g.generatePrintFixed(inst)
+		
 	case ir.OpPrintBool:
 		// Print bool as "true" or "false"
 		g.loadToA(inst.Src1)
@@ -2722,6 +3165,14 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 		// Use LDIR for block copy
 		g.emit("    LDIR           ; Copy BC bytes from HL to DE")
 		
+	case ir.OpFormat:
+		// Built-in format - write one piece of text into a buffer
+		return g.generateFormat(inst)
+		
+	case ir.OpStrCall:
+		// String runtime - compare, copy, append or search
+		return g.generateStrCall(inst)
+		
 	case ir.OpMemset:
 		// Built-in memset - set memory block
 		// Src1 = dest, Src2 = value, Args[0] = size
@@ -2752,24 +3203,43 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 	case ir.OpLoadIndex:
 		// Load element from array
 		// Src1 = array pointer, Src2 = index
+		if isAddressTableElement(inst.Type) {
+			g.generateLoadAddressTableEntry(inst)
+			break
+		}
 		g.loadToHL(inst.Src1)
 		// Save array pointer
 		g.emit("    PUSH HL")
 		// Load index to DE
 		if inst.Type != nil && inst.Type.Size() == 1 {
-			// For byte index, load to A first then to DE
+			// For byte index, load to A first then to DE (Z80N adds A itself)
 			g.loadToA(inst.Src2)
-			g.emit("    LD E, A")
-			g.emit("    LD D, 0")
+			if !g.z80n {
+				g.emit("    LD E, A")
+				g.emit("    LD D, 0")
+			}
 		} else {
 			g.loadToDE(inst.Src2)
 		}
 		// Restore array pointer
 		g.emit("    POP HL")
-		// Multiply index by element size (assuming 1 byte elements for now)
-		// TODO: Handle different element sizes
-		g.emit("    ADD HL, DE")
-		// Load value at array[index]
+		if inst.Type != nil && inst.Type.Size() == 2 {
+			// Word elements: HL + 2*index, then load both bytes
+			g.emit("    ADD HL, DE")
+			g.emit("    ADD HL, DE")
+			g.emit("    LD E, (HL)")
+			g.emit("    INC HL")
+			g.emit("    LD D, (HL)")
+			g.emit("    EX DE, HL")
+			g.storeFromHL(inst.Dest)
+			break
+		}
+		// Byte elements (u8, bool and u8-backed enums): one byte per index
+		if g.z80n {
+			g.emit("    ADD HL, A")
+		} else {
+			g.emit("    ADD HL, DE")
+		}
 		g.emit("    LD A, (HL)")
 		g.storeFromA(inst.Dest)
 		
@@ -2803,12 +3273,15 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 		
 	case ir.OpDJNZ:
 		// Decrement and jump if not zero
-		// Uses B register for Z80's native DJNZ instruction
+		// Uses B register for Z80's native DJNZ instruction. Unless the
+		// counter lives in B, the count left is stored before the jump,
+		// so the next iteration reloads it.
 		g.loadToB(inst.Src1)
-		g.emit("    DJNZ %s", inst.Label)
-		// Store updated value back
-		g.emit("    LD A, B")
-		g.storeFromA(inst.Src1)
+		if location, value := g.getRegisterLocation(inst.Src1); location != LocationPhysical || value.(PhysicalReg) != RegB {
+			g.emit("    DEC A")
+			g.storeFromA(inst.Src1)
+		}
+		g.emit("    DJNZ %s", g.sanitizeLabel(inst.Label))
 		
 	case ir.OpLoadImm:
 		// Load immediate value
@@ -2927,36 +3400,46 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 		
 	case ir.OpStoreIndex:
 		// Store element to array
-		// Src1 = array pointer, Src2 = index, Imm = value to store (packed in immediate)
-		// Note: This is a limitation - we need a third source operand
-		// For now, assume the value is in a fixed location or use a workaround
+		// Src1 = array pointer, Src2 = index, Src3 = value to store
 		g.loadToHL(inst.Src1)
 		// Save array pointer
 		g.emit("    PUSH HL")
-		// Load index
-		if inst.Type != nil && inst.Type.Size() == 1 {
-			// For byte arrays
+		// Load index, then restore array pointer and add it
+		if inst.Type != nil && inst.Type.Size() == 1 && g.z80n {
+			// For byte arrays, Z80N adds A to HL directly
 			g.loadToA(inst.Src2)
-			g.emit("    LD E, A")
-			g.emit("    LD D, 0")
+			g.emit("    POP HL")
+			g.emit("    ADD HL, A")
 		} else {
-			// For word arrays
-			g.loadToDE(inst.Src2)
-			// Multiply by 2 for word-sized elements
-			g.emit("    SLA E")
-			g.emit("    RL D")
+			if inst.Type != nil && inst.Type.Size() == 1 {
+				// For byte arrays
+				g.loadToA(inst.Src2)
+				g.emit("    LD E, A")
+				g.emit("    LD D, 0")
+			} else {
+				// For word arrays
+				g.loadToDE(inst.Src2)
+				// Multiply by 2 for word-sized elements
+				g.emit("    SLA E")
+				g.emit("    RL D")
+			}
+			g.emit("    POP HL")
+			g.emit("    ADD HL, DE")
 		}
-		// Restore array pointer and add index
-		g.emit("    POP HL")
-		g.emit("    ADD HL, DE")
-		// Store value at array[index]
-		// TODO: This needs the value source - for now using immediate
+		// Store value at array[index], keeping the element address while
+		// the value is loaded
+		g.emit("    PUSH HL")
 		if inst.Type != nil && inst.Type.Size() == 1 {
-			g.emit("    LD (HL), %d    ; TODO: Need value source", inst.Imm)
+			g.loadToA(inst.Src3)
+			g.emit("    POP HL")
+			g.emit("    LD (HL), A")
 		} else {
-			g.emit("    LD (HL), %d    ; TODO: Need value source (low)", inst.Imm & 0xFF)
+			g.loadToHL(inst.Src3)
+			g.emit("    EX DE, HL")
+			g.emit("    POP HL")
+			g.emit("    LD (HL), E")
 			g.emit("    INC HL")
-			g.emit("    LD (HL), %d    ; TODO: Need value source (high)", (inst.Imm >> 8) & 0xFF)
+			g.emit("    LD (HL), D")
 		}
 		
 	case ir.OpLoadParam:
@@ -3039,6 +3522,9 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 		}
 		
 		
+	case ir.OpJumpIndirect:
+		return g.generateJumpIndirect(inst)
+		
 	case ir.OpCallIndirect:
 		// Indirect function call through register (for lambdas)
 		g.emit("    ; Indirect call through r%d", inst.Src1)
@@ -3178,13 +3664,19 @@ func (g *Z80Generator) generateInstruction(inst ir.Instruction) error {
 func (g *Z80Generator) generateComparison(inst ir.Instruction) {
 	// For comparisons, we need both operands in different registers
 	// Optimal pattern: determine which operand to load first based on the operation
+	if inst.Type != nil && inst.Type.Size() == 1 {
+		g.generateByteComparison(inst)
+		return
+	}
+	if is24Bit(inst.Type) || g.wide[inst.Src1] || g.wide[inst.Src2] {
+		g.generate24Comparison(inst)
+		return
+	}
 	
 	switch inst.Op {
 	case ir.OpEq:
 		// Equality comparison - order doesn't matter
-		// Optimal: load Src1 to HL, Src2 to DE, then compare
-		g.loadToHL(inst.Src1)
-		g.loadToDE(inst.Src2)
+		g.loadToDEAndHL(inst.Src2, inst.Src1)
 		g.emit("    OR A           ; Clear carry")
 		g.emit("    SBC HL, DE     ; Compare Src1 - Src2")
 		eqTrueLabel := g.getFunctionLabel("eq_true")
@@ -3200,9 +3692,7 @@ func (g *Z80Generator) generateComparison(inst ir.Instruction) {
 		
 	case ir.OpNe:
 		// Not equal
-		// Optimal: load Src1 to HL, Src2 to DE, then compare
-		g.loadToHL(inst.Src1)
-		g.loadToDE(inst.Src2)
+		g.loadToDEAndHL(inst.Src2, inst.Src1)
 		g.emit("    OR A           ; Clear carry")
 		g.emit("    SBC HL, DE     ; Compare Src1 - Src2")
 		neTrueLabel := g.getFunctionLabel("ne_true")
@@ -3217,84 +3707,120 @@ func (g *Z80Generator) generateComparison(inst ir.Instruction) {
 		g.storeFromHL(inst.Dest)
 		
 	case ir.OpLt:
-		// Less than: Src1 < Src2
-		g.loadToHL(inst.Src1)
-		g.loadToDE(inst.Src2)
-		g.emit("    OR A           ; Clear carry")
-		g.emit("    SBC HL, DE     ; Compare Src1 - Src2")
-		ltTrueLabel := g.getFunctionLabel("lt_true")
-		ltDoneLabel := g.getFunctionLabel("lt_done")
-		g.emit("    JP M, %s", ltTrueLabel)
-		g.emit("    LD HL, 0       ; False")
-		g.emit("    JP %s", ltDoneLabel)
-		g.emit("%s:", ltTrueLabel)
-		g.emit("    LD HL, 1       ; True")
-		g.emit("%s:", ltDoneLabel)
-		g.labelCounter++
-		g.storeFromHL(inst.Dest)
+		// Src1 < Src2
+		g.generateLessThan(inst.Src1, inst.Src2, inst, false)
 		
 	case ir.OpGt:
-		// Greater than: Src1 > Src2
-		g.loadToHL(inst.Src1)
-		g.loadToDE(inst.Src2)
-		g.emit("    OR A           ; Clear carry")
-		g.emit("    SBC HL, DE     ; Compare Src1 - Src2")
-		gtCheckZeroLabel := g.getFunctionLabel("gt_check_zero")
-		gtFalseLabel := g.getFunctionLabel("gt_false")
-		gtDoneLabel := g.getFunctionLabel("gt_done")
-		g.emit("    JP P, %s", gtCheckZeroLabel)
-		g.emit("    LD HL, 0       ; False (negative)")
-		g.emit("    JP %s", gtDoneLabel)
-		g.emit("%s:", gtCheckZeroLabel)
-		g.emit("    LD A, H")
-		g.emit("    OR L           ; Check if result is zero")
-		g.emit("    JP Z, %s", gtFalseLabel)
-		g.emit("    LD HL, 1       ; True (positive and non-zero)")
-		g.emit("    JP %s", gtDoneLabel)
-		g.emit("%s:", gtFalseLabel)
-		g.emit("    LD HL, 0       ; False (zero)")
-		g.emit("%s:", gtDoneLabel)
-		g.labelCounter++
-		g.storeFromHL(inst.Dest)
+		// Src1 > Src2 is Src2 < Src1
+		g.generateLessThan(inst.Src2, inst.Src1, inst, false)
 		
 	case ir.OpLe:
-		// Less than or equal: Src1 <= Src2
-		g.loadToHL(inst.Src1)
-		g.loadToDE(inst.Src2)
-		g.emit("    OR A           ; Clear carry")
-		g.emit("    SBC HL, DE     ; Compare Src1 - Src2")
-		leTrueLabel := g.getFunctionLabel("le_true")
-		leDoneLabel := g.getFunctionLabel("le_done")
-		g.emit("    JP M, %s", leTrueLabel)
-		g.emit("    JP Z, %s", leTrueLabel)
-		g.emit("    LD HL, 0       ; False")
-		g.emit("    JP %s", leDoneLabel)
-		g.emit("%s:", leTrueLabel)
-		g.emit("    LD HL, 1       ; True")
-		g.emit("%s:", leDoneLabel)
-		g.labelCounter++
-		g.storeFromHL(inst.Dest)
+		// Src1 <= Src2 is !(Src2 < Src1)
+		g.generateLessThan(inst.Src2, inst.Src1, inst, true)
 		
 	case ir.OpGe:
-		// Greater than or equal: Src1 >= Src2
-		g.loadToHL(inst.Src1)
-		g.loadToDE(inst.Src2)
-		g.emit("    OR A           ; Clear carry")
-		g.emit("    SBC HL, DE     ; Compare Src1 - Src2")
-		geTrueLabel := g.getFunctionLabel("ge_true")
-		geDoneLabel := g.getFunctionLabel("ge_done")
-		g.emit("    JP P, %s", geTrueLabel)
-		g.emit("    JP Z, %s", geTrueLabel)
-		g.emit("    LD HL, 0       ; False")
-		g.emit("    JP %s", geDoneLabel)
-		g.emit("%s:", geTrueLabel)
-		g.emit("    LD HL, 1       ; True")
-		g.emit("%s:", geDoneLabel)
-		g.labelCounter++
-		g.storeFromHL(inst.Dest)
+		// Src1 >= Src2 is !(Src1 < Src2)
+		g.generateLessThan(inst.Src1, inst.Src2, inst, true)
+	}
+}
+
+// generateLessThan stores lhs < rhs (or its negation) into inst.Dest.
+// After SBC HL, DE (or CP for bytes) the carry flag holds the unsigned
+// result; for signed types the result is S xor V, since overflow inverts
+// the sign bit.
+func (g *Z80Generator) generateLessThan(lhs, rhs ir.Register, inst ir.Instruction, negate bool) {
+	g.loadToDEAndHL(rhs, lhs)
+	g.emit("    OR A           ; Clear carry")
+	g.emit("    SBC HL, DE     ; Compare")
+	g.emitLessThanResult(inst, negate)
+}
+
+// generateByteComparison compares 8-bit operands with CP in A rather than
+// widening them to HL, where a byte loaded from memory would bring a
+// garbage high byte. CP leaves the flags as SBC HL, DE does.
+func (g *Z80Generator) generateByteComparison(inst ir.Instruction) {
+	lhs, rhs := inst.Src1, inst.Src2
+	if inst.Op == ir.OpGt || inst.Op == ir.OpLe {
+		// Src1 > Src2 is Src2 < Src1, as for 16 bits
+		lhs, rhs = rhs, lhs
+	}
+	g.loadToA(rhs)
+	g.emit("    LD E, A")
+	g.loadToA(lhs)
+	g.emit("    CP E           ; Compare")
+	
+	switch inst.Op {
+	case ir.OpEq, ir.OpNe:
+		g.emitEqualResult(inst)
+	case ir.OpLt, ir.OpGt:
+		g.emitLessThanResult(inst, false)
+	case ir.OpLe, ir.OpGe:
+		g.emitLessThanResult(inst, true)
 	}
 }
 
+// emitEqualResult stores the result of OpEq or OpNe from the zero flag of
+// the preceding compare into inst.Dest
+func (g *Z80Generator) emitEqualResult(inst ir.Instruction) {
+	cond := "Z"
+	if inst.Op == ir.OpNe {
+		cond = "NZ"
+	}
+	trueLabel := g.getFunctionLabel("eq_true")
+	doneLabel := g.getFunctionLabel("eq_done")
+	g.emit("    JP %s, %s", cond, trueLabel)
+	g.emit("    LD HL, 0       ; False")
+	g.emit("    JP %s", doneLabel)
+	g.emit("%s:", trueLabel)
+	g.emit("    LD HL, 1       ; True")
+	g.emit("%s:", doneLabel)
+	g.labelCounter++
+	g.storeFromHL(inst.Dest)
+}
+
+// emitLessThanResult stores the less-than result of the preceding compare
+// (or its negation) into inst.Dest
+func (g *Z80Generator) emitLessThanResult(inst ir.Instruction, negate bool) {
+	trueLabel := g.getFunctionLabel("lt_true")
+	falseLabel := g.getFunctionLabel("lt_false")
+	doneLabel := g.getFunctionLabel("lt_done")
+	
+	if isSignedType(inst.Type) {
+		noOverflowLabel := g.getFunctionLabel("lt_no_overflow")
+		g.emit("    JP PO, %s", noOverflowLabel)
+		g.emit("    JP P, %s       ; Overflow: sign is inverted", trueLabel)
+		g.emit("    JP %s", falseLabel)
+		g.emit("%s:", noOverflowLabel)
+		g.emit("    JP M, %s", trueLabel)
+	} else {
+		g.emit("    JP C, %s       ; Unsigned: borrow means less", trueLabel)
+	}
+	
+	falseValue, trueValue := 0, 1
+	if negate {
+		falseValue, trueValue = 1, 0
+	}
+	g.emit("%s:", falseLabel)
+	g.emit("    LD HL, %d", falseValue)
+	g.emit("    JP %s", doneLabel)
+	g.emit("%s:", trueLabel)
+	g.emit("    LD HL, %d", trueValue)
+	g.emit("%s:", doneLabel)
+	g.labelCounter++
+	g.storeFromHL(inst.Dest)
+}
+
+// isSignedType reports whether comparisons on t use signed logic
+func isSignedType(t ir.Type) bool {
+	if basic, ok := t.(*ir.BasicType); ok {
+		switch basic.Kind {
+		case ir.TypeI8, ir.TypeI16, ir.TypeI24:
+			return true
+		}
+	}
+	return false
+}
+
 // Register management helpers
 
 // loadToA loads a virtual register to A
@@ -3303,6 +3829,17 @@ func (g *Z80Generator) loadToA(reg ir.Register) {
 		g.emit("    XOR A")
 		return
 	}
+	if reg > 0 && g.aRegister == reg {
+		g.useSpilled(reg)
+		g.emit("    ; Register %d already in A", reg)
+		return
+	}
+	if reg > 0 && g.currentRegister == reg {
+		// The low byte, as LD A,(addr) would read it
+		g.useSpilled(reg)
+		g.emit("    LD A, L        ; Register %d from HL", reg)
+		return
+	}
 	
 	// Use hierarchical register allocation
 	location, value := g.getRegisterLocation(reg)
@@ -3356,6 +3893,7 @@ func (g *Z80Generator) loadToA(reg ir.Register) {
 			// Absolute addressing
 			g.emit("    LD A, ($%04X)     ; Virtual register %d from memory", addr, reg)
 		}
+		g.aRegister = reg
 	}
 }
 
@@ -3409,10 +3947,11 @@ func (g *Z80Generator) storeFromA(reg ir.Register) {
 			// Stack-based local variable - use IX+offset
 			offset := g.getLocalOffset(reg)
 			g.emit("    LD (IX%+d), A     ; Virtual register %d to stack", offset, reg)
-		} else {
+		} else if !g.holdSpill(reg, fmt.Sprintf("    LD ($%04X), A     ; Virtual register %d to memory", addr, reg)) {
 			// Absolute addressing
 			g.emit("    LD ($%04X), A     ; Virtual register %d to memory", addr, reg)
 		}
+		g.aRegister = reg
 	}
 }
 
@@ -3422,6 +3961,11 @@ func (g *Z80Generator) loadToHL(reg ir.Register) {
 		g.emit("    LD HL, 0")
 		return
 	}
+	if reg > 0 && g.currentRegister == reg {
+		g.useSpilled(reg)
+		g.emit("    ; Register %d already in HL", reg)
+		return
+	}
 	
 	// Use hierarchical register allocation for 16-bit loads
 	location, value := g.getRegisterLocation(reg)
@@ -3439,6 +3983,12 @@ func (g *Z80Generator) loadToHL(reg ir.Register) {
 		if physReg == RegBC || physReg == RegDE {
 			g.emit("    LD H, %s", regName[:1]) // BC->B, DE->D
 			g.emit("    LD L, %s", regName[1:]) // BC->C, DE->E
+			g.currentRegister = reg
+		} else if physReg <= RegL {
+			// 8-bit value, zero-extended
+			g.emit("    LD L, %s", regName)
+			g.emit("    LD H, 0")
+			g.currentRegister = reg
 		}
 		
 	case LocationShadow:
@@ -3468,6 +4018,7 @@ func (g *Z80Generator) loadToHL(reg ir.Register) {
 			// Absolute addressing
 			g.emit("    LD HL, ($%04X)    ; Virtual register %d from memory", addr, reg)
 		}
+		g.currentRegister = reg
 	}
 }
 
@@ -3477,6 +4028,17 @@ func (g *Z80Generator) loadToDE(reg ir.Register) {
 		g.emit("    LD DE, 0")
 		return
 	}
+	if reg > 0 && g.deRegister == reg {
+		g.emit("    ; Register %d already in DE", reg)
+		return
+	}
+	if reg > 0 && g.currentRegister == reg {
+		g.useSpilled(reg)
+		g.emit("    LD D, H")
+		g.emit("    LD E, L        ; Register %d from HL", reg)
+		g.currentRegister, g.deRegister = reg, reg
+		return
+	}
 	
 	// Use hierarchical register allocation
 	location, value := g.getRegisterLocation(reg)
@@ -3495,6 +4057,7 @@ func (g *Z80Generator) loadToDE(reg ir.Register) {
 			regName := g.physicalRegToAssembly(physReg)
 			g.emit("    LD D, %s", regName[:1])
 			g.emit("    LD E, %s", regName[1:])
+			g.deRegister = reg
 		case RegA:
 			g.emit("    LD E, A        ; Load 8-bit value to DE")
 			g.emit("    LD D, 0        ; Zero extend")
@@ -3548,7 +4111,38 @@ func (g *Z80Generator) loadToDE(reg ir.Register) {
 			g.emit("    LD HL, ($%04X)    ; Virtual register %d from memory", addr, reg)
 			g.emit("    EX DE, HL")
 		}
+		g.deRegister = reg
+	}
+}
+
+// loadToDEAndHL loads one register to DE and another to HL. When the DE
+// operand is already in HL, EX DE,HL moves it across instead of reloading
+// it, which also brings back a HL operand that was sitting in DE.
+func (g *Z80Generator) loadToDEAndHL(de, hl ir.Register) {
+	if de > 0 && de != hl && g.currentRegister == de && g.deRegister != de {
+		inDE := g.deRegister
+		g.useSpilled(de)
+		g.emit("    EX DE, HL      ; Register %d from HL", de)
+		g.currentRegister, g.deRegister = inDE, de
+		g.loadToHL(hl)
+		return
+	}
+	// The HL operand is already in HL: park it in DE while the DE operand
+	// is loaded from memory through HL, instead of storing and reloading it
+	if hl > 0 && de > 0 && de != hl && g.currentRegister == hl && g.deRegister != de {
+		location, value := g.getRegisterLocation(de)
+		if location == LocationMemory && (g.useAbsoluteLocals || !g.isLocalRegister(de)) {
+			g.useSpilled(hl)
+			g.emit("    EX DE, HL      ; Keep register %d", hl)
+			g.emit("    LD HL, ($%04X)    ; Virtual register %d from memory", value.(uint16), de)
+			g.emit("    EX DE, HL")
+			g.currentRegister, g.deRegister = hl, de
+			return
+		}
 	}
+	// DE first: loading DE from memory goes through HL
+	g.loadToDE(de)
+	g.loadToHL(hl)
 }
 
 // storeFromHL stores HL to a virtual register
@@ -3569,6 +4163,16 @@ func (g *Z80Generator) storeFromHL(reg ir.Register) {
 		if physReg == RegBC || physReg == RegDE {
 			g.emit("    LD %s, H", regName[:1])
 			g.emit("    LD %s, L", regName[1:])
+			g.currentRegister = reg
+			if physReg == RegDE {
+				g.deRegister = reg
+			}
+		} else if physReg <= RegL {
+			// 8-bit register, low byte
+			g.emit("    LD %s, L", regName)
+			if physReg != RegH {
+				g.currentRegister = reg
+			}
 		}
 		
 	case LocationShadow:
@@ -3594,10 +4198,11 @@ func (g *Z80Generator) storeFromHL(reg ir.Register) {
 			offset := g.getLocalOffset(reg)
 			g.emit("    LD (IX%+d), L     ; Virtual register %d to stack (low)", offset, reg)
 			g.emit("    LD (IX%+d), H     ; Virtual register %d to stack (high)", offset+1, reg)
-		} else {
+		} else if !g.holdSpill(reg, fmt.Sprintf("    LD ($%04X), HL    ; Virtual register %d to memory", addr, reg)) {
 			// Absolute addressing
 			g.emit("    LD ($%04X), HL    ; Virtual register %d to memory", addr, reg)
 		}
+		g.currentRegister = reg
 	}
 }
 
@@ -3616,6 +4221,9 @@ func (g *Z80Generator) getGlobalAddr(name string) uint16 {
 	globalBase := uint16(0xF000)
 	for i, global := range g.module.Globals {
 		if global.Name == name {
+			if global.Address != nil {
+				return *global.Address
+			}
 			// Each global gets 32 bytes of space
 			return globalBase + uint16(i*32)
 		}
@@ -3631,10 +4239,21 @@ func (g *Z80Generator) newLabel() string {
 
 // emit writes a line of assembly
 func (g *Z80Generator) emit(format string, args ...interface{}) {
+	line := format
 	if len(args) > 0 {
-		fmt.Fprintf(g.writer, format+"\n", args...)
-	} else {
-		fmt.Fprintln(g.writer, format)
+		line = fmt.Sprintf(format, args...)
+	}
+	code := strings.TrimSpace(line)
+	isCode := code != "" && !strings.HasPrefix(code, ";")
+	if isCode && g.spill != nil {
+		g.flushSpill()
+	}
+	fmt.Fprintln(g.writer, line)
+	
+	// Any instruction may change HL, DE or A, and any label may be reached
+	// with other values in them; only comments keep what is tracked
+	if isCode {
+		g.currentRegister, g.deRegister, g.aRegister = 0, 0, 0
 	}
 }
 
@@ -3712,7 +4331,15 @@ func (g *Z80Generator) generateTrueSMCCall(inst ir.Instruction, targetFunc *ir.F
 		return
 	}
 	
-	// Patch each parameter anchor with the argument value
+	g.patchTrueSMCArguments(inst, targetFunc)
+	
+	// Make the call
+	g.emit("    CALL %s", g.callTarget(targetFunc.Name))
+}
+
+// patchTrueSMCArguments patches each parameter anchor of a TRUE SMC
+// function with its argument
+func (g *Z80Generator) patchTrueSMCArguments(inst ir.Instruction, targetFunc *ir.Function) {
 	for i, param := range targetFunc.Params {
 		argReg := inst.Args[i]
 		anchorAddr := fmt.Sprintf("%s$imm0", param.Name)
@@ -3726,16 +4353,8 @@ func (g *Z80Generator) generateTrueSMCCall(inst ir.Instruction, targetFunc *ir.F
 			anchorHigh := fmt.Sprintf("%s$immHI0", param.Name)
 			anchorLow := fmt.Sprintf("%s$immLO0", param.Name)
 			
-			// Load the 24-bit value
-			// For now, assume it's in memory as 3 consecutive bytes
-			g.loadToHL(argReg)  // This loads the low 16 bits
-			
-			// Patch low 16 bits
+			g.load24(argReg, isSignedType(param.Type))
 			g.emit("    LD (%s), HL       ; Patch %s low 16 bits", anchorLow, param.Name)
-			
-			// Load high byte - for now, assume it's at argReg+2 in memory
-			// TODO: Proper 24-bit register allocation
-			g.emit("    LD A, 0           ; TODO: Load high byte of %s", param.Name)
 			g.emit("    LD (%s), A        ; Patch %s high byte", anchorHigh, param.Name)
 		} else {
 			// 16-bit patch - NO DI/EI needed (atomic instruction)
@@ -3743,9 +4362,71 @@ func (g *Z80Generator) generateTrueSMCCall(inst ir.Instruction, targetFunc *ir.F
 			g.emit("    LD (%s), HL       ; Patch %s (atomic)", anchorAddr, param.Name)
 		}
 	}
-	
-	// Make the call
-	g.emit("    CALL %s", targetFunc.Name)
+}
+
+// tailCallReturn returns how many instructions after a call marked as a
+// tail call lead up to the return of its result, or 0 if this function
+// cannot jump to targetFunc after all: its return does more than RET, or
+// its teardown would lose arguments passed in registers.
+func (g *Z80Generator) tailCallReturn(inst ir.Instruction, targetFunc *ir.Function) int {
+	fn := g.currentFunc
+	if fn.IsInterrupt || len(inst.Args) != len(targetFunc.Params) {
+		return 0
+	}
+	// Only SMC functions without TRUE SMC anchors end in a patchable return
+	if fn.NeedsPatchPoints && !fn.UsesTrueSMC {
+		return 0
+	}
+	if _, ok := fn.GetMetadata("direct_return_target"); ok {
+		return 0
+	}
+	if !(targetFunc.IsSMCDefault || targetFunc.IsSMCEnabled) && len(inst.Args) > 0 {
+		if targetFunc.IsRecursive || len(inst.Args) > 2 || g.teardownRestoresRegisters() {
+			return 0
+		}
+	}
+	for i := g.currentInstructionIndex + 1; i < len(fn.Instructions); i++ {
+		switch next := fn.Instructions[i]; next.Op {
+		case ir.OpNop:
+			continue
+		case ir.OpReturn:
+			if next.Src1 == 0 || next.Src1 == inst.Dest {
+				return i - g.currentInstructionIndex
+			}
+		}
+		break
+	}
+	return 0
+}
+
+// teardownRestoresRegisters reports whether the frame teardown pops or
+// exchanges any of the registers arguments are passed in
+func (g *Z80Generator) teardownRestoresRegisters() bool {
+	fn := g.currentFunc
+	if fn.IsSMCDefault || fn.IsSMCEnabled {
+		return fn.UsedRegisters != 0 && !fn.IsRecursive &&
+			(fn.ModifiedRegisters.Contains(ir.Z80_DE) || fn.ModifiedRegisters.Contains(ir.Z80_BC))
+	}
+	return g.useShadowRegs || fn.ModifiedRegisters.Contains(ir.Z80_HL) ||
+		fn.ModifiedRegisters.Contains(ir.Z80_DE) || fn.ModifiedRegisters.Contains(ir.Z80_BC) ||
+		fn.ModifiedRegisters.Contains(ir.Z80_AF)
+}
+
+// generateTailCall passes the arguments, tears down our frame and jumps to
+// targetFunc, whose RET then returns straight to our caller with the
+// result. A chain of tail calls between mutually recursive functions runs
+// in constant stack.
+func (g *Z80Generator) generateTailCall(inst ir.Instruction, targetFunc *ir.Function) {
+	g.emit("    ; Tail call to %s (args: %d)", targetFunc.Name, len(inst.Args))
+	if len(inst.Args) > 0 {
+		g.prepareCallArguments(inst.Args, targetFunc)
+	}
+	if targetFunc.UsesTrueSMC {
+		g.patchTrueSMCArguments(inst, targetFunc)
+	}
+	g.generateFrameTeardown()
+	g.emit("    JP %s", g.callTarget(targetFunc.Name))
+	g.usedFunctions[targetFunc.Name] = true
 }
 
 // emitAsmBlock processes and emits inline assembly code
@@ -4001,6 +4682,24 @@ func (g *Z80Generator) loadToB(reg ir.Register) {
 	g.emit("    LD B, A")
 }
 
+// byteCompareJump matches the compare chains of case: a byte compared for
+// equality with a constant loaded just before, and a jump on the result.
+// It returns the constant and the jump, which CP n / JP Z replace.
+func (g *Z80Generator) byteCompareJump(inst ir.Instruction) (int64, ir.Instruction, bool) {
+	instructions := g.currentFunction.Instructions
+	idx := g.currentInstructionIndex
+	if inst.Op != ir.OpEq || inst.Type == nil || inst.Type.Size() != 1 ||
+		idx == 0 || idx+1 >= len(instructions) {
+		return 0, ir.Instruction{}, false
+	}
+	load, next := instructions[idx-1], instructions[idx+1]
+	if (load.Op != ir.OpLoadImm && load.Op != ir.OpLoadConst) || load.Dest != inst.Src2 ||
+		load.Imm < 0 || load.Imm > 255 || next.Op != ir.OpJumpIf || next.Src1 != inst.Dest {
+		return 0, ir.Instruction{}, false
+	}
+	return load.Imm, next, true
+}
+
 // canOptimizeToDJNZ checks if we can optimize DEC + JUMP_IF_NOT_ZERO to DJNZ
 func (g *Z80Generator) canOptimizeToDJNZ(decInst ir.Instruction) bool {
 	// Check if this is the start of a DJNZ pattern
@@ -4037,8 +4736,8 @@ func (g *Z80Generator) generateDJNZ(decInst ir.Instruction) error {
 // shouldUseStackLocals determines if a function should use stack-based locals
 func (g *Z80Generator) shouldUseStackLocals(fn *ir.Function) bool {
 	// Use stack locals for:
-	// 1. Recursive functions (required)
-	if g.isRecursive(fn) {
+	// 1. Recursive functions (required), directly or through others
+	if fn.IsRecursive || g.isRecursive(fn) {
 		return true
 	}
 	
@@ -4102,7 +4801,7 @@ func (g *Z80Generator) needsPrintHelpers() bool {
 		for _, inst := range fn.Instructions {
 			switch inst.Op {
 			case ir.OpPrintU8, ir.OpPrintU16, ir.OpPrintI8, ir.OpPrintI16,
-			     ir.OpPrintBool, ir.OpPrintString:
+			     ir.OpPrintFixed, ir.OpPrintBool, ir.OpPrintString:
 				// These operations use helper functions
 				return true
 			case ir.OpCall:
@@ -4117,6 +4816,29 @@ func (g *Z80Generator) needsPrintHelpers() bool {
 	return false
 }
 
+// emitHelperCharOut prints the character in A from a print helper on the
+// target platform. On CP/M the BDOS call is wrapped to keep BC, DE and HL,
+// which the ROM calls of the other platforms preserve.
+func (g *Z80Generator) emitHelperCharOut(comment string) {
+	switch g.targetPlatform {
+	case "cpm":
+		g.emit("    PUSH BC")
+		g.emit("    PUSH DE")
+		g.emit("    PUSH HL")
+		g.emit("    LD E, A")
+		g.emit("    LD C, 2            ; BDOS function 2: %s", comment)
+		g.emit("    CALL 5")
+		g.emit("    POP HL")
+		g.emit("    POP DE")
+		g.emit("    POP BC")
+	case "msx":
+		g.emit("    CALL $00A2         ; MSX BIOS CHPUT: %s", comment)
+	case "cpc", "amstrad":
+		g.emit("    CALL $BB5A         ; CPC TXT OUTPUT: %s", comment)
+	default: // "zxspectrum" and others
+		g.emit("    RST 16             ; %s", comment)
+	}
+}
 // generatePrintHelpers generates runtime helper functions for print operations
 func (g *Z80Generator) generatePrintHelpers() {
 	g.emit("\n; Runtime print helper functions")
@@ -4224,37 +4946,32 @@ func (g *Z80Generator) generatePrintHelpers() {
 	if g.usedFunctions["print_u16_decimal"] || g.usedFunctions["print_u8_decimal"] || g.usedFunctions["print_i8_decimal"] || g.usedFunctions["print_i16_decimal"] {
 	g.emit("print_u16_decimal:")
 	g.emit("    LD BC, -10000")
-	g.emit("    LD DE, -1000")
 	g.emit("    CALL print_digit")
 	g.emit("    LD BC, -1000")
-	g.emit("    LD DE, -100")
 	g.emit("    CALL print_digit")
 	g.emit("    LD BC, -100")
-	g.emit("    LD DE, -10")
 	g.emit("    CALL print_digit")
 	g.emit("    LD BC, -10")
-	g.emit("    LD DE, -1")
 	g.emit("    CALL print_digit")
 	g.emit("    LD A, L")
 	g.emit("    ADD A, '0'         ; Convert to ASCII")
-	g.emit("    RST 16             ; Print last digit")
+	g.emitHelperCharOut("print last digit")
 	g.emit("    RET")
 	g.emit("")
 	
-	// Helper function for printing digits
-	if g.usedFunctions["print_u16_decimal"] || g.usedFunctions["print_digit"] {
+	// Helper function for printing digits, which print_u16_decimal calls
+	// whichever of the print routines pulled it in
 	g.emit("print_digit:")
 	g.emit("    LD A, '0'-1")
 	g.emit("print_digit_loop:")
 	g.emit("    INC A")
 	g.emit("    ADD HL, BC         ; Subtract power of 10")
 	g.emit("    JR C, print_digit_loop")
-	g.emit("    ADD HL, DE         ; Add back one power of 10")
-	g.emit("    RST 16             ; Print digit")
+	g.emit("    SBC HL, BC         ; Add back one power of 10 (carry is clear)")
+	g.emitHelperCharOut("print digit")
 	g.emit("    RET")
 	g.emit("")
 	}
-	}
 	
 	// Print signed integers (same as unsigned for now)
 	if g.usedFunctions["print_i8_decimal"] {
@@ -4263,7 +4980,7 @@ func (g *Z80Generator) generatePrintHelpers() {
 	g.emit("    JR Z, print_u8_decimal")
 	g.emit("    PUSH AF")
 	g.emit("    LD A, '-'          ; Print minus sign")
-	g.emit("    RST 16")
+	g.emitHelperCharOut("print minus sign")
 	g.emit("    POP AF")
 	g.emit("    NEG                ; Make positive")
 	g.emit("    JR print_u8_decimal")
@@ -4276,7 +4993,7 @@ func (g *Z80Generator) generatePrintHelpers() {
 	g.emit("    JR Z, print_u16_decimal")
 	g.emit("    PUSH HL")
 	g.emit("    LD A, '-'          ; Print minus sign")
-	g.emit("    RST 16")
+	g.emitHelperCharOut("print minus sign")
 	g.emit("    POP HL")
 	g.emit("    LD A, H            ; Negate HL")
 	g.emit("    CPL")
@@ -4504,7 +5221,9 @@ func (g *Z80Generator) generateStdlibRoutines() {
 		}
 		
 		// Set pixel
-		if g.usedFunctions["zx_set_pixel"] {
+		if g.usedFunctions["zx_set_pixel"] && g.z80n {
+		g.generateZ80NSetPixel()
+		} else if g.usedFunctions["zx_set_pixel"] {
 		g.emit("zx_set_pixel:")
 		g.emit("    ; TODO: Implement pixel setting")
 		g.emit("    ; For now, just return")
@@ -0,0 +1,331 @@
+package codegen
+
+import "github.com/minz/minzc/pkg/ir"
+
+// Multiplication and division that the constant and Z80N cases leave go
+// through a small runtime library, emitted once per module with the
+// routines it uses. Unsigned 8-bit operations are inlined instead when
+// optimizing for speed (see SetInlineArithmetic): a shift-and-add or
+// shift-and-subtract loop of eight steps, fast and still short.
+
+// arithmeticRoutines lists each runtime routine with the ones it calls
+var arithmeticRoutines = map[string][]string{
+	"mul16":  nil,
+	"div16":  nil,
+	"mod16":  {"div16"},
+	"sdiv16": {"div16"},
+	"smod16": {"sdiv16", "div16"},
+	"smul8":  {"mul16"},
+	"sdiv8":  {"sdiv16", "div16"},
+}
+
+// useArithmeticRoutine marks a runtime routine and its dependencies used
+func (g *Z80Generator) useArithmeticRoutine(name string) {
+	g.usedFunctions[name] = true
+	for _, dep := range arithmeticRoutines[name] {
+		g.usedFunctions[dep] = true
+	}
+}
+
+// SetInlineArithmetic inlines unsigned 8-bit multiplication, division and
+// modulo instead of calling the runtime routines
+func (g *Z80Generator) SetInlineArithmetic(enabled bool) {
+	g.inlineArith = enabled
+}
+
+// generateMul multiplies Src1 by Src2, either inline (u8) or with mul16
+// and smul8. The product is left in HL.
+func (g *Z80Generator) generateMul(inst ir.Instruction, is16bit bool) {
+	switch {
+	case is16bit:
+		// The low 16 bits are the same for signed operands
+		g.useArithmeticRoutine("mul16")
+		g.loadToDEAndHL(inst.Src2, inst.Src1)
+		g.emit("    CALL mul16         ; HL = HL * DE")
+	case isSignedType(inst.Type):
+		g.useArithmeticRoutine("smul8")
+		g.loadToA(inst.Src2)
+		g.emit("    LD E, A")
+		g.loadToA(inst.Src1)
+		g.emit("    CALL smul8         ; HL = A * E (signed)")
+	case g.inlineArith:
+		g.emit("    ; 8-bit multiplication (shift and add)")
+		g.loadToA(inst.Src1)
+		g.emit("    LD E, A")
+		g.emit("    LD D, 0       ; DE = multiplicand")
+		g.loadToA(inst.Src2)
+		g.emit("    LD HL, 0      ; HL = result")
+		g.emit("    LD B, 8")
+		loop := g.getFunctionLabel("mul_loop")
+		skip := g.getFunctionLabel("mul_skip")
+		g.labelCounter++
+		g.emit("%s:", loop)
+		g.emit("    ADD HL, HL")
+		g.emit("    RLA           ; Next multiplier bit, highest first")
+		g.emit("    JR NC, %s", skip)
+		g.emit("    ADD HL, DE")
+		g.emit("%s:", skip)
+		g.emit("    DJNZ %s", loop)
+	default:
+		g.useArithmeticRoutine("mul16")
+		g.loadToA(inst.Src2)
+		g.emit("    LD E, A")
+		g.emit("    LD D, 0")
+		g.loadToA(inst.Src1)
+		g.emit("    LD L, A")
+		g.emit("    LD H, 0")
+		g.emit("    CALL mul16         ; HL = HL * DE")
+	}
+	g.storeFromHL(inst.Dest)
+}
+
+// generateDivMod divides Src1 by Src2 for OpDiv and OpMod. Division by
+// zero gives 0 for both.
+func (g *Z80Generator) generateDivMod(inst ir.Instruction) {
+	mod := inst.Op == ir.OpMod
+	signed := isSignedType(inst.Type)
+	is16bit := inst.Type != nil && inst.Type.Size() == 2
+
+	switch {
+	case is16bit && signed:
+		g.loadToDEAndHL(inst.Src2, inst.Src1)
+		if mod {
+			g.useArithmeticRoutine("smod16")
+			g.emit("    CALL smod16        ; HL = HL mod DE (signed)")
+		} else {
+			g.useArithmeticRoutine("sdiv16")
+			g.emit("    CALL sdiv16        ; HL = HL / DE (signed)")
+		}
+	case is16bit:
+		g.loadToDEAndHL(inst.Src2, inst.Src1)
+		if mod {
+			g.useArithmeticRoutine("mod16")
+			g.emit("    CALL mod16         ; HL = HL mod DE")
+		} else {
+			g.useArithmeticRoutine("div16")
+			g.emit("    CALL div16         ; HL = HL / DE")
+		}
+	case signed:
+		g.useArithmeticRoutine("sdiv8")
+		g.loadToA(inst.Src2)
+		g.emit("    LD E, A")
+		g.loadToA(inst.Src1)
+		g.emit("    CALL sdiv8         ; L = A / E, E = A mod E (signed)")
+		if mod {
+			g.emit("    EX DE, HL")
+		}
+	case g.inlineArith:
+		g.generateInlineDivMod8(inst, mod)
+		return
+	default:
+		g.loadToA(inst.Src2)
+		g.emit("    LD E, A")
+		g.emit("    LD D, 0")
+		g.loadToA(inst.Src1)
+		g.emit("    LD L, A")
+		g.emit("    LD H, 0")
+		if mod {
+			g.useArithmeticRoutine("mod16")
+			g.emit("    CALL mod16         ; HL = HL mod DE")
+		} else {
+			g.useArithmeticRoutine("div16")
+			g.emit("    CALL div16         ; HL = HL / DE")
+		}
+	}
+	g.storeFromHL(inst.Dest)
+}
+
+// generateInlineDivMod8 divides two unsigned bytes inline, one quotient
+// bit per step
+func (g *Z80Generator) generateInlineDivMod8(inst ir.Instruction, mod bool) {
+	g.emit("    ; 8-bit division (shift and subtract)")
+	g.loadToA(inst.Src2)
+	g.emit("    LD E, A       ; E = divisor")
+	g.loadToA(inst.Src1)
+	g.emit("    LD D, A       ; D = dividend, then quotient")
+	loop := g.getFunctionLabel("div_loop")
+	over := g.getFunctionLabel("div_over")
+	skip := g.getFunctionLabel("div_skip")
+	zero := g.getFunctionLabel("div_by_zero")
+	done := g.getFunctionLabel("div_done")
+	g.labelCounter++
+	g.emit("    LD A, E")
+	g.emit("    OR A")
+	g.emit("    JR Z, %s", zero)
+	g.emit("    XOR A         ; A = remainder")
+	g.emit("    LD B, 8")
+	g.emit("%s:", loop)
+	g.emit("    SLA D")
+	g.emit("    RLA           ; Next dividend bit into the remainder")
+	g.emit("    JR C, %s", over)
+	g.emit("    CP E")
+	g.emit("    JR C, %s", skip)
+	g.emit("%s:", over)
+	g.emit("    SUB E")
+	g.emit("    INC D         ; Quotient bit")
+	g.emit("%s:", skip)
+	g.emit("    DJNZ %s", loop)
+	if !mod {
+		g.emit("    LD A, D")
+	}
+	g.emit("    JR %s", done)
+	g.emit("%s:", zero)
+	g.emit("    XOR A         ; Division by zero gives 0")
+	g.emit("%s:", done)
+	g.emit("    LD L, A")
+	g.emit("    LD H, 0")
+	g.storeFromHL(inst.Dest)
+}
+
+// negateHL and negateDE are the instructions that negate a register pair
+var (
+	negateHL = []string{"XOR A", "SUB L", "LD L, A", "SBC A, A", "SUB H", "LD H, A"}
+	negateDE = []string{"XOR A", "SUB E", "LD E, A", "SBC A, A", "SUB D", "LD D, A"}
+)
+
+// generateArithmeticRoutines emits the multiplication and division
+// runtime routines in use
+func (g *Z80Generator) generateArithmeticRoutines() {
+	emitAll := func(lines []string) {
+		for _, line := range lines {
+			g.emit("    %s", line)
+		}
+	}
+
+	if g.usedFunctions["mul16"] {
+		g.emit("; Multiply: HL = HL * DE (low 16 bits, signed or unsigned)")
+		g.emit("mul16:")
+		g.emit("    LD B, H")
+		g.emit("    LD C, L")
+		g.emit("    LD HL, 0")
+		g.emit("    LD A, 16")
+		g.emit("mul16_loop:")
+		g.emit("    ADD HL, HL")
+		g.emit("    RL E")
+		g.emit("    RL D               ; Next multiplier bit, highest first")
+		g.emit("    JR NC, mul16_skip")
+		g.emit("    ADD HL, BC")
+		g.emit("mul16_skip:")
+		g.emit("    DEC A")
+		g.emit("    JR NZ, mul16_loop")
+		g.emit("    RET")
+		g.emit("")
+	}
+
+	if g.usedFunctions["smul8"] {
+		g.emit("; Signed multiply: HL = A * E")
+		g.emit("smul8:")
+		g.emit("    LD L, A")
+		g.emit("    RLA")
+		g.emit("    SBC A, A")
+		g.emit("    LD H, A            ; HL = A, sign-extended")
+		g.emit("    LD A, E")
+		g.emit("    RLA")
+		g.emit("    SBC A, A")
+		g.emit("    LD D, A            ; DE = E, sign-extended")
+		g.emit("    JP mul16")
+		g.emit("")
+	}
+
+	if g.usedFunctions["div16"] {
+		g.emit("; Unsigned divide: HL = HL / DE, DE = HL mod DE (0 and 0 for DE = 0)")
+		g.emit("div16:")
+		g.emit("    LD A, D")
+		g.emit("    OR E")
+		g.emit("    JR Z, div16_zero")
+		g.emit("    LD A, H")
+		g.emit("    LD C, L            ; AC = dividend, then quotient")
+		g.emit("    LD HL, 0           ; HL = remainder")
+		g.emit("    LD B, 16")
+		g.emit("div16_loop:")
+		g.emit("    SLA C")
+		g.emit("    RLA")
+		g.emit("    ADC HL, HL         ; Next dividend bit into the remainder")
+		g.emit("    JR C, div16_over   ; 17 bits: more than the divisor")
+		g.emit("    SBC HL, DE")
+		g.emit("    JR NC, div16_bit")
+		g.emit("    ADD HL, DE         ; Too small: restore")
+		g.emit("    DJNZ div16_loop")
+		g.emit("    JR div16_done")
+		g.emit("div16_over:")
+		g.emit("    OR A")
+		g.emit("    SBC HL, DE")
+		g.emit("div16_bit:")
+		g.emit("    INC C              ; Quotient bit")
+		g.emit("    DJNZ div16_loop")
+		g.emit("div16_done:")
+		g.emit("    EX DE, HL")
+		g.emit("    LD H, A")
+		g.emit("    LD L, C")
+		g.emit("    RET")
+		g.emit("div16_zero:")
+		g.emit("    LD H, D")
+		g.emit("    LD L, E")
+		g.emit("    RET")
+		g.emit("")
+	}
+
+	if g.usedFunctions["mod16"] {
+		g.emit("; Unsigned modulo: HL = HL mod DE")
+		g.emit("mod16:")
+		g.emit("    CALL div16")
+		g.emit("    EX DE, HL")
+		g.emit("    RET")
+		g.emit("")
+	}
+
+	if g.usedFunctions["sdiv16"] {
+		g.emit("; Signed divide: HL = HL / DE rounded toward zero, DE = HL mod DE")
+		g.emit("; with the sign of the dividend")
+		g.emit("sdiv16:")
+		g.emit("    LD A, H")
+		g.emit("    XOR D")
+		g.emit("    PUSH AF            ; Bit 7: sign of the quotient")
+		g.emit("    LD A, H")
+		g.emit("    PUSH AF            ; Bit 7: sign of the remainder")
+		g.emit("    BIT 7, H")
+		g.emit("    JR Z, sdiv16_hl")
+		emitAll(negateHL)
+		g.emit("sdiv16_hl:")
+		g.emit("    BIT 7, D")
+		g.emit("    JR Z, sdiv16_de")
+		emitAll(negateDE)
+		g.emit("sdiv16_de:")
+		g.emit("    CALL div16")
+		g.emit("    POP AF")
+		g.emit("    RLA")
+		g.emit("    JR NC, sdiv16_rem")
+		emitAll(negateDE)
+		g.emit("sdiv16_rem:")
+		g.emit("    POP AF")
+		g.emit("    RLA")
+		g.emit("    RET NC")
+		emitAll(negateHL)
+		g.emit("    RET")
+		g.emit("")
+	}
+
+	if g.usedFunctions["smod16"] {
+		g.emit("; Signed modulo: HL = HL mod DE, with the sign of the dividend")
+		g.emit("smod16:")
+		g.emit("    CALL sdiv16")
+		g.emit("    EX DE, HL")
+		g.emit("    RET")
+		g.emit("")
+	}
+
+	if g.usedFunctions["sdiv8"] {
+		g.emit("; Signed divide: L = A / E, E = A mod E")
+		g.emit("sdiv8:")
+		g.emit("    LD L, A")
+		g.emit("    RLA")
+		g.emit("    SBC A, A")
+		g.emit("    LD H, A")
+		g.emit("    LD A, E")
+		g.emit("    RLA")
+		g.emit("    SBC A, A")
+		g.emit("    LD D, A")
+		g.emit("    JP sdiv16")
+		g.emit("")
+	}
+}
@@ -0,0 +1,255 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// Inline assembly with operands names the registers it uses:
+//
+//	asm("LD {0}, {1}" : "A"(x) : "r"(y) : "HL")
+//
+// Inputs are loaded into their registers before the code, through A or HL,
+// and outputs stored from theirs after it. An operand constrained to "r"
+// gets a free register of its size, one no other operand or clobber uses.
+// The register allocator keeps the values live across the asm out of
+// everything it touches, so they survive it without being reloaded.
+
+// asmRegisters are the registers a constraint or clobber can name
+var asmRegisters = map[string]PhysicalReg{
+	"A": RegA, "B": RegB, "C": RegC, "D": RegD, "E": RegE, "H": RegH, "L": RegL,
+	"BC": RegBC, "DE": RegDE, "HL": RegHL, "IX": RegIX, "IY": RegIY,
+}
+
+// asmFreeRegisters are the registers "r" picks from, in order
+var asmFreeRegisters = map[bool][]PhysicalReg{
+	false: {RegB, RegC, RegD, RegE, RegA, RegH, RegL},
+	true:  {RegDE, RegBC, RegHL},
+}
+
+// asmNoEffect are clobbers that do not concern the register allocator
+var asmNoEffect = map[string]bool{"F": true, "CC": true, "MEMORY": true}
+
+// regParts returns the 8-bit registers a physical register is made of
+func regParts(reg PhysicalReg) []PhysicalReg {
+	switch reg {
+	case RegBC:
+		return []PhysicalReg{RegB, RegC}
+	case RegDE:
+		return []PhysicalReg{RegD, RegE}
+	case RegHL:
+		return []PhysicalReg{RegH, RegL}
+	}
+	return []PhysicalReg{reg}
+}
+
+// regsOverlap reports whether two physical registers share a part
+func regsOverlap(a, b PhysicalReg) bool {
+	for _, pa := range regParts(a) {
+		for _, pb := range regParts(b) {
+			if pa == pb {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isWide reports whether an asm operand is a 16-bit value
+func isWide(op ir.AsmOperand) bool {
+	return op.Type != nil && op.Type.Size() > 1
+}
+
+// isWideReg reports whether a physical register holds 16 bits
+func isWideReg(reg PhysicalReg) bool {
+	return reg >= RegBC && reg <= RegIY
+}
+
+// bindAsmOperands returns the register of each operand of an inline asm
+func bindAsmOperands(inst *ir.Instruction) ([]PhysicalReg, error) {
+	clobbers, err := asmClobbers(inst)
+	if err != nil {
+		return nil, err
+	}
+	regs := make([]PhysicalReg, len(inst.AsmOperands))
+
+	// Named registers first; inputs may share one with an output
+	for n, op := range inst.AsmOperands {
+		name := strings.ToUpper(op.Constraint)
+		if name == "R" || name == "" {
+			continue
+		}
+		reg, ok := asmRegisters[name]
+		if !ok {
+			return nil, fmt.Errorf("inline assembly operand {%d}: unknown register constraint %q", n, op.Constraint)
+		}
+		if reg == RegIX || reg == RegIY {
+			// IX is the frame of stack locals, IY the system variables
+			return nil, fmt.Errorf("inline assembly operand {%d}: %s cannot carry an operand", n, name)
+		}
+		if isWide(op) != isWideReg(reg) {
+			return nil, fmt.Errorf("inline assembly operand {%d}: %s value in register %s", n, op.Type, name)
+		}
+		for m := 0; m < n; m++ {
+			if regs[m] != RegNone && inst.AsmOperands[m].Output == op.Output && regsOverlap(regs[m], reg) {
+				return nil, fmt.Errorf("inline assembly operands {%d} and {%d} both use %s", m, n, name)
+			}
+		}
+		for _, clobber := range clobbers {
+			if op.Output && regsOverlap(clobber, reg) {
+				return nil, fmt.Errorf("inline assembly operand {%d}: output register %s is also clobbered", n, name)
+			}
+		}
+		regs[n] = reg
+	}
+
+	// Then a register nothing else uses for each "r"
+	for n, op := range inst.AsmOperands {
+		if regs[n] != RegNone {
+			continue
+		}
+		for _, candidate := range asmFreeRegisters[isWide(op)] {
+			taken := false
+			for _, used := range append(append([]PhysicalReg(nil), regs...), clobbers...) {
+				taken = taken || used != RegNone && regsOverlap(used, candidate)
+			}
+			if !taken {
+				regs[n] = candidate
+				break
+			}
+		}
+		if regs[n] == RegNone {
+			return nil, fmt.Errorf("inline assembly operand {%d}: no free register", n)
+		}
+	}
+	return regs, nil
+}
+
+// asmClobbers returns the registers an inline asm declares it changes
+func asmClobbers(inst *ir.Instruction) ([]PhysicalReg, error) {
+	var regs []PhysicalReg
+	for _, clobber := range inst.AsmClobbers {
+		name := strings.ToUpper(clobber)
+		if name == "AF" {
+			name = "A"
+		}
+		if asmNoEffect[name] {
+			continue
+		}
+		reg, ok := asmRegisters[name]
+		if !ok {
+			return nil, fmt.Errorf("inline assembly: unknown clobbered register %q", clobber)
+		}
+		regs = append(regs, reg)
+	}
+	return regs, nil
+}
+
+// asmUsedRegisters returns every register an inline asm with operands may
+// change: its clobbers and operands, and A or HL when an operand passes
+// through them
+func asmUsedRegisters(inst *ir.Instruction) []PhysicalReg {
+	regs, err := bindAsmOperands(inst)
+	if err != nil {
+		return nil // Reported by code generation
+	}
+	clobbers, _ := asmClobbers(inst)
+	regs = append(regs, clobbers...)
+	for _, op := range inst.AsmOperands {
+		regs = append(regs, transitReg(isWide(op)))
+	}
+	return regs
+}
+
+// hasAsmOperands reports whether an OpAsm declares operands or clobbers
+func hasAsmOperands(inst *ir.Instruction) bool {
+	return len(inst.AsmOperands) > 0 || len(inst.AsmClobbers) > 0
+}
+
+// generateAsmWithOperands loads the inputs of an inline asm, emits its
+// code with {N} replaced by the operand registers, and stores the outputs
+func (g *Z80Generator) generateAsmWithOperands(inst *ir.Instruction) error {
+	regs, err := bindAsmOperands(inst)
+	if err != nil {
+		return err
+	}
+
+	// 16-bit inputs go through HL and 8-bit ones through A, so load the
+	// 16-bit ones first and the operands of HL and A last
+	for _, wide := range []bool{true, false} {
+		transit := transitReg(wide)
+		for _, last := range []bool{false, true} {
+			for n, op := range inst.AsmOperands {
+				if !op.Output && isWide(op) == wide && (regs[n] == transit) == last {
+					g.loadAsmInput(op.Reg, regs[n])
+				}
+			}
+		}
+	}
+
+	code := inst.AsmCode
+	for n := range inst.AsmOperands {
+		code = strings.ReplaceAll(code, fmt.Sprintf("{%d}", n), g.physicalRegToAssembly(regs[n]))
+	}
+	g.emitAsmBlock(code)
+
+	// Likewise store 8-bit outputs first, and the outputs in A and HL
+	// before those that pass through them
+	for _, wide := range []bool{false, true} {
+		transit := transitReg(wide)
+		for _, first := range []bool{true, false} {
+			for n, op := range inst.AsmOperands {
+				if op.Output && isWide(op) == wide && (regs[n] == transit) == first {
+					g.storeAsmOutput(op.Reg, regs[n])
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// transitReg returns the register values of a size are moved through
+func transitReg(wide bool) PhysicalReg {
+	if wide {
+		return RegHL
+	}
+	return RegA
+}
+
+// loadAsmInput loads a virtual register into a physical one
+func (g *Z80Generator) loadAsmInput(reg ir.Register, phys PhysicalReg) {
+	name := g.physicalRegToAssembly(phys)
+	switch phys {
+	case RegHL:
+		g.loadToHL(reg)
+	case RegBC, RegDE:
+		g.loadToHL(reg)
+		g.emit("    LD %s, H", name[:1])
+		g.emit("    LD %s, L", name[1:])
+	case RegA:
+		g.loadToA(reg)
+	default:
+		g.loadToA(reg)
+		g.emit("    LD %s, A", name)
+	}
+}
+
+// storeAsmOutput stores a physical register to a virtual one
+func (g *Z80Generator) storeAsmOutput(reg ir.Register, phys PhysicalReg) {
+	name := g.physicalRegToAssembly(phys)
+	switch phys {
+	case RegHL:
+		g.storeFromHL(reg)
+	case RegBC, RegDE:
+		g.emit("    LD H, %s", name[:1])
+		g.emit("    LD L, %s", name[1:])
+		g.storeFromHL(reg)
+	case RegA:
+		g.storeFromA(reg)
+	default:
+		g.emit("    LD A, %s", name)
+		g.storeFromA(reg)
+	}
+}
@@ -2,6 +2,7 @@ package codegen
 
 import (
 	"bytes"
+	"io"
 	"github.com/minz/minzc/pkg/ir"
 	"github.com/minz/minzc/pkg/optimizer"
 )
@@ -9,6 +10,7 @@ import (
 // Z80Backend implements the Backend interface for Z80 code generation
 type Z80Backend struct {
 	options *BackendOptions
+	z80n    bool // Generate Z80N instructions (see ZNextBackend)
 }
 
 // NewZ80Backend creates a new Z80 backend
@@ -27,30 +29,7 @@ func (b *Z80Backend) Name() string {
 func (b *Z80Backend) Generate(module *ir.Module) (string, error) {
 	// Create a buffer to collect the generated code
 	var buf bytes.Buffer
-	
-	// Create the Z80 generator with the buffer
-	gen := NewZ80Generator(&buf)
-	
-	// Set target platform if specified
-	if b.options != nil && b.options.Target != "" {
-		gen.SetTargetPlatform(b.options.Target)
-	}
-	
-	// Configure based on options
-	if b.options != nil {
-		if b.options.EnableSMC {
-			// Enable SMC for all functions
-			for _, fn := range module.Functions {
-				fn.IsSMCEnabled = true
-			}
-		}
-		
-		// Set target address if specified
-		if b.options.TargetAddress != 0 {
-			// TODO: Add support for custom origin address in Z80Generator
-			// For now, we'll add it as a comment
-		}
-	}
+	gen := b.newGenerator(&buf, module)
 	
 	// Generate the code
 	if err := gen.Generate(module); err != nil {
@@ -61,7 +40,7 @@ func (b *Z80Backend) Generate(module *ir.Module) (string, error) {
 	assembly := buf.String()
 	
 	// Apply assembly-level peephole optimization if optimization is enabled
-	if b.options != nil && b.options.OptimizationLevel > 0 {
+	if b.peephole() {
 		peephole := optimizer.NewAssemblyPeepholePass()
 		optimized := peephole.OptimizeAssembly(assembly)
 		// Add a comment to show optimization ran
@@ -72,7 +51,67 @@ func (b *Z80Backend) Generate(module *ir.Module) (string, error) {
 		}
 	}
 	
-	return assembly, nil
+	return relaxBranches(assembly), nil
+}
+
+// GenerateSplit generates Z80 assembly with one file per function
+func (b *Z80Backend) GenerateSplit(module *ir.Module) (*SplitOutput, error) {
+	gen := b.newGenerator(nil, module)
+	out, err := gen.GenerateSplit(module)
+	if err != nil {
+		return nil, err
+	}
+	
+	if b.peephole() {
+		peephole := optimizer.NewAssemblyPeepholePass()
+		for i := range out.Functions {
+			out.Functions[i].Content = peephole.OptimizeAssembly(out.Functions[i].Content)
+		}
+	}
+	
+	return out, nil
+}
+
+// peephole reports whether the assembly peephole pass runs
+func (b *Z80Backend) peephole() bool {
+	return b.options != nil && b.options.OptimizationLevel > 0 && !b.options.DisablePeephole
+}
+
+// newGenerator creates a Z80 generator configured from the backend options
+func (b *Z80Backend) newGenerator(w io.Writer, module *ir.Module) *Z80Generator {
+	gen := NewZ80Generator(w)
+	gen.SetZ80N(b.z80n)
+	
+	// Set target platform if specified
+	if b.options != nil && b.options.Target != "" {
+		gen.SetTargetPlatform(b.options.Target)
+	}
+	
+	// Configure based on options
+	if b.options != nil {
+		if b.options.EnableSMC {
+			// Enable SMC for all functions but those in ROM
+			for _, fn := range module.Functions {
+				fn.IsSMCEnabled = !fn.InROM
+			}
+		}
+		
+		for section, origin := range b.options.SectionOrigins {
+			gen.SetSectionOrigin(section, origin)
+		}
+		
+		gen.SetSections(b.options.Sections)
+		gen.SetRelocatableCalls(b.options.RelocatableCalls)
+		gen.SetDeterministic(b.options.Deterministic)
+		gen.SetSourceLines(b.options.SourceLines)
+		gen.SetInlineArithmetic(b.options.OptimizationLevel >= 2 && !b.options.OptimizeSize)
+		
+		if b.options.TargetAddress != 0 {
+			gen.SetCodeOrigin(b.options.TargetAddress)
+		}
+	}
+	
+	return gen
 }
 
 // GetFileExtension returns the file extension for Z80 assembly
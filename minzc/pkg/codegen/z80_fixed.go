@@ -0,0 +1,159 @@
+package codegen
+
+import (
+	"fmt"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// isFixed16 reports whether t is a 16-bit fixed-point type (f8.8, f.16)
+func isFixed16(t ir.Type) bool {
+	return ir.FixedFracBits(t) > 0 && t.Size() == 2
+}
+
+// generateFixedMul multiplies two fixed-point values and rescales the
+// product: the full product is formed by fixmul16 and shifted right by the
+// fractional bits, truncating the fraction.
+func (g *Z80Generator) generateFixedMul(inst ir.Instruction) error {
+	g.usedFunctions["fixmul16"] = true
+
+	switch inst.Type.Size() {
+	case 1: // f.8
+		g.loadToA(inst.Src1)
+		g.emit("    LD C, A")
+		g.emit("    LD B, 0")
+		g.loadToA(inst.Src2)
+		g.emit("    LD E, A")
+		g.emit("    LD D, 0")
+		g.emit("    CALL fixmul16      ; DEHL = BC * DE")
+		g.emit("    LD A, H            ; Product >> 8")
+		g.storeFromA(inst.Dest)
+	case 2:
+		g.loadToDE(inst.Src2)
+		g.loadToHL(inst.Src1)
+		g.emit("    LD B, H")
+		g.emit("    LD C, L")
+		g.emit("    CALL fixmul16      ; DEHL = BC * DE")
+		if ir.FixedFracBits(inst.Type) == 8 {
+			g.emit("    LD L, H            ; Product >> 8")
+			g.emit("    LD H, E")
+		} else {
+			g.emit("    EX DE, HL          ; Product >> 16")
+		}
+		g.storeFromHL(inst.Dest)
+	default:
+		return fmt.Errorf("fixed-point multiply of %s is not supported", inst.Type)
+	}
+	return nil
+}
+
+// generatePrintFixed prints a fixed-point value as a decimal fraction.
+// f.16 is printed from its high fraction byte, i.e. to 1/256 precision.
+func (g *Z80Generator) generatePrintFixed(inst ir.Instruction) error {
+	switch t := inst.Type.(type) {
+	case *ir.BasicType:
+		switch t.Kind {
+		case ir.TypeF8_8:
+			g.loadToHL(inst.Src1)
+		case ir.TypeF_8:
+			g.loadToA(inst.Src1)
+			g.emit("    LD L, A            ; Fraction")
+			g.emit("    LD H, 0            ; No integer part")
+		case ir.TypeF_16:
+			g.loadToHL(inst.Src1)
+			g.emit("    LD L, H            ; High fraction byte")
+			g.emit("    LD H, 0            ; No integer part")
+		default:
+			return fmt.Errorf("printing %s is not supported", t)
+		}
+	default:
+		return fmt.Errorf("printing %s is not supported", inst.Type)
+	}
+	g.emit("    CALL print_fixed8")
+	g.usedFunctions["print_fixed8"] = true
+	return nil
+}
+
+// generateFixedPointRoutines emits the fixed-point runtime routines in use
+func (g *Z80Generator) generateFixedPointRoutines() {
+	if g.usedFunctions["fixmul16"] {
+		g.emit("; Unsigned 16x16 multiply: DEHL = BC * DE")
+		g.emit("fixmul16:")
+		g.emit("    LD HL, 0")
+		g.emit("    LD A, 16")
+		g.emit("fixmul16_loop:")
+		g.emit("    ADD HL, HL")
+		g.emit("    RL E")
+		g.emit("    RL D")
+		g.emit("    JR NC, fixmul16_skip")
+		g.emit("    ADD HL, BC")
+		g.emit("    JR NC, fixmul16_skip")
+		g.emit("    INC DE")
+		g.emit("fixmul16_skip:")
+		g.emit("    DEC A")
+		g.emit("    JR NZ, fixmul16_loop")
+		g.emit("    RET")
+		g.emit("")
+	}
+
+	if g.usedFunctions["print_fixed8"] {
+		g.emit("; Print fixed-point H.L (L in 1/256ths) with up to 3 fraction digits")
+		g.emit("print_fixed8:")
+		g.emit("    PUSH HL")
+		g.emit("    LD A, H")
+		g.emit("    CALL print_fixed_int")
+		g.emit("    LD A, '.'")
+		g.emit("    RST 16")
+		g.emit("    POP HL")
+		g.emit("    LD B, 3            ; At most 3 fraction digits")
+		g.emit("print_fixed8_frac:")
+		g.emit("    LD H, 0            ; HL = fraction * 10")
+		g.emit("    LD D, H")
+		g.emit("    LD E, L")
+		g.emit("    ADD HL, HL")
+		g.emit("    ADD HL, HL")
+		g.emit("    ADD HL, DE")
+		g.emit("    ADD HL, HL")
+		g.emit("    LD A, H            ; H = next digit, L = remaining fraction")
+		g.emit("    ADD A, '0'")
+		g.emit("    RST 16")
+		g.emit("    LD A, L")
+		g.emit("    OR A")
+		g.emit("    RET Z              ; Exact")
+		g.emit("    DJNZ print_fixed8_frac")
+		g.emit("    RET")
+		g.emit("")
+
+		g.emit("; Print A as decimal without leading zeros")
+		g.emit("print_fixed_int:")
+		g.emit("    LD C, 0            ; Non-zero once a digit is printed")
+		g.emit("    LD B, 100")
+		g.emit("    CALL print_fixed_int_digit")
+		g.emit("    LD B, 10")
+		g.emit("    CALL print_fixed_int_digit")
+		g.emit("    ADD A, '0'")
+		g.emit("    RST 16")
+		g.emit("    RET")
+		g.emit("print_fixed_int_digit:")
+		g.emit("    LD D, 0")
+		g.emit("print_fixed_int_loop:")
+		g.emit("    CP B")
+		g.emit("    JR C, print_fixed_int_out")
+		g.emit("    SUB B")
+		g.emit("    INC D")
+		g.emit("    JR print_fixed_int_loop")
+		g.emit("print_fixed_int_out:")
+		g.emit("    LD E, A            ; Remainder")
+		g.emit("    LD A, D")
+		g.emit("    OR C")
+		g.emit("    JR Z, print_fixed_int_skip ; Leading zero")
+		g.emit("    LD A, D")
+		g.emit("    ADD A, '0'")
+		g.emit("    RST 16")
+		g.emit("    INC C")
+		g.emit("print_fixed_int_skip:")
+		g.emit("    LD A, E")
+		g.emit("    RET")
+		g.emit("")
+	}
+}
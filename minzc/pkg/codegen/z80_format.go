@@ -0,0 +1,245 @@
+package codegen
+
+import (
+	"fmt"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// The format_* routines write text at the cursor in DE and return DE past
+// the last byte written. They mirror the print helpers but target memory
+// instead of the console. Numbers take their value in A (8-bit) or HL
+// (16-bit); strings take their address in HL.
+
+// formatRoutineDeps lists the routines each format routine calls
+var formatRoutineDeps = map[string][]string{
+	"format_u8_decimal":  {"format_u16_decimal"},
+	"format_i8_decimal":  {"format_u8_decimal"},
+	"format_i16_decimal": {"format_u16_decimal"},
+	"format_u16_decimal": {"format_div10"},
+	"format_hex_u16":     {"format_hex_u8"},
+}
+
+// useFormatRoutine marks a format routine and the routines it calls as used
+func (g *Z80Generator) useFormatRoutine(name string) {
+	if g.usedFunctions[name] {
+		return
+	}
+	g.usedFunctions[name] = true
+	for _, dep := range formatRoutineDeps[name] {
+		g.useFormatRoutine(dep)
+	}
+}
+
+// formatRoutine picks the routine writing a value of type t with verb
+func formatRoutine(verb rune, t ir.Type) (string, error) {
+	switch verb {
+	case 's':
+		if _, ok := t.(*ir.LStringType); ok {
+			return "format_lstring", nil
+		}
+		return "format_string", nil
+	case 'x':
+		if t.Size() == 1 {
+			return "format_hex_u8", nil
+		}
+		return "format_hex_u16", nil
+	case 'd':
+		if basic, ok := t.(*ir.BasicType); ok {
+			switch basic.Kind {
+			case ir.TypeU8:
+				return "format_u8_decimal", nil
+			case ir.TypeI8:
+				return "format_i8_decimal", nil
+			case ir.TypeU16:
+				return "format_u16_decimal", nil
+			case ir.TypeI16:
+				return "format_i16_decimal", nil
+			}
+		}
+	}
+	return "", fmt.Errorf("cannot format %s with %%%c", t, verb)
+}
+
+// generateFormat writes one piece of a format() call at the cursor in Src1
+// and leaves the advanced cursor in Dest
+func (g *Z80Generator) generateFormat(inst ir.Instruction) error {
+	verb := rune(inst.Imm)
+	if verb == 0 {
+		g.loadToHL(inst.Src1)
+		for _, ch := range []byte(inst.Symbol) {
+			g.emit("    LD (HL), %d", ch)
+			g.emit("    INC HL")
+		}
+		g.storeFromHL(inst.Dest)
+		return nil
+	}
+
+	if verb == 'c' {
+		g.loadToA(inst.Src2)
+		g.loadToDE(inst.Src1)
+		g.emit("    LD (DE), A")
+		g.emit("    INC DE")
+	} else {
+		routine, err := formatRoutine(verb, inst.Type)
+		if err != nil {
+			return err
+		}
+		if verb != 's' && inst.Type.Size() == 1 {
+			g.loadToA(inst.Src2)
+			g.loadToDE(inst.Src1)
+		} else {
+			g.loadToDEAndHL(inst.Src1, inst.Src2)
+		}
+		g.emit("    CALL %s", routine)
+		g.useFormatRoutine(routine)
+	}
+	g.emit("    EX DE, HL          ; Advanced cursor")
+	g.storeFromHL(inst.Dest)
+	return nil
+}
+
+// generateFormatRoutines emits the format routines in use
+func (g *Z80Generator) generateFormatRoutines() {
+	if g.usedFunctions["format_i8_decimal"] {
+		g.emit("; Write signed A as decimal at DE")
+		g.emit("format_i8_decimal:")
+		g.emit("    BIT 7, A")
+		g.emit("    JR Z, format_u8_decimal")
+		g.emit("    NEG")
+		g.emit("    LD L, A")
+		g.emit("    LD A, '-'")
+		g.emit("    LD (DE), A")
+		g.emit("    INC DE")
+		g.emit("    LD A, L")
+		g.emit("")
+	}
+
+	if g.usedFunctions["format_u8_decimal"] {
+		g.emit("; Write A as decimal at DE")
+		g.emit("format_u8_decimal:")
+		g.emit("    LD H, 0")
+		g.emit("    LD L, A")
+		g.emit("    JR format_u16_decimal")
+		g.emit("")
+	}
+
+	if g.usedFunctions["format_i16_decimal"] {
+		g.emit("; Write signed HL as decimal at DE")
+		g.emit("format_i16_decimal:")
+		g.emit("    BIT 7, H")
+		g.emit("    JR Z, format_u16_decimal")
+		g.emit("    LD A, '-'")
+		g.emit("    LD (DE), A")
+		g.emit("    INC DE")
+		g.emit("    XOR A              ; HL = -HL")
+		g.emit("    SUB L")
+		g.emit("    LD L, A")
+		g.emit("    SBC A, A")
+		g.emit("    SUB H")
+		g.emit("    LD H, A")
+		g.emit("")
+	}
+
+	if g.usedFunctions["format_u16_decimal"] {
+		g.emit("; Write HL as decimal at DE, without leading zeros")
+		g.emit("format_u16_decimal:")
+		g.emit("    LD B, 0            ; Digits on the stack")
+		g.emit("format_u16_digit:")
+		g.emit("    CALL format_div10")
+		g.emit("    ADD A, '0'")
+		g.emit("    PUSH AF")
+		g.emit("    INC B")
+		g.emit("    LD A, H")
+		g.emit("    OR L")
+		g.emit("    JR NZ, format_u16_digit")
+		g.emit("format_u16_write:")
+		g.emit("    POP AF             ; Most significant digit first")
+		g.emit("    LD (DE), A")
+		g.emit("    INC DE")
+		g.emit("    DJNZ format_u16_write")
+		g.emit("    RET")
+		g.emit("")
+	}
+
+	if g.usedFunctions["format_div10"] {
+		g.emit("; HL = HL / 10, A = remainder")
+		g.emit("format_div10:")
+		g.emit("    PUSH BC")
+		g.emit("    LD B, 16")
+		g.emit("    XOR A")
+		g.emit("format_div10_loop:")
+		g.emit("    ADD HL, HL")
+		g.emit("    RLA")
+		g.emit("    CP 10")
+		g.emit("    JR C, format_div10_next")
+		g.emit("    SUB 10")
+		g.emit("    INC L              ; Quotient bit")
+		g.emit("format_div10_next:")
+		g.emit("    DJNZ format_div10_loop")
+		g.emit("    POP BC")
+		g.emit("    RET")
+		g.emit("")
+	}
+
+	if g.usedFunctions["format_hex_u16"] {
+		g.emit("; Write HL as 4 hex digits at DE")
+		g.emit("format_hex_u16:")
+		g.emit("    LD A, H")
+		g.emit("    CALL format_hex_u8")
+		g.emit("    LD A, L")
+		g.emit("")
+	}
+
+	if g.usedFunctions["format_hex_u8"] {
+		g.emit("; Write A as 2 hex digits at DE")
+		g.emit("format_hex_u8:")
+		g.emit("    PUSH AF")
+		g.emit("    RRA")
+		g.emit("    RRA")
+		g.emit("    RRA")
+		g.emit("    RRA")
+		g.emit("    CALL format_hex_nibble")
+		g.emit("    POP AF")
+		g.emit("format_hex_nibble:")
+		g.emit("    AND $0F")
+		g.emit("    ADD A, '0'")
+		g.emit("    CP '9'+1")
+		g.emit("    JR C, format_hex_digit")
+		g.emit("    ADD A, 'A'-'9'-1")
+		g.emit("format_hex_digit:")
+		g.emit("    LD (DE), A")
+		g.emit("    INC DE")
+		g.emit("    RET")
+		g.emit("")
+	}
+
+	if g.usedFunctions["format_string"] {
+		g.emit("; Copy the length-prefixed string at HL to DE")
+		g.emit("format_string:")
+		g.emit("    LD C, (HL)")
+		g.emit("    LD B, 0")
+		g.emit("    INC HL")
+		g.emit("    LD A, C")
+		g.emit("    OR A")
+		g.emit("    RET Z")
+		g.emit("    LDIR")
+		g.emit("    RET")
+		g.emit("")
+	}
+
+	if g.usedFunctions["format_lstring"] {
+		g.emit("; Copy the u16 length-prefixed string at HL to DE")
+		g.emit("format_lstring:")
+		g.emit("    LD C, (HL)")
+		g.emit("    INC HL")
+		g.emit("    LD B, (HL)")
+		g.emit("    INC HL")
+		g.emit("    LD A, B")
+		g.emit("    OR C")
+		g.emit("    RET Z")
+		g.emit("    LDIR")
+		g.emit("    RET")
+		g.emit("")
+	}
+}
@@ -0,0 +1,101 @@
+package codegen
+
+import (
+	"strings"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// A function marked @interrupt(im2) is installed as the mode 2 interrupt
+// handler, which on the ZX Spectrum runs once per frame:
+//
+//	@interrupt(im2)
+//	fun on_frame() -> void { ticks = ticks + 1; }
+//
+// In mode 2 the CPU calls through the word at I * 256 + the byte on the
+// data bus. The Spectrum's bus floats, so that byte can be anything: the
+// vector table is 257 bytes on a page boundary, all holding the number of
+// the page after it, so every vector read gives the same address just past
+// the table. A JP to the handler sits there. The table follows the code,
+// aligned from the code origin (--org), and costs 257 bytes plus padding
+// up to the JP of as many bytes as the table's page number.
+//
+// main begins by pointing I at the table and switching to IM 2. The
+// handler can interrupt any code, so it saves both register sets and IX
+// and IY, and returns with EI; RETI.
+
+// IM2Table labels the IM2 vector table
+const IM2Table = "__im2_table"
+
+// IM2Entry labels the JP to the handler that every vector points at
+const IM2Entry = "__im2_entry"
+
+// isMainFunction reports whether a function is the program entry
+func isMainFunction(name string) bool {
+	return name == "main" || strings.HasSuffix(name, ".main")
+}
+
+// im2Handler returns the @interrupt(im2) handler, or nil
+func (g *Z80Generator) im2Handler() *ir.Function {
+	for _, fn := range g.module.Functions {
+		if fn.IsInterrupt && fn.InterruptMode == 2 {
+			return fn
+		}
+	}
+	return nil
+}
+
+// generateIM2Setup installs the IM2 handler at the start of main
+func (g *Z80Generator) generateIM2Setup(fn *ir.Function) {
+	if !isMainFunction(fn.Name) || g.im2Handler() == nil {
+		return
+	}
+	g.emit("    ; Install %s as the IM2 interrupt handler", g.im2Handler().Name)
+	g.emit("    DI")
+	g.emit("    LD A, %s / 256", IM2Table)
+	g.emit("    LD I, A")
+	g.emit("    IM 2")
+	g.emit("    EI")
+}
+
+// generateIM2Table emits the vector table and the JP every vector reaches
+func (g *Z80Generator) generateIM2Table() {
+	handler := g.im2Handler()
+	if handler == nil {
+		return
+	}
+	g.emit("\n; IM2 vector table: every vector is (page after the table) * $101")
+	g.emit("    ALIGN 256")
+	g.emit("%s:", IM2Table)
+	g.emit("    DS 257, %s / 256 + 1", IM2Table)
+	g.emit("    DS %s / 256        ; Up to the vector address", IM2Table)
+	g.emit("%s:", IM2Entry)
+	g.emit("    JP %s", g.sanitizeFunctionName(handler.Name))
+}
+
+// generateIM2Prologue saves every register for an IM2 handler
+func (g *Z80Generator) generateIM2Prologue() {
+	for _, reg := range []string{"AF", "BC", "DE", "HL"} {
+		g.emit("    PUSH %s", reg)
+	}
+	g.emit("    EX AF, AF'")
+	g.emit("    EXX")
+	for _, reg := range []string{"AF", "BC", "DE", "HL", "IX", "IY"} {
+		g.emit("    PUSH %s", reg)
+	}
+}
+
+// generateIM2Epilogue restores what generateIM2Prologue saved and returns
+// from the interrupt
+func (g *Z80Generator) generateIM2Epilogue() {
+	for _, reg := range []string{"IY", "IX", "HL", "DE", "BC", "AF"} {
+		g.emit("    POP %s", reg)
+	}
+	g.emit("    EXX")
+	g.emit("    EX AF, AF'")
+	for _, reg := range []string{"HL", "DE", "BC", "AF"} {
+		g.emit("    POP %s", reg)
+	}
+	g.emit("    EI")
+	g.emit("    RETI")
+}
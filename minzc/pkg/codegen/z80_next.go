@@ -0,0 +1,63 @@
+package codegen
+
+import "github.com/minz/minzc/pkg/ir"
+
+// Z80N (ZX Spectrum Next) code generation. The Next's CPU adds an 8x8
+// hardware multiply, 8-bit adds to the register pairs and screen address
+// helpers to the Z80, used here where they beat the plain Z80 sequences.
+
+// generateZ80NMul multiplies Src1 by Src2 with MUL D,E (DE = D * E, 8
+// T-states). A 16-bit product keeps its low 16 bits, which take three
+// partial products: lo*lo + ((hi*lo + lo*hi) << 8).
+func (g *Z80Generator) generateZ80NMul(inst ir.Instruction, is16bit bool) {
+	if !is16bit {
+		g.emit("    ; 8-bit multiplication (Z80N MUL)")
+		g.loadToA(inst.Src2)
+		g.emit("    LD E, A       ; E = multiplier")
+		g.loadToA(inst.Src1)
+		g.emit("    LD D, A       ; D = multiplicand")
+		g.emit("    MUL D, E      ; DE = D * E")
+		g.emit("    EX DE, HL")
+		g.storeFromHL(inst.Dest)
+		return
+	}
+
+	g.emit("    ; 16-bit multiplication (Z80N MUL)")
+	g.loadToDEAndHL(inst.Src2, inst.Src1)
+	g.emit("    LD B, H       ; BC = multiplicand")
+	g.emit("    LD C, L")
+	g.emit("    LD H, D       ; HL = multiplier")
+	g.emit("    LD L, E")
+	g.emit("    LD D, B")
+	g.emit("    MUL D, E      ; high * low")
+	g.emit("    LD A, E")
+	g.emit("    LD D, C")
+	g.emit("    LD E, H")
+	g.emit("    MUL D, E      ; low * high")
+	g.emit("    ADD A, E      ; A = high byte of the cross products")
+	g.emit("    LD D, C")
+	g.emit("    LD E, L")
+	g.emit("    MUL D, E      ; low * low")
+	g.emit("    ADD A, D")
+	g.emit("    LD H, A")
+	g.emit("    LD L, E")
+	g.storeFromHL(inst.Dest)
+}
+
+// generateZ80NSetPixel emits zx_set_pixel(x, y), which sets a pixel of the
+// Spectrum screen. PIXELAD computes its address from D = y and E = x, and
+// SETAE its bit mask from E.
+func (g *Z80Generator) generateZ80NSetPixel() {
+	g.emit("zx_set_pixel:")
+	g.emit("    POP HL             ; Return address")
+	g.emit("    POP DE             ; E = x")
+	g.emit("    POP BC             ; C = y")
+	g.emit("    PUSH HL            ; Restore return address")
+	g.emit("    LD D, C")
+	g.emit("    PIXELAD            ; HL = screen address of (E, D)")
+	g.emit("    SETAE              ; A = mask of pixel E")
+	g.emit("    OR (HL)")
+	g.emit("    LD (HL), A")
+	g.emit("    RET")
+	g.emit("")
+}
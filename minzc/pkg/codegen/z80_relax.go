@@ -0,0 +1,59 @@
+package codegen
+
+import (
+	"strings"
+
+	"github.com/minz/minzc/pkg/z80asm"
+)
+
+// relaxBranches rewrites the relative jumps whose targets are out of
+// range as absolute jumps: DJNZ becomes DEC B; JP NZ and JR becomes JP.
+// Code generation picks DJNZ and JR without knowing how far the target
+// is, so a long loop body, such as a fused iterator chain with several
+// lambdas, would not otherwise assemble. Rewriting one jump makes the code
+// longer and can push others out of range, so it repeats until the
+// assembler reports none.
+func relaxBranches(assembly string) string {
+	for pass := 0; pass < 8; pass++ {
+		result, err := z80asm.NewAssembler().AssembleString(assembly)
+		if err != nil {
+			return assembly
+		}
+		lines := strings.Split(assembly, "\n")
+		changed := false
+		for _, asmErr := range result.Errors {
+			if !strings.Contains(asmErr.Message, "relative jump out of range") || asmErr.Line < 1 || asmErr.Line > len(lines) {
+				continue
+			}
+			if relaxed, ok := relaxBranch(lines[asmErr.Line-1]); ok {
+				lines[asmErr.Line-1] = relaxed
+				changed = true
+			}
+		}
+		if !changed {
+			return assembly
+		}
+		assembly = strings.Join(lines, "\n")
+	}
+	return assembly
+}
+
+// relaxBranch returns the absolute form of a DJNZ or JR line
+func relaxBranch(line string) (string, bool) {
+	code := line
+	if comment := strings.Index(code, ";"); comment >= 0 {
+		code = code[:comment]
+	}
+	fields := strings.Fields(code)
+	if len(fields) < 2 {
+		return line, false
+	}
+	operands := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(code), fields[0]))
+	switch strings.ToUpper(fields[0]) {
+	case "DJNZ":
+		return "    DEC B\n    JP NZ, " + operands + " ; DJNZ out of range", true
+	case "JR":
+		return "    JP " + operands + " ; JR out of range", true
+	}
+	return line, false
+}
@@ -0,0 +1,120 @@
+package codegen
+
+import (
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// rotateSteps picks the cheapest way to rotate a value of width bits left by
+// n: whether to swap the bytes of a 16-bit value first (a rotate by 8), and
+// how many single-bit steps to take in which direction afterwards. No more
+// than width/2 steps (4 for 16 bits) are ever needed.
+func rotateSteps(n, width int) (swap bool, left bool, steps int) {
+	n = (n%width + width) % width
+	if width == 16 && n >= 8 {
+		swap, n = true, n-8
+	}
+	if n > 4 {
+		if width == 16 {
+			// Left by n is a byte swap and right by 8-n
+			return !swap, false, 8 - n
+		}
+		return false, false, width - n
+	}
+	return swap, true, n
+}
+
+// emitRotate16Step rotates HL by one bit: RLA/RRA moves the bit leaving one
+// end into carry and RL/RR bring it in at the other
+func (g *Z80Generator) emitRotate16Step(left bool) {
+	if left {
+		g.emit("    LD A, H")
+		g.emit("    RLA                ; Carry = bit 15")
+		g.emit("    RL L")
+		g.emit("    RL H")
+		return
+	}
+	g.emit("    LD A, L")
+	g.emit("    RRA                ; Carry = bit 0")
+	g.emit("    RR H")
+	g.emit("    RR L")
+}
+
+// generateRotate generates OpRotl and OpRotr. A constant count (Src2 of 0)
+// is unrolled; a count in a register is masked to the width and looped.
+func (g *Z80Generator) generateRotate(inst ir.Instruction) error {
+	left := inst.Op == ir.OpRotl
+	wide := inst.Type != nil && inst.Type.Size() == 2
+
+	if inst.Src2 == 0 {
+		width := 8
+		if wide {
+			width = 16
+		}
+		n := int(inst.Imm)
+		if !left {
+			n = -n
+		}
+		swap, stepLeft, steps := rotateSteps(n, width)
+
+		if !wide {
+			g.loadToA(inst.Src1)
+			for i := 0; i < steps; i++ {
+				if stepLeft {
+					g.emit("    RLCA")
+				} else {
+					g.emit("    RRCA")
+				}
+			}
+			g.emit("    LD L, A")
+			g.emit("    LD H, 0")
+			g.storeFromHL(inst.Dest)
+			return nil
+		}
+
+		g.loadToHL(inst.Src1)
+		if swap {
+			g.emit("    LD A, L            ; Rotate by 8: swap bytes")
+			g.emit("    LD L, H")
+			g.emit("    LD H, A")
+		}
+		for i := 0; i < steps; i++ {
+			g.emitRotate16Step(stepLeft)
+		}
+		g.storeFromHL(inst.Dest)
+		return nil
+	}
+
+	doneLabel := g.getFunctionLabel("rot_done")
+	loopLabel := g.getFunctionLabel("rot_loop")
+	g.labelCounter++
+	g.loadToDEAndHL(inst.Src2, inst.Src1)
+	g.emit("    LD A, E")
+	if wide {
+		g.emit("    AND 15             ; Count modulo 16")
+		g.emit("    JR Z, %s", doneLabel)
+		g.emit("    LD B, A")
+		g.emit("%s:", loopLabel)
+		g.emitRotate16Step(left)
+		g.emit("    DJNZ %s", loopLabel)
+		g.emit("%s:", doneLabel)
+		g.storeFromHL(inst.Dest)
+		return nil
+	}
+
+	g.emit("    AND 7              ; Count modulo 8")
+	g.emit("    LD B, A")
+	g.emit("    LD A, L")
+	g.emit("    JR Z, %s", doneLabel)
+	g.emit("%s:", loopLabel)
+	if left {
+		g.emit("    RLCA")
+	} else {
+		g.emit("    RRCA")
+	}
+	g.emit("    DJNZ %s", loopLabel)
+	g.emit("%s:", doneLabel)
+	g.emit("    LD L, A")
+	g.emit("    LD H, 0")
+	g.storeFromHL(inst.Dest)
+	return nil
+}
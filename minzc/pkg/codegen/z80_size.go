@@ -0,0 +1,67 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/minz/minzc/pkg/z80asm"
+)
+
+// FunctionSize is the assembled size of one generated function
+type FunctionSize struct {
+	Name  string // IR function name
+	Bytes int
+}
+
+// MeasureFunctions assembles Z80 output and returns the size in bytes of
+// each function, in output order. Functions are the lines between the
+// "; Function:" and "; End of function:" comments generateFunction emits,
+// so runtime routines and data are not counted against any function.
+func MeasureFunctions(asm string) ([]FunctionSize, error) {
+	result, err := z80asm.NewAssembler().AssembleString(asm)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Errors) > 0 {
+		return nil, fmt.Errorf("assembly failed: %v", result.Errors[0])
+	}
+
+	// Source line number -> index into sizes
+	owner := make(map[int]int)
+	var sizes []FunctionSize
+	current := -1
+	for i, line := range strings.Split(asm, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "; Function: "):
+			name := strings.TrimPrefix(line, "; Function: ")
+			if hint := strings.Index(name, " ("); hint >= 0 {
+				name = name[:hint]
+			}
+			sizes = append(sizes, FunctionSize{Name: name})
+			current = len(sizes) - 1
+		case strings.HasPrefix(line, "; End of function: "):
+			current = -1
+		case current >= 0:
+			owner[i+1] = current
+		}
+	}
+
+	for _, line := range result.Listing {
+		if fn, ok := owner[line.LineNumber]; ok {
+			sizes[fn].Bytes += len(line.Bytes)
+		}
+	}
+	return sizes, nil
+}
+
+// LargeFunctions returns the functions larger than limit bytes
+func LargeFunctions(sizes []FunctionSize, limit int) []FunctionSize {
+	var large []FunctionSize
+	for _, size := range sizes {
+		if size.Bytes > limit {
+			large = append(large, size)
+		}
+	}
+	return large
+}
@@ -0,0 +1,66 @@
+package codegen
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// With source lines enabled, the code of each source line starts with a
+// marker naming the first MIR instruction lowered from it:
+//
+//	; @line 4 main.minz:12
+//
+// so a listing can pair source, MIR and assembly after the peephole pass,
+// which keeps comments in place.
+
+// SourceLineMarker starts a source line marker
+const SourceLineMarker = "; @line "
+
+// SetSourceLines enables source line markers
+func (g *Z80Generator) SetSourceLines(enabled bool) {
+	g.sourceLines = enabled
+}
+
+// beginSourceRuns indexes the source runs of the function being generated
+func (g *Z80Generator) beginSourceRuns(fn *ir.Function) {
+	if !g.sourceLines {
+		return
+	}
+	g.sourceRunStarts = make(map[int]ir.SourceRun)
+	for _, run := range fn.SourceRuns() {
+		if run.Line > 0 {
+			g.sourceRunStarts[run.Start] = run
+		}
+	}
+}
+
+// markSourceLine emits a marker if instruction i starts a source line
+func (g *Z80Generator) markSourceLine(i int) {
+	if !g.sourceLines {
+		return
+	}
+	if run, ok := g.sourceRunStarts[i]; ok {
+		file := run.File
+		if file == "" {
+			file = "<input>"
+		}
+		g.emit("%s%d %s:%d", SourceLineMarker, i, filepath.Base(file), run.Line)
+	}
+}
+
+// ParseSourceLineMarker returns the MIR instruction index of a source line
+// marker
+func ParseSourceLineMarker(line string) (int, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(line), SourceLineMarker)
+	if !ok {
+		return 0, false
+	}
+	var index int
+	if _, err := fmt.Sscanf(rest, "%d", &index); err != nil {
+		return 0, false
+	}
+	return index, true
+}
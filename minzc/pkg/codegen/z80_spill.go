@@ -0,0 +1,86 @@
+package codegen
+
+import (
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// Virtual registers without a physical register live in memory, and every
+// result is stored there. With the function's liveness, results nothing
+// reads are not stored at all. Most others are read once, by the very next
+// instruction, which finds them still in HL or A: their store is held back
+// until code is emitted, and dropped if the reader takes the value from HL
+// or A and it is dead afterwards; anything else emitted first writes it
+// out as usual.
+
+// spillReaders are the instructions known to read each operand once,
+// through loadToA, loadToHL, loadToDE or loadToDEAndHL
+var spillReaders = map[ir.Opcode]bool{
+	ir.OpAdd: true, ir.OpSub: true, ir.OpAnd: true, ir.OpOr: true, ir.OpXor: true,
+	ir.OpEq: true, ir.OpNe: true, ir.OpLt: true, ir.OpGt: true, ir.OpLe: true, ir.OpGe: true,
+	ir.OpMove: true, ir.OpStoreVar: true, ir.OpReturn: true,
+	ir.OpJumpIf: true, ir.OpJumpIfNot: true,
+}
+
+// startInstruction records that instruction i of the current function is
+// being generated
+func (g *Z80Generator) startInstruction(i int) {
+	// The reader emitted no code, so it did not need the stored value
+	if g.spill != nil && i > g.spillReader {
+		g.spill = nil
+	}
+	g.instIndex = i
+}
+
+// holdSpill drops or holds back the store of reg if it may not be needed,
+// and reports whether it did
+func (g *Z80Generator) holdSpill(reg ir.Register, store ...string) bool {
+	if g.liveness == nil || reg <= 0 {
+		return false
+	}
+	insts := g.currentFunc.Instructions
+	if g.instIndex >= len(insts) {
+		return false
+	}
+
+	// Nothing reads the value at all
+	if insts[g.instIndex].Writes() == reg && !g.liveness.LiveAfter(g.instIndex, reg) {
+		return true
+	}
+
+	next := g.instIndex + 1
+	for next < len(insts) && insts[next].Op == ir.OpSourceMark {
+		next++
+	}
+	if next >= len(insts) || !spillReaders[insts[next].Op] || insts[g.instIndex].IsBranch() {
+		return false
+	}
+	reads := 0
+	for _, r := range insts[next].Reads() {
+		if r == reg {
+			reads++
+		}
+	}
+	if reads != 1 || g.liveness.LiveAfter(next, reg) {
+		return false
+	}
+	g.flushSpill()
+	g.spill, g.spillReg, g.spillReader = store, reg, next
+	return true
+}
+
+// useSpilled is called when reg is taken from HL or A; a held-back store
+// of it by its last reader is no longer needed
+func (g *Z80Generator) useSpilled(reg ir.Register) {
+	if g.spill != nil && g.spillReg == reg && g.instIndex == g.spillReader {
+		g.spill = nil
+	}
+}
+
+// flushSpill writes out a held-back store
+func (g *Z80Generator) flushSpill() {
+	store := g.spill
+	g.spill = nil
+	for _, line := range store {
+		g.emit("%s", line)
+	}
+}
@@ -0,0 +1,160 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// SplitSharedFile holds globals, strings and runtime support in split output
+const SplitSharedFile = "globals.a80"
+
+// SplitManifestFile lists split output files in assembly order
+const SplitManifestFile = "manifest.txt"
+
+// SplitFile is one assembly file of split output
+type SplitFile struct {
+	Name     string // File name, unique within the output
+	Function string // Source function; empty for the shared file and IM2 vectors
+	Content  string
+}
+
+// SplitOutput is assembly split into one file per function plus a shared file.
+// Files are assembled by concatenating them in manifest order: the shared file
+// places data at $F000 and opens the code section, so functions follow it.
+type SplitOutput struct {
+	Shared    SplitFile
+	Functions []SplitFile
+}
+
+// Files returns all files in assembly order
+func (s *SplitOutput) Files() []SplitFile {
+	return append([]SplitFile{s.Shared}, s.Functions...)
+}
+
+// Manifest returns the manifest listing every file in assembly order
+func (s *SplitOutput) Manifest() string {
+	var buf strings.Builder
+	buf.WriteString("; MinZ split output manifest\n")
+	buf.WriteString("; Assemble by concatenating files in this order\n")
+	fmt.Fprintf(&buf, "%-24s ; globals, strings and runtime\n", s.Shared.Name)
+	for _, f := range s.Functions {
+		fmt.Fprintf(&buf, "%-24s ; %s\n", f.Name, f.Function)
+	}
+	return buf.String()
+}
+
+// Write writes all files and the manifest into dir
+func (s *SplitOutput) Write(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, f := range s.Files() {
+		if err := os.WriteFile(filepath.Join(dir, f.Name), []byte(f.Content), 0644); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(filepath.Join(dir, SplitManifestFile), []byte(s.Manifest()), 0644)
+}
+
+// GenerateSplit generates assembly with each function in its own file.
+// Cross-function calls stay symbolic (CALL name) and resolve when the
+// files are assembled together.
+func (g *Z80Generator) GenerateSplit(module *ir.Module) (*SplitOutput, error) {
+	g.module = module
+	original := g.writer
+	defer func() { g.writer = original }()
+
+	out := &SplitOutput{}
+	used := map[string]bool{SplitSharedFile: true, SplitManifestFile: true}
+
+	for _, fn := range orderFunctions(module.Functions) {
+		var buf bytes.Buffer
+		g.writer = &buf
+		g.emit("; MinZ generated code")
+		g.emit("; Function file for %s", fn.Name)
+		if err := g.generateFunction(fn); err != nil {
+			return nil, err
+		}
+		out.Functions = append(out.Functions, SplitFile{
+			Name:     splitFileName(fn.Name, used),
+			Function: fn.Name,
+			Content:  buf.String(),
+		})
+	}
+
+	// The IM2 vector table follows all the code
+	if g.im2Handler() != nil {
+		var buf bytes.Buffer
+		g.writer = &buf
+		g.emit("; MinZ generated code")
+		g.generateIM2Table()
+		out.Functions = append(out.Functions, SplitFile{
+			Name:    splitFileName("im2_vectors", used),
+			Content: buf.String(),
+		})
+	}
+
+	// The shared file is generated last: print helpers, stdlib routines and
+	// data blocks are only known once every function has been generated
+	var buf bytes.Buffer
+	g.writer = &buf
+	g.writeHeader()
+	if len(module.Globals) > 0 || len(module.Strings) > 0 || len(g.dataBlocks) > 0 {
+		g.emit("\n; Data section")
+		g.generateOrigin("DATA", 0xF000, true)
+		g.emit("")
+		for _, global := range module.Globals {
+			g.generateGlobal(global)
+		}
+		for _, str := range module.Strings {
+			g.generateString(str)
+		}
+		g.generateDataBlocks()
+		g.generateStructDataBlocks()
+	}
+
+	// Runtime support opens the code section; functions follow in manifest order
+	g.emit("\n; Code section")
+	g.generateCodeOrigin()
+	g.generateCallThunks()
+	g.generatePatchTable()
+	if g.needsPrintHelpers() {
+		g.generatePrintHelpers()
+	}
+	g.generateStdlibRoutines()
+	g.generateFixedPointRoutines()
+	g.generateArithmeticRoutines()
+	g.generateFormatRoutines()
+	out.Shared = SplitFile{Name: SplitSharedFile, Content: buf.String()}
+
+	return out, nil
+}
+
+// splitFileName derives a unique .a80 file name from a function name
+func splitFileName(funcName string, used map[string]bool) string {
+	var b strings.Builder
+	for _, r := range strings.TrimLeft(funcName, ".") {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	base := b.String()
+	if base == "" {
+		base = "function"
+	}
+
+	name := base + ".a80"
+	for i := 2; used[name]; i++ {
+		name = fmt.Sprintf("%s_%d.a80", base, i)
+	}
+	used[name] = true
+	return name
+}
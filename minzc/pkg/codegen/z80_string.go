@@ -0,0 +1,199 @@
+package codegen
+
+import (
+	"fmt"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// The str_* routines work on u8 length-prefixed strings and are emitted only
+// when used. Each takes its first string in HL and its second in DE and
+// returns its result in A:
+//
+//	str_cmp    HL = a, DE = b; A = 0 if equal, 1 if a > b, $FF if a < b
+//	str_copy   DE = dest, HL = src, A = capacity; A = 1 if all of src fit
+//	str_cat    DE = dest, HL = src, A = capacity; A = 1 if all of src fit
+//	str_index  HL = s, DE = sub; A = index of the first sub in s, or $FF
+//
+// Strings compare byte by byte, a prefix before the longer string. Copy and
+// cat never write more than capacity characters after the length byte and
+// cut src short when it does not fit.
+
+// stringRoutineDeps lists the routines each string routine runs into
+var stringRoutineDeps = map[string][]string{
+	"str_copy": {"str_cat"},
+}
+
+// useStringRoutine marks a string routine and the routines it needs as used
+func (g *Z80Generator) useStringRoutine(name string) {
+	if g.usedFunctions[name] {
+		return
+	}
+	g.usedFunctions[name] = true
+	for _, dep := range stringRoutineDeps[name] {
+		g.useStringRoutine(dep)
+	}
+}
+
+// generateStrCall calls the string routine named by the instruction
+func (g *Z80Generator) generateStrCall(inst ir.Instruction) error {
+	switch inst.Symbol {
+	case "str_cmp", "str_index":
+		g.loadToDEAndHL(inst.Src2, inst.Src1)
+	case "str_copy", "str_cat":
+		if len(inst.Args) != 1 {
+			return fmt.Errorf("%s needs a capacity", inst.Symbol)
+		}
+		g.loadToA(inst.Args[0])
+		g.emit("    PUSH AF            ; Capacity")
+		g.loadToDEAndHL(inst.Src1, inst.Src2)
+		g.emit("    POP AF")
+	default:
+		return fmt.Errorf("unknown string routine %s", inst.Symbol)
+	}
+	g.emit("    CALL %s", inst.Symbol)
+	g.useStringRoutine(inst.Symbol)
+	g.storeFromA(inst.Dest)
+	return nil
+}
+
+// generateStringRoutines emits the string routines in use
+func (g *Z80Generator) generateStringRoutines() {
+	if g.usedFunctions["str_cmp"] {
+		g.emit("; Compare the strings at HL and DE: A = 0, 1 (HL greater) or $FF")
+		g.emit("str_cmp:")
+		g.emit("    LD C, (HL)         ; C = length of a")
+		g.emit("    LD A, (DE)")
+		g.emit("    LD B, A            ; B = length of b")
+		g.emit("str_cmp_loop:")
+		g.emit("    INC HL")
+		g.emit("    INC DE")
+		g.emit("    LD A, C")
+		g.emit("    OR A")
+		g.emit("    JR Z, str_cmp_end_a")
+		g.emit("    LD A, B")
+		g.emit("    OR A")
+		g.emit("    JR Z, str_cmp_greater ; b is a prefix of a")
+		g.emit("    LD A, (DE)")
+		g.emit("    CP (HL)")
+		g.emit("    JR C, str_cmp_greater")
+		g.emit("    JR NZ, str_cmp_less")
+		g.emit("    DEC C")
+		g.emit("    DEC B")
+		g.emit("    JR str_cmp_loop")
+		g.emit("str_cmp_end_a:")
+		g.emit("    LD A, B")
+		g.emit("    OR A")
+		g.emit("    RET Z              ; Same length: equal")
+		g.emit("str_cmp_less:")
+		g.emit("    LD A, $FF")
+		g.emit("    RET")
+		g.emit("str_cmp_greater:")
+		g.emit("    LD A, 1")
+		g.emit("    RET")
+		g.emit("")
+	}
+
+	if g.usedFunctions["str_copy"] {
+		g.emit("; Copy the string at HL into the buffer at DE holding A characters")
+		g.emit("str_copy:")
+		g.emit("    LD B, A")
+		g.emit("    XOR A")
+		g.emit("    LD (DE), A         ; Empty the destination, then append")
+		g.emit("    LD A, B")
+	}
+
+	if g.usedFunctions["str_cat"] {
+		g.emit("; Append the string at HL to the buffer at DE holding A characters")
+		g.emit("str_cat:")
+		g.emit("    PUSH DE            ; Destination length byte")
+		g.emit("    LD B, A            ; B = capacity")
+		g.emit("    LD A, (DE)")
+		g.emit("    LD C, A            ; C = destination length")
+		g.emit("    INC DE")
+		g.emit("    LD A, E            ; DE = end of the destination text")
+		g.emit("    ADD A, C")
+		g.emit("    LD E, A")
+		g.emit("    ADC A, D")
+		g.emit("    SUB E")
+		g.emit("    LD D, A")
+		g.emit("    LD A, B")
+		g.emit("    SUB C              ; A = room left")
+		g.emit("    JR NC, str_cat_room")
+		g.emit("    XOR A              ; Already over capacity")
+		g.emit("str_cat_room:")
+		g.emit("    LD B, A")
+		g.emit("    LD A, (HL)         ; A = source length")
+		g.emit("    INC HL")
+		g.emit("    CP B")
+		g.emit("    JR C, str_cat_fits")
+		g.emit("    JR Z, str_cat_fits")
+		g.emit("    LD A, B            ; Copy only what fits")
+		g.emit("    LD B, 0")
+		g.emit("    JR str_cat_copy")
+		g.emit("str_cat_fits:")
+		g.emit("    LD B, 1")
+		g.emit("str_cat_copy:")
+		g.emit("    EX (SP), HL        ; HL = destination, source text on the stack")
+		g.emit("    PUSH AF")
+		g.emit("    ADD A, C")
+		g.emit("    LD (HL), A         ; New destination length")
+		g.emit("    POP AF")
+		g.emit("    POP HL")
+		g.emit("    LD C, A")
+		g.emit("    LD A, B            ; Whether all of the source fit")
+		g.emit("    LD B, 0")
+		g.emit("    INC C")
+		g.emit("    DEC C")
+		g.emit("    RET Z")
+		g.emit("    LDIR")
+		g.emit("    RET")
+		g.emit("")
+	}
+
+	if g.usedFunctions["str_index"] {
+		g.emit("; Find the string at DE in the string at HL: A = index, or $FF")
+		g.emit("str_index:")
+		g.emit("    LD A, (DE)")
+		g.emit("    LD C, A            ; C = needle length")
+		g.emit("    OR A")
+		g.emit("    RET Z              ; An empty needle is found at 0")
+		g.emit("    INC DE")
+		g.emit("    LD A, (HL)")
+		g.emit("    INC HL")
+		g.emit("    SUB C")
+		g.emit("    JR C, str_index_none ; Needle longer than the string")
+		g.emit("    INC A")
+		g.emit("    LD B, A            ; B = positions left to try")
+		g.emit("    PUSH AF            ; Positions in all, for the index")
+		g.emit("str_index_try:")
+		g.emit("    PUSH BC")
+		g.emit("    PUSH DE")
+		g.emit("    PUSH HL")
+		g.emit("str_index_match:")
+		g.emit("    LD A, (DE)")
+		g.emit("    CP (HL)")
+		g.emit("    JR NZ, str_index_next")
+		g.emit("    INC DE")
+		g.emit("    INC HL")
+		g.emit("    DEC C")
+		g.emit("    JR NZ, str_index_match")
+		g.emit("    POP HL")
+		g.emit("    POP DE")
+		g.emit("    POP BC")
+		g.emit("    POP AF")
+		g.emit("    SUB B              ; Index = positions tried before this one")
+		g.emit("    RET")
+		g.emit("str_index_next:")
+		g.emit("    POP HL")
+		g.emit("    POP DE")
+		g.emit("    POP BC")
+		g.emit("    INC HL")
+		g.emit("    DJNZ str_index_try")
+		g.emit("    POP AF")
+		g.emit("str_index_none:")
+		g.emit("    LD A, $FF")
+		g.emit("    RET")
+		g.emit("")
+	}
+}
@@ -0,0 +1,2029 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/emulator"
+	"github.com/minz/minzc/pkg/ir"
+	"github.com/minz/minzc/pkg/optimizer"
+	"github.com/minz/minzc/pkg/parser"
+	"github.com/minz/minzc/pkg/semantic"
+	"github.com/minz/minzc/pkg/z80asm"
+)
+
+// newTestFunction builds a minimal function returning a constant
+func newTestFunction(name string, value int64) *ir.Function {
+	fn := ir.NewFunction(name, &ir.BasicType{Kind: ir.TypeU8})
+	fn.IsSMCDefault = false
+	fn.IsSMCEnabled = false
+	fn.Instructions = []ir.Instruction{
+		{Op: ir.OpLoadConst, Dest: 1, Imm: value, Type: &ir.BasicType{Kind: ir.TypeU8}},
+		{Op: ir.OpReturn, Src1: 1},
+	}
+	fn.NextReg = 2
+	return fn
+}
+
+// generateZ80 runs the Z80 generator over a module and returns the assembly
+func generateZ80(t *testing.T, module *ir.Module, setup func(g *Z80Generator)) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gen := NewZ80Generator(&buf)
+	if setup != nil {
+		setup(gen)
+	}
+	if err := gen.Generate(module); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	return buf.String()
+}
+
+// runZ80 assembles generated code and runs it from entry until it returns
+// to address 0, the same exit convention mze uses
+func runZ80(t *testing.T, asm string, entry string) *emulator.RemogattoZ80 {
+	t.Helper()
+	return execZ80(t, assembleZ80(t, asm), entry)
+}
+
+// assembleZ80 assembles generated code, failing the test on any error
+func assembleZ80(t *testing.T, asm string) *z80asm.Result {
+	t.Helper()
+	assembler := z80asm.NewAssembler()
+	result, err := assembler.AssembleString(asm)
+	if err != nil {
+		t.Fatalf("assembly failed: %v\n%s", err, asm)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("assembly errors: %v\n%s", result.Errors, asm)
+	}
+	return result
+}
+
+// execZ80 loads an assembled binary and runs it from entry
+func execZ80(t *testing.T, result *z80asm.Result, entry string) *emulator.RemogattoZ80 {
+	t.Helper()
+	start, ok := result.Symbols[strings.ToUpper(entry)]
+	if !ok {
+		t.Fatalf("entry %s not found", entry)
+	}
+
+	z := emulator.NewRemogattoZ80()
+	z.LoadMemory(result.Origin, result.Binary)
+	// Return address 0 on the stack ends the run
+	z.SetSP(0xFEFE)
+	z.SetMemory(0xFEFE, 0)
+	z.SetMemory(0xFEFF, 0)
+	z.SetPC(start)
+	if err := z.Run(); err != nil {
+		t.Fatalf("execution failed: %v\n%s", err, z.DumpState())
+	}
+	return z
+}
+
+func TestSectionPlacement(t *testing.T) {
+	main := newTestFunction("main", 0)
+	bank1 := newTestFunction("load_level", 1)
+	bank1.Section = "bank1"
+	bank2 := newTestFunction("play_music", 2)
+	bank2.Section = "bank2"
+
+	module := &ir.Module{
+		Name:      "test",
+		Functions: []*ir.Function{bank1, main, bank2},
+		Globals: []ir.Global{
+			{Name: "level_data", Type: &ir.BasicType{Kind: ir.TypeU8}, Section: "bank1"},
+			{Name: "score", Type: &ir.BasicType{Kind: ir.TypeU16}},
+		},
+	}
+
+	asm := generateZ80(t, module, func(g *Z80Generator) {
+		g.SetSectionOrigin("bank2", 0xD000)
+	})
+
+	mainOrg := strings.Index(asm, "ORG $8000")
+	bank1Org := strings.Index(asm, "; Section: bank1\n    ORG $C000")
+	bank2Org := strings.Index(asm, "; Section: bank2\n    ORG $D000")
+	if mainOrg < 0 || bank1Org < 0 || bank2Org < 0 {
+		t.Fatalf("missing section ORGs (main=%d bank1=%d bank2=%d):\n%s", mainOrg, bank1Org, bank2Org, asm)
+	}
+
+	labelPos := func(label string) int {
+		pos := strings.Index(asm, "\n"+label+":")
+		if pos < 0 {
+			t.Fatalf("label %s not found:\n%s", label, asm)
+		}
+		return pos
+	}
+
+	if pos := labelPos("main"); pos < mainOrg || pos > bank1Org {
+		t.Errorf("default-section function should be in the main block")
+	}
+	if pos := labelPos("load_level"); pos < bank1Org || pos > bank2Org {
+		t.Errorf("load_level should be under the bank1 ORG")
+	}
+	if pos := labelPos("level_data"); pos < bank1Org || pos > bank2Org {
+		t.Errorf("level_data should be under the bank1 ORG")
+	}
+	if pos := labelPos("play_music"); pos < bank2Org {
+		t.Errorf("play_music should be under the bank2 ORG")
+	}
+	if pos := labelPos("score"); pos > mainOrg {
+		t.Errorf("default-section global should be in the main data section")
+	}
+}
+
+func TestSectionDirectives(t *testing.T) {
+	main := newTestFunction("main", 0)
+	bank1 := newTestFunction("load_level", 1)
+	bank1.Section = "bank1"
+	bank2 := newTestFunction("play_music", 2)
+	bank2.Section = "bank2"
+	module := &ir.Module{
+		Name:      "test",
+		Functions: []*ir.Function{bank1, main, bank2},
+		Globals:   []ir.Global{{Name: "score", Type: &ir.BasicType{Kind: ir.TypeU16}}},
+	}
+
+	asm := generateZ80(t, module, func(g *Z80Generator) {
+		g.SetSections(true)
+		g.SetSectionOrigin("bank2", 0xD000)
+	})
+	for _, want := range []string{"    SECTION DATA, $F000\n", "    SECTION CODE, $8000\n", "    SECTION bank1\n", "    SECTION bank2, $D000\n"} {
+		if !strings.Contains(asm, want) {
+			t.Errorf("missing %q:\n%s", strings.TrimSpace(want), asm)
+		}
+	}
+	if strings.Contains(asm, "ORG") {
+		t.Errorf("ORG emitted with sections:\n%s", asm)
+	}
+
+	// The assembler places bank1 right after the data
+	result := assembleZ80(t, asm)
+	data := result.Sections[1]
+	if data.Name != "DATA" || result.Symbols["LOAD_LEVEL"] != data.Start+uint16(data.Size) || result.Symbols["PLAY_MUSIC"] != 0xD000 {
+		t.Errorf("load_level at $%04X, play_music at $%04X; sections %+v",
+			result.Symbols["LOAD_LEVEL"], result.Symbols["PLAY_MUSIC"], result.Sections)
+	}
+}
+
+func TestComputedGotoDispatchTable(t *testing.T) {
+	u16 := &ir.BasicType{Kind: ir.TypeU16}
+	handlers := []*ir.Function{
+		newTestFunction("handler0", 10),
+		newTestFunction("handler1", 20),
+		newTestFunction("handler2", 30),
+		newTestFunction("handler3", 40),
+	}
+
+	for index, want := range []uint8{10, 20, 30, 40} {
+		dispatch := ir.NewFunction("dispatch", &ir.BasicType{Kind: ir.TypeVoid})
+		dispatch.IsSMCDefault = false
+		dispatch.IsSMCEnabled = false
+		dispatch.NextReg = 3
+		// Block labels are scoped to the function, so the table is dispatch_dispatch_table
+		dispatch.Instructions = []ir.Instruction{
+			{Op: ir.OpLoadLabel, Dest: 1, Symbol: fmt.Sprintf("dispatch_dispatch_table+%d", index*2), Type: u16},
+			{Op: ir.OpLoad, Dest: 2, Src1: 1, Type: u16},
+			{Op: ir.OpJumpIndirect, Src1: 2},
+			{Op: ir.OpLabel, Label: "dispatch_table"},
+			{Op: ir.OpAsm, AsmCode: "DW handler0, handler1, handler2, handler3"},
+		}
+
+		module := &ir.Module{
+			Name:      "test",
+			Functions: append([]*ir.Function{dispatch}, handlers...),
+		}
+		asm := generateZ80(t, module, nil)
+		if !strings.Contains(asm, "JP (HL)") {
+			t.Fatalf("expected JP (HL) in output:\n%s", asm)
+		}
+
+		z := runZ80(t, asm, "dispatch")
+		if got := z.GetRegisters().A; got != want {
+			t.Errorf("entry %d: handler returned %d, want %d", index, got, want)
+		}
+	}
+}
+
+func TestFunctionTable(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	fnType := &ir.FunctionType{Params: []ir.Type{}, Return: u8}
+	tableType := &ir.ArrayType{Element: fnType, Length: 4}
+
+	for index, want := range []uint8{10, 20, 30, 40} {
+		dispatch := ir.NewFunction("dispatch", &ir.BasicType{Kind: ir.TypeVoid})
+		dispatch.IsSMCDefault = false
+		dispatch.IsSMCEnabled = false
+		dispatch.NextReg = 5
+		dispatch.Instructions = []ir.Instruction{
+			{Op: ir.OpLoadAddr, Dest: 1, Symbol: "handlers", Type: tableType},
+			{Op: ir.OpLoadConst, Dest: 2, Imm: int64(index), Type: u8},
+			{Op: ir.OpLoadIndex, Dest: 3, Src1: 1, Src2: 2, Type: fnType},
+			{Op: ir.OpCallIndirect, Dest: 4, Src1: 3},
+			{Op: ir.OpReturn},
+		}
+
+		module := &ir.Module{
+			Name: "test",
+			Functions: []*ir.Function{
+				dispatch,
+				newTestFunction("handler0", 10),
+				newTestFunction("handler1", 20),
+				newTestFunction("handler2", 30),
+				newTestFunction("handler3", 40),
+			},
+			Globals: []ir.Global{{
+				Name:     "handlers",
+				Type:     tableType,
+				Init:     []string{"handler0", "handler1", "handler2", "handler3"},
+				Constant: true,
+			}},
+		}
+		asm := generateZ80(t, module, func(g *Z80Generator) {
+			g.usePhysicalRegs = false
+		})
+		if !strings.Contains(asm, "DW handler0, handler1, handler2, handler3") {
+			t.Fatalf("expected the table as a DW list:\n%s", asm)
+		}
+
+		z := runZ80(t, asm, "dispatch")
+		if got := z.GetRegisters().A; got != want {
+			t.Errorf("entry %d: handler returned %d, want %d\n%s", index, got, want, asm)
+		}
+	}
+}
+
+func TestSplitOutput(t *testing.T) {
+	helper := newTestFunction("helper", 42)
+	main := ir.NewFunction("main", &ir.BasicType{Kind: ir.TypeU8})
+	main.IsSMCDefault = false
+	main.IsSMCEnabled = false
+	main.NextReg = 2
+	main.Instructions = []ir.Instruction{
+		{Op: ir.OpCall, Dest: 1, Symbol: "helper", Type: &ir.BasicType{Kind: ir.TypeU8}},
+		{Op: ir.OpReturn, Src1: 1},
+	}
+	module := &ir.Module{
+		Name:      "test",
+		Functions: []*ir.Function{main, helper, newTestFunction("util$u8", 1)},
+		Globals:   []ir.Global{{Name: "counter", Type: &ir.BasicType{Kind: ir.TypeU8}}},
+	}
+
+	out, err := NewZ80Generator(nil).GenerateSplit(module)
+	if err != nil {
+		t.Fatalf("split generation failed: %v", err)
+	}
+
+	if len(out.Functions) != len(module.Functions) {
+		t.Fatalf("got %d function files, want %d", len(out.Functions), len(module.Functions))
+	}
+	if len(out.Files()) != len(module.Functions)+1 {
+		t.Errorf("got %d files, want %d functions plus the shared file", len(out.Files()), len(module.Functions))
+	}
+	if !strings.Contains(out.Shared.Content, "counter:") {
+		t.Errorf("shared file missing globals:\n%s", out.Shared.Content)
+	}
+
+	wantNames := []string{"main.a80", "helper.a80", "util_u8.a80"}
+	for i, f := range out.Functions {
+		if f.Name != wantNames[i] {
+			t.Errorf("file %d named %s, want %s", i, f.Name, wantNames[i])
+		}
+		if f.Function != module.Functions[i].Name {
+			t.Errorf("%s holds %s, want %s", f.Name, f.Function, module.Functions[i].Name)
+		}
+	}
+	if !strings.Contains(out.Functions[0].Content, "CALL helper") {
+		t.Errorf("cross-function call not symbolic:\n%s", out.Functions[0].Content)
+	}
+
+	manifest := out.Manifest()
+	for _, f := range out.Files() {
+		if !strings.Contains(manifest, f.Name) {
+			t.Errorf("manifest missing %s:\n%s", f.Name, manifest)
+		}
+	}
+
+	// Concatenated in manifest order, the files assemble and run as one program.
+	// The assembler lays ORG blocks out contiguously, so run without the data section.
+	module.Globals = nil
+	out, err = NewZ80Generator(nil).GenerateSplit(module)
+	if err != nil {
+		t.Fatalf("split generation failed: %v", err)
+	}
+	var combined strings.Builder
+	for _, f := range out.Files() {
+		combined.WriteString(f.Content)
+	}
+	z := runZ80(t, combined.String(), "main")
+	if got := z.GetRegisters().A; got != 42 {
+		t.Errorf("main returned %d, want 42", got)
+	}
+}
+
+// longJumps assembles code and counts absolute jumps in [from, to) whose
+// target is beyond JR range, i.e. jumps that cannot be shortened to JR
+func longJumps(t *testing.T, asm string, from, to string) int {
+	t.Helper()
+	result, err := z80asm.NewAssembler().AssembleString(asm)
+	if err != nil {
+		t.Fatalf("assembly failed: %v\n%s", err, asm)
+	}
+	start, end := result.Symbols[strings.ToUpper(from)], result.Symbols[strings.ToUpper(to)]
+	if start >= end {
+		t.Fatalf("%s ($%04X) not before %s ($%04X)", from, start, to, end)
+	}
+
+	count := 0
+	for _, line := range result.Listing {
+		if line.Address < start || line.Address >= end || len(line.Bytes) != 3 {
+			continue
+		}
+		// JP nn and JP cc,nn
+		if op := line.Bytes[0]; op != 0xC3 && op&0xC7 != 0xC2 {
+			continue
+		}
+		target := int(line.Bytes[1]) | int(line.Bytes[2])<<8
+		offset := target - (int(line.Address) + 2)
+		if offset < -128 || offset > 127 {
+			count++
+		}
+	}
+	return count
+}
+
+func TestHotColdPlacement(t *testing.T) {
+	// update jumps to render; report_error sits between them in source order
+	update := newTestFunction("update", 0)
+	update.Instructions = []ir.Instruction{
+		{Op: ir.OpLoadConst, Dest: 1, Imm: 1, Type: &ir.BasicType{Kind: ir.TypeU8}},
+		{Op: ir.OpJumpIfNotZero, Src1: 1, Symbol: "render"},
+		{Op: ir.OpReturn, Src1: 1},
+	}
+	reportError := newTestFunction("report_error", 0)
+	padding := make([]ir.Instruction, 200)
+	for i := range padding {
+		padding[i] = ir.Instruction{Op: ir.OpNop}
+	}
+	reportError.Instructions = append(padding, reportError.Instructions...)
+	render := newTestFunction("render", 2)
+	main := newTestFunction("main", 0)
+
+	newModule := func() *ir.Module {
+		return &ir.Module{
+			Name:      "test",
+			Functions: []*ir.Function{main, update, reportError, render},
+		}
+	}
+
+	before := longJumps(t, generateZ80(t, newModule(), nil), "update", "render")
+
+	update.Hint = ir.HintHot
+	render.Hint = ir.HintHot
+	reportError.Hint = ir.HintCold
+	asm := generateZ80(t, newModule(), nil)
+
+	pos := func(label string) int {
+		p := strings.Index(asm, "\n"+label+":")
+		if p < 0 {
+			t.Fatalf("label %s not found:\n%s", label, asm)
+		}
+		return p
+	}
+	if !(pos("update") < pos("render") && pos("render") < pos("main") && pos("main") < pos("report_error")) {
+		t.Errorf("want @hot update, render, then main, then @cold report_error:\n%s", asm)
+	}
+	if !strings.Contains(asm, "; Function: report_error (@cold)") {
+		t.Errorf("missing @cold annotation in function header")
+	}
+
+	after := longJumps(t, asm, "update", "render")
+	if before != 1 || after != 0 {
+		t.Errorf("long jumps in hot code: %d before ordering, %d after; want 1 and 0", before, after)
+	}
+}
+
+func TestComparison16(t *testing.T) {
+	u16 := &ir.BasicType{Kind: ir.TypeU16}
+	i16 := &ir.BasicType{Kind: ir.TypeI16}
+
+	tests := []struct {
+		name string
+		op   ir.Opcode
+		typ  ir.Type
+		a, b int64
+		want uint8
+	}{
+		// Unsigned values straddling $8000 compare by carry, not sign
+		{"u16 0x8000 > 0x7FFF", ir.OpGt, u16, 0x8000, 0x7FFF, 1},
+		{"u16 0x7FFF < 0x8000", ir.OpLt, u16, 0x7FFF, 0x8000, 1},
+		{"u16 0x8000 < 0x7FFF", ir.OpLt, u16, 0x8000, 0x7FFF, 0},
+		{"u16 0xFFFF >= 0x0001", ir.OpGe, u16, 0xFFFF, 0x0001, 1},
+		{"u16 0x0001 <= 0xFFFF", ir.OpLe, u16, 0x0001, 0xFFFF, 1},
+		{"u16 0x1234 <= 0x1234", ir.OpLe, u16, 0x1234, 0x1234, 1},
+		{"u16 0x1234 > 0x1234", ir.OpGt, u16, 0x1234, 0x1234, 0},
+		// Signed values, including subtractions that overflow
+		{"i16 -1 < 1", ir.OpLt, i16, -1, 1, 1},
+		{"i16 1 > -1", ir.OpGt, i16, 1, -1, 1},
+		{"i16 -32768 < 32767", ir.OpLt, i16, -32768, 32767, 1},
+		{"i16 32767 > -32768", ir.OpGt, i16, 32767, -32768, 1},
+		{"i16 32767 < -1", ir.OpLt, i16, 32767, -1, 0},
+		{"i16 -5 >= -5", ir.OpGe, i16, -5, -5, 1},
+		{"i16 -6 >= -5", ir.OpGe, i16, -6, -5, 0},
+		{"i16 -32768 <= -32768", ir.OpLe, i16, -32768, -32768, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := ir.NewFunction("compare", &ir.BasicType{Kind: ir.TypeU8})
+			fn.IsSMCDefault = false
+			fn.IsSMCEnabled = false
+			fn.Instructions = []ir.Instruction{
+				{Op: ir.OpLoadConst, Dest: 1, Imm: int64(uint16(tt.a)), Type: tt.typ},
+				{Op: ir.OpLoadConst, Dest: 2, Imm: int64(uint16(tt.b)), Type: tt.typ},
+				{Op: tt.op, Dest: 3, Src1: 1, Src2: 2, Type: tt.typ},
+				{Op: ir.OpReturn, Src1: 3},
+			}
+			fn.NextReg = 4
+
+			// Keep virtual registers in memory so both operands survive to the compare
+			asm := generateZ80(t, &ir.Module{Name: "test", Functions: []*ir.Function{fn}}, func(g *Z80Generator) {
+				g.usePhysicalRegs = false
+			})
+			z := runZ80(t, asm, "compare")
+			if got := uint8(z.GetRegisters().HL); got != tt.want {
+				t.Errorf("got %d, want %d\n%s", got, tt.want, asm)
+			}
+		})
+	}
+}
+
+func TestRelocatableCalls(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	// main and helper both call value; moved_value is where value is relocated to
+	caller := func(name string) *ir.Function {
+		fn := ir.NewFunction(name, u8)
+		fn.IsSMCDefault = false
+		fn.IsSMCEnabled = false
+		fn.Instructions = []ir.Instruction{
+			{Op: ir.OpCall, Dest: 1, Symbol: "value", Type: u8},
+			{Op: ir.OpReturn, Src1: 1},
+		}
+		fn.NextReg = 2
+		return fn
+	}
+	module := &ir.Module{
+		Name: "test",
+		Functions: []*ir.Function{
+			caller("main"), caller("helper"),
+			newTestFunction("value", 7), newTestFunction("moved_value", 9),
+		},
+	}
+
+	asm := generateZ80(t, module, func(g *Z80Generator) {
+		g.usePhysicalRegs = false
+		g.SetRelocatableCalls(true)
+	})
+
+	if strings.Contains(asm, "CALL value\n") {
+		t.Errorf("call bypasses the thunk table:\n%s", asm)
+	}
+	if n := strings.Count(asm, "CALL value_thunk"); n != 2 {
+		t.Errorf("got %d calls through value_thunk, want 2:\n%s", n, asm)
+	}
+	if !strings.Contains(asm, "value_thunk:\n    JP value\n") {
+		t.Errorf("missing thunk entry for value:\n%s", asm)
+	}
+
+	result := assembleZ80(t, asm)
+	table := result.Symbols[CallThunkTable]
+	if table != 0x8000 {
+		t.Errorf("thunk table at $%04X, want fixed address $8000", table)
+	}
+
+	for _, entry := range []string{"main", "helper"} {
+		if got := uint8(execZ80(t, result, entry).GetRegisters().HL); got != 7 {
+			t.Errorf("%s returned %d before relocation, want 7", entry, got)
+		}
+	}
+
+	// Relocate value by rewriting the address bytes of its JP thunk only
+	thunk := result.Symbols["VALUE_THUNK"] - result.Origin
+	moved := result.Symbols["MOVED_VALUE"]
+	if result.Binary[thunk] != 0xC3 {
+		t.Fatalf("value_thunk does not start with JP: $%02X", result.Binary[thunk])
+	}
+	result.Binary[thunk+1] = byte(moved)
+	result.Binary[thunk+2] = byte(moved >> 8)
+
+	for _, entry := range []string{"main", "helper"} {
+		if got := uint8(execZ80(t, result, entry).GetRegisters().HL); got != 9 {
+			t.Errorf("%s returned %d after patching the thunk, want 9", entry, got)
+		}
+	}
+}
+
+func TestFixedPoint(t *testing.T) {
+	f88 := &ir.BasicType{Kind: ir.TypeF8_8}
+	fixedFunction := func(op ir.Opcode, a, b int64) *ir.Function {
+		fn := ir.NewFunction("fixed", f88)
+		fn.IsSMCDefault = false
+		fn.IsSMCEnabled = false
+		fn.Instructions = []ir.Instruction{
+			{Op: ir.OpLoadConst, Dest: 1, Imm: a, Type: f88},
+			{Op: ir.OpLoadConst, Dest: 2, Imm: b, Type: f88},
+			{Op: op, Dest: 3, Src1: 1, Src2: 2, Type: f88},
+			{Op: ir.OpReturn, Src1: 3},
+		}
+		fn.NextReg = 4
+		return fn
+	}
+
+	tests := []struct {
+		name string
+		op   ir.Opcode
+		a, b int64
+		want uint16
+	}{
+		{"1.5 + 2.25", ir.OpAdd, 0x0180, 0x0240, 0x03C0},  // 3.75
+		{"3.5 * 2.0", ir.OpMul, 0x0380, 0x0200, 0x0700},   // 7.0
+		{"0.5 * 0.5", ir.OpMul, 0x0080, 0x0080, 0x0040},   // 0.25
+		{"1.5 * 2.25", ir.OpMul, 0x0180, 0x0240, 0x0360},  // 3.375
+		{"0.75 * 10.0", ir.OpMul, 0x00C0, 0x0A00, 0x0780}, // 7.5
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asm := generateZ80(t, &ir.Module{Name: "test", Functions: []*ir.Function{fixedFunction(tt.op, tt.a, tt.b)}}, func(g *Z80Generator) {
+				g.usePhysicalRegs = false
+			})
+			if got := runZ80(t, asm, "fixed").GetRegisters().HL; got != tt.want {
+				t.Errorf("got $%04X, want $%04X\n%s", got, tt.want, asm)
+			}
+		})
+	}
+
+	t.Run("print", func(t *testing.T) {
+		for _, tt := range []struct {
+			raw  int64
+			want string
+		}{
+			{0x0380, "3.5"},
+			{0x0000, "0.0"},
+			{0x6440, "100.25"},
+			{0x0A01, "10.003"},
+		} {
+			fn := ir.NewFunction("show", &ir.BasicType{Kind: ir.TypeVoid})
+			fn.IsSMCDefault = false
+			fn.IsSMCEnabled = false
+			fn.Instructions = []ir.Instruction{
+				{Op: ir.OpLoadConst, Dest: 1, Imm: tt.raw, Type: f88},
+				{Op: ir.OpPrintFixed, Src1: 1, Type: f88},
+				{Op: ir.OpReturn},
+			}
+			fn.NextReg = 2
+			asm := generateZ80(t, &ir.Module{Name: "test", Functions: []*ir.Function{fn}}, func(g *Z80Generator) {
+				g.usePhysicalRegs = false
+			})
+
+			result := assembleZ80(t, asm)
+			z := emulator.NewRemogattoZ80()
+			z.LoadMemory(result.Origin, result.Binary)
+			// RST 16 stub: OUT ($01), A; RET
+			z.LoadMemory(0x0010, []byte{0xD3, 0x01, 0xC9})
+			var out strings.Builder
+			z.SetIOHandlers(nil, func(port uint16, value byte) {
+				if port&0xFF == 0x01 {
+					out.WriteByte(value)
+				}
+			})
+			z.SetSP(0xFEFE)
+			z.SetMemory(0xFEFE, 0)
+			z.SetMemory(0xFEFF, 0)
+			z.SetPC(result.Symbols["SHOW"])
+			if err := z.Run(); err != nil {
+				t.Fatalf("execution failed: %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("printed %q for $%04X, want %q\n%s", out.String(), tt.raw, tt.want, asm)
+			}
+		}
+	})
+}
+
+func TestAnnotateSource(t *testing.T) {
+	source := `fun tick() -> void {
+}
+
+fun main() -> void {
+    let i: u8 = 3;
+    while i > 0 {
+        tick();
+    }
+}
+`
+	path := filepath.Join(t.TempDir(), "loop.minz")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	compile := func(annotate bool) string {
+		file, err := parser.NewAntlrParser().ParseFile(path)
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		analyzer := semantic.NewAnalyzer()
+		analyzer.SetAnnotateSource(annotate)
+		module, err := analyzer.Analyze(file)
+		if err != nil {
+			t.Fatalf("analysis failed: %v", err)
+		}
+		return generateZ80(t, module, nil)
+	}
+
+	if asm := compile(false); strings.Contains(asm, "; --- begin") {
+		t.Errorf("source marks emitted without --annotate-source:\n%s", asm)
+	}
+
+	asm := compile(true)
+	lines := strings.Split(asm, "\n")
+	begin, end := -1, -1
+	for i, line := range lines {
+		if line == "; --- begin while loop (loop.minz:6) ---" {
+			begin = i
+		}
+		if begin >= 0 && end < 0 && i > begin && line == "; --- end ---" {
+			end = i
+		}
+	}
+	if begin < 0 || end < 0 {
+		t.Fatalf("while loop not bracketed with its source line:\n%s", asm)
+	}
+
+	// The loop head, the call in the body and the back edge are all inside
+	loop := strings.Join(lines[begin:end], "\n")
+	for _, want := range []string{"JP Z, loop_main_end_loop", "CALL loop_tick", "JP loop_main_loop"} {
+		if !strings.Contains(loop, want) {
+			t.Errorf("loop bracket lacks %q:\n%s", want, loop)
+		}
+	}
+	if !strings.Contains(asm, "; --- begin function main (loop.minz:4) ---") {
+		t.Errorf("function main not bracketed:\n%s", asm)
+	}
+}
+
+func TestDeterministicOutput(t *testing.T) {
+	source := `fun add(a: u8, b: u8) -> u8 {
+    return a + b;
+}
+
+fun main() -> void {
+    let i: u8 = add(2, 3);
+    if i > 4 {
+        let j: u8 = add(i, 1);
+    }
+}
+`
+	path := filepath.Join(t.TempDir(), "det.minz")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	compile := func() string {
+		file, err := parser.NewAntlrParser().ParseFile(path)
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		module, err := semantic.NewAnalyzer().Analyze(file)
+		if err != nil {
+			t.Fatalf("analysis failed: %v", err)
+		}
+		asm, err := NewZ80Backend(&BackendOptions{Deterministic: true}).Generate(module)
+		if err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+		return asm
+	}
+
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+	first, second := compile(), compile()
+	if first != second {
+		t.Errorf("outputs differ between identical compilations:\n%s\n---\n%s", first, second)
+	}
+	if strings.Contains(first, "; Generated:") {
+		t.Errorf("deterministic output has a timestamp:\n%s", first)
+	}
+
+	t.Setenv("SOURCE_DATE_EPOCH", "1000000000")
+	if asm := compile(); !strings.Contains(asm, "; Generated: 2001-09-09 01:46:40") {
+		t.Errorf("SOURCE_DATE_EPOCH not used for the timestamp:\n%s", asm)
+	}
+}
+
+func TestSourceLineMarkers(t *testing.T) {
+	source := `fun add(a: u8, b: u8) -> u8 {
+    return a + b;
+}
+
+fun main() -> void {
+    let i: u8 = add(2, 3);
+    if i > 4 {
+        let j: u8 = add(i, 1);
+    }
+}
+`
+	path := filepath.Join(t.TempDir(), "lines.minz")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := parser.NewAntlrParser().ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	module, err := semantic.NewAnalyzer().Analyze(file)
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	var main *ir.Function
+	for _, fn := range module.Functions {
+		if strings.HasSuffix(fn.Name, "main") {
+			main = fn
+		}
+	}
+	if main == nil {
+		t.Fatal("main was not compiled")
+	}
+
+	if asm := generateZ80(t, module, nil); strings.Contains(asm, SourceLineMarker) {
+		t.Errorf("source line markers emitted without source lines enabled:\n%s", asm)
+	}
+
+	// Every marker in main names the first instruction of a line
+	asm := generateZ80(t, module, func(g *Z80Generator) { g.SetSourceLines(true) })
+	body := asm[strings.Index(asm, "; Function: "+main.Name):]
+	body = body[:strings.Index(body, "; End of function: "+main.Name)]
+	var lines []int
+	for _, line := range strings.Split(body, "\n") {
+		index, ok := ParseSourceLineMarker(line)
+		if !ok {
+			continue
+		}
+		inst := main.Instructions[index]
+		if want := fmt.Sprintf("lines.minz:%d", inst.SourceLine); !strings.HasSuffix(line, want) {
+			t.Errorf("marker %q does not end with %s", line, want)
+		}
+		lines = append(lines, inst.SourceLine)
+	}
+	if len(lines) < 3 || lines[0] != 6 || lines[1] != 7 || !slices.Contains(lines, 8) {
+		t.Errorf("main has markers for lines %v, want 6, 7 and 8 in order", lines)
+	}
+}
+
+func TestEnumName(t *testing.T) {
+	source := `enum Color { Red, Green, Blue }
+
+fun main() -> *u8 {
+    let c: Color = Color.Blue;
+    return c.name();
+}
+`
+	path := filepath.Join(t.TempDir(), "names.minz")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := parser.NewAntlrParser().ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	module, err := semantic.NewAnalyzer().Analyze(file)
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	asm := generateZ80(t, module, func(g *Z80Generator) {
+		g.usePhysicalRegs = false
+	})
+	for _, want := range []string{
+		`DB "Red"`,
+		`DB "Blue"`,
+		`DB "unknown"`,
+		"names.Color_names:\n    DW str_0, str_1, str_2, str_3",
+		"; Color variant name",
+		"ADD HL, DE         ; 2 bytes per entry",
+		"EX DE, HL          ; HL = table entry",
+	} {
+		if !strings.Contains(asm, want) {
+			t.Errorf("generated code missing %q:\n%s", want, asm)
+		}
+	}
+	assembleZ80(t, asm)
+}
+
+func TestFixedAddressGlobal(t *testing.T) {
+	addr := uint16(0x4010)
+	module := &ir.Module{
+		Name:      "test",
+		Functions: []*ir.Function{newTestFunction("main", 0)},
+		Globals: []ir.Global{
+			{Name: "attr", Type: &ir.BasicType{Kind: ir.TypeU8}, Address: &addr},
+		},
+	}
+
+	asm := generateZ80(t, module, nil)
+	if !strings.Contains(asm, "attr EQU $4010") {
+		t.Errorf("@at global not bound with EQU:\n%s", asm)
+	}
+	if strings.Contains(asm, "\nattr:") || strings.Contains(asm, "; Data section") {
+		t.Errorf("@at global should take no storage:\n%s", asm)
+	}
+	if got := assembleZ80(t, asm).Symbols["ATTR"]; got != addr {
+		t.Errorf("ATTR = $%04X, want $%04X", got, addr)
+	}
+}
+
+func TestSubtractOperandSwap(t *testing.T) {
+	u16 := &ir.BasicType{Kind: ir.TypeU16}
+	subtract := func(src1, src2 ir.Register) *ir.Function {
+		fn := ir.NewFunction("sub", u16)
+		fn.IsSMCDefault = false
+		fn.IsSMCEnabled = false
+		fn.Instructions = []ir.Instruction{
+			{Op: ir.OpLoadConst, Dest: 1, Imm: 1000, Type: u16},
+			{Op: ir.OpLoadConst, Dest: 2, Imm: 300, Type: u16},
+			{Op: ir.OpSub, Dest: 3, Src1: src1, Src2: src2, Type: u16},
+			{Op: ir.OpReturn, Src1: 3},
+		}
+		fn.NextReg = 4
+		return fn
+	}
+
+	tests := []struct {
+		name       string
+		src1, src2 ir.Register
+		swap       bool // The subtrahend is still in HL from its load
+		want       uint16
+	}{
+		{"1000 - 300", 1, 2, true, 700},
+		{"300 - 1000", 2, 1, false, 0xFD44},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asm := generateZ80(t, &ir.Module{Name: "test", Functions: []*ir.Function{subtract(tt.src1, tt.src2)}}, func(g *Z80Generator) {
+				g.usePhysicalRegs = false
+			})
+			swapped := strings.Contains(asm, "EX DE, HL      ; Register 2 from HL")
+			if swapped != tt.swap {
+				t.Errorf("EX DE,HL operand swap = %v, want %v\n%s", swapped, tt.swap, asm)
+			}
+			if got := runZ80(t, asm, "sub").GetRegisters().HL; got != tt.want {
+				t.Errorf("got $%04X, want $%04X\n%s", got, tt.want, asm)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	i8 := &ir.BasicType{Kind: ir.TypeI8}
+	u16 := &ir.BasicType{Kind: ir.TypeU16}
+	i16 := &ir.BasicType{Kind: ir.TypeI16}
+	const buf = 0x9000
+
+	// format(buf, "[" + verb + "]", value) returning the length
+	formatFunction := func(verb rune, value int64, typ ir.Type) *ir.Function {
+		fn := ir.NewFunction("fmt", u16)
+		fn.IsSMCDefault = false
+		fn.IsSMCEnabled = false
+		fn.Instructions = []ir.Instruction{
+			{Op: ir.OpLoadConst, Dest: 1, Imm: buf, Type: u16},
+			{Op: ir.OpLoadConst, Dest: 2, Imm: value, Type: typ},
+			{Op: ir.OpFormat, Dest: 3, Src1: 1, Symbol: "["},
+			{Op: ir.OpFormat, Dest: 4, Src1: 3, Src2: 2, Imm: int64(verb), Type: typ},
+			{Op: ir.OpFormat, Dest: 5, Src1: 4, Symbol: "]"},
+			{Op: ir.OpSub, Dest: 6, Src1: 5, Src2: 1, Type: u16},
+			{Op: ir.OpReturn, Src1: 6},
+		}
+		fn.NextReg = 7
+		return fn
+	}
+
+	tests := []struct {
+		name  string
+		verb  rune
+		value int64
+		typ   ir.Type
+		want  string
+	}{
+		{"u16 decimal", 'd', 1234, u16, "[1234]"},
+		{"u16 decimal max", 'd', 65535, u16, "[65535]"},
+		{"u8 decimal zero", 'd', 0, u8, "[0]"},
+		{"i8 decimal", 'd', 0xF9, i8, "[-7]"},
+		{"i16 decimal", 'd', 0xFED4, i16, "[-300]"},
+		{"i16 decimal min", 'd', 0x8000, i16, "[-32768]"},
+		{"u8 hex", 'x', 0xAB, u8, "[AB]"},
+		{"u16 hex", 'x', 0x0BEF, u16, "[0BEF]"},
+		{"char", 'c', 'Z', u8, "[Z]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asm := generateZ80(t, &ir.Module{Name: "test", Functions: []*ir.Function{formatFunction(tt.verb, tt.value, tt.typ)}}, func(g *Z80Generator) {
+				g.usePhysicalRegs = false
+			})
+			z := runZ80(t, asm, "fmt")
+			if got := z.GetRegisters().HL; got != uint16(len(tt.want)) {
+				t.Errorf("length = %d, want %d\n%s", got, len(tt.want), asm)
+			}
+			got := make([]byte, len(tt.want))
+			for i := range got {
+				got[i] = z.GetMemory(buf + uint16(i))
+			}
+			if string(got) != tt.want {
+				t.Errorf("buffer = %q, want %q\n%s", got, tt.want, asm)
+			}
+		})
+	}
+}
+
+func TestLargeFunctionWarning(t *testing.T) {
+	small := newTestFunction("small", 1)
+	large := newTestFunction("large", 2)
+	u16 := &ir.BasicType{Kind: ir.TypeU16}
+	var body []ir.Instruction
+	for i := 0; i < 100; i++ {
+		body = append(body,
+			ir.Instruction{Op: ir.OpLoadConst, Dest: 2, Imm: int64(i * 257), Type: u16},
+			ir.Instruction{Op: ir.OpStoreVar, Src1: 2, Symbol: "counter", Type: u16})
+	}
+	large.Instructions = append(body, large.Instructions...)
+	large.NextReg = 3
+
+	module := &ir.Module{
+		Name:      "test",
+		Functions: []*ir.Function{small, large},
+		Globals:   []ir.Global{{Name: "counter", Type: u16}},
+	}
+	asm, err := NewZ80Backend(&BackendOptions{OptimizationLevel: 2}).Generate(module)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	sizes, err := MeasureFunctions(asm)
+	if err != nil {
+		t.Fatalf("MeasureFunctions failed: %v\n%s", err, asm)
+	}
+	if len(sizes) != 2 {
+		t.Fatalf("measured %v, want small and large", sizes)
+	}
+	for _, size := range sizes {
+		if size.Bytes == 0 {
+			t.Errorf("function %s measured as empty", size.Name)
+		}
+	}
+
+	got := LargeFunctions(sizes, 256)
+	if len(got) != 1 || got[0].Name != "large" || got[0].Bytes <= 256 {
+		t.Errorf("LargeFunctions(%v, 256) = %v, want only large", sizes, got)
+	}
+}
+
+func TestEnumArrayStride(t *testing.T) {
+	rgb := map[string]int{"Red": 0, "Green": 1, "Blue": 2}
+	tests := []struct {
+		name    string
+		backing ir.TypeKind
+		want    uint16
+	}{
+		{"u8-backed", ir.TypeU8, 0x02},
+		{"u16-backed", ir.TypeU16, 0x0102},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			color := &ir.EnumType{Name: "Color", Variants: rgb, Backing: tt.backing}
+			colors := &ir.ArrayType{Element: color, Length: 8}
+
+			// return colors[3]
+			fn := ir.NewFunction("pick", color)
+			fn.IsSMCDefault = false
+			fn.IsSMCEnabled = false
+			fn.Instructions = []ir.Instruction{
+				{Op: ir.OpLoadAddr, Dest: 1, Symbol: "colors", Type: colors},
+				{Op: ir.OpLoadConst, Dest: 2, Imm: 3, Type: color.BackingType()},
+				{Op: ir.OpLoadIndex, Dest: 3, Src1: 1, Src2: 2, Type: color},
+				{Op: ir.OpReturn, Src1: 3},
+			}
+			fn.NextReg = 4
+			module := &ir.Module{
+				Name:      "test",
+				Functions: []*ir.Function{fn},
+				Globals: []ir.Global{
+					{Name: "colors", Type: colors},
+					{Name: "after", Type: color},
+				},
+			}
+
+			asm := generateZ80(t, module, func(g *Z80Generator) {
+				g.usePhysicalRegs = false
+				g.localVarBase = 0xE000 // Keep registers off the data at $F000
+			})
+			result := assembleZ80(t, asm)
+			base := result.Symbols["COLORS"]
+			if got, want := int(result.Symbols["AFTER"]-base), 8*color.Size(); got != want {
+				t.Errorf("[Color; 8] occupies %d bytes, want %d:\n%s", got, want, asm)
+			}
+
+			// Elements 3 and 4 differ in every byte, so a wrong stride or
+			// width shows up in the loaded value
+			data := []byte{0x02, 0x01, 0x00, 0x00}
+			offset := int(base-result.Origin) + 3*color.Size()
+			copy(result.Binary[offset:], data)
+
+			regs := execZ80(t, result, "pick").GetRegisters()
+			got := uint16(regs.A)
+			if color.Size() == 2 {
+				got = regs.HL
+			}
+			if got != tt.want {
+				t.Errorf("colors[3] = $%04X, want $%04X\n%s", got, tt.want, asm)
+			}
+		})
+	}
+}
+
+func TestStoreIndex(t *testing.T) {
+	tests := []struct {
+		name  string
+		kind  ir.TypeKind
+		value int64
+	}{
+		{"u8", ir.TypeU8, 0xA5},
+		{"u16", ir.TypeU16, 0x1234},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			elem := &ir.BasicType{Kind: tt.kind}
+			values := &ir.ArrayType{Element: elem, Length: 4}
+
+			// values[2] = value
+			fn := ir.NewFunction("put", &ir.BasicType{Kind: ir.TypeVoid})
+			fn.IsSMCDefault = false
+			fn.IsSMCEnabled = false
+			fn.Instructions = []ir.Instruction{
+				{Op: ir.OpLoadAddr, Dest: 1, Symbol: "values", Type: values},
+				{Op: ir.OpLoadConst, Dest: 2, Imm: 2, Type: elem},
+				{Op: ir.OpLoadConst, Dest: 3, Imm: tt.value, Type: elem},
+				{Op: ir.OpStoreIndex, Src1: 1, Src2: 2, Src3: 3, Type: elem},
+				{Op: ir.OpReturn},
+			}
+			fn.NextReg = 4
+			module := &ir.Module{
+				Name:      "test",
+				Functions: []*ir.Function{fn},
+				Globals:   []ir.Global{{Name: "values", Type: values}},
+			}
+
+			asm := generateZ80(t, module, func(g *Z80Generator) {
+				g.usePhysicalRegs = false
+				g.localVarBase = 0xE000
+			})
+			result := assembleZ80(t, asm)
+			z := execZ80(t, result, "put")
+
+			// Only the bytes of element 2 change
+			base := result.Symbols["VALUES"]
+			size := uint16(elem.Size())
+			want := make([]byte, 4*size)
+			want[2*size] = byte(tt.value)
+			if size == 2 {
+				want[2*size+1] = byte(tt.value >> 8)
+			}
+			for i := range want {
+				if got := z.GetMemory(base + uint16(i)); got != want[i] {
+					t.Errorf("values byte %d = $%02X, want $%02X\n%s", i, got, want[i], asm)
+				}
+			}
+		})
+	}
+}
+
+func TestRotate(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	u16 := &ir.BasicType{Kind: ir.TypeU16}
+
+	// Returns rotl/rotr(value, count), with the count in a register unless
+	// it is constant
+	rotateFunction := func(op ir.Opcode, value, count int64, constant bool, typ ir.Type) *ir.Function {
+		fn := ir.NewFunction("rot", typ)
+		fn.IsSMCDefault = false
+		fn.IsSMCEnabled = false
+		rot := ir.Instruction{Op: op, Dest: 3, Src1: 1, Type: typ}
+		if constant {
+			rot.Imm = count
+		} else {
+			rot.Src2 = 2
+		}
+		fn.Instructions = []ir.Instruction{
+			{Op: ir.OpLoadConst, Dest: 1, Imm: value, Type: typ},
+			{Op: ir.OpLoadConst, Dest: 2, Imm: count, Type: u8},
+			rot,
+			{Op: ir.OpReturn, Src1: 3},
+		}
+		fn.NextReg = 4
+		return fn
+	}
+
+	rotl := func(value, count int64, width uint) uint16 {
+		mask := int64(1)<<width - 1
+		n := uint(count) % width
+		return uint16((value<<n | value>>(width-n)) & mask)
+	}
+
+	for _, tt := range []struct {
+		typ   ir.Type
+		value int64
+		width uint
+	}{{u8, 0x96, 8}, {u16, 0x8D3A, 16}} {
+		for _, constant := range []bool{true, false} {
+			for _, op := range []ir.Opcode{ir.OpRotl, ir.OpRotr} {
+				counts := []int64{0, 17}
+				for n := int64(1); n < int64(tt.width); n++ {
+					counts = append(counts, n)
+				}
+				for _, count := range counts {
+					if constant && count == 0 {
+						continue // Folded away by the analyzer
+					}
+					want := rotl(tt.value, count, tt.width)
+					if op == ir.OpRotr {
+						want = rotl(tt.value, int64(tt.width)-count%int64(tt.width), tt.width)
+					}
+					name := fmt.Sprintf("%s %s %d constant=%v", tt.typ, op, count, constant)
+					t.Run(name, func(t *testing.T) {
+						fn := rotateFunction(op, tt.value, count, constant, tt.typ)
+						asm := generateZ80(t, &ir.Module{Name: "test", Functions: []*ir.Function{fn}}, func(g *Z80Generator) {
+							g.usePhysicalRegs = false
+						})
+						if got := runZ80(t, asm, "rot").GetRegisters().HL; got != want {
+							t.Errorf("result = $%04X, want $%04X\n%s", got, want, asm)
+						}
+					})
+				}
+			}
+		}
+	}
+}
+
+func TestComparison8(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	i8 := &ir.BasicType{Kind: ir.TypeI8}
+
+	tests := []struct {
+		name string
+		op   ir.Opcode
+		typ  ir.Type
+		a, b int64
+		want uint8
+	}{
+		{"u8 0x80 > 0x7F", ir.OpGt, u8, 0x80, 0x7F, 1},
+		{"u8 0x7F < 0x80", ir.OpLt, u8, 0x7F, 0x80, 1},
+		{"u8 0xFF <= 0x01", ir.OpLe, u8, 0xFF, 0x01, 0},
+		{"u8 0x42 == 0x42", ir.OpEq, u8, 0x42, 0x42, 1},
+		{"u8 0x42 != 0x43", ir.OpNe, u8, 0x42, 0x43, 1},
+		{"i8 -1 < 1", ir.OpLt, i8, -1, 1, 1},
+		{"i8 -128 < 127", ir.OpLt, i8, -128, 127, 1},
+		{"i8 127 > -128", ir.OpGt, i8, 127, -128, 1},
+		{"i8 -6 >= -5", ir.OpGe, i8, -6, -5, 0},
+		{"i8 -5 <= -5", ir.OpLe, i8, -5, -5, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := ir.NewFunction("compare", &ir.BasicType{Kind: ir.TypeU8})
+			fn.IsSMCDefault = false
+			fn.IsSMCEnabled = false
+			fn.Instructions = []ir.Instruction{
+				{Op: ir.OpLoadConst, Dest: 1, Imm: int64(uint8(tt.a)), Type: tt.typ},
+				{Op: ir.OpLoadConst, Dest: 2, Imm: int64(uint8(tt.b)), Type: tt.typ},
+				{Op: tt.op, Dest: 3, Src1: 1, Src2: 2, Type: tt.typ},
+				{Op: ir.OpReturn, Src1: 3},
+			}
+			fn.NextReg = 4
+
+			asm := generateZ80(t, &ir.Module{Name: "test", Functions: []*ir.Function{fn}}, func(g *Z80Generator) {
+				g.usePhysicalRegs = false
+			})
+			if strings.Contains(asm, "SBC HL, DE") {
+				t.Errorf("8-bit comparison widened to HL:\n%s", asm)
+			}
+			z := runZ80(t, asm, "compare")
+			if got := uint8(z.GetRegisters().HL); got != tt.want {
+				t.Errorf("got %d, want %d\n%s", got, tt.want, asm)
+			}
+		})
+	}
+}
+
+func TestSpillElimination(t *testing.T) {
+	u16 := &ir.BasicType{Kind: ir.TypeU16}
+	fn := ir.NewFunction("calc", u16)
+	fn.IsSMCDefault = false
+	fn.IsSMCEnabled = false
+	fn.Instructions = []ir.Instruction{
+		{Op: ir.OpLoadConst, Dest: 5, Imm: 99, Type: u16}, // Never read
+		{Op: ir.OpLoadConst, Dest: 1, Imm: 1000, Type: u16},
+		{Op: ir.OpLoadConst, Dest: 2, Imm: 300, Type: u16},
+		{Op: ir.OpAdd, Dest: 3, Src1: 1, Src2: 2, Type: u16},
+		{Op: ir.OpSub, Dest: 4, Src1: 3, Src2: 1, Type: u16},
+		{Op: ir.OpReturn, Src1: 4},
+	}
+	fn.NextReg = 6
+
+	asm := generateZ80(t, &ir.Module{Name: "test", Functions: []*ir.Function{fn}}, func(g *Z80Generator) {
+		g.usePhysicalRegs = false
+	})
+	// Only r1 is read after the next instruction; r5 is never read
+	for reg := 1; reg <= 5; reg++ {
+		stored := strings.Contains(asm, fmt.Sprintf("Virtual register %d to memory", reg))
+		if stored != (reg == 1) {
+			t.Errorf("register %d stored = %v, want %v\n%s", reg, stored, reg == 1, asm)
+		}
+	}
+	if got := runZ80(t, asm, "calc").GetRegisters().HL; got != 300 {
+		t.Errorf("got %d, want 300\n%s", got, asm)
+	}
+}
+
+func TestLoopInvariantTiming(t *testing.T) {
+	// total += scale * 3, ten times round a DJNZ loop
+	build := func() *ir.Module {
+		u8 := &ir.BasicType{Kind: ir.TypeU8}
+		fn := ir.NewFunction("sum", u8)
+		fn.IsSMCDefault = false
+		fn.IsSMCEnabled = false
+		fn.Instructions = []ir.Instruction{
+			{Op: ir.OpLoadConst, Dest: 1, Imm: 10, Type: u8, Hint: ir.RegHintB},
+			{Op: ir.OpLoadConst, Dest: 2, Imm: 5, Type: u8},
+			{Op: ir.OpLabel, Label: "sum_loop"},
+			{Op: ir.OpLoadConst, Dest: 3, Imm: 3, Type: u8},
+			{Op: ir.OpMul, Dest: 4, Src1: 2, Src2: 3, Type: u8},
+			{Op: ir.OpLoadVar, Dest: 5, Symbol: "total", Type: u8},
+			{Op: ir.OpAdd, Dest: 6, Src1: 5, Src2: 4, Type: u8},
+			{Op: ir.OpStoreVar, Src1: 6, Symbol: "total", Type: u8},
+			{Op: ir.OpDJNZ, Src1: 1, Label: "sum_loop"},
+			{Op: ir.OpLoadVar, Dest: 7, Symbol: "total", Type: u8},
+			{Op: ir.OpReturn, Src1: 7},
+		}
+		fn.NextReg = 8
+		return &ir.Module{
+			Name:      "test",
+			Functions: []*ir.Function{fn},
+			Globals:   []ir.Global{{Name: "total", Type: u8}},
+		}
+	}
+	run := func(module *ir.Module) int {
+		asm := generateZ80(t, module, func(g *Z80Generator) {
+			g.usePhysicalRegs = false
+		})
+		z := runZ80(t, asm, "sum")
+		if got := z.GetRegisters().HL & 0xFF; got != 150 {
+			t.Fatalf("sum = %d, want 150\n%s", got, asm)
+		}
+		return z.GetCycles()
+	}
+
+	before := run(build())
+	hoisted := build()
+	if changed, err := optimizer.NewLoopInvariantPass().Run(hoisted); err != nil || !changed {
+		t.Fatalf("nothing hoisted (err %v)", err)
+	}
+	after := run(hoisted)
+	if after >= before {
+		t.Errorf("%d T-states with the multiply hoisted, %d without", after, before)
+	}
+}
+
+func TestIncludeBinData(t *testing.T) {
+	data := []byte{0x00, 0x3C, 0x42, 0xFF}
+	module := &ir.Module{
+		Name:      "test",
+		Functions: []*ir.Function{newTestFunction("main", 0)},
+		Globals: []ir.Global{{
+			Name:     "sprites",
+			Type:     &ir.ArrayType{Element: &ir.BasicType{Kind: ir.TypeU8}, Length: len(data) + 2},
+			Init:     data,
+			Constant: true,
+			Align:    256,
+		}},
+	}
+
+	asm := generateZ80(t, module, nil)
+	if !strings.Contains(asm, "ALIGN 256\nsprites:") {
+		t.Errorf("embedded file not aligned:\n%s", asm)
+	}
+
+	result := assembleZ80(t, asm)
+	addr, ok := result.Symbols["SPRITES"]
+	if !ok {
+		t.Fatalf("sprites not defined:\n%s", asm)
+	}
+	if addr%256 != 0 {
+		t.Errorf("sprites at $%04X, want a page boundary", addr)
+	}
+	offset := int(addr - result.Origin)
+	want := append([]byte{byte(len(data)), 0}, data...)
+	if got := result.Binary[offset : offset+len(want)]; !bytes.Equal(got, want) {
+		t.Errorf("sprites = % X, want % X", got, want)
+	}
+}
+
+func TestCPMTarget(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	helper := newTestFunction("helper", 0)
+	main := ir.NewFunction("test.main", &ir.BasicType{Kind: ir.TypeVoid})
+	main.IsSMCDefault = false
+	main.IsSMCEnabled = false
+	main.NextReg = 4
+	main.Instructions = []ir.Instruction{
+		{Op: ir.OpLoadConst, Dest: 1, Imm: 'O', Type: u8},
+		{Op: ir.OpPrint, Src1: 1},
+		{Op: ir.OpLoadConst, Dest: 2, Imm: 'K', Type: u8},
+		{Op: ir.OpPrint, Src1: 2},
+		{Op: ir.OpLoadConst, Dest: 3, Imm: 42, Type: u8},
+		{Op: ir.OpPrintU8, Src1: 3},
+		{Op: ir.OpReturn},
+	}
+	module := &ir.Module{Name: "test", Functions: []*ir.Function{helper, main}}
+
+	asm := generateZ80(t, module, func(g *Z80Generator) {
+		g.SetTargetPlatform("cpm")
+		g.usePhysicalRegs = false
+	})
+	if !strings.Contains(asm, "ORG $0100\n    CALL test_main\n    LD C, 0") {
+		t.Fatalf("CP/M code should start at $0100 with a call to main and a BDOS exit:\n%s", asm)
+	}
+
+	// Run it as a .COM file under the BDOS
+	result := assembleZ80(t, asm)
+	z := emulator.NewRemogattoZ80()
+	z.LoadMemory(result.Origin, result.Binary)
+	var out bytes.Buffer
+	z.EnableCPM(t.TempDir(), strings.NewReader(""), &out)
+	z.SetPC(emulator.CPMLoadAddr)
+	if err := z.Run(); err != nil {
+		t.Fatalf("run failed: %v\n%s", err, asm)
+	}
+	if out.String() != "OK00042" {
+		t.Errorf("output = %q, want OK00042\n%s", out.String(), asm)
+	}
+}
+
+func TestTaggedUnionCase(t *testing.T) {
+	u8 := &ast.PrimitiveType{Name: "u8"}
+	id := func(name string) *ast.Identifier { return &ast.Identifier{Name: name} }
+	num := func(n int64) *ast.NumberLiteral { return &ast.NumberLiteral{Value: n} }
+	variant := func(name string) *ast.FieldExpr { return &ast.FieldExpr{Object: id("Shape"), Field: name} }
+	returns := func(value ast.Expression) *ast.BlockStmt {
+		return &ast.BlockStmt{Statements: []ast.Statement{&ast.ReturnStmt{Value: value}}}
+	}
+
+	// fun name() -> u8 {
+	//     let s: Shape = value;
+	//     case s {
+	//         Shape.Rect(w, h) => { return w + h; }
+	//         Shape.Circle(r) => { return r; }
+	//         Shape.Empty => { return 9; }
+	//     }
+	//     return 0;
+	// }
+	area := func(name string, value ast.Expression) *ast.FunctionDecl {
+		return &ast.FunctionDecl{
+			Name:       name,
+			ReturnType: u8,
+			Body: &ast.BlockStmt{Statements: []ast.Statement{
+				&ast.VarDecl{Name: "s", Type: &ast.TypeIdentifier{Name: "Shape"}, Value: value},
+				&ast.CaseStmt{Value: id("s"), Arms: []ast.CaseArm{
+					{
+						Pattern: &ast.EnumPattern{EnumType: "Shape", Variant: "Rect", Bindings: []string{"w", "h"}},
+						Body:    returns(&ast.BinaryExpr{Left: id("w"), Operator: "+", Right: id("h")}),
+					},
+					{Pattern: &ast.EnumPattern{EnumType: "Shape", Variant: "Circle", Bindings: []string{"r"}}, Body: returns(id("r"))},
+					{Pattern: &ast.EnumPattern{EnumType: "Shape", Variant: "Empty"}, Body: returns(num(9))},
+				}},
+				&ast.ReturnStmt{Value: num(0)},
+			}},
+		}
+	}
+
+	// enum Shape { Empty, Circle(u8), Rect(u8, u8) }
+	file := &ast.File{
+		Name: "shapes.minz",
+		Declarations: []ast.Declaration{
+			&ast.EnumDecl{
+				Name:     "Shape",
+				Variants: []string{"Empty", "Circle", "Rect"},
+				Payloads: map[string][]ast.Type{"Circle": {u8}, "Rect": {u8, u8}},
+			},
+			area("rect", &ast.CallExpr{Function: variant("Rect"), Arguments: []ast.Expression{num(3), num(4)}}),
+			area("circle", &ast.CallExpr{Function: variant("Circle"), Arguments: []ast.Expression{num(5)}}),
+			area("empty", variant("Empty")),
+		},
+	}
+	module, err := semantic.NewAnalyzer().Analyze(file)
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	asm := generateZ80(t, module, func(g *Z80Generator) {
+		g.usePhysicalRegs = false
+	})
+	// Values are built in their buffers, and each case tests the tag with
+	// CP n / JP Z before loading the payload the arm binds
+	for _, want := range []string{
+		"shapes.Shape_Rect_1:\n    DS 3",
+		"; Shape.Rect payload 2\n    LD HL, ($F004)    ; Virtual register 2 from memory\n    LD DE, 2\n    ADD HL, DE",
+		"; Shape tag\n    ; Register 6 already in HL\n    LD A, (HL)",
+		"CP 2\n    JP Z, shapes_rect_case_arm_0_",
+		"CP 1\n    JP Z, shapes_rect_case_arm_1_",
+		"CP 0\n    JP Z, shapes_rect_case_arm_2_",
+	} {
+		if !strings.Contains(asm, want) {
+			t.Errorf("generated code missing %q:\n%s", want, asm)
+		}
+	}
+	assembleZ80(t, asm)
+}
+
+func TestArithmeticRuntime(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	i8 := &ir.BasicType{Kind: ir.TypeI8}
+	u16 := &ir.BasicType{Kind: ir.TypeU16}
+	i16 := &ir.BasicType{Kind: ir.TypeI16}
+	// No multiplier below has a shift-and-add shortcut
+	arith := func(op ir.Opcode, typ ir.Type, a, b int64) *ir.Function {
+		fn := ir.NewFunction("arith", typ)
+		fn.IsSMCDefault = false
+		fn.IsSMCEnabled = false
+		fn.Instructions = []ir.Instruction{
+			{Op: ir.OpLoadConst, Dest: 1, Imm: a, Type: typ},
+			{Op: ir.OpLoadConst, Dest: 2, Imm: b, Type: typ},
+			{Op: op, Dest: 3, Src1: 1, Src2: 2, Type: typ},
+			{Op: ir.OpReturn, Src1: 3},
+		}
+		fn.NextReg = 4
+		return fn
+	}
+
+	tests := []struct {
+		op   ir.Opcode
+		typ  ir.Type
+		a, b int64
+		want uint16
+		mask uint16 // Result bits to compare
+	}{
+		{ir.OpMul, u8, 13, 11, 143, 0xFF},
+		{ir.OpMul, u8, 255, 0, 0, 0xFF},
+		{ir.OpMul, i8, 11, 0xFD, 0xFFDF, 0xFF}, // 11 * -3
+		{ir.OpMul, u16, 300, 217, 65100, 0xFFFF},
+		{ir.OpMul, i16, 0xFED4, 11, 0xF31C, 0xFFFF}, // -300 * 11
+		{ir.OpDiv, u8, 200, 7, 28, 0xFF},
+		{ir.OpDiv, u8, 250, 200, 1, 0xFF},
+		{ir.OpDiv, u8, 9, 0, 0, 0xFF},
+		{ir.OpMod, u8, 200, 7, 4, 0xFF},
+		{ir.OpMod, u8, 250, 130, 120, 0xFF},
+		{ir.OpDiv, i8, 0x9C, 7, 0xF2, 0xFF},   // -100 / 7 = -14
+		{ir.OpMod, i8, 0x9C, 7, 0xFE, 0xFF},   // -100 % 7 = -2
+		{ir.OpDiv, i8, 100, 0xF9, 0xF2, 0xFF}, // 100 / -7 = -14
+		{ir.OpDiv, u16, 60000, 7, 8571, 0xFFFF},
+		{ir.OpMod, u16, 60000, 7, 3, 0xFFFF},
+		{ir.OpDiv, u16, 65535, 40000, 1, 0xFFFF},
+		{ir.OpMod, u16, 65535, 40000, 25535, 0xFFFF},
+		{ir.OpDiv, u16, 1234, 0, 0, 0xFFFF},
+		{ir.OpDiv, i16, 0x8AD0, 7, 0xEF43, 0xFFFF},    // -30000 / 7 = -4285
+		{ir.OpMod, i16, 0x8AD0, 7, 0xFFFB, 0xFFFF},    // -30000 % 7 = -5
+		{ir.OpDiv, i16, 0x8AD0, 0xFFF9, 4285, 0xFFFF}, // -30000 / -7
+	}
+	for _, inline := range []bool{false, true} {
+		for _, tt := range tests {
+			name := fmt.Sprintf("%v/%s %d %s %d", inline, tt.typ, tt.a, tt.op, tt.b)
+			t.Run(name, func(t *testing.T) {
+				module := &ir.Module{Name: "test", Functions: []*ir.Function{arith(tt.op, tt.typ, tt.a, tt.b)}}
+				asm := generateZ80(t, module, func(g *Z80Generator) {
+					g.usePhysicalRegs = false
+					g.SetInlineArithmetic(inline)
+				})
+				calls := strings.Contains(asm, "    CALL ")
+				if wantCalls := !inline || tt.typ != u8; calls != wantCalls {
+					t.Errorf("runtime call = %v, want %v\n%s", calls, wantCalls, asm)
+				}
+
+				if got := runZ80(t, asm, "arith").GetRegisters().HL & tt.mask; got != tt.want&tt.mask {
+					t.Errorf("got $%04X, want $%04X\n%s", got, tt.want&tt.mask, asm)
+				}
+			})
+		}
+	}
+
+	// Each routine is emitted once, however often it is called
+	fn := arith(ir.OpMul, u16, 300, 217)
+	fn.Instructions = append(fn.Instructions[:3:3],
+		ir.Instruction{Op: ir.OpMul, Dest: 3, Src1: 3, Src2: 2, Type: u16},
+		ir.Instruction{Op: ir.OpMod, Dest: 3, Src1: 3, Src2: 1, Type: u16},
+		ir.Instruction{Op: ir.OpReturn, Src1: 3})
+	asm := generateZ80(t, &ir.Module{Name: "test", Functions: []*ir.Function{fn}}, nil)
+	if strings.Count(asm, "\nmul16:") != 1 || strings.Count(asm, "\ndiv16:") != 1 || strings.Contains(asm, "sdiv16:") {
+		t.Errorf("want one mul16, div16 and mod16 routine:\n%s", asm)
+	}
+}
+
+func TestInlineAsmOperands(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	u16 := &ir.BasicType{Kind: ir.TypeU16}
+	// r6 = 7, live across the asm; r3, r4 = asm(r1 = 5, r2 = 300)
+	asmFunc := func(ret ir.Instruction) *ir.Function {
+		fn := ir.NewFunction("inline", u16)
+		fn.IsSMCDefault = false
+		fn.IsSMCEnabled = false
+		fn.Instructions = []ir.Instruction{
+			{Op: ir.OpLoadConst, Dest: 6, Imm: 7, Type: u8},
+			{Op: ir.OpLoadConst, Dest: 1, Imm: 5, Type: u8},
+			{Op: ir.OpLoadConst, Dest: 2, Imm: 300, Type: u16},
+			{
+				Op:      ir.OpAsm,
+				AsmCode: "LD A, {2}\nADD A, A\nEX DE, HL\nINC DE\nLD C, 0",
+				AsmOperands: []ir.AsmOperand{
+					{Reg: 3, Constraint: "A", Output: true, Type: u8},
+					{Reg: 4, Constraint: "DE", Output: true, Type: u16},
+					{Reg: 1, Constraint: "r", Type: u8},
+					{Reg: 2, Constraint: "HL", Type: u16},
+				},
+				AsmClobbers: []string{"C"},
+			},
+			ret,
+		}
+		fn.NextReg = 8
+		return fn
+	}
+
+	tests := []struct {
+		name string
+		ret  []ir.Instruction
+		want uint16
+	}{
+		{"8-bit output", []ir.Instruction{{Op: ir.OpReturn, Src1: 3}}, 10},
+		{"16-bit output", []ir.Instruction{{Op: ir.OpReturn, Src1: 4}}, 301},
+		{"value across asm", []ir.Instruction{
+			{Op: ir.OpAdd, Dest: 7, Src1: 4, Src2: 6, Type: u16},
+			{Op: ir.OpReturn, Src1: 7},
+		}, 308},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := asmFunc(tt.ret[0])
+			fn.Instructions = append(fn.Instructions[:4], tt.ret...)
+			module := &ir.Module{Name: "test", Functions: []*ir.Function{fn}}
+			asm := generateZ80(t, module, func(g *Z80Generator) { g.usePhysicalRegs = false })
+			if !strings.Contains(asm, "    LD A, B\n") {
+				t.Errorf("\"r\" operand not bound to B:\n%s", asm)
+			}
+			mask := uint16(0xFFFF)
+			if tt.want < 0x100 {
+				mask = 0xFF
+			}
+			if got := runZ80(t, asm, "inline").GetRegisters().HL & mask; got != tt.want {
+				t.Errorf("got %d, want %d\n%s", got, tt.want, asm)
+			}
+		})
+	}
+
+	// The allocator keeps values live across the asm out of its registers
+	fn := asmFunc(ir.Instruction{Op: ir.OpReturn, Src1: 6})
+	alloc := NewZ80RegisterAllocator()
+	alloc.AllocateFunction(fn)
+	if reg, ok := alloc.GetAllocation(6); ok {
+		for _, used := range asmUsedRegisters(&fn.Instructions[3]) {
+			if regsOverlap(reg, used) {
+				t.Errorf("r6 allocated to %d, which the asm changes", reg)
+			}
+		}
+	}
+
+	// Bad constraints are reported
+	for _, op := range []ir.AsmOperand{
+		{Reg: 1, Constraint: "Q", Type: u8},
+		{Reg: 1, Constraint: "HL", Type: u8},
+		{Reg: 1, Constraint: "IX", Type: u16},
+	} {
+		fn := asmFunc(ir.Instruction{Op: ir.OpReturn, Src1: 1})
+		fn.Instructions[3].AsmOperands = []ir.AsmOperand{op}
+		var buf bytes.Buffer
+		if err := NewZ80Generator(&buf).Generate(&ir.Module{Name: "test", Functions: []*ir.Function{fn}}); err == nil {
+			t.Errorf("constraint %q on %s accepted", op.Constraint, op.Type)
+		}
+	}
+}
+
+func TestIM2Handler(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	handler := ir.NewFunction("on_frame", &ir.BasicType{Kind: ir.TypeVoid})
+	handler.IsSMCDefault = true
+	handler.IsInterrupt = true
+	handler.InterruptMode = 2
+	handler.Instructions = []ir.Instruction{
+		{Op: ir.OpLoadVar, Dest: 1, Symbol: "ticks", Type: u8},
+		{Op: ir.OpInc, Dest: 2, Src1: 1, Type: u8},
+		{Op: ir.OpStoreVar, Src1: 2, Symbol: "ticks", Type: u8},
+		{Op: ir.OpReturn},
+	}
+	handler.NextReg = 3
+
+	// main: HALT until the frame interrupt, then return ticks
+	main := ir.NewFunction("main", u8)
+	main.IsSMCDefault = true
+	main.Instructions = []ir.Instruction{
+		{Op: ir.OpAsm, AsmCode: "HALT"},
+		{Op: ir.OpLoadVar, Dest: 1, Symbol: "ticks", Type: u8},
+		{Op: ir.OpReturn, Src1: 1},
+	}
+	main.NextReg = 2
+
+	module := &ir.Module{
+		Name:      "test",
+		Functions: []*ir.Function{handler, main},
+		Globals:   []ir.Global{{Name: "ticks", Type: u8}},
+	}
+	asm := generateZ80(t, module, func(g *Z80Generator) {
+		g.SetCodeOrigin(0x6000)
+	})
+	for _, want := range []string{"ORG $6000", "LD I, A\n    IM 2\n    EI", "EXX\n    EX AF, AF'", "EI\n    RETI", "JP on_frame"} {
+		if !strings.Contains(asm, want) {
+			t.Errorf("output is missing %q:\n%s", want, asm)
+		}
+	}
+
+	result := assembleZ80(t, asm)
+	table, entry := result.Symbols[strings.ToUpper(IM2Table)], result.Symbols[strings.ToUpper(IM2Entry)]
+	if table%256 != 0 || table < 0x6000 {
+		t.Fatalf("vector table at $%04X, want a page boundary after the code", table)
+	}
+	if page := table/256 + 1; entry != page*0x101 {
+		t.Errorf("handler entry at $%04X, want $%04X", entry, page*0x101)
+	}
+
+	z := emulator.NewRemogattoZ80()
+	z.EnableAccurateTiming()
+	// The data section comes first, so place each line at its own address
+	for _, line := range result.Listing {
+		z.LoadMemory(line.Address, line.Bytes)
+	}
+	z.SetSP(0xFEFE)
+	z.SetMemory(0xFEFE, 0)
+	z.SetMemory(0xFEFF, 0)
+	z.SetPC(result.Symbols["MAIN"])
+	if err := z.Run(); err != nil {
+		t.Fatalf("execution failed: %v\n%s", err, z.DumpState())
+	}
+	if got := z.GetRegisters().HL & 0xFF; got != 1 {
+		t.Errorf("ticks = %d after one frame, want 1\n%s", got, asm)
+	}
+	if sp := z.GetSP(); sp != 0xFF00 {
+		t.Errorf("SP = $%04X after return, want $FF00: the handler unbalanced the stack", sp)
+	}
+}
+
+func TestRelaxBranches(t *testing.T) {
+	// A DJNZ and JRs back over 200 bytes, and a DJNZ in range
+	asm := "    ORG $8000\nloop:\n    LD B, 4\nnear:\n    NOP\n    DJNZ near\n" +
+		strings.Repeat("    NOP\n", 200) +
+		"    DJNZ loop\n    JR loop\n    JR NZ, loop\n    RET\n"
+
+	relaxed := relaxBranches(asm)
+	for _, want := range []string{"DJNZ near\n", "DEC B\n    JP NZ, loop ; DJNZ out of range", "JP loop ; JR out of range", "JP NZ, loop ; JR out of range"} {
+		if !strings.Contains(relaxed, want) {
+			t.Errorf("output is missing %q:\n%s", want, relaxed)
+		}
+	}
+	assembleZ80(t, relaxed)
+}
+
+func TestArithmetic24(t *testing.T) {
+	u24 := &ir.BasicType{Kind: ir.TypeU24}
+	i24 := &ir.BasicType{Kind: ir.TypeI24}
+	u16 := &ir.BasicType{Kind: ir.TypeU16}
+	result24 := func(z *emulator.RemogattoZ80) int64 {
+		regs := z.GetRegisters()
+		return int64(regs.A)<<16 | int64(regs.HL)
+	}
+
+	tests := []struct {
+		name string
+		op   ir.Opcode
+		typ  ir.Type
+		a, b int64
+		want int64
+	}{
+		{"u24 carry into high byte", ir.OpAdd, u24, 0x00FFFF, 0x000001, 0x010000},
+		{"u24 add", ir.OpAdd, u24, 0x123456, 0x0F0F0F, 0x214365},
+		{"u24 add wraps", ir.OpAdd, u24, 0xFFFFFF, 0x000002, 0x000001},
+		{"u24 borrow from high byte", ir.OpSub, u24, 0x010000, 0x000001, 0x00FFFF},
+		{"u24 sub", ir.OpSub, u24, 0x214365, 0x123456, 0x0F0F0F},
+		{"i24 sub below zero", ir.OpSub, i24, 5, 7, 0xFFFFFE},
+		// Comparisons that the low words alone would get wrong
+		{"u24 0x010000 > 0x00FFFF", ir.OpGt, u24, 0x010000, 0x00FFFF, 1},
+		{"u24 0x00FFFF < 0x010000", ir.OpLt, u24, 0x00FFFF, 0x010000, 1},
+		{"u24 0x800000 >= 0x7FFFFF", ir.OpGe, u24, 0x800000, 0x7FFFFF, 1},
+		{"u24 0x123456 <= 0x123456", ir.OpLe, u24, 0x123456, 0x123456, 1},
+		{"u24 0x123456 == 0x133456", ir.OpEq, u24, 0x123456, 0x133456, 0},
+		{"u24 0x123456 == 0x123456", ir.OpEq, u24, 0x123456, 0x123456, 1},
+		{"u24 0x123456 != 0x023456", ir.OpNe, u24, 0x123456, 0x023456, 1},
+		{"i24 -1 < 1", ir.OpLt, i24, 0xFFFFFF, 1, 1},
+		{"i24 -8388608 < 8388607", ir.OpLt, i24, 0x800000, 0x7FFFFF, 1},
+		{"i24 8388607 > -1", ir.OpGt, i24, 0x7FFFFF, 0xFFFFFF, 1},
+		{"i24 -65536 >= -65535", ir.OpGe, i24, 0xFF0000, 0xFF0001, 0},
+	}
+
+	for _, physical := range []bool{false, true} {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%v/%s", physical, tt.name), func(t *testing.T) {
+				returns := tt.typ
+				if tt.op != ir.OpAdd && tt.op != ir.OpSub {
+					returns = &ir.BasicType{Kind: ir.TypeBool}
+				}
+				fn := ir.NewFunction("wide", returns)
+				fn.IsSMCDefault = false
+				fn.IsSMCEnabled = false
+				fn.Instructions = []ir.Instruction{
+					{Op: ir.OpLoadConst, Dest: 1, Imm: tt.a, Type: tt.typ},
+					{Op: ir.OpLoadConst, Dest: 2, Imm: tt.b, Type: tt.typ},
+					{Op: tt.op, Dest: 3, Src1: 1, Src2: 2, Type: tt.typ},
+					{Op: ir.OpReturn, Src1: 3},
+				}
+				fn.NextReg = 4
+				asm := generateZ80(t, &ir.Module{Name: "test", Functions: []*ir.Function{fn}}, func(g *Z80Generator) {
+					g.usePhysicalRegs = physical
+				})
+				z := runZ80(t, asm, "wide")
+				got := int64(z.GetRegisters().HL)
+				if returns == tt.typ {
+					got = result24(z)
+				}
+				if got != tt.want {
+					t.Errorf("got $%06X, want $%06X\n%s", got, tt.want, asm)
+				}
+			})
+		}
+	}
+
+	t.Run("variables and calls", func(t *testing.T) {
+		// add(a, b) = a + b, passed in A:HL and C:DE; main adds a u16 to
+		// the result, zero-extended, through 24-bit locals
+		add := ir.NewFunction("add", u24)
+		add.IsSMCDefault = false
+		add.IsSMCEnabled = false
+		add.Params = []ir.Parameter{{Name: "a", Type: u24, Reg: 1}, {Name: "b", Type: u24, Reg: 2}}
+		add.Instructions = []ir.Instruction{
+			{Op: ir.OpLoadVar, Dest: 3, Symbol: "a"},
+			{Op: ir.OpLoadVar, Dest: 4, Symbol: "b"},
+			{Op: ir.OpAdd, Dest: 5, Src1: 3, Src2: 4, Type: u24},
+			{Op: ir.OpReturn, Src1: 5},
+		}
+		add.NextReg = 6
+
+		main := ir.NewFunction("main", u24)
+		main.IsSMCDefault = false
+		main.IsSMCEnabled = false
+		x := main.AddLocal("x", u24)
+		y := main.AddLocal("y", u24)
+		main.Instructions = []ir.Instruction{
+			{Op: ir.OpLoadConst, Dest: 3, Imm: 0x01FFFF, Type: u24},
+			{Op: ir.OpStoreVar, Dest: x, Src1: 3, Symbol: "x"},
+			{Op: ir.OpLoadConst, Dest: 4, Imm: 0x020001, Type: u24},
+			{Op: ir.OpStoreVar, Dest: y, Src1: 4, Symbol: "y"},
+			{Op: ir.OpLoadVar, Dest: 5, Symbol: "x"},
+			{Op: ir.OpLoadVar, Dest: 6, Symbol: "y"},
+			{Op: ir.OpCall, Dest: 7, Symbol: "add", Args: []ir.Register{5, 6}},
+			{Op: ir.OpLoadConst, Dest: 8, Imm: 0xFFFF, Type: u16},
+			{Op: ir.OpMove, Dest: 9, Src1: 8, Type: u16},
+			{Op: ir.OpAdd, Dest: 10, Src1: 7, Src2: 9, Type: u24},
+			{Op: ir.OpStoreVar, Dest: x, Src1: 10, Symbol: "x"},
+			{Op: ir.OpLoadVar, Dest: 11, Symbol: "x"},
+			{Op: ir.OpReturn, Src1: 11},
+		}
+		main.NextReg = 12
+
+		for _, physical := range []bool{false, true} {
+			module := &ir.Module{Name: "test", Functions: []*ir.Function{add, main}}
+			asm := generateZ80(t, module, func(g *Z80Generator) {
+				g.usePhysicalRegs = physical
+			})
+			// 0x01FFFF + 0x020001 + 0xFFFF
+			if got := result24(runZ80(t, asm, "main")); got != 0x04FFFF {
+				t.Errorf("physical %v: got $%06X, want $04FFFF\n%s", physical, got, asm)
+			}
+		}
+	})
+
+	t.Run("TRUE SMC anchors", func(t *testing.T) {
+		// The caller patches both anchors of the parameter; the second
+		// load reuses them
+		twice := ir.NewFunction("twice", u24)
+		twice.IsSMCDefault = true
+		twice.UsesTrueSMC = true
+		twice.Params = []ir.Parameter{{Name: "n", Type: u24, Reg: 1}}
+		twice.Instructions = []ir.Instruction{
+			{Op: ir.OpTrueSMCLoad, Dest: 2, Symbol: "n$imm0", Type: u24},
+			{Op: ir.OpTrueSMCLoad, Dest: 3, Symbol: "n$imm0", Type: u24},
+			{Op: ir.OpAdd, Dest: 4, Src1: 2, Src2: 3, Type: u24},
+			{Op: ir.OpReturn, Src1: 4},
+		}
+		twice.NextReg = 5
+
+		main := ir.NewFunction("main", u24)
+		main.IsSMCDefault = false
+		main.IsSMCEnabled = false
+		main.Instructions = []ir.Instruction{
+			{Op: ir.OpLoadConst, Dest: 1, Imm: 0x345678, Type: u24},
+			{Op: ir.OpCall, Dest: 2, Symbol: "twice", Args: []ir.Register{1}},
+			{Op: ir.OpReturn, Src1: 2},
+		}
+		main.NextReg = 3
+
+		// z80asm does not take $ in symbols, so the code is not run
+		module := &ir.Module{Name: "test", Functions: []*ir.Function{twice, main}}
+		asm := generateZ80(t, module, func(g *Z80Generator) {
+			g.usePhysicalRegs = false
+		})
+		for _, want := range []string{
+			"LD A, 0        ; n high byte anchor",
+			"LD HL, 0       ; n low 16 bits anchor",
+			"LD HL, (n$immLO0) ; Reuse from anchor",
+			"LD A, (n$immHI0)",
+			"LD (n$immLO0), HL       ; Patch n low 16 bits",
+			"LD (n$immHI0), A        ; Patch n high byte",
+			"DW n$immLO0",
+			"DW n$immHI0",
+		} {
+			if !strings.Contains(asm, want) {
+				t.Errorf("missing %q:\n%s", want, asm)
+			}
+		}
+		if strings.Contains(asm, "TODO") {
+			t.Errorf("24-bit anchors left a TODO:\n%s", asm)
+		}
+	})
+}
+
+func TestMutualTailCalls(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	u16 := &ir.BasicType{Kind: ir.TypeU16}
+
+	// ping(n) returns 1 if n is even: n == 0 ? 1 : pong(n - 1), and pong
+	// the other way round. Deep enough that a CALL per step would run
+	// the stack into the code.
+	state := func(name, other string, base int64) *ir.Function {
+		fn := ir.NewFunction(name, u8)
+		fn.IsSMCDefault = false
+		fn.IsSMCEnabled = false
+		fn.Params = []ir.Parameter{{Name: "n", Type: u16, Reg: 1}}
+		fn.Instructions = []ir.Instruction{
+			{Op: ir.OpLoadConst, Dest: 3, Imm: 0, Type: u16},
+			{Op: ir.OpEq, Dest: 4, Src1: 1, Src2: 3, Type: u16},
+			{Op: ir.OpJumpIfNot, Src1: 4, Label: name + "_step"},
+			{Op: ir.OpLoadConst, Dest: 5, Imm: base, Type: u8},
+			{Op: ir.OpReturn, Src1: 5},
+			{Op: ir.OpLabel, Label: name + "_step"},
+			{Op: ir.OpLoadConst, Dest: 6, Imm: 1, Type: u16},
+			{Op: ir.OpSub, Dest: 7, Src1: 1, Src2: 6, Type: u16},
+			{Op: ir.OpCall, Dest: 8, Symbol: other, Args: []ir.Register{7}, TailCall: true},
+			{Op: ir.OpNop},
+			{Op: ir.OpReturn, Src1: 8},
+		}
+		fn.NextReg = 9
+		return fn
+	}
+
+	for _, n := range []int64{0, 3, 20000, 20001} {
+		main := ir.NewFunction("main", u8)
+		main.IsSMCDefault = false
+		main.IsSMCEnabled = false
+		main.Instructions = []ir.Instruction{
+			{Op: ir.OpLoadConst, Dest: 1, Imm: n, Type: u16},
+			{Op: ir.OpCall, Dest: 2, Symbol: "ping", Args: []ir.Register{1}},
+			{Op: ir.OpReturn, Src1: 2},
+		}
+		main.NextReg = 3
+
+		module := &ir.Module{Name: "test", Functions: []*ir.Function{main, state("ping", "pong", 1), state("pong", "ping", 0)}}
+		asm := generateZ80(t, module, func(g *Z80Generator) {
+			g.usePhysicalRegs = false
+		})
+		if !strings.Contains(asm, "JP pong") || strings.Contains(asm, "CALL pong") {
+			t.Fatalf("ping does not jump to pong:\n%s", asm)
+		}
+		want := uint16(1 - n%2)
+		if got := runZ80(t, asm, "main").GetRegisters().HL & 0xFF; got != want {
+			t.Errorf("ping(%d) = %d, want %d\n%s", n, got, want, asm)
+		}
+	}
+}
+
+func TestStringRuntime(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	u16 := &ir.BasicType{Kind: ir.TypeU16}
+	const buf = 0x9000
+
+	// Returns routine(a, b), or routine(buf, a, capacity) then
+	// routine(buf, b, capacity) for the copy and cat routines
+	stringFunction := func(routine, a, b string, capacity int64) *ir.Module {
+		fn := ir.NewFunction("str", u8)
+		fn.IsSMCDefault = false
+		fn.IsSMCEnabled = false
+		fn.Instructions = []ir.Instruction{
+			{Op: ir.OpLoadString, Dest: 1, Symbol: "str_a"},
+			{Op: ir.OpLoadString, Dest: 2, Symbol: "str_b"},
+		}
+		if capacity < 0 {
+			fn.Instructions = append(fn.Instructions,
+				ir.Instruction{Op: ir.OpStrCall, Dest: 3, Src1: 1, Src2: 2, Symbol: routine, Type: u8})
+		} else {
+			fn.Instructions = append(fn.Instructions,
+				ir.Instruction{Op: ir.OpLoadConst, Dest: 4, Imm: buf, Type: u16},
+				ir.Instruction{Op: ir.OpLoadConst, Dest: 5, Imm: capacity, Type: u8},
+				ir.Instruction{Op: ir.OpStrCall, Dest: 6, Src1: 4, Src2: 1, Args: []ir.Register{5}, Symbol: "str_copy", Type: u8},
+				ir.Instruction{Op: ir.OpStrCall, Dest: 3, Src1: 4, Src2: 2, Args: []ir.Register{5}, Symbol: routine, Type: u8})
+		}
+		fn.Instructions = append(fn.Instructions, ir.Instruction{Op: ir.OpReturn, Src1: 3})
+		fn.NextReg = 7
+		return &ir.Module{
+			Name:      "test",
+			Functions: []*ir.Function{fn},
+			Strings:   []*ir.String{{Label: "str_a", Value: a}, {Label: "str_b", Value: b}},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		routine  string
+		a, b     string
+		capacity int64 // -1 for routines without a buffer
+		want     byte
+		buffer   string
+	}{
+		{"cmp equal", "str_cmp", "HELLO", "HELLO", -1, 0, ""},
+		{"cmp less", "str_cmp", "APPLE", "APRICOT", -1, 0xFF, ""},
+		{"cmp greater", "str_cmp", "PEAR", "PEACH", -1, 1, ""},
+		{"cmp prefix", "str_cmp", "ABC", "ABCD", -1, 0xFF, ""},
+		{"cmp longer", "str_cmp", "ABCD", "ABC", -1, 1, ""},
+		{"cmp empty", "str_cmp", "", "", -1, 0, ""},
+		{"index found", "str_index", "HELLO WORLD", "WORLD", -1, 6, ""},
+		{"index first", "str_index", "ABAB", "AB", -1, 0, ""},
+		{"index last char", "str_index", "ABCD", "D", -1, 3, ""},
+		{"index missing", "str_index", "HELLO", "HELP", -1, 0xFF, ""},
+		{"index too long", "str_index", "HI", "HIGH", -1, 0xFF, ""},
+		{"index empty", "str_index", "HI", "", -1, 0, ""},
+		{"copy", "str_copy", "OLD", "NEW", 8, 1, "NEW"},
+		{"copy truncated", "str_copy", "OLD", "LONGER", 4, 0, "LONG"},
+		{"cat", "str_cat", "HELLO", ", WORLD", 16, 1, "HELLO, WORLD"},
+		{"cat exact", "str_cat", "AB", "CD", 4, 1, "ABCD"},
+		{"cat truncated", "str_cat", "AB", "CDEF", 5, 0, "ABCDE"},
+		{"cat full", "str_cat", "ABC", "D", 3, 0, "ABC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asm := generateZ80(t, stringFunction(tt.routine, tt.a, tt.b, tt.capacity), func(g *Z80Generator) {
+				g.usePhysicalRegs = false
+				g.localVarBase = 0xE000 // Clear of the strings at $F000
+			})
+			result := assembleZ80(t, asm)
+			z := emulator.NewRemogattoZ80()
+			// The data section comes first, so place each line at its own address
+			for _, line := range result.Listing {
+				z.LoadMemory(line.Address, line.Bytes)
+			}
+			z.SetSP(0xFEFE)
+			z.SetMemory(0xFEFE, 0)
+			z.SetMemory(0xFEFF, 0)
+			z.SetPC(result.Symbols["STR"])
+			if err := z.Run(); err != nil {
+				t.Fatalf("execution failed: %v\n%s", err, z.DumpState())
+			}
+			if got := byte(z.GetRegisters().HL); got != tt.want {
+				t.Errorf("result = $%02X, want $%02X\n%s", got, tt.want, asm)
+			}
+			if tt.capacity < 0 {
+				return
+			}
+			got := make([]byte, z.GetMemory(buf))
+			for i := range got {
+				got[i] = z.GetMemory(buf + 1 + uint16(i))
+			}
+			if string(got) != tt.buffer {
+				t.Errorf("buffer = %q, want %q\n%s", got, tt.buffer, asm)
+			}
+		})
+	}
+}
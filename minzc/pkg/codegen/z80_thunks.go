@@ -0,0 +1,39 @@
+package codegen
+
+// CallThunkTable labels the call-thunk table. The table opens the code
+// section, so it sits at a fixed address ($8000, or the --org address)
+// regardless of function sizes: entry i is at CALL_THUNKS + 3*i and holds
+// JP target.
+const CallThunkTable = "CALL_THUNKS"
+
+// thunkLabel returns the thunk entry label for a function
+func (g *Z80Generator) thunkLabel(funcName string) string {
+	return g.sanitizeFunctionName(funcName) + "_thunk"
+}
+
+// callTarget returns the label a CALL to a module function should use:
+// the function itself, or its thunk when relocatable calls are enabled
+func (g *Z80Generator) callTarget(funcName string) string {
+	if g.relocatableCalls {
+		return g.thunkLabel(funcName)
+	}
+	return g.sanitizeFunctionName(funcName)
+}
+
+// generateCallThunks emits one JP entry per function. Relocating a function
+// then only requires rewriting the two address bytes of its thunk; every
+// caller picks up the new address.
+func (g *Z80Generator) generateCallThunks() {
+	if !g.relocatableCalls || len(g.module.Functions) == 0 {
+		return
+	}
+
+	g.emit("; Call-thunk table (relocatable calls)")
+	g.emit("%s:", CallThunkTable)
+	for _, fn := range g.module.Functions {
+		g.emit("%s:", g.thunkLabel(fn.Name))
+		g.emit("    JP %s", g.sanitizeFunctionName(fn.Name))
+	}
+	g.emit("%s_END:", CallThunkTable)
+	g.emit("")
+}
@@ -0,0 +1,298 @@
+package codegen
+
+import (
+	"sort"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// 24-bit values (u24, i24, f16.8 and f8.16) are held in A:HL, A the high
+// byte, with a second operand in C:DE. They are returned in A:HL, and the
+// first two register-passed parameters arrive in A:HL and C:DE.
+//
+// Virtual registers holding 24-bit values never get a physical register.
+// In memory a value takes three bytes, low byte first, so 16-bit code
+// reading it sees its low word. Temporaries are 2 bytes apart in the
+// variable area, so 24-bit ones get their own 3-byte slots above the
+// function's registers (see assignWideSlots).
+
+// is24Bit reports whether t is one of the 24-bit types
+func is24Bit(t ir.Type) bool {
+	basic, ok := t.(*ir.BasicType)
+	return ok && basic.Size() == 3
+}
+
+// wideSlot is where a 24-bit value lives: IX+offset for a local in the
+// stack frame, an absolute address otherwise
+type wideSlot struct {
+	onStack bool
+	offset  int
+	addr    uint16
+}
+
+// findWideRegisters returns the virtual registers of fn that hold 24-bit
+// values. Only instructions that write all three bytes define one; any
+// other register read as 24 bits is extended from 16.
+func (g *Z80Generator) findWideRegisters(fn *ir.Function) map[ir.Register]bool {
+	wide := make(map[ir.Register]bool)
+	for _, param := range fn.Params {
+		if is24Bit(param.Type) {
+			wide[param.Reg] = true
+		}
+	}
+
+	readWide := make(map[ir.Register]bool)
+	for _, inst := range fn.Instructions {
+		switch inst.Op {
+		case ir.OpAdd, ir.OpSub, ir.OpTrueSMCLoad:
+			if is24Bit(inst.Type) {
+				wide[inst.Dest] = true
+			}
+		case ir.OpMove:
+			if is24Bit(inst.Type) || wide[inst.Src1] {
+				wide[inst.Dest] = true
+			}
+		case ir.OpLoadConst:
+			if is24Bit(inst.Type) || inst.Imm > 0xFFFF || inst.Imm < -0x8000 {
+				wide[inst.Dest] = true
+			}
+		case ir.OpLoadParam:
+			if param := g.findParameter(fn, inst.Symbol); param != nil && is24Bit(param.Type) {
+				wide[inst.Dest] = true
+			}
+		case ir.OpLoadVar:
+			if is24Bit(g.variableType(inst.Symbol, inst.Src1)) {
+				wide[inst.Dest] = true
+			}
+		case ir.OpCall:
+			if target := g.findFunction(inst.Symbol); target != nil && is24Bit(target.ReturnType) {
+				wide[inst.Dest] = true
+			}
+		}
+
+		switch inst.Op {
+		case ir.OpAdd, ir.OpSub, ir.OpEq, ir.OpNe, ir.OpLt, ir.OpGt, ir.OpLe, ir.OpGe:
+			if is24Bit(inst.Type) {
+				readWide[inst.Src1], readWide[inst.Src2] = true, true
+			}
+		case ir.OpStoreVar:
+			if is24Bit(g.variableType(inst.Symbol, inst.Dest)) {
+				readWide[inst.Src1] = true
+			}
+		case ir.OpReturn:
+			if is24Bit(fn.ReturnType) {
+				readWide[inst.Src1] = true
+			}
+		}
+	}
+
+	// Constants read as 24 bits are loaded whole rather than extended
+	for _, inst := range fn.Instructions {
+		if inst.Op == ir.OpLoadConst && readWide[inst.Dest] {
+			wide[inst.Dest] = true
+		}
+	}
+	delete(wide, 0)
+	return wide
+}
+
+// assignWideSlots gives the 24-bit registers and locals of fn 3-byte slots
+// above its 2-byte register slots. Locals laid out by generateFunction
+// get their addresses there instead.
+func (g *Z80Generator) assignWideSlots(fn *ir.Function) {
+	var regs []ir.Register
+	for reg := range g.wide {
+		regs = append(regs, reg)
+	}
+	for _, local := range fn.Locals {
+		if is24Bit(local.Type) && !g.wide[local.Reg] {
+			regs = append(regs, local.Reg)
+		}
+	}
+	if len(regs) == 0 {
+		return
+	}
+	sort.Slice(regs, func(i, j int) bool { return regs[i] < regs[j] })
+
+	top := fn.NextReg
+	size := 0
+	for _, local := range fn.Locals {
+		top = max(top, local.Reg+1)
+		size += local.Type.Size()
+	}
+	for _, inst := range fn.Instructions {
+		top = max(top, inst.Dest+1, inst.Src1+1, inst.Src2+1, inst.Src3+1)
+	}
+	next := g.localVarBase + uint16(max(2*int(top), size))
+	for _, reg := range regs {
+		g.regAlloc.SetAddress(reg, next)
+		next += 3
+	}
+}
+
+// variableType returns the type of the variable an OpLoadVar or OpStoreVar
+// names, by symbol or by the register of a local
+func (g *Z80Generator) variableType(symbol string, reg ir.Register) ir.Type {
+	if symbol != "" {
+		for _, global := range g.module.Globals {
+			if global.Name == symbol {
+				return global.Type
+			}
+		}
+	}
+	for _, local := range g.currentFunc.Locals {
+		if symbol != "" && local.Name == symbol || symbol == "" && local.Reg == reg {
+			return local.Type
+		}
+	}
+	for _, param := range g.currentFunc.Params {
+		if symbol != "" && param.Name == symbol || symbol == "" && param.Reg == reg {
+			return param.Type
+		}
+	}
+	return nil
+}
+
+// variableSlot returns where the variable an OpLoadVar or OpStoreVar names
+// lives
+func (g *Z80Generator) variableSlot(symbol string, reg ir.Register) wideSlot {
+	if symbol != "" {
+		if addr := g.getGlobalAddr(symbol); addr != 0 {
+			return wideSlot{addr: addr}
+		}
+		for _, local := range g.currentFunc.Locals {
+			if local.Name == symbol {
+				return g.registerSlot(local.Reg)
+			}
+		}
+		for _, param := range g.currentFunc.Params {
+			if param.Name == symbol {
+				return g.registerSlot(param.Reg)
+			}
+		}
+	}
+	return g.registerSlot(reg)
+}
+
+// registerSlot returns where a virtual register lives in memory
+func (g *Z80Generator) registerSlot(reg ir.Register) wideSlot {
+	if !g.useAbsoluteLocals && g.isLocalRegister(reg) {
+		return wideSlot{onStack: true, offset: g.getLocalOffset(reg)}
+	}
+	return wideSlot{addr: g.getAbsoluteAddr(reg)}
+}
+
+// loadSlot24 loads a 24-bit value from memory into A:HL
+func (g *Z80Generator) loadSlot24(slot wideSlot) {
+	if slot.onStack {
+		g.emit("    LD L, (IX%+d)", slot.offset)
+		g.emit("    LD H, (IX%+d)", slot.offset+1)
+		g.emit("    LD A, (IX%+d)", slot.offset+2)
+		return
+	}
+	g.emit("    LD HL, ($%04X)", slot.addr)
+	g.emit("    LD A, ($%04X)", slot.addr+2)
+}
+
+// storeSlot24 stores A:HL into memory
+func (g *Z80Generator) storeSlot24(slot wideSlot) {
+	if slot.onStack {
+		g.emit("    LD (IX%+d), L", slot.offset)
+		g.emit("    LD (IX%+d), H", slot.offset+1)
+		g.emit("    LD (IX%+d), A", slot.offset+2)
+		return
+	}
+	g.emit("    LD ($%04X), HL", slot.addr)
+	g.emit("    LD ($%04X), A", slot.addr+2)
+}
+
+// load24 loads a virtual register into A:HL. A 16-bit register is
+// extended, with its sign if signed.
+func (g *Z80Generator) load24(reg ir.Register, signed bool) {
+	if g.wide[reg] {
+		g.emit("    ; Register %d (24-bit) to A:HL", reg)
+		g.loadSlot24(g.registerSlot(reg))
+		return
+	}
+	g.loadToHL(reg)
+	if signed {
+		g.emit("    LD A, H")
+		g.emit("    RLA")
+		g.emit("    SBC A, A       ; Sign-extend to 24 bits")
+	} else {
+		g.emit("    XOR A          ; Zero-extend to 24 bits")
+	}
+}
+
+// store24 stores A:HL into a virtual register; a 16-bit one keeps HL
+func (g *Z80Generator) store24(reg ir.Register) {
+	if !g.wide[reg] {
+		g.storeFromHL(reg)
+		return
+	}
+	g.emit("    ; A:HL to register %d (24-bit)", reg)
+	g.storeSlot24(g.registerSlot(reg))
+}
+
+// load24Pair loads rhs into C:DE and lhs into A:HL
+func (g *Z80Generator) load24Pair(lhs, rhs ir.Register, signed bool) {
+	g.load24(rhs, signed)
+	g.emit("    LD C, A")
+	g.emit("    EX DE, HL")
+	g.load24(lhs, signed)
+}
+
+// generate24Arith adds or subtracts 24-bit values: the low words with
+// ADD/SBC HL, DE, then the high bytes with the carry
+func (g *Z80Generator) generate24Arith(inst ir.Instruction) {
+	signed := isSignedType(inst.Type)
+	switch inst.Op {
+	case ir.OpAdd:
+		g.load24Pair(inst.Src1, inst.Src2, signed)
+		g.emit("    ADD HL, DE")
+		g.emit("    ADC A, C       ; A:HL = Src1 + Src2")
+	case ir.OpSub:
+		g.load24Pair(inst.Src1, inst.Src2, signed)
+		g.emit("    OR A           ; Clear carry")
+		g.emit("    SBC HL, DE")
+		g.emit("    SBC A, C       ; A:HL = Src1 - Src2")
+	}
+	g.store24(inst.Dest)
+}
+
+// generate24Comparison compares 24-bit values. Subtracting as for
+// generate24Arith leaves the carry, sign and overflow flags of the whole
+// subtraction, as SBC HL, DE does for 16 bits; equality needs both the low
+// words and the high bytes equal.
+func (g *Z80Generator) generate24Comparison(inst ir.Instruction) {
+	lhs, rhs := inst.Src1, inst.Src2
+	if inst.Op == ir.OpGt || inst.Op == ir.OpLe {
+		lhs, rhs = rhs, lhs
+	}
+	g.load24Pair(lhs, rhs, isSignedType(inst.Type))
+	g.emit("    OR A           ; Clear carry")
+	g.emit("    SBC HL, DE")
+
+	switch inst.Op {
+	case ir.OpEq, ir.OpNe:
+		differLabel := g.getFunctionLabel("eq_differ")
+		g.emit("    JP NZ, %s", differLabel)
+		g.emit("    CP C           ; Low words equal: compare high bytes")
+		g.emit("%s:", differLabel)
+		g.emitEqualResult(inst)
+	case ir.OpLt, ir.OpGt:
+		g.emit("    SBC A, C       ; Compare")
+		g.emitLessThanResult(inst, false)
+	case ir.OpLe, ir.OpGe:
+		g.emit("    SBC A, C       ; Compare")
+		g.emitLessThanResult(inst, true)
+	}
+}
+
+// generate24Const loads a constant into a 24-bit register
+func (g *Z80Generator) generate24Const(inst ir.Instruction) {
+	value := inst.Imm & 0xFFFFFF
+	g.emit("    LD HL, $%04X", value&0xFFFF)
+	g.emit("    LD A, $%02X", value>>16)
+	g.store24(inst.Dest)
+}
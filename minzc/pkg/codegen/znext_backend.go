@@ -0,0 +1,46 @@
+package codegen
+
+// ZNextBackend implements the Backend interface for the ZX Spectrum Next.
+// It generates Z80 code plus the Z80N instructions of the Next's CPU where
+// they pay off:
+// - MUL D,E for multiplication instead of the shift-add loop
+// - ADD HL,A for byte array indexing
+// - PIXELAD/SETAE for plotting pixels
+// Assemble the output with mza -t zxnext, which writes a .nex file.
+type ZNextBackend struct {
+	Z80Backend
+}
+
+// NewZNextBackend creates a new ZX Spectrum Next backend
+func NewZNextBackend(options *BackendOptions) Backend {
+	return &ZNextBackend{
+		Z80Backend: Z80Backend{options: options, z80n: true},
+	}
+}
+
+// Name returns the name of this backend
+func (b *ZNextBackend) Name() string {
+	return "znext"
+}
+
+// SupportsFeature checks if the Next backend supports a specific feature
+func (b *ZNextBackend) SupportsFeature(feature string) bool {
+	switch feature {
+	case FeatureHardwareMultiply:
+		return true // MUL D,E: DE = D * E in 8 T-states
+	case "z80n":
+		return true
+	default:
+		return b.Z80Backend.SupportsFeature(feature)
+	}
+}
+
+// Register the ZX Spectrum Next backend
+func init() {
+	RegisterBackend("znext", func(options *BackendOptions) Backend {
+		return NewZNextBackend(options)
+	})
+	RegisterBackend("zxnext", func(options *BackendOptions) Backend {
+		return NewZNextBackend(options)
+	})
+}
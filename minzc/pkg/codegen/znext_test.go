@@ -0,0 +1,72 @@
+package codegen
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+func TestZNextBackend(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	u16 := &ir.BasicType{Kind: ir.TypeU16}
+	table := &ir.ArrayType{Element: u8, Length: 16}
+	module := func() *ir.Module {
+		fn := ir.NewFunction("main.main", u16)
+		fn.Instructions = []ir.Instruction{
+			{Op: ir.OpLoadConst, Dest: 1, Imm: 13, Type: u8},
+			{Op: ir.OpLoadConst, Dest: 2, Imm: 11, Type: u8},
+			{Op: ir.OpMul, Dest: 3, Src1: 1, Src2: 2, Type: u8},
+			{Op: ir.OpLoadConst, Dest: 4, Imm: 4, Type: u8},
+			{Op: ir.OpMul, Dest: 5, Src1: 3, Src2: 4, Type: u8}, // Still a shift
+			{Op: ir.OpLoadAddr, Dest: 6, Symbol: "table", Type: table},
+			{Op: ir.OpLoadIndex, Dest: 7, Src1: 6, Src2: 5, Type: u8},
+			{Op: ir.OpLoadConst, Dest: 8, Imm: 300, Type: u16},
+			{Op: ir.OpLoadConst, Dest: 9, Imm: 7, Type: u16},
+			{Op: ir.OpMul, Dest: 10, Src1: 8, Src2: 9, Type: u16},
+			{Op: ir.OpReturn, Src1: 10},
+		}
+		fn.NextReg = 11
+		return &ir.Module{Name: "main", Functions: []*ir.Function{fn}, Globals: []ir.Global{{Name: "table", Type: table}}}
+	}
+
+	backend := GetBackend("znext", &BackendOptions{Deterministic: true})
+	if backend == nil {
+		t.Fatal("znext backend is not registered")
+	}
+	if !backend.SupportsFeature(FeatureHardwareMultiply) || !backend.SupportsFeature(FeatureSelfModifyingCode) ||
+		GetBackend("z80", &BackendOptions{}).SupportsFeature(FeatureHardwareMultiply) {
+		t.Error("hardware multiply should be a feature of znext only")
+	}
+	asm, err := backend.Generate(module())
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if n := strings.Count(asm, "    MUL D, E"); n != 4 {
+		t.Errorf("got %d MUL D, E, want 1 for u8 and 3 for u16:\n%s", n, asm)
+	}
+	for _, want := range []string{"    TARGET zxnext\n", "    ADD A, A ", "    ADD HL, A\n"} {
+		if !strings.Contains(asm, want) {
+			t.Errorf("output is missing %q:\n%s", want, asm)
+		}
+	}
+	if strings.Contains(asm, "mul_loop") || strings.Contains(asm, "LD D, 0") {
+		t.Errorf("output multiplies or indexes the Z80 way:\n%s", asm)
+	}
+
+	// TARGET zxnext lets the assembler take the Z80N instructions
+	result := assembleZ80(t, asm)
+	if !bytes.Contains(result.Binary, []byte{0xED, 0x30}) || !bytes.Contains(result.Binary, []byte{0xED, 0x31}) {
+		t.Errorf("binary has no MUL D, E or ADD HL, A: % X", result.Binary)
+	}
+
+	// The Z80 backend keeps to the Z80
+	asm, err = GetBackend("z80", &BackendOptions{Deterministic: true}).Generate(module())
+	if err != nil {
+		t.Fatalf("Z80 Generate failed: %v", err)
+	}
+	if strings.Contains(asm, "MUL") || strings.Contains(asm, "ADD HL, A") || strings.Contains(asm, "TARGET") {
+		t.Errorf("Z80 output uses Z80N instructions:\n%s", asm)
+	}
+}
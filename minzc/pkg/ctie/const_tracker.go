@@ -177,7 +177,7 @@ func (c *ConstTracker) computeArithmetic(inst *ir.Instruction) {
 	case ir.OpSub:
 		result = src1Val.ToInt() - src2Val.ToInt()
 	case ir.OpMul:
-		result = src1Val.ToInt() * src2Val.ToInt()
+		result = ir.FixedMul(src1Val.ToInt(), src2Val.ToInt(), inst.Type)
 	case ir.OpDiv:
 		if src2Val.ToInt() != 0 {
 			result = src1Val.ToInt() / src2Val.ToInt()
@@ -184,7 +184,7 @@ func (e *CompileTimeExecutor) executeInstruction(inst *ir.Instruction, ctx *Exec
 	case ir.OpSub:
 		return e.executeBinaryOp(ctx, func(a, b int64) int64 { return a - b })
 	case ir.OpMul:
-		return e.executeBinaryOp(ctx, func(a, b int64) int64 { return a * b })
+		return e.executeBinaryOp(ctx, func(a, b int64) int64 { return ir.FixedMul(a, b, inst.Type) })
 	case ir.OpDiv:
 		return e.executeBinaryOp(ctx, func(a, b int64) int64 {
 			if b == 0 {
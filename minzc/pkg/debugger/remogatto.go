@@ -0,0 +1,387 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/minz/minzc/pkg/emulator"
+)
+
+// RemogattoDebugger is the interactive debugger behind mze --debug. It
+// drives the full-coverage emulator and names addresses by the symbols of
+// an mza -s symbol file.
+type RemogattoDebugger struct {
+	emu         *emulator.RemogattoZ80
+	symbols     map[string]uint16
+	breakpoints map[uint16]bool
+	finished    bool
+
+	input  *bufio.Scanner
+	output io.Writer
+}
+
+// NewRemogattoDebugger creates a debugger for emu; symbols may be nil
+func NewRemogattoDebugger(emu *emulator.RemogattoZ80, symbols map[string]uint16, config *Config) *RemogattoDebugger {
+	if config == nil {
+		config = &Config{}
+	}
+	if config.Input == nil {
+		config.Input = os.Stdin
+	}
+	if config.Output == nil {
+		config.Output = os.Stdout
+	}
+	return &RemogattoDebugger{
+		emu:         emu,
+		symbols:     symbols,
+		breakpoints: make(map[uint16]bool),
+		input:       bufio.NewScanner(config.Input),
+		output:      config.Output,
+	}
+}
+
+// Run reads and executes commands until quit or the end of input
+func (d *RemogattoDebugger) Run() error {
+	fmt.Fprintln(d.output, "MinZ Z80 Debugger - type 'help' for commands")
+	d.showLocation()
+
+	for {
+		fmt.Fprintf(d.output, "dbg %s> ", d.describe(d.emu.GetPC()))
+		if !d.input.Scan() {
+			fmt.Fprintln(d.output)
+			return d.input.Err()
+		}
+		quit, err := d.execute(d.input.Text())
+		if err != nil {
+			fmt.Fprintf(d.output, "Error: %v\n", err)
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+// execute runs one command line and reports whether it was quit
+func (d *RemogattoDebugger) execute(line string) (bool, error) {
+	parts := strings.Fields(line)
+	if len(parts) == 0 {
+		parts = []string{"step"} // An empty line steps
+	}
+	args := parts[1:]
+
+	switch parts[0] {
+	case "h", "help", "?":
+		d.printHelp()
+
+	case "s", "step":
+		count := 1
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return false, fmt.Errorf("invalid step count %q", args[0])
+			}
+			count = n
+		}
+		for i := 0; i < count && !d.emu.IsHalted(); i++ {
+			d.emu.Step()
+		}
+		d.showLocation()
+
+	case "n", "next":
+		return false, d.next()
+
+	case "c", "continue":
+		return false, d.resume(d.atBreakpoint)
+
+	case "b", "break":
+		if len(args) == 0 {
+			d.listBreakpoints()
+			return false, nil
+		}
+		addr, err := d.address(args[0])
+		if err != nil {
+			return false, err
+		}
+		d.breakpoints[addr] = true
+		fmt.Fprintf(d.output, "Breakpoint set at $%04X %s\n", addr, d.describe(addr))
+
+	case "d", "delete":
+		if len(args) == 0 {
+			return false, fmt.Errorf("usage: delete <address|symbol>")
+		}
+		addr, err := d.address(args[0])
+		if err != nil {
+			return false, err
+		}
+		if !d.breakpoints[addr] {
+			return false, fmt.Errorf("no breakpoint at $%04X", addr)
+		}
+		delete(d.breakpoints, addr)
+		fmt.Fprintf(d.output, "Breakpoint deleted at $%04X\n", addr)
+
+	case "r", "regs":
+		d.showRegisters()
+
+	case "set":
+		if len(args) != 2 {
+			return false, fmt.Errorf("usage: set <register> <value>")
+		}
+		value, err := d.address(args[1])
+		if err != nil {
+			return false, err
+		}
+		if strings.ToUpper(args[0]) == "PC" {
+			d.emu.SetPC(value)
+		} else if err := d.emu.SetRegister(args[0], value); err != nil {
+			return false, err
+		}
+		d.showRegisters()
+
+	case "m", "mem":
+		if len(args) == 0 {
+			return false, fmt.Errorf("usage: mem <address|symbol> [count]")
+		}
+		addr, err := d.address(args[0])
+		if err != nil {
+			return false, err
+		}
+		count := uint16(64)
+		if len(args) > 1 {
+			if count, err = emulator.ParseValue(args[1]); err != nil {
+				return false, err
+			}
+		}
+		d.showMemory(addr, count)
+
+	case "poke":
+		if len(args) < 2 {
+			return false, fmt.Errorf("usage: poke <address|symbol> <byte> [byte...]")
+		}
+		addr, err := d.address(args[0])
+		if err != nil {
+			return false, err
+		}
+		for i, text := range args[1:] {
+			value, err := emulator.ParseValue(text)
+			if err != nil || value > 0xFF {
+				return false, fmt.Errorf("invalid byte %q", text)
+			}
+			d.emu.SetMemory(addr+uint16(i), byte(value))
+		}
+		d.showMemory(addr, uint16(len(args)-1))
+
+	case "dis":
+		addr := d.emu.GetPC()
+		if len(args) > 0 {
+			var err error
+			if addr, err = d.address(args[0]); err != nil {
+				return false, err
+			}
+		}
+		d.showDisassembly(addr, 8)
+
+	case "sym", "symbols":
+		d.listSymbols(args)
+
+	case "q", "quit":
+		return true, nil
+
+	default:
+		return false, fmt.Errorf("unknown command %q (type 'help' for commands)", parts[0])
+	}
+	return false, nil
+}
+
+// next steps over a CALL or RST: it runs until the instruction after it
+// is reached with the stack back where it was
+func (d *RemogattoDebugger) next() error {
+	pc, sp := d.emu.GetPC(), d.emu.GetSP()
+	if !isCall(d.emu.GetMemory(pc)) {
+		d.emu.Step()
+		d.showLocation()
+		return nil
+	}
+	_, length := d.emu.Disassemble(pc)
+	ret := pc + length
+	return d.resume(func(at uint16) bool {
+		return (at == ret && d.emu.GetSP() == sp) || d.breakpoints[at]
+	})
+}
+
+// isCall reports whether opcode is CALL, CALL cc or RST
+func isCall(opcode byte) bool {
+	return opcode == 0xCD || opcode&0xC7 == 0xC4 || opcode&0xC7 == 0xC7
+}
+
+// resume runs until stop, reporting a breakpoint or the end of the program
+func (d *RemogattoDebugger) resume(stop func(pc uint16) bool) error {
+	if d.finished {
+		return fmt.Errorf("the program has finished")
+	}
+	stopped, err := d.emu.RunUntil(stop)
+	if err != nil {
+		return err
+	}
+	if !stopped {
+		d.finished = true
+		fmt.Fprintf(d.output, "Program finished at $%04X with exit code %d\n", d.emu.GetPC(), d.emu.GetExitCode())
+		return nil
+	}
+	if d.atBreakpoint(d.emu.GetPC()) {
+		fmt.Fprintf(d.output, "Breakpoint hit at $%04X %s\n", d.emu.GetPC(), d.describe(d.emu.GetPC()))
+	}
+	d.showLocation()
+	return nil
+}
+
+func (d *RemogattoDebugger) atBreakpoint(pc uint16) bool {
+	return d.breakpoints[pc]
+}
+
+// address parses a symbol name or a decimal, $hex or 0x hex number
+func (d *RemogattoDebugger) address(text string) (uint16, error) {
+	if addr, ok := d.symbols[text]; ok {
+		return addr, nil
+	}
+	addr, err := emulator.ParseValue(text)
+	if err != nil {
+		return 0, fmt.Errorf("unknown symbol or address %q", text)
+	}
+	return addr, nil
+}
+
+// describe names addr by its enclosing symbol, or by its value without symbols
+func (d *RemogattoDebugger) describe(addr uint16) string {
+	if name := emulator.EnclosingSymbol(addr, d.symbols); name != "" {
+		return name
+	}
+	return fmt.Sprintf("$%04X", addr)
+}
+
+// showLocation prints the registers and the next instruction
+func (d *RemogattoDebugger) showLocation() {
+	d.showRegisters()
+	d.showDisassembly(d.emu.GetPC(), 1)
+}
+
+func (d *RemogattoDebugger) showRegisters() {
+	r := d.emu.GetRegisters()
+	flags := []byte("SZ-H-PNC")
+	for i := range flags {
+		if r.F&(0x80>>i) == 0 {
+			flags[i] = '-'
+		}
+	}
+	fmt.Fprintf(d.output, "PC=$%04X SP=$%04X A=$%02X F=%s BC=$%04X DE=$%04X HL=$%04X IX=$%04X IY=$%04X\n",
+		r.PC, r.SP, r.A, flags, r.BC, r.DE, r.HL, r.IX, r.IY)
+}
+
+// showDisassembly prints lines instructions from addr, with symbol labels
+func (d *RemogattoDebugger) showDisassembly(addr uint16, lines int) {
+	labels := make(map[uint16][]string)
+	for name, a := range d.symbols {
+		labels[a] = append(labels[a], name)
+	}
+	for i := 0; i < lines; i++ {
+		names := labels[addr]
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(d.output, "%s:\n", name)
+		}
+
+		mnemonic, length := d.emu.Disassemble(addr)
+		var bytes strings.Builder
+		for j := uint16(0); j < length; j++ {
+			fmt.Fprintf(&bytes, "%02X ", d.emu.GetMemory(addr+j))
+		}
+		marker := "  "
+		if addr == d.emu.GetPC() {
+			marker = "=>"
+		}
+		if d.breakpoints[addr] {
+			marker = "*" + marker[1:]
+		}
+		fmt.Fprintf(d.output, "%s $%04X  %-12s %s\n", marker, addr, bytes.String(), mnemonic)
+		addr += length
+	}
+}
+
+// showMemory prints count bytes from addr as hex and ASCII
+func (d *RemogattoDebugger) showMemory(addr, count uint16) {
+	for i := uint16(0); i < count; i += 16 {
+		var hex, ascii strings.Builder
+		for j := i; j < i+16 && j < count; j++ {
+			b := d.emu.GetMemory(addr + j)
+			fmt.Fprintf(&hex, "%02X ", b)
+			if b >= 32 && b < 127 {
+				ascii.WriteByte(b)
+			} else {
+				ascii.WriteByte('.')
+			}
+		}
+		fmt.Fprintf(d.output, "$%04X: %-48s %s\n", addr+i, hex.String(), ascii.String())
+	}
+}
+
+func (d *RemogattoDebugger) listBreakpoints() {
+	if len(d.breakpoints) == 0 {
+		fmt.Fprintln(d.output, "No breakpoints set")
+		return
+	}
+	var addrs []int
+	for addr := range d.breakpoints {
+		addrs = append(addrs, int(addr))
+	}
+	sort.Ints(addrs)
+	fmt.Fprintln(d.output, "Breakpoints:")
+	for _, addr := range addrs {
+		fmt.Fprintf(d.output, "  $%04X %s\n", addr, d.describe(uint16(addr)))
+	}
+}
+
+// listSymbols prints the symbols containing any of filters, by address
+func (d *RemogattoDebugger) listSymbols(filters []string) {
+	var names []string
+	for name := range d.symbols {
+		matches := len(filters) == 0
+		for _, f := range filters {
+			matches = matches || strings.Contains(name, f)
+		}
+		if matches {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		fmt.Fprintln(d.output, "No symbols")
+		return
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := d.symbols[names[i]], d.symbols[names[j]]
+		return a < b || (a == b && names[i] < names[j])
+	})
+	for _, name := range names {
+		fmt.Fprintf(d.output, "  $%04X  %s\n", d.symbols[name], name)
+	}
+}
+
+func (d *RemogattoDebugger) printHelp() {
+	fmt.Fprintln(d.output, "Addresses are symbols or numbers (decimal, $hex or 0x hex).")
+	fmt.Fprintln(d.output, "Commands:")
+	fmt.Fprintln(d.output, "  s/step [n]              - Step n instructions (empty line steps one)")
+	fmt.Fprintln(d.output, "  n/next                  - Step over a CALL or RST")
+	fmt.Fprintln(d.output, "  c/continue              - Run until a breakpoint or the end")
+	fmt.Fprintln(d.output, "  b/break [addr]          - Set a breakpoint, or list them")
+	fmt.Fprintln(d.output, "  d/delete <addr>         - Delete a breakpoint")
+	fmt.Fprintln(d.output, "  r/regs                  - Show registers")
+	fmt.Fprintln(d.output, "  set <reg> <value>       - Set a register (A, HL, PC, ...)")
+	fmt.Fprintln(d.output, "  m/mem <addr> [count]    - Show memory")
+	fmt.Fprintln(d.output, "  poke <addr> <byte>...   - Write memory")
+	fmt.Fprintln(d.output, "  dis [addr]              - Disassemble")
+	fmt.Fprintln(d.output, "  sym [filter]            - List symbols")
+	fmt.Fprintln(d.output, "  q/quit                  - Exit debugger")
+}
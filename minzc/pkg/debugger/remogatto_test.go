@@ -0,0 +1,75 @@
+package debugger
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/emulator"
+)
+
+// debugSession runs the debugger over a small program with the commands
+// in script and returns its output
+func debugSession(t *testing.T, script string) (*emulator.RemogattoZ80, string) {
+	t.Helper()
+	z := emulator.NewRemogattoZ80()
+	z.LoadMemory(0x8000, []byte{
+		0x3E, 0x05, // main: LD A, 5
+		0xCD, 0x10, 0x80, //   CALL inc
+		0x47, //               LD B, A
+		0xF3, //               DI
+		0x76, //               HALT
+	})
+	z.LoadMemory(0x8010, []byte{
+		0x3C, // inc: INC A
+		0xC9, //      RET
+	})
+	z.SetPC(0x8000)
+	z.SetSP(0xFF00)
+
+	var out strings.Builder
+	symbols := map[string]uint16{"main": 0x8000, "inc": 0x8010}
+	d := NewRemogattoDebugger(z, symbols, &Config{Input: strings.NewReader(script), Output: &out})
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return z, out.String()
+}
+
+func TestRemogattoDebuggerBreakpoints(t *testing.T) {
+	z, out := debugSession(t, strings.Join([]string{
+		"b inc",
+		"c",
+		"s",
+		"set A $20",
+		"poke $9000 1 $FF",
+		"m $9000 2",
+		"c",
+		"q",
+	}, "\n"))
+
+	for _, want := range []string{
+		"Breakpoint set at $8010 inc",
+		"Breakpoint hit at $8010 inc",
+		"dbg inc+1> ",
+		"A=$20",
+		"$9000: 01 FF",
+		"Program finished at $8007",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %q:\n%s", want, out)
+		}
+	}
+	if r := z.GetRegisters(); r.BC>>8 != 0x20 || z.GetMemory(0x9001) != 0xFF {
+		t.Errorf("B=$%02X [$9001]=$%02X, want the values set in the debugger", r.BC>>8, z.GetMemory(0x9001))
+	}
+}
+
+func TestRemogattoDebuggerNext(t *testing.T) {
+	z, out := debugSession(t, "s\nnext\nbogus\n")
+	if r := z.GetRegisters(); r.PC != 0x8005 || r.A != 6 || r.SP != 0xFF00 {
+		t.Errorf("after stepping over the call PC=$%04X A=%d SP=$%04X, want PC=$8005 A=6 SP=$FF00", r.PC, r.A, r.SP)
+	}
+	if !strings.Contains(out, `unknown command "bogus"`) {
+		t.Errorf("output is missing the unknown command error:\n%s", out)
+	}
+}
@@ -0,0 +1,97 @@
+// Package diagnostics describes compiler errors in a form editors and CI
+// can consume: each one has a location, a severity, a stable code and an
+// optional hint on how to fix it.
+package diagnostics
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Severities
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Error codes. E00xx come from the parser, E01xx from semantic analysis.
+const (
+	CodeUnknown       = "E0000" // An error without a more specific code
+	CodeSyntax        = "E0001"
+	CodeSemantic      = "E0100"
+	CodeUndefined     = "E0101" // Undefined identifier, function, type or field
+	CodeTypeMismatch  = "E0102"
+	CodeTypeInference = "E0103" // A type could not be inferred or determined
+	CodeArgumentCount = "E0104"
+	CodeImmutable     = "E0105" // Assignment to an immutable variable
+	CodeUnsupported   = "E0106"
+)
+
+// Diagnostic is one compiler message. Line and Column are 1-based and
+// zero when the error has no known location.
+type Diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	Hint     string `json:"hint,omitempty"`
+}
+
+// Reporter is implemented by errors that can describe themselves as a
+// Diagnostic
+type Reporter interface {
+	error
+	Diagnostic() Diagnostic
+}
+
+// FromError returns the diagnostics of err. An error joining several, such
+// as errors.Join or the semantic analyzer's, gives one for each; otherwise
+// the first Reporter in its chain is used, and an error without one
+// becomes a single diagnostic without a location.
+func FromError(err error) []Diagnostic {
+	if err == nil {
+		return nil
+	}
+	diags, _ := collect(err)
+	return diags
+}
+
+// collect returns the diagnostics of err and whether they describe it
+// better than its own message does
+func collect(err error) ([]Diagnostic, bool) {
+	if reporter, ok := err.(Reporter); ok {
+		return []Diagnostic{reporter.Diagnostic()}, true
+	}
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		var diags []Diagnostic
+		for _, inner := range e.Unwrap() {
+			found, _ := collect(inner)
+			diags = append(diags, found...)
+		}
+		return diags, true
+	case interface{ Unwrap() error }:
+		if inner := e.Unwrap(); inner != nil {
+			if diags, ok := collect(inner); ok {
+				return diags, true
+			}
+		}
+	}
+	return []Diagnostic{{Severity: SeverityError, Code: CodeUnknown, Message: err.Error()}}, false
+}
+
+// WriteJSON writes diagnostics as a JSON document of the form
+// {"diagnostics": [...]}
+func WriteJSON(w io.Writer, diags []Diagnostic) error {
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Diagnostics []Diagnostic `json:"diagnostics"`
+	}{diags})
+}
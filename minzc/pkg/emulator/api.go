@@ -0,0 +1,115 @@
+package emulator
+
+// Emulator is the API for Go programs that embed the emulator, such as
+// fuzzers and custom test harnesses. NewEmulator returns one; it is the
+// RemogattoZ80 the other emulator types wrap, so their embedded
+// RemogattoZ80 field is one too.
+//
+//	emu := emulator.NewEmulator()
+//	emu.LoadAt(0x8000, code)
+//	emu.SetRegister("PC", 0x8000)
+//	emu.SetHooks(emulator.Hooks{
+//		PortWrite: func(port uint16, value byte) { ... },
+//	})
+//	err := emu.Run()
+//	hl := emu.Registers().HL
+//
+// Run stops at the exit conventions of MinZ programs: DI:HALT, RST 38h, or
+// a RET to $0000. The methods below are kept stable; the types behind it
+// may grow others.
+type Emulator interface {
+	MemoryReader
+	MemoryWriter
+
+	// Reset resets the CPU and clears the output and cycle count
+	Reset()
+	// LoadAt copies data into memory from address
+	LoadAt(address uint16, data []byte) error
+	// Step executes one instruction and returns its T-states
+	Step() int
+	// Run executes until the program ends or the cycle limit is passed
+	Run() error
+	// RunUntil runs like Run, but also stops before an instruction for
+	// which stop returns true, reporting whether that is why it stopped
+	RunUntil(stop func(pc uint16) bool) (bool, error)
+	// SetCycleLimit sets the T-states after which Run fails; 0 is none
+	SetCycleLimit(limit int)
+
+	// Registers returns the main registers
+	Registers() Registers
+	// SetRegister sets a register or pair by name: A, BC, HL, PC, ...
+	SetRegister(name string, value uint16) error
+
+	// SetHooks replaces the hooks; the zero Hooks removes them
+	SetHooks(hooks Hooks)
+
+	// GetCycles returns the T-states executed since the last Reset
+	GetCycles() int
+	// IsHalted reports whether the program stopped with DI:HALT
+	IsHalted() bool
+	// GetExitCode returns A after RST 38h, or HL after a RET to $0000
+	GetExitCode() uint16
+	// GetOutput returns the bytes written to port $01
+	GetOutput() []byte
+}
+
+// MemoryReader reads memory without the CPU: no T-states pass and no
+// hooks are called
+type MemoryReader interface {
+	ReadMemory(address uint16) byte
+}
+
+// MemoryWriter writes memory without the CPU, ROM included
+type MemoryWriter interface {
+	WriteMemory(address uint16, value byte)
+}
+
+// Hooks are called as the CPU executes. Any of them may be nil.
+type Hooks struct {
+	// PortRead answers IN from ports no emulated device answers; without
+	// it they read $FF
+	PortRead func(port uint16) byte
+	// PortWrite sees every OUT
+	PortWrite func(port uint16, value byte)
+	// MemoryRead sees every byte the CPU reads, opcode fetches included
+	MemoryRead func(address uint16, value byte)
+	// MemoryWrite sees every byte the CPU writes outside the ROM
+	MemoryWrite func(address uint16, value byte)
+}
+
+var _ Emulator = (*RemogattoZ80)(nil)
+
+// NewEmulator creates an emulator with the full Z80 instruction set and
+// 64K of RAM above a 16K ROM area the CPU cannot write
+func NewEmulator() Emulator {
+	return NewRemogattoZ80()
+}
+
+// LoadAt copies data into memory from address
+func (z *RemogattoZ80) LoadAt(address uint16, data []byte) error {
+	return z.LoadMemory(address, data)
+}
+
+// Registers returns the main registers
+func (z *RemogattoZ80) Registers() Registers {
+	return z.GetRegisters()
+}
+
+// ReadMemory reads a byte without the CPU
+func (z *RemogattoZ80) ReadMemory(address uint16) byte {
+	return z.memory.data[address]
+}
+
+// WriteMemory writes a byte without the CPU
+func (z *RemogattoZ80) WriteMemory(address uint16, value byte) {
+	z.memory.poke(address, value)
+}
+
+// SetHooks replaces the hooks. The port hooks are the handlers
+// SetIOHandlers sets, so RemogattoZ80WithScreen's screen hooks are
+// replaced too.
+func (z *RemogattoZ80) SetHooks(hooks Hooks) {
+	z.SetIOHandlers(hooks.PortRead, hooks.PortWrite)
+	z.memory.readHook = hooks.MemoryRead
+	z.memory.writeHook = hooks.MemoryWrite
+}
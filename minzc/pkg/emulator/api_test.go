@@ -0,0 +1,81 @@
+package emulator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEmulatorAPI(t *testing.T) {
+	code := []byte{
+		0xDB, 0x10, // IN A, ($10)
+		0x32, 0x00, 0x90, // LD ($9000), A
+		0x3A, 0x01, 0x90, // LD A, ($9001)
+		0xD3, 0x20, // OUT ($20), A
+		0x21, 0x34, 0x12, // LD HL, $1234
+		0xF3, 0x76, // DI; HALT
+	}
+
+	var emu Emulator = NewEmulator()
+	if err := emu.LoadAt(0x8000, code); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	emu.WriteMemory(0x9001, 0x5A)
+	if err := emu.SetRegister("PC", 0x8000); err != nil {
+		t.Fatalf("set PC: %v", err)
+	}
+
+	var portWrites [][2]uint16
+	var reads, writes []uint16
+	emu.SetHooks(Hooks{
+		PortRead:    func(port uint16) byte { return byte(port) + 1 },
+		PortWrite:   func(port uint16, value byte) { portWrites = append(portWrites, [2]uint16{port & 0xFF, uint16(value)}) },
+		MemoryRead:  func(address uint16, value byte) { reads = append(reads, address) },
+		MemoryWrite: func(address uint16, value byte) { writes = append(writes, address) },
+	})
+
+	if cycles := emu.Step(); cycles == 0 || emu.GetCycles() != cycles {
+		t.Errorf("IN A, (n) took %d T-states, %d counted", cycles, emu.GetCycles())
+	}
+	if a := emu.Registers().A; a != 0x11 {
+		t.Errorf("A = $%02X after IN from the PortRead hook, want $11", a)
+	}
+	if err := emu.Run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if !emu.IsHalted() || emu.Registers().HL != 0x1234 {
+		t.Errorf("halted %v, HL = $%04X", emu.IsHalted(), emu.Registers().HL)
+	}
+	if got := emu.ReadMemory(0x9000); got != 0x11 {
+		t.Errorf("($9000) = $%02X, want $11", got)
+	}
+	if want := [][2]uint16{{0x20, 0x5A}}; !reflect.DeepEqual(portWrites, want) {
+		t.Errorf("port writes %v, want %v", portWrites, want)
+	}
+	if want := []uint16{0x9000}; !reflect.DeepEqual(writes, want) {
+		t.Errorf("memory writes %v, want %v", writes, want)
+	}
+	// Every byte of the program is fetched, and $9001 is read as data
+	seen := make(map[uint16]bool)
+	for _, address := range reads {
+		seen[address] = true
+	}
+	for address := uint16(0x8000); address < 0x8000+uint16(len(code)); address++ {
+		if !seen[address] {
+			t.Errorf("read of $%04X not hooked", address)
+		}
+	}
+	if !seen[0x9001] || seen[0x9000] {
+		t.Errorf("data reads %v", reads)
+	}
+
+	// The zero Hooks removes them; unanswered ports read $FF again
+	emu.SetHooks(Hooks{})
+	emu.Reset()
+	emu.LoadAt(0x8000, code)
+	emu.SetRegister("PC", 0x8000)
+	emu.Step()
+	if a := emu.Registers().A; a != 0xFF {
+		t.Errorf("A = $%02X without hooks, want $FF", a)
+	}
+}
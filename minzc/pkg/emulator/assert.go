@@ -0,0 +1,164 @@
+package emulator
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Assertions are post-conditions checked when execution stops, so a script
+// can run a compiled program and tell from mze's exit code whether it left
+// the right results:
+//
+//	--assert $8000=$42          byte at $8000
+//	--assert result=$1234       little-endian word at a --dbg symbol
+//	--assert-reg A=$42,HL=300   registers, as --registers sets them
+//	--assert-cycles-max 5000    at most 5000 T-states
+//
+// A hex value with more than two digits, or a decimal one above 255,
+// checks a word.
+
+// AssertionKind is what an assertion checks
+type AssertionKind int
+
+const (
+	AssertMemory AssertionKind = iota
+	AssertRegister
+	AssertCyclesMax
+)
+
+// Assertion is one post-condition
+type Assertion struct {
+	Kind  AssertionKind
+	Name  string // Register, or the symbol at Addr
+	Addr  uint16
+	Size  int // Bytes of memory: 1, or 2 for a word
+	Value uint64
+}
+
+// AssertionFailure is an assertion that did not hold, with what was found
+type AssertionFailure struct {
+	Assertion
+	Got uint64
+}
+
+// ParseMemoryAssertion parses ADDR=VALUE. The address is a number or one
+// of symbols.
+func ParseMemoryAssertion(spec string, symbols map[string]uint16) (Assertion, error) {
+	name, text, ok := strings.Cut(spec, "=")
+	if !ok {
+		return Assertion{}, fmt.Errorf("memory assertion %q is not ADDR=VALUE", spec)
+	}
+	name, text = strings.TrimSpace(name), strings.TrimSpace(text)
+	addr, err := ParseValue(name)
+	if err == nil {
+		name = ""
+	} else if addr, ok = symbols[name]; !ok {
+		return Assertion{}, fmt.Errorf("memory assertion %q: %q is not an address or known symbol", spec, name)
+	}
+	value, err := ParseValue(text)
+	if err != nil {
+		return Assertion{}, fmt.Errorf("memory assertion %q: %v", spec, err)
+	}
+	size := 1
+	if value > 0xFF || hexDigits(text) > 2 {
+		size = 2
+	}
+	return Assertion{Kind: AssertMemory, Name: name, Addr: addr, Size: size, Value: uint64(value)}, nil
+}
+
+// hexDigits returns the number of digits of a $ or 0x hex value, or 0
+func hexDigits(text string) int {
+	switch {
+	case strings.HasPrefix(text, "$"):
+		return len(text) - 1
+	case strings.HasPrefix(strings.ToLower(text), "0x"):
+		return len(text) - 2
+	}
+	return 0
+}
+
+// ParseRegisterAssertions parses a register list like ParseRegisters
+func ParseRegisterAssertions(spec string) ([]Assertion, error) {
+	values, err := ParseRegisters(spec)
+	if err != nil {
+		return nil, err
+	}
+	assertions := make([]Assertion, len(values))
+	for i, v := range values {
+		assertions[i] = Assertion{Kind: AssertRegister, Name: v.Name, Value: uint64(v.Value)}
+	}
+	return assertions, nil
+}
+
+// CyclesMaxAssertion returns an assertion that execution took at most max
+// T-states
+func CyclesMaxAssertion(max uint64) Assertion {
+	return Assertion{Kind: AssertCyclesMax, Name: "cycles", Value: max}
+}
+
+// CheckAssertions returns the assertions that do not hold once execution
+// has stopped
+func (z *RemogattoZ80) CheckAssertions(assertions []Assertion) []AssertionFailure {
+	var failures []AssertionFailure
+	regs := z.GetRegisters()
+	for _, a := range assertions {
+		var got uint64
+		switch a.Kind {
+		case AssertMemory:
+			got = uint64(z.GetMemory(a.Addr))
+			if a.Size == 2 {
+				got |= uint64(z.GetMemory(a.Addr+1)) << 8
+			}
+		case AssertRegister:
+			value, _ := regs.Register(a.Name)
+			got = uint64(value)
+		case AssertCyclesMax:
+			got = uint64(z.GetCycles())
+		}
+		failed := got != a.Value
+		if a.Kind == AssertCyclesMax {
+			failed = got > a.Value
+		}
+		if failed {
+			failures = append(failures, AssertionFailure{a, got})
+		}
+	}
+	return failures
+}
+
+// WriteAssertionReport writes failed assertions as a diff: a - line with
+// what was asserted, a + line with what was found
+func WriteAssertionReport(w io.Writer, failures []AssertionFailure, total int) {
+	fmt.Fprintf(w, "❌ %d of %d assertions failed\n", len(failures), total)
+	fmt.Fprintln(w, "--- asserted")
+	fmt.Fprintln(w, "+++ actual")
+	for _, f := range failures {
+		want, got := f.describe(f.Value, "="), f.describe(f.Got, "=")
+		if f.Kind == AssertCyclesMax {
+			want = f.describe(f.Value, "<=")
+		}
+		fmt.Fprintf(w, "-%s\n+%s\n", want, got)
+	}
+}
+
+// describe formats an assertion's subject with a value
+func (a Assertion) describe(value uint64, op string) string {
+	switch a.Kind {
+	case AssertMemory:
+		name := fmt.Sprintf("$%04X", a.Addr)
+		if a.Name != "" {
+			name = fmt.Sprintf("%s (%s)", a.Name, name)
+		}
+		if a.Size == 2 {
+			return fmt.Sprintf("word %s %s $%04X", name, op, value)
+		}
+		return fmt.Sprintf("byte %s %s $%02X", name, op, value)
+	case AssertRegister:
+		if registerSizes[a.Name] == 1 {
+			return fmt.Sprintf("%s %s $%02X", a.Name, op, value)
+		}
+		return fmt.Sprintf("%s %s $%04X", a.Name, op, value)
+	}
+	return fmt.Sprintf("%s %s %d", a.Name, op, value)
+}
@@ -0,0 +1,84 @@
+package emulator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseMemoryAssertion(t *testing.T) {
+	symbols := map[string]uint16{"result": 0x9000}
+	for spec, want := range map[string]Assertion{
+		"$8000=$42":     {Kind: AssertMemory, Addr: 0x8000, Size: 1, Value: 0x42},
+		"0x8000=0x0042": {Kind: AssertMemory, Addr: 0x8000, Size: 2, Value: 0x42},
+		"32768=300":     {Kind: AssertMemory, Addr: 0x8000, Size: 2, Value: 300},
+		"result = 7":    {Kind: AssertMemory, Name: "result", Addr: 0x9000, Size: 1, Value: 7},
+	} {
+		got, err := ParseMemoryAssertion(spec, symbols)
+		if err != nil {
+			t.Errorf("%q: %v", spec, err)
+		} else if got != want {
+			t.Errorf("%q = %+v, want %+v", spec, got, want)
+		}
+	}
+
+	for spec, msg := range map[string]string{
+		"$8000":      "is not ADDR=VALUE",
+		"missing=1":  "not an address or known symbol",
+		"$8000=zero": "invalid value",
+	} {
+		if _, err := ParseMemoryAssertion(spec, symbols); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: error %v, want %q", spec, err, msg)
+		}
+	}
+}
+
+func TestCheckAssertions(t *testing.T) {
+	z := NewRemogattoZ80()
+	z.LoadMemory(0x8000, []byte{
+		0x3E, 0x42, // LD A, $42
+		0x32, 0x10, 0x80, // LD ($8010), A
+		0x21, 0x34, 0x12, // LD HL, $1234
+		0x22, 0x12, 0x80, // LD ($8012), HL
+		0xF3, 0x76, // DI; HALT
+	})
+	z.SetPC(0x8000)
+	if err := z.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	regs, err := ParseRegisterAssertions("A=$42,HL=$1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	passing := append(regs,
+		Assertion{Kind: AssertMemory, Addr: 0x8010, Size: 1, Value: 0x42},
+		Assertion{Kind: AssertMemory, Addr: 0x8012, Size: 2, Value: 0x1234},
+		CyclesMaxAssertion(uint64(z.GetCycles())),
+	)
+	if failures := z.CheckAssertions(passing); len(failures) != 0 {
+		t.Errorf("unexpected failures: %+v", failures)
+	}
+
+	failing := []Assertion{
+		{Kind: AssertMemory, Name: "result", Addr: 0x8012, Size: 2, Value: 0x1235},
+		{Kind: AssertRegister, Name: "A", Value: 0x41},
+		CyclesMaxAssertion(10),
+	}
+	failures := z.CheckAssertions(failing)
+	if len(failures) != 3 {
+		t.Fatalf("%d failures, want 3: %+v", len(failures), failures)
+	}
+	var report bytes.Buffer
+	WriteAssertionReport(&report, failures, len(failing))
+	for _, want := range []string{
+		"3 of 3 assertions failed\n",
+		"-word result ($8012) = $1235\n+word result ($8012) = $1234\n",
+		"-A = $41\n+A = $42\n",
+		"-cycles <= 10\n+cycles = ",
+	} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("report is missing %q:\n%s", want, report.String())
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package emulator
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// The AY-3-8912 programmable sound generator of the 128K Spectrum (and of
+// the add-on interfaces for the 48K) has three square-wave tone channels,
+// a noise generator and an envelope generator, driven by 16 registers:
+//
+//	R0-R5   tone periods of channels A, B and C (12 bits, low byte first)
+//	R6      noise period (5 bits)
+//	R7      mixer: bits 0-2 disable tone, bits 3-5 disable noise on A-C
+//	R8-R10  channel amplitude (bits 0-3), or the envelope when bit 4 is set
+//	R11-R12 envelope period (16 bits); R13 envelope shape, restarting it
+//	R14-R15 I/O ports
+//
+// A write to port $FFFD selects a register, which a read of $FFFD returns
+// and a write to $BFFD sets. The chip decodes only A15, A14 and A1. It runs
+// at half the CPU clock and its generators advance every 8 of its cycles,
+// that is every 16 T-states.
+const (
+	ayPortMask     = 0xC002
+	ayRegisterPort = 0xC000 // $FFFD
+	ayDataPort     = 0x8000 // $BFFD
+
+	ayTickTstates = 16      // T-states per generator step
+	cpuClock      = 3500000 // T-states per second
+
+	// AudioSampleRate is the sample rate of the recorded output
+	AudioSampleRate = 44100
+)
+
+// ayRegisterMasks are the bits each register implements
+var ayRegisterMasks = [16]byte{
+	0xFF, 0x0F, 0xFF, 0x0F, 0xFF, 0x0F, 0x1F, 0xFF,
+	0x1F, 0x1F, 0x1F, 0xFF, 0xFF, 0x0F, 0xFF, 0xFF,
+}
+
+// ayLevels is the output of the chip's logarithmic DAC at each amplitude
+var ayLevels = [16]float64{
+	0, 0.00999, 0.01445, 0.02106, 0.03070, 0.04555, 0.06450, 0.10736,
+	0.12659, 0.20499, 0.29221, 0.37284, 0.49253, 0.63532, 0.80558, 1,
+}
+
+// AY emulates the AY-3-8912 and records its output as 16-bit samples
+type AY struct {
+	registers [16]byte
+	selected  byte
+
+	tstates *int // The CPU's T-state counter
+	time    int  // Counter value the generators have been run to
+
+	tone      [3]ayCounter
+	toneHigh  [3]bool
+	noise     ayCounter
+	noiseLFSR uint32
+
+	envelope        ayCounter
+	envelopeStep    int
+	envelopeAttack  bool
+	envelopeHolding bool
+
+	// Output of the current sample so far, and the samples recorded since
+	// the counter value origin
+	recording bool
+	origin    int
+	sum       float64
+	ticks     int
+	samples   []int16
+}
+
+// ayCounter counts generator steps up to a period
+type ayCounter struct {
+	count int
+}
+
+// step advances the counter and reports whether it reached period
+func (c *ayCounter) step(period int) bool {
+	c.count++
+	if c.count >= max(period, 1) {
+		c.count = 0
+		return true
+	}
+	return false
+}
+
+// EnableAY attaches an AY-3-8912 to ports $FFFD and $BFFD and returns it.
+// With record set, its output is kept for WriteWAV.
+func (z *RemogattoZ80) EnableAY(record bool) *AY {
+	if z.ay == nil {
+		z.ay = &AY{tstates: &z.cpu.Tstates, time: z.cpu.Tstates, noiseLFSR: 1, envelopeStep: 15, envelopeHolding: true}
+		z.ports.ay = z.ay
+	}
+	z.ay.recording = record
+	z.ay.origin = z.ay.time
+	return z.ay
+}
+
+// Register returns the value of register r
+func (a *AY) Register(r int) byte {
+	return a.registers[r&0x0F]
+}
+
+// readPort answers a read of the register port. Selecting a register past
+// R15 deselects the chip, which then leaves the bus alone.
+func (a *AY) readPort(port uint16) (byte, bool) {
+	if port&ayPortMask != ayRegisterPort || a.selected > 15 {
+		return 0, false
+	}
+	return a.registers[a.selected], true
+}
+
+// writePort selects or sets a register
+func (a *AY) writePort(port uint16, value byte) {
+	switch port & ayPortMask {
+	case ayRegisterPort:
+		a.selected = value
+	case ayDataPort:
+		if a.selected > 15 {
+			return
+		}
+		a.update()
+		a.registers[a.selected] = value & ayRegisterMasks[a.selected]
+		if a.selected == 13 {
+			a.restartEnvelope()
+		}
+	}
+}
+
+// update runs the generators up to the current T-state, recording the
+// output on the way, so each register change takes effect when it is made
+func (a *AY) update() {
+	for a.time+ayTickTstates <= *a.tstates {
+		a.time += ayTickTstates
+		a.tick()
+		if !a.recording {
+			continue
+		}
+		a.sum += a.output()
+		a.ticks++
+		sampleEnd := a.origin + int(int64(len(a.samples)+1)*cpuClock/AudioSampleRate)
+		if a.time >= sampleEnd {
+			a.samples = append(a.samples, int16(a.sum/float64(a.ticks)*32767))
+			a.sum, a.ticks = 0, 0
+		}
+	}
+}
+
+// tick advances the tone, noise and envelope generators by one step
+func (a *AY) tick() {
+	for ch := range a.tone {
+		period := int(a.registers[2*ch]) | int(a.registers[2*ch+1])<<8
+		if a.tone[ch].step(period) {
+			a.toneHigh[ch] = !a.toneHigh[ch]
+		}
+	}
+
+	// Noise changes at half the rate of a tone of the same period
+	if a.noise.step(2 * int(a.registers[6])) {
+		bit := (a.noiseLFSR ^ a.noiseLFSR>>3) & 1
+		a.noiseLFSR = a.noiseLFSR>>1 | bit<<16
+	}
+
+	// The envelope takes 16 steps per cycle, each twice its period long
+	envelopePeriod := int(a.registers[11]) | int(a.registers[12])<<8
+	if a.envelope.step(2 * envelopePeriod) {
+		a.advanceEnvelope()
+	}
+}
+
+// restartEnvelope starts the shape just written to R13
+func (a *AY) restartEnvelope() {
+	a.envelope.count = 0
+	a.envelopeStep = 0
+	a.envelopeAttack = a.registers[13]&0x04 != 0
+	a.envelopeHolding = false
+}
+
+// advanceEnvelope moves the envelope on one step. At the end of a cycle
+// the shape bits decide: without CONTINUE (bit 3) it drops to 0 and holds;
+// ALTERNATE (bit 1) reverses the direction; HOLD (bit 0) stops it there.
+func (a *AY) advanceEnvelope() {
+	if a.envelopeHolding {
+		return
+	}
+	a.envelopeStep++
+	if a.envelopeStep < 16 {
+		return
+	}
+	shape := a.registers[13]
+	switch {
+	case shape&0x08 == 0:
+		a.envelopeAttack, a.envelopeStep, a.envelopeHolding = false, 15, true
+	case shape&0x01 != 0:
+		if shape&0x02 != 0 {
+			a.envelopeAttack = !a.envelopeAttack
+		}
+		a.envelopeStep, a.envelopeHolding = 15, true
+	default:
+		if shape&0x02 != 0 {
+			a.envelopeAttack = !a.envelopeAttack
+		}
+		a.envelopeStep = 0
+	}
+}
+
+// envelopeLevel returns the envelope's current amplitude
+func (a *AY) envelopeLevel() int {
+	if a.envelopeAttack {
+		return a.envelopeStep
+	}
+	return 15 - a.envelopeStep
+}
+
+// output returns the mix of the three channels, from 0 to 1
+func (a *AY) output() float64 {
+	mixer := a.registers[7]
+	noiseHigh := a.noiseLFSR&1 != 0
+	var sum float64
+	for ch := 0; ch < 3; ch++ {
+		toneOn := a.toneHigh[ch] || mixer&(1<<ch) != 0
+		noiseOn := noiseHigh || mixer&(8<<ch) != 0
+		if !toneOn || !noiseOn {
+			continue
+		}
+		amplitude := a.registers[8+ch]
+		level := int(amplitude & 0x0F)
+		if amplitude&0x10 != 0 {
+			level = a.envelopeLevel()
+		}
+		sum += ayLevels[level]
+	}
+	return sum / 3
+}
+
+// Samples returns the output recorded up to the current T-state, at
+// AudioSampleRate
+func (a *AY) Samples() []int16 {
+	a.update()
+	return a.samples
+}
+
+// WriteWAV writes the recorded output as a 16-bit mono PCM WAV file
+func (a *AY) WriteWAV(w io.Writer) error {
+	samples := a.Samples()
+	dataSize := uint32(2 * len(samples))
+	fields := []any{
+		[4]byte{'R', 'I', 'F', 'F'}, 36 + dataSize, [4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '}, uint32(16),
+		uint16(1),                   // PCM
+		uint16(1),                   // Mono
+		uint32(AudioSampleRate),     // Sample rate
+		uint32(AudioSampleRate * 2), // Byte rate
+		uint16(2),                   // Block align
+		uint16(16),                  // Bits per sample
+		[4]byte{'d', 'a', 't', 'a'}, dataSize,
+		samples,
+	}
+	for _, field := range fields {
+		if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// ayWrite is Z80 code setting AY register r to value
+func ayWrite(r, value byte) []byte {
+	return []byte{
+		0x01, 0xFD, 0xFF, // LD BC, $FFFD
+		0x3E, r, // LD A, r
+		0xED, 0x79, // OUT (C), A
+		0x06, 0xBF, // LD B, $BF
+		0x3E, value, // LD A, value
+		0xED, 0x79, // OUT (C), A
+	}
+}
+
+func TestAYTone(t *testing.T) {
+	var program []byte
+	program = append(program, ayWrite(0, 125)...)  // Channel A: 1750000 / (16 * 125) = 875 Hz
+	program = append(program, ayWrite(1, 0xF0)...) // Only the low nibble exists
+	program = append(program, ayWrite(7, 0x3E)...) // Tone on A only
+	program = append(program, ayWrite(8, 15)...)
+	program = append(program,
+		0x01, 0xFD, 0xFF, // LD BC, $FFFD
+		0x3E, 0x01, // LD A, 1
+		0xED, 0x79, // OUT (C), A
+		0xED, 0x50, // IN D, (C)
+		0x21, 0x96, 0x34, // LD HL, 13462: 26 T-states a loop, 0.1 s
+		0x2B,       // loop: DEC HL
+		0x7C,       // LD A, H
+		0xB5,       // OR L
+		0x20, 0xFB, // JR NZ, loop
+		0xF3, 0x76, // DI; HALT
+	)
+
+	z := NewRemogattoZ80()
+	ay := z.EnableAY(true)
+	z.LoadMemory(0x8000, program)
+	z.SetPC(0x8000)
+	if err := z.Run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if d := z.cpu.D; d != 0x00 {
+		t.Errorf("R1 read back as $%02X, want $00", d)
+	}
+	if r := ay.Register(0); r != 125 {
+		t.Errorf("R0 = %d, want 125", r)
+	}
+
+	samples := ay.Samples()
+	if len(samples) < AudioSampleRate/10 || len(samples) > AudioSampleRate/10+50 {
+		t.Fatalf("%d samples for 0.1 s, want about %d", len(samples), AudioSampleRate/10)
+	}
+	rises := 0
+	for i := 1; i < len(samples); i++ {
+		if samples[i-1] < 1000 && samples[i] >= 1000 {
+			rises++
+		}
+	}
+	if rises < 85 || rises > 89 {
+		t.Errorf("%d cycles in 0.1 s, want 87 for 875 Hz", rises)
+	}
+
+	var wav bytes.Buffer
+	if err := ay.WriteWAV(&wav); err != nil {
+		t.Fatal(err)
+	}
+	data := wav.Bytes()
+	if string(data[0:4]) != "RIFF" || string(data[8:16]) != "WAVEfmt " || string(data[36:40]) != "data" {
+		t.Errorf("bad WAV header % X", data[:44])
+	}
+	if size := binary.LittleEndian.Uint32(data[40:44]); int(size) != 2*len(samples) || len(data) != 44+2*len(samples) {
+		t.Errorf("WAV data size %d for %d samples", size, len(samples))
+	}
+	if rate := binary.LittleEndian.Uint32(data[24:28]); rate != AudioSampleRate {
+		t.Errorf("WAV sample rate %d", rate)
+	}
+}
+
+func TestAYEnvelopeShapes(t *testing.T) {
+	for _, tt := range []struct {
+		shape byte
+		want  map[int]int // Level at each step
+	}{
+		{0x00, map[int]int{0: 15, 15: 0, 16: 0, 47: 0}},                 // \___
+		{0x04, map[int]int{0: 0, 15: 15, 16: 0, 47: 0}},                 // /___
+		{0x08, map[int]int{0: 15, 15: 0, 16: 15, 31: 0}},                // \\\\
+		{0x0B, map[int]int{0: 15, 15: 0, 16: 15, 47: 15}},               // \¯¯¯
+		{0x0D, map[int]int{0: 0, 15: 15, 16: 15, 47: 15}},               // /¯¯¯
+		{0x0E, map[int]int{0: 0, 15: 15, 16: 15, 31: 0, 32: 0, 47: 15}}, // /\/\
+	} {
+		a := &AY{}
+		a.registers[13] = tt.shape
+		a.restartEnvelope()
+		for step := 0; step < 48; step++ {
+			if want, ok := tt.want[step]; ok && a.envelopeLevel() != want {
+				t.Errorf("shape $%02X: level %d at step %d, want %d", tt.shape, a.envelopeLevel(), step, want)
+			}
+			a.advanceEnvelope()
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package emulator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CallSentinel is the return address Call pushes. A RET that pops it back
+// to the stack pointer Call started from ends the call.
+const CallSentinel uint16 = 0xFFFF
+
+// RegisterValue is one NAME=VALUE pair of a register list
+type RegisterValue struct {
+	Name  string
+	Value uint16
+}
+
+// registerSizes lists the registers that can be set and read by name, with
+// their size in bytes
+var registerSizes = map[string]int{
+	"A": 1, "F": 1, "B": 1, "C": 1, "D": 1, "E": 1, "H": 1, "L": 1,
+	"AF": 2, "BC": 2, "DE": 2, "HL": 2, "IX": 2, "IY": 2, "SP": 2, "PC": 2,
+}
+
+// ParseRegisters parses a comma-separated list of register assignments such
+// as "A=5,HL=$9000,BC=0x10". Values are decimal, or hex with a $ or 0x
+// prefix, and must fit the register.
+func ParseRegisters(spec string) ([]RegisterValue, error) {
+	var values []RegisterValue
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		name, text, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("register assignment %q is not NAME=VALUE", field)
+		}
+		name = strings.ToUpper(strings.TrimSpace(name))
+		size, known := registerSizes[name]
+		if !known {
+			return nil, fmt.Errorf("unknown register %q", name)
+		}
+		value, err := ParseValue(strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("register %s: %v", name, err)
+		}
+		if size == 1 && value > 0xFF {
+			return nil, fmt.Errorf("register %s: value $%X does not fit in 8 bits", name, value)
+		}
+		values = append(values, RegisterValue{Name: name, Value: value})
+	}
+	return values, nil
+}
+
+// ParseValue parses a 16-bit decimal, $hex or 0x hex value
+func ParseValue(text string) (uint16, error) {
+	base := 10
+	switch {
+	case strings.HasPrefix(text, "$"):
+		text, base = text[1:], 16
+	case strings.HasPrefix(strings.ToLower(text), "0x"):
+		text, base = text[2:], 16
+	}
+	value, err := strconv.ParseUint(text, base, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", text)
+	}
+	return uint16(value), nil
+}
+
+// SetRegister sets a register by name (see ParseRegisters)
+func (z *RemogattoZ80) SetRegister(name string, value uint16) error {
+	cpu := z.cpu
+	lo, hi := byte(value), byte(value>>8)
+	switch strings.ToUpper(name) {
+	case "A":
+		cpu.A = lo
+	case "F":
+		cpu.F = lo
+	case "B":
+		cpu.B = lo
+	case "C":
+		cpu.C = lo
+	case "D":
+		cpu.D = lo
+	case "E":
+		cpu.E = lo
+	case "H":
+		cpu.H = lo
+	case "L":
+		cpu.L = lo
+	case "AF":
+		cpu.A, cpu.F = hi, lo
+	case "BC":
+		cpu.SetBC(value)
+	case "DE":
+		cpu.SetDE(value)
+	case "HL":
+		cpu.SetHL(value)
+	case "IX":
+		cpu.SetIX(value)
+	case "IY":
+		cpu.SetIY(value)
+	case "SP":
+		cpu.SetSP(value)
+	case "PC":
+		cpu.SetPC(value)
+	default:
+		return fmt.Errorf("unknown register %q", name)
+	}
+	return nil
+}
+
+// Register reads a register by name (see ParseRegisters)
+func (r Registers) Register(name string) (uint16, error) {
+	switch strings.ToUpper(name) {
+	case "A":
+		return uint16(r.A), nil
+	case "F":
+		return uint16(r.F), nil
+	case "B":
+		return r.BC >> 8, nil
+	case "C":
+		return r.BC & 0xFF, nil
+	case "D":
+		return r.DE >> 8, nil
+	case "E":
+		return r.DE & 0xFF, nil
+	case "H":
+		return r.HL >> 8, nil
+	case "L":
+		return r.HL & 0xFF, nil
+	case "AF":
+		return uint16(r.A)<<8 | uint16(r.F), nil
+	case "BC":
+		return r.BC, nil
+	case "DE":
+		return r.DE, nil
+	case "HL":
+		return r.HL, nil
+	case "IX":
+		return r.IX, nil
+	case "IY":
+		return r.IY, nil
+	case "SP":
+		return r.SP, nil
+	case "PC":
+		return r.PC, nil
+	}
+	return 0, fmt.Errorf("unknown register %q", name)
+}
+
+// Call runs the routine at addr as if called from elsewhere: it pushes
+// CallSentinel as the return address and runs until the routine returns
+// through it, leaving PC at CallSentinel and SP where it was before the
+// call. A routine that stops any other way (HALT, RST 38h, running off to
+// $0000) is an error.
+func (z *RemogattoZ80) Call(addr uint16) error {
+	sp := z.cpu.SP()
+	z.memory.data[sp-2] = byte(CallSentinel & 0xFF)
+	z.memory.data[sp-1] = byte(CallSentinel >> 8)
+	z.cpu.SetSP(sp - 2)
+	z.cpu.SetPC(addr)
+
+	z.calling, z.callSP, z.returned = true, sp, false
+	defer func() { z.calling = false }()
+	if err := z.Run(); err != nil {
+		return err
+	}
+	if !z.returned {
+		return fmt.Errorf("routine at $%04X stopped at $%04X without returning", addr, z.cpu.PC())
+	}
+	return nil
+}
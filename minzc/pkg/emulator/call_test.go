@@ -0,0 +1,68 @@
+package emulator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCall(t *testing.T) {
+	z := NewRemogattoZ80()
+	z.LoadMemory(0x8000, []byte{0xF3, 0x76}) // DI; HALT
+	z.LoadMemory(0x8100, []byte{
+		0x85, // ADD A, L
+		0xC9, // RET
+	})
+
+	regs, err := ParseRegisters("a=5, L=$07, SP=0xFF00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range regs {
+		if err := z.SetRegister(r.Name, r.Value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := z.Call(0x8100); err != nil {
+		t.Fatalf("call failed: %v\n%s", err, z.DumpState())
+	}
+
+	got := z.GetRegisters()
+	if a, _ := got.Register("A"); a != 12 {
+		t.Errorf("A = %d, want 12", a)
+	}
+	if got.PC != CallSentinel || got.SP != 0xFF00 {
+		t.Errorf("PC=$%04X SP=$%04X after return, want PC=$%04X SP=$FF00", got.PC, got.SP, CallSentinel)
+	}
+
+	// A routine that halts instead of returning
+	if err := z.Call(0x8000); err == nil || !strings.Contains(err.Error(), "without returning") {
+		t.Errorf("error = %v, want a routine that did not return", err)
+	}
+}
+
+func TestParseRegisters(t *testing.T) {
+	regs, err := ParseRegisters("A=$0C,hl=0x9000,BC=300")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RegisterValue{{"A", 0x0C}, {"HL", 0x9000}, {"BC", 300}}
+	if len(regs) != len(want) {
+		t.Fatalf("got %v, want %v", regs, want)
+	}
+	for i := range want {
+		if regs[i] != want[i] {
+			t.Errorf("register %d = %v, want %v", i, regs[i], want[i])
+		}
+	}
+
+	for spec, msg := range map[string]string{
+		"A":       "is not NAME=VALUE",
+		"Q=1":     "unknown register",
+		"A=300":   "does not fit in 8 bits",
+		"HL=$XYZ": "invalid value",
+	} {
+		if _, err := ParseRegisters(spec); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("ParseRegisters(%q) error = %v, want %q", spec, err, msg)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package emulator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Coverage records which addresses were executed at least once.
+// One bit per address covers the full 64K address space.
+type Coverage struct {
+	bitmap [65536 / 8]byte
+}
+
+// NewCoverage creates an empty coverage map
+func NewCoverage() *Coverage {
+	return &Coverage{}
+}
+
+// Mark records a single executed address
+func (c *Coverage) Mark(addr uint16) {
+	c.bitmap[addr>>3] |= 1 << (addr & 7)
+}
+
+// IsHit reports whether an address was executed
+func (c *Coverage) IsHit(addr uint16) bool {
+	return c.bitmap[addr>>3]&(1<<(addr&7)) != 0
+}
+
+// Record marks the bytes of the instruction executed at pc.
+// Control transfers are sized from the opcode; everything else
+// falls through, so its length is the distance to the new PC.
+func (c *Coverage) Record(pc, newPC uint16, opcode byte) {
+	length, ok := branchLength(opcode)
+	if !ok {
+		length = newPC - pc
+		if length == 0 || length > 4 {
+			length = 2 // prefixed: JP (IX/IY), RETI/RETN, repeating block ops
+		}
+	}
+	for i := uint16(0); i < length; i++ {
+		c.Mark(pc + i)
+	}
+}
+
+// branchLength returns the size of an unprefixed control transfer instruction
+func branchLength(opcode byte) (uint16, bool) {
+	switch {
+	case opcode == 0xC3, opcode == 0xCD: // JP nn, CALL nn
+		return 3, true
+	case opcode&0xC7 == 0xC2, opcode&0xC7 == 0xC4: // JP cc,nn / CALL cc,nn
+		return 3, true
+	case opcode == 0x18, opcode == 0x10: // JR e, DJNZ e
+		return 2, true
+	case opcode&0xE7 == 0x20: // JR cc,e
+		return 2, true
+	case opcode == 0xC9, opcode == 0xE9, opcode == 0x76: // RET, JP (HL), HALT
+		return 1, true
+	case opcode&0xC7 == 0xC0, opcode&0xC7 == 0xC7: // RET cc, RST n
+		return 1, true
+	}
+	return 0, false
+}
+
+// AddressRange is a contiguous run of executed or unexecuted addresses
+type AddressRange struct {
+	Start uint16
+	End   uint16 // inclusive
+	Hit   bool
+}
+
+// Ranges splits [start, end] into runs of executed and unexecuted addresses
+func (c *Coverage) Ranges(start, end uint16) []AddressRange {
+	var ranges []AddressRange
+	if end < start {
+		return ranges
+	}
+
+	current := AddressRange{Start: start, End: start, Hit: c.IsHit(start)}
+	for addr := uint32(start) + 1; addr <= uint32(end); addr++ {
+		hit := c.IsHit(uint16(addr))
+		if hit == current.Hit {
+			current.End = uint16(addr)
+			continue
+		}
+		ranges = append(ranges, current)
+		current = AddressRange{Start: uint16(addr), End: uint16(addr), Hit: hit}
+	}
+	return append(ranges, current)
+}
+
+// Count returns the number of executed addresses in [start, end]
+func (c *Coverage) Count(start, end uint16) int {
+	count := 0
+	for addr := uint32(start); addr <= uint32(end); addr++ {
+		if c.IsHit(uint16(addr)) {
+			count++
+		}
+	}
+	return count
+}
+
+// Percent returns the executed share of [start, end] as a percentage
+func (c *Coverage) Percent(start, end uint16) float64 {
+	if end < start {
+		return 0
+	}
+	total := int(end) - int(start) + 1
+	return float64(c.Count(start, end)) * 100 / float64(total)
+}
+
+// UnexecutedSymbols returns the symbols in [start, end] whose address was never executed,
+// sorted by address. With a symbol table from the assembler these are the functions
+// the program never entered.
+func (c *Coverage) UnexecutedSymbols(start, end uint16, symbols map[string]uint16) []string {
+	var names []string
+	for name, addr := range symbols {
+		if addr >= start && addr <= end && !c.IsHit(addr) {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if symbols[names[i]] != symbols[names[j]] {
+			return symbols[names[i]] < symbols[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// WriteReport writes a coverage report for [start, end].
+// symbols may be nil; when present, ranges are annotated with the enclosing symbol.
+func (c *Coverage) WriteReport(w io.Writer, start, end uint16, symbols map[string]uint16) error {
+	total := int(end) - int(start) + 1
+	fmt.Fprintf(w, "MinZ Z80 Coverage Report\n")
+	fmt.Fprintf(w, "========================\n")
+	fmt.Fprintf(w, "Range:    $%04X-$%04X\n", start, end)
+	fmt.Fprintf(w, "Executed: %d/%d bytes (%.1f%%)\n\n", c.Count(start, end), total, c.Percent(start, end))
+
+	for _, r := range c.Ranges(start, end) {
+		status := "not hit"
+		if r.Hit {
+			status = "hit"
+		}
+		line := fmt.Sprintf("$%04X-$%04X  %-7s  %d bytes", r.Start, r.End, status, int(r.End)-int(r.Start)+1)
+		if name := EnclosingSymbol(r.Start, symbols); name != "" {
+			line += "  " + name
+		}
+		fmt.Fprintln(w, line)
+	}
+
+	if symbols != nil {
+		unexecuted := c.UnexecutedSymbols(start, end, symbols)
+		fmt.Fprintf(w, "\nUnexecuted functions: %d\n", len(unexecuted))
+		for _, name := range unexecuted {
+			fmt.Fprintf(w, "  $%04X  %s\n", symbols[name], name)
+		}
+	}
+	return nil
+}
+
+// EnclosingSymbol names addr by the nearest symbol at or below it, as
+// "name" or "name+offset"; it is "" when no symbol is at or below addr
+func EnclosingSymbol(addr uint16, symbols map[string]uint16) string {
+	best := ""
+	var bestAddr uint16
+	for name, a := range symbols {
+		if a > addr {
+			continue
+		}
+		if best == "" || a > bestAddr || (a == bestAddr && name < best) {
+			best, bestAddr = name, a
+		}
+	}
+	if best != "" && bestAddr != addr {
+		return fmt.Sprintf("%s+%d", best, addr-bestAddr)
+	}
+	return best
+}
+
+// ParseSymbols reads a symbol table as written by mza -s.
+// Lines have the form "NAME = $XXXX (decimal)"; anything else is skipped.
+func ParseSymbols(r io.Reader) (map[string]uint16, error) {
+	symbols := make(map[string]uint16)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := strings.TrimSpace(parts[0])
+		fields := strings.Fields(parts[1])
+		if name == "" || len(fields) == 0 || !strings.HasPrefix(fields[0], "$") {
+			continue
+		}
+		addr, err := strconv.ParseUint(fields[0][1:], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address for symbol %s: %s", name, fields[0])
+		}
+		symbols[name] = uint16(addr)
+	}
+	return symbols, scanner.Err()
+}
@@ -0,0 +1,78 @@
+package emulator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCoverageSkippedBranch(t *testing.T) {
+	program := []byte{
+		0x3E, 0x01, // $8000 LD A, 1
+		0xFE, 0x01, // $8002 CP 1
+		0x28, 0x02, // $8004 JR Z, done
+		0x3E, 0x02, // $8006 LD A, 2     ; skipped
+		0xC9, //       $8008 done: RET
+	}
+
+	z := NewRemogattoZ80()
+	z.LoadMemory(0x8000, program)
+	z.SetSP(0xFEFE)
+	z.SetPC(0x8000)
+	coverage := z.EnableCoverage()
+	if err := z.Run(); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+
+	end := uint16(0x8000 + len(program) - 1)
+	want := []AddressRange{
+		{Start: 0x8000, End: 0x8005, Hit: true},
+		{Start: 0x8006, End: 0x8007, Hit: false},
+		{Start: 0x8008, End: 0x8008, Hit: true},
+	}
+	got := coverage.Ranges(0x8000, end)
+	if len(got) != len(want) {
+		t.Fatalf("ranges = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("range %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	symbols := map[string]uint16{"MAIN": 0x8000, "NOT_ONE": 0x8006, "DONE": 0x8008}
+	unexecuted := coverage.UnexecutedSymbols(0x8000, end, symbols)
+	if len(unexecuted) != 1 || unexecuted[0] != "NOT_ONE" {
+		t.Errorf("unexecuted = %v, want [NOT_ONE]", unexecuted)
+	}
+
+	var report bytes.Buffer
+	if err := coverage.WriteReport(&report, 0x8000, end, symbols); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"Executed: 7/9 bytes (77.8%)",
+		"$8006-$8007  not hit  2 bytes  NOT_ONE",
+		"$8006  NOT_ONE",
+	} {
+		if !strings.Contains(report.String(), line) {
+			t.Errorf("report missing %q:\n%s", line, report.String())
+		}
+	}
+}
+
+func TestParseSymbols(t *testing.T) {
+	input := `MinZ Z80 Assembler Symbol Table
+==============================
+
+MAIN                 = $8000 (32768)
+HELPER               = $8010 (32784)
+`
+	symbols, err := ParseSymbols(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(symbols) != 2 || symbols["MAIN"] != 0x8000 || symbols["HELPER"] != 0x8010 {
+		t.Errorf("symbols = %v", symbols)
+	}
+}
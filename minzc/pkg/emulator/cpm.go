@@ -0,0 +1,580 @@
+package emulator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CP/M 2.2 memory layout for .COM programs
+const (
+	CPMLoadAddr uint16 = 0x0100 // Start of the TPA, where a .COM file runs
+	BDOSEntry   uint16 = 0x0005 // CALL 5 enters the BDOS
+	cpmTPATop   uint16 = 0xFE00 // Top of the TPA, stored at $0006
+	cpmFCB1     uint16 = 0x005C // Default FCB, from the first argument
+	cpmFCB2     uint16 = 0x006C // Second FCB, from the second argument
+	cpmTail     uint16 = 0x0080 // Command tail, also the default DMA buffer
+)
+
+// cpmRecord is the size of a CP/M record, the unit of file I/O
+const cpmRecord = 128
+
+// FCB fields used by the file functions
+const (
+	fcbEX = 12 // Extent, the file position in 16K units
+	fcbS2 = 14 // Extent high bits, in 512K units
+	fcbRC = 15 // Records in the current extent
+	fcbCR = 32 // Current record within the extent
+	fcbR0 = 33 // Random record number, 3 bytes
+)
+
+// CPM emulates the CP/M 2.2 BDOS: console I/O and FCB-based file I/O
+// backed by the files of a host directory, which is drive A:. A program
+// calls it with the function number in C and a parameter in DE or E, and
+// gets a byte result in A and L or a word result in HL.
+type CPM struct {
+	Dir    string // Host directory holding the files
+	mem    []byte // The emulator's memory
+	input  *bufio.Reader
+	output io.Writer
+	dma    uint16
+	files  map[string]*os.File // Open host files by path
+	search []string            // Files left for Search Next
+}
+
+// EnableCPM sets up the zero page and stack of CP/M around a program loaded
+// at CPMLoadAddr and serves BDOS calls from then on, with console I/O on
+// input and output and files from dir. Low memory becomes writable.
+func (z *RemogattoZ80) EnableCPM(dir string, input io.Reader, output io.Writer) *CPM {
+	z.cpm = &CPM{
+		Dir:    dir,
+		mem:    z.memory.data[:],
+		input:  bufio.NewReader(input),
+		output: output,
+		dma:    cpmTail,
+		files:  make(map[string]*os.File),
+	}
+	z.memory.romEnd = 0
+
+	// JP 0 warm boots, which ends the run like a RET to $0000; CALL 5 is
+	// trapped, but programs read the top of the TPA from its JP
+	mem := z.memory.data[:]
+	copy(mem, []byte{0xC3, 0x00, 0x00, 0x00, 0x00, 0xC3, byte(cpmTPATop & 0xFF), byte(cpmTPATop >> 8)})
+	z.cpm.SetCommandLine(nil)
+
+	// Returning from the program warm boots too
+	mem[cpmTPATop-2], mem[cpmTPATop-1] = 0, 0
+	z.cpu.SetSP(cpmTPATop - 2)
+	return z.cpm
+}
+
+// SetCommandLine fills in the command tail and the two default FCBs from
+// the program's arguments, as the CCP does
+func (c *CPM) SetCommandLine(args []string) {
+	tail := strings.ToUpper(strings.Join(args, " "))
+	if tail != "" {
+		tail = " " + tail
+	}
+	if len(tail) > 127 {
+		tail = tail[:127]
+	}
+	c.mem[cpmTail] = byte(len(tail))
+	copy(c.mem[cpmTail+1:], tail)
+
+	for i, addr := range []uint16{cpmFCB1, cpmFCB2} {
+		name := ""
+		if i < len(args) {
+			name = args[i]
+		}
+		c.setFCBName(addr, name)
+	}
+}
+
+// setFCBName clears the FCB at addr and sets its drive and name, expanding
+// * to ? wildcards
+func (c *CPM) setFCBName(addr uint16, name string) {
+	mem := c.mem[addr:]
+	for i := 0; i < 16; i++ {
+		mem[i] = 0
+	}
+	for i := 1; i < 12; i++ {
+		mem[i] = ' '
+	}
+	name = strings.ToUpper(name)
+	if len(name) >= 2 && name[1] == ':' {
+		mem[0] = name[0] - 'A' + 1
+		name = name[2:]
+	}
+	base, ext, _ := strings.Cut(name, ".")
+	fill := func(field []byte, part string) {
+		for i := range field {
+			switch {
+			case i < len(part) && part[i] == '*':
+				for ; i < len(field); i++ {
+					field[i] = '?'
+				}
+				return
+			case i < len(part):
+				field[i] = part[i]
+			}
+		}
+	}
+	fill(mem[1:9], base)
+	fill(mem[9:12], ext)
+}
+
+// Close closes the host files the program left open
+func (c *CPM) Close() error {
+	var first error
+	for path, f := range c.files {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+		delete(c.files, path)
+	}
+	return first
+}
+
+// bdos serves the BDOS call at $0005 and returns to the caller. Function 0
+// stops the program instead.
+func (z *RemogattoZ80) bdos() error {
+	c, cpu := z.cpm, z.cpu
+	fn, de := cpu.C, cpu.DE()
+
+	var result uint16
+	switch fn {
+	case 0: // System reset
+		z.halted = true
+		return nil
+	case 1: // Console input, echoed
+		ch := c.readChar()
+		c.output.Write([]byte{ch})
+		result = uint16(ch)
+	case 2: // Console output
+		c.output.Write([]byte{cpu.E})
+	case 6: // Direct console I/O
+		switch cpu.E {
+		case 0xFF:
+			result = uint16(c.readChar())
+		case 0xFE: // Status: no key waiting
+		default:
+			c.output.Write([]byte{cpu.E})
+		}
+	case 9: // Print string up to $
+		for addr := de; c.mem[addr] != '$'; addr++ {
+			c.output.Write([]byte{c.mem[addr]})
+		}
+	case 10: // Read console buffer
+		c.readLine(de)
+	case 11: // Console status: no key waiting
+	case 12: // Version: CP/M 2.2
+		result = 0x0022
+	case 13: // Reset disk system
+		c.dma = cpmTail
+	case 14, 32: // Select disk, get/set user code: there is only A: user 0
+	case 24: // Login vector: A:
+		result = 0x0001
+	case 25: // Current disk: A:
+	case 26: // Set DMA address
+		c.dma = de
+	case 15:
+		result = c.open(de)
+	case 16:
+		result = c.close(de)
+	case 17:
+		result = c.searchFirst(de)
+	case 18:
+		result = c.searchNext()
+	case 19:
+		result = c.delete(de)
+	case 20:
+		result = c.readSequential(de)
+	case 21:
+		result = c.writeSequential(de)
+	case 22:
+		result = c.make(de)
+	case 23:
+		result = c.rename(de)
+	case 33:
+		result = c.readRandom(de)
+	case 34, 40: // Write random, with zero fill: new records read as zeros anyway
+		result = c.writeRandom(de)
+	case 35:
+		result = c.fileSize(de)
+	case 36: // Set random record to the sequential position
+		c.setRandom(de, c.position(de))
+	default:
+		return fmt.Errorf("unsupported BDOS function %d at $%04X", fn, z.returnAddress())
+	}
+
+	cpu.SetHL(result)
+	cpu.A, cpu.B = byte(result), byte(result>>8)
+
+	// RET
+	sp := cpu.SP()
+	cpu.SetPC(z.returnAddress())
+	cpu.SetSP(sp + 2)
+	return nil
+}
+
+// returnAddress returns the address on top of the stack
+func (z *RemogattoZ80) returnAddress() uint16 {
+	sp := z.cpu.SP()
+	return uint16(z.memory.data[sp]) | uint16(z.memory.data[sp+1])<<8
+}
+
+// readChar reads a console character; ^Z at the end of the input
+func (c *CPM) readChar() byte {
+	ch, err := c.input.ReadByte()
+	if err != nil {
+		return 0x1A
+	}
+	if ch == '\n' {
+		return '\r'
+	}
+	return ch
+}
+
+// readLine reads a console line into the buffer at addr: its size in the
+// first byte, the length read in the second, then the characters
+func (c *CPM) readLine(addr uint16) {
+	size := int(c.mem[addr])
+	line, _ := c.input.ReadString('\n')
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) > size {
+		line = line[:size]
+	}
+	c.mem[addr+1] = byte(len(line))
+	copy(c.mem[addr+2:], line)
+	io.WriteString(c.output, line+"\r\n")
+}
+
+// fcbName returns the name in the FCB at addr as NAME.TYP, with ? for
+// wildcards
+func (c *CPM) fcbName(addr uint16) string {
+	field := func(from, to uint16) string {
+		var b []byte
+		for i := from; i < to; i++ {
+			b = append(b, c.mem[addr+i]&0x7F) // Without attribute bits
+		}
+		return strings.TrimRight(string(b), " ")
+	}
+	name, ext := field(1, 9), field(9, 12)
+	if ext == "" {
+		return name
+	}
+	return name + "." + ext
+}
+
+// matchName reports whether a host file name matches an FCB name, which
+// may hold ? wildcards
+func matchName(pattern, name string) bool {
+	split := func(s string) (string, string) {
+		base, ext, _ := strings.Cut(strings.ToUpper(s), ".")
+		return fmt.Sprintf("%-8s", base), fmt.Sprintf("%-3s", ext)
+	}
+	pb, pe := split(pattern)
+	nb, ne := split(name)
+	if len(nb) > 8 || len(ne) > 3 || strings.Count(name, ".") > 1 {
+		return false
+	}
+	match := func(p, s string) bool {
+		for i := range p {
+			if p[i] != '?' && p[i] != s[i] {
+				return false
+			}
+		}
+		return true
+	}
+	return match(pb, nb) && match(pe, ne)
+}
+
+// findFiles returns the host paths of the files matching an FCB name, in
+// name order
+func (c *CPM) findFiles(pattern string) []string {
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && matchName(pattern, e.Name()) {
+			paths = append(paths, filepath.Join(c.Dir, e.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// file returns the open host file for the FCB at addr, opening it if the
+// program did not
+func (c *CPM) file(addr uint16) *os.File {
+	paths := c.findFiles(c.fcbName(addr))
+	if len(paths) == 0 {
+		return nil
+	}
+	path := paths[0]
+	if f, ok := c.files[path]; ok {
+		return f
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if f, err = os.Open(path); err != nil {
+			return nil
+		}
+	}
+	c.files[path] = f
+	return f
+}
+
+// open opens the file named by the FCB at addr and sets its record count
+func (c *CPM) open(addr uint16) uint16 {
+	f := c.file(addr)
+	if f == nil {
+		return 0xFF
+	}
+	c.setRecordCount(addr, f)
+	return 0
+}
+
+// setRecordCount sets RC to the number of records of the current extent
+func (c *CPM) setRecordCount(addr uint16, f *os.File) {
+	records := 0
+	if info, err := f.Stat(); err == nil {
+		records = int((info.Size() + cpmRecord - 1) / cpmRecord)
+	}
+	extent := int(c.position(addr)) / cpmRecord
+	records -= extent * cpmRecord
+	if records < 0 {
+		records = 0
+	} else if records > cpmRecord {
+		records = cpmRecord
+	}
+	c.mem[addr+fcbRC] = byte(records)
+}
+
+// close closes the file named by the FCB at addr
+func (c *CPM) close(addr uint16) uint16 {
+	paths := c.findFiles(c.fcbName(addr))
+	if len(paths) == 0 {
+		return 0xFF
+	}
+	if f, ok := c.files[paths[0]]; ok {
+		delete(c.files, paths[0])
+		if f.Close() != nil {
+			return 0xFF
+		}
+	}
+	return 0
+}
+
+// searchFirst starts listing the files matching the FCB at addr
+func (c *CPM) searchFirst(addr uint16) uint16 {
+	pattern := "????????.???"
+	if c.mem[addr] != '?' {
+		pattern = c.fcbName(addr)
+	}
+	c.search = c.findFiles(pattern)
+	return c.searchNext()
+}
+
+// searchNext writes the directory entry of the next matching file to the
+// DMA buffer
+func (c *CPM) searchNext() uint16 {
+	if len(c.search) == 0 {
+		return 0xFF
+	}
+	path := c.search[0]
+	c.search = c.search[1:]
+
+	entry := c.mem[c.dma : c.dma+32]
+	for i := range entry {
+		entry[i] = 0
+	}
+	c.setFCBName(c.dma, filepath.Base(path))
+	entry[0] = 0 // User 0
+	if info, err := os.Stat(path); err == nil {
+		records := (info.Size() + cpmRecord - 1) / cpmRecord
+		if records > cpmRecord {
+			records = cpmRecord
+		}
+		entry[fcbRC] = byte(records)
+	}
+	return 0
+}
+
+// delete deletes the files matching the FCB at addr
+func (c *CPM) delete(addr uint16) uint16 {
+	paths := c.findFiles(c.fcbName(addr))
+	if len(paths) == 0 {
+		return 0xFF
+	}
+	for _, path := range paths {
+		if f, ok := c.files[path]; ok {
+			f.Close()
+			delete(c.files, path)
+		}
+		os.Remove(path)
+	}
+	return 0
+}
+
+// make creates the file named by the FCB at addr, empty, and opens it
+func (c *CPM) make(addr uint16) uint16 {
+	name := c.fcbName(addr)
+	if strings.Contains(name, "?") {
+		return 0xFF
+	}
+	path := filepath.Join(c.Dir, name)
+	if paths := c.findFiles(name); len(paths) > 0 {
+		path = paths[0] // Keep the host file's case
+	}
+	if f, ok := c.files[path]; ok {
+		f.Close()
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0xFF
+	}
+	c.files[path] = f
+	c.mem[addr+fcbRC] = 0
+	return 0
+}
+
+// rename renames the file named by the FCB at addr to the name 16 bytes on
+func (c *CPM) rename(addr uint16) uint16 {
+	paths := c.findFiles(c.fcbName(addr))
+	if len(paths) == 0 {
+		return 0xFF
+	}
+	if f, ok := c.files[paths[0]]; ok {
+		f.Close()
+		delete(c.files, paths[0])
+	}
+	if os.Rename(paths[0], filepath.Join(c.Dir, c.fcbName(addr+16))) != nil {
+		return 0xFF
+	}
+	return 0
+}
+
+// position returns the sequential record number of the FCB at addr
+func (c *CPM) position(addr uint16) uint32 {
+	fcb := c.mem[addr:]
+	return uint32(fcb[fcbS2])<<12 | uint32(fcb[fcbEX]&0x1F)<<7 | uint32(fcb[fcbCR]&0x7F)
+}
+
+// setPosition sets the sequential record number of the FCB at addr
+func (c *CPM) setPosition(addr uint16, record uint32) {
+	fcb := c.mem[addr:]
+	fcb[fcbS2] = byte(record >> 12)
+	fcb[fcbEX] = byte(record>>7) & 0x1F
+	fcb[fcbCR] = byte(record) & 0x7F
+}
+
+// random returns the random record number of the FCB at addr, and whether
+// it is in range
+func (c *CPM) random(addr uint16) (uint32, bool) {
+	r := c.mem[addr+fcbR0:]
+	return uint32(r[0]) | uint32(r[1])<<8, r[2] == 0
+}
+
+// setRandom sets the random record number of the FCB at addr
+func (c *CPM) setRandom(addr uint16, record uint32) {
+	r := c.mem[addr+fcbR0:]
+	r[0], r[1], r[2] = byte(record), byte(record>>8), byte(record>>16)
+}
+
+// readRecord reads a record of the file of the FCB at addr to the DMA
+// buffer. A short last record is padded with ^Z.
+func (c *CPM) readRecord(addr uint16, record uint32) uint16 {
+	f := c.file(addr)
+	if f == nil {
+		return 9 // Invalid FCB
+	}
+	buf := make([]byte, cpmRecord)
+	n, _ := f.ReadAt(buf, int64(record)*cpmRecord)
+	if n == 0 {
+		return 1 // End of file
+	}
+	for i := n; i < cpmRecord; i++ {
+		buf[i] = 0x1A
+	}
+	copy(c.mem[c.dma:], buf)
+	return 0
+}
+
+// writeRecord writes the DMA buffer as a record of the file of the FCB at
+// addr
+func (c *CPM) writeRecord(addr uint16, record uint32) uint16 {
+	f := c.file(addr)
+	if f == nil {
+		return 9 // Invalid FCB
+	}
+	buf := make([]byte, cpmRecord)
+	copy(buf, c.mem[c.dma:])
+	if _, err := f.WriteAt(buf, int64(record)*cpmRecord); err != nil {
+		return 2 // Disk full
+	}
+	return 0
+}
+
+// readSequential reads the current record and moves to the next
+func (c *CPM) readSequential(addr uint16) uint16 {
+	record := c.position(addr)
+	result := c.readRecord(addr, record)
+	if result == 0 {
+		c.setPosition(addr, record+1)
+	}
+	return result
+}
+
+// writeSequential writes the current record and moves to the next
+func (c *CPM) writeSequential(addr uint16) uint16 {
+	record := c.position(addr)
+	result := c.writeRecord(addr, record)
+	if result == 0 {
+		c.setPosition(addr, record+1)
+	}
+	return result
+}
+
+// readRandom reads the record named by R0-R2, which also becomes the
+// sequential position
+func (c *CPM) readRandom(addr uint16) uint16 {
+	record, ok := c.random(addr)
+	if !ok {
+		return 6 // Random record out of range
+	}
+	c.setPosition(addr, record)
+	return c.readRecord(addr, record)
+}
+
+// writeRandom writes the record named by R0-R2, which also becomes the
+// sequential position
+func (c *CPM) writeRandom(addr uint16) uint16 {
+	record, ok := c.random(addr)
+	if !ok {
+		return 6 // Random record out of range
+	}
+	c.setPosition(addr, record)
+	return c.writeRecord(addr, record)
+}
+
+// fileSize sets R0-R2 to the number of records of the file of the FCB at
+// addr
+func (c *CPM) fileSize(addr uint16) uint16 {
+	f := c.file(addr)
+	if f == nil {
+		return 0xFF
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return 0xFF
+	}
+	c.setRandom(addr, uint32((info.Size()+cpmRecord-1)/cpmRecord))
+	return 0
+}
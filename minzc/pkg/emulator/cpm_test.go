@@ -0,0 +1,167 @@
+package emulator
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/z80asm"
+)
+
+// cpmCopy copies the file named on the command line to OUT.TXT a record
+// at a time, then reads the second record of the copy back at random
+const cpmCopy = `
+    ORG $0100
+    LD DE, banner
+    LD C, 9
+    CALL 5
+    LD DE, $005C
+    LD C, 15            ; Open
+    CALL 5
+    INC A
+    JR Z, missing
+    LD DE, outfcb
+    LD C, 22            ; Make
+    CALL 5
+copy:
+    LD DE, $005C
+    LD C, 20            ; Read sequential
+    CALL 5
+    OR A
+    JR NZ, copied
+    LD DE, outfcb
+    LD C, 21            ; Write sequential
+    CALL 5
+    JR copy
+copied:
+    LD DE, outfcb
+    LD C, 16            ; Close
+    CALL 5
+    LD A, 1
+    LD (outfcb+33), A
+    LD DE, $1000
+    LD C, 26            ; Set DMA
+    CALL 5
+    LD DE, outfcb
+    LD C, 33            ; Read random
+    CALL 5
+    LD C, 0             ; System reset
+    CALL 5
+missing:
+    LD DE, nofile
+    LD C, 9
+    CALL 5
+    RET
+banner:
+    DB "COPY$"
+nofile:
+    DB "NO FILE$"
+outfcb:
+    DB 0, "OUT", 32, 32, 32, 32, 32, "TXT"
+    DS 24
+`
+
+// runCPM runs a CP/M program with its files in dir
+func runCPM(t *testing.T, source, dir string, args ...string) (*RemogattoZ80, string) {
+	t.Helper()
+	result, err := z80asm.NewAssembler().AssembleString(source)
+	if err != nil {
+		t.Fatalf("assembly failed: %v", err)
+	}
+
+	z := NewRemogattoZ80()
+	z.LoadMemory(result.Origin, result.Binary)
+	var out bytes.Buffer
+	cpm := z.EnableCPM(dir, strings.NewReader(""), &out)
+	defer cpm.Close()
+	cpm.SetCommandLine(args)
+	z.SetPC(CPMLoadAddr)
+	if err := z.Run(); err != nil {
+		t.Fatalf("run failed: %v\n%s", err, z.DumpState())
+	}
+	return z, out.String()
+}
+
+func TestCPMFileCopy(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 200)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "in.txt"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	z, out := runCPM(t, cpmCopy, dir, "in.txt")
+	if out != "COPY" {
+		t.Errorf("output = %q, want COPY", out)
+	}
+	if !z.IsHalted() {
+		t.Errorf("program did not stop with BDOS function 0\n%s", z.DumpState())
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "OUT.TXT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(data, bytes.Repeat([]byte{0x1A}, 56)...)
+	if !bytes.Equal(got, want) {
+		t.Errorf("OUT.TXT = % X\nwant % X", got, want)
+	}
+
+	// Record 1 read at random, padded with ^Z
+	if z.GetRegisters().A != 0 {
+		t.Errorf("read random returned %d, want 0", z.GetRegisters().A)
+	}
+	for i := 0; i < 128; i++ {
+		if got, want := z.GetMemory(0x1000+uint16(i)), want[128+i]; got != want {
+			t.Fatalf("random record byte %d = $%02X, want $%02X", i, got, want)
+		}
+	}
+}
+
+func TestCPMMissingFile(t *testing.T) {
+	z, out := runCPM(t, cpmCopy, t.TempDir(), "none.txt")
+	if out != "COPYNO FILE" {
+		t.Errorf("output = %q, want COPYNO FILE", out)
+	}
+	// The RET warm boots
+	if pc := z.GetPC(); pc != 0 {
+		t.Errorf("PC = $%04X after returning, want $0000", pc)
+	}
+}
+
+func TestCPMUnsupportedFunction(t *testing.T) {
+	result, err := z80asm.NewAssembler().AssembleString("    ORG $0100\n    LD C, 99\n    CALL 5\n    RET\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := NewRemogattoZ80()
+	z.LoadMemory(result.Origin, result.Binary)
+	z.EnableCPM(t.TempDir(), strings.NewReader(""), &bytes.Buffer{})
+	z.SetPC(CPMLoadAddr)
+	if err := z.Run(); err == nil || !strings.Contains(err.Error(), "unsupported BDOS function 99") {
+		t.Errorf("error = %v, want an unsupported BDOS function", err)
+	}
+}
+
+func TestCPMMatchName(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"DATA.TXT", "data.txt", true},
+		{"DATA.TXT", "data.txt.bak", false},
+		{"DATA", "DATA", true},
+		{"DATA.???", "data.bin", true},
+		{"D???????.TXT", "doc.txt", true},
+		{"D???????.TXT", "readme.txt", false},
+		{"????????.???", "toolongname.txt", false},
+	} {
+		if got := matchName(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchName(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package emulator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The 48K Spectrum's 40 keys form a matrix of eight half-rows of five.
+// The ULA answers every even port: the high byte of the port address
+// selects half-rows, one per address line A8-A15 that is low, and bits 0-4
+// of the byte read are 0 for a key held in any selected half-row. Bits 5-7
+// read as 1. IN A, ($FE) with A = $7F reads SPACE, SYMBOL SHIFT, M, N and B.
+//
+// Key presses are scheduled at a T-state counted from when the keyboard
+// was enabled, and last Hold T-states:
+//
+//	SPACE@100000,Q@200000        press SPACE, then Q
+//	CAPS+5@70000:140000          CAPS SHIFT and 5 (cursor left) for 2 frames
+
+// DefaultKeyHold is how long a key press lasts unless given: two frames,
+// long enough for a program that polls the keyboard once a frame
+const DefaultKeyHold = 2 * FrameTstates
+
+// keyboardRows names the keys of each half-row, from bit 0, in the order
+// of the address lines A8-A15 that select them
+var keyboardRows = [8][5]string{
+	{"CAPS", "Z", "X", "C", "V"},
+	{"A", "S", "D", "F", "G"},
+	{"Q", "W", "E", "R", "T"},
+	{"1", "2", "3", "4", "5"},
+	{"0", "9", "8", "7", "6"},
+	{"P", "O", "I", "U", "Y"},
+	{"ENTER", "L", "K", "J", "H"},
+	{"SPACE", "SYM", "M", "N", "B"},
+}
+
+// keyAliases are other names accepted for keys
+var keyAliases = map[string]string{
+	"CAPS SHIFT": "CAPS", "CAPSSHIFT": "CAPS", "SHIFT": "CAPS",
+	"SYMBOL": "SYM", "SYMBOL SHIFT": "SYM", "SYMSHIFT": "SYM",
+	"RETURN": "ENTER", "SPC": "SPACE",
+}
+
+// matrixKey is the position of a key in the matrix
+type matrixKey struct {
+	row int
+	bit int
+}
+
+// lookupKey finds a key by name
+func lookupKey(name string) (matrixKey, bool) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if alias, ok := keyAliases[name]; ok {
+		name = alias
+	}
+	for row, keys := range keyboardRows {
+		for bit, key := range keys {
+			if key == name {
+				return matrixKey{row, bit}, true
+			}
+		}
+	}
+	return matrixKey{}, false
+}
+
+// KeyPress holds Keys down together from T-state At for Hold T-states
+type KeyPress struct {
+	Keys []string
+	At   int
+	Hold int
+}
+
+// ParseKeyPresses parses a comma-separated list of key presses such as
+// "SPACE@100000,CAPS+5@200000:70000": keys joined by +, the T-state of the
+// press after @, and optionally how long it lasts after a colon
+func ParseKeyPresses(spec string) ([]KeyPress, error) {
+	var presses []KeyPress
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		names, timing, ok := strings.Cut(field, "@")
+		if !ok {
+			return nil, fmt.Errorf("key press %q is not KEY@TSTATE", field)
+		}
+		press := KeyPress{Hold: DefaultKeyHold}
+		for _, name := range strings.Split(names, "+") {
+			if _, ok := lookupKey(name); !ok {
+				return nil, fmt.Errorf("unknown key %q", strings.TrimSpace(name))
+			}
+			press.Keys = append(press.Keys, strings.ToUpper(strings.TrimSpace(name)))
+		}
+		at, hold, hasHold := strings.Cut(timing, ":")
+		var err error
+		if press.At, err = parseTstates(at); err != nil {
+			return nil, fmt.Errorf("key press %q: %v", field, err)
+		}
+		if hasHold {
+			if press.Hold, err = parseTstates(hold); err != nil || press.Hold == 0 {
+				return nil, fmt.Errorf("key press %q: invalid hold %q", field, strings.TrimSpace(hold))
+			}
+		}
+		presses = append(presses, press)
+	}
+	return presses, nil
+}
+
+// parseTstates parses a decimal, $hex or 0x hex T-state count
+func parseTstates(text string) (int, error) {
+	text = strings.TrimSpace(text)
+	digits, base := text, 10
+	switch {
+	case strings.HasPrefix(text, "$"):
+		digits, base = text[1:], 16
+	case strings.HasPrefix(strings.ToLower(text), "0x"):
+		digits, base = text[2:], 16
+	}
+	value, err := strconv.ParseUint(digits, base, 31)
+	if err != nil {
+		return 0, fmt.Errorf("invalid T-state %q", text)
+	}
+	return int(value), nil
+}
+
+// Keyboard is the key matrix read through the ULA's port
+type Keyboard struct {
+	tstates *int // The CPU's T-state counter
+	origin  int  // Counter value key press times count from
+	presses []KeyPress
+}
+
+// EnableKeyboard attaches the keyboard matrix to the even ports and
+// returns it. Key press times count from now.
+func (z *RemogattoZ80) EnableKeyboard() *Keyboard {
+	if z.keyboard == nil {
+		z.keyboard = &Keyboard{tstates: &z.cpu.Tstates, origin: z.cpu.Tstates}
+		z.ports.keyboard = z.keyboard
+	}
+	return z.keyboard
+}
+
+// Now returns the T-state key press times are measured against
+func (k *Keyboard) Now() int {
+	return *k.tstates - k.origin
+}
+
+// Schedule adds key presses; their keys must be known (see ParseKeyPresses)
+func (k *Keyboard) Schedule(presses ...KeyPress) {
+	k.presses = append(k.presses, presses...)
+}
+
+// Press holds keys down from now for DefaultKeyHold T-states
+func (k *Keyboard) Press(keys ...string) {
+	k.Schedule(KeyPress{Keys: keys, At: k.Now(), Hold: DefaultKeyHold})
+}
+
+// Held reports whether a key is down now
+func (k *Keyboard) Held(name string) bool {
+	key, ok := lookupKey(name)
+	return ok && k.rows()[key.row]&(1<<key.bit) != 0
+}
+
+// rows returns the keys down now in each half-row, a bit set for each,
+// and forgets presses that are over
+func (k *Keyboard) rows() [8]byte {
+	var rows [8]byte
+	now := k.Now()
+	pending := k.presses[:0]
+	for _, press := range k.presses {
+		if now >= press.At+press.Hold {
+			continue
+		}
+		pending = append(pending, press)
+		if now < press.At {
+			continue
+		}
+		for _, name := range press.Keys {
+			if key, ok := lookupKey(name); ok {
+				rows[key.row] |= 1 << key.bit
+			}
+		}
+	}
+	k.presses = pending
+	return rows
+}
+
+// read answers a read of an even port
+func (k *Keyboard) read(port uint16) byte {
+	value := byte(0xFF)
+	for row, keys := range k.rows() {
+		if port&(0x100<<row) == 0 {
+			value &^= keys
+		}
+	}
+	return value
+}
+
+// hostKeys maps characters typed on a host keyboard to the Spectrum keys
+// that type them with SYMBOL SHIFT
+var hostKeys = map[byte]string{
+	'!': "1", '@': "2", '#': "3", '$': "4", '%': "5", '&': "6", '\'': "7",
+	'(': "8", ')': "9", '_': "0", '<': "R", '>': "T", ';': "O", '"': "P",
+	'^': "H", '-': "J", '+': "K", '=': "L", ':': "Z", '?': "C", '/': "V",
+	'*': "B", ',': "N", '.': "M",
+}
+
+// HostKeys returns the Spectrum keys that type a character from a host
+// keyboard: letters and digits, capitals with CAPS SHIFT, punctuation with
+// SYMBOL SHIFT, SPACE, ENTER and DELETE (CAPS SHIFT and 0)
+func HostKeys(ch byte) ([]string, bool) {
+	switch {
+	case ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9':
+		return []string{strings.ToUpper(string(ch))}, true
+	case ch >= 'A' && ch <= 'Z':
+		return []string{"CAPS", string(ch)}, true
+	case ch == ' ':
+		return []string{"SPACE"}, true
+	case ch == '\r', ch == '\n':
+		return []string{"ENTER"}, true
+	case ch == 0x7F, ch == 0x08:
+		return []string{"CAPS", "0"}, true
+	}
+	if key, ok := hostKeys[ch]; ok {
+		return []string{"SYM", key}, true
+	}
+	return nil, false
+}
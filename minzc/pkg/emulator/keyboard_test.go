@@ -0,0 +1,86 @@
+package emulator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeyPresses(t *testing.T) {
+	presses, err := ParseKeyPresses("SPACE@100000, caps+5@$1000:70000,Symbol@0x10")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := []KeyPress{
+		{Keys: []string{"SPACE"}, At: 100000, Hold: DefaultKeyHold},
+		{Keys: []string{"CAPS", "5"}, At: 0x1000, Hold: 70000},
+		{Keys: []string{"SYMBOL"}, At: 0x10, Hold: DefaultKeyHold},
+	}
+	if !reflect.DeepEqual(presses, want) {
+		t.Errorf("got %+v, want %+v", presses, want)
+	}
+
+	for _, spec := range []string{"SPACE", "F1@100", "Q@soon", "Q@100:0", "Q@-5"} {
+		if _, err := ParseKeyPresses(spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
+
+func TestKeyboardMatrix(t *testing.T) {
+	code := []byte{
+		0x01, 0xFE, 0x7F, // LD BC, $7FFE: SPACE to B
+		0xED, 0x78, // wait: IN A, (C)
+		0xCB, 0x47, // BIT 0, A
+		0x20, 0xFA, // JR NZ, wait
+		0x06, 0xFB, // LD B, $FB: Q to T
+		0xED, 0x78, // IN A, (C)
+		0x32, 0x00, 0x90, // LD ($9000), A
+		0x06, 0xFD, // LD B, $FD: A to G
+		0xED, 0x78, // IN A, (C)
+		0x32, 0x01, 0x90, // LD ($9001), A
+		0x06, 0x7A, // LD B, $7A: both half-rows and the SPACE row
+		0xED, 0x78, // IN A, (C)
+		0x32, 0x02, 0x90, // LD ($9002), A
+		0xF3, 0x76, // DI; HALT
+	}
+
+	z := NewRemogattoZ80()
+	keyboard := z.EnableKeyboard()
+	presses, err := ParseKeyPresses("SPACE@20000,E+T@19000,Q@0:100")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	keyboard.Schedule(presses...)
+	z.LoadMemory(0x8000, code)
+	z.SetPC(0x8000)
+	if err := z.Run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if cycles := z.GetCycles(); cycles < 20000 || cycles > 20000+DefaultKeyHold {
+		t.Errorf("SPACE read after %d T-states, want from 20000", cycles)
+	}
+	// Q was released long before; E and T are still held
+	for address, want := range map[uint16]byte{0x9000: 0xEB, 0x9001: 0xFF, 0x9002: 0xEA} {
+		if got := z.GetMemory(address); got != want {
+			t.Errorf("$%04X = $%02X, want $%02X", address, got, want)
+		}
+	}
+	if !keyboard.Held("t") || keyboard.Held("Q") {
+		t.Error("Held does not match the presses")
+	}
+}
+
+func TestHostKeys(t *testing.T) {
+	for ch, want := range map[byte][]string{
+		'q': {"Q"}, 'Q': {"CAPS", "Q"}, '7': {"7"}, ' ': {"SPACE"}, '\r': {"ENTER"},
+		0x7F: {"CAPS", "0"}, '"': {"SYM", "P"}, '.': {"SYM", "M"},
+	} {
+		if got, ok := HostKeys(ch); !ok || !reflect.DeepEqual(got, want) {
+			t.Errorf("HostKeys(%q) = %v, want %v", ch, got, want)
+		}
+	}
+	if _, ok := HostKeys(0x1B); ok {
+		t.Error("ESC types a key")
+	}
+}
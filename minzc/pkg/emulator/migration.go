@@ -40,6 +40,8 @@ func MigrateToRemogatto(basic *Z80) *RemogattoZ80 {
 }
 
 // EmulatorInterface defines common interface for both emulators
+//
+// Deprecated: programs embedding the emulator should use Emulator.
 type EmulatorInterface interface {
 	Reset()
 	LoadMemory(address uint16, data []byte) error
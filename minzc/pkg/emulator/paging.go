@@ -0,0 +1,192 @@
+package emulator
+
+// The 128K ZX Spectrum has eight 16K RAM pages and two 16K ROMs, selected
+// by writes to port $7FFD (decoded as A15 and A1 low):
+//
+//	bits 0-2  RAM page at $C000-$FFFF
+//	bit 3     screen shown: page 5, or the shadow screen in page 7
+//	bit 4     ROM at $0000-$3FFF: 0 = the 128K editor, 1 = 48K BASIC
+//	bit 5     lock: ignore writes to $7FFD until the machine is reset
+//
+// Page 5 is always at $4000 and page 2 at $8000, so when either is also
+// paged in at $C000 the two windows show the same bytes. Port $01, the
+// emulator's console, is not decoded as $7FFD.
+//
+// The CPU's 64K view stays in Memory.data; a page is copied out of it when
+// it is paged out of $C000 and back when it is paged in.
+
+// PageSize is the size of a 128K Spectrum RAM page or ROM
+const PageSize = 0x4000
+
+// Paging holds the memory of a 128K Spectrum that is not mapped in
+type Paging struct {
+	memory *Memory
+	ram    [8][PageSize]byte // Pages while they are not at $C000
+	rom    [2][PageSize]byte
+	port   byte // Last value written to $7FFD
+	locked bool
+}
+
+// Enable128K turns on 128K Spectrum paging and returns it. The memory at
+// $C000 becomes RAM page 0 and ROM 0 is paged in.
+func (z *RemogattoZ80) Enable128K() *Paging {
+	if z.paging == nil {
+		p := &Paging{memory: z.memory}
+		copy(p.rom[0][:], z.memory.data[:PageSize])
+		copy(p.rom[1][:], z.memory.data[:PageSize])
+		z.paging = p
+		z.memory.paging = p
+		z.ports.paging = p
+	}
+	return z.paging
+}
+
+// Paging returns the 128K paging, or nil when it is not enabled
+func (z *RemogattoZ80) Paging() *Paging {
+	return z.paging
+}
+
+// Port returns the last value written to $7FFD
+func (p *Paging) Port() byte {
+	return p.port
+}
+
+// Locked reports whether paging is locked until reset
+func (p *Paging) Locked() bool {
+	return p.locked
+}
+
+// RAMPage returns the number of the RAM page at $C000
+func (p *Paging) RAMPage() int {
+	return int(p.port & 0x07)
+}
+
+// ROM returns the number of the ROM at $0000
+func (p *Paging) ROM() int {
+	return int(p.port>>4) & 1
+}
+
+// ScreenPage returns the RAM page the ULA displays: 5, or 7 for the shadow
+// screen
+func (p *Paging) ScreenPage() int {
+	if p.port&0x08 != 0 {
+		return 7
+	}
+	return 5
+}
+
+// Write applies a value written to $7FFD, unless paging is locked
+func (p *Paging) Write(value byte) {
+	if p.locked {
+		return
+	}
+	p.pageRAM(int(value & 0x07))
+	if value&0x10 != p.port&0x10 {
+		p.pageROM(int(value>>4) & 1)
+	}
+	p.port = value & 0x3F
+	p.locked = value&0x20 != 0
+}
+
+// Reset unlocks paging and restores page 0 and ROM 0
+func (p *Paging) Reset() {
+	p.locked = false
+	p.Write(0)
+}
+
+// pageRAM maps a RAM page at $C000
+func (p *Paging) pageRAM(page int) {
+	old := p.RAMPage()
+	if page == old {
+		return
+	}
+	data := &p.memory.data
+	if old != 5 && old != 2 {
+		copy(p.ram[old][:], data[0xC000:])
+	}
+	if base, ok := fixedPage(page); ok {
+		copy(data[0xC000:], data[base:base+PageSize])
+	} else {
+		copy(data[0xC000:], p.ram[page][:])
+	}
+	p.port = p.port&^0x07 | byte(page)
+}
+
+// pageROM maps a ROM at $0000, saving the one it replaces, which loading
+// into memory may have changed
+func (p *Paging) pageROM(rom int) {
+	data := &p.memory.data
+	copy(p.rom[1-rom][:], data[:PageSize])
+	copy(data[:PageSize], p.rom[rom][:])
+}
+
+// fixedPage returns the address at which page 5 or 2 is always mapped
+func fixedPage(page int) (uint16, bool) {
+	switch page {
+	case 5:
+		return 0x4000, true
+	case 2:
+		return 0x8000, true
+	}
+	return 0, false
+}
+
+// alias returns the other address showing the same byte as address, when
+// page 5 or 2 is mapped at $C000 as well as at its own window
+func (p *Paging) alias(address uint16) (uint16, bool) {
+	base, ok := fixedPage(p.RAMPage())
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case address >= 0xC000:
+		return address - 0xC000 + base, true
+	case address >= base && address < base+PageSize:
+		return address - base + 0xC000, true
+	}
+	return 0, false
+}
+
+// Page returns RAM page n. The slice shares the emulator's memory only
+// while the page is mapped; load pages before running, not during.
+func (p *Paging) Page(n int) []byte {
+	if base, ok := fixedPage(n); ok {
+		return p.memory.data[base : base+PageSize]
+	}
+	if n == p.RAMPage() {
+		return p.memory.data[0xC000:]
+	}
+	return p.ram[n][:]
+}
+
+// LoadPage copies data into RAM page n, starting at offset
+func (p *Paging) LoadPage(n int, offset uint16, data []byte) {
+	copy(p.Page(n)[offset:], data)
+	if base, ok := fixedPage(n); ok && n == p.RAMPage() {
+		copy(p.memory.data[0xC000:], p.memory.data[base:base+PageSize])
+	}
+}
+
+// LoadROM sets the contents of ROM 0 or 1
+func (p *Paging) LoadROM(n int, data []byte) {
+	copy(p.rom[n][:], data)
+	if n == p.ROM() {
+		copy(p.memory.data[:PageSize], p.rom[n][:])
+	}
+}
+
+// screenByte returns the byte at offset in the displayed screen page
+func (p *Paging) screenByte(offset uint16) byte {
+	if p.ScreenPage() == 5 {
+		return p.memory.data[0x4000+offset]
+	}
+	if p.RAMPage() == 7 {
+		return p.memory.data[0xC000+offset]
+	}
+	return p.ram[7][offset]
+}
+
+// isPagingPort reports whether a port address selects $7FFD
+func isPagingPort(address uint16) bool {
+	return address&0x8002 == 0 && address&0xFF != 0x01
+}
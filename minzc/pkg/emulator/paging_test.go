@@ -0,0 +1,130 @@
+package emulator
+
+import "testing"
+
+// pageOut is Z80 code writing value to $7FFD
+func pageOut(value byte) []byte {
+	return []byte{
+		0x01, 0xFD, 0x7F, // LD BC, $7FFD
+		0x3E, value, // LD A, value
+		0xED, 0x79, // OUT (C), A
+	}
+}
+
+// storeC000 is Z80 code storing value at $C000
+func storeC000(value byte) []byte {
+	return []byte{
+		0x3E, value, // LD A, value
+		0x32, 0x00, 0xC0, // LD ($C000), A
+	}
+}
+
+// run128K runs a program at $8000 on a 128K
+func run128K(t *testing.T, program ...[]byte) (*RemogattoZ80, *Paging) {
+	t.Helper()
+	var code []byte
+	for _, part := range program {
+		code = append(code, part...)
+	}
+	code = append(code, 0xF3, 0x76) // DI; HALT
+
+	z := NewRemogattoZ80()
+	paging := z.Enable128K()
+	z.LoadMemory(0x8000, code)
+	z.SetPC(0x8000)
+	if err := z.Run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	return z, paging
+}
+
+func TestPagingRAM(t *testing.T) {
+	// Each page keeps its own byte at $C000
+	z, paging := run128K(t,
+		storeC000(0x10),
+		pageOut(1), storeC000(0x11),
+		pageOut(3), storeC000(0x13),
+		pageOut(1),
+	)
+	if got := paging.RAMPage(); got != 1 {
+		t.Fatalf("RAM page %d, want 1", got)
+	}
+	if got := z.GetMemory(0xC000); got != 0x11 {
+		t.Errorf("page 1 at $C000 holds $%02X, want $11", got)
+	}
+	for page, want := range map[int]byte{0: 0x10, 1: 0x11, 3: 0x13, 4: 0x00} {
+		if got := paging.Page(page)[0]; got != want {
+			t.Errorf("page %d holds $%02X, want $%02X", page, got, want)
+		}
+	}
+}
+
+func TestPagingFixedPages(t *testing.T) {
+	// Page 5 at $C000 is the screen at $4000, both ways
+	z, _ := run128K(t,
+		pageOut(5), storeC000(0xAA),
+		[]byte{0x3E, 0x55, 0x32, 0x01, 0x40}, // LD A, $55; LD ($4001), A
+		pageOut(0),
+	)
+	if got := z.GetMemory(0x4000); got != 0xAA {
+		t.Errorf("$4000 = $%02X after writing $C000 with page 5 in, want $AA", got)
+	}
+	if got := z.GetMemory(0xC001); got != 0x00 {
+		t.Errorf("page 0 at $C001 = $%02X, want $00", got)
+	}
+
+	z, _ = run128K(t, []byte{0x3E, 0x77, 0x32, 0x00, 0x90}, pageOut(2)) // LD ($9000), A
+	if got := z.GetMemory(0xD000); got != 0x77 {
+		t.Errorf("page 2 at $D000 = $%02X, want $77", got)
+	}
+}
+
+func TestPagingShadowScreen(t *testing.T) {
+	_, paging := run128K(t, pageOut(0x07), storeC000(0xF0), pageOut(0x08))
+	if got := paging.ScreenPage(); got != 7 {
+		t.Fatalf("screen page %d, want 7", got)
+	}
+	if got := paging.screenByte(0); got != 0xF0 {
+		t.Errorf("shadow screen byte $%02X, want $F0", got)
+	}
+}
+
+func TestPagingROM(t *testing.T) {
+	z := NewRemogattoZ80()
+	paging := z.Enable128K()
+	paging.LoadROM(0, []byte{0x01})
+	paging.LoadROM(1, []byte{0x48})
+	z.LoadMemory(0x8000, append(pageOut(0x10), 0xF3, 0x76))
+	z.SetPC(0x8000)
+	if got := z.GetMemory(0x0000); got != 0x01 {
+		t.Fatalf("ROM 0 byte $%02X, want $01", got)
+	}
+	if err := z.Run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got := z.GetMemory(0x0000); got != 0x48 {
+		t.Errorf("after selecting ROM 1, $0000 = $%02X, want $48", got)
+	}
+}
+
+func TestPagingLock(t *testing.T) {
+	z, paging := run128K(t, pageOut(0x23), pageOut(0x04))
+	if !paging.Locked() || paging.RAMPage() != 3 {
+		t.Fatalf("locked %v, page %d; want locked at page 3", paging.Locked(), paging.RAMPage())
+	}
+	z.Reset()
+	if paging.Locked() || paging.RAMPage() != 0 {
+		t.Errorf("after reset locked %v, page %d; want unlocked at page 0", paging.Locked(), paging.RAMPage())
+	}
+}
+
+func TestPagingConsolePort(t *testing.T) {
+	// Port $01 is the console, not $7FFD
+	z, paging := run128K(t, []byte{0x3E, 0x41, 0xD3, 0x01}) // LD A, 'A'; OUT ($01), A
+	if paging.Port() != 0 {
+		t.Errorf("console output paged: $7FFD = $%02X", paging.Port())
+	}
+	if got := string(*z.ports.output); got != "A" {
+		t.Errorf("output %q, want \"A\"", got)
+	}
+}
@@ -0,0 +1,169 @@
+package emulator
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// 48K .sna snapshots: a 27-byte register header followed by the 48K of RAM
+// at $4000-$FFFF. The format has no PC field; PC is pushed onto the stack
+// in the saved RAM and popped again by the loader (RETN).
+const (
+	snaHeaderSize = 27
+	snaRAMStart   = 0x4000
+	snaRAMSize    = 0x10000 - snaRAMStart
+	snaFileSize   = snaHeaderSize + snaRAMSize
+)
+
+// Snapshot is the full machine state: every CPU register and the 48K RAM
+type Snapshot struct {
+	A, F, B, C, D, E, H, L         byte
+	A_, F_, B_, C_, D_, E_, H_, L_ byte
+	IX, IY, SP, PC                 uint16
+	I, R                           byte
+	IFF1, IFF2, IM                 byte
+	Border                         byte
+	RAM                            [snaRAMSize]byte // $4000-$FFFF
+}
+
+// Snapshot captures the current CPU registers and RAM
+func (z *RemogattoZ80) Snapshot() *Snapshot {
+	cpu := z.cpu
+	s := &Snapshot{
+		A: cpu.A, F: cpu.F, B: cpu.B, C: cpu.C, D: cpu.D, E: cpu.E, H: cpu.H, L: cpu.L,
+		A_: cpu.A_, F_: cpu.F_, B_: cpu.B_, C_: cpu.C_, D_: cpu.D_, E_: cpu.E_, H_: cpu.H_, L_: cpu.L_,
+		IX: cpu.IX(), IY: cpu.IY(), SP: cpu.SP(), PC: cpu.PC(),
+		I:    cpu.I,
+		R:    cpu.R7&0x80 | byte(cpu.R&0x7F),
+		IFF1: cpu.IFF1, IFF2: cpu.IFF2, IM: cpu.IM,
+		Border: z.ports.border,
+	}
+	copy(s.RAM[:], z.memory.data[snaRAMStart:])
+	return s
+}
+
+// RestoreSnapshot loads registers and RAM from a snapshot
+func (z *RemogattoZ80) RestoreSnapshot(s *Snapshot) {
+	cpu := z.cpu
+	cpu.A, cpu.F, cpu.B, cpu.C, cpu.D, cpu.E, cpu.H, cpu.L = s.A, s.F, s.B, s.C, s.D, s.E, s.H, s.L
+	cpu.A_, cpu.F_, cpu.B_, cpu.C_, cpu.D_, cpu.E_, cpu.H_, cpu.L_ = s.A_, s.F_, s.B_, s.C_, s.D_, s.E_, s.H_, s.L_
+	cpu.SetIX(s.IX)
+	cpu.SetIY(s.IY)
+	cpu.SetSP(s.SP)
+	cpu.SetPC(s.PC)
+	cpu.I = s.I
+	cpu.R = uint16(s.R & 0x7F)
+	cpu.R7 = s.R & 0x80
+	cpu.IFF1, cpu.IFF2, cpu.IM = s.IFF1, s.IFF2, s.IM
+	cpu.Halted = false
+	z.halted = false
+	z.ports.border = s.Border
+	copy(z.memory.data[snaRAMStart:], s.RAM[:])
+}
+
+// Border returns the border colour last written to the ULA port
+func (z *RemogattoZ80) Border() byte {
+	return z.ports.border
+}
+
+// ReadSnapshot reads a .sna or .z80 snapshot, chosen by the file name
+func ReadSnapshot(filename string, r io.Reader) (*Snapshot, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".sna":
+		return ReadSNA(r)
+	case ".z80":
+		return ReadZ80(r)
+	}
+	return nil, fmt.Errorf("%s is not a .sna or .z80 snapshot", filename)
+}
+
+// IsSnapshotFile reports whether filename has a snapshot extension
+func IsSnapshotFile(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return ext == ".sna" || ext == ".z80"
+}
+
+// WriteSNA writes s as a 48K .sna file. PC is pushed onto the stack in the
+// saved RAM, so SP must leave room for it above $4000.
+func WriteSNA(w io.Writer, s *Snapshot) error {
+	sp := s.SP - 2
+	if sp < snaRAMStart || sp > 0xFFFE {
+		return fmt.Errorf("cannot save PC: SP $%04X leaves no stack room in RAM", s.SP)
+	}
+
+	buf := make([]byte, snaFileSize)
+	buf[0] = s.I
+	binary.LittleEndian.PutUint16(buf[1:], pair(s.H_, s.L_))
+	binary.LittleEndian.PutUint16(buf[3:], pair(s.D_, s.E_))
+	binary.LittleEndian.PutUint16(buf[5:], pair(s.B_, s.C_))
+	binary.LittleEndian.PutUint16(buf[7:], pair(s.A_, s.F_))
+	binary.LittleEndian.PutUint16(buf[9:], pair(s.H, s.L))
+	binary.LittleEndian.PutUint16(buf[11:], pair(s.D, s.E))
+	binary.LittleEndian.PutUint16(buf[13:], pair(s.B, s.C))
+	binary.LittleEndian.PutUint16(buf[15:], s.IY)
+	binary.LittleEndian.PutUint16(buf[17:], s.IX)
+	if s.IFF2 != 0 {
+		buf[19] = 0x04
+	}
+	buf[20] = s.R
+	binary.LittleEndian.PutUint16(buf[21:], pair(s.A, s.F))
+	binary.LittleEndian.PutUint16(buf[23:], sp)
+	buf[25] = s.IM
+	buf[26] = s.Border
+
+	ram := buf[snaHeaderSize:]
+	copy(ram, s.RAM[:])
+	binary.LittleEndian.PutUint16(ram[sp-snaRAMStart:], s.PC)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadSNA reads a 48K .sna file, popping PC off the saved stack
+func ReadSNA(r io.Reader) (*Snapshot, error) {
+	buf := make([]byte, snaFileSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("reading 48K snapshot: %w", err)
+	}
+
+	s := &Snapshot{}
+	s.I = buf[0]
+	s.H_, s.L_ = unpair(binary.LittleEndian.Uint16(buf[1:]))
+	s.D_, s.E_ = unpair(binary.LittleEndian.Uint16(buf[3:]))
+	s.B_, s.C_ = unpair(binary.LittleEndian.Uint16(buf[5:]))
+	s.A_, s.F_ = unpair(binary.LittleEndian.Uint16(buf[7:]))
+	s.H, s.L = unpair(binary.LittleEndian.Uint16(buf[9:]))
+	s.D, s.E = unpair(binary.LittleEndian.Uint16(buf[11:]))
+	s.B, s.C = unpair(binary.LittleEndian.Uint16(buf[13:]))
+	s.IY = binary.LittleEndian.Uint16(buf[15:])
+	s.IX = binary.LittleEndian.Uint16(buf[17:])
+	if buf[19]&0x04 != 0 {
+		s.IFF1, s.IFF2 = 1, 1
+	}
+	s.R = buf[20]
+	s.A, s.F = unpair(binary.LittleEndian.Uint16(buf[21:]))
+	sp := binary.LittleEndian.Uint16(buf[23:])
+	s.IM = buf[25] & 0x03
+	s.Border = buf[26] & 0x07
+	copy(s.RAM[:], buf[snaHeaderSize:])
+
+	if sp < snaRAMStart || sp > 0xFFFE {
+		return nil, fmt.Errorf("snapshot SP $%04X does not point into RAM", sp)
+	}
+	s.PC = binary.LittleEndian.Uint16(s.RAM[sp-snaRAMStart:])
+	s.SP = sp + 2
+	return s, nil
+}
+
+// pair joins a high and low register into a 16-bit value
+func pair(hi, lo byte) uint16 {
+	return uint16(hi)<<8 | uint16(lo)
+}
+
+// unpair splits a 16-bit value into its high and low registers
+func unpair(v uint16) (hi, lo byte) {
+	return byte(v >> 8), byte(v)
+}
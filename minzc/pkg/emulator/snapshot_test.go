@@ -0,0 +1,199 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	program := []byte{
+		0x31, 0x00, 0xFF, // LD SP, $FF00
+		0x21, 0x00, 0x90, // LD HL, $9000
+		0x36, 0xAB, //       LD (HL), $AB
+		0x23,       //       INC HL
+		0x36, 0xCD, //       LD (HL), $CD
+		0xD9,             // EXX
+		0x01, 0x11, 0x11, // LD BC, $1111
+		0xD9,             // EXX
+		0x01, 0x33, 0x22, // LD BC, $2233
+		0xDD, 0x21, 0x55, 0x44, // LD IX, $4455
+		0xED, 0x56, // IM 1
+		0xF3, //       DI
+		0x76, //       HALT
+	}
+
+	z := NewRemogattoZ80()
+	z.LoadMemory(0x8000, program)
+	z.SetPC(0x8000)
+	if err := z.Run(); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	saved := z.Snapshot()
+
+	var sna bytes.Buffer
+	if err := WriteSNA(&sna, saved); err != nil {
+		t.Fatal(err)
+	}
+	if sna.Len() != 49179 {
+		t.Fatalf("snapshot is %d bytes, want 49179", sna.Len())
+	}
+	loaded, err := ReadSNA(&sna)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewRemogattoZ80()
+	restored.RestoreSnapshot(loaded)
+	got := restored.Snapshot()
+
+	for addr := uint16(0x9000); addr <= 0x9001; addr++ {
+		if got, want := restored.GetMemory(addr), z.GetMemory(addr); got != want {
+			t.Errorf("memory[$%04X] = $%02X, want $%02X", addr, got, want)
+		}
+	}
+	if got.RAM[0x8000-0x4000] != program[0] {
+		t.Errorf("program not restored at $8000")
+	}
+
+	// The saved RAM differs only where PC was pushed below SP
+	got.RAM, saved.RAM = [len(got.RAM)]byte{}, [len(saved.RAM)]byte{}
+	if *got != *saved {
+		t.Errorf("registers = %+v, want %+v", *got, *saved)
+	}
+	if got.PC != 0x8000+uint16(len(program))-1 || got.SP != 0xFF00 || got.IX != 0x4455 || got.IM != 1 {
+		t.Errorf("PC=$%04X SP=$%04X IX=$%04X IM=%d, want PC at HALT, SP=$FF00, IX=$4455, IM=1",
+			got.PC, got.SP, got.IX, got.IM)
+	}
+	if got.B != 0x22 || got.C != 0x33 || got.B_ != 0x11 || got.C_ != 0x11 {
+		t.Errorf("BC=$%02X%02X BC'=$%02X%02X, want $2233 and $1111", got.B, got.C, got.B_, got.C_)
+	}
+}
+
+func TestSnapshotRejectsROMStack(t *testing.T) {
+	if err := WriteSNA(&bytes.Buffer{}, &Snapshot{SP: 0x3000}); err == nil {
+		t.Error("expected an error for SP in ROM")
+	}
+}
+
+// z80Header builds a .z80 header with recognisable register values
+func z80Header(pc uint16, flags byte) []byte {
+	h := make([]byte, z80HeaderSize)
+	h[0], h[1] = 0x12, 0x34 // A, F
+	h[2], h[3] = 0x33, 0x22 // BC
+	h[4], h[5] = 0x00, 0x90 // HL
+	binary.LittleEndian.PutUint16(h[6:], pc)
+	binary.LittleEndian.PutUint16(h[8:], 0xFF00)
+	h[10], h[11] = 0x3F, 0x85 // I, R (bit 7 comes from the flags)
+	h[12] = flags
+	h[13], h[14] = 0x66, 0x55 // DE
+	h[15], h[16] = 0x11, 0x11 // BC'
+	binary.LittleEndian.PutUint16(h[25:], 0x4455)
+	h[27], h[28], h[29] = 1, 1, 1 // IFF1, IFF2, IM 1
+	return h
+}
+
+func TestReadZ80(t *testing.T) {
+	check := func(name string, s *Snapshot, pc uint16) {
+		t.Helper()
+		if s.A != 0x12 || s.F != 0x34 || s.B != 0x22 || s.C != 0x33 || s.D != 0x55 || s.E != 0x66 ||
+			s.B_ != 0x11 || s.IX != 0x4455 || s.SP != 0xFF00 || s.PC != pc {
+			t.Errorf("%s: registers = %+v", name, *s)
+		}
+		if s.R != 0x85 || s.IM != 1 || s.IFF1 != 1 || s.IFF2 != 1 || s.Border != 2 {
+			t.Errorf("%s: R=$%02X IM=%d IFF=%d/%d border=%d, want R=$85 IM=1 IFF=1/1 border=2",
+				name, s.R, s.IM, s.IFF1, s.IFF2, s.Border)
+		}
+		for addr, want := range map[uint16]byte{0x4000: 0xAA, 0x8000: 0x3E, 0x8001: 0x07, 0xC000: 0xBB, 0xFFFF: 0xCC} {
+			if got := s.RAM[addr-snaRAMStart]; got != want {
+				t.Errorf("%s: memory[$%04X] = $%02X, want $%02X", name, addr, got, want)
+			}
+		}
+	}
+
+	// Version 1, compressed: bit 0 of the flags is R bit 7, bits 1-3 the border
+	v1 := z80Header(0x8000, 0x01|2<<1|0x20)
+	v1 = append(v1, 0xAA)                    // $4000
+	v1 = append(v1, 0xED, 0xED, 0xFF, 0x00)  // 255 zeros
+	for n := 1 + 255; n < 0x4000; n += 255 { // Zeros up to $8000
+		run := byte(255)
+		if 0x4000-n < 255 {
+			run = byte(0x4000 - n)
+		}
+		v1 = append(v1, 0xED, 0xED, run, 0x00)
+	}
+	v1 = append(v1, 0x3E, 0x07) // $8000: LD A, 7
+	v1 = append(v1, make([]byte, 0x4000-2)...)
+	v1 = append(v1, 0xBB) // $C000
+	v1 = append(v1, make([]byte, 0x4000-2)...)
+	v1 = append(v1, 0xCC)                   // $FFFF
+	v1 = append(v1, 0x00, 0xED, 0xED, 0x00) // End marker
+	s, err := ReadZ80(bytes.NewReader(v1))
+	if err != nil {
+		t.Fatalf("version 1: %v", err)
+	}
+	check("version 1", s, 0x8000)
+
+	// Version 2: uncompressed and compressed pages
+	v2 := z80Header(0, 0x01|2<<1)
+	ext := make([]byte, 2+23)
+	binary.LittleEndian.PutUint16(ext, 23)
+	binary.LittleEndian.PutUint16(ext[2:], 0x8000)
+	v2 = append(v2, ext...)
+	page := func(number byte, mem []byte) {
+		v2 = append(v2, 0xFF, 0xFF, number)
+		v2 = append(v2, mem...)
+	}
+	mem := make([]byte, 0x4000)
+	mem[0] = 0xAA
+	page(8, mem)
+	mem = make([]byte, 0x4000)
+	mem[0], mem[1] = 0x3E, 0x07
+	page(4, mem)
+	compressed := []byte{0xBB}
+	for n := 1; n < 0x4000-1; n += 255 {
+		run := 255
+		if 0x4000-1-n < run {
+			run = 0x4000 - 1 - n
+		}
+		compressed = append(compressed, 0xED, 0xED, byte(run), 0x00)
+	}
+	compressed = append(compressed, 0xCC)
+	v2 = append(v2, byte(len(compressed)), byte(len(compressed)>>8), 5)
+	v2 = append(v2, compressed...)
+	if s, err = ReadZ80(bytes.NewReader(v2)); err != nil {
+		t.Fatalf("version 2: %v", err)
+	}
+	check("version 2", s, 0x8000)
+
+	// 128K snapshots are refused
+	v2[z80HeaderSize+4] = 4
+	if _, err := ReadZ80(bytes.NewReader(v2)); err == nil || !strings.Contains(err.Error(), "hardware mode 4") {
+		t.Errorf("128K snapshot: err = %v, want a hardware mode error", err)
+	}
+}
+
+func TestSnapshotBorder(t *testing.T) {
+	z := NewRemogattoZ80()
+	z.LoadMemory(0x8000, []byte{
+		0x3E, 0x05, // LD A, 5
+		0xD3, 0xFE, // OUT ($FE), A
+		0xF3, //       DI
+		0x76, //       HALT
+	})
+	z.SetPC(0x8000)
+	z.SetSP(0xFF00)
+	if err := z.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if z.Border() != 5 || z.Snapshot().Border != 5 {
+		t.Errorf("border = %d, snapshot border = %d, want 5", z.Border(), z.Snapshot().Border)
+	}
+
+	restored := NewRemogattoZ80()
+	restored.RestoreSnapshot(&Snapshot{Border: 3, SP: 0xFF00})
+	if restored.Border() != 3 {
+		t.Errorf("restored border = %d, want 3", restored.Border())
+	}
+}
@@ -0,0 +1,154 @@
+package emulator
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// .z80 snapshots: a 30-byte header, then either the 48K of RAM (version 1)
+// or, when the header's PC is 0, an extended header and 16K pages
+// (versions 2 and 3). Memory may be compressed with ED ED nn bb runs.
+const (
+	z80HeaderSize = 30
+	z80PageSize   = 0x4000
+)
+
+// z80Pages maps the page numbers of a 48K snapshot to their addresses
+var z80Pages = map[byte]uint16{
+	4: 0x8000,
+	5: 0xC000,
+	8: 0x4000,
+}
+
+// ReadZ80 reads a 48K .z80 snapshot of any version. 128K snapshots are
+// rejected, since only the 48K memory map is emulated.
+func ReadZ80(r io.Reader) (*Snapshot, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading .z80 snapshot: %w", err)
+	}
+	if len(data) < z80HeaderSize {
+		return nil, fmt.Errorf("reading .z80 snapshot: header is truncated")
+	}
+
+	h := data[:z80HeaderSize]
+	flags := h[12]
+	if flags == 0xFF {
+		flags = 0x01 // Compatibility: old versions wrote 255 for 1
+	}
+	s := &Snapshot{
+		A: h[0], F: h[1],
+		C: h[2], B: h[3],
+		L: h[4], H: h[5],
+		SP: binary.LittleEndian.Uint16(h[8:]),
+		I:  h[10],
+		R:  h[11]&0x7F | flags<<7,
+		E:  h[13], D: h[14],
+		C_: h[15], B_: h[16],
+		E_: h[17], D_: h[18],
+		L_: h[19], H_: h[20],
+		A_: h[21], F_: h[22],
+		IY:     binary.LittleEndian.Uint16(h[23:]),
+		IX:     binary.LittleEndian.Uint16(h[25:]),
+		IM:     h[29] & 0x03,
+		Border: flags >> 1 & 0x07,
+	}
+	if h[27] != 0 {
+		s.IFF1 = 1
+	}
+	if h[28] != 0 {
+		s.IFF2 = 1
+	}
+
+	// Version 1: PC in the header and one 48K block
+	if pc := binary.LittleEndian.Uint16(h[6:]); pc != 0 {
+		s.PC = pc
+		ram := data[z80HeaderSize:]
+		if flags&0x20 != 0 {
+			ram, err = decompressZ80(ram, snaRAMSize)
+		} else if len(ram) < snaRAMSize {
+			err = fmt.Errorf("RAM is truncated")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading .z80 snapshot: %w", err)
+		}
+		copy(s.RAM[:], ram)
+		return s, nil
+	}
+
+	// Versions 2 and 3: extended header, then the pages
+	if len(data) < z80HeaderSize+2 {
+		return nil, fmt.Errorf("reading .z80 snapshot: extended header is truncated")
+	}
+	extra := int(binary.LittleEndian.Uint16(data[z80HeaderSize:]))
+	body := z80HeaderSize + 2 + extra
+	if extra < 4 || len(data) < body {
+		return nil, fmt.Errorf("reading .z80 snapshot: extended header is truncated")
+	}
+	ext := data[z80HeaderSize+2 : body]
+	s.PC = binary.LittleEndian.Uint16(ext[0:])
+	mode := ext[2]
+	if !(mode == 0 || mode == 1 || (mode == 3 && extra > 23)) {
+		return nil, fmt.Errorf("reading .z80 snapshot: hardware mode %d is not a 48K Spectrum", mode)
+	}
+
+	loaded := 0
+	for pos := body; pos < len(data); {
+		if pos+3 > len(data) {
+			return nil, fmt.Errorf("reading .z80 snapshot: page header is truncated")
+		}
+		length := int(binary.LittleEndian.Uint16(data[pos:]))
+		page := data[pos+2]
+		pos += 3
+
+		var mem []byte
+		if length == 0xFFFF {
+			if pos+z80PageSize > len(data) {
+				return nil, fmt.Errorf("reading .z80 snapshot: page %d is truncated", page)
+			}
+			mem = data[pos : pos+z80PageSize]
+			pos += z80PageSize
+		} else {
+			if pos+length > len(data) {
+				return nil, fmt.Errorf("reading .z80 snapshot: page %d is truncated", page)
+			}
+			if mem, err = decompressZ80(data[pos:pos+length], z80PageSize); err != nil {
+				return nil, fmt.Errorf("reading .z80 snapshot: page %d: %w", page, err)
+			}
+			pos += length
+		}
+
+		addr, ok := z80Pages[page]
+		if !ok {
+			continue // ROM pages and pages of other models
+		}
+		copy(s.RAM[addr-snaRAMStart:], mem)
+		loaded++
+	}
+	if loaded != len(z80Pages) {
+		return nil, fmt.Errorf("reading .z80 snapshot: %d of %d RAM pages present", loaded, len(z80Pages))
+	}
+	return s, nil
+}
+
+// decompressZ80 expands ED ED nn bb runs (nn copies of bb) until size bytes
+// are produced. The version 1 end marker 00 ED ED 00 may follow.
+func decompressZ80(data []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for i := 0; i < len(data) && len(out) < size; {
+		if i+3 < len(data) && data[i] == 0xED && data[i+1] == 0xED {
+			for n := 0; n < int(data[i+2]); n++ {
+				out = append(out, data[i+3])
+			}
+			i += 4
+			continue
+		}
+		out = append(out, data[i])
+		i++
+	}
+	if len(out) != size {
+		return nil, fmt.Errorf("compressed data expands to %d bytes, want %d", len(out), size)
+	}
+	return out, nil
+}
@@ -0,0 +1,213 @@
+package emulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/remogatto/z80"
+)
+
+// TraceFormat selects how executed instructions are written
+type TraceFormat string
+
+const (
+	// TraceText is one human-readable line per instruction: address,
+	// opcode bytes, mnemonic, the registers after it, the T-states it took
+	// and the T-states since reset:
+	//
+	//	$8000  3E 05        LD A,0x05        A=05 F=00 BC=0000 ... SP=FFFF  T=7/7
+	TraceText TraceFormat = "text"
+	// TraceJSON is one JSON object per line (JSON Lines) with the fields
+	// pc, bytes (opcode bytes), mnemonic, registers (a, f, bc, de, hl, ix,
+	// iy, sp, pc after the instruction), tstates (taken by the instruction)
+	// and cycles (T-states since reset):
+	//
+	//	{"pc":32768,"bytes":[62,5],"mnemonic":"LD A,0x05","registers":{...},"tstates":7,"cycles":7}
+	TraceJSON TraceFormat = "json"
+	// TraceFuse writes the two state lines of the Fuse emulator's Z80 test
+	// suite (tests.expected) per instruction, so traces can be diffed
+	// against Fuse:
+	//
+	//	AF BC DE HL AF' BC' DE' HL' IX IY SP PC MEMPTR
+	//	I R IFF1 IFF2 IM halted tstates
+	//
+	// MEMPTR is not emulated and is always 0000.
+	TraceFuse TraceFormat = "fuse"
+)
+
+// ParseTraceFormat validates a trace format name
+func ParseTraceFormat(name string) (TraceFormat, error) {
+	switch format := TraceFormat(strings.ToLower(name)); format {
+	case TraceText, TraceJSON, TraceFuse:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown trace format %q (want text, json or fuse)", name)
+}
+
+// TraceEntry is one executed instruction. Registers and Cycles are the
+// state after the instruction; Cycles counts T-states since reset and
+// TStates those the instruction took, including an interrupt it let in.
+type TraceEntry struct {
+	PC        uint16
+	Bytes     []byte
+	Mnemonic  string
+	Registers Registers
+	TStates   int
+	Cycles    int
+
+	// Remaining state for the Fuse format
+	AltAF, AltBC, AltDE, AltHL uint16
+	I, R, IFF1, IFF2, IM       byte
+	Halted                     bool
+}
+
+// traceJSON is the JSON Lines record for a TraceEntry
+type traceJSON struct {
+	PC        uint16            `json:"pc"`
+	Bytes     []int             `json:"bytes"`
+	Mnemonic  string            `json:"mnemonic"`
+	Registers map[string]uint16 `json:"registers"`
+	TStates   int               `json:"tstates"`
+	Cycles    int               `json:"cycles"`
+}
+
+// Tracer writes executed instructions in the selected format. The first
+// write error stops tracing and is reported by Err.
+type Tracer struct {
+	w      io.Writer
+	format TraceFormat
+	err    error
+}
+
+// NewTracer creates a tracer writing to w
+func NewTracer(w io.Writer, format TraceFormat) *Tracer {
+	return &Tracer{w: w, format: format}
+}
+
+// Err returns the first write error, if any
+func (t *Tracer) Err() error {
+	return t.err
+}
+
+// Record writes one executed instruction
+func (t *Tracer) Record(e TraceEntry) {
+	if t.err != nil {
+		return
+	}
+	switch t.format {
+	case TraceJSON:
+		t.err = json.NewEncoder(t.w).Encode(e.toJSON())
+	case TraceFuse:
+		r := e.Registers
+		halted := 0
+		if e.Halted {
+			halted = 1
+		}
+		_, t.err = fmt.Fprintf(t.w, "%04x %04x %04x %04x %04x %04x %04x %04x %04x %04x %04x %04x %04x\n%02x %02x %d %d %d %d %d\n",
+			uint16(r.A)<<8|uint16(r.F), r.BC, r.DE, r.HL, e.AltAF, e.AltBC, e.AltDE, e.AltHL,
+			r.IX, r.IY, r.SP, r.PC, 0,
+			e.I, e.R, e.IFF1, e.IFF2, e.IM, halted, e.Cycles)
+	default:
+		hex := make([]string, len(e.Bytes))
+		for i, b := range e.Bytes {
+			hex[i] = fmt.Sprintf("%02X", b)
+		}
+		r := e.Registers
+		_, t.err = fmt.Fprintf(t.w, "$%04X  %-12s %-16s A=%02X F=%02X BC=%04X DE=%04X HL=%04X IX=%04X IY=%04X SP=%04X  T=%d/%d\n",
+			e.PC, strings.Join(hex, " "), e.Mnemonic, r.A, r.F, r.BC, r.DE, r.HL, r.IX, r.IY, r.SP, e.TStates, e.Cycles)
+	}
+}
+
+func (e TraceEntry) toJSON() traceJSON {
+	bytes := make([]int, len(e.Bytes))
+	for i, b := range e.Bytes {
+		bytes[i] = int(b)
+	}
+	r := e.Registers
+	return traceJSON{
+		PC:       e.PC,
+		Bytes:    bytes,
+		Mnemonic: e.Mnemonic,
+		Registers: map[string]uint16{
+			"a": uint16(r.A), "f": uint16(r.F),
+			"bc": r.BC, "de": r.DE, "hl": r.HL,
+			"ix": r.IX, "iy": r.IY, "sp": r.SP, "pc": r.PC,
+		},
+		TStates: e.TStates,
+		Cycles:  e.Cycles,
+	}
+}
+
+// untimedMemory reads memory for the disassembler without advancing the
+// CPU's T-state counter
+type untimedMemory struct {
+	*Memory
+}
+
+func (u untimedMemory) ReadByte(address uint16) byte {
+	return u.data[address]
+}
+
+func (u untimedMemory) ReadByteInternal(address uint16) byte {
+	return u.data[address]
+}
+
+// SetTracer enables instruction tracing; nil disables it
+func (z *RemogattoZ80) SetTracer(t *Tracer) {
+	z.tracer = t
+}
+
+// beginTrace captures the instruction at pc before it executes, since
+// self-modifying code may overwrite it, and the T-states it starts at
+func (z *RemogattoZ80) beginTrace(pc uint16) TraceEntry {
+	mnemonic, length := z.Disassemble(pc)
+	bytes := make([]byte, length)
+	for i := range bytes {
+		bytes[i] = z.memory.data[pc+uint16(i)]
+	}
+	return TraceEntry{PC: pc, Bytes: bytes, Mnemonic: mnemonic, Cycles: z.cpu.Tstates}
+}
+
+// byteImmediate matches the library's rendering of an 8-bit immediate,
+// which it parenthesises like a memory operand
+var byteImmediate = regexp.MustCompile(`([ ,])\((0x[0-9a-f]{2})\)$`)
+
+// Disassemble returns the instruction at addr and its length in bytes
+func (z *RemogattoZ80) Disassemble(addr uint16) (string, uint16) {
+	memory := untimedMemory{z.memory}
+	switch z.memory.data[addr] {
+	case 0xD3: // The library prints "(nn)" and misses the port byte
+		return fmt.Sprintf("OUT (0x%02x),A", z.memory.data[addr+1]), 2
+	case 0xDB:
+		return fmt.Sprintf("IN A,(0x%02x)", z.memory.data[addr+1]), 2
+	}
+	mnemonic, next, shift := z80.Disassemble(memory, addr, 0)
+	for shift != 0 && next-addr < 4 {
+		mnemonic, next, shift = z80.Disassemble(memory, next, shift)
+	}
+	length := next - addr
+	if length == 0 || length > 4 {
+		length = 1
+	}
+	mnemonic = byteImmediate.ReplaceAllString(strings.TrimSpace(mnemonic), "$1$2")
+	return mnemonic, length
+}
+
+// endTrace completes the entry with the state after execution and records it
+func (z *RemogattoZ80) endTrace(e TraceEntry) {
+	cpu := z.cpu
+	e.Registers = z.GetRegisters()
+	e.TStates = cpu.Tstates - e.Cycles
+	e.Cycles = cpu.Tstates
+	e.AltAF = uint16(cpu.A_)<<8 | uint16(cpu.F_)
+	e.AltBC = uint16(cpu.B_)<<8 | uint16(cpu.C_)
+	e.AltDE = uint16(cpu.D_)<<8 | uint16(cpu.E_)
+	e.AltHL = uint16(cpu.H_)<<8 | uint16(cpu.L_)
+	e.I, e.R = cpu.I, byte(cpu.R&0x7F)|cpu.R7&0x80
+	e.IFF1, e.IFF2, e.IM = cpu.IFF1, cpu.IFF2, cpu.IM
+	e.Halted = cpu.Halted
+	z.tracer.Record(e)
+}
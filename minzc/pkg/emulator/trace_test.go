@@ -0,0 +1,159 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// traceProgram runs LD A,5 / LD B,A / INC A and returns the trace
+func traceProgram(t *testing.T, format TraceFormat) string {
+	t.Helper()
+	z := NewRemogattoZ80()
+	z.LoadMemory(0x8000, []byte{
+		0x3E, 0x05, // LD A, 5
+		0x47, //       LD B, A
+		0x3C, //       INC A
+	})
+	z.SetPC(0x8000)
+
+	var buf bytes.Buffer
+	tracer := NewTracer(&buf, format)
+	z.SetTracer(tracer)
+	for i := 0; i < 3; i++ {
+		z.Step()
+	}
+	if err := tracer.Err(); err != nil {
+		t.Fatalf("trace write failed: %v", err)
+	}
+	return buf.String()
+}
+
+func TestTraceText(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(traceProgram(t, TraceText)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	for i, want := range []string{"$8000  3E 05", "$8002  47", "$8003  3C"} {
+		if !strings.HasPrefix(lines[i], want) {
+			t.Errorf("line %d = %q, want prefix %q", i, lines[i], want)
+		}
+	}
+	if !strings.Contains(lines[2], "A=06") || !strings.Contains(lines[2], "BC=0500") {
+		t.Errorf("final state missing from %q", lines[2])
+	}
+	for i, want := range []string{"T=7/7", "T=4/11", "T=4/15"} {
+		if !strings.HasSuffix(lines[i], want) {
+			t.Errorf("line %d = %q, want T-states %s", i, lines[i], want)
+		}
+	}
+}
+
+func TestTraceJSON(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(traceProgram(t, TraceJSON)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3", len(lines))
+	}
+
+	wantPC := []uint16{0x8000, 0x8002, 0x8003}
+	wantLen := []int{2, 1, 1}
+	wantTstates := []int{7, 4, 4}
+	lastCycles := 0
+	for i, line := range lines {
+		var rec struct {
+			PC        *uint16           `json:"pc"`
+			Bytes     []int             `json:"bytes"`
+			Mnemonic  string            `json:"mnemonic"`
+			Registers map[string]uint16 `json:"registers"`
+			Tstates   *int              `json:"tstates"`
+			Cycles    *int              `json:"cycles"`
+		}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("line %d is not JSON: %v\n%s", i, err, line)
+		}
+		if rec.PC == nil || *rec.PC != wantPC[i] {
+			t.Errorf("line %d: pc missing or wrong: %s", i, line)
+		}
+		if len(rec.Bytes) != wantLen[i] {
+			t.Errorf("line %d: bytes = %v, want %d bytes", i, rec.Bytes, wantLen[i])
+		}
+		if rec.Mnemonic == "" {
+			t.Errorf("line %d: empty mnemonic", i)
+		}
+		for _, reg := range []string{"a", "f", "bc", "de", "hl", "ix", "iy", "sp", "pc"} {
+			if _, ok := rec.Registers[reg]; !ok {
+				t.Errorf("line %d: register %s missing", i, reg)
+			}
+		}
+		if rec.Tstates == nil || *rec.Tstates != wantTstates[i] {
+			t.Errorf("line %d: tstates missing or not %d: %s", i, wantTstates[i], line)
+		}
+		if rec.Cycles == nil || *rec.Cycles <= lastCycles {
+			t.Errorf("line %d: cycles missing or not increasing: %s", i, line)
+		} else {
+			lastCycles = *rec.Cycles
+		}
+	}
+	if !strings.Contains(lines[0], `"mnemonic":"LD A,`) {
+		t.Errorf("first mnemonic is not LD A: %s", lines[0])
+	}
+}
+
+func TestTraceFuse(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(traceProgram(t, TraceFuse)), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("got %d lines, want 2 per instruction:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	for i := 0; i < len(lines); i += 2 {
+		if n := len(strings.Fields(lines[i])); n != 13 {
+			t.Errorf("register line %q has %d words, want 13", lines[i], n)
+		}
+		if n := len(strings.Fields(lines[i+1])); n != 7 {
+			t.Errorf("state line %q has %d fields, want 7", lines[i+1], n)
+		}
+	}
+
+	// After INC A: A=06, B=05, PC=8004
+	regs := strings.Fields(lines[4])
+	if !strings.HasPrefix(regs[0], "06") || regs[1] != "0500" || regs[11] != "8004" {
+		t.Errorf("final register line = %q", lines[4])
+	}
+	state := strings.Fields(lines[5])
+	if state[6] != "15" {
+		t.Errorf("tstates = %s, want 15 (7 + 4 + 4)", state[6])
+	}
+}
+
+func TestParseTraceFormat(t *testing.T) {
+	for _, name := range []string{"text", "json", "fuse", "JSON"} {
+		if _, err := ParseTraceFormat(name); err != nil {
+			t.Errorf("ParseTraceFormat(%q): %v", name, err)
+		}
+	}
+	if _, err := ParseTraceFormat("xml"); err == nil {
+		t.Error("ParseTraceFormat accepted xml")
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	z := NewRemogattoZ80()
+	for _, tt := range []struct {
+		code   []byte
+		want   string
+		length uint16
+	}{
+		{[]byte{0x3E, 0x05}, "LD A,0x05", 2},
+		{[]byte{0x3A, 0x00, 0x40}, "LD A,(0x4000)", 3},
+		{[]byte{0xFE, 0x10}, "CP 0x10", 2},
+		{[]byte{0xDD, 0x36, 0x02, 0x07}, "LD (ix+0x02),0x07", 4},
+		{[]byte{0xD3, 0xFE}, "OUT (0xfe),A", 2},
+		{[]byte{0xDB, 0x1F}, "IN A,(0x1f)", 2},
+	} {
+		z.LoadMemory(0x8000, tt.code)
+		got, length := z.Disassemble(0x8000)
+		if got != tt.want || length != tt.length {
+			t.Errorf("Disassemble(% X) = %q, %d; want %q, %d", tt.code, got, length, tt.want, tt.length)
+		}
+	}
+}
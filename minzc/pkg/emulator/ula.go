@@ -0,0 +1,158 @@
+package emulator
+
+// Accurate timing models the 48K ZX Spectrum's ULA, which shares the bus
+// with the CPU. A frame is 312 lines of 224 T-states; the interrupt is
+// raised for the first 32 T-states of each frame. While the ULA fetches
+// the 192 lines of screen data it holds off CPU accesses to $4000-$7FFF
+// for up to 6 T-states, and a read of a port nothing answers returns the
+// byte it is fetching at that moment (the floating bus). Execution starts
+// at the beginning of a frame, just after its interrupt.
+const (
+	FrameTstates    = 69888 // T-states per frame
+	LineTstates     = 224   // T-states per line
+	ContendedStart  = 14335 // First T-state of a frame the CPU can be held at
+	InterruptLength = 32    // T-states the interrupt stays raised
+
+	screenLines      = 192
+	contendedLength  = 128   // T-states per line spent fetching screen data
+	floatingBusStart = 14338 // T-state of the first screen byte on the bus
+)
+
+// contentionPattern is the delay of an access to contended memory at each
+// T-state of a ULA fetch cycle
+var contentionPattern = [8]int{6, 5, 4, 3, 2, 1, 0, 0}
+
+// ULA keeps the frame timing of the accurate timing mode
+type ULA struct {
+	tstates     *int // The CPU's T-state counter
+	origin      int  // Counter value at which frame 0 began
+	interrupted int  // Last frame whose interrupt was taken
+	memory      *Memory
+}
+
+// EnableAccurateTiming turns on the 48K ULA timing model and returns it
+func (z *RemogattoZ80) EnableAccurateTiming() *ULA {
+	if z.ula == nil {
+		z.ula = &ULA{tstates: &z.cpu.Tstates, origin: z.cpu.Tstates, memory: z.memory}
+		z.memory.ula = z.ula
+		z.ports.ula = z.ula
+	}
+	return z.ula
+}
+
+// Frame returns the number of the current frame, counting from 0
+func (u *ULA) Frame() int {
+	return (*u.tstates - u.origin) / FrameTstates
+}
+
+// FramePosition returns the T-state within the current frame
+func (u *ULA) FramePosition() int {
+	return (*u.tstates - u.origin) % FrameTstates
+}
+
+// contentionDelay returns how long an access to contended memory at frame
+// T-state t is held
+func contentionDelay(t int) int {
+	t -= ContendedStart
+	if t < 0 || t >= screenLines*LineTstates {
+		return 0
+	}
+	x := t % LineTstates
+	if x >= contendedLength {
+		return 0
+	}
+	return contentionPattern[x%8]
+}
+
+// isContended reports whether the ULA shares the bus for an address
+func isContended(addr uint16) bool {
+	return addr&0xC000 == 0x4000
+}
+
+// contend holds the CPU for an access to addr at the current T-state. On a
+// 128K the odd RAM pages are contended wherever they are mapped.
+func (u *ULA) contend(addr uint16) {
+	paging := u.memory.paging
+	if isContended(addr) || paging != nil && addr >= 0xC000 && paging.RAMPage()&1 != 0 {
+		*u.tstates += contentionDelay(u.FramePosition())
+	}
+}
+
+// ioCycle runs the 4 T-states of an I/O access, as held by the ULA: it
+// answers even ports, and ports in $4000-$7FFF look like contended memory
+// on the address bus. The read (if any) happens after the first T-state.
+func (u *ULA) ioCycle(port uint16, read func() byte) byte {
+	u.contend(port)
+	*u.tstates++
+	var value byte
+	if read != nil {
+		value = read()
+	}
+	switch {
+	case port&0x01 == 0:
+		u.contend(0x4000) // The ULA itself
+		*u.tstates += 3
+	case isContended(port):
+		for i := 0; i < 3; i++ {
+			u.contend(port)
+			*u.tstates++
+		}
+	default:
+		*u.tstates += 3
+	}
+	return value
+}
+
+// floatingBus returns the byte the ULA is fetching: a bitmap byte, its
+// attribute, the next bitmap byte and its attribute, then four idle
+// T-states, across the 128 T-states of each screen line
+func (u *ULA) floatingBus() byte {
+	t := u.FramePosition() - floatingBusStart
+	if t < 0 || t >= screenLines*LineTstates {
+		return 0xFF
+	}
+	line, x := t/LineTstates, t%LineTstates
+	if x >= contendedLength {
+		return 0xFF
+	}
+	column := uint16(x/8) * 2
+	phase := x % 8
+	if phase >= 4 {
+		return 0xFF
+	}
+	column += uint16(phase / 2)
+	if phase%2 == 0 {
+		return u.screenByte(screenAddress(line) + column)
+	}
+	return u.screenByte(0x5800 + uint16(line/8)*32 + column)
+}
+
+// screenByte returns the byte of the displayed screen at address, which on
+// a 128K may be the shadow screen in page 7
+func (u *ULA) screenByte(address uint16) byte {
+	if u.memory.paging != nil {
+		return u.memory.paging.screenByte(address - 0x4000)
+	}
+	return u.memory.data[address]
+}
+
+// screenAddress returns the address of the first bitmap byte of a line
+func screenAddress(line int) uint16 {
+	y := uint16(line)
+	return 0x4000 | (y&0xC0)<<5 | (y&0x07)<<8 | (y&0x38)<<2
+}
+
+// interrupt takes the frame interrupt if it is raised and enabled. An
+// interrupt is not taken right after EI, which enables them only after
+// the next instruction.
+func (u *ULA) interrupt(z *RemogattoZ80, opcode byte) {
+	frame := u.Frame()
+	if frame == u.interrupted || u.FramePosition() >= InterruptLength {
+		return
+	}
+	if z.cpu.IFF1 == 0 || opcode == 0xFB {
+		return
+	}
+	u.interrupted = frame
+	z.cpu.Interrupt()
+}
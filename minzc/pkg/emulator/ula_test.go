@@ -0,0 +1,122 @@
+package emulator
+
+import "testing"
+
+func TestContentionDelay(t *testing.T) {
+	for _, tt := range []struct {
+		t, want int
+	}{
+		{ContendedStart - 1, 0},
+		{ContendedStart, 6},
+		{ContendedStart + 5, 1},
+		{ContendedStart + 6, 0},
+		{ContendedStart + 8, 6},
+		{ContendedStart + 127, 0},
+		{ContendedStart + 128, 0}, // Border of the line
+		{ContendedStart + LineTstates, 6},
+		{ContendedStart + 191*LineTstates + 1, 5},
+		{ContendedStart + 192*LineTstates, 0}, // Below the screen
+	} {
+		if got := contentionDelay(tt.t); got != tt.want {
+			t.Errorf("contentionDelay(%d) = %d, want %d", tt.t, got, tt.want)
+		}
+	}
+}
+
+// stepAt runs one instruction starting at frame T-state start and returns
+// its T-states
+func stepAt(z *RemogattoZ80, start int) int {
+	z.cpu.Tstates = z.ula.origin + start
+	z.SetPC(0x8000)
+	return z.Step()
+}
+
+func TestAccurateTimingContention(t *testing.T) {
+	z := NewRemogattoZ80()
+	z.EnableAccurateTiming()
+	z.LoadMemory(0x8000, []byte{0x3A, 0x00, 0x40}) // LD A, ($4000)
+
+	// The read is the last 3 of 13 T-states
+	if got := stepAt(z, 1000); got != 13 {
+		t.Errorf("outside the screen: %d T-states, want 13", got)
+	}
+	if got := stepAt(z, ContendedStart-10); got != 19 {
+		t.Errorf("read at the start of a fetch: %d T-states, want 19", got)
+	}
+	z.LoadMemory(0x8000, []byte{0x3A, 0x00, 0x80}) // LD A, ($8000)
+	if got := stepAt(z, ContendedStart-10); got != 13 {
+		t.Errorf("uncontended address: %d T-states, want 13", got)
+	}
+
+	// OUT to the ULA is held like contended memory
+	z.LoadMemory(0x8000, []byte{0xD3, 0xFE}) // OUT ($FE), A
+	if got := stepAt(z, 1000); got != 11 {
+		t.Errorf("OUT outside the screen: %d T-states, want 11", got)
+	}
+	if got := stepAt(z, ContendedStart-8); got != 17 {
+		t.Errorf("OUT during a fetch: %d T-states, want 17", got)
+	}
+}
+
+func TestFloatingBus(t *testing.T) {
+	z := NewRemogattoZ80()
+	z.EnableAccurateTiming()
+	z.LoadMemory(0x8000, []byte{0xDB, 0xFF}) // IN A, ($FF)
+	z.SetMemory(0x4000, 0xAA)                // Bitmap of line 0, column 0
+	z.SetMemory(0x5800, 0x38)                // Its attribute
+	z.SetMemory(0x4001, 0x55)
+	z.cpu.A = 0
+
+	// The port is read 8 T-states into the instruction
+	for _, tt := range []struct {
+		at   int
+		want byte
+	}{
+		{floatingBusStart - 1, 0xFF},
+		{floatingBusStart, 0xAA},
+		{floatingBusStart + 1, 0x38},
+		{floatingBusStart + 2, 0x55},
+		{floatingBusStart + 4, 0xFF},   // Idle
+		{floatingBusStart + 130, 0xFF}, // Border
+	} {
+		stepAt(z, tt.at-8)
+		if z.cpu.A != tt.want {
+			t.Errorf("IN at T-state %d read $%02X, want $%02X", tt.at, z.cpu.A, tt.want)
+		}
+		z.cpu.A = 0
+	}
+}
+
+func TestFrameInterrupt(t *testing.T) {
+	z := NewRemogattoZ80()
+	ula := z.EnableAccurateTiming()
+	z.LoadMemory(0x8000, []byte{
+		0x3E, 0x90, // LD A, $90
+		0xED, 0x47, // LD I, A
+		0xED, 0x5E, // IM 2
+		0xFB, // EI
+		0x76, // HALT: waits for the interrupt
+		0xF3, // DI
+		0x76, // HALT: stops
+	})
+	z.LoadMemory(0x90FF, []byte{0x00, 0xA0}) // IM 2 vector: $A000
+	z.LoadMemory(0xA000, []byte{
+		0x06, 0x07, // LD B, 7
+		0xC9, // RET
+	})
+	z.SetSP(0xFF00)
+	z.SetPC(0x8000)
+
+	if err := z.Run(); err != nil {
+		t.Fatalf("run failed: %v\n%s", err, z.DumpState())
+	}
+	if regs := z.GetRegisters(); regs.BC>>8 != 7 {
+		t.Errorf("B = %d, want 7: interrupt handler did not run", regs.BC>>8)
+	}
+	if ula.Frame() != 1 {
+		t.Errorf("stopped in frame %d, want 1", ula.Frame())
+	}
+	if pos := ula.FramePosition(); pos > InterruptLength+40 {
+		t.Errorf("stopped at frame T-state %d, want just after the interrupt", pos)
+	}
+}
@@ -66,7 +66,7 @@ func NewRemogattoZ80WithScreen() *RemogattoZ80WithScreen {
 			if z80.Hooks.OnIN != nil {
 				return z80.Hooks.OnIN(byte(port))
 			}
-			return 0xFF
+			return z80.ports.Unattached(port)
 		},
 		func(port uint16, value byte) {
 			if z80.Hooks.OnOUT != nil {
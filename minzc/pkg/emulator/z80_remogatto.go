@@ -28,6 +28,35 @@ type RemogattoZ80 struct {
 	
 	// Output capture
 	output []byte
+	
+	// Optional execution coverage
+	coverage *Coverage
+	
+	// Optional instruction trace
+	tracer *Tracer
+	
+	// Call in progress: the SP to return to and whether it has returned
+	calling  bool
+	callSP   uint16
+	returned bool
+	
+	// ULA timing model, when accurate timing is enabled
+	ula *ULA
+	
+	// CP/M BDOS, when CP/M is enabled
+	cpm *CPM
+	
+	// AY sound chip, when enabled
+	ay *AY
+	
+	// 128K memory paging, when enabled
+	paging *Paging
+	
+	// Keyboard matrix, when enabled
+	keyboard *Keyboard
+	
+	// T-states after which Run stops with an error; 0 for no limit
+	cycleLimit int
 }
 
 // Memory implements z80.MemoryAccessor interface
@@ -35,6 +64,11 @@ type Memory struct {
 	data     [65536]byte
 	romEnd   uint16
 	smcTracker func(addr uint16, oldVal, newVal byte) // Optional SMC tracking
+	tstates  *int // CPU T-state counter advanced by the contention hooks
+	ula      *ULA // Holds accesses to contended memory; nil when uncontended
+	paging   *Paging // 128K paging, when enabled
+	readHook  func(address uint16, value byte) // CPU reads (see Hooks)
+	writeHook func(address uint16, value byte) // CPU writes (see Hooks)
 }
 
 func NewMemory() *Memory {
@@ -43,17 +77,39 @@ func NewMemory() *Memory {
 	}
 }
 
+// ReadByte is a timed CPU read: 3 T-states
 func (m *Memory) ReadByte(address uint16) byte {
-	return m.data[address]
+	m.contend(address)
+	m.addTstates(3)
+	return m.ReadByteInternal(address)
 }
 
+// WriteByte is a timed CPU write: 3 T-states
 func (m *Memory) WriteByte(address uint16, value byte) {
+	m.contend(address)
+	m.addTstates(3)
+	m.WriteByteInternal(address, value)
+}
+
+// Required by MemoryAccessor interface
+func (m *Memory) ReadByteInternal(address uint16) byte {
+	value := m.data[address]
+	if m.readHook != nil {
+		m.readHook(address, value)
+	}
+	return value
+}
+
+func (m *Memory) WriteByteInternal(address uint16, value byte) {
 	if address < m.romEnd {
 		return // ROM protection
 	}
 	
 	oldVal := m.data[address]
-	m.data[address] = value
+	m.poke(address, value)
+	if m.writeHook != nil {
+		m.writeHook(address, value)
+	}
 	
 	// Track SMC if handler is set
 	if m.smcTracker != nil && oldVal != value {
@@ -61,31 +117,74 @@ func (m *Memory) WriteByte(address uint16, value byte) {
 	}
 }
 
-// Required by MemoryAccessor interface
-func (m *Memory) ReadByteInternal(address uint16) byte {
-	return m.ReadByte(address)
+// poke stores a byte, and in the other window of a 128K page mapped twice
+func (m *Memory) poke(address uint16, value byte) {
+	m.data[address] = value
+	if m.paging != nil {
+		if other, ok := m.paging.alias(address); ok {
+			m.data[other] = value
+		}
+	}
 }
 
-func (m *Memory) WriteByteInternal(address uint16, value byte) {
-	m.WriteByte(address, value)
+// The contention hooks are where the CPU core accounts T-states for memory
+// cycles. Unless accurate timing is enabled memory is uncontended, so each
+// simply adds its time.
+func (m *Memory) ContendRead(address uint16, time int) {
+	m.contend(address)
+	m.addTstates(time)
+}
+func (m *Memory) ContendReadNoMreq(address uint16, time int) {
+	m.contend(address)
+	m.addTstates(time)
+}
+func (m *Memory) ContendReadNoMreq_loop(address uint16, time int, count uint) {
+	m.contendLoop(address, time, count)
+}
+func (m *Memory) ContendWriteNoMreq(address uint16, time int) {
+	m.contend(address)
+	m.addTstates(time)
+}
+func (m *Memory) ContendWriteNoMreq_loop(address uint16, time int, count uint) {
+	m.contendLoop(address, time, count)
+}
+
+// contend lets the ULA hold an access to address
+func (m *Memory) contend(address uint16) {
+	if m.ula != nil {
+		m.ula.contend(address)
+	}
+}
+
+// contendLoop accounts count cycles of time T-states each, every one of
+// which the ULA may hold
+func (m *Memory) contendLoop(address uint16, time int, count uint) {
+	if m.ula == nil {
+		m.addTstates(time * int(count))
+		return
+	}
+	for i := uint(0); i < count; i++ {
+		m.ula.contend(address)
+		m.addTstates(time)
+	}
 }
 
-func (m *Memory) ContendRead(address uint16, time int) {}
-func (m *Memory) ContendReadNoMreq(address uint16, time int) {}
-func (m *Memory) ContendReadNoMreq_loop(address uint16, time int, count uint) {}
-func (m *Memory) ContendWriteNoMreq(address uint16, time int) {}
-func (m *Memory) ContendWriteNoMreq_loop(address uint16, time int, count uint) {}
+func (m *Memory) addTstates(time int) {
+	if m.tstates != nil {
+		*m.tstates += time
+	}
+}
 
 // Additional methods required by MemoryAccessor
 func (m *Memory) Read(address uint16) byte {
-	return m.ReadByte(address)
+	return m.ReadByteInternal(address)
 }
 
 func (m *Memory) Write(address uint16, value byte, protectROM bool) {
 	if protectROM && address < m.romEnd {
 		return
 	}
-	m.WriteByte(address, value)
+	m.WriteByteInternal(address, value)
 }
 
 func (m *Memory) Data() []byte {
@@ -97,6 +196,12 @@ type Ports struct {
 	ioRead  func(port uint16) byte
 	ioWrite func(port uint16, value byte)
 	output  *[]byte
+	border  byte // Last colour written to the ULA port ($FE)
+	tstates *int // CPU T-state counter advanced by the contention hooks
+	ula     *ULA // Times I/O cycles when accurate timing is enabled
+	ay      *AY  // Sound chip on $FFFD/$BFFD, when enabled
+	paging  *Paging // 128K memory paging on $7FFD, when enabled
+	keyboard *Keyboard // Key matrix on the even ports, when enabled
 }
 
 func NewPorts(output *[]byte) *Ports {
@@ -106,18 +211,59 @@ func NewPorts(output *[]byte) *Ports {
 }
 
 func (p *Ports) ReadPort(address uint16) byte {
-	if p.ioRead != nil {
-		return p.ioRead(address)
+	read := func() byte {
+		if p.ay != nil {
+			if value, ok := p.ay.readPort(address); ok {
+				return value
+			}
+		}
+		if p.keyboard != nil && address&0x01 == 0 {
+			return p.keyboard.read(address)
+		}
+		if p.ioRead != nil {
+			return p.ioRead(address)
+		}
+		return p.Unattached(address)
+	}
+	if p.ula != nil {
+		return p.ula.ioCycle(address, read)
+	}
+	return read()
+}
+
+// Unattached returns what a read of a port no device answers returns:
+// $FF, or with accurate timing the byte on the ULA's floating bus for the
+// odd ports it does not decode
+func (p *Ports) Unattached(address uint16) byte {
+	if p.ula != nil && address&0x01 != 0 {
+		return p.ula.floatingBus()
 	}
 	return 0xFF
 }
 
 func (p *Ports) WritePort(address uint16, b byte) {
+	if p.ula != nil {
+		p.ula.ioCycle(address, nil)
+	}
+	
 	// Console output port
 	if address&0xFF == 0x01 {
 		*p.output = append(*p.output, b)
 	}
 	
+	// The ULA answers every even port; bits 0-2 set the border
+	if address&0x01 == 0 {
+		p.border = b & 0x07
+	}
+	
+	if p.ay != nil {
+		p.ay.writePort(address, b)
+	}
+	
+	if p.paging != nil && isPagingPort(address) {
+		p.paging.Write(b)
+	}
+	
 	if p.ioWrite != nil {
 		p.ioWrite(address, b)
 	}
@@ -131,8 +277,17 @@ func (p *Ports) WritePortInternal(address uint16, b byte, contend bool) {
 	p.WritePort(address, b)
 }
 
-func (p *Ports) ContendPortPreio(address uint16) {}
-func (p *Ports) ContendPortPostio(address uint16) {}
+// An uncontended I/O cycle is 1 T-state before the access and 3 after
+func (p *Ports) ContendPortPreio(address uint16) {
+	if p.tstates != nil {
+		*p.tstates += 1
+	}
+}
+func (p *Ports) ContendPortPostio(address uint16) {
+	if p.tstates != nil {
+		*p.tstates += 3
+	}
+}
 
 // NewRemogattoZ80 creates a new Z80 with full instruction coverage
 func NewRemogattoZ80() *RemogattoZ80 {
@@ -140,6 +295,8 @@ func NewRemogattoZ80() *RemogattoZ80 {
 	output := make([]byte, 0)
 	ports := NewPorts(&output)
 	cpu := z80.NewZ80(memory, ports)
+	memory.tstates = &cpu.Tstates
+	ports.tstates = &cpu.Tstates
 	
 	return &RemogattoZ80{
 		cpu:          cpu,
@@ -149,6 +306,7 @@ func NewRemogattoZ80() *RemogattoZ80 {
 		exitOnRST38:  true,
 		exitOnRET0:   true,
 		exitOnDIHalt: true,
+		cycleLimit:   DefaultCycleLimit,
 	}
 }
 
@@ -158,6 +316,9 @@ func (z *RemogattoZ80) Reset() {
 	z.cycles = 0
 	z.halted = false
 	z.output = z.output[:0]
+	if z.paging != nil {
+		z.paging.Reset()
+	}
 }
 
 // LoadMemory loads data into memory at the specified address
@@ -166,58 +327,133 @@ func (z *RemogattoZ80) LoadMemory(address uint16, data []byte) error {
 		if int(address)+i >= 65536 {
 			return fmt.Errorf("memory overflow at %04X", address+uint16(i))
 		}
-		z.memory.data[int(address)+i] = b
+		z.memory.poke(address+uint16(i), b)
 	}
 	return nil
 }
 
+// DefaultCycleLimit is how many T-states Run executes before giving up
+const DefaultCycleLimit = 10000000
+
+// SetCycleLimit sets how many T-states Run executes before it stops with
+// an error; 0 lets it run until the program ends
+func (z *RemogattoZ80) SetCycleLimit(limit int) {
+	z.cycleLimit = limit
+}
+
 // Run executes instructions until a termination condition
 func (z *RemogattoZ80) Run() error {
+	_, err := z.RunUntil(nil)
+	return err
+}
+
+// RunUntil runs like Run, but also stops before an instruction for which
+// stop returns true and reports whether that is why it stopped. The first
+// instruction is always executed, so resuming from a breakpoint progresses.
+func (z *RemogattoZ80) RunUntil(stop func(pc uint16) bool) (bool, error) {
+	first := true
 	for {
 		// Check if halted
 		if z.halted {
-			return nil
+			return false, nil
 		}
 		
 		// Get current PC for exit detection
 		pc := z.cpu.PC()
+		if stop != nil && !first && stop(pc) {
+			return true, nil
+		}
+		first = false
+		
+		// BDOS calls are served by the host
+		if z.cpm != nil && pc == BDOSEntry {
+			if err := z.bdos(); err != nil {
+				return false, err
+			}
+			continue
+		}
+		opcode := z.memory.data[pc]
+		
+		var entry TraceEntry
+		if z.tracer != nil {
+			entry = z.beginTrace(pc)
+		}
 		
 		// Execute one instruction
+		oldCycles := z.cpu.Tstates
 		z.cpu.DoOpcode()
-		z.cycles += int(z.cpu.Tstates)
+		if z.ula != nil {
+			z.ula.interrupt(z, opcode)
+		}
+		z.cycles += z.cpu.Tstates - oldCycles
+		
+		if z.tracer != nil {
+			z.endTrace(entry)
+		}
 		
 		// Check exit conditions
 		newPC := z.cpu.PC()
 		
+		if z.coverage != nil {
+			z.coverage.Record(pc, newPC, opcode)
+		}
+		
+		// RET back to the sentinel pushed by Call
+		if z.calling && newPC == CallSentinel && z.cpu.SP() == z.callSP {
+			z.returned = true
+			return false, nil
+		}
+		
 		// RST 38h exit convention
 		if z.exitOnRST38 && pc != newPC && z.memory.data[pc] == 0xFF {
 			z.exitCode = uint16(z.cpu.A)
-			return nil
+			return false, nil
 		}
 		
 		// RET to 0x0000 exit (ZX Spectrum)
 		if z.exitOnRET0 && newPC == 0x0000 && pc != 0x0000 {
 			z.exitCode = uint16(z.cpu.HL())
-			return nil
+			return false, nil
 		}
 		
 		// DI:HALT sequence
 		if z.exitOnDIHalt && z.cpu.Halted && z.cpu.IFF1 == 0 {
 			z.halted = true
-			return nil
+			return false, nil
 		}
 		
 		// Safety: limit execution
-		if z.cycles > 10000000 {
-			return fmt.Errorf("execution limit exceeded")
+		if z.cycleLimit > 0 && z.cycles > z.cycleLimit {
+			return false, fmt.Errorf("execution limit exceeded")
 		}
 	}
 }
 
 // Step executes a single instruction
 func (z *RemogattoZ80) Step() int {
+	pc := z.cpu.PC()
+	if z.cpm != nil && pc == BDOSEntry {
+		if err := z.bdos(); err != nil {
+			z.halted = true
+		}
+		return 0
+	}
+	opcode := z.memory.data[pc]
 	oldCycles := z.cpu.Tstates
+	var entry TraceEntry
+	if z.tracer != nil {
+		entry = z.beginTrace(pc)
+	}
 	z.cpu.DoOpcode()
+	if z.ula != nil {
+		z.ula.interrupt(z, opcode)
+	}
+	if z.tracer != nil {
+		z.endTrace(entry)
+	}
+	if z.coverage != nil {
+		z.coverage.Record(pc, z.cpu.PC(), opcode)
+	}
 	cyclesUsed := int(z.cpu.Tstates - oldCycles)
 	z.cycles += cyclesUsed
 	
@@ -286,7 +522,7 @@ func (z *RemogattoZ80) IsHalted() bool {
 
 // SetMemory sets a memory location
 func (z *RemogattoZ80) SetMemory(address uint16, value byte) {
-	z.memory.data[address] = value
+	z.memory.poke(address, value)
 }
 
 // GetMemory reads a memory location
@@ -299,6 +535,19 @@ func (z *RemogattoZ80) SetSMCTracker(tracker func(addr uint16, oldVal, newVal by
 	z.memory.smcTracker = tracker
 }
 
+// EnableCoverage starts recording executed addresses and returns the coverage map
+func (z *RemogattoZ80) EnableCoverage() *Coverage {
+	if z.coverage == nil {
+		z.coverage = NewCoverage()
+	}
+	return z.coverage
+}
+
+// GetCoverage returns the coverage map, or nil if coverage is not enabled
+func (z *RemogattoZ80) GetCoverage() *Coverage {
+	return z.coverage
+}
+
 // SetIOHandlers sets custom I/O handlers
 func (z *RemogattoZ80) SetIOHandlers(read func(port uint16) byte, write func(port uint16, value byte)) {
 	z.ports.ioRead = read
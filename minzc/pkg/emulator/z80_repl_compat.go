@@ -112,6 +112,17 @@ func (z *REPLCompatibleZ80) ExecuteWithHooks(pc uint16) ([]byte, int) {
 	return output, cycles
 }
 
+// CallWithHooks calls the routine at addr as Call does, with the screen
+// hooks active, and returns the output and T-states of this call alone
+func (z *REPLCompatibleZ80) CallWithHooks(addr uint16) ([]byte, int, error) {
+	z.syncRegistersToCPU()
+	outputStart, cyclesStart := len(z.RemogattoZ80.output), z.RemogattoZ80.cycles
+	z.RemogattoZ80.halted = false
+	err := z.RemogattoZ80.Call(addr)
+	z.syncRegistersFromCPU()
+	return z.RemogattoZ80.output[outputStart:], z.RemogattoZ80.cycles - cyclesStart, err
+}
+
 // Reset resets the CPU state
 func (z *REPLCompatibleZ80) Reset() {
 	z.RemogattoZ80WithScreen.Reset()
@@ -142,4 +153,19 @@ func (z *REPLCompatibleZ80) GetIFF2() bool {
 // GetIM returns interrupt mode
 func (z *REPLCompatibleZ80) GetIM() byte {
 	return z.RemogattoZ80.cpu.IM
-}
\ No newline at end of file
+}
+// SetIFF1 sets interrupt flip-flop 1
+func (z *REPLCompatibleZ80) SetIFF1(v bool) {
+	z.RemogattoZ80.cpu.IFF1 = 0
+	if v {
+		z.RemogattoZ80.cpu.IFF1 = 1
+	}
+}
+
+// SetIFF2 sets interrupt flip-flop 2
+func (z *REPLCompatibleZ80) SetIFF2(v bool) {
+	z.RemogattoZ80.cpu.IFF2 = 0
+	if v {
+		z.RemogattoZ80.cpu.IFF2 = 1
+	}
+}
@@ -192,7 +192,7 @@ func (interp *MIRInterpreter) executeInstruction(inst *ir.Instruction) error {
 	case ir.OpMul:
 		val1 := interp.registers[inst.Src1]
 		val2 := interp.registers[inst.Src2]
-		result := val1 * val2
+		result := ir.FixedMul(val1, val2, inst.Type)
 		interp.registers[inst.Dest] = result
 		interp.updateFlags(result)
 		
@@ -297,6 +297,23 @@ func (interp *MIRInterpreter) executeInstruction(inst *ir.Instruction) error {
 		shift := interp.registers[inst.Src2]
 		interp.registers[inst.Dest] = val >> uint(shift)
 		
+	case ir.OpRotl, ir.OpRotr:
+		width := uint(8)
+		if inst.Type != nil && inst.Type.Size() == 2 {
+			width = 16
+		}
+		mask := int64(1)<<width - 1
+		val := interp.registers[inst.Src1] & mask
+		n := uint(inst.Imm)
+		if inst.Src2 != 0 {
+			n = uint(interp.registers[inst.Src2])
+		}
+		n %= width
+		if inst.Op == ir.OpRotr {
+			n = (width - n) % width
+		}
+		interp.registers[inst.Dest] = (val<<n | val>>(width-n)) & mask
+		
 	// Memory operations (simulated)
 	case ir.OpLoad:
 		addr := interp.registers[inst.Src1]
@@ -85,6 +85,7 @@ const (
 	OpJumpIfNotZero
 	OpCall
 	OpCallIndirect  // Indirect function call through register
+	OpJumpIndirect  // Computed goto through register (no return)
 	OpReturn
 	
 	// Data movement
@@ -155,6 +156,8 @@ const (
 	OpNot
 	OpShl
 	OpShr
+	OpRotl // Rotate Src1 (u8 or u16 Type) left by Src2, or by Imm when Src2 is 0
+	OpRotr // Rotate Src1 (u8 or u16 Type) right by Src2, or by Imm when Src2 is 0
 	
 	// Logical (short-circuit evaluation)
 	OpLogicalAnd
@@ -186,6 +189,9 @@ const (
 	// Inline assembly
 	OpAsm
 	
+	// Source annotation (--annotate-source)
+	OpSourceMark    // Begin or end of a source construct; Comment holds the text, no code
+	
 	// Loop operations
 	OpLoadAddr       // Load address of variable/array
 	OpCopyToBuffer   // Copy memory to static buffer
@@ -208,6 +214,7 @@ const (
 	OpPrintU16      // Print u16 as decimal
 	OpPrintI8       // Print i8 as decimal 
 	OpPrintI16      // Print i16 as decimal
+	OpPrintFixed    // Print fixed-point value as decimal (Type gives the format)
 	OpPrintBool     // Print bool as "true"/"false"
 	OpPrintString   // Print null-terminated string
 	OpPrintStringDirect // Print short string directly (no loop)
@@ -215,6 +222,8 @@ const (
 	OpLen           // Get length of array/string
 	OpMemcpy        // Copy memory block
 	OpMemset        // Set memory block
+	OpFormat        // Write Src2 (of Type) as text at cursor Src1; Imm = verb, 0 writes Symbol; Dest = advanced cursor
+	OpStrCall       // Run string routine Symbol on Src1 and Src2, with capacity Args[0] for str_copy/str_cat; Dest = result
 	
 	// Metaprogramming
 	OpEmit          // @emit instruction for compile-time code generation
@@ -255,6 +264,7 @@ type Instruction struct {
 	Dest         Register
 	Src1         Register
 	Src2         Register
+	Src3         Register // Value stored by OpStoreIndex
 	Imm          int64
 	Imm2         int64  // Second immediate for some operations
 	Label        string
@@ -266,6 +276,8 @@ type Instruction struct {
 	SMCTarget    string            // Target label for SMC store operations
 	AsmCode      string            // Raw assembly code for OpAsm instructions
 	AsmName      string            // Optional name for named asm blocks
+	AsmOperands  []AsmOperand      // Values bound to {N} in AsmCode, outputs first
+	AsmClobbers  []string          // Registers an OpAsm declares it changes
 	LiteralData  []int64           // Literal data values for OpArrayLiteral
 	StructArrayData []StructLiteralData // Struct literal data for struct arrays
 	
@@ -275,6 +287,7 @@ type Instruction struct {
 	BasicBlockID int    // Which basic block this instruction belongs to
 	ProfileHint  string // PGO hints: "hot", "cold", "likely", "unlikely"
 	Args         []Register        // Argument registers for OpCall
+	TailCall     bool              // OpCall whose result is returned at once: may jump to the callee
 	Hint         RegisterHint      // Hint for register allocator
 	
 	// VM-specific fields
@@ -302,6 +315,16 @@ type AsmBlock struct {
 	Code string
 }
 
+// AsmOperand binds a {N} placeholder of inline assembly to a virtual
+// register. Constraint is the Z80 register that carries the value into or
+// out of the assembly ("A", "HL"...), or "r" for one the code generator picks.
+type AsmOperand struct {
+	Reg        Register
+	Constraint string
+	Output     bool
+	Type       Type
+}
+
 // Register represents a virtual register
 type Register int
 
@@ -390,6 +413,39 @@ func (t *BasicType) String() string {
 	}
 }
 
+// FracBits returns the number of fractional bits of a fixed-point type, or 0
+// for integer types. A fixed-point value is stored as value * 2^FracBits in
+// Size() bytes, little-endian like the integer of the same size.
+func (t *BasicType) FracBits() int {
+	switch t.Kind {
+	case TypeF8_8, TypeF_8, TypeF16_8:
+		return 8
+	case TypeF_16, TypeF8_16:
+		return 16
+	default:
+		return 0
+	}
+}
+
+// FixedFracBits returns the fractional bits of t if it is a fixed-point type, or 0
+func FixedFracBits(t Type) int {
+	if bt, ok := t.(*BasicType); ok {
+		return bt.FracBits()
+	}
+	return 0
+}
+
+// FixedMul evaluates a multiply of type t the way generated code does: a
+// fixed-point product is shifted right by the fractional bits and wraps to
+// the type's size. Other types multiply as integers.
+func FixedMul(a, b int64, t Type) int64 {
+	frac := FixedFracBits(t)
+	if frac == 0 {
+		return a * b
+	}
+	return (a * b >> frac) & (int64(1)<<(8*t.Size()) - 1)
+}
+
 // PointerType represents pointer types
 type PointerType struct {
 	Base      Type
@@ -476,14 +532,49 @@ func (t *IteratorType) String() string {
 type EnumType struct {
 	Name     string
 	Variants map[string]int
+	Backing  TypeKind          // TypeU8 or TypeU16; TypeVoid picks by variant count
+	Payloads map[string][]Type // Data carried by the variants that have any
 }
 
+// Size is that of the backing type, or for a tagged union (an enum whose
+// variants carry data) the tag followed by room for the largest payload
 func (t *EnumType) Size() int {
-	// Enums are represented as u8 or u16 depending on variant count
-	if len(t.Variants) <= 256 {
-		return 1
+	size := t.BackingType().Size()
+	largest := 0
+	for variant := range t.Payloads {
+		largest = max(largest, t.PayloadOffset(variant, len(t.Payloads[variant])))
+	}
+	return max(size, largest)
+}
+
+// HasPayloads reports whether the enum is a tagged union. Its values are
+// then addresses of the tag and payload rather than the tag itself.
+func (t *EnumType) HasPayloads() bool {
+	return len(t.Payloads) > 0
+}
+
+// PayloadOffset returns the offset of field i of a variant's payload from
+// the start of the value; the payload follows the tag
+func (t *EnumType) PayloadOffset(variant string, i int) int {
+	offset := t.BackingType().Size()
+	for _, field := range t.Payloads[variant][:i] {
+		offset += field.Size()
+	}
+	return offset
+}
+
+// BackingType is the integer type enum values are stored as: the @repr
+// type if given, else u8 when every variant fits in a byte
+func (t *EnumType) BackingType() *BasicType {
+	if t.Backing != TypeVoid {
+		return &BasicType{Kind: t.Backing}
 	}
-	return 2
+	for _, value := range t.Variants {
+		if value > 0xFF {
+			return &BasicType{Kind: TypeU16}
+		}
+	}
+	return &BasicType{Kind: TypeU8}
 }
 
 func (t *EnumType) String() string {
@@ -575,6 +666,8 @@ type Function struct {
 	NextReg      Register
 	NumParams    int
 	IsInterrupt  bool
+	InterruptMode int     // 2 for the handler installed by @interrupt(im2), else 0
+	IsExported   bool     // Declared with export, so callable from outside the program
 	NextRegister Register // Same as NextReg but more clearly named
 	IsSMCEnabled bool     // Whether self-modifying code is enabled for this function
 	IsRecursive  bool     // Whether this function is recursive
@@ -594,16 +687,43 @@ type Function struct {
 	
 	// Instruction Patching support
 	NeedsPatchPoints bool                 // Function will be called with instruction patching
+	InROM            bool                 // Code placed in ROM, so never patched (see optimizer.CheckSMCSafety)
 	
 	// Metadata for optimization passes
 	Metadata map[string]string // Generic metadata storage
 	CalleeSavedRegs  RegisterSet // Registers this function must preserve
 	MaxStackDepth    int         // Maximum stack depth for this function
+	FrameSize        int         // Bytes of locals after frame layout, 0 if not laid out
 	CallingConvention string     // ABI calling convention ("smc", "register", "stack", etc.)
 	
 	// Local function support
 	ParentFunction string                  // Name of parent function (if this is a local function)
 	CapturedVars   map[string]*CapturedVar // Variables captured from parent scope
+	
+	// Placement
+	Section string       // Named output section from @section("name"), empty for the main block
+	Hint    FunctionHint // Placement and optimization hint from @hot or @cold
+}
+
+// FunctionHint tells the backend how hot a function is
+type FunctionHint int
+
+const (
+	HintNone FunctionHint = iota
+	HintHot               // @hot: optimize for speed, place near the entry point
+	HintCold              // @cold: optimize for size, place after all other code
+)
+
+// String returns the attribute spelling of the hint
+func (h FunctionHint) String() string {
+	switch h {
+	case HintHot:
+		return "@hot"
+	case HintCold:
+		return "@cold"
+	default:
+		return ""
+	}
 }
 
 // Parameter represents a function parameter
@@ -619,7 +739,7 @@ type Local struct {
 	Name   string
 	Type   Type
 	Reg    Register
-	Offset int // Stack offset if spilled
+	Offset int // Frame offset, shared with locals never live at the same time
 }
 
 // CapturedVar represents a variable captured from a parent scope in a local function
@@ -656,6 +776,43 @@ func (f *Function) LastAllocatedReg() Register {
 	return f.NextReg - 1
 }
 
+// EndsWithReturn reports whether the last instruction, ignoring trailing
+// source marks, is a return
+func (f *Function) EndsWithReturn() bool {
+	for i := len(f.Instructions) - 1; i >= 0; i-- {
+		if f.Instructions[i].Op != OpSourceMark {
+			return f.Instructions[i].Op == OpReturn
+		}
+	}
+	return false
+}
+
+// SourceRun is a stretch of a function's instructions lowered from one
+// source line, Instructions[Start:End]
+type SourceRun struct {
+	File       string
+	Line       int // 0 for the instructions before the first source line
+	Start, End int
+}
+
+// SourceRuns splits the instructions into runs by source line. Instructions
+// without a line, such as those added by optimization passes, stay in the
+// run before them.
+func (f *Function) SourceRuns() []SourceRun {
+	var runs []SourceRun
+	for i, inst := range f.Instructions {
+		if len(runs) > 0 {
+			last := &runs[len(runs)-1]
+			if inst.SourceLine == 0 || inst.SourceLine == last.Line && inst.SourceFile == last.File {
+				last.End = i + 1
+				continue
+			}
+		}
+		runs = append(runs, SourceRun{File: inst.SourceFile, Line: inst.SourceLine, Start: i, End: i + 1})
+	}
+	return runs
+}
+
 // AddParam adds a parameter to the function
 func (f *Function) AddParam(name string, typ Type) Register {
 	reg := f.AllocReg()
@@ -759,6 +916,9 @@ type Global struct {
 	Init     interface{} // Initial value
 	Value    interface{} // AST expression for constants
 	Constant bool        // Whether this is a constant
+	Section  string      // Named output section from @section("name"), empty for the main block
+	Address  *uint16     // Fixed address from @at(addr); no storage is allocated
+	Align    int         // Start on a multiple of this many bytes, 0 for no alignment
 }
 
 // ConstExpr represents a constant expression for initialization
@@ -808,9 +968,14 @@ func (i *Instruction) String() string {
 	case OpJumpIfNot:
 		return fmt.Sprintf("jump_if_not r%d, %s", i.Src1, i.Label)
 	case OpCall:
+		if i.TailCall {
+			return fmt.Sprintf("r%d = tail call %s", i.Dest, i.Symbol)
+		}
 		return fmt.Sprintf("r%d = call %s", i.Dest, i.Symbol)
 	case OpCallIndirect:
 		return fmt.Sprintf("r%d = call_indirect r%d", i.Dest, i.Src1)
+	case OpJumpIndirect:
+		return fmt.Sprintf("jump_indirect r%d", i.Src1)
 	case OpReturn:
 		if i.Src1 != 0 {
 			return fmt.Sprintf("return r%d", i.Src1)
@@ -847,10 +1012,23 @@ func (i *Instruction) String() string {
 	case OpGe:
 		return fmt.Sprintf("r%d = r%d >= r%d", i.Dest, i.Src1, i.Src2)
 	case OpAsm:
+		operands := ""
+		for n, op := range i.AsmOperands {
+			dir := "in"
+			if op.Output {
+				dir = "out"
+			}
+			operands += fmt.Sprintf(" {%d}=%s(r%d:%s)", n, dir, op.Reg, op.Constraint)
+		}
+		if len(i.AsmClobbers) > 0 {
+			operands += fmt.Sprintf(" clobbers(%s)", strings.Join(i.AsmClobbers, ", "))
+		}
 		if i.AsmName != "" {
-			return fmt.Sprintf("asm %s { %s }", i.AsmName, i.AsmCode)
+			return fmt.Sprintf("asm %s { %s }%s", i.AsmName, i.AsmCode, operands)
 		}
-		return fmt.Sprintf("asm { %s }", i.AsmCode)
+		return fmt.Sprintf("asm { %s }%s", i.AsmCode, operands)
+	case OpSourceMark:
+		return fmt.Sprintf("--- %s ---", i.Comment)
 	case OpLoadAddr:
 		return fmt.Sprintf("r%d = addr(%s)", i.Dest, i.Symbol)
 	case OpCopyToBuffer:
@@ -873,6 +1051,10 @@ func (i *Instruction) String() string {
 		return fmt.Sprintf("r%d = *r%d", i.Dest, i.Src1)
 	case OpStore:
 		return fmt.Sprintf("*r%d = r%d", i.Src1, i.Src2)
+	case OpLoadIndex:
+		return fmt.Sprintf("r%d = r%d[r%d]", i.Dest, i.Src1, i.Src2)
+	case OpStoreIndex:
+		return fmt.Sprintf("r%d[r%d] = r%d", i.Src1, i.Src2, i.Src3)
 	case OpMod:
 		return fmt.Sprintf("r%d = r%d %% r%d", i.Dest, i.Src1, i.Src2)
 	case OpNeg:
@@ -893,6 +1075,15 @@ func (i *Instruction) String() string {
 		return fmt.Sprintf("r%d = r%d << r%d", i.Dest, i.Src1, i.Src2)
 	case OpShr:
 		return fmt.Sprintf("r%d = r%d >> r%d", i.Dest, i.Src1, i.Src2)
+	case OpRotl, OpRotr:
+		name := "rotl"
+		if i.Op == OpRotr {
+			name = "rotr"
+		}
+		if i.Src2 == 0 {
+			return fmt.Sprintf("r%d = %s(r%d, %d)", i.Dest, name, i.Src1, i.Imm)
+		}
+		return fmt.Sprintf("r%d = %s(r%d, r%d)", i.Dest, name, i.Src1, i.Src2)
 	case OpPrint:
 		return fmt.Sprintf("print(r%d)", i.Src1)
 	case OpPrintU8:
@@ -903,6 +1094,8 @@ func (i *Instruction) String() string {
 		return fmt.Sprintf("print_i8(r%d)", i.Src1)
 	case OpPrintI16:
 		return fmt.Sprintf("print_i16(r%d)", i.Src1)
+	case OpPrintFixed:
+		return fmt.Sprintf("print_fixed(r%d)", i.Src1)
 	case OpPrintBool:
 		return fmt.Sprintf("print_bool(r%d)", i.Src1)
 	case OpPrintString:
@@ -917,6 +1110,16 @@ func (i *Instruction) String() string {
 		return fmt.Sprintf("memcpy([r%d], [r%d], r%d)", i.Dest, i.Src1, i.Src2)
 	case OpMemset:
 		return fmt.Sprintf("memset([r%d], r%d, r%d)", i.Dest, i.Src1, i.Src2)
+	case OpFormat:
+		if i.Imm == 0 {
+			return fmt.Sprintf("r%d = format(r%d, %q)", i.Dest, i.Src1, i.Symbol)
+		}
+		return fmt.Sprintf("r%d = format(r%d, %%%c, r%d)", i.Dest, i.Src1, rune(i.Imm), i.Src2)
+	case OpStrCall:
+		if len(i.Args) > 0 {
+			return fmt.Sprintf("r%d = %s(r%d, r%d, r%d)", i.Dest, i.Symbol, i.Src1, i.Src2, i.Args[0])
+		}
+		return fmt.Sprintf("r%d = %s(r%d, r%d)", i.Dest, i.Symbol, i.Src1, i.Src2)
 	case OpLoadField:
 		return fmt.Sprintf("r%d = r%d.field[%d]", i.Dest, i.Src1, i.Imm)
 	case OpStoreField:
@@ -925,8 +1128,18 @@ func (i *Instruction) String() string {
 		return fmt.Sprintf("r%d = &r%d", i.Dest, i.Src1)
 	case OpLoadLabel:
 		return fmt.Sprintf("r%d = label %s", i.Dest, i.Symbol)
+	case OpMove:
+		return fmt.Sprintf("r%d = r%d", i.Dest, i.Src1)
+	case OpTrueSMCLoad:
+		return fmt.Sprintf("r%d = smc_load %s", i.Dest, i.Symbol)
+	case OpPatchTemplate:
+		return fmt.Sprintf("patch_template %s, %s", i.PatchPointLabel, i.TemplateName)
+	case OpPatchTarget:
+		return fmt.Sprintf("patch_target %s, %s", i.PatchPointLabel, i.TargetAddress)
+	case OpPatchParam:
+		return fmt.Sprintf("patch_param %s.%s = %d", i.Symbol, i.ParamName, i.Imm)
 	default:
-		return fmt.Sprintf("unknown op %d", i.Op)
+		return strings.ToLower(i.Op.String())
 	}
 }
 
@@ -1034,6 +1247,7 @@ func (op Opcode) String() string {
 	case OpJumpIfNotZero: return "JUMP_IF_NOT_ZERO"
 	case OpCall: return "CALL"
 	case OpCallIndirect: return "CALL_INDIRECT"
+	case OpJumpIndirect: return "JUMP_INDIRECT"
 	case OpReturn: return "RETURN"
 	case OpLoadConst: return "LOAD_CONST"
 	case OpLoadVar: return "LOAD_VAR"
@@ -1063,6 +1277,8 @@ func (op Opcode) String() string {
 	case OpNot: return "NOT"
 	case OpShl: return "SHL"
 	case OpShr: return "SHR"
+	case OpRotl: return "ROTL"
+	case OpRotr: return "ROTR"
 	case OpCmp: return "CMP"
 	case OpTest: return "TEST"
 	case OpEq: return "EQ"
@@ -1081,6 +1297,7 @@ func (op Opcode) String() string {
 	case OpPush: return "PUSH"
 	case OpPop: return "POP"
 	case OpAsm: return "ASM"
+	case OpSourceMark: return "SOURCE_MARK"
 	case OpLoadAddr: return "LOAD_ADDR"
 	case OpCopyToBuffer: return "COPY_TO_BUFFER"
 	case OpCopyFromBuffer: return "COPY_FROM_BUFFER"
@@ -1100,10 +1317,13 @@ func (op Opcode) String() string {
 	case OpPrintU16: return "PRINT_U16"
 	case OpPrintI8: return "PRINT_I8"
 	case OpPrintI16: return "PRINT_I16"
+	case OpPrintFixed: return "PRINT_FIXED"
 	case OpPrintBool: return "PRINT_BOOL"
 	case OpPrintString: return "PRINT_STRING"
 	case OpPrintStringDirect: return "PRINT_STRING_DIRECT"
 	case OpLoadString: return "LOAD_STRING"
+	case OpFormat: return "FORMAT"
+	case OpStrCall: return "STR_CALL"
 	case OpSMCLoadConst: return "SMC_LOAD_CONST"
 	case OpSMCStoreConst: return "SMC_STORE_CONST"
 	case OpSMCParam: return "SMC_PARAM"
@@ -0,0 +1,220 @@
+package ir
+
+// Liveness records which virtual registers are still needed after each
+// instruction of a function. Backends use it to keep a value in a CPU
+// register instead of spilling it when nothing reads it later.
+type Liveness struct {
+	liveOut []map[Register]bool // Registers read after each instruction
+	pinned  map[Register]bool   // Parameter and local registers, always live
+}
+
+// definingOps are the opcodes known to only write Dest. Any other opcode
+// may read Dest too (OpArrayElement, OpCopyFromBuffer...), so it is
+// counted as a read and never ends the register's live range.
+var definingOps = map[Opcode]bool{
+	OpLoadConst: true, OpLoadVar: true, OpLoadParam: true, OpLoadField: true,
+	OpLoadIndex: true, OpLoadElement: true, OpLoadBitField: true, OpMove: true,
+	OpLoadLabel: true, OpLoadDirect: true, OpLoadString: true, OpLoadAddr: true,
+	OpLoadPtr: true, OpLoad: true, OpAddr: true, OpLen: true, OpCall: true,
+	OpAdd: true, OpSub: true, OpMul: true, OpDiv: true, OpMod: true,
+	OpNeg: true, OpInc: true, OpDec: true,
+	OpAnd: true, OpOr: true, OpXor: true, OpNot: true, OpShl: true, OpShr: true,
+	OpEq: true, OpNe: true, OpLt: true, OpGt: true, OpLe: true, OpGe: true,
+}
+
+// Reads returns the virtual registers an instruction reads
+func (i *Instruction) Reads() []Register {
+	var regs []Register
+	add := func(r Register) {
+		if r > 0 {
+			regs = append(regs, r)
+		}
+	}
+	add(i.Src1)
+	add(i.Src2)
+	add(i.Src3)
+	for _, r := range i.Args {
+		add(r)
+	}
+	for _, op := range i.AsmOperands {
+		if !op.Output {
+			add(op.Reg)
+		}
+	}
+	if !definingOps[i.Op] {
+		add(i.Dest)
+	}
+	return regs
+}
+
+// Writes returns the virtual register an instruction defines, or 0
+func (i *Instruction) Writes() Register {
+	if definingOps[i.Op] && i.Dest > 0 {
+		return i.Dest
+	}
+	return 0
+}
+
+// IsBranch reports whether an instruction may jump elsewhere
+func (i *Instruction) IsBranch() bool {
+	switch i.Op {
+	case OpJump, OpJumpIf, OpJumpIfNot, OpJumpIfZero, OpJumpIfNotZero,
+		OpJumpIndirect, OpReturn, OpDJNZ, OpJmp, OpJmpIf, OpJmpIfNot:
+		return true
+	}
+	return false
+}
+
+// branchTarget returns the label a branch jumps to
+func (i *Instruction) branchTarget() string {
+	if i.Label != "" {
+		return i.Label
+	}
+	return i.Symbol
+}
+
+// AnalyzeLiveness computes the live registers after every instruction of
+// fn, over its basic blocks and the jumps between them
+func AnalyzeLiveness(fn *Function) *Liveness {
+	n := len(fn.Instructions)
+	l := &Liveness{
+		liveOut: make([]map[Register]bool, n),
+		pinned:  make(map[Register]bool),
+	}
+	for _, p := range fn.Params {
+		l.pinned[p.Reg] = true
+	}
+	for _, local := range fn.Locals {
+		l.pinned[local.Reg] = true
+	}
+	if n == 0 {
+		return l
+	}
+
+	// Split into basic blocks: they start at labels and after branches
+	var starts []int
+	blockOf := make([]int, n)
+	labels := make(map[string]int)
+	for i := range fn.Instructions {
+		inst := &fn.Instructions[i]
+		if i == 0 || inst.Op == OpLabel || fn.Instructions[i-1].IsBranch() {
+			starts = append(starts, i)
+		}
+		blockOf[i] = len(starts) - 1
+		if inst.Op == OpLabel {
+			labels[inst.Label] = blockOf[i]
+		}
+	}
+	end := func(b int) int {
+		if b+1 < len(starts) {
+			return starts[b+1]
+		}
+		return n
+	}
+
+	// Successors of each block
+	succs := make([][]int, len(starts))
+	for b := range starts {
+		last := &fn.Instructions[end(b)-1]
+		switch last.Op {
+		case OpReturn:
+		case OpJumpIndirect:
+			// Any label may be the target
+			for _, target := range labels {
+				succs[b] = append(succs[b], target)
+			}
+		default:
+			if last.IsBranch() {
+				if target, ok := labels[last.branchTarget()]; ok {
+					succs[b] = append(succs[b], target)
+				} else {
+					for _, target := range labels {
+						succs[b] = append(succs[b], target)
+					}
+				}
+			}
+			if last.Op != OpJump && last.Op != OpJmp && b+1 < len(starts) {
+				succs[b] = append(succs[b], b+1)
+			}
+		}
+	}
+
+	// Iterate live-in sets to a fixed point, walking blocks backwards
+	liveIn := make([]map[Register]bool, len(starts))
+	for b := range liveIn {
+		liveIn[b] = make(map[Register]bool)
+	}
+	blockLiveOut := func(b int) map[Register]bool {
+		out := make(map[Register]bool)
+		for _, s := range succs[b] {
+			for r := range liveIn[s] {
+				out[r] = true
+			}
+		}
+		return out
+	}
+	for changed := true; changed; {
+		changed = false
+		for b := len(starts) - 1; b >= 0; b-- {
+			live := blockLiveOut(b)
+			for i := end(b) - 1; i >= starts[b]; i-- {
+				l.step(&fn.Instructions[i], live)
+			}
+			if len(live) != len(liveIn[b]) {
+				liveIn[b] = live
+				changed = true
+			}
+		}
+	}
+
+	// Record the live-out set of every instruction
+	for b := range starts {
+		live := blockLiveOut(b)
+		for i := end(b) - 1; i >= starts[b]; i-- {
+			out := make(map[Register]bool, len(live))
+			for r := range live {
+				out[r] = true
+			}
+			l.liveOut[i] = out
+			l.step(&fn.Instructions[i], live)
+		}
+	}
+	return l
+}
+
+// step turns the live set after inst into the live set before it
+func (l *Liveness) step(inst *Instruction, live map[Register]bool) {
+	if w := inst.Writes(); w != 0 {
+		delete(live, w)
+	}
+	for _, op := range inst.AsmOperands {
+		if op.Output {
+			delete(live, op.Reg)
+		}
+	}
+	for _, r := range inst.Reads() {
+		live[r] = true
+	}
+}
+
+// LiveAfter reports whether reg may be read after instruction i
+func (l *Liveness) LiveAfter(i int, reg Register) bool {
+	if l.pinned[reg] {
+		return true
+	}
+	if i < 0 || i >= len(l.liveOut) {
+		return false
+	}
+	return l.liveOut[i][reg]
+}
+
+// MaxPressure returns the largest number of registers live at once
+func (l *Liveness) MaxPressure() int {
+	max := 0
+	for _, live := range l.liveOut {
+		if len(live) > max {
+			max = len(live)
+		}
+	}
+	return max
+}
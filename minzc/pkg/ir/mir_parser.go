@@ -312,6 +312,7 @@ func (p *mirParser) parseAssignment(line string) (Instruction, error) {
 		// Parse source
 		src := strings.TrimSpace(parts[1])
 		inst.Src1 = Register(p.parseRegister(src))
+		inst.Size = 1 // Default to byte, as loads do
 		return inst, nil
 	}
 	
@@ -444,6 +445,10 @@ func (p *mirParser) parseOpcode(s string) Opcode {
 		return OpShl
 	case "shr":
 		return OpShr
+	case "rotl":
+		return OpRotl
+	case "rotr":
+		return OpRotr
 	case "not":
 		return OpNot
 	case "neg":
@@ -0,0 +1,814 @@
+package mir
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// The binary MIR format stores a module with everything the optimizer and
+// backends use, which the text format drops: comments, source lines, SMC
+// anchors and parameter offsets, patch points, metadata and initializers.
+// A file is
+//
+//	magic "MINZMIR\x00", version
+//	module
+//
+// Integers are varints and strings are length-prefixed. Each record (a
+// function, instruction, global...) starts with a mask of its non-zero
+// fields and holds only those, so an instruction costs just the fields its
+// opcode uses. Opcodes are written by name the first time they appear and
+// by index after that, so files survive renumbering of the opcodes.
+//
+// The AST of a global initializer that is not a constant (Global.Value)
+// is not stored.
+
+// MIR binary file identification
+const (
+	MIRBinaryMagic   = "MINZMIR\x00"
+	MIRBinaryVersion = 1
+)
+
+// maxCount bounds the length of any list in a file, so a corrupt count
+// fails instead of allocating without limit
+const maxCount = 1 << 24
+
+// Type tags
+const (
+	typeBasic = iota + 1
+	typePointer
+	typeArray
+	typeLambda
+	typeStruct
+	typeIterator
+	typeEnum
+	typeString
+	typeLString
+	typeBitStruct
+	typeFunction
+)
+
+// Global initializer tags
+const (
+	initInt64 = iota + 1
+	initInt
+	initBool
+	initBytes
+	initStrings
+)
+
+// IsMIRBinary reports whether data starts with the binary MIR magic
+func IsMIRBinary(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(MIRBinaryMagic))
+}
+
+// WriteMIRBinary writes a module in the binary MIR format
+func WriteMIRBinary(w io.Writer, module *ir.Module) error {
+	c := &codec{w: bufio.NewWriter(w), opIndex: make(map[ir.Opcode]uint64)}
+	c.w.WriteString(MIRBinaryMagic)
+	version := uint64(MIRBinaryVersion)
+	c.uvarint(&version)
+	c.module(module)
+	if c.err != nil {
+		return c.err
+	}
+	return c.w.Flush()
+}
+
+// ReadMIRBinary reads a module written by WriteMIRBinary
+func ReadMIRBinary(r io.Reader) (*ir.Module, error) {
+	c := &codec{r: bufio.NewReader(r)}
+	magic := make([]byte, len(MIRBinaryMagic))
+	if _, err := io.ReadFull(c.r, magic); err != nil || string(magic) != MIRBinaryMagic {
+		return nil, fmt.Errorf("not a binary MIR file")
+	}
+	var version uint64
+	c.uvarint(&version)
+	if c.err == nil && version != MIRBinaryVersion {
+		return nil, fmt.Errorf("unsupported binary MIR version %d", version)
+	}
+	module := &ir.Module{}
+	c.module(module)
+	if c.err != nil {
+		return nil, fmt.Errorf("corrupt binary MIR: %w", c.err)
+	}
+	return module, nil
+}
+
+// LoadMIRFile reads a .mir file in either the text or the binary format
+func LoadMIRFile(filename string) (*ir.Module, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if IsMIRBinary(data) {
+		return ReadMIRBinary(bytes.NewReader(data))
+	}
+	return ParseMIRFile(filename)
+}
+
+// codec writes or reads the binary format. The same code describes each
+// record in both directions: writing, the field methods write the value
+// they are given; reading, they store into it.
+type codec struct {
+	w   *bufio.Writer
+	r   *bufio.Reader
+	err error
+
+	// Opcodes met so far, by index in the file
+	ops     []ir.Opcode
+	opIndex map[ir.Opcode]uint64
+
+	// The record being coded. Writing, its fields are visited twice: once
+	// measuring the mask of non-zero fields, then writing those.
+	mask      uint64
+	bit       uint
+	measuring bool
+}
+
+func (c *codec) writing() bool { return c.w != nil }
+
+func (c *codec) fail(err error) {
+	if c.err == nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		c.err = err
+	}
+}
+
+// record codes a record whose fields body visits in order
+func (c *codec) record(body func()) {
+	mask, bit, measuring := c.mask, c.bit, c.measuring
+	defer func() { c.mask, c.bit, c.measuring = mask, bit, measuring }()
+
+	if c.writing() {
+		c.mask, c.bit, c.measuring = 0, 0, true
+		body()
+		c.bit, c.measuring = 0, false
+		c.uvarint(&c.mask)
+	} else {
+		c.uvarint(&c.mask)
+		c.bit = 0
+	}
+	if c.err == nil {
+		body()
+	}
+}
+
+// field reports whether the record's next field is in the file; set says
+// whether it is non-zero when writing
+func (c *codec) field(set bool) bool {
+	if c.bit == 64 {
+		panic("mir: record with more than 64 fields")
+	}
+	bit := uint64(1) << c.bit
+	c.bit++
+	if c.measuring {
+		if set {
+			c.mask |= bit
+		}
+		return false
+	}
+	return c.mask&bit != 0
+}
+
+// Raw values, always present
+
+func (c *codec) uvarint(p *uint64) {
+	if c.err != nil || c.measuring {
+		return
+	}
+	if c.writing() {
+		c.w.Write(binary.AppendUvarint(nil, *p))
+		return
+	}
+	v, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	*p = v
+}
+
+func (c *codec) varint(p *int64) {
+	if c.err != nil || c.measuring {
+		return
+	}
+	if c.writing() {
+		c.w.Write(binary.AppendVarint(nil, *p))
+		return
+	}
+	v, err := binary.ReadVarint(c.r)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	*p = v
+}
+
+// intValue codes an int as a varint
+func (c *codec) intValue(p *int) {
+	v := int64(*p)
+	c.varint(&v)
+	*p = int(v)
+}
+
+// count codes the length of a list
+func (c *codec) count(n int) int {
+	v := uint64(n)
+	c.uvarint(&v)
+	if v > maxCount {
+		c.fail(fmt.Errorf("list of %d entries", v))
+		return 0
+	}
+	return int(v)
+}
+
+func (c *codec) bytes(p *[]byte) {
+	n := c.count(len(*p))
+	if c.err != nil || c.measuring {
+		return
+	}
+	if c.writing() {
+		c.w.Write(*p)
+		return
+	}
+	*p = make([]byte, n)
+	if _, err := io.ReadFull(c.r, *p); err != nil {
+		c.fail(err)
+	}
+}
+
+func (c *codec) string(p *string) {
+	b := []byte(*p)
+	c.bytes(&b)
+	*p = string(b)
+}
+
+func (c *codec) bool(p *bool) {
+	v := uint64(0)
+	if *p {
+		v = 1
+	}
+	c.uvarint(&v)
+	*p = v != 0
+}
+
+// opcode codes an opcode by name on its first appearance, then by index
+func (c *codec) opcode(p *ir.Opcode) {
+	if c.writing() {
+		if c.measuring {
+			return
+		}
+		index, seen := c.opIndex[*p]
+		if !seen {
+			index = uint64(len(c.ops))
+			c.opIndex[*p] = index
+			c.ops = append(c.ops, *p)
+		}
+		c.uvarint(&index)
+		if !seen {
+			name := p.String()
+			c.string(&name)
+		}
+		return
+	}
+
+	var index uint64
+	c.uvarint(&index)
+	switch {
+	case c.err != nil:
+	case index < uint64(len(c.ops)):
+		*p = c.ops[index]
+	case index == uint64(len(c.ops)):
+		var name string
+		c.string(&name)
+		op, ok := opcodesByName()[name]
+		if !ok && c.err == nil {
+			c.fail(fmt.Errorf("unknown opcode %s", name))
+		}
+		c.ops = append(c.ops, op)
+		*p = op
+	default:
+		c.fail(fmt.Errorf("opcode index %d out of range", index))
+	}
+}
+
+// opcodesByName maps the names Opcode.String gives back to opcodes
+func opcodesByName() map[string]ir.Opcode {
+	names := make(map[string]ir.Opcode)
+	for op := 0; op < 256; op++ {
+		names[ir.Opcode(op).String()] = ir.Opcode(op)
+	}
+	return names
+}
+
+// kind codes a basic type kind by name
+func (c *codec) kind(p *ir.TypeKind) {
+	name := (&ir.BasicType{Kind: *p}).String()
+	c.string(&name)
+	if c.writing() || c.err != nil {
+		return
+	}
+	for k := ir.TypeVoid; ; k++ {
+		switch (&ir.BasicType{Kind: k}).String() {
+		case name:
+			*p = k
+			return
+		case "unknown":
+			c.fail(fmt.Errorf("unknown type kind %s", name))
+			return
+		}
+	}
+}
+
+// Record fields, present when non-zero. Each call takes the record's next
+// field, so values inside a field's list or map are coded with the raw
+// methods above.
+
+type integer interface {
+	~int | ~int64 | ~uint8 | ~uint16 | ~uint32
+}
+
+func intField[T integer](c *codec, p *T) {
+	if c.field(*p != 0) {
+		v := int64(*p)
+		c.varint(&v)
+		*p = T(v)
+	}
+}
+
+func (c *codec) stringField(p *string) {
+	if c.field(*p != "") {
+		c.string(p)
+	}
+}
+
+func (c *codec) flagField(p *bool) {
+	if c.field(*p) && !c.writing() {
+		*p = true
+	}
+}
+
+func (c *codec) typeField(p *ir.Type) {
+	if c.field(*p != nil) {
+		c.typ(p)
+	}
+}
+
+// listField codes a non-empty list
+func listField[V any](c *codec, p *[]V, elem func(*V)) {
+	if c.field(len(*p) > 0) {
+		list(c, p, elem)
+	}
+}
+
+// mapField codes a non-empty map, in key order
+func mapField[V any](c *codec, p *map[string]V, value func(*V)) {
+	if !c.field(len(*p) > 0) {
+		return
+	}
+	n := c.count(len(*p))
+	if c.writing() {
+		keys := make([]string, 0, n)
+		for k := range *p {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v := (*p)[k]
+			c.string(&k)
+			value(&v)
+		}
+		return
+	}
+	*p = make(map[string]V, n)
+	for i := 0; i < n && c.err == nil; i++ {
+		var k string
+		var v V
+		c.string(&k)
+		value(&v)
+		(*p)[k] = v
+	}
+}
+
+// list codes a list whose length is always present
+func list[V any](c *codec, p *[]V, elem func(*V)) {
+	n := c.count(len(*p))
+	if !c.writing() {
+		if c.err != nil || n == 0 {
+			return
+		}
+		*p = make([]V, n)
+	}
+	for i := range *p {
+		if c.err != nil {
+			return
+		}
+		elem(&(*p)[i])
+	}
+}
+
+// pointer codes the record a non-nil pointer holds
+func pointer[V any](p **V, body func(*V)) {
+	if *p == nil {
+		*p = new(V)
+	}
+	body(*p)
+}
+
+// Types
+
+func (c *codec) typ(p *ir.Type) {
+	var tag uint64
+	if c.writing() {
+		tag = typeTag(*p)
+		if tag == 0 {
+			c.fail(fmt.Errorf("cannot store type %s (%T)", *p, *p))
+			return
+		}
+	}
+	c.uvarint(&tag)
+	if c.err != nil {
+		return
+	}
+	if !c.writing() {
+		*p = newType(tag)
+		if *p == nil {
+			c.fail(fmt.Errorf("unknown type tag %d", tag))
+			return
+		}
+	}
+
+	switch t := (*p).(type) {
+	case *ir.BasicType:
+		c.kind(&t.Kind)
+	case *ir.PointerType:
+		c.typ(&t.Base)
+		c.bool(&t.IsMutable)
+	case *ir.ArrayType:
+		c.typ(&t.Element)
+		n := int64(t.Length)
+		c.varint(&n)
+		t.Length = int(n)
+	case *ir.LambdaType:
+		list(c, &t.ParamTypes, c.typ)
+		c.typ(&t.ReturnType)
+	case *ir.StructType:
+		c.string(&t.Name)
+		list(c, &t.FieldOrder, c.string)
+		if !c.writing() {
+			t.Fields = make(map[string]ir.Type, len(t.FieldOrder))
+		}
+		for _, name := range t.FieldOrder {
+			field := t.Fields[name]
+			c.typ(&field)
+			t.Fields[name] = field
+		}
+	case *ir.IteratorType:
+		c.typ(&t.ElementType)
+	case *ir.EnumType:
+		c.string(&t.Name)
+		c.record(func() {
+			mapField(c, &t.Variants, c.intValue)
+			mapField(c, &t.Payloads, func(fields *[]ir.Type) { list(c, fields, c.typ) })
+		})
+		c.kind(&t.Backing)
+	case *ir.StringType:
+		c.record(func() { intField(c, &t.MaxLength) })
+	case *ir.LStringType:
+		c.record(func() { intField(c, &t.MaxLength) })
+	case *ir.BitStructType:
+		c.typ(&t.UnderlyingType)
+		list(c, &t.FieldOrder, c.string)
+		if !c.writing() {
+			t.Fields = make(map[string]*ir.BitField, len(t.FieldOrder))
+		}
+		for _, name := range t.FieldOrder {
+			field := t.Fields[name]
+			pointer(&field, func(f *ir.BitField) {
+				c.record(func() {
+					c.stringField(&f.Name)
+					intField(c, &f.BitOffset)
+					intField(c, &f.BitWidth)
+				})
+			})
+			t.Fields[name] = field
+		}
+	case *ir.FunctionType:
+		list(c, &t.Params, c.typ)
+		c.typ(&t.Return)
+	}
+}
+
+func typeTag(t ir.Type) uint64 {
+	switch t.(type) {
+	case *ir.BasicType:
+		return typeBasic
+	case *ir.PointerType:
+		return typePointer
+	case *ir.ArrayType:
+		return typeArray
+	case *ir.LambdaType:
+		return typeLambda
+	case *ir.StructType:
+		return typeStruct
+	case *ir.IteratorType:
+		return typeIterator
+	case *ir.EnumType:
+		return typeEnum
+	case *ir.StringType:
+		return typeString
+	case *ir.LStringType:
+		return typeLString
+	case *ir.BitStructType:
+		return typeBitStruct
+	case *ir.FunctionType:
+		return typeFunction
+	}
+	return 0
+}
+
+func newType(tag uint64) ir.Type {
+	switch tag {
+	case typeBasic:
+		return &ir.BasicType{}
+	case typePointer:
+		return &ir.PointerType{}
+	case typeArray:
+		return &ir.ArrayType{}
+	case typeLambda:
+		return &ir.LambdaType{}
+	case typeStruct:
+		return &ir.StructType{}
+	case typeIterator:
+		return &ir.IteratorType{}
+	case typeEnum:
+		return &ir.EnumType{}
+	case typeString:
+		return &ir.StringType{}
+	case typeLString:
+		return &ir.LStringType{}
+	case typeBitStruct:
+		return &ir.BitStructType{}
+	case typeFunction:
+		return &ir.FunctionType{}
+	}
+	return nil
+}
+
+// Module records
+
+func (c *codec) module(m *ir.Module) {
+	c.record(func() {
+		c.stringField(&m.Name)
+		listField(c, &m.Globals, c.global)
+		listField(c, &m.Strings, func(s **ir.String) {
+			pointer(s, func(s *ir.String) {
+				c.record(func() {
+					c.stringField(&s.Label)
+					c.stringField(&s.Value)
+					c.flagField(&s.IsLong)
+				})
+			})
+		})
+		listField(c, &m.PatchTable, func(e *ir.PatchEntry) {
+			c.record(func() {
+				c.stringField(&e.Symbol)
+				intField(c, &e.Address)
+				intField(c, &e.Size)
+				intField(c, &e.Bank)
+				c.stringField(&e.ParamTag)
+				c.stringField(&e.Function)
+			})
+		})
+		listField(c, &m.Functions, func(fn **ir.Function) { pointer(fn, c.function) })
+	})
+}
+
+func (c *codec) global(g *ir.Global) {
+	c.record(func() {
+		c.stringField(&g.Name)
+		c.typeField(&g.Type)
+		if c.field(g.Init != nil) {
+			c.initializer(g)
+		}
+		c.flagField(&g.Constant)
+		c.stringField(&g.Section)
+		if c.field(g.Address != nil) {
+			pointer(&g.Address, func(addr *uint16) {
+				v := int64(*addr)
+				c.varint(&v)
+				*addr = uint16(v)
+			})
+		}
+		intField(c, &g.Align)
+	})
+}
+
+// initializer codes the constant value a global starts with
+func (c *codec) initializer(g *ir.Global) {
+	var tag uint64
+	if c.writing() {
+		switch g.Init.(type) {
+		case int64:
+			tag = initInt64
+		case int:
+			tag = initInt
+		case bool:
+			tag = initBool
+		case []byte:
+			tag = initBytes
+		case []string:
+			tag = initStrings
+		default:
+			c.fail(fmt.Errorf("cannot store the initializer of %s (%T)", g.Name, g.Init))
+			return
+		}
+	}
+	c.uvarint(&tag)
+	if c.err != nil {
+		return
+	}
+
+	switch tag {
+	case initInt64:
+		v, _ := g.Init.(int64)
+		c.varint(&v)
+		g.Init = v
+	case initInt:
+		v, _ := g.Init.(int)
+		n := int64(v)
+		c.varint(&n)
+		g.Init = int(n)
+	case initBool:
+		v, _ := g.Init.(bool)
+		c.bool(&v)
+		g.Init = v
+	case initBytes:
+		v, _ := g.Init.([]byte)
+		c.bytes(&v)
+		g.Init = v
+	case initStrings:
+		v, _ := g.Init.([]string)
+		list(c, &v, c.string)
+		g.Init = v
+	default:
+		c.fail(fmt.Errorf("unknown initializer tag %d", tag))
+	}
+}
+
+func (c *codec) function(fn *ir.Function) {
+	c.record(func() {
+		c.stringField(&fn.Name)
+		listField(c, &fn.Params, func(p *ir.Parameter) {
+			c.record(func() {
+				c.stringField(&p.Name)
+				c.typeField(&p.Type)
+				intField(c, &p.Reg)
+				c.flagField(&p.IsTSMCRef)
+			})
+		})
+		c.typeField(&fn.ReturnType)
+		c.typeField(&fn.ErrorType)
+		listField(c, &fn.Locals, func(l *ir.Local) {
+			c.record(func() {
+				c.stringField(&l.Name)
+				c.typeField(&l.Type)
+				intField(c, &l.Reg)
+				intField(c, &l.Offset)
+			})
+		})
+		listField(c, &fn.Instructions, c.instruction)
+		intField(c, &fn.NextReg)
+		intField(c, &fn.NumParams)
+		c.flagField(&fn.IsInterrupt)
+		intField(c, &fn.NextRegister)
+		c.flagField(&fn.IsSMCEnabled)
+		c.flagField(&fn.IsRecursive)
+		mapField(c, &fn.SMCLocations, c.intValue)
+		c.flagField(&fn.IsSMCDefault)
+		mapField(c, &fn.SMCParamOffsets, c.intValue)
+		c.flagField(&fn.RequiresContext)
+		c.flagField(&fn.HasTailRecursion)
+		intField(c, &fn.UsedRegisters)
+		intField(c, &fn.ModifiedRegisters)
+		c.flagField(&fn.UsesTrueSMC)
+		mapField(c, &fn.SMCAnchors, func(a **ir.SMCAnchorInfo) {
+			pointer(a, func(a *ir.SMCAnchorInfo) {
+				c.record(func() {
+					c.stringField(&a.Symbol)
+					intField(c, &a.Address)
+					intField(c, &a.Size)
+					if c.field(a.Instruction != 0) {
+						c.opcode(&a.Instruction)
+					}
+				})
+			})
+		})
+		c.flagField(&fn.NeedsPatchPoints)
+		mapField(c, &fn.Metadata, c.string)
+		intField(c, &fn.CalleeSavedRegs)
+		intField(c, &fn.MaxStackDepth)
+		c.stringField(&fn.CallingConvention)
+		c.stringField(&fn.ParentFunction)
+		mapField(c, &fn.CapturedVars, func(v **ir.CapturedVar) {
+			pointer(v, func(v *ir.CapturedVar) {
+				c.record(func() {
+					c.stringField(&v.Name)
+					c.typeField(&v.Type)
+					intField(c, &v.ParentReg)
+					intField(c, &v.LocalReg)
+					c.stringField(&v.CaptureMode)
+				})
+			})
+		})
+		c.stringField(&fn.Section)
+		intField(c, &fn.Hint)
+		c.flagField(&fn.IsExported)
+		intField(c, &fn.InterruptMode)
+	})
+
+	// Passes add to this map without checking it exists
+	if fn.SMCParamOffsets == nil {
+		fn.SMCParamOffsets = make(map[string]int)
+	}
+}
+
+func (c *codec) instruction(inst *ir.Instruction) {
+	c.opcode(&inst.Op)
+	c.record(func() {
+		intField(c, &inst.Dest)
+		intField(c, &inst.Src1)
+		intField(c, &inst.Src2)
+		intField(c, &inst.Src3)
+		intField(c, &inst.Imm)
+		intField(c, &inst.Imm2)
+		c.stringField(&inst.Label)
+		c.stringField(&inst.Symbol)
+		c.typeField(&inst.Type)
+		c.stringField(&inst.Comment)
+		mapField(c, &inst.PhysicalRegs, c.string)
+		c.stringField(&inst.SMCLabel)
+		c.stringField(&inst.SMCTarget)
+		c.stringField(&inst.AsmCode)
+		c.stringField(&inst.AsmName)
+		listField(c, &inst.LiteralData, c.varint)
+		listField(c, &inst.StructArrayData, func(d *ir.StructLiteralData) {
+			c.string(&d.TypeName)
+			c.record(func() { mapField(c, &d.Fields, c.varint) })
+		})
+		intField(c, &inst.SourceLine)
+		c.stringField(&inst.SourceFile)
+		intField(c, &inst.BasicBlockID)
+		c.stringField(&inst.ProfileHint)
+		listField(c, &inst.Args, func(r *ir.Register) {
+			v := int64(*r)
+			c.varint(&v)
+			*r = ir.Register(v)
+		})
+		intField(c, &inst.Hint)
+		intField(c, &inst.Value)
+		intField(c, &inst.Target)
+		c.stringField(&inst.FuncName)
+		intField(c, &inst.Offset)
+		intField(c, &inst.Size)
+		c.stringField(&inst.PatchPointLabel)
+		c.stringField(&inst.TemplateName)
+		c.stringField(&inst.TargetAddress)
+		c.stringField(&inst.ParamName)
+		c.stringField(&inst.StringValue)
+		intField(c, &inst.StringID)
+		if c.field(inst.PatchPoint != nil) {
+			pointer(&inst.PatchPoint, c.patchPoint)
+		}
+	})
+}
+
+func (c *codec) patchPoint(p *ir.PatchPoint) {
+	c.record(func() {
+		c.stringField(&p.Label)
+		intField(c, &p.Size)
+		mapField(c, &p.Templates, func(t **ir.PatchTemplate) {
+			pointer(t, func(t *ir.PatchTemplate) {
+				c.record(func() {
+					c.stringField(&t.Name)
+					if c.field(len(t.Instructions) > 0) {
+						c.bytes(&t.Instructions)
+					}
+					intField(c, &t.Size)
+					c.stringField(&t.Description)
+				})
+			})
+		})
+		c.stringField(&p.Default)
+	})
+}
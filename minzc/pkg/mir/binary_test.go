@@ -0,0 +1,152 @@
+package mir
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+func TestMIRBinaryRoundTrip(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	u16 := &ir.BasicType{Kind: ir.TypeU16}
+	point := &ir.StructType{
+		Name:       "Point",
+		Fields:     map[string]ir.Type{"x": u8, "y": u16},
+		FieldOrder: []string{"x", "y"},
+	}
+	flags := &ir.BitStructType{
+		UnderlyingType: u8,
+		Fields:         map[string]*ir.BitField{"ready": {Name: "ready", BitOffset: 0, BitWidth: 1}},
+		FieldOrder:     []string{"ready"},
+	}
+	addr := uint16(0xC000)
+
+	module := ir.NewModule("test")
+	module.Globals = []ir.Global{
+		{Name: "count", Type: u16, Init: int64(-3)},
+		{Name: "limit", Type: u8, Init: 10, Constant: true},
+		{Name: "ready", Type: &ir.BasicType{Kind: ir.TypeBool}, Init: true},
+		{Name: "table", Type: &ir.ArrayType{Element: u8, Length: 3}, Init: []byte{1, 2, 3}, Section: "bank1", Address: &addr, Align: 256},
+		{Name: "names", Type: &ir.ArrayType{Element: &ir.PointerType{Base: u8}, Length: 2}, Init: []string{"a", "b"}},
+		{Name: "origin", Type: point},
+		{Name: "status", Type: flags},
+		{Name: "color", Type: &ir.EnumType{Name: "Color", Variants: map[string]int{"Red": 0, "Blue": 1}, Backing: ir.TypeU8}},
+		{Name: "shape", Type: &ir.EnumType{Name: "Shape", Variants: map[string]int{"Empty": 0, "Rect": 1}, Backing: ir.TypeU8, Payloads: map[string][]ir.Type{"Rect": {u8, u16}}}},
+		{Name: "greet", Type: &ir.LambdaType{ParamTypes: []ir.Type{u8}, ReturnType: &ir.StringType{MaxLength: 32}}},
+	}
+	module.Strings = []*ir.String{{Label: "str_0", Value: "Hello\n"}, {Label: "str_1", Value: "long", IsLong: true}}
+	module.PatchTable = []ir.PatchEntry{{Symbol: "add_x_op", Address: 0x8003, Size: 1, ParamTag: "x", Function: "add"}}
+
+	add := ir.NewFunction("add", u8)
+	add.Params = []ir.Parameter{{Name: "x", Type: u8, Reg: 1}, {Name: "p", Type: &ir.PointerType{Base: point, IsMutable: true}, Reg: 2, IsTSMCRef: true}}
+	add.Locals = []ir.Local{{Name: "t", Type: u16, Reg: 3, Offset: -2}}
+	add.NextReg = 5
+	add.NumParams = 2
+	add.IsSMCEnabled = true
+	add.IsSMCDefault = false
+	add.UsesTrueSMC = true
+	add.SMCParamOffsets["x"] = 1
+	add.SMCAnchors = map[string]*ir.SMCAnchorInfo{"x": {Symbol: "add_x_op", Address: 3, Size: 1, Instruction: ir.OpLoadConst}}
+	add.Metadata = map[string]string{"inline": "never"}
+	add.UsedRegisters = ir.RegisterSet(ir.Z80_A | ir.Z80_HL)
+	add.CallingConvention = "smc"
+	add.Section = "bank1"
+	add.Instructions = []ir.Instruction{
+		{Op: ir.OpLoadParam, Dest: 1, Symbol: "x", Type: u8, Comment: "load x", SourceLine: 3, SourceFile: "add.minz"},
+		{Op: ir.OpLoadConst, Dest: 4, Imm: -1, Comment: "SMC anchor", SMCLabel: "add_x_op"},
+		{Op: ir.OpStoreIndex, Src1: 2, Src2: 3, Src3: 4, Type: u16},
+		{Op: ir.OpAsm, AsmCode: "  NOP\n", AsmName: "pad", PhysicalRegs: map[string]string{"r1": "A"}},
+		{Op: ir.OpCall, Dest: 4, Symbol: "print", Args: []ir.Register{1, 4}},
+		{Op: ir.OpReturn, Src1: 4, LiteralData: []int64{1, -2}, StructArrayData: []ir.StructLiteralData{{TypeName: "Point", Fields: map[string]int64{"x": 1}}}},
+		{Op: ir.OpLoadConst, Dest: 4, PatchPoint: &ir.PatchPoint{
+			Label:     "pp",
+			Size:      2,
+			Templates: map[string]*ir.PatchTemplate{"nop": {Name: "nop", Instructions: []byte{0, 0}, Size: 2}},
+			Default:   "nop",
+		}},
+	}
+	main := ir.NewFunction("main", &ir.BasicType{Kind: ir.TypeVoid})
+	main.IsInterrupt = true
+	main.IsExported = true
+	main.Instructions = []ir.Instruction{{Op: ir.OpCall, Symbol: "add"}, {Op: ir.OpReturn}}
+	module.Functions = []*ir.Function{add, main}
+
+	var buf bytes.Buffer
+	if err := WriteMIRBinary(&buf, module); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if !IsMIRBinary(buf.Bytes()) {
+		t.Fatal("output does not start with the MIR magic")
+	}
+	got, err := ReadMIRBinary(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !reflect.DeepEqual(got, module) {
+		t.Errorf("round trip changed the module:\ngot  %+v\nwant %+v", got.Functions[0], module.Functions[0])
+	}
+
+	// Map keys are written in order, so the output is reproducible
+	var again bytes.Buffer
+	if err := WriteMIRBinary(&again, got); err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	if !bytes.Equal(again.Bytes(), buf.Bytes()) {
+		t.Error("writing the read module gave different bytes")
+	}
+
+	// Truncated and foreign files are errors
+	for i := len(MIRBinaryMagic) + 1; i < buf.Len(); i += 7 {
+		if _, err := ReadMIRBinary(bytes.NewReader(buf.Bytes()[:i])); err == nil {
+			t.Fatalf("no error reading %d of %d bytes", i, buf.Len())
+		}
+	}
+	if _, err := ReadMIRBinary(strings.NewReader("; MIR text")); err == nil {
+		t.Error("no error reading a text file")
+	}
+	future := append([]byte(MIRBinaryMagic), 99)
+	if _, err := ReadMIRBinary(bytes.NewReader(future)); err == nil || !strings.Contains(err.Error(), "version 99") {
+		t.Errorf("future version: err = %v", err)
+	}
+}
+
+func TestLoadMIRFile(t *testing.T) {
+	module := ir.NewModule("test")
+	fn := ir.NewFunction("main", &ir.BasicType{Kind: ir.TypeVoid})
+	fn.Instructions = []ir.Instruction{{Op: ir.OpReturn, Comment: "done"}}
+	module.Functions = []*ir.Function{fn}
+
+	dir := t.TempDir()
+	binaryFile := filepath.Join(dir, "binary.mir")
+	var buf bytes.Buffer
+	if err := WriteMIRBinary(&buf, module); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(binaryFile, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	textFile := filepath.Join(dir, "text.mir")
+	text := "; MinZ Intermediate Representation (MIR)\n; Module: test\n\nFunction main() -> void\n  Instructions:\n      0: return\n"
+	if err := os.WriteFile(textFile, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{binaryFile, textFile} {
+		got, err := LoadMIRFile(file)
+		if err != nil {
+			t.Fatalf("%s: %v", filepath.Base(file), err)
+		}
+		if len(got.Functions) != 1 || got.Functions[0].Name != "main" {
+			t.Errorf("%s: functions = %v", filepath.Base(file), got.Functions)
+		}
+	}
+	got, _ := LoadMIRFile(binaryFile)
+	if got.Functions[0].Instructions[0].Comment != "done" {
+		t.Error("binary file lost the instruction comment")
+	}
+}
@@ -0,0 +1,168 @@
+package mir
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// CallEdge is one caller -> callee edge of a call graph
+type CallEdge struct {
+	Caller   string
+	Callee   string
+	Indirect bool // Through OpCallIndirect, resolved from the function address loaded
+}
+
+// CallGraph is the inter-function call graph of a module
+type CallGraph struct {
+	Functions  []*ir.Function
+	Edges      []CallEdge
+	External   []string        // Callees that are not functions of the module (runtime routines)
+	Unresolved map[string]int  // Indirect calls whose target is not known, per caller
+	recursive  map[string]bool // Functions on a call cycle
+}
+
+// BuildCallGraph collects the calls of every function in module. Indirect
+// calls are resolved when the called register holds a function address
+// loaded in the same function; the rest are counted in Unresolved.
+func BuildCallGraph(module *ir.Module) *CallGraph {
+	g := &CallGraph{
+		Functions:  module.Functions,
+		Unresolved: make(map[string]int),
+	}
+	known := make(map[string]bool)
+	for _, fn := range module.Functions {
+		known[fn.Name] = true
+	}
+
+	external := make(map[string]bool)
+	for _, fn := range module.Functions {
+		seen := make(map[CallEdge]bool)
+		addresses := make(map[ir.Register]string) // Registers holding a function address
+		add := func(edge CallEdge) {
+			if seen[edge] {
+				return
+			}
+			seen[edge] = true
+			g.Edges = append(g.Edges, edge)
+			if !known[edge.Callee] && !external[edge.Callee] {
+				external[edge.Callee] = true
+				g.External = append(g.External, edge.Callee)
+			}
+		}
+
+		for _, inst := range fn.Instructions {
+			switch inst.Op {
+			case ir.OpLoadLabel, ir.OpLoadAddr:
+				if known[inst.Symbol] {
+					addresses[inst.Dest] = inst.Symbol
+				} else {
+					delete(addresses, inst.Dest)
+				}
+			case ir.OpMove:
+				if name, ok := addresses[inst.Src1]; ok {
+					addresses[inst.Dest] = name
+				} else {
+					delete(addresses, inst.Dest)
+				}
+			case ir.OpCall:
+				if inst.Symbol != "" {
+					add(CallEdge{Caller: fn.Name, Callee: inst.Symbol})
+				}
+			case ir.OpCallIndirect:
+				if name, ok := addresses[inst.Src1]; ok {
+					add(CallEdge{Caller: fn.Name, Callee: name, Indirect: true})
+				} else {
+					g.Unresolved[fn.Name]++
+				}
+			default:
+				if inst.Dest != 0 {
+					delete(addresses, inst.Dest)
+				}
+			}
+		}
+	}
+	sort.Strings(g.External)
+
+	g.findRecursion()
+	return g
+}
+
+// findRecursion marks every function that can reach itself through calls,
+// directly or through other functions
+func (g *CallGraph) findRecursion() {
+	callees := make(map[string][]string)
+	for _, e := range g.Edges {
+		callees[e.Caller] = append(callees[e.Caller], e.Callee)
+	}
+
+	g.recursive = make(map[string]bool)
+	for _, fn := range g.Functions {
+		visited := make(map[string]bool)
+		stack := append([]string(nil), callees[fn.Name]...)
+		for len(stack) > 0 {
+			name := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if name == fn.Name {
+				g.recursive[fn.Name] = true
+				break
+			}
+			if visited[name] {
+				continue
+			}
+			visited[name] = true
+			stack = append(stack, callees[name]...)
+		}
+	}
+}
+
+// IsRecursive reports whether the function can call itself, directly or
+// through other functions
+func (g *CallGraph) IsRecursive(name string) bool {
+	return g.recursive[name]
+}
+
+// WriteDOT writes the call graph in Graphviz DOT format. Recursive
+// functions are drawn red, SMC functions as double octagons, runtime
+// routines dashed and indirect calls as dashed edges.
+func (g *CallGraph) WriteDOT(w io.Writer) error {
+	v := &Visualizer{writer: w}
+
+	v.emit("digraph MinZ_CallGraph {")
+	v.emit("  rankdir=LR;")
+	v.emit("  node [shape=box, style=rounded];")
+	v.emit("")
+
+	for _, fn := range g.Functions {
+		shape, color := "box", "lightyellow"
+		if fn.IsSMCEnabled {
+			shape = "doubleoctagon"
+		}
+		label := fn.Name
+		if g.recursive[fn.Name] {
+			color = "lightcoral"
+			label += "\\n(recursive)"
+		}
+		if n := g.Unresolved[fn.Name]; n > 0 {
+			label += fmt.Sprintf("\\n+%d indirect", n)
+		}
+		v.emit("  \"%s\" [label=\"%s\", shape=%s, style=filled, fillcolor=%s];", fn.Name, label, shape, color)
+	}
+	for _, name := range g.External {
+		v.emit("  \"%s\" [style=dashed, color=gray];", name)
+	}
+	v.emit("")
+
+	for _, e := range g.Edges {
+		if e.Indirect {
+			v.emit("  \"%s\" -> \"%s\" [style=dashed];", e.Caller, e.Callee)
+		} else {
+			v.emit("  \"%s\" -> \"%s\";", e.Caller, e.Callee)
+		}
+	}
+	v.emit("}")
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package mir
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+func TestCallGraph(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	fn := func(name string, smc bool, insts ...ir.Instruction) *ir.Function {
+		f := ir.NewFunction(name, u8)
+		f.IsSMCEnabled = smc
+		f.Instructions = append(insts, ir.Instruction{Op: ir.OpReturn})
+		return f
+	}
+
+	module := ir.NewModule("test")
+	module.Functions = []*ir.Function{
+		fn("main", false,
+			ir.Instruction{Op: ir.OpCall, Dest: 1, Symbol: "fact"},
+			ir.Instruction{Op: ir.OpLoadLabel, Dest: 2, Symbol: "double"},
+			ir.Instruction{Op: ir.OpMove, Dest: 3, Src1: 2},
+			ir.Instruction{Op: ir.OpCallIndirect, Dest: 4, Src1: 3},
+			ir.Instruction{Op: ir.OpCall, Symbol: "print_u8_decimal", Args: []ir.Register{4}},
+		),
+		fn("fact", true,
+			ir.Instruction{Op: ir.OpCall, Dest: 1, Symbol: "fact"},
+			ir.Instruction{Op: ir.OpCall, Dest: 2, Symbol: "fact"},
+		),
+		fn("double", false,
+			ir.Instruction{Op: ir.OpLoadVar, Dest: 1, Symbol: "callback"},
+			ir.Instruction{Op: ir.OpCallIndirect, Dest: 2, Src1: 1},
+		),
+		fn("unused", false),
+	}
+
+	graph := BuildCallGraph(module)
+	if !graph.IsRecursive("fact") || graph.IsRecursive("main") || graph.IsRecursive("double") {
+		t.Errorf("recursive: fact=%v main=%v double=%v, want only fact",
+			graph.IsRecursive("fact"), graph.IsRecursive("main"), graph.IsRecursive("double"))
+	}
+
+	var out strings.Builder
+	if err := graph.WriteDOT(&out); err != nil {
+		t.Fatal(err)
+	}
+	dot := out.String()
+	for _, want := range []string{
+		`"fact" -> "fact";`,
+		`"main" -> "fact";`,
+		`"main" -> "double" [style=dashed];`,
+		`"main" -> "print_u8_decimal";`,
+		`"print_u8_decimal" [style=dashed, color=gray];`,
+		`"fact" [label="fact\n(recursive)", shape=doubleoctagon, style=filled, fillcolor=lightcoral];`,
+		`"double" [label="double\n+1 indirect", shape=box`,
+		`"unused" [label="unused", shape=box`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("call graph is missing %s:\n%s", want, dot)
+		}
+	}
+	// Repeated calls are drawn once, and nothing calls the unused function
+	if n := strings.Count(dot, `"fact" -> "fact"`); n != 1 {
+		t.Errorf("fact -> fact drawn %d times, want once", n)
+	}
+	if strings.Contains(dot, `-> "unused"`) {
+		t.Errorf("unexpected edge into unused:\n%s", dot)
+	}
+}
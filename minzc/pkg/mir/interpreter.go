@@ -130,7 +130,7 @@ func (i *Interpreter) executeInstruction(inst *ir.Instruction) error {
 		i.updateFlags(result)
 		
 	case ir.OpMul:
-		result := i.registers[inst.Src1] * i.registers[inst.Src2]
+		result := ir.FixedMul(i.registers[inst.Src1], i.registers[inst.Src2], inst.Type)
 		i.registers[inst.Dest] = result
 		i.updateFlags(result)
 		
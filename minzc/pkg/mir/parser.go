@@ -188,6 +188,11 @@ func (p *mirParser) parseFunctionAttribute() {
 		p.currentFunc.IsRecursive = true
 	case "interrupt":
 		p.currentFunc.IsInterrupt = true
+	case "interrupt(im2)":
+		p.currentFunc.IsInterrupt = true
+		p.currentFunc.InterruptMode = 2
+	case "export":
+		p.currentFunc.IsExported = true
 	}
 }
 
@@ -332,6 +337,13 @@ func (p *mirParser) parseInstruction(instStr string) (*ir.Instruction, error) {
 			inst.Label = parts[1]
 		}
 		
+	case "jump_indirect":
+		inst.Op = ir.OpJumpIndirect
+		if len(parts) > 1 && strings.HasPrefix(parts[1], "r") {
+			regNum, _ := strconv.Atoi(parts[1][1:])
+			inst.Src1 = ir.Register(regNum)
+		}
+		
 	case "jump_if_not":
 		inst.Op = ir.OpJumpIfNot
 		if len(parts) > 2 {
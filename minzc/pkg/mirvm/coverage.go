@@ -0,0 +1,215 @@
+package mirvm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// Coverage records how often each MIR instruction of each function
+// executed, and which way each conditional branch went. It is the MIR-level
+// counterpart of the emulator's per-address coverage, used to check that
+// tests exercise every path before trusting the generated code.
+type Coverage struct {
+	hits map[string]*functionHits // Function name -> its counts
+}
+
+// functionHits counts, per instruction of a function, the executions and
+// the times a conditional branch jumped or fell through
+type functionHits struct {
+	count    []int
+	taken    []int
+	notTaken []int
+}
+
+// FunctionCoverage summarizes the coverage of one function
+type FunctionCoverage struct {
+	Name      string
+	Executed  int
+	Total     int
+	Uncovered []int // Indices of instructions that never executed
+
+	// Conditional branches have two outcomes each: jumped and fell through
+	Outcomes        int
+	OutcomesCovered int
+}
+
+// Percent returns the executed share of the function's instructions
+func (f FunctionCoverage) Percent() float64 {
+	if f.Total == 0 {
+		return 100
+	}
+	return float64(f.Executed) * 100 / float64(f.Total)
+}
+
+// BranchPercent returns the share of branch outcomes that happened
+func (f FunctionCoverage) BranchPercent() float64 {
+	if f.Outcomes == 0 {
+		return 100
+	}
+	return float64(f.OutcomesCovered) * 100 / float64(f.Outcomes)
+}
+
+// NewCoverage creates an empty coverage map
+func NewCoverage() *Coverage {
+	return &Coverage{hits: make(map[string]*functionHits)}
+}
+
+// EnableCoverage starts recording instruction coverage and returns the map
+func (vm *VM) EnableCoverage() *Coverage {
+	vm.coverage = NewCoverage()
+	return vm.coverage
+}
+
+// functionHits returns the counts of fn, creating them on first use
+func (c *Coverage) functionHits(fn *ir.Function) *functionHits {
+	hits, ok := c.hits[fn.Name]
+	if !ok {
+		n := len(fn.Instructions)
+		hits = &functionHits{count: make([]int, n), taken: make([]int, n), notTaken: make([]int, n)}
+		c.hits[fn.Name] = hits
+	}
+	return hits
+}
+
+// Mark records that instruction pc of fn executed
+func (c *Coverage) Mark(fn *ir.Function, pc int) {
+	if hits := c.functionHits(fn); pc < len(hits.count) {
+		hits.count[pc]++
+	}
+}
+
+// MarkBranch records which way the conditional branch at pc of fn went
+func (c *Coverage) MarkBranch(fn *ir.Function, pc int, taken bool) {
+	hits := c.functionHits(fn)
+	if pc >= len(hits.count) {
+		return
+	}
+	if taken {
+		hits.taken[pc]++
+	} else {
+		hits.notTaken[pc]++
+	}
+}
+
+// isConditionalBranch reports whether inst jumps or falls through
+// depending on a register
+func isConditionalBranch(inst ir.Instruction) bool {
+	return inst.Op == ir.OpJmpIf || inst.Op == ir.OpJmpIfNot
+}
+
+// Function returns the coverage of fn. A function that was never called
+// has every instruction uncovered.
+func (c *Coverage) Function(fn *ir.Function) FunctionCoverage {
+	hits := c.hits[fn.Name]
+	if hits == nil {
+		hits = &functionHits{}
+	}
+	result := FunctionCoverage{Name: fn.Name, Total: len(fn.Instructions)}
+	for i, inst := range fn.Instructions {
+		if i < len(hits.count) && hits.count[i] > 0 {
+			result.Executed++
+		} else {
+			result.Uncovered = append(result.Uncovered, i)
+		}
+		if isConditionalBranch(inst) {
+			result.Outcomes += 2
+			if i < len(hits.count) && hits.taken[i] > 0 {
+				result.OutcomesCovered++
+			}
+			if i < len(hits.count) && hits.notTaken[i] > 0 {
+				result.OutcomesCovered++
+			}
+		}
+	}
+	return result
+}
+
+// WriteReport writes per-function coverage for the module's functions,
+// listing the indices of the instructions that never executed
+func (c *Coverage) WriteReport(w io.Writer, module *ir.Module) error {
+	overall, functions := c.module(module)
+	fmt.Fprintf(w, "MinZ MIR Coverage Report\n")
+	fmt.Fprintf(w, "========================\n")
+	fmt.Fprintf(w, "Executed: %d/%d instructions (%.1f%%)\n", overall.Executed, overall.Total, overall.Percent())
+	fmt.Fprintf(w, "Branches: %d/%d outcomes (%.1f%%)\n\n", overall.OutcomesCovered, overall.Outcomes, overall.BranchPercent())
+
+	for _, f := range functions {
+		line := fmt.Sprintf("%-24s %4d/%-4d %5.1f%%", f.Name, f.Executed, f.Total, f.Percent())
+		if len(f.Uncovered) > 0 {
+			indices := make([]string, len(f.Uncovered))
+			for i, pc := range f.Uncovered {
+				indices[i] = fmt.Sprintf("%d", pc)
+			}
+			line += "  uncovered: " + strings.Join(indices, ", ")
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// module returns the coverage of each function of module and their total
+func (c *Coverage) module(module *ir.Module) (FunctionCoverage, []FunctionCoverage) {
+	var overall FunctionCoverage
+	functions := make([]FunctionCoverage, 0, len(module.Functions))
+	for _, fn := range module.Functions {
+		f := c.Function(fn)
+		overall.Executed += f.Executed
+		overall.Total += f.Total
+		overall.Outcomes += f.Outcomes
+		overall.OutcomesCovered += f.OutcomesCovered
+		functions = append(functions, f)
+	}
+	return overall, functions
+}
+
+// WriteAnnotated writes the module's MIR with each instruction prefixed by
+// the times it executed, ##### when it never did, and each conditional
+// branch followed by how often it jumped and fell through. Lines flagged
+// with ! are branches that only ever went one way.
+//
+//	; fact: 9/10 instructions, 3/4 branch outcomes
+//	Function fact
+//	!      5 |    2: jmpif r3 6  ; jumped 0, fell through 5
+//	   ##### |    5: r1 = 0
+func (c *Coverage) WriteAnnotated(w io.Writer, module *ir.Module) error {
+	overall, functions := c.module(module)
+	fmt.Fprintf(w, "; MinZ MIR Coverage\n")
+	fmt.Fprintf(w, "; Module: %s\n", module.Name)
+	fmt.Fprintf(w, "; Executed: %d/%d instructions (%.1f%%), %d/%d branch outcomes (%.1f%%)\n",
+		overall.Executed, overall.Total, overall.Percent(),
+		overall.OutcomesCovered, overall.Outcomes, overall.BranchPercent())
+
+	for i, fn := range module.Functions {
+		f := functions[i]
+		hits := c.hits[fn.Name]
+		fmt.Fprintf(w, "\n; %s: %d/%d instructions, %d/%d branch outcomes\n",
+			fn.Name, f.Executed, f.Total, f.OutcomesCovered, f.Outcomes)
+		fmt.Fprintf(w, "Function %s\n", fn.Name)
+		for pc, inst := range fn.Instructions {
+			count, taken, notTaken := 0, 0, 0
+			if hits != nil && pc < len(hits.count) {
+				count, taken, notTaken = hits.count[pc], hits.taken[pc], hits.notTaken[pc]
+			}
+			counter := "#####"
+			if count > 0 {
+				counter = fmt.Sprintf("%d", count)
+			}
+			line := fmt.Sprintf("%8s |  %3d: %s", counter, pc, formatInstruction(inst))
+			if isConditionalBranch(inst) && count > 0 {
+				line += fmt.Sprintf("  ; jumped %d, fell through %d", taken, notTaken)
+				if taken == 0 || notTaken == 0 {
+					line = "!" + line[1:]
+				}
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
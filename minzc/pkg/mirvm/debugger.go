@@ -0,0 +1,343 @@
+package mirvm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The VM stops before an instruction with a breakpoint, after one that
+// changed a watched memory location, and while stepping. Without a console
+// it reports the stop and runs on; with one (see EnableInteractive) it
+// reads debugger commands until one resumes it.
+
+// ErrQuit is returned by Run when the program is abandoned from the
+// interactive debugger
+var ErrQuit = errors.New("quit from debugger")
+
+// Watchpoint is a memory location whose changes stop the VM
+type Watchpoint struct {
+	Addr  int64
+	Size  int   // 1, 2, 4 or 8 bytes, little-endian
+	value int64 // Value after the last instruction
+}
+
+// Step modes of the debugger
+const (
+	runFree  = iota // Stop at breakpoints and watchpoints only
+	stepInto        // Stop before the next instruction
+	stepOver        // Stop before the next instruction of this call depth or less
+)
+
+// console is the interactive debugger's input
+type console struct {
+	in    *bufio.Scanner
+	mode  int
+	depth int // Call depth for stepOver
+}
+
+// EnableInteractive makes the VM stop at its first instruction and at
+// every breakpoint and watchpoint, and read debugger commands from in
+func (vm *VM) EnableInteractive(in io.Reader) {
+	vm.console = &console{in: bufio.NewScanner(in), mode: stepInto}
+}
+
+// AddWatchpoint stops the VM whenever the size bytes at addr change
+func (vm *VM) AddWatchpoint(addr int64, size int) error {
+	switch size {
+	case 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("invalid watchpoint size %d (1, 2, 4 or 8)", size)
+	}
+	if addr < 0 || addr+int64(size) > int64(len(vm.memory)) {
+		return fmt.Errorf("watchpoint address 0x%04X is outside memory", addr)
+	}
+	w := Watchpoint{Addr: addr, Size: size}
+	w.value = vm.peek(addr, size)
+	vm.watchpoints = append(vm.watchpoints, w)
+	return nil
+}
+
+// peek reads memory without going through devices or bounds checks, so
+// the debugger has no side effects. Out of range bytes read as 0.
+func (vm *VM) peek(addr int64, size int) int64 {
+	var value int64
+	for i := 0; i < size; i++ {
+		if a := addr + int64(i); a >= 0 && a < int64(len(vm.memory)) {
+			value |= int64(vm.memory[a]) << (i * 8)
+		}
+	}
+	return value
+}
+
+// checkWatchpoints reports the watched locations the instruction at fn:pc
+// changed, and remembers their new values
+func (vm *VM) checkWatchpoints(fn string, pc int) string {
+	var changes []string
+	for i := range vm.watchpoints {
+		w := &vm.watchpoints[i]
+		value := vm.peek(w.Addr, w.Size)
+		if value == w.value {
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("Watchpoint 0x%04X: %d -> %d (by %s:%d)", w.Addr, w.value, value, fn, pc))
+		w.value = value
+	}
+	return strings.Join(changes, "\n")
+}
+
+// stopReason reports why the VM stops before the current instruction, or
+// "" if it runs on
+func (vm *VM) stopReason() string {
+	if vm.watchHit != "" {
+		return vm.watchHit
+	}
+	if vm.checkBreakpoint() {
+		return fmt.Sprintf("Breakpoint hit at %s:%d", vm.currentFunc.Name, vm.pc)
+	}
+	if vm.console == nil {
+		return ""
+	}
+	switch vm.console.mode {
+	case stepInto:
+		return "step"
+	case stepOver:
+		if len(vm.callStack) <= vm.console.depth {
+			return "step"
+		}
+	}
+	return ""
+}
+
+// handleStop reports a stop and, with a console, reads commands until one
+// resumes execution
+func (vm *VM) handleStop(reason string) error {
+	out := vm.config.OutputStream
+	if reason != "step" {
+		fmt.Fprintf(out, "\n%s\n", reason)
+	}
+	vm.showNext()
+	if vm.console == nil {
+		return nil
+	}
+
+	for {
+		fmt.Fprint(out, "(mzv) ")
+		if !vm.console.in.Scan() {
+			fmt.Fprintln(out)
+			return ErrQuit
+		}
+		args := strings.Fields(vm.console.in.Text())
+		if len(args) == 0 {
+			continue
+		}
+		resume, err := vm.debugCommand(args[0], args[1:])
+		if err != nil {
+			return err
+		}
+		if resume {
+			return nil
+		}
+	}
+}
+
+// showNext prints the instruction about to run
+func (vm *VM) showNext() {
+	if vm.pc < len(vm.currentFunc.Instructions) {
+		fmt.Fprintf(vm.config.OutputStream, "  Next: [%s:%d] %s\n",
+			vm.currentFunc.Name, vm.pc, formatInstruction(vm.currentFunc.Instructions[vm.pc]))
+	} else {
+		fmt.Fprintf(vm.config.OutputStream, "  Next: [%s:%d] (end of function)\n", vm.currentFunc.Name, vm.pc)
+	}
+}
+
+// debugCommand runs one debugger command and reports whether it resumes
+// execution
+func (vm *VM) debugCommand(cmd string, args []string) (bool, error) {
+	out := vm.config.OutputStream
+	switch cmd {
+	case "s", "step":
+		vm.console.mode = stepInto
+		return true, nil
+	case "n", "next":
+		vm.console.mode, vm.console.depth = stepOver, len(vm.callStack)
+		return true, nil
+	case "c", "continue":
+		vm.console.mode = runFree
+		return true, nil
+	case "q", "quit":
+		return false, ErrQuit
+
+	case "b", "break":
+		fn, pc, err := vm.parseLocation(args)
+		if err != nil {
+			fmt.Fprintln(out, err)
+			break
+		}
+		if vm.config.Breakpoints == nil {
+			vm.config.Breakpoints = make(map[string][]int)
+		}
+		vm.config.Breakpoints[fn] = append(vm.config.Breakpoints[fn], pc)
+		fmt.Fprintf(out, "Breakpoint at %s:%d\n", fn, pc)
+	case "d", "delete":
+		fn, pc, err := vm.parseLocation(args)
+		if err != nil {
+			fmt.Fprintln(out, err)
+			break
+		}
+		bps := vm.config.Breakpoints[fn]
+		for i, bp := range bps {
+			if bp == pc {
+				vm.config.Breakpoints[fn] = append(bps[:i], bps[i+1:]...)
+				fmt.Fprintf(out, "Deleted breakpoint at %s:%d\n", fn, pc)
+				return false, nil
+			}
+		}
+		fmt.Fprintf(out, "No breakpoint at %s:%d\n", fn, pc)
+	case "w", "watch":
+		if len(args) == 0 {
+			fmt.Fprintln(out, "Usage: watch <addr> [size]")
+			break
+		}
+		addr, err := strconv.ParseInt(args[0], 0, 64)
+		size := 1
+		if err == nil && len(args) > 1 {
+			size, err = strconv.Atoi(args[1])
+		}
+		if err != nil {
+			fmt.Fprintf(out, "Invalid watchpoint: %s\n", strings.Join(args, " "))
+			break
+		}
+		if err := vm.AddWatchpoint(addr, size); err != nil {
+			fmt.Fprintln(out, err)
+			break
+		}
+		fmt.Fprintf(out, "Watchpoint 0x%04X (%d bytes) = %d\n", addr, size, vm.peek(addr, size))
+
+	case "r", "regs":
+		vm.printRegisters()
+	case "p", "print":
+		if len(args) == 0 {
+			fmt.Fprintln(out, "Usage: print r<n>")
+			break
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(args[0], "r"))
+		if err != nil || n < 0 || n >= len(vm.registers) {
+			fmt.Fprintf(out, "Invalid register: %s\n", args[0])
+			break
+		}
+		fmt.Fprintf(out, "r%d = %d (0x%X)\n", n, vm.registers[n], vm.registers[n])
+	case "x", "mem":
+		vm.printMemory(args)
+	case "l", "list":
+		vm.listInstructions()
+	case "bt", "backtrace":
+		fmt.Fprintf(out, "  #0 %s:%d\n", vm.currentFunc.Name, vm.pc)
+		for i := len(vm.callStack) - 1; i >= 0; i-- {
+			frame := vm.callStack[i]
+			fmt.Fprintf(out, "  #%d %s:%d\n", len(vm.callStack)-i, frame.Function.Name, frame.ReturnPC-1)
+		}
+	case "h", "help", "?":
+		fmt.Fprint(out, debuggerHelp)
+	default:
+		fmt.Fprintf(out, "Unknown command: %s (h for help)\n", cmd)
+	}
+	return false, nil
+}
+
+const debuggerHelp = `  s, step              Run one instruction, entering calls
+  n, next              Run one instruction, stepping over calls
+  c, continue          Run to the next breakpoint or watchpoint
+  b, break [fn:]<n>    Stop before instruction n (of fn, default: current)
+  d, delete [fn:]<n>   Delete a breakpoint
+  w, watch <addr> [size]  Stop when the 1, 2, 4 or 8 bytes at addr change
+  r, regs              Show the non-zero registers
+  p, print r<n>        Show a register
+  x, mem <addr> [n]    Dump n bytes of memory (default: 64)
+  l, list              Show the instructions around the current one
+  bt, backtrace        Show the call stack
+  q, quit              Abandon the program
+`
+
+// parseLocation parses a breakpoint location, "fn:n" or "n" in the current
+// function
+func (vm *VM) parseLocation(args []string) (string, int, error) {
+	if len(args) == 0 {
+		return "", 0, fmt.Errorf("missing location ([function:]instruction)")
+	}
+	fn, index := vm.currentFunc.Name, args[0]
+	if i := strings.LastIndexByte(args[0], ':'); i >= 0 {
+		fn, index = args[0][:i], args[0][i+1:]
+		if _, ok := vm.funcIndex[fn]; !ok {
+			return "", 0, fmt.Errorf("unknown function: %s", fn)
+		}
+	}
+	pc, err := strconv.Atoi(index)
+	if err != nil || pc < 0 {
+		return "", 0, fmt.Errorf("invalid instruction index: %s", index)
+	}
+	return fn, pc, nil
+}
+
+// printRegisters prints the registers that are not zero
+func (vm *VM) printRegisters() {
+	out := vm.config.OutputStream
+	var regs []int
+	for i, value := range vm.registers {
+		if value != 0 {
+			regs = append(regs, i)
+		}
+	}
+	if len(regs) == 0 {
+		fmt.Fprintln(out, "All registers are zero")
+		return
+	}
+	sort.Ints(regs)
+	for _, i := range regs {
+		fmt.Fprintf(out, "  r%-3d = %d (0x%X)\n", i, vm.registers[i], vm.registers[i])
+	}
+	fmt.Fprintf(out, "  sp = 0x%04X  fp = 0x%04X\n", vm.sp, vm.fp)
+}
+
+// printMemory dumps memory 16 bytes to a line
+func (vm *VM) printMemory(args []string) {
+	out := vm.config.OutputStream
+	if len(args) == 0 {
+		fmt.Fprintln(out, "Usage: mem <addr> [n]")
+		return
+	}
+	addr, err := strconv.ParseInt(args[0], 0, 64)
+	n := int64(64)
+	if err == nil && len(args) > 1 {
+		n, err = strconv.ParseInt(args[1], 0, 64)
+	}
+	if err != nil || addr < 0 || n <= 0 || addr >= int64(len(vm.memory)) {
+		fmt.Fprintf(out, "Invalid memory range: %s\n", strings.Join(args, " "))
+		return
+	}
+	n = min(n, int64(len(vm.memory))-addr)
+
+	for row := int64(0); row < n; row += 16 {
+		fmt.Fprintf(out, "  %04X ", addr+row)
+		for i := row; i < row+16 && i < n; i++ {
+			fmt.Fprintf(out, " %02X", vm.memory[addr+i])
+		}
+		fmt.Fprintln(out)
+	}
+}
+
+// listInstructions shows the instructions around the current one
+func (vm *VM) listInstructions() {
+	insts := vm.currentFunc.Instructions
+	for i := max(0, vm.pc-3); i < min(len(insts), vm.pc+4); i++ {
+		marker := " "
+		if i == vm.pc {
+			marker = ">"
+		}
+		fmt.Fprintf(vm.config.OutputStream, "%s %s:%d  %s\n", marker, vm.currentFunc.Name, i, formatInstruction(insts[i]))
+	}
+}
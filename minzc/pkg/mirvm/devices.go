@@ -0,0 +1,104 @@
+package mirvm
+
+import "fmt"
+
+// Devices let tests model hardware at the MIR level: a memory device takes
+// over loads and stores in an address range (a screen, a memory-mapped
+// register), a port device answers the port_in and port_out built-ins
+// (keyboard rows, the border). Programs can then exercise their hardware
+// logic in the VM before going through Z80 code generation.
+
+// MemoryDevice handles the bytes of a mapped address range. Addresses are
+// absolute, not relative to the start of the range.
+type MemoryDevice interface {
+	Load(addr uint16) byte
+	Store(addr uint16, value byte)
+}
+
+// PortDevice handles I/O port accesses
+type PortDevice interface {
+	In(port uint16) byte
+	Out(port uint16, value byte)
+}
+
+// memoryMapping is an address range handled by a device
+type memoryMapping struct {
+	start, end int64 // end is exclusive
+	device     MemoryDevice
+}
+
+// portMapping selects the ports p with p&mask == match, the way hardware
+// that decodes only some address lines sees them
+type portMapping struct {
+	mask, match uint16
+	device      PortDevice
+}
+
+// MapMemory routes loads and stores of the size bytes from start to dev.
+// The range may lie outside the VM's memory limit; ranges may not overlap.
+func (vm *VM) MapMemory(start uint16, size int, dev MemoryDevice) error {
+	end := int64(start) + int64(size)
+	if size <= 0 || end > 0x10000 {
+		return fmt.Errorf("invalid device range 0x%04X+%d", start, size)
+	}
+	for _, m := range vm.memoryDevices {
+		if int64(start) < m.end && m.start < end {
+			return fmt.Errorf("device range 0x%04X-0x%04X overlaps 0x%04X-0x%04X",
+				start, end-1, m.start, m.end-1)
+		}
+	}
+	vm.memoryDevices = append(vm.memoryDevices, memoryMapping{start: int64(start), end: end, device: dev})
+	return nil
+}
+
+// MapPort routes the ports p with p&mask == match to dev. A mask of 0xFFFF
+// maps a single port; the ZX Spectrum ULA, which answers every even port,
+// is MapPort(0x0001, 0x0000, ula). The first matching device wins.
+func (vm *VM) MapPort(mask, match uint16, dev PortDevice) {
+	vm.portDevices = append(vm.portDevices, portMapping{mask: mask, match: match & mask, device: dev})
+}
+
+// memoryDevice returns the device mapped at addr, if any
+func (vm *VM) memoryDevice(addr int64) MemoryDevice {
+	for _, m := range vm.memoryDevices {
+		if addr >= m.start && addr < m.end {
+			return m.device
+		}
+	}
+	return nil
+}
+
+// touchesDevice reports whether any byte of an access is mapped to a device
+func (vm *VM) touchesDevice(addr int64, size int) bool {
+	for _, m := range vm.memoryDevices {
+		if addr < m.end && m.start < addr+int64(size) {
+			return true
+		}
+	}
+	return false
+}
+
+// portDevice returns the device mapped at port, if any
+func (vm *VM) portDevice(port uint16) PortDevice {
+	for _, m := range vm.portDevices {
+		if port&m.mask == m.match {
+			return m.device
+		}
+	}
+	return nil
+}
+
+// portIn reads a port. Unmapped ports read 0xFF, as a floating bus does.
+func (vm *VM) portIn(port uint16) byte {
+	if dev := vm.portDevice(port); dev != nil {
+		return dev.In(port)
+	}
+	return 0xFF
+}
+
+// portOut writes a port. Writes to unmapped ports are ignored.
+func (vm *VM) portOut(port uint16, value byte) {
+	if dev := vm.portDevice(port); dev != nil {
+		dev.Out(port, value)
+	}
+}
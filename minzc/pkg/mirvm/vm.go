@@ -4,6 +4,7 @@ package mirvm
 import (
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/minz/minzc/pkg/ir"
 )
@@ -11,6 +12,7 @@ import (
 // Config holds VM configuration
 type Config struct {
 	MemorySize   int
+	MemoryLimit  int // Accessible bytes from address 0; 0 means all of MemorySize
 	StackSize    int
 	Debug        bool
 	Trace        bool
@@ -47,15 +49,42 @@ type VM struct {
 	callStack     []CallFrame
 	
 	// Debug state
-	breakHit      bool
-	stepMode      bool
 	instructionCount int
+	coverage      *Coverage // Non-nil once EnableCoverage is called
+	console       *console  // Interactive debugger, see EnableInteractive
+	watchpoints   []Watchpoint
+	watchHit      string // Watchpoint changes made by the last instruction
+	
+	// Hardware devices registered with MapMemory and MapPort
+	memoryDevices []memoryMapping
+	portDevices   []portMapping
 	
 	// Metaprogramming support
 	emittedCode   []string // Captured @emit output
 	stringPool    map[int64]string // String literals
 }
 
+// MemoryError reports a memory access outside the VM's valid range.
+// Run prefixes the message with the function and instruction index.
+type MemoryError struct {
+	Access      string // "load" or "store"
+	Function    string
+	PC          int
+	Instruction string // MIR instruction that made the access
+	Addr        int64
+	Size        int
+	Limit       int // Valid addresses are 0 to Limit-1
+}
+
+func (e *MemoryError) Error() string {
+	addr := fmt.Sprintf("0x%04X", e.Addr)
+	if e.Addr < 0 {
+		addr = fmt.Sprintf("%d", e.Addr)
+	}
+	return fmt.Sprintf("out-of-bounds %s of %d byte(s) at address %s by '%s'; valid range is 0x0000-0x%04X",
+		e.Access, e.Size, addr, e.Instruction, e.Limit-1)
+}
+
 // CallFrame represents a function call frame
 type CallFrame struct {
 	Function     *ir.Function
@@ -86,8 +115,13 @@ func (vm *VM) LoadModule(module *ir.Module) error {
 		vm.funcIndex[fn.Name] = fn
 	}
 	
-	// Find main function
+	// Find main function; modules compiled from source qualify it
 	mainFunc, ok := vm.funcIndex["main"]
+	for _, fn := range module.Functions {
+		if !ok && strings.HasSuffix(fn.Name, ".main") {
+			mainFunc, ok = fn, true
+		}
+	}
 	if !ok {
 		return fmt.Errorf("no main function found")
 	}
@@ -113,20 +147,29 @@ func (vm *VM) Run() (int, error) {
 	
 	// Main execution loop
 	for vm.instructionCount < vm.config.MaxSteps {
-		// Check breakpoints
-		if vm.checkBreakpoint() {
-			if err := vm.handleBreakpoint(); err != nil {
+		// Check breakpoints and stepping
+		if reason := vm.stopReason(); reason != "" {
+			if err := vm.handleStop(reason); err != nil {
 				return 1, err
 			}
 		}
 		
 		// Execute next instruction
+		fn, pc := vm.currentFunc.Name, vm.pc
 		done, err := vm.executeInstruction()
 		if err != nil {
-			return 1, fmt.Errorf("runtime error at %s:%d: %v", 
+			return 1, fmt.Errorf("runtime error at %s:%d: %w", 
 				vm.currentFunc.Name, vm.pc, err)
 		}
 		
+		// Check watchpoints; a change stops before the next instruction
+		if len(vm.watchpoints) > 0 {
+			vm.watchHit = vm.checkWatchpoints(fn, pc)
+			if done && vm.watchHit != "" {
+				fmt.Fprintf(vm.config.OutputStream, "\n%s\n", vm.watchHit)
+			}
+		}
+		
 		if done {
 			// Program completed successfully
 			return 0, nil
@@ -160,6 +203,10 @@ func (vm *VM) executeInstruction() (bool, error) {
 	
 	inst := vm.currentFunc.Instructions[vm.pc]
 	
+	if vm.coverage != nil {
+		vm.coverage.Mark(vm.currentFunc, vm.pc)
+	}
+	
 	if vm.config.Trace {
 		vm.traceInstruction(inst)
 	}
@@ -180,7 +227,10 @@ func (vm *VM) executeInstruction() (bool, error) {
 		if inst.Offset != 0 {
 			addr += int64(inst.Offset)
 		}
-		value := vm.readMemory(int(addr), inst.Size)
+		value, err := vm.readMemory(addr, inst.Size)
+		if err != nil {
+			return false, err
+		}
 		vm.registers[inst.Dest] = value
 		
 	case ir.OpStoreMem:
@@ -189,7 +239,9 @@ func (vm *VM) executeInstruction() (bool, error) {
 			addr += int64(inst.Offset)
 		}
 		value := vm.registers[inst.Src1]
-		vm.writeMemory(int(addr), value, inst.Size)
+		if err := vm.writeMemory(addr, value, inst.Size); err != nil {
+			return false, err
+		}
 		
 	case ir.OpAdd:
 		vm.registers[inst.Dest] = vm.registers[inst.Src1] + vm.registers[inst.Src2]
@@ -198,7 +250,7 @@ func (vm *VM) executeInstruction() (bool, error) {
 		vm.registers[inst.Dest] = vm.registers[inst.Src1] - vm.registers[inst.Src2]
 		
 	case ir.OpMul:
-		vm.registers[inst.Dest] = vm.registers[inst.Src1] * vm.registers[inst.Src2]
+		vm.registers[inst.Dest] = ir.FixedMul(vm.registers[inst.Src1], vm.registers[inst.Src2], inst.Type)
 		
 	case ir.OpDiv:
 		if vm.registers[inst.Src2] == 0 {
@@ -250,13 +302,21 @@ func (vm *VM) executeInstruction() (bool, error) {
 		return false, nil
 		
 	case ir.OpJmpIf:
-		if vm.registers[inst.Src1] != 0 {
+		taken := vm.registers[inst.Src1] != 0
+		if vm.coverage != nil {
+			vm.coverage.MarkBranch(vm.currentFunc, vm.pc, taken)
+		}
+		if taken {
 			vm.pc = inst.Target
 			return false, nil
 		}
 		
 	case ir.OpJmpIfNot:
-		if vm.registers[inst.Src1] == 0 {
+		taken := vm.registers[inst.Src1] == 0
+		if vm.coverage != nil {
+			vm.coverage.MarkBranch(vm.currentFunc, vm.pc, taken)
+		}
+		if taken {
 			vm.pc = inst.Target
 			return false, nil
 		}
@@ -272,11 +332,16 @@ func (vm *VM) executeInstruction() (bool, error) {
 		return false, vm.returnFromFunction()
 		
 	case ir.OpPush:
+		if err := vm.writeMemory(int64(vm.sp-8), vm.registers[inst.Src1], 8); err != nil {
+			return false, err
+		}
 		vm.sp -= 8
-		vm.writeMemory(vm.sp, vm.registers[inst.Src1], 8)
 		
 	case ir.OpPop:
-		value := vm.readMemory(vm.sp, 8)
+		value, err := vm.readMemory(int64(vm.sp), 8)
+		if err != nil {
+			return false, err
+		}
 		vm.registers[inst.Dest] = value
 		vm.sp += 8
 		
@@ -318,8 +383,11 @@ func (vm *VM) callFunction(name string) error {
 	fn, ok := vm.funcIndex[name]
 	if !ok {
 		// Check for built-in functions
-		if vm.handleBuiltin(name) {
-			return nil
+		if handled, err := vm.handleBuiltin(name); handled {
+			if err == nil {
+				vm.pc++
+			}
+			return err
 		}
 		return fmt.Errorf("undefined function: %s", name)
 	}
@@ -400,69 +468,188 @@ func (vm *VM) ClearEmittedCode() {
 	vm.emittedCode = make([]string, 0)
 }
 
-// handleBuiltin handles built-in functions
-func (vm *VM) handleBuiltin(name string) bool {
+// handleBuiltin handles built-in functions.
+// It reports whether name is a built-in and any error from running it.
+func (vm *VM) handleBuiltin(name string) (bool, error) {
 	switch name {
 	case "print_u8":
 		value := vm.registers[0] // Assuming first argument in r0
 		fmt.Fprintf(vm.config.OutputStream, "%d", byte(value))
-		return true
+		return true, nil
 		
 	case "print_u16":
 		value := vm.registers[0]
 		fmt.Fprintf(vm.config.OutputStream, "%d", uint16(value))
-		return true
+		return true, nil
 		
 	case "print_char":
 		value := vm.registers[0]
 		fmt.Fprintf(vm.config.OutputStream, "%c", byte(value))
-		return true
+		return true, nil
+		
+	case "port_in":
+		// port in r0, value returned in r0
+		vm.registers[0] = int64(vm.portIn(uint16(vm.registers[0])))
+		return true, nil
+		
+	case "port_out":
+		// port in r0, value in r1
+		vm.portOut(uint16(vm.registers[0]), byte(vm.registers[1]))
+		return true, nil
 		
 	case "memcpy":
 		// dst in r0, src in r1, size in r2
-		dst := int(vm.registers[0])
-		src := int(vm.registers[1])
+		dst := vm.registers[0]
+		src := vm.registers[1]
 		size := int(vm.registers[2])
-		copy(vm.memory[dst:dst+size], vm.memory[src:src+size])
-		return true
+		if vm.touchesDevice(src, size) || vm.touchesDevice(dst, size) {
+			// Byte by byte, so the devices see every access
+			for i := int64(0); i < int64(size); i++ {
+				value, err := vm.readMemory(src+i, 1)
+				if err != nil {
+					return true, err
+				}
+				if err := vm.writeMemory(dst+i, value, 1); err != nil {
+					return true, err
+				}
+			}
+			return true, nil
+		}
+		if err := vm.checkAccess("load", src, size); err != nil {
+			return true, err
+		}
+		if err := vm.checkAccess("store", dst, size); err != nil {
+			return true, err
+		}
+		copy(vm.memory[dst:dst+int64(size)], vm.memory[src:src+int64(size)])
+		return true, nil
 		
 	case "memset":
 		// dst in r0, value in r1, size in r2
-		dst := int(vm.registers[0])
+		dst := vm.registers[0]
 		value := byte(vm.registers[1])
 		size := int(vm.registers[2])
+		if vm.touchesDevice(dst, size) {
+			for i := int64(0); i < int64(size); i++ {
+				if err := vm.writeMemory(dst+i, int64(value), 1); err != nil {
+					return true, err
+				}
+			}
+			return true, nil
+		}
+		if err := vm.checkAccess("store", dst, size); err != nil {
+			return true, err
+		}
 		for i := 0; i < size; i++ {
-			vm.memory[dst+i] = value
+			vm.memory[dst+int64(i)] = value
 		}
-		return true
+		return true, nil
 	}
 	
-	return false
+	return false, nil
 }
 
 // Memory access functions
-func (vm *VM) readMemory(addr int, size int) int64 {
-	if addr < 0 || addr+size > len(vm.memory) {
-		// Memory access error - return 0
-		return 0
+func (vm *VM) readMemory(addr int64, size int) (int64, error) {
+	if vm.touchesDevice(addr, size) {
+		return vm.readMapped(addr, size)
+	}
+	if err := vm.checkAccess("load", addr, size); err != nil {
+		return 0, err
 	}
 	
 	var value int64
 	for i := 0; i < size; i++ {
-		value |= int64(vm.memory[addr+i]) << (i * 8)
+		value |= int64(vm.memory[addr+int64(i)]) << (i * 8)
 	}
-	return value
+	return value, nil
 }
 
-func (vm *VM) writeMemory(addr int, value int64, size int) {
-	if addr < 0 || addr+size > len(vm.memory) {
-		// Memory access error - ignore
-		return
+func (vm *VM) writeMemory(addr int64, value int64, size int) error {
+	if vm.touchesDevice(addr, size) {
+		return vm.writeMapped(addr, value, size)
+	}
+	if err := vm.checkAccess("store", addr, size); err != nil {
+		return err
 	}
 	
 	for i := 0; i < size; i++ {
-		vm.memory[addr+i] = byte(value >> (i * 8))
+		vm.memory[addr+int64(i)] = byte(value >> (i * 8))
+	}
+	return nil
+}
+
+// readMapped reads an access that is at least partly mapped to devices,
+// taking each byte from its device or from memory
+func (vm *VM) readMapped(addr int64, size int) (int64, error) {
+	limit := int64(vm.memoryLimit())
+	var value int64
+	for i := 0; i < size; i++ {
+		a := addr + int64(i)
+		var b byte
+		if dev := vm.memoryDevice(a); dev != nil {
+			b = dev.Load(uint16(a))
+		} else if a >= 0 && a < limit {
+			b = vm.memory[a]
+		} else {
+			return 0, vm.checkAccess("load", addr, size)
+		}
+		value |= int64(b) << (i * 8)
+	}
+	return value, nil
+}
+
+// writeMapped writes an access that is at least partly mapped to devices.
+// Nothing is written when part of it is out of bounds.
+func (vm *VM) writeMapped(addr int64, value int64, size int) error {
+	limit := int64(vm.memoryLimit())
+	for i := 0; i < size; i++ {
+		a := addr + int64(i)
+		if vm.memoryDevice(a) == nil && (a < 0 || a >= limit) {
+			return vm.checkAccess("store", addr, size)
+		}
 	}
+	for i := 0; i < size; i++ {
+		a := addr + int64(i)
+		if dev := vm.memoryDevice(a); dev != nil {
+			dev.Store(uint16(a), byte(value>>(i*8)))
+		} else {
+			vm.memory[a] = byte(value >> (i * 8))
+		}
+	}
+	return nil
+}
+
+// memoryLimit returns the number of accessible bytes
+func (vm *VM) memoryLimit() int {
+	if vm.config.MemoryLimit > 0 && vm.config.MemoryLimit < len(vm.memory) {
+		return vm.config.MemoryLimit
+	}
+	return len(vm.memory)
+}
+
+// checkAccess validates a memory access of size bytes at addr made by
+// the current instruction
+func (vm *VM) checkAccess(access string, addr int64, size int) error {
+	limit := vm.memoryLimit()
+	if addr >= 0 && size >= 0 && addr+int64(size) <= int64(limit) {
+		return nil
+	}
+	
+	err := &MemoryError{
+		Access: access,
+		Addr:   addr,
+		Size:   size,
+		Limit:  limit,
+	}
+	if vm.currentFunc != nil {
+		err.Function = vm.currentFunc.Name
+		err.PC = vm.pc
+		if vm.pc < len(vm.currentFunc.Instructions) {
+			err.Instruction = formatInstruction(vm.currentFunc.Instructions[vm.pc])
+		}
+	}
+	return err
 }
 
 // initGlobal initializes a global variable
@@ -500,22 +687,6 @@ func (vm *VM) checkBreakpoint() bool {
 	return false
 }
 
-func (vm *VM) handleBreakpoint() error {
-	fmt.Fprintf(vm.config.OutputStream, "\nBreakpoint hit at %s:%d\n", 
-		vm.currentFunc.Name, vm.pc)
-	
-	// Print current instruction
-	if vm.pc < len(vm.currentFunc.Instructions) {
-		inst := vm.currentFunc.Instructions[vm.pc]
-		fmt.Fprintf(vm.config.OutputStream, "  Next: %s\n", formatInstruction(inst))
-	}
-	
-	// TODO: Interactive debugger
-	vm.stepMode = true
-	
-	return nil
-}
-
 func (vm *VM) traceInstruction(inst ir.Instruction) {
 	fmt.Fprintf(vm.config.OutputStream, "[%s:%d] %s\n", 
 		vm.currentFunc.Name, vm.pc, formatInstruction(inst))
@@ -531,12 +702,24 @@ func formatInstruction(inst ir.Instruction) string {
 		return fmt.Sprintf("r%d = r%d + r%d", inst.Dest, inst.Src1, inst.Src2)
 	case ir.OpSub:
 		return fmt.Sprintf("r%d = r%d - r%d", inst.Dest, inst.Src1, inst.Src2)
+	case ir.OpLoadMem:
+		return fmt.Sprintf("r%d = load%d [r%d%+d]", inst.Dest, inst.Size, inst.Src1, inst.Offset)
+	case ir.OpStoreMem:
+		return fmt.Sprintf("store%d [r%d%+d], r%d", inst.Size, inst.Dest, inst.Offset, inst.Src1)
+	case ir.OpPush:
+		return fmt.Sprintf("push r%d", inst.Src1)
+	case ir.OpPop:
+		return fmt.Sprintf("r%d = pop", inst.Dest)
 	case ir.OpCall:
 		return fmt.Sprintf("call %s", inst.FuncName)
 	case ir.OpReturn:
 		return "return"
 	case ir.OpJmp:
 		return fmt.Sprintf("jmp %d", inst.Target)
+	case ir.OpJmpIf:
+		return fmt.Sprintf("jmpif r%d %d", inst.Src1, inst.Target)
+	case ir.OpJmpIfNot:
+		return fmt.Sprintf("jmpnot r%d %d", inst.Src1, inst.Target)
 	default:
 		return inst.Op.String()
 	}
@@ -0,0 +1,301 @@
+package mirvm
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// runMain runs a single-function module with a 1K memory limit
+func runMain(t *testing.T, instructions []ir.Instruction) error {
+	t.Helper()
+	main := ir.NewFunction("main", &ir.BasicType{Kind: ir.TypeVoid})
+	main.Instructions = instructions
+
+	vm := New(Config{
+		MemorySize:   65536,
+		MemoryLimit:  1024,
+		StackSize:    1024,
+		MaxSteps:     100,
+		OutputStream: &bytes.Buffer{},
+	})
+	if err := vm.LoadModule(&ir.Module{Name: "test", Functions: []*ir.Function{main}}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := vm.Run()
+	return err
+}
+
+func TestOutOfBoundsLoad(t *testing.T) {
+	err := runMain(t, []ir.Instruction{
+		{Op: ir.OpLoadImm, Dest: 1, Value: 1020},
+		{Op: ir.OpLoadMem, Dest: 2, Src1: 1, Offset: 4, Size: 2},
+		{Op: ir.OpHalt},
+	})
+
+	var memErr *MemoryError
+	if !errors.As(err, &memErr) {
+		t.Fatalf("expected MemoryError, got %v", err)
+	}
+	if memErr.Access != "load" || memErr.Function != "main" || memErr.PC != 1 || memErr.Addr != 1024 {
+		t.Errorf("unexpected diagnostic: %+v", memErr)
+	}
+	for _, want := range []string{"main:1", "r2 = load2 [r1+4]", "address 0x0400", "0x0000-0x03FF"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestOutOfBoundsStore(t *testing.T) {
+	err := runMain(t, []ir.Instruction{
+		{Op: ir.OpLoadImm, Dest: 1, Value: 42},
+		{Op: ir.OpLoadImm, Dest: 2, Value: -1},
+		{Op: ir.OpStoreMem, Dest: 2, Src1: 1, Size: 1},
+		{Op: ir.OpHalt},
+	})
+
+	var memErr *MemoryError
+	if !errors.As(err, &memErr) {
+		t.Fatalf("expected MemoryError, got %v", err)
+	}
+	if memErr.Access != "store" || memErr.PC != 2 || memErr.Addr != -1 {
+		t.Errorf("unexpected diagnostic: %+v", memErr)
+	}
+	for _, want := range []string{"main:2", "out-of-bounds store", "store1 [r2+0], r1", "address -1"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestCoverageSkippedBranch(t *testing.T) {
+	main := ir.NewFunction("main", &ir.BasicType{Kind: ir.TypeVoid})
+	main.Instructions = []ir.Instruction{
+		{Op: ir.OpLoadImm, Dest: 1, Value: 1},
+		{Op: ir.OpJmpIf, Src1: 1, Target: 3},
+		{Op: ir.OpLoadImm, Dest: 2, Value: 2}, // Skipped
+		{Op: ir.OpHalt},
+	}
+	helper := ir.NewFunction("helper", &ir.BasicType{Kind: ir.TypeVoid})
+	helper.Instructions = []ir.Instruction{{Op: ir.OpReturn}}
+	module := &ir.Module{Name: "test", Functions: []*ir.Function{main, helper}}
+
+	vm := New(Config{MemorySize: 1024, StackSize: 1024, MaxSteps: 100, OutputStream: &bytes.Buffer{}})
+	if err := vm.LoadModule(module); err != nil {
+		t.Fatal(err)
+	}
+	coverage := vm.EnableCoverage()
+	if _, err := vm.Run(); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+
+	got := coverage.Function(main)
+	if got.Executed != 3 || got.Total != 4 || len(got.Uncovered) != 1 || got.Uncovered[0] != 2 {
+		t.Errorf("main coverage = %+v, want 3/4 with instruction 2 uncovered", got)
+	}
+
+	var report bytes.Buffer
+	if err := coverage.WriteReport(&report, module); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Executed: 3/5 instructions (60.0%)",
+		"75.0%  uncovered: 2",
+		"0.0%  uncovered: 0",
+	} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, report.String())
+		}
+	}
+}
+
+func TestCoverageAnnotated(t *testing.T) {
+	main := ir.NewFunction("main", &ir.BasicType{Kind: ir.TypeVoid})
+	main.Instructions = []ir.Instruction{
+		{Op: ir.OpLoadImm, Dest: 1, Value: 3},
+		{Op: ir.OpLoadImm, Dest: 2, Value: 1},
+		{Op: ir.OpSub, Dest: 1, Src1: 1, Src2: 2},
+		{Op: ir.OpJmpIf, Src1: 1, Target: 2},    // Both ways
+		{Op: ir.OpJmpIfNot, Src1: 1, Target: 6}, // Always jumps
+		{Op: ir.OpLoadImm, Dest: 3, Value: 7},
+		{Op: ir.OpHalt},
+	}
+	module := &ir.Module{Name: "loop", Functions: []*ir.Function{main}}
+
+	vm := New(Config{MemorySize: 1024, StackSize: 1024, MaxSteps: 100, OutputStream: &bytes.Buffer{}})
+	if err := vm.LoadModule(module); err != nil {
+		t.Fatal(err)
+	}
+	coverage := vm.EnableCoverage()
+	if _, err := vm.Run(); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+
+	if got := coverage.Function(main); got.Outcomes != 4 || got.OutcomesCovered != 3 {
+		t.Errorf("branch outcomes = %d/%d, want 3/4", got.OutcomesCovered, got.Outcomes)
+	}
+
+	var report bytes.Buffer
+	if err := coverage.WriteAnnotated(&report, module); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"; Executed: 6/7 instructions (85.7%), 3/4 branch outcomes (75.0%)",
+		"       3 |    2: r1 = r1 - r2\n",
+		"       3 |    3: jmpif r1 2  ; jumped 2, fell through 1\n",
+		"!      1 |    4: jmpnot r1 6  ; jumped 1, fell through 0\n",
+		"   ##### |    5: r3 = 7\n",
+	} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, report.String())
+		}
+	}
+}
+
+// testScreen records stores to a memory-mapped range and answers loads
+type testScreen struct {
+	stores map[uint16]byte
+}
+
+func (s *testScreen) Load(addr uint16) byte         { return byte(addr) }
+func (s *testScreen) Store(addr uint16, value byte) { s.stores[addr] = value }
+
+// testPorts reads a fixed keyboard row and records port writes
+type testPorts struct {
+	out []uint16
+}
+
+func (p *testPorts) In(port uint16) byte         { return byte(port >> 8) }
+func (p *testPorts) Out(port uint16, value byte) { p.out = append(p.out, port, uint16(value)) }
+
+func TestDevices(t *testing.T) {
+	main := ir.NewFunction("main", &ir.BasicType{Kind: ir.TypeVoid})
+	main.Instructions = []ir.Instruction{
+		{Op: ir.OpLoadImm, Dest: 1, Value: 0x4000},
+		{Op: ir.OpLoadImm, Dest: 2, Value: 0x1234},
+		{Op: ir.OpStoreMem, Dest: 1, Src1: 2, Size: 2},           // Both bytes to the screen
+		{Op: ir.OpLoadMem, Dest: 3, Src1: 1, Offset: 7, Size: 1}, // Reads the low address byte
+		{Op: ir.OpLoadImm, Dest: 0, Value: 0xFEFE},
+		{Op: ir.OpCall, FuncName: "port_in"},
+		{Op: ir.OpLoadReg, Dest: 4, Src1: 0},
+		{Op: ir.OpLoadImm, Dest: 0, Value: 0x00FE},
+		{Op: ir.OpLoadImm, Dest: 1, Value: 2},
+		{Op: ir.OpCall, FuncName: "port_out"},
+		{Op: ir.OpLoadImm, Dest: 0, Value: 0x00FF},
+		{Op: ir.OpCall, FuncName: "port_in"}, // Odd port: not decoded
+		{Op: ir.OpHalt},
+	}
+
+	vm := New(Config{MemorySize: 65536, MemoryLimit: 1024, StackSize: 1024, MaxSteps: 100, OutputStream: &bytes.Buffer{}})
+	screen := &testScreen{stores: make(map[uint16]byte)}
+	if err := vm.MapMemory(0x4000, 0x1B00, screen); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.MapMemory(0x5000, 16, screen); err == nil {
+		t.Error("overlapping device range was accepted")
+	}
+	ports := &testPorts{}
+	vm.MapPort(0x0001, 0x0000, ports)
+
+	if err := vm.LoadModule(&ir.Module{Name: "test", Functions: []*ir.Function{main}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.Run(); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+
+	if screen.stores[0x4000] != 0x34 || screen.stores[0x4001] != 0x12 {
+		t.Errorf("screen stores = %v, want 0x34 at 0x4000 and 0x12 at 0x4001", screen.stores)
+	}
+	if vm.registers[3] != 0x07 {
+		t.Errorf("screen load = 0x%X, want 0x07", vm.registers[3])
+	}
+	if vm.registers[4] != 0xFE {
+		t.Errorf("port_in(0xFEFE) = 0x%X, want 0xFE", vm.registers[4])
+	}
+	if len(ports.out) != 2 || ports.out[0] != 0x00FE || ports.out[1] != 2 {
+		t.Errorf("port writes = %v, want [0xFE 2]", ports.out)
+	}
+	if vm.registers[0] != 0xFF {
+		t.Errorf("unmapped port read 0x%X, want 0xFF", vm.registers[0])
+	}
+}
+
+// debugModule stores 42 at 0x100 from main and calls helper
+func debugModule() *ir.Module {
+	main := ir.NewFunction("main", &ir.BasicType{Kind: ir.TypeVoid})
+	main.Instructions = []ir.Instruction{
+		{Op: ir.OpLoadImm, Dest: 1, Value: 0x100},
+		{Op: ir.OpLoadImm, Dest: 2, Value: 42},
+		{Op: ir.OpStoreMem, Dest: 1, Src1: 2, Size: 1},
+		{Op: ir.OpCall, FuncName: "helper"},
+		{Op: ir.OpLoadReg, Dest: 3, Src1: 5},
+		{Op: ir.OpHalt},
+	}
+	helper := ir.NewFunction("helper", &ir.BasicType{Kind: ir.TypeVoid})
+	helper.Instructions = []ir.Instruction{
+		{Op: ir.OpLoadImm, Dest: 5, Value: 7},
+		{Op: ir.OpReturn},
+	}
+	return &ir.Module{Name: "test", Functions: []*ir.Function{main, helper}}
+}
+
+func TestWatchpoint(t *testing.T) {
+	var out bytes.Buffer
+	vm := New(Config{MemorySize: 1024, StackSize: 1024, MaxSteps: 100, OutputStream: &out})
+	if err := vm.LoadModule(debugModule()); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.AddWatchpoint(0x100, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.AddWatchpoint(0x100, 3); err == nil {
+		t.Error("watchpoint of 3 bytes was accepted")
+	}
+	if _, err := vm.Run(); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	for _, want := range []string{"Watchpoint 0x0100: 0 -> 42 (by main:2)", "Next: [main:3] call helper"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestInteractiveDebugger(t *testing.T) {
+	var out bytes.Buffer
+	vm := New(Config{MemorySize: 1024, StackSize: 1024, MaxSteps: 100, OutputStream: &out})
+	if err := vm.LoadModule(debugModule()); err != nil {
+		t.Fatal(err)
+	}
+	// Step past the store, break in helper, check its frame, step out
+	vm.EnableInteractive(strings.NewReader(strings.Join([]string{
+		"n", "n", "n", "p r2", "x 0x100 2", "b helper:1", "c", "bt", "r", "s", "q",
+	}, "\n")))
+	_, err := vm.Run()
+	if !errors.Is(err, ErrQuit) {
+		t.Fatalf("Run = %v, want ErrQuit", err)
+	}
+	if vm.registers[3] != 0 {
+		t.Error("the program ran on after quit")
+	}
+	for _, want := range []string{
+		"Next: [main:0]",
+		"Next: [main:2] store1 [r1+0], r2",
+		"r2 = 42 (0x2A)",
+		"0100  2A 00",
+		"Breakpoint at helper:1",
+		"Breakpoint hit at helper:1",
+		"#0 helper:1\n  #1 main:3",
+		"r5   = 7",
+		"Next: [main:4] r3 = r5",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+}
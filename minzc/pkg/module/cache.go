@@ -0,0 +1,152 @@
+package module
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Dependencies are fetched once into a cache shared by all projects, one
+// directory per repository and version:
+//
+//	~/.minz/pkg/github.com/someone/sprites@v1.2.0/
+//
+// MINZ_CACHE moves the cache. A library's checkout is searched for imports
+// like a project root, so import sprites.draw loads sprites/draw.minz from
+// it.
+
+// CacheDir returns the dependency cache directory
+func CacheDir() (string, error) {
+	if dir := os.Getenv("MINZ_CACHE"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("no dependency cache: set MINZ_CACHE (%v)", err)
+	}
+	return filepath.Join(home, ".minz", "pkg"), nil
+}
+
+// Dir returns where the dependency is checked out in the cache
+func (d Dependency) Dir(cache string) string {
+	path := d.Git
+	if i := strings.Index(path, "://"); i >= 0 {
+		path = path[i+3:]
+	}
+	// scp-like git@host:path
+	if at := strings.Index(path, "@"); at >= 0 && !strings.Contains(path[:at], "/") {
+		path = path[at+1:]
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	path = strings.NewReplacer(":", "/", "..", "_").Replace(path)
+	return filepath.Join(cache, filepath.FromSlash(path)+"@"+d.Version)
+}
+
+// Fetched reports whether the dependency is in the cache
+func (d Dependency) Fetched(cache string) bool {
+	info, err := os.Stat(d.Dir(cache))
+	return err == nil && info.IsDir()
+}
+
+// Fetch clones the dependency's version, a tag or branch, into the cache
+// unless it is there already
+func (d Dependency) Fetch(cache string) error {
+	if d.Fetched(cache) {
+		return nil
+	}
+	dir := d.Dir(cache)
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	// Clone next to the final directory and rename it into place, so an
+	// interrupted fetch never leaves a partial checkout in the cache
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".fetch-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	clone := exec.Command("git", "clone", "--quiet", "--depth", "1", "--branch", d.Version, d.Git, tmp)
+	clone.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if out, err := clone.CombinedOutput(); err != nil {
+		return fmt.Errorf("fetching %s@%s from %s: %v\n%s", d.Name, d.Version, d.Git, err, strings.TrimSpace(string(out)))
+	}
+	if err := os.RemoveAll(filepath.Join(tmp, ".git")); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dir)
+}
+
+// SearchPaths returns the cache directories of the manifest's dependencies
+// and theirs, in dependency order. It fails for a dependency that has not
+// been fetched.
+func SearchPaths(manifest *Manifest, cache string) ([]string, error) {
+	var paths []string
+	err := walkDependencies(manifest, cache, func(dep Dependency) error {
+		if !dep.Fetched(cache) {
+			return fmt.Errorf("dependency %s@%s is not fetched: run mz mod tidy", dep.Name, dep.Version)
+		}
+		paths = append(paths, dep.Dir(cache))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// FetchAll fetches the manifest's dependencies and theirs, and returns the
+// ones that were not in the cache
+func FetchAll(manifest *Manifest, cache string) ([]Dependency, error) {
+	var fetched []Dependency
+	err := walkDependencies(manifest, cache, func(dep Dependency) error {
+		if dep.Fetched(cache) {
+			return nil
+		}
+		fetched = append(fetched, dep)
+		return dep.Fetch(cache)
+	})
+	return fetched, err
+}
+
+// walkDependencies calls visit for each dependency of the manifest, then
+// walks the dependencies in the library's own minz.toml once visit has
+// returned. A repository at a version is visited once.
+func walkDependencies(manifest *Manifest, cache string, visit func(Dependency) error) error {
+	seen := make(map[string]bool)
+	var walk func(m *Manifest) error
+	walk = func(m *Manifest) error {
+		for _, dep := range m.Dependencies {
+			dir := dep.Dir(cache)
+			if seen[dir] {
+				continue
+			}
+			seen[dir] = true
+			if err := visit(dep); err != nil {
+				return err
+			}
+			lib, err := loadLibraryManifest(dir)
+			if err != nil {
+				return err
+			}
+			if lib != nil {
+				if err := walk(lib); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(manifest)
+}
+
+// loadLibraryManifest loads the minz.toml of a checked-out library, or
+// returns nil if it has none
+func loadLibraryManifest(dir string) (*Manifest, error) {
+	path := filepath.Join(dir, ManifestFile)
+	if _, err := os.Stat(path); err != nil {
+		return nil, nil
+	}
+	return LoadManifest(path)
+}
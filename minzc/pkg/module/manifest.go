@@ -0,0 +1,291 @@
+package module
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ManifestFile is the name of the project manifest. It names the project
+// and the libraries it depends on:
+//
+//	[package]
+//	name = "mygame"
+//	version = "0.1.0"
+//
+//	[dependencies]
+//	sprites = { git = "https://github.com/someone/sprites", version = "v1.2.0" }
+//
+// Only this subset of TOML is read: the two tables, comments, string values
+// and inline tables of strings.
+const ManifestFile = "minz.toml"
+
+// Manifest is a parsed minz.toml
+type Manifest struct {
+	Name         string
+	Version      string
+	Dependencies []Dependency // Sorted by name
+}
+
+// Dependency is a library fetched from a git repository at a tag or branch
+type Dependency struct {
+	Name    string
+	Git     string
+	Version string
+}
+
+// FindManifest looks for minz.toml in dir and its parents and returns its
+// path, or "" if there is none
+func FindManifest(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, ManifestFile)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// LoadManifest reads a minz.toml file
+func LoadManifest(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	manifest, err := ParseManifest(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return manifest, nil
+}
+
+// ParseManifest parses a manifest in the TOML subset described at
+// ManifestFile
+func ParseManifest(r io.Reader) (*Manifest, error) {
+	manifest := &Manifest{}
+	table := ""
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unterminated table header", lineNo)
+			}
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			if table != "package" && table != "dependencies" {
+				return nil, fmt.Errorf("line %d: unknown table [%s]", lineNo, table)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch table {
+		case "package":
+			text, err := parseString(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s: %v", lineNo, key, err)
+			}
+			switch key {
+			case "name":
+				manifest.Name = text
+			case "version":
+				manifest.Version = text
+			default:
+				return nil, fmt.Errorf("line %d: unknown package key %q", lineNo, key)
+			}
+		case "dependencies":
+			dep, err := parseDependency(key, value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNo, err)
+			}
+			if manifest.Dependency(dep.Name) != nil {
+				return nil, fmt.Errorf("line %d: dependency %s listed twice", lineNo, dep.Name)
+			}
+			manifest.Dependencies = append(manifest.Dependencies, dep)
+		default:
+			return nil, fmt.Errorf("line %d: key %q outside a table", lineNo, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	manifest.sortDependencies()
+	return manifest, nil
+}
+
+// parseDependency parses name = { git = "...", version = "..." }
+func parseDependency(name, value string) (Dependency, error) {
+	dep := Dependency{Name: name}
+	if !strings.HasPrefix(value, "{") || !strings.HasSuffix(value, "}") {
+		return dep, fmt.Errorf("dependency %s: expected { git = \"...\", version = \"...\" }", name)
+	}
+	for _, field := range splitFields(value[1 : len(value)-1]) {
+		key, text, ok := strings.Cut(field, "=")
+		if !ok {
+			return dep, fmt.Errorf("dependency %s: expected key = value in %q", name, field)
+		}
+		key = strings.TrimSpace(key)
+		str, err := parseString(strings.TrimSpace(text))
+		if err != nil {
+			return dep, fmt.Errorf("dependency %s: %s: %v", name, key, err)
+		}
+		switch key {
+		case "git":
+			dep.Git = str
+		case "version":
+			dep.Version = str
+		default:
+			return dep, fmt.Errorf("dependency %s: unknown key %q", name, key)
+		}
+	}
+	if dep.Git == "" || dep.Version == "" {
+		return dep, fmt.Errorf("dependency %s needs both git and version", name)
+	}
+	return dep, nil
+}
+
+// splitFields splits the inside of an inline table at the commas outside
+// strings
+func splitFields(text string) []string {
+	var fields []string
+	start, quoted := 0, false
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				fields = append(fields, text[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if rest := strings.TrimSpace(text[start:]); rest != "" {
+		fields = append(fields, rest)
+	}
+	return fields
+}
+
+// stripComment removes a # comment that is not inside a string
+func stripComment(line string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case '#':
+			if !quoted {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// parseString parses a basic TOML string, which has Go's escapes
+func parseString(value string) (string, error) {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return "", fmt.Errorf("expected a quoted string, got %s", value)
+	}
+	text, err := strconv.Unquote(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid string %s", value)
+	}
+	return text, nil
+}
+
+// Dependency returns the dependency with the given name, or nil
+func (m *Manifest) Dependency(name string) *Dependency {
+	for i := range m.Dependencies {
+		if m.Dependencies[i].Name == name {
+			return &m.Dependencies[i]
+		}
+	}
+	return nil
+}
+
+// AddDependency adds a dependency, or changes the repository and version of
+// the one with the same name
+func (m *Manifest) AddDependency(dep Dependency) {
+	if existing := m.Dependency(dep.Name); existing != nil {
+		*existing = dep
+		return
+	}
+	m.Dependencies = append(m.Dependencies, dep)
+	m.sortDependencies()
+}
+
+// RemoveDependency removes the dependency with the given name
+func (m *Manifest) RemoveDependency(name string) {
+	for i, dep := range m.Dependencies {
+		if dep.Name == name {
+			m.Dependencies = append(m.Dependencies[:i], m.Dependencies[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *Manifest) sortDependencies() {
+	sort.Slice(m.Dependencies, func(i, j int) bool {
+		return m.Dependencies[i].Name < m.Dependencies[j].Name
+	})
+}
+
+// Write writes the manifest in the form ParseManifest reads. Comments in
+// the file it came from are not kept.
+func (m *Manifest) Write(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("[package]\n")
+	fmt.Fprintf(&b, "name = %s\n", strconv.Quote(m.Name))
+	if m.Version != "" {
+		fmt.Fprintf(&b, "version = %s\n", strconv.Quote(m.Version))
+	}
+	b.WriteString("\n[dependencies]\n")
+	for _, dep := range m.Dependencies {
+		fmt.Fprintf(&b, "%s = { git = %s, version = %s }\n", dep.Name, strconv.Quote(dep.Git), strconv.Quote(dep.Version))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Save writes the manifest to a file
+func (m *Manifest) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := m.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,148 @@
+package module
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseManifest(t *testing.T) {
+	source := `# A game
+[package]
+name = "game"   # trailing comment
+version = "0.1.0"
+
+[dependencies]
+sprites = { git = "https://github.com/someone/sprites.git", version = "v1.2.0" }
+math = {version = "main", git = "git@example.com:libs/math#2"}
+`
+	manifest, err := ParseManifest(strings.NewReader(source))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := &Manifest{
+		Name:    "game",
+		Version: "0.1.0",
+		Dependencies: []Dependency{
+			{Name: "math", Git: "git@example.com:libs/math#2", Version: "main"},
+			{Name: "sprites", Git: "https://github.com/someone/sprites.git", Version: "v1.2.0"},
+		},
+	}
+	if !reflect.DeepEqual(manifest, want) {
+		t.Fatalf("got %+v, want %+v", manifest, want)
+	}
+
+	var written strings.Builder
+	if err := manifest.Write(&written); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	reread, err := ParseManifest(strings.NewReader(written.String()))
+	if err != nil || !reflect.DeepEqual(reread, want) {
+		t.Errorf("written manifest reads back as %+v (%v):\n%s", reread, err, written.String())
+	}
+
+	if dir := want.Dependencies[1].Dir("/cache"); dir != filepath.FromSlash("/cache/github.com/someone/sprites@v1.2.0") {
+		t.Errorf("sprites cached in %s", dir)
+	}
+	if dir := want.Dependencies[0].Dir("/cache"); dir != filepath.FromSlash("/cache/example.com/libs/math#2@main") {
+		t.Errorf("math cached in %s", dir)
+	}
+
+	for _, bad := range []string{
+		"name = \"x\"",
+		"[package]\nname = x",
+		"[package]\nauthor = \"x\"",
+		"[tools]",
+		"[dependencies]\nlib = { git = \"a\" }",
+		"[dependencies]\nlib = \"v1\"",
+		"[dependencies]\nlib = { git = \"a\", version = \"1\" }\nlib = { git = \"b\", version = \"1\" }",
+	} {
+		if _, err := ParseManifest(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
+
+func TestFetchAndTidy(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	tmp := t.TempDir()
+	cache := filepath.Join(tmp, "cache")
+
+	// Two libraries; sprites depends on math through its own minz.toml
+	library := func(name string, files map[string]string) string {
+		dir := filepath.Join(tmp, name)
+		for path, text := range files {
+			writeFile(t, filepath.Join(dir, path), text)
+		}
+		for _, args := range [][]string{
+			{"init", "-q"}, {"add", "-A"},
+			{"-c", "user.name=t", "-c", "user.email=t@t", "commit", "-qm", name},
+			{"tag", "v1"},
+		} {
+			cmd := exec.Command("git", args...)
+			cmd.Dir = dir
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("git %v: %v\n%s", args, err, out)
+			}
+		}
+		return dir
+	}
+	mathRepo := library("math", map[string]string{"math/fixed.minz": "pub fun one() -> u8 { return 1; }\n"})
+	spritesRepo := library("sprites", map[string]string{
+		"sprites/draw.minz": "import math.fixed;\n",
+		ManifestFile:        "[package]\nname = \"sprites\"\n\n[dependencies]\nmath = { git = \"" + mathRepo + "\", version = \"v1\" }\n",
+	})
+
+	project := filepath.Join(tmp, "game")
+	writeFile(t, filepath.Join(project, "main.minz"), "import sprites.draw;\nfun main() -> void {}\n")
+	manifest := &Manifest{Name: "game"}
+	manifest.AddDependency(Dependency{Name: "sprites", Git: spritesRepo, Version: "v1"})
+	manifest.AddDependency(Dependency{Name: "math", Git: mathRepo, Version: "v1"})
+
+	if _, err := SearchPaths(manifest, cache); err == nil {
+		t.Error("search paths of unfetched dependencies")
+	}
+	fetched, dropped, err := Tidy(manifest, project, cache)
+	if err != nil {
+		t.Fatalf("tidy: %v", err)
+	}
+	if len(fetched) != 2 || len(dropped) != 1 || dropped[0].Name != "math" {
+		t.Errorf("fetched %v, dropped %v", fetched, dropped)
+	}
+	if _, err := os.Stat(filepath.Join(manifest.Dependencies[0].Dir(cache), ".git")); err == nil {
+		t.Error(".git kept in the cache")
+	}
+
+	// math is still found through sprites' manifest
+	paths, err := SearchPaths(manifest, cache)
+	if err != nil {
+		t.Fatalf("search paths: %v", err)
+	}
+	want := []string{
+		Dependency{Git: spritesRepo, Version: "v1"}.Dir(cache),
+		Dependency{Git: mathRepo, Version: "v1"}.Dir(cache),
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("search paths %v, want %v", paths, want)
+	}
+
+	missing := Dependency{Name: "gone", Git: filepath.Join(tmp, "gone"), Version: "v1"}
+	if err := missing.Fetch(cache); err == nil || missing.Fetched(cache) {
+		t.Errorf("fetching a missing repository: %v", err)
+	}
+}
+
+func writeFile(t *testing.T, path, text string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,86 @@
+package module
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// importPattern matches the module path of an import statement
+var importPattern = regexp.MustCompile(`(?m)^\s*import\s+([A-Za-z_][A-Za-z0-9_.]*)`)
+
+// ProjectImports returns the module paths imported by the .minz files under
+// root, sorted. Hidden directories are skipped.
+func ProjectImports(root string) ([]string, error) {
+	seen := make(map[string]bool)
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != root && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".minz" {
+			return nil
+		}
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, match := range importPattern.FindAllSubmatch(source, -1) {
+			seen[strings.TrimSuffix(string(match[1]), ".")] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	imports := make([]string, 0, len(seen))
+	for path := range seen {
+		imports = append(imports, path)
+	}
+	sort.Strings(imports)
+	return imports, nil
+}
+
+// Provides reports whether the dependency's checkout has the module an
+// import names
+func (d Dependency) Provides(cache, importPath string) bool {
+	file := filepath.Join(d.Dir(cache), filepath.FromSlash(strings.ReplaceAll(importPath, ".", "/"))+".minz")
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+// Tidy fetches every dependency the manifest needs and drops the direct
+// dependencies that none of the imports under root are found in. It
+// returns the dependencies fetched and dropped; the caller saves the
+// manifest.
+func Tidy(manifest *Manifest, root, cache string) (fetched, dropped []Dependency, err error) {
+	if fetched, err = FetchAll(manifest, cache); err != nil {
+		return fetched, nil, err
+	}
+	imports, err := ProjectImports(root)
+	if err != nil {
+		return fetched, nil, err
+	}
+	for _, dep := range append([]Dependency(nil), manifest.Dependencies...) {
+		used := false
+		for _, path := range imports {
+			if dep.Provides(cache, path) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			manifest.RemoveDependency(dep.Name)
+			dropped = append(dropped, dep)
+		}
+	}
+	return fetched, dropped, nil
+}
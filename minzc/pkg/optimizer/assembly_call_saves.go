@@ -0,0 +1,192 @@
+package optimizer
+
+import (
+	"strings"
+)
+
+// Z80 registers tracked by the register-aware peepholes. IX and IY are
+// tracked as whole pairs.
+const (
+	regA = 1 << iota
+	regB
+	regC
+	regD
+	regE
+	regH
+	regL
+	regIX
+	regIY
+)
+
+// pairRegs maps a register or register pair name to its register bits
+var pairRegs = map[string]int{
+	"A": regA, "B": regB, "C": regC, "D": regD, "E": regE, "H": regH, "L": regL,
+	"AF": regA, "BC": regB | regC, "DE": regD | regE, "HL": regH | regL,
+	"IX": regIX, "IY": regIY, "IXH": regIX, "IXL": regIX, "IYH": regIY, "IYL": regIY,
+}
+
+// operandRegs returns the registers an operand names or addresses through,
+// e.g. HL for (HL) and IX for (IX+4)
+func operandRegs(op string) int {
+	if strings.HasPrefix(op, "(") {
+		op = strings.TrimSuffix(strings.TrimPrefix(op, "("), ")")
+		if i := strings.IndexAny(op, "+-"); i > 0 {
+			op = strings.TrimSpace(op[:i])
+		}
+	}
+	return pairRegs[op]
+}
+
+// isMemoryOperand reports whether an operand is a memory reference
+func isMemoryOperand(op string) bool {
+	return strings.HasPrefix(op, "(")
+}
+
+// regEffect returns the registers an instruction reads and the registers it
+// fully defines. branches is true when control may leave the straight-line
+// path; calls and returns leave the listing, so everything counts as read
+// there. known is false for instructions whose register use is not modeled.
+func regEffect(inst asmInstruction) (reads, writes int, branches, known bool) {
+	ops := inst.operands
+	all := 0
+	for _, op := range ops {
+		all |= operandRegs(op)
+	}
+	// dest returns the registers a one- or two-operand instruction defines
+	dest := func() int {
+		if len(ops) == 0 || isMemoryOperand(ops[0]) {
+			return 0
+		}
+		return operandRegs(ops[0])
+	}
+
+	switch inst.mnemonic {
+	case "NOP", "DI", "EI", "IM", "SCF", "CCF":
+		return 0, 0, false, true
+	case "LD":
+		if len(ops) != 2 {
+			return 0, 0, false, false
+		}
+		reads = operandRegs(ops[1])
+		if isMemoryOperand(ops[0]) {
+			reads |= operandRegs(ops[0])
+		}
+		return reads, dest(), false, true
+	case "PUSH":
+		return all, 0, false, true
+	case "POP":
+		return 0, all, false, true
+	case "EX", "EXX":
+		// Swaps are modeled as reads of both sides
+		if inst.mnemonic == "EXX" {
+			all = regB | regC | regD | regE | regH | regL
+		}
+		return all, 0, false, true
+
+	case "ADD", "ADC", "SBC", "SUB", "AND", "OR", "XOR", "CP":
+		if len(ops) == 1 {
+			reads = regA | all
+		} else {
+			reads = all
+		}
+		if inst.mnemonic == "CP" {
+			return reads, 0, false, true
+		}
+		if len(ops) == 1 {
+			return reads, regA, false, true
+		}
+		return reads, dest(), false, true
+	case "INC", "DEC":
+		return all, dest(), false, true
+	case "NEG", "CPL", "DAA", "RLA", "RRA", "RLCA", "RRCA":
+		return regA, regA, false, true
+	case "RLC", "RRC", "RL", "RR", "SLA", "SRA", "SRL", "SLL", "SET", "RES":
+		return all, dest(), false, true
+	case "BIT":
+		return all, 0, false, true
+
+	case "JP", "JR":
+		return all, 0, true, true
+	case "DJNZ":
+		return regB, 0, true, true
+	case "CALL", "RET", "RETI", "RETN", "RST", "HALT":
+		return 0, 0, true, true
+	}
+	return 0, 0, false, false
+}
+
+// regsDeadAfter reports whether none of the given registers can be read
+// after line i before being redefined, following jumps like flagsDeadAfter
+func regsDeadAfter(lines []string, i int, mask int) bool {
+	budget := flagScanLimit
+	return deadFrom(lines, asmLabels(lines), i+1, mask, regEffect, map[int]bool{}, &budget)
+}
+
+// nextInstruction returns the index of the first line after i that holds an
+// instruction, skipping blank and comment-only lines. It stops at labels and
+// directives, returning -1.
+func nextInstruction(lines []string, i int) (int, asmInstruction) {
+	for j := i + 1; j < len(lines); j++ {
+		if inst, ok := parseAsmInstruction(lines[j]); ok {
+			return j, inst
+		}
+		if code, _ := splitAsmComment(lines[j]); strings.TrimSpace(code) != "" {
+			break
+		}
+	}
+	return -1, asmInstruction{}
+}
+
+// isStackArgument reports whether a PUSH passes a call argument on the
+// stack, as the code generator marks them. The callee reads those through
+// its frame, so they must stay even when the caller never uses the value.
+func isStackArgument(inst asmInstruction) bool {
+	comment := strings.ToLower(inst.comment)
+	return strings.Contains(comment, "argument") || strings.Contains(comment, "parameter")
+}
+
+// optimizeCallSaves removes PUSH rr / CALL nn / POP rr sequences that save a
+// register across a call when the restored register is dead after the POP.
+// Without a later read the save is useless, and dropping both keeps the
+// stack balanced. PUSH AF also needs the restored flags to be dead.
+func (p *AssemblyPeepholePass) optimizeCallSaves(lines []string) []string {
+	removed := make(map[int]bool)
+	for i, line := range lines {
+		push, ok := parseAsmInstruction(line)
+		if !ok || push.mnemonic != "PUSH" || len(push.operands) != 1 || isStackArgument(push) {
+			continue
+		}
+		pair := push.operands[0]
+		mask := operandRegs(pair)
+		if mask == 0 {
+			continue
+		}
+
+		callLine, call := nextInstruction(lines, i)
+		if callLine < 0 || call.mnemonic != "CALL" || len(call.operands) != 1 {
+			continue // Only unconditional calls always reach the POP
+		}
+		popLine, pop := nextInstruction(lines, callLine)
+		if popLine < 0 || pop.mnemonic != "POP" || len(pop.operands) != 1 || pop.operands[0] != pair {
+			continue
+		}
+		if !regsDeadAfter(lines, popLine, mask) || pair == "AF" && !flagsDeadAfter(lines, popLine, flagsAll) {
+			continue
+		}
+
+		lines[i] = push.indent + "; Eliminated PUSH/POP " + pair + " around CALL (" + pair + " dead after the call)"
+		removed[popLine] = true
+		p.optimizationsCount++
+	}
+
+	if len(removed) == 0 {
+		return lines
+	}
+	kept := lines[:0]
+	for i, line := range lines {
+		if !removed[i] {
+			kept = append(kept, line)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,301 @@
+package optimizer
+
+import (
+	"strings"
+)
+
+// Z80 flags tracked by the flag-aware peepholes
+const (
+	flagS = 1 << iota
+	flagZ
+	flagH
+	flagPV
+	flagN
+	flagC
+
+	flagsAll = flagS | flagZ | flagH | flagPV | flagN | flagC
+)
+
+// flagScanLimit bounds how many instructions flag liveness checks; anything
+// undecided after that many is treated as live
+const flagScanLimit = 32
+
+// asmInstruction is an instruction line split into mnemonic and operands
+type asmInstruction struct {
+	indent   string
+	mnemonic string
+	operands []string
+	comment  string
+}
+
+// parseAsmInstruction parses a line holding a single instruction. Labels,
+// directives, blank and comment-only lines return ok = false, as does a
+// line with a label before the instruction.
+func parseAsmInstruction(line string) (asmInstruction, bool) {
+	code, comment := splitAsmComment(line)
+	trimmed := strings.TrimSpace(code)
+	if trimmed == "" || strings.Contains(trimmed, ":") || !strings.HasPrefix(code, " ") && !strings.HasPrefix(code, "\t") {
+		return asmInstruction{}, false
+	}
+
+	inst := asmInstruction{
+		indent:  code[:len(code)-len(strings.TrimLeft(code, " \t"))],
+		comment: comment,
+	}
+	fields := strings.SplitN(trimmed, " ", 2)
+	inst.mnemonic = strings.ToUpper(fields[0])
+	if len(fields) == 2 {
+		for _, op := range strings.Split(fields[1], ",") {
+			inst.operands = append(inst.operands, strings.ToUpper(strings.TrimSpace(op)))
+		}
+	}
+	return inst, true
+}
+
+// splitAsmComment splits a line at the first ';' outside a quoted string
+func splitAsmComment(line string) (string, string) {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ';':
+			return line[:i], strings.TrimSpace(line[i:])
+		}
+	}
+	return line, ""
+}
+
+// isLabelLine reports whether a line only defines a label
+func isLabelLine(line string) bool {
+	code, _ := splitAsmComment(line)
+	code = strings.TrimSpace(code)
+	return strings.HasSuffix(code, ":") && !strings.ContainsAny(code, " \t")
+}
+
+// conditionFlag returns the flag a branch condition tests
+func conditionFlag(cond string) (int, bool) {
+	switch cond {
+	case "Z", "NZ":
+		return flagZ, true
+	case "C", "NC":
+		return flagC, true
+	case "PO", "PE":
+		return flagPV, true
+	case "P", "M":
+		return flagS, true
+	}
+	return 0, false
+}
+
+// flagEffect returns the flags an instruction reads and the flags it fully
+// defines. branches is true when control may leave the straight-line path.
+// known is false for instructions whose flag behavior is not modeled.
+func flagEffect(inst asmInstruction) (reads, writes int, branches, known bool) {
+	ops := inst.operands
+	is8bit := func() bool {
+		// 8-bit INC/DEC: a single register or (HL)/(IX+d)/(IY+d) operand
+		return len(ops) == 1 && (len(ops[0]) == 1 || strings.HasPrefix(ops[0], "("))
+	}
+
+	switch inst.mnemonic {
+	case "LD", "NOP", "DI", "EI", "EXX", "OUT", "IM":
+		return 0, 0, false, true
+	case "PUSH":
+		if len(ops) == 1 && ops[0] == "AF" {
+			return flagsAll, 0, false, true
+		}
+		return 0, 0, false, true
+	case "POP":
+		if len(ops) == 1 && ops[0] == "AF" {
+			return 0, flagsAll, false, true
+		}
+		return 0, 0, false, true
+	case "EX":
+		if len(ops) == 2 && ops[0] == "AF" {
+			return flagsAll, flagsAll, false, true
+		}
+		return 0, 0, false, true
+
+	case "ADD":
+		if len(ops) == 2 && ops[0] != "A" {
+			return 0, flagH | flagN | flagC, false, true // 16-bit add
+		}
+		return 0, flagsAll, false, true
+	case "ADC", "SBC":
+		return flagC, flagsAll, false, true
+	case "SUB", "AND", "OR", "XOR", "CP", "NEG":
+		return 0, flagsAll, false, true
+	case "INC", "DEC":
+		if is8bit() {
+			return 0, flagS | flagZ | flagH | flagPV | flagN, false, true
+		}
+		return 0, 0, false, true
+	case "RLCA", "RRCA":
+		return 0, flagH | flagN | flagC, false, true
+	case "RLA", "RRA":
+		return flagC, flagH | flagN | flagC, false, true
+	case "RLC", "RRC", "SLA", "SRA", "SRL", "SLL":
+		return 0, flagsAll, false, true
+	case "RL", "RR":
+		return flagC, flagsAll, false, true
+	case "BIT":
+		return 0, flagZ | flagH | flagN, false, true
+	case "SET", "RES":
+		return 0, 0, false, true
+	case "SCF":
+		return 0, flagH | flagN | flagC, false, true
+	case "CCF":
+		return flagC, flagN | flagC, false, true
+	case "CPL":
+		return 0, flagH | flagN, false, true
+	case "DAA":
+		return flagH | flagN | flagC, flagS | flagZ | flagH | flagPV | flagC, false, true
+
+	case "JP", "JR", "CALL", "RET":
+		if len(ops) > 0 {
+			if flag, ok := conditionFlag(ops[0]); ok && (len(ops) == 2 || inst.mnemonic == "RET") {
+				return flag, 0, true, true
+			}
+		}
+		return 0, 0, true, true
+	case "DJNZ", "RETI", "RETN", "RST", "HALT":
+		return 0, 0, true, true
+	}
+	return 0, 0, false, false
+}
+
+// flagsDeadAfter reports whether none of the given flags can be observed
+// after line i: on every path, each flag in mask is redefined before it is
+// read. Jumps to labels in lines are followed; calls, returns and indirect
+// jumps leave the listing and count as reading every flag.
+func flagsDeadAfter(lines []string, i int, mask int) bool {
+	budget := flagScanLimit
+	return deadFrom(lines, asmLabels(lines), i+1, mask, flagEffect, map[int]bool{}, &budget)
+}
+
+// asmLabels maps each label defined in lines to its line index
+func asmLabels(lines []string) map[string]int {
+	labels := make(map[string]int)
+	for j, line := range lines {
+		if isLabelLine(line) {
+			code, _ := splitAsmComment(line)
+			labels[strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(code), ":"))] = j
+		}
+	}
+	return labels
+}
+
+// asmEffect describes what an instruction does to the values a liveness
+// scan tracks; flagEffect and regEffect are the two models
+type asmEffect func(inst asmInstruction) (reads, writes int, branches, known bool)
+
+// deadFrom scans one path from line start. A line already reached with the
+// same mask is being checked elsewhere, which also cuts off loops.
+func deadFrom(lines []string, labels map[string]int, start, mask int, effect asmEffect, visited map[int]bool, budget *int) bool {
+	for j := start; j < len(lines); j++ {
+		key := j<<16 | mask
+		if visited[key] {
+			return true
+		}
+		visited[key] = true
+
+		inst, ok := parseAsmInstruction(lines[j])
+		if !ok {
+			code, _ := splitAsmComment(lines[j])
+			if strings.TrimSpace(code) == "" || isLabelLine(lines[j]) {
+				continue // Falling through a label keeps the values
+			}
+			return false
+		}
+		if *budget--; *budget < 0 {
+			return false
+		}
+
+		reads, writes, branches, known := effect(inst)
+		if !known || reads&mask != 0 {
+			return false
+		}
+		if branches {
+			if len(inst.operands) == 0 || inst.mnemonic != "JP" && inst.mnemonic != "JR" && inst.mnemonic != "DJNZ" {
+				return false
+			}
+			target, ok := labels[inst.operands[len(inst.operands)-1]]
+			if !ok {
+				return false
+			}
+			if !deadFrom(lines, labels, target, mask, effect, visited, budget) {
+				return false
+			}
+			if len(inst.operands) == 1 && inst.mnemonic != "DJNZ" {
+				return true // Unconditional jump: no fall-through path
+			}
+			continue
+		}
+		if mask &^= writes; mask == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// isPatchAnchor reports whether line i directly follows a label. SMC
+// anchors (x$immOP:, smc_N:) label an instruction whose immediate is
+// patched at run time, so the instruction must keep its encoding.
+func isPatchAnchor(lines []string, i int) bool {
+	for j := i - 1; j >= 0; j-- {
+		code, _ := splitAsmComment(lines[j])
+		if strings.TrimSpace(code) == "" {
+			continue
+		}
+		return isLabelLine(lines[j])
+	}
+	return false
+}
+
+// isZeroOperand reports whether an immediate operand is zero
+func isZeroOperand(op string) bool {
+	switch op {
+	case "0", "00", "$0", "$00", "0X0", "0X00", "#0", "#00", "0H", "00H":
+		return true
+	}
+	return false
+}
+
+// optimizeZeroIdioms rewrites LD A,0 to XOR A and CP 0 to OR A where the
+// flags the replacement changes are dead. XOR A sets every flag, while
+// OR A and CP 0 differ only in P/V and N.
+func (p *AssemblyPeepholePass) optimizeZeroIdioms(lines []string) []string {
+	for i, line := range lines {
+		inst, ok := parseAsmInstruction(line)
+		if !ok {
+			continue
+		}
+
+		var replacement, was string
+		var changed int
+		switch {
+		case inst.mnemonic == "LD" && len(inst.operands) == 2 && inst.operands[0] == "A" && isZeroOperand(inst.operands[1]):
+			replacement, was, changed = "XOR A", "LD A,0", flagsAll
+		case inst.mnemonic == "CP" && len(inst.operands) == 1 && isZeroOperand(inst.operands[0]):
+			replacement, was, changed = "OR A", "CP 0", flagPV|flagN
+		default:
+			continue
+		}
+		if isPatchAnchor(lines, i) || !flagsDeadAfter(lines, i, changed) {
+			continue
+		}
+
+		comment := inst.comment
+		if comment == "" {
+			comment = "; Optimized: " + was + " -> " + replacement
+		}
+		lines[i] = inst.indent + replacement + "        " + comment
+		p.optimizationsCount++
+	}
+	return lines
+}
@@ -0,0 +1,154 @@
+package optimizer
+
+import (
+	"strings"
+)
+
+// knownFlags is the flag state a forward scan has proven at a line. Only H,
+// N and C are tracked by value; S, Z and P/V depend on results, so they are
+// only known to match OR A of the current accumulator.
+type knownFlags struct {
+	mask   int  // Flags among H, N and C whose value is known
+	values int  // Their values
+	logicA bool // S, Z and P/V are what OR A would set for the current A
+	aZero  bool // A is known to be zero
+}
+
+// flagSetup is what a flag-setting instruction leaves in the flags
+type flagSetup struct {
+	values int  // Values of H, N and C
+	logicA bool // Also sets S, Z and P/V from A (OR A, AND A, XOR A)
+	aZero  bool // Also needs A to be zero already (XOR A)
+}
+
+// flagSetups lists the instructions eliminated when the flags they set are
+// already in place. CCF always changes carry, so it is tracked but never
+// redundant.
+var flagSetups = map[string]flagSetup{
+	"OR A":  {values: 0, logicA: true},
+	"AND A": {values: flagH, logicA: true},
+	"XOR A": {values: 0, logicA: true, aZero: true},
+	"SCF":   {values: flagC},
+}
+
+// step advances the state over an instruction
+func (s *knownFlags) step(inst asmInstruction) {
+	_, writes, branches, known := flagEffect(inst)
+	_, regWrites, _, regKnown := regEffect(inst)
+	if !known || !regKnown || branches || inst.mnemonic == "EX" {
+		*s = knownFlags{} // Control leaves, or A and the flags are swapped
+		return
+	}
+
+	aZero := s.aZero
+	carry, carryKnown := s.values&flagC, s.mask&flagC != 0
+	if regWrites&regA != 0 {
+		s.logicA, s.aZero = false, false
+	}
+	s.mask &^= writes
+	if writes&(flagS|flagZ|flagPV) != 0 {
+		s.logicA = false
+	}
+
+	set := func(flag int, on bool) {
+		s.mask |= flag
+		s.values &^= flag
+		if on {
+			s.values |= flag
+		}
+	}
+	switch inst.mnemonic {
+	case "OR", "XOR", "AND":
+		if len(inst.operands) != 1 {
+			return
+		}
+		set(flagH, inst.mnemonic == "AND")
+		set(flagN, false)
+		set(flagC, false)
+		s.logicA = true
+		if inst.operands[0] == "A" {
+			// OR A and AND A leave A alone; XOR A clears it
+			s.aZero = aZero || inst.mnemonic == "XOR"
+		}
+	case "SCF":
+		set(flagH, false)
+		set(flagN, false)
+		set(flagC, true)
+	case "CCF":
+		set(flagN, false)
+		if carryKnown {
+			set(flagH, carry != 0)
+			set(flagC, carry == 0)
+		}
+	case "CPL":
+		set(flagH, true)
+		set(flagN, true)
+	case "INC", "DEC":
+		if writes&flagN != 0 {
+			set(flagN, inst.mnemonic == "DEC")
+		}
+	}
+}
+
+// redundantFlags returns the flags an instruction from flagSetups would
+// change from the known state; ok is false when it must stay regardless
+func (s *knownFlags) redundantFlags(setup flagSetup) (diff int, ok bool) {
+	if s.mask&flagC == 0 || s.values&flagC != setup.values&flagC {
+		return 0, false // Carry is the point of the setup and must be known
+	}
+	if setup.aZero && !s.aZero {
+		return 0, false
+	}
+	for _, flag := range []int{flagH, flagN} {
+		if s.mask&flag == 0 || s.values&flag != setup.values&flag {
+			diff |= flag
+		}
+	}
+	if setup.logicA && !s.logicA {
+		diff |= flagS | flagZ | flagPV
+	}
+	return diff, true
+}
+
+// optimizeKnownFlags removes OR A, AND A, XOR A and SCF when carry already
+// holds the value they set and their other flag changes are either already
+// in place or dead, e.g. the OR A before SBC HL,DE right after an AND.
+// The state is only tracked along straight-line code: labels, jumps, calls
+// and anything not modeled forget everything.
+func (p *AssemblyPeepholePass) optimizeKnownFlags(lines []string) []string {
+	var state knownFlags
+	for i, line := range lines {
+		inst, ok := parseAsmInstruction(line)
+		if !ok {
+			if code, _ := splitAsmComment(line); strings.TrimSpace(code) != "" {
+				state = knownFlags{}
+			}
+			continue
+		}
+
+		name := inst.mnemonic
+		if len(inst.operands) == 1 {
+			name += " " + inst.operands[0]
+		} else if len(inst.operands) > 1 {
+			name = ""
+		}
+		setup, isSetup := flagSetups[name]
+		if !isSetup {
+			state.step(inst)
+			continue
+		}
+		diff, ok := state.redundantFlags(setup)
+		if !ok || diff != 0 && !flagsDeadAfter(lines, i, diff) {
+			state.step(inst)
+			continue
+		}
+
+		lines[i] = inst.indent + "; Eliminated " + name + " (flags already set)"
+		state.mask &^= diff
+		if diff&(flagS|flagZ|flagPV) != 0 {
+			state.logicA = false
+		}
+		p.optimizationsCount++
+	}
+	return lines
+}
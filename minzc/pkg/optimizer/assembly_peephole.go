@@ -61,13 +61,8 @@ func createAssemblyPeepholePatterns() []AssemblyPeepholePattern {
 			Replacement: "${1}LD A, B    ; Eliminated redundant LD B,A",
 		},
 		
-		// Pattern 2: Load zero optimization
-		{
-			Name:        "load_zero_to_xor",
-			Description: "Replace LD r, 0 with XOR r, r (smaller and faster)",
-			Pattern:     regexp.MustCompile(`(?m)^(\s*)LD\s+(A|B|C|D|E|H|L),\s*0$`),
-			Replacement: "${1}XOR $2, $2    ; Optimized: was LD $2, 0",
-		},
+		// LD A,0 -> XOR A and CP 0 -> OR A change flags, so they are applied
+		// by optimizeZeroIdioms where flag liveness is checked
 		
 		// Pattern 3: Increment optimization
 		{
@@ -243,22 +238,6 @@ func createAssemblyPeepholePatterns() []AssemblyPeepholePattern {
 			Replacement: "${1}INC SP\n${1}INC SP       ; Optimized: drop 2 bytes (was POP $2)",
 		},
 		
-		// Pattern 22: Optimize compare with zero
-		{
-			Name:        "optimize_cp_zero",
-			Description: "Convert CP 0 to OR A for flag setting",
-			Pattern:     regexp.MustCompile(`(?m)^(\s*)CP\s+0$`),
-			Replacement: "${1}OR A         ; Optimized: CP 0 -> OR A",
-		},
-		
-		// Pattern 23: Optimize LD reg,0 to XOR reg (for A only)
-		{
-			Name:        "optimize_ld_a_zero",
-			Description: "Convert LD A,0 to XOR A",
-			Pattern:     regexp.MustCompile(`(?m)^(\s*)LD\s+A,\s*0$`),
-			Replacement: "${1}XOR A        ; Optimized: LD A,0 -> XOR A",
-		},
-		
 		// Pattern 24: Optimize ADD A,1 to INC A
 		{
 			Name:        "optimize_add_a_one",
@@ -386,7 +365,8 @@ func (p *AssemblyPeepholePass) optimizeAssemblyLines(lines []string) []string {
 		}
 	}
 	
-	return strings.Split(assembly, "\n")
+	lines = p.optimizeZeroIdioms(p.optimizeRedundantLoads(strings.Split(assembly, "\n")))
+	return p.optimizeKnownFlags(p.optimizeCallSaves(lines))
 }
 
 // Additional Z80-specific optimizations that could be added:
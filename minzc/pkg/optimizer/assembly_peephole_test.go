@@ -0,0 +1,306 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestZeroIdiomPeephole(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []string
+		want  string // Expected first instruction after optimization
+	}{
+		{
+			name:  "LD A,0 before a flag-setting compare",
+			input: []string{"    LD A, 0", "    LD (var), A", "    LD B, A", "    CP B", "    JR Z, done"},
+			want:  "XOR A",
+		},
+		{
+			name:  "LD A,0 through a fall-through label",
+			input: []string{"    LD A, 0", "loop:", "    OR B", "    RET"},
+			want:  "XOR A",
+		},
+		{
+			name:  "LD A,0 with flags consumed by a conditional jump",
+			input: []string{"    CP 5", "    LD A, 0", "    JR NZ, skip"},
+			want:  "CP 5",
+		},
+		{
+			name:  "LD A,0 with carry consumed by ADC",
+			input: []string{"    LD A, 0", "    ADC A, B"},
+			want:  "LD A, 0",
+		},
+		{
+			name:  "LD A,0 before a return",
+			input: []string{"    LD A, 0", "    RET"},
+			want:  "LD A, 0",
+		},
+		{
+			name:  "LD A,0 as a patched SMC immediate",
+			input: []string{"x$immOP:", "    LD A, 0        ; x anchor (will be patched)", "    OR B", "    RET"},
+			want:  "LD A, 0",
+		},
+		{
+			name:  "CP 0 followed by a zero test",
+			input: []string{"    CP 0", "    JR Z, done", "    INC B", "done:", "    CP 10", "    RET"},
+			want:  "OR A",
+		},
+		{
+			name:  "CP 0 with parity tested at the jump target",
+			input: []string{"    CP 0", "    JR Z, done", "    RET", "done:", "    JP PO, odd", "    RET"},
+			want:  "CP 0",
+		},
+		{
+			name:  "CP 0 followed by a parity test",
+			input: []string{"    CP 0", "    JP PE, done"},
+			want:  "CP 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pass := NewAssemblyPeepholePass()
+			lines := pass.optimizeZeroIdioms(append([]string(nil), tt.input...))
+			var got string
+			for _, line := range lines {
+				if inst, ok := parseAsmInstruction(line); ok && strings.HasPrefix(tt.want, inst.mnemonic) {
+					got = strings.TrimSpace(strings.SplitN(line, ";", 2)[0])
+					break
+				}
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q\n%s", got, tt.want, strings.Join(lines, "\n"))
+			}
+		})
+	}
+
+	// The second LD A,0 is unsafe: the JR NZ after it reads Z
+	out := NewAssemblyPeepholePass().OptimizeAssembly("    LD A, 0\n    OR B\n    LD A, 0\n    JR NZ, skip\n")
+	if strings.Count(out, "    XOR A") != 1 || !strings.Contains(out, "    LD A, 0\n    JR NZ") {
+		t.Errorf("expected exactly the first LD A,0 rewritten:\n%s", out)
+	}
+}
+
+func TestCallSavePeephole(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  []string
+		remove bool // Whether the PUSH/POP pair should be eliminated
+	}{
+		{
+			name:   "HL overwritten after the call",
+			input:  []string{"    PUSH HL", "    CALL print", "    POP HL", "    LD HL, 5", "    RET"},
+			remove: true,
+		},
+		{
+			name:   "DE redefined on both paths of a branch",
+			input:  []string{"    PUSH DE", "    CALL f", "    POP DE", "    OR A", "    JR Z, zero", "    LD DE, 1", "    RET", "zero:", "    POP DE", "    RET"},
+			remove: true,
+		},
+		{
+			name:   "HL used after the call",
+			input:  []string{"    PUSH HL", "    CALL print", "    POP HL", "    LD (result), HL", "    LD HL, 0", "    RET"},
+			remove: false,
+		},
+		{
+			name:   "low byte read through a memory operand",
+			input:  []string{"    PUSH HL", "    CALL print", "    POP HL", "    LD A, (HL)", "    LD HL, 0"},
+			remove: false,
+		},
+		{
+			name:   "BC read at the jump target",
+			input:  []string{"    PUSH BC", "    CALL f", "    POP BC", "    JP next", "    LD BC, 0", "next:", "    LD A, C"},
+			remove: false,
+		},
+		{
+			name:   "register live into a return",
+			input:  []string{"    PUSH HL", "    CALL f", "    POP HL", "    RET"},
+			remove: false,
+		},
+		{
+			name:   "stack argument read by the callee",
+			input:  []string{"    PUSH HL       ; Argument 0", "    CALL f", "    POP HL", "    LD HL, 0"},
+			remove: false,
+		},
+		{
+			name:   "flags restored by POP AF are tested",
+			input:  []string{"    PUSH AF", "    CALL f", "    POP AF", "    LD A, 1", "    JR Z, done"},
+			remove: false,
+		},
+		{
+			name:   "different register popped",
+			input:  []string{"    PUSH HL", "    CALL f", "    POP DE", "    LD DE, 0", "    LD HL, 0"},
+			remove: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pass := NewAssemblyPeepholePass()
+			lines := pass.optimizeCallSaves(append([]string(nil), tt.input...))
+			pushes := 0
+			for _, line := range lines {
+				if inst, ok := parseAsmInstruction(line); ok && inst.mnemonic == "PUSH" {
+					pushes++
+				}
+			}
+			removed := pushes == 0 && len(lines) == len(tt.input)-1
+			if removed != tt.remove {
+				t.Errorf("removed = %v, want %v\n%s", removed, tt.remove, strings.Join(lines, "\n"))
+			}
+		})
+	}
+
+	// Only the first save is dead: HL is stored after the second call
+	out := NewAssemblyPeepholePass().OptimizeAssembly("    PUSH HL\n    CALL f\n    POP HL\n    LD HL, 1\n    PUSH HL\n    CALL g\n    POP HL\n    LD (x), HL\n")
+	if strings.Count(out, "    PUSH HL") != 1 || !strings.Contains(out, "    CALL g\n    POP HL") {
+		t.Errorf("expected exactly the first PUSH/POP HL removed:\n%s", out)
+	}
+}
+
+func TestKnownFlagsPeephole(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  []string
+		remove bool // Whether the flag setup before the last instruction goes
+	}{
+		{
+			name:   "OR A after AND clears carry already",
+			input:  []string{"    AND $0F", "    LD E, A", "    LD D, 0", "    OR A", "    SBC HL, DE"},
+			remove: true,
+		},
+		{
+			name:   "OR A repeated on an unchanged A",
+			input:  []string{"    OR A", "    LD HL, 0", "    OR A", "    JR Z, done"},
+			remove: true,
+		},
+		{
+			name:   "SCF after SCF",
+			input:  []string{"    SCF", "    LD B, 1", "    SCF", "    ADC A, B"},
+			remove: true,
+		},
+		{
+			name:   "XOR A on an A already cleared",
+			input:  []string{"    XOR A", "    LD (count), A", "    XOR A", "    RET"},
+			remove: true,
+		},
+		{
+			name:   "OR A after SUB, carry unknown",
+			input:  []string{"    SUB B", "    LD E, A", "    OR A", "    SBC HL, DE"},
+			remove: false,
+		},
+		{
+			name:   "OR A at a label",
+			input:  []string{"    AND $0F", "loop:", "    OR A", "    SBC HL, DE"},
+			remove: false,
+		},
+		{
+			name:   "OR A after a call",
+			input:  []string{"    OR A", "    CALL f", "    OR A", "    SBC HL, DE"},
+			remove: false,
+		},
+		{
+			name:   "OR A whose zero flag is tested after A changed",
+			input:  []string{"    OR A", "    LD A, (HL)", "    OR A", "    JR Z, done"},
+			remove: false,
+		},
+		{
+			name:   "XOR A after A was reloaded",
+			input:  []string{"    XOR A", "    LD A, B", "    XOR A", "    RET"},
+			remove: false,
+		},
+		{
+			name:   "OR A after CCF flipped a clear carry",
+			input:  []string{"    OR A", "    CCF", "    OR A", "    SBC HL, DE"},
+			remove: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pass := NewAssemblyPeepholePass()
+			lines := pass.optimizeKnownFlags(append([]string(nil), tt.input...))
+			last := len(lines) - 2
+			removed := strings.Contains(lines[last], "; Eliminated")
+			if removed != tt.remove {
+				t.Errorf("removed = %v, want %v\n%s", removed, tt.remove, strings.Join(lines, "\n"))
+			}
+			for i := 0; i < last; i++ {
+				if lines[i] != tt.input[i] {
+					t.Errorf("line %d changed: %q\n%s", i, lines[i], strings.Join(lines, "\n"))
+				}
+			}
+		})
+	}
+}
+
+func TestRedundantLoadPeephole(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []string
+		want  []string // Instructions left, without comments
+	}{
+		{
+			name:  "store then reload",
+			input: []string{"    LD ($F002), A    ; Virtual register 1 to memory", "    LD A, ($F002)    ; Virtual register 1 from memory", "    RET"},
+			want:  []string{"LD ($F002), A", "RET"},
+		},
+		{
+			name:  "16-bit store then reload across a comment",
+			input: []string{"    LD ($F004), HL", "    ; r2 = r1", "    LD HL, ($F004)"},
+			want:  []string{"LD ($F004), HL"},
+		},
+		{
+			name:  "reload into another register",
+			input: []string{"    LD (HL), A", "    LD E, (HL)"},
+			want:  []string{"LD (HL), A", "LD E, A"},
+		},
+		{
+			name:  "reload from another address",
+			input: []string{"    LD ($F002), A", "    LD A, ($F003)"},
+			want:  []string{"LD ($F002), A", "LD A, ($F003)"},
+		},
+		{
+			name:  "label between store and reload",
+			input: []string{"    LD ($F002), A", "loop:", "    LD A, ($F002)"},
+			want:  []string{"LD ($F002), A", "LD A, ($F002)"},
+		},
+		{
+			name:  "PUSH and POP of the same pair",
+			input: []string{"    PUSH HL", "    POP HL", "    RET"},
+			want:  []string{"RET"},
+		},
+		{
+			name:  "PUSH and POP of different pairs",
+			input: []string{"    PUSH HL", "    POP DE"},
+			want:  []string{"LD D, H", "LD E, L"},
+		},
+		{
+			name:  "PUSH IX and POP HL stay",
+			input: []string{"    PUSH IX", "    POP HL"},
+			want:  []string{"PUSH IX", "POP HL"},
+		},
+		{
+			name:  "move undone by the next move",
+			input: []string{"    LD B, A", "    LD A, B", "    LD A, A"},
+			want:  []string{"LD B, A"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pass := NewAssemblyPeepholePass()
+			lines := pass.optimizeRedundantLoads(append([]string(nil), tt.input...))
+			var got []string
+			for _, line := range lines {
+				if _, ok := parseAsmInstruction(line); ok {
+					got = append(got, strings.TrimSpace(strings.SplitN(line, ";", 2)[0]))
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package optimizer
+
+// Redundant moves left behind by the code generator, which stores each
+// virtual register to memory and loads it back on the next use:
+//
+//	LD ($F002), A / LD A, ($F002)   -> LD ($F002), A
+//	LD (HL), A / LD E, (HL)         -> LD (HL), A / LD E, A
+//	PUSH HL / POP HL                -> (nothing)
+//	PUSH HL / POP DE                -> LD D, H / LD E, L
+//	LD B, A / LD A, B               -> LD B, A
+//	LD A, A                         -> (nothing)
+//
+// None of these change flags and each pair must be adjacent apart from
+// blank and comment lines, so a label in between (a jump into the middle)
+// keeps both.
+
+// byteRegs are the 8-bit registers a reload can be turned into a move from
+var byteRegs = map[string]bool{"A": true, "B": true, "C": true, "D": true, "E": true, "H": true, "L": true}
+
+// pairHalves splits the register pairs that PUSH/POP can be replaced for
+var pairHalves = map[string][2]string{
+	"BC": {"B", "C"},
+	"DE": {"D", "E"},
+	"HL": {"H", "L"},
+}
+
+// optimizeRedundantLoads removes stores that are immediately reloaded,
+// PUSH/POP pairs that restore what they saved and register moves that undo
+// the previous one, and turns PUSH rr / POP ss into two register moves
+func (p *AssemblyPeepholePass) optimizeRedundantLoads(lines []string) []string {
+	removed := make(map[int]bool)
+	for i := 0; i < len(lines); i++ {
+		if removed[i] {
+			continue
+		}
+		first, ok := parseAsmInstruction(lines[i])
+		if !ok {
+			continue
+		}
+
+		// LD r, r does nothing
+		if first.mnemonic == "LD" && len(first.operands) == 2 && byteRegs[first.operands[0]] && first.operands[0] == first.operands[1] {
+			removed[i] = true
+			p.optimizationsCount++
+			continue
+		}
+
+		j, second := nextInstruction(lines, i)
+		if j < 0 {
+			continue
+		}
+
+		switch {
+		case first.mnemonic == "PUSH" && second.mnemonic == "POP" && len(first.operands) == 1 && len(second.operands) == 1:
+			from, to := first.operands[0], second.operands[0]
+			if from == to {
+				lines[i] = first.indent + "; Eliminated PUSH/POP " + from
+				removed[j] = true
+				p.optimizationsCount++
+				continue
+			}
+			src, okFrom := pairHalves[from]
+			dst, okTo := pairHalves[to]
+			if okFrom && okTo {
+				lines[i] = first.indent + "LD " + dst[0] + ", " + src[0] + "    ; Was PUSH " + from + " / POP " + to
+				lines[j] = second.indent + "LD " + dst[1] + ", " + src[1]
+				p.optimizationsCount++
+			}
+
+		case first.mnemonic == "LD" && second.mnemonic == "LD" && len(first.operands) == 2 && len(second.operands) == 2:
+			store, value := first.operands[0], first.operands[1]
+			reg, load := second.operands[0], second.operands[1]
+			switch {
+			case isMemoryOperand(store) && load == store && reg == value:
+				// Reloading the value just stored
+				removed[j] = true
+				p.optimizationsCount++
+			case isMemoryOperand(store) && load == store && byteRegs[reg] && byteRegs[value]:
+				// Reloading it into another register: copy the register instead
+				lines[j] = second.indent + "LD " + reg + ", " + value + "    ; Was LD " + reg + ", " + load
+				p.optimizationsCount++
+			case byteRegs[store] && byteRegs[value] && reg == value && load == store:
+				// LD B, A / LD A, B: A already holds B
+				removed[j] = true
+				p.optimizationsCount++
+			}
+		}
+	}
+
+	if len(removed) == 0 {
+		return lines
+	}
+	kept := lines[:0]
+	for i, line := range lines {
+		if !removed[i] {
+			kept = append(kept, line)
+		}
+	}
+	return kept
+}
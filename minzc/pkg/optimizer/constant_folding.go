@@ -56,11 +56,12 @@ func (p *ConstantFoldingPass) optimizeFunction(fn *ir.Function) bool {
 			if val1, ok1 := p.constants[inst.Src1]; ok1 {
 				if val2, ok2 := p.constants[inst.Src2]; ok2 {
 					// Both operands are constants - fold them
-					result := p.foldBinaryOp(inst.Op, val1, val2)
+					result := p.foldBinaryOp(inst.Op, val1, val2, inst.Type)
 					newInst := ir.Instruction{
 						Op:   ir.OpLoadConst,
 						Dest: inst.Dest,
 						Imm:  result,
+						Type: inst.Type,
 						Comment: "Folded: " + inst.Comment,
 					}
 					newInstructions = append(newInstructions, newInst)
@@ -162,14 +163,14 @@ func (p *ConstantFoldingPass) optimizeFunction(fn *ir.Function) bool {
 }
 
 // foldBinaryOp performs constant folding for binary operations
-func (p *ConstantFoldingPass) foldBinaryOp(op ir.Opcode, val1, val2 int64) int64 {
+func (p *ConstantFoldingPass) foldBinaryOp(op ir.Opcode, val1, val2 int64, typ ir.Type) int64 {
 	switch op {
 	case ir.OpAdd:
 		return val1 + val2
 	case ir.OpSub:
 		return val1 - val2
 	case ir.OpMul:
-		return val1 * val2
+		return ir.FixedMul(val1, val2, typ)
 	case ir.OpDiv:
 		if val2 != 0 {
 			return val1 / val2
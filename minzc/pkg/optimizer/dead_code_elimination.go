@@ -51,8 +51,9 @@ func (p *DeadCodeEliminationPass) optimizeFunction(fn *ir.Function) bool {
 	for _, inst := range fn.Instructions {
 		keep := true
 		
-		// Skip instructions after unconditional jump/return until next label
-		if afterUnreachable && inst.Op != ir.OpLabel {
+		// Skip instructions after unconditional jump/return until next label.
+		// Source annotations are kept so begin/end brackets stay paired.
+		if afterUnreachable && inst.Op != ir.OpLabel && inst.Op != ir.OpSourceMark {
 			keep = false
 			changed = true
 		}
@@ -75,7 +76,7 @@ func (p *DeadCodeEliminationPass) optimizeFunction(fn *ir.Function) bool {
 		case ir.OpLoadConst, ir.OpLoadVar, ir.OpLoadField,
 			 ir.OpAdd, ir.OpSub, ir.OpMul, ir.OpDiv, ir.OpMod,
 			 ir.OpAnd, ir.OpOr, ir.OpXor, ir.OpShl, ir.OpShr,
-			 ir.OpNeg, ir.OpNot,
+			 ir.OpRotl, ir.OpRotr, ir.OpNeg, ir.OpNot,
 			 ir.OpEq, ir.OpNe, ir.OpLt, ir.OpGt, ir.OpLe, ir.OpGe,
 			 ir.OpAlloc:
 			// Remove instructions whose results are never used
@@ -138,61 +139,84 @@ func (p *DeadCodeEliminationPass) markUsedRegisters(fn *ir.Function) {
 		p.used[ir.Register(i+1)] = true
 	}
 	
-	// Mark registers used in instructions
-	for _, inst := range fn.Instructions {
-		switch inst.Op {
-		case ir.OpReturn:
-			if inst.Src1 != 0 {
-				p.used[inst.Src1] = true
-			}
-			
-		case ir.OpStoreVar, ir.OpStoreField:
-			if inst.Src1 != 0 {
-				p.used[inst.Src1] = true
-			}
-			if inst.Src2 != 0 {
-				p.used[inst.Src2] = true
-			}
-			
-		case ir.OpAdd, ir.OpSub, ir.OpMul, ir.OpDiv, ir.OpMod,
-			 ir.OpAnd, ir.OpOr, ir.OpXor, ir.OpShl, ir.OpShr,
-			 ir.OpEq, ir.OpNe, ir.OpLt, ir.OpGt, ir.OpLe, ir.OpGe:
-			if inst.Src1 != 0 {
-				p.used[inst.Src1] = true
-			}
-			if inst.Src2 != 0 {
-				p.used[inst.Src2] = true
-			}
-			
-		case ir.OpNeg, ir.OpNot, ir.OpLoadVar, ir.OpLoadField:
-			if inst.Src1 != 0 {
-				p.used[inst.Src1] = true
-			}
-			
-		case ir.OpJumpIfNot:
-			if inst.Src1 != 0 {
-				p.used[inst.Src1] = true
+	// Mark registers used in instructions. A value can be read before the
+	// instruction that computes it, as in a loop, so repeat until nothing
+	// new is marked.
+	for {
+		marked := len(p.used)
+		for _, inst := range fn.Instructions {
+			switch inst.Op {
+			case ir.OpReturn:
+				if inst.Src1 != 0 {
+					p.used[inst.Src1] = true
+				}
+				
+			case ir.OpStoreVar, ir.OpStoreField, ir.OpFormat:
+				if inst.Src1 != 0 {
+					p.used[inst.Src1] = true
+				}
+				if inst.Src2 != 0 {
+					p.used[inst.Src2] = true
+				}
+				
+			case ir.OpAdd, ir.OpSub, ir.OpMul, ir.OpDiv, ir.OpMod,
+				 ir.OpAnd, ir.OpOr, ir.OpXor, ir.OpShl, ir.OpShr, ir.OpRotl, ir.OpRotr,
+				 ir.OpEq, ir.OpNe, ir.OpLt, ir.OpGt, ir.OpLe, ir.OpGe:
+				if inst.Src1 != 0 {
+					p.used[inst.Src1] = true
+				}
+				if inst.Src2 != 0 {
+					p.used[inst.Src2] = true
+				}
+				
+			case ir.OpStoreIndex:
+				for _, r := range []ir.Register{inst.Src1, inst.Src2, inst.Src3} {
+					if r != 0 {
+						p.used[r] = true
+					}
+				}
+				
+			case ir.OpStrCall:
+				// str_copy and str_cat write their destination
+				for _, r := range append([]ir.Register{inst.Src1, inst.Src2}, inst.Args...) {
+					if r != 0 {
+						p.used[r] = true
+					}
+				}
+				
+			case ir.OpNeg, ir.OpNot, ir.OpLoadVar, ir.OpLoadField:
+				if inst.Src1 != 0 {
+					p.used[inst.Src1] = true
+				}
+				
+			case ir.OpJumpIfNot, ir.OpJumpIf, ir.OpJumpIfZero, ir.OpJumpIfNotZero, ir.OpDJNZ:
+				if inst.Src1 != 0 {
+					p.used[inst.Src1] = true
+				}
+				
+			case ir.OpCall:
+				// Mark all argument registers as used
+				// TODO: Track actual arguments
+				if inst.Src1 != 0 {
+					p.used[inst.Src1] = true
+				}
+				if inst.Src2 != 0 {
+					p.used[inst.Src2] = true
+				}
 			}
 			
-		case ir.OpCall:
-			// Mark all argument registers as used
-			// TODO: Track actual arguments
-			if inst.Src1 != 0 {
-				p.used[inst.Src1] = true
-			}
-			if inst.Src2 != 0 {
-				p.used[inst.Src2] = true
+			// If this instruction's result is used, mark its operands as used too
+			if inst.Dest != 0 && p.used[inst.Dest] {
+				if inst.Src1 != 0 {
+					p.used[inst.Src1] = true
+				}
+				if inst.Src2 != 0 {
+					p.used[inst.Src2] = true
+				}
 			}
 		}
-		
-		// If this instruction's result is used, mark its operands as used too
-		if inst.Dest != 0 && p.used[inst.Dest] {
-			if inst.Src1 != 0 {
-				p.used[inst.Src1] = true
-			}
-			if inst.Src2 != 0 {
-				p.used[inst.Src2] = true
-			}
+		if len(p.used) == marked {
+			break
 		}
 	}
 }
@@ -202,11 +226,8 @@ func (p *DeadCodeEliminationPass) markReferencedLabels(fn *ir.Function) {
 	p.labelRefs = make(map[string]bool)
 	
 	for _, inst := range fn.Instructions {
-		switch inst.Op {
-		case ir.OpJump, ir.OpJumpIfNot:
-			if inst.Label != "" {
-				p.labelRefs[inst.Label] = true
-			}
+		if inst.IsBranch() && inst.Label != "" {
+			p.labelRefs[inst.Label] = true
 		}
 	}
-}
\ No newline at end of file
+}
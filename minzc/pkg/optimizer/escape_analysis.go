@@ -0,0 +1,496 @@
+package optimizer
+
+import (
+	"sort"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// Escape analysis keeps locals out of memory, and out of each other's way.
+//
+// ScalarReplacementPass splits a small struct local whose address never
+// leaves the function into one local per field: `let p = Point{x: 1, y: 2}`
+// followed by p.x and p.y becomes the locals p.x and p.y, with no struct
+// allocated at all.
+//
+// FrameLayoutPass gives each local a frame offset. A local is live from its
+// first reference to its last, stretched over any loop it is referenced in;
+// a composite whose address is passed on, or a local whose address is taken
+// and not only dereferenced on the spot, is live over the whole function.
+// Locals whose live ranges do not overlap share the same bytes, so a
+// function's frame is as large as the locals live at once, not all of them.
+
+// maxScalarReplacedStruct is the largest struct, in bytes, that
+// ScalarReplacementPass splits into its fields
+const maxScalarReplacedStruct = 4
+
+// ScalarReplacementPass replaces small non-escaping struct locals by a
+// local per field
+type ScalarReplacementPass struct{}
+
+// NewScalarReplacementPass creates a new scalar replacement pass
+func NewScalarReplacementPass() Pass {
+	return &ScalarReplacementPass{}
+}
+
+// Name returns the name of this pass
+func (p *ScalarReplacementPass) Name() string {
+	return "Scalar Replacement"
+}
+
+// Run splits the struct locals of every function that it can
+func (p *ScalarReplacementPass) Run(module *ir.Module) (bool, error) {
+	changed := false
+	for _, fn := range module.Functions {
+		for p.replaceOne(fn) {
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// structField is a field of a struct being replaced
+type structField struct {
+	name string
+	typ  ir.Type
+	reg  ir.Register // Register of the local that replaces it
+}
+
+// replaceOne splits one struct local of fn and reports whether it did
+func (p *ScalarReplacementPass) replaceOne(fn *ir.Function) bool {
+	uses := registerUses(fn)
+	for i := range fn.Instructions {
+		inst := &fn.Instructions[i]
+		if inst.Op != ir.OpAlloc {
+			continue
+		}
+		st, ok := inst.Type.(*ir.StructType)
+		if !ok {
+			continue
+		}
+		fields, ok := splittableFields(st)
+		if !ok {
+			continue
+		}
+		if name, drop, ok := p.candidate(fn, i, uses); ok {
+			p.replace(fn, name, fields, drop)
+			return true
+		}
+	}
+	return false
+}
+
+// splittableFields returns the fields of st by offset, if st is small
+// enough to split and each field fits a register
+func splittableFields(st *ir.StructType) (map[int64]*structField, bool) {
+	if st.Size() == 0 || st.Size() > maxScalarReplacedStruct {
+		return nil, false
+	}
+	fields := make(map[int64]*structField)
+	offset := int64(0)
+	for _, name := range st.FieldOrder {
+		typ := st.Fields[name]
+		if _, basic := typ.(*ir.BasicType); !basic || typ.Size() > 2 {
+			return nil, false
+		}
+		fields[offset] = &structField{name: name, typ: typ}
+		offset += int64(typ.Size())
+	}
+	return fields, true
+}
+
+// candidate checks that the struct allocated at alloc is only stored to a
+// single local and used through field accesses. It returns the local's
+// name and the instructions the replacement drops: the allocation, the
+// store to the local and the loads of it.
+func (p *ScalarReplacementPass) candidate(fn *ir.Function, alloc int, uses map[ir.Register][]int) (string, map[int]bool, bool) {
+	reg := fn.Instructions[alloc].Dest
+	drop := map[int]bool{alloc: true}
+	name := ""
+	for _, u := range uses[reg] {
+		use := &fn.Instructions[u]
+		switch {
+		case isFieldAccess(use, reg):
+		case use.Op == ir.OpStoreVar && use.Symbol != "" && use.Src1 == reg && name == "":
+			name = use.Symbol
+			drop[u] = true
+		default:
+			return "", nil, false
+		}
+	}
+	if name == "" {
+		return "", nil, false
+	}
+
+	var local *ir.Local
+	for i := range fn.Locals {
+		if fn.Locals[i].Name == name {
+			if local != nil {
+				return "", nil, false // Shadowed: two locals share the name
+			}
+			local = &fn.Locals[i]
+		}
+	}
+	if local == nil || len(uses[local.Reg]) > 0 {
+		return "", nil, false
+	}
+	for i := range fn.Instructions {
+		inst := &fn.Instructions[i]
+		if inst.Symbol != name || drop[i] {
+			continue
+		}
+		if inst.Op != ir.OpLoadVar {
+			return "", nil, false
+		}
+		for _, u := range uses[inst.Dest] {
+			if !isFieldAccess(&fn.Instructions[u], inst.Dest) {
+				return "", nil, false
+			}
+		}
+		drop[i] = true
+	}
+	return name, drop, true
+}
+
+// isFieldAccess reports whether inst loads or stores a field of the struct
+// at reg, without using reg in any other way
+func isFieldAccess(inst *ir.Instruction, reg ir.Register) bool {
+	switch inst.Op {
+	case ir.OpLoadField:
+		return inst.Src1 == reg
+	case ir.OpStoreField:
+		return inst.Src1 == reg && inst.Src2 != reg
+	}
+	return false
+}
+
+// replace rewrites the field accesses of the struct local name to the
+// field locals and drops the instructions in drop
+func (p *ScalarReplacementPass) replace(fn *ir.Function, name string, fields map[int64]*structField, drop map[int]bool) {
+	// The struct's registers: the allocation and the loads of the local
+	structRegs := make(map[ir.Register]bool)
+	for i := range drop {
+		if inst := &fn.Instructions[i]; inst.Op != ir.OpStoreVar {
+			structRegs[inst.Dest] = true
+		}
+	}
+
+	locals := fn.Locals[:0]
+	for _, local := range fn.Locals {
+		if local.Name != name {
+			locals = append(locals, local)
+		}
+	}
+	fn.Locals = locals
+	for _, offset := range sortedOffsets(fields) {
+		field := fields[offset]
+		field.reg = fn.AddLocal(name+"."+field.name, field.typ)
+	}
+
+	insts := make([]ir.Instruction, 0, len(fn.Instructions))
+	for i, inst := range fn.Instructions {
+		if drop[i] {
+			continue
+		}
+		if (inst.Op == ir.OpLoadField || inst.Op == ir.OpStoreField) && structRegs[inst.Src1] {
+			field := fields[inst.Imm]
+			if inst.Op == ir.OpLoadField {
+				inst = ir.Instruction{Op: ir.OpLoadVar, Dest: inst.Dest, Symbol: name + "." + field.name, Type: field.typ, Comment: inst.Comment}
+			} else {
+				inst = ir.Instruction{Op: ir.OpStoreVar, Dest: field.reg, Src1: inst.Src2, Symbol: name + "." + field.name, Type: field.typ, Comment: inst.Comment}
+			}
+		}
+		insts = append(insts, inst)
+	}
+	fn.Instructions = insts
+}
+
+func sortedOffsets(fields map[int64]*structField) []int64 {
+	offsets := make([]int64, 0, len(fields))
+	for offset := range fields {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	return offsets
+}
+
+// registerUses returns, for each register, the indices of the
+// instructions that read it
+func registerUses(fn *ir.Function) map[ir.Register][]int {
+	uses := make(map[ir.Register][]int)
+	for i := range fn.Instructions {
+		for _, reg := range readRegisters(&fn.Instructions[i]) {
+			uses[reg] = append(uses[reg], i)
+		}
+	}
+	return uses
+}
+
+// readRegisters returns the registers inst reads
+func readRegisters(inst *ir.Instruction) []ir.Register {
+	var regs []ir.Register
+	for _, reg := range []ir.Register{inst.Src1, inst.Src2, inst.Src3} {
+		if reg != 0 {
+			regs = append(regs, reg)
+		}
+	}
+	regs = append(regs, inst.Args...)
+	if inst.Dest != 0 && (inst.Op == ir.OpStoreDirect || inst.Op == ir.OpStoreVar && inst.Symbol == "") {
+		// The target address, or the local stored to
+		regs = append(regs, inst.Dest)
+	}
+	return regs
+}
+
+// FrameLayoutPass assigns frame offsets to locals, sharing bytes between
+// locals that are never live at the same time. It runs once, after the
+// passes that move instructions around.
+type FrameLayoutPass struct{}
+
+// NewFrameLayoutPass creates a new frame layout pass
+func NewFrameLayoutPass() Pass {
+	return &FrameLayoutPass{}
+}
+
+// Name returns the name of this pass
+func (p *FrameLayoutPass) Name() string {
+	return "Frame Layout"
+}
+
+// Run lays out the frame of every function
+func (p *FrameLayoutPass) Run(module *ir.Module) (bool, error) {
+	parents := make(map[string]bool)
+	for _, fn := range module.Functions {
+		if fn.ParentFunction != "" {
+			parents[fn.ParentFunction] = true
+		}
+	}
+	changed := false
+	for _, fn := range module.Functions {
+		// Local functions reach their parent's locals directly
+		if !parents[fn.Name] && p.layout(fn) {
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// frameSlot is the locals of one name and the instructions they are live
+// over
+type frameSlot struct {
+	name       string
+	size       int
+	start, end int
+	offset     int
+}
+
+// layout assigns offsets to the locals of fn and reports whether any two
+// share bytes
+func (p *FrameLayoutPass) layout(fn *ir.Function) bool {
+	if len(fn.Locals) == 0 {
+		return false
+	}
+	for i := range fn.Instructions {
+		switch fn.Instructions[i].Op {
+		case ir.OpAsm, ir.OpJumpIndirect, ir.OpLoadLabel:
+			return false // Code the MIR cannot see may reach any local
+		}
+	}
+
+	slots := p.liveRanges(fn)
+	sort.SliceStable(slots, func(i, j int) bool { return slots[i].start < slots[j].start })
+	size, total := 0, 0
+	for i, slot := range slots {
+		// First fit among the slots live at the same time
+		offset := 0
+		for moved := true; moved; {
+			moved = false
+			for _, other := range slots[:i] {
+				if other.end >= slot.start && other.start <= slot.end &&
+					offset < other.offset+other.size && other.offset < offset+slot.size {
+					offset = other.offset + other.size
+					moved = true
+				}
+			}
+		}
+		slots[i].offset = offset
+		if offset+slot.size > size {
+			size = offset + slot.size
+		}
+		total += slot.size
+	}
+
+	offsets := make(map[string]int)
+	for _, slot := range slots {
+		offsets[slot.name] = slot.offset
+	}
+	for i := range fn.Locals {
+		fn.Locals[i].Offset = offsets[fn.Locals[i].Name]
+	}
+	fn.FrameSize = size
+	return size < total
+}
+
+// liveRanges returns a slot per local name, live from its first reference
+// to its last
+func (p *FrameLayoutPass) liveRanges(fn *ir.Function) []frameSlot {
+	byName := make(map[string]int)
+	byReg := make(map[ir.Register]int)
+	var slots []frameSlot
+	for _, local := range fn.Locals {
+		i, ok := byName[local.Name]
+		if !ok {
+			i = len(slots)
+			byName[local.Name] = i
+			slots = append(slots, frameSlot{name: local.Name, start: -1})
+		}
+		if size := local.Type.Size(); size > slots[i].size {
+			slots[i].size = size
+		}
+		byReg[local.Reg] = i
+	}
+
+	// Registers holding a local's address, by the slot they point into:
+	// loads of arrays and structs, and the address of any local
+	derived := make(map[ir.Register]int)
+	values := make(map[ir.Register]int)
+	refs := make([][]int, len(slots))
+	escapes := make([]bool, len(slots))
+	for i := range fn.Instructions {
+		inst := &fn.Instructions[i]
+		if inst.Op == ir.OpLoadVar || inst.Op == ir.OpStoreVar {
+			if s, ok := byName[inst.Symbol]; ok {
+				refs[s] = append(refs[s], i)
+				if inst.Op == ir.OpLoadVar && isComposite(fn, inst.Symbol) {
+					derived[inst.Dest] = s
+				} else if inst.Op == ir.OpLoadVar {
+					values[inst.Dest] = s
+				}
+			}
+		}
+		for _, reg := range readRegisters(inst) {
+			if s, ok := byReg[reg]; ok {
+				refs[s] = append(refs[s], i)
+			}
+		}
+		if s, ok := values[inst.Src1]; ok && inst.Op == ir.OpAddr {
+			derived[inst.Dest] = s
+		}
+	}
+
+	// Follow the addresses through arithmetic and moves, until every use
+	// is either a dereference or lets the address out
+	for grown := true; grown; {
+		grown = false
+		for i := range fn.Instructions {
+			inst := &fn.Instructions[i]
+			for _, reg := range readRegisters(inst) {
+				s, ok := derived[reg]
+				if !ok {
+					continue
+				}
+				switch {
+				case isDereference(inst, reg):
+					refs[s] = append(refs[s], i)
+				case inst.Op == ir.OpAdd || inst.Op == ir.OpSub || inst.Op == ir.OpMove:
+					refs[s] = append(refs[s], i)
+					if _, seen := derived[inst.Dest]; !seen && inst.Dest != 0 {
+						derived[inst.Dest] = s
+						grown = true
+					}
+				default:
+					escapes[s] = true
+				}
+			}
+		}
+	}
+
+	loops := backEdges(fn)
+	for s := range slots {
+		if escapes[s] {
+			slots[s].start, slots[s].end = 0, len(fn.Instructions)
+			continue
+		}
+		for _, r := range refs[s] {
+			if slots[s].start < 0 || r < slots[s].start {
+				slots[s].start = r
+			}
+			if r > slots[s].end {
+				slots[s].end = r
+			}
+		}
+		if slots[s].start < 0 {
+			slots[s].start = 0
+			continue // Never referenced
+		}
+		// A value kept round a loop is live over all of it
+		for stretched := true; stretched; {
+			stretched = false
+			for _, loop := range loops {
+				if loop.start <= slots[s].end && loop.end >= slots[s].start &&
+					(loop.start < slots[s].start || loop.end > slots[s].end) {
+					slots[s].start = min(slots[s].start, loop.start)
+					slots[s].end = max(slots[s].end, loop.end)
+					stretched = true
+				}
+			}
+		}
+	}
+	return slots
+}
+
+// isDereference reports whether inst uses the address in reg only to load
+// or store through it
+func isDereference(inst *ir.Instruction, reg ir.Register) bool {
+	switch inst.Op {
+	case ir.OpLoadIndex, ir.OpLoadField, ir.OpLoad, ir.OpLoadPtr:
+		return inst.Src1 == reg
+	case ir.OpStoreIndex:
+		return inst.Src1 == reg && inst.Src2 != reg && inst.Src3 != reg
+	case ir.OpStoreField, ir.OpStore, ir.OpStorePtr:
+		return inst.Src1 == reg && inst.Src2 != reg
+	case ir.OpStoreDirect:
+		return inst.Dest == reg && inst.Src1 != reg
+	case ir.OpStrCall:
+		// The string routines read and write their arguments and keep
+		// nothing
+		return true
+	}
+	return false
+}
+
+// isComposite reports whether the local name is an array or struct, which
+// loads yield the address of
+func isComposite(fn *ir.Function, name string) bool {
+	for _, local := range fn.Locals {
+		if local.Name == name {
+			switch local.Type.(type) {
+			case *ir.ArrayType, *ir.StructType:
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// backEdges returns the loops of fn: each branch back to an earlier label,
+// with the label at start and the branch at end
+func backEdges(fn *ir.Function) []mirLoop {
+	labels := make(map[string]int)
+	for i := range fn.Instructions {
+		if fn.Instructions[i].Op == ir.OpLabel {
+			labels[fn.Instructions[i].Label] = i
+		}
+	}
+	var loops []mirLoop
+	for i := range fn.Instructions {
+		inst := &fn.Instructions[i]
+		if !inst.IsBranch() {
+			continue
+		}
+		if h, ok := labels[branchLabel(inst)]; ok && h < i {
+			loops = append(loops, mirLoop{h, i})
+		}
+	}
+	return loops
+}
@@ -0,0 +1,178 @@
+package optimizer
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// pointFunction builds, as the analyzer lowers it,
+//
+//	fun f() -> u8 { let p = Point{x: 3, y: 4}; <use of p>; return p.x + p.y; }
+//
+// where use adds instructions reading the register holding p
+func pointFunction(use func(fn *ir.Function, p ir.Register) []ir.Instruction) *ir.Function {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	point := &ir.StructType{Name: "t.Point", Fields: map[string]ir.Type{"x": u8, "y": u8}, FieldOrder: []string{"x", "y"}}
+	fn := ir.NewFunction("t.f", u8)
+	local := fn.AddLocal("p", &ir.BasicType{Kind: ir.TypeU16})
+	fn.Instructions = []ir.Instruction{
+		{Op: ir.OpAlloc, Dest: 10, Imm: 2, Type: point},
+		{Op: ir.OpLoadConst, Dest: 11, Imm: 3, Type: u8},
+		{Op: ir.OpStoreField, Src1: 10, Src2: 11, Imm: 0, Type: u8},
+		{Op: ir.OpLoadConst, Dest: 12, Imm: 4, Type: u8},
+		{Op: ir.OpStoreField, Src1: 10, Src2: 12, Imm: 1, Type: u8},
+		{Op: ir.OpStoreVar, Dest: local, Src1: 10, Symbol: "p", Type: point},
+		{Op: ir.OpLoadVar, Dest: 13, Symbol: "p"},
+	}
+	fn.Instructions = append(fn.Instructions, use(fn, 13)...)
+	fn.Instructions = append(fn.Instructions,
+		ir.Instruction{Op: ir.OpLoadField, Dest: 14, Src1: 13, Imm: 0, Type: u8},
+		ir.Instruction{Op: ir.OpLoadVar, Dest: 15, Symbol: "p"},
+		ir.Instruction{Op: ir.OpLoadField, Dest: 16, Src1: 15, Imm: 1, Type: u8},
+		ir.Instruction{Op: ir.OpAdd, Dest: 17, Src1: 14, Src2: 16, Type: u8},
+		ir.Instruction{Op: ir.OpReturn, Src1: 17},
+	)
+	fn.NextReg = 20
+	return fn
+}
+
+func TestScalarReplacement(t *testing.T) {
+	tests := []struct {
+		name string
+		use  func(fn *ir.Function, p ir.Register) []ir.Instruction
+		want string // Opcodes after the pass
+	}{
+		{
+			name: "fields only",
+			use:  func(*ir.Function, ir.Register) []ir.Instruction { return nil },
+			want: "LOAD_CONST STORE_VAR LOAD_CONST STORE_VAR LOAD_VAR LOAD_VAR ADD RETURN",
+		},
+		{
+			name: "passed to a call",
+			use: func(fn *ir.Function, p ir.Register) []ir.Instruction {
+				return []ir.Instruction{{Op: ir.OpCall, Dest: 18, Symbol: "t.draw", Args: []ir.Register{p}}}
+			},
+			want: "ALLOC LOAD_CONST STORE_FIELD LOAD_CONST STORE_FIELD STORE_VAR LOAD_VAR CALL LOAD_FIELD LOAD_VAR LOAD_FIELD ADD RETURN",
+		},
+		{
+			name: "address taken",
+			use: func(fn *ir.Function, p ir.Register) []ir.Instruction {
+				return []ir.Instruction{{Op: ir.OpAddr, Dest: 18, Src1: p}}
+			},
+			want: "ALLOC LOAD_CONST STORE_FIELD LOAD_CONST STORE_FIELD STORE_VAR LOAD_VAR ADDR LOAD_FIELD LOAD_VAR LOAD_FIELD ADD RETURN",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := pointFunction(tt.use)
+			module := &ir.Module{Name: "t", Functions: []*ir.Function{fn}}
+			if _, err := NewScalarReplacementPass().Run(module); err != nil {
+				t.Fatal(err)
+			}
+			var ops []string
+			for _, inst := range fn.Instructions {
+				ops = append(ops, inst.Op.String())
+			}
+			if got := strings.Join(ops, " "); got != tt.want {
+				t.Errorf("instructions = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	fn := pointFunction(func(*ir.Function, ir.Register) []ir.Instruction { return nil })
+	NewScalarReplacementPass().Run(&ir.Module{Functions: []*ir.Function{fn}})
+	var locals []string
+	for _, local := range fn.Locals {
+		locals = append(locals, local.Name+":"+local.Type.String())
+	}
+	if got := strings.Join(locals, " "); got != "p.x:u8 p.y:u8" {
+		t.Errorf("locals = %s, want p.x:u8 p.y:u8", got)
+	}
+	if load := fn.Instructions[5]; load.Symbol != "p.y" || load.Dest != 16 {
+		t.Errorf("p.y loads as %s", load.String())
+	}
+}
+
+func TestFrameLayout(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	buffer := &ir.ArrayType{Element: u8, Length: 4}
+	// fill stores into the array name, at reg
+	fill := func(name string, reg ir.Register) []ir.Instruction {
+		return []ir.Instruction{
+			{Op: ir.OpLoadVar, Dest: reg, Symbol: name},
+			{Op: ir.OpLoadConst, Dest: reg + 1, Imm: 1, Type: u8},
+			{Op: ir.OpAdd, Dest: reg + 2, Src1: reg, Src2: reg + 1},
+			{Op: ir.OpStoreDirect, Dest: reg + 2, Src1: reg + 1, Type: u8},
+		}
+	}
+	loop := func(body ...[]ir.Instruction) []ir.Instruction {
+		insts := []ir.Instruction{{Op: ir.OpLabel, Label: "t.f.loop"}}
+		for _, b := range body {
+			insts = append(insts, b...)
+		}
+		return append(insts, ir.Instruction{Op: ir.OpJumpIfNot, Src1: 99, Label: "t.f.loop"})
+	}
+	tests := []struct {
+		name      string
+		body      [][]ir.Instruction
+		offsets   string // Of a, b and n
+		frameSize int
+	}{
+		{
+			name: "one after the other",
+			body: [][]ir.Instruction{
+				fill("a", 20),
+				{{Op: ir.OpLoadVar, Dest: 30, Symbol: "a"}, {Op: ir.OpLoadIndex, Dest: 31, Src1: 30, Src2: 21}},
+				{{Op: ir.OpStoreVar, Symbol: "n", Src1: 31}},
+				fill("b", 40),
+				{{Op: ir.OpLoadVar, Dest: 50, Symbol: "n"}, {Op: ir.OpReturn, Src1: 50}},
+			},
+			offsets:   "0 1 0",
+			frameSize: 5,
+		},
+		{
+			name: "in one loop",
+			body: [][]ir.Instruction{
+				loop(fill("a", 20), fill("b", 40)),
+				{{Op: ir.OpStoreVar, Symbol: "n", Src1: 99}, {Op: ir.OpReturn}},
+			},
+			offsets:   "0 4 0",
+			frameSize: 8,
+		},
+		{
+			name: "address passed on",
+			body: [][]ir.Instruction{
+				{{Op: ir.OpLoadVar, Dest: 20, Symbol: "a"}, {Op: ir.OpMove, Dest: 21, Src1: 20}, {Op: ir.OpCall, Symbol: "t.keep", Args: []ir.Register{21}}},
+				fill("b", 40),
+				{{Op: ir.OpStoreVar, Symbol: "n", Src1: 99}, {Op: ir.OpReturn}},
+			},
+			offsets:   "0 4 4",
+			frameSize: 8,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := ir.NewFunction("t.f", u8)
+			fn.AddLocal("a", buffer)
+			fn.AddLocal("b", buffer)
+			fn.AddLocal("n", u8)
+			for _, b := range tt.body {
+				fn.Instructions = append(fn.Instructions, b...)
+			}
+			module := &ir.Module{Name: "t", Functions: []*ir.Function{fn}}
+			if _, err := NewFrameLayoutPass().Run(module); err != nil {
+				t.Fatal(err)
+			}
+			var offsets []string
+			for _, local := range fn.Locals {
+				offsets = append(offsets, strconv.Itoa(local.Offset))
+			}
+			if got := strings.Join(offsets, " "); got != tt.offsets || fn.FrameSize != tt.frameSize {
+				t.Errorf("offsets = %s, frame %d bytes; want %s, %d bytes", got, fn.FrameSize, tt.offsets, tt.frameSize)
+			}
+		})
+	}
+}
@@ -31,6 +31,10 @@ func (p *InliningPass) Run(module *ir.Module) (bool, error) {
 	// Then inline calls to these functions
 	changed := false
 	for _, function := range module.Functions {
+		// @cold functions are optimized for size; inlining only grows them
+		if function.Hint == ir.HintCold {
+			continue
+		}
 		if p.inlineCalls(function) {
 			changed = true
 		}
@@ -57,6 +61,11 @@ func (p *InliningPass) isInlineCandidate(fn *ir.Function) bool {
 		return false
 	}
 	
+	// Don't duplicate @cold code into callers
+	if fn.Hint == ir.HintCold {
+		return false
+	}
+	
 	// Check size
 	if len(fn.Instructions) > p.maxInlineSize {
 		return false
@@ -157,6 +166,11 @@ func (p *InliningPass) generateInlinedCode(fn *ir.Function, call ir.Instruction,
 	for _, inst := range fn.Instructions {
 		newInst := inst
 		
+		// The callee's source marks describe its own body, not the call site
+		if inst.Op == ir.OpSourceMark {
+			continue
+		}
+		
 		// Skip return instructions
 		if inst.Op == ir.OpReturn {
 			// Map return value to call destination
@@ -185,6 +199,11 @@ func (p *InliningPass) generateInlinedCode(fn *ir.Function, call ir.Instruction,
 				newInst.Src2 = mapped
 			}
 		}
+		if inst.Src3 != 0 {
+			if mapped, ok := regMap[inst.Src3]; ok {
+				newInst.Src3 = mapped
+			}
+		}
 		
 		// Add comment to indicate inlining
 		if newInst.Comment != "" {
@@ -192,6 +192,11 @@ func (l *LayoutOptimizer) scoreFunctions(functions []*ir.Function) []*FunctionSc
 
 // estimateFunctionSize estimates code size in bytes
 func (l *LayoutOptimizer) estimateFunctionSize(fn *ir.Function) int {
+	return estimateCodeSize(fn)
+}
+
+// estimateCodeSize estimates the size of a function's code in bytes
+func estimateCodeSize(fn *ir.Function) int {
 	// Rough estimate: 3 bytes per IR instruction
 	size := len(fn.Instructions) * 3
 	
@@ -0,0 +1,252 @@
+package optimizer
+
+import (
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// LoopInvariantPass moves computations whose result is the same on every
+// iteration of a loop to its preheader, just before the loop's label.
+// Iterator chains are the usual source: a map over an array recomputes
+// the same address or scaled constant each time round the DJNZ loop.
+//
+// A loop is a label and the last branch back to it, entered only by
+// falling into the label. An instruction in it is invariant when it is the
+// register's only definition (the MIR is SSA-like, so that is most of them)
+// and it is a constant, label or address load, or arithmetic whose
+// operands are defined outside the loop or are invariant themselves.
+// Division is left alone, as the runtime may trap on zero.
+//
+// Only expressions with at least one arithmetic instruction move: a lone
+// load is a single immediate load in the loop, and hoisting it would
+// replace that with a reload of the kept value. A load moves with the
+// arithmetic it feeds, and only when nothing else in the loop reads it.
+type LoopInvariantPass struct{}
+
+// NewLoopInvariantPass creates a new loop-invariant code motion pass
+func NewLoopInvariantPass() Pass {
+	return &LoopInvariantPass{}
+}
+
+// Name returns the name of this pass
+func (p *LoopInvariantPass) Name() string {
+	return "Loop-Invariant Code Motion"
+}
+
+// Run hoists the loop invariants of every function
+func (p *LoopInvariantPass) Run(module *ir.Module) (bool, error) {
+	changed := false
+	for _, fn := range module.Functions {
+		// Hoisting out of an inner loop may expose invariants of the
+		// loop around it, so repeat until nothing moves
+		for p.hoistFunction(fn) {
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// mirLoop is a loop of a function's instructions: the header label at
+// start, and the last branch back to it at end
+type mirLoop struct {
+	start, end int
+}
+
+// hoistFunction hoists the invariants of one loop of fn and reports
+// whether it did
+func (p *LoopInvariantPass) hoistFunction(fn *ir.Function) bool {
+	labels := make(map[string]int)
+	for i := range fn.Instructions {
+		inst := &fn.Instructions[i]
+		switch inst.Op {
+		case ir.OpLabel:
+			labels[inst.Label] = i
+		case ir.OpJumpIndirect, ir.OpLoadLabel, ir.OpAsm:
+			// Jumps to labels the MIR does not name
+			return false
+		}
+	}
+
+	for _, loop := range p.findLoops(fn, labels) {
+		if p.hoistLoop(fn, loop) {
+			return true
+		}
+	}
+	return false
+}
+
+// findLoops returns the loops of fn that are entered only through their
+// header, innermost first
+func (p *LoopInvariantPass) findLoops(fn *ir.Function, labels map[string]int) []mirLoop {
+	ends := make(map[int]int)
+	for i := range fn.Instructions {
+		inst := &fn.Instructions[i]
+		if !inst.IsBranch() {
+			continue
+		}
+		if h, ok := labels[branchLabel(inst)]; ok && h < i && i > ends[h] {
+			ends[h] = i
+		}
+	}
+
+	var loops []mirLoop
+	for start, end := range ends {
+		if start > 0 && !fallsThrough(&fn.Instructions[start-1]) {
+			continue // Entered by a jump, which would skip the preheader
+		}
+		entered := false
+		for i := range fn.Instructions {
+			inst := &fn.Instructions[i]
+			if (i < start || i > end) && inst.IsBranch() {
+				if t, ok := labels[branchLabel(inst)]; ok && t >= start && t <= end {
+					entered = true
+					break
+				}
+			}
+		}
+		if !entered {
+			loops = append(loops, mirLoop{start, end})
+		}
+	}
+	// Innermost (shortest) first, then in program order
+	for i := 1; i < len(loops); i++ {
+		for j := i; j > 0 && loopBefore(loops[j], loops[j-1]); j-- {
+			loops[j], loops[j-1] = loops[j-1], loops[j]
+		}
+	}
+	return loops
+}
+
+func loopBefore(a, b mirLoop) bool {
+	if a.end-a.start != b.end-b.start {
+		return a.end-a.start < b.end-b.start
+	}
+	return a.start < b.start
+}
+
+// hoistLoop moves the invariants of loop to just before its header
+func (p *LoopInvariantPass) hoistLoop(fn *ir.Function, loop mirLoop) bool {
+	pinned := make(map[ir.Register]bool)
+	for _, param := range fn.Params {
+		pinned[param.Reg] = true
+	}
+	for _, local := range fn.Locals {
+		pinned[local.Reg] = true
+	}
+	defs := make(map[ir.Register]int)
+	definedInLoop := make(map[ir.Register]int) // Register to its instruction
+	for i := range fn.Instructions {
+		inst := &fn.Instructions[i]
+		if inst.Dest > 0 {
+			defs[inst.Dest]++
+			if i > loop.start && i <= loop.end {
+				definedInLoop[inst.Dest] = i
+			}
+		}
+	}
+
+	// Invariants, in order: each operand comes from outside the loop or
+	// from an earlier invariant
+	invariant := make(map[int]bool)
+	for i := loop.start + 1; i < loop.end; i++ {
+		inst := &fn.Instructions[i]
+		if inst.Dest <= 0 || defs[inst.Dest] != 1 || pinned[inst.Dest] || !isHoistable(inst) {
+			continue
+		}
+		operandsInvariant := true
+		for _, r := range inst.Reads() {
+			if d, inLoop := definedInLoop[r]; pinned[r] || inLoop && !invariant[d] {
+				operandsInvariant = false
+			}
+		}
+		if operandsInvariant {
+			invariant[i] = true
+		}
+	}
+
+	// Keep loads that something left in the loop reads, or that feed no
+	// hoisted arithmetic, and the arithmetic that reads kept loads
+	for {
+		dropped := false
+		for i := range invariant {
+			inst := &fn.Instructions[i]
+			keep := false
+			if isArithmetic(inst) {
+				for _, r := range inst.Reads() {
+					if d, inLoop := definedInLoop[r]; inLoop && !invariant[d] {
+						keep = true
+					}
+				}
+			} else {
+				feeds := false
+				for j := loop.start + 1; j <= loop.end; j++ {
+					for _, r := range fn.Instructions[j].Reads() {
+						if r != inst.Dest {
+							continue
+						}
+						if invariant[j] {
+							feeds = true
+						} else {
+							keep = true
+						}
+					}
+				}
+				keep = keep || !feeds
+			}
+			if keep {
+				delete(invariant, i)
+				dropped = true
+			}
+		}
+		if !dropped {
+			break
+		}
+	}
+	if len(invariant) == 0 {
+		return false
+	}
+
+	// Preheader code, then the loop without it
+	var hoisted, rest []ir.Instruction
+	for i := loop.start; i <= loop.end; i++ {
+		if invariant[i] {
+			hoisted = append(hoisted, fn.Instructions[i])
+		} else {
+			rest = append(rest, fn.Instructions[i])
+		}
+	}
+	copy(fn.Instructions[loop.start:], hoisted)
+	copy(fn.Instructions[loop.start+len(hoisted):], rest)
+	return true
+}
+
+// isHoistable reports whether an instruction computes its result from its
+// operands alone, without memory or side effects
+func isHoistable(inst *ir.Instruction) bool {
+	switch inst.Op {
+	case ir.OpLoadConst, ir.OpLoadString:
+		return true
+	case ir.OpLoadAddr:
+		return inst.Src1 == 0 && len(inst.Args) == 0
+	case ir.OpDiv, ir.OpMod:
+		return false
+	}
+	return isArithmetic(inst)
+}
+
+// fallsThrough reports whether execution can continue past inst to the
+// next instruction
+func fallsThrough(inst *ir.Instruction) bool {
+	switch inst.Op {
+	case ir.OpJump, ir.OpJmp, ir.OpReturn, ir.OpJumpIndirect:
+		return false
+	}
+	return true
+}
+
+// branchLabel returns the label a branch jumps to
+func branchLabel(inst *ir.Instruction) string {
+	if inst.Label != "" {
+		return inst.Label
+	}
+	return inst.Symbol
+}
@@ -93,6 +93,9 @@ func (p *MIRReorderingPass) buildDependencyGraph(fn *ir.Function) *dependency {
 		if inst.Src2 != 0 {
 			deps.reads[inst.Src2] = append(deps.reads[inst.Src2], i)
 		}
+		if inst.Src3 != 0 {
+			deps.reads[inst.Src3] = append(deps.reads[inst.Src3], i)
+		}
 		
 		// Track register writes
 		if inst.Dest != 0 {
@@ -102,7 +105,8 @@ func (p *MIRReorderingPass) buildDependencyGraph(fn *ir.Function) *dependency {
 		// Track memory operations
 		switch inst.Op {
 		case ir.OpLoadVar, ir.OpStoreVar, ir.OpLoadField, ir.OpStoreField,
-			 ir.OpLoadElement, ir.OpStoreElement, ir.OpCall:
+			 ir.OpLoadElement, ir.OpStoreElement, ir.OpStoreIndex, ir.OpCall, ir.OpFormat,
+			 ir.OpStrCall:
 			deps.memory = append(deps.memory, i)
 		}
 	}
@@ -318,10 +322,10 @@ func isMemoryOp(inst *ir.Instruction) bool {
 
 func isControlFlow(inst *ir.Instruction) bool {
 	switch inst.Op {
-	case ir.OpJump, ir.OpJumpIf, ir.OpJumpIfNot, ir.OpCall, ir.OpReturn:
+	case ir.OpLabel, ir.OpCall:
 		return true
 	}
-	return false
+	return inst.IsBranch()
 }
 
 func isArithmetic(inst *ir.Instruction) bool {
@@ -23,8 +23,10 @@ type Pass interface {
 
 // Optimizer manages and runs optimization passes
 type Optimizer struct {
-	level  OptimizationLevel
-	passes []Pass
+	level        OptimizationLevel
+	passes       []Pass
+	reachability *ReachabilityPass // Whole-module pass run once, before the others
+	frameLayout  Pass              // Run once, after the others
 }
 
 // NewOptimizer creates a new optimizer with the specified level
@@ -49,9 +51,15 @@ func NewOptimizerWithOptions(level OptimizationLevel, enableTrueSMC bool) *Optim
 	}
 	
 	if level >= OptLevelFull {
+		opt.reachability = NewReachabilityPass()
+		opt.frameLayout = NewFrameLayoutPass()
+		
 		// Advanced optimizations - reorder before peephole for maximum pattern exposure
 		opt.passes = append(opt.passes,
+			NewScalarReplacementPass(),         // Split small non-escaping struct locals into their fields
+			NewLoopInvariantPass(),             // Hoist loop-invariant computations to the preheader
 			NewSmartPeepholeOptimizationPass(), // NEW: Smart peephole with integrated reordering!
+			NewRegisterSchedulingPass(),        // Sink single-use loads next to their readers
 			NewRegisterAllocationPass(),
 			NewInliningPass(),
 		)
@@ -81,7 +89,14 @@ func (o *Optimizer) Optimize(module *ir.Module) error {
 		return nil
 	}
 	
-	// First, detect recursive functions
+	// Drop the functions and globals the program never reaches
+	if o.reachability != nil {
+		if _, err := o.reachability.Run(module); err != nil {
+			return fmt.Errorf("optimization pass %s failed: %w", o.reachability.Name(), err)
+		}
+	}
+	
+	// Detect recursive functions
 	recursionDetector := NewRecursionDetector()
 	recursionDetector.AnalyzeModule(module)
 	
@@ -114,9 +129,24 @@ func (o *Optimizer) Optimize(module *ir.Module) error {
 		}
 	}
 	
+	// Share frame bytes between locals once nothing moves any more
+	if o.frameLayout != nil {
+		if _, err := o.frameLayout.Run(module); err != nil {
+			return fmt.Errorf("optimization pass %s failed: %w", o.frameLayout.Name(), err)
+		}
+	}
+	
 	return nil
 }
 
+// Removed returns the functions and globals removed as unreachable
+func (o *Optimizer) Removed() ReachabilityStats {
+	if o.reachability == nil {
+		return ReachabilityStats{}
+	}
+	return o.reachability.Stats()
+}
+
 // Helper functions for common optimization tasks
 
 // IsConstant checks if an instruction produces a constant value
@@ -148,6 +178,9 @@ func ReplaceRegister(inst *ir.Instruction, oldReg, newReg ir.Register) {
 	if inst.Src2 == oldReg {
 		inst.Src2 = newReg
 	}
+	if inst.Src3 == oldReg {
+		inst.Src3 = newReg
+	}
 	if inst.Dest == oldReg {
 		inst.Dest = newReg
 	}
@@ -1,6 +1,8 @@
 package optimizer
 
 import (
+	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/minz/minzc/pkg/ir"
@@ -39,6 +41,19 @@ func TestConstantFolding(t *testing.T) {
 				{Op: ir.OpLoadConst, Dest: 3, Imm: 30, Comment: "Folded: "},
 			},
 		},
+		{
+			name: "fold fixed-point multiplication",
+			input: []ir.Instruction{
+				{Op: ir.OpLoadConst, Dest: 1, Imm: 896}, // 3.5
+				{Op: ir.OpLoadConst, Dest: 2, Imm: 512}, // 2.0
+				{Op: ir.OpMul, Dest: 3, Src1: 1, Src2: 2, Type: &ir.BasicType{Kind: ir.TypeF8_8}},
+			},
+			expected: []ir.Instruction{
+				{Op: ir.OpLoadConst, Dest: 1, Imm: 896},
+				{Op: ir.OpLoadConst, Dest: 2, Imm: 512},
+				{Op: ir.OpLoadConst, Dest: 3, Imm: 1792, Comment: "Folded: "}, // 7.0
+			},
+		},
 		{
 			name: "fold comparison",
 			input: []ir.Instruction{
@@ -267,4 +282,237 @@ func TestPeepholeOptimization(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+// Test register scheduling
+func TestRegisterScheduling(t *testing.T) {
+	fn := &ir.Function{
+		Name:   "test_func",
+		Instructions: []ir.Instruction{
+			{Op: ir.OpLoadConst, Dest: 1, Imm: 10},
+			{Op: ir.OpLoadVar, Dest: 2, Symbol: "a"},
+			{Op: ir.OpLoadVar, Dest: 3, Symbol: "b"},
+			{Op: ir.OpStoreVar, Src1: 3, Symbol: "a"},
+			{Op: ir.OpLoadConst, Dest: 4, Imm: 1},
+			{Op: ir.OpAdd, Dest: 5, Src1: 1, Src2: 4},
+			{Op: ir.OpSub, Dest: 6, Src1: 2, Src2: 5},
+			{Op: ir.OpReturn, Src1: 6},
+		},
+	}
+	before := ir.AnalyzeLiveness(fn).MaxPressure()
+
+	changed, err := NewRegisterSchedulingPass().Run(&ir.Module{Functions: []*ir.Function{fn}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !changed {
+		t.Fatal("expected changes but none were made")
+	}
+
+	// The constant moves next to its reader; the load of a cannot pass
+	// the store to a
+	var order []ir.Register
+	for _, inst := range fn.Instructions {
+		if inst.Dest != 0 {
+			order = append(order, inst.Dest)
+		}
+	}
+	want := []ir.Register{2, 3, 1, 4, 5, 6}
+	if len(order) != len(want) {
+		t.Fatalf("got order %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got order %v, want %v", order, want)
+		}
+	}
+	if after := ir.AnalyzeLiveness(fn).MaxPressure(); after > before {
+		t.Errorf("register pressure rose from %d to %d", before, after)
+	}
+}
+
+func TestLoopInvariantCodeMotion(t *testing.T) {
+	loop := []ir.Instruction{
+		{Op: ir.OpLoadConst, Dest: 1, Imm: 10, Hint: ir.RegHintB},
+		{Op: ir.OpLabel, Label: "loop"},
+		{Op: ir.OpLoadAddr, Dest: 2, Symbol: "table"},
+		{Op: ir.OpLoadConst, Dest: 3, Imm: 16},
+		{Op: ir.OpAdd, Dest: 4, Src1: 2, Src2: 3}, // table + 16: invariant
+		{Op: ir.OpLoad, Dest: 5, Src1: 4},
+		{Op: ir.OpLoadConst, Dest: 6, Imm: 3}, // Read by r7, which is not hoisted
+		{Op: ir.OpAdd, Dest: 7, Src1: 5, Src2: 6},
+		{Op: ir.OpStoreVar, Src1: 7, Symbol: "total"},
+		{Op: ir.OpDJNZ, Src1: 1, Label: "loop"},
+		{Op: ir.OpReturn},
+	}
+	order := func(fn *ir.Function) []string {
+		var names []string
+		for _, inst := range fn.Instructions {
+			switch {
+			case inst.Op == ir.OpLabel:
+				names = append(names, inst.Label)
+			case inst.Dest != 0:
+				names = append(names, fmt.Sprintf("r%d", inst.Dest))
+			}
+		}
+		return names
+	}
+	run := func(instructions []ir.Instruction) (*ir.Function, bool) {
+		fn := &ir.Function{Name: "test_func", Instructions: append([]ir.Instruction(nil), instructions...)}
+		changed, err := NewLoopInvariantPass().Run(&ir.Module{Functions: []*ir.Function{fn}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return fn, changed
+	}
+
+	fn, changed := run(loop)
+	if !changed {
+		t.Fatal("expected changes but none were made")
+	}
+	want := []string{"r1", "r2", "r3", "r4", "loop", "r5", "r6", "r7"}
+	if got := order(fn); !reflect.DeepEqual(got, want) {
+		t.Errorf("got order %v, want %v", got, want)
+	}
+
+	// Nested: the invariant leaves both loops
+	nested := []ir.Instruction{
+		{Op: ir.OpLoadConst, Dest: 1, Imm: 4},
+		{Op: ir.OpLoadVar, Dest: 8, Symbol: "base"},
+		{Op: ir.OpLabel, Label: "outer"},
+		{Op: ir.OpLoadConst, Dest: 2, Imm: 8},
+		{Op: ir.OpLabel, Label: "inner"},
+		{Op: ir.OpLoadConst, Dest: 3, Imm: 32},
+		{Op: ir.OpMul, Dest: 4, Src1: 8, Src2: 3},
+		{Op: ir.OpStoreVar, Src1: 4, Symbol: "out"},
+		{Op: ir.OpDJNZ, Src1: 2, Label: "inner"},
+		{Op: ir.OpDJNZ, Src1: 1, Label: "outer"},
+		{Op: ir.OpReturn},
+	}
+	fn, _ = run(nested)
+	want = []string{"r1", "r8", "r3", "r4", "outer", "r2", "inner"}
+	if got := order(fn); !reflect.DeepEqual(got, want) {
+		t.Errorf("nested: got order %v, want %v", got, want)
+	}
+
+	// A loop entered by a jump to its label has no preheader to use
+	entered := append([]ir.Instruction{{Op: ir.OpJump, Label: "loop"}}, loop...)
+	if _, changed := run(entered); changed {
+		t.Error("hoisted out of a loop entered by a jump")
+	}
+
+	// Division by a loop-invariant zero must stay where it may not run
+	division := append([]ir.Instruction(nil), loop...)
+	division[4].Op = ir.OpDiv
+	if _, changed := run(division); changed {
+		t.Error("hoisted a division")
+	}
+}
+
+func TestLoopStateSurvivesCleanup(t *testing.T) {
+	// A counted loop whose state lives in variables, as fused iterator
+	// loops are lowered: every store is read back by name, and the loop is
+	// closed by DJNZ and left by a conditional jump.
+	fn := &ir.Function{
+		Name: "test_func",
+		Instructions: []ir.Instruction{
+			{Op: ir.OpLoadConst, Dest: 1, Imm: 0},
+			{Op: ir.OpStoreVar, Src1: 1, Symbol: "found"},
+			{Op: ir.OpLoadConst, Dest: 2, Imm: 8, Hint: ir.RegHintB},
+			{Op: ir.OpLabel, Label: "loop"},
+			{Op: ir.OpStoreVar, Src1: 2, Symbol: "count"},
+			{Op: ir.OpLoadVar, Dest: 3, Symbol: "ptr"},
+			{Op: ir.OpLoad, Dest: 4, Src1: 3},
+			{Op: ir.OpLoadConst, Dest: 5, Imm: 9},
+			{Op: ir.OpGt, Dest: 6, Src1: 4, Src2: 5},
+			{Op: ir.OpJumpIfNot, Src1: 6, Label: "next"},
+			{Op: ir.OpStoreVar, Src1: 4, Symbol: "found"},
+			{Op: ir.OpJump, Label: "exit"},
+			{Op: ir.OpLabel, Label: "next"},
+			{Op: ir.OpLoadVar, Dest: 2, Symbol: "count"},
+			{Op: ir.OpDJNZ, Src1: 2, Label: "loop"},
+			{Op: ir.OpLabel, Label: "exit"},
+			{Op: ir.OpLoadVar, Dest: 7, Symbol: "found"},
+			{Op: ir.OpReturn, Src1: 7},
+		},
+	}
+	module := &ir.Module{Functions: []*ir.Function{fn}}
+	for _, pass := range []Pass{NewDeadCodeEliminationPass(), NewSmartPeepholeOptimizationPass(), NewDeadCodeEliminationPass()} {
+		if _, err := pass.Run(module); err != nil {
+			t.Fatalf("%s: %v", pass.Name(), err)
+		}
+	}
+
+	var got []string
+	for _, inst := range fn.Instructions {
+		switch inst.Op {
+		case ir.OpLabel:
+			got = append(got, inst.Label+":")
+		case ir.OpStoreVar, ir.OpLoadVar, ir.OpLoad, ir.OpDJNZ:
+			got = append(got, inst.String())
+		}
+	}
+	want := []string{
+		"store found, r1", "loop:", "store count, r2", "r3 = load ptr", "r4 = *r3",
+		"store found, r4", "next:", "r2 = load count", "djnz r2, loop", "exit:", "r7 = load found",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestMutualTailCalls(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	u16 := &ir.BasicType{Kind: ir.TypeU16}
+	function := func(name string, returns ir.Type, smc bool, params int, body ...ir.Instruction) *ir.Function {
+		fn := ir.NewFunction(name, returns)
+		fn.IsSMCDefault = smc
+		fn.IsSMCEnabled = smc
+		for i := 0; i < params; i++ {
+			fn.Params = append(fn.Params, ir.Parameter{Name: fmt.Sprintf("p%d", i), Type: u8})
+		}
+		fn.Instructions = body
+		return fn
+	}
+	call := func(callee string, args ...ir.Register) ir.Instruction {
+		return ir.Instruction{Op: ir.OpCall, Dest: 9, Symbol: callee, Args: args}
+	}
+	ret := ir.Instruction{Op: ir.OpReturn, Src1: 9}
+
+	module := &ir.Module{Functions: []*ir.Function{
+		// SMC state machine: even and odd return each other's result
+		function("even", u8, true, 1, call("odd", 1), ir.Instruction{Op: ir.OpNop}, ret),
+		function("odd", u8, true, 1, call("even", 1), ret),
+		// Register-passing callee from a register-passing caller
+		function("step", u8, false, 0, call("leaf", 1, 2), ret),
+		function("leaf", u8, false, 2, ir.Instruction{Op: ir.OpReturn, Src1: 1}),
+		// An SMC caller and a register-passing callee disagree
+		function("mixed", u8, true, 0, call("leaf", 1, 2), ret),
+		// The caller returns a u16, leaf a u8
+		function("wider", u16, false, 0, call("leaf", 1, 2), ret),
+		// The result is not returned as it stands
+		function("adds", u8, false, 0, call("leaf", 1, 2),
+			ir.Instruction{Op: ir.OpAdd, Dest: 10, Src1: 9, Src2: 9}, ir.Instruction{Op: ir.OpReturn, Src1: 10}),
+	}}
+	pass := NewTailRecursionPass()
+	if changed, err := pass.Run(module); err != nil || !changed {
+		t.Fatalf("run: changed %v, %v", changed, err)
+	}
+
+	want := map[string]bool{"even": true, "odd": true, "step": true}
+	for _, fn := range module.Functions {
+		if fn.Instructions[0].Op != ir.OpCall {
+			continue
+		}
+		if got := fn.Instructions[0].TailCall; got != want[fn.Name] {
+			t.Errorf("%s: tail call %v, want %v", fn.Name, got, want[fn.Name])
+		}
+	}
+	if got := module.Functions[0].Instructions[0].String(); got != "r9 = tail call odd" {
+		t.Errorf("even calls %q", got)
+	}
+
+	// Marking is done once
+	if changed, _ := pass.Run(module); changed {
+		t.Error("second run changed the module")
+	}
+}
@@ -0,0 +1,168 @@
+package optimizer
+
+import (
+	"strings"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// ReachabilityPass removes the functions and globals a program never uses.
+// Starting from main, exported functions and interrupt handlers, it follows
+// every name an instruction refers to (calls, addresses, variables, labels)
+// and the functions named by function tables. Inline assembly is scanned
+// for names too, so a routine only called from asm is kept.
+//
+// A module without an entry point is a library, and is left alone. Removed
+// functions no longer pull in the runtime routines (cls, print helpers...)
+// the code generator emits for them.
+type ReachabilityPass struct {
+	stats ReachabilityStats
+}
+
+// ReachabilityStats describes what a ReachabilityPass removed
+type ReachabilityStats struct {
+	Functions  []string // Names of the removed functions
+	Globals    []string // Names of the removed globals
+	BytesSaved int      // Estimated size of the removed code and data
+}
+
+// NewReachabilityPass creates a new whole-module dead code elimination pass
+func NewReachabilityPass() *ReachabilityPass {
+	return &ReachabilityPass{}
+}
+
+// Name returns the name of this pass
+func (p *ReachabilityPass) Name() string {
+	return "Whole-Module Dead Code Elimination"
+}
+
+// Stats returns what the pass has removed so far
+func (p *ReachabilityPass) Stats() ReachabilityStats {
+	return p.stats
+}
+
+// isEntryPoint reports whether fn is run from outside the program's code
+func isEntryPoint(fn *ir.Function) bool {
+	return fn.Name == "main" || strings.HasSuffix(fn.Name, ".main") || fn.IsExported || fn.IsInterrupt
+}
+
+// shortName strips the module prefix from a symbol, as calls may use either
+func shortName(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// Run removes the unreachable functions and globals of module
+func (p *ReachabilityPass) Run(module *ir.Module) (bool, error) {
+	var work []*ir.Function
+	for _, fn := range module.Functions {
+		if isEntryPoint(fn) {
+			work = append(work, fn)
+		}
+	}
+	if len(work) == 0 {
+		return false, nil
+	}
+
+	functions := make(map[string][]*ir.Function)
+	for _, fn := range module.Functions {
+		functions[fn.Name] = append(functions[fn.Name], fn)
+		if short := shortName(fn.Name); short != fn.Name {
+			functions[short] = append(functions[short], fn)
+		}
+	}
+	globals := make(map[string][]int)
+	for i, global := range module.Globals {
+		globals[global.Name] = append(globals[global.Name], i)
+		if short := shortName(global.Name); short != global.Name {
+			globals[short] = append(globals[short], i)
+		}
+	}
+
+	reached := make(map[*ir.Function]bool)
+	for _, fn := range work {
+		reached[fn] = true
+	}
+	used := make(map[int]bool)
+	var mark func(name string)
+	mark = func(name string) {
+		if name == "" {
+			return
+		}
+		for _, fn := range functions[name] {
+			if !reached[fn] {
+				reached[fn] = true
+				work = append(work, fn)
+			}
+		}
+		for _, i := range globals[name] {
+			if used[i] {
+				continue
+			}
+			used[i] = true
+			if entries, ok := module.Globals[i].Init.([]string); ok {
+				for _, entry := range entries {
+					mark(entry)
+				}
+			}
+		}
+	}
+
+	for len(work) > 0 {
+		fn := work[len(work)-1]
+		work = work[:len(work)-1]
+		for i := range fn.Instructions {
+			inst := &fn.Instructions[i]
+			mark(inst.Symbol)
+			mark(inst.Label)
+			mark(inst.FuncName)
+			// Patching a call's return refers to a patch point in the callee
+			mark(strings.TrimSuffix(inst.PatchPointLabel, "_return_patch"))
+			if inst.Op == ir.OpAsm {
+				p.markMentioned(inst.AsmCode, functions, globals, mark)
+			}
+		}
+	}
+
+	var keptFunctions []*ir.Function
+	for _, fn := range module.Functions {
+		if reached[fn] {
+			keptFunctions = append(keptFunctions, fn)
+			continue
+		}
+		p.stats.Functions = append(p.stats.Functions, fn.Name)
+		p.stats.BytesSaved += estimateCodeSize(fn)
+	}
+	var keptGlobals []ir.Global
+	for i, global := range module.Globals {
+		if used[i] {
+			keptGlobals = append(keptGlobals, global)
+			continue
+		}
+		p.stats.Globals = append(p.stats.Globals, global.Name)
+		if global.Address == nil && global.Type != nil {
+			p.stats.BytesSaved += global.Type.Size()
+		}
+	}
+
+	changed := len(keptFunctions) != len(module.Functions) || len(keptGlobals) != len(module.Globals)
+	module.Functions = keptFunctions
+	module.Globals = keptGlobals
+	return changed, nil
+}
+
+// markMentioned marks every function and global whose name appears in
+// inline assembly. Labels there are sanitized or shortened, so the match
+// is on the name without its module prefix or overload suffix.
+func (p *ReachabilityPass) markMentioned(code string, functions map[string][]*ir.Function,
+	globals map[string][]int, mark func(string)) {
+	for name := range functions {
+		if base, _, _ := strings.Cut(shortName(name), "$"); strings.Contains(code, base) {
+			mark(name)
+		}
+	}
+	for name := range globals {
+		if strings.Contains(code, shortName(name)) {
+			mark(name)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package optimizer
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+func TestReachability(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	function := func(name string, body ...ir.Instruction) *ir.Function {
+		fn := ir.NewFunction(name, u8)
+		fn.Instructions = append(body, ir.Instruction{Op: ir.OpReturn})
+		return fn
+	}
+	call := func(name string) ir.Instruction { return ir.Instruction{Op: ir.OpCall, Symbol: name} }
+
+	isr := function("prog.on_frame", ir.Instruction{Op: ir.OpLoadVar, Dest: 1, Symbol: "prog.frames"})
+	isr.IsInterrupt = true
+	api := function("prog.api")
+	api.IsExported = true
+
+	module := &ir.Module{
+		Name: "prog",
+		Functions: []*ir.Function{
+			function("prog.main",
+				call("prog.update"),
+				ir.Instruction{Op: ir.OpLoadAddr, Dest: 1, Symbol: "prog.handlers"},
+				ir.Instruction{Op: ir.OpAsm, AsmCode: "    CALL asm_only\n"},
+			),
+			function("prog.update", call("draw$u8"), ir.Instruction{Op: ir.OpStoreVar, Symbol: "prog.score"}),
+			function("prog.draw$u8"),
+			function("prog.asm_only"),
+			function("prog.on_key"),
+			function("prog.unused", call("prog.unused_too"), ir.Instruction{Op: ir.OpLoadVar, Symbol: "prog.level"}),
+			function("prog.unused_too"),
+			isr,
+			api,
+		},
+		Globals: []ir.Global{
+			{Name: "prog.score", Type: u8},
+			{Name: "prog.frames", Type: u8},
+			{Name: "prog.level", Type: &ir.BasicType{Kind: ir.TypeU16}},
+			{Name: "prog.handlers", Type: &ir.ArrayType{Element: u8, Length: 1}, Init: []string{"prog.on_key"}, Constant: true},
+		},
+	}
+
+	pass := NewReachabilityPass()
+	changed, err := pass.Run(module)
+	if err != nil || !changed {
+		t.Fatalf("Run = %v, %v", changed, err)
+	}
+
+	var functions, globals []string
+	for _, fn := range module.Functions {
+		functions = append(functions, fn.Name)
+	}
+	for _, global := range module.Globals {
+		globals = append(globals, global.Name)
+	}
+	wantFunctions := []string{"prog.main", "prog.update", "prog.draw$u8", "prog.asm_only", "prog.on_key", "prog.on_frame", "prog.api"}
+	if !slices.Equal(functions, wantFunctions) {
+		t.Errorf("kept functions %v, want %v", functions, wantFunctions)
+	}
+	if want := []string{"prog.score", "prog.frames", "prog.handlers"}; !slices.Equal(globals, want) {
+		t.Errorf("kept globals %v, want %v", globals, want)
+	}
+
+	stats := pass.Stats()
+	if !slices.Equal(stats.Functions, []string{"prog.unused", "prog.unused_too"}) || !slices.Equal(stats.Globals, []string{"prog.level"}) {
+		t.Errorf("removed %v and %v", stats.Functions, stats.Globals)
+	}
+	if stats.BytesSaved <= 2 {
+		t.Errorf("saved %d bytes", stats.BytesSaved)
+	}
+
+	// Without an entry point the module is a library and keeps everything
+	library := &ir.Module{Name: "lib", Functions: []*ir.Function{function("lib.helper")}}
+	if changed, _ := NewReachabilityPass().Run(library); changed || len(library.Functions) != 1 {
+		t.Errorf("library lost its functions")
+	}
+}
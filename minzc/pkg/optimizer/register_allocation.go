@@ -115,6 +115,19 @@ func (p *RegisterAllocationPass) computeLiveRanges(fn *ir.Function) {
 				}
 			}
 		}
+		
+		if inst.Src3 != 0 {
+			if lr, exists := p.liveRanges[inst.Src3]; exists {
+				lr.End = i
+				lr.Uses++
+			} else {
+				p.liveRanges[inst.Src3] = &LiveRange{
+					Start: 0,
+					End:   i,
+					Uses:  1,
+				}
+			}
+		}
 	}
 }
 
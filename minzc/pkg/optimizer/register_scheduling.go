@@ -0,0 +1,146 @@
+package optimizer
+
+import (
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// RegisterSchedulingPass moves each value that is read once down to the
+// instruction that reads it. The Z80 code generator keeps the result of
+// one instruction in HL (or A) for the next, and with liveness it skips
+// the spill to memory when nothing else reads the value; a load several
+// instructions ahead of its use forces a store and a reload instead.
+//
+// Only loads that read no registers are moved (constants, variables,
+// labels and addresses), so no other value's live range grows: every
+// move lowers register pressure or leaves it unchanged. For two operands
+// the second ends up next to the consumer, which is the one the code
+// generator can take straight from HL for both commutative and
+// non-commutative operations.
+type RegisterSchedulingPass struct{}
+
+// NewRegisterSchedulingPass creates a new register scheduling pass
+func NewRegisterSchedulingPass() Pass {
+	return &RegisterSchedulingPass{}
+}
+
+// Name returns the name of this pass
+func (p *RegisterSchedulingPass) Name() string {
+	return "Register Scheduling"
+}
+
+// Run schedules the instructions of every function
+func (p *RegisterSchedulingPass) Run(module *ir.Module) (bool, error) {
+	changed := false
+	for _, fn := range module.Functions {
+		if p.scheduleFunction(fn) {
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// scheduleFunction sinks single-use loads in fn to their readers
+func (p *RegisterSchedulingPass) scheduleFunction(fn *ir.Function) bool {
+	// Parameter and local registers also name the variable's memory
+	pinned := make(map[ir.Register]bool)
+	for _, param := range fn.Params {
+		pinned[param.Reg] = true
+	}
+	for _, local := range fn.Locals {
+		pinned[local.Reg] = true
+	}
+
+	uses := make(map[ir.Register]int)
+	defs := make(map[ir.Register]int)
+	for i := range fn.Instructions {
+		inst := &fn.Instructions[i]
+		for _, r := range inst.Reads() {
+			uses[r]++
+		}
+		if inst.Dest > 0 {
+			defs[inst.Dest]++
+		}
+	}
+
+	changed := false
+	for j := range fn.Instructions {
+		inst := &fn.Instructions[j]
+		for _, r := range []ir.Register{inst.Src1, inst.Src2, inst.Src3} {
+			if r <= 0 || uses[r] != 1 || defs[r] != 1 || pinned[r] {
+				continue
+			}
+			k := p.findDefinition(fn, j, r)
+			if k < 0 || k == j-1 || !p.canSink(fn, k, j, pinned) {
+				continue
+			}
+			moved := fn.Instructions[k]
+			copy(fn.Instructions[k:j-1], fn.Instructions[k+1:j])
+			fn.Instructions[j-1] = moved
+			changed = true
+		}
+	}
+	return changed
+}
+
+// findDefinition returns the index of the movable load of r in the basic
+// block before instruction j, or -1
+func (p *RegisterSchedulingPass) findDefinition(fn *ir.Function, j int, r ir.Register) int {
+	for k := j - 1; k >= 0; k-- {
+		inst := &fn.Instructions[k]
+		if isScheduleBarrier(inst) {
+			return -1
+		}
+		if inst.Dest == r {
+			if isSinkableLoad(inst) {
+				return k
+			}
+			return -1
+		}
+	}
+	return -1
+}
+
+// canSink reports whether the load at k can move past the instructions
+// up to j. Registers cannot conflict: the load reads none, and its result
+// is written and read only once. Variable loads must not pass anything
+// that may write memory, including a variable's own register.
+func (p *RegisterSchedulingPass) canSink(fn *ir.Function, k, j int, pinned map[ir.Register]bool) bool {
+	readsMemory := fn.Instructions[k].Op == ir.OpLoadVar
+	for m := k + 1; m < j; m++ {
+		inst := &fn.Instructions[m]
+		if isScheduleBarrier(inst) {
+			return false
+		}
+		if readsMemory && (pinned[inst.Dest] ||
+			!isSinkableLoad(inst) && !isArithmetic(inst) && !isComparison(inst)) {
+			return false
+		}
+	}
+	return true
+}
+
+// isSinkableLoad reports whether an instruction loads a value without
+// reading any register
+func isSinkableLoad(inst *ir.Instruction) bool {
+	switch inst.Op {
+	case ir.OpLoadConst, ir.OpLoadVar, ir.OpLoadLabel, ir.OpLoadString, ir.OpLoadAddr:
+		return inst.Src1 == 0 && inst.Src2 == 0 && len(inst.Args) == 0
+	}
+	return false
+}
+
+// isScheduleBarrier reports whether instructions cannot move across inst:
+// basic block boundaries, and source annotations that would otherwise
+// no longer enclose their code
+func isScheduleBarrier(inst *ir.Instruction) bool {
+	return inst.Op == ir.OpLabel || inst.Op == ir.OpSourceMark || inst.Op == ir.OpAsm ||
+		inst.IsBranch() || isControlFlow(inst)
+}
+
+func isComparison(inst *ir.Instruction) bool {
+	switch inst.Op {
+	case ir.OpEq, ir.OpNe, ir.OpLt, ir.OpGt, ir.OpLe, ir.OpGe:
+		return true
+	}
+	return false
+}
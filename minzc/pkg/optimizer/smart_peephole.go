@@ -164,7 +164,7 @@ func (p *SmartPeepholeOptimizationPass) getPatterns() []Pattern {
 				case ir.OpSub:
 					result = inst1.Imm - inst2.Imm
 				case ir.OpMul:
-					result = inst1.Imm * inst2.Imm
+					result = ir.FixedMul(inst1.Imm, inst2.Imm, inst3.Type)
 				case ir.OpAnd:
 					result = inst1.Imm & inst2.Imm
 				case ir.OpOr:
@@ -179,6 +179,7 @@ func (p *SmartPeepholeOptimizationPass) getPatterns() []Pattern {
 						Op:   ir.OpLoadConst,
 						Dest: inst3.Dest,
 						Imm:  result,
+						Type: inst3.Type,
 					},
 				}
 			},
@@ -369,8 +370,10 @@ func (p *SmartPeepholeOptimizationPass) cleanupFunction(fn *ir.Function) bool {
 	
 	changed := false
 	
-	// Remove dead stores
+	// Remove dead stores. Variables are loaded by name, so a store is
+	// live while anything loads its symbol.
 	uses := make(map[ir.Register]int)
+	loaded := make(map[string]bool)
 	for _, inst := range fn.Instructions {
 		if inst.Src1 != 0 {
 			uses[inst.Src1]++
@@ -378,12 +381,15 @@ func (p *SmartPeepholeOptimizationPass) cleanupFunction(fn *ir.Function) bool {
 		if inst.Src2 != 0 {
 			uses[inst.Src2]++
 		}
+		if inst.Op == ir.OpLoadVar && inst.Symbol != "" {
+			loaded[inst.Symbol] = true
+		}
 	}
 	
 	newInstructions := []ir.Instruction{}
 	for _, inst := range fn.Instructions {
 		// Skip dead stores
-		if (inst.Op == ir.OpStoreVar || inst.Op == ir.OpStoreField) && uses[inst.Dest] == 0 {
+		if (inst.Op == ir.OpStoreVar || inst.Op == ir.OpStoreField) && uses[inst.Dest] == 0 && !loaded[inst.Symbol] {
 			changed = true
 			continue
 		}
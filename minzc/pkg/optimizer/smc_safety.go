@@ -0,0 +1,135 @@
+package optimizer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// CheckSMCSafety decides, function by function, whether self-modifying
+// code is legal. SMC parameters and TRUE SMC anchors are patched into the
+// function's own code, which cannot be done once that code is in ROM: an
+// MSX cartridge, or anything placed over the machine's ROM. Such functions
+// fall back to the register or stack calling convention, and are marked
+// InROM so the backend does not turn SMC back on.
+//
+// Callers that patch the return of a ROM function (instruction patching)
+// call it plainly instead. The check runs before the optimizer, whose SMC
+// passes skip functions without SMC. A function storing into its own TSMC
+// reference parameter has no fallback, so that is an error in ROM.
+
+// AddressRange is an inclusive range of addresses
+type AddressRange struct {
+	Start, End uint16
+}
+
+// contains reports whether addr is in the range
+func (r AddressRange) contains(addr uint16) bool {
+	return addr >= r.Start && addr <= r.End
+}
+
+// SMCPlacement tells CheckSMCSafety where code goes
+type SMCPlacement struct {
+	CodeOrigin     uint16            // Functions outside any @section
+	SectionOrigins map[string]uint16 // Origin of each @section
+	ROM            []AddressRange    // Code here cannot be patched
+}
+
+// origin returns the address of the section fn is placed in
+func (p SMCPlacement) origin(fn *ir.Function) uint16 {
+	if origin, ok := p.SectionOrigins[fn.Section]; ok && fn.Section != "" {
+		return origin
+	}
+	return p.CodeOrigin
+}
+
+// inROM reports whether code at addr cannot be patched
+func (p SMCPlacement) inROM(addr uint16) bool {
+	for _, r := range p.ROM {
+		if r.contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// SMCDowngrade is a function compiled without SMC because it is in ROM
+type SMCDowngrade struct {
+	Function string
+	Address  uint16 // Origin of the function's section
+	Explicit bool   // It asked for SMC with @abi("smc")
+}
+
+// String describes the downgrade for the compiler's report
+func (d SMCDowngrade) String() string {
+	if d.Explicit {
+		return fmt.Sprintf("%s (in ROM at $%04X; @abi(\"smc\") ignored)", d.Function, d.Address)
+	}
+	return fmt.Sprintf("%s (in ROM at $%04X)", d.Function, d.Address)
+}
+
+// CheckSMCSafety turns SMC off for the functions of module whose code is
+// in ROM, and returns those that would have been patched
+func CheckSMCSafety(module *ir.Module, placement SMCPlacement) ([]SMCDowngrade, error) {
+	var downgrades []SMCDowngrade
+	rom := make(map[string]bool)
+	for _, fn := range module.Functions {
+		addr := placement.origin(fn)
+		if !placement.inROM(addr) {
+			continue
+		}
+		for _, inst := range fn.Instructions {
+			if inst.Op == ir.OpStoreTSMCRef {
+				return downgrades, fmt.Errorf("%s is in ROM at $%04X but assigns its TSMC reference parameter %s; place it in a RAM @section",
+					fn.Name, addr, inst.Symbol)
+			}
+		}
+		fn.InROM = true
+		rom[fn.Name] = true
+		smc := fn.IsSMCEnabled || fn.IsSMCDefault || fn.UsesTrueSMC
+		if (smc && len(fn.Params) > 0 || fn.NeedsPatchPoints) && !fn.IsInterrupt {
+			downgrades = append(downgrades, SMCDowngrade{
+				Function: fn.Name,
+				Address:  addr,
+				Explicit: fn.CallingConvention == "smc",
+			})
+		}
+		fn.IsSMCEnabled = false
+		fn.IsSMCDefault = false
+		fn.UsesTrueSMC = false
+		fn.NeedsPatchPoints = false
+		if fn.CallingConvention == "smc" {
+			fn.CallingConvention = ""
+		}
+		for i := range fn.Params {
+			fn.Params[i].IsTSMCRef = false
+		}
+	}
+	if len(rom) > 0 {
+		for _, fn := range module.Functions {
+			unpatchCalls(fn, rom)
+		}
+	}
+	return downgrades, nil
+}
+
+// unpatchCalls drops the instructions that patch the return sequence or
+// parameters of a function in rom, leaving plain calls
+func unpatchCalls(fn *ir.Function, rom map[string]bool) {
+	kept := fn.Instructions[:0]
+	for _, inst := range fn.Instructions {
+		switch inst.Op {
+		case ir.OpPatchTemplate, ir.OpPatchTarget:
+			if rom[strings.TrimSuffix(inst.PatchPointLabel, "_return_patch")] {
+				continue
+			}
+		case ir.OpPatchParam:
+			if rom[inst.Symbol] {
+				continue
+			}
+		}
+		kept = append(kept, inst)
+	}
+	fn.Instructions = kept
+}
@@ -0,0 +1,80 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ir"
+)
+
+func TestSMCSafety(t *testing.T) {
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	function := func(name, section string, body ...ir.Instruction) *ir.Function {
+		fn := ir.NewFunction(name, u8)
+		fn.AddParam("x", u8)
+		fn.Section = section
+		fn.Instructions = append(body, ir.Instruction{Op: ir.OpReturn, Src1: 1})
+		return fn
+	}
+	// patchedCall is the analyzer's call patching the return of name
+	patchedCall := func(name string) []ir.Instruction {
+		return []ir.Instruction{
+			{Op: ir.OpPatchTemplate, PatchPointLabel: name + "_return_patch", TemplateName: "store_u8"},
+			{Op: ir.OpPatchTarget, PatchPointLabel: name + "_return_patch", TargetAddress: "temp_result"},
+			{Op: ir.OpPatchParam, Symbol: name, ParamName: "x", Src1: 1, Type: u8},
+			{Op: ir.OpCall, Dest: 2, Symbol: name, Args: []ir.Register{1}},
+		}
+	}
+
+	main := function("prog.main", "", append(patchedCall("prog.draw"), patchedCall("prog.mix")...)...)
+	draw := function("prog.draw", "")
+	draw.NeedsPatchPoints = true
+	draw.CallingConvention = "smc"
+	mix := function("prog.mix", "ram")
+	mix.NeedsPatchPoints = true
+	module := &ir.Module{Name: "prog", Functions: []*ir.Function{main, draw, mix}}
+
+	placement := SMCPlacement{
+		CodeOrigin:     0x8000,
+		SectionOrigins: map[string]uint16{"ram": 0xC000},
+		ROM:            []AddressRange{{0x0000, 0x7FFF}, {0x8000, 0xBFFF}},
+	}
+	downgrades, err := CheckSMCSafety(module, placement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report []string
+	for _, d := range downgrades {
+		report = append(report, d.String())
+	}
+	want := `prog.main (in ROM at $8000); prog.draw (in ROM at $8000; @abi("smc") ignored)`
+	if got := strings.Join(report, "; "); got != want {
+		t.Errorf("downgrades = %s, want %s", got, want)
+	}
+
+	for _, fn := range []*ir.Function{main, draw} {
+		if fn.IsSMCEnabled || fn.IsSMCDefault || fn.NeedsPatchPoints || !fn.InROM || fn.CallingConvention != "" {
+			t.Errorf("%s keeps SMC: enabled %v, default %v, patched %v, in ROM %v, %q",
+				fn.Name, fn.IsSMCEnabled, fn.IsSMCDefault, fn.NeedsPatchPoints, fn.InROM, fn.CallingConvention)
+		}
+	}
+	if !mix.IsSMCEnabled || !mix.NeedsPatchPoints || mix.InROM {
+		t.Errorf("prog.mix in RAM lost SMC")
+	}
+
+	// The call to draw is plain, the call to mix still patches it
+	var ops []string
+	for _, inst := range main.Instructions {
+		ops = append(ops, inst.Op.String())
+	}
+	if got := strings.Join(ops, " "); got != "CALL PATCH_TEMPLATE PATCH_TARGET PATCH_PARAM CALL RETURN" {
+		t.Errorf("main = %s", got)
+	}
+
+	// A TSMC reference store cannot fall back
+	ref := function("prog.ref", "", ir.Instruction{Op: ir.OpStoreTSMCRef, Src1: 1, Symbol: "p"})
+	_, err = CheckSMCSafety(&ir.Module{Functions: []*ir.Function{ref}}, placement)
+	if err == nil || !strings.Contains(err.Error(), "prog.ref is in ROM at $8000") {
+		t.Errorf("TSMC reference store in ROM: %v", err)
+	}
+}
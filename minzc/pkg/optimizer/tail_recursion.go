@@ -42,6 +42,13 @@ func (p *TailRecursionPass) Run(module *ir.Module) (bool, error) {
 		}
 	}
 	
+	if marked := p.markTailCalls(module); marked > 0 {
+		changed = true
+		if p.diagnostics {
+			fmt.Printf("  ✅ %d tail calls between functions can jump\n", marked)
+		}
+	}
+	
 	if p.diagnostics {
 		fmt.Printf("  Total functions optimized: %d\n", p.optimized)
 		fmt.Println("=====================================")
@@ -229,6 +236,90 @@ func (p *TailRecursionPass) transformTailCall(fn *ir.Function, call TailCallInfo
 	}
 }
 
+// markTailCalls marks the calls to other functions whose result is
+// returned at once, so that the code generator can jump to the callee and
+// let it return to our caller. Mutually recursive functions, such as the
+// states of a state machine, then run in constant stack. Only calls where
+// caller and callee agree on how arguments and results travel are marked:
+// both SMC, with the arguments patched into the callee, or both register
+// based. It returns the number of calls newly marked.
+func (p *TailRecursionPass) markTailCalls(module *ir.Module) int {
+	functions := make(map[string]*ir.Function, len(module.Functions))
+	for _, fn := range module.Functions {
+		functions[fn.Name] = fn
+	}
+	
+	marked := 0
+	for _, fn := range module.Functions {
+		if fn.IsInterrupt || fn.ErrorType != nil || (fn.NeedsPatchPoints && !fn.UsesTrueSMC) {
+			continue
+		}
+		if _, ok := fn.GetMetadata("direct_return_target"); ok {
+			continue
+		}
+		for i := range fn.Instructions {
+			inst := &fn.Instructions[i]
+			if inst.Op != ir.OpCall || inst.TailCall || p.isRecursiveCall(inst, fn.Name) {
+				continue
+			}
+			callee := functions[inst.Symbol]
+			if callee == nil || !canTailCall(fn, callee, len(inst.Args)) {
+				continue
+			}
+			if p.findTailReturn(fn.Instructions, i) == -1 {
+				continue
+			}
+			inst.TailCall = true
+			marked++
+		}
+	}
+	return marked
+}
+
+// canTailCall reports whether caller may hand its return address to callee
+func canTailCall(caller, callee *ir.Function, args int) bool {
+	if callee.IsInterrupt || callee.ErrorType != nil || args != len(callee.Params) {
+		return false
+	}
+	// The callee's result must be ours as it stands
+	if caller.ReturnType != nil && !isVoidType(caller.ReturnType) {
+		if callee.ReturnType == nil || callee.ReturnType.Size() != caller.ReturnType.Size() {
+			return false
+		}
+	}
+	callerSMC := caller.IsSMCEnabled || caller.IsSMCDefault
+	calleeSMC := callee.IsSMCEnabled || callee.IsSMCDefault
+	if callerSMC || calleeSMC {
+		return callerSMC && calleeSMC
+	}
+	// Register passing only: stacked arguments would sit above the
+	// return address the callee inherits
+	return !callee.IsRecursive && args <= 2
+}
+
+// isVoidType reports whether t is the void type
+func isVoidType(t ir.Type) bool {
+	basic, ok := t.(*ir.BasicType)
+	return ok && basic.Kind == ir.TypeVoid
+}
+
+// findTailReturn returns the index of the return of the call's result
+// that follows the call with nothing but no-ops between, or -1
+func (p *TailRecursionPass) findTailReturn(instructions []ir.Instruction, callIndex int) int {
+	for i := callIndex + 1; i < len(instructions); i++ {
+		switch inst := &instructions[i]; inst.Op {
+		case ir.OpNop:
+			continue
+		case ir.OpReturn:
+			if inst.Src1 == 0 || inst.Src1 == instructions[callIndex].Dest {
+				return i
+			}
+		}
+		break
+	}
+	return -1
+}
+
 // getShortFunctionName extracts short name from full function name
 func getShortFunctionName(fullName string) string {
 	for i := len(fullName) - 1; i >= 0; i-- {
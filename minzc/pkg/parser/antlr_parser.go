@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -8,6 +9,7 @@ import (
 
 	antlr "github.com/antlr4-go/antlr/v4"
 	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/diagnostics"
 	minzparser "github.com/minz/minzc/pkg/parser/minzparser/grammar"
 )
 
@@ -53,7 +55,7 @@ func (p *AntlrParser) ParseFile(filename string) (*ast.File, error) {
 
 	// Check for errors
 	if len(p.errors) > 0 {
-		return nil, p.errors[0]
+		return nil, errors.Join(p.errors...)
 	}
 
 	// Convert to AST using visitor
@@ -78,8 +80,46 @@ type antlrErrorListener struct {
 }
 
 func (l *antlrErrorListener) SyntaxError(recognizer antlr.Recognizer, offendingSymbol interface{}, line, column int, msg string, e antlr.RecognitionException) {
-	err := fmt.Errorf("%s:%d:%d: %s", l.filename, line, column, msg)
-	*l.errors = append(*l.errors, err)
+	*l.errors = append(*l.errors, &SyntaxError{File: l.filename, Line: line, Column: column + 1, Message: msg})
+}
+
+// SyntaxError is a parse error at a 1-based line and column
+type SyntaxError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+}
+
+// Diagnostic describes the error for --error-format json
+func (e *SyntaxError) Diagnostic() diagnostics.Diagnostic {
+	return diagnostics.Diagnostic{
+		File:     e.File,
+		Line:     e.Line,
+		Column:   e.Column,
+		Severity: diagnostics.SeverityError,
+		Code:     diagnostics.CodeSyntax,
+		Message:  e.Message,
+	}
+}
+
+// startPosition returns the 1-based position of a rule's first token
+func startPosition(ctx antlr.ParserRuleContext) ast.Position {
+	tok := ctx.GetStart()
+	return ast.Position{Line: tok.GetLine(), Column: tok.GetColumn() + 1, Offset: tok.GetStart()}
+}
+
+// endPosition returns the 1-based position of a rule's last token
+func endPosition(ctx antlr.ParserRuleContext) ast.Position {
+	tok := ctx.GetStop()
+	if tok == nil {
+		return startPosition(ctx)
+	}
+	return ast.Position{Line: tok.GetLine(), Column: tok.GetColumn() + 1, Offset: tok.GetStart()}
 }
 
 // antlrVisitor converts ANTLR parse tree to MinZ AST
@@ -92,6 +132,7 @@ type antlrVisitor struct {
 // VisitSourceFile converts the root node
 func (v *antlrVisitor) VisitSourceFile(ctx *minzparser.SourceFileContext) interface{} {
 	file := &ast.File{
+		Name:         v.filename,
 		Imports:      []*ast.ImportStmt{},
 		Declarations: []ast.Declaration{},
 	}
@@ -190,8 +231,10 @@ func (v *antlrVisitor) VisitDeclaration(ctx *minzparser.DeclarationContext) inte
 // VisitFunctionDeclaration converts function declarations
 func (v *antlrVisitor) VisitFunctionDeclaration(ctx *minzparser.FunctionDeclarationContext) interface{} {
 	fn := &ast.FunctionDecl{
-		Params: []*ast.Parameter{},
-		Body:   &ast.BlockStmt{Statements: []ast.Statement{}},
+		Params:   []*ast.Parameter{},
+		Body:     &ast.BlockStmt{Statements: []ast.Statement{}},
+		StartPos: startPosition(ctx),
+		EndPos:   endPosition(ctx),
 	}
 
 	// Visibility
@@ -276,6 +319,7 @@ func (v *antlrVisitor) VisitParameter(ctx *minzparser.ParameterContext) interfac
 	
 	if idCtx := ctx.IDENTIFIER(); idCtx != nil {
 		param.Name = idCtx.GetText()
+		param.IsSelf = param.Name == "self"
 	}
 	
 	if typeCtx := ctx.Type_(); typeCtx != nil {
@@ -360,6 +404,16 @@ func (v *antlrVisitor) VisitEnumDeclaration(ctx *minzparser.EnumDeclarationConte
 
 	if membersCtx := ctx.EnumMemberList(); membersCtx != nil {
 		enum.Variants = v.VisitEnumMemberList(membersCtx.(*minzparser.EnumMemberListContext)).([]string)
+		for _, memberCtx := range membersCtx.(*minzparser.EnumMemberListContext).AllEnumMember() {
+			idCtx, exprCtx := memberCtx.IDENTIFIER(), memberCtx.Expression()
+			if idCtx == nil || exprCtx == nil {
+				continue
+			}
+			if enum.Values == nil {
+				enum.Values = make(map[string]ast.Expression)
+			}
+			enum.Values[idCtx.GetText()] = v.VisitExpression(exprCtx.(*minzparser.ExpressionContext)).(ast.Expression)
+		}
 	}
 
 	return enum
@@ -440,13 +494,14 @@ func (v *antlrVisitor) VisitImplBlock(ctx *minzparser.ImplBlockContext) interfac
 		Methods: []*ast.FunctionDecl{},
 	}
 	
-	// Get the interface and target type
+	// Get the interface and target type: impl Interface for Type
 	types := ctx.AllType_()
 	if len(types) >= 2 {
-		// impl.Trait = v.VisitType(types[0].(*minzparser.TypeContext)).(ast.Type)
-		// impl.Target = v.VisitType(types[1].(*minzparser.TypeContext)).(ast.Type)
-		// TODO: Update AST to support Trait and Target fields
+		impl.InterfaceName = types[0].GetText()
+		impl.ForType = v.VisitType(types[1].(*minzparser.TypeContext)).(ast.Type)
 	}
+	impl.StartPos = startPosition(ctx)
+	impl.EndPos = endPosition(ctx)
 	
 	// Get methods
 	for _, fnCtx := range ctx.AllFunctionDeclaration() {
@@ -728,7 +783,7 @@ func (v *antlrVisitor) VisitStatement(ctx *minzparser.StatementContext) interfac
 
 // VisitLetStatement converts let statements
 func (v *antlrVisitor) VisitLetStatement(ctx *minzparser.LetStatementContext) interface{} {
-	let := &ast.VarDecl{}
+	let := &ast.VarDecl{StartPos: startPosition(ctx), EndPos: endPosition(ctx)}
 	
 	if ctx.GetText() != "" && strings.Contains(ctx.GetText(), "mut") {
 		let.IsMutable = true
@@ -753,6 +808,8 @@ func (v *antlrVisitor) VisitLetStatement(ctx *minzparser.LetStatementContext) in
 func (v *antlrVisitor) VisitVarStatement(ctx *minzparser.VarStatementContext) interface{} {
 	varDecl := &ast.VarDecl{
 		IsMutable: true, // var is always mutable
+		StartPos:  startPosition(ctx),
+		EndPos:    endPosition(ctx),
 	}
 	
 	if idCtx := ctx.IDENTIFIER(); idCtx != nil {
@@ -810,20 +867,22 @@ func (v *antlrVisitor) extractStringLiteral(text string) string {
 // For now, stub implementations:
 
 func (v *antlrVisitor) VisitAssignmentStatement(ctx *minzparser.AssignmentStatementContext) interface{} {
-	return &ast.AssignStmt{}
+	return &ast.AssignStmt{StartPos: startPosition(ctx), EndPos: endPosition(ctx)}
 }
 
 func (v *antlrVisitor) VisitExpressionStatement(ctx *minzparser.ExpressionStatementContext) interface{} {
 	if exprCtx := ctx.Expression(); exprCtx != nil {
 		return &ast.ExpressionStmt{
 			Expression: v.VisitExpression(exprCtx.(*minzparser.ExpressionContext)).(ast.Expression),
+			StartPos:   startPosition(ctx),
+			EndPos:     endPosition(ctx),
 		}
 	}
 	return nil
 }
 
 func (v *antlrVisitor) VisitReturnStatement(ctx *minzparser.ReturnStatementContext) interface{} {
-	ret := &ast.ReturnStmt{}
+	ret := &ast.ReturnStmt{StartPos: startPosition(ctx), EndPos: endPosition(ctx)}
 	if exprCtx := ctx.Expression(); exprCtx != nil {
 		ret.Value = v.VisitExpression(exprCtx.(*minzparser.ExpressionContext)).(ast.Expression)
 	}
@@ -831,7 +890,7 @@ func (v *antlrVisitor) VisitReturnStatement(ctx *minzparser.ReturnStatementConte
 }
 
 func (v *antlrVisitor) VisitIfStatement(ctx *minzparser.IfStatementContext) interface{} {
-	stmt := &ast.IfStmt{}
+	stmt := &ast.IfStmt{StartPos: startPosition(ctx), EndPos: endPosition(ctx)}
 	
 	// Parse condition
 	if condCtx := ctx.Expression(); condCtx != nil {
@@ -866,7 +925,7 @@ func (v *antlrVisitor) VisitIfStatement(ctx *minzparser.IfStatementContext) inte
 }
 
 func (v *antlrVisitor) VisitWhileStatement(ctx *minzparser.WhileStatementContext) interface{} {
-	stmt := &ast.WhileStmt{}
+	stmt := &ast.WhileStmt{StartPos: startPosition(ctx), EndPos: endPosition(ctx)}
 	
 	// Parse condition
 	if condCtx := ctx.Expression(); condCtx != nil {
@@ -886,7 +945,7 @@ func (v *antlrVisitor) VisitWhileStatement(ctx *minzparser.WhileStatementContext
 }
 
 func (v *antlrVisitor) VisitForStatement(ctx *minzparser.ForStatementContext) interface{} {
-	stmt := &ast.ForStmt{}
+	stmt := &ast.ForStmt{StartPos: startPosition(ctx), EndPos: endPosition(ctx)}
 	
 	// Parse iterator variable
 	if idCtx := ctx.IDENTIFIER(); idCtx != nil {
@@ -911,7 +970,7 @@ func (v *antlrVisitor) VisitForStatement(ctx *minzparser.ForStatementContext) in
 }
 
 func (v *antlrVisitor) VisitLoopStatement(ctx *minzparser.LoopStatementContext) interface{} {
-	stmt := &ast.LoopStmt{}
+	stmt := &ast.LoopStmt{StartPos: startPosition(ctx), EndPos: endPosition(ctx)}
 	
 	// Parse body
 	if blockCtx := ctx.Block(); blockCtx != nil {
@@ -1046,8 +1105,15 @@ func (v *antlrVisitor) VisitBlockStatement(ctx *minzparser.BlockStatementContext
 }
 
 func (v *antlrVisitor) VisitDeferStatement(ctx *minzparser.DeferStatementContext) interface{} {
-	// Defer statements not yet in AST
-	return &ast.ExpressionStmt{}
+	stmt := &ast.DeferStmt{StartPos: startPosition(ctx), EndPos: endPosition(ctx)}
+	if blockCtx := ctx.Block(); blockCtx != nil {
+		stmt.Body = v.VisitBlock(blockCtx.(*minzparser.BlockContext)).(*ast.BlockStmt)
+	} else if exprCtx := ctx.Expression(); exprCtx != nil {
+		if expr, ok := v.VisitExpression(exprCtx.(*minzparser.ExpressionContext)).(ast.Expression); ok {
+			stmt.Body = &ast.ExpressionStmt{Expression: expr, StartPos: stmt.StartPos, EndPos: stmt.EndPos}
+		}
+	}
+	return stmt
 }
 
 func (v *antlrVisitor) VisitAsmStatement(ctx *minzparser.AsmStatementContext) interface{} {
@@ -1399,19 +1465,51 @@ func (v *antlrVisitor) applyPostfixOperator(expr ast.Expression, ctx *minzparser
 		}
 	}
 	
-	// Field access: .identifier
+	// Field access: .identifier, or a method call: .identifier(args)
 	if idCtx := ctx.IDENTIFIER(); idCtx != nil {
-		return &ast.FieldExpr{
+		field := &ast.FieldExpr{
 			Object: expr,
 			Field:  idCtx.GetText(),
 		}
+		if !strings.HasSuffix(ctx.GetText(), ")") {
+			return field
+		}
+		args := []ast.Expression{}
+		if argsCtx := ctx.ArgumentList(); argsCtx != nil {
+			for _, argCtx := range argsCtx.(*minzparser.ArgumentListContext).AllExpression() {
+				args = append(args, v.VisitExpression(argCtx.(*minzparser.ExpressionContext)).(ast.Expression))
+			}
+		}
+		return &ast.CallExpr{
+			Function:  field,
+			Arguments: args,
+		}
 	}
 	
-	// Function call: (args)
-	if argsCtx := ctx.ArgumentList(); argsCtx != nil {
+	// Iterator methods taking a lambda: .map(|x| ...), .filter(...), .forEach(...)
+	if lambdaCtx := ctx.LambdaExpression(); lambdaCtx != nil {
+		text := ctx.GetText()
+		method := strings.TrimPrefix(text[:strings.Index(text, "(")], ".")
+		args := []ast.Expression{}
+		if lambda, ok := v.VisitLambdaExpression(lambdaCtx.(*minzparser.LambdaExpressionContext)).(ast.Expression); ok {
+			args = append(args, lambda)
+		}
+		return &ast.CallExpr{
+			Function: &ast.FieldExpr{
+				Object: expr,
+				Field:  method,
+			},
+			Arguments: args,
+		}
+	}
+	
+	// Function call: (args) or ()
+	if argsCtx := ctx.ArgumentList(); argsCtx != nil || ctx.GetText() == "()" {
 		args := []ast.Expression{}
-		for _, argCtx := range argsCtx.(*minzparser.ArgumentListContext).AllExpression() {
-			args = append(args, v.VisitExpression(argCtx.(*minzparser.ExpressionContext)).(ast.Expression))
+		if argsCtx != nil {
+			for _, argCtx := range argsCtx.(*minzparser.ArgumentListContext).AllExpression() {
+				args = append(args, v.VisitExpression(argCtx.(*minzparser.ExpressionContext)).(ast.Expression))
+			}
 		}
 		return &ast.CallExpr{
 			Function:  expr,
@@ -1507,7 +1605,7 @@ func (v *antlrVisitor) VisitLiteral(ctx *minzparser.LiteralContext) interface{}
 	
 	if str := ctx.StringLiteral(); str != nil {
 		return &ast.StringLiteral{
-			Value: v.extractStringLiteral(str.GetText()),
+			Value: unescapeString(v.extractStringLiteral(str.GetText())),
 		}
 	}
 	
@@ -1563,19 +1661,8 @@ func (v *antlrVisitor) VisitNumberLiteral(ctx *minzparser.NumberLiteralContext)
 		}
 	}
 	
-	// Decimal
-	if strings.Contains(text, ".") {
-		// Float - convert to fixed-point
-		value, _ := strconv.ParseFloat(text, 64)
-		return &ast.NumberLiteral{
-			Value: int64(value * 256), // Fixed-point representation
-		}
-	}
-	
-	value, _ := strconv.ParseInt(text, 10, 64)
-	return &ast.NumberLiteral{
-		Value: value,
-	}
+	// Decimal, possibly fractional; the analyzer scales it to the fixed-point type
+	return parseNumberLiteral(text)
 }
 
 // VisitArrayLiteral handles array literals
@@ -1822,11 +1909,23 @@ func (v *antlrVisitor) VisitPointerType(ctx *minzparser.PointerTypeContext) inte
 
 // VisitFunctionType handles function type definitions
 func (v *antlrVisitor) VisitFunctionType(ctx *minzparser.FunctionTypeContext) interface{} {
-	// Function types are not fully supported in AST yet
-	// Return a type identifier for now
-	return &ast.TypeIdentifier{
-		Name: "function",
+	fn := &ast.FunctionType{
+		ParamTypes: []ast.Type{},
+	}
+
+	if listCtx := ctx.TypeList(); listCtx != nil {
+		for _, typeCtx := range listCtx.(*minzparser.TypeListContext).AllType_() {
+			fn.ParamTypes = append(fn.ParamTypes, v.VisitType(typeCtx.(*minzparser.TypeContext)).(ast.Type))
+		}
 	}
+
+	if retCtx := ctx.ReturnType(); retCtx != nil {
+		fn.ReturnType = v.VisitReturnType(retCtx.(*minzparser.ReturnTypeContext)).(ast.Type)
+	} else {
+		fn.ReturnType = &ast.PrimitiveType{Name: "void"}
+	}
+
+	return fn
 }
 
 // VisitStructType handles struct type definitions
@@ -314,6 +314,10 @@ func (p *Parser) convertEnumPattern(node *SExpNode) ast.Pattern {
 					enumPattern.Variant = p.getNodeText(variantNode)
 				}
 				i++
+			} else if child.Text == "binding:" && i+1 < len(node.Children) {
+				// A name bound to the payload, or _ to skip it
+				enumPattern.Bindings = append(enumPattern.Bindings, p.getNodeText(node.Children[i+1]))
+				i++
 			}
 		}
 	}
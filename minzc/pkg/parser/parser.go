@@ -1195,12 +1195,10 @@ func (p *Parser) parseExpression(node map[string]interface{}) ast.Expression {
 			EndPos:        p.getPosition(node, "endPosition"),
 		}
 	case "number_literal":
-		value, _ := strconv.ParseInt(p.getText(node), 0, 64)
-		return &ast.NumberLiteral{
-			Value:    value,
-			StartPos: p.getPosition(node, "startPosition"),
-			EndPos:   p.getPosition(node, "endPosition"),
-		}
+		lit := parseNumberLiteral(p.getText(node))
+		lit.StartPos = p.getPosition(node, "startPosition")
+		lit.EndPos = p.getPosition(node, "endPosition")
+		return lit
 	case "boolean_literal":
 		return &ast.BooleanLiteral{
 			Value:    p.getText(node) == "true",
@@ -2251,3 +2249,16 @@ func (p *Parser) parseCaseStmt(node map[string]interface{}) *ast.CaseStmt {
 }
 
 // parseCaseArm parses a case arm
+
+// parseNumberLiteral converts number literal text (decimal, 0x hex, 0b binary
+// or a decimal with a fractional part such as 3.5) to a NumberLiteral.
+// Fractional literals keep their exact value; the analyzer scales them to
+// the fixed-point type they are used with.
+func parseNumberLiteral(text string) *ast.NumberLiteral {
+	if strings.Contains(text, ".") && !strings.HasPrefix(strings.ToLower(text), "0x") {
+		value, _ := strconv.ParseFloat(text, 64)
+		return &ast.NumberLiteral{Value: int64(value), IsFloat: true, Float: value}
+	}
+	value, _ := strconv.ParseInt(text, 0, 64)
+	return &ast.NumberLiteral{Value: value}
+}
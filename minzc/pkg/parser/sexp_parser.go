@@ -368,9 +368,17 @@ func (p *Parser) convertAttributedDeclaration(node *SExpNode) ast.Declaration {
 		}
 	}
 	
-	// Add attribute to the declaration if it's a function
-	if funcDecl, ok := decl.(*ast.FunctionDecl); ok && attr != nil {
-		funcDecl.Attributes = []*ast.Attribute{attr}
+	// Add attribute to the declaration; nested attributed declarations
+	// are converted first, so prepend to keep source order
+	if attr != nil {
+		switch d := decl.(type) {
+		case *ast.FunctionDecl:
+			d.Attributes = append([]*ast.Attribute{attr}, d.Attributes...)
+		case *ast.VarDecl:
+			d.Attributes = append([]*ast.Attribute{attr}, d.Attributes...)
+		case *ast.EnumDecl:
+			d.Attributes = append([]*ast.Attribute{attr}, d.Attributes...)
+		}
 	}
 	
 	return decl
@@ -442,6 +450,8 @@ func (p *Parser) convertStatement(node *SExpNode) ast.Statement {
 		return p.convertFunction(node)
 	case "return_statement":
 		return p.convertReturnStmt(node)
+	case "defer_statement":
+		return p.convertDeferStmt(node)
 	case "if_statement":
 		return p.convertIfStmt(node)
 	case "while_statement":
@@ -468,6 +478,8 @@ func (p *Parser) convertStatement(node *SExpNode) ast.Statement {
 		return p.convertTargetBlock(node)
 	case "compile_time_asm":
 		return p.convertCompileTimeAsm(node)
+	case "compile_time_for":
+		return p.convertCompileTimeFor(node)
 	}
 	return nil
 }
@@ -747,12 +759,10 @@ func (p *Parser) convertExpressionNode(node *SExpNode) ast.Expression {
 			EndPos:     node.EndPos,
 		}
 	case "number_literal":
-		val, _ := strconv.ParseInt(p.getNodeText(node), 0, 64)
-		return &ast.NumberLiteral{
-			Value:    val,
-			StartPos: node.StartPos,
-			EndPos:   node.EndPos,
-		}
+		lit := parseNumberLiteral(p.getNodeText(node))
+		lit.StartPos = node.StartPos
+		lit.EndPos = node.EndPos
+		return lit
 	case "identifier":
 		return &ast.Identifier{
 			Name:     p.getNodeText(node),
@@ -842,6 +852,8 @@ func (p *Parser) convertExpressionNode(node *SExpNode) ast.Expression {
 	case "cast_expression":
 		// Convert cast expression properly
 		return p.convertCastExpr(node)
+	case "inline_assembly":
+		return p.convertInlineAssembly(node)
 	case "try_expression":
 		// Convert the try expression (? operator for error propagation)
 		return p.convertTryExpr(node)
@@ -1099,6 +1111,62 @@ func (p *Parser) convertFieldExpr(node *SExpNode) *ast.FieldExpr {
 	return fieldExpr
 }
 
+// convertInlineAssembly converts asm("code" : outputs : inputs : clobbers)
+func (p *Parser) convertInlineAssembly(node *SExpNode) *ast.InlineAssembly {
+	asm := &ast.InlineAssembly{
+		StartPos: node.StartPos,
+		EndPos:   node.EndPos,
+	}
+	
+	// Operands are "constraint"(expression)
+	operands := func(list *SExpNode) []*ast.AsmOperand {
+		var result []*ast.AsmOperand
+		for _, child := range list.Children {
+			if child.Type != "asm_output" && child.Type != "asm_input" {
+				continue
+			}
+			operand := &ast.AsmOperand{}
+			for _, part := range child.Children {
+				switch part.Type {
+				case "string_literal":
+					operand.Constraint = p.asmString(part)
+				case "identifier", "expression":
+					operand.Expr = p.convertExpressionNode(part)
+				}
+			}
+			result = append(result, operand)
+		}
+		return result
+	}
+	
+	for _, child := range node.Children {
+		switch child.Type {
+		case "string_literal":
+			asm.Code = p.asmString(child)
+		case "asm_output_list":
+			asm.Outputs = operands(child)
+		case "asm_input_list":
+			asm.Inputs = operands(child)
+		case "asm_clobber_list":
+			for _, clobber := range child.Children {
+				if clobber.Type == "string_literal" {
+					asm.Clobbers = append(asm.Clobbers, p.asmString(clobber))
+				}
+			}
+		}
+	}
+	return asm
+}
+
+// asmString returns the value of a string literal of inline assembly
+func (p *Parser) asmString(node *SExpNode) string {
+	text := p.getNodeText(node)
+	if len(text) >= 2 && text[0] == '"' && text[len(text)-1] == '"' {
+		text = text[1 : len(text)-1]
+	}
+	return unescapeString(text)
+}
+
 func (p *Parser) convertCastExpr(node *SExpNode) *ast.CastExpr {
 	castExpr := &ast.CastExpr{
 		StartPos: node.StartPos,
@@ -1559,14 +1627,20 @@ func (p *Parser) convertEnumDecl(node *SExpNode) *ast.EnumDecl {
 	// In Z80, enums are just u8 values: 0, 1, 2, 3...
 	for _, child := range node.Children {
 		if child.Type == "enum_variant" {
-			// Get variant name
+			// Get variant name, then the types of any payload
+			variantName := ""
 			for _, varChild := range child.Children {
-				if varChild.Type == "identifier" {
-					variantName := p.getNodeText(varChild)
+				switch {
+				case varChild.Type == "identifier" && variantName == "":
+					variantName = p.getNodeText(varChild)
 					if variantName != "" {
 						enumDecl.Variants = append(enumDecl.Variants, variantName)
 					}
-					break
+				case varChild.Type == "type" && variantName != "":
+					if enumDecl.Payloads == nil {
+						enumDecl.Payloads = make(map[string][]ast.Type)
+					}
+					enumDecl.Payloads[variantName] = append(enumDecl.Payloads[variantName], p.convertType(varChild))
 				}
 			}
 		}
@@ -1622,6 +1696,28 @@ func (p *Parser) convertIfStmt(node *SExpNode) *ast.IfStmt {
 	return ifStmt
 }
 
+func (p *Parser) convertDeferStmt(node *SExpNode) *ast.DeferStmt {
+	deferStmt := &ast.DeferStmt{
+		StartPos: node.StartPos,
+		EndPos:   node.EndPos,
+	}
+	
+	// defer <statement>, where the statement is usually a block
+	for _, child := range node.Children {
+		if child.Type != "statement" || len(child.Children) == 0 {
+			continue
+		}
+		inner := child.Children[0]
+		if inner.Type == "block_statement" && len(inner.Children) > 0 {
+			deferStmt.Body = p.convertBlock(inner.Children[0])
+		} else {
+			deferStmt.Body = p.convertStatement(inner)
+		}
+	}
+	
+	return deferStmt
+}
+
 func (p *Parser) convertWhileStmt(node *SExpNode) *ast.WhileStmt {
 	whileStmt := &ast.WhileStmt{
 		StartPos: node.StartPos,
@@ -1676,6 +1772,30 @@ func (p *Parser) convertForStmt(node *SExpNode) *ast.ForStmt {
 	return forStmt
 }
 
+// convertCompileTimeFor converts @for item in @fields(T) { ... }; the
+// children are the identifier, the expression and the block, as for 'for'
+func (p *Parser) convertCompileTimeFor(node *SExpNode) ast.Statement {
+	ctFor := &ast.CompileTimeFor{
+		StartPos: node.StartPos,
+		EndPos:   node.EndPos,
+	}
+	for _, child := range node.Children {
+		switch child.Type {
+		case "identifier":
+			if ctFor.Iterator == "" {
+				ctFor.Iterator = p.getNodeText(child)
+			}
+		case "expression":
+			if call, ok := p.convertExpression(child).(*ast.MetafunctionCall); ok && ctFor.Items == nil {
+				ctFor.Items = call
+			}
+		case "block":
+			ctFor.Body = p.convertBlock(child)
+		}
+	}
+	return ctFor
+}
+
 func (p *Parser) convertExpressionStmt(node *SExpNode) ast.Statement {
 	stmt := &ast.ExpressionStmt{
 		StartPos: node.StartPos,
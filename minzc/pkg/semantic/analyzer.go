@@ -44,14 +44,33 @@ type Analyzer struct {
 	functionCalls         map[string][]string // Track which functions call which
 	exprTypes             map[ast.Expression]ir.Type // Type information for expressions
 	lambdaCounter         int // Counter for generating unique lambda names
+	labelCounter          int // Counter for generating unique labels, per analysis so output is reproducible
 	registeredModules     map[string]bool // Track already registered modules to prevent duplicates
 	metafunctionProcessor *metafunction.Processor // Processor for @metafunction calls
 	errorPropagationContext *ErrorPropagationContext // Track error propagation state
 	targetBackend         string // Target backend for @target directive
 	targetPlatform        string // Target platform (zxspectrum, cpm, etc.)
+	annotateSource        bool   // Bracket loops, ifs and functions with source marks
+	sourceName            string // Base name of the file being analyzed, for source marks
+	sourcePath            string // Path of the file being analyzed, recorded on instructions
+	dropScopes            map[*ir.Function][][]dropLocal // Drop locals of each open block, per function
+	movedLocals           map[*VarSymbol]bool // Drop locals moved out, which are not dropped
+	pureFunctions         map[string]*ast.FunctionDecl // @pure functions, callable inside @comptime
+	comptimeDepth         int // Nesting of @comptime forms being folded
+	pureCallDepth         int // Nesting of @pure calls being evaluated
+	genericFunctions      map[string]*genericTemplate // Generic functions, instantiated per call
+	genericInstances      map[string]*FuncSymbol // Instances of generic functions by mangled name
+	implBlocks            map[*ast.ImplBlock]ir.Type // Implementing type of each registered impl block, nil if it failed
 	// castInterfaces        map[string]*CastInterface // Cast interfaces for compile-time dispatch (future)
 	simpleCastInterfaces  map[string]*SimpleCastInterface // Simplified cast interfaces (v0.11.0)
 	builtinModules        map[string]*BuiltinModule // Built-in module registry
+	importStack           []string // Modules being imported, outermost first, to report cycles
+	moduleFiles           []string // Modules compiled along with the main file
+	defines               map[string]interface{} // Command-line constants (-D), by name
+	constDecls            map[string]*ast.ConstDecl // Top-level constants by name, resolved on first use
+	resolvedConsts        map[*ast.ConstDecl]bool // Constants already analyzed
+	resolvingConsts       map[string]bool // Constants being resolved, to report cycles
+	im2Handler            string // Function installed by @interrupt(im2)
 }
 
 // NewAnalyzer creates a new semantic analyzer
@@ -71,6 +90,12 @@ func NewAnalyzer() *Analyzer {
 		// castInterfaces:    make(map[string]*CastInterface), // future
 		simpleCastInterfaces: make(map[string]*SimpleCastInterface),
 		builtinModules:    InitBuiltinModules(),
+		dropScopes:        make(map[*ir.Function][][]dropLocal),
+		movedLocals:       make(map[*VarSymbol]bool),
+		pureFunctions:     make(map[string]*ast.FunctionDecl),
+		genericFunctions:  make(map[string]*genericTemplate),
+		genericInstances:  make(map[string]*FuncSymbol),
+		implBlocks:        make(map[*ast.ImplBlock]ir.Type),
 	}
 	
 	return analyzer
@@ -86,6 +111,31 @@ func (a *Analyzer) SetTargetPlatform(platform string) {
 	a.targetPlatform = platform
 }
 
+// SetAnnotateSource enables source marks around loops, ifs and functions,
+// which backends emit as comments (--annotate-source)
+func (a *Analyzer) SetAnnotateSource(enabled bool) {
+	a.annotateSource = enabled
+}
+
+// SetProjectRoot makes imports resolve relative to the project root first,
+// so import foo.bar loads <root>/foo/bar.minz
+func (a *Analyzer) SetProjectRoot(root string) {
+	a.moduleLoader.SetProjectRoot(root)
+}
+
+// AddSearchPath makes imports also resolve relative to dir, after the
+// project root and the standard library
+func (a *Analyzer) AddSearchPath(dir string) {
+	a.moduleLoader.AddSearchPath(dir)
+}
+
+// AddModuleFile compiles a source file as the module importPath along with
+// the main file, as if the main file imported it
+func (a *Analyzer) AddModuleFile(importPath, filename string) {
+	a.moduleLoader.AddModuleFile(importPath, filename)
+	a.moduleFiles = append(a.moduleFiles, importPath)
+}
+
 // registerPredefinedConstants registers predefined constants like TARGET
 func (a *Analyzer) registerPredefinedConstants() {
 	// Register TARGET constant with the current platform
@@ -109,6 +159,13 @@ func (a *Analyzer) Analyze(file *ast.File) (*ir.Module, error) {
 		return nil, fmt.Errorf("template expansion failed: %w", err)
 	}
 	file = expandedFile
+	a.sourceName = filepath.Base(file.Name)
+	a.sourcePath = file.Name
+	
+	// PREPROCESSING STEP 2: @define constants and top-level @if, before the
+	// imports so that imported modules see the defines too
+	a.registerDefines()
+	file.Declarations = a.expandCompileTime(file.Declarations)
 	
 	// Set current module name
 	if file.ModuleName != "" {
@@ -124,10 +181,19 @@ func (a *Analyzer) Analyze(file *ast.File) (*ir.Module, error) {
 	// Process imports
 	for _, imp := range file.Imports {
 		if err := a.processImport(imp); err != nil {
-			a.errors = append(a.errors, err)
+			a.report(imp, err)
+		}
+	}
+	for _, name := range a.moduleFiles {
+		if err := a.processImport(&ast.ImportStmt{Path: name}); err != nil {
+			a.report(nil, err)
 		}
 	}
 
+	// Constants are resolved on first use from here on, so types and
+	// globals can use constants declared further down
+	a.collectConstants(file.Declarations)
+
 	// First pass, phase 1a: Register all type names (without processing fields/members)
 	// This allows for self-referential and mutually-referential types
 	for _, decl := range file.Declarations {
@@ -135,16 +201,16 @@ func (a *Analyzer) Analyze(file *ast.File) (*ir.Module, error) {
 		case *ast.StructDecl:
 			// Register just the struct name, not the fields yet
 			if err := a.registerStructName(d); err != nil {
-				a.errors = append(a.errors, err)
+				a.report(d, err)
 			}
 		case *ast.EnumDecl:
 			// Enums don't have forward reference issues, so we can fully register them
 			if err := a.analyzeEnumDecl(d); err != nil {
-				a.errors = append(a.errors, err)
+				a.report(d, err)
 			}
 		case *ast.InterfaceDecl:
 			if err := a.analyzeInterfaceDecl(d); err != nil {
-				a.errors = append(a.errors, err)
+				a.report(d, err)
 			}
 		}
 	}
@@ -156,12 +222,12 @@ func (a *Analyzer) Analyze(file *ast.File) (*ir.Module, error) {
 		case *ast.StructDecl:
 			// Now process the struct fields
 			if err := a.analyzeStructDecl(d); err != nil {
-				a.errors = append(a.errors, err)
+				a.report(d, err)
 			}
 		case *ast.TypeDecl:
 			// Register type aliases (including bit structs)
 			if err := a.analyzeTypeDecl(d); err != nil {
-				a.errors = append(a.errors, err)
+				a.report(d, err)
 			}
 		case *ast.InterfaceDecl:
 			// Interface already processed in first pass
@@ -176,18 +242,18 @@ func (a *Analyzer) Analyze(file *ast.File) (*ir.Module, error) {
 		case *ast.MinzBlock:
 			// Process MinZ blocks to generate code
 			if err := a.analyzeMinzBlock(d); err != nil {
-				a.errors = append(a.errors, err)
+				a.report(d, err)
 			}
 		case *ast.ExpressionDecl:
 			// Process top-level @minz expressions to generate code
 			switch expr := d.Expression.(type) {
 			case *ast.CompileTimeMinz:
 				if _, err := a.analyzeMinzExpr(expr, nil); err != nil {
-					a.errors = append(a.errors, err)
+					a.report(expr, err)
 				}
 			case *ast.MinzMetafunctionCall:
 				if _, err := a.analyzeMinzMetafunctionCall(expr, nil); err != nil {
-					a.errors = append(a.errors, err)
+					a.report(expr, err)
 				}
 			}
 		}
@@ -196,57 +262,65 @@ func (a *Analyzer) Analyze(file *ast.File) (*ir.Module, error) {
 	// First pass, phase 3: Register function signatures, constants, and global variables
 	// Now that all types are registered and @minz blocks have generated code, 
 	// we can safely process function signatures
+	var functionTables []*ast.ConstDecl
 	for _, decl := range file.Declarations {
 		switch d := decl.(type) {
 		case *ast.FunctionDecl:
 			if err := a.registerFunctionSignature(d); err != nil {
-				a.errors = append(a.errors, err)
+				a.report(d, err)
 			}
 		case *ast.VarDecl:
 			// Register global variables early so functions can reference them
 			if err := a.analyzeVarDecl(d); err != nil {
-				a.errors = append(a.errors, err)
+				a.report(d, err)
 			}
 		case *ast.ConstDecl:
-			// Register constants early as well
-			if err := a.analyzeConstDecl(d); err != nil {
-				a.errors = append(a.errors, err)
+			if isFunctionTableDecl(d) {
+				// Entries may name functions declared further down
+				functionTables = append(functionTables, d)
+				continue
+			}
+			// Register constants early as well, unless a use above resolved it
+			if err := a.declareConst(d); err != nil {
+				a.report(d, err)
 			}
 		case *ast.LuaBlock:
 			// Process Lua blocks early so functions defined in them are available
 			if err := a.analyzeLuaBlock(d); err != nil {
-				a.errors = append(a.errors, err)
+				a.report(d, err)
+			}
+		case *ast.ImplBlock:
+			// Methods are callable before their impl block
+			if err := a.registerImplBlock(d); err != nil {
+				a.report(d, err)
 			}
 		case *ast.MinzBlock:
 			// Already processed in phase 2
 			continue
 		}
 	}
+	for _, table := range functionTables {
+		if err := a.analyzeConstDecl(table); err != nil {
+			a.report(table, err)
+		}
+	}
 
 	// Second pass: Process all declarations (including generated ones)
 	for _, decl := range file.Declarations {
 		if err := a.analyzeDeclaration(decl); err != nil {
-			a.errors = append(a.errors, err)
+			a.report(decl, err)
 		}
 	}
 	
 	// Also process generated declarations from @minz blocks
 	for _, decl := range a.generatedDeclarations {
 		if err := a.analyzeDeclaration(decl); err != nil {
-			a.errors = append(a.errors, err)
+			a.report(decl, err)
 		}
 	}
 
 	if len(a.errors) > 0 {
-		// Build detailed error message
-		var errMsg string
-		for i, err := range a.errors {
-			if i > 0 {
-				errMsg += "\n"
-			}
-			errMsg += fmt.Sprintf("  %d. %v", i+1, err)
-		}
-		return nil, fmt.Errorf("semantic analysis failed with %d errors:\n%s", len(a.errors), errMsg)
+		return nil, &AnalysisError{Errors: a.errors}
 	}
 
 	return a.module, nil
@@ -420,23 +494,8 @@ func (a *Analyzer) addBuiltins() {
 		IsBuiltin: true,
 	})
 	
-	// format - format a string with arguments (simplified sprintf)
-	a.currentScope.Define("format", &FuncSymbol{
-		Name: "format",
-		Params: []*ast.Parameter{
-			{Name: "fmt", Type: &ast.PrimitiveType{Name: "str"}},
-			{Name: "arg", Type: &ast.PrimitiveType{Name: "u16"}}, // Simplified: single numeric arg
-		},
-		Type: &ir.FunctionType{
-			Params: []ir.Type{
-				&ir.StringType{},
-				&ir.BasicType{Kind: ir.TypeU16},
-			},
-			Return: &ir.StringType{},
-		},
-		ReturnType: &ir.StringType{},
-		IsBuiltin: true,
-	})
+	// format(buf, fmt, args...) is variadic and handled by analyzeFormatCall;
+	// rotl8/rotr8/rotl16/rotr16 are handled by analyzeRotateCall
 	
 	// === QUICK WIN STUB FUNCTIONS ===
 	// These are the most commonly missing functions that block tests
@@ -648,6 +707,11 @@ func (a *Analyzer) processImport(imp *ast.ImportStmt) error {
 		// Module already loaded - no need to do anything for duplicate imports
 		return nil
 	}
+	for i, loading := range a.importStack {
+		if loading == moduleName {
+			return fmt.Errorf("import cycle: %s", strings.Join(append(a.importStack[i:], moduleName), " -> "))
+		}
+	}
 	
 	// First check built-in modules
 	if builtinModule, ok := a.builtinModules[moduleName]; ok {
@@ -750,6 +814,12 @@ func (a *Analyzer) registerModuleAlias(originalModule, alias string) {
 		}
 	}
 	
+	for name, overloads := range a.currentScope.overloads {
+		if strings.HasPrefix(name, originalModule+".") {
+			a.currentScope.overloads[alias+"."+strings.TrimPrefix(name, originalModule+".")] = overloads
+		}
+	}
+	
 	// Debug: if no aliases created, something is wrong
 	if aliasCount == 0 {
 		fmt.Printf("Warning: No symbols found with prefix '%s.' to alias to '%s.'\n", originalModule, alias)
@@ -770,7 +840,12 @@ func (a *Analyzer) registerModuleAlias(originalModule, alias string) {
 	}
 }
 
-// processLoadedModule analyzes a loaded module and registers its exports
+// processLoadedModule analyzes a module loaded from a file. Its
+// declarations are analyzed like those of the main file, with every name
+// prefixed by the import path (util.math.quad), in a scope of their own:
+// only the public functions and the module's types, constants and
+// variables are visible to the importer, but all functions are compiled,
+// since the public ones may call the private ones.
 func (a *Analyzer) processLoadedModule(module *LoadedModule, imp *ast.ImportStmt) error {
 	// Always use the full path as the primary module prefix
 	modulePrefix := imp.Path
@@ -780,173 +855,125 @@ func (a *Analyzer) processLoadedModule(module *LoadedModule, imp *ast.ImportStmt
 		Name: modulePrefix,
 	})
 	
-	// If an alias is specified, also register the alias
-	if imp.Alias != "" {
-		a.currentScope.Define(imp.Alias, &ModuleSymbol{
-			Name: imp.Alias,
-		})
-	}
-	
 	// Save current module context
 	prevModule := a.currentModule
-	// Set current module to the prefix being used for symbols
+	prevScope := a.currentScope
 	a.currentModule = modulePrefix
-	defer func() { a.currentModule = prevModule }()
+	a.currentScope = NewScope(prevScope)
+	a.importStack = append(a.importStack, modulePrefix)
+	moduleScope := a.currentScope
+	defer func() {
+		a.currentModule = prevModule
+		a.currentScope = prevScope
+		a.importStack = a.importStack[:len(a.importStack)-1]
+	}()
 	
-	// First pass: register all exported symbols
-	for _, item := range module.File.Declarations {
-		switch decl := item.(type) {
-		case *ast.FunctionDecl:
-			if decl.IsPublic || decl.IsExport {
-				// Register exported function
-				fnName := modulePrefix + "." + decl.Name
-				returnType, err := a.convertType(decl.ReturnType)
-				if err != nil {
-					return fmt.Errorf("invalid return type for function %s: %w", decl.Name, err)
-				}
-				
-				// Convert parameters to get proper type information
-				var paramTypes []ir.Type
-				for _, param := range decl.Params {
-					paramType, err := a.convertType(param.Type)
-					if err != nil {
-						return fmt.Errorf("invalid parameter type for function %s: %w", decl.Name, err)
-					}
-					paramTypes = append(paramTypes, paramType)
-				}
-				
-				funcSym := &FuncSymbol{
-					Name:       fnName,
-					ReturnType: returnType,
-					Params:     decl.Params,
-					Type: &ir.FunctionType{
-						Params: paramTypes,
-						Return: returnType,
-					},
-				}
-				
-				// Register with unmangled name for module access
-				a.currentScope.Define(fnName, funcSym)
-				
-				// Also register with mangled name for overloading support
-				mangledName := generateMangledNameFromTypes(fnName, paramTypes)
-				a.currentScope.Define(mangledName, funcSym)
-			}
-		case *ast.ConstDecl:
-			if decl.IsPublic {
-				// Register exported constant
-				constName := modulePrefix + "." + decl.Name
-				constType, err := a.convertType(decl.Type)
-				if err != nil {
-					return fmt.Errorf("invalid type for constant %s: %w", decl.Name, err)
-				}
-				
-				// For now, we can't evaluate the value without full analysis
-				// So we'll just register the type
-				a.currentScope.Define(constName, &ConstSymbol{
-					Name: constName,
-					Type: constType,
-				})
-			}
-		case *ast.VarDecl:
-			if decl.IsPublic {
-				// Register exported variable
-				varName := modulePrefix + "." + decl.Name
-				varType, err := a.convertType(decl.Type)
-				if err != nil {
-					return fmt.Errorf("invalid type for variable %s: %w", decl.Name, err)
-				}
-				
-				a.currentScope.Define(varName, &VarSymbol{
-					Name: varName,
-					Type: varType,
-				})
-			}
+	// The module's own imports come first
+	for _, sub := range module.File.Imports {
+		if err := a.processImport(sub); err != nil {
+			return err
 		}
 	}
+	module.File.Declarations = a.expandCompileTime(module.File.Declarations)
 	
-	// If an alias was specified, create alias symbols for all exported items
-	if imp.Alias != "" {
-		a.registerModuleAlias(imp.Path, imp.Alias)
-	}
-	
-	// Second pass: analyze all module declarations (constants, types, etc.)
-	// This ensures that module functions can reference module-level symbols
+	// Types first, so signatures can use them
 	for _, decl := range module.File.Declarations {
 		switch d := decl.(type) {
-		case *ast.ConstDecl:
-			// Analyze all constants (not just public ones) so functions can use them
-			if err := a.analyzeConstDecl(d); err != nil {
-				// Log warning but continue
-				fmt.Printf("Warning: failed to analyze constant %s: %v\n", d.Name, err)
+		case *ast.StructDecl:
+			if err := a.registerStructName(d); err != nil {
+				return err
 			}
-		case *ast.VarDecl:
-			// Analyze all variables
-			if err := a.analyzeVarDecl(d); err != nil {
-				// Log warning but continue
-				fmt.Printf("Warning: failed to analyze variable %s: %v\n", d.Name, err)
+		case *ast.EnumDecl:
+			if err := a.analyzeEnumDecl(d); err != nil {
+				return err
 			}
+		}
+	}
+	for _, decl := range module.File.Declarations {
+		switch d := decl.(type) {
 		case *ast.StructDecl:
-			// Analyze struct types
 			if err := a.analyzeStructDecl(d); err != nil {
-				// Log warning but continue
-				fmt.Printf("Warning: failed to analyze struct %s: %v\n", d.Name, err)
+				return err
+			}
+		case *ast.TypeDecl:
+			if err := a.analyzeTypeDecl(d); err != nil {
+				return err
 			}
+		}
+	}
+	
+	// Then function signatures, constants and variables
+	public := make(map[string]bool)
+	for _, decl := range module.File.Declarations {
+		switch d := decl.(type) {
 		case *ast.FunctionDecl:
-			// Analyze function declarations (to generate code for them)
+			if err := a.registerFunctionSignature(d); err != nil {
+				return err
+			}
 			if d.IsPublic || d.IsExport {
-				// Temporarily adjust the function name to include module prefix
-				originalName := d.Name
-				d.Name = modulePrefix + "." + d.Name
-				
-				if err := a.analyzeFunctionDecl(d); err != nil {
-					// Log warning but continue
-					fmt.Printf("Warning: failed to analyze function %s: %v\n", d.Name, err)
-				}
-				
-				// Restore original name
-				d.Name = originalName
+				public[modulePrefix+"."+d.Name] = true
 			}
-		case *ast.EnumDecl:
-			// Analyze enum types
-			if err := a.analyzeEnumDecl(d); err != nil {
-				// Log warning but continue
-				fmt.Printf("Warning: failed to analyze enum %s: %v\n", d.Name, err)
+		case *ast.ConstDecl:
+			if err := a.analyzeConstDecl(d); err != nil {
+				return err
+			}
+		case *ast.VarDecl:
+			if err := a.analyzeVarDecl(d); err != nil {
+				return err
 			}
 		}
 	}
 	
-	// Third pass: analyze exported functions to generate IR
-	for _, item := range module.File.Declarations {
-		switch decl := item.(type) {
-		case *ast.FunctionDecl:
-			if decl.IsPublic || decl.IsExport {
-				// Register the function in the symbol table first
-				fnSym := a.currentScope.Lookup(modulePrefix + "." + decl.Name)
-				if fnSym == nil {
-					fmt.Printf("Warning: function %s.%s not found in symbol table\n", modulePrefix, decl.Name)
-					continue
-				}
-				
-				// Create a copy of the function decl with prefixed name
-				// This ensures the IR function has the correct qualified name
-				prefixedDecl := *decl
-				prefixedDecl.Name = modulePrefix + "." + decl.Name
-				
-				// Analyze the function to generate IR
-				if err := a.analyzeFunctionDecl(&prefixedDecl); err != nil {
-					// Skip functions that fail to analyze (e.g., due to inline assembly)
-					// but still allow the module to load
-					fmt.Printf("Warning: skipping function %s due to analysis error: %v\n", decl.Name, err)
-					continue
-				}
+	// Function bodies, attributed to the module's file
+	prevName, prevPath := a.sourceName, a.sourcePath
+	a.sourceName, a.sourcePath = filepath.Base(module.File.Name), module.File.Name
+	defer func() { a.sourceName, a.sourcePath = prevName, prevPath }()
+	for _, decl := range module.File.Declarations {
+		if fn, ok := decl.(*ast.FunctionDecl); ok {
+			if err := a.analyzeFunctionDecl(fn); err != nil {
+				return err
 			}
 		}
 	}
 	
+	// Export the prefixed names to the importer, hiding private functions
+	for name, sym := range moduleScope.symbols {
+		if !strings.HasPrefix(name, modulePrefix+".") {
+			continue
+		}
+		if base, isFunc := moduleFunctionName(name, sym); isFunc && !public[base] {
+			continue
+		}
+		prevScope.Define(name, sym)
+	}
+	for name, overloads := range moduleScope.overloads {
+		if public[name] {
+			prevScope.overloads[name] = overloads
+		}
+	}
+	
+	// If an alias was specified, create alias symbols for all exported items
+	if imp.Alias != "" {
+		a.currentScope = prevScope
+		a.registerModuleAlias(imp.Path, imp.Alias)
+	}
+	
 	return nil
 }
 
+// moduleFunctionName returns the unmangled name of a function symbol
+// (util.math.quad for util.math.quad$u8) and whether sym is a function
+func moduleFunctionName(name string, sym Symbol) (string, bool) {
+	switch sym.(type) {
+	case *FuncSymbol, *FunctionOverloadSet:
+		if i := strings.Index(name, "$"); i >= 0 {
+			name = name[:i]
+		}
+		return name, true
+	}
+	return name, false
+}
+
 // registerScreenModule registers screen module functions
 func (a *Analyzer) registerScreenModule() {
 	// Register screen as a module
@@ -1249,6 +1276,13 @@ func (a *Analyzer) analyzeDeclaration(decl ast.Declaration) error {
 // registerFunctionSignature registers a function's signature in the symbol table
 // This is called in the first pass to allow forward references
 func (a *Analyzer) registerFunctionSignature(fn *ast.FunctionDecl) error {
+	if len(fn.GenericParams) > 0 {
+		return a.registerGenericFunction(fn)
+	}
+	if hasAttribute(fn.Attributes, "pure") {
+		a.pureFunctions[fn.Name] = fn
+	}
+	
 	// Convert return type
 	returnType, err := a.convertType(fn.ReturnType)
 	if err != nil {
@@ -1338,6 +1372,11 @@ func (a *Analyzer) registerFunctionSignature(fn *ast.FunctionDecl) error {
 
 // analyzeFunctionDecl analyzes a function declaration
 func (a *Analyzer) analyzeFunctionDecl(fn *ast.FunctionDecl) error {
+	// Generic functions are analyzed per instance, from their calls
+	if len(fn.GenericParams) > 0 {
+		return nil
+	}
+	
 	// Get prefixed name
 	prefixedName := fn.Name
 	// Only add prefix if the name doesn't already contain a dot (module prefix)
@@ -1361,12 +1400,32 @@ func (a *Analyzer) analyzeFunctionDecl(fn *ast.FunctionDecl) error {
 	
 	// Create IR function with mangled name for unique identification
 	irFunc := ir.NewFunction(mangledName, funcSym.ReturnType)
+	irFunc.IsExported = fn.IsExport
 	
 	// Process @abi attributes
 	if err := a.processAbiAttributes(fn, irFunc); err != nil {
 		return fmt.Errorf("error processing @abi attributes for %s: %v", fn.Name, err)
 	}
 	
+	// Process @section attribute
+	section, err := a.processSectionAttribute(fn.Attributes)
+	if err != nil {
+		return fmt.Errorf("error processing @section attribute for %s: %v", fn.Name, err)
+	}
+	irFunc.Section = section
+	
+	// Process @hot/@cold attributes
+	hint, err := a.processHintAttributes(fn.Attributes)
+	if err != nil {
+		return fmt.Errorf("error processing attributes for %s: %v", fn.Name, err)
+	}
+	irFunc.Hint = hint
+	
+	// Process @interrupt attribute
+	if err := a.processInterruptAttribute(fn, irFunc); err != nil {
+		return fmt.Errorf("error processing @interrupt attribute for %s: %v", fn.Name, err)
+	}
+	
 	// Default to SMC unless overridden by attributes
 	if irFunc.CallingConvention == "" {
 		irFunc.IsSMCDefault = true
@@ -1435,6 +1494,9 @@ func (a *Analyzer) analyzeFunctionDecl(fn *ast.FunctionDecl) error {
 	}
 
 	// Analyze function body
+	if a.annotateSource {
+		a.emitSourceMark(irFunc, fmt.Sprintf("begin function %s (%s)", fn.Name, a.sourceLocation(fn)))
+	}
 	if err := a.analyzeBlock(fn.Body, irFunc); err != nil {
 		return fmt.Errorf("error in function %s: %w", fn.Name, err)
 	}
@@ -1443,6 +1505,9 @@ func (a *Analyzer) analyzeFunctionDecl(fn *ast.FunctionDecl) error {
 	if len(irFunc.Instructions) == 0 || irFunc.Instructions[len(irFunc.Instructions)-1].Op != ir.OpReturn {
 		irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{Op: ir.OpReturn})
 	}
+	if a.annotateSource {
+		a.emitSourceMark(irFunc, "end")
+	}
 
 	// Finalize SMC decision based on function properties
 	// ONLY if no explicit @abi attribute was set
@@ -1504,8 +1569,20 @@ func (a *Analyzer) analyzeVarDecl(v *ast.VarDecl) error {
 		varType = t
 	}
 	
+	// A literal initializing a fixed-point global is encoded in its format
+	var fixedInit *int64
+	if num, ok := v.Value.(*ast.NumberLiteral); ok && ir.FixedFracBits(varType) > 0 {
+		raw, err := fixedLiteralValue(num, varType)
+		if err != nil {
+			return fmt.Errorf("invalid initializer for %s: %w", v.Name, err)
+		}
+		fixedInit = &raw
+	}
+	
 	// Get the inferred type from value if present
-	if v.Value != nil {
+	if fixedInit != nil {
+		inferredType = varType
+	} else if v.Value != nil {
 		t, err := a.inferType(v.Value)
 		if err != nil {
 			// If we have an explicit type, use it even if inference fails
@@ -1562,8 +1639,32 @@ func (a *Analyzer) analyzeVarDecl(v *ast.VarDecl) error {
 		Type: varType,
 	}
 	
+	// Process @section attribute
+	section, err := a.processSectionAttribute(v.Attributes)
+	if err != nil {
+		return fmt.Errorf("error processing @section attribute for %s: %v", v.Name, err)
+	}
+	global.Section = section
+	
+	// Process @at attribute
+	address, err := a.processAtAttribute(v.Attributes)
+	if err != nil {
+		return fmt.Errorf("error processing @at attribute for %s: %v", v.Name, err)
+	}
+	if address != nil {
+		if section != "" {
+			return fmt.Errorf("%s cannot be both @at and @section", v.Name)
+		}
+		if v.Value != nil {
+			return fmt.Errorf("@at global %s cannot have an initializer", v.Name)
+		}
+		global.Address = address
+	}
+	
 	// If there's an initializer, evaluate it
-	if v.Value != nil {
+	if fixedInit != nil {
+		global.Init = *fixedInit
+	} else if v.Value != nil {
 		// Try to evaluate the initializer as a constant
 		if val, err := a.evaluateConstExpr(v.Value); err == nil {
 			global.Init = val
@@ -1585,6 +1686,12 @@ func (a *Analyzer) analyzeConstDecl(c *ast.ConstDecl) error {
 	if c.Value == nil {
 		return fmt.Errorf("constant %s must have a value", c.Name)
 	}
+	if isFunctionTableDecl(c) {
+		return a.analyzeFunctionTable(c)
+	}
+	if call, ok := isIncludeBinCall(c.Value); ok {
+		return a.analyzeIncludeBinConst(c, call)
+	}
 	
 	// Determine type
 	var constType ir.Type
@@ -1792,13 +1899,47 @@ func (a *Analyzer) analyzeEnumDecl(e *ast.EnumDecl) error {
 		Variants: make(map[string]int),
 	}
 	
-	// Assign values to variants
-	for i, variant := range e.Variants {
+	// Assign values to variants, counting on from the last explicit one
+	next := 0
+	for _, variant := range e.Variants {
 		if _, exists := enumType.Variants[variant]; exists {
 			return fmt.Errorf("duplicate variant %s in enum %s", variant, e.Name)
 		}
-		enumType.Variants[variant] = i
+		if expr, ok := e.Values[variant]; ok {
+			value, err := a.enumValue(expr)
+			if err != nil {
+				return fmt.Errorf("value of %s.%s: %w", e.Name, variant, err)
+			}
+			next = value
+		}
+		for other, value := range enumType.Variants {
+			if value == next {
+				return fmt.Errorf("%s.%s and %s.%s both have value %d", e.Name, other, e.Name, variant, next)
+			}
+		}
+		enumType.Variants[variant] = next
+		next++
+	}
+	if err := a.enumPayloads(e, enumType); err != nil {
+		return err
+	}
+	
+	// Record the backing type: @repr if given, else the smallest that fits
+	backing, err := a.processReprAttribute(e.Attributes)
+	if err != nil {
+		return fmt.Errorf("error processing @repr attribute for %s: %v", e.Name, err)
+	}
+	minimal := enumType.BackingType().Kind
+	if backing == ir.TypeU8 && minimal != ir.TypeU8 {
+		if len(e.Variants) > 256 {
+			return fmt.Errorf("enum %s has %d variants, too many for @repr(u8)", e.Name, len(e.Variants))
+		}
+		return fmt.Errorf("enum %s has values above 255, too large for @repr(u8)", e.Name)
+	}
+	if backing == ir.TypeVoid {
+		backing = minimal
 	}
+	enumType.Backing = backing
 	
 	// Register enum type
 	a.currentScope.Define(e.Name, &TypeSymbol{
@@ -1927,37 +2068,63 @@ func (a *Analyzer) analyzeBlock(block *ast.BlockStmt, irFunc *ir.Function) error
 	}()
 
 	// PASS 2: Process all statements (including analyzing function bodies)
+	a.enterDropScope(irFunc)
 	for i, stmt := range block.Statements {
 		if debug {
 			fmt.Printf("DEBUG: analyzeBlock processing statement %d of type %T\n", i, stmt)
 		}
+		// Control never comes back from a computed goto
+		if isComputedGoto(stmt) && i != len(block.Statements)-1 {
+			a.exitDropScope(irFunc, false)
+			return a.errorAt(block.Statements[i+1], "unreachable code after @goto_ptr")
+		}
 		if err := a.analyzeStatement(stmt, irFunc); err != nil {
+			a.exitDropScope(irFunc, false)
 			return err
 		}
 	}
 
-	return nil
+	// A trailing return has already dropped this block's locals
+	fallsThrough := true
+	if n := len(block.Statements); n > 0 {
+		_, isReturn := block.Statements[n-1].(*ast.ReturnStmt)
+		fallsThrough = !isReturn && !isComputedGoto(block.Statements[n-1])
+	}
+	return a.exitDropScope(irFunc, fallsThrough)
 }
 
 // analyzeStatement analyzes a statement
-func (a *Analyzer) analyzeStatement(stmt ast.Statement, irFunc *ir.Function) error {
+func (a *Analyzer) analyzeStatement(stmt ast.Statement, irFunc *ir.Function) (err error) {
 	if stmt == nil {
 		return fmt.Errorf("encountered nil statement - likely a parsing error")
 	}
+	defer a.recordSourceLine(stmt, irFunc, len(irFunc.Instructions))
+	defer func() { err = a.positioned(stmt, err) }()
 	
 	switch s := stmt.(type) {
 	case *ast.VarDecl:
-		return a.analyzeVarDeclInFunc(s, irFunc)
+		if s.Value != nil {
+			a.noteMove(s.Value)
+		}
+		if err := a.analyzeVarDeclInFunc(s, irFunc); err != nil {
+			return err
+		}
+		a.trackDrop(s.Name, irFunc)
+		return nil
 	case *ast.ConstDecl:
 		return a.analyzeConstDeclInFunc(s, irFunc)
 	case *ast.ReturnStmt:
 		return a.analyzeReturnStmt(s, irFunc)
+	case *ast.DeferStmt:
+		return a.analyzeDeferStmt(s, irFunc)
 	case *ast.IfStmt:
-		return a.analyzeIfStmt(s, irFunc)
+		return a.annotated(s, "if", irFunc, func() error { return a.analyzeIfStmt(s, irFunc) })
 	case *ast.WhileStmt:
-		return a.analyzeWhileStmt(s, irFunc)
+		return a.annotated(s, "while loop", irFunc, func() error { return a.analyzeWhileStmt(s, irFunc) })
 	case *ast.ForStmt:
-		return a.analyzeForStmt(s, irFunc)
+		return a.annotated(s, "for loop", irFunc, func() error { return a.analyzeForStmt(s, irFunc) })
+	case *ast.CompileTimeFor:
+		return a.annotated(s, "@for", irFunc, func() error { return a.analyzeCompileTimeFor(s, irFunc) })
 	case *ast.CaseStmt:
 		return a.analyzeCaseStmt(s, irFunc)
 	case *ast.BlockStmt:
@@ -1973,9 +2140,10 @@ func (a *Analyzer) analyzeStatement(stmt ast.Statement, irFunc *ir.Function) err
 		_, err := a.analyzeExpression(s.Expression, irFunc)
 		return err
 	case *ast.AssignStmt:
+		a.noteMove(s.Value)
 		return a.analyzeAssignStmt(s, irFunc)
 	case *ast.LoopStmt:
-		return a.analyzeLoopStmt(s, irFunc)
+		return a.annotated(s, "loop", irFunc, func() error { return a.analyzeLoopStmt(s, irFunc) })
 	case *ast.DoTimesStmt:
 		return a.analyzeDoTimesStmt(s, irFunc)
 	case *ast.LoopAtStmt:
@@ -2118,7 +2286,7 @@ func (a *Analyzer) analyzeVarDeclInFunc(v *ast.VarDecl, irFunc *ir.Function) err
 				Type:   varType,
 			})
 		} else {
-			valueReg, err := a.analyzeExpression(v.Value, irFunc)
+			valueReg, err := a.analyzeExpressionAs(v.Value, varType, irFunc)
 			if err != nil {
 				return err
 			}
@@ -2192,10 +2360,9 @@ func (a *Analyzer) analyzeVarDeclInFunc(v *ast.VarDecl, irFunc *ir.Function) err
 						if i == 0 {
 							// First element - store directly
 							irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-								Op:      ir.OpStoreIndex,
-								Dest:    baseReg,
-								Src1:    elemReg,
-								Imm:     0,
+								Op:      ir.OpStorePtr,
+								Src1:    baseReg,
+								Src2:    elemReg,
 								Type:    arrayType.Element,
 								Comment: fmt.Sprintf("Store element %d", i),
 							})
@@ -2347,15 +2514,22 @@ func (a *Analyzer) analyzeConstDeclInFunc(c *ast.ConstDecl, irFunc *ir.Function)
 // analyzeReturnStmt analyzes a return statement
 func (a *Analyzer) analyzeReturnStmt(ret *ast.ReturnStmt, irFunc *ir.Function) error {
 	if ret.Value != nil {
-		reg, err := a.analyzeExpression(ret.Value, irFunc)
+		reg, err := a.analyzeExpressionAs(ret.Value, irFunc.ReturnType, irFunc)
 		if err != nil {
 			return err
 		}
+		a.noteMove(ret.Value)
+		if err := a.emitReturnDrops(irFunc); err != nil {
+			return err
+		}
 		irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
 			Op:   ir.OpReturn,
 			Src1: reg,
 		})
 	} else {
+		if err := a.emitReturnDrops(irFunc); err != nil {
+			return err
+		}
 		irFunc.Emit(ir.OpReturn, 0, 0, 0)
 	}
 	return nil
@@ -2363,6 +2537,18 @@ func (a *Analyzer) analyzeReturnStmt(ret *ast.ReturnStmt, irFunc *ir.Function) e
 
 // analyzeIfStmt analyzes an if statement
 func (a *Analyzer) analyzeIfStmt(ifStmt *ast.IfStmt, irFunc *ir.Function) error {
+	// A constant condition, such as a -D or @define flag, compiles only the
+	// branch it takes
+	if taken, ok := a.constantCondition(ifStmt.Condition); ok {
+		if taken {
+			return a.analyzeBlock(ifStmt.Then, irFunc)
+		}
+		if ifStmt.Else != nil {
+			return a.analyzeStatement(ifStmt.Else, irFunc)
+		}
+		return nil
+	}
+	
 	// Generate code for condition
 	condReg, err := a.analyzeExpression(ifStmt.Condition, irFunc)
 	if err != nil {
@@ -2414,23 +2600,28 @@ func (a *Analyzer) analyzeCaseStmt(caseStmt *ast.CaseStmt, irFunc *ir.Function)
 	
 	// Get the type of the expression
 	exprType := a.exprTypes[caseStmt.Value]
+	if err := checkExhaustive(caseStmt.Arms, exprType); err != nil {
+		return err
+	}
+	subjectReg := a.caseSubject(exprReg, exprType, irFunc)
 	
 	// Generate labels for each arm and the end
 	endLabel := a.generateLabel("case_end")
 	armLabels := make([]string, len(caseStmt.Arms))
+	testLabels := make([]string, len(caseStmt.Arms)+1)
 	for i := range caseStmt.Arms {
 		armLabels[i] = a.generateLabel(fmt.Sprintf("case_arm_%d", i))
+		testLabels[i] = a.generateLabel(fmt.Sprintf("case_test_%d", i))
 	}
+	testLabels[len(caseStmt.Arms)] = endLabel
 	
-	// Generate comparison and jump code for each pattern
+	// Generate comparison and jump code for each pattern; a pattern that
+	// fails moves on to the next one's test
 	for i, arm := range caseStmt.Arms {
-		nextArmLabel := endLabel
-		if i < len(caseStmt.Arms)-1 {
-			nextArmLabel = armLabels[i+1]
-		}
+		irFunc.EmitLabel(testLabels[i])
 		
 		// Analyze the pattern and generate comparison
-		if err := a.analyzePattern(arm.Pattern, exprReg, exprType, armLabels[i], nextArmLabel, irFunc); err != nil {
+		if err := a.analyzePattern(arm.Pattern, subjectReg, exprType, armLabels[i], testLabels[i+1], irFunc); err != nil {
 			return err
 		}
 	}
@@ -2457,6 +2648,12 @@ func (a *Analyzer) analyzeCaseStmt(caseStmt *ast.CaseStmt, irFunc *ir.Function)
 				IsMutable: false, // Pattern variables are immutable
 			})
 		}
+		if enumPattern, ok := arm.Pattern.(*ast.EnumPattern); ok {
+			if err := a.bindVariantPayload(enumPattern, exprReg, exprType, irFunc); err != nil {
+				a.currentScope = prevScope
+				return err
+			}
+		}
 		
 		// If there's a guard, check it
 		if arm.Guard != nil {
@@ -2472,32 +2669,28 @@ func (a *Analyzer) analyzeCaseStmt(caseStmt *ast.CaseStmt, irFunc *ir.Function)
 				return fmt.Errorf("pattern guard must be of type bool, got %v", guardType)
 			}
 			
-			// Jump to next arm if guard fails
-			nextArmLabel := endLabel
-			if i < len(caseStmt.Arms)-1 {
-				nextArmLabel = armLabels[i+1]
-			}
-			
+			// Try the next pattern if guard fails
 			irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
 				Op:    ir.OpJumpIfNot,
 				Src1:  guardReg,
-				Label: nextArmLabel,
+				Label: testLabels[i+1],
 			})
 		}
 		
-		// Analyze the arm body
+		// Analyze the arm body. A block is also an expression, so it is
+		// matched first to keep its statements, such as return, intact.
 		switch body := arm.Body.(type) {
-		case ast.Expression:
-			// Expression body - just analyze it
-			_, err := a.analyzeExpression(body, irFunc)
-			if err != nil {
+		case *ast.BlockStmt:
+			// Block body
+			if err := a.analyzeBlock(body, irFunc); err != nil {
 				// Restore scope before returning error
 				a.currentScope = prevScope
 				return err
 			}
-		case *ast.BlockStmt:
-			// Block body
-			if err := a.analyzeBlock(body, irFunc); err != nil {
+		case ast.Expression:
+			// Expression body - just analyze it
+			_, err := a.analyzeExpression(body, irFunc)
+			if err != nil {
 				// Restore scope before returning error
 				a.currentScope = prevScope
 				return err
@@ -2528,6 +2721,10 @@ func (a *Analyzer) analyzeCaseExpr(caseExpr *ast.CaseExpr, irFunc *ir.Function)
 	
 	// Get the type of the expression
 	exprType := a.exprTypes[caseExpr.Value]
+	if err := checkExhaustive(caseExpr.Arms, exprType); err != nil {
+		return 0, err
+	}
+	subjectReg := a.caseSubject(exprReg, exprType, irFunc)
 	
 	// Determine result type from first arm
 	var resultType ir.Type
@@ -2556,20 +2753,36 @@ func (a *Analyzer) analyzeCaseExpr(caseExpr *ast.CaseExpr, irFunc *ir.Function)
 	}
 	
 	// Generate comparison and jump code for each pattern
+	nextLabel := ""
 	for i, arm := range caseExpr.Arms {
-		// Generate matching code for pattern
-		nextLabel := endLabel
+		if nextLabel != "" {
+			irFunc.EmitLabel(nextLabel)
+		}
+		
+		// Generate matching code for pattern; a failed match (or guard)
+		// goes on to the next arm's test
+		nextLabel = endLabel
 		if i < len(caseExpr.Arms)-1 {
-			nextLabel = armLabels[i+1]
+			nextLabel = a.generateLabel(fmt.Sprintf("case_expr_test_%d", i+1))
 		}
 		
-		if err := a.analyzePattern(arm.Pattern, exprReg, exprType, armLabels[i], nextLabel, irFunc); err != nil {
+		if err := a.analyzePattern(arm.Pattern, subjectReg, exprType, armLabels[i], nextLabel, irFunc); err != nil {
 			return 0, err
 		}
 		
 		// Label for this arm
 		irFunc.EmitLabel(armLabels[i])
 		
+		// Payload bindings live for the arm
+		prevScope := a.currentScope
+		a.currentScope = NewScope(a.currentScope)
+		if enumPattern, ok := arm.Pattern.(*ast.EnumPattern); ok {
+			if err := a.bindVariantPayload(enumPattern, exprReg, exprType, irFunc); err != nil {
+				a.currentScope = prevScope
+				return 0, err
+			}
+		}
+		
 		// Check guard condition if present
 		if arm.Guard != nil {
 			guardReg, err := a.analyzeExpression(arm.Guard, irFunc)
@@ -2610,6 +2823,8 @@ func (a *Analyzer) analyzeCaseExpr(caseExpr *ast.CaseExpr, irFunc *ir.Function)
 			return 0, fmt.Errorf("unexpected case arm body type: %T", arm.Body)
 		}
 		
+		a.currentScope = prevScope
+		
 		// Move result to result register
 		irFunc.Emit(ir.OpMove, resultReg, bodyReg, 0)
 		
@@ -2723,6 +2938,9 @@ func (a *Analyzer) analyzePattern(pattern ast.Pattern, exprReg ir.Register, expr
 		if !exists {
 			return fmt.Errorf("enum %s has no variant %s", p.EnumType, p.Variant)
 		}
+		if subjectType, ok := exprType.(*ir.EnumType); ok && subjectType.Name != enumType.Name {
+			return fmt.Errorf("type mismatch: pattern %s.%s cannot match a %s", p.EnumType, p.Variant, subjectType.Name)
+		}
 		
 		// Create literal for the enum value
 		litReg := irFunc.AllocReg()
@@ -2730,15 +2948,17 @@ func (a *Analyzer) analyzePattern(pattern ast.Pattern, exprReg ir.Register, expr
 			Op:   ir.OpLoadImm,
 			Dest: litReg,
 			Imm:  int64(variantValue),
+			Type: enumType.BackingType(),
 		})
 		
-		// Compare against the enum value
+		// Compare against the enum value (the tag of a tagged union)
 		condReg := irFunc.AllocReg()
 		irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
 			Op:   ir.OpEq,
 			Dest: condReg,
 			Src1: exprReg,
 			Src2: litReg,
+			Type: enumType.BackingType(),
 		})
 		
 		// Jump to match label if equal
@@ -3092,28 +3312,15 @@ func (a *Analyzer) analyzeAssignStmt(stmt *ast.AssignStmt, irFunc *ir.Function)
 			return fmt.Errorf("type mismatch: array element is %s, value is %s", elementType, valueType)
 		}
 		
-		// Generate IR using two instructions approach
-		// First, calculate the address (array + index)
-		tempReg := irFunc.AllocReg()
-		
-		// For byte arrays, index is already the offset
-		// For larger elements, we'd need to multiply by element size
+		// Store the value at array[index]; the index is scaled by the
+		// element size in code generation
 		irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-			Op:   ir.OpAdd,
-			Dest: tempReg,
+			Op:   ir.OpStoreIndex,
 			Src1: arrayReg,
 			Src2: indexReg,
-			Type: &ir.PointerType{Base: elementType},
-			Comment: "Calculate array element address",
-		})
-		
-		// Store the value at the calculated address using pointer store
-		irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-			Op:   ir.OpStorePtr,
-			Src1: tempReg, // address in Src1
-			Src2: valueReg, // value in Src2
-			Type: elementType,
-			Comment: fmt.Sprintf("Store to array[index] (%s)", elementType),
+			Src3: valueReg,
+			Type: elementType,
+			Comment: fmt.Sprintf("Store to array[index] (%s)", elementType),
 		})
 		
 	case *ast.FieldExpr:
@@ -3818,6 +4025,9 @@ func (a *Analyzer) analyzeExpression(expr ast.Expression, irFunc *ir.Function) (
 	case *ast.CompileTimeError:
 		return a.analyzeErrorExpr(e, irFunc)
 	case *ast.MetafunctionCall:
+		if isComptimeCall(e) {
+			return a.analyzeComptime(e, irFunc)
+		}
 		return a.analyzeMetafunctionCall(e, irFunc)
 	case *ast.MinzMetafunctionCall:
 		return a.analyzeMinzMetafunctionCall(e, irFunc)
@@ -3844,48 +4054,72 @@ func (a *Analyzer) analyzeExpression(expr ast.Expression, irFunc *ir.Function) (
 	}
 }
 
-// analyzeInlineAssembly analyzes inline assembly
+// analyzeInlineAssembly analyzes inline assembly. Its operands are bound
+// to {N} in the code, outputs first: asm("LD {0}, {1}" : "A"(x) : "r"(y) : "HL")
+// reads y in a register of the code generator's choosing, leaves x in A and
+// may change HL. Each output is stored back to its variable afterwards.
 func (a *Analyzer) analyzeInlineAssembly(asm *ast.InlineAssembly, irFunc *ir.Function) (ir.Register, error) {
-	// Create an IR instruction for inline assembly
-	inst := &ir.Instruction{
-		Op:      ir.OpAsm,
-		AsmCode: asm.Code,
+	inst := ir.Instruction{
+		Op:          ir.OpAsm,
+		AsmCode:     asm.Code,
+		AsmClobbers: asm.Clobbers,
 	}
 	
-	// Process input operands
-	for _, input := range asm.Inputs {
-		// Analyze the input expression
-		reg, err := a.analyzeExpression(input.Expr, irFunc)
-		if err != nil {
-			return 0, fmt.Errorf("failed to analyze inline assembly input: %w", err)
+	// Outputs must be variables, which take the value after the asm
+	var stores []ir.Instruction
+	var outputReg ir.Register
+	for _, output := range asm.Outputs {
+		id, ok := output.Expr.(*ast.Identifier)
+		if !ok {
+			return 0, a.errorAt(output.Expr, "inline assembly output must be a variable")
+		}
+		sym := a.currentScope.Lookup(id.Name)
+		if sym == nil {
+			sym = a.currentScope.Lookup(a.prefixSymbol(id.Name))
+		}
+		varSym, ok := sym.(*VarSymbol)
+		if !ok {
+			return 0, a.errorAt(id, "undefined variable: %s", id.Name)
 		}
 		
-		// For now, we'll store the register in the instruction
-		// In a full implementation, we'd need to handle constraints properly
-		if inst.Src1 == 0 {
-			inst.Src1 = reg
-		} else if inst.Src2 == 0 {
-			inst.Src2 = reg
+		reg := irFunc.AllocReg()
+		inst.AsmOperands = append(inst.AsmOperands, ir.AsmOperand{
+			Reg:        reg,
+			Constraint: strings.TrimPrefix(output.Constraint, "="),
+			Output:     true,
+			Type:       varSym.Type,
+		})
+		stores = append(stores, ir.Instruction{
+			Op:     ir.OpStoreVar,
+			Dest:   varSym.Reg,
+			Src1:   reg,
+			Symbol: varSym.Name,
+		})
+		if outputReg == 0 {
+			outputReg = reg
 		}
 	}
 	
-	// Process output operands
-	var outputReg ir.Register
-	for range asm.Outputs {
-		// For outputs, we need to allocate a register
-		outputReg = irFunc.NextRegister
-		irFunc.NextRegister++
-		
-		// Store the output register
-		if inst.Dest == 0 {
-			inst.Dest = outputReg
+	for _, input := range asm.Inputs {
+		reg, err := a.analyzeExpression(input.Expr, irFunc)
+		if err != nil {
+			return 0, fmt.Errorf("failed to analyze inline assembly input: %w", err)
 		}
+		inputType, err := a.inferType(input.Expr)
+		if err != nil {
+			return 0, fmt.Errorf("failed to analyze inline assembly input: %w", err)
+		}
+		inst.AsmOperands = append(inst.AsmOperands, ir.AsmOperand{
+			Reg:        reg,
+			Constraint: input.Constraint,
+			Type:       inputType,
+		})
 	}
 	
-	// Add the instruction
-	irFunc.Instructions = append(irFunc.Instructions, *inst)
+	irFunc.Instructions = append(irFunc.Instructions, inst)
+	irFunc.Instructions = append(irFunc.Instructions, stores...)
 	
-	// Return the output register if any, otherwise 0
+	// The first output is the expression's value
 	return outputReg, nil
 }
 
@@ -4066,6 +4300,11 @@ func (a *Analyzer) analyzeIdentifier(id *ast.Identifier, irFunc *ir.Function) (i
 
 // analyzeNumberLiteral analyzes a number literal
 func (a *Analyzer) analyzeNumberLiteral(num *ast.NumberLiteral, irFunc *ir.Function) (ir.Register, error) {
+	// A fractional literal without a fixed-point context is f8.8
+	if num.IsFloat {
+		return a.analyzeExpressionAs(num, defaultFixedType, irFunc)
+	}
+	
 	reg := irFunc.AllocReg()
 	
 	// Infer type based on value
@@ -4120,15 +4359,32 @@ func (a *Analyzer) analyzeBinaryExpr(bin *ast.BinaryExpr, irFunc *ir.Function) (
 	}
 	
 	// Analyze operands
-	leftReg, err := a.analyzeExpression(bin.Left, irFunc)
+	leftReg, rightReg, err := a.analyzeBinaryOperands(bin, irFunc)
 	if err != nil {
 		return 0, err
 	}
 
-	rightReg, err := a.analyzeExpression(bin.Right, irFunc)
+	// Operator overloading: custom types implementing Add/Sub lower to a method call.
+	// Primitive types never have an implementation, so they keep the fast path below.
+	method, err := a.findOperatorMethod(bin, irFunc)
 	if err != nil {
 		return 0, err
 	}
+	if method != nil {
+		if a.currentFunc != nil {
+			a.functionCalls[a.currentFunc.Name] = append(a.functionCalls[a.currentFunc.Name], method.Name)
+		}
+		resultReg := irFunc.AllocReg()
+		irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+			Op:      ir.OpCall,
+			Dest:    resultReg,
+			Symbol:  method.Name,
+			Args:    []ir.Register{leftReg, rightReg},
+			Comment: fmt.Sprintf("operator %s", bin.Operator),
+		})
+		a.exprTypes[bin] = method.ReturnType
+		return resultReg, nil
+	}
 
 	// Generate operation
 	resultReg := irFunc.AllocReg()
@@ -4200,6 +4456,15 @@ func (a *Analyzer) analyzeBinaryExpr(bin *ast.BinaryExpr, irFunc *ir.Function) (
 		resultType = rightType
 	}
 	
+	// Fixed-point operands have their own scaling rules
+	if instType, fixedResult, ok, err := fixedBinaryTypes(bin.Operator, leftType, rightType); err != nil {
+		return 0, err
+	} else if ok {
+		a.exprTypes[bin] = fixedResult
+		irFunc.EmitTyped(op, resultReg, leftReg, rightReg, instType)
+		return resultReg, nil
+	}
+	
 	// Store the result type
 	a.exprTypes[bin] = resultType
 	
@@ -4276,8 +4541,16 @@ func (a *Analyzer) analyzeUnaryExpr(un *ast.UnaryExpr, irFunc *ir.Function) (ir.
 
 // analyzeAssignment analyzes an assignment expression
 func (a *Analyzer) analyzeAssignment(bin *ast.BinaryExpr, irFunc *ir.Function) (ir.Register, error) {
+	// A literal assigned to a fixed-point variable takes its format
+	var targetType ir.Type
+	if id, ok := bin.Left.(*ast.Identifier); ok {
+		if varSym, ok := a.currentScope.Lookup(id.Name).(*VarSymbol); ok {
+			targetType = varSym.Type
+		}
+	}
+	
 	// Analyze the right-hand side first
-	valueReg, err := a.analyzeExpression(bin.Right, irFunc)
+	valueReg, err := a.analyzeExpressionAs(bin.Right, targetType, irFunc)
 	if err != nil {
 		return 0, err
 	}
@@ -4689,10 +4962,16 @@ func (a *Analyzer) analyzeCompoundAssignment(bin *ast.BinaryExpr, irFunc *ir.Fun
 	}
 	
 	// Analyze the right-hand side
-	rightReg, err := a.analyzeExpression(bin.Right, irFunc)
+	rightReg, err := a.analyzeExpressionAs(bin.Right, varSym.Type, irFunc)
 	if err != nil {
 		return 0, err
 	}
+	instType := varSym.Type
+	if t, _, ok, err := fixedBinaryTypes(baseOp, varSym.Type, a.exprTypes[bin.Right]); err != nil {
+		return 0, err
+	} else if ok {
+		instType = t
+	}
 	
 	// Load the current value of the variable
 	currentReg := irFunc.AllocReg()
@@ -4731,7 +5010,7 @@ func (a *Analyzer) analyzeCompoundAssignment(bin *ast.BinaryExpr, irFunc *ir.Fun
 		Dest: resultReg,
 		Src1: currentReg,
 		Src2: rightReg,
-		Type: varSym.Type,
+		Type: instType,
 		Comment: fmt.Sprintf("Compound assignment %s %s", target.Name, bin.Operator),
 	})
 	
@@ -4781,6 +5060,22 @@ func (a *Analyzer) analyzeCallExpr(call *ast.CallExpr, irFunc *ir.Function) (ir.
 			fmt.Printf("  call.Function is nil!\n")
 		}
 	}
+	if a.isFormatCall(call) {
+		return a.analyzeFormatCall(call, irFunc)
+	}
+	if builtin, ok := a.rotateCall(call); ok {
+		return a.analyzeRotateCall(call, builtin, irFunc)
+	}
+	if builtin, ok := a.stringCall(call); ok {
+		return a.analyzeStringCall(call, builtin, irFunc)
+	}
+	if enumType, variant, ok := a.variantCall(call); ok {
+		return a.analyzeVariantValue(call, enumType, variant, call.Arguments, irFunc)
+	}
+	if chain, ok := a.iteratorCall(call); ok {
+		return a.analyzeIteratorChainExpr(chain, irFunc)
+	}
+	
 	var funcName string
 	var sym Symbol
 	var isMethodCall bool
@@ -4817,8 +5112,15 @@ func (a *Analyzer) analyzeCallExpr(call *ast.CallExpr, irFunc *ir.Function) (ir.
 				prefixedName = a.prefixSymbol(funcName)
 			}
 			
-			// Try to resolve as an overloaded function
-			funcSym, err := a.resolveOverload(prefixedName, call.Arguments, irFunc)
+			// Generic functions are instantiated for the argument types,
+			// otherwise try to resolve as an overloaded function
+			funcSym, isGeneric, err := a.instantiateGeneric(prefixedName, call.Arguments)
+			if isGeneric && err != nil {
+				return 0, err
+			}
+			if !isGeneric {
+				funcSym, err = a.resolveOverload(prefixedName, call.Arguments, irFunc)
+			}
 			if err == nil {
 				sym = funcSym
 				funcName = funcSym.Name  // Use the mangled name
@@ -4976,36 +5278,47 @@ func (a *Analyzer) analyzeCallExpr(call *ast.CallExpr, irFunc *ir.Function) (ir.
 		sym = a.currentScope.Lookup(funcName)
 		
 		if sym == nil {
-			// Try as instance method call (obj.method())
+			// Interface.method(value): the impl for the value's type
+			method, isInterface, err := a.interfaceCall(call, fn)
+			if err != nil {
+				return 0, err
+			}
+			if isInterface {
+				sym = method
+				funcName = method.Name
+			}
+		}
+		
+		if sym == nil {
+			// Instance method call (obj.method()), bound statically to the
+			// impl for the receiver's type
+			method, err := a.receiverMethod(fn)
+			if err != nil {
+				return 0, err
+			}
+			if method != nil {
+				sym = method
+				funcName = method.Name
+				isMethodCall = true
+				methodReceiver = fn.Object  // Store the receiver for self parameter
+				
+				if debug {
+					fmt.Printf("DEBUG: Resolved method %s to %s\n", fn.Field, funcName)
+				}
+			}
+		}
+		
+		if sym == nil {
 			if id, ok := fn.Object.(*ast.Identifier); ok {
-				// Look up the variable to get its type
-				varSym := a.currentScope.Lookup(id.Name)
-				if varSymbol, ok := varSym.(*VarSymbol); ok {
-					// Find interface implementations for this type
-					// First check if there's an overload set for this method
+				// Methods declared outside impl blocks: an overload set Type.method
+				if varSymbol, ok := a.currentScope.Lookup(id.Name).(*VarSymbol); ok {
 					methodBaseName := a.getMethodBaseName(varSymbol.Type, fn.Field)
-					if methodBaseName != "" {
-						// Try to find the overload set
-						if overloadSet, ok := a.currentScope.Lookup(methodBaseName).(*FunctionOverloadSet); ok {
-							// We have an overload set - we'll resolve it later with arguments
-							sym = overloadSet
-							funcName = methodBaseName
-							isMethodCall = true
-							methodReceiver = fn.Object  // Store the full receiver expression
-						} else {
-							// Try single method lookup
-							methodFunc := a.findInterfaceMethod(varSymbol.Type, fn.Field)
-							if methodFunc != nil {
-								sym = methodFunc
-								funcName = methodFunc.Name
-								isMethodCall = true
-								methodReceiver = fn.Object  // Store the receiver for self parameter
-								
-								if debug {
-									fmt.Printf("DEBUG: Resolved interface method %s.%s to %s\n", id.Name, fn.Field, funcName)
-								}
-							}
-						}
+					if overloadSet, ok := a.currentScope.Lookup(methodBaseName).(*FunctionOverloadSet); methodBaseName != "" && ok {
+						// We have an overload set - we'll resolve it later with arguments
+						sym = overloadSet
+						funcName = methodBaseName
+						isMethodCall = true
+						methodReceiver = fn.Object  // Store the full receiver expression
 					}
 				}
 				
@@ -5029,6 +5342,13 @@ func (a *Analyzer) analyzeCallExpr(call *ast.CallExpr, irFunc *ir.Function) (ir.
 			}
 		}
 		
+		if sym == nil && fn.Field == enumNameMethod {
+			// Generated variant name: color.name()
+			if enumType := a.enumReceiverType(fn.Object); enumType != nil {
+				return a.analyzeEnumNameCall(call, fn.Object, enumType, irFunc)
+			}
+		}
+		
 		if sym == nil {
 			// Check if this looks like a nested public function call
 			if strings.Contains(funcName, ".") {
@@ -5070,6 +5390,10 @@ func (a *Analyzer) analyzeCallExpr(call *ast.CallExpr, irFunc *ir.Function) (ir.
 			return 0, fmt.Errorf("undefined function: %s", funcName)
 		}
 		
+	case *ast.IndexExpr:
+		// Call through a function table: handlers[i]()
+		return a.analyzeFunctionTableCall(call, fn, irFunc)
+		
 	case *ast.TryExpr:
 		// Function call with ? suffix (error propagation)
 		// The actual function is inside the TryExpr
@@ -5724,6 +6048,13 @@ func (a *Analyzer) analyzeStructLiteral(lit *ast.StructLiteral, irFunc *ir.Funct
 
 // analyzeFieldExpr analyzes a field access expression
 func (a *Analyzer) analyzeFieldExpr(field *ast.FieldExpr, irFunc *ir.Function) (ir.Register, error) {
+	if lit, ok, err := a.metaProperty(field); ok {
+		if err != nil {
+			return 0, err
+		}
+		return a.analyzeMetaProperty(field, lit, irFunc)
+	}
+	
 	// Special handling for module field access (e.g., screen.set_pixel)
 	// Check this FIRST before trying to analyze the object as an expression
 	if id, ok := field.Object.(*ast.Identifier); ok {
@@ -6088,10 +6419,18 @@ func (a *Analyzer) analyzeEnumLiteral(lit *ast.EnumLiteral, irFunc *ir.Function)
 	if !exists {
 		return 0, fmt.Errorf("no variant %s in enum %s", lit.Variant, lit.EnumName)
 	}
+	if enumType.HasPayloads() {
+		return a.analyzeVariantValue(lit, enumType, lit.Variant, nil, irFunc)
+	}
 	
-	// Generate constant load
+	// Generate constant load, sized by the enum's backing type
 	resultReg := irFunc.AllocReg()
-	irFunc.EmitImm(ir.OpLoadConst, resultReg, int64(value))
+	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+		Op:   ir.OpLoadConst,
+		Dest: resultReg,
+		Imm:  int64(value),
+		Type: enumType.BackingType(),
+	})
 	
 	// Store the enum type information
 	a.exprTypes[lit] = typeSym.Type
@@ -6152,33 +6491,8 @@ func (a *Analyzer) analyzePrintExpr(print *ast.CompileTimePrint, irFunc *ir.Func
 		fmt.Printf("DEBUG: analyzePrintExpr called with expr type: %T\n", print.Expr)
 	}
 	
-	// Check if the expression is a string literal for interpolation
-	if strLit, ok := print.Expr.(*ast.StringLiteral); ok {
-		if debug {
-			fmt.Printf("DEBUG: String literal value: %q\n", strLit.Value)
-		}
-		// Use enhanced interpolation with { constant } support
-		err := a.processEnhancedStringInterpolation(strLit.Value, irFunc)
-		if err != nil {
-			if debug {
-				fmt.Printf("DEBUG: Enhanced interpolation failed: %v, falling back\n", err)
-			}
-			// Fall back to old implementation if enhanced fails
-			err = a.processStringInterpolation(strLit.Value, irFunc)
-			if err != nil {
-				return 0, fmt.Errorf("failed to process string interpolation: %w", err)
-			}
-		}
-	} else {
-		// For non-string expressions, evaluate and print the value
-		reg, err := a.analyzeExpression(print.Expr, irFunc)
-		if err != nil {
-			return 0, err
-		}
-		
-		// Generate print instruction based on type
-		exprType := a.exprTypes[print.Expr]
-		a.generatePrintValue(reg, exprType, irFunc)
+	if err := a.analyzePrint([]ast.Expression{print.Expr}, irFunc); err != nil {
+		return 0, err
 	}
 	
 	// @print doesn't return a value
@@ -6292,6 +6606,11 @@ func (a *Analyzer) evaluateConstantExpression(expr ast.Expression) (interface{},
 			return constSym.Value, nil
 		}
 		return nil, fmt.Errorf("identifier %s is not a compile-time constant", e.Name)
+	case *ast.FieldExpr:
+		if c, ok := a.moduleConstant(e); ok {
+			return c.Value, nil
+		}
+		return nil, fmt.Errorf("%s is not a compile-time constant", e.Field)
 	case *ast.BinaryExpr:
 		// Evaluate binary expressions with constant operands
 		left, err := a.evaluateConstantExpression(e.Left)
@@ -6312,6 +6631,11 @@ func (a *Analyzer) evaluateConstantExpression(expr ast.Expression) (interface{},
 		}
 		
 		return a.evaluateConstantUnaryOp(e.Operator, operand)
+	case *ast.MetafunctionCall:
+		if isComptimeCall(e) {
+			return a.evaluateComptime(e)
+		}
+		return nil, fmt.Errorf("@%s cannot be evaluated at compile time", strings.TrimPrefix(e.Name, "@"))
 	case *ast.CompileTimeIf:
 		// Evaluate @if at compile time
 		conditionValue, err := a.evaluateConstantExpression(e.Condition)
@@ -6471,6 +6795,19 @@ func (a *Analyzer) isTruthy(value interface{}) bool {
 
 // analyzeMetafunctionCall analyzes general @metafunction(...) calls
 func (a *Analyzer) analyzeMetafunctionCall(call *ast.MetafunctionCall, irFunc *ir.Function) (ir.Register, error) {
+	if isReflectCall(call) {
+		return 0, a.errorAt(call, "@%s can only be used as the items of @for", call.Name)
+	}
+	if _, ok := isIncludeBinCall(call); ok {
+		return a.analyzeIncludeBin(call, irFunc)
+	}
+	if _, ok := isDefineCall(call); ok {
+		return 0, a.analyzeDefine(call, a.currentScope)
+	}
+	if isPrintCall(call) {
+		return 0, a.analyzePrintCall(call, irFunc)
+	}
+	
 	// Create metafunction processor if not already created
 	if a.metafunctionProcessor == nil {
 		// For now, assume we can find the project root
@@ -6624,47 +6961,27 @@ func (a *Analyzer) analyzeMetafunctionCall(call *ast.MetafunctionCall, irFunc *i
 		}
 		return 0, nil
 		
-	case "println":
-		// Handle @println as @print with a newline
-		// First analyze all arguments like @print does
-		if len(call.Arguments) > 0 {
-			// Check if the first argument is a string literal for interpolation
-			if strLit, ok := call.Arguments[0].(*ast.StringLiteral); ok && len(call.Arguments) == 1 {
-				// Use enhanced interpolation with { constant } support
-				err := a.processEnhancedStringInterpolation(strLit.Value, irFunc)
-				if err != nil {
-					// Fall back to old implementation if enhanced fails
-					err = a.processStringInterpolation(strLit.Value, irFunc)
-					if err != nil {
-						return 0, fmt.Errorf("failed to process string interpolation: %w", err)
-					}
-				}
-			} else if strLit, ok := call.Arguments[0].(*ast.StringLiteral); ok && len(call.Arguments) > 1 {
-				// Handle format string with arguments
-				// First, extract expressions from remaining arguments
-				var args []ast.Expression
-				for i := 1; i < len(call.Arguments); i++ {
-					args = append(args, call.Arguments[i])
-				}
-				
-				// Process the format string with the provided arguments
-				err := a.processFormatString(strLit.Value, args, irFunc)
-				if err != nil {
-					return 0, fmt.Errorf("failed to process format string: %w", err)
-				}
-			} else {
-				// Single expression - print its value
-				reg, err := a.analyzeExpression(call.Arguments[0], irFunc)
-				if err != nil {
-					return 0, fmt.Errorf("failed to analyze expression: %w", err)
-				}
-				// Generate print instruction based on type
-				exprType := a.exprTypes[call.Arguments[0]]
-				a.generatePrintValue(reg, exprType, irFunc)
+	case "goto_ptr":
+		// Handle @goto_ptr(target) - computed goto, never returns here
+		if len(call.Arguments) != 1 {
+			return 0, fmt.Errorf("@goto_ptr requires exactly one argument (target address)")
+		}
+		
+		targetType := a.exprTypes[call.Arguments[0]]
+		if !isCodeAddressType(targetType) {
+			typeStr := "unknown"
+			if targetType != nil {
+				typeStr = targetType.String()
 			}
+			return 0, a.errorAt(call, "@goto_ptr target must be a function or 16-bit address, got %s", typeStr)
 		}
-		// Add a newline after the print
-		a.generatePrintString("\n", irFunc)
+		
+		irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+			Op:      ir.OpJumpIndirect,
+			Src1:    analyzedArgs[0],
+			Comment: "@goto_ptr computed goto",
+		})
+		a.exprTypes[call] = &ir.BasicType{Kind: ir.TypeVoid}
 		return 0, nil
 		
 	default:
@@ -6807,72 +7124,6 @@ func (a *Analyzer) analyzeMinzMetafunctionCall(call *ast.MinzMetafunctionCall, i
 	return 0, nil
 }
 
-// processFormatString handles format strings with explicit arguments
-func (a *Analyzer) processFormatString(format string, args []ast.Expression, irFunc *ir.Function) error {
-	// Split the string by {} placeholders
-	parts := []struct {
-		isExpr bool
-		value  string
-		argIdx int
-	}{}
-	
-	current := ""
-	i := 0
-	argIdx := 0
-	for i < len(format) {
-		if i+1 < len(format) && format[i] == '{' && format[i+1] == '}' {
-			// Found placeholder
-			if current != "" {
-				parts = append(parts, struct {
-					isExpr bool
-					value  string
-					argIdx int
-				}{false, current, 0})
-				current = ""
-			}
-			if argIdx < len(args) {
-				parts = append(parts, struct {
-					isExpr bool
-					value  string
-					argIdx int
-				}{true, "", argIdx})
-				argIdx++
-			}
-			i += 2
-		} else {
-			current += string(format[i])
-			i++
-		}
-	}
-	
-	// Add remaining string
-	if current != "" {
-		parts = append(parts, struct {
-			isExpr bool
-			value  string
-			argIdx int
-		}{false, current, 0})
-	}
-	
-	// Generate code for each part
-	for _, part := range parts {
-		if part.isExpr {
-			// Evaluate and print the argument
-			reg, err := a.analyzeExpression(args[part.argIdx], irFunc)
-			if err != nil {
-				return fmt.Errorf("failed to evaluate argument %d: %w", part.argIdx, err)
-			}
-			exprType := a.exprTypes[args[part.argIdx]]
-			a.generatePrintValue(reg, exprType, irFunc)
-		} else {
-			// Print string literal
-			a.generatePrintString(part.value, irFunc)
-		}
-	}
-	
-	return nil
-}
-
 // processStringInterpolation handles string interpolation for @print
 func (a *Analyzer) processStringInterpolation(format string, irFunc *ir.Function) error {
 	// TODO: Use Lua to parse the format string and generate optimal print code
@@ -7090,7 +7341,7 @@ func (a *Analyzer) generatePrintString(str string, irFunc *ir.Function) {
 		irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
 			Op:      ir.OpPrintStringDirect,
 			Symbol:  str, // Pass the string directly
-			Comment: fmt.Sprintf("Direct print \"%s\" (%d chars)", str, len(str)),
+			Comment: fmt.Sprintf("Direct print %q (%d chars)", str, len(str)),
 		})
 	} else {
 		// Use loop-based print for longer strings
@@ -7117,7 +7368,7 @@ func (a *Analyzer) generatePrintString(str string, irFunc *ir.Function) {
 			Op:      ir.OpPrintString,
 			Src1:    strReg,
 			Symbol:  stringLabel, // Pass the label so codegen can check if IsLong
-			Comment: fmt.Sprintf("Print \"%s\" (%d chars via loop)", str, len(str)),
+			Comment: fmt.Sprintf("Print %q (%d chars via loop)", str, len(str)),
 		})
 	}
 }
@@ -7133,12 +7384,18 @@ func (a *Analyzer) generatePrintValue(reg ir.Register, typ ir.Type, irFunc *ir.F
 		switch t := typ.(type) {
 		case *ir.BasicType:
 			switch t.Kind {
-			case ir.TypeU8, ir.TypeI8:
+			case ir.TypeU8:
 				op = ir.OpPrintU8
-			case ir.TypeU16, ir.TypeI16:
+			case ir.TypeI8:
+				op = ir.OpPrintI8
+			case ir.TypeU16:
 				op = ir.OpPrintU16
+			case ir.TypeI16:
+				op = ir.OpPrintI16
 			case ir.TypeBool:
 				op = ir.OpPrintBool
+			case ir.TypeF8_8, ir.TypeF_8, ir.TypeF_16, ir.TypeF16_8, ir.TypeF8_16:
+				op = ir.OpPrintFixed
 			default:
 				op = ir.OpPrintU8
 			}
@@ -7158,6 +7415,7 @@ func (a *Analyzer) generatePrintValue(reg ir.Register, typ ir.Type, irFunc *ir.F
 	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
 		Op:   op,
 		Src1: reg,
+		Type: typ,
 	})
 }
 
@@ -7275,6 +7533,14 @@ func (a *Analyzer) typesEqual(t1, t2 ir.Type) bool {
 
 // evaluateConstExpr evaluates a constant expression and returns its value
 func (a *Analyzer) evaluateConstExpr(expr ast.Expression) (interface{}, error) {
+	if field, ok := expr.(*ast.FieldExpr); ok {
+		if lit, ok, err := a.metaProperty(field); ok {
+			if err != nil {
+				return nil, err
+			}
+			return a.evaluateConstExpr(lit)
+		}
+	}
 	switch e := expr.(type) {
 	case *ast.NumberLiteral:
 		return e.Value, nil
@@ -7432,6 +7698,13 @@ func (a *Analyzer) evaluateConstExpr(expr ast.Expression) (interface{}, error) {
 				sym = a.currentScope.Lookup(prefixedName)
 			}
 		}
+		if sym == nil {
+			resolved, err := a.resolveConst(e.Name)
+			if err != nil {
+				return nil, err
+			}
+			sym = resolved
+		}
 		if sym == nil {
 			return nil, fmt.Errorf("undefined constant: %s", e.Name)
 		}
@@ -7439,6 +7712,21 @@ func (a *Analyzer) evaluateConstExpr(expr ast.Expression) (interface{}, error) {
 			return constSym.Value, nil
 		}
 		return nil, fmt.Errorf("%s is not a constant", e.Name)
+	case *ast.FieldExpr:
+		if c, ok := a.moduleConstant(e); ok {
+			return c.Value, nil
+		}
+		return nil, fmt.Errorf("%s is not a constant", e.Field)
+	case *ast.MetafunctionCall:
+		if isComptimeCall(e) {
+			return a.evaluateComptime(e)
+		}
+		return nil, fmt.Errorf("@%s is not a constant", strings.TrimPrefix(e.Name, "@"))
+	case *ast.CallExpr:
+		if a.comptimeDepth > 0 {
+			return a.evaluatePureCall(e)
+		}
+		return nil, fmt.Errorf("function call is not a constant")
 	default:
 		return nil, fmt.Errorf("expression is not a constant")
 	}
@@ -7525,6 +7813,9 @@ func (a *Analyzer) convertType(astType ast.Type) (ir.Type, error) {
 		// Try to evaluate the size as a constant expression
 		if t.Size != nil {
 			val, err := a.evaluateConstExpr(t.Size)
+			if err != nil && isComptimeCall(t.Size) {
+				return nil, fmt.Errorf("array size: %w", err)
+			}
 			if err == nil && val != nil {
 				// Convert the value to an integer
 				var size int
@@ -7553,6 +7844,9 @@ func (a *Analyzer) convertType(astType ast.Type) (ir.Type, error) {
 		if t.Size == nil {
 			return nil, fmt.Errorf("array size is nil")
 		}
+		if _, err := a.evaluateConstExpr(t.Size); err != nil {
+			return nil, fmt.Errorf("array size must be a constant: %w", err)
+		}
 		return nil, fmt.Errorf("array size must be a constant, got %T", t.Size)
 	case *ast.TypeIdentifier:
 		// Look up the type in the symbol table
@@ -7588,6 +7882,10 @@ func (a *Analyzer) convertType(astType ast.Type) (ir.Type, error) {
 		}
 		typeSym, ok := sym.(*TypeSymbol)
 		if !ok {
+			if _, isInterface := sym.(*InterfaceSymbol); isInterface {
+				// Methods are bound statically, so there is no value of interface type
+				return nil, fmt.Errorf("interface %s is not a type: take a generic parameter <T: %s> instead", t.Name, t.Name)
+			}
 			return nil, fmt.Errorf("%s is not a type", t.Name)
 		}
 		return typeSym.Type, nil
@@ -7649,6 +7947,9 @@ func (a *Analyzer) convertType(astType ast.Type) (ir.Type, error) {
 func (a *Analyzer) inferType(expr ast.Expression) (ir.Type, error) {
 	switch e := expr.(type) {
 	case *ast.NumberLiteral:
+		if e.IsFloat {
+			return defaultFixedType, nil
+		}
 		// Infer type based on value
 		if e.Value >= 0 && e.Value <= 255 {
 			// Small positive values default to u8
@@ -7718,6 +8019,14 @@ func (a *Analyzer) inferType(expr ast.Expression) (ir.Type, error) {
 			sym = a.currentScope.Lookup(prefixedName)
 		}
 		
+		if sym == nil {
+			resolved, err := a.resolveConst(e.Name)
+			if err != nil {
+				return nil, err
+			}
+			sym = resolved
+		}
+		
 		if sym == nil {
 			// Debug for screen
 			if e.Name == "screen" {
@@ -7740,12 +8049,34 @@ func (a *Analyzer) inferType(expr ast.Expression) (ir.Type, error) {
 			return nil, fmt.Errorf("cannot infer type from %s", e.Name)
 		}
 	case *ast.CallExpr:
+		if a.isFormatCall(e) {
+			return &ir.BasicType{Kind: ir.TypeU16}, nil
+		}
+		if builtin, ok := a.rotateCall(e); ok {
+			return &ir.BasicType{Kind: builtin.kind}, nil
+		}
+		if builtin, ok := a.stringCall(e); ok {
+			return &ir.BasicType{Kind: builtin.result}, nil
+		}
+		if enumType, _, ok := a.variantCall(e); ok {
+			return enumType, nil
+		}
+		if chain, ok := a.iteratorCall(e); ok {
+			return a.analyzeIteratorChainType(chain)
+		}
+		
 		// Infer type from function return type
 		var funcName string
 		var sym Symbol
 		
 		switch fn := e.Function.(type) {
 		case *ast.Identifier:
+			if funcSym, isGeneric, err := a.instantiateGeneric(fn.Name, e.Arguments); isGeneric {
+				if err != nil {
+					return nil, err
+				}
+				return funcSym.ReturnType, nil
+			}
 			funcName = fn.Name
 			sym = a.currentScope.Lookup(funcName)
 			
@@ -7763,6 +8094,40 @@ func (a *Analyzer) inferType(expr ast.Expression) (ir.Type, error) {
 				sym = a.currentScope.Lookup(funcName)
 			}
 			
+			// Interface.method(value) and value.method()
+			if sym == nil {
+				method, isInterface, err := a.interfaceCall(e, fn)
+				if err != nil {
+					return nil, err
+				}
+				if !isInterface {
+					if method, err = a.receiverMethod(fn); err != nil {
+						return nil, err
+					}
+				}
+				if method != nil {
+					return method.ReturnType, nil
+				}
+				
+				// Type.method(value)
+				if id, ok := fn.Object.(*ast.Identifier); ok {
+					if typeSym, ok := a.currentScope.Lookup(id.Name).(*TypeSymbol); ok {
+						sym = a.findTypeMethod(typeSym.Type, fn.Field)
+					}
+				}
+			}
+			
+			// Method on an enum value, including the generated name()
+			if sym == nil {
+				if enumType := a.enumReceiverType(fn.Object); enumType != nil {
+					if method := a.findTypeMethod(enumType, fn.Field); method != nil {
+						sym = method
+					} else if fn.Field == enumNameMethod {
+						return &ir.StringType{MaxLength: 255}, nil
+					}
+				}
+			}
+			
 		default:
 			return nil, fmt.Errorf("indirect function calls not yet supported for type inference")
 		}
@@ -7928,6 +8293,12 @@ func (a *Analyzer) inferType(expr ast.Expression) (ir.Type, error) {
 			return &ir.StringType{MaxLength: 255}, nil
 		}
 	case *ast.FieldExpr:
+		if lit, ok, err := a.metaProperty(e); ok {
+			if err != nil {
+				return nil, err
+			}
+			return a.inferType(lit)
+		}
 		// Check if this is a module field access or enum variant access
 		if id, ok := e.Object.(*ast.Identifier); ok {
 			sym := a.currentScope.Lookup(id.Name)
@@ -8097,10 +8468,17 @@ func (a *Analyzer) inferType(expr ast.Expression) (ir.Type, error) {
 			Length:  len(e.Elements),
 		}, nil
 	case *ast.MetafunctionCall:
+		if isComptimeCall(e) {
+			literal, err := a.comptimeLiteral(e)
+			if err != nil {
+				return nil, err
+			}
+			return a.inferType(literal)
+		}
 		// Handle type inference for metafunction calls
 		switch e.Name {
-		case "to_string":
-			// @to_string always returns a string pointer (str type)
+		case "to_string", "include_bin":
+			// @to_string and @include_bin return a pointer to length-prefixed bytes
 			return &ir.PointerType{Base: &ir.BasicType{Kind: ir.TypeU8}}, nil
 		case "print":
 			// @print returns void
@@ -8191,12 +8569,135 @@ func (a *Analyzer) typesCompatible(declared, inferred ir.Type) bool {
 	return declared.String() == inferred.String()
 }
 
-var labelCounter int
-
 // generateLabel generates a unique label
 func (a *Analyzer) generateLabel(prefix string) string {
-	labelCounter++
-	return fmt.Sprintf("%s_%d", prefix, labelCounter)
+	a.labelCounter++
+	return fmt.Sprintf("%s_%d", prefix, a.labelCounter)
+}
+
+// isComputedGoto reports whether a statement is a bare @goto_ptr(...) call
+func isComputedGoto(stmt ast.Statement) bool {
+	exprStmt, ok := stmt.(*ast.ExpressionStmt)
+	if !ok {
+		return false
+	}
+	call, ok := exprStmt.Expression.(*ast.MetafunctionCall)
+	return ok && call.Name == "goto_ptr"
+}
+
+// isCodeAddressType reports whether a value of this type can be a jump target
+func isCodeAddressType(t ir.Type) bool {
+	switch typ := t.(type) {
+	case *ir.FunctionType, *ir.LambdaType, *ir.PointerType:
+		return true
+	case *ir.BasicType:
+		return typ.Kind == ir.TypeU16
+	}
+	return false
+}
+
+// processSectionAttribute extracts the section name from a @section("name") attribute.
+// Returns an empty string when no section is requested.
+func (a *Analyzer) processSectionAttribute(attrs []*ast.Attribute) (string, error) {
+	for _, attr := range attrs {
+		if attr.Name != "section" {
+			continue
+		}
+		if len(attr.Arguments) != 1 {
+			return "", fmt.Errorf("@section attribute requires exactly one argument")
+		}
+		strLit, ok := attr.Arguments[0].(*ast.StringLiteral)
+		if !ok {
+			return "", fmt.Errorf("@section attribute expects a string argument")
+		}
+		if strLit.Value == "" {
+			return "", fmt.Errorf("@section name cannot be empty")
+		}
+		return strLit.Value, nil
+	}
+	return "", nil
+}
+
+// processReprAttribute returns the backing type from a @repr(u8) or
+// @repr(u16) attribute, or TypeVoid when there is none
+func (a *Analyzer) processReprAttribute(attrs []*ast.Attribute) (ir.TypeKind, error) {
+	for _, attr := range attrs {
+		if attr.Name != "repr" {
+			continue
+		}
+		if len(attr.Arguments) != 1 {
+			return ir.TypeVoid, fmt.Errorf("@repr attribute requires exactly one argument")
+		}
+		id, ok := attr.Arguments[0].(*ast.Identifier)
+		if !ok {
+			return ir.TypeVoid, fmt.Errorf("@repr attribute expects a type name")
+		}
+		switch id.Name {
+		case "u8":
+			return ir.TypeU8, nil
+		case "u16":
+			return ir.TypeU16, nil
+		}
+		return ir.TypeVoid, fmt.Errorf("@repr type must be u8 or u16, got %s", id.Name)
+	}
+	return ir.TypeVoid, nil
+}
+
+// processHintAttributes extracts the placement hint from @hot or @cold.
+// A function cannot be both.
+func (a *Analyzer) processHintAttributes(attrs []*ast.Attribute) (ir.FunctionHint, error) {
+	hint := ir.HintNone
+	for _, attr := range attrs {
+		var h ir.FunctionHint
+		switch attr.Name {
+		case "hot":
+			h = ir.HintHot
+		case "cold":
+			h = ir.HintCold
+		default:
+			continue
+		}
+		if len(attr.Arguments) != 0 {
+			return ir.HintNone, fmt.Errorf("%s attribute takes no arguments", h)
+		}
+		if hint != ir.HintNone && hint != h {
+			return ir.HintNone, fmt.Errorf("function cannot be both @hot and @cold")
+		}
+		hint = h
+	}
+	return hint, nil
+}
+
+// processInterruptAttribute makes a function marked @interrupt an interrupt
+// handler. @interrupt(im2) also installs it as the mode 2 handler, which
+// main sets up (see codegen/z80_im2.go); a program has at most one.
+func (a *Analyzer) processInterruptAttribute(fn *ast.FunctionDecl, irFunc *ir.Function) error {
+	for _, attr := range fn.Attributes {
+		if attr.Name != "interrupt" {
+			continue
+		}
+		if len(fn.Params) != 0 {
+			return fmt.Errorf("interrupt handler cannot take parameters")
+		}
+		if ret, ok := irFunc.ReturnType.(*ir.BasicType); !ok || ret.Kind != ir.TypeVoid {
+			return fmt.Errorf("interrupt handler must return void, not %s", irFunc.ReturnType)
+		}
+		irFunc.IsInterrupt = true
+		if len(attr.Arguments) == 0 {
+			return nil
+		}
+		mode, ok := attr.Arguments[0].(*ast.Identifier)
+		if len(attr.Arguments) != 1 || !ok || mode.Name != "im2" {
+			return fmt.Errorf("@interrupt takes no argument or im2")
+		}
+		if a.im2Handler != "" {
+			return fmt.Errorf("%s is already the @interrupt(im2) handler", a.im2Handler)
+		}
+		a.im2Handler = fn.Name
+		irFunc.InterruptMode = 2
+		return nil
+	}
+	return nil
 }
 
 // processAbiAttributes processes @abi attributes on function declarations
@@ -8477,8 +8978,11 @@ func (a *Analyzer) analyzeInterfaceDecl(decl *ast.InterfaceDecl) error {
 	return nil
 }
 
-// analyzeImplBlock analyzes an implementation block
-func (a *Analyzer) analyzeImplBlock(impl *ast.ImplBlock) error {
+// registerImplBlock checks an implementation block against its interface
+// and registers its methods, so calls anywhere in the file resolve to them
+func (a *Analyzer) registerImplBlock(impl *ast.ImplBlock) error {
+	a.implBlocks[impl] = nil
+	
 	// Look up the interface
 	interfaceSym := a.currentScope.Lookup(impl.InterfaceName)
 	if interfaceSym == nil {
@@ -8496,6 +9000,19 @@ func (a *Analyzer) analyzeImplBlock(impl *ast.ImplBlock) error {
 		return fmt.Errorf("invalid type in impl block: %w", err)
 	}
 	
+	// Primitive types already have built-in operators; overloading them would be ambiguous
+	if op, isOperator := operatorForInterface(impl.InterfaceName); isOperator {
+		if _, isBasic := implType.(*ir.BasicType); isBasic {
+			return fmt.Errorf("cannot overload operator %s for primitive type %s: it already has a built-in meaning",
+				op, implType.String())
+		}
+	}
+	
+	implKey := implSymbolKey(impl.InterfaceName, implType)
+	if _, exists := a.currentScope.Lookup(implKey).(*ImplSymbol); exists {
+		return fmt.Errorf("interface %s is already implemented for %s", impl.InterfaceName, implType.String())
+	}
+	
 	// Create implementation symbol
 	implSym := &ImplSymbol{
 		InterfaceName: impl.InterfaceName,
@@ -8510,6 +9027,9 @@ func (a *Analyzer) analyzeImplBlock(impl *ast.ImplBlock) error {
 		if !ok {
 			return fmt.Errorf("method %s is not part of interface %s", method.Name, impl.InterfaceName)
 		}
+		if _, ok := implSym.Methods[method.Name]; ok {
+			return fmt.Errorf("method %s is implemented twice for %s", method.Name, implType.String())
+		}
 		
 		// Verify method signature matches interface
 		if len(method.Params) != len(ifaceMethod.Params) {
@@ -8522,63 +9042,35 @@ func (a *Analyzer) analyzeImplBlock(impl *ast.ImplBlock) error {
 			return fmt.Errorf("method %s must have 'self' as first parameter", method.Name)
 		}
 		
-		// Give the method a unique name based on the implementing type to avoid conflicts
-		implTypeIR, err := a.convertType(impl.ForType)
-		if err != nil {
-			return fmt.Errorf("invalid type in impl block: %w", err)
-		}
+		// Set the type of 'self' to the implementing type
+		method.Params[0].Type = impl.ForType
+		method.Params[0].Name = "self"
 		
-		// For methods with 'self', we need to set the self parameter type
-		if len(method.Params) > 0 && method.Params[0].IsSelf {
-			// Set the type of 'self' to the implementing type
-			method.Params[0].Type = impl.ForType
-			// Also set the name explicitly to "self"
-			method.Params[0].Name = "self"
-			// fmt.Printf("DEBUG: Set self parameter type to %T for method %s\n", impl.ForType, method.Name)
-			// if impl.ForType != nil {
-			// 	fmt.Printf("  ForType: %v\n", impl.ForType)
-			// }
+		if err := a.checkImplSignature(method, ifaceMethod); err != nil {
+			return fmt.Errorf("method %s of %s for %s: %w", method.Name, impl.InterfaceName, implType.String(), err)
 		}
 		
-		// Create a unique method name for registration
+		// Register the method under a name unique to the implementing type,
+		// Circle.area, and keep the registered overload for calls
 		originalMethodName := method.Name
-		uniqueMethodName := implTypeIR.String() + "." + originalMethodName
-		
-		// Register the method function with a unique name
-		returnType, err := a.convertType(method.ReturnType)
-		if err != nil {
-			return fmt.Errorf("invalid return type for method %s: %w", originalMethodName, err)
-		}
-		
-		methodSymbol := &FuncSymbol{
-			Name:       uniqueMethodName,
-			ReturnType: returnType,
-			Params:     method.Params,
+		method.Name = implMethodName(implType, originalMethodName)
+		err := a.registerFunctionSignature(method)
+		var funcSym *FuncSymbol
+		if err == nil {
+			funcSym, ok = a.currentScope.Lookup(generateMangledName(method.Name, method.Params)).(*FuncSymbol)
+			if !ok {
+				err = fmt.Errorf("method was not registered")
+			}
 		}
-		
-		// Register in the current scope
-		a.currentScope.Define(uniqueMethodName, methodSymbol)
-		
-		// Temporarily change the method name for IR generation
-		method.Name = uniqueMethodName
-		
-		// Register the method signature first (since it wasn't done in the first pass)
-		if err := a.registerFunctionSignature(method); err != nil {
+		method.Name = originalMethodName
+		if err != nil {
 			return fmt.Errorf("error registering method %s: %w", originalMethodName, err)
 		}
-		
-		// Analyze the method as a regular function
-		if err := a.analyzeFunctionDecl(method); err != nil {
-			return fmt.Errorf("error analyzing method %s: %w", originalMethodName, err)
-		}
-		
-		// Restore original name but add to implementation with the unique function symbol
-		method.Name = originalMethodName
-		implSym.Methods[originalMethodName] = methodSymbol
+		implSym.Methods[originalMethodName] = funcSym
 	}
 	
 	// Verify all interface methods are implemented
-	for methodName := range iface.Methods {
+	for _, methodName := range iface.MethodNames() {
 		if _, ok := implSym.Methods[methodName]; !ok {
 			return fmt.Errorf("type %s does not implement method %s of interface %s", 
 				implType.String(), methodName, impl.InterfaceName)
@@ -8586,12 +9078,83 @@ func (a *Analyzer) analyzeImplBlock(impl *ast.ImplBlock) error {
 	}
 	
 	// Register the implementation
-	implKey := fmt.Sprintf("%s_for_%s", impl.InterfaceName, implType.String())
 	a.currentScope.Define(implKey, implSym)
+	a.implBlocks[impl] = implType
 	
 	return nil
 }
 
+// analyzeImplBlock analyzes the method bodies of a registered implementation block
+func (a *Analyzer) analyzeImplBlock(impl *ast.ImplBlock) error {
+	implType, registered := a.implBlocks[impl]
+	if !registered {
+		// Generated by @minz after the registration pass
+		if err := a.registerImplBlock(impl); err != nil {
+			return err
+		}
+		implType = a.implBlocks[impl]
+	}
+	if implType == nil {
+		// Its registration failed and was reported
+		return nil
+	}
+	for _, method := range impl.Methods {
+		// Analyze the method as a regular function under its registered name
+		originalMethodName := method.Name
+		method.Name = implMethodName(implType, originalMethodName)
+		err := a.analyzeFunctionDecl(method)
+		method.Name = originalMethodName
+		if err != nil {
+			return fmt.Errorf("error analyzing method %s: %w", originalMethodName, err)
+		}
+	}
+	return nil
+}
+
+// operatorInterfaces maps overloadable operators to the interface and method implementing them
+var operatorInterfaces = map[string]struct {
+	Interface string
+	Method    string
+}{
+	"+": {Interface: "Add", Method: "add"},
+	"-": {Interface: "Sub", Method: "sub"},
+}
+
+// operatorForInterface returns the operator an interface overloads, if any
+func operatorForInterface(interfaceName string) (string, bool) {
+	for op, entry := range operatorInterfaces {
+		if entry.Interface == interfaceName {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+// findOperatorMethod returns the method overloading the operator for the left operand's type,
+// or nil if the type has no implementation of the operator's interface
+func (a *Analyzer) findOperatorMethod(bin *ast.BinaryExpr, irFunc *ir.Function) (*FuncSymbol, error) {
+	entry, ok := operatorInterfaces[bin.Operator]
+	operandType := a.exprTypes[bin.Left]
+	if !ok || operandType == nil {
+		return nil, nil
+	}
+	if _, isBasic := operandType.(*ir.BasicType); isBasic {
+		return nil, nil
+	}
+	
+	if !a.implements(operandType, entry.Interface) {
+		return nil, nil
+	}
+	
+	// Methods are registered as overload sets; resolve with self as the first argument
+	baseName := a.getMethodBaseName(operandType, entry.Method)
+	method, err := a.resolveOverload(baseName, []ast.Expression{bin.Left, bin.Right}, irFunc)
+	if err != nil {
+		return nil, fmt.Errorf("operator %s on %s: %w", bin.Operator, operandType.String(), err)
+	}
+	return method, nil
+}
+
 // findTypeMethod searches for a method implementation for the given type
 func (a *Analyzer) findTypeMethod(targetType ir.Type, methodName string) Symbol {
 	// Iterate through all symbols in the current scope to find impl blocks
@@ -8799,75 +9362,6 @@ func (a *Analyzer) getMethodBaseName(objType ir.Type, methodName string) string
 	return typeName + "." + methodName
 }
 
-// findInterfaceMethod finds the concrete implementation function for an interface method
-// This is the core of zero-cost interfaces - compile-time method resolution
-func (a *Analyzer) findInterfaceMethod(objType ir.Type, methodName string) *FuncSymbol {
-	if debug {
-		fmt.Printf("DEBUG: findInterfaceMethod called for type %s, method %s\n", objType.String(), methodName)
-	}
-	
-	// Convert IR type back to type name for lookup
-	var typeName string
-	switch t := objType.(type) {
-	case *ir.StructType:
-		typeName = t.Name
-		// Remove module prefix if present for local lookups
-		if idx := strings.LastIndex(typeName, "."); idx >= 0 {
-			baseTypeName := typeName[idx+1:]
-			// We'll try both with and without module prefix
-			typeName = baseTypeName
-		}
-	case *ir.BasicType:
-		// Basic types don't have interface implementations for now
-		return nil
-	default:
-		if debug {
-			fmt.Printf("DEBUG: Unsupported type for interface method: %T\n", objType)
-		}
-		return nil
-	}
-	
-	if debug {
-		fmt.Printf("DEBUG: Looking for implementations of %s.%s\n", typeName, methodName)
-	}
-	
-	// Look for implementation functions
-	// Implementation functions are named: TypeName_InterfaceName_MethodName or TypeName_MethodName
-	possibleNames := []string{
-		fmt.Sprintf("%s_%s", typeName, methodName),  // Simple naming: Circle_draw
-		fmt.Sprintf("%s.%s", typeName, methodName),  // Dot notation: Circle.draw
-	}
-	
-	for _, name := range possibleNames {
-		if sym := a.currentScope.Lookup(name); sym != nil {
-			if funcSym, ok := sym.(*FuncSymbol); ok {
-				if debug {
-					fmt.Printf("DEBUG: Found implementation function: %s\n", name)
-				}
-				return funcSym
-			}
-		}
-		
-		// Try with module prefix
-		if a.currentModule != "" && a.currentModule != "main" {
-			prefixedName := a.prefixSymbol(name)
-			if sym := a.currentScope.Lookup(prefixedName); sym != nil {
-				if funcSym, ok := sym.(*FuncSymbol); ok {
-					if debug {
-						fmt.Printf("DEBUG: Found prefixed implementation function: %s\n", prefixedName)
-					}
-					return funcSym
-				}
-			}
-		}
-	}
-	
-	if debug {
-		fmt.Printf("DEBUG: No implementation found for %s.%s\n", typeName, methodName)
-	}
-	return nil
-}
-
 // checkLambdaCaptures ensures lambda doesn't capture variables (for now)
 func (a *Analyzer) checkLambdaCaptures(lambda *ast.LambdaExpr) error {
 	// For now, just return nil - no capture detection
@@ -9105,6 +9599,13 @@ func (a *Analyzer) analyzeLocalFunctionDecl(fn *ast.FunctionDecl, parentFunc *ir
 		return fmt.Errorf("error processing @abi attributes for local function %s: %v", fn.Name, err)
 	}
 	
+	// Process @hot/@cold attributes
+	hint, err := a.processHintAttributes(fn.Attributes)
+	if err != nil {
+		return fmt.Errorf("error processing attributes for local function %s: %v", fn.Name, err)
+	}
+	localIRFunc.Hint = hint
+	
 	// Enable SMC by default for local functions too
 	if localIRFunc.CallingConvention == "" {
 		localIRFunc.IsSMCDefault = true
@@ -10182,7 +10683,9 @@ func (a *Analyzer) findSimilarIdentifiers(name string) []string {
 	builtins := []string{
 		"print_u8", "print_u16", "print_i8", "print_i16",
 		"print_bool", "print_char", "print",
-		"memcpy", "memset", "strlen",
+		"memcpy", "memset", "strlen", "format",
+		"rotl8", "rotr8", "rotl16", "rotr16",
+		"str_cmp", "str_copy", "str_cat", "str_index",
 	}
 	for _, builtin := range builtins {
 		candidates[builtin] = true
@@ -0,0 +1,218 @@
+package semantic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// @comptime(expr) folds expr to a constant during analysis, wherever a
+// constant is needed:
+//
+//	const SIZE: u8 = 16;
+//	@pure fun double(n: u16) -> u16 { return n * 2; }
+//
+//	let buffer: [u8; @comptime(2 * SIZE)];
+//	@at(@comptime(0x4000 + double(SIZE))) let attr: u8;
+//
+// The expression may use consts and call @pure functions, whose bodies are
+// evaluated with their arguments bound as consts. An expression that cannot
+// be folded is an error rather than a runtime computation.
+
+// maxPureCallDepth bounds recursion between @pure function calls
+const maxPureCallDepth = 64
+
+// isComptimeCall reports whether expr is an @comptime(...) form
+func isComptimeCall(expr ast.Expression) bool {
+	call, ok := expr.(*ast.MetafunctionCall)
+	return ok && strings.TrimPrefix(call.Name, "@") == "comptime"
+}
+
+// hasAttribute reports whether attrs contain @name
+func hasAttribute(attrs []*ast.Attribute, name string) bool {
+	for _, attr := range attrs {
+		if attr.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateComptime folds the argument of an @comptime(...) form
+func (a *Analyzer) evaluateComptime(call *ast.MetafunctionCall) (interface{}, error) {
+	if len(call.Arguments) != 1 {
+		return nil, fmt.Errorf("@comptime requires exactly one argument")
+	}
+	a.comptimeDepth++
+	defer func() { a.comptimeDepth-- }()
+
+	value, err := a.evaluateConstExpr(call.Arguments[0])
+	if err != nil {
+		return nil, fmt.Errorf("@comptime expression cannot be folded to a constant: %w", err)
+	}
+	return value, nil
+}
+
+// comptimeLiteral folds an @comptime(...) form into the literal it stands for
+func (a *Analyzer) comptimeLiteral(call *ast.MetafunctionCall) (ast.Expression, error) {
+	value, err := a.evaluateComptime(call)
+	if err != nil {
+		return nil, err
+	}
+	switch v := value.(type) {
+	case int64:
+		return &ast.NumberLiteral{Value: v, StartPos: call.StartPos, EndPos: call.EndPos}, nil
+	case int:
+		return &ast.NumberLiteral{Value: int64(v), StartPos: call.StartPos, EndPos: call.EndPos}, nil
+	case bool:
+		return &ast.BooleanLiteral{Value: v, StartPos: call.StartPos, EndPos: call.EndPos}, nil
+	}
+	return nil, fmt.Errorf("@comptime expression folded to %T, want a number or bool", value)
+}
+
+// analyzeComptime loads the folded value of an @comptime(...) form
+func (a *Analyzer) analyzeComptime(call *ast.MetafunctionCall, irFunc *ir.Function) (ir.Register, error) {
+	literal, err := a.comptimeLiteral(call)
+	if err != nil {
+		return 0, err
+	}
+	reg, err := a.analyzeExpression(literal, irFunc)
+	if err != nil {
+		return 0, err
+	}
+	if t, ok := a.exprTypes[literal]; ok {
+		a.exprTypes[call] = t
+	}
+	return reg, nil
+}
+
+// evaluatePureCall runs a @pure function on constant arguments. Inside
+// @comptime only, so ordinary constant folding never executes calls.
+func (a *Analyzer) evaluatePureCall(call *ast.CallExpr) (interface{}, error) {
+	id, ok := call.Function.(*ast.Identifier)
+	if !ok {
+		return nil, fmt.Errorf("only calls to @pure functions can be folded")
+	}
+	fn, ok := a.pureFunctions[id.Name]
+	if !ok {
+		return nil, fmt.Errorf("%s is not a @pure function", id.Name)
+	}
+	if len(call.Arguments) != len(fn.Params) {
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", id.Name, len(fn.Params), len(call.Arguments))
+	}
+	if a.pureCallDepth >= maxPureCallDepth {
+		return nil, fmt.Errorf("@pure call depth exceeded %d in %s", maxPureCallDepth, id.Name)
+	}
+
+	bodyScope := NewScope(a.currentScope)
+	for i, param := range fn.Params {
+		value, err := a.evaluateConstExpr(call.Arguments[i])
+		if err != nil {
+			return nil, fmt.Errorf("argument %s of %s: %w", param.Name, id.Name, err)
+		}
+		paramType, err := a.convertType(param.Type)
+		if err != nil {
+			return nil, err
+		}
+		bodyScope.Define(param.Name, &ConstSymbol{Name: param.Name, Type: paramType, Value: value})
+	}
+
+	prevScope := a.currentScope
+	a.currentScope = bodyScope
+	a.pureCallDepth++
+	defer func() {
+		a.currentScope = prevScope
+		a.pureCallDepth--
+	}()
+
+	value, returned, err := a.evaluatePureBlock(fn.Body)
+	if err != nil {
+		return nil, fmt.Errorf("in @pure function %s: %w", id.Name, err)
+	}
+	if !returned {
+		return nil, fmt.Errorf("@pure function %s returns no value", id.Name)
+	}
+	return value, nil
+}
+
+// evaluatePureBlock runs the statements of a @pure function body. Constant
+// bindings, ifs and returns are supported; returned is false when the
+// block runs to its end.
+func (a *Analyzer) evaluatePureBlock(block *ast.BlockStmt) (value interface{}, returned bool, err error) {
+	if block == nil {
+		return nil, false, nil
+	}
+	for _, stmt := range block.Statements {
+		switch s := stmt.(type) {
+		case *ast.ReturnStmt:
+			if s.Value == nil {
+				return nil, false, fmt.Errorf("return without a value")
+			}
+			value, err := a.evaluateConstExpr(s.Value)
+			return value, true, err
+		case *ast.ConstDecl:
+			value, err := a.evaluateConstExpr(s.Value)
+			if err != nil {
+				return nil, false, err
+			}
+			a.currentScope.Define(s.Name, &ConstSymbol{Name: s.Name, Value: value})
+		case *ast.VarDecl:
+			if s.IsMutable || s.Value == nil {
+				return nil, false, fmt.Errorf("mutable variable %s cannot be evaluated at compile time", s.Name)
+			}
+			value, err := a.evaluateConstExpr(s.Value)
+			if err != nil {
+				return nil, false, err
+			}
+			a.currentScope.Define(s.Name, &ConstSymbol{Name: s.Name, Value: value})
+		case *ast.IfStmt:
+			cond, err := a.evaluateConstExpr(s.Condition)
+			if err != nil {
+				return nil, false, err
+			}
+			var branch *ast.BlockStmt
+			if a.isTruthy(cond) {
+				branch = s.Then
+			} else if elseBlock, ok := s.Else.(*ast.BlockStmt); ok {
+				branch = elseBlock
+			} else if elseIf, ok := s.Else.(*ast.IfStmt); ok {
+				branch = &ast.BlockStmt{Statements: []ast.Statement{elseIf}}
+			}
+			if value, returned, err := a.evaluatePureBlock(branch); err != nil || returned {
+				return value, returned, err
+			}
+		default:
+			return nil, false, fmt.Errorf("statement %T cannot be evaluated at compile time", stmt)
+		}
+	}
+	return nil, false, nil
+}
+
+// processAtAttribute returns the fixed address from an @at(addr)
+// attribute, or nil when there is none
+func (a *Analyzer) processAtAttribute(attrs []*ast.Attribute) (*uint16, error) {
+	for _, attr := range attrs {
+		if attr.Name != "at" {
+			continue
+		}
+		if len(attr.Arguments) != 1 {
+			return nil, fmt.Errorf("@at attribute requires exactly one argument")
+		}
+		value, err := a.evaluateConstExpr(attr.Arguments[0])
+		if err != nil {
+			return nil, fmt.Errorf("@at address must be a constant: %w", err)
+		}
+		addr, ok := value.(int64)
+		if !ok {
+			return nil, fmt.Errorf("@at address must be an integer, got %T", value)
+		}
+		if addr < 0 || addr > 0xFFFF {
+			return nil, fmt.Errorf("@at address %d is outside 0..65535", addr)
+		}
+		fixed := uint16(addr)
+		return &fixed, nil
+	}
+	return nil, nil
+}
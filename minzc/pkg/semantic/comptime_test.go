@@ -0,0 +1,117 @@
+package semantic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// comptimeProgram builds:
+//
+//	const SIZE: u8 = 4;
+//	@pure fun double(n: u16) -> u16 { return n * 2; }
+//	<globals>
+//	fun main() -> void {}
+func comptimeProgram(globals ...ast.Declaration) *ast.File {
+	u16 := &ast.PrimitiveType{Name: "u16"}
+	decls := []ast.Declaration{
+		&ast.ConstDecl{Name: "SIZE", Type: &ast.PrimitiveType{Name: "u8"}, Value: &ast.NumberLiteral{Value: 4}},
+		&ast.FunctionDecl{
+			Name:       "double",
+			Params:     []*ast.Parameter{{Name: "n", Type: u16}},
+			ReturnType: u16,
+			Attributes: []*ast.Attribute{{Name: "pure"}},
+			Body: &ast.BlockStmt{Statements: []ast.Statement{
+				&ast.ReturnStmt{Value: &ast.BinaryExpr{
+					Left:     &ast.Identifier{Name: "n"},
+					Operator: "*",
+					Right:    &ast.NumberLiteral{Value: 2},
+				}},
+			}},
+		},
+	}
+	decls = append(decls, globals...)
+	decls = append(decls, &ast.FunctionDecl{
+		Name:       "main",
+		ReturnType: &ast.PrimitiveType{Name: "void"},
+		Body:       &ast.BlockStmt{},
+	})
+	return &ast.File{Name: "comptime.minz", Declarations: decls}
+}
+
+// comptime wraps expr in @comptime(...)
+func comptime(expr ast.Expression) *ast.MetafunctionCall {
+	return &ast.MetafunctionCall{Name: "comptime", Arguments: []ast.Expression{expr}}
+}
+
+// twiceSize is 2 * SIZE
+func twiceSize() ast.Expression {
+	return &ast.BinaryExpr{Left: &ast.NumberLiteral{Value: 2}, Operator: "*", Right: &ast.Identifier{Name: "SIZE"}}
+}
+
+// comptimeGlobal analyzes a comptimeProgram and returns the named global,
+// which carries the file's module prefix
+func comptimeGlobal(t *testing.T, name string, globals ...ast.Declaration) ir.Global {
+	t.Helper()
+	module, err := NewAnalyzer().Analyze(comptimeProgram(globals...))
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	for _, g := range module.Globals {
+		if strings.HasSuffix(g.Name, "."+name) {
+			return g
+		}
+	}
+	t.Fatalf("global %s not generated", name)
+	return ir.Global{}
+}
+
+func TestComptimeArraySize(t *testing.T) {
+	buffer := comptimeGlobal(t, "buffer", &ast.VarDecl{
+		Name: "buffer",
+		Type: &ast.ArrayType{ElementType: &ast.PrimitiveType{Name: "u8"}, Size: comptime(twiceSize())},
+	})
+	arr, ok := buffer.Type.(*ir.ArrayType)
+	if !ok || arr.Length != 8 {
+		t.Errorf("buffer type = %v, want [u8; 8]", buffer.Type)
+	}
+}
+
+func TestComptimeAtAddress(t *testing.T) {
+	// @at(@comptime(0x4000 + double(2 * SIZE))) let attr: u8;
+	addr := &ast.BinaryExpr{
+		Left:     &ast.NumberLiteral{Value: 0x4000},
+		Operator: "+",
+		Right:    &ast.CallExpr{Function: &ast.Identifier{Name: "double"}, Arguments: []ast.Expression{twiceSize()}},
+	}
+	attr := comptimeGlobal(t, "attr", &ast.VarDecl{
+		Name:       "attr",
+		Type:       &ast.PrimitiveType{Name: "u8"},
+		Attributes: []*ast.Attribute{{Name: "at", Arguments: []ast.Expression{comptime(addr)}}},
+	})
+	if attr.Address == nil || *attr.Address != 0x4010 {
+		t.Errorf("attr address = %v, want $4010", attr.Address)
+	}
+}
+
+func TestComptimeNotFoldable(t *testing.T) {
+	// let mut counter: u8 = 0; let buffer: [u8; @comptime(counter + 1)];
+	_, err := NewAnalyzer().Analyze(comptimeProgram(
+		&ast.VarDecl{Name: "counter", Type: &ast.PrimitiveType{Name: "u8"}, Value: &ast.NumberLiteral{Value: 0}, IsMutable: true},
+		&ast.VarDecl{
+			Name: "buffer",
+			Type: &ast.ArrayType{
+				ElementType: &ast.PrimitiveType{Name: "u8"},
+				Size:        comptime(&ast.BinaryExpr{Left: &ast.Identifier{Name: "counter"}, Operator: "+", Right: &ast.NumberLiteral{Value: 1}}),
+			},
+		},
+	))
+	if err == nil {
+		t.Fatal("expected an error for a non-constant @comptime expression")
+	}
+	if !strings.Contains(err.Error(), "@comptime expression cannot be folded") || !strings.Contains(err.Error(), "counter") {
+		t.Errorf("error = %v, want a @comptime folding error naming counter", err)
+	}
+}
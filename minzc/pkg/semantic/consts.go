@@ -0,0 +1,114 @@
+package semantic
+
+import (
+	"fmt"
+
+	"github.com/minz/minzc/pkg/ast"
+)
+
+// Top-level constants are resolved on first use rather than in source
+// order, so anything that needs a value at compile time can use constants
+// declared further down the file:
+//
+//	global screen: [u8; SCREEN_W * SCREEN_H];
+//	enum Key { Space = KEY_BASE, Enter }
+//	@at(SCREEN_BASE + 0x1800) global attrs: [u8; 768];
+//
+//	const SCREEN_W: u8 = 32;
+//	const SCREEN_H: u8 = HALF * 2;
+//	const HALF: u8 = 12;
+//	const KEY_BASE: u8 = 0x20;
+//	const SCREEN_BASE: u16 = 0x4000;
+//
+// Array lengths, enum values, @at addresses and constants all fold through
+// evaluateConstExpr: literals, operators, other constants, @if and
+// @comptime, whose @pure functions may also be declared anywhere in the
+// file. A constant defined in terms of itself is an error.
+
+// collectConstants records the file's constants and @pure functions so
+// that they can be used before their declarations
+func (a *Analyzer) collectConstants(decls []ast.Declaration) {
+	a.constDecls = make(map[string]*ast.ConstDecl)
+	a.resolvedConsts = make(map[*ast.ConstDecl]bool)
+	a.resolvingConsts = make(map[string]bool)
+	for _, decl := range decls {
+		switch d := decl.(type) {
+		case *ast.ConstDecl:
+			if _, seen := a.constDecls[d.Name]; !seen && !isFunctionTableDecl(d) {
+				a.constDecls[d.Name] = d
+			}
+		case *ast.FunctionDecl:
+			if len(d.GenericParams) == 0 && hasAttribute(d.Attributes, "pure") {
+				a.pureFunctions[d.Name] = d
+			}
+		}
+	}
+}
+
+// declareConst analyzes a top-level constant in declaration order, unless
+// a use further up has already resolved it
+func (a *Analyzer) declareConst(c *ast.ConstDecl) error {
+	if a.resolvedConsts[c] {
+		return nil
+	}
+	return a.analyzeConstOnce(c)
+}
+
+// resolveConst analyzes the constant name ahead of its declaration and
+// returns its symbol, or nil when no top-level constant has that name
+func (a *Analyzer) resolveConst(name string) (Symbol, error) {
+	c, ok := a.constDecls[name]
+	if !ok {
+		return nil, nil
+	}
+	if a.resolvingConsts[name] {
+		return nil, fmt.Errorf("constant %s is defined in terms of itself", name)
+	}
+	if a.resolvedConsts[c] {
+		// Analyzed, yet not defined
+		return nil, fmt.Errorf("constant %s is invalid", name)
+	}
+
+	// Constants live in the file scope, whatever scope the use is in
+	scope := a.currentScope
+	for a.currentScope.parent != nil {
+		a.currentScope = a.currentScope.parent
+	}
+	err := a.analyzeConstOnce(c)
+	a.currentScope = scope
+	if err != nil {
+		a.report(c, err)
+		return nil, fmt.Errorf("constant %s is invalid", name)
+	}
+	return a.currentScope.Lookup(name), nil
+}
+
+// analyzeConstOnce analyzes a constant, marking it resolved so that no
+// later use or declaration analyzes it again
+func (a *Analyzer) analyzeConstOnce(c *ast.ConstDecl) error {
+	a.resolvedConsts[c] = true
+	a.resolvingConsts[c.Name] = true
+	defer delete(a.resolvingConsts, c.Name)
+	return a.analyzeConstDecl(c)
+}
+
+// enumValue folds an explicit enum discriminant
+func (a *Analyzer) enumValue(expr ast.Expression) (int, error) {
+	value, err := a.evaluateConstExpr(expr)
+	if err != nil {
+		return 0, fmt.Errorf("must be a constant: %w", err)
+	}
+	var n int64
+	switch v := value.(type) {
+	case int64:
+		n = v
+	case int:
+		n = int64(v)
+	default:
+		return 0, fmt.Errorf("must be an integer, got %T", value)
+	}
+	if n < 0 || n > 0xFFFF {
+		return 0, fmt.Errorf("%d is outside 0..65535", n)
+	}
+	return int(n), nil
+}
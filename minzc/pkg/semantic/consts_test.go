@@ -0,0 +1,119 @@
+package semantic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// constsProgram builds:
+//
+//	global screen: [u8; SCREEN_W * SCREEN_H];
+//	enum Key { Space = KEY_BASE, Enter, Escape = 0x1B }
+//	const SCREEN_W: u8 = 32;
+//	const SCREEN_H: u8 = HALF * 2;
+//	const HALF: u8 = 12;
+//	const KEY_BASE = SCREEN_W;
+//	fun main() -> void {}
+func constsProgram() *ast.File {
+	u8 := &ast.PrimitiveType{Name: "u8"}
+	id := func(name string) *ast.Identifier { return &ast.Identifier{Name: name} }
+	return &ast.File{
+		Name: "consts.minz",
+		Declarations: []ast.Declaration{
+			&ast.VarDecl{
+				Name: "screen",
+				Type: &ast.ArrayType{
+					ElementType: u8,
+					Size:        &ast.BinaryExpr{Left: id("SCREEN_W"), Operator: "*", Right: id("SCREEN_H")},
+				},
+			},
+			&ast.EnumDecl{
+				Name:     "Key",
+				Variants: []string{"Space", "Enter", "Escape"},
+				Values: map[string]ast.Expression{
+					"Space":  id("KEY_BASE"),
+					"Escape": &ast.NumberLiteral{Value: 0x1B},
+				},
+			},
+			&ast.ConstDecl{Name: "SCREEN_W", Type: u8, Value: &ast.NumberLiteral{Value: 32}},
+			&ast.ConstDecl{Name: "SCREEN_H", Type: u8, Value: &ast.BinaryExpr{Left: id("HALF"), Operator: "*", Right: &ast.NumberLiteral{Value: 2}}},
+			&ast.ConstDecl{Name: "HALF", Type: u8, Value: &ast.NumberLiteral{Value: 12}},
+			&ast.ConstDecl{Name: "KEY_BASE", Value: id("SCREEN_W")},
+			&ast.FunctionDecl{Name: "main", ReturnType: &ast.PrimitiveType{Name: "void"}, Body: &ast.BlockStmt{}},
+		},
+	}
+}
+
+func TestConstsBeforeDeclaration(t *testing.T) {
+	a := NewAnalyzer()
+	module, err := a.Analyze(constsProgram())
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	var screen *ir.Global
+	for i := range module.Globals {
+		if strings.HasSuffix(module.Globals[i].Name, ".screen") {
+			screen = &module.Globals[i]
+		}
+	}
+	if screen == nil {
+		t.Fatal("global screen not generated")
+	}
+	if arr, ok := screen.Type.(*ir.ArrayType); !ok || arr.Length != 768 {
+		t.Errorf("screen type = %v, want [u8; 768]", screen.Type)
+	}
+
+	sym, ok := a.currentScope.Lookup("Key").(*TypeSymbol)
+	if !ok {
+		t.Fatal("enum Key not registered")
+	}
+	key := sym.Type.(*ir.EnumType)
+	for variant, want := range map[string]int{"Space": 32, "Enter": 33, "Escape": 0x1B} {
+		if got := key.Variants[variant]; got != want {
+			t.Errorf("Key.%s = %d, want %d", variant, got, want)
+		}
+	}
+	if got := enumNameLimit(key); got != 34 {
+		t.Errorf("name table limit = %d, want 34", got)
+	}
+}
+
+func TestConstErrors(t *testing.T) {
+	u8 := &ast.PrimitiveType{Name: "u8"}
+	id := func(name string) *ast.Identifier { return &ast.Identifier{Name: name} }
+	main := &ast.FunctionDecl{Name: "main", ReturnType: &ast.PrimitiveType{Name: "void"}, Body: &ast.BlockStmt{}}
+	for _, tt := range []struct {
+		name  string
+		decls []ast.Declaration
+		want  string
+	}{
+		{"cycle", []ast.Declaration{
+			&ast.ConstDecl{Name: "A", Type: u8, Value: &ast.BinaryExpr{Left: id("B"), Operator: "+", Right: &ast.NumberLiteral{Value: 1}}},
+			&ast.ConstDecl{Name: "B", Type: u8, Value: id("A")},
+		}, "constant A is defined in terms of itself"},
+		{"array size", []ast.Declaration{
+			&ast.VarDecl{Name: "buf", Type: &ast.ArrayType{ElementType: u8, Size: id("MISSING")}},
+		}, "array size must be a constant: undefined constant: MISSING"},
+		{"duplicate enum value", []ast.Declaration{
+			&ast.EnumDecl{Name: "Dup", Variants: []string{"A", "B"}, Values: map[string]ast.Expression{"B": &ast.NumberLiteral{Value: 0}}},
+		}, "Dup.A and Dup.B both have value 0"},
+		{"repr", []ast.Declaration{
+			&ast.EnumDecl{
+				Name:       "Wide",
+				Variants:   []string{"A"},
+				Values:     map[string]ast.Expression{"A": &ast.NumberLiteral{Value: 300}},
+				Attributes: []*ast.Attribute{{Name: "repr", Arguments: []ast.Expression{id("u8")}}},
+			},
+		}, "values above 255, too large for @repr(u8)"},
+	} {
+		file := &ast.File{Name: "errors.minz", Declarations: append(tt.decls, main)}
+		_, err := NewAnalyzer().Analyze(file)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
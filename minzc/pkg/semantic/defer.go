@@ -0,0 +1,54 @@
+package semantic
+
+import (
+	"fmt"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// A deferred statement runs when control leaves the block it is in, at the
+// block's end or at a return, so paired operations stay together:
+//
+//	fun read_banked(bank: u8) -> u8 {
+//	    let saved = current_bank();
+//	    select_bank(bank);
+//	    defer select_bank(saved);
+//	    if bank == 0 { return 0; }    // saved is selected again before the return
+//	    return peek_bank();           // and here, after peek_bank()
+//	}
+//
+// Defers are lowered statically: the analyzer compiles a fresh copy of the
+// statement at each exit, with no runtime list of cleanups. They run in
+// reverse order, together with the drop() calls of Drop locals (see
+// drop.go), and a return evaluates its value before running them.
+
+// analyzeDeferStmt registers a deferred statement to run when the enclosing
+// block exits
+func (a *Analyzer) analyzeDeferStmt(d *ast.DeferStmt, irFunc *ir.Function) error {
+	if d.Body == nil {
+		return fmt.Errorf("defer requires a statement or block")
+	}
+	if containsReturn(d.Body) {
+		return fmt.Errorf("return is not allowed in a deferred statement")
+	}
+	scopes := a.dropScopes[irFunc]
+	if len(scopes) == 0 {
+		return fmt.Errorf("defer outside a block")
+	}
+	scopes[len(scopes)-1] = append(scopes[len(scopes)-1], dropLocal{deferred: d.Body, scope: a.currentScope})
+	return nil
+}
+
+// emitDeferred compiles a copy of a deferred statement in the scope it was
+// deferred in
+func (a *Analyzer) emitDeferred(local dropLocal, irFunc *ir.Function) error {
+	prevScope := a.currentScope
+	a.currentScope = local.scope
+	err := a.analyzeStatement(local.deferred, irFunc)
+	a.currentScope = prevScope
+	if err != nil {
+		return fmt.Errorf("deferred statement: %w", err)
+	}
+	return nil
+}
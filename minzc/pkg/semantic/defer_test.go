@@ -0,0 +1,130 @@
+package semantic
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// deferMark builds defer mark(n)
+func deferMark(n int64) *ast.DeferStmt {
+	return &ast.DeferStmt{Body: &ast.ExpressionStmt{Expression: &ast.CallExpr{
+		Function:  &ast.Identifier{Name: "mark"},
+		Arguments: []ast.Expression{&ast.NumberLiteral{Value: n}},
+	}}}
+}
+
+// deferEvents analyzes a dropProgram with fun mark(n: u8) -> void {} added,
+// and lists the marks, drops and returns in use(), in instruction order
+func deferEvents(t *testing.T, body ...ast.Statement) ([]string, error) {
+	t.Helper()
+	file := dropProgram(body...)
+	file.Declarations = append(file.Declarations, &ast.FunctionDecl{
+		Name:       "mark",
+		Params:     []*ast.Parameter{{Name: "n", Type: &ast.PrimitiveType{Name: "u8"}}},
+		ReturnType: &ast.PrimitiveType{Name: "void"},
+		Body:       &ast.BlockStmt{},
+	})
+	module, err := NewAnalyzer().Analyze(file)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fn := range module.Functions {
+		if !strings.Contains(fn.Name, ".use") {
+			continue
+		}
+		consts := make(map[ir.Register]int64)
+		loads := make(map[ir.Register]string)
+		var events []string
+		for _, inst := range fn.Instructions {
+			switch {
+			case inst.Op == ir.OpLoadConst:
+				consts[inst.Dest] = inst.Imm
+			case inst.Op == ir.OpLoadVar:
+				loads[inst.Dest] = inst.Symbol
+			case inst.Op == ir.OpCall && strings.Contains(inst.Symbol, ".mark"):
+				events = append(events, "mark "+strconv.FormatInt(consts[inst.Args[0]], 10))
+			case inst.Op == ir.OpCall && strings.Contains(inst.Symbol, "Port.drop"):
+				events = append(events, "drop "+loads[inst.Args[0]])
+			case inst.Op == ir.OpReturn:
+				events = append(events, "return")
+			}
+		}
+		return events, nil
+	}
+	t.Fatal("use not generated")
+	return nil, nil
+}
+
+func TestDeferAtEveryReturn(t *testing.T) {
+	got, err := deferEvents(t,
+		deferMark(1),
+		deferMark(2),
+		&ast.IfStmt{
+			Condition: &ast.Identifier{Name: "flag"},
+			Then:      &ast.BlockStmt{Statements: []ast.Statement{returnValue(0)}},
+		},
+		returnValue(1),
+	)
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	want := []string{"mark 2", "mark 1", "return", "mark 2", "mark 1", "return"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestDeferInBlock(t *testing.T) {
+	// A defer in an inner block runs at that block's end, and at a return
+	// from inside it before the outer defers
+	got, err := deferEvents(t,
+		deferMark(1),
+		&ast.IfStmt{
+			Condition: &ast.Identifier{Name: "flag"},
+			Then: &ast.BlockStmt{Statements: []ast.Statement{
+				deferMark(2),
+				&ast.IfStmt{
+					Condition: &ast.Identifier{Name: "flag"},
+					Then:      &ast.BlockStmt{Statements: []ast.Statement{returnValue(0)}},
+				},
+				&ast.ExpressionStmt{Expression: &ast.NumberLiteral{Value: 0}},
+			}},
+		},
+		returnValue(1),
+	)
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	want := []string{"mark 2", "mark 1", "return", "mark 2", "mark 1", "return"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestDeferWithDrop(t *testing.T) {
+	// Defers and drops run together, in reverse order
+	got, err := deferEvents(t, openPort("a"), deferMark(1), openPort("b"), returnValue(0))
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	want := []string{"drop b", "mark 1", "drop a", "return"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestDeferReturnRejected(t *testing.T) {
+	_, err := deferEvents(t,
+		&ast.DeferStmt{Body: &ast.BlockStmt{Statements: []ast.Statement{returnValue(0)}}},
+		returnValue(1),
+	)
+	if err == nil || !strings.Contains(err.Error(), "return is not allowed in a deferred statement") {
+		t.Fatalf("got error %v, want a return-in-defer error", err)
+	}
+}
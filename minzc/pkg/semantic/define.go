@@ -0,0 +1,205 @@
+package semantic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// Compile-time constants are defined with @define or on the command line
+// (-D NAME=value), and fold like any other constant:
+//
+//	@define(DEBUG, 0);
+//	@define(BUFFER_SIZE, 16 * 4);
+//
+//	@if(DEBUG) {
+//	    @define(LOG_LEVEL, 3);
+//	    var trace_count: u16 = 0;
+//	}
+//
+// Names defined at the top level of a file are global: every file compiled
+// with it sees them, including the modules it imports. Inside a function a
+// define lasts to the end of its block. A -D value wins over an @define of
+// the same name, so a source gives the defaults and the build overrides them.
+//
+// At the top level @if keeps only the declarations of the branch it takes.
+// In a function a plain if whose condition folds to a constant compiles
+// only the taken branch, so if DEBUG { ... } costs nothing when DEBUG is 0.
+
+// SetDefine defines name as a compile-time constant in every file compiled
+// (-D NAME=value). The value is an int64, a bool or a string.
+func (a *Analyzer) SetDefine(name string, value interface{}) {
+	if a.defines == nil {
+		a.defines = make(map[string]interface{})
+	}
+	a.defines[name] = value
+}
+
+// isDefineCall reports whether an expression is @define(NAME, value)
+func isDefineCall(expr ast.Expression) (*ast.MetafunctionCall, bool) {
+	call, ok := expr.(*ast.MetafunctionCall)
+	if !ok || strings.TrimPrefix(call.Name, "@") != "define" {
+		return nil, false
+	}
+	return call, true
+}
+
+// registerDefines defines the command-line constants in the global scope
+func (a *Analyzer) registerDefines() {
+	for name, value := range a.defines {
+		a.rootScope().Define(name, &ConstSymbol{Name: name, Type: defineType(value), Value: value})
+	}
+}
+
+// analyzeDefine defines the constant of @define(NAME, value) in scope
+func (a *Analyzer) analyzeDefine(call *ast.MetafunctionCall, scope *Scope) error {
+	if len(call.Arguments) != 2 {
+		return a.errorAt(call, "@define requires a name and a value")
+	}
+	id, ok := call.Arguments[0].(*ast.Identifier)
+	if !ok {
+		return a.errorAt(call, "@define: the first argument must be a name")
+	}
+	if _, ok := a.defines[id.Name]; ok {
+		return nil // Overridden with -D
+	}
+	value, err := a.evaluateConstantExpression(call.Arguments[1])
+	if err != nil {
+		return a.errorAt(call, "@define(%s): value must be constant: %v", id.Name, err)
+	}
+	if sym, ok := scope.symbols[id.Name]; ok {
+		if c, isConst := sym.(*ConstSymbol); !isConst || c.Value != value {
+			return a.errorAt(call, "@define(%s): %s is already defined", id.Name, id.Name)
+		}
+		return nil
+	}
+	scope.Define(id.Name, &ConstSymbol{Name: id.Name, Type: defineType(value), Value: value})
+	return nil
+}
+
+// defineType is the type of a defined value, inferred as for a literal
+func defineType(value interface{}) ir.Type {
+	switch v := value.(type) {
+	case int64:
+		if v >= 0 && v <= 255 {
+			return &ir.BasicType{Kind: ir.TypeU8}
+		} else if v >= -128 && v <= 127 {
+			return &ir.BasicType{Kind: ir.TypeI8}
+		} else if v >= 0 && v <= 65535 {
+			return &ir.BasicType{Kind: ir.TypeU16}
+		}
+		return &ir.BasicType{Kind: ir.TypeI16}
+	case bool:
+		return &ir.BasicType{Kind: ir.TypeBool}
+	default:
+		return &ir.PointerType{Base: &ir.BasicType{Kind: ir.TypeU8}}
+	}
+}
+
+// rootScope returns the global scope
+func (a *Analyzer) rootScope() *Scope {
+	scope := a.currentScope
+	for scope.parent != nil {
+		scope = scope.parent
+	}
+	return scope
+}
+
+// expandCompileTime evaluates the top-level @defines of a file, then
+// replaces each top-level @if by the declarations of the branch it takes
+func (a *Analyzer) expandCompileTime(decls []ast.Declaration) []ast.Declaration {
+	// All @defines first, so that every @if sees them
+	for _, decl := range decls {
+		if d, ok := decl.(*ast.ExpressionDecl); ok {
+			if call, ok := isDefineCall(d.Expression); ok {
+				if err := a.analyzeDefine(call, a.rootScope()); err != nil {
+					a.report(call, err)
+				}
+			}
+		}
+	}
+
+	var expanded []ast.Declaration
+	for _, decl := range decls {
+		switch d := decl.(type) {
+		case *ast.ExpressionDecl:
+			if _, ok := isDefineCall(d.Expression); ok {
+				continue
+			}
+		case *ast.CompileTimeIf:
+			branch, err := a.compileTimeBranch(d)
+			if err != nil {
+				a.report(d, err)
+				continue
+			}
+			expanded = append(expanded, a.expandCompileTime(branch)...)
+			continue
+		}
+		expanded = append(expanded, decl)
+	}
+	return expanded
+}
+
+// compileTimeBranch returns the declarations of the branch a top-level @if
+// takes
+func (a *Analyzer) compileTimeBranch(ctIf *ast.CompileTimeIf) ([]ast.Declaration, error) {
+	taken, ok := a.constantCondition(ctIf.Condition)
+	if !ok {
+		_, err := a.evaluateConstantExpression(ctIf.Condition)
+		return nil, fmt.Errorf("@if condition must be constant: %v", err)
+	}
+	branch := ctIf.ElseExpr
+	if taken {
+		branch = ctIf.ThenExpr
+	}
+	block, _ := branch.(*ast.BlockStmt)
+	if block == nil {
+		return nil, nil
+	}
+
+	var decls []ast.Declaration
+	for _, stmt := range block.Statements {
+		switch s := stmt.(type) {
+		case *ast.ExpressionStmt:
+			decls = append(decls, &ast.ExpressionDecl{Expression: s.Expression, StartPos: s.StartPos, EndPos: s.EndPos})
+		case ast.Declaration:
+			decls = append(decls, s)
+		default:
+			return nil, fmt.Errorf("@if at the top level can only hold declarations, not %T", stmt)
+		}
+	}
+	return decls, nil
+}
+
+// constantCondition folds a condition to whether it holds, if it is constant
+func (a *Analyzer) constantCondition(cond ast.Expression) (taken bool, ok bool) {
+	value, err := a.evaluateConstantExpression(cond)
+	if err != nil {
+		return false, false
+	}
+	switch value.(type) {
+	case int64, bool:
+		return a.isTruthy(value), true
+	}
+	return false, false
+}
+
+// moduleConstant resolves a qualified name such as config.DEBUG or
+// util.config.SIZE to a constant of an imported module
+func (a *Analyzer) moduleConstant(field *ast.FieldExpr) (*ConstSymbol, bool) {
+	name := field.Field
+	for obj := field.Object; ; {
+		switch o := obj.(type) {
+		case *ast.Identifier:
+			c, ok := a.currentScope.Lookup(o.Name + "." + name).(*ConstSymbol)
+			return c, ok
+		case *ast.FieldExpr:
+			name = o.Field + "." + name
+			obj = o.Object
+		default:
+			return nil, false
+		}
+	}
+}
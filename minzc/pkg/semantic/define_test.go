@@ -0,0 +1,133 @@
+package semantic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// define is the top-level declaration @define(name, value)
+func define(name string, value ast.Expression) *ast.ExpressionDecl {
+	return &ast.ExpressionDecl{Expression: defineCall(name, value)}
+}
+
+func defineCall(name string, value ast.Expression) *ast.MetafunctionCall {
+	return &ast.MetafunctionCall{Name: "@define", Arguments: []ast.Expression{&ast.Identifier{Name: name}, value}}
+}
+
+// definesMain analyzes file with main() -> u16 { body } added and returns
+// the constants main loads and whether it branches
+func definesMain(t *testing.T, analyzer *Analyzer, file *ast.File, body ...ast.Statement) ([]int64, bool) {
+	t.Helper()
+	file.Declarations = append(file.Declarations, &ast.FunctionDecl{
+		Name:       "main",
+		ReturnType: &ast.PrimitiveType{Name: "u16"},
+		Body:       &ast.BlockStmt{Statements: body},
+	})
+	module, err := analyzer.Analyze(file)
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	for _, fn := range module.Functions {
+		if !strings.HasSuffix(fn.Name, "main") {
+			continue
+		}
+		var consts []int64
+		branches := false
+		for _, inst := range fn.Instructions {
+			switch inst.Op {
+			case ir.OpLoadConst:
+				consts = append(consts, inst.Imm)
+			case ir.OpJumpIf, ir.OpJumpIfNot:
+				branches = true
+			}
+		}
+		return consts, branches
+	}
+	t.Fatal("main was not compiled")
+	return nil, false
+}
+
+func TestDefine(t *testing.T) {
+	debug := &ast.Identifier{Name: "DEBUG"}
+	file := func() *ast.File {
+		return &ast.File{Name: "main.minz", Declarations: []ast.Declaration{
+			define("DEBUG", &ast.NumberLiteral{Value: 0}),
+			&ast.CompileTimeIf{
+				Condition: debug,
+				ThenExpr:  &ast.BlockStmt{Statements: []ast.Statement{&ast.ExpressionStmt{Expression: defineCall("LEVEL", &ast.NumberLiteral{Value: 3})}}},
+				ElseExpr:  &ast.BlockStmt{Statements: []ast.Statement{&ast.ExpressionStmt{Expression: defineCall("LEVEL", &ast.NumberLiteral{Value: 1})}}},
+			},
+		}}
+	}
+	body := func() []ast.Statement {
+		return []ast.Statement{
+			&ast.IfStmt{Condition: debug, Then: &ast.BlockStmt{Statements: []ast.Statement{
+				&ast.ReturnStmt{Value: &ast.NumberLiteral{Value: 999}},
+			}}},
+			&ast.ReturnStmt{Value: &ast.Identifier{Name: "LEVEL"}},
+		}
+	}
+
+	consts, branches := definesMain(t, NewAnalyzer(), file(), body()...)
+	if branches || contains(consts, 999) || !contains(consts, 1) {
+		t.Errorf("DEBUG=0: main loads %v (branches: %v), want LEVEL 1 without the debug branch", consts, branches)
+	}
+
+	// -D overrides the @define
+	analyzer := NewAnalyzer()
+	analyzer.SetDefine("DEBUG", int64(1))
+	consts, branches = definesMain(t, analyzer, file(), body()...)
+	if branches || !contains(consts, 999) || !contains(consts, 3) {
+		t.Errorf("-D DEBUG=1: main loads %v (branches: %v), want 999 and LEVEL 3", consts, branches)
+	}
+}
+
+func TestDefineRedefinition(t *testing.T) {
+	_, err := NewAnalyzer().Analyze(&ast.File{Name: "main.minz", Declarations: []ast.Declaration{
+		define("SIZE", &ast.NumberLiteral{Value: 1}),
+		define("SIZE", &ast.NumberLiteral{Value: 2}),
+	}})
+	if err == nil || !strings.Contains(err.Error(), "SIZE is already defined") {
+		t.Fatalf("got error %v, want a redefinition error", err)
+	}
+}
+
+func TestDefineAcrossModules(t *testing.T) {
+	root := writeModules(t, map[string]string{
+		"config.minz": "pub const SIZE: u16 = 300;\n\n@if(TRACE) {\n    @define(FACTOR, 3);\n} else {\n    @define(FACTOR, 2);\n}\n",
+	})
+
+	// The module sees TRACE from the main file, which folds the module's
+	// constant and define
+	analyzer := NewAnalyzer()
+	analyzer.SetProjectRoot(root)
+	total := &ast.BinaryExpr{
+		Left:     &ast.FieldExpr{Object: &ast.Identifier{Name: "config"}, Field: "SIZE"},
+		Operator: "+",
+		Right:    &ast.Identifier{Name: "FACTOR"},
+	}
+	file := &ast.File{
+		Name:    "main.minz",
+		Imports: []*ast.ImportStmt{{Path: "config"}},
+		Declarations: []ast.Declaration{
+			define("TRACE", &ast.BooleanLiteral{Value: true}),
+			&ast.ConstDecl{Name: "TOTAL", Type: &ast.PrimitiveType{Name: "u16"}, Value: total},
+		},
+	}
+	consts, _ := definesMain(t, analyzer, file, &ast.ReturnStmt{Value: &ast.Identifier{Name: "TOTAL"}})
+	if !contains(consts, 303) {
+		t.Errorf("main loads %v, want TOTAL = 303", consts)
+	}
+}
+
+func contains(values []int64, want int64) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
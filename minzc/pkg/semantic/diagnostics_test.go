@@ -0,0 +1,68 @@
+package semantic
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/diagnostics"
+)
+
+func TestAnalyzeDiagnostics(t *testing.T) {
+	u8 := &ast.PrimitiveType{Name: "u8"}
+	void := &ast.PrimitiveType{Name: "void"}
+
+	// fun main() -> void {
+	//     let counter: u8 = 1;
+	//     let y: u8 = countr;
+	// }
+	// fun other() -> void {
+	//     let z: u8 = missing;
+	// }
+	file := &ast.File{
+		Name: "diag.minz",
+		Declarations: []ast.Declaration{
+			&ast.FunctionDecl{
+				Name:       "main",
+				ReturnType: void,
+				StartPos:   ast.Position{Line: 1, Column: 1},
+				Body: &ast.BlockStmt{Statements: []ast.Statement{
+					&ast.VarDecl{Name: "counter", Type: u8, Value: &ast.NumberLiteral{Value: 1}, StartPos: ast.Position{Line: 2, Column: 5}},
+					&ast.VarDecl{Name: "y", Type: u8, Value: &ast.Identifier{Name: "countr"}, StartPos: ast.Position{Line: 3, Column: 5}},
+				}},
+			},
+			&ast.FunctionDecl{
+				Name:       "other",
+				ReturnType: void,
+				StartPos:   ast.Position{Line: 5, Column: 1},
+				Body: &ast.BlockStmt{Statements: []ast.Statement{
+					&ast.VarDecl{Name: "z", Type: u8, Value: &ast.Identifier{Name: "missing"}, StartPos: ast.Position{Line: 6, Column: 5}},
+				}},
+			},
+		},
+	}
+
+	_, err := NewAnalyzer().Analyze(file)
+	if err == nil {
+		t.Fatal("analysis succeeded")
+	}
+	diags := diagnostics.FromError(fmt.Errorf("semantic error: %w", err))
+	if len(diags) != 2 {
+		t.Fatalf("got %d diagnostics, want 2: %+v", len(diags), diags)
+	}
+	for i, want := range []struct {
+		line int
+		hint string
+	}{
+		{3, "replace it with 'counter'"},
+		{6, "declare it before use, or import the module that defines it"},
+	} {
+		d := diags[i]
+		if d.File != "diag.minz" || d.Line != want.line || d.Column != 5 {
+			t.Errorf("diagnostic %d at %s:%d:%d, want diag.minz:%d:5", i, d.File, d.Line, d.Column, want.line)
+		}
+		if d.Severity != diagnostics.SeverityError || d.Code != diagnostics.CodeUndefined || d.Hint != want.hint {
+			t.Errorf("diagnostic %d = %+v", i, d)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package semantic
+
+import (
+	"fmt"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// Locals of a type implementing the Drop interface are cleaned up when
+// they go out of scope:
+//
+//	interface Drop { drop(self: Port) -> void; }
+//	impl Drop for Port { fun drop(self: Port) -> void { release(self.id); } }
+//
+//	fun poll() -> u8 {
+//	    let p: Port = open_port();
+//	    if busy(p) { return 0; }    // p.drop() runs before the return
+//	    return read(p);             // and here, after read(p)
+//	}
+//
+// A block that runs to its end drops its Drop locals in reverse declaration
+// order. A return evaluates its value first and then drops the locals of
+// every enclosing block, innermost first. Passing a local to a call only
+// borrows it. Returning it or copying it into another variable moves it,
+// and a moved local is not dropped. Moves are recorded as they are
+// analyzed, so a move on one path also suppresses the drop on the others:
+// a value may leak, but it is never dropped twice.
+
+// dropInterface and dropMethod name the cleanup interface and its method
+const (
+	dropInterface = "Drop"
+	dropMethod    = "drop"
+)
+
+// dropLocal is a local that needs drop() when its block exits, or a
+// deferred statement to run then (see defer.go)
+type dropLocal struct {
+	sym      *VarSymbol
+	deferred ast.Statement
+	scope    *Scope // Scope the local was declared in, to call drop() on it even where it is shadowed
+}
+
+// implementsDrop reports whether a type has an impl of the Drop interface
+func (a *Analyzer) implementsDrop(t ir.Type) bool {
+	return a.implements(t, dropInterface)
+}
+
+// enterDropScope starts tracking Drop locals for a block of irFunc
+func (a *Analyzer) enterDropScope(irFunc *ir.Function) {
+	a.dropScopes[irFunc] = append(a.dropScopes[irFunc], nil)
+}
+
+// exitDropScope stops tracking the innermost block of irFunc. When control
+// can reach the block's end, its locals are dropped there.
+func (a *Analyzer) exitDropScope(irFunc *ir.Function, fallsThrough bool) error {
+	scopes := a.dropScopes[irFunc]
+	if len(scopes) == 0 {
+		return nil
+	}
+	innermost := scopes[len(scopes)-1]
+	if len(scopes) == 1 {
+		delete(a.dropScopes, irFunc)
+	} else {
+		a.dropScopes[irFunc] = scopes[:len(scopes)-1]
+	}
+	if !fallsThrough {
+		return nil
+	}
+	return a.emitDrops(innermost, irFunc)
+}
+
+// trackDrop registers a just-declared local for drop() if its type
+// implements Drop
+func (a *Analyzer) trackDrop(name string, irFunc *ir.Function) {
+	scopes := a.dropScopes[irFunc]
+	if len(scopes) == 0 {
+		return
+	}
+	sym, ok := a.currentScope.symbols[name].(*VarSymbol)
+	if !ok || !a.implementsDrop(sym.Type) {
+		return
+	}
+	scopes[len(scopes)-1] = append(scopes[len(scopes)-1], dropLocal{sym: sym, scope: a.currentScope})
+}
+
+// noteMove records that expr moves a Drop local out, if it names one
+func (a *Analyzer) noteMove(expr ast.Expression) {
+	id, ok := expr.(*ast.Identifier)
+	if !ok {
+		return
+	}
+	if sym, ok := a.currentScope.Lookup(id.Name).(*VarSymbol); ok && a.implementsDrop(sym.Type) {
+		a.movedLocals[sym] = true
+	}
+}
+
+// emitReturnDrops drops the locals of every enclosing block of irFunc
+// before a return
+func (a *Analyzer) emitReturnDrops(irFunc *ir.Function) error {
+	scopes := a.dropScopes[irFunc]
+	for i := len(scopes) - 1; i >= 0; i-- {
+		if err := a.emitDrops(scopes[i], irFunc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emitDrops calls drop() on a block's locals and runs its deferred
+// statements in reverse declaration order, skipping moved locals
+func (a *Analyzer) emitDrops(locals []dropLocal, irFunc *ir.Function) error {
+	for i := len(locals) - 1; i >= 0; i-- {
+		local := locals[i]
+		if local.deferred != nil {
+			if err := a.emitDeferred(local, irFunc); err != nil {
+				return err
+			}
+			continue
+		}
+		if a.movedLocals[local.sym] {
+			continue
+		}
+
+		call := &ast.CallExpr{
+			Function:  &ast.FieldExpr{Object: &ast.Identifier{Name: local.sym.Name}, Field: dropMethod},
+			Arguments: []ast.Expression{},
+		}
+		prevScope := a.currentScope
+		a.currentScope = local.scope
+		_, err := a.analyzeExpression(call, irFunc)
+		a.currentScope = prevScope
+		if err != nil {
+			return fmt.Errorf("dropping %s: %w", local.sym.Name, err)
+		}
+	}
+	return nil
+}
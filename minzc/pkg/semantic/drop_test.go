@@ -0,0 +1,150 @@
+package semantic
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// dropProgram builds:
+//
+//	struct Port { id: u8 }
+//	interface Drop { drop(self: Port) -> void; }
+//	impl Drop for Port { fun drop(self: Port) -> void {} }
+//	fun use(flag: bool) -> u8 { <body> }
+func dropProgram(body ...ast.Statement) *ast.File {
+	port := func() ast.Type { return &ast.TypeIdentifier{Name: "Port"} }
+	void := &ast.PrimitiveType{Name: "void"}
+	self := func() []*ast.Parameter { return []*ast.Parameter{{Name: "self", Type: port(), IsSelf: true}} }
+
+	return &ast.File{
+		Name: "ports.minz",
+		Declarations: []ast.Declaration{
+			&ast.StructDecl{
+				Name:   "Port",
+				Fields: []*ast.Field{{Name: "id", Type: &ast.PrimitiveType{Name: "u8"}}},
+			},
+			&ast.InterfaceDecl{
+				Name:    "Drop",
+				Methods: []*ast.InterfaceMethod{{Name: "drop", Params: self(), ReturnType: void}},
+			},
+			&ast.ImplBlock{
+				InterfaceName: "Drop",
+				ForType:       port(),
+				Methods: []*ast.FunctionDecl{{
+					Name:       "drop",
+					Params:     self(),
+					ReturnType: void,
+					Body:       &ast.BlockStmt{},
+				}},
+			},
+			&ast.FunctionDecl{
+				Name:       "use",
+				Params:     []*ast.Parameter{{Name: "flag", Type: &ast.PrimitiveType{Name: "bool"}}},
+				ReturnType: &ast.PrimitiveType{Name: "u8"},
+				Body:       &ast.BlockStmt{Statements: body},
+			},
+		},
+	}
+}
+
+// openPort declares let name: Port = Port { id: 1 }
+func openPort(name string) *ast.VarDecl {
+	return &ast.VarDecl{
+		Name: name,
+		Type: &ast.TypeIdentifier{Name: "Port"},
+		Value: &ast.StructLiteral{
+			TypeName: "Port",
+			Fields:   []*ast.FieldInit{{Name: "id", Value: &ast.NumberLiteral{Value: 1}}},
+		},
+	}
+}
+
+// returnValue builds return <value>
+func returnValue(value int64) *ast.ReturnStmt {
+	return &ast.ReturnStmt{Value: &ast.NumberLiteral{Value: value}}
+}
+
+// dropEvents analyzes a dropProgram and lists the drops and returns in
+// use(), in instruction order, as "drop <local>" and "return"
+func dropEvents(t *testing.T, body ...ast.Statement) []string {
+	t.Helper()
+	module, err := NewAnalyzer().Analyze(dropProgram(body...))
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	for _, fn := range module.Functions {
+		if !strings.Contains(fn.Name, ".use") {
+			continue
+		}
+		loads := make(map[ir.Register]string)
+		var events []string
+		for _, inst := range fn.Instructions {
+			switch {
+			case inst.Op == ir.OpLoadVar:
+				loads[inst.Dest] = inst.Symbol
+			case inst.Op == ir.OpCall && strings.Contains(inst.Symbol, "Port.drop"):
+				if len(inst.Args) != 1 {
+					t.Fatalf("drop call with %d arguments, want self only", len(inst.Args))
+				}
+				events = append(events, "drop "+loads[inst.Args[0]])
+			case inst.Op == ir.OpReturn:
+				events = append(events, "return")
+			}
+		}
+		return events
+	}
+	t.Fatal("use not generated")
+	return nil
+}
+
+func TestDropOnScopeExit(t *testing.T) {
+	got := dropEvents(t,
+		openPort("a"),
+		&ast.BlockStmt{Statements: []ast.Statement{openPort("inner")}},
+		&ast.ExpressionStmt{Expression: &ast.NumberLiteral{Value: 0}},
+	)
+	want := []string{"drop inner", "drop a", "return"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestDropOnEarlyReturn(t *testing.T) {
+	got := dropEvents(t,
+		openPort("a"),
+		&ast.IfStmt{
+			Condition: &ast.Identifier{Name: "flag"},
+			Then:      &ast.BlockStmt{Statements: []ast.Statement{openPort("b"), returnValue(0)}},
+		},
+		returnValue(1),
+	)
+	want := []string{"drop b", "drop a", "return", "drop a", "return"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestDropReverseDeclarationOrder(t *testing.T) {
+	got := dropEvents(t, openPort("a"), openPort("b"), openPort("c"), returnValue(0))
+	want := []string{"drop c", "drop b", "drop a", "return"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestDropSkipsMovedLocal(t *testing.T) {
+	got := dropEvents(t,
+		openPort("a"),
+		&ast.VarDecl{Name: "b", Type: &ast.TypeIdentifier{Name: "Port"}, Value: &ast.Identifier{Name: "a"}},
+		returnValue(0),
+	)
+	want := []string{"drop b", "return"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,148 @@
+package semantic
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// Every enum has a generated name() method returning its variant name:
+//
+//	enum Color { Red, Green, Blue }
+//	let c: Color = Color.Green;
+//	print_string(c.name());   // "Green"
+//
+// The first name() call on an enum emits the variant names as strings and a
+// constant table of their addresses indexed by discriminant. The table ends
+// with an "unknown" entry that out-of-range discriminants are clamped to, so
+// name() never reads past it. A method called name in an impl block for the
+// enum takes precedence.
+
+// enumNameMethod is the generated variant-name method
+const enumNameMethod = "name"
+
+// unknownEnumName is returned for a discriminant with no variant
+const unknownEnumName = "unknown"
+
+// enumReceiverType returns the enum type of a method receiver, or nil
+func (a *Analyzer) enumReceiverType(expr ast.Expression) *ir.EnumType {
+	t, err := a.inferType(expr)
+	if err != nil {
+		return nil
+	}
+	enumType, _ := t.(*ir.EnumType)
+	return enumType
+}
+
+// enumVariantNames lists an enum's variants in discriminant order
+func enumVariantNames(enumType *ir.EnumType) []string {
+	names := make([]string, 0, len(enumType.Variants))
+	for name := range enumType.Variants {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return enumType.Variants[names[i]] < enumType.Variants[names[j]]
+	})
+	return names
+}
+
+// enumNameLimit is one past the largest discriminant: the index of the
+// name table's "unknown" entry
+func enumNameLimit(enumType *ir.EnumType) int {
+	limit := 0
+	for _, value := range enumType.Variants {
+		limit = max(limit, value+1)
+	}
+	return limit
+}
+
+// enumNameTable returns the name table for an enum, emitting it and the
+// variant strings on first use
+func (a *Analyzer) enumNameTable(enumType *ir.EnumType) *ir.Global {
+	tableName := a.prefixSymbol(enumType.Name + "_names")
+	for i := range a.module.Globals {
+		if a.module.Globals[i].Name == tableName {
+			return &a.module.Globals[i]
+		}
+	}
+
+	label := func(name string) string {
+		l := fmt.Sprintf("str_%d", len(a.module.Strings))
+		a.module.Strings = append(a.module.Strings, &ir.String{Label: l, Value: name})
+		return l
+	}
+	labels := make([]string, enumNameLimit(enumType)+1)
+	for _, name := range enumVariantNames(enumType) {
+		labels[enumType.Variants[name]] = label(name)
+	}
+	// Discriminants skipped by explicit values share the "unknown" entry
+	unknown := label(unknownEnumName)
+	for i := range labels {
+		if labels[i] == "" {
+			labels[i] = unknown
+		}
+	}
+
+	a.module.Globals = append(a.module.Globals, ir.Global{
+		Name: tableName,
+		Type: &ir.ArrayType{
+			Element: &ir.PointerType{Base: &ir.BasicType{Kind: ir.TypeU8}},
+			Length:  len(labels),
+		},
+		Init:     labels,
+		Constant: true,
+	})
+	return &a.module.Globals[len(a.module.Globals)-1]
+}
+
+// analyzeEnumNameCall lowers receiver.name() to a clamped load from the
+// enum's name table
+func (a *Analyzer) analyzeEnumNameCall(call *ast.CallExpr, receiver ast.Expression, enumType *ir.EnumType, irFunc *ir.Function) (ir.Register, error) {
+	if len(call.Arguments) != 0 {
+		return 0, a.errorAt(call, "%s.%s() takes no arguments", enumType.Name, enumNameMethod)
+	}
+
+	valueReg, err := a.analyzeExpression(receiver, irFunc)
+	if err != nil {
+		return 0, err
+	}
+	table := a.enumNameTable(enumType)
+
+	// index = value < limit ? value : limit (the "unknown" entry)
+	unknownIndex := int64(enumNameLimit(enumType))
+	backing := enumType.BackingType()
+	limitReg := irFunc.AllocReg()
+	inRangeReg := irFunc.AllocReg()
+	irFunc.Instructions = append(irFunc.Instructions,
+		ir.Instruction{Op: ir.OpLoadConst, Dest: limitReg, Imm: unknownIndex, Type: backing},
+		ir.Instruction{Op: ir.OpLt, Dest: inRangeReg, Src1: valueReg, Src2: limitReg, Type: backing},
+	)
+
+	indexReg := irFunc.AllocReg()
+	lookupLabel := a.generateLabel("enum_name")
+	irFunc.Instructions = append(irFunc.Instructions,
+		ir.Instruction{Op: ir.OpMove, Dest: indexReg, Src1: limitReg, Comment: "Unknown discriminant"},
+		ir.Instruction{Op: ir.OpJumpIfNot, Src1: inRangeReg, Label: lookupLabel},
+		ir.Instruction{Op: ir.OpMove, Dest: indexReg, Src1: valueReg},
+	)
+	irFunc.EmitLabel(lookupLabel)
+
+	tableReg := irFunc.AllocReg()
+	resultReg := irFunc.AllocReg()
+	irFunc.Instructions = append(irFunc.Instructions,
+		ir.Instruction{Op: ir.OpLoadAddr, Dest: tableReg, Symbol: table.Name, Type: table.Type},
+		ir.Instruction{
+			Op:      ir.OpLoadIndex,
+			Dest:    resultReg,
+			Src1:    tableReg,
+			Src2:    indexReg,
+			Type:    table.Type.(*ir.ArrayType).Element,
+			Comment: fmt.Sprintf("%s variant name", enumType.Name),
+		},
+	)
+
+	a.exprTypes[call] = &ir.StringType{MaxLength: 255}
+	return resultReg, nil
+}
@@ -0,0 +1,259 @@
+package semantic
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// enumProgram builds:
+//
+//	enum Color { Red, Green, Blue }
+//	interface Describe { code(self: Color) -> u8; }
+//	impl Describe for Color {
+//	    fun code(self: Color) -> u8 { return 7; }
+//	}
+//	fun main() -> ret {
+//	    let c: Color = Color.Blue;
+//	    return c.<method>();
+//	}
+func enumProgram(method string, ret ast.Type) *ast.File {
+	color := &ast.TypeIdentifier{Name: "Color"}
+	u8 := &ast.PrimitiveType{Name: "u8"}
+	self := []*ast.Parameter{{Name: "self", Type: color, IsSelf: true}}
+
+	return &ast.File{
+		Name: "colors.minz",
+		Declarations: []ast.Declaration{
+			&ast.EnumDecl{Name: "Color", Variants: []string{"Red", "Green", "Blue"}},
+			&ast.InterfaceDecl{
+				Name:    "Describe",
+				Methods: []*ast.InterfaceMethod{{Name: "code", Params: self, ReturnType: u8}},
+			},
+			&ast.ImplBlock{
+				InterfaceName: "Describe",
+				ForType:       color,
+				Methods: []*ast.FunctionDecl{{
+					Name:       "code",
+					Params:     self,
+					ReturnType: u8,
+					Body: &ast.BlockStmt{Statements: []ast.Statement{
+						&ast.ReturnStmt{Value: &ast.NumberLiteral{Value: 7}},
+					}},
+				}},
+			},
+			&ast.FunctionDecl{
+				Name:       "main",
+				ReturnType: ret,
+				Body: &ast.BlockStmt{Statements: []ast.Statement{
+					&ast.VarDecl{
+						Name:  "c",
+						Type:  color,
+						Value: &ast.FieldExpr{Object: &ast.Identifier{Name: "Color"}, Field: "Blue"},
+					},
+					&ast.ReturnStmt{Value: &ast.CallExpr{
+						Function:  &ast.FieldExpr{Object: &ast.Identifier{Name: "c"}, Field: method},
+						Arguments: []ast.Expression{},
+					}},
+				}},
+			},
+		},
+	}
+}
+
+// enumMain analyzes an enumProgram and returns the module and its main
+func enumMain(t *testing.T, method string, ret ast.Type) (*ir.Module, *ir.Function) {
+	t.Helper()
+	module, err := NewAnalyzer().Analyze(enumProgram(method, ret))
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	for _, fn := range module.Functions {
+		if strings.HasSuffix(fn.Name, "main") {
+			return module, fn
+		}
+	}
+	t.Fatal("main not generated")
+	return nil, nil
+}
+
+func TestEnumNameTable(t *testing.T) {
+	module, _ := enumMain(t, "name", &ast.PointerType{BaseType: &ast.PrimitiveType{Name: "u8"}})
+
+	var table *ir.Global
+	for i := range module.Globals {
+		if strings.HasSuffix(module.Globals[i].Name, "Color_names") {
+			table = &module.Globals[i]
+		}
+	}
+	if table == nil {
+		t.Fatal("Color name table not emitted")
+	}
+	if !table.Constant {
+		t.Error("name table should be constant")
+	}
+
+	strs := make(map[string]string)
+	for _, s := range module.Strings {
+		strs[s.Label] = s.Value
+	}
+	labels, ok := table.Init.([]string)
+	if !ok {
+		t.Fatalf("table entries = %#v, want string labels", table.Init)
+	}
+	want := []string{"Red", "Green", "Blue", "unknown"}
+	if len(labels) != len(want) {
+		t.Fatalf("table has %d entries, want %d", len(labels), len(want))
+	}
+	for i, name := range want {
+		if got := strs[labels[i]]; got != name {
+			t.Errorf("entry %d = %q, want %q", i, got, name)
+		}
+	}
+}
+
+func TestEnumNameUnknownFallback(t *testing.T) {
+	_, main := enumMain(t, "name", &ast.PointerType{BaseType: &ast.PrimitiveType{Name: "u8"}})
+
+	// The discriminant is compared with the variant count, and the index
+	// defaults to that count, which is the "unknown" entry
+	limits := make(map[ir.Register]int64)
+	var fallback, load *ir.Instruction
+	for i, inst := range main.Instructions {
+		switch inst.Op {
+		case ir.OpLoadConst:
+			limits[inst.Dest] = inst.Imm
+		case ir.OpLt:
+			if limits[inst.Src2] != 3 {
+				t.Errorf("discriminant compared with %d, want 3", limits[inst.Src2])
+			}
+		case ir.OpMove:
+			if fallback == nil {
+				fallback = &main.Instructions[i]
+			}
+		case ir.OpLoadIndex:
+			load = &main.Instructions[i]
+		}
+	}
+	if fallback == nil || limits[fallback.Src1] != 3 {
+		t.Fatalf("no fallback to the unknown entry in main: %v", main.Instructions)
+	}
+	if load == nil || load.Src2 != fallback.Dest {
+		t.Errorf("name not loaded through the clamped index: %v", main.Instructions)
+	}
+}
+
+func TestEnumImplMethod(t *testing.T) {
+	_, main := enumMain(t, "code", &ast.PrimitiveType{Name: "u8"})
+
+	for _, inst := range main.Instructions {
+		if inst.Op == ir.OpCall && strings.Contains(inst.Symbol, "Color.code") {
+			return
+		}
+	}
+	t.Errorf("no call to Color.code in main: %v", main.Instructions)
+}
+
+// reprProgram builds:
+//
+//	@repr(<repr>) enum Color { <variants> }
+//	let colors: [Color; 8];
+//	fun pick(i: u8) -> Color { return colors[i]; }
+func reprProgram(repr string, variants []string) *ast.File {
+	enum := &ast.EnumDecl{Name: "Color", Variants: variants}
+	if repr != "" {
+		enum.Attributes = []*ast.Attribute{{Name: "repr", Arguments: []ast.Expression{&ast.Identifier{Name: repr}}}}
+	}
+	color := &ast.TypeIdentifier{Name: "Color"}
+	return &ast.File{
+		Name: "colors.minz",
+		Declarations: []ast.Declaration{
+			enum,
+			&ast.VarDecl{
+				Name:      "colors",
+				Type:      &ast.ArrayType{ElementType: color, Size: &ast.NumberLiteral{Value: 8}},
+				IsMutable: true,
+			},
+			&ast.FunctionDecl{
+				Name:       "pick",
+				Params:     []*ast.Parameter{{Name: "i", Type: &ast.PrimitiveType{Name: "u8"}}},
+				ReturnType: color,
+				Body: &ast.BlockStmt{Statements: []ast.Statement{
+					&ast.ReturnStmt{Value: &ast.IndexExpr{Array: &ast.Identifier{Name: "colors"}, Index: &ast.Identifier{Name: "i"}}},
+				}},
+			},
+		},
+	}
+}
+
+func TestEnumReprStorage(t *testing.T) {
+	rgb := []string{"Red", "Green", "Blue"}
+	for _, tt := range []struct {
+		repr     string
+		elemSize int
+	}{
+		{"", 1},
+		{"u8", 1},
+		{"u16", 2},
+	} {
+		t.Run("repr "+tt.repr, func(t *testing.T) {
+			module, err := NewAnalyzer().Analyze(reprProgram(tt.repr, rgb))
+			if err != nil {
+				t.Fatalf("analysis failed: %v", err)
+			}
+
+			var colors *ir.Global
+			for i := range module.Globals {
+				if strings.HasSuffix(module.Globals[i].Name, "colors") {
+					colors = &module.Globals[i]
+				}
+			}
+			if colors == nil {
+				t.Fatal("colors not emitted")
+			}
+			if got := colors.Type.Size(); got != 8*tt.elemSize {
+				t.Errorf("[Color; 8] is %d bytes, want %d", got, 8*tt.elemSize)
+			}
+
+			loads := 0
+			for _, fn := range module.Functions {
+				if !strings.Contains(fn.Name, ".pick") {
+					continue
+				}
+				for _, inst := range fn.Instructions {
+					if inst.Op != ir.OpLoadIndex {
+						continue
+					}
+					loads++
+					if inst.Type.Size() != tt.elemSize {
+						t.Errorf("indexed load of %s is %d bytes wide, want %d", inst.Type, inst.Type.Size(), tt.elemSize)
+					}
+				}
+			}
+			if loads != 1 {
+				t.Errorf("found %d indexed loads in pick, want 1", loads)
+			}
+		})
+	}
+
+	many := make([]string, 300)
+	for i := range many {
+		many[i] = fmt.Sprintf("V%d", i)
+	}
+	for _, tt := range []struct {
+		repr     string
+		variants []string
+		want     string
+	}{
+		{"u8", many, "300 variants, too many for @repr(u8)"},
+		{"i32", rgb, "@repr type must be u8 or u16, got i32"},
+	} {
+		_, err := NewAnalyzer().Analyze(reprProgram(tt.repr, tt.variants))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("@repr(%s): error = %v, want %q", tt.repr, err, tt.want)
+		}
+	}
+}
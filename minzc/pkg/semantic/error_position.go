@@ -1,8 +1,13 @@
 package semantic
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
+	"strings"
+
 	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/diagnostics"
 )
 
 // ErrorWithPosition represents an error with source position information
@@ -11,6 +16,7 @@ type ErrorWithPosition struct {
 	Position ast.Position
 	File     string
 	Context  string // Optional: line of code for context
+	Err      error  // Optional: the error this one gives a position to
 }
 
 func (e ErrorWithPosition) Error() string {
@@ -22,13 +28,97 @@ func (e ErrorWithPosition) Error() string {
 	return e.Message
 }
 
+// Unwrap returns the error this one gives a position to, if any
+func (e ErrorWithPosition) Unwrap() error {
+	return e.Err
+}
+
+// Diagnostic describes the error for --error-format json
+func (e ErrorWithPosition) Diagnostic() diagnostics.Diagnostic {
+	code, hint := classifyError(e.Message)
+	return diagnostics.Diagnostic{
+		File:     e.File,
+		Line:     e.Position.Line,
+		Column:   e.Position.Column,
+		Severity: diagnostics.SeverityError,
+		Code:     code,
+		Message:  e.Message,
+		Hint:     hint,
+	}
+}
+
+// suggestionPattern finds the "did you mean" suggestion of undefined names
+var suggestionPattern = regexp.MustCompile(`did you mean '([^']+)'\?`)
+
+// classifyError picks the diagnostic code of a message and a hint on how
+// to fix it
+func classifyError(message string) (code, hint string) {
+	lower := strings.ToLower(message)
+	switch {
+	case strings.Contains(lower, "undefined") || strings.Contains(lower, "unknown type"):
+		if m := suggestionPattern.FindStringSubmatch(message); m != nil {
+			return diagnostics.CodeUndefined, fmt.Sprintf("replace it with '%s'", m[1])
+		}
+		return diagnostics.CodeUndefined, "declare it before use, or import the module that defines it"
+	case strings.Contains(lower, "type mismatch"):
+		return diagnostics.CodeTypeMismatch, "convert the value with 'as <type>'"
+	case strings.Contains(lower, "cannot infer") || strings.Contains(lower, "cannot determine"):
+		return diagnostics.CodeTypeInference, "add a type annotation, e.g. 'let x: u8 = ...'"
+	case strings.Contains(lower, "arguments"):
+		return diagnostics.CodeArgumentCount, ""
+	case strings.Contains(lower, "immutable"):
+		return diagnostics.CodeImmutable, "declare the variable with 'let mut'"
+	case strings.Contains(lower, "unsupported") || strings.Contains(lower, "not supported"):
+		return diagnostics.CodeUnsupported, ""
+	}
+	return diagnostics.CodeSemantic, ""
+}
+
+// positioned gives err the position of node, unless it already has one
+func (a *Analyzer) positioned(node ast.Node, err error) error {
+	var located ErrorWithPosition
+	if err == nil || node == nil || node.Pos().Line == 0 || errors.As(err, &located) && located.Position.Line > 0 {
+		return err
+	}
+	return ErrorWithPosition{
+		Message:  err.Error(),
+		Position: node.Pos(),
+		File:     a.sourcePath,
+		Err:      err,
+	}
+}
+
+// report records an error found while analyzing node
+func (a *Analyzer) report(node ast.Node, err error) {
+	a.errors = append(a.errors, a.positioned(node, err))
+}
+
+// AnalysisError is returned by Analyze with every error it found
+type AnalysisError struct {
+	Errors []error
+}
+
+func (e *AnalysisError) Error() string {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "semantic analysis failed with %d errors:", len(e.Errors))
+	for i, err := range e.Errors {
+		fmt.Fprintf(&msg, "\n  %d. %v", i+1, err)
+	}
+	return msg.String()
+}
+
+// Unwrap returns the errors, so errors.As and errors.Is look at each
+func (e *AnalysisError) Unwrap() []error {
+	return e.Errors
+}
+
 // Helper function to create positioned errors
 func (a *Analyzer) errorAt(node ast.Node, format string, args ...interface{}) error {
 	msg := fmt.Sprintf(format, args...)
 	return ErrorWithPosition{
 		Message:  msg,
 		Position: node.Pos(),
-		File:     a.currentFile,
+		File:     a.sourcePath,
 	}
 }
 
@@ -48,7 +138,7 @@ func (a *Analyzer) undefinedIdentifierError(id *ast.Identifier, context string)
 	return ErrorWithPosition{
 		Message:  msg,
 		Position: id.Pos(),
-		File:     a.currentFile,
+		File:     a.sourcePath,
 	}
 }
 
@@ -65,7 +155,7 @@ func (a *Analyzer) undefinedFunctionError(call *ast.CallExpr, funcName string) e
 	return ErrorWithPosition{
 		Message:  msg,
 		Position: call.Pos(),
-		File:     a.currentFile,
+		File:     a.sourcePath,
 	}
 }
 
@@ -75,6 +165,6 @@ func (a *Analyzer) typeMismatchError(node ast.Node, expected, got string) error
 	return ErrorWithPosition{
 		Message:  msg,
 		Position: node.Pos(),
-		File:     a.currentFile,
+		File:     a.sourcePath,
 	}
 }
\ No newline at end of file
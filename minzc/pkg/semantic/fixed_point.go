@@ -0,0 +1,178 @@
+package semantic
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// Fixed-point types (f.8, f.16, f8.8, f16.8, f8.16) store value * 2^frac as
+// an unsigned integer of the type's size: f8.8 holds 0 to 255.996 in steps
+// of 1/256. The same rules as for unsigned integers apply:
+//
+//   - Literals are rounded to the nearest step; a literal outside the range
+//     of its type is a compile error.
+//   - Addition and subtraction wrap around on overflow.
+//   - Multiplication forms the full product and shifts it right by the
+//     fractional bits, so the fraction truncates toward zero and an integer
+//     part that does not fit wraps around.
+//   - Multiplying by an integer expression scales without a rescale, and
+//     shifting left or right scales by a power of two.
+//   - Division is not supported.
+//
+// A fractional literal without a fixed-point context, e.g. `let x = 3.5`,
+// is f8.8.
+
+// defaultFixedType is the type of a fractional literal with no context
+var defaultFixedType = &ir.BasicType{Kind: ir.TypeF8_8}
+
+// fixedLiteralValue encodes a number literal in fixed-point type t
+func fixedLiteralValue(num *ast.NumberLiteral, t ir.Type) (int64, error) {
+	value := float64(num.Value)
+	if num.IsFloat {
+		value = num.Float
+	}
+
+	raw := math.Round(value * float64(int64(1)<<ir.FixedFracBits(t)))
+	if raw < 0 || raw >= float64(int64(1)<<(8*t.Size())) {
+		return 0, fmt.Errorf("literal %g out of range for %s", value, t)
+	}
+	return int64(raw), nil
+}
+
+// analyzeExpressionAs analyzes expr where a value of type want is expected.
+// Number literals in a fixed-point context are encoded in that format;
+// anything else is analyzed as usual.
+func (a *Analyzer) analyzeExpressionAs(expr ast.Expression, want ir.Type, irFunc *ir.Function) (ir.Register, error) {
+	num, ok := expr.(*ast.NumberLiteral)
+	if !ok || ir.FixedFracBits(want) == 0 {
+		return a.analyzeExpression(expr, irFunc)
+	}
+
+	raw, err := fixedLiteralValue(num, want)
+	if err != nil {
+		return 0, err
+	}
+	reg := irFunc.AllocReg()
+	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+		Op:      ir.OpLoadConst,
+		Dest:    reg,
+		Imm:     raw,
+		Type:    want,
+		Comment: fmt.Sprintf("%g as %s", literalValue(num), want),
+	})
+	a.exprTypes[num] = want
+	return reg, nil
+}
+
+// literalValue returns the value a number literal was written as
+func literalValue(num *ast.NumberLiteral) float64 {
+	if num.IsFloat {
+		return num.Float
+	}
+	return float64(num.Value)
+}
+
+// analyzeBinaryOperands analyzes both operands of a binary expression.
+// A number literal next to a fixed-point operand takes that operand's type,
+// except for a shift count, which stays an integer.
+func (a *Analyzer) analyzeBinaryOperands(bin *ast.BinaryExpr, irFunc *ir.Function) (ir.Register, ir.Register, error) {
+	if bin.Operator == "<<" || bin.Operator == ">>" {
+		leftReg, err := a.analyzeExpression(bin.Left, irFunc)
+		if err != nil {
+			return 0, 0, err
+		}
+		rightReg, err := a.analyzeExpression(bin.Right, irFunc)
+		return leftReg, rightReg, err
+	}
+
+	_, leftLit := bin.Left.(*ast.NumberLiteral)
+	_, rightLit := bin.Right.(*ast.NumberLiteral)
+
+	if leftLit && !rightLit {
+		// The literal has no side effects, so the right side can go first
+		rightReg, err := a.analyzeExpression(bin.Right, irFunc)
+		if err != nil {
+			return 0, 0, err
+		}
+		leftReg, err := a.analyzeExpressionAs(bin.Left, a.exprTypes[bin.Right], irFunc)
+		return leftReg, rightReg, err
+	}
+
+	leftReg, err := a.analyzeExpression(bin.Left, irFunc)
+	if err != nil {
+		return 0, 0, err
+	}
+	rightReg, err := a.analyzeExpressionAs(bin.Right, a.exprTypes[bin.Left], irFunc)
+	return leftReg, rightReg, err
+}
+
+// fixedBinaryTypes applies the fixed-point rules to a binary operation.
+// It returns the type for the IR instruction and the type of the result;
+// ok is false when neither operand is fixed-point. Only a fixed * fixed
+// multiply is emitted with the fixed-point type, telling the backend to
+// rescale; everything else operates on the raw integer.
+func fixedBinaryTypes(operator string, left, right ir.Type) (inst ir.Type, result ir.Type, ok bool, err error) {
+	leftFixed, rightFixed := ir.FixedFracBits(left) > 0, ir.FixedFracBits(right) > 0
+	if !leftFixed && !rightFixed {
+		return nil, nil, false, nil
+	}
+
+	fixed, other := left, right
+	if !leftFixed {
+		fixed, other = right, left
+	}
+
+	switch operator {
+	case "/", "%":
+		return nil, nil, true, fmt.Errorf("operator %s is not supported for fixed-point type %s", operator, fixed)
+	case "*":
+		if leftFixed && rightFixed {
+			if left.String() != right.String() {
+				return nil, nil, true, fmt.Errorf("cannot multiply %s by %s", left, right)
+			}
+			// Fixed-point multiply: codegen rescales the product
+			return fixed, fixed, true, nil
+		}
+		// Scaling by an integer is a plain integer multiply of the raw value
+		return rawIntegerType(fixed), fixed, true, nil
+	case "<<", ">>":
+		if leftFixed && !rightFixed {
+			// Shifting the raw value scales by a power of two
+			return rawIntegerType(fixed), fixed, true, nil
+		}
+	}
+
+	if other != nil && other.String() != fixed.String() {
+		return nil, nil, true, fmt.Errorf("operator %s requires both operands to be %s, got %s and %s",
+			operator, fixed, typeName(left), typeName(right))
+	}
+	switch operator {
+	case "==", "!=", "<", ">", "<=", ">=":
+		// Raw values compare like unsigned integers
+		return rawIntegerType(fixed), &ir.BasicType{Kind: ir.TypeBool}, true, nil
+	}
+	return rawIntegerType(fixed), fixed, true, nil
+}
+
+// rawIntegerType returns the unsigned integer type with the same size as t
+func rawIntegerType(t ir.Type) ir.Type {
+	switch t.Size() {
+	case 1:
+		return &ir.BasicType{Kind: ir.TypeU8}
+	case 3:
+		return &ir.BasicType{Kind: ir.TypeU24}
+	default:
+		return &ir.BasicType{Kind: ir.TypeU16}
+	}
+}
+
+// typeName names a possibly unknown type in diagnostics
+func typeName(t ir.Type) string {
+	if t == nil {
+		return "unknown"
+	}
+	return t.String()
+}
@@ -0,0 +1,113 @@
+package semantic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// fixedProgram builds:
+//
+//	fun main() -> void {
+//	    let x: f8.8 = 3.5;
+//	    let y: f8.8 = x <op> 2.0;
+//	    @print(y);
+//	}
+func fixedProgram(op string) *ast.File {
+	f88 := &ast.PrimitiveType{Name: "f8.8"}
+	return &ast.File{
+		Name: "fixed.minz",
+		Declarations: []ast.Declaration{
+			&ast.FunctionDecl{
+				Name:       "main",
+				ReturnType: &ast.PrimitiveType{Name: "void"},
+				Body: &ast.BlockStmt{Statements: []ast.Statement{
+					&ast.VarDecl{Name: "x", Type: f88, Value: &ast.NumberLiteral{Value: 3, IsFloat: true, Float: 3.5}},
+					&ast.VarDecl{Name: "y", Type: f88, Value: &ast.BinaryExpr{
+						Left:     &ast.Identifier{Name: "x"},
+						Operator: op,
+						Right:    &ast.NumberLiteral{Value: 2, IsFloat: true, Float: 2.0},
+					}},
+					&ast.ExpressionStmt{Expression: &ast.CompileTimePrint{Expr: &ast.Identifier{Name: "y"}}},
+				}},
+			},
+		},
+	}
+}
+
+func analyzeFixedMain(t *testing.T, op string) *ir.Function {
+	t.Helper()
+	module, err := NewAnalyzer().Analyze(fixedProgram(op))
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	for _, fn := range module.Functions {
+		if strings.HasSuffix(fn.Name, ".main") || fn.Name == "main" {
+			return fn
+		}
+	}
+	t.Fatal("main not generated")
+	return nil
+}
+
+func TestFixedPointLiteral(t *testing.T) {
+	main := analyzeFixedMain(t, "+")
+	var consts []int64
+	for _, inst := range main.Instructions {
+		if inst.Op == ir.OpLoadConst {
+			if ir.FixedFracBits(inst.Type) != 8 {
+				t.Errorf("literal loaded as %v, want f8.8", inst.Type)
+			}
+			consts = append(consts, inst.Imm)
+		}
+	}
+	// 3.5 * 256 and 2.0 * 256
+	if len(consts) != 2 || consts[0] != 896 || consts[1] != 512 {
+		t.Errorf("literal encodings = %v, want [896 512]", consts)
+	}
+
+	num := &ast.NumberLiteral{Value: 256, IsFloat: true, Float: 256.0}
+	if _, err := fixedLiteralValue(num, &ir.BasicType{Kind: ir.TypeF8_8}); err == nil {
+		t.Error("256.0 accepted as f8.8")
+	}
+}
+
+func TestFixedPointArithmetic(t *testing.T) {
+	tests := []struct {
+		op      string
+		want    ir.Opcode
+		rescale bool
+	}{
+		{"+", ir.OpAdd, false},
+		{"-", ir.OpSub, false},
+		{"*", ir.OpMul, true},
+	}
+	for _, tt := range tests {
+		main := analyzeFixedMain(t, tt.op)
+		found, printed := false, false
+		for _, inst := range main.Instructions {
+			switch inst.Op {
+			case tt.want:
+				found = true
+				// Only a fixed * fixed multiply carries the fixed type to codegen
+				if rescale := ir.FixedFracBits(inst.Type) > 0; rescale != tt.rescale {
+					t.Errorf("%s emitted with type %v, rescale = %v, want %v", tt.op, inst.Type, rescale, tt.rescale)
+				}
+			case ir.OpPrintFixed:
+				printed = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: no %v emitted", tt.op, tt.want)
+		}
+		if !printed {
+			t.Errorf("%s: f8.8 result not printed with print_fixed", tt.op)
+		}
+	}
+
+	if _, err := NewAnalyzer().Analyze(fixedProgram("/")); err == nil {
+		t.Error("fixed-point division accepted")
+	}
+}
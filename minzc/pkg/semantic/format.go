@@ -0,0 +1,204 @@
+package semantic
+
+import (
+	"fmt"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// format(buf, "...", args...) writes formatted text into a caller-provided
+// buffer and returns the number of bytes written, without a heap:
+//
+//	let buf: [u8; 16];
+//	let n = format(&buf, "x=%d ($%x)", x, x);
+//
+// The format string must be a literal and is split at compile time.
+// Specifiers:
+//
+//	%d  decimal u8/u16/i8/i16, no leading zeros
+//	%x  hex u8 (2 digits) or u16 (4 digits)
+//	%c  a u8 character
+//	%s  a String, LString or *u8 (length-prefixed)
+//	%%  a literal %
+//
+// No terminator or length prefix is written; the buffer must be large
+// enough for the result.
+
+// formatBuiltin is the name of the builtin
+const formatBuiltin = "format"
+
+// formatPiece is literal text or one specifier of a format string
+type formatPiece struct {
+	text string // Literal text when verb is 0
+	verb rune
+}
+
+// isFormatCall reports whether call is the format builtin rather than a
+// user function of the same name
+func (a *Analyzer) isFormatCall(call *ast.CallExpr) bool {
+	id, ok := call.Function.(*ast.Identifier)
+	return ok && id.Name == formatBuiltin && a.currentScope.Lookup(formatBuiltin) == nil &&
+		a.currentScope.Lookup(a.prefixSymbol(formatBuiltin)) == nil
+}
+
+// parseFormat splits a format string into literal text and specifiers
+func parseFormat(format string) ([]formatPiece, error) {
+	var pieces []formatPiece
+	text := []byte{}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			text = append(text, format[i])
+			continue
+		}
+		if i+1 == len(format) {
+			return nil, fmt.Errorf("format string ends with a lone %%")
+		}
+		i++
+		switch verb := format[i]; verb {
+		case '%':
+			text = append(text, '%')
+		case 'd', 'x', 'c', 's':
+			if len(text) > 0 {
+				pieces = append(pieces, formatPiece{text: string(text)})
+				text = []byte{}
+			}
+			pieces = append(pieces, formatPiece{verb: rune(verb)})
+		default:
+			return nil, fmt.Errorf("unknown format specifier %%%c", verb)
+		}
+	}
+	if len(text) > 0 {
+		pieces = append(pieces, formatPiece{text: string(text)})
+	}
+	return pieces, nil
+}
+
+// checkFormatArg reports whether a value of type t can be formatted with verb
+func checkFormatArg(verb rune, t ir.Type) error {
+	kind := ir.TypeVoid
+	if basic, ok := t.(*ir.BasicType); ok {
+		kind = basic.Kind
+	}
+	switch verb {
+	case 'd', 'x':
+		switch kind {
+		case ir.TypeU8, ir.TypeU16, ir.TypeI8, ir.TypeI16:
+			return nil
+		}
+	case 'c':
+		if kind == ir.TypeU8 {
+			return nil
+		}
+	case 's':
+		switch s := t.(type) {
+		case *ir.StringType, *ir.LStringType:
+			return nil
+		case *ir.PointerType:
+			if base, ok := s.Base.(*ir.BasicType); ok && base.Kind == ir.TypeU8 {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%%%c cannot format a value of type %s", verb, t)
+}
+
+// isByteBuffer reports whether t can be the buffer argument of format
+func isByteBuffer(t ir.Type) bool {
+	if ptr, ok := t.(*ir.PointerType); ok {
+		t = ptr.Base
+	}
+	if arr, ok := t.(*ir.ArrayType); ok {
+		t = arr.Element
+	}
+	basic, ok := t.(*ir.BasicType)
+	return ok && basic.Kind == ir.TypeU8
+}
+
+// analyzeFormatCall lowers format(buf, "...", args...) to one OpFormat per
+// piece of the format string, threading the buffer cursor through them, and
+// returns the number of bytes written
+func (a *Analyzer) analyzeFormatCall(call *ast.CallExpr, irFunc *ir.Function) (ir.Register, error) {
+	if len(call.Arguments) < 2 {
+		return 0, a.errorAt(call, "format expects a buffer and a format string")
+	}
+	lit, ok := call.Arguments[1].(*ast.StringLiteral)
+	if !ok {
+		return 0, a.errorAt(call.Arguments[1], "format string must be a string literal")
+	}
+	pieces, err := parseFormat(lit.Value)
+	if err != nil {
+		return 0, a.errorAt(lit, "%v", err)
+	}
+
+	verbs := 0
+	for _, piece := range pieces {
+		if piece.verb != 0 {
+			verbs++
+		}
+	}
+	values := call.Arguments[2:]
+	if len(values) != verbs {
+		return 0, a.errorAt(call, "format string %q has %d specifiers, got %d arguments", lit.Value, verbs, len(values))
+	}
+
+	bufType, err := a.inferType(call.Arguments[0])
+	if err != nil {
+		return 0, err
+	}
+	if !isByteBuffer(bufType) {
+		return 0, a.errorAt(call.Arguments[0], "format buffer must be a u8 pointer or array, got %s", bufType)
+	}
+	buf, err := a.analyzeExpression(call.Arguments[0], irFunc)
+	if err != nil {
+		return 0, err
+	}
+
+	// Arguments are evaluated left to right before anything is written
+	valueRegs := make([]ir.Register, len(values))
+	valueTypes := make([]ir.Type, len(values))
+	for i, value := range values {
+		if valueRegs[i], err = a.analyzeExpression(value, irFunc); err != nil {
+			return 0, err
+		}
+		if valueTypes[i], err = a.inferType(value); err != nil {
+			return 0, err
+		}
+	}
+
+	cursor := buf
+	next := 0
+	for _, piece := range pieces {
+		inst := ir.Instruction{
+			Op:   ir.OpFormat,
+			Dest: irFunc.AllocReg(),
+			Src1: cursor,
+			Imm:  int64(piece.verb),
+		}
+		if piece.verb == 0 {
+			inst.Symbol = piece.text
+		} else {
+			if err := checkFormatArg(piece.verb, valueTypes[next]); err != nil {
+				return 0, a.errorAt(values[next], "format argument %d: %v", next+1, err)
+			}
+			inst.Src2 = valueRegs[next]
+			inst.Type = valueTypes[next]
+			next++
+		}
+		irFunc.Instructions = append(irFunc.Instructions, inst)
+		cursor = inst.Dest
+	}
+
+	u16 := &ir.BasicType{Kind: ir.TypeU16}
+	length := irFunc.AllocReg()
+	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+		Op:      ir.OpSub,
+		Dest:    length,
+		Src1:    cursor,
+		Src2:    buf,
+		Type:    u16,
+		Comment: "format length",
+	})
+	a.exprTypes[call] = u16
+	return length, nil
+}
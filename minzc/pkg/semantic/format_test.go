@@ -0,0 +1,93 @@
+package semantic
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// analyzeFormat analyzes
+//
+//	fun show(buf: *u8, x: u16) -> u16 { return format(buf, <format>, <args>); }
+//
+// and returns show's instructions
+func analyzeFormat(format string, args ...ast.Expression) ([]ir.Instruction, error) {
+	u16 := &ast.PrimitiveType{Name: "u16"}
+	call := &ast.CallExpr{
+		Function:  &ast.Identifier{Name: "format"},
+		Arguments: append([]ast.Expression{&ast.Identifier{Name: "buf"}, &ast.StringLiteral{Value: format}}, args...),
+	}
+	file := &ast.File{
+		Name: "show.minz",
+		Declarations: []ast.Declaration{&ast.FunctionDecl{
+			Name: "show",
+			Params: []*ast.Parameter{
+				{Name: "buf", Type: &ast.PointerType{BaseType: &ast.PrimitiveType{Name: "u8"}, IsMutable: true}},
+				{Name: "x", Type: u16},
+			},
+			ReturnType: u16,
+			Body:       &ast.BlockStmt{Statements: []ast.Statement{&ast.ReturnStmt{Value: call}}},
+		}},
+	}
+
+	module, err := NewAnalyzer().Analyze(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range module.Functions {
+		if strings.Contains(fn.Name, ".show") {
+			return fn.Instructions, nil
+		}
+	}
+	return nil, fmt.Errorf("show not generated")
+}
+
+func TestFormatLowering(t *testing.T) {
+	x := &ast.Identifier{Name: "x"}
+	insts, err := analyzeFormat("x=%d, %x%%", x, x)
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	var pieces []string
+	for _, inst := range insts {
+		switch {
+		case inst.Op == ir.OpFormat && inst.Imm == 0:
+			pieces = append(pieces, inst.Symbol)
+		case inst.Op == ir.OpFormat:
+			pieces = append(pieces, "%"+string(rune(inst.Imm))+" "+inst.Type.String())
+		case inst.Op == ir.OpSub && inst.Comment == "format length":
+			pieces = append(pieces, "length")
+		}
+	}
+	want := []string{"x=", "%d u16", ", ", "%x u16", "%", "length"}
+	if !reflect.DeepEqual(pieces, want) {
+		t.Errorf("pieces = %q, want %q", pieces, want)
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	x := &ast.Identifier{Name: "x"}
+	tests := []struct {
+		format string
+		args   []ast.Expression
+		want   string
+	}{
+		{"%d %d", []ast.Expression{x}, "has 2 specifiers, got 1 arguments"},
+		{"%c", []ast.Expression{x}, "%c cannot format a value of type u16"},
+		{"%q", []ast.Expression{x}, "unknown format specifier %q"},
+		{"100%", nil, "ends with a lone %"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			_, err := analyzeFormat(tt.format, tt.args...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package semantic
+
+import (
+	"fmt"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// Function tables are constant arrays of function pointers used for
+// jump-table dispatch:
+//
+//	const handlers: [fn() -> void; 4] = [a, b, c, d];
+//	handlers[i]();
+//
+// Every entry must name a function with exactly the element signature; for
+// an overloaded name the matching overload is taken. The table is emitted as
+// a global DW list, and a call loads the indexed address and calls through it.
+// Tables hold at most 256 entries, so the index is taken as a byte.
+
+// maxFunctionTableEntries bounds a table to what a byte index can reach
+const maxFunctionTableEntries = 256
+
+// isFunctionTableDecl reports whether a constant declares a function table
+func isFunctionTableDecl(c *ast.ConstDecl) bool {
+	arr, ok := c.Type.(*ast.ArrayType)
+	if !ok {
+		return false
+	}
+	_, ok = arr.ElementType.(*ast.FunctionType)
+	return ok
+}
+
+// analyzeFunctionTable registers a function table as a read-only global
+func (a *Analyzer) analyzeFunctionTable(c *ast.ConstDecl) error {
+	t, err := a.convertType(c.Type)
+	if err != nil {
+		return fmt.Errorf("invalid type for constant %s: %w", c.Name, err)
+	}
+	tableType := t.(*ir.ArrayType)
+	fnType := tableType.Element.(*ir.FunctionType)
+
+	init, ok := c.Value.(*ast.ArrayInitializer)
+	if !ok {
+		return a.errorAt(c, "function table %s must be initialized with a list of functions", c.Name)
+	}
+	if tableType.Length > 0 && len(init.Elements) != tableType.Length {
+		return a.errorAt(c, "function table %s has %d entries, want %d", c.Name, len(init.Elements), tableType.Length)
+	}
+	if len(init.Elements) > maxFunctionTableEntries {
+		return a.errorAt(c, "function table %s has %d entries, at most %d are supported",
+			c.Name, len(init.Elements), maxFunctionTableEntries)
+	}
+	tableType.Length = len(init.Elements)
+
+	entries := make([]string, len(init.Elements))
+	for i, elem := range init.Elements {
+		id, ok := elem.(*ast.Identifier)
+		if !ok {
+			return a.errorAt(c, "function table %s: entry %d is not a function name", c.Name, i)
+		}
+		funcSym, err := a.resolveTableEntry(id.Name, fnType)
+		if err != nil {
+			return a.errorAt(c, "function table %s: entry %d: %v", c.Name, i, err)
+		}
+		entries[i] = funcSym.Name
+	}
+
+	prefixedName := a.prefixSymbol(c.Name)
+	a.currentScope.Define(prefixedName, &VarSymbol{Name: prefixedName, Type: tableType})
+	if prefixedName != c.Name && a.currentModule != "" {
+		a.currentScope.Define(c.Name, &VarSymbol{Name: prefixedName, Type: tableType})
+	}
+
+	a.module.Globals = append(a.module.Globals, ir.Global{
+		Name:     prefixedName,
+		Type:     tableType,
+		Init:     entries,
+		Constant: true,
+	})
+	return nil
+}
+
+// resolveTableEntry finds the function a table entry names and checks that
+// its signature is the table's element type
+func (a *Analyzer) resolveTableEntry(name string, want *ir.FunctionType) (*FuncSymbol, error) {
+	sym := a.currentScope.Lookup(name)
+	if sym == nil {
+		sym = a.currentScope.Lookup(a.prefixSymbol(name))
+	}
+
+	switch s := sym.(type) {
+	case *FuncSymbol:
+		if got := funcSymbolType(s); got.String() != want.String() {
+			return nil, fmt.Errorf("%s has type %s, want %s", name, got, want)
+		}
+		return s, nil
+	case *FunctionOverloadSet:
+		for _, overload := range s.Overloads {
+			if funcSymbolType(overload).String() == want.String() {
+				return overload, nil
+			}
+		}
+		if len(s.Overloads) == 1 {
+			for _, only := range s.Overloads {
+				return nil, fmt.Errorf("%s has type %s, want %s", name, funcSymbolType(only), want)
+			}
+		}
+		return nil, fmt.Errorf("no overload of %s has type %s", name, want)
+	case nil:
+		return nil, fmt.Errorf("undefined function %s", name)
+	}
+	return nil, fmt.Errorf("%s is not a function", name)
+}
+
+// funcSymbolType returns the signature of a declared function
+func funcSymbolType(f *FuncSymbol) *ir.FunctionType {
+	ret := f.ReturnType
+	if ret == nil {
+		ret = &ir.BasicType{Kind: ir.TypeVoid}
+	}
+	params := f.ParamTypes
+	if params == nil {
+		params = []ir.Type{}
+	}
+	return &ir.FunctionType{Params: params, Return: ret}
+}
+
+// analyzeFunctionTableCall calls the function selected by indexing a
+// function table, e.g. handlers[i](x)
+func (a *Analyzer) analyzeFunctionTableCall(call *ast.CallExpr, index *ast.IndexExpr, irFunc *ir.Function) (ir.Register, error) {
+	fnReg, err := a.analyzeIndexExpr(index, irFunc)
+	if err != nil {
+		return 0, err
+	}
+	fnType, ok := a.exprTypes[index].(*ir.FunctionType)
+	if !ok {
+		return 0, a.errorAt(call, "cannot call an element of type %s", typeName(a.exprTypes[index]))
+	}
+	if len(call.Arguments) != len(fnType.Params) {
+		return 0, a.errorAt(call, "function table call argument count mismatch: expected %d, got %d",
+			len(fnType.Params), len(call.Arguments))
+	}
+
+	argRegs := make([]ir.Register, len(call.Arguments))
+	for i, arg := range call.Arguments {
+		argReg, err := a.analyzeExpression(arg, irFunc)
+		if err != nil {
+			return 0, fmt.Errorf("failed to analyze function table call argument %d: %w", i, err)
+		}
+		argRegs[i] = argReg
+	}
+
+	resultReg := irFunc.AllocReg()
+	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+		Op:      ir.OpCallIndirect,
+		Dest:    resultReg,
+		Src1:    fnReg,
+		Args:    argRegs,
+		Comment: "Call through function table",
+	})
+	a.exprTypes[call] = fnType.Return
+	return resultReg, nil
+}
@@ -0,0 +1,145 @@
+package semantic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// functionTableProgram builds:
+//
+//	const handlers: [fn() -> void; 4] = [a, b, c, d];
+//	fun a() -> void {}   // likewise b, c, d; b takes params
+//	fun main() -> void {
+//	    let i: u8 = 2;
+//	    handlers[i]();
+//	}
+func functionTableProgram(params ...*ast.Parameter) *ast.File {
+	void := &ast.PrimitiveType{Name: "void"}
+	handler := func(name string, params []*ast.Parameter) *ast.FunctionDecl {
+		return &ast.FunctionDecl{Name: name, Params: params, ReturnType: void, Body: &ast.BlockStmt{}}
+	}
+
+	return &ast.File{
+		Name: "table.minz",
+		Declarations: []ast.Declaration{
+			// Before the functions, which the entries may name
+			&ast.ConstDecl{
+				Name: "handlers",
+				Type: &ast.ArrayType{
+					ElementType: &ast.FunctionType{ParamTypes: []ast.Type{}, ReturnType: void},
+					Size:        &ast.NumberLiteral{Value: 4},
+				},
+				Value: &ast.ArrayInitializer{Elements: []ast.Expression{
+					&ast.Identifier{Name: "a"},
+					&ast.Identifier{Name: "b"},
+					&ast.Identifier{Name: "c"},
+					&ast.Identifier{Name: "d"},
+				}},
+			},
+			handler("a", nil),
+			handler("b", params),
+			handler("c", nil),
+			handler("d", nil),
+			&ast.FunctionDecl{
+				Name:       "main",
+				ReturnType: void,
+				Body: &ast.BlockStmt{Statements: []ast.Statement{
+					&ast.VarDecl{Name: "i", Type: &ast.PrimitiveType{Name: "u8"}, Value: &ast.NumberLiteral{Value: 2}},
+					&ast.ExpressionStmt{Expression: &ast.CallExpr{
+						Function: &ast.IndexExpr{
+							Array: &ast.Identifier{Name: "handlers"},
+							Index: &ast.Identifier{Name: "i"},
+						},
+						Arguments: []ast.Expression{},
+					}},
+				}},
+			},
+		},
+	}
+}
+
+func TestFunctionTableDefinition(t *testing.T) {
+	module, err := NewAnalyzer().Analyze(functionTableProgram())
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	var table *ir.Global
+	for i := range module.Globals {
+		if strings.HasSuffix(module.Globals[i].Name, "handlers") {
+			table = &module.Globals[i]
+		}
+	}
+	if table == nil {
+		t.Fatal("handlers table not emitted")
+	}
+	if !table.Constant {
+		t.Error("function table should be constant")
+	}
+	if table.Type.Size() != 8 {
+		t.Errorf("table size = %d, want 8", table.Type.Size())
+	}
+
+	entries, ok := table.Init.([]string)
+	if !ok || len(entries) != 4 {
+		t.Fatalf("table entries = %#v, want 4 function names", table.Init)
+	}
+	for i, name := range []string{"a", "b", "c", "d"} {
+		if !strings.Contains(entries[i], name) {
+			t.Errorf("entry %d = %s, want function %s", i, entries[i], name)
+		}
+	}
+}
+
+func TestFunctionTableCall(t *testing.T) {
+	module, err := NewAnalyzer().Analyze(functionTableProgram())
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	var main *ir.Function
+	for _, fn := range module.Functions {
+		if strings.HasSuffix(fn.Name, "main") {
+			main = fn
+		}
+	}
+	if main == nil {
+		t.Fatal("main not generated")
+	}
+
+	var load *ir.Instruction
+	for i, inst := range main.Instructions {
+		switch inst.Op {
+		case ir.OpLoadIndex:
+			if _, ok := inst.Type.(*ir.FunctionType); !ok {
+				t.Errorf("indexed load has type %v, want a function type", inst.Type)
+			}
+			load = &main.Instructions[i]
+		case ir.OpCallIndirect:
+			if load == nil {
+				t.Fatal("indirect call before loading the table entry")
+			}
+			if inst.Src1 != load.Dest {
+				t.Errorf("call through r%d, want the loaded entry r%d", inst.Src1, load.Dest)
+			}
+			return
+		}
+	}
+	t.Errorf("no indirect call through the table in main: %v", main.Instructions)
+}
+
+func TestFunctionTableSignatureMismatch(t *testing.T) {
+	param := &ast.Parameter{Name: "x", Type: &ast.PrimitiveType{Name: "u8"}}
+	_, err := NewAnalyzer().Analyze(functionTableProgram(param))
+	if err == nil {
+		t.Fatal("expected an error for an entry with the wrong signature")
+	}
+	for _, want := range []string{"handlers", "entry 1", "b has type fun(u8) -> void, want fun() -> void"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package semantic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// Generic functions are monomorphized: a declaration such as
+//
+//	fun max<T>(a: T, b: T) -> T { ... }
+//
+// generates no code by itself. Each call infers T from the argument types
+// and analyzes a copy of the function with T bound to that type, once per
+// distinct binding, under the usual mangled name (max$u8$u8, max$i16$i16).
+// Number literals adapt to the other arguments, so max(x, 10) with x: i16
+// uses max$i16$i16; when only literals bind T it is the smallest of u8,
+// i8, u16 and i16 that holds them.
+
+// genericTemplate is a generic function with the scope and module it was
+// declared in, where its instances are analyzed
+type genericTemplate struct {
+	decl   *ast.FunctionDecl
+	scope  *Scope
+	module string
+}
+
+// registerGenericFunction records a generic function so calls can
+// instantiate it
+func (a *Analyzer) registerGenericFunction(fn *ast.FunctionDecl) error {
+	seen := make(map[string]bool)
+	for _, param := range fn.GenericParams {
+		if seen[param.Name] {
+			return fmt.Errorf("duplicate type parameter %s in function %s", param.Name, fn.Name)
+		}
+		seen[param.Name] = true
+	}
+
+	template := &genericTemplate{decl: fn, scope: a.currentScope, module: a.currentModule}
+	prefixedName := fn.Name
+	if !strings.Contains(fn.Name, ".") {
+		prefixedName = a.prefixSymbol(fn.Name)
+	}
+	a.genericFunctions[prefixedName] = template
+	a.genericFunctions[fn.Name] = template
+	return nil
+}
+
+// instantiateGeneric returns the instance of the generic function name
+// for the argument types of a call, analyzing it on first use. It reports
+// false when name is not a generic function.
+func (a *Analyzer) instantiateGeneric(name string, args []ast.Expression) (*FuncSymbol, bool, error) {
+	template, ok := a.genericFunctions[name]
+	if !ok {
+		return nil, false, nil
+	}
+	fn := template.decl
+	if len(args) != len(fn.Params) {
+		return nil, true, fmt.Errorf("generic function %s expects %d arguments, got %d",
+			fn.Name, len(fn.Params), len(args))
+	}
+
+	bindings, err := a.inferTypeArguments(fn, args)
+	if err != nil {
+		return nil, true, err
+	}
+	if err := a.checkBounds(fn, bindings); err != nil {
+		return nil, true, err
+	}
+
+	// A concrete copy of the declaration; the body is shared
+	instance := *fn
+	instance.GenericParams = nil
+	instance.Params = make([]*ast.Parameter, len(fn.Params))
+	for i, param := range fn.Params {
+		concrete := *param
+		concrete.Type = substituteType(param.Type, bindings)
+		instance.Params[i] = &concrete
+	}
+	instance.ReturnType = substituteType(fn.ReturnType, bindings)
+
+	prefixedName := fn.Name
+	if !strings.Contains(fn.Name, ".") {
+		prefixedName = a.prefixWithModule(template.module, fn.Name)
+	}
+	mangledName := generateMangledName(prefixedName, instance.Params)
+	if funcSym, ok := a.genericInstances[mangledName]; ok {
+		return funcSym, true, nil
+	}
+
+	// Instances live beside the generic function, whatever calls them
+	prevScope, prevModule := a.currentScope, a.currentModule
+	defer func() { a.currentScope, a.currentModule = prevScope, prevModule }()
+	a.currentScope, a.currentModule = template.scope, template.module
+	if err := a.registerFunctionSignature(&instance); err != nil {
+		return nil, true, err
+	}
+	funcSym, ok := a.currentScope.Lookup(mangledName).(*FuncSymbol)
+	if !ok {
+		return nil, true, fmt.Errorf("instance %s of generic function %s was not registered", mangledName, fn.Name)
+	}
+	// Registered before the body so recursive calls find it
+	a.genericInstances[mangledName] = funcSym
+
+	// The type parameters name their bindings inside the body
+	a.currentScope = NewScope(template.scope)
+	for _, param := range fn.GenericParams {
+		a.currentScope.Define(param.Name, &TypeSymbol{Name: param.Name, Type: bindings[param.Name]})
+	}
+	if err := a.analyzeFunctionDecl(&instance); err != nil {
+		return nil, true, fmt.Errorf("in %s with %s: %w", fn.Name, formatBindings(fn, bindings), err)
+	}
+	return funcSym, true, nil
+}
+
+// inferTypeArguments binds each type parameter of fn from the types of
+// the call's arguments. Arguments other than number literals must agree;
+// literals only decide type parameters that nothing else binds.
+func (a *Analyzer) inferTypeArguments(fn *ast.FunctionDecl, args []ast.Expression) (map[string]ir.Type, error) {
+	typeParams := make(map[string]bool)
+	for _, param := range fn.GenericParams {
+		typeParams[param.Name] = true
+	}
+
+	bindings := make(map[string]ir.Type)
+	literals := make(map[string][]int64)
+	for i, param := range fn.Params {
+		if value, ok := integerLiteral(args[i]); ok {
+			if name := typeParamName(param.Type, typeParams); name != "" {
+				literals[name] = append(literals[name], value)
+				continue
+			}
+		}
+		if err := a.bindParam(fn, param.Type, args[i], typeParams, bindings); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, param := range fn.GenericParams {
+		if _, ok := bindings[param.Name]; ok {
+			continue
+		}
+		values, ok := literals[param.Name]
+		if !ok {
+			return nil, fmt.Errorf("cannot infer type parameter %s of %s from the arguments", param.Name, fn.Name)
+		}
+		bindings[param.Name] = literalType(values)
+	}
+	return bindings, nil
+}
+
+// integerLiteral returns the value of a number literal, negated or not
+func integerLiteral(expr ast.Expression) (int64, bool) {
+	if neg, ok := expr.(*ast.UnaryExpr); ok && neg.Operator == "-" {
+		value, ok := integerLiteral(neg.Operand)
+		return -value, ok
+	}
+	if lit, ok := expr.(*ast.NumberLiteral); ok && !lit.IsFloat {
+		return lit.Value, true
+	}
+	return 0, false
+}
+
+// literalType is the smallest type that holds every literal
+func literalType(values []int64) ir.Type {
+	kind := ir.TypeU8
+	for _, v := range values {
+		switch {
+		case v < -128 || v > 0xFF && kind == ir.TypeI8:
+			kind = ir.TypeI16
+		case v < 0 && kind == ir.TypeU8:
+			kind = ir.TypeI8
+		case v < 0 && kind == ir.TypeU16:
+			kind = ir.TypeI16
+		case v > 0xFF && kind == ir.TypeU8:
+			kind = ir.TypeU16
+		}
+	}
+	return &ir.BasicType{Kind: kind}
+}
+
+// bindParam binds the type parameters a parameter's type mentions, such
+// as T in T or *T, from the matching part of the argument's type
+func (a *Analyzer) bindParam(fn *ast.FunctionDecl, paramType ast.Type, arg ast.Expression,
+	typeParams map[string]bool, bindings map[string]ir.Type) error {
+	if !mentionsTypeParam(paramType, typeParams) {
+		return nil
+	}
+	argType, err := a.inferType(arg)
+	if err != nil {
+		return fmt.Errorf("cannot infer the type of an argument to %s: %w", fn.Name, err)
+	}
+	for {
+		if name := typeParamName(paramType, typeParams); name != "" {
+			return bindTypeParam(fn, name, argType, bindings)
+		}
+		switch t := paramType.(type) {
+		case *ast.PointerType:
+			ptr, ok := argType.(*ir.PointerType)
+			if !ok {
+				return fmt.Errorf("%s expects a pointer, got %s", fn.Name, argType)
+			}
+			paramType, argType = t.BaseType, ptr.Base
+		case *ast.ArrayType:
+			arr, ok := argType.(*ir.ArrayType)
+			if !ok {
+				return fmt.Errorf("%s expects an array, got %s", fn.Name, argType)
+			}
+			paramType, argType = t.ElementType, arr.Element
+		default:
+			return nil
+		}
+	}
+}
+
+// bindTypeParam binds name to typ, or checks it against an earlier binding
+func bindTypeParam(fn *ast.FunctionDecl, name string, typ ir.Type, bindings map[string]ir.Type) error {
+	if bound, ok := bindings[name]; ok {
+		if bound.String() != typ.String() {
+			return fmt.Errorf("conflicting types for %s in call to %s: %s and %s", name, fn.Name, bound, typ)
+		}
+		return nil
+	}
+	bindings[name] = typ
+	return nil
+}
+
+// typeParamName returns the type parameter a type names, or ""
+func typeParamName(t ast.Type, typeParams map[string]bool) string {
+	var name string
+	switch t := t.(type) {
+	case *ast.PrimitiveType:
+		name = t.Name
+	case *ast.TypeIdentifier:
+		name = t.Name
+	}
+	if typeParams[name] {
+		return name
+	}
+	return ""
+}
+
+// mentionsTypeParam reports whether a type refers to a type parameter
+func mentionsTypeParam(t ast.Type, typeParams map[string]bool) bool {
+	switch typ := t.(type) {
+	case *ast.PointerType:
+		return mentionsTypeParam(typ.BaseType, typeParams)
+	case *ast.ArrayType:
+		return mentionsTypeParam(typ.ElementType, typeParams)
+	}
+	return typeParamName(t, typeParams) != ""
+}
+
+// substituteType replaces the type parameters in t by their bindings
+func substituteType(t ast.Type, bindings map[string]ir.Type) ast.Type {
+	switch typ := t.(type) {
+	case *ast.PrimitiveType:
+		if bound, ok := bindings[typ.Name]; ok {
+			return irTypeToAST(bound)
+		}
+	case *ast.TypeIdentifier:
+		if bound, ok := bindings[typ.Name]; ok {
+			return irTypeToAST(bound)
+		}
+	case *ast.PointerType:
+		ptr := *typ
+		ptr.BaseType = substituteType(typ.BaseType, bindings)
+		return &ptr
+	case *ast.ArrayType:
+		arr := *typ
+		arr.ElementType = substituteType(typ.ElementType, bindings)
+		return &arr
+	}
+	return t
+}
+
+// irTypeToAST spells a bound type as source would
+func irTypeToAST(t ir.Type) ast.Type {
+	switch typ := t.(type) {
+	case *ir.BasicType:
+		return &ast.PrimitiveType{Name: typ.String()}
+	case *ir.PointerType:
+		return &ast.PointerType{BaseType: irTypeToAST(typ.Base)}
+	case *ir.ArrayType:
+		return &ast.ArrayType{ElementType: irTypeToAST(typ.Element), Size: &ast.NumberLiteral{Value: int64(typ.Length)}}
+	}
+	// Structs, enums and aliases by name
+	return &ast.TypeIdentifier{Name: t.String()}
+}
+
+// prefixWithModule prefixes a name as prefixSymbol would in module
+func (a *Analyzer) prefixWithModule(module, name string) string {
+	prev := a.currentModule
+	a.currentModule = module
+	defer func() { a.currentModule = prev }()
+	return a.prefixSymbol(name)
+}
+
+// formatBindings lists the bindings of a generic function's type
+// parameters, in declaration order, for error messages
+func formatBindings(fn *ast.FunctionDecl, bindings map[string]ir.Type) string {
+	parts := make([]string, len(fn.GenericParams))
+	for i, param := range fn.GenericParams {
+		parts[i] = fmt.Sprintf("%s = %s", param.Name, bindings[param.Name])
+	}
+	return strings.Join(parts, ", ")
+}
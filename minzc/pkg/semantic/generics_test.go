@@ -0,0 +1,152 @@
+package semantic
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// genericProgram builds:
+//
+//	fun max<T>(a: T, b: T) -> T {
+//	    let m: T = a;
+//	    if b > a { m = b; }
+//	    return m;
+//	}
+//	fun main() -> void {
+//	    let <name>: <type> = 1; ...
+//	    let r<i> = max(<args>);
+//	}
+func genericProgram(vars [][2]string, calls [][]ast.Expression) *ast.File {
+	typeT := &ast.TypeIdentifier{Name: "T"}
+	maxDecl := &ast.FunctionDecl{
+		Name:          "max",
+		GenericParams: []*ast.GenericParam{{Name: "T"}},
+		Params: []*ast.Parameter{
+			{Name: "a", Type: typeT},
+			{Name: "b", Type: typeT},
+		},
+		ReturnType: typeT,
+		Body: &ast.BlockStmt{Statements: []ast.Statement{
+			&ast.VarDecl{Name: "m", Type: typeT, Value: &ast.Identifier{Name: "a"}, IsMutable: true},
+			&ast.IfStmt{
+				Condition: &ast.BinaryExpr{Left: &ast.Identifier{Name: "b"}, Operator: ">", Right: &ast.Identifier{Name: "a"}},
+				Then: &ast.BlockStmt{Statements: []ast.Statement{
+					&ast.AssignStmt{Target: &ast.Identifier{Name: "m"}, Value: &ast.Identifier{Name: "b"}},
+				}},
+			},
+			&ast.ReturnStmt{Value: &ast.Identifier{Name: "m"}},
+		}},
+	}
+
+	var body []ast.Statement
+	for _, v := range vars {
+		body = append(body, &ast.VarDecl{
+			Name:  v[0],
+			Type:  &ast.PrimitiveType{Name: v[1]},
+			Value: &ast.NumberLiteral{Value: 1},
+		})
+	}
+	for i, args := range calls {
+		body = append(body, &ast.VarDecl{
+			Name:  "r" + string(rune('0'+i)),
+			Value: &ast.CallExpr{Function: &ast.Identifier{Name: "max"}, Arguments: args},
+		})
+	}
+
+	return &ast.File{
+		Name: "generic.minz",
+		Declarations: []ast.Declaration{
+			maxDecl,
+			&ast.FunctionDecl{
+				Name:       "main",
+				ReturnType: &ast.PrimitiveType{Name: "void"},
+				Body:       &ast.BlockStmt{Statements: body},
+			},
+		},
+	}
+}
+
+func ident(name string) ast.Expression { return &ast.Identifier{Name: name} }
+
+func number(value int64) ast.Expression { return &ast.NumberLiteral{Value: value} }
+
+func TestGenericInstances(t *testing.T) {
+	module, err := NewAnalyzer().Analyze(genericProgram(
+		[][2]string{{"x", "u8"}, {"y", "u16"}, {"s", "i8"}, {"p", "i16"}},
+		[][]ast.Expression{
+			{ident("x"), number(7)},
+			{ident("y"), number(2)},
+			{ident("s"), &ast.UnaryExpr{Operator: "-", Operand: number(2)}},
+			{number(4), ident("p")},
+			{ident("x"), ident("x")},
+			{number(1), number(300)},
+		},
+	))
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	returns := make(map[string]string)
+	var names []string
+	for _, fn := range module.Functions {
+		if strings.Contains(fn.Name, "max") {
+			names = append(names, fn.Name)
+			returns[fn.Name] = fn.ReturnType.String()
+		}
+	}
+	sort.Strings(names)
+	want := []string{"generic.max$i16$i16", "generic.max$i8$i8", "generic.max$u16$u16", "generic.max$u8$u8"}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Fatalf("instances = %v, want %v", names, want)
+	}
+	for _, name := range names {
+		if kind := strings.Split(name, "$")[1]; returns[name] != kind {
+			t.Errorf("%s returns %s, want %s", name, returns[name], kind)
+		}
+	}
+
+	var main *ir.Function
+	for _, fn := range module.Functions {
+		if strings.HasSuffix(fn.Name, "main") {
+			main = fn
+		}
+	}
+	calls := 0
+	for _, inst := range main.Instructions {
+		if inst.Op == ir.OpCall && strings.Contains(inst.Symbol, "max$") {
+			calls++
+		}
+	}
+	if calls != 6 {
+		t.Errorf("main calls instances %d times, want 6", calls)
+	}
+}
+
+func TestGenericErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []ast.Expression
+		want string
+	}{
+		{"conflicting types", []ast.Expression{ident("x"), ident("y")}, "conflicting types for T"},
+		{"argument count", []ast.Expression{ident("x")}, "expects 2 arguments"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewAnalyzer().Analyze(genericProgram(
+				[][2]string{{"x", "u8"}, {"y", "u16"}},
+				[][]ast.Expression{tt.args},
+			))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not mention %q", err, tt.want)
+			}
+		})
+	}
+}
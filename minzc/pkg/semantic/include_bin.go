@@ -0,0 +1,132 @@
+package semantic
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// Binary assets such as sprite sheets, music and screens are embedded with
+// @include_bin:
+//
+//	const SPRITES = @include_bin("sprites.bin");
+//	const SCREEN = @include_bin("screen.scr", 256);
+//
+// The file is read at compile time, relative to the source file, and
+// emitted as a read-only global: a 16-bit length followed by the bytes.
+// The optional second argument aligns the start of the block to that many
+// bytes, a power of two, e.g. 256 for page-aligned tables. A constant names
+// the address of the length; inside a function @include_bin(...) is an
+// expression of type *u8 with that address.
+
+// maxIncludeBinSize is the most a 16-bit length prefix can describe
+const maxIncludeBinSize = 0xFFFF
+
+// isIncludeBinCall reports whether an expression is @include_bin(...)
+func isIncludeBinCall(expr ast.Expression) (*ast.MetafunctionCall, bool) {
+	call, ok := expr.(*ast.MetafunctionCall)
+	if !ok || strings.TrimPrefix(call.Name, "@") != "include_bin" {
+		return nil, false
+	}
+	return call, true
+}
+
+// analyzeIncludeBinConst embeds a file as a read-only global named by the
+// constant
+func (a *Analyzer) analyzeIncludeBinConst(c *ast.ConstDecl, call *ast.MetafunctionCall) error {
+	prefixedName := a.prefixSymbol(c.Name)
+	blockType, err := a.includeBin(call, prefixedName)
+	if err != nil {
+		return err
+	}
+
+	a.currentScope.Define(prefixedName, &VarSymbol{Name: prefixedName, Type: blockType})
+	if prefixedName != c.Name && a.currentModule != "" {
+		a.currentScope.Define(c.Name, &VarSymbol{Name: prefixedName, Type: blockType})
+	}
+	return nil
+}
+
+// analyzeIncludeBin embeds a file used as an expression and loads its
+// address
+func (a *Analyzer) analyzeIncludeBin(call *ast.MetafunctionCall, irFunc *ir.Function) (ir.Register, error) {
+	label := a.generateLabel("bin")
+	if _, err := a.includeBin(call, label); err != nil {
+		return 0, err
+	}
+
+	reg := irFunc.AllocReg()
+	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+		Op:      ir.OpLoadAddr,
+		Dest:    reg,
+		Symbol:  label,
+		Type:    &ir.PointerType{Base: &ir.BasicType{Kind: ir.TypeU8}},
+		Comment: fmt.Sprintf("@include_bin(%q)", includeBinPath(call)),
+	})
+	a.exprTypes[call] = &ir.PointerType{Base: &ir.BasicType{Kind: ir.TypeU8}}
+	return reg, nil
+}
+
+// includeBin reads the file of an @include_bin call and adds it to the
+// module as the global label. It returns the type of the whole block.
+func (a *Analyzer) includeBin(call *ast.MetafunctionCall, label string) (ir.Type, error) {
+	if len(call.Arguments) < 1 || len(call.Arguments) > 2 {
+		return nil, a.errorAt(call, "@include_bin requires a file name and an optional alignment")
+	}
+	path := includeBinPath(call)
+	if path == "" {
+		return nil, a.errorAt(call, "@include_bin requires a string literal file name")
+	}
+
+	align := 0
+	if len(call.Arguments) == 2 {
+		value, err := a.evaluateConstExpr(call.Arguments[1])
+		if err != nil {
+			return nil, a.errorAt(call, "@include_bin alignment must be a constant: %v", err)
+		}
+		n, ok := value.(int64)
+		if !ok || n < 1 || n > 0x8000 || n&(n-1) != 0 {
+			return nil, a.errorAt(call, "@include_bin alignment must be a power of two up to 32768, got %v", value)
+		}
+		align = int(n)
+	}
+
+	// Relative to the file being compiled, like imports
+	if !filepath.IsAbs(path) && a.sourcePath != "" {
+		path = filepath.Join(filepath.Dir(a.sourcePath), path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, a.errorAt(call, "@include_bin: %v", err)
+	}
+	if len(data) > maxIncludeBinSize {
+		return nil, a.errorAt(call, "@include_bin: %s is %d bytes, at most %d are supported",
+			path, len(data), maxIncludeBinSize)
+	}
+
+	blockType := &ir.ArrayType{Element: &ir.BasicType{Kind: ir.TypeU8}, Length: len(data) + 2}
+	a.module.Globals = append(a.module.Globals, ir.Global{
+		Name:     label,
+		Type:     blockType,
+		Init:     data,
+		Constant: true,
+		Align:    align,
+	})
+	return blockType, nil
+}
+
+// includeBinPath returns the file name of an @include_bin call, or "" when
+// it is not a string literal
+func includeBinPath(call *ast.MetafunctionCall) string {
+	if len(call.Arguments) == 0 {
+		return ""
+	}
+	if lit, ok := call.Arguments[0].(*ast.StringLiteral); ok {
+		return lit.Value
+	}
+	return ""
+}
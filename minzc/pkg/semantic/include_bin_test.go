@@ -0,0 +1,118 @@
+package semantic
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// includeBinProgram writes sprites.bin next to game.minz and builds:
+//
+//	const SPRITES = @include_bin("sprites.bin", <align>);
+//	fun main() -> void {
+//	    let tiles = @include_bin("<tiles>");
+//	}
+func includeBinProgram(t *testing.T, align int64, tiles string) *ast.File {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sprites.bin"), []byte{0x00, 0x3C, 0x42, 0xFF}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return &ast.File{
+		Name: filepath.Join(dir, "game.minz"),
+		Declarations: []ast.Declaration{
+			&ast.ConstDecl{
+				Name: "SPRITES",
+				Value: &ast.MetafunctionCall{Name: "include_bin", Arguments: []ast.Expression{
+					&ast.StringLiteral{Value: "sprites.bin"},
+					&ast.NumberLiteral{Value: align},
+				}},
+			},
+			&ast.FunctionDecl{
+				Name:       "main",
+				ReturnType: &ast.PrimitiveType{Name: "void"},
+				Body: &ast.BlockStmt{Statements: []ast.Statement{
+					&ast.VarDecl{Name: "tiles", Value: &ast.MetafunctionCall{
+						Name:      "include_bin",
+						Arguments: []ast.Expression{&ast.StringLiteral{Value: tiles}},
+					}},
+				}},
+			},
+		},
+	}
+}
+
+func TestIncludeBin(t *testing.T) {
+	module, err := NewAnalyzer().Analyze(includeBinProgram(t, 256, "sprites.bin"))
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	var blocks []ir.Global
+	for _, global := range module.Globals {
+		if _, ok := global.Init.([]byte); ok {
+			blocks = append(blocks, global)
+		}
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("got %d embedded files, want 2: %+v", len(blocks), module.Globals)
+	}
+
+	sprites := blocks[0]
+	if !strings.HasSuffix(sprites.Name, "SPRITES") {
+		t.Errorf("first block is %s, want the SPRITES constant", sprites.Name)
+	}
+	if !sprites.Constant || sprites.Align != 256 {
+		t.Errorf("SPRITES constant=%v align=%d, want a constant aligned to 256", sprites.Constant, sprites.Align)
+	}
+	if !bytes.Equal(sprites.Init.([]byte), []byte{0x00, 0x3C, 0x42, 0xFF}) {
+		t.Errorf("SPRITES data = %v", sprites.Init)
+	}
+	if sprites.Type.Size() != 6 {
+		t.Errorf("SPRITES size = %d, want 6 with the length", sprites.Type.Size())
+	}
+
+	var main *ir.Function
+	for _, fn := range module.Functions {
+		if strings.HasSuffix(fn.Name, "main") {
+			main = fn
+		}
+	}
+	if main == nil {
+		t.Fatal("main not generated")
+	}
+	for _, inst := range main.Instructions {
+		if inst.Op == ir.OpLoadAddr && inst.Symbol == blocks[1].Name {
+			return
+		}
+	}
+	t.Errorf("main does not load the address of %s: %v", blocks[1].Name, main.Instructions)
+}
+
+func TestIncludeBinErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		align int64
+		tiles string
+		want  string
+	}{
+		{"missing file", 256, "missing.bin", "missing.bin"},
+		{"bad alignment", 100, "sprites.bin", "power of two"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewAnalyzer().Analyze(includeBinProgram(t, tt.align, tt.tiles))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not mention %q", err, tt.want)
+			}
+		})
+	}
+}
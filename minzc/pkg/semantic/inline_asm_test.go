@@ -0,0 +1,92 @@
+package semantic
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// analyzeAsm analyzes
+//
+//	fun f(y: u16) -> u8 { let mut x: u8 = 0; <asm>; return x; }
+//
+// and returns f's instructions
+func analyzeAsm(asm *ast.InlineAssembly) ([]ir.Instruction, error) {
+	u8 := &ast.PrimitiveType{Name: "u8"}
+	file := &ast.File{
+		Name: "asm.minz",
+		Declarations: []ast.Declaration{&ast.FunctionDecl{
+			Name:       "f",
+			Params:     []*ast.Parameter{{Name: "y", Type: &ast.PrimitiveType{Name: "u16"}}},
+			ReturnType: u8,
+			Body: &ast.BlockStmt{Statements: []ast.Statement{
+				&ast.VarDecl{Name: "x", Type: u8, Value: &ast.NumberLiteral{Value: 0}, IsMutable: true},
+				&ast.ExpressionStmt{Expression: asm},
+				&ast.ReturnStmt{Value: &ast.Identifier{Name: "x"}},
+			}},
+		}},
+	}
+
+	module, err := NewAnalyzer().Analyze(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range module.Functions {
+		if strings.Contains(fn.Name, ".f") {
+			return fn.Instructions, nil
+		}
+	}
+	return nil, fmt.Errorf("f not generated")
+}
+
+func TestInlineAsmOperands(t *testing.T) {
+	insts, err := analyzeAsm(&ast.InlineAssembly{
+		Code:     "LD A, {1}",
+		Outputs:  []*ast.AsmOperand{{Constraint: "=A", Expr: &ast.Identifier{Name: "x"}}},
+		Inputs:   []*ast.AsmOperand{{Constraint: "r", Expr: &ast.Identifier{Name: "y"}}},
+		Clobbers: []string{"HL"},
+	})
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	for i, inst := range insts {
+		if inst.Op != ir.OpAsm {
+			continue
+		}
+		ops := inst.AsmOperands
+		if len(ops) != 2 || !ops[0].Output || ops[0].Constraint != "A" || ops[1].Output || ops[1].Constraint != "r" {
+			t.Fatalf("operands = %+v, want out A then in r", ops)
+		}
+		if ops[0].Type.Size() != 1 || ops[1].Type.Size() != 2 {
+			t.Errorf("operand sizes %d, %d, want 1, 2", ops[0].Type.Size(), ops[1].Type.Size())
+		}
+		if !reflect.DeepEqual(inst.AsmClobbers, []string{"HL"}) {
+			t.Errorf("clobbers = %v, want [HL]", inst.AsmClobbers)
+		}
+		// The input is read, and the output stored to x right after
+		if !reflect.DeepEqual(inst.Reads(), []ir.Register{ops[1].Reg}) {
+			t.Errorf("asm reads %v, want [r%d]", inst.Reads(), ops[1].Reg)
+		}
+		store := insts[i+1]
+		if store.Op != ir.OpStoreVar || store.Src1 != ops[0].Reg || !strings.HasSuffix(store.Symbol, "x") {
+			t.Errorf("after asm: %s, want x stored from r%d", store.String(), ops[0].Reg)
+		}
+		return
+	}
+	t.Fatal("no asm instruction")
+}
+
+func TestInlineAsmOutputMustBeVariable(t *testing.T) {
+	_, err := analyzeAsm(&ast.InlineAssembly{
+		Code:    "LD A, 1",
+		Outputs: []*ast.AsmOperand{{Constraint: "A", Expr: &ast.NumberLiteral{Value: 1}}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "inline assembly output must be a variable") {
+		t.Fatalf("got error %v, want an output error", err)
+	}
+}
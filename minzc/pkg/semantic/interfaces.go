@@ -0,0 +1,179 @@
+package semantic
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// Interfaces are resolved entirely at compile time:
+//
+//	interface Shape { area(self: Shape) -> u16; }
+//	impl Shape for Circle { fun area(self: Circle) -> u16 { ... } }
+//	impl Shape for Rect { fun area(self: Rect) -> u16 { ... } }
+//
+//	let a = c.area();          // CALL Circle.area$Circle
+//	let b = Shape.area(r);     // CALL Rect.area$Rect
+//	fun total<T: Shape>(s: T) -> u16 { return s.area(); }
+//
+// A method call binds, from the static type of its receiver, to the
+// function implementing the method for that type, which is called
+// directly: there are no vtables and no type tags. A generic function is
+// monomorphized per concrete type (see generics.go), so each instance
+// calls that type's methods, and its interface bounds are checked when it
+// is instantiated. An interface is not a type of its own: a parameter that
+// takes any Shape is a generic parameter T: Shape.
+
+// implSymbolKey names the scope symbol of the impl of an interface for a type
+func implSymbolKey(iface string, t ir.Type) string {
+	return fmt.Sprintf("%s_for_%s", iface, t.String())
+}
+
+// implMethodName is the function name of a method implemented for a type
+func implMethodName(t ir.Type, method string) string {
+	return t.String() + "." + method
+}
+
+// implements reports whether a type has an impl of the interface
+func (a *Analyzer) implements(t ir.Type, iface string) bool {
+	_, ok := a.currentScope.Lookup(implSymbolKey(iface, t)).(*ImplSymbol)
+	return ok
+}
+
+// MethodNames returns the interface's method names in sorted order
+func (i *InterfaceSymbol) MethodNames() []string {
+	names := make([]string, 0, len(i.Methods))
+	for name := range i.Methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkImplSignature checks that an implemented method returns and takes
+// what the interface declares; self takes the implementing type
+func (a *Analyzer) checkImplSignature(method *ast.FunctionDecl, ifaceMethod *InterfaceMethod) error {
+	returnType, err := a.convertType(method.ReturnType)
+	if err != nil {
+		return err
+	}
+	if ifaceMethod.ReturnType != nil && returnType.String() != ifaceMethod.ReturnType.String() {
+		return fmt.Errorf("returns %s, but the interface declares %s", returnType.String(), ifaceMethod.ReturnType.String())
+	}
+	for i := 1; i < len(method.Params); i++ {
+		want := ifaceMethod.Params[i]
+		if want.Type == nil {
+			continue
+		}
+		wantType, err := a.convertType(want.Type)
+		if err != nil {
+			return err
+		}
+		got, err := a.convertType(method.Params[i].Type)
+		if err != nil {
+			return err
+		}
+		if got.String() != wantType.String() {
+			return fmt.Errorf("parameter %s is %s, but the interface declares %s", method.Params[i].Name, got.String(), wantType.String())
+		}
+	}
+	return nil
+}
+
+// methodOf returns the function implementing a method for a type, or nil
+// if none of the type's impls has it. A method name that two implemented
+// interfaces share is ambiguous and must be called as Interface.method(value).
+func (a *Analyzer) methodOf(t ir.Type, method string) (*FuncSymbol, error) {
+	typeName := t.String()
+	found := make(map[string]*FuncSymbol)
+	for scope := a.currentScope; scope != nil; scope = scope.parent {
+		for _, sym := range scope.symbols {
+			impl, ok := sym.(*ImplSymbol)
+			if !ok || impl.TypeName != typeName {
+				continue
+			}
+			if fn, ok := impl.Methods[method]; ok {
+				found[impl.InterfaceName] = fn
+			}
+		}
+	}
+	if len(found) <= 1 {
+		for _, fn := range found {
+			return fn, nil
+		}
+		return nil, nil
+	}
+	ifaces := make([]string, 0, len(found))
+	for name := range found {
+		ifaces = append(ifaces, name)
+	}
+	sort.Strings(ifaces)
+	return nil, fmt.Errorf("method %s of %s is ambiguous: both %s implement it; call it as %s.%s(value)",
+		method, typeName, strings.Join(ifaces, " and "), ifaces[0], method)
+}
+
+// receiverMethod resolves value.method() from the static type of the
+// receiver. It returns nil if the receiver is not a value or its type has
+// no such method.
+func (a *Analyzer) receiverMethod(field *ast.FieldExpr) (*FuncSymbol, error) {
+	if id, ok := field.Object.(*ast.Identifier); ok {
+		if _, isVar := a.currentScope.Lookup(id.Name).(*VarSymbol); !isVar {
+			// A type, module or interface name
+			return nil, nil
+		}
+	}
+	recvType, err := a.inferType(field.Object)
+	if err != nil {
+		return nil, nil
+	}
+	return a.methodOf(recvType, field.Field)
+}
+
+// interfaceCall resolves Interface.method(value, ...) to the method
+// implemented for the type of value. It reports false when the call is not
+// on an interface.
+func (a *Analyzer) interfaceCall(call *ast.CallExpr, field *ast.FieldExpr) (*FuncSymbol, bool, error) {
+	id, ok := field.Object.(*ast.Identifier)
+	if !ok {
+		return nil, false, nil
+	}
+	iface, ok := a.currentScope.Lookup(id.Name).(*InterfaceSymbol)
+	if !ok {
+		return nil, false, nil
+	}
+	if _, ok := iface.Methods[field.Field]; !ok {
+		return nil, true, fmt.Errorf("interface %s has no method %s", id.Name, field.Field)
+	}
+	if len(call.Arguments) == 0 {
+		return nil, true, fmt.Errorf("%s.%s needs the value to call it on as its first argument", id.Name, field.Field)
+	}
+	recvType, err := a.inferType(call.Arguments[0])
+	if err != nil {
+		return nil, true, err
+	}
+	impl, ok := a.currentScope.Lookup(implSymbolKey(id.Name, recvType)).(*ImplSymbol)
+	if !ok {
+		return nil, true, fmt.Errorf("%s does not implement interface %s", recvType.String(), id.Name)
+	}
+	return impl.Methods[field.Field], true, nil
+}
+
+// checkBounds checks that the types bound to a generic function's type
+// parameters implement the interfaces the parameters require
+func (a *Analyzer) checkBounds(fn *ast.FunctionDecl, bindings map[string]ir.Type) error {
+	for _, param := range fn.GenericParams {
+		for _, bound := range param.Bounds {
+			if _, ok := a.currentScope.Lookup(bound).(*InterfaceSymbol); !ok {
+				return fmt.Errorf("bound %s of type parameter %s is not an interface", bound, param.Name)
+			}
+			if t := bindings[param.Name]; !a.implements(t, bound) {
+				return fmt.Errorf("%s does not implement interface %s, required by type parameter %s of %s",
+					t.String(), bound, param.Name, fn.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package semantic
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// shapesProgram builds, with main above the impls it calls:
+//
+//	struct Circle { r: u8 }
+//	struct Rect { w: u8, h: u8 }
+//	interface Shape { area(self) -> u16; }
+//	fun twice<T: Shape>(s: T) -> u16 { return s.area() * 2; }
+//	fun main() -> void { <body> }
+//	impl Shape for Circle { fun area(self) -> u16 { return 3; } }
+//	impl Shape for Rect { fun area(self) -> u16 { return 12; } }
+func shapesProgram(body ...ast.Statement) *ast.File {
+	u8 := &ast.PrimitiveType{Name: "u8"}
+	u16 := &ast.PrimitiveType{Name: "u16"}
+	impl := func(typeName string, area int64) *ast.ImplBlock {
+		return &ast.ImplBlock{
+			InterfaceName: "Shape",
+			ForType:       &ast.TypeIdentifier{Name: typeName},
+			Methods: []*ast.FunctionDecl{{
+				Name:       "area",
+				Params:     []*ast.Parameter{{Name: "self", IsSelf: true}},
+				ReturnType: u16,
+				Body: &ast.BlockStmt{Statements: []ast.Statement{
+					&ast.ReturnStmt{Value: number(area)},
+				}},
+			}},
+		}
+	}
+	return &ast.File{
+		Name: "shapes.minz",
+		Declarations: []ast.Declaration{
+			&ast.StructDecl{Name: "Circle", Fields: []*ast.Field{{Name: "r", Type: u8}}},
+			&ast.StructDecl{Name: "Rect", Fields: []*ast.Field{{Name: "w", Type: u8}, {Name: "h", Type: u8}}},
+			&ast.InterfaceDecl{
+				Name: "Shape",
+				Methods: []*ast.InterfaceMethod{{
+					Name:       "area",
+					Params:     []*ast.Parameter{{Name: "self", IsSelf: true}},
+					ReturnType: u16,
+				}},
+			},
+			&ast.FunctionDecl{
+				Name:          "twice",
+				GenericParams: []*ast.GenericParam{{Name: "T", Bounds: []string{"Shape"}}},
+				Params:        []*ast.Parameter{{Name: "s", Type: &ast.TypeIdentifier{Name: "T"}}},
+				ReturnType:    u16,
+				Body: &ast.BlockStmt{Statements: []ast.Statement{
+					&ast.ReturnStmt{Value: &ast.BinaryExpr{Left: methodCall("s", "area"), Operator: "*", Right: number(2)}},
+				}},
+			},
+			&ast.FunctionDecl{
+				Name:       "main",
+				ReturnType: &ast.PrimitiveType{Name: "void"},
+				Body:       &ast.BlockStmt{Statements: body},
+			},
+			impl("Circle", 3),
+			impl("Rect", 12),
+		},
+	}
+}
+
+// methodCall builds object.method()
+func methodCall(object, method string, args ...ast.Expression) *ast.CallExpr {
+	return &ast.CallExpr{Function: &ast.FieldExpr{Object: ident(object), Field: method}, Arguments: args}
+}
+
+// shapeLocals declares c = Circle { r: 2 } and r = Rect { w: 3, h: 4 }
+func shapeLocals() []ast.Statement {
+	return []ast.Statement{
+		&ast.VarDecl{Name: "c", Value: &ast.StructLiteral{TypeName: "Circle", Fields: []*ast.FieldInit{{Name: "r", Value: number(2)}}}},
+		&ast.VarDecl{Name: "r", Value: &ast.StructLiteral{TypeName: "Rect", Fields: []*ast.FieldInit{{Name: "w", Value: number(3)}, {Name: "h", Value: number(4)}}}},
+	}
+}
+
+// calls returns the functions each function of the module calls, by the
+// last part of its name
+func calls(module *ir.Module) map[string][]string {
+	out := make(map[string][]string)
+	for _, fn := range module.Functions {
+		name := fn.Name[strings.Index(fn.Name, ".")+1:]
+		for _, inst := range fn.Instructions {
+			if inst.Op == ir.OpCall {
+				out[name] = append(out[name], inst.Symbol[strings.Index(inst.Symbol, ".")+1:])
+			}
+		}
+	}
+	return out
+}
+
+func TestInterfaceStaticDispatch(t *testing.T) {
+	body := append(shapeLocals(),
+		&ast.VarDecl{Name: "a", Value: methodCall("c", "area")},
+		&ast.VarDecl{Name: "b", Value: methodCall("Shape", "area", ident("r"))},
+		&ast.VarDecl{Name: "d", Value: methodCall("Circle", "area", ident("c"))},
+		&ast.VarDecl{Name: "e", Value: &ast.CallExpr{Function: ident("twice"), Arguments: []ast.Expression{ident("c")}}},
+		&ast.VarDecl{Name: "f", Value: &ast.CallExpr{Function: ident("twice"), Arguments: []ast.Expression{ident("r")}}},
+	)
+	module, err := NewAnalyzer().Analyze(shapesProgram(body...))
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	for _, fn := range module.Functions {
+		for _, inst := range fn.Instructions {
+			if inst.Op == ir.OpCallIndirect {
+				t.Errorf("%s dispatches indirectly: %s", fn.Name, inst.String())
+			}
+		}
+	}
+
+	got := calls(module)
+	want := map[string]string{
+		"main":                "Circle.area$Circle Rect.area$Rect Circle.area$Circle twice$shapes_Circle twice$shapes_Rect",
+		"twice$shapes_Circle": "Circle.area$Circle",
+		"twice$shapes_Rect":   "Rect.area$Rect",
+	}
+	var names []string
+	for name := range want {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if calls := strings.Join(got[name], " "); calls != want[name] {
+			t.Errorf("%s calls %q, want %q", name, calls, want[name])
+		}
+	}
+}
+
+func TestInterfaceErrors(t *testing.T) {
+	square := &ast.StructDecl{Name: "Square", Fields: []*ast.Field{{Name: "side", Type: &ast.PrimitiveType{Name: "u8"}}}}
+	tests := []struct {
+		name string
+		edit func(file *ast.File)
+		body []ast.Statement
+		want string
+	}{
+		{
+			name: "unsatisfied bound",
+			edit: func(file *ast.File) { file.Declarations = append([]ast.Declaration{square}, file.Declarations...) },
+			body: []ast.Statement{
+				&ast.VarDecl{Name: "s", Value: &ast.StructLiteral{TypeName: "Square", Fields: []*ast.FieldInit{{Name: "side", Value: number(1)}}}},
+				&ast.VarDecl{Name: "a", Value: &ast.CallExpr{Function: ident("twice"), Arguments: []ast.Expression{ident("s")}}},
+			},
+			want: "Square does not implement interface Shape, required by type parameter T of twice",
+		},
+		{
+			name: "interface as a type",
+			edit: func(file *ast.File) {
+				file.Declarations = append(file.Declarations, &ast.FunctionDecl{
+					Name:       "any",
+					Params:     []*ast.Parameter{{Name: "s", Type: &ast.TypeIdentifier{Name: "Shape"}}},
+					ReturnType: &ast.PrimitiveType{Name: "void"},
+					Body:       &ast.BlockStmt{},
+				})
+			},
+			want: "interface Shape is not a type: take a generic parameter <T: Shape> instead",
+		},
+		{
+			name: "wrong return type",
+			edit: func(file *ast.File) {
+				impl := file.Declarations[len(file.Declarations)-1].(*ast.ImplBlock)
+				impl.Methods[0].ReturnType = &ast.PrimitiveType{Name: "u8"}
+			},
+			want: "method area of Shape for shapes.Rect: returns u8, but the interface declares u16",
+		},
+		{
+			name: "implemented twice",
+			edit: func(file *ast.File) {
+				file.Declarations = append(file.Declarations, file.Declarations[len(file.Declarations)-1])
+			},
+			want: "interface Shape is already implemented for shapes.Rect",
+		},
+		{
+			name: "no impl for Interface.method",
+			edit: func(file *ast.File) { file.Declarations = append([]ast.Declaration{square}, file.Declarations...) },
+			body: []ast.Statement{
+				&ast.VarDecl{Name: "s", Value: &ast.StructLiteral{TypeName: "Square", Fields: []*ast.FieldInit{{Name: "side", Value: number(1)}}}},
+				&ast.VarDecl{Name: "a", Value: methodCall("Shape", "area", ident("s"))},
+			},
+			want: "shapes.Square does not implement interface Shape",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := shapesProgram(tt.body...)
+			tt.edit(file)
+			_, err := NewAnalyzer().Analyze(file)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
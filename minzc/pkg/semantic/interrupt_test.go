@@ -0,0 +1,62 @@
+package semantic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// interruptHandler builds @interrupt(im2) fun name() -> void {}
+func interruptHandler(name string) *ast.FunctionDecl {
+	return &ast.FunctionDecl{
+		Name:       name,
+		ReturnType: &ast.PrimitiveType{Name: "void"},
+		Body:       &ast.BlockStmt{},
+		Attributes: []*ast.Attribute{{Name: "interrupt", Arguments: []ast.Expression{&ast.Identifier{Name: "im2"}}}},
+	}
+}
+
+func TestInterruptIM2(t *testing.T) {
+	main := &ast.FunctionDecl{Name: "main", ReturnType: &ast.PrimitiveType{Name: "void"}, Body: &ast.BlockStmt{}}
+	module, err := NewAnalyzer().Analyze(&ast.File{
+		Name:         "frame.minz",
+		Declarations: []ast.Declaration{interruptHandler("on_frame"), main},
+	})
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	var handler *ir.Function
+	for _, fn := range module.Functions {
+		if strings.HasSuffix(fn.Name, "on_frame") {
+			handler = fn
+		}
+	}
+	if handler == nil || !handler.IsInterrupt || handler.InterruptMode != 2 {
+		t.Fatalf("on_frame = %+v, want an IM2 interrupt handler", handler)
+	}
+
+	withParam := interruptHandler("tick")
+	withParam.Params = []*ast.Parameter{{Name: "n", Type: &ast.PrimitiveType{Name: "u8"}}}
+	withResult := interruptHandler("tick")
+	withResult.ReturnType = &ast.PrimitiveType{Name: "u8"}
+	badMode := interruptHandler("tick")
+	badMode.Attributes[0].Arguments = []ast.Expression{&ast.Identifier{Name: "im1"}}
+	for _, tt := range []struct {
+		name  string
+		decls []ast.Declaration
+		want  string
+	}{
+		{"parameters", []ast.Declaration{withParam}, "interrupt handler cannot take parameters"},
+		{"result", []ast.Declaration{withResult}, "interrupt handler must return void"},
+		{"mode", []ast.Declaration{badMode}, "@interrupt takes no argument or im2"},
+		{"second handler", []ast.Declaration{interruptHandler("on_frame"), interruptHandler("tick")}, "is already the @interrupt(im2) handler"},
+	} {
+		file := &ast.File{Name: "frame.minz", Declarations: append(tt.decls, main)}
+		_, err := NewAnalyzer().Analyze(file)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
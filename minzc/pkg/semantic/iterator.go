@@ -64,15 +64,26 @@ func (a *Analyzer) analyzeIteratorChainType(chain *ast.IteratorChainExpr) (ir.Ty
 		return nil, fmt.Errorf("type %s is not iterable", sourceType)
 	}
 	
-	// Track the current type through the chain
+	// Track the current type through the chain; after enumerate,
+	// functions up to the next map also take the index
 	currentType := elementType
+	var indexType ir.Type
 	
 	for _, op := range chain.Operations {
-		newType, err := a.analyzeIteratorOpType(op, currentType)
+		newType, err := a.analyzeIteratorOpType(op, currentType, indexType)
 		if err != nil {
 			return nil, err
 		}
 		currentType = newType
+		if op.Type == ast.IterOpMap {
+			indexType = nil
+		}
+		if op.Type == ast.IterOpEnumerate {
+			indexType = &ir.BasicType{Kind: ir.TypeU8}
+			if arr, ok := sourceType.(*ir.ArrayType); ok && arr.Length > 255 {
+				indexType = &ir.BasicType{Kind: ir.TypeU16}
+			}
+		}
 	}
 	
 	// The final type depends on the last operation
@@ -88,6 +99,9 @@ func (a *Analyzer) analyzeIteratorChainType(chain *ast.IteratorChainExpr) (ir.Ty
 		case ast.IterOpForEach:
 			// forEach returns void
 			return &ir.BasicType{Kind: ir.TypeVoid}, nil
+		case ast.IterOpFind:
+			// find returns the element it found
+			return currentType, nil
 		case ast.IterOpReduce:
 			// Reduce returns the accumulator type
 			// This would need more analysis of the reduce function
@@ -121,82 +135,49 @@ func (a *Analyzer) getIterableElementType(t ir.Type) ir.Type {
 	return nil
 }
 
-// analyzeIteratorOpType analyzes the result type of a single iterator operation
-func (a *Analyzer) analyzeIteratorOpType(op ast.IteratorOp, inputType ir.Type) (ir.Type, error) {
+// analyzeIteratorOpType analyzes the result type of a single iterator operation.
+// indexType is the type of the index functions also take after enumerate, or nil.
+func (a *Analyzer) analyzeIteratorOpType(op ast.IteratorOp, inputType, indexType ir.Type) (ir.Type, error) {
+	name := iteratorOpName(op.Type)
+	argTypes := []ir.Type{inputType}
+	if indexType != nil {
+		argTypes = []ir.Type{indexType, inputType}
+	}
+	
 	switch op.Type {
 	case ast.IterOpMap:
-		// Map transforms elements
-		// The function should be: fn(T) -> U
-		fnType, err := a.inferType(op.Function)
-		if err != nil {
-			return nil, err
+		// Map transforms elements: fn(T) -> U
+		if op.Function == nil {
+			return nil, fmt.Errorf("map requires a function")
 		}
+		return a.iteratorFunctionType(op.Function, argTypes)
 		
-		if funcType, ok := fnType.(*ir.FunctionType); ok {
-			// Verify input type matches
-			if len(funcType.Params) != 1 {
-				return nil, fmt.Errorf("map function must take exactly one parameter")
-			}
-			if !typesEqual(funcType.Params[0], inputType) {
-				return nil, fmt.Errorf("map function parameter type %s doesn't match element type %s",
-					funcType.Params[0], inputType)
-			}
-			// Return the output type
-			return funcType.Return, nil
+	case ast.IterOpFilter, ast.IterOpTakeWhile, ast.IterOpSkipWhile, ast.IterOpFind:
+		// Predicates: fn(T) -> bool, and the type stays the same
+		if op.Function == nil {
+			return nil, fmt.Errorf("%s requires a predicate function", name)
 		}
-		return nil, fmt.Errorf("map requires a function, got %s", fnType)
-		
-	case ast.IterOpFilter:
-		// Filter keeps/removes elements based on predicate
-		// The function should be: fn(T) -> bool
-		fnType, err := a.inferType(op.Function)
+		returnType, err := a.iteratorFunctionType(op.Function, argTypes)
 		if err != nil {
 			return nil, err
 		}
-		
-		if funcType, ok := fnType.(*ir.FunctionType); ok {
-			if len(funcType.Params) != 1 {
-				return nil, fmt.Errorf("filter function must take exactly one parameter")
-			}
-			if !typesEqual(funcType.Params[0], inputType) {
-				return nil, fmt.Errorf("filter function parameter type %s doesn't match element type %s",
-					funcType.Params[0], inputType)
-			}
-			if basicType, ok := funcType.Return.(*ir.BasicType); !ok || basicType.Kind != ir.TypeBool {
-				return nil, fmt.Errorf("filter function must return bool, got %s", funcType.Return)
-			}
-			// Filter doesn't change the type
-			return inputType, nil
+		if basicType, ok := returnType.(*ir.BasicType); !ok || basicType.Kind != ir.TypeBool {
+			return nil, fmt.Errorf("%s function must return bool, got %s", name, returnType)
 		}
-		return nil, fmt.Errorf("filter requires a function, got %s", fnType)
+		return inputType, nil
 		
-	case ast.IterOpForEach:
-		// forEach applies a function to each element
-		// The function should be: fn(T) -> void
-		fnType, err := a.inferType(op.Function)
-		if err != nil {
-			return nil, err
+	case ast.IterOpForEach, ast.IterOpPeek, ast.IterOpInspect:
+		// Side effects only: fn(T), and the type stays the same
+		if op.Function == nil {
+			return nil, fmt.Errorf("%s requires a function", name)
 		}
-		
-		if funcType, ok := fnType.(*ir.FunctionType); ok {
-			if len(funcType.Params) != 1 {
-				return nil, fmt.Errorf("forEach function must take exactly one parameter")
-			}
-			if !typesEqual(funcType.Params[0], inputType) {
-				return nil, fmt.Errorf("forEach function parameter type %s doesn't match element type %s",
-					funcType.Params[0], inputType)
-			}
-			// forEach consumes the iterator
-			return &ir.BasicType{Kind: ir.TypeVoid}, nil
+		if _, err := a.iteratorFunctionType(op.Function, argTypes); err != nil {
+			return nil, err
 		}
-		return nil, fmt.Errorf("forEach requires a function, got %s", fnType)
-		
-	case ast.IterOpTake:
-		// Take n elements - type stays the same
 		return inputType, nil
 		
-	case ast.IterOpSkip:
-		// Skip n elements - type stays the same
+	case ast.IterOpTake, ast.IterOpSkip:
+		// Take or skip n elements - type stays the same
 		return inputType, nil
 		
 	case ast.IterOpCollect:
@@ -204,8 +185,7 @@ func (a *Analyzer) analyzeIteratorOpType(op ast.IteratorOp, inputType ir.Type) (
 		return inputType, nil
 		
 	case ast.IterOpEnumerate:
-		// Enumerate returns tuples of (index, element)
-		// For now, we'll keep the element type (tuples not yet supported)
+		// Later functions take (index, element); the element type stays
 		return inputType, nil
 		
 	case ast.IterOpChain:
@@ -216,33 +196,20 @@ func (a *Analyzer) analyzeIteratorOpType(op ast.IteratorOp, inputType ir.Type) (
 		// FlatMap transforms and flattens
 		// The function should be: fn(T) -> [U]
 		// Result type is U (the element type of the returned array)
-		fnType, err := a.inferType(op.Function)
+		if op.Function == nil {
+			return nil, fmt.Errorf("flatMap requires a function")
+		}
+		returnType, err := a.iteratorFunctionType(op.Function, argTypes)
 		if err != nil {
 			return nil, err
 		}
-		
-		if funcType, ok := fnType.(*ir.FunctionType); ok {
-			if len(funcType.Params) != 1 {
-				return nil, fmt.Errorf("flatMap function must take exactly one parameter")
-			}
-			// Return type should be an array
-			if arrayType, ok := funcType.Return.(*ir.ArrayType); ok {
-				return arrayType.Element, nil
-			}
-			return nil, fmt.Errorf("flatMap function must return an array, got %s", funcType.Return)
+		if arrayType, ok := returnType.(*ir.ArrayType); ok {
+			return arrayType.Element, nil
 		}
-		return nil, fmt.Errorf("flatMap requires a function, got %s", fnType)
-		
-	case ast.IterOpTakeWhile, ast.IterOpSkipWhile:
-		// These take predicates and don't change the type
-		return inputType, nil
-		
-	case ast.IterOpPeek, ast.IterOpInspect:
-		// These are side-effect operations that don't change the type
-		return inputType, nil
+		return nil, fmt.Errorf("flatMap function must return an array, got %s", returnType)
 		
 	default:
-		return nil, fmt.Errorf("unsupported iterator operation: %v", op.Type)
+		return nil, fmt.Errorf("unsupported iterator operation: %s", name)
 	}
 }
 
@@ -251,7 +218,7 @@ func isIteratorMethod(name string) bool {
 	switch name {
 	case "iter", "map", "filter", "forEach", "reduce", "collect", 
 	     "take", "skip", "zip", "enumerate", "chain", "flatMap",
-	     "takeWhile", "skipWhile", "peek", "inspect":
+	     "takeWhile", "skipWhile", "peek", "inspect", "find":
 		return true
 	}
 	return false
@@ -261,290 +228,8 @@ func isIteratorMethod(name string) bool {
 func (a *Analyzer) generateArrayIteration(chain *ast.IteratorChainExpr, sourceReg ir.Register, 
 	arrayType *ir.ArrayType, elementType ir.Type, irFunc *ir.Function) (ir.Register, error) {
 	
-	// Check if we have enhanced operations that require special handling
-	hasEnhancedOps := false
-	for _, op := range chain.Operations {
-		switch op.Type {
-		case ast.IterOpSkip, ast.IterOpTake, ast.IterOpEnumerate,
-		     ast.IterOpChain, ast.IterOpFlatMap, ast.IterOpTakeWhile, 
-		     ast.IterOpSkipWhile, ast.IterOpPeek, ast.IterOpInspect:
-			hasEnhancedOps = true
-		}
-		if hasEnhancedOps {
-			break
-		}
-	}
-	
-	// Check if we can use DJNZ optimization (arrays ≤255 elements)
-	useDJNZ := arrayType.Length > 0 && arrayType.Length <= 255
-	
-	if useDJNZ && hasEnhancedOps {
-		// Use enhanced DJNZ generation for complex operations
-		return a.generateEnhancedDJNZIteration(chain, sourceReg, arrayType, elementType, irFunc)
-	} else if useDJNZ {
-		// Use standard DJNZ for simple operations
-		return a.generateDJNZIteration(chain, sourceReg, arrayType, elementType, irFunc)
-	}
-	
-	// Fall back to standard indexed iteration for large arrays
-	return a.generateIndexedIteration(chain, sourceReg, arrayType, elementType, irFunc)
-}
-
-// generateDJNZIteration generates optimized DJNZ loop for arrays ≤255 elements
-// This uses pointer arithmetic and DJNZ instruction for 3x performance
-func (a *Analyzer) generateDJNZIteration(chain *ast.IteratorChainExpr, sourceReg ir.Register,
-	arrayType *ir.ArrayType, elementType ir.Type, irFunc *ir.Function) (ir.Register, error) {
-	
-	// Add debug comment
-	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-		Op:      ir.OpNop,
-		Comment: fmt.Sprintf("DJNZ OPTIMIZED LOOP for array[%d]", arrayType.Length),
-	})
-	
-	// Generate labels for the loop
-	loopLabel := a.generateLabel("djnz_loop")
-	
-	// Allocate registers for DJNZ pattern
-	counterReg := irFunc.AllocReg()  // B register for DJNZ
-	ptrReg := irFunc.AllocReg()      // HL register for pointer
-	elementReg := irFunc.AllocReg()  // A register for element
-	
-	// Initialize counter (B register) with array length
-	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-		Op:   ir.OpLoadConst,
-		Dest: counterReg,
-		Imm:  int64(arrayType.Length),
-		Type: &ir.BasicType{Kind: ir.TypeU8},
-		Hint: ir.RegHintB, // Hint to use B register for DJNZ
-		Comment: fmt.Sprintf("DJNZ counter = %d", arrayType.Length),
-	})
-	
-	// Initialize pointer to array start
-	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-		Op:   ir.OpMove,
-		Dest: ptrReg,
-		Src1: sourceReg,
-		Type: &ir.PointerType{Base: elementType},
-		Hint: ir.RegHintHL, // Hint to use HL register pair
-		Comment: "Pointer to array start",
-	})
-	
-	// Loop start
-	irFunc.EmitLabel(loopLabel)
-	
-	// Load element through pointer
-	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-		Op:   ir.OpLoad,
-		Dest: elementReg,
-		Src1: ptrReg,
-		Type: elementType,
-		Comment: "Load element via pointer",
-	})
-	
-	// Apply iterator operations
-	currentReg := elementReg
-	var continueLabels []string
-	
-	for _, op := range chain.Operations {
-		// Handle filter operations specially - they need continue labels
-		if op.Type == ast.IterOpFilter {
-			// Call the filter predicate
-			predicateResult, err := a.applyIteratorFunction(op.Function, currentReg, elementType, irFunc)
-			if err != nil {
-				return 0, fmt.Errorf("failed to apply filter predicate: %w", err)
-			}
-			
-			// Generate continue label for this filter
-			continueLabel := a.generateLabel("filter_continue")
-			continueLabels = append(continueLabels, continueLabel)
-			
-			// Jump to continue if predicate is false
-			irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-				Op:    ir.OpJumpIfNot,
-				Src1:  predicateResult,
-				Label: continueLabel,
-				Comment: "Skip if filter predicate is false",
-			})
-			// Filter doesn't change the element value
-		} else {
-			// Apply other operations normally
-			newReg, err := a.applyIteratorOperation(op, currentReg, elementType, irFunc)
-			if err != nil {
-				return 0, fmt.Errorf("failed to apply iterator operation: %w", err)
-			}
-			currentReg = newReg
-		}
-	}
-	
-	// Emit continue labels for filters (right before loop increment)
-	for _, label := range continueLabels {
-		irFunc.EmitLabel(label)
-	}
-	
-	// Increment pointer to next element
-	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-		Op:   ir.OpInc,
-		Dest: ptrReg,
-		Src1: ptrReg,
-		Type: &ir.PointerType{Base: elementType},
-		Hint: ir.RegHintHL,
-		Comment: "Advance to next element",
-	})
-	
-	// DJNZ instruction - decrement counter and jump if not zero
-	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-		Op:    ir.OpDJNZ,
-		Src1:  counterReg,
-		Label: loopLabel,
-		Hint:  ir.RegHintB,
-		Comment: "DJNZ - decrement and loop",
-	})
-	
-	// No end label needed - DJNZ handles the exit condition
-	
-	// Return void register (iterators don't return values)
-	return 0, nil
-}
-
-// generateIndexedIteration generates standard indexed loop for large arrays
-func (a *Analyzer) generateIndexedIteration(chain *ast.IteratorChainExpr, sourceReg ir.Register,
-	arrayType *ir.ArrayType, elementType ir.Type, irFunc *ir.Function) (ir.Register, error) {
-	
-	// Generate labels for the loop
-	loopLabel := a.generateLabel("iter_loop")
-	endLabel := a.generateLabel("iter_end")
-	
-	// Allocate registers for iteration
-	indexReg := irFunc.AllocReg()
-	elementPtrReg := irFunc.AllocReg()
-	elementReg := irFunc.AllocReg()
-	lengthReg := irFunc.AllocReg()
-	
-	// Initialize index to 0
-	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-		Op:   ir.OpLoadConst,
-		Dest: indexReg,
-		Imm:  0,
-		Type: &ir.BasicType{Kind: ir.TypeU8},
-		Comment: "Iterator index = 0",
-	})
-	
-	// Load array length
-	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-		Op:   ir.OpLoadConst,
-		Dest: lengthReg,
-		Imm:  int64(arrayType.Length),
-		Type: &ir.BasicType{Kind: ir.TypeU8},
-		Comment: fmt.Sprintf("Array length = %d", arrayType.Length),
-	})
-	
-	// Loop start
-	irFunc.EmitLabel(loopLabel)
-	
-	// Check if index < length
-	condReg := irFunc.AllocReg()
-	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-		Op:   ir.OpLt,
-		Dest: condReg,
-		Src1: indexReg,
-		Src2: lengthReg,
-		Type: &ir.BasicType{Kind: ir.TypeBool},
-		Comment: "Check index < length",
-	})
-	
-	// Jump to end if done
-	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-		Op:    ir.OpJumpIfNot,
-		Src1:  condReg,
-		Label: endLabel,
-	})
-	
-	// Calculate element address: base + index * element_size
-	// For u8 arrays, element_size = 1
-	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-		Op:   ir.OpAdd,
-		Dest: elementPtrReg,
-		Src1: sourceReg,
-		Src2: indexReg,
-		Type: &ir.PointerType{Base: elementType},
-		Comment: "Calculate element address",
-	})
-	
-	// Load element value
-	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-		Op:   ir.OpLoad,
-		Dest: elementReg,
-		Src1: elementPtrReg,
-		Type: elementType,
-		Comment: "Load array element",
-	})
-	
-	// Apply iterator operations
-	currentReg := elementReg
-	var continueLabels []string
-	
-	for _, op := range chain.Operations {
-		// Handle filter operations specially - they need continue labels
-		if op.Type == ast.IterOpFilter {
-			// Call the filter predicate
-			predicateResult, err := a.applyIteratorFunction(op.Function, currentReg, elementType, irFunc)
-			if err != nil {
-				return 0, fmt.Errorf("failed to apply filter predicate: %w", err)
-			}
-			
-			// Generate continue label for this filter
-			continueLabel := a.generateLabel("filter_continue")
-			continueLabels = append(continueLabels, continueLabel)
-			
-			// Jump to continue if predicate is false
-			irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-				Op:    ir.OpJumpIfNot,
-				Src1:  predicateResult,
-				Label: continueLabel,
-				Comment: "Skip if filter predicate is false",
-			})
-			// Filter doesn't change the element value
-		} else {
-			// Apply other operations normally
-			newReg, err := a.applyIteratorOperation(op, currentReg, elementType, irFunc)
-			if err != nil {
-				return 0, fmt.Errorf("failed to apply iterator operation: %w", err)
-			}
-			currentReg = newReg
-		}
-	}
-	
-	// Emit continue labels for filters (right before loop increment)
-	for _, label := range continueLabels {
-		irFunc.EmitLabel(label)
-	}
-	
-	// Increment index
-	oneReg := irFunc.AllocReg()
-	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-		Op:   ir.OpLoadConst,
-		Dest: oneReg,
-		Imm:  1,
-		Type: &ir.BasicType{Kind: ir.TypeU8},
-	})
-	
-	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-		Op:   ir.OpAdd,
-		Dest: indexReg,
-		Src1: indexReg,
-		Src2: oneReg,
-		Type: &ir.BasicType{Kind: ir.TypeU8},
-		Comment: "Increment index",
-	})
-	
-	// Jump back to loop start
-	irFunc.EmitJump(loopLabel)
-	
-	// End label
-	irFunc.EmitLabel(endLabel)
-	
-	// Return void register (iterators don't return values)
-	return 0, nil
+	// Every chain over an array compiles to a single fused loop
+	return a.generateFusedIteration(chain, sourceReg, arrayType, irFunc)
 }
 
 // generateStringIteration generates code for iterating over a string
@@ -554,78 +239,6 @@ func (a *Analyzer) generateStringIteration(chain *ast.IteratorChainExpr, sourceR
 	return 0, fmt.Errorf("string iteration not yet implemented")
 }
 
-// applyIteratorOperation applies a single iterator operation to the current element
-func (a *Analyzer) applyIteratorOperation(op ast.IteratorOp, elementReg ir.Register, 
-	elementType ir.Type, irFunc *ir.Function) (ir.Register, error) {
-	
-	switch op.Type {
-	case ast.IterOpMap:
-		// Apply transformation function
-		if op.Function == nil {
-			return 0, fmt.Errorf("map requires a function")
-		}
-		return a.applyIteratorFunction(op.Function, elementReg, elementType, irFunc)
-		
-	case ast.IterOpFilter:
-		// Apply predicate and skip if false
-		if op.Function == nil {
-			return 0, fmt.Errorf("filter requires a predicate function")
-		}
-		
-		// Call the predicate function
-		predicateResult, err := a.applyIteratorFunction(op.Function, elementReg, elementType, irFunc)
-		if err != nil {
-			return 0, fmt.Errorf("failed to apply filter predicate: %w", err)
-		}
-		
-		// Generate continue label for this filter
-		continueLabel := a.generateLabel("filter_continue")
-		
-		// Jump to continue (skip rest of operations) if predicate is false
-		irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
-			Op:    ir.OpJumpIfNot,
-			Src1:  predicateResult,
-			Label: continueLabel,
-			Comment: "Skip if filter predicate is false",
-		})
-		
-		// Note: We need to emit the continue label at the end of the loop body
-		// For now, we'll store it and the caller will handle it
-		// This is a limitation - we need better control flow handling
-		
-		// Return the element register unchanged (filter doesn't transform the value)
-		return elementReg, nil
-		
-	case ast.IterOpForEach:
-		// Apply function to each element
-		if op.Function == nil {
-			return 0, fmt.Errorf("forEach requires a function")
-		}
-		_, err := a.applyIteratorFunction(op.Function, elementReg, elementType, irFunc)
-		return elementReg, err
-		
-	case ast.IterOpTake, ast.IterOpSkip:
-		// These operations are handled at the loop level, not per-element
-		// Just pass through the element unchanged
-		return elementReg, nil
-		
-	case ast.IterOpEnumerate:
-		// Enumerate adds index tracking - handled at loop level
-		return elementReg, nil
-		
-	case ast.IterOpPeek, ast.IterOpInspect:
-		// Call function but don't change element
-		if op.Function == nil {
-			return 0, fmt.Errorf("%v requires a function", op.Type)
-		}
-		_, err := a.applyIteratorFunction(op.Function, elementReg, elementType, irFunc)
-		return elementReg, err
-		
-	default:
-		return 0, fmt.Errorf("iterator operation %v not yet implemented", op.Type)
-	}
-}
-
 // applyIteratorFunction applies a function to an element in an iterator chain
 func (a *Analyzer) applyIteratorFunction(function ast.Expression, elementReg ir.Register,
 	elementType ir.Type, irFunc *ir.Function) (ir.Register, error) {
@@ -668,8 +281,13 @@ func (a *Analyzer) applyIteratorFunction(function ast.Expression, elementReg ir.
 		return resultReg, nil
 		
 	case *ast.LambdaExpr:
-		// Lambda expression - generate as separate function and call it
-		return a.generateIteratorLambda(function.(*ast.LambdaExpr), elementReg, elementType, irFunc)
+		// Lambda expression - specialize it into the loop body when possible,
+		// otherwise generate it as a separate function and call it
+		lambda := function.(*ast.LambdaExpr)
+		if canInlineIteratorLambda(lambda, 1) {
+			return a.inlineIteratorLambda(lambda, []ir.Register{elementReg}, []ir.Type{elementType}, irFunc)
+		}
+		return a.generateIteratorLambda(lambda, elementReg, elementType, irFunc)
 		
 	default:
 		return 0, fmt.Errorf("unsupported function type in iterator: %T", function)
@@ -728,6 +346,8 @@ func (a *Analyzer) transformIteratorMethodCall(object ast.Expression, method str
 		opType = ast.IterOpPeek
 	case "inspect":
 		opType = ast.IterOpInspect
+	case "find":
+		opType = ast.IterOpFind
 	default:
 		return nil, fmt.Errorf("unknown iterator method: %s", method)
 	}
@@ -746,6 +366,137 @@ func (a *Analyzer) transformIteratorMethodCall(object ast.Expression, method str
 	return chain, nil
 }
 
+// canInlineIteratorLambda reports whether a lambda taking arity arguments can be
+// specialized into the loop body. Block bodies qualify unless they return before
+// their last statement, since an early return would leave the enclosing function
+// instead of the lambda.
+func canInlineIteratorLambda(lambda *ast.LambdaExpr, arity int) bool {
+	if len(lambda.Params) != arity {
+		return false
+	}
+	block, ok := lambda.Body.(*ast.BlockStmt)
+	if !ok {
+		return true
+	}
+	for i, stmt := range block.Statements {
+		if _, isReturn := stmt.(*ast.ReturnStmt); isReturn && i == len(block.Statements)-1 {
+			continue
+		}
+		if containsReturn(stmt) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsReturn reports whether a statement is or contains a return
+func containsReturn(stmt ast.Statement) bool {
+	switch s := stmt.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.BlockStmt:
+		for _, inner := range s.Statements {
+			if containsReturn(inner) {
+				return true
+			}
+		}
+	case *ast.IfStmt:
+		if s.Then != nil && containsReturn(s.Then) {
+			return true
+		}
+		return s.Else != nil && containsReturn(s.Else)
+	case *ast.WhileStmt:
+		return s.Body != nil && containsReturn(s.Body)
+	case *ast.ForStmt:
+		return s.Body != nil && containsReturn(s.Body)
+	case *ast.LoopStmt:
+		return s.Body != nil && containsReturn(s.Body)
+	case *ast.DeferStmt:
+		return s.Body != nil && containsReturn(s.Body)
+	}
+	return false
+}
+
+// inlineIteratorLambda specializes a lambda into the enclosing loop body.
+// Each parameter becomes a local bound to its argument and the body is
+// analyzed in place, so the fused loop has no closure and no CALL.
+func (a *Analyzer) inlineIteratorLambda(lambda *ast.LambdaExpr, args []ir.Register,
+	argTypes []ir.Type, irFunc *ir.Function) (ir.Register, error) {
+	
+	prevScope := a.currentScope
+	a.currentScope = NewScope(prevScope)
+	defer func() { a.currentScope = prevScope }()
+	
+	for i, param := range lambda.Params {
+		var paramType ir.Type = argTypes[i]
+		if param.Type != nil {
+			var err error
+			paramType, err = a.convertType(param.Type)
+			if err != nil {
+				return 0, fmt.Errorf("failed to convert lambda parameter type: %w", err)
+			}
+			if !a.typesCompatible(argTypes[i], paramType) {
+				return 0, fmt.Errorf("lambda parameter type %s incompatible with element type %s",
+					paramType, argTypes[i])
+			}
+		}
+		
+		// Each specialization gets its own local so chained lambdas
+		// reusing a parameter name don't share storage
+		localName := fmt.Sprintf("%s_lambda%d", param.Name, a.lambdaCounter)
+		a.lambdaCounter++
+		paramReg := irFunc.AddLocal(localName, paramType)
+		irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+			Op:      ir.OpStoreVar,
+			Dest:    paramReg,
+			Src1:    args[i],
+			Symbol:  localName,
+			Type:    paramType,
+			Comment: fmt.Sprintf("Inline lambda: %s = argument %d", param.Name, i),
+		})
+		a.currentScope.Define(param.Name, &VarSymbol{
+			Name: localName,
+			Type: paramType,
+			Reg:  paramReg,
+		})
+	}
+	
+	switch body := lambda.Body.(type) {
+	case *ast.BlockStmt:
+		stmts := body.Statements
+		var result ast.Expression
+		if n := len(stmts); n > 0 {
+			if ret, ok := stmts[n-1].(*ast.ReturnStmt); ok {
+				result = ret.Value
+				stmts = stmts[:n-1]
+			}
+		}
+		for _, stmt := range stmts {
+			if err := a.analyzeStatement(stmt, irFunc); err != nil {
+				return 0, fmt.Errorf("failed to inline iterator lambda block: %w", err)
+			}
+		}
+		if result == nil {
+			return 0, nil
+		}
+		resultReg, err := a.analyzeExpression(result, irFunc)
+		if err != nil {
+			return 0, fmt.Errorf("failed to inline iterator lambda result: %w", err)
+		}
+		return resultReg, nil
+		
+	case ast.Expression:
+		resultReg, err := a.analyzeExpression(body, irFunc)
+		if err != nil {
+			return 0, fmt.Errorf("failed to inline iterator lambda expression: %w", err)
+		}
+		return resultReg, nil
+		
+	default:
+		return 0, fmt.Errorf("unsupported lambda body type: %T", body)
+	}
+}
+
 // generateIteratorLambda converts a lambda expression to a function and calls it
 // This enables zero-cost lambda abstractions in iterator chains
 func (a *Analyzer) generateIteratorLambda(lambda *ast.LambdaExpr, elementReg ir.Register, 
@@ -886,9 +637,6 @@ func (a *Analyzer) generateIteratorLambda(lambda *ast.LambdaExpr, elementReg ir.
 // inferIteratorLambdaReturnType attempts to infer the return type of a lambda body
 func (a *Analyzer) inferIteratorLambdaReturnType(body ast.Node) (ir.Type, error) {
 	switch body := body.(type) {
-	case ast.Expression:
-		// Try to infer the expression type
-		return a.inferType(body)
 	case *ast.BlockStmt:
 		// Look for return statements or final expression
 		if len(body.Statements) > 0 {
@@ -902,6 +650,9 @@ func (a *Analyzer) inferIteratorLambdaReturnType(body ast.Node) (ir.Type, error)
 		}
 		// Default to void for blocks without explicit return
 		return &ir.BasicType{Kind: ir.TypeVoid}, nil
+	case ast.Expression:
+		// Try to infer the expression type
+		return a.inferType(body)
 	default:
 		return nil, fmt.Errorf("cannot infer return type for lambda body type %T", body)
 	}
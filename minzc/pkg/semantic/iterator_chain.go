@@ -95,15 +95,7 @@ func (a *Analyzer) generateChainedIteration(chain *ast.IteratorChainExpr, firstS
 func (a *Analyzer) generateArrayIterationChain(chain *ast.IteratorChainExpr, sourceReg ir.Register,
 	arrayType *ir.ArrayType, elementType ir.Type, irFunc *ir.Function) error {
 	
-	// Determine if we can use DJNZ
-	useDJNZ := arrayType.Length > 0 && arrayType.Length <= 255
-	
-	if useDJNZ {
-		_, err := a.generateDJNZIteration(chain, sourceReg, arrayType, elementType, irFunc)
-		return err
-	}
-	
-	_, err := a.generateIndexedIteration(chain, sourceReg, arrayType, elementType, irFunc)
+	_, err := a.generateFusedIteration(chain, sourceReg, arrayType, irFunc)
 	return err
 }
 
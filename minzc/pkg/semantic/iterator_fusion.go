@@ -0,0 +1,651 @@
+package semantic
+
+import (
+	"fmt"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// An iterator chain over a fixed-size array compiles to one loop, with
+// every operation fused into its body and lambdas specialized in place:
+//
+//	arr.iter().map(|x| { return x * 2; }).filter(|x| { return x > 4; }).forEach(print_u8);
+//
+//	    LD B, 5 ; LD HL, arr
+//	loop:
+//	    element = (HL); x = element * 2
+//	    if !(x > 4) goto next
+//	    print_u8(x)
+//	next:
+//	    INC HL ; DJNZ loop
+//	exit:
+//
+// Arrays of up to 255 elements count down in B with DJNZ; longer ones use
+// a 16-bit counter. An operation that drops an element jumps to next, and
+// one that ends the iteration early jumps to exit:
+//
+//	map(f)        element = f(element)
+//	filter(p)     next unless p(element)
+//	forEach(f)    f(element); also peek and inspect
+//	take(n)       exit after n elements
+//	skip(n)       next for the first n elements
+//	takeWhile(p)  exit unless p(element)
+//	skipWhile(p)  next while p(element) has held for every element so far
+//	enumerate()   functions up to the next map take (index, element)
+//	find(p)       the first element for which p holds, or 0; ends the chain
+//
+// A take or skip with a constant count at the start of the chain narrows
+// the range of the loop instead of counting at run time.
+
+// iteratorCall returns the iterator chain a method call chain such as
+// arr.iter().map(f).filter(p) stands for. The chain starts at .iter() on
+// something iterable.
+func (a *Analyzer) iteratorCall(call *ast.CallExpr) (*ast.IteratorChainExpr, bool) {
+	var calls []*ast.CallExpr
+	var expr ast.Expression = call
+	for {
+		c, ok := expr.(*ast.CallExpr)
+		if !ok {
+			return nil, false
+		}
+		field, ok := c.Function.(*ast.FieldExpr)
+		if !ok || field.IsDoubleColon || !isIteratorMethod(field.Field) {
+			return nil, false
+		}
+		if field.Field == "iter" {
+			if len(c.Arguments) != 0 {
+				return nil, false
+			}
+			sourceType, err := a.inferType(field.Object)
+			if err != nil || a.getIterableElementType(sourceType) == nil {
+				return nil, false
+			}
+			chain := &ast.IteratorChainExpr{Source: field.Object, StartPos: call.Pos(), EndPos: call.End()}
+			for i := len(calls) - 1; i >= 0; i-- {
+				method := calls[i].Function.(*ast.FieldExpr).Field
+				step, err := a.transformIteratorMethodCall(field.Object, method, calls[i].Arguments)
+				if err != nil || step == nil || len(step.Operations) != 1 {
+					return nil, false
+				}
+				op := step.Operations[0]
+				op.StartPos, op.EndPos = calls[i].Pos(), calls[i].End()
+				chain.Operations = append(chain.Operations, op)
+			}
+			return chain, true
+		}
+		calls = append(calls, c)
+		expr = field.Object
+	}
+}
+
+// fusedLoop is the state of one fused iterator loop. Values that outlive
+// a single operation live in locals named after the loop, so lambda
+// bodies are free to use every register.
+type fusedLoop struct {
+	name      string // Loop label, also the prefix of its locals
+	next      string // Label that goes on with the next element
+	exit      string // Label that leaves the loop
+	item      string // Local holding the current element
+	itemType  ir.Type
+	index     string // Local holding the enumerate index, or ""
+	indexType ir.Type
+	found     string // Local holding the result of find, or ""
+	foundType ir.Type
+}
+
+// local returns the name of one of the loop's locals
+func (l *fusedLoop) local(what string) string {
+	return l.name + "_" + what
+}
+
+// declareFusedLocal adds a loop local and stores its first value
+func declareFusedLocal(irFunc *ir.Function, name string, value ir.Register, typ ir.Type) {
+	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+		Op:     ir.OpStoreVar,
+		Dest:   irFunc.AddLocal(name, typ),
+		Src1:   value,
+		Symbol: name,
+		Type:   typ,
+	})
+}
+
+// storeFusedLocal stores to a loop local
+func storeFusedLocal(irFunc *ir.Function, name string, value ir.Register, typ ir.Type) {
+	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+		Op: ir.OpStoreVar, Src1: value, Symbol: name, Type: typ,
+	})
+}
+
+// loadFusedLocal loads a loop local into a new register
+func loadFusedLocal(irFunc *ir.Function, name string, typ ir.Type) ir.Register {
+	reg := irFunc.AllocReg()
+	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+		Op: ir.OpLoadVar, Dest: reg, Symbol: name, Type: typ,
+	})
+	return reg
+}
+
+// generateFusedIteration compiles an iterator chain over an array to a
+// single loop
+func (a *Analyzer) generateFusedIteration(chain *ast.IteratorChainExpr, sourceReg ir.Register,
+	arrayType *ir.ArrayType, irFunc *ir.Function) (ir.Register, error) {
+
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	u16 := &ir.BasicType{Kind: ir.TypeU16}
+	if arrayType.Length < 0 {
+		return 0, fmt.Errorf("cannot iterate over an array of unknown length")
+	}
+
+	// Leading constant skip and take narrow the range
+	ops := chain.Operations
+	start, count := 0, arrayType.Length
+	for len(ops) > 0 && (ops[0].Type == ast.IterOpSkip || ops[0].Type == ast.IterOpTake) {
+		n, ok := a.iteratorCount(ops[0])
+		if !ok {
+			break
+		}
+		if n > count {
+			n = count
+		}
+		if ops[0].Type == ast.IterOpSkip {
+			start += n
+			count -= n
+		} else {
+			count = n
+		}
+		ops = ops[1:]
+	}
+	for i, op := range ops {
+		if op.Type == ast.IterOpFind && i != len(ops)-1 {
+			return 0, fmt.Errorf("find must be the last operation of an iterator chain")
+		}
+	}
+
+	loop := &fusedLoop{name: a.generateLabel("iter_loop")}
+	loop.next = loop.name + "_next"
+	loop.exit = loop.name + "_exit"
+	loop.item, loop.itemType = loop.local("item"), arrayType.Element
+	loop.indexType = u8
+	if arrayType.Length > 255 {
+		loop.indexType = u16
+	}
+	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+		Op:      ir.OpNop,
+		Comment: fmt.Sprintf("FUSED ITERATOR LOOP: array[%d], %d elements from %d", arrayType.Length, count, start),
+	})
+
+	// State that lives across iterations: find's result, run-time take
+	// and skip counts, skipWhile flags and the enumerate count
+	state := make([]string, len(ops))
+	for i, op := range ops {
+		var value ir.Register
+		typ := ir.Type(u8)
+		switch op.Type {
+		case ast.IterOpTake, ast.IterOpSkip:
+			if op.Function == nil {
+				return 0, fmt.Errorf("%s requires a count", iteratorOpName(op.Type))
+			}
+			reg, err := a.analyzeExpression(op.Function, irFunc)
+			if err != nil {
+				return 0, fmt.Errorf("failed to analyze %s count: %w", iteratorOpName(op.Type), err)
+			}
+			value, typ = reg, loop.indexType
+		case ast.IterOpSkipWhile, ast.IterOpEnumerate, ast.IterOpFind:
+			imm := int64(0)
+			switch op.Type {
+			case ast.IterOpSkipWhile:
+				imm = 1 // Still skipping
+			case ast.IterOpEnumerate:
+				typ = loop.indexType
+			case ast.IterOpFind:
+				resultType, err := a.analyzeIteratorChainType(chain)
+				if err != nil {
+					return 0, err
+				}
+				typ = resultType
+			}
+			value = irFunc.AllocReg()
+			irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+				Op: ir.OpLoadConst, Dest: value, Imm: imm, Type: typ,
+			})
+		default:
+			continue
+		}
+		state[i] = loop.local(fmt.Sprintf("%s%d", iteratorOpName(op.Type), i))
+		declareFusedLocal(irFunc, state[i], value, typ)
+		if op.Type == ast.IterOpFind {
+			loop.found, loop.foundType = state[i], typ
+		}
+	}
+
+	if count > 0 {
+		if err := a.emitFusedLoop(loop, ops, state, sourceReg, start, count, irFunc); err != nil {
+			return 0, err
+		}
+	}
+	irFunc.EmitLabel(loop.exit)
+
+	if loop.found == "" {
+		return 0, nil
+	}
+	result := loadFusedLocal(irFunc, loop.found, loop.foundType)
+	irFunc.Instructions[len(irFunc.Instructions)-1].Comment = "Result of find"
+	return result, nil
+}
+
+// emitFusedLoop emits the loop over count elements from start
+func (a *Analyzer) emitFusedLoop(loop *fusedLoop, ops []ast.IteratorOp, state []string, sourceReg ir.Register,
+	start, count int, irFunc *ir.Function) error {
+
+	elementType := loop.itemType
+	ptrType := &ir.PointerType{Base: elementType}
+	ptr := loop.local("ptr")
+	counter := loop.local("count")
+	useDJNZ := count <= 255
+	counterType := ir.Type(&ir.BasicType{Kind: ir.TypeU8})
+	if !useDJNZ {
+		counterType = &ir.BasicType{Kind: ir.TypeU16}
+	}
+
+	counterReg := irFunc.AllocReg()
+	init := ir.Instruction{
+		Op:      ir.OpLoadConst,
+		Dest:    counterReg,
+		Imm:     int64(count),
+		Type:    counterType,
+		Comment: fmt.Sprintf("Loop counter = %d", count),
+	}
+	if useDJNZ {
+		init.Hint = ir.RegHintB
+		init.Comment = fmt.Sprintf("DJNZ counter = %d", count)
+	}
+	irFunc.Instructions = append(irFunc.Instructions, init)
+	if !useDJNZ {
+		declareFusedLocal(irFunc, counter, counterReg, counterType)
+	}
+
+	startReg := sourceReg
+	if start > 0 {
+		offsetReg := irFunc.AllocReg()
+		startReg = irFunc.AllocReg()
+		irFunc.Instructions = append(irFunc.Instructions,
+			ir.Instruction{
+				Op:      ir.OpLoadConst,
+				Dest:    offsetReg,
+				Imm:     int64(start * elementType.Size()),
+				Type:    &ir.BasicType{Kind: ir.TypeU16},
+				Comment: fmt.Sprintf("Skip %d elements", start),
+			},
+			ir.Instruction{
+				Op:   ir.OpAdd,
+				Dest: startReg,
+				Src1: sourceReg,
+				Src2: offsetReg,
+				Type: ptrType,
+			})
+	}
+	declareFusedLocal(irFunc, ptr, startReg, ptrType)
+	irFunc.Instructions[len(irFunc.Instructions)-1].Comment = "Pointer to the first element"
+
+	// DJNZ leaves the count in its register on the way back to the top,
+	// where it goes to the local until the next DJNZ
+	irFunc.EmitLabel(loop.name)
+	if useDJNZ {
+		declareFusedLocal(irFunc, counter, counterReg, counterType)
+	}
+	ptrReg := loadFusedLocal(irFunc, ptr, ptrType)
+	elementReg := irFunc.AllocReg()
+	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+		Op:      ir.OpLoad,
+		Dest:    elementReg,
+		Src1:    ptrReg,
+		Type:    elementType,
+		Comment: "Load element via pointer",
+	})
+	declareFusedLocal(irFunc, loop.item, elementReg, elementType)
+
+	for i, op := range ops {
+		if err := a.emitFusedOp(loop, i, op, state[i], irFunc); err != nil {
+			return err
+		}
+	}
+
+	irFunc.EmitLabel(loop.next)
+	ptrReg = loadFusedLocal(irFunc, ptr, ptrType)
+	nextReg := irFunc.AllocReg()
+	if size := elementType.Size(); size == 1 {
+		irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+			Op:      ir.OpInc,
+			Dest:    nextReg,
+			Src1:    ptrReg,
+			Type:    ptrType,
+			Comment: "Advance to next element",
+		})
+	} else {
+		sizeReg := irFunc.AllocReg()
+		irFunc.Instructions = append(irFunc.Instructions,
+			ir.Instruction{
+				Op:      ir.OpLoadConst,
+				Dest:    sizeReg,
+				Imm:     int64(size),
+				Type:    &ir.BasicType{Kind: ir.TypeU16},
+				Comment: fmt.Sprintf("Element size = %d", size),
+			},
+			ir.Instruction{
+				Op:      ir.OpAdd,
+				Dest:    nextReg,
+				Src1:    ptrReg,
+				Src2:    sizeReg,
+				Type:    ptrType,
+				Comment: "Advance to next element",
+			})
+	}
+	storeFusedLocal(irFunc, ptr, nextReg, ptrType)
+
+	if useDJNZ {
+		irFunc.Instructions = append(irFunc.Instructions,
+			ir.Instruction{Op: ir.OpLoadVar, Dest: counterReg, Symbol: counter, Type: counterType},
+			ir.Instruction{
+				Op:      ir.OpDJNZ,
+				Src1:    counterReg,
+				Label:   loop.name,
+				Hint:    ir.RegHintB,
+				Comment: "DJNZ - decrement and loop",
+			})
+		return nil
+	}
+	leftReg := loadFusedLocal(irFunc, counter, counterType)
+	decReg := irFunc.AllocReg()
+	zeroReg := irFunc.AllocReg()
+	moreReg := irFunc.AllocReg()
+	irFunc.Instructions = append(irFunc.Instructions,
+		ir.Instruction{Op: ir.OpDec, Dest: decReg, Src1: leftReg, Type: counterType},
+		ir.Instruction{Op: ir.OpStoreVar, Src1: decReg, Symbol: counter, Type: counterType},
+		ir.Instruction{Op: ir.OpLoadConst, Dest: zeroReg, Imm: 0, Type: counterType},
+		ir.Instruction{Op: ir.OpNe, Dest: moreReg, Src1: decReg, Src2: zeroReg, Type: &ir.BasicType{Kind: ir.TypeBool}},
+		ir.Instruction{Op: ir.OpJumpIf, Src1: moreReg, Label: loop.name, Comment: "Loop while elements remain"},
+	)
+	return nil
+}
+
+// emitFusedOp emits operation i of the loop body
+func (a *Analyzer) emitFusedOp(loop *fusedLoop, i int, op ast.IteratorOp, local string, irFunc *ir.Function) error {
+	name := iteratorOpName(op.Type)
+	u8 := &ir.BasicType{Kind: ir.TypeU8}
+	apply := func() (ir.Register, error) {
+		if op.Function == nil {
+			return 0, fmt.Errorf("%s requires a function", name)
+		}
+		reg, err := a.applyFusedFunction(loop, op.Function, irFunc)
+		if err != nil {
+			return 0, fmt.Errorf("failed to apply %s function: %w", name, err)
+		}
+		return reg, nil
+	}
+	jumpIf := func(op ir.Opcode, cond ir.Register, label, comment string) {
+		irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+			Op: op, Src1: cond, Label: label, Comment: comment,
+		})
+	}
+
+	switch op.Type {
+	case ast.IterOpMap:
+		resultType, err := a.fusedFunctionType(loop, op.Function)
+		if err != nil {
+			return err
+		}
+		reg, err := apply()
+		if err != nil {
+			return err
+		}
+		// The mapped element no longer comes with its index
+		loop.item, loop.itemType, loop.index = loop.local(fmt.Sprintf("map%d", i)), resultType, ""
+		declareFusedLocal(irFunc, loop.item, reg, resultType)
+
+	case ast.IterOpFilter, ast.IterOpTakeWhile:
+		cond, err := apply()
+		if err != nil {
+			return err
+		}
+		if op.Type == ast.IterOpFilter {
+			jumpIf(ir.OpJumpIfNot, cond, loop.next, "Skip if filter predicate is false")
+		} else {
+			jumpIf(ir.OpJumpIfNot, cond, loop.exit, "Exit loop if takeWhile predicate is false")
+		}
+
+	case ast.IterOpForEach, ast.IterOpPeek, ast.IterOpInspect:
+		if _, err := apply(); err != nil {
+			return err
+		}
+
+	case ast.IterOpTake, ast.IterOpSkip:
+		// take: exit once the count runs out, else count it down;
+		// skip: drop elements while counting down to 0
+		done := local + "_done"
+		countReg := loadFusedLocal(irFunc, local, loop.indexType)
+		zeroReg := irFunc.AllocReg()
+		condReg := irFunc.AllocReg()
+		irFunc.Instructions = append(irFunc.Instructions,
+			ir.Instruction{Op: ir.OpLoadConst, Dest: zeroReg, Imm: 0, Type: loop.indexType},
+			ir.Instruction{Op: ir.OpNe, Dest: condReg, Src1: countReg, Src2: zeroReg, Type: &ir.BasicType{Kind: ir.TypeBool}},
+		)
+		if op.Type == ast.IterOpTake {
+			jumpIf(ir.OpJumpIfNot, condReg, loop.exit, "Exit loop once take has its elements")
+		} else {
+			jumpIf(ir.OpJumpIfNot, condReg, done, "Pass on elements once skip is done")
+		}
+		decReg := irFunc.AllocReg()
+		irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+			Op: ir.OpDec, Dest: decReg, Src1: countReg, Type: loop.indexType,
+		})
+		storeFusedLocal(irFunc, local, decReg, loop.indexType)
+		if op.Type == ast.IterOpSkip {
+			irFunc.EmitJump(loop.next)
+			irFunc.EmitLabel(done)
+		}
+
+	case ast.IterOpSkipWhile:
+		// Once the predicate fails for one element, the rest all pass
+		done := local + "_done"
+		skipping := loadFusedLocal(irFunc, local, u8)
+		jumpIf(ir.OpJumpIfNot, skipping, done, "Pass on elements once skipWhile is done")
+		cond, err := apply()
+		if err != nil {
+			return err
+		}
+		jumpIf(ir.OpJumpIf, cond, loop.next, "Skip while the predicate holds")
+		zeroReg := irFunc.AllocReg()
+		irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+			Op: ir.OpLoadConst, Dest: zeroReg, Imm: 0, Type: u8,
+		})
+		storeFusedLocal(irFunc, local, zeroReg, u8)
+		irFunc.EmitLabel(done)
+
+	case ast.IterOpEnumerate:
+		// The index is the number of elements enumerate has passed on
+		loop.index = local + "_index"
+		countReg := loadFusedLocal(irFunc, local, loop.indexType)
+		declareFusedLocal(irFunc, loop.index, countReg, loop.indexType)
+		nextReg := irFunc.AllocReg()
+		irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+			Op: ir.OpInc, Dest: nextReg, Src1: countReg, Type: loop.indexType,
+		})
+		storeFusedLocal(irFunc, local, nextReg, loop.indexType)
+
+	case ast.IterOpFind:
+		cond, err := apply()
+		if err != nil {
+			return err
+		}
+		jumpIf(ir.OpJumpIfNot, cond, loop.next, "Keep looking if the predicate is false")
+		found := loadFusedLocal(irFunc, loop.item, loop.itemType)
+		storeFusedLocal(irFunc, local, found, loop.itemType)
+		irFunc.EmitJump(loop.exit)
+
+	default:
+		return fmt.Errorf("%s is not supported in a fused iterator chain", name)
+	}
+	return nil
+}
+
+// applyFusedFunction applies an iterator function to the current element,
+// and to its index after enumerate
+func (a *Analyzer) applyFusedFunction(loop *fusedLoop, function ast.Expression, irFunc *ir.Function) (ir.Register, error) {
+	elementReg := loadFusedLocal(irFunc, loop.item, loop.itemType)
+	if loop.index == "" {
+		return a.applyIteratorFunction(function, elementReg, loop.itemType, irFunc)
+	}
+	indexReg := loadFusedLocal(irFunc, loop.index, loop.indexType)
+	args := []ir.Register{indexReg, elementReg}
+	argTypes := []ir.Type{loop.indexType, loop.itemType}
+	switch fn := function.(type) {
+	case *ast.LambdaExpr:
+		if !canInlineIteratorLambda(fn, 2) {
+			return 0, fmt.Errorf("a lambda after enumerate must take (index, element) and cannot return early")
+		}
+		return a.inlineIteratorLambda(fn, args, argTypes, irFunc)
+	case *ast.Identifier:
+		sym, ok := a.lookupFunction(fn.Name).(*FuncSymbol)
+		if !ok {
+			return 0, fmt.Errorf("undefined function: %s", fn.Name)
+		}
+		if len(sym.Params) != 2 {
+			return 0, fmt.Errorf("%s must take (index, element) after enumerate", fn.Name)
+		}
+		resultReg := irFunc.AllocReg()
+		irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+			Op:      ir.OpCall,
+			Dest:    resultReg,
+			Symbol:  sym.Name,
+			Args:    args,
+			Type:    sym.ReturnType,
+			Comment: fmt.Sprintf("Call %s", sym.Name),
+		})
+		return resultReg, nil
+	}
+	return 0, fmt.Errorf("unsupported function type in iterator: %T", function)
+}
+
+// fusedFunctionType returns the result type of an iterator function
+// applied at this point of the loop
+func (a *Analyzer) fusedFunctionType(loop *fusedLoop, function ast.Expression) (ir.Type, error) {
+	if loop.index == "" {
+		return a.iteratorFunctionType(function, []ir.Type{loop.itemType})
+	}
+	return a.iteratorFunctionType(function, []ir.Type{loop.indexType, loop.itemType})
+}
+
+// lookupFunction looks a function up by its source or module-prefixed name
+func (a *Analyzer) lookupFunction(name string) Symbol {
+	if sym := a.currentScope.Lookup(name); sym != nil {
+		return sym
+	}
+	return a.currentScope.Lookup(a.prefixSymbol(name))
+}
+
+// iteratorFunctionType returns the result type of a lambda or named
+// function applied to arguments of argTypes
+func (a *Analyzer) iteratorFunctionType(function ast.Expression, argTypes []ir.Type) (ir.Type, error) {
+	switch fn := function.(type) {
+	case *ast.LambdaExpr:
+		if fn.ReturnType != nil {
+			return a.convertType(fn.ReturnType)
+		}
+		if len(fn.Params) != len(argTypes) {
+			return nil, fmt.Errorf("iterator lambda must take %d parameters, got %d", len(argTypes), len(fn.Params))
+		}
+		prevScope := a.currentScope
+		a.currentScope = NewScope(prevScope)
+		defer func() { a.currentScope = prevScope }()
+		for i, param := range fn.Params {
+			paramType := argTypes[i]
+			if param.Type != nil {
+				t, err := a.convertType(param.Type)
+				if err != nil {
+					return nil, err
+				}
+				paramType = t
+			}
+			a.currentScope.Define(param.Name, &VarSymbol{Name: param.Name, Type: paramType})
+		}
+		return a.inferIteratorLambdaReturnType(fn.Body)
+	case *ast.Identifier:
+		if len(argTypes) == 1 {
+			if sym, err := a.resolveIteratorFunction(fn, argTypes[0], nil); err == nil {
+				return sym.ReturnType, nil
+			}
+		}
+		if sym, ok := a.lookupFunction(fn.Name).(*FuncSymbol); ok {
+			return sym.ReturnType, nil
+		}
+		if fn.Name == "print_u8" || fn.Name == "print_u16" {
+			return &ir.BasicType{Kind: ir.TypeVoid}, nil
+		}
+		return nil, fmt.Errorf("undefined function: %s", fn.Name)
+	}
+	return nil, fmt.Errorf("unsupported function type in iterator: %T", function)
+}
+
+// iteratorCount returns the constant count of a take or skip
+func (a *Analyzer) iteratorCount(op ast.IteratorOp) (int, bool) {
+	if op.Function == nil {
+		return 0, false
+	}
+	value, err := a.evaluateConstExpr(op.Function)
+	if err != nil {
+		return 0, false
+	}
+	var n int64
+	switch v := value.(type) {
+	case int64:
+		n = v
+	case int:
+		n = int64(v)
+	default:
+		return 0, false
+	}
+	if n < 0 || n > 0xFFFF {
+		return 0, false
+	}
+	return int(n), true
+}
+
+// iteratorOpName returns the method name of an iterator operation
+func iteratorOpName(op ast.IteratorOpType) string {
+	switch op {
+	case ast.IterOpMap:
+		return "map"
+	case ast.IterOpFilter:
+		return "filter"
+	case ast.IterOpForEach:
+		return "forEach"
+	case ast.IterOpReduce:
+		return "reduce"
+	case ast.IterOpCollect:
+		return "collect"
+	case ast.IterOpTake:
+		return "take"
+	case ast.IterOpSkip:
+		return "skip"
+	case ast.IterOpZip:
+		return "zip"
+	case ast.IterOpEnumerate:
+		return "enumerate"
+	case ast.IterOpChain:
+		return "chain"
+	case ast.IterOpFlatMap:
+		return "flatMap"
+	case ast.IterOpTakeWhile:
+		return "takeWhile"
+	case ast.IterOpSkipWhile:
+		return "skipWhile"
+	case ast.IterOpPeek:
+		return "peek"
+	case ast.IterOpInspect:
+		return "inspect"
+	case ast.IterOpFind:
+		return "find"
+	}
+	return fmt.Sprintf("operation %d", op)
+}
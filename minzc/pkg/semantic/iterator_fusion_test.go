@@ -0,0 +1,346 @@
+package semantic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// iteratorChainProgram builds:
+//
+//	global arr: [u8; 5] = [1, 2, 3, 4, 5];
+//	fun main() -> void {
+//	    let mut total: u8 = 0;
+//	    arr.iter().map(|x| x * 2).filter(|x| x > 4).forEach(|x| { total = total + x; });
+//	}
+func iteratorChainProgram() *ast.File {
+	u8 := &ast.PrimitiveType{Name: "u8"}
+	x := func() ast.Expression { return &ast.Identifier{Name: "x"} }
+	num := func(v int64) ast.Expression { return &ast.NumberLiteral{Value: v} }
+	lambda := func(body ast.Node) *ast.LambdaExpr {
+		return &ast.LambdaExpr{Params: []*ast.LambdaParam{{Name: "x"}}, Body: body}
+	}
+
+	return &ast.File{
+		Name: "iter.minz",
+		Declarations: []ast.Declaration{
+			&ast.VarDecl{
+				Name:  "arr",
+				Type:  &ast.ArrayType{ElementType: u8, Size: num(5)},
+				Value: &ast.ArrayInitializer{Elements: []ast.Expression{num(1), num(2), num(3), num(4), num(5)}},
+			},
+			&ast.FunctionDecl{
+				Name:       "main",
+				ReturnType: &ast.PrimitiveType{Name: "void"},
+				Body: &ast.BlockStmt{Statements: []ast.Statement{
+					&ast.VarDecl{Name: "total", Type: u8, IsMutable: true, Value: num(0)},
+					&ast.ExpressionStmt{Expression: &ast.IteratorChainExpr{
+						Source: &ast.Identifier{Name: "arr"},
+						Operations: []ast.IteratorOp{
+							{Type: ast.IterOpMap, Function: lambda(&ast.BinaryExpr{Left: x(), Operator: "*", Right: num(2)})},
+							{Type: ast.IterOpFilter, Function: lambda(&ast.BinaryExpr{Left: x(), Operator: ">", Right: num(4)})},
+							{Type: ast.IterOpForEach, Function: lambda(&ast.BlockStmt{Statements: []ast.Statement{
+								&ast.AssignStmt{
+									Target: &ast.Identifier{Name: "total"},
+									Value:  &ast.BinaryExpr{Left: &ast.Identifier{Name: "total"}, Operator: "+", Right: x()},
+								},
+							}})},
+						},
+					}},
+				}},
+			},
+		},
+	}
+}
+
+// evalFusedLoop interprets the straight-line and DJNZ loop IR an iterator
+// chain lowers to, with source holding the array contents. It returns the
+// final values of named variables, and the returned value as "return".
+func evalFusedLoop(t *testing.T, fn *ir.Function, source []int64) map[string]int64 {
+	t.Helper()
+	regs := map[ir.Register]int64{}
+	vars := map[string]int64{}
+	labels := map[string]int{}
+	for i, inst := range fn.Instructions {
+		if inst.Op == ir.OpLabel {
+			labels[inst.Label] = i
+		}
+	}
+
+	for pc, steps := 0, 0; pc < len(fn.Instructions); pc++ {
+		if steps++; steps > 10000 {
+			t.Fatal("loop did not terminate")
+		}
+		inst := fn.Instructions[pc]
+		switch inst.Op {
+		case ir.OpNop, ir.OpLabel:
+		case ir.OpLoadAddr:
+			regs[inst.Dest] = 0 // Index into source
+		case ir.OpLoadConst:
+			regs[inst.Dest] = inst.Imm
+		case ir.OpMove:
+			regs[inst.Dest] = regs[inst.Src1]
+		case ir.OpLoad:
+			regs[inst.Dest] = source[regs[inst.Src1]]
+		case ir.OpInc:
+			regs[inst.Dest] = regs[inst.Src1] + 1
+		case ir.OpDec:
+			regs[inst.Dest] = regs[inst.Src1] - 1
+		case ir.OpLoadVar:
+			regs[inst.Dest] = vars[inst.Symbol]
+		case ir.OpStoreVar:
+			vars[inst.Symbol] = regs[inst.Src1]
+		case ir.OpAdd:
+			regs[inst.Dest] = regs[inst.Src1] + regs[inst.Src2]
+		case ir.OpMul:
+			regs[inst.Dest] = regs[inst.Src1] * regs[inst.Src2]
+		case ir.OpGt:
+			regs[inst.Dest] = 0
+			if regs[inst.Src1] > regs[inst.Src2] {
+				regs[inst.Dest] = 1
+			}
+		case ir.OpLt:
+			regs[inst.Dest] = 0
+			if regs[inst.Src1] < regs[inst.Src2] {
+				regs[inst.Dest] = 1
+			}
+		case ir.OpNe:
+			regs[inst.Dest] = 0
+			if regs[inst.Src1] != regs[inst.Src2] {
+				regs[inst.Dest] = 1
+			}
+		case ir.OpJump:
+			pc = labels[inst.Label]
+		case ir.OpJumpIf:
+			if regs[inst.Src1] != 0 {
+				pc = labels[inst.Label]
+			}
+		case ir.OpJumpIfNot:
+			if regs[inst.Src1] == 0 {
+				pc = labels[inst.Label]
+			}
+		case ir.OpDJNZ:
+			if regs[inst.Src1]--; regs[inst.Src1] != 0 {
+				pc = labels[inst.Label]
+			}
+		case ir.OpReturn:
+			if inst.Src1 != 0 {
+				vars["return"] = regs[inst.Src1]
+			}
+			return vars
+		default:
+			t.Fatalf("unexpected instruction in fused loop: %s", inst.String())
+		}
+	}
+	return vars
+}
+
+func TestIteratorChainFusesLambdas(t *testing.T) {
+	module, err := NewAnalyzer().Analyze(iteratorChainProgram())
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	var main *ir.Function
+	for _, fn := range module.Functions {
+		if strings.Contains(fn.Name, "lambda") {
+			t.Errorf("lambda was not specialized into the loop: %s generated", fn.Name)
+		}
+		if strings.HasSuffix(fn.Name, ".main") {
+			main = fn
+		}
+	}
+	if main == nil {
+		t.Fatal("main not generated")
+	}
+
+	loops := 0
+	for _, inst := range main.Instructions {
+		switch inst.Op {
+		case ir.OpCall:
+			t.Errorf("fused loop contains a call: %s", inst.String())
+		case ir.OpDJNZ, ir.OpJump:
+			loops++
+		}
+	}
+	if loops != 1 {
+		t.Errorf("expected a single loop, found %d back-edges", loops)
+	}
+
+	// map(x * 2) gives 2, 4, 6, 8, 10; filter(x > 4) keeps 6, 8, 10
+	vars := evalFusedLoop(t, main, []int64{1, 2, 3, 4, 5})
+	if vars["total"] != 24 {
+		t.Errorf("total = %d, want 24", vars["total"])
+	}
+}
+
+// fusedProgram builds a program over a global u8 array holding values
+// whose main runs body, declaring total first
+func fusedProgram(values []int64, totalType string, returnType string, body ...ast.Statement) *ast.File {
+	elements := make([]ast.Expression, len(values))
+	for i, v := range values {
+		elements[i] = &ast.NumberLiteral{Value: v}
+	}
+	statements := append([]ast.Statement{
+		&ast.VarDecl{Name: "total", Type: &ast.PrimitiveType{Name: totalType}, IsMutable: true, Value: &ast.NumberLiteral{Value: 0}},
+	}, body...)
+	return &ast.File{
+		Name: "fused.minz",
+		Declarations: []ast.Declaration{
+			&ast.VarDecl{
+				Name:  "arr",
+				Type:  &ast.ArrayType{ElementType: &ast.PrimitiveType{Name: "u8"}, Size: &ast.NumberLiteral{Value: int64(len(values))}},
+				Value: &ast.ArrayInitializer{Elements: elements},
+			},
+			&ast.FunctionDecl{
+				Name:       "main",
+				ReturnType: &ast.PrimitiveType{Name: returnType},
+				Body:       &ast.BlockStmt{Statements: statements},
+			},
+		},
+	}
+}
+
+// method calls arr.iter() followed by the given methods, as the parser
+// produces a method chain
+func method(receiver ast.Expression, name string, args ...ast.Expression) ast.Expression {
+	return &ast.CallExpr{Function: &ast.FieldExpr{Object: receiver, Field: name}, Arguments: args}
+}
+
+// lambdaOf builds |params| body
+func lambdaOf(body ast.Node, params ...string) *ast.LambdaExpr {
+	lambda := &ast.LambdaExpr{Body: body}
+	for _, name := range params {
+		lambda.Params = append(lambda.Params, &ast.LambdaParam{Name: name})
+	}
+	return lambda
+}
+
+// binary builds left op right over identifiers and numbers
+func binary(left interface{}, op string, right interface{}) ast.Expression {
+	operand := func(v interface{}) ast.Expression {
+		if name, ok := v.(string); ok {
+			return &ast.Identifier{Name: name}
+		}
+		return &ast.NumberLiteral{Value: int64(v.(int))}
+	}
+	return &ast.BinaryExpr{Left: operand(left), Operator: op, Right: operand(right)}
+}
+
+// addToTotal is forEach(|x| { total = total + x; })
+func addToTotal() *ast.LambdaExpr {
+	return lambdaOf(&ast.BlockStmt{Statements: []ast.Statement{
+		&ast.AssignStmt{Target: &ast.Identifier{Name: "total"}, Value: binary("total", "+", "x")},
+	}}, "x")
+}
+
+// runFused analyzes a program and evaluates its main
+func runFused(t *testing.T, file *ast.File, values []int64) (*ir.Function, map[string]int64) {
+	t.Helper()
+	module, err := NewAnalyzer().Analyze(file)
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	for _, fn := range module.Functions {
+		if strings.HasSuffix(fn.Name, ".main") {
+			for _, inst := range fn.Instructions {
+				if inst.Op == ir.OpCall {
+					t.Errorf("fused loop contains a call: %s", inst.String())
+				}
+			}
+			return fn, evalFusedLoop(t, fn, values)
+		}
+	}
+	t.Fatal("main not generated")
+	return nil, nil
+}
+
+func TestIteratorMethodChainFind(t *testing.T) {
+	values := []int64{1, 2, 3, 4, 5}
+	chain := method(method(method(method(&ast.Identifier{Name: "arr"}, "iter"),
+		"map", lambdaOf(binary("x", "*", 2), "x")),
+		"filter", lambdaOf(binary("x", ">", 4), "x")),
+		"find", lambdaOf(binary("x", ">", 7), "x"))
+	file := fusedProgram(values, "u8", "u8", &ast.ReturnStmt{Value: chain})
+
+	// map(x * 2) gives 2, 4, 6, 8, 10; filter(x > 4) keeps 6, 8, 10
+	_, vars := runFused(t, file, values)
+	if vars["return"] != 8 {
+		t.Errorf("find returned %d, want 8", vars["return"])
+	}
+}
+
+func TestIteratorChainEarlyExitAndCounters(t *testing.T) {
+	values := []int64{1, 2, 3, 4, 5}
+	tests := []struct {
+		name string
+		ops  []ast.IteratorOp
+		want int64
+	}{
+		{"takeWhile", []ast.IteratorOp{
+			{Type: ast.IterOpTakeWhile, Function: lambdaOf(binary("x", "<", 4), "x")},
+		}, 1 + 2 + 3},
+		{"skip and take", []ast.IteratorOp{
+			{Type: ast.IterOpSkip, Function: &ast.NumberLiteral{Value: 1}},
+			{Type: ast.IterOpTake, Function: &ast.NumberLiteral{Value: 3}},
+		}, 2 + 3 + 4},
+		{"runtime take", []ast.IteratorOp{
+			{Type: ast.IterOpTake, Function: &ast.Identifier{Name: "n"}},
+		}, 1 + 2},
+		{"take after filter", []ast.IteratorOp{
+			{Type: ast.IterOpFilter, Function: lambdaOf(binary("x", ">", 1), "x")},
+			{Type: ast.IterOpTake, Function: &ast.NumberLiteral{Value: 2}},
+		}, 2 + 3},
+		{"skipWhile", []ast.IteratorOp{
+			{Type: ast.IterOpSkipWhile, Function: lambdaOf(binary("x", "<", 3), "x")},
+		}, 3 + 4 + 5},
+		{"enumerate", []ast.IteratorOp{
+			{Type: ast.IterOpEnumerate},
+			{Type: ast.IterOpMap, Function: lambdaOf(binary("i", "*", "x"), "i", "x")},
+		}, 0*1 + 1*2 + 2*3 + 3*4 + 4*5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops := append(tt.ops, ast.IteratorOp{Type: ast.IterOpForEach, Function: addToTotal()})
+			file := fusedProgram(values, "u8", "void",
+				&ast.VarDecl{Name: "n", Type: &ast.PrimitiveType{Name: "u8"}, Value: &ast.NumberLiteral{Value: 2}},
+				&ast.ExpressionStmt{Expression: &ast.IteratorChainExpr{Source: &ast.Identifier{Name: "arr"}, Operations: ops}},
+			)
+			_, vars := runFused(t, file, values)
+			if vars["total"] != tt.want {
+				t.Errorf("total = %d, want %d", vars["total"], tt.want)
+			}
+		})
+	}
+}
+
+func TestIteratorChainLongArrayUses16BitCounter(t *testing.T) {
+	values := make([]int64, 300)
+	for i := range values {
+		values[i] = int64(i % 256)
+	}
+	file := fusedProgram(values, "u16", "void",
+		&ast.ExpressionStmt{Expression: &ast.IteratorChainExpr{
+			Source: &ast.Identifier{Name: "arr"},
+			Operations: []ast.IteratorOp{
+				{Type: ast.IterOpFilter, Function: lambdaOf(binary("x", ">", 200), "x")},
+				{Type: ast.IterOpForEach, Function: lambdaOf(&ast.BlockStmt{Statements: []ast.Statement{
+					&ast.AssignStmt{Target: &ast.Identifier{Name: "total"}, Value: binary("total", "+", 1)},
+				}}, "x")},
+			},
+		}},
+	)
+
+	main, vars := runFused(t, file, values)
+	for _, inst := range main.Instructions {
+		if inst.Op == ir.OpDJNZ {
+			t.Errorf("DJNZ cannot count %d elements", len(values))
+		}
+	}
+	if vars["total"] != 55 {
+		t.Errorf("total = %d, want 55", vars["total"])
+	}
+}
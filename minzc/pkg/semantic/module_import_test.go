@@ -0,0 +1,130 @@
+package semantic
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+)
+
+// writeModules writes source files under a fresh project root
+func writeModules(t *testing.T, files map[string]string) string {
+	t.Helper()
+	t.Setenv("MINZ_USE_ANTLR", "1")
+	root := t.TempDir()
+	for name, source := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// mainCalling builds a main file that imports the given modules and calls
+// callee(3)
+func mainCalling(callee string, imports ...string) *ast.File {
+	file := &ast.File{
+		Name: "main.minz",
+		Declarations: []ast.Declaration{
+			&ast.FunctionDecl{
+				Name:       "main",
+				ReturnType: &ast.PrimitiveType{Name: "void"},
+				Body: &ast.BlockStmt{Statements: []ast.Statement{
+					&ast.VarDecl{
+						Name: "x",
+						Type: &ast.PrimitiveType{Name: "u8"},
+						Value: &ast.CallExpr{
+							Function:  &ast.Identifier{Name: callee},
+							Arguments: []ast.Expression{&ast.NumberLiteral{Value: 3}},
+						},
+					},
+				}},
+			},
+		},
+	}
+	for _, path := range imports {
+		file.Imports = append(file.Imports, &ast.ImportStmt{Path: path})
+	}
+	return file
+}
+
+const mathModule = `fun twice(x: u8) -> u8 {
+    return x + x;
+}
+
+pub fun quad(x: u8) -> u8 {
+    let y: u8 = twice(x);
+    return twice(y);
+}
+`
+
+func TestModuleImport(t *testing.T) {
+	root := writeModules(t, map[string]string{"util/math.minz": mathModule})
+
+	analyzer := NewAnalyzer()
+	analyzer.SetProjectRoot(root)
+	module, err := analyzer.Analyze(mainCalling("util.math.quad", "util.math"))
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	names := make(map[string]bool)
+	for _, fn := range module.Functions {
+		names[fn.Name] = true
+	}
+	for _, want := range []string{"util.math.quad$u8", "util.math.twice$u8"} {
+		if !names[want] {
+			t.Errorf("function %s was not compiled; have %v", want, names)
+		}
+	}
+}
+
+func TestModulePrivateFunction(t *testing.T) {
+	root := writeModules(t, map[string]string{"util/math.minz": mathModule})
+
+	analyzer := NewAnalyzer()
+	analyzer.SetProjectRoot(root)
+	_, err := analyzer.Analyze(mainCalling("util.math.twice", "util.math"))
+	if err == nil {
+		t.Fatal("calling a private function of another module was accepted")
+	}
+}
+
+func TestModuleImportCycle(t *testing.T) {
+	root := writeModules(t, map[string]string{
+		"a.minz": "import b;\n\npub fun one() -> u8 {\n    return 1;\n}\n",
+		"b.minz": "import a;\n\npub fun two() -> u8 {\n    return 2;\n}\n",
+	})
+
+	analyzer := NewAnalyzer()
+	analyzer.SetProjectRoot(root)
+	_, err := analyzer.Analyze(mainCalling("a.one", "a"))
+	if err == nil || !strings.Contains(err.Error(), "import cycle: a -> b -> a") {
+		t.Fatalf("got error %v, want an import cycle", err)
+	}
+}
+
+func TestModuleFile(t *testing.T) {
+	root := writeModules(t, map[string]string{"lib/extra.minz": mathModule})
+
+	// Given on the command line rather than imported
+	analyzer := NewAnalyzer()
+	analyzer.AddModuleFile("extra", filepath.Join(root, "lib", "extra.minz"))
+	module, err := analyzer.Analyze(mainCalling("extra.quad"))
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	found := false
+	for _, fn := range module.Functions {
+		found = found || fn.Name == "extra.quad$u8"
+	}
+	if !found {
+		t.Error("extra.quad$u8 was not compiled")
+	}
+}
@@ -16,6 +16,8 @@ type ModuleLoader struct {
 	searchPaths []string
 	// Cache of loaded modules
 	cache map[string]*LoadedModule
+	// Files given explicitly, by import path
+	files map[string]string
 	// Parser for module files
 	parser *parser.Parser
 }
@@ -36,6 +38,7 @@ func NewModuleLoader() *ModuleLoader {
 			".",
 		},
 		cache:  make(map[string]*LoadedModule),
+		files:  make(map[string]string),
 		parser: parser.New(),
 	}
 }
@@ -52,13 +55,15 @@ func (ml *ModuleLoader) LoadModule(importPath string) (*LoadedModule, error) {
 	filePath := strings.ReplaceAll(importPath, ".", "/") + ".minz"
 	
 	
-	// Search for the module file
-	var fullPath string
+	// Search for the module file, unless it was given explicitly
+	fullPath := ml.files[importPath]
 	for _, searchPath := range ml.searchPaths {
+		if fullPath != "" {
+			break
+		}
 		candidate := filepath.Join(searchPath, filePath)
 		if _, err := os.Stat(candidate); err == nil {
 			fullPath = candidate
-			break
 		}
 	}
 	
@@ -86,6 +91,17 @@ func (ml *ModuleLoader) LoadModule(importPath string) (*LoadedModule, error) {
 	return module, nil
 }
 
+// SetProjectRoot makes the project root the first place modules are
+// searched for
+func (ml *ModuleLoader) SetProjectRoot(root string) {
+	ml.searchPaths = append([]string{root}, ml.searchPaths...)
+}
+
+// AddModuleFile makes importPath load the given file
+func (ml *ModuleLoader) AddModuleFile(importPath, filename string) {
+	ml.files[importPath] = filename
+}
+
 // AddSearchPath adds a directory to search for modules
 func (ml *ModuleLoader) AddSearchPath(path string) {
 	ml.searchPaths = append(ml.searchPaths, path)
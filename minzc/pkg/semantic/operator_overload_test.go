@@ -0,0 +1,110 @@
+package semantic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// fixedPointProgram builds:
+//
+//	struct Fixed { raw: u16 }
+//	interface Add { fun add(self, other: Fixed) -> Fixed; }
+//	impl Add for <implType> { fun add(self, other: Fixed) -> Fixed { return other; } }
+//	fun sum(a: Fixed, b: Fixed) -> Fixed { return a + b; }
+func fixedPointProgram(implType ast.Type) *ast.File {
+	fixed := func() ast.Type { return &ast.TypeIdentifier{Name: "Fixed"} }
+	return &ast.File{
+		Name: "fixed.minz",
+		Declarations: []ast.Declaration{
+			&ast.StructDecl{
+				Name:   "Fixed",
+				Fields: []*ast.Field{{Name: "raw", Type: &ast.PrimitiveType{Name: "u16"}}},
+			},
+			&ast.InterfaceDecl{
+				Name: "Add",
+				Methods: []*ast.InterfaceMethod{{
+					Name:       "add",
+					Params:     []*ast.Parameter{{Name: "self", IsSelf: true}, {Name: "other", Type: fixed()}},
+					ReturnType: fixed(),
+				}},
+			},
+			&ast.ImplBlock{
+				InterfaceName: "Add",
+				ForType:       implType,
+				Methods: []*ast.FunctionDecl{{
+					Name:       "add",
+					Params:     []*ast.Parameter{{Name: "self", IsSelf: true}, {Name: "other", Type: fixed()}},
+					ReturnType: fixed(),
+					Body: &ast.BlockStmt{Statements: []ast.Statement{
+						&ast.ReturnStmt{Value: &ast.Identifier{Name: "other"}},
+					}},
+				}},
+			},
+			&ast.FunctionDecl{
+				Name:       "sum",
+				Params:     []*ast.Parameter{{Name: "a", Type: fixed()}, {Name: "b", Type: fixed()}},
+				ReturnType: fixed(),
+				Body: &ast.BlockStmt{Statements: []ast.Statement{
+					&ast.ReturnStmt{Value: &ast.BinaryExpr{
+						Left:     &ast.Identifier{Name: "a"},
+						Operator: "+",
+						Right:    &ast.Identifier{Name: "b"},
+					}},
+				}},
+			},
+		},
+	}
+}
+
+func TestOperatorOverloadDispatchesToMethod(t *testing.T) {
+	module, err := NewAnalyzer().Analyze(fixedPointProgram(&ast.TypeIdentifier{Name: "Fixed"}))
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	var sum, add *ir.Function
+	for _, fn := range module.Functions {
+		switch {
+		case strings.Contains(fn.Name, ".sum"):
+			sum = fn
+		case strings.Contains(fn.Name, "Fixed.add"):
+			add = fn
+		}
+	}
+	if sum == nil || add == nil {
+		t.Fatal("functions sum and Fixed.add not generated")
+	}
+
+	var call *ir.Instruction
+	for i := range sum.Instructions {
+		inst := &sum.Instructions[i]
+		if inst.Op == ir.OpAdd {
+			t.Errorf("a + b on Fixed emitted a built-in add: %s", inst.String())
+		}
+		if inst.Op == ir.OpCall {
+			call = inst
+		}
+	}
+	if call == nil {
+		t.Fatal("a + b on Fixed did not lower to a method call")
+	}
+	if call.Symbol != add.Name {
+		t.Errorf("operator + called %s, want %s", call.Symbol, add.Name)
+	}
+	if len(call.Args) != 2 {
+		t.Errorf("operator + passed %d arguments, want 2", len(call.Args))
+	}
+}
+
+func TestOperatorOverloadOnPrimitiveIsError(t *testing.T) {
+	_, err := NewAnalyzer().Analyze(fixedPointProgram(&ast.PrimitiveType{Name: "u16"}))
+	if err == nil {
+		t.Fatal("expected error overloading + on u16")
+	}
+	if !strings.Contains(err.Error(), "cannot overload operator + for primitive type u16") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package semantic
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// @print writes text and values to the screen without building a string:
+//
+//	@print("Value of x: ", x);
+//	@print("x={} y={}\n", x, y);
+//	@print("Hello, {NAME}!");
+//
+// When the first argument is a string literal with {} placeholders, the
+// other arguments fill them in order ({{ and }} print braces). Otherwise
+// the arguments are printed one after the other. A lone string literal
+// keeps the { name } interpolation. @println does the same and ends the
+// line.
+//
+// Literals, constants and constant expressions are folded into the text
+// at compile time, so adjacent text is printed with one instruction, a
+// direct print when it is short. Runtime values are printed by type:
+// print_u8, print_u16, print_i8, print_i16, print_bool or fixed point.
+
+// printPiece is text or a runtime value of @print
+type printPiece struct {
+	text  string
+	value ast.Expression // Nil for text
+}
+
+// isPrintCall reports whether a metafunction call is @print(...) or
+// @println(...)
+func isPrintCall(call *ast.MetafunctionCall) bool {
+	switch strings.TrimPrefix(call.Name, "@") {
+	case "print", "println":
+		return true
+	}
+	return false
+}
+
+// analyzePrintCall lowers @print(...), and @println(...), which ends the
+// line after its arguments
+func (a *Analyzer) analyzePrintCall(call *ast.MetafunctionCall, irFunc *ir.Function) error {
+	a.exprTypes[call] = &ir.BasicType{Kind: ir.TypeVoid}
+	if strings.TrimPrefix(call.Name, "@") == "print" {
+		return a.analyzePrint(call.Arguments, irFunc)
+	}
+	if len(call.Arguments) > 0 {
+		if err := a.analyzePrint(call.Arguments, irFunc); err != nil {
+			return err
+		}
+	}
+	a.generatePrintString("\n", irFunc)
+	return nil
+}
+
+// analyzePrint lowers @print(args...)
+func (a *Analyzer) analyzePrint(args []ast.Expression, irFunc *ir.Function) error {
+	if len(args) == 0 {
+		return fmt.Errorf("@print requires at least one argument")
+	}
+	if format, ok := args[0].(*ast.StringLiteral); ok && len(args) == 1 {
+		// Use enhanced interpolation with { constant } support
+		if err := a.processEnhancedStringInterpolation(format.Value, irFunc); err != nil {
+			// Fall back to old implementation if enhanced fails
+			if err := a.processStringInterpolation(format.Value, irFunc); err != nil {
+				return fmt.Errorf("failed to process string interpolation: %w", err)
+			}
+		}
+		return nil
+	}
+
+	pieces, err := printPieces(args)
+	if err != nil {
+		return err
+	}
+	var text strings.Builder
+	for _, piece := range pieces {
+		if piece.value == nil {
+			text.WriteString(piece.text)
+			continue
+		}
+		if folded, ok := a.printConstant(piece.value); ok {
+			text.WriteString(folded)
+			continue
+		}
+		if text.Len() > 0 {
+			a.generatePrintString(text.String(), irFunc)
+			text.Reset()
+		}
+		reg, err := a.analyzeExpression(piece.value, irFunc)
+		if err != nil {
+			return err
+		}
+		a.generatePrintValue(reg, a.exprTypes[piece.value], irFunc)
+	}
+	if text.Len() > 0 {
+		a.generatePrintString(text.String(), irFunc)
+	}
+	return nil
+}
+
+// printPieces splits the arguments of @print into text and values, filling
+// the placeholders of a format string
+func printPieces(args []ast.Expression) ([]printPiece, error) {
+	if format, ok := args[0].(*ast.StringLiteral); ok {
+		pieces, placeholders := splitPlaceholders(format.Value, args[1:])
+		if placeholders > 0 {
+			if placeholders != len(args)-1 {
+				return nil, fmt.Errorf("@print format %q has %d placeholders, got %d arguments",
+					format.Value, placeholders, len(args)-1)
+			}
+			return pieces, nil
+		}
+	}
+	pieces := make([]printPiece, len(args))
+	for i, arg := range args {
+		pieces[i] = printPiece{value: arg}
+	}
+	return pieces, nil
+}
+
+// splitPlaceholders splits a format string at its {} placeholders, giving
+// them the values in order, and counts the placeholders
+func splitPlaceholders(format string, values []ast.Expression) ([]printPiece, int) {
+	var pieces []printPiece
+	text := []byte{}
+	placeholders := 0
+	for i := 0; i < len(format); i++ {
+		if i+1 < len(format) {
+			switch format[i : i+2] {
+			case "{{", "}}":
+				text = append(text, format[i])
+				i++
+				continue
+			case "{}":
+				if len(text) > 0 {
+					pieces = append(pieces, printPiece{text: string(text)})
+					text = []byte{}
+				}
+				if placeholders < len(values) {
+					pieces = append(pieces, printPiece{value: values[placeholders]})
+				}
+				placeholders++
+				i++
+				continue
+			}
+		}
+		text = append(text, format[i])
+	}
+	if len(text) > 0 {
+		pieces = append(pieces, printPiece{text: string(text)})
+	}
+	return pieces, placeholders
+}
+
+// printConstant returns the text of a value known at compile time
+func (a *Analyzer) printConstant(expr ast.Expression) (string, bool) {
+	if num, ok := expr.(*ast.NumberLiteral); ok && num.IsFloat {
+		return strconv.FormatFloat(num.Float, 'f', -1, 64), true
+	}
+	if t, err := a.inferType(expr); err == nil && isFixedPointType(t) {
+		return "", false // The constant holds the encoded value
+	}
+	value, err := a.evaluateConstantExpression(expr)
+	if err != nil {
+		return "", false
+	}
+	switch v := value.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case string:
+		return v, true
+	}
+	return "", false
+}
+
+// isFixedPointType reports whether t is one of the fixed-point types
+func isFixedPointType(t ir.Type) bool {
+	basic, ok := t.(*ir.BasicType)
+	if !ok {
+		return false
+	}
+	switch basic.Kind {
+	case ir.TypeF8_8, ir.TypeF_8, ir.TypeF_16, ir.TypeF16_8, ir.TypeF8_16:
+		return true
+	}
+	return false
+}
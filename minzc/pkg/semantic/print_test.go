@@ -0,0 +1,94 @@
+package semantic
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// analyzePrintArgs analyzes
+//
+//	const LIVES: u8 = 3;
+//	fun show(x: u8, n: i8, ok: bool) -> void { @print(<args>); }
+//
+// and lists what show prints: text in quotes, values by their opcode
+func analyzePrintArgs(args ...ast.Expression) ([]string, error) {
+	param := func(name, typ string) *ast.Parameter {
+		return &ast.Parameter{Name: name, Type: &ast.PrimitiveType{Name: typ}}
+	}
+	call := &ast.MetafunctionCall{Name: "print", Arguments: args}
+	file := &ast.File{
+		Name: "show.minz",
+		Declarations: []ast.Declaration{
+			&ast.ConstDecl{Name: "LIVES", Type: &ast.PrimitiveType{Name: "u8"}, Value: &ast.NumberLiteral{Value: 3}},
+			&ast.FunctionDecl{
+				Name:       "show",
+				Params:     []*ast.Parameter{param("x", "u8"), param("n", "i8"), param("ok", "bool")},
+				ReturnType: &ast.PrimitiveType{Name: "void"},
+				Body:       &ast.BlockStmt{Statements: []ast.Statement{&ast.ExpressionStmt{Expression: call}}},
+			},
+		},
+	}
+
+	module, err := NewAnalyzer().Analyze(file)
+	if err != nil {
+		return nil, err
+	}
+	strs := make(map[string]string)
+	for _, s := range module.Strings {
+		strs[s.Label] = s.Value
+	}
+	for _, fn := range module.Functions {
+		if !strings.Contains(fn.Name, ".show") {
+			continue
+		}
+		var printed []string
+		for _, inst := range fn.Instructions {
+			switch inst.Op {
+			case ir.OpPrintStringDirect:
+				printed = append(printed, fmt.Sprintf("%q", inst.Symbol))
+			case ir.OpPrintString:
+				printed = append(printed, fmt.Sprintf("%q", strs[inst.Symbol]))
+			case ir.OpPrintU8, ir.OpPrintU16, ir.OpPrintI8, ir.OpPrintBool:
+				printed = append(printed, inst.Op.String())
+			}
+		}
+		return printed, nil
+	}
+	return nil, fmt.Errorf("show not generated")
+}
+
+func TestPrintLowering(t *testing.T) {
+	str := func(s string) ast.Expression { return &ast.StringLiteral{Value: s} }
+	id := func(name string) ast.Expression { return &ast.Identifier{Name: name} }
+	tests := []struct {
+		name string
+		args []ast.Expression
+		want []string
+	}{
+		{"arguments in turn", []ast.Expression{str("Value of x: "), id("x")}, []string{`"Value of x: "`, "PRINT_U8"}},
+		{"placeholders", []ast.Expression{str("n={}, ok={}\n"), id("n"), id("ok")}, []string{`"n="`, "PRINT_I8", `", ok="`, "PRINT_BOOL", `"\n"`}},
+		{"constants folded", []ast.Expression{str("{} lives, {}{{}}"), id("LIVES"), &ast.BooleanLiteral{Value: true}}, []string{`"3 lives, true{}"`}},
+		{"constant between values", []ast.Expression{id("x"), str("/"), &ast.NumberLiteral{Value: 9}, id("x")}, []string{"PRINT_U8", `"/9"`, "PRINT_U8"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			printed, err := analyzePrintArgs(tt.args...)
+			if err != nil {
+				t.Fatalf("analysis failed: %v", err)
+			}
+			if !reflect.DeepEqual(printed, tt.want) {
+				t.Errorf("printed %s, want %s", printed, tt.want)
+			}
+		})
+	}
+
+	_, err := analyzePrintArgs(str("{} and {}"), id("x"))
+	if err == nil || !strings.Contains(err.Error(), "has 2 placeholders, got 1 arguments") {
+		t.Errorf("error = %v, want a placeholder count mismatch", err)
+	}
+}
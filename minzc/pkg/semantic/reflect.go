@@ -0,0 +1,151 @@
+package semantic
+
+import (
+	"strings"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// Compile-time reflection: @fields(T) and @variants(T) list the layout of a
+// struct or enum, and @for repeats a block once per item with the item's
+// properties available as constants:
+//
+//	@for f in @fields(Point) {
+//	    buf[f.offset] = f.size;       // f.name, f.offset, f.size, f.index
+//	}
+//	@for v in @variants(Color) {
+//	    names[v.value] = v.name;      // v.name, v.value, v.index
+//	}
+//
+// Nothing of the loop is left at run time; each property use is replaced
+// by a number or string literal.
+
+// reflectProperties lists the properties of each reflection metafunction's
+// items, in the order error messages name them
+var reflectProperties = map[string][]string{
+	"fields":   {"name", "offset", "size", "index"},
+	"variants": {"name", "value", "index"},
+}
+
+// MetaSymbol is the item bound by @for: a set of named constants
+type MetaSymbol struct {
+	Source     string                 // "fields" or "variants"
+	Properties map[string]interface{} // int64 or string
+}
+
+func (m *MetaSymbol) symbol() {}
+
+// reflectItems evaluates @fields(T) or @variants(T)
+func (a *Analyzer) reflectItems(call *ast.MetafunctionCall) ([]*MetaSymbol, error) {
+	if _, ok := reflectProperties[call.Name]; !ok {
+		return nil, a.errorAt(call, "@for can only iterate @fields(T) or @variants(T), got @%s", call.Name)
+	}
+	if len(call.Arguments) != 1 {
+		return nil, a.errorAt(call, "@%s expects one type, got %d arguments", call.Name, len(call.Arguments))
+	}
+	id, ok := call.Arguments[0].(*ast.Identifier)
+	if !ok {
+		return nil, a.errorAt(call, "@%s expects a type name", call.Name)
+	}
+	t, err := a.convertType(&ast.TypeIdentifier{Name: id.Name})
+	if err != nil {
+		return nil, a.errorAt(call, "@%s: %v", call.Name, err)
+	}
+
+	var items []*MetaSymbol
+	switch call.Name {
+	case "fields":
+		structType, ok := t.(*ir.StructType)
+		if !ok {
+			return nil, a.errorAt(call, "@fields expects a struct type, got %s", id.Name)
+		}
+		offset := 0
+		for i, name := range structType.FieldOrder {
+			size := structType.Fields[name].Size()
+			items = append(items, &MetaSymbol{Source: call.Name, Properties: map[string]interface{}{
+				"name": name, "offset": int64(offset), "size": int64(size), "index": int64(i),
+			}})
+			offset += size
+		}
+	case "variants":
+		enumType, ok := t.(*ir.EnumType)
+		if !ok {
+			return nil, a.errorAt(call, "@variants expects an enum type, got %s", id.Name)
+		}
+		for i, name := range enumVariantNames(enumType) {
+			items = append(items, &MetaSymbol{Source: call.Name, Properties: map[string]interface{}{
+				"name": name, "value": int64(enumType.Variants[name]), "index": int64(i),
+			}})
+		}
+	}
+	return items, nil
+}
+
+// analyzeCompileTimeFor unrolls @for: the body is analyzed once per item
+// with the iterator bound to that item
+func (a *Analyzer) analyzeCompileTimeFor(ctFor *ast.CompileTimeFor, irFunc *ir.Function) error {
+	if ctFor.Items == nil {
+		return a.errorAt(ctFor, "@for can only iterate @fields(T) or @variants(T)")
+	}
+	items, err := a.reflectItems(ctFor.Items)
+	if err != nil {
+		return err
+	}
+	if ctFor.Body == nil {
+		return nil
+	}
+
+	prevScope := a.currentScope
+	defer func() { a.currentScope = prevScope }()
+	for _, item := range items {
+		a.currentScope = NewScope(prevScope)
+		a.currentScope.Define(ctFor.Iterator, item)
+		if err := a.analyzeBlock(ctFor.Body, irFunc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// metaProperty returns the literal a property of a @for item stands for,
+// such as f.offset; ok is false when field is not a @for item property
+func (a *Analyzer) metaProperty(field *ast.FieldExpr) (lit ast.Expression, ok bool, err error) {
+	id, isIdent := field.Object.(*ast.Identifier)
+	if !isIdent {
+		return nil, false, nil
+	}
+	item, isMeta := a.currentScope.Lookup(id.Name).(*MetaSymbol)
+	if !isMeta {
+		return nil, false, nil
+	}
+	switch value := item.Properties[field.Field].(type) {
+	case int64:
+		return &ast.NumberLiteral{Value: value, StartPos: field.StartPos, EndPos: field.EndPos}, true, nil
+	case string:
+		return &ast.StringLiteral{Value: value, StartPos: field.StartPos, EndPos: field.EndPos}, true, nil
+	}
+	return nil, true, a.errorAt(field, "@%s item has no property %s (want %s)",
+		item.Source, field.Field, strings.Join(reflectProperties[item.Source], ", "))
+}
+
+// analyzeMetaProperty loads a @for item property as a constant
+func (a *Analyzer) analyzeMetaProperty(field *ast.FieldExpr, lit ast.Expression, irFunc *ir.Function) (ir.Register, error) {
+	reg, err := a.analyzeExpression(lit, irFunc)
+	if err != nil {
+		return 0, err
+	}
+	t, err := a.inferType(lit)
+	if err != nil {
+		return 0, err
+	}
+	a.exprTypes[field] = t
+	return reg, nil
+}
+
+// isReflectCall reports whether call is @fields or @variants, which only
+// make sense as the items of @for
+func isReflectCall(call *ast.MetafunctionCall) bool {
+	_, ok := reflectProperties[call.Name]
+	return ok
+}
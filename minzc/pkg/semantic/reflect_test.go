@@ -0,0 +1,147 @@
+package semantic
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// reflectProgram builds:
+//
+//	struct Point { x: u8, y: u16, z: u8 }
+//	enum Color { Red, Green, Blue }
+//	fun gen(buf: *u8, c: u8) -> String { <body>; return "?"; }
+//
+// and returns gen's instructions and the module's strings by label
+func reflectProgram(body ...ast.Statement) ([]ir.Instruction, map[string]string, error) {
+	u8 := &ast.PrimitiveType{Name: "u8"}
+	u16 := &ast.PrimitiveType{Name: "u16"}
+	file := &ast.File{
+		Name: "reflect.minz",
+		Declarations: []ast.Declaration{
+			&ast.StructDecl{Name: "Point", Fields: []*ast.Field{
+				{Name: "x", Type: u8}, {Name: "y", Type: u16}, {Name: "z", Type: u8},
+			}},
+			&ast.EnumDecl{Name: "Color", Variants: []string{"Red", "Green", "Blue"}},
+			&ast.FunctionDecl{
+				Name: "gen",
+				Params: []*ast.Parameter{
+					{Name: "buf", Type: &ast.PointerType{BaseType: u8, IsMutable: true}},
+					{Name: "c", Type: u8},
+				},
+				ReturnType: &ast.TypeIdentifier{Name: "String"},
+				Body: &ast.BlockStmt{Statements: append(body,
+					&ast.ReturnStmt{Value: &ast.StringLiteral{Value: "?"}})},
+			},
+		},
+	}
+
+	module, err := NewAnalyzer().Analyze(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	strs := make(map[string]string)
+	for _, str := range module.Strings {
+		strs[str.Label] = str.Value
+	}
+	for _, fn := range module.Functions {
+		if strings.Contains(fn.Name, ".gen") {
+			return fn.Instructions, strs, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("gen not generated")
+}
+
+// metaFor builds @for <item> in @<reflect>(<typ>) { <body> }
+func metaFor(item, reflect, typ string, body ast.Statement) *ast.CompileTimeFor {
+	return &ast.CompileTimeFor{
+		Iterator: item,
+		Items:    &ast.MetafunctionCall{Name: reflect, Arguments: []ast.Expression{&ast.Identifier{Name: typ}}},
+		Body:     &ast.BlockStmt{Statements: []ast.Statement{body}},
+	}
+}
+
+// prop builds <item>.<name>
+func prop(item, name string) *ast.FieldExpr {
+	return &ast.FieldExpr{Object: &ast.Identifier{Name: item}, Field: name}
+}
+
+func TestReflectFields(t *testing.T) {
+	// @for f in @fields(Point) { buf[f.offset] = f.size; }
+	insts, _, err := reflectProgram(metaFor("f", "fields", "Point", &ast.AssignStmt{
+		Target: &ast.IndexExpr{Array: &ast.Identifier{Name: "buf"}, Index: prop("f", "offset")},
+		Value:  prop("f", "size"),
+	}))
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	// Each store indexes buf by the offset and stores the size
+	consts := map[ir.Register]int64{}
+	var stores []string
+	for _, inst := range insts {
+		switch inst.Op {
+		case ir.OpLoadConst:
+			consts[inst.Dest] = inst.Imm
+		case ir.OpStoreIndex:
+			stores = append(stores, fmt.Sprintf("buf[%d] = %d", consts[inst.Src2], consts[inst.Src3]))
+		}
+	}
+	want := []string{"buf[0] = 1", "buf[1] = 2", "buf[3] = 1"}
+	if fmt.Sprint(stores) != fmt.Sprint(want) {
+		t.Errorf("stores = %q, want %q", stores, want)
+	}
+}
+
+func TestReflectVariants(t *testing.T) {
+	// @for v in @variants(Color) { if c == v.value { return v.name; } }
+	insts, strs, err := reflectProgram(metaFor("v", "variants", "Color", &ast.IfStmt{
+		Condition: &ast.BinaryExpr{Left: &ast.Identifier{Name: "c"}, Operator: "==", Right: prop("v", "value")},
+		Then:      &ast.BlockStmt{Statements: []ast.Statement{&ast.ReturnStmt{Value: prop("v", "name")}}},
+	}))
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	// Each variant compares c with its value and returns its name
+	var value int64
+	var arms []string
+	for _, inst := range insts {
+		switch inst.Op {
+		case ir.OpLoadConst:
+			value = inst.Imm
+		case ir.OpLoadLabel:
+			if s, ok := strs[inst.Symbol]; ok && s != "?" {
+				arms = append(arms, fmt.Sprintf("%d:%s", value, s))
+			}
+		}
+	}
+	want := []string{"0:Red", "1:Green", "2:Blue"}
+	if fmt.Sprint(arms) != fmt.Sprint(want) {
+		t.Errorf("arms = %q, want %q", arms, want)
+	}
+}
+
+func TestReflectErrors(t *testing.T) {
+	tests := []struct {
+		body ast.Statement
+		want string
+	}{
+		{metaFor("f", "fields", "Color", &ast.ExpressionStmt{Expression: prop("f", "name")}), "@fields expects a struct type, got Color"},
+		{metaFor("v", "variants", "Point", &ast.ExpressionStmt{Expression: prop("v", "name")}), "@variants expects an enum type, got Point"},
+		{metaFor("f", "fields", "Point", &ast.ExpressionStmt{Expression: prop("f", "value")}), "@fields item has no property value (want name, offset, size, index)"},
+		{&ast.ExpressionStmt{Expression: &ast.MetafunctionCall{Name: "fields", Arguments: []ast.Expression{&ast.Identifier{Name: "Point"}}}},
+			"@fields can only be used as the items of @for"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			_, _, err := reflectProgram(tt.body)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package semantic
+
+import (
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// rotl8(x, n), rotr8(x, n), rotl16(x, n) and rotr16(x, n) rotate x by n
+// bits, for checksums and hashes:
+//
+//	crc = rotl8(crc, 1) ^ b;
+//
+// A constant n is reduced modulo the width and unrolled; any other n is
+// masked to the width at run time and rotated in a loop.
+
+// rotateBuiltin describes one rotate builtin
+type rotateBuiltin struct {
+	op   ir.Opcode
+	kind ir.TypeKind // Width of the rotated value
+}
+
+// rotateBuiltins maps the builtin names to their operations
+var rotateBuiltins = map[string]rotateBuiltin{
+	"rotl8":  {ir.OpRotl, ir.TypeU8},
+	"rotr8":  {ir.OpRotr, ir.TypeU8},
+	"rotl16": {ir.OpRotl, ir.TypeU16},
+	"rotr16": {ir.OpRotr, ir.TypeU16},
+}
+
+// rotateCall returns the rotate builtin call invokes, unless a user
+// function of the same name shadows it
+func (a *Analyzer) rotateCall(call *ast.CallExpr) (rotateBuiltin, bool) {
+	id, ok := call.Function.(*ast.Identifier)
+	if !ok {
+		return rotateBuiltin{}, false
+	}
+	builtin, ok := rotateBuiltins[id.Name]
+	if !ok || a.currentScope.Lookup(id.Name) != nil || a.currentScope.Lookup(a.prefixSymbol(id.Name)) != nil {
+		return rotateBuiltin{}, false
+	}
+	return builtin, true
+}
+
+// isIntegerType reports whether t is an 8- or 16-bit integer type
+func isIntegerType(t ir.Type) bool {
+	basic, ok := t.(*ir.BasicType)
+	if !ok {
+		return false
+	}
+	switch basic.Kind {
+	case ir.TypeU8, ir.TypeU16, ir.TypeI8, ir.TypeI16:
+		return true
+	}
+	return false
+}
+
+// analyzeRotateCall lowers a rotate builtin to OpRotl or OpRotr
+func (a *Analyzer) analyzeRotateCall(call *ast.CallExpr, builtin rotateBuiltin, irFunc *ir.Function) (ir.Register, error) {
+	name := call.Function.(*ast.Identifier).Name
+	if len(call.Arguments) != 2 {
+		return 0, a.errorAt(call, "%s expects a value and a count, got %d arguments", name, len(call.Arguments))
+	}
+	for i, arg := range call.Arguments {
+		t, err := a.inferType(arg)
+		if err != nil {
+			return 0, err
+		}
+		if !isIntegerType(t) {
+			return 0, a.errorAt(arg, "%s argument %d must be an integer, got %s", name, i+1, t)
+		}
+	}
+
+	value, err := a.analyzeExpression(call.Arguments[0], irFunc)
+	if err != nil {
+		return 0, err
+	}
+	resultType := &ir.BasicType{Kind: builtin.kind}
+	inst := ir.Instruction{
+		Op:      builtin.op,
+		Dest:    irFunc.AllocReg(),
+		Src1:    value,
+		Type:    resultType,
+		Comment: name,
+	}
+	a.exprTypes[call] = resultType
+	if count, err := a.evaluateConstExpr(call.Arguments[1]); err == nil {
+		if n, ok := count.(int64); ok {
+			width := int64(resultType.Size() * 8)
+			if inst.Imm = (n%width + width) % width; inst.Imm == 0 {
+				return value, nil // A whole number of turns
+			}
+		}
+	}
+	if inst.Imm == 0 {
+		if inst.Src2, err = a.analyzeExpression(call.Arguments[1], irFunc); err != nil {
+			return 0, err
+		}
+	}
+	irFunc.Instructions = append(irFunc.Instructions, inst)
+	return inst.Dest, nil
+}
@@ -0,0 +1,92 @@
+package semantic
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// analyzeRotate analyzes
+//
+//	fun mix(x: u8, n: u8) -> <ret> { return <call>; }
+//
+// and returns mix's instructions
+func analyzeRotate(ret string, call *ast.CallExpr) ([]ir.Instruction, error) {
+	u8 := &ast.PrimitiveType{Name: "u8"}
+	file := &ast.File{
+		Name: "mix.minz",
+		Declarations: []ast.Declaration{&ast.FunctionDecl{
+			Name:       "mix",
+			Params:     []*ast.Parameter{{Name: "x", Type: u8}, {Name: "n", Type: u8}},
+			ReturnType: &ast.PrimitiveType{Name: ret},
+			Body:       &ast.BlockStmt{Statements: []ast.Statement{&ast.ReturnStmt{Value: call}}},
+		}},
+	}
+
+	module, err := NewAnalyzer().Analyze(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range module.Functions {
+		if strings.Contains(fn.Name, ".mix") {
+			return fn.Instructions, nil
+		}
+	}
+	return nil, fmt.Errorf("mix not generated")
+}
+
+func rotateCallExpr(name string, args ...ast.Expression) *ast.CallExpr {
+	return &ast.CallExpr{Function: &ast.Identifier{Name: name}, Arguments: args}
+}
+
+func TestRotateLowering(t *testing.T) {
+	x := &ast.Identifier{Name: "x"}
+	n := &ast.Identifier{Name: "n"}
+	tests := []struct {
+		name string
+		ret  string
+		call *ast.CallExpr
+		want string // Rendered rotate, or "" when none is emitted
+	}{
+		{"constant", "u8", rotateCallExpr("rotl8", x, &ast.NumberLiteral{Value: 3}), "ROTL u8 imm=3 var=false"},
+		{"constant reduced", "u8", rotateCallExpr("rotr8", x, &ast.NumberLiteral{Value: 10}), "ROTR u8 imm=2 var=false"},
+		{"variable", "u8", rotateCallExpr("rotr8", x, n), "ROTR u8 imm=0 var=true"},
+		{"16-bit", "u16", rotateCallExpr("rotl16", x, &ast.NumberLiteral{Value: 12}), "ROTL u16 imm=12 var=false"},
+		{"whole turn", "u8", rotateCallExpr("rotl8", x, &ast.NumberLiteral{Value: 8}), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			insts, err := analyzeRotate(tt.ret, tt.call)
+			if err != nil {
+				t.Fatalf("analysis failed: %v", err)
+			}
+			var got []string
+			for _, inst := range insts {
+				if inst.Op == ir.OpRotl || inst.Op == ir.OpRotr {
+					got = append(got, fmt.Sprintf("%s %s imm=%d var=%v", inst.Op, inst.Type, inst.Imm, inst.Src2 != 0))
+				}
+			}
+			switch {
+			case tt.want == "" && len(got) != 0:
+				t.Errorf("rotates = %q, want none", got)
+			case tt.want != "" && (len(got) != 1 || got[0] != tt.want):
+				t.Errorf("rotates = %q, want [%q]", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotateErrors(t *testing.T) {
+	x := &ast.Identifier{Name: "x"}
+	_, err := analyzeRotate("u8", rotateCallExpr("rotl8", x))
+	if err == nil || !strings.Contains(err.Error(), "rotl8 expects a value and a count, got 1 arguments") {
+		t.Errorf("error = %v, want an argument count error", err)
+	}
+	_, err = analyzeRotate("u8", rotateCallExpr("rotl8", x, &ast.BooleanLiteral{Value: true}))
+	if err == nil || !strings.Contains(err.Error(), "rotl8 argument 2 must be an integer") {
+		t.Errorf("error = %v, want an argument type error", err)
+	}
+}
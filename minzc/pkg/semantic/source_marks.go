@@ -0,0 +1,66 @@
+package semantic
+
+import (
+	"fmt"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// With --annotate-source, loops, ifs and function bodies are bracketed by
+// OpSourceMark instructions, which backends emit as comments:
+//
+//	; --- begin while loop (main.minz:12) ---
+//	...
+//	; --- end ---
+
+// recordSourceLine attributes the instructions a statement emitted from
+// index start to its source line. Nested statements record theirs first, so
+// each instruction keeps the innermost statement's line.
+func (a *Analyzer) recordSourceLine(stmt ast.Statement, irFunc *ir.Function, start int) {
+	line := stmt.Pos().Line
+	if line <= 0 {
+		return
+	}
+	for i := start; i < len(irFunc.Instructions); i++ {
+		if inst := &irFunc.Instructions[i]; inst.SourceLine == 0 {
+			inst.SourceLine = line
+			inst.SourceFile = a.sourcePath
+		}
+	}
+}
+
+// annotated runs analyze between begin/end source marks for node when
+// source annotation is enabled
+func (a *Analyzer) annotated(node ast.Node, construct string, irFunc *ir.Function, analyze func() error) error {
+	if !a.annotateSource {
+		return analyze()
+	}
+	a.emitSourceMark(irFunc, fmt.Sprintf("begin %s (%s)", construct, a.sourceLocation(node)))
+	if err := analyze(); err != nil {
+		return err
+	}
+	a.emitSourceMark(irFunc, "end")
+	return nil
+}
+
+// emitSourceMark appends a source mark with the given text
+func (a *Analyzer) emitSourceMark(irFunc *ir.Function, text string) {
+	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+		Op:      ir.OpSourceMark,
+		Comment: text,
+	})
+}
+
+// sourceLocation formats a node's position as file:line, or just the file
+// when the parser recorded no position
+func (a *Analyzer) sourceLocation(node ast.Node) string {
+	name := a.sourceName
+	if name == "" {
+		name = "<input>"
+	}
+	if line := node.Pos().Line; line > 0 {
+		return fmt.Sprintf("%s:%d", name, line)
+	}
+	return name
+}
@@ -0,0 +1,163 @@
+package semantic
+
+import (
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// str_cmp, str_copy, str_cat and str_index work on u8 length-prefixed
+// strings: String values, and u8 pointers and arrays holding a length byte
+// and then the text:
+//
+//	let name: [u8; 17];                // Length byte and 16 characters
+//	str_copy(&name, "PLAYER");
+//	if !str_cat(&name, suffix) { ... } // suffix was cut short
+//	if str_cmp(a, b) < 0 { ... }       // -1, 0 or 1
+//	let at = str_index(line, "=");     // 255 when not found
+//
+// str_copy and str_cat never write past the destination's capacity: they
+// copy what fits and return whether all of the source did. The capacity in
+// characters is an optional third argument, which defaults to the length
+// of a destination array less its length byte. The runtime routines are
+// emitted only when a program calls them.
+
+// stringBuiltin describes one string builtin
+type stringBuiltin struct {
+	writes bool        // Writes its first argument, a buffer with a capacity
+	result ir.TypeKind // Type of the result
+}
+
+// stringBuiltins maps the builtin names to their descriptions
+var stringBuiltins = map[string]stringBuiltin{
+	"str_cmp":   {false, ir.TypeI8},
+	"str_copy":  {true, ir.TypeBool},
+	"str_cat":   {true, ir.TypeBool},
+	"str_index": {false, ir.TypeU8},
+}
+
+// stringCall returns the string builtin call invokes, unless a user
+// function of the same name shadows it
+func (a *Analyzer) stringCall(call *ast.CallExpr) (stringBuiltin, bool) {
+	id, ok := call.Function.(*ast.Identifier)
+	if !ok {
+		return stringBuiltin{}, false
+	}
+	builtin, ok := stringBuiltins[id.Name]
+	if !ok || a.currentScope.Lookup(id.Name) != nil || a.currentScope.Lookup(a.prefixSymbol(id.Name)) != nil {
+		return stringBuiltin{}, false
+	}
+	return builtin, true
+}
+
+// isStringValue reports whether t holds a u8 length-prefixed string: a
+// String, or a u8 pointer or array
+func isStringValue(t ir.Type) bool {
+	switch t.(type) {
+	case *ir.StringType:
+		return true
+	case *ir.PointerType, *ir.ArrayType:
+		return isByteBuffer(t)
+	}
+	return false
+}
+
+// bufferCapacity returns the characters a u8 array, or a pointer to one,
+// holds after its length byte
+func bufferCapacity(t ir.Type) (int64, bool) {
+	if ptr, ok := t.(*ir.PointerType); ok {
+		t = ptr.Base
+	}
+	arr, ok := t.(*ir.ArrayType)
+	if !ok || arr.Length < 1 {
+		return 0, false
+	}
+	if arr.Length > 256 {
+		return 255, true
+	}
+	return int64(arr.Length - 1), true
+}
+
+// analyzeStringCall lowers a string builtin to OpStrCall
+func (a *Analyzer) analyzeStringCall(call *ast.CallExpr, builtin stringBuiltin, irFunc *ir.Function) (ir.Register, error) {
+	name := call.Function.(*ast.Identifier).Name
+	switch {
+	case builtin.writes && len(call.Arguments) != 2 && len(call.Arguments) != 3:
+		return 0, a.errorAt(call, "%s expects a destination, a source and an optional capacity, got %d arguments", name, len(call.Arguments))
+	case !builtin.writes && len(call.Arguments) != 2:
+		return 0, a.errorAt(call, "%s expects two strings, got %d arguments", name, len(call.Arguments))
+	}
+
+	types := make([]ir.Type, len(call.Arguments))
+	for i, arg := range call.Arguments {
+		t, err := a.inferType(arg)
+		if err != nil {
+			return 0, err
+		}
+		types[i] = t
+	}
+	for i := 0; i < 2; i++ {
+		if !isStringValue(types[i]) {
+			return 0, a.errorAt(call.Arguments[i], "%s argument %d must be a string or a u8 buffer, got %s", name, i+1, types[i])
+		}
+	}
+
+	var capacity int64
+	hasCapacity := false
+	if builtin.writes {
+		bufferCap, isArray := bufferCapacity(types[0])
+		if len(call.Arguments) == 3 {
+			if !isIntegerType(types[2]) {
+				return 0, a.errorAt(call.Arguments[2], "%s capacity must be an integer, got %s", name, types[2])
+			}
+			if value, err := a.evaluateConstExpr(call.Arguments[2]); err == nil {
+				if n, ok := value.(int64); ok {
+					if n < 0 || n > 255 {
+						return 0, a.errorAt(call.Arguments[2], "%s capacity %d is out of range 0..255", name, n)
+					}
+					if isArray && n > bufferCap {
+						return 0, a.errorAt(call.Arguments[2], "%s capacity %d exceeds the %d characters the destination holds", name, n, bufferCap)
+					}
+				}
+			}
+		} else if isArray {
+			capacity, hasCapacity = bufferCap, true
+		} else {
+			return 0, a.errorAt(call, "%s needs a capacity: the destination %s has no fixed size", name, types[0])
+		}
+	}
+
+	args := make([]ir.Register, len(call.Arguments))
+	for i, arg := range call.Arguments {
+		reg, err := a.analyzeExpression(arg, irFunc)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = reg
+	}
+	resultType := &ir.BasicType{Kind: builtin.result}
+	inst := ir.Instruction{
+		Op:      ir.OpStrCall,
+		Dest:    irFunc.AllocReg(),
+		Src1:    args[0],
+		Src2:    args[1],
+		Symbol:  name,
+		Type:    resultType,
+		Comment: name,
+	}
+	if hasCapacity {
+		reg := irFunc.AllocReg()
+		irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+			Op:      ir.OpLoadConst,
+			Dest:    reg,
+			Imm:     capacity,
+			Type:    &ir.BasicType{Kind: ir.TypeU8},
+			Comment: name + " capacity",
+		})
+		inst.Args = []ir.Register{reg}
+	} else if builtin.writes {
+		inst.Args = []ir.Register{args[2]}
+	}
+	irFunc.Instructions = append(irFunc.Instructions, inst)
+	a.exprTypes[call] = resultType
+	return inst.Dest, nil
+}
@@ -0,0 +1,112 @@
+package semantic
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// analyzeString analyzes
+//
+//	fun f(a: *u8, b: *u8, name: *[u8; 9]) -> <ret> { return <call>; }
+//
+// and returns f's instructions
+func analyzeString(ret string, call *ast.CallExpr) ([]ir.Instruction, error) {
+	u8 := &ast.PrimitiveType{Name: "u8"}
+	file := &ast.File{
+		Name: "f.minz",
+		Declarations: []ast.Declaration{&ast.FunctionDecl{
+			Name: "f",
+			Params: []*ast.Parameter{
+				{Name: "a", Type: &ast.PointerType{BaseType: u8}},
+				{Name: "b", Type: &ast.PointerType{BaseType: u8}},
+				{Name: "name", Type: &ast.PointerType{BaseType: &ast.ArrayType{ElementType: u8, Size: &ast.NumberLiteral{Value: 9}}, IsMutable: true}},
+			},
+			ReturnType: &ast.PrimitiveType{Name: ret},
+			Body:       &ast.BlockStmt{Statements: []ast.Statement{&ast.ReturnStmt{Value: call}}},
+		}},
+	}
+
+	module, err := NewAnalyzer().Analyze(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range module.Functions {
+		if strings.Contains(fn.Name, ".f") {
+			return fn.Instructions, nil
+		}
+	}
+	return nil, fmt.Errorf("f not generated")
+}
+
+func stringCallExpr(name string, args ...ast.Expression) *ast.CallExpr {
+	return &ast.CallExpr{Function: ident(name), Arguments: args}
+}
+
+func TestStringLowering(t *testing.T) {
+	a, b, name := ident("a"), ident("b"), ident("name")
+	tests := []struct {
+		name string
+		ret  string
+		call *ast.CallExpr
+		want string // Rendered call and its capacity, if any
+	}{
+		{"compare", "i8", stringCallExpr("str_cmp", a, b), "str_cmp i8"},
+		{"literal", "u8", stringCallExpr("str_index", a, &ast.StringLiteral{Value: "="}), "str_index u8"},
+		{"array capacity", "bool", stringCallExpr("str_copy", name, a), "str_copy bool cap=8"},
+		{"given capacity", "bool", stringCallExpr("str_cat", name, b, number(4)), "str_cat bool"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			insts, err := analyzeString(tt.ret, tt.call)
+			if err != nil {
+				t.Fatalf("analysis failed: %v", err)
+			}
+			capacities := make(map[ir.Register]int64)
+			var got []string
+			for _, inst := range insts {
+				if inst.Op == ir.OpLoadConst && strings.HasSuffix(inst.Comment, " capacity") {
+					capacities[inst.Dest] = inst.Imm
+				}
+				if inst.Op != ir.OpStrCall {
+					continue
+				}
+				call := fmt.Sprintf("%s %s", inst.Symbol, inst.Type)
+				if len(inst.Args) == 1 {
+					if n, ok := capacities[inst.Args[0]]; ok {
+						call += fmt.Sprintf(" cap=%d", n)
+					}
+				}
+				got = append(got, call)
+			}
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("string calls = %q, want [%q]", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStringErrors(t *testing.T) {
+	a, b, name := ident("a"), ident("b"), ident("name")
+	tests := []struct {
+		call *ast.CallExpr
+		want string
+	}{
+		{stringCallExpr("str_cmp", a, number(5)), "str_cmp argument 2 must be a string or a u8 buffer"},
+		{stringCallExpr("str_index", a), "str_index expects two strings, got 1 arguments"},
+		{stringCallExpr("str_cat", a, b), "str_cat needs a capacity"},
+		{stringCallExpr("str_copy", name, a, number(9)), "str_copy capacity 9 exceeds the 8 characters the destination holds"},
+		{stringCallExpr("str_copy", a, b, number(300)), "str_copy capacity 300 is out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			_, err := analyzeString("u8", tt.call)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
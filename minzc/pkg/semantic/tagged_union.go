@@ -0,0 +1,226 @@
+package semantic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// Enum variants can carry data, making the enum a tagged union:
+//
+//	enum Shape { Empty, Circle(u8), Rect(u8, u8) }
+//	let s: Shape = Shape.Rect(3, 4);
+//	case s {
+//	    Shape.Rect(w, h) => area = w * h,
+//	    Shape.Circle(_) => area = 0,
+//	    Shape.Empty => area = 0,
+//	}
+//
+// A value of such an enum is the address of its tag (the variant's
+// discriminant, stored as the backing type) followed by the payload fields
+// in order, with room for the largest payload. Each constructor expression
+// owns a static buffer, as locals do under absolute addressing, so a value
+// lives until the same expression builds the next one.
+//
+// A case over an enum loads the tag once and compares it with each arm's
+// variant; the Z80 backend turns each compare and branch into CP n / JP Z.
+// The arms must cover every variant, or end with _ or a binding.
+
+// enumPayloads resolves the payload types of an enum's variants
+func (a *Analyzer) enumPayloads(e *ast.EnumDecl, enumType *ir.EnumType) error {
+	for _, variant := range e.Variants {
+		fields := e.Payloads[variant]
+		if len(fields) == 0 {
+			continue
+		}
+		if enumType.Payloads == nil {
+			enumType.Payloads = make(map[string][]ir.Type)
+		}
+		for i, field := range fields {
+			fieldType, err := a.convertType(field)
+			if err != nil {
+				return fmt.Errorf("payload %d of %s.%s: %w", i+1, e.Name, variant, err)
+			}
+			enumType.Payloads[variant] = append(enumType.Payloads[variant], fieldType)
+		}
+	}
+	for variant := range e.Payloads {
+		if _, exists := enumType.Variants[variant]; !exists {
+			return fmt.Errorf("payload for unknown variant %s in enum %s", variant, e.Name)
+		}
+	}
+	return nil
+}
+
+// variantCall reports whether call constructs an enum variant, as in
+// Shape.Circle(5), and returns the enum and variant
+func (a *Analyzer) variantCall(call *ast.CallExpr) (*ir.EnumType, string, bool) {
+	field, ok := call.Function.(*ast.FieldExpr)
+	if !ok {
+		return nil, "", false
+	}
+	id, ok := field.Object.(*ast.Identifier)
+	if !ok {
+		return nil, "", false
+	}
+	typeSym, ok := a.currentScope.Lookup(id.Name).(*TypeSymbol)
+	if !ok {
+		return nil, "", false
+	}
+	enumType, ok := typeSym.Type.(*ir.EnumType)
+	if !ok {
+		return nil, "", false
+	}
+	if _, isVariant := enumType.Variants[field.Field]; !isVariant {
+		return nil, "", false
+	}
+	return enumType, field.Field, true
+}
+
+// analyzeVariantValue builds a value of a tagged-union enum in its static
+// buffer and returns the buffer's address
+func (a *Analyzer) analyzeVariantValue(node ast.Expression, enumType *ir.EnumType, variant string,
+	args []ast.Expression, irFunc *ir.Function) (ir.Register, error) {
+	tag, exists := enumType.Variants[variant]
+	if !exists {
+		return 0, fmt.Errorf("no variant %s in enum %s", variant, enumType.Name)
+	}
+	fields := enumType.Payloads[variant]
+	if len(args) != len(fields) {
+		return 0, fmt.Errorf("%s.%s expects %d arguments, got %d", enumType.Name, variant, len(fields), len(args))
+	}
+
+	buffer := a.prefixSymbol(a.generateLabel(fmt.Sprintf("%s_%s", enumType.Name, variant)))
+	a.module.Globals = append(a.module.Globals, ir.Global{Name: buffer, Type: enumType})
+
+	valueReg := irFunc.AllocReg()
+	tagReg := irFunc.AllocReg()
+	irFunc.Instructions = append(irFunc.Instructions,
+		ir.Instruction{Op: ir.OpLoadAddr, Dest: valueReg, Symbol: buffer, Type: enumType},
+		ir.Instruction{Op: ir.OpLoadConst, Dest: tagReg, Imm: int64(tag), Type: enumType.BackingType()},
+		ir.Instruction{
+			Op:      ir.OpStoreField,
+			Src1:    valueReg,
+			Src2:    tagReg,
+			Type:    enumType.BackingType(),
+			Comment: fmt.Sprintf("%s.%s tag", enumType.Name, variant),
+		},
+	)
+	for i, arg := range args {
+		argReg, err := a.analyzeExpression(arg, irFunc)
+		if err != nil {
+			return 0, err
+		}
+		argType, err := a.inferType(arg)
+		if err == nil && !a.typesCompatible(fields[i], argType) {
+			return 0, fmt.Errorf("type mismatch: payload %d of %s.%s is %s, got %s",
+				i+1, enumType.Name, variant, fields[i], argType)
+		}
+		irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+			Op:      ir.OpStoreField,
+			Src1:    valueReg,
+			Src2:    argReg,
+			Imm:     int64(enumType.PayloadOffset(variant, i)),
+			Type:    fields[i],
+			Comment: fmt.Sprintf("%s.%s payload %d", enumType.Name, variant, i+1),
+		})
+	}
+
+	a.exprTypes[node] = enumType
+	return valueReg, nil
+}
+
+// caseSubject returns the register a case compares its patterns with: the
+// tag of a tagged union, otherwise the value itself
+func (a *Analyzer) caseSubject(valueReg ir.Register, valueType ir.Type, irFunc *ir.Function) ir.Register {
+	enumType, ok := valueType.(*ir.EnumType)
+	if !ok || !enumType.HasPayloads() {
+		return valueReg
+	}
+	tagReg := irFunc.AllocReg()
+	irFunc.Instructions = append(irFunc.Instructions, ir.Instruction{
+		Op:      ir.OpLoadField,
+		Dest:    tagReg,
+		Src1:    valueReg,
+		Type:    enumType.BackingType(),
+		Comment: fmt.Sprintf("%s tag", enumType.Name),
+	})
+	return tagReg
+}
+
+// bindVariantPayload defines the names an enum pattern binds, loading each
+// from the matched value's payload
+func (a *Analyzer) bindVariantPayload(pattern *ast.EnumPattern, valueReg ir.Register, valueType ir.Type, irFunc *ir.Function) error {
+	if len(pattern.Bindings) == 0 {
+		return nil
+	}
+	enumType, ok := valueType.(*ir.EnumType)
+	if !ok {
+		return fmt.Errorf("pattern %s.%s matches a %v value", pattern.EnumType, pattern.Variant, valueType)
+	}
+	fields := enumType.Payloads[pattern.Variant]
+	if len(pattern.Bindings) != len(fields) {
+		return fmt.Errorf("pattern %s.%s binds %d values, but the variant carries %d",
+			pattern.EnumType, pattern.Variant, len(pattern.Bindings), len(fields))
+	}
+	for i, name := range pattern.Bindings {
+		if name == "_" {
+			continue
+		}
+		reg := irFunc.AddLocal(name, fields[i])
+		fieldReg := irFunc.AllocReg()
+		irFunc.Instructions = append(irFunc.Instructions,
+			ir.Instruction{
+				Op:      ir.OpLoadField,
+				Dest:    fieldReg,
+				Src1:    valueReg,
+				Imm:     int64(enumType.PayloadOffset(pattern.Variant, i)),
+				Type:    fields[i],
+				Comment: fmt.Sprintf("%s.%s payload %d", enumType.Name, pattern.Variant, i+1),
+			},
+			ir.Instruction{Op: ir.OpStoreVar, Dest: reg, Src1: fieldReg, Symbol: name, Type: fields[i]},
+		)
+		a.currentScope.Define(name, &VarSymbol{Name: name, Type: fields[i], Reg: reg})
+	}
+	return nil
+}
+
+// checkExhaustive reports the variants of an enum that no arm of a case
+// matches. Arms with a guard may not match, so only _, a binding or an
+// unguarded variant pattern covers anything.
+func checkExhaustive(arms []ast.CaseArm, valueType ir.Type) error {
+	enumType, ok := valueType.(*ir.EnumType)
+	if !ok {
+		return nil
+	}
+	covered := make(map[string]bool)
+	for _, arm := range arms {
+		if arm.Guard != nil {
+			continue
+		}
+		switch p := arm.Pattern.(type) {
+		case *ast.WildcardPattern:
+			return nil
+		case *ast.IdentifierPattern:
+			dot := strings.LastIndex(p.Name, ".")
+			if dot < 0 {
+				return nil
+			}
+			covered[p.Name[dot+1:]] = true
+		case *ast.EnumPattern:
+			covered[p.Variant] = true
+		}
+	}
+	var missing []string
+	for _, variant := range enumVariantNames(enumType) {
+		if !covered[variant] {
+			missing = append(missing, enumType.Name+"."+variant)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("non-exhaustive case on %s: missing %s", enumType.Name, strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package semantic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/minz/minzc/pkg/ast"
+	"github.com/minz/minzc/pkg/ir"
+)
+
+// shapeFile declares enum Shape { Empty, Circle(u8), Rect(u8, u8) } and
+// fun area(s: Shape) -> u8 { case s { arms } return 0; }
+func shapeFile(arms ...ast.CaseArm) *ast.File {
+	u8 := &ast.PrimitiveType{Name: "u8"}
+	return &ast.File{
+		Name: "shapes.minz",
+		Declarations: []ast.Declaration{
+			&ast.EnumDecl{
+				Name:     "Shape",
+				Variants: []string{"Empty", "Circle", "Rect"},
+				Payloads: map[string][]ast.Type{"Circle": {u8}, "Rect": {u8, u8}},
+			},
+			&ast.FunctionDecl{
+				Name:       "area",
+				Params:     []*ast.Parameter{{Name: "s", Type: &ast.TypeIdentifier{Name: "Shape"}}},
+				ReturnType: u8,
+				Body: &ast.BlockStmt{Statements: []ast.Statement{
+					&ast.CaseStmt{Value: &ast.Identifier{Name: "s"}, Arms: arms},
+					&ast.ReturnStmt{Value: &ast.NumberLiteral{Value: 0}},
+				}},
+			},
+		},
+	}
+}
+
+// shapeArm is an arm matching a Shape variant that returns its last binding,
+// or 0 without any
+func shapeArm(variant string, bindings ...string) ast.CaseArm {
+	var value ast.Expression = &ast.NumberLiteral{Value: 0}
+	if len(bindings) > 0 {
+		value = &ast.Identifier{Name: bindings[len(bindings)-1]}
+	}
+	return ast.CaseArm{
+		Pattern: &ast.EnumPattern{EnumType: "Shape", Variant: variant, Bindings: bindings},
+		Body:    &ast.BlockStmt{Statements: []ast.Statement{&ast.ReturnStmt{Value: value}}},
+	}
+}
+
+func TestTaggedUnionLayout(t *testing.T) {
+	module, err := NewAnalyzer().Analyze(shapeFile(
+		shapeArm("Rect", "_", "h"),
+		shapeArm("Circle", "r"),
+		shapeArm("Empty"),
+	))
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	var shape *ir.EnumType
+	for _, fn := range module.Functions {
+		for _, param := range fn.Params {
+			shape, _ = param.Type.(*ir.EnumType)
+		}
+	}
+	if shape == nil {
+		t.Fatal("no Shape parameter")
+	}
+	if shape.Size() != 3 || shape.PayloadOffset("Rect", 1) != 2 || shape.PayloadOffset("Circle", 0) != 1 {
+		t.Errorf("Shape is %d bytes with Rect payload 2 at %d, want 3 and 2",
+			shape.Size(), shape.PayloadOffset("Rect", 1))
+	}
+
+	// The tag is loaded once, then each binding from its payload offset
+	var loads []int64
+	for _, inst := range module.Functions[0].Instructions {
+		if inst.Op == ir.OpLoadField {
+			loads = append(loads, inst.Imm)
+		}
+	}
+	if want := []int64{0, 2, 1}; len(loads) != len(want) || loads[0] != 0 || loads[1] != 2 || loads[2] != 1 {
+		t.Errorf("field loads at offsets %v, want %v", loads, want)
+	}
+}
+
+func TestTaggedUnionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		arms []ast.CaseArm
+		want string
+	}{
+		{
+			"missing variants",
+			[]ast.CaseArm{shapeArm("Rect", "w", "h")},
+			"non-exhaustive case on Shape: missing Shape.Empty, Shape.Circle",
+		},
+		{
+			"binding count",
+			[]ast.CaseArm{shapeArm("Rect", "w"), shapeArm("Circle", "r"), shapeArm("Empty")},
+			"pattern Shape.Rect binds 1 values, but the variant carries 2",
+		},
+		{
+			"guarded arm",
+			[]ast.CaseArm{
+				shapeArm("Circle", "r"),
+				shapeArm("Empty"),
+				{Pattern: &ast.EnumPattern{EnumType: "Shape", Variant: "Rect", Bindings: []string{"w", "h"}},
+					Guard: &ast.BooleanLiteral{Value: true},
+					Body:  &ast.BlockStmt{}},
+			},
+			"missing Shape.Rect",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewAnalyzer().Analyze(shapeFile(tt.arms...))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	wildcard := []ast.CaseArm{shapeArm("Rect", "w", "h"), {Pattern: &ast.WildcardPattern{}, Body: &ast.BlockStmt{}}}
+	if _, err := NewAnalyzer().Analyze(shapeFile(wildcard...)); err != nil {
+		t.Errorf("case ending with _ failed: %v", err)
+	}
+}
@@ -325,4 +325,70 @@ func (stats CompressionStats) Render() string {
 	output += "╚═══════════════════════════════════════════════════════════════════╝"
 	
 	return output
-}
\ No newline at end of file
+}
+// rleEncode packs data with run-length encoding: a control byte c below
+// 0x80 is followed by c+1 literal bytes, one from 0x80 up by a single byte
+// repeated c-0x80+3 times. Images XORed with the previous keyframe are
+// mostly zeros, which this packs 130 to 2.
+func rleEncode(data []byte) []byte {
+	var out []byte
+	literal := 0 // Start of the pending literal bytes
+	flush := func(end int) {
+		for literal < end {
+			n := end - literal
+			if n > 128 {
+				n = 128
+			}
+			out = append(out, byte(n-1))
+			out = append(out, data[literal:literal+n]...)
+			literal += n
+		}
+	}
+	for i := 0; i < len(data); {
+		run := 1
+		for i+run < len(data) && data[i+run] == data[i] && run < 130 {
+			run++
+		}
+		if run < 3 {
+			i += run
+			continue
+		}
+		flush(i)
+		out = append(out, byte(0x80+run-3), data[i])
+		i += run
+		literal = i
+	}
+	flush(len(data))
+	return out
+}
+
+// rleDecode unpacks data packed by rleEncode, which must give size bytes
+func rleDecode(data []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for i := 0; i < len(data); {
+		c := int(data[i])
+		i++
+		if c < 0x80 {
+			if i+c+1 > len(data) {
+				return nil, fmt.Errorf("RLE literal runs past the end of the data")
+			}
+			out = append(out, data[i:i+c+1]...)
+			i += c + 1
+		} else {
+			if i >= len(data) {
+				return nil, fmt.Errorf("RLE run without a value")
+			}
+			for n := c - 0x80 + 3; n > 0; n-- {
+				out = append(out, data[i])
+			}
+			i++
+		}
+		if len(out) > size {
+			break
+		}
+	}
+	if len(out) != size {
+		return nil, fmt.Errorf("RLE data unpacks to %d bytes, want %d", len(out), size)
+	}
+	return out, nil
+}
@@ -2,6 +2,7 @@ package tas
 
 import (
 	"fmt"
+	"path/filepath"
 )
 
 // Z80Emulator interface for Z80 emulation
@@ -60,8 +61,8 @@ type Z80Emulator interface {
 	SetTStates(uint64)
 	GetMemory() []byte
 	SetMemory([]byte)
-	ReadByte(uint16) byte
-	WriteByte(uint16, byte)
+	Peek(uint16) byte
+	Poke(uint16, byte)
 	GetBorder() byte
 	GetLastOpcode() string
 	GetRegisters() *CPURegisters
@@ -185,7 +186,7 @@ func NewTASDebugger(emu Z80Emulator) *TASDebugger {
 	
 	return &TASDebugger{
 		emulator:       emu,
-		stateHistory:   make([]StateSnapshot, 0, 1000000),
+		stateHistory:   make([]StateSnapshot, 0, 256), // Snapshots are 70KB each: grow as needed
 		saveStates:     make(map[string]*StateSnapshot),
 		inputLog:       make([]InputEvent, 0, 10000),
 		smcEvents:      make([]SMCEvent, 0, 1000),
@@ -215,12 +216,43 @@ func (t *TASDebugger) SetRecordingStrategy(strategy RecordingStrategy) {
 	fmt.Printf("📼 Recording strategy changed to: %s\n", strategyNames[strategy])
 }
 
+// StartRecording begins recording frames
+func (t *TASDebugger) StartRecording() {
+	t.recording = true
+}
+
+// StopRecording stops recording; the recorded timeline is kept
+func (t *TASDebugger) StopRecording() {
+	t.recording = false
+}
+
+// IsRecording reports whether frames are being recorded
+func (t *TASDebugger) IsRecording() bool {
+	return t.recording
+}
+
+// CurrentFrame returns the position in the timeline. It equals FrameCount
+// at the present, and is lower after a rewind.
+func (t *TASDebugger) CurrentFrame() int64 {
+	return t.currentFrame
+}
+
+// FrameCount returns the number of recorded frames
+func (t *TASDebugger) FrameCount() int {
+	return len(t.stateHistory)
+}
+
 // RecordFrame captures current state - called every instruction or frame
 func (t *TASDebugger) RecordFrame() {
 	if !t.recording {
 		return
 	}
 	
+	// Recording after a rewind starts a new timeline from here
+	if t.currentFrame < int64(len(t.stateHistory)) {
+		t.stateHistory = t.stateHistory[:t.currentFrame]
+	}
+	
 	snapshot := t.captureState()
 	cycle := int64(t.emulator.GetCycles())
 	
@@ -379,6 +411,11 @@ func (t *TASDebugger) Rewind(frames int) error {
 			targetFrame, len(t.stateHistory))
 	}
 	
+	// Keep the present so Forward can return to it
+	if t.currentFrame == int64(len(t.stateHistory)) {
+		t.stateHistory = append(t.stateHistory, t.captureState())
+	}
+	
 	// Restore the state
 	t.restoreState(&t.stateHistory[targetFrame])
 	t.currentFrame = targetFrame
@@ -386,6 +423,24 @@ func (t *TASDebugger) Rewind(frames int) error {
 	return nil
 }
 
+// Forward goes forward in time again after a rewind
+func (t *TASDebugger) Forward(frames int) error {
+	last := int64(len(t.stateHistory)) - 1
+	if t.currentFrame >= last {
+		return fmt.Errorf("already at the latest frame")
+	}
+	
+	targetFrame := t.currentFrame + int64(frames)
+	if targetFrame > last {
+		targetFrame = last
+	}
+	
+	t.restoreState(&t.stateHistory[targetFrame])
+	t.currentFrame = targetFrame
+	
+	return nil
+}
+
 // SaveState creates a named save state (like TAS save slots)
 func (t *TASDebugger) SaveState(name string) {
 	snapshot := t.captureState()
@@ -592,8 +647,8 @@ func (t *TASDebugger) getStackTrace() []uint16 {
 	
 	for i := 0; i < 16 && sp < 0xFFFF; i++ {
 		// Read potential return address from stack
-		low := t.emulator.ReadByte(sp)
-		high := t.emulator.ReadByte(sp + 1)
+		low := t.emulator.Peek(sp)
+		high := t.emulator.Peek(sp + 1)
 		addr := uint16(high)<<8 | uint16(low)
 		
 		// Simple heuristic: likely a code address
@@ -619,15 +674,14 @@ func (t *TASDebugger) ExportReplay(filename string) error {
 	// Use the TAS file format
 	tasFile := CreateReplay(t)
 	
-	// Determine format from extension
-	format := uint8(TASFormatJSON)
-	if len(filename) > 5 {
-		switch filename[len(filename)-5:] {
-		case ".tasb":
-			format = TASFormatBinary
-		case ".tasc", "as.gz":
-			format = TASFormatCompressed
-		}
+	// Determine format from extension: compressed binary unless asked
+	// for .json or uncompressed .tasb
+	format := uint8(TASFormatCompressed)
+	switch filepath.Ext(filename) {
+	case ".json":
+		format = TASFormatJSON
+	case ".tasb":
+		format = TASFormatBinary
 	}
 	
 	return tasFile.SaveToFile(filename, format)
@@ -645,9 +699,8 @@ func (t *TASDebugger) ImportReplay(filename string) error {
 	t.inputLog = tasFile.Events.Inputs
 	t.smcEvents = tasFile.Events.SMCEvents
 	
-	if len(t.stateHistory) > 0 {
-		t.currentFrame = int64(t.stateHistory[len(t.stateHistory)-1].Frame)
-	}
+	// The emulator is left as it is: at the present, after the last frame
+	t.currentFrame = int64(len(t.stateHistory))
 	
 	return nil
 }
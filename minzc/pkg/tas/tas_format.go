@@ -2,16 +2,17 @@ package tas
 
 import (
 	"bytes"
-	"compress/gzip"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"time"
 )
 
-// TAS file format constants
+// TAS file format constants: JSON for reading and editing by hand, binary
+// (.tasb) and compressed binary (.tas) for sharing recordings
 const (
 	TASMagic       = "MINZTAS\x00"
 	TASVersion     = 1
@@ -38,7 +39,7 @@ type TASHeader struct {
 	Format     uint8     `json:"format"`
 	Flags      uint8     `json:"flags"`
 	Created    time.Time `json:"created"`
-	Checksum   uint32    `json:"checksum"`
+	Checksum   uint32    `json:"checksum"` // CRC-32 of the body in the binary formats
 }
 
 // TASMetadata contains recording metadata
@@ -91,10 +92,8 @@ func (t *TASFile) SaveToFile(filename string, format uint8) error {
 	switch format {
 	case TASFormatJSON:
 		return t.saveJSON(filename)
-	case TASFormatBinary:
+	case TASFormatBinary, TASFormatCompressed:
 		return t.saveBinary(filename)
-	case TASFormatCompressed:
-		return t.saveCompressed(filename)
 	default:
 		return fmt.Errorf("unknown format: %d", format)
 	}
@@ -102,32 +101,18 @@ func (t *TASFile) SaveToFile(filename string, format uint8) error {
 
 // LoadFromFile loads TAS recording from file
 func LoadFromFile(filename string) (*TASFile, error) {
-	// Open file
-	file, err := os.Open(filename)
+	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-	
-	// Read magic header
-	magic := make([]byte, 8)
-	if _, err := file.Read(magic); err != nil {
-		return nil, err
-	}
-	
-	// Reset to beginning
-	file.Seek(0, 0)
 	
 	// Detect format
-	if string(magic) == TASMagic {
-		// Binary format
-		return loadBinary(file)
-	} else if magic[0] == '{' {
+	if bytes.HasPrefix(data, []byte(TASMagic)) {
+		// Binary format, compressed or not
+		return loadBinary(data)
+	} else if len(data) > 0 && data[0] == '{' {
 		// JSON format
-		return loadJSON(file)
-	} else if magic[0] == 0x1f && magic[1] == 0x8b {
-		// Gzipped format
-		return loadCompressed(file)
+		return loadJSON(bytes.NewReader(data))
 	}
 	
 	return nil, fmt.Errorf("unknown file format")
@@ -146,668 +131,414 @@ func (t *TASFile) saveJSON(filename string) error {
 	return encoder.Encode(t)
 }
 
-// saveBinary saves in efficient binary format
-func (t *TASFile) saveBinary(filename string) error {
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-	
-	// Write header
-	if err := binary.Write(file, binary.LittleEndian, t.Header); err != nil {
-		return err
-	}
-	
-	// Write metadata as JSON (for flexibility)
-	metaBytes, err := json.Marshal(t.Metadata)
-	if err != nil {
-		return err
-	}
-	
-	// Write metadata length and data
-	if err := binary.Write(file, binary.LittleEndian, uint32(len(metaBytes))); err != nil {
-		return err
-	}
-	if _, err := file.Write(metaBytes); err != nil {
-		return err
-	}
-	
-	// Write events
-	if err := t.writeEvents(file); err != nil {
-		return err
-	}
-	
-	// Write state snapshots if present
-	if len(t.States) > 0 {
-		if err := t.writeStates(file); err != nil {
-			return err
-		}
+// Load functions
+
+func loadJSON(r io.Reader) (*TASFile, error) {
+	var tas TASFile
+	decoder := json.NewDecoder(r)
+	if err := decoder.Decode(&tas); err != nil {
+		return nil, err
 	}
-	
-	return nil
+	return &tas, nil
 }
 
-// saveCompressed saves with gzip compression
-func (t *TASFile) saveCompressed(filename string) error {
-	file, err := os.Create(filename)
+// The binary format is a fixed header followed by a body:
+//
+//	header  "MINZTAS\0", version u16, format u8, flags u8,
+//	        created i64 (Unix nanoseconds), CRC-32 of the body u32
+//	body    metadata  u32 length, then JSON
+//	        events    inputs, SMC events and I/O events: for each a
+//	                  varint count, then the events with their cycles
+//	                  as varint deltas from the one before
+//	        states    varint count; the first state is where the
+//	                  recording starts, the rest are keyframes to seek to
+//
+// A state is its registers followed by the memory and screen images. In
+// the compressed format each image is stored as its XOR with the image of
+// the previous state, run-length encoded, so a keyframe costs little more
+// than the bytes that changed since the last one. Numbers are little-endian
+// and varints are zigzag LEB128 (see writeVarInt).
+
+// fileHeader is the header of a binary TAS file as it is on disk
+type fileHeader struct {
+	Magic    [8]byte
+	Version  uint16
+	Format   uint8
+	Flags    uint8
+	Created  int64
+	Checksum uint32
+}
+
+// stateRecord is the fixed-size part of a state in a binary TAS file
+type stateRecord struct {
+	Cycle, Frame, TStates          uint64
+	PC, SP, IX, IY                 uint16
+	A, F, B, C, D, E, H, L         byte
+	A_, F_, B_, C_, D_, E_, H_, L_ byte
+	I, R                           byte
+	IFF1, IFF2                     bool
+	Border                         byte
+}
+
+// saveBinary saves in the binary format, with compressed state images if
+// the header's format is TASFormatCompressed
+func (t *TASFile) saveBinary(filename string) error {
+	data, err := t.encodeBinary()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	
-	// Create gzip writer
-	gz := gzip.NewWriter(file)
-	defer gz.Close()
-	
-	// Set metadata
-	gz.Header.Name = filename
-	gz.Header.Comment = fmt.Sprintf("MinZ TAS Recording v%d", TASVersion)
-	gz.Header.ModTime = time.Now()
-	
-	// Use binary format inside gzip
-	t.Header.Format = TASFormatBinary
-	
-	// Create buffer for binary data
-	var buf bytes.Buffer
-	
-	// Write header
-	if err := binary.Write(&buf, binary.LittleEndian, t.Header); err != nil {
-		return err
-	}
-	
-	// Write rest of data
+	return os.WriteFile(filename, data, 0644)
+}
+
+// encodeBinary returns the file image of the recording in the binary format
+func (t *TASFile) encodeBinary() ([]byte, error) {
+	compressed := t.Header.Format == TASFormatCompressed
+
+	var body bytes.Buffer
 	metaBytes, err := json.Marshal(t.Metadata)
 	if err != nil {
-		return err
-	}
-	
-	if err := binary.Write(&buf, binary.LittleEndian, uint32(len(metaBytes))); err != nil {
-		return err
-	}
-	buf.Write(metaBytes)
-	
-	// Write events efficiently
-	if err := t.writeEventsCompact(&buf); err != nil {
-		return err
+		return nil, err
 	}
-	
-	// Compress and write
-	_, err = gz.Write(buf.Bytes())
-	return err
-}
+	binary.Write(&body, binary.LittleEndian, uint32(len(metaBytes)))
+	body.Write(metaBytes)
 
-// writeEvents writes events in binary format
-func (t *TASFile) writeEvents(w io.Writer) error {
-	// Write input events count and data
-	if err := binary.Write(w, binary.LittleEndian, uint32(len(t.Events.Inputs))); err != nil {
-		return err
-	}
-	for _, input := range t.Events.Inputs {
-		if err := writeInputEvent(w, input); err != nil {
-			return err
-		}
-	}
-	
-	// Write SMC events
-	if err := binary.Write(w, binary.LittleEndian, uint32(len(t.Events.SMCEvents))); err != nil {
-		return err
-	}
-	for _, smc := range t.Events.SMCEvents {
-		if err := writeSMCEvent(w, smc); err != nil {
-			return err
-		}
+	t.writeEvents(&body)
+
+	writeVarInt(&body, int64(len(t.States)))
+	prev := &StateSnapshot{}
+	for i := range t.States {
+		writeState(&body, &t.States[i], prev, compressed)
+		prev = &t.States[i]
 	}
-	
-	// Write IO events
-	if err := binary.Write(w, binary.LittleEndian, uint32(len(t.Events.IOEvents))); err != nil {
-		return err
+
+	t.Header.Checksum = crc32.ChecksumIEEE(body.Bytes())
+	header := fileHeader{
+		Version:  t.Header.Version,
+		Format:   t.Header.Format,
+		Flags:    t.Header.Flags,
+		Created:  t.Header.Created.UnixNano(),
+		Checksum: t.Header.Checksum,
 	}
-	for _, io := range t.Events.IOEvents {
-		if err := writeIOEvent(w, io); err != nil {
-			return err
-		}
+	copy(header.Magic[:], TASMagic)
+
+	var file bytes.Buffer
+	if err := binary.Write(&file, binary.LittleEndian, header); err != nil {
+		return nil, err
 	}
-	
-	return nil
+	file.Write(body.Bytes())
+	return file.Bytes(), nil
 }
 
-// writeEventsCompact writes events with delta compression
-func (t *TASFile) writeEventsCompact(w io.Writer) error {
-	var lastCycle int64 = 0
-	
-	// Merge all events and sort by cycle
-	type timedEvent struct {
-		cycle     int64
-		eventType byte
-		data      interface{}
-	}
-	
-	var events []timedEvent
-	
-	// Add all events
+// writeEvents writes the event log, each kind in cycle order
+func (t *TASFile) writeEvents(w *bytes.Buffer) {
+	writeVarInt(w, int64(len(t.Events.Inputs)))
+	var last int64
 	for _, input := range t.Events.Inputs {
-		events = append(events, timedEvent{int64(input.Cycle), 'I', input})
+		writeVarInt(w, int64(input.Cycle)-last)
+		last = int64(input.Cycle)
+		writeVarInt(w, int64(input.Frame))
+		binary.Write(w, binary.LittleEndian, input.Port)
+		w.WriteByte(input.Value)
+		writeString(w, input.Type)
 	}
+
+	writeVarInt(w, int64(len(t.Events.SMCEvents)))
+	last = 0
 	for _, smc := range t.Events.SMCEvents {
-		events = append(events, timedEvent{int64(smc.Cycle), 'S', smc})
+		writeVarInt(w, int64(smc.Cycle)-last)
+		last = int64(smc.Cycle)
+		binary.Write(w, binary.LittleEndian, smc.PC)
+		binary.Write(w, binary.LittleEndian, smc.Address)
+		w.WriteByte(smc.OldValue)
+		w.WriteByte(smc.NewValue)
+		writeString(w, smc.Reason)
 	}
+
+	writeVarInt(w, int64(len(t.Events.IOEvents)))
+	last = 0
 	for _, io := range t.Events.IOEvents {
-		events = append(events, timedEvent{io.Cycle, 'O', io})
-	}
-	
-	// Sort by cycle (already sorted in practice)
-	// Write total event count
-	if err := binary.Write(w, binary.LittleEndian, uint32(len(events))); err != nil {
-		return err
-	}
-	
-	// Write events with delta encoding
-	for _, evt := range events {
-		// Write delta cycle (usually small)
-		delta := evt.cycle - lastCycle
-		if err := writeVarInt(w, delta); err != nil {
-			return err
-		}
-		lastCycle = evt.cycle
-		
-		// Write event type
-		if err := binary.Write(w, binary.LittleEndian, evt.eventType); err != nil {
-			return err
-		}
-		
-		// Write event data
-		switch evt.eventType {
-		case 'I':
-			if err := writeInputEventCompact(w, evt.data.(InputEvent)); err != nil {
-				return err
-			}
-		case 'S':
-			if err := writeSMCEventCompact(w, evt.data.(SMCEvent)); err != nil {
-				return err
-			}
-		case 'O':
-			if err := writeIOEventCompact(w, evt.data.(IOEvent)); err != nil {
-				return err
-			}
+		writeVarInt(w, io.Cycle-last)
+		last = io.Cycle
+		// Bit 15 of the port holds the direction
+		portAndDir := io.Port & 0x7FFF
+		if io.IsInput {
+			portAndDir |= 0x8000
 		}
+		binary.Write(w, binary.LittleEndian, portAndDir)
+		w.WriteByte(io.Value)
 	}
-	
-	return nil
 }
 
-// writeStates writes state snapshots
-func (t *TASFile) writeStates(w io.Writer) error {
-	// Write count
-	if err := binary.Write(w, binary.LittleEndian, uint32(len(t.States))); err != nil {
-		return err
+// writeState writes a state, with its images relative to prev if compressed
+func writeState(w *bytes.Buffer, state, prev *StateSnapshot, compressed bool) {
+	binary.Write(w, binary.LittleEndian, stateRecord{
+		Cycle: state.Cycle, Frame: state.Frame, TStates: state.TStates,
+		PC: state.PC, SP: state.SP, IX: state.IX, IY: state.IY,
+		A: state.A, F: state.F, B: state.B, C: state.C, D: state.D, E: state.E, H: state.H, L: state.L,
+		A_: state.A_, F_: state.F_, B_: state.B_, C_: state.C_, D_: state.D_, E_: state.E_, H_: state.H_, L_: state.L_,
+		I: state.I, R: state.R,
+		IFF1: state.IFF1, IFF2: state.IFF2,
+		Border: state.Border,
+	})
+	writeString(w, state.LastOpcode)
+	writeVarInt(w, int64(len(state.StackTrace)))
+	for _, addr := range state.StackTrace {
+		binary.Write(w, binary.LittleEndian, addr)
 	}
-	
-	// Write each state
-	for _, state := range t.States {
-		if err := writeStateSnapshot(w, state); err != nil {
-			return err
-		}
-	}
-	
-	return nil
-}
 
-// Helper functions for writing individual events
-
-func writeInputEvent(w io.Writer, evt InputEvent) error {
-	if err := binary.Write(w, binary.LittleEndian, evt.Cycle); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, evt.Port); err != nil {
-		return err
-	}
-	return binary.Write(w, binary.LittleEndian, evt.Value)
+	writeImage(w, state.Memory[:], prev.Memory[:], compressed)
+	writeImage(w, state.Screen[:], prev.Screen[:], compressed)
 }
 
-func writeSMCEvent(w io.Writer, evt SMCEvent) error {
-	if err := binary.Write(w, binary.LittleEndian, evt.Cycle); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, evt.PC); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, evt.Address); err != nil {
-		return err
+// writeImage writes a memory image as it is, or compressed as its XOR with
+// the previous one
+func writeImage(w *bytes.Buffer, image, prev []byte, compressed bool) {
+	if !compressed {
+		w.Write(image)
+		return
 	}
-	if err := binary.Write(w, binary.LittleEndian, evt.OldValue); err != nil {
-		return err
+	delta := make([]byte, len(image))
+	for i := range image {
+		delta[i] = image[i] ^ prev[i]
 	}
-	return binary.Write(w, binary.LittleEndian, evt.NewValue)
+	packed := rleEncode(delta)
+	writeVarInt(w, int64(len(packed)))
+	w.Write(packed)
 }
 
-func writeIOEvent(w io.Writer, evt IOEvent) error {
-	if err := binary.Write(w, binary.LittleEndian, evt.Cycle); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, evt.Port); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, evt.Value); err != nil {
-		return err
-	}
-	isInput := byte(0)
-	if evt.IsInput {
-		isInput = 1
-	}
-	return binary.Write(w, binary.LittleEndian, isInput)
+func writeString(w *bytes.Buffer, s string) {
+	writeVarInt(w, int64(len(s)))
+	w.WriteString(s)
 }
 
-// Compact versions use smaller encodings
-
-func writeInputEventCompact(w io.Writer, evt InputEvent) error {
-	// Port and value as compact format
-	data := byte(evt.Port & 0xFF)
-	if evt.Value != 0 {
-		data |= 0x80
-	}
-	return binary.Write(w, binary.LittleEndian, data)
-}
-
-func writeSMCEventCompact(w io.Writer, evt SMCEvent) error {
-	// Pack PC and Address into 3 bytes total (if possible)
-	if err := binary.Write(w, binary.LittleEndian, evt.PC); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, evt.Address); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, evt.OldValue); err != nil {
-		return err
-	}
-	return binary.Write(w, binary.LittleEndian, evt.NewValue)
-}
-
-func writeIOEventCompact(w io.Writer, evt IOEvent) error {
-	// Pack port and direction into 2 bytes
-	portAndDir := evt.Port
-	if evt.IsInput {
-		portAndDir |= 0x8000
-	}
-	if err := binary.Write(w, binary.LittleEndian, portAndDir); err != nil {
-		return err
-	}
-	return binary.Write(w, binary.LittleEndian, evt.Value)
-}
-
-func writeStateSnapshot(w io.Writer, state StateSnapshot) error {
-	// Write cycle
-	if err := binary.Write(w, binary.LittleEndian, state.Cycle); err != nil {
-		return err
-	}
-	
-	// Write registers
-	if err := binary.Write(w, binary.LittleEndian, state.PC); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.SP); err != nil {
-		return err
-	}
-	// Write main registers
-	if err := binary.Write(w, binary.LittleEndian, state.A); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.F); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.B); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.C); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.D); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.E); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.H); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.L); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.IX); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.IY); err != nil {
-		return err
-	}
-	
-	// Write shadow registers
-	if err := binary.Write(w, binary.LittleEndian, state.A_); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.F_); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.B_); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.C_); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.D_); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.E_); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.H_); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.L_); err != nil {
-		return err
-	}
-	
-	// Write other registers
-	if err := binary.Write(w, binary.LittleEndian, state.I); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.R); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.IFF1); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, state.IFF2); err != nil {
-		return err
-	}
-	// IM field not present in StateSnapshot
-	// if err := binary.Write(w, binary.LittleEndian, state.IM); err != nil {
-	//	return err
-	// }
-	
-	// Write memory (compressed in practice)
-	_, err := w.Write(state.Memory[:])
-	return err
-}
-
-// Variable-length integer encoding for deltas
+// Variable-length integer encoding for deltas: zigzag, so that small
+// negative values stay short, then 7 bits per byte, low bits first
 func writeVarInt(w io.Writer, v int64) error {
-	// Simple variable-length encoding
-	for v >= 0x80 {
-		if err := binary.Write(w, binary.LittleEndian, byte(v|0x80)); err != nil {
+	u := uint64(v<<1) ^ uint64(v>>63)
+	for u >= 0x80 {
+		if err := binary.Write(w, binary.LittleEndian, byte(u|0x80)); err != nil {
 			return err
 		}
-		v >>= 7
+		u >>= 7
 	}
-	return binary.Write(w, binary.LittleEndian, byte(v))
+	return binary.Write(w, binary.LittleEndian, byte(u))
 }
 
 func readVarInt(r io.Reader) (int64, error) {
-	var v int64
+	var u uint64
 	var shift uint
 	for {
 		var b byte
 		if err := binary.Read(r, binary.LittleEndian, &b); err != nil {
 			return 0, err
 		}
-		v |= int64(b&0x7f) << shift
+		if shift > 63 {
+			return 0, fmt.Errorf("varint overflows 64 bits")
+		}
+		u |= uint64(b&0x7f) << shift
 		if b&0x80 == 0 {
 			break
 		}
 		shift += 7
 	}
-	return v, nil
+	return int64(u>>1) ^ -int64(u&1), nil
 }
 
-// Load functions
-
-func loadJSON(r io.Reader) (*TASFile, error) {
-	var tas TASFile
-	decoder := json.NewDecoder(r)
-	if err := decoder.Decode(&tas); err != nil {
-		return nil, err
-	}
-	return &tas, nil
-}
-
-func loadBinary(r io.Reader) (*TASFile, error) {
-	var tas TASFile
-	
-	// Read header
-	if err := binary.Read(r, binary.LittleEndian, &tas.Header); err != nil {
-		return nil, err
-	}
-	
-	// Verify magic
-	if string(tas.Header.Magic[:7]) != "MINZTAS" {
-		return nil, fmt.Errorf("invalid TAS file magic")
-	}
-	
-	// Read metadata length
-	var metaLen uint32
-	if err := binary.Read(r, binary.LittleEndian, &metaLen); err != nil {
-		return nil, err
-	}
-	
-	// Read metadata
-	metaBytes := make([]byte, metaLen)
-	if _, err := r.Read(metaBytes); err != nil {
-		return nil, err
-	}
-	if err := json.Unmarshal(metaBytes, &tas.Metadata); err != nil {
-		return nil, err
-	}
-	
-	// Read events
-	if err := readEvents(r, &tas.Events); err != nil {
-		return nil, err
-	}
-	
-	// Read states if present
-	var stateCount uint32
-	if err := binary.Read(r, binary.LittleEndian, &stateCount); err != nil {
-		// No states is OK
-		return &tas, nil
-	}
-	
-	tas.States = make([]StateSnapshot, stateCount)
-	for i := uint32(0); i < stateCount; i++ {
-		if err := readStateSnapshot(r, &tas.States[i]); err != nil {
-			return nil, err
-		}
-	}
-	
-	return &tas, nil
+// decoder reads the body of a binary TAS file, keeping the first error
+type decoder struct {
+	r   *bytes.Reader
+	err error
 }
 
-func loadCompressed(r io.Reader) (*TASFile, error) {
-	gz, err := gzip.NewReader(r)
-	if err != nil {
-		return nil, err
+func (d *decoder) read(v interface{}) {
+	if d.err == nil {
+		d.err = binary.Read(d.r, binary.LittleEndian, v)
 	}
-	defer gz.Close()
-	
-	return loadBinary(gz)
 }
 
-func readEvents(r io.Reader, events *TASEvents) error {
-	// Read input events
-	var inputCount uint32
-	if err := binary.Read(r, binary.LittleEndian, &inputCount); err != nil {
-		return err
-	}
-	events.Inputs = make([]InputEvent, inputCount)
-	for i := uint32(0); i < inputCount; i++ {
-		if err := readInputEvent(r, &events.Inputs[i]); err != nil {
-			return err
-		}
-	}
-	
-	// Read SMC events
-	var smcCount uint32
-	if err := binary.Read(r, binary.LittleEndian, &smcCount); err != nil {
-		return err
-	}
-	events.SMCEvents = make([]SMCEvent, smcCount)
-	for i := uint32(0); i < smcCount; i++ {
-		if err := readSMCEvent(r, &events.SMCEvents[i]); err != nil {
-			return err
-		}
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
 	}
-	
-	// Read IO events
-	var ioCount uint32
-	if err := binary.Read(r, binary.LittleEndian, &ioCount); err != nil {
-		return err
-	}
-	events.IOEvents = make([]IOEvent, ioCount)
-	for i := uint32(0); i < ioCount; i++ {
-		if err := readIOEvent(r, &events.IOEvents[i]); err != nil {
-			return err
-		}
-	}
-	
-	return nil
+	v, err := readVarInt(d.r)
+	d.err = err
+	return v
 }
 
-func readInputEvent(r io.Reader, evt *InputEvent) error {
-	if err := binary.Read(r, binary.LittleEndian, &evt.Cycle); err != nil {
-		return err
-	}
-	if err := binary.Read(r, binary.LittleEndian, &evt.Port); err != nil {
-		return err
+// count reads the number of items that follow, each at least minSize bytes
+func (d *decoder) count(minSize int) int {
+	n := d.varint()
+	if d.err == nil && (n < 0 || n*int64(minSize) > int64(d.r.Len())) {
+		d.err = fmt.Errorf("corrupt TAS file: %d items in %d bytes", n, d.r.Len())
 	}
-	if err := binary.Read(r, binary.LittleEndian, &evt.Value); err != nil {
-		return err
+	if d.err != nil {
+		return 0
 	}
-	return nil
+	return int(n)
 }
 
-func readSMCEvent(r io.Reader, evt *SMCEvent) error {
-	if err := binary.Read(r, binary.LittleEndian, &evt.Cycle); err != nil {
-		return err
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
 	}
-	if err := binary.Read(r, binary.LittleEndian, &evt.PC); err != nil {
-		return err
-	}
-	if err := binary.Read(r, binary.LittleEndian, &evt.Address); err != nil {
-		return err
+	data := make([]byte, n)
+	if _, err := io.ReadFull(d.r, data); err != nil {
+		d.err = err
+		return nil
 	}
-	if err := binary.Read(r, binary.LittleEndian, &evt.OldValue); err != nil {
-		return err
-	}
-	return binary.Read(r, binary.LittleEndian, &evt.NewValue)
+	return data
 }
 
-func readIOEvent(r io.Reader, evt *IOEvent) error {
-	if err := binary.Read(r, binary.LittleEndian, &evt.Cycle); err != nil {
-		return err
-	}
-	if err := binary.Read(r, binary.LittleEndian, &evt.Port); err != nil {
-		return err
-	}
-	if err := binary.Read(r, binary.LittleEndian, &evt.Value); err != nil {
-		return err
-	}
-	var isInput byte
-	if err := binary.Read(r, binary.LittleEndian, &isInput); err != nil {
-		return err
-	}
-	evt.IsInput = isInput != 0
-	return nil
+func (d *decoder) string() string {
+	return string(d.bytes(d.count(1)))
 }
 
-func readStateSnapshot(r io.Reader, state *StateSnapshot) error {
-	// Read cycle
-	if err := binary.Read(r, binary.LittleEndian, &state.Cycle); err != nil {
-		return err
+// loadBinary decodes a file in the binary format
+func loadBinary(data []byte) (*TASFile, error) {
+	var header fileHeader
+	r := bytes.NewReader(data)
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("truncated TAS header: %v", err)
 	}
-	
-	// Read registers
-	if err := binary.Read(r, binary.LittleEndian, &state.PC); err != nil {
-		return err
-	}
-	if err := binary.Read(r, binary.LittleEndian, &state.SP); err != nil {
-		return err
-	}
-	// Read main registers
-	if err := binary.Read(r, binary.LittleEndian, &state.A); err != nil {
-		return err
-	}
-	if err := binary.Read(r, binary.LittleEndian, &state.F); err != nil {
-		return err
-	}
-	if err := binary.Read(r, binary.LittleEndian, &state.B); err != nil {
-		return err
-	}
-	if err := binary.Read(r, binary.LittleEndian, &state.C); err != nil {
-		return err
-	}
-	if err := binary.Read(r, binary.LittleEndian, &state.D); err != nil {
-		return err
-	}
-	if err := binary.Read(r, binary.LittleEndian, &state.E); err != nil {
-		return err
-	}
-	if err := binary.Read(r, binary.LittleEndian, &state.H); err != nil {
-		return err
-	}
-	if err := binary.Read(r, binary.LittleEndian, &state.L); err != nil {
-		return err
-	}
-	if err := binary.Read(r, binary.LittleEndian, &state.IX); err != nil {
-		return err
-	}
-	if err := binary.Read(r, binary.LittleEndian, &state.IY); err != nil {
-		return err
-	}
-	
-	// Read shadow registers
-	if err := binary.Read(r, binary.LittleEndian, &state.A_); err != nil {
-		return err
-	}
-	if err := binary.Read(r, binary.LittleEndian, &state.F_); err != nil {
-		return err
-	}
-	if err := binary.Read(r, binary.LittleEndian, &state.B_); err != nil {
-		return err
+	if string(header.Magic[:]) != TASMagic {
+		return nil, fmt.Errorf("invalid TAS file magic")
 	}
-	if err := binary.Read(r, binary.LittleEndian, &state.C_); err != nil {
-		return err
+	if header.Version > TASVersion {
+		return nil, fmt.Errorf("TAS file version %d is newer than this tool (%d)", header.Version, TASVersion)
 	}
-	if err := binary.Read(r, binary.LittleEndian, &state.D_); err != nil {
-		return err
+	if header.Format != TASFormatBinary && header.Format != TASFormatCompressed {
+		return nil, fmt.Errorf("unknown TAS format: %d", header.Format)
 	}
-	if err := binary.Read(r, binary.LittleEndian, &state.E_); err != nil {
-		return err
+	body := data[len(data)-r.Len():]
+	if crc32.ChecksumIEEE(body) != header.Checksum {
+		return nil, fmt.Errorf("TAS file checksum mismatch (file is damaged)")
 	}
-	if err := binary.Read(r, binary.LittleEndian, &state.H_); err != nil {
-		return err
+
+	tas := &TASFile{
+		Header: TASHeader{
+			Version:  header.Version,
+			Format:   header.Format,
+			Flags:    header.Flags,
+			Created:  time.Unix(0, header.Created),
+			Checksum: header.Checksum,
+		},
 	}
-	if err := binary.Read(r, binary.LittleEndian, &state.L_); err != nil {
-		return err
+	copy(tas.Header.Magic[:], header.Magic[:])
+
+	d := &decoder{r: r}
+	var metaLen uint32
+	d.read(&metaLen)
+	if d.err == nil && int64(metaLen) > int64(r.Len()) {
+		d.err = fmt.Errorf("metadata runs past the end of the file")
+	}
+	metaBytes := d.bytes(int(metaLen))
+	if d.err == nil {
+		d.err = json.Unmarshal(metaBytes, &tas.Metadata)
+	}
+
+	d.readEvents(&tas.Events)
+
+	compressed := header.Format == TASFormatCompressed
+	prev := &StateSnapshot{}
+	tas.States = make([]StateSnapshot, d.count(binary.Size(stateRecord{})))
+	for i := range tas.States {
+		d.readState(&tas.States[i], prev, compressed)
+		prev = &tas.States[i]
+	}
+
+	if d.err == nil && r.Len() != 0 {
+		d.err = fmt.Errorf("%d bytes of trailing data", r.Len())
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("corrupt TAS file: %v", d.err)
+	}
+	return tas, nil
+}
+
+// readEvents reads the event log written by writeEvents
+func (d *decoder) readEvents(events *TASEvents) {
+	events.Inputs = make([]InputEvent, d.count(6))
+	var last int64
+	for i := range events.Inputs {
+		input := &events.Inputs[i]
+		last += d.varint()
+		input.Cycle = uint64(last)
+		input.Frame = uint64(d.varint())
+		d.read(&input.Port)
+		d.read(&input.Value)
+		input.Type = d.string()
+	}
+
+	events.SMCEvents = make([]SMCEvent, d.count(8))
+	last = 0
+	for i := range events.SMCEvents {
+		smc := &events.SMCEvents[i]
+		last += d.varint()
+		smc.Cycle = uint64(last)
+		d.read(&smc.PC)
+		d.read(&smc.Address)
+		d.read(&smc.OldValue)
+		d.read(&smc.NewValue)
+		smc.Reason = d.string()
+	}
+
+	events.IOEvents = make([]IOEvent, d.count(4))
+	last = 0
+	for i := range events.IOEvents {
+		io := &events.IOEvents[i]
+		last += d.varint()
+		io.Cycle = last
+		var portAndDir uint16
+		d.read(&portAndDir)
+		io.Port = portAndDir & 0x7FFF
+		io.IsInput = portAndDir&0x8000 != 0
+		d.read(&io.Value)
+	}
+}
+
+// readState reads a state written by writeState
+func (d *decoder) readState(state, prev *StateSnapshot, compressed bool) {
+	var rec stateRecord
+	d.read(&rec)
+	state.Cycle, state.Frame, state.TStates = rec.Cycle, rec.Frame, rec.TStates
+	state.PC, state.SP, state.IX, state.IY = rec.PC, rec.SP, rec.IX, rec.IY
+	state.A, state.F, state.B, state.C = rec.A, rec.F, rec.B, rec.C
+	state.D, state.E, state.H, state.L = rec.D, rec.E, rec.H, rec.L
+	state.A_, state.F_, state.B_, state.C_ = rec.A_, rec.F_, rec.B_, rec.C_
+	state.D_, state.E_, state.H_, state.L_ = rec.D_, rec.E_, rec.H_, rec.L_
+	state.I, state.R = rec.I, rec.R
+	state.IFF1, state.IFF2 = rec.IFF1, rec.IFF2
+	state.Border = rec.Border
+	state.LastOpcode = d.string()
+	if n := d.count(2); n > 0 {
+		state.StackTrace = make([]uint16, n)
+		for i := range state.StackTrace {
+			d.read(&state.StackTrace[i])
+		}
 	}
-	
-	// Read other registers
-	if err := binary.Read(r, binary.LittleEndian, &state.I); err != nil {
-		return err
+
+	d.readImage(state.Memory[:], prev.Memory[:], compressed)
+	d.readImage(state.Screen[:], prev.Screen[:], compressed)
+}
+
+// readImage reads a memory image written by writeImage into image
+func (d *decoder) readImage(image, prev []byte, compressed bool) {
+	if !compressed {
+		copy(image, d.bytes(len(image)))
+		return
 	}
-	if err := binary.Read(r, binary.LittleEndian, &state.R); err != nil {
-		return err
+	packed := d.bytes(d.count(1))
+	if d.err != nil {
+		return
 	}
-	if err := binary.Read(r, binary.LittleEndian, &state.IFF1); err != nil {
-		return err
+	delta, err := rleDecode(packed, len(image))
+	if err != nil {
+		d.err = err
+		return
 	}
-	if err := binary.Read(r, binary.LittleEndian, &state.IFF2); err != nil {
-		return err
+	for i := range image {
+		image[i] = delta[i] ^ prev[i]
 	}
-	// IM field not present in StateSnapshot
-	// if err := binary.Read(r, binary.LittleEndian, &state.IM); err != nil {
-	//	return err
-	// }
-	
-	// Read memory
-	_, err := r.Read(state.Memory[:])
-	return err
 }
 
 // calculateChecksum calculates CRC32 checksum
@@ -850,12 +581,14 @@ func CreateReplay(debugger *TASDebugger) *TASFile {
 		},
 	}
 	
-	// Add key frames for seeking
+	// Add key frames for seeking: every frame of a short recording,
+	// every 100th of a long one
+	step := 1
 	if len(debugger.stateHistory) > 100 {
-		// Sample every 100 frames for quick seeking
-		for i := 0; i < len(debugger.stateHistory); i += 100 {
-			tas.States = append(tas.States, debugger.stateHistory[i])
-		}
+		step = 100
+	}
+	for i := 0; i < len(debugger.stateHistory); i += step {
+		tas.States = append(tas.States, debugger.stateHistory[i])
 	}
 	
 	return tas
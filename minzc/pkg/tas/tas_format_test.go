@@ -27,9 +27,9 @@ func TestTASFileFormat(t *testing.T) {
 		},
 		Events: TASEvents{
 			Inputs: []InputEvent{
-				{Cycle: 100, Key: 'A', Pressed: true},
-				{Cycle: 200, Key: 'A', Pressed: false},
-				{Cycle: 300, Key: 'B', Pressed: true},
+				{Cycle: 100, Port: 0xFDFE, Value: 0x1E, Type: "key"},
+				{Cycle: 200, Port: 0xFDFE, Value: 0x1F, Type: "key"},
+				{Cycle: 300, Port: 0x7FFE, Value: 0x0F, Type: "key"},
 			},
 			SMCEvents: []SMCEvent{
 				{Cycle: 150, PC: 0x8000, Address: 0x8042, OldValue: 0x00, NewValue: 0x42},
@@ -90,8 +90,8 @@ func TestTASFileFormat(t *testing.T) {
 		if loaded.Metadata.TotalFrames != 100 {
 			t.Errorf("Total frames mismatch: got %d, want 100", loaded.Metadata.TotalFrames)
 		}
-		if loaded.Events.Inputs[0].Key != 'A' {
-			t.Errorf("First input key mismatch: got %c, want A", loaded.Events.Inputs[0].Key)
+		if in := loaded.Events.Inputs[0]; in.Port != 0xFDFE || in.Value != 0x1E || in.Type != "key" {
+			t.Errorf("First input mismatch: got %+v", in)
 		}
 	})
 	
@@ -176,4 +176,101 @@ func (r *testReader) Read(p []byte) (int, error) {
 	n := copy(p, r.buf[r.pos:])
 	r.pos += n
 	return n, nil
-}
\ No newline at end of file
+}
+func TestTASKeyframes(t *testing.T) {
+	// An initial state and two keyframes that each change a little memory
+	tasFile := &TASFile{Metadata: TASMetadata{ProgramName: "keyframes"}}
+	state := StateSnapshot{Cycle: 0, PC: 0x8000, SP: 0xFFFE, A: 0x12, IFF1: true, LastOpcode: "NOP"}
+	for i := 0x8000; i < 0x8400; i++ {
+		state.Memory[i] = byte(i % 7)
+	}
+	copy(state.Screen[:], state.Memory[0x4000:])
+	tasFile.States = append(tasFile.States, state)
+	state.Cycle, state.PC, state.A = 1000, 0x8010, 0x34
+	state.Memory[0x9000] = 0xAA
+	state.StackTrace = []uint16{0x8003}
+	tasFile.States = append(tasFile.States, state)
+	state.Cycle, state.Border = 2000, 2
+	state.Memory[0x4000], state.Screen[0] = 0xFF, 0xFF
+	tasFile.States = append(tasFile.States, state)
+	tasFile.Events.IOEvents = []IOEvent{{Cycle: 1500, Port: 0xFE, Value: 2}}
+
+	sizes := map[uint8]int64{}
+	for _, format := range []uint8{TASFormatBinary, TASFormatCompressed} {
+		filename := t.TempDir() + "/keyframes.tas"
+		if err := tasFile.SaveToFile(filename, format); err != nil {
+			t.Fatalf("Failed to save format %d: %v", format, err)
+		}
+		info, _ := os.Stat(filename)
+		sizes[format] = info.Size()
+
+		loaded, err := LoadFromFile(filename)
+		if err != nil {
+			t.Fatalf("Failed to load format %d: %v", format, err)
+		}
+		if len(loaded.States) != 3 {
+			t.Fatalf("Format %d: got %d states, want 3", format, len(loaded.States))
+		}
+		for i, got := range loaded.States {
+			want := tasFile.States[i]
+			if got.Cycle != want.Cycle || got.PC != want.PC || got.A != want.A || got.Border != want.Border ||
+				got.IFF1 != want.IFF1 || got.LastOpcode != want.LastOpcode || len(got.StackTrace) != len(want.StackTrace) {
+				t.Errorf("Format %d: state %d registers mismatch: got %+v", format, i, got.PC)
+			}
+			if got.Memory != want.Memory || got.Screen != want.Screen {
+				t.Errorf("Format %d: state %d memory mismatch", format, i)
+			}
+		}
+		if loaded.Events.IOEvents[0] != tasFile.Events.IOEvents[0] {
+			t.Errorf("Format %d: IO event mismatch: got %+v", format, loaded.Events.IOEvents[0])
+		}
+	}
+	if sizes[TASFormatCompressed]*10 > sizes[TASFormatBinary] {
+		t.Errorf("Compressed file is %d bytes, binary %d", sizes[TASFormatCompressed], sizes[TASFormatBinary])
+	}
+}
+
+func TestTASDamagedFile(t *testing.T) {
+	filename := t.TempDir() + "/damaged.tas"
+	tasFile := &TASFile{Events: TASEvents{Inputs: []InputEvent{{Cycle: 10, Port: 0xFE, Value: 1}}}}
+	if err := tasFile.SaveToFile(filename, TASFormatCompressed); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filename)
+	data[len(data)-3] ^= 0xFF
+	os.WriteFile(filename, data, 0644)
+
+	if _, err := LoadFromFile(filename); err == nil {
+		t.Error("Damaged file loaded without an error")
+	}
+}
+
+func TestRLE(t *testing.T) {
+	tests := [][]byte{
+		{},
+		{1},
+		{1, 1},
+		{1, 1, 1},
+		{1, 2, 3, 3, 3, 3, 4},
+		make([]byte, 1000),
+		[]byte("literal bytes only, no runs at all"),
+	}
+	for _, data := range tests {
+		packed := rleEncode(data)
+		got, err := rleDecode(packed, len(data))
+		if err != nil {
+			t.Errorf("rleDecode(%v): %v", data, err)
+			continue
+		}
+		if string(got) != string(data) {
+			t.Errorf("RLE round trip: got %v, want %v", got, data)
+		}
+	}
+
+	if packed := rleEncode(make([]byte, 65536)); len(packed) > 1100 {
+		t.Errorf("64K of zeros packed to %d bytes", len(packed))
+	}
+	if _, err := rleDecode([]byte{0x85}, 8); err == nil {
+		t.Error("Truncated RLE data decoded without an error")
+	}
+}
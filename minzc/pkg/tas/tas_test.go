@@ -74,8 +74,8 @@ func (m *MockZ80) GetTStates() uint64 { return m.tstates }
 func (m *MockZ80) SetTStates(v uint64) { m.tstates = v }
 func (m *MockZ80) GetMemory() []byte { return m.memory[:] }
 func (m *MockZ80) SetMemory(mem []byte) { copy(m.memory[:], mem) }
-func (m *MockZ80) ReadByte(addr uint16) byte { return m.memory[addr] }
-func (m *MockZ80) WriteByte(addr uint16, val byte) { m.memory[addr] = val }
+func (m *MockZ80) Peek(addr uint16) byte { return m.memory[addr] }
+func (m *MockZ80) Poke(addr uint16, val byte) { m.memory[addr] = val }
 func (m *MockZ80) GetBorder() byte { return m.border }
 func (m *MockZ80) GetLastOpcode() string { return "NOP" }
 func (m *MockZ80) GetRegisters() *CPURegisters {
@@ -125,6 +125,42 @@ func TestTASDebuggerBasic(t *testing.T) {
 	fmt.Println("✅ TAS basic recording and rewind works!")
 }
 
+func TestTASForward(t *testing.T) {
+	emu := &MockZ80{pc: 0x8000}
+	tas := NewTASDebugger(emu)
+	tas.StartRecording()
+	
+	// Record the state before each of three runs
+	for i := 0; i < 3; i++ {
+		tas.RecordFrame()
+		emu.pc += 0x100
+	}
+	
+	// Back to before the last run, then to the present again
+	if err := tas.Rewind(1); err != nil {
+		t.Fatalf("Rewind failed: %v", err)
+	}
+	if emu.pc != 0x8200 {
+		t.Errorf("Expected PC=0x8200 after rewind, got 0x%04X", emu.pc)
+	}
+	if err := tas.Forward(5); err != nil {
+		t.Fatalf("Forward failed: %v", err)
+	}
+	if emu.pc != 0x8300 {
+		t.Errorf("Expected PC=0x8300 after forward, got 0x%04X", emu.pc)
+	}
+	if err := tas.Forward(1); err == nil {
+		t.Error("Forward past the latest frame succeeded")
+	}
+	
+	// Recording after a rewind drops the frames after it
+	tas.Rewind(2)
+	tas.RecordFrame()
+	if tas.FrameCount() != 2 || tas.CurrentFrame() != 2 {
+		t.Errorf("Expected 2 frames at frame 2, got %d at frame %d", tas.FrameCount(), tas.CurrentFrame())
+	}
+}
+
 func TestTASSaveStates(t *testing.T) {
 	emu := &MockZ80{pc: 0x8000}
 	tas := NewTASDebugger(emu)
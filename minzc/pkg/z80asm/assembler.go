@@ -3,6 +3,7 @@ package z80asm
 import (
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 )
 
@@ -13,6 +14,9 @@ type Assembler struct {
 	Strict            bool // Sjasmplus compatibility mode
 	CaseSensitive     bool // Case sensitivity for labels
 	EnableMacros      bool // Enable macro processing
+	WarnSelfModifying bool // Warn about stores into non-SMC code
+	Z80N              bool // Accept the ZX Spectrum Next's Z80N instructions
+	SM83              bool // Assemble for the Game Boy's SM83 (see sm83.go)
 	
 	// Internal state
 	pass          int
@@ -22,10 +26,28 @@ type Assembler struct {
 	lines         []*Line
 	output        []byte
 	instructions  []*AssembledInstruction
+	labelLines    map[int][]string // Pass 2: labels defined before instructions[i]
 	errors        []AssemblerError
 	warnings      []string
 	macroProcessor *MacroProcessor
 	macroDefinition *macroDefinitionState // Current macro being defined
+	structs       map[string]*StructDef
+	structDefinition *structDefinitionState // Current STRUCT being defined
+	
+	// Object modules (see object.go)
+	relocatable   bool              // Assembling an object module; ORG is not allowed
+	externValues  map[string]uint16 // Values of EXTERN symbols in this run
+	externNames   []string          // EXTERN symbols, in declaration order
+	publicNames   []string          // PUBLIC symbols, in declaration order
+	
+	// Symbols set with Define (see conditional.go)
+	defines       map[string]uint16
+	
+	// Sections (see sections.go)
+	usesSections  bool
+	sections      []*section              // In the order the source opens them
+	section       *section                // Current section
+	sectionConfig map[string]SectionSpec  // Set with ConfigureSection
 	
 	// Target platform support
 	target        *TargetConfig
@@ -218,15 +240,34 @@ type Result struct {
 	Listing     []ListingLine
 	Errors      []AssemblerError
 	Warnings    []string
+	SelfModifyingWrites []SelfModifyingWrite // With WarnSelfModifying
+	Macros      []*Macro // Defined macros, in name order
+	Sections    []SectionInfo // With SECTION, in placement order
+}
+
+// SortedSymbolNames returns the defined symbol names in alphabetical order,
+// so symbol output does not depend on map iteration order
+func (r *Result) SortedSymbolNames() []string {
+	names := make([]string, 0, len(r.Symbols))
+	for name := range r.Symbols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // ListingLine represents a line in the assembly listing
 type ListingLine struct {
-	Address     uint16
-	Bytes       []byte
-	LineNumber  int
-	SourceLine  string
-	Label       string
+	Address      uint16
+	Bytes        []byte
+	LineNumber   int
+	SourceLine   string
+	Label        string
+	Macro        string   // Macro that produced this line; LineNumber is then the invocation
+	MacroCall    string   // Source of that invocation
+	Labels       []string // Labels defined just before this line, in source order
+	Tstates      int      // T-states of an instruction; 0 for data
+	TstatesTaken int      // T-states when its branch is taken or it repeats, else Tstates
 }
 
 // AssembledInstruction represents a fully assembled instruction
@@ -262,6 +303,7 @@ func NewAssembler() *Assembler {
 		CaseSensitive:     false,
 		EnableMacros:      true,
 		symbols:           make(map[string]*Symbol),
+		structs:           make(map[string]*StructDef),
 		origin:            0x8000, // Default origin
 		macroProcessor:    NewMacroProcessor(),
 	}
@@ -296,6 +338,36 @@ func (a *Assembler) AssembleString(source string) (*Result, error) {
 		return nil, fmt.Errorf("parse error: %w", err)
 	}
 	
+	// Drop the branches of IF/IFDEF blocks that are not assembled
+	lines, err = a.applyConditionals(lines)
+	if err != nil {
+		return nil, fmt.Errorf("conditional error: %w", err)
+	}
+	
+	// Repeat REPT blocks
+	lines, err = a.expandRepeats(lines)
+	if err != nil {
+		return nil, fmt.Errorf("repeat error: %w", err)
+	}
+	
+	// Expand macro invocations into their bodies
+	if a.EnableMacros {
+		lines, err = a.expandMacros(lines)
+		if err != nil {
+			return nil, fmt.Errorf("macro error: %w", err)
+		}
+		
+		// Macro bodies may contain conditionals and REPT blocks of their own
+		lines, err = a.applyConditionals(lines)
+		if err != nil {
+			return nil, fmt.Errorf("conditional error: %w", err)
+		}
+		lines, err = a.expandRepeats(lines)
+		if err != nil {
+			return nil, fmt.Errorf("repeat error: %w", err)
+		}
+	}
+	
 	// Preprocess local labels (expand .loop to main.loop)
 	lines, err = preprocessLocalLabels(lines)
 	if err != nil {
@@ -309,6 +381,7 @@ func (a *Assembler) AssembleString(source string) (*Result, error) {
 	lines = expandFakeInstructions(lines)
 	
 	a.lines = lines
+	a.usesSections = usesSections(lines)
 	
 	// Pass 1: Build symbol table and calculate addresses
 	a.pass = 1
@@ -316,15 +389,36 @@ func (a *Assembler) AssembleString(source string) (*Result, error) {
 		return nil, fmt.Errorf("pass 1 error: %w", err)
 	}
 	
+	// Place the sections, and run pass 1 again until labels stop moving
+	if a.usesSections {
+		for i := 1; a.placeSections(); i++ {
+			if i == maxLayoutPasses {
+				return nil, fmt.Errorf("section layout did not settle after %d passes", i)
+			}
+			a.restartPass1()
+			if err := a.performPass(); err != nil {
+				return nil, fmt.Errorf("pass 1 error: %w", err)
+			}
+		}
+		if err := a.checkSections(); err != nil {
+			return nil, fmt.Errorf("memory layout error: %w", err)
+		}
+	}
+	
 	// Pass 2: Generate code
 	a.pass = 2
 	a.currentAddr = a.origin
 	a.output = make([]byte, 0, 65536)
 	a.instructions = make([]*AssembledInstruction, 0)
+	a.labelLines = make(map[int][]string)
 	
 	if err := a.performPass(); err != nil {
 		return nil, fmt.Errorf("pass 2 error: %w", err)
 	}
+	var sections []SectionInfo
+	if a.usesSections {
+		sections = a.linkSections()
+	}
 	
 	// Validate memory layout for target platform
 	if err := a.ValidateMemoryLayout(); err != nil {
@@ -339,8 +433,19 @@ func (a *Assembler) AssembleString(source string) (*Result, error) {
 		Symbols: make(map[string]uint16),
 		Listing: make([]ListingLine, 0),
 		Errors:  a.errors,
+		Macros:  a.macroProcessor.Macros(),
+		Sections: sections,
 	}
 	
+	// Flag constant-address stores into code
+	if a.WarnSelfModifying {
+		result.SelfModifyingWrites = a.findSelfModifyingWrites()
+		for _, w := range result.SelfModifyingWrites {
+			a.warnings = append(a.warnings, w.String())
+		}
+	}
+	result.Warnings = a.warnings
+	
 	// Copy symbols
 	for name, sym := range a.symbols {
 		if sym.Defined {
@@ -349,13 +454,19 @@ func (a *Assembler) AssembleString(source string) (*Result, error) {
 	}
 	
 	// Generate listing
-	for _, inst := range a.instructions {
+	for i, inst := range a.instructions {
 		listing := ListingLine{
 			Address:    inst.Address,
 			Bytes:      inst.Bytes,
 			LineNumber: inst.Line.Number,
 			SourceLine: formatSourceLine(inst.Line),
 			Label:      inst.Line.Label,
+			Macro:      inst.Line.Macro,
+			MacroCall:  inst.Line.MacroCall,
+			Labels:     a.labelLines[i],
+		}
+		if inst.Line.Mnemonic != "" {
+			listing.Tstates, listing.TstatesTaken = Timing(inst.Bytes)
 		}
 		result.Listing = append(result.Listing, listing)
 	}
@@ -367,7 +478,28 @@ func (a *Assembler) AssembleString(source string) (*Result, error) {
 func (a *Assembler) reset() {
 	a.pass = 0
 	a.currentAddr = a.origin
+	a.symbols = a.initialSymbols()
+	a.structs = make(map[string]*StructDef)
 	
+	// Macros defined by a previous source do not carry over
+	a.macroProcessor.Clear()
+	if a.EnableMacros {
+		a.macroProcessor.DefineStandardMacros()
+	}
+	a.structDefinition = nil
+	a.externNames = nil
+	a.publicNames = nil
+	a.output = nil
+	a.instructions = nil
+	a.errors = nil
+	a.warnings = nil
+	a.sections = nil
+	a.section = nil
+}
+
+// initialSymbols returns the symbols defined before the source: the
+// target's and those set with Define
+func (a *Assembler) initialSymbols() map[string]*Symbol {
 	// Preserve target symbols if target is set
 	targetSymbols := make(map[string]*Symbol)
 	if a.target != nil {
@@ -383,20 +515,25 @@ func (a *Assembler) reset() {
 			}
 		}
 	}
-	
-	a.symbols = targetSymbols
-	a.output = nil
-	a.instructions = nil
-	a.errors = nil
-	a.warnings = nil
+	for name, value := range a.defines {
+		targetSymbols[name] = &Symbol{Name: name, Value: value, Defined: true}
+	}
+	return targetSymbols
 }
 
 // performPass executes one assembly pass
 func (a *Assembler) performPass() error {
 	a.currentAddr = a.origin
+	a.beginSectionPass()
+	defer a.endSectionPass()
 	
 	for _, line := range a.lines {
-		if err := a.processLine(line); err != nil {
+		outputBefore, instructionsBefore := len(a.output), len(a.instructions)
+		err := a.processLine(line)
+		if err == nil {
+			err = a.checkBSS(line, outputBefore, instructionsBefore)
+		}
+		if err != nil {
 			// Create enhanced error based on error type
 			var assemblyError AssemblerError
 			
@@ -442,6 +579,11 @@ func (a *Assembler) processLine(line *Line) error {
 		return nil
 	}
 	
+	// Lines inside STRUCT ... ENDS declare fields, not code
+	if a.structDefinition != nil {
+		return a.processStructLine(line)
+	}
+	
 	// Handle directive first if it's EQU (label is handled by EQU itself)
 	if line.Directive == "EQU" {
 		return a.processDirective(line)
@@ -452,6 +594,9 @@ func (a *Assembler) processLine(line *Line) error {
 		if err := a.defineLabel(line.Label); err != nil {
 			return err
 		}
+		if a.pass == 2 {
+			a.labelLines[len(a.instructions)] = append(a.labelLines[len(a.instructions)], line.Label)
+		}
 	}
 	
 	// Handle other directives
@@ -2,6 +2,10 @@ package z80asm
 
 import (
 	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -305,4 +309,1161 @@ func TestSymbols(t *testing.T) {
 			t.Errorf("Symbol %s: got $%04X, want $%04X", name, addr, expectedAddr)
 		}
 	}
-}
\ No newline at end of file
+}
+func TestDeterministicOutput(t *testing.T) {
+	source := `
+		ORG $8000
+	START:
+		LD HL, TABLE
+		CALL ZETA
+		JP START
+	ZETA:
+		RET
+	TABLE:
+		DB 1, 2, 3
+	ALPHA:  EQU 7
+	`
+
+	assemble := func() *Result {
+		result, err := NewAssembler().AssembleString(source)
+		if err != nil {
+			t.Fatalf("Assembly failed: %v", err)
+		}
+		return result
+	}
+	first, second := assemble(), assemble()
+
+	if !bytes.Equal(first.Binary, second.Binary) {
+		t.Errorf("binaries differ between identical assemblies")
+	}
+	if !reflect.DeepEqual(first.Listing, second.Listing) {
+		t.Errorf("listings differ between identical assemblies")
+	}
+
+	names := first.SortedSymbolNames()
+	if !reflect.DeepEqual(names, second.SortedSymbolNames()) {
+		t.Errorf("symbol order differs: %v vs %v", names, second.SortedSymbolNames())
+	}
+	if !sort.StringsAreSorted(names) || len(names) < 4 {
+		t.Errorf("SortedSymbolNames() = %v, want the defined symbols in order", names)
+	}
+}
+
+func TestCRCVerify(t *testing.T) {
+	asm := NewAssembler()
+	result, err := asm.AssembleString("ORG $8000\nLD A, 42\nRET")
+	if err != nil {
+		t.Fatalf("Assembly failed: %v", err)
+	}
+
+	// CRC32 of 3E 2A C9
+	crc := CRC32(result.Binary)
+
+	for _, expected := range []string{
+		fmt.Sprintf("%08X", crc),
+		fmt.Sprintf("0x%08x", crc),
+		fmt.Sprintf("$%08X", crc),
+	} {
+		if _, err := VerifyCRC(result.Binary, expected); err != nil {
+			t.Errorf("VerifyCRC(%s) failed: %v", expected, err)
+		}
+	}
+
+	got, err := VerifyCRC(result.Binary, "DEADBEEF")
+	mismatch, ok := err.(*CRCMismatchError)
+	if !ok {
+		t.Fatalf("expected CRCMismatchError, got %v", err)
+	}
+	if mismatch.Expected != 0xDEADBEEF || mismatch.Actual != crc || got != crc {
+		t.Errorf("mismatch values: expected=$%08X actual=$%08X", mismatch.Expected, mismatch.Actual)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "DEADBEEF") || !strings.Contains(msg, fmt.Sprintf("%08X", crc)) {
+		t.Errorf("error should report both values, got %q", msg)
+	}
+
+	if _, err := VerifyCRC(result.Binary, "not-hex"); err == nil {
+		t.Error("expected error for invalid CRC value")
+	}
+}
+
+func TestBinaryDiff(t *testing.T) {
+	asm := NewAssembler()
+	result, err := asm.AssembleString("ORG $8000\nLD HL, $1234\nLD B, 16\nDJNZ $\nLD A, 42\nRET")
+	if err != nil {
+		t.Fatalf("Assembly failed: %v", err)
+	}
+
+	expected := append([]byte(nil), result.Binary...)
+	if err := CompareBinary(result.Binary, expected); err != nil {
+		t.Fatalf("identical images reported as different: %v", err)
+	}
+
+	// LD A, 42 is at offset 7; its operand at offset 8
+	expected[8] = 43
+	err = CompareBinary(result.Binary, expected)
+	mismatch, ok := err.(*BinaryMismatchError)
+	if !ok {
+		t.Fatalf("expected BinaryMismatchError, got %v", err)
+	}
+	if mismatch.Offset != 8 {
+		t.Errorf("mismatch offset = %d, want 8", mismatch.Offset)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "$0008") || !strings.Contains(msg, "expected $2B, got $2A") {
+		t.Errorf("error should report the offset and both bytes, got %q", msg)
+	}
+	context := mismatch.Context()
+	if !strings.Contains(context, "21 34 12 06 10 10 FE 3E 2B C9 --") ||
+		!strings.Contains(context, "21 34 12 06 10 10 FE 3E 2A C9 --") ||
+		!strings.Contains(context, strings.Repeat(" ", 3*8)+"^^") {
+		t.Errorf("context does not show both images and mark offset 8:\n%s", context)
+	}
+
+	// A truncated expected image differs where it ends
+	err = CompareBinary(result.Binary, result.Binary[:5])
+	if mismatch, ok := err.(*BinaryMismatchError); !ok || mismatch.Offset != 5 || !strings.Contains(err.Error(), "longer") {
+		t.Errorf("truncated expected image: got %v, want a mismatch at offset 5", err)
+	}
+}
+
+func TestStruct(t *testing.T) {
+	source := `
+    ORG $8000
+    STRUCT sprite
+x       DB
+y       DB
+frames  DS 4
+    ENDS
+
+start:
+    LD (IX+sprite.y), A
+    LD B, (IX+sprite.frames)
+    LD HL, sprite
+    RET
+player:
+    INST sprite
+after:
+`
+	asm := NewAssembler()
+	result, err := asm.AssembleString(source)
+	if err != nil {
+		t.Fatalf("Assembly failed: %v", err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("Assembly errors: %v", result.Errors)
+	}
+
+	offsets := map[string]uint16{
+		"SPRITE.X":      0,
+		"SPRITE.Y":      1,
+		"SPRITE.FRAMES": 2,
+		"SPRITE":        6, // struct name is its size
+	}
+	for name, want := range offsets {
+		if got, ok := result.Symbols[name]; !ok || got != want {
+			t.Errorf("%s = %d (defined %v), want %d", name, got, ok, want)
+		}
+	}
+
+	expected := []byte{
+		0xDD, 0x77, 0x01, // LD (IX+sprite.y), A
+		0xDD, 0x46, 0x02, // LD B, (IX+sprite.frames)
+		0x21, 0x06, 0x00, // LD HL, sprite
+		0xC9,             // RET
+		0, 0, 0, 0, 0, 0, // INST sprite
+	}
+	if !bytes.Equal(result.Binary, expected) {
+		t.Errorf("binary = % X, want % X", result.Binary, expected)
+	}
+	if size := result.Symbols["AFTER"] - result.Symbols["PLAYER"]; size != 6 {
+		t.Errorf("INST sprite reserved %d bytes, want 6", size)
+	}
+}
+
+func TestWarnSelfModifying(t *testing.T) {
+	source := `
+    ORG $8000
+main:
+    LD A, 1
+    LD (counter), A
+    LD (code_label), A
+    LD (smc_1+1), A
+    RET
+code_label:
+    NOP
+    RET
+patched:
+smc_1:
+    LD A, 0
+    RET
+
+    ORG $F000
+counter:
+    DB 0
+`
+	asm := NewAssembler()
+	asm.WarnSelfModifying = true
+	result, err := asm.AssembleString(source)
+	if err != nil {
+		t.Fatalf("Assembly failed: %v", err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("Assembly errors: %v", result.Errors)
+	}
+
+	if len(result.SelfModifyingWrites) != 1 {
+		t.Fatalf("got %d self-modifying writes, want 1: %v", len(result.SelfModifyingWrites), result.SelfModifyingWrites)
+	}
+	w := result.SelfModifyingWrites[0]
+	if w.Line != 6 || w.Target != result.Symbols["CODE_LABEL"] || w.Function != "CODE_LABEL" {
+		t.Errorf("flagged %+v, want LD (code_label),A on line 6", w)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "writes into code of 'CODE_LABEL'") {
+		t.Errorf("warnings = %v", result.Warnings)
+	}
+
+	// The check is opt-in
+	result, err = NewAssembler().AssembleString(source)
+	if err != nil {
+		t.Fatalf("Assembly failed: %v", err)
+	}
+	if len(result.SelfModifyingWrites) != 0 || len(result.Warnings) != 0 {
+		t.Errorf("stores flagged without WarnSelfModifying: %v", result.Warnings)
+	}
+}
+
+// decodeTAP splits a .TAP file into its blocks' flag and data, checking
+// each block's checksum
+func decodeTAP(t *testing.T, tap []byte) [][]byte {
+	t.Helper()
+	var blocks [][]byte
+	for len(tap) > 0 {
+		if len(tap) < 2 {
+			t.Fatalf("truncated block length")
+		}
+		length := int(tap[0]) | int(tap[1])<<8
+		if length < 2 || len(tap) < 2+length {
+			t.Fatalf("block length %d does not fit the %d remaining bytes", length, len(tap)-2)
+		}
+		block := tap[2 : 2+length]
+		checksum := byte(0)
+		for _, b := range block {
+			checksum ^= b
+		}
+		if checksum != 0 {
+			t.Errorf("block %d has a bad checksum", len(blocks))
+		}
+		blocks = append(blocks, block[:length-1]) // Flag and data
+		tap = tap[2+length:]
+	}
+	return blocks
+}
+
+func TestAutorunTAP(t *testing.T) {
+	result, err := NewAssembler().AssembleString("    ORG $8000\nstart:\n    LD A, 2\n    RET\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tap, err := GenerateAutorunTAP(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := decodeTAP(t, tap)
+	if len(blocks) != 4 {
+		t.Fatalf("got %d blocks, want loader header and data, code header and data", len(blocks))
+	}
+	word := func(b []byte, i int) uint16 { return uint16(b[i]) | uint16(b[i+1])<<8 }
+
+	// BASIC loader, autostarting at line 10
+	header := blocks[0]
+	if len(header) != 18 || header[0] != 0x00 || header[1] != 0 {
+		t.Fatalf("loader header = % X, want a 17-byte program header", header)
+	}
+	loader := blocks[1]
+	if loader[0] != 0xFF || word(header, 12) != uint16(len(loader)-1) || word(header, 14) != 10 {
+		t.Errorf("loader header = % X does not describe a %d-byte program starting at line 10", header, len(loader)-1)
+	}
+	wantLoader := []byte{
+		0x00, 0x0A, 0x1A, 0x00, // Line 10, 26 bytes
+		0xFD, 0xB0, '"', '3', '2', '7', '6', '7', '"', ':', // CLEAR VAL "32767":
+		0xEF, '"', '"', 0xAF, ':', // LOAD ""CODE :
+		0xF9, 0xC0, 0xB0, '"', '3', '2', '7', '6', '8', '"', // RANDOMIZE USR VAL "32768"
+		0x0D,
+	}
+	if !bytes.Equal(loader[1:], wantLoader) {
+		t.Errorf("loader = % X, want % X", loader[1:], wantLoader)
+	}
+
+	// The code file follows, loading at the origin
+	code := blocks[2]
+	if code[0] != 0x00 || code[1] != 3 || word(code, 12) != 3 || word(code, 14) != 0x8000 {
+		t.Errorf("code header = % X, want 3 bytes of CODE at $8000", code)
+	}
+	if !bytes.Equal(blocks[3], append([]byte{0xFF}, result.Binary...)) {
+		t.Errorf("code data = % X, want FF % X", blocks[3], result.Binary)
+	}
+
+	low, err := NewAssembler().AssembleString("    ORG $5C00\n    RET\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GenerateAutorunTAP(low); err == nil {
+		t.Error("expected an error for code overlapping BASIC")
+	}
+}
+
+func TestIntelHex(t *testing.T) {
+	result, err := NewAssembler().AssembleString("    ORG $8000\n    LD HL, msg\n    RET\nmsg:\n    DB \"Hello, EPROM world!\", 0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hex, err := GenerateIntelHex(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 24 bytes: a full record, the 8 left over, then end of file
+	want := ":10800000210480C948656C6C6F2C204550524F4D3F\n" +
+		":0880100020776F726C642100FF\n" +
+		":00000001FF\n"
+	if string(hex) != want {
+		t.Errorf("hex =\n%s\nwant\n%s", hex, want)
+	}
+
+	high, err := NewAssembler().AssembleString("    ORG $FFFE\n    DB 1, 2, 3\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GenerateIntelHex(high); err == nil {
+		t.Error("expected an error for code past $FFFF")
+	}
+}
+
+func TestSymbolFormats(t *testing.T) {
+	result, err := NewAssembler().AssembleString("    ORG $8000\nmain:\n    RET\nSCR EQU $4000\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"labels": "02:8000 MAIN\n05:4000 SCR",
+		"map":    "MAIN                            = $8000 ; addr, public\nSCR                             = $4000 ; addr, public",
+		"sjasm":  "MAIN: EQU 0x00008000\nSCR: EQU 0x00004000",
+		"nocash": "8000 MAIN\n4000 SCR",
+	} {
+		format, err := ParseSymbolFormat(name)
+		if err != nil {
+			t.Fatalf("ParseSymbolFormat(%q): %v", name, err)
+		}
+		if got := string(GenerateSymbols(result, format, true)); got != want {
+			t.Errorf("%s symbols =\n%s\nwant\n%s", name, got, want)
+		}
+	}
+
+	for filename, want := range map[string]string{"game.labels": "labels", "game.MAP": "map", "game.sym": "minz", "game": "minz"} {
+		if got := SymbolFormatForFile(filename).Name; got != want {
+			t.Errorf("%s gets format %s, want %s", filename, got, want)
+		}
+	}
+	if _, err := ParseSymbolFormat("elf"); err == nil {
+		t.Error("expected an error for an unknown symbol format")
+	}
+}
+
+func TestZ80N(t *testing.T) {
+	source := `    ORG $8000
+    MUL D, E
+    ADD HL, A
+    ADD HL, BC
+    ADD DE, $1234
+    PUSH $1234
+    PUSH HL
+    NEXTREG $07, 3
+    NEXTREG $07, A
+    PIXELAD
+    SETAE
+    JP (C)
+    JP (HL)
+`
+	want := []byte{
+		0xED, 0x30,             // MUL D, E
+		0xED, 0x31,             // ADD HL, A
+		0x09,                   // ADD HL, BC (Z80)
+		0xED, 0x35, 0x34, 0x12, // ADD DE, $1234
+		0xED, 0x8A, 0x12, 0x34, // PUSH $1234, big-endian
+		0xE5,                   // PUSH HL (Z80)
+		0xED, 0x91, 0x07, 0x03, // NEXTREG $07, 3
+		0xED, 0x92, 0x07,       // NEXTREG $07, A
+		0xED, 0x94,             // PIXELAD
+		0xED, 0x95,             // SETAE
+		0xED, 0x98,             // JP (C)
+		0xE9,                   // JP (HL) (Z80)
+	}
+
+	// The zxnext target, the TARGET directive or the Z80N option enable them
+	next := NewAssembler()
+	if err := next.SetTarget(TargetZXNext); err != nil {
+		t.Fatal(err)
+	}
+	directive := NewAssembler()
+	option := NewAssembler()
+	option.Z80N = true
+	for name, assembler := range map[string]*Assembler{"target": next, "directive": directive, "option": option} {
+		src := source
+		if name == "directive" {
+			src = "    TARGET zxnext\n" + source
+		}
+		result, err := assembler.AssembleString(src)
+		if err != nil || len(result.Errors) > 0 {
+			t.Fatalf("%s: %v %v", name, err, result.Errors)
+		}
+		if !bytes.Equal(result.Binary, want) {
+			t.Errorf("%s: got % X, want % X", name, result.Binary, want)
+		}
+	}
+
+	// On other targets they are errors
+	result, err := NewAssembler().AssembleString("    ORG $8000\n    MUL D, E\n")
+	if err == nil && len(result.Errors) == 0 {
+		t.Fatal("MUL D, E assembled without Z80N")
+	}
+	if err == nil {
+		err = fmt.Errorf("%v", result.Errors)
+	}
+	if !strings.Contains(err.Error(), "Z80N instruction needs the zxnext target") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestNEXFile(t *testing.T) {
+	assembler := NewAssembler()
+	assembler.Z80N = true
+	// From the end of bank 2 into bank 0
+	result, err := assembler.AssembleString("    ORG $BFFE\n    MUL D, E\n    RET\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nex, err := generateNEXFile(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nex) != nexHeaderSize+2*0x4000 {
+		t.Fatalf("got %d bytes, want the header and two banks", len(nex))
+	}
+	if string(nex[:8]) != "NextV1.2" || nex[nexBankCount] != 2 {
+		t.Errorf("header starts % X, want NextV1.2 and 2 banks", nex[:10])
+	}
+	if nex[nexBanks+2] != 1 || nex[nexBanks+0] != 1 || nex[nexBanks+5] != 0 {
+		t.Errorf("bank flags = % X, want banks 0 and 2", nex[nexBanks:nexBanks+8])
+	}
+	if nex[nexPC] != 0xFE || nex[nexPC+1] != 0xBF {
+		t.Errorf("PC = $%02X%02X, want $BFFE", nex[nexPC+1], nex[nexPC])
+	}
+
+	// Bank 2 comes first and ends with MUL, then bank 0 starts with RET
+	bank2, bank0 := nex[nexHeaderSize:nexHeaderSize+0x4000], nex[nexHeaderSize+0x4000:]
+	if bank2[0x3FFE] != 0xED || bank2[0x3FFF] != 0x30 || bank0[0] != 0xC9 {
+		t.Errorf("banks end % X and start % X", bank2[0x3FFE:], bank0[:1])
+	}
+
+	low, err := NewAssembler().AssembleString("    ORG $0100\n    RET\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := generateNEXFile(low); err == nil {
+		t.Error("expected an error for code below $4000")
+	}
+}
+
+func TestSM83(t *testing.T) {
+	source := `    ORG $0150
+    LD (HL+), A
+    LD A, (HLD)
+    LDI A, (HL)
+    LDH ($44), A
+    LDH A, ($FF44)
+    LD (C), A
+    LDH A, (C)
+    LD ($C000), A
+    LD A, ($C000)
+    LD ($C002), SP
+    ADD SP, -4
+    LD HL, SP+6
+    SWAP A
+    SWAP (HL)
+    STOP
+    RETI
+    LD A, B
+    JP (HL)
+`
+	want := []byte{
+		0x22,             // LD (HL+), A
+		0x3A,             // LD A, (HLD)
+		0x2A,             // LDI A, (HL)
+		0xE0, 0x44,       // LDH ($44), A
+		0xF0, 0x44,       // LDH A, ($FF44)
+		0xE2,             // LD (C), A
+		0xF2,             // LDH A, (C)
+		0xEA, 0x00, 0xC0, // LD ($C000), A
+		0xFA, 0x00, 0xC0, // LD A, ($C000)
+		0x08, 0x02, 0xC0, // LD ($C002), SP
+		0xE8, 0xFC,       // ADD SP, -4
+		0xF8, 0x06,       // LD HL, SP+6
+		0xCB, 0x37,       // SWAP A
+		0xCB, 0x36,       // SWAP (HL)
+		0x10, 0x00,       // STOP
+		0xD9,             // RETI
+		0x78,             // LD A, B (Z80)
+		0xE9,             // JP (HL) (Z80)
+	}
+	assembler := NewAssembler()
+	if err := assembler.SetTarget(TargetGameBoy); err != nil {
+		t.Fatal(err)
+	}
+	result, err := assembler.AssembleString(source)
+	if err != nil || len(result.Errors) > 0 {
+		t.Fatalf("%v %v", err, result.Errors)
+	}
+	if !bytes.Equal(result.Binary, want) {
+		t.Errorf("got % X, want % X", result.Binary, want)
+	}
+
+	for _, tt := range []struct {
+		gameboy bool
+		line    string
+		want    string
+	}{
+		{false, "SWAP A", "SM83 instruction needs the gameboy target"},
+		{true, "EXX", "not an SM83 instruction"},
+		{true, "LD IX, $1234", "not an SM83 instruction"},
+		{true, "LD A, ($1234)", ""},
+		{true, "LD HL, ($1234)", "not an SM83 instruction"},
+		{true, "SLL B", "not an SM83 instruction"},
+	} {
+		assembler := NewAssembler()
+		if tt.gameboy {
+			assembler.SetTarget(TargetGameBoy)
+		}
+		result, err := assembler.AssembleString("    ORG $0150\n    " + tt.line + "\n")
+		if err == nil && len(result.Errors) > 0 {
+			err = fmt.Errorf("%v", result.Errors)
+		}
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.line, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: error = %v, want %q", tt.line, err, tt.want)
+		}
+	}
+}
+
+func TestGBROM(t *testing.T) {
+	assembler := NewAssembler()
+	assembler.SetTarget(TargetGameBoy)
+	result, err := assembler.AssembleString("    ORG $0150\nstart:\n    DI\n    JR start\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rom, err := generateGBROM(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rom) != gbMaxROM {
+		t.Fatalf("got %d bytes, want a 32K cartridge", len(rom))
+	}
+	if !bytes.Equal(rom[gbEntry:gbLogo], []byte{0x00, 0xC3, 0x50, 0x01}) {
+		t.Errorf("entry = % X, want NOP, JP $0150", rom[gbEntry:gbLogo])
+	}
+	if !bytes.Equal(rom[gbLogo:gbTitle], gbLogoBitmap) {
+		t.Error("the header has no logo")
+	}
+	if !bytes.Equal(rom[0x150:0x153], []byte{0xF3, 0x18, 0xFD}) {
+		t.Errorf("code = % X", rom[0x150:0x153])
+	}
+
+	// The boot ROM's header check, and the global checksum
+	var check byte
+	for _, b := range rom[0x134:0x14D] {
+		check = check - b - 1
+	}
+	if rom[0x14D] != check || check != 0xE7 {
+		t.Errorf("header checksum = $%02X, want $%02X", rom[0x14D], check)
+	}
+	sum := uint16(0)
+	for _, b := range rom {
+		sum += uint16(b)
+	}
+	sum -= uint16(rom[0x14E]) + uint16(rom[0x14F])
+	if got := uint16(rom[0x14E])<<8 | uint16(rom[0x14F]); got != sum {
+		t.Errorf("global checksum = $%04X, want $%04X", got, sum)
+	}
+
+	// A program with its own header keeps it
+	header, err := NewAssembler().AssembleString("    ORG $0100\n    NOP\n    JP $0200\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rom, err = generateGBROM(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rom[gbEntry+2] != 0x00 || rom[gbEntry+3] != 0x02 {
+		t.Errorf("entry = % X, want the program's JP $0200", rom[gbEntry:gbLogo])
+	}
+
+	for _, src := range []string{"    ORG $0120\n    NOP\n", "    ORG $7FFF\n    NOP\n    NOP\n"} {
+		result, err := NewAssembler().AssembleString(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := generateGBROM(result); err == nil {
+			t.Errorf("expected an error for %q", src)
+		}
+	}
+}
+
+func TestParseOutputFormat(t *testing.T) {
+	for name, ext := range map[string]string{"hex": ".hex", "TAP": ".tap", "bin": ".bin", "nex": ".nex", "gb": ".gb"} {
+		format, err := ParseOutputFormat(name)
+		if err != nil {
+			t.Errorf("ParseOutputFormat(%q): %v", name, err)
+			continue
+		}
+		if format.Extension != ext {
+			t.Errorf("%s extension = %s, want %s", name, format.Extension, ext)
+		}
+	}
+	if _, err := ParseOutputFormat("srec"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestMacroListing(t *testing.T) {
+	source := `    ORG $8000
+clear MACRO addr, count
+    LD HL, addr
+    LD B, count
+.fill:
+    LD (HL), 0
+    INC HL
+    DJNZ .fill
+ENDM
+MACRO beep
+    LD A, 7
+ENDM
+main:
+    clear $4000, 16
+    beep
+    RET
+`
+	result, err := NewAssembler().AssembleString(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("assembly errors: %v", result.Errors)
+	}
+
+	want := []byte{
+		0x21, 0x00, 0x40, 0x06, 0x10, 0x36, 0x00, 0x23, 0x10, 0xFB, // clear $4000, 16
+		0x3E, 0x07, // beep
+		0xC9,
+	}
+	if !bytes.Equal(result.Binary, want) {
+		t.Errorf("binary = % X, want % X", result.Binary, want)
+	}
+
+	// Each expanded line names its macro and the invocation line
+	var tags []string
+	for _, line := range result.Listing {
+		tags = append(tags, fmt.Sprintf("%s@%d", line.Macro, line.LineNumber))
+	}
+	wantTags := []string{"clear@14", "clear@14", "clear@14", "clear@14", "clear@14", "beep@15", "@16"}
+	if strings.Join(tags, " ") != strings.Join(wantTags, " ") {
+		t.Errorf("listing tags = %v, want %v", tags, wantTags)
+	}
+	if call := result.Listing[0].MacroCall; call != "CLEAR $4000, 16" {
+		t.Errorf("clear invoked as %q", call)
+	}
+	if labels := result.Listing[0].Labels; !reflect.DeepEqual(labels, []string{"main"}) {
+		t.Errorf("labels before clear %v", labels)
+	}
+	if call := result.Listing[6].MacroCall; call != "" {
+		t.Errorf("RET expanded from %q", call)
+	}
+
+	signatures := make(map[string]bool)
+	for _, macro := range result.Macros {
+		signatures[macro.Signature()] = true
+	}
+	for _, sig := range []string{"clear addr, count", "beep", "MEMCPY dst, src, size", "PUSH_ALL"} {
+		if !signatures[sig] {
+			t.Errorf("macro summary lacks %q: %v", sig, signatures)
+		}
+	}
+
+	// Macros do not leak into the next source
+	again := NewAssembler()
+	if _, err := again.AssembleString(source); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := again.AssembleString(source); err != nil {
+		t.Errorf("reassembling the same macros failed: %v", err)
+	}
+}
+
+func TestMacroLocalsRepeatVariadic(t *testing.T) {
+	source := `    ORG $8000
+COUNT EQU 3
+wait MACRO n
+    LOCAL again
+    LD B, n
+again:
+    DJNZ again
+ENDM
+MACRO bytes first, rest...
+    DB first, rest
+    REPT first
+    NOP
+    ENDR
+ENDM
+start:
+    wait 2
+    wait 4
+    REPT COUNT
+    INC A
+    ENDR
+    bytes 2, 7, 8
+    bytes 1
+    REPT 2
+    REPT 2
+    DEC A
+    ENDR
+    ENDR
+    RET
+`
+	result, err := NewAssembler().AssembleString(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("assembly errors: %v", result.Errors)
+	}
+	want := []byte{
+		0x06, 0x02, 0x10, 0xFE, // wait 2, with its own again
+		0x06, 0x04, 0x10, 0xFE, // wait 4
+		0x3C, 0x3C, 0x3C, // REPT COUNT
+		0x02, 0x07, 0x08, 0x00, 0x00, // bytes 2, 7, 8
+		0x01, 0x00, // bytes 1, with no rest
+		0x3D, 0x3D, 0x3D, 0x3D, // Nested REPT 2
+		0xC9,
+	}
+	if !bytes.Equal(result.Binary, want) {
+		t.Errorf("binary = % X, want % X", result.Binary, want)
+	}
+
+	var signature string
+	for _, macro := range result.Macros {
+		if macro.Name == "bytes" {
+			signature = macro.Signature()
+		}
+	}
+	if signature != "bytes first, rest..." {
+		t.Errorf("signature = %q", signature)
+	}
+
+	for _, tt := range []struct{ source, want string }{
+		{"    REPT 2\n    NOP\n", "REPT without ENDR"},
+		{"    NOP\n    ENDR\n", "ENDR without REPT"},
+		{"    REPT later\n    NOP\n    ENDR\nlater:\n", "REPT count must be a constant"},
+		{"    LOCAL loop\n", "LOCAL outside a macro"},
+		{"MACRO m a..., b\nENDM\n", "only the last parameter"},
+		{"MACRO m a, b...\nENDM\n    m\n", "expects at least 1 arguments, got 0"},
+	} {
+		result, err := NewAssembler().AssembleString(tt.source)
+		if err == nil && len(result.Errors) > 0 {
+			err = fmt.Errorf("%v", result.Errors)
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: error %v, want %q", tt.source, err, tt.want)
+		}
+	}
+}
+
+func TestObjectLink(t *testing.T) {
+	mainSource := `
+    PUBLIC start
+    EXTERN print, message
+start:
+    LD HL, message+1
+    CALL print
+    JR start
+    JP done
+done:
+    RET
+`
+	libSource := `
+    PUBLIC print, message, WIDTH
+WIDTH EQU 32
+print:
+    LD A, (HL)
+    RET
+message:
+    DB "Hi", 0
+    DW print
+`
+	mainObj, result, err := NewAssembler().AssembleObject(mainSource)
+	if err != nil {
+		t.Fatalf("main: %v", err)
+	}
+	if mainObj == nil {
+		t.Fatalf("main: assembly errors: %v", result.Errors)
+	}
+	libObj, _, err := NewAssembler().AssembleObject(libSource)
+	if err != nil || libObj == nil {
+		t.Fatalf("lib: object = %v, err = %v", libObj, err)
+	}
+
+	if want := []uint16{9}; !reflect.DeepEqual(mainObj.Relocations, want) {
+		t.Errorf("main relocations = %v, want %v (JP done only)", mainObj.Relocations, want)
+	}
+	wantExterns := []ExternReference{{Symbol: "PRINT", Offset: 4}, {Symbol: "MESSAGE", Offset: 1}}
+	if !reflect.DeepEqual(mainObj.Externs, wantExterns) {
+		t.Errorf("main externs = %v, want %v", mainObj.Externs, wantExterns)
+	}
+	wantPublics := []PublicSymbol{
+		{Name: "PRINT", Value: 0, Relocatable: true},
+		{Name: "MESSAGE", Value: 2, Relocatable: true},
+		{Name: "WIDTH", Value: 32, Relocatable: false},
+	}
+	if !reflect.DeepEqual(libObj.Publics, wantPublics) {
+		t.Errorf("lib publics = %v, want %v", libObj.Publics, wantPublics)
+	}
+
+	// Round trip through a file
+	path := t.TempDir() + "/lib.obj"
+	if err := libObj.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	libObj, err = ReadObjectFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	linked, err := Link([]*Object{mainObj, libObj}, 0x8000)
+	if err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	expected := []byte{
+		0x21, 0x0F, 0x80, // LD HL, message+1
+		0xCD, 0x0C, 0x80, // CALL print
+		0x18, 0xF8, // JR start
+		0xC3, 0x0B, 0x80, // JP done
+		0xC9,       // RET
+		0x7E, 0xC9, // print: LD A, (HL) : RET
+		'H', 'i', 0, // message
+		0x0C, 0x80, // DW print
+	}
+	if !bytes.Equal(linked.Binary, expected) {
+		t.Errorf("linked = % X, want % X", linked.Binary, expected)
+	}
+	if linked.Origin != 0x8000 || linked.Symbols["START"] != 0x8000 || linked.Symbols["WIDTH"] != 32 {
+		t.Errorf("origin $%04X, symbols %v", linked.Origin, linked.Symbols)
+	}
+
+	// Errors: missing and duplicate symbols, and what cannot be relocated
+	if _, err := Link([]*Object{mainObj}, 0x8000); err == nil || !strings.Contains(err.Error(), "undefined external symbol") {
+		t.Errorf("expected an undefined symbol error, got %v", err)
+	}
+	if _, err := Link([]*Object{libObj, libObj}, 0x8000); err == nil || !strings.Contains(err.Error(), "PUBLIC in both") {
+		t.Errorf("expected a duplicate symbol error, got %v", err)
+	}
+	if _, _, err := NewAssembler().AssembleObject("here:\n    LD A, here^H\n    RET\n"); err == nil {
+		t.Error("expected an error for the high byte of an address")
+	}
+	if obj, _, _ := NewAssembler().AssembleObject("    ORG $8000\n    RET\n"); obj != nil {
+		t.Error("expected ORG to be rejected in an object module")
+	}
+	if result, _ := NewAssembler().AssembleString("    EXTERN print\n    CALL print\n"); len(result.Errors) == 0 {
+		t.Error("expected EXTERN to be rejected outside an object module")
+	}
+}
+
+func TestConditionalAssembly(t *testing.T) {
+	source := `
+ZX EQU 1
+MSX EQU 2
+    IF PLATFORM = ZX
+    LD A, 1
+    ELIF PLATFORM == MSX
+    LD A, 2
+    ELSE
+    LD A, 3
+    ENDIF
+    IFDEF DEBUG
+    IF LEVEL > 1
+    HALT
+    ENDIF
+    NOP
+    ENDIF
+    IFNDEF start
+start:
+    ENDIF
+    IFDEF start
+    RET
+    ENDIF
+`
+	tests := []struct {
+		defines []string
+		want    []byte
+	}{
+		{[]string{"PLATFORM=1"}, []byte{0x3E, 0x01, 0xC9}},
+		{[]string{"platform=$2", "DEBUG", "LEVEL=1"}, []byte{0x3E, 0x02, 0x00, 0xC9}},
+		{[]string{"PLATFORM=3", "DEBUG", "LEVEL=2"}, []byte{0x3E, 0x03, 0x76, 0x00, 0xC9}},
+	}
+	for _, tt := range tests {
+		a := NewAssembler()
+		for _, def := range tt.defines {
+			if err := a.Define(def); err != nil {
+				t.Fatal(err)
+			}
+		}
+		result, err := a.AssembleString(source)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.defines, err)
+		}
+		if len(result.Errors) > 0 {
+			t.Fatalf("%v: assembly errors: %v", tt.defines, result.Errors)
+		}
+		if !bytes.Equal(result.Binary, tt.want) {
+			t.Errorf("%v: binary = % X, want % X", tt.defines, result.Binary, tt.want)
+		}
+	}
+
+	for _, bad := range []string{
+		"    IF 1\n    NOP\n",
+		"    NOP\n    ENDIF\n",
+		"    IF 1\n    ELSE\n    ELIF 1\n    ENDIF\n",
+		"    IF UNDEFINED\n    ENDIF\n",
+	} {
+		if _, err := NewAssembler().AssembleString(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+	if err := NewAssembler().Define("1X=2"); err == nil {
+		t.Error("expected an invalid define name to be rejected")
+	}
+}
+
+func TestExpressions(t *testing.T) {
+	source := `
+SIZE EQU 3
+    ORG $8000
+    LD HL, (buffer + SIZE*2) & $FFFE
+    LD A, (buffer_end - buffer) / 2
+    LD BC, 1 + 2 * 3 - 20 - 5
+    LD DE, ~$0F & $FF | 1 << 8
+    LD A, %1010 % 4 + 'A'
+    LD HL, (buffer)
+    LD HL, (buffer + 1) * 2
+    LD A, buffer >> 8
+buffer:
+    DS 8
+buffer_end:
+`
+	result, err := NewAssembler().AssembleString(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("assembly errors: %v", result.Errors)
+	}
+	want := []byte{
+		0x21, 0x1A, 0x80, // buffer = $8015; ($8015 + 6) & $FFFE
+		0x3E, 0x04,
+		0x01, 0xEE, 0xFF, // 1 + 6 - 20 - 5 wraps
+		0x11, 0xF0, 0x01, // & binds tighter than |, << tighter than &
+		0x3E, 0x43,
+		0x2A, 0x15, 0x80, // wholly in parentheses: a memory load
+		0x21, 0x2C, 0x00, // $8016 * 2 wraps
+		0x3E, 0x80,
+	}
+	if !bytes.Equal(result.Binary[:len(want)], want) {
+		t.Errorf("binary = % X, want % X", result.Binary[:len(want)], want)
+	}
+
+	for _, bad := range []string{"    LD A, (1 + 2\n", "    LD A, 1 +\n", "    LD A, 4 / 0\n", "    LD HL, missing * 2\n"} {
+		result, err := NewAssembler().AssembleString(bad)
+		if err == nil && len(result.Errors) == 0 {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+
+	// The compiler's mangled names carry $ after the first character
+	result, err = NewAssembler().AssembleString("    ORG $8000\nadd$u8$u8:\n    LD HL, add$u8$u8 + 1\n")
+	if err != nil || len(result.Errors) > 0 {
+		t.Fatalf("mangled name: %v %v", err, result.Errors)
+	}
+	if want := []byte{0x21, 0x01, 0x80}; !bytes.Equal(result.Binary, want) {
+		t.Errorf("mangled name: binary = % X, want % X", result.Binary, want)
+	}
+}
+
+func TestTiming(t *testing.T) {
+	tests := []struct {
+		source        string
+		tstates, taken int
+	}{
+		{"NOP", 4, 4},
+		{"LD HL, $1234", 10, 10},
+		{"LD A, (HL)", 7, 7},
+		{"LD (HL), 5", 10, 10},
+		{"LD ($8000), A", 13, 13},
+		{"JR NZ, $", 7, 12},
+		{"DJNZ $", 8, 13},
+		{"RET C", 5, 11},
+		{"CALL Z, $8000", 10, 17},
+		{"JP P, $8000", 10, 10},
+		{"EX (SP), HL", 19, 19},
+		{"RLC B", 8, 8},
+		{"SET 3, (HL)", 15, 15},
+		{"BIT 7, (HL)", 12, 12},
+		{"SBC HL, DE", 15, 15},
+		{"LD DE, ($8000)", 20, 20},
+		{"LDIR", 16, 21},
+		{"NEG", 8, 8},
+		{"LD IX, $1234", 14, 14},
+		{"PUSH IY", 15, 15},
+		{"LD SP, IX", 10, 10},
+		{"JP (IX)", 8, 8},
+		{"LD A, (IX+3)", 19, 19},
+		{"LD (IY+1), A", 19, 19},
+		{"INC (HL)", 11, 11},
+		{"BIT 1, (IX+4)", 20, 20},
+		{"RES 1, (IX+4)", 23, 23},
+	}
+	for _, tt := range tests {
+		result, err := NewAssembler().AssembleString("    ORG $8000\n    " + tt.source + "\n")
+		if err != nil || len(result.Errors) > 0 {
+			t.Fatalf("%s: %v %v", tt.source, err, result.Errors)
+		}
+		line := result.Listing[0]
+		if line.Tstates != tt.tstates || line.TstatesTaken != tt.taken {
+			t.Errorf("%s (% X): %d/%d T-states, want %d/%d", tt.source, line.Bytes, line.Tstates, line.TstatesTaken, tt.tstates, tt.taken)
+		}
+		if tstates, taken := Timing(line.Bytes); tstates != line.Tstates || taken != line.TstatesTaken {
+			t.Errorf("%s: Timing = %d/%d", tt.source, tstates, taken)
+		}
+	}
+
+	// Indexed forms the assembler does not take yet
+	for _, tt := range []struct {
+		code           []byte
+		tstates, taken int
+	}{
+		{[]byte{0xFD, 0x86, 0x02}, 19, 19},       // ADD A, (IY+2)
+		{[]byte{0xDD, 0x34, 0x00}, 23, 23},       // INC (IX+0)
+		{[]byte{0xDD, 0x36, 0x01, 0x07}, 19, 19}, // LD (IX+1), 7
+		{[]byte{0xDD, 0x24}, 8, 8},               // INC IXH
+		{[]byte{0xED}, 0, 0},
+	} {
+		if tstates, taken := Timing(tt.code); tstates != tt.tstates || taken != tt.taken {
+			t.Errorf("% X: %d/%d T-states, want %d/%d", tt.code, tstates, taken, tt.tstates, tt.taken)
+		}
+	}
+	
+	// Data takes no time
+	result, err := NewAssembler().AssembleString("    ORG $8000\n    DB 0, 0\n")
+	if err != nil || result.Listing[0].Tstates != 0 {
+		t.Errorf("DB listed with %d T-states (%v)", result.Listing[0].Tstates, err)
+	}
+}
+
+func TestSections(t *testing.T) {
+	source := `
+start:
+    LD HL, message
+    LD DE, buffer
+    SECTION BSS
+buffer:
+    DS 16
+    SECTION DATA
+message:
+    DB "HI", 0
+    SECTION CODE
+    RET
+    SECTION BSS
+count:
+    DS 2
+`
+	a := NewAssembler()
+	a.SetTarget(TargetZXSpectrum)
+	result, err := a.AssembleString(source)
+	if err != nil || len(result.Errors) > 0 {
+		t.Fatalf("assembly failed: %v %v", err, result.Errors)
+	}
+	
+	// CODE at $8000, then DATA, then BSS, which adds nothing to the binary
+	want := []byte{0x21, 0x07, 0x80, 0x11, 0x0A, 0x80, 0xC9, 'H', 'I', 0}
+	if !bytes.Equal(result.Binary, want) || result.Origin != 0x8000 {
+		t.Errorf("binary = % X at $%04X, want % X at $8000", result.Binary, result.Origin, want)
+	}
+	for name, addr := range map[string]uint16{"START": 0x8000, "MESSAGE": 0x8007, "BUFFER": 0x800A, "COUNT": 0x801A} {
+		if result.Symbols[name] != addr {
+			t.Errorf("%s = $%04X, want $%04X", name, result.Symbols[name], addr)
+		}
+	}
+	wantSections := []SectionInfo{
+		{Name: "CODE", Start: 0x8000, Size: 7},
+		{Name: "DATA", Start: 0x8007, Size: 3},
+		{Name: "BSS", Start: 0x800A, Size: 18, BSS: true},
+	}
+	if !reflect.DeepEqual(result.Sections, wantSections) {
+		t.Errorf("sections = %+v, want %+v", result.Sections, wantSections)
+	}
+	
+	// A configured base leaves a gap, which is zero filled
+	spec, err := ParseSectionSpec("data=$8010")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.ConfigureSection(spec)
+	result, err = a.AssembleString(source)
+	if err != nil || result.Symbols["MESSAGE"] != 0x8010 || result.Symbols["BUFFER"] != 0x8013 || len(result.Binary) != 0x13 {
+		t.Errorf("with DATA at $8010: %v, message $%04X, buffer $%04X, %d bytes",
+			err, result.Symbols["MESSAGE"], result.Symbols["BUFFER"], len(result.Binary))
+	}
+	
+	errorTests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"overflow", "    SECTION CODE\n    DS $4001\n", "CODE section exceeds $BFFF (ends at $C000)"},
+		{"overlap", "    SECTION CODE\n    DS 16\n    SECTION DATA, $8008\n    DB 1\n", "DATA section ($8008-$8008) overlaps CODE section ($8000-$800F)"},
+		{"code in BSS", "    SECTION BSS\n    DB 1\n", "BSS section can only reserve space with DS, not hold DB"},
+		{"ORG", "    ORG $9000\n    SECTION CODE\n    NOP\n", "ORG cannot be mixed with SECTION"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAssembler()
+			a.SetTarget(TargetZXSpectrum)
+			result, err := a.AssembleString(tt.source)
+			message := fmt.Sprint(err)
+			if result != nil {
+				message += fmt.Sprint(result.Errors)
+			}
+			if !strings.Contains(message, tt.want) {
+				t.Errorf("got %s, want %q", message, tt.want)
+			}
+		})
+	}
+	
+	for _, text := range []string{"CODE", "CODE=", "CODE=zz", "CODE=$8000:zz"} {
+		if _, err := ParseSectionSpec(text); err == nil {
+			t.Errorf("ParseSectionSpec(%q) succeeded", text)
+		}
+	}
+	if spec, err := ParseSectionSpec("bss=auto:$FEFF"); err != nil || spec != (SectionSpec{Name: "BSS", Auto: true, Limit: 0xFEFF}) {
+		t.Errorf("ParseSectionSpec(bss=auto:$FEFF) = %+v, %v", spec, err)
+	}
+}
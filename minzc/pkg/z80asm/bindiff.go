@@ -0,0 +1,86 @@
+package z80asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BinaryMismatchError reports the first byte where an output image differs
+// from the expected image
+type BinaryMismatchError struct {
+	Offset   int
+	Expected []byte
+	Actual   []byte
+}
+
+func (e *BinaryMismatchError) Error() string {
+	switch {
+	case e.Offset >= len(e.Expected):
+		return fmt.Sprintf("binary mismatch at offset $%04X: output is longer than expected (%d bytes, expected %d)",
+			e.Offset, len(e.Actual), len(e.Expected))
+	case e.Offset >= len(e.Actual):
+		return fmt.Sprintf("binary mismatch at offset $%04X: output is shorter than expected (%d bytes, expected %d)",
+			e.Offset, len(e.Actual), len(e.Expected))
+	}
+	return fmt.Sprintf("binary mismatch at offset $%04X: expected $%02X, got $%02X",
+		e.Offset, e.Expected[e.Offset], e.Actual[e.Offset])
+}
+
+// binaryDiffRow is the number of bytes per row of the diff context
+const binaryDiffRow = 16
+
+// Context returns a hex dump of both images around the mismatch: the row
+// holding it plus one row either side, with the differing byte marked.
+// Bytes past the end of an image are shown as "--".
+func (e *BinaryMismatchError) Context() string {
+	row := e.Offset / binaryDiffRow * binaryDiffRow
+	start := row - binaryDiffRow
+	if start < 0 {
+		start = 0
+	}
+	end := row + 2*binaryDiffRow
+
+	dump := func(data []byte, from int) string {
+		bytes := make([]string, binaryDiffRow)
+		for i := range bytes {
+			if from+i < len(data) {
+				bytes[i] = fmt.Sprintf("%02X", data[from+i])
+			} else {
+				bytes[i] = "--"
+			}
+		}
+		return strings.Join(bytes, " ")
+	}
+
+	var sb strings.Builder
+	for from := start; from < end; from += binaryDiffRow {
+		if from >= len(e.Expected) && from >= len(e.Actual) {
+			break
+		}
+		fmt.Fprintf(&sb, "  $%04X expected: %s\n", from, dump(e.Expected, from))
+		fmt.Fprintf(&sb, "        actual:   %s\n", dump(e.Actual, from))
+		if from == row {
+			marker := strings.Repeat(" ", 3*(e.Offset-row))
+			fmt.Fprintf(&sb, "                  %s^^\n", marker)
+		}
+	}
+	return sb.String()
+}
+
+// CompareBinary compares an output image byte for byte with the expected
+// image and returns a *BinaryMismatchError at the first difference
+func CompareBinary(actual, expected []byte) error {
+	n := len(actual)
+	if len(expected) < n {
+		n = len(expected)
+	}
+	for i := 0; i < n; i++ {
+		if actual[i] != expected[i] {
+			return &BinaryMismatchError{Offset: i, Expected: expected, Actual: actual}
+		}
+	}
+	if len(actual) != len(expected) {
+		return &BinaryMismatchError{Offset: n, Expected: expected, Actual: actual}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package z80asm
+
+import (
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+)
+
+// CRC32 computes the IEEE CRC32 checksum of an output image
+func CRC32(data []byte) uint32 {
+	return crc32.ChecksumIEEE(data)
+}
+
+// ParseCRC parses an expected checksum value.
+// Accepts the same hex prefixes as the assembler ($, 0x, #, h suffix);
+// a bare value is treated as hex since checksums are conventionally printed that way.
+func ParseCRC(s string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	lower := strings.ToLower(s)
+
+	switch {
+	case strings.HasPrefix(lower, "0x"):
+		s = s[2:]
+	case strings.HasPrefix(s, "$"), strings.HasPrefix(s, "#"):
+		s = s[1:]
+	case strings.HasSuffix(lower, "h"):
+		s = s[:len(s)-1]
+	}
+
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid CRC value: %q", s)
+	}
+	return uint32(v), nil
+}
+
+// CRCMismatchError reports a checksum verification failure
+type CRCMismatchError struct {
+	Expected uint32
+	Actual   uint32
+}
+
+func (e *CRCMismatchError) Error() string {
+	return fmt.Sprintf("CRC mismatch: expected $%08X, got $%08X", e.Expected, e.Actual)
+}
+
+// VerifyCRC checks data against an expected CRC32 value.
+// Returns the computed checksum and a *CRCMismatchError if they differ.
+func VerifyCRC(data []byte, expected string) (uint32, error) {
+	want, err := ParseCRC(expected)
+	if err != nil {
+		return 0, err
+	}
+
+	got := CRC32(data)
+	if got != want {
+		return got, &CRCMismatchError{Expected: want, Actual: got}
+	}
+	return got, nil
+}
@@ -0,0 +1,255 @@
+package z80asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Conditional assembly keeps or drops lines before they are assembled, so
+// one source can build for several platforms or feature sets:
+//
+//	IF expr         IFDEF name      IFNDEF name
+//	ELIF expr       ELSE            ENDIF
+//
+// An IF is true when its expression is non-zero; two expressions can be
+// compared with =, ==, !=, <>, <, <=, > or >=. Conditions see the symbols
+// set with Define (mza -D) and the EQU constants above them. Labels are not
+// placed yet: IFDEF knows the labels above it, but an IF cannot use their
+// addresses.
+
+// conditionalBlock is an IF ... ENDIF being processed
+type conditionalBlock struct {
+	line      int  // Line of the IF
+	outer     bool // Whether the enclosing code is assembled
+	active    bool // Whether the current branch is assembled
+	taken     bool // Whether a branch has been assembled
+	afterElse bool
+}
+
+// Define sets a symbol for every source assembled afterwards, as if by
+// NAME EQU value. def is NAME=value or just NAME, which is 1.
+func (a *Assembler) Define(def string) error {
+	name, valueText, hasValue := strings.Cut(def, "=")
+	name = strings.TrimSpace(name)
+	if !isValidSymbol(name) {
+		return fmt.Errorf("invalid symbol name in define %q", def)
+	}
+	value := uint16(1)
+	if hasValue {
+		v, err := a.EvaluateExpression(valueText)
+		if err != nil {
+			return fmt.Errorf("invalid value in define %q: %w", def, err)
+		}
+		value = v
+	}
+	if !a.CaseSensitive {
+		name = strings.ToUpper(name)
+	}
+	if a.defines == nil {
+		a.defines = make(map[string]uint16)
+	}
+	a.defines[name] = value
+	a.symbols[name] = &Symbol{Name: name, Value: value, Defined: true}
+	return nil
+}
+
+// isConditionalDirective reports whether a directive is part of
+// conditional assembly
+func isConditionalDirective(directive string) bool {
+	switch directive {
+	case "IF", "ELIF", "ELSE", "ENDIF", "IFDEF", "IFNDEF":
+		return true
+	}
+	return false
+}
+
+// applyConditionals removes the lines of conditional branches that are not
+// assembled, and the conditional directives themselves. Macro bodies are
+// left alone: they are handled once expanded.
+func (a *Assembler) applyConditionals(lines []*Line) ([]*Line, error) {
+	var result []*Line
+	var stack []*conditionalBlock
+	inMacro := false
+	known := make(map[string]bool) // Labels and constants above the line
+	var constants []string         // EQUs added to the symbol table
+
+	// The EQUs were only needed for the conditions; the passes define them
+	defer func() {
+		for _, name := range constants {
+			delete(a.symbols, name)
+		}
+	}()
+
+	active := func() bool {
+		return len(stack) == 0 || stack[len(stack)-1].active
+	}
+
+	for _, line := range lines {
+		directive := line.Directive
+		if inMacro || !isConditionalDirective(directive) {
+			if !active() {
+				continue
+			}
+			switch directive {
+			case "MACRO":
+				inMacro = true
+			case "ENDM":
+				inMacro = false
+			case "EQU":
+				if name, ok := a.conditionalConstant(line); ok {
+					constants = append(constants, name)
+				}
+			}
+			if line.Label != "" {
+				known[a.symbolName(line.Label)] = true
+			}
+			result = append(result, line)
+			continue
+		}
+
+		switch directive {
+		case "IF", "IFDEF", "IFNDEF":
+			block := &conditionalBlock{line: line.Number, outer: active()}
+			if block.outer {
+				cond, err := a.evaluateCondition(line, known)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", line.Number, err)
+				}
+				block.active, block.taken = cond, cond
+			}
+			stack = append(stack, block)
+
+		case "ELIF", "ELSE":
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: %s without IF", line.Number, directive)
+			}
+			block := stack[len(stack)-1]
+			if block.afterElse {
+				return nil, fmt.Errorf("line %d: %s after ELSE", line.Number, directive)
+			}
+			block.active = false
+			if directive == "ELSE" {
+				block.afterElse = true
+				block.active = block.outer && !block.taken
+			} else if block.outer && !block.taken {
+				cond, err := a.evaluateCondition(line, known)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", line.Number, err)
+				}
+				block.active = cond
+			}
+			block.taken = block.taken || block.active
+
+		case "ENDIF":
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: ENDIF without IF", line.Number)
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("line %d: IF without ENDIF", stack[len(stack)-1].line)
+	}
+	return result, nil
+}
+
+// symbolName returns the symbol table key of a name
+func (a *Assembler) symbolName(name string) string {
+	if a.CaseSensitive {
+		return name
+	}
+	return strings.ToUpper(name)
+}
+
+// conditionalConstant adds an EQU whose value is already known to the
+// symbol table, so later conditions can use it
+func (a *Assembler) conditionalConstant(line *Line) (string, bool) {
+	if line.Label == "" || len(line.Operands) != 1 {
+		return "", false
+	}
+	name := a.symbolName(line.Label)
+	if _, exists := a.symbols[name]; exists {
+		return "", false
+	}
+	value, err := a.EvaluateExpression(line.Operands[0])
+	if err != nil {
+		return "", false
+	}
+	a.symbols[name] = &Symbol{Name: name, Value: value, Defined: true}
+	return name, true
+}
+
+// evaluateCondition evaluates the condition of an IF, ELIF, IFDEF or IFNDEF
+func (a *Assembler) evaluateCondition(line *Line, known map[string]bool) (bool, error) {
+	operand := strings.TrimSpace(strings.Join(line.Operands, ","))
+	if operand == "" {
+		return false, fmt.Errorf("%s requires an operand", line.Directive)
+	}
+
+	if line.Directive == "IFDEF" || line.Directive == "IFNDEF" {
+		if !isValidSymbol(operand) {
+			return false, fmt.Errorf("%s requires a symbol name, got %q", line.Directive, operand)
+		}
+		name := a.symbolName(operand)
+		sym, ok := a.symbols[name]
+		defined := known[name] || ok && sym.Defined
+		return defined == (line.Directive == "IFDEF"), nil
+	}
+
+	left, op, right := splitComparison(operand)
+	lv, err := a.EvaluateExpression(left)
+	if err != nil {
+		return false, err
+	}
+	if op == "" {
+		return lv != 0, nil
+	}
+	rv, err := a.EvaluateExpression(right)
+	if err != nil {
+		return false, err
+	}
+	switch op {
+	case "=", "==":
+		return lv == rv, nil
+	case "!=", "<>":
+		return lv != rv, nil
+	case "<":
+		return lv < rv, nil
+	case "<=":
+		return lv <= rv, nil
+	case ">":
+		return lv > rv, nil
+	default: // ">="
+		return lv >= rv, nil
+	}
+}
+
+// splitComparison splits a condition at its comparison operator, outside
+// quotes and parentheses. op is empty when there is none.
+func splitComparison(expr string) (left, op, right string) {
+	depth := 0
+	quote := byte(0)
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0 && strings.ContainsRune("=!<>", rune(c)):
+			op = expr[i : i+1]
+			if i+1 < len(expr) && strings.ContainsRune("=>", rune(expr[i+1])) {
+				op = expr[i : i+2]
+			}
+			return strings.TrimSpace(expr[:i]), op, strings.TrimSpace(expr[i+len(op):])
+		}
+	}
+	return expr, "", ""
+}
@@ -12,6 +12,8 @@ func (a *Assembler) processDirective(line *Line) error {
 	switch directive {
 	case "ORG":
 		return a.handleORG(line)
+	case "SECTION":
+		return a.handleSECTION(line)
 	case "DB", "DEFB":
 		return a.handleDB(line)
 	case "DW", "DEFW":
@@ -30,10 +32,22 @@ func (a *Assembler) processDirective(line *Line) error {
 		return a.handleMACRO(line)
 	case "ENDM":
 		return a.handleENDM(line)
+	case "LOCAL":
+		return fmt.Errorf("LOCAL outside a macro")
+	case "STRUCT":
+		return a.handleSTRUCT(line)
+	case "ENDS":
+		return a.handleENDS(line)
+	case "INST":
+		return a.handleINST(line)
 	case "TARGET":
 		return a.handleTARGET(line)
 	case "MODEL":
 		return a.handleMODEL(line)
+	case "PUBLIC":
+		return a.handlePUBLIC(line)
+	case "EXTERN":
+		return a.handleEXTERN(line)
 	default:
 		if a.Strict {
 			return fmt.Errorf("unknown directive: %s", directive)
@@ -67,6 +81,12 @@ func (a *Assembler) handleORG(line *Line) error {
 	if len(line.Operands) != 1 {
 		return fmt.Errorf("ORG requires exactly one operand")
 	}
+	if a.relocatable {
+		return fmt.Errorf("ORG is not allowed in an object module; the linker places it")
+	}
+	if a.usesSections {
+		return fmt.Errorf("ORG cannot be mixed with SECTION; give the section a base instead")
+	}
 	
 	addr, err := a.resolveValue(line.Operands[0])
 	if err != nil {
@@ -476,7 +496,7 @@ func (a *Assembler) handleTARGET(line *Line) error {
 	target, err := ParseTarget(targetStr)
 	if err != nil {
 		// Provide helpful error with available targets
-		return fmt.Errorf("unknown target '%s'. Available targets: generic, zxspectrum, cpm, msx, gameboy", targetStr)
+		return fmt.Errorf("unknown target '%s'. Available targets: generic, zxspectrum, zxnext, cpm, msx, gameboy", targetStr)
 	}
 	
 	// Set the target configuration
@@ -149,8 +149,8 @@ func encodeLD(a *Assembler, line *Line, def *InstructionDef) ([]byte, error) {
 		return encodeLDRegReg(destReg, srcReg)
 	}
 	
-	// Handle immediate loads
-	if destIsReg && !srcIsReg {
+	// Handle immediate loads (LD r, (nn) is a memory load)
+	if destIsReg && !srcIsReg && !isIndirect(src) {
 		return encodeLDRegImm(a, destReg, src)
 	}
 	
@@ -169,6 +169,20 @@ func encodeLD(a *Assembler, line *Line, def *InstructionDef) ([]byte, error) {
 
 // encodeLDRegReg handles register to register loads
 func encodeLDRegReg(dest, src Register) ([]byte, error) {
+	// Interrupt vector and refresh registers only load from and into A
+	switch {
+	case dest == RegI && src == RegA:
+		return []byte{0xED, 0x47}, nil
+	case dest == RegR && src == RegA:
+		return []byte{0xED, 0x4F}, nil
+	case dest == RegA && src == RegI:
+		return []byte{0xED, 0x57}, nil
+	case dest == RegA && src == RegR:
+		return []byte{0xED, 0x5F}, nil
+	case dest == RegI || dest == RegR || src == RegI || src == RegR:
+		return nil, fmt.Errorf("I and R only load from and into A")
+	}
+
 	// 8-bit register to register
 	if isReg8(dest) && isReg8(src) {
 		destCode, err := encodeReg8(dest)
@@ -489,7 +503,7 @@ func encodeIXOffset(a *Assembler, dest, src string) ([]byte, error) {
 	var err error
 	
 	if isIndexedOperand(dest, "IX") {
-		offset, err = getIndexOffset(dest)
+		offset, err = a.getIndexOffset(dest)
 		if err != nil {
 			return nil, err
 		}
@@ -516,7 +530,7 @@ func encodeIXOffset(a *Assembler, dest, src string) ([]byte, error) {
 	}
 	
 	if isIndexedOperand(src, "IX") {
-		offset, err = getIndexOffset(src)
+		offset, err = a.getIndexOffset(src)
 		if err != nil {
 			return nil, err
 		}
@@ -543,7 +557,7 @@ func encodeIYOffset(a *Assembler, dest, src string) ([]byte, error) {
 	var err error
 	
 	if isIndexedOperand(dest, "IY") {
-		offset, err = getIndexOffset(dest)
+		offset, err = a.getIndexOffset(dest)
 		if err != nil {
 			return nil, err
 		}
@@ -570,7 +584,7 @@ func encodeIYOffset(a *Assembler, dest, src string) ([]byte, error) {
 	}
 	
 	if isIndexedOperand(src, "IY") {
-		offset, err = getIndexOffset(src)
+		offset, err = a.getIndexOffset(src)
 		if err != nil {
 			return nil, err
 		}
@@ -79,172 +79,236 @@ func (a *Assembler) EvaluateExpression(expr string) (uint16, error) {
 	
 	// Check for single symbol
 	if isValidSymbol(expr) {
-		if sym, ok := a.symbols[strings.ToUpper(expr)]; ok {
-			return sym.Value, nil
-		}
-		// In pass 1, forward references are OK - create placeholder
-		if a.pass == 1 {
-			a.symbols[strings.ToUpper(expr)] = &Symbol{
-				Name:    expr,
-				Defined: false,
-			}
-			return 0, nil
-		}
-		// In pass 2, undefined symbols are errors
-		return 0, fmt.Errorf("undefined symbol: %s", expr)
+		return a.symbolValue(expr)
 	}
 	
 	// Parse arithmetic expression
 	return a.evaluateArithmeticExpression(expr)
 }
 
-// evaluateArithmeticExpression handles +, -, *, / operations
+// Operands are full constant expressions, with C precedence from loosest
+// to tightest:
+//
+//	|   ^   &   << >>   + -   * / %   unary - + ~
+//
+// Parentheses group, and numbers, $ (the current address), 'c' and symbols
+// are the operands. Arithmetic wraps at 16 bits. A symbol defined further
+// down counts as 0 in pass 1 and has its value in pass 2:
+//
+//	LD HL, (buffer + SIZE*2) & $FFFE
+//	LD A, (table_end - table) / 2
+//
+// An operand wholly in parentheses is still an indirect one: LD HL, (buffer)
+// loads from memory.
+
+// binaryPrecedence gives each binary operator's precedence, higher binding
+// tighter
+var binaryPrecedence = map[string]int{
+	"|":  1,
+	"^":  2,
+	"&":  3,
+	"<<": 4, ">>": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+// exprParser evaluates one expression by precedence climbing
+type exprParser struct {
+	a    *Assembler
+	expr string
+	pos  int
+}
+
+// evaluateArithmeticExpression evaluates an expression with operators
 func (a *Assembler) evaluateArithmeticExpression(expr string) (uint16, error) {
-	// Simple tokenizer for arithmetic expressions
-	// Supports: symbol+number, symbol-number, number+number, etc.
-	
-	// Check for operators that shouldn't be split (^^H, ^^L, ^^, ^H, ^L)
-	// Handle combined operators first (longest patterns)
-	if strings.HasSuffix(expr, "^^H") || strings.HasSuffix(expr, "^^h") {
-		baseExpr := expr[:len(expr)-3]
-		val, err := a.EvaluateExpression(baseExpr)
-		if err != nil {
-			return 0, err
-		}
-		// Align then extract high byte
-		aligned := (val + 0xFF) & 0xFF00
-		return (aligned >> 8) & 0xFF, nil
+	p := &exprParser{a: a, expr: expr}
+	val, err := p.parseBinary(1)
+	if err != nil {
+		return 0, err
 	}
-	
-	if strings.HasSuffix(expr, "^^L") || strings.HasSuffix(expr, "^^l") {
-		baseExpr := expr[:len(expr)-3]
-		val, err := a.EvaluateExpression(baseExpr)
-		if err != nil {
-			return 0, err
-		}
-		// Align then extract low byte (should always be 0x00)
-		aligned := (val + 0xFF) & 0xFF00
-		return aligned & 0xFF, nil
+	if p.skipSpace(); p.pos < len(p.expr) {
+		return 0, fmt.Errorf("invalid expression: %s (unexpected %q)", expr, p.expr[p.pos:])
 	}
-	
-	// Handle address alignment (^^)
-	if strings.HasSuffix(expr, "^^") {
-		baseExpr := expr[:len(expr)-2]
-		val, err := a.EvaluateExpression(baseExpr)
-		if err != nil {
-			return 0, err
-		}
-		// Align to next 256-byte boundary (unless already aligned)
-		aligned := (val + 0xFF) & 0xFF00
-		return aligned, nil
+	return val, nil
+}
+
+// parseBinary parses operands joined by operators of at least minPrec
+func (p *exprParser) parseBinary(minPrec int) (uint16, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return 0, err
 	}
-	
-	// Check for high/low byte extraction
-	if strings.HasSuffix(expr, "^H") || strings.HasSuffix(expr, "^h") {
-		baseExpr := expr[:len(expr)-2]
-		val, err := a.EvaluateExpression(baseExpr)
-		if err != nil {
-			return 0, err
+	for {
+		op := p.peekOperator()
+		prec, ok := binaryPrecedence[op]
+		if !ok || prec < minPrec {
+			return left, nil
 		}
-		return (val >> 8) & 0xFF, nil // High byte
-	}
-	
-	if strings.HasSuffix(expr, "^L") || strings.HasSuffix(expr, "^l") {
-		baseExpr := expr[:len(expr)-2]
-		val, err := a.EvaluateExpression(baseExpr)
+		p.pos += len(op)
+		right, err := p.parseBinary(prec + 1)
 		if err != nil {
 			return 0, err
 		}
-		return val & 0xFF, nil // Low byte
-	}
-	
-	// Handle addition
-	if idx := strings.LastIndex(expr, "+"); idx > 0 {
-		left := expr[:idx]
-		right := expr[idx+1:]
-		
-		leftVal, err := a.EvaluateExpression(left)
-		if err != nil {
+		if left, err = p.apply(op, left, right); err != nil {
 			return 0, err
 		}
-		
-		rightVal, err := a.EvaluateExpression(right)
-		if err != nil {
-			return 0, err
+	}
+}
+
+// peekOperator returns the binary operator at the current position, or ""
+func (p *exprParser) peekOperator() string {
+	p.skipSpace()
+	rest := p.expr[p.pos:]
+	if strings.HasPrefix(rest, "<<") || strings.HasPrefix(rest, ">>") {
+		return rest[:2]
+	}
+	if rest != "" && strings.ContainsRune("|^&+-*/%", rune(rest[0])) {
+		return rest[:1]
+	}
+	return ""
+}
+
+// apply applies a binary operator
+func (p *exprParser) apply(op string, left, right uint16) (uint16, error) {
+	switch op {
+	case "|":
+		return left | right, nil
+	case "^":
+		return left ^ right, nil
+	case "&":
+		return left & right, nil
+	case "<<":
+		return left << right, nil
+	case ">>":
+		return left >> right, nil
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	}
+	if right == 0 {
+		// A divisor defined further down is still 0 in pass 1
+		if p.a.pass == 1 {
+			return 0, nil
 		}
-		
-		return leftVal + rightVal, nil
+		return 0, fmt.Errorf("division by zero in %s", p.expr)
 	}
-	
-	// Handle subtraction (but not negative numbers)
-	if idx := strings.LastIndex(expr, "-"); idx > 0 {
-		left := expr[:idx]
-		right := expr[idx+1:]
-		
-		leftVal, err := a.EvaluateExpression(left)
+	if op == "/" {
+		return left / right, nil
+	}
+	return left % right, nil
+}
+
+// parseUnary parses an operand, with any unary operators before it
+func (p *exprParser) parseUnary() (uint16, error) {
+	p.skipSpace()
+	if p.pos >= len(p.expr) {
+		return 0, fmt.Errorf("invalid expression: %s (missing operand)", p.expr)
+	}
+	switch p.expr[p.pos] {
+	case '-', '+', '~':
+		op := p.expr[p.pos]
+		p.pos++
+		val, err := p.parseUnary()
 		if err != nil {
 			return 0, err
 		}
-		
-		rightVal, err := a.EvaluateExpression(right)
-		if err != nil {
-			return 0, err
+		switch op {
+		case '-':
+			return -val, nil
+		case '~':
+			return ^val, nil
 		}
-		
-		return leftVal - rightVal, nil
-	}
-	
-	// Handle multiplication
-	if idx := strings.Index(expr, "*"); idx > 0 {
-		left := expr[:idx]
-		right := expr[idx+1:]
-		
-		leftVal, err := a.EvaluateExpression(left)
+		return val, nil
+	case '(':
+		p.pos++
+		val, err := p.parseBinary(1)
 		if err != nil {
 			return 0, err
 		}
-		
-		rightVal, err := a.EvaluateExpression(right)
-		if err != nil {
-			return 0, err
+		if p.skipSpace(); p.pos >= len(p.expr) || p.expr[p.pos] != ')' {
+			return 0, fmt.Errorf("invalid expression: %s (missing ')')", p.expr)
 		}
-		
-		return leftVal * rightVal, nil
+		p.pos++
+		return val, nil
 	}
-	
-	// Handle division
-	if idx := strings.Index(expr, "/"); idx > 0 {
-		left := expr[:idx]
-		right := expr[idx+1:]
-		
-		leftVal, err := a.EvaluateExpression(left)
-		if err != nil {
-			return 0, err
-		}
-		
-		rightVal, err := a.EvaluateExpression(right)
-		if err != nil {
-			return 0, err
+	return p.parseOperand()
+}
+
+// parseOperand parses a number, $, a character or a symbol
+func (p *exprParser) parseOperand() (uint16, error) {
+	start := p.pos
+	rest := p.expr[start:]
+	switch {
+	case rest[0] == '\'' || rest[0] == '"':
+		// 'A', or an escape such as '\n'
+		end := 1
+		if end < len(rest) && rest[end] == '\\' {
+			end++
 		}
-		
-		if rightVal == 0 {
-			return 0, fmt.Errorf("division by zero")
+		end += 2
+		if end > len(rest) || rest[end-1] != rest[0] {
+			return 0, fmt.Errorf("invalid expression: %s (bad character literal)", p.expr)
 		}
-		
-		return leftVal / rightVal, nil
+		p.pos += end
+		return parseNumber(rest[:end])
+	case rest[0] == '$' && (len(rest) == 1 || !isHexDigit(rest[1])):
+		p.pos++
+		return p.a.currentAddr, nil
 	}
-	
-	// Check for current address symbol
-	if expr == "$" {
-		return a.currentAddr, nil
+
+	// A number or symbol runs to the next operator, bracket or space; %
+	// starts a binary number only where an operand is expected
+	p.pos++
+	for p.pos < len(p.expr) && !strings.ContainsRune("|^&+-*/%<>~() \t", rune(p.expr[p.pos])) {
+		p.pos++
 	}
-	
-	// No operators found, try to parse as immediate
-	if val, err := a.parseImmediate(expr); err == nil {
-		return uint16(val), nil
+	token := p.expr[start:p.pos]
+	if isValidSymbol(token) {
+		return p.a.symbolValue(token)
 	}
-	
-	return 0, fmt.Errorf("invalid expression: %s", expr)
+	val, err := p.a.parseImmediate(token)
+	if err != nil {
+		val, err = parseNumber(token) // #FF
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid expression: %s (bad operand %q)", p.expr, token)
+	}
+	return val, nil
+}
+
+// skipSpace advances past spaces and tabs
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.expr) && (p.expr[p.pos] == ' ' || p.expr[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// symbolValue returns a symbol's value. In pass 1 a symbol not defined yet
+// is a forward reference and counts as 0.
+func (a *Assembler) symbolValue(name string) (uint16, error) {
+	sym, ok := a.symbols[strings.ToUpper(name)]
+	if ok && sym.Defined {
+		return sym.Value, nil
+	}
+	if a.pass == 1 {
+		if ok {
+			return 0, nil
+		}
+		a.symbols[strings.ToUpper(name)] = &Symbol{
+			Name:    name,
+			Defined: false,
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("undefined symbol: %s", name)
+}
+
+// isHexDigit reports whether c is a hexadecimal digit
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
 }
 
 // isValidSymbol checks if a string is a valid symbol name
@@ -258,9 +322,10 @@ func isValidSymbol(s string) bool {
 		return false
 	}
 	
-	// Rest can be letters, digits, or underscore
+	// Rest can be letters, digits, underscore, dot (struct.field,
+	// scope.local) or $ (the compiler's mangled names, add$u8$u8)
 	for _, r := range s[1:] {
-		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '.' && r != '$' {
 			return false
 		}
 	}
@@ -28,6 +28,9 @@ func expandFakeInstructions(lines []*Line) []*Line {
 		if mnemonic == "LD" && len(line.Operands) == 2 {
 			expanded := tryExpandFakeLD(line)
 			if expanded != nil {
+				for _, l := range expanded {
+					l.Macro, l.MacroCall = line.Macro, line.MacroCall
+				}
 				result = append(result, expanded...)
 				continue
 			}
@@ -0,0 +1,75 @@
+package z80asm
+
+import "fmt"
+
+// A Game Boy cartridge starts at $0100 in its ROM, after the restart and
+// interrupt vectors, with a header the boot ROM checks before running it:
+// the entry point (usually NOP, JP start), the Nintendo logo, which must
+// match the one in the boot ROM, the title and cartridge type, and a
+// checksum of the header. A 32K cartridge needs no memory bank controller
+// and maps the whole ROM at $0000-$7FFF.
+
+// Offsets of the cartridge header fields
+const (
+	gbEntry          = 0x0100 // Four bytes of code, jumping to the program
+	gbLogo           = 0x0104 // Nintendo logo, 48 bytes
+	gbTitle          = 0x0134 // Title, up to 16 characters
+	gbCartType       = 0x0147 // 0: ROM only
+	gbROMSize        = 0x0148 // 0: 32K
+	gbHeaderChecksum = 0x014D // Of $0134-$014C
+	gbGlobalChecksum = 0x014E // Of the rest of the ROM, high byte first
+	gbHeaderEnd      = 0x0150
+)
+
+// gbMaxROM is the size of a cartridge without a memory bank controller
+const gbMaxROM = 0x8000
+
+// gbLogoBitmap is the Nintendo logo the boot ROM compares the header with
+var gbLogoBitmap = []byte{
+	0xCE, 0xED, 0x66, 0x66, 0xCC, 0x0D, 0x00, 0x0B, 0x03, 0x73, 0x00, 0x83,
+	0x00, 0x0C, 0x00, 0x0D, 0x00, 0x08, 0x11, 0x1F, 0x88, 0x89, 0x00, 0x0E,
+	0xDC, 0xCC, 0x6E, 0xE6, 0xDD, 0xDD, 0xD9, 0x99, 0xBB, 0xBB, 0x67, 0x63,
+	0x6E, 0x0E, 0xEC, 0xCC, 0xDD, 0xDC, 0x99, 0x9F, 0xBB, 0xB9, 0x33, 0x3E,
+}
+
+// generateGBROM creates a 32K Game Boy cartridge ROM holding the binary at
+// its origin. The header keeps what the program put there and gets the
+// logo and checksums; a program that starts after the header gets the
+// entry point NOP, JP origin.
+func generateGBROM(result *Result) ([]byte, error) {
+	start := int(result.Origin)
+	end := start + len(result.Binary)
+	if end > gbMaxROM {
+		return nil, fmt.Errorf("Game Boy ROM too large: ends at $%04X, past $7FFF (a bigger cartridge needs a memory bank controller)", end-1)
+	}
+	if start > gbEntry && start < gbHeaderEnd {
+		return nil, fmt.Errorf("a Game Boy program cannot start inside the header ($%04X)", start)
+	}
+
+	rom := make([]byte, gbMaxROM)
+	copy(rom[start:], result.Binary)
+
+	if start >= gbHeaderEnd {
+		rom[gbEntry] = 0x00   // NOP
+		rom[gbEntry+1] = 0xC3 // JP origin
+		rom[gbEntry+2], rom[gbEntry+3] = byte(start), byte(start>>8)
+	}
+	copy(rom[gbLogo:], gbLogoBitmap)
+	rom[gbCartType] = 0
+	rom[gbROMSize] = 0
+
+	var check byte
+	for _, b := range rom[gbTitle:gbHeaderChecksum] {
+		check = check - b - 1
+	}
+	rom[gbHeaderChecksum] = check
+
+	var sum uint16
+	for i, b := range rom {
+		if i != gbGlobalChecksum && i != gbGlobalChecksum+1 {
+			sum += uint16(b)
+		}
+	}
+	rom[gbGlobalChecksum], rom[gbGlobalChecksum+1] = byte(sum>>8), byte(sum)
+	return rom, nil
+}
@@ -0,0 +1,54 @@
+package z80asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// An Intel HEX file is ASCII text, one record per line:
+//
+//	:LLAAAATT<data>CC
+//
+// LL is the number of data bytes, AAAA their load address, TT the record
+// type and CC the two's complement of the sum of all the other bytes. A Z80
+// program fits the 16-bit addresses of data records, so no extended address
+// records are needed; an end-of-file record closes the file.
+
+// Intel HEX record types
+const (
+	hexData = 0x00
+	hexEOF  = 0x01
+)
+
+// hexRecordSize is the number of data bytes per record, the usual width
+// EPROM programmers expect
+const hexRecordSize = 16
+
+// hexRecord encodes one record with its checksum
+func hexRecord(recordType byte, address uint16, data []byte) string {
+	record := append([]byte{byte(len(data)), byte(address >> 8), byte(address), recordType}, data...)
+	var sum byte
+	var sb strings.Builder
+	sb.WriteByte(':')
+	for _, b := range record {
+		sum += b
+		fmt.Fprintf(&sb, "%02X", b)
+	}
+	fmt.Fprintf(&sb, "%02X\n", -sum)
+	return sb.String()
+}
+
+// GenerateIntelHex creates an Intel HEX file loading the binary at its
+// origin, for EPROM programmers and monitors that read HEX
+func GenerateIntelHex(result *Result) ([]byte, error) {
+	if int(result.Origin)+len(result.Binary) > 0x10000 {
+		return nil, fmt.Errorf("code at $%04X (%d bytes) runs past $FFFF", result.Origin, len(result.Binary))
+	}
+	var sb strings.Builder
+	for offset := 0; offset < len(result.Binary); offset += hexRecordSize {
+		end := min(offset+hexRecordSize, len(result.Binary))
+		sb.WriteString(hexRecord(hexData, result.Origin+uint16(offset), result.Binary[offset:end]))
+	}
+	sb.WriteString(hexRecord(hexEOF, 0, nil))
+	return []byte(sb.String()), nil
+}
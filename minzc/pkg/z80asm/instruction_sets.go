@@ -554,7 +554,7 @@ func makeCBIndexEncoder(opcode byte, isIX bool) EncoderFunc {
 			return nil, fmt.Errorf("instruction requires 1 operand")
 		}
 		
-		offset, err := getIndexOffset(line.Operands[0])
+		offset, err := a.getIndexOffset(line.Operands[0])
 		if err != nil {
 			return nil, err
 		}
@@ -619,7 +619,7 @@ func makeBitIndexEncoder(baseOpcode byte, isIX bool) EncoderFunc {
 		}
 		
 		// Parse index offset
-		offset, err := getIndexOffset(line.Operands[1])
+		offset, err := a.getIndexOffset(line.Operands[1])
 		if err != nil {
 			return nil, err
 		}
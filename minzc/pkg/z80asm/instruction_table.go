@@ -20,6 +20,7 @@ const (
 	OpTypeRelative         // e (relative jump)
 	OpTypeCondition        // NZ, Z, NC, C, PO, PE, P, M
 	OpTypeBit              // 0-7 (bit number)
+	OpTypeSPOffset         // SP+e, SP-e (SM83)
 )
 
 // InstructionPattern defines a pattern for matching and encoding instructions
@@ -30,6 +31,8 @@ type InstructionPattern struct {
 	EncodingFunc func(*Assembler, *InstructionPattern, []interface{}) ([]byte, error)
 	Size         int // Size in bytes (0 = calculate from encoding)
 	Cycles       int // Clock cycles
+	Z80N         bool // ZX Spectrum Next only (see z80n.go)
+	SM83         bool // Game Boy only (see sm83.go)
 }
 
 // OperandPattern defines the pattern for a single operand
@@ -49,6 +52,8 @@ func init() {
 	instructionTable = append(instructionTable, stackInstructions...)
 	instructionTable = append(instructionTable, bitInstructions...)
 	instructionTable = append(instructionTable, miscInstructions...)
+	instructionTable = append(instructionTable, z80nInstructions...)
+	instructionTable = append(instructionTable, sm83Instructions...)
 }
 
 // LD instruction patterns - comprehensive coverage
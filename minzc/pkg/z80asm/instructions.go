@@ -973,27 +973,32 @@ func encodeCondition(cond Condition) (byte, error) {
 	}
 }
 
-// getIndexOffset extracts the offset from (IX+d) or (IY+d)
-func getIndexOffset(operand string) (int8, error) {
+// getIndexOffset extracts the offset from (IX+d) or (IY+d).
+// The offset may be an expression such as (IX+point.y).
+func (a *Assembler) getIndexOffset(operand string) (int8, error) {
 	inner := stripIndirect(operand)
 	upper := strings.ToUpper(inner)
 	
 	var offsetStr string
-	if strings.HasPrefix(upper, "IX+") {
+	negative := false
+	if strings.HasPrefix(upper, "IX+") || strings.HasPrefix(upper, "IY+") {
 		offsetStr = inner[3:]
-	} else if strings.HasPrefix(upper, "IX-") {
-		offsetStr = inner[2:] // Keep the minus sign
-	} else if strings.HasPrefix(upper, "IY+") {
+	} else if strings.HasPrefix(upper, "IX-") || strings.HasPrefix(upper, "IY-") {
 		offsetStr = inner[3:]
-	} else if strings.HasPrefix(upper, "IY-") {
-		offsetStr = inner[2:] // Keep the minus sign
+		negative = true
 	} else {
 		return 0, fmt.Errorf("invalid index format: %s", operand)
 	}
 	
 	val, err := parseNumber(offsetStr)
 	if err != nil {
-		return 0, err
+		val, err = a.resolveValue(offsetStr)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if negative {
+		val = -val
 	}
 	
 	// Check range
@@ -63,6 +63,8 @@ func preprocessLocalLabels(lines []*Line) ([]*Line, error) {
 			Operands: make([]string, len(line.Operands)),
 			Comment:  line.Comment,
 			IsBlank:  line.IsBlank,
+			Macro:    line.Macro,
+			MacroCall: line.MacroCall,
 		}
 		
 		// Process label if present
@@ -2,6 +2,7 @@ package z80asm
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 )
 
@@ -10,7 +11,8 @@ type Macro struct {
 	Name       string
 	Parameters []string
 	Body       []string
-	LocalCount int // Counter for unique local labels
+	LocalCount int  // Counter for unique local labels
+	Variadic   bool // The last parameter, written name..., takes the remaining arguments
 }
 
 // MacroExpansion represents an expanded macro instance
@@ -43,8 +45,16 @@ func (mp *MacroProcessor) DefineMacro(name string, params []string, body []strin
 	}
 	
 	// Validate parameters
+	params = slices.Clone(params)
+	variadic := false
 	paramSet := make(map[string]bool)
-	for _, param := range params {
+	for i, param := range params {
+		if base, ok := strings.CutSuffix(param, "..."); ok {
+			if i != len(params)-1 {
+				return fmt.Errorf("only the last parameter of macro '%s' can be variadic", name)
+			}
+			param, params[i], variadic = base, base, true
+		}
 		if paramSet[param] {
 			return fmt.Errorf("duplicate parameter '%s' in macro '%s'", param, name)
 		}
@@ -55,6 +65,7 @@ func (mp *MacroProcessor) DefineMacro(name string, params []string, body []strin
 		Name:       name,
 		Parameters: params,
 		Body:       body,
+		Variadic:   variadic,
 	}
 	
 	return nil
@@ -73,14 +84,23 @@ func (mp *MacroProcessor) ExpandMacro(name string, args []string) ([]string, err
 	}
 	
 	// Check argument count
-	if len(args) != len(macro.Parameters) {
+	if macro.Variadic {
+		if len(args) < len(macro.Parameters)-1 {
+			return nil, fmt.Errorf("macro '%s' expects at least %d arguments, got %d",
+				name, len(macro.Parameters)-1, len(args))
+		}
+	} else if len(args) != len(macro.Parameters) {
 		return nil, fmt.Errorf("macro '%s' expects %d arguments, got %d", 
 			name, len(macro.Parameters), len(args))
 	}
 	
-	// Create argument map
+	// Create argument map; a variadic parameter gets the rest of the list
 	argMap := make(map[string]string)
 	for i, param := range macro.Parameters {
+		if macro.Variadic && i == len(macro.Parameters)-1 {
+			argMap[param] = strings.Join(args[i:], ", ")
+			break
+		}
 		argMap[param] = args[i]
 	}
 	
@@ -92,8 +112,12 @@ func (mp *MacroProcessor) ExpandMacro(name string, args []string) ([]string, err
 	mp.expansionDepth++
 	defer func() { mp.expansionDepth-- }()
 	
+	body, locals := macroLocals(macro.Body)
 	var expanded []string
-	for _, line := range macro.Body {
+	for _, line := range body {
+		for _, local := range locals {
+			line = replaceIdentifier(line, local, fmt.Sprintf("%s__M%d", local, localBase))
+		}
 		expandedLine := mp.substituteLine(line, argMap, localBase)
 		
 		// Handle nested macro calls
@@ -111,6 +135,57 @@ func (mp *MacroProcessor) ExpandMacro(name string, args []string) ([]string, err
 	return expanded, nil
 }
 
+// macroLocals returns a macro body without its LOCAL lines, and the names
+// those declare. Each expansion gets its own copy of these labels.
+func macroLocals(body []string) ([]string, []string) {
+	var lines, locals []string
+	for _, line := range body {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.EqualFold(fields[0], "LOCAL") {
+			lines = append(lines, line)
+			continue
+		}
+		declared, _, _ := strings.Cut(strings.TrimSpace(line)[len(fields[0]):], ";")
+		for _, name := range strings.Split(declared, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				locals = append(locals, name)
+			}
+		}
+	}
+	return lines, locals
+}
+
+// replaceIdentifier replaces whole-word occurrences of name in line,
+// outside strings
+func replaceIdentifier(line, name, value string) string {
+	isWord := func(c byte) bool {
+		return c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+	}
+	var b strings.Builder
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ';':
+			b.WriteString(line[i:])
+			return b.String()
+		case strings.HasPrefix(line[i:], name) && (i == 0 || !isWord(line[i-1])) &&
+			(i+len(name) == len(line) || !isWord(line[i+len(name)])):
+			b.WriteString(value)
+			i += len(name) - 1
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
 // substituteLine replaces parameters and local labels in a line
 func (mp *MacroProcessor) substituteLine(line string, args map[string]string, localBase int) string {
 	result := line
@@ -0,0 +1,139 @@
+package z80asm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// expandMacros collects MACRO ... ENDM definitions and replaces each macro
+// invocation with its expanded body. Both forms of definition are accepted:
+//
+//	MACRO name param1, param2      name MACRO param1, param2
+//	    ; body                         ; body
+//	ENDM                           ENDM
+//
+// Expanded lines keep the invocation's line number, so errors point at the
+// call, and carry the macro name and the invocation for the listing.
+func (a *Assembler) expandMacros(lines []*Line) ([]*Line, error) {
+	var result []*Line
+	var def *macroDefinitionState
+	defLine := 0
+
+	for _, line := range lines {
+		if line.Directive == "MACRO" && def == nil {
+			name, params, err := macroHeader(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line.Number, err)
+			}
+			def = &macroDefinitionState{name: name, params: params}
+			defLine = line.Number
+			continue
+		}
+		if line.Directive == "ENDM" {
+			if def == nil {
+				return nil, fmt.Errorf("line %d: ENDM without matching MACRO", line.Number)
+			}
+			if err := a.macroProcessor.DefineMacro(def.name, def.params, def.body); err != nil {
+				return nil, fmt.Errorf("line %d: %w", defLine, err)
+			}
+			def = nil
+			continue
+		}
+		if def != nil {
+			if !line.IsBlank {
+				def.body = append(def.body, formatSourceLine(line))
+			}
+			continue
+		}
+
+		macro := a.lookupMacro(line.Mnemonic)
+		if macro == nil {
+			result = append(result, line)
+			continue
+		}
+		if line.Label != "" {
+			result = append(result, &Line{Number: line.Number, Label: line.Label})
+		}
+		call := formatSourceLine(&Line{Mnemonic: line.Mnemonic, Operands: line.Operands})
+		body, err := a.macroProcessor.ExpandMacro(macro.Name, line.Operands)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.Number, err)
+		}
+		for _, text := range body {
+			expanded, err := ParseLine(text, line.Number)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: in macro %s: %w", line.Number, macro.Name, err)
+			}
+			expanded.Macro = macro.Name
+			expanded.MacroCall = call
+			result = append(result, expanded)
+		}
+	}
+
+	if def != nil {
+		return nil, fmt.Errorf("line %d: MACRO %s without ENDM", defLine, def.name)
+	}
+	return result, nil
+}
+
+// macroHeader returns the name and parameters of a MACRO line. The operand
+// parser leaves the name and first parameter of "MACRO name p1, p2" in one
+// operand, so that is split on whitespace.
+func macroHeader(line *Line) (string, []string, error) {
+	var fields []string
+	for i, op := range line.Operands {
+		if i == 0 {
+			fields = append(fields, strings.Fields(op)...)
+		} else {
+			fields = append(fields, op)
+		}
+	}
+	if line.Label != "" {
+		return line.Label, fields, nil
+	}
+	if len(fields) == 0 {
+		return "", nil, fmt.Errorf("MACRO requires a name")
+	}
+	return fields[0], fields[1:], nil
+}
+
+// lookupMacro finds the macro a mnemonic invokes. Mnemonics are upper-cased
+// by the parser, so macro names match case-insensitively.
+func (a *Assembler) lookupMacro(mnemonic string) *Macro {
+	if mnemonic == "" {
+		return nil
+	}
+	if macro, ok := a.macroProcessor.GetMacro(mnemonic); ok {
+		return macro
+	}
+	for name, macro := range a.macroProcessor.macros {
+		if strings.EqualFold(name, mnemonic) {
+			return macro
+		}
+	}
+	return nil
+}
+
+// Macros returns every defined macro in name order
+func (mp *MacroProcessor) Macros() []*Macro {
+	macros := make([]*Macro, 0, len(mp.macros))
+	for _, macro := range mp.macros {
+		macros = append(macros, macro)
+	}
+	sort.Slice(macros, func(i, j int) bool { return macros[i].Name < macros[j].Name })
+	return macros
+}
+
+// Signature returns the macro's name and parameter list, e.g.
+// "MEMCPY dst, src, size"
+func (m *Macro) Signature() string {
+	if len(m.Parameters) == 0 {
+		return m.Name
+	}
+	signature := m.Name + " " + strings.Join(m.Parameters, ", ")
+	if m.Variadic {
+		signature += "..."
+	}
+	return signature
+}
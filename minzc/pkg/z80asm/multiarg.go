@@ -39,6 +39,9 @@ func expandMultiArgInstructions(lines []*Line) []*Line {
 		// Check if this instruction supports multi-arg
 		if multiArgInstructions[mnemonic] && len(line.Operands) > 1 {
 			expanded := expandMultiArg(line)
+			for _, l := range expanded {
+				l.Macro, l.MacroCall = line.Macro, line.MacroCall
+			}
 			result = append(result, expanded...)
 		} else {
 			result = append(result, line)
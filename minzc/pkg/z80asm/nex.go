@@ -0,0 +1,67 @@
+package z80asm
+
+import "fmt"
+
+// A .nex file is the executable format of NextZXOS on the ZX Spectrum
+// Next: a 512-byte header naming the program's 16K RAM banks and where it
+// starts, then the contents of each of those banks.
+
+// nexHeaderSize is the size of a .nex header (format V1.2)
+const nexHeaderSize = 512
+
+// Offsets of the .nex header fields
+const (
+	nexBankCount = 9   // Number of 16K banks in the file
+	nexBorder    = 11  // Border colour
+	nexSP        = 12  // Stack pointer
+	nexPC        = 14  // Start address (0: load only)
+	nexBanks     = 18  // One flag per bank 0-111 in the file
+	nexEntryBank = 139 // Bank paged in at $C000 to start
+)
+
+// nexBankAt maps the 16K pages of the 64K address space to the RAM banks
+// NextZXOS has them in when it starts a program (the ROM is at $0000)
+var nexBankAt = []struct {
+	address int
+	bank    int
+}{
+	{0x4000, 5},
+	{0x8000, 2},
+	{0xC000, 0},
+}
+
+// generateNEXFile creates a ZX Spectrum Next .nex file holding each bank
+// the binary occupies, in the order NextZXOS loads them (5, 2, 0). The
+// program starts at its origin with the stack at the top of memory.
+func generateNEXFile(result *Result) ([]byte, error) {
+	start := int(result.Origin)
+	end := start + len(result.Binary)
+	if start < 0x4000 {
+		return nil, fmt.Errorf("a .nex program must start at $4000 or above, not $%04X", start)
+	}
+	if end > 0x10000 {
+		return nil, fmt.Errorf("program runs past $FFFF (%d bytes from $%04X)", len(result.Binary), start)
+	}
+
+	header := make([]byte, nexHeaderSize)
+	copy(header, "NextV1.2")
+	header[nexBorder] = 7 // White
+	header[nexSP], header[nexSP+1] = 0xFE, 0xFF
+	header[nexPC], header[nexPC+1] = byte(start), byte(start>>8)
+	header[nexEntryBank] = 0
+
+	var banks []byte
+	for _, page := range nexBankAt {
+		from, to := max(start, page.address), min(end, page.address+0x4000)
+		if from >= to {
+			continue
+		}
+		bank := make([]byte, 0x4000)
+		copy(bank[from-page.address:], result.Binary[from-start:to-start])
+		banks = append(banks, bank...)
+		header[nexBanks+page.bank] = 1
+		header[nexBankCount]++
+	}
+
+	return append(header, banks...), nil
+}
@@ -0,0 +1,310 @@
+package z80asm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// An object module is code assembled at address 0 together with what the
+// linker needs to place it anywhere: the offsets of the words holding
+// addresses inside the module, the PUBLIC symbols it exports and the words
+// referring to EXTERN symbols it imports. Linking adds the module's address
+// to the first and the symbol's value to the second.
+//
+// The assembler does not track where values come from, so relocations are
+// found the way PRL files are made: the module is assembled a second time
+// at relocationProbe and every word that moved by exactly that much holds a
+// module address. Each EXTERN gets one more run with the symbol set to
+// relocationProbe. The probe's two bytes differ, so a lone high or low byte
+// of an address is reported instead of passing for a word.
+
+// objectFormat identifies an object file written by mza -c
+const objectFormat = "minz-object"
+
+// relocationProbe is the address a module is moved to when looking for
+// relocations
+const relocationProbe = 0x0301
+
+// Object is a relocatable object module
+type Object struct {
+	Format      string            `json:"format"`
+	Version     int               `json:"version"`
+	Module      string            `json:"module"`
+	Code        []byte            `json:"code"`        // Assembled at address 0; base64 in the file
+	Relocations []uint16          `json:"relocations"` // Offsets of words holding module addresses
+	Publics     []PublicSymbol    `json:"publics"`
+	Externs     []ExternReference `json:"externs"`
+}
+
+// PublicSymbol is a symbol exported with PUBLIC. Labels are relocatable
+// and move with the module; EQU constants are not.
+type PublicSymbol struct {
+	Name        string `json:"name"`
+	Value       uint16 `json:"value"`
+	Relocatable bool   `json:"relocatable"`
+}
+
+// ExternReference is a word in the code that refers to an EXTERN symbol.
+// The word holds the addend (as in LD HL, table+4) until it is linked.
+type ExternReference struct {
+	Symbol string `json:"symbol"`
+	Offset uint16 `json:"offset"`
+}
+
+// handlePUBLIC exports symbols from an object module. Outside an object
+// module it has no effect, so the same source can be assembled either way.
+func (a *Assembler) handlePUBLIC(line *Line) error {
+	if len(line.Operands) == 0 {
+		return fmt.Errorf("PUBLIC requires at least one symbol")
+	}
+	for _, operand := range line.Operands {
+		if !isValidSymbol(operand) {
+			return fmt.Errorf("invalid PUBLIC symbol '%s'", operand)
+		}
+		if a.pass == 1 {
+			a.publicNames = append(a.publicNames, a.symbolKey(operand))
+		}
+	}
+	return nil
+}
+
+// handleEXTERN declares symbols defined by another module, resolved when
+// the modules are linked
+func (a *Assembler) handleEXTERN(line *Line) error {
+	if len(line.Operands) == 0 {
+		return fmt.Errorf("EXTERN requires at least one symbol")
+	}
+	for _, operand := range line.Operands {
+		if !isValidSymbol(operand) {
+			return fmt.Errorf("invalid EXTERN symbol '%s'", operand)
+		}
+		name := a.symbolKey(operand)
+		if !a.relocatable {
+			return fmt.Errorf("external symbol '%s' can only be resolved by linking an object module", name)
+		}
+		if a.pass == 1 {
+			if err := a.defineConstant(name, a.externValues[name]); err != nil {
+				return err
+			}
+			a.externNames = append(a.externNames, name)
+		}
+	}
+	return nil
+}
+
+// AssembleObjectFile assembles a source file into an object module named
+// after the file
+func (a *Assembler) AssembleObjectFile(filename string) (*Object, *Result, error) {
+	source, err := ReadFile(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	object, result, err := a.AssembleObject(source)
+	if object != nil {
+		object.Module = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	}
+	return object, result, err
+}
+
+// AssembleObject assembles source code into an object module. The result
+// is the assembly at address 0, for listings and symbol files; when it has
+// errors the object is nil. ORG is not allowed, since the linker decides
+// where the module goes.
+func (a *Assembler) AssembleObject(source string) (*Object, *Result, error) {
+	origin := a.origin
+	a.relocatable = true
+	defer func() {
+		a.origin = origin
+		a.relocatable = false
+		a.externValues = nil
+	}()
+
+	assembleAt := func(addr uint16, externs map[string]uint16) (*Result, error) {
+		a.origin = addr
+		a.externValues = externs
+		return a.AssembleString(source)
+	}
+
+	base, err := assembleAt(0, nil)
+	if err != nil || len(base.Errors) > 0 {
+		return nil, base, err
+	}
+	externNames := a.externNames
+	publicNames := a.publicNames
+
+	moved, err := assembleAt(relocationProbe, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(moved.Errors) > 0 {
+		return nil, nil, fmt.Errorf("module cannot be relocated: %v", moved.Errors[0])
+	}
+	object := &Object{
+		Format:  objectFormat,
+		Version: 1,
+		Code:    base.Binary,
+	}
+	if object.Relocations, err = movedWords(base.Binary, moved.Binary); err != nil {
+		return nil, nil, err
+	}
+
+	for _, name := range externNames {
+		resolved, err := assembleAt(0, map[string]uint16{name: relocationProbe})
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(resolved.Errors) > 0 {
+			return nil, nil, fmt.Errorf("EXTERN %s cannot be resolved at link time: %v", name, resolved.Errors[0])
+		}
+		offsets, err := movedWords(base.Binary, resolved.Binary)
+		if err != nil {
+			return nil, nil, fmt.Errorf("EXTERN %s: %w", name, err)
+		}
+		for _, offset := range offsets {
+			object.Externs = append(object.Externs, ExternReference{Symbol: name, Offset: offset})
+		}
+	}
+
+	for _, name := range publicNames {
+		value, ok := base.Symbols[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("PUBLIC symbol '%s' is not defined", name)
+		}
+		object.Publics = append(object.Publics, PublicSymbol{
+			Name:        name,
+			Value:       value,
+			Relocatable: moved.Symbols[name] != value,
+		})
+	}
+
+	return object, base, nil
+}
+
+// movedWords compares code assembled twice, the second time with something
+// moved by relocationProbe, and returns the offsets of the words that moved
+// with it
+func movedWords(base, moved []byte) ([]uint16, error) {
+	if len(base) != len(moved) {
+		return nil, fmt.Errorf("module size depends on its address (%d or %d bytes); ALIGN cannot be used in an object module",
+			len(base), len(moved))
+	}
+
+	var offsets []uint16
+	for i := 0; i < len(base); i++ {
+		if base[i] == moved[i] {
+			continue
+		}
+		if i+1 < len(base) {
+			before := uint16(base[i]) | uint16(base[i+1])<<8
+			after := uint16(moved[i]) | uint16(moved[i+1])<<8
+			if after-before == relocationProbe {
+				offsets = append(offsets, uint16(i))
+				i++
+				continue
+			}
+		}
+		return nil, fmt.Errorf("byte at offset $%04X depends on an address but is not a 16-bit word", i)
+	}
+	return offsets, nil
+}
+
+// WriteFile writes the object module to a file
+func (o *Object) WriteFile(filename string) error {
+	data, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// ReadObjectFile reads an object module written by WriteFile
+func ReadObjectFile(filename string) (*Object, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var object Object
+	if err := json.Unmarshal(data, &object); err != nil || object.Format != objectFormat {
+		return nil, fmt.Errorf("%s is not an object file", filename)
+	}
+	if object.Version != 1 {
+		return nil, fmt.Errorf("%s: unsupported object version %d", filename, object.Version)
+	}
+	if object.Module == "" {
+		object.Module = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	}
+	return &object, nil
+}
+
+// Link places the object modules one after another from origin, applies
+// their relocations and resolves each EXTERN against the PUBLIC symbols of
+// all modules. The result's symbols are the public ones.
+func Link(objects []*Object, origin uint16) (*Result, error) {
+	// Place the modules and collect their public symbols
+	bases := make([]uint16, len(objects))
+	symbols := make(map[string]uint16)
+	owners := make(map[string]string)
+	end := int(origin)
+	for i, object := range objects {
+		bases[i] = uint16(end)
+		end += len(object.Code)
+		if end > 0x10000 {
+			return nil, fmt.Errorf("linked code runs past $FFFF (module %s ends at $%X)", object.Module, end)
+		}
+		for _, sym := range object.Publics {
+			if owner, exists := owners[sym.Name]; exists {
+				return nil, fmt.Errorf("symbol '%s' is PUBLIC in both %s and %s", sym.Name, owner, object.Module)
+			}
+			value := sym.Value
+			if sym.Relocatable {
+				value += bases[i]
+			}
+			symbols[sym.Name] = value
+			owners[sym.Name] = object.Module
+		}
+	}
+
+	// Copy the code and fix up addresses
+	binary := make([]byte, 0, end-int(origin))
+	for i, object := range objects {
+		code := append([]byte(nil), object.Code...)
+		for _, offset := range object.Relocations {
+			if err := addToWord(code, offset, bases[i]); err != nil {
+				return nil, fmt.Errorf("module %s: relocation %w", object.Module, err)
+			}
+		}
+		for _, ref := range object.Externs {
+			value, ok := symbols[ref.Symbol]
+			if !ok {
+				return nil, fmt.Errorf("module %s: undefined external symbol '%s'", object.Module, ref.Symbol)
+			}
+			if err := addToWord(code, ref.Offset, value); err != nil {
+				return nil, fmt.Errorf("module %s: reference to %s %w", object.Module, ref.Symbol, err)
+			}
+		}
+		binary = append(binary, code...)
+	}
+
+	return &Result{
+		Binary:  binary,
+		Origin:  origin,
+		Size:    uint16(len(binary)),
+		Symbols: symbols,
+	}, nil
+}
+
+// addToWord adds value to the little-endian word at offset
+func addToWord(code []byte, offset uint16, value uint16) error {
+	if int(offset)+1 >= len(code) {
+		return fmt.Errorf("at offset $%04X is outside the code", offset)
+	}
+	word := uint16(code[offset]) | uint16(code[offset+1])<<8
+	word += value
+	code[offset] = byte(word)
+	code[offset+1] = byte(word >> 8)
+	return nil
+}
@@ -16,6 +16,8 @@ type Line struct {
 	Operands   []string
 	Comment    string
 	IsBlank    bool
+	Macro      string // Macro this line was expanded from, if any
+	MacroCall  string // Source of the invocation it was expanded from
 }
 
 // ParseLine parses a single line of assembly
@@ -59,6 +61,16 @@ func ParseLine(line string, lineNum int) (*Line, error) {
 		return result, nil
 	}
 	
+	// Check for NAME MACRO params pattern
+	if len(tokens) >= 2 && strings.ToUpper(tokens[1]) == "MACRO" {
+		result.Label = tokens[0]
+		result.Directive = "MACRO"
+		if len(tokens) > 2 {
+			result.Operands = parseOperands(strings.Join(tokens[2:], " "))
+		}
+		return result, nil
+	}
+	
 	// Check if first token is a directive (starts with uppercase)
 	if isDirective(tokens[0]) {
 		result.Directive = strings.ToUpper(tokens[0])
@@ -85,9 +97,13 @@ func ParseLine(line string, lineNum int) (*Line, error) {
 func isDirective(token string) bool {
 	upper := strings.ToUpper(token)
 	directives := []string{
-		"ORG", "END", "DB", "DEFB", "DW", "DEFW", "DS", "DEFS", "EQU",
+		"ORG", "SECTION", "END", "DB", "DEFB", "DW", "DEFW", "DS", "DEFS", "EQU",
 		"ALIGN", "INCLUDE", "MACRO", "ENDM",
+		"STRUCT", "ENDS", "INST", // Data structures
 		"TARGET", "MODEL", // Platform-specific directives
+		"PUBLIC", "EXTERN", // Object modules
+		"IF", "ELIF", "ELSE", "ENDIF", "IFDEF", "IFNDEF", // Conditional assembly
+		"REPT", "ENDR", "LOCAL", // Repetition and macro-local labels
 	}
 	for _, d := range directives {
 		if upper == d {
@@ -310,7 +326,23 @@ func parseNumber(s string) (uint16, error) {
 
 // isIndirect checks if operand is indirect addressing (HL), (nn), etc
 func isIndirect(s string) bool {
-	return strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")")
+	if !strings.HasPrefix(s, "(") || !strings.HasSuffix(s, ")") {
+		return false
+	}
+	// (a + b) * (c + d) is an expression: the first ( must close last
+	depth := 0
+	for i, ch := range s {
+		switch ch {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i == len(s)-1
+			}
+		}
+	}
+	return false
 }
 
 // stripIndirect removes parentheses from indirect operand
@@ -1,6 +1,7 @@
 package z80asm
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -24,10 +25,23 @@ func (a *Assembler) processInstruction(line *Line) error {
 		a.currentAddr += uint16(len(encoded))
 		return nil
 	}
+	if errors.Is(err, errZ80N) || errors.Is(err, errSM83) || errors.Is(err, errNotSM83) {
+		return err
+	}
 	
 	// Fall back to old instruction processing for now
 	// This will be removed once table is complete
-	return a.processInstructionOld(line)
+	if !a.SM83 {
+		return a.processInstructionOld(line)
+	}
+	before := len(a.output)
+	if err := a.processInstructionOld(line); err != nil {
+		return err
+	}
+	if a.pass == 2 && !sm83Encodes(a.output[before:]) {
+		return notSM83Error(line)
+	}
+	return nil
 }
 
 // encodeInstructionTable uses the table-driven approach to encode instructions
@@ -38,15 +52,34 @@ func (a *Assembler) encodeInstructionTable(line *Line) ([]byte, error) {
 		return nil, fmt.Errorf("unknown instruction: %s", line.Mnemonic)
 	}
 	
+	if a.SM83 {
+		patterns = sm83First(patterns)
+	}
+	
 	// Try to match each pattern
 	for _, pattern := range patterns {
+		if (pattern.Z80N || pattern.SM83) && takesRegisterAsImmediate(pattern, line) {
+			continue
+		}
 		if match, values := a.matchPattern(pattern, line); match {
+			if pattern.Z80N && !a.Z80N {
+				return nil, z80nError(line)
+			}
+			if pattern.SM83 && !a.SM83 {
+				return nil, sm83Error(line)
+			}
 			// Generate encoding
+			encoding := pattern.Encoding
 			if pattern.EncodingFunc != nil {
-				return pattern.EncodingFunc(a, &pattern, values)
+				var err error
+				if encoding, err = pattern.EncodingFunc(a, &pattern, values); err != nil {
+					return nil, err
+				}
 			}
-			// Simple encoding (no operands or fixed encoding)
-			return pattern.Encoding, nil
+			if a.SM83 && !pattern.SM83 && !sm83Encodes(encoding) {
+				return nil, notSM83Error(line)
+			}
+			return encoding, nil
 		}
 	}
 	
@@ -111,6 +144,16 @@ func (a *Assembler) parseOperandAs(operand string, pattern OperandPattern) (inte
 		return reg, true
 		
 	case OpTypeImm8, OpTypeImm16:
+		// (nn) is a memory operand, though it is also a valid expression
+		if isIndirect(operand) {
+			return nil, false
+		}
+		// A register is not a forward reference, though pass 1 would
+		// take it for one and size LD SP, IX as LD SP, nn
+		if parseReg8(operand) != "" || parseReg16(operand) != "" {
+			return nil, false
+		}
+		
 		// Try to resolve value (number or symbol)
 		value, err := a.resolveValue(operand)
 		if err != nil {
@@ -126,7 +169,7 @@ func (a *Assembler) parseOperandAs(operand string, pattern OperandPattern) (inte
 		
 	case OpTypeIndReg:
 		// Check for indirect register addressing
-		if !strings.HasPrefix(operand, "(") || !strings.HasSuffix(operand, ")") {
+		if !isIndirect(operand) {
 			return nil, false
 		}
 		
@@ -134,7 +177,7 @@ func (a *Assembler) parseOperandAs(operand string, pattern OperandPattern) (inte
 		
 		// Check constraint if specified
 		if pattern.Constraint != "" {
-			if operand != pattern.Constraint {
+			if !strings.EqualFold(operand, pattern.Constraint) {
 				return nil, false
 			}
 			return operand, true
@@ -150,12 +193,21 @@ func (a *Assembler) parseOperandAs(operand string, pattern OperandPattern) (inte
 		
 	case OpTypeIndImm:
 		// Check for indirect immediate (memory address)
-		if !strings.HasPrefix(operand, "(") || !strings.HasSuffix(operand, ")") {
+		if !isIndirect(operand) {
 			return nil, false
 		}
 		
 		inner := operand[1:len(operand)-1]
 		
+		// (IX+d) and (IY+d) are indexed, not absolute addresses, and (C)
+		// or (HL) not forward references
+		if isIndexedOperand(operand, "IX") || isIndexedOperand(operand, "IY") {
+			return nil, false
+		}
+		if parseReg8(inner) != "" || parseReg16(inner) != "" {
+			return nil, false
+		}
+		
 		// Resolve the address
 		addr, err := a.resolveValue(inner)
 		if err != nil {
@@ -200,6 +252,9 @@ func (a *Assembler) parseOperandAs(operand string, pattern OperandPattern) (inte
 		
 		return nil, false
 		
+	case OpTypeSPOffset:
+		return a.parseSPOffset(operand)
+		
 	case OpTypeBit:
 		// Parse bit number (0-7)
 		bit, err := strconv.Atoi(operand)
@@ -0,0 +1,87 @@
+package z80asm
+
+import (
+	"fmt"
+	"slices"
+)
+
+// expandRepeats replaces each REPT n ... ENDR block with n copies of its
+// lines. Blocks nest, and the count may use the EQU constants above it.
+// Macro bodies are left alone: a REPT there may count with a parameter, so
+// it is expanded with the macro's invocation.
+func (a *Assembler) expandRepeats(lines []*Line) ([]*Line, error) {
+	var constants []string // EQUs added to the symbol table for the counts
+	defer func() {
+		for _, name := range constants {
+			delete(a.symbols, name)
+		}
+	}()
+
+	var result []*Line
+	inMacro := false
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case inMacro || line.Directive != "REPT" && line.Directive != "ENDR":
+			switch line.Directive {
+			case "MACRO":
+				inMacro = true
+			case "ENDM":
+				inMacro = false
+			case "EQU":
+				if name, ok := a.conditionalConstant(line); ok {
+					constants = append(constants, name)
+				}
+			}
+			result = append(result, line)
+			continue
+		case line.Directive == "ENDR":
+			return nil, fmt.Errorf("line %d: ENDR without REPT", line.Number)
+		}
+
+		end, err := matchingENDR(lines, i)
+		if err != nil {
+			return nil, err
+		}
+		if len(line.Operands) != 1 {
+			return nil, fmt.Errorf("line %d: REPT requires a count", line.Number)
+		}
+		count, err := a.EvaluateExpression(line.Operands[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: REPT count must be a constant: %w", line.Number, err)
+		}
+		body, err := a.expandRepeats(lines[i+1 : end])
+		if err != nil {
+			return nil, err
+		}
+		if line.Label != "" {
+			result = append(result, &Line{Number: line.Number, Label: line.Label})
+		}
+		for n := 0; n < int(count); n++ {
+			for _, bodyLine := range body {
+				copied := *bodyLine
+				copied.Operands = slices.Clone(bodyLine.Operands)
+				result = append(result, &copied)
+			}
+		}
+		i = end
+	}
+	return result, nil
+}
+
+// matchingENDR returns the index of the ENDR closing the REPT at lines[start]
+func matchingENDR(lines []*Line, start int) (int, error) {
+	depth := 0
+	for i := start; i < len(lines); i++ {
+		switch lines[i].Directive {
+		case "REPT":
+			depth++
+		case "ENDR":
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("line %d: REPT without ENDR", lines[start].Number)
+}
@@ -0,0 +1,379 @@
+package z80asm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Sections replace a single manual ORG. Code and data go in named
+// sections, which can be opened and reopened anywhere in the source:
+//
+//	    SECTION CODE
+//	main:
+//	    LD HL, message
+//	    ...
+//	    SECTION DATA
+//	message:
+//	    DB "HELLO", 0
+//	    SECTION BSS
+//	buffer:
+//	    DS 256
+//
+// Each target gives CODE, DATA and BSS a base address or places them one
+// after the other, and a limit; `SECTION name, base` and SectionSpec
+// settings (mza --section) override them. Sections without a base follow
+// the section placed before them: the target's sections in their order,
+// then the others in the order the source opens them. A section that
+// ends past its limit is an error ("CODE section exceeds $BFFF"), as are
+// sections that overlap.
+//
+// BSS only reserves space: it may hold labels and DS, and adds nothing to
+// the output. The output is the other sections laid out by address, with
+// any gap between them zero filled. Lines before the first SECTION belong
+// to CODE, and ORG cannot be mixed with sections.
+
+// SectionSpec places a section
+type SectionSpec struct {
+	Name  string
+	Base  uint16 // Start address, unless Auto
+	Auto  bool   // Placed right after the section before it
+	Limit uint16 // Last address the section may use; 0 keeps the target's
+}
+
+// SectionInfo is where a section ended up
+type SectionInfo struct {
+	Name  string
+	Start uint16
+	Size  int
+	BSS   bool // Reserved space only, not in the output
+}
+
+// defaultSections places sections on targets that do not list their own:
+// code at the origin, then data, then uninitialized data
+var defaultSections = []SectionSpec{
+	{Name: "CODE", Auto: true, Limit: 0xFFFF},
+	{Name: "DATA", Auto: true, Limit: 0xFFFF},
+	{Name: "BSS", Auto: true, Limit: 0xFFFF},
+}
+
+// maxLayoutPasses bounds the pass 1 runs that settle section addresses.
+// The second run normally settles them; ALIGN may take one more.
+const maxLayoutPasses = 4
+
+// section is a section of the source being assembled
+type section struct {
+	name       string
+	base       uint16 // Start address, once placed
+	limit      uint16
+	inlineBase *uint16 // Set by SECTION name, base
+	addr       uint16  // Location counter when the section is not current
+	entered    bool    // Opened in this pass
+	size       int     // Bytes, measured in pass 1
+	data       []byte  // Output, in pass 2
+}
+
+// isBSS reports whether the section only reserves space
+func (s *section) isBSS() bool {
+	return strings.EqualFold(s.name, "BSS")
+}
+
+// ConfigureSection overrides where a section goes, whatever the target
+// and the source say
+func (a *Assembler) ConfigureSection(spec SectionSpec) {
+	if a.sectionConfig == nil {
+		a.sectionConfig = make(map[string]SectionSpec)
+	}
+	a.sectionConfig[strings.ToUpper(spec.Name)] = spec
+}
+
+// ParseSectionSpec parses a section setting: NAME=BASE or NAME=BASE:LIMIT,
+// with BASE "auto" to place the section after the one before it
+func ParseSectionSpec(text string) (SectionSpec, error) {
+	name, place, ok := strings.Cut(text, "=")
+	if !ok || name == "" || place == "" {
+		return SectionSpec{}, fmt.Errorf("invalid section %q (expected NAME=BASE or NAME=BASE:LIMIT)", text)
+	}
+	spec := SectionSpec{Name: strings.ToUpper(name)}
+	base, limit, hasLimit := strings.Cut(place, ":")
+	if strings.EqualFold(base, "auto") {
+		spec.Auto = true
+	} else {
+		value, err := parseNumber(base)
+		if err != nil {
+			return SectionSpec{}, fmt.Errorf("invalid base address in section %q: %v", text, err)
+		}
+		spec.Base = value
+	}
+	if hasLimit {
+		value, err := parseNumber(limit)
+		if err != nil {
+			return SectionSpec{}, fmt.Errorf("invalid limit in section %q: %v", text, err)
+		}
+		spec.Limit = value
+	}
+	return spec, nil
+}
+
+// usesSections reports whether the source opens any section
+func usesSections(lines []*Line) bool {
+	for _, line := range lines {
+		if line.Directive == "SECTION" {
+			return true
+		}
+	}
+	return false
+}
+
+// targetSections returns the section settings of the target
+func (a *Assembler) targetSections() []SectionSpec {
+	if a.target != nil && len(a.target.MemoryLayout.Sections) > 0 {
+		return a.target.MemoryLayout.Sections
+	}
+	return defaultSections
+}
+
+// sectionSpec returns where the section goes: the configured setting, or
+// the base the source gives it, or the target's setting
+func (a *Assembler) sectionSpec(s *section) SectionSpec {
+	spec := SectionSpec{Name: s.name, Auto: true, Limit: 0xFFFF}
+	for _, t := range a.targetSections() {
+		if strings.EqualFold(t.Name, s.name) {
+			spec = t
+		}
+	}
+	if s.inlineBase != nil {
+		spec.Base, spec.Auto = *s.inlineBase, false
+	}
+	if c, ok := a.sectionConfig[strings.ToUpper(s.name)]; ok {
+		spec.Base, spec.Auto = c.Base, c.Auto
+		if c.Limit != 0 {
+			spec.Limit = c.Limit
+		}
+	}
+	if spec.Limit == 0 {
+		spec.Limit = 0xFFFF
+	}
+	return spec
+}
+
+// handleSECTION opens a section: SECTION name [, base]
+func (a *Assembler) handleSECTION(line *Line) error {
+	if len(line.Operands) < 1 || len(line.Operands) > 2 {
+		return fmt.Errorf("SECTION requires a name and an optional base address")
+	}
+	if a.relocatable {
+		return fmt.Errorf("SECTION is not allowed in an object module; the linker places it")
+	}
+	var base *uint16
+	if len(line.Operands) == 2 {
+		value, err := a.resolveValue(line.Operands[1])
+		if err != nil {
+			return fmt.Errorf("invalid SECTION base: %w", err)
+		}
+		base = &value
+	}
+	return a.enterSection(line.Operands[0], base)
+}
+
+// enterSection makes name the current section, creating it when the
+// source first opens it
+func (a *Assembler) enterSection(name string, base *uint16) error {
+	var s *section
+	for _, existing := range a.sections {
+		if strings.EqualFold(existing.name, name) {
+			s = existing
+		}
+	}
+	if s == nil {
+		s = &section{name: name}
+		a.sections = append(a.sections, s)
+		spec := a.sectionSpec(s)
+		s.base, s.limit = spec.Base, spec.Limit // Provisional when Auto
+	}
+	if base != nil {
+		if s.inlineBase != nil && *s.inlineBase != *base {
+			return fmt.Errorf("SECTION %s given base $%04X, but earlier $%04X", name, *base, *s.inlineBase)
+		}
+		if s.inlineBase == nil && a.pass == 1 {
+			s.inlineBase = base
+			if spec := a.sectionSpec(s); !spec.Auto && !s.entered {
+				s.base = spec.Base
+			}
+		}
+	}
+
+	if a.section != nil {
+		a.section.addr = a.currentAddr
+		if a.pass == 2 {
+			a.section.data = a.output
+		}
+	}
+	if !s.entered {
+		s.entered = true
+		s.addr = s.base
+	}
+	a.section = s
+	a.currentAddr = s.addr
+	if a.pass == 2 {
+		a.output = s.data
+	}
+	return nil
+}
+
+// beginSectionPass opens CODE for the lines before the first SECTION
+func (a *Assembler) beginSectionPass() {
+	if !a.usesSections {
+		return
+	}
+	a.section = nil
+	for _, s := range a.sections {
+		s.entered = false
+		s.data = nil
+	}
+	a.enterSection("CODE", nil)
+}
+
+// endSectionPass closes the current section, measuring the sections in
+// pass 1
+func (a *Assembler) endSectionPass() {
+	if a.section == nil {
+		return
+	}
+	a.section.addr = a.currentAddr
+	if a.pass == 2 {
+		a.section.data = a.output
+		return
+	}
+	for _, s := range a.sections {
+		s.size = int(s.addr) - int(s.base)
+		if s.size < 0 {
+			s.size += 0x10000
+		}
+	}
+}
+
+// checkBSS drops what a line put in a BSS section: DS and ALIGN only
+// reserve space there, and anything else is an error
+func (a *Assembler) checkBSS(line *Line, outputBefore, instructionsBefore int) error {
+	if a.section == nil || !a.section.isBSS() || a.pass != 2 || line.Directive == "SECTION" || len(a.output) == outputBefore {
+		return nil
+	}
+	a.output = a.output[:outputBefore]
+	switch line.Directive {
+	case "DS", "DEFS", "ALIGN":
+		for _, inst := range a.instructions[instructionsBefore:] {
+			inst.Bytes = nil
+		}
+		return nil
+	}
+	a.instructions = a.instructions[:instructionsBefore]
+	what := line.Mnemonic
+	if line.Directive != "" {
+		what = line.Directive
+	}
+	return fmt.Errorf("%s section can only reserve space with DS, not hold %s", a.section.name, what)
+}
+
+// orderedSections returns the sections in placement order: the target's
+// in its order, then the rest in the order the source opened them
+func (a *Assembler) orderedSections() []*section {
+	rank := make(map[string]int)
+	for i, t := range a.targetSections() {
+		rank[strings.ToUpper(t.Name)] = i
+	}
+	ordered := append([]*section(nil), a.sections...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		ri, iok := rank[strings.ToUpper(ordered[i].name)]
+		rj, jok := rank[strings.ToUpper(ordered[j].name)]
+		if iok != jok {
+			return iok
+		}
+		return iok && ri < rj
+	})
+	return ordered
+}
+
+// placeSections gives each section its address from the sizes measured
+// in pass 1, and reports whether any moved
+func (a *Assembler) placeSections() bool {
+	moved := false
+	next := int(a.origin)
+	for _, s := range a.orderedSections() {
+		spec := a.sectionSpec(s)
+		base := spec.Base
+		if spec.Auto {
+			base = uint16(next)
+		}
+		if base != s.base {
+			moved = true
+		}
+		s.base, s.limit = base, spec.Limit
+		next = int(base) + s.size
+	}
+	return moved
+}
+
+// checkSections reports a section that runs past its limit or into
+// another section
+func (a *Assembler) checkSections() error {
+	var placed []*section
+	for _, s := range a.orderedSections() {
+		if s.size == 0 {
+			continue
+		}
+		end := int(s.base) + s.size - 1
+		if end > int(s.limit) {
+			return fmt.Errorf("%s section exceeds $%04X (ends at $%04X)", s.name, s.limit, end)
+		}
+		for _, other := range placed {
+			otherEnd := int(other.base) + other.size - 1
+			if int(s.base) <= otherEnd && int(other.base) <= end {
+				return fmt.Errorf("%s section ($%04X-$%04X) overlaps %s section ($%04X-$%04X)",
+					s.name, s.base, end, other.name, other.base, otherEnd)
+			}
+		}
+		placed = append(placed, s)
+	}
+	return nil
+}
+
+// linkSections lays the output of the sections out by address and
+// returns where each went
+func (a *Assembler) linkSections() []SectionInfo {
+	var infos []SectionInfo
+	var stored []*section
+	for _, s := range a.orderedSections() {
+		infos = append(infos, SectionInfo{Name: s.name, Start: s.base, Size: s.size, BSS: s.isBSS()})
+		if !s.isBSS() && len(s.data) > 0 {
+			stored = append(stored, s)
+		}
+	}
+	sort.SliceStable(stored, func(i, j int) bool { return stored[i].base < stored[j].base })
+
+	a.output = nil
+	if len(stored) == 0 {
+		return infos
+	}
+	a.origin = stored[0].base
+	for _, s := range stored {
+		offset := int(s.base) - int(a.origin)
+		for len(a.output) < offset {
+			a.output = append(a.output, 0)
+		}
+		a.output = append(a.output, s.data...)
+	}
+	return infos
+}
+
+// restartPass1 forgets what pass 1 defined, so it can run again with the
+// sections placed
+func (a *Assembler) restartPass1() {
+	a.symbols = a.initialSymbols()
+	a.structs = make(map[string]*StructDef)
+	a.structDefinition = nil
+	a.externNames = nil
+	a.publicNames = nil
+	a.errors = nil
+	a.warnings = nil
+}
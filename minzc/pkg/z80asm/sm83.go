@@ -0,0 +1,180 @@
+package z80asm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The SM83 is the CPU of the Game Boy: an 8080 with the Z80's CB-prefixed
+// bit instructions and relative jumps, but none of its DD, ED or FD
+// prefixed ones, shadow registers, IN/OUT, or parity and sign conditions.
+// It reuses their opcodes for:
+//
+//	LD (HL+),A  LD A,(HL+)  LD (HL-),A  LD A,(HL-)   (also LDI and LDD)
+//	LD (nn),A   LD A,(nn)   LD (nn),SP
+//	LDH (n),A   LDH A,(n)   LD (C),A    LD A,(C)     ($FF00 page)
+//	ADD SP,e    LD HL,SP+e  (also LDHL SP,e)
+//	SWAP r      STOP        RETI
+//
+// With the SM83 option (set by the gameboy target) these take precedence,
+// and Z80 instructions the SM83 lacks are errors. Without it the SM83
+// ones are errors.
+
+// errSM83 is reported for an SM83 instruction when they are not enabled
+var errSM83 = errors.New("SM83 instruction needs the gameboy target")
+
+// errNotSM83 is reported for a Z80 instruction the SM83 does not have
+var errNotSM83 = errors.New("not an SM83 instruction (gameboy target)")
+
+// sm83Instructions are the SM83 instruction patterns. With the SM83 option
+// they are tried before the Z80 ones.
+var sm83Instructions = []InstructionPattern{
+	// Loads through HL that step it
+	{Mnemonic: "LD", Operands: []OperandPattern{{OpTypeIndReg, "(HL+)"}, {OpTypeReg8, "A"}}, Encoding: []byte{0x22}, SM83: true},
+	{Mnemonic: "LD", Operands: []OperandPattern{{OpTypeIndReg, "(HLI)"}, {OpTypeReg8, "A"}}, Encoding: []byte{0x22}, SM83: true},
+	{Mnemonic: "LDI", Operands: []OperandPattern{{OpTypeIndReg, "(HL)"}, {OpTypeReg8, "A"}}, Encoding: []byte{0x22}, SM83: true},
+	{Mnemonic: "LD", Operands: []OperandPattern{{OpTypeReg8, "A"}, {OpTypeIndReg, "(HL+)"}}, Encoding: []byte{0x2A}, SM83: true},
+	{Mnemonic: "LD", Operands: []OperandPattern{{OpTypeReg8, "A"}, {OpTypeIndReg, "(HLI)"}}, Encoding: []byte{0x2A}, SM83: true},
+	{Mnemonic: "LDI", Operands: []OperandPattern{{OpTypeReg8, "A"}, {OpTypeIndReg, "(HL)"}}, Encoding: []byte{0x2A}, SM83: true},
+	{Mnemonic: "LD", Operands: []OperandPattern{{OpTypeIndReg, "(HL-)"}, {OpTypeReg8, "A"}}, Encoding: []byte{0x32}, SM83: true},
+	{Mnemonic: "LD", Operands: []OperandPattern{{OpTypeIndReg, "(HLD)"}, {OpTypeReg8, "A"}}, Encoding: []byte{0x32}, SM83: true},
+	{Mnemonic: "LDD", Operands: []OperandPattern{{OpTypeIndReg, "(HL)"}, {OpTypeReg8, "A"}}, Encoding: []byte{0x32}, SM83: true},
+	{Mnemonic: "LD", Operands: []OperandPattern{{OpTypeReg8, "A"}, {OpTypeIndReg, "(HL-)"}}, Encoding: []byte{0x3A}, SM83: true},
+	{Mnemonic: "LD", Operands: []OperandPattern{{OpTypeReg8, "A"}, {OpTypeIndReg, "(HLD)"}}, Encoding: []byte{0x3A}, SM83: true},
+	{Mnemonic: "LDD", Operands: []OperandPattern{{OpTypeReg8, "A"}, {OpTypeIndReg, "(HL)"}}, Encoding: []byte{0x3A}, SM83: true},
+
+	// The $FF00 page, where the hardware registers and HRAM are
+	{Mnemonic: "LD", Operands: []OperandPattern{{OpTypeIndReg, "(C)"}, {OpTypeReg8, "A"}}, Encoding: []byte{0xE2}, SM83: true},
+	{Mnemonic: "LDH", Operands: []OperandPattern{{OpTypeIndReg, "(C)"}, {OpTypeReg8, "A"}}, Encoding: []byte{0xE2}, SM83: true},
+	{Mnemonic: "LD", Operands: []OperandPattern{{OpTypeReg8, "A"}, {OpTypeIndReg, "(C)"}}, Encoding: []byte{0xF2}, SM83: true},
+	{Mnemonic: "LDH", Operands: []OperandPattern{{OpTypeReg8, "A"}, {OpTypeIndReg, "(C)"}}, Encoding: []byte{0xF2}, SM83: true},
+	{Mnemonic: "LDH", Operands: []OperandPattern{{OpTypeIndImm, ""}, {OpTypeReg8, "A"}}, EncodingFunc: encodeLDH, Encoding: []byte{0xE0}, SM83: true},
+	{Mnemonic: "LDH", Operands: []OperandPattern{{OpTypeReg8, "A"}, {OpTypeIndImm, ""}}, EncodingFunc: encodeLDH, Encoding: []byte{0xF0}, SM83: true},
+
+	// Absolute addresses, which the Z80 encodes as 32, 3A and ED 73
+	{Mnemonic: "LD", Operands: []OperandPattern{{OpTypeIndImm, ""}, {OpTypeReg8, "A"}}, EncodingFunc: encodeLD16Imm, Encoding: []byte{0xEA}, SM83: true},
+	{Mnemonic: "LD", Operands: []OperandPattern{{OpTypeReg8, "A"}, {OpTypeIndImm, ""}}, EncodingFunc: encodeLD16Imm, Encoding: []byte{0xFA}, SM83: true},
+	{Mnemonic: "LD", Operands: []OperandPattern{{OpTypeIndImm, ""}, {OpTypeReg16, "SP"}}, EncodingFunc: encodeLD16Imm, Encoding: []byte{0x08}, SM83: true},
+
+	// Signed offsets from SP
+	{Mnemonic: "ADD", Operands: []OperandPattern{{OpTypeReg16, "SP"}, {OpTypeImm16, ""}}, EncodingFunc: encodeSPOffset, Encoding: []byte{0xE8}, SM83: true},
+	{Mnemonic: "LD", Operands: []OperandPattern{{OpTypeReg16, "HL"}, {OpTypeSPOffset, ""}}, EncodingFunc: encodeSPOffset, Encoding: []byte{0xF8}, SM83: true},
+	{Mnemonic: "LDHL", Operands: []OperandPattern{{OpTypeReg16, "SP"}, {OpTypeImm16, ""}}, EncodingFunc: encodeSPOffset, Encoding: []byte{0xF8}, SM83: true},
+
+	// SWAP exchanges the nibbles, in place of the Z80's undocumented SLL
+	{Mnemonic: "SWAP", Operands: []OperandPattern{{OpTypeReg8, "B"}}, Encoding: []byte{0xCB, 0x30}, SM83: true},
+	{Mnemonic: "SWAP", Operands: []OperandPattern{{OpTypeReg8, "C"}}, Encoding: []byte{0xCB, 0x31}, SM83: true},
+	{Mnemonic: "SWAP", Operands: []OperandPattern{{OpTypeReg8, "D"}}, Encoding: []byte{0xCB, 0x32}, SM83: true},
+	{Mnemonic: "SWAP", Operands: []OperandPattern{{OpTypeReg8, "E"}}, Encoding: []byte{0xCB, 0x33}, SM83: true},
+	{Mnemonic: "SWAP", Operands: []OperandPattern{{OpTypeReg8, "H"}}, Encoding: []byte{0xCB, 0x34}, SM83: true},
+	{Mnemonic: "SWAP", Operands: []OperandPattern{{OpTypeReg8, "L"}}, Encoding: []byte{0xCB, 0x35}, SM83: true},
+	{Mnemonic: "SWAP", Operands: []OperandPattern{{OpTypeIndReg, "(HL)"}}, Encoding: []byte{0xCB, 0x36}, SM83: true},
+	{Mnemonic: "SWAP", Operands: []OperandPattern{{OpTypeReg8, "A"}}, Encoding: []byte{0xCB, 0x37}, SM83: true},
+
+	{Mnemonic: "STOP", Encoding: []byte{0x10, 0x00}, SM83: true},
+	{Mnemonic: "RETI", Encoding: []byte{0xD9}, SM83: true},
+}
+
+// sm83Missing are the first bytes of Z80 instructions the SM83 does not
+// have, or has as a different instruction
+var sm83Missing = map[byte]bool{
+	0x08: true, // EX AF,AF'
+	0x10: true, // DJNZ
+	0x22: true, // LD (nn),HL
+	0x2A: true, // LD HL,(nn)
+	0x32: true, // LD (nn),A
+	0x3A: true, // LD A,(nn)
+	0xD3: true, // OUT (n),A
+	0xD9: true, // EXX
+	0xDB: true, // IN A,(n)
+	0xDD: true, // IX
+	0xE3: true, // EX (SP),HL
+	0xEB: true, // EX DE,HL
+	0xED: true, // Extended instructions
+	0xFD: true, // IY
+	// Parity and sign conditions
+	0xE0: true, 0xE2: true, 0xE4: true, 0xE8: true, 0xEA: true, 0xEC: true,
+	0xF0: true, 0xF2: true, 0xF4: true, 0xF8: true, 0xFA: true, 0xFC: true,
+}
+
+// sm83Encodes reports whether the SM83 runs the Z80 instruction code
+func sm83Encodes(code []byte) bool {
+	if len(code) == 0 {
+		return true
+	}
+	if code[0] == 0xCB {
+		return len(code) < 2 || code[1]&0xF8 != 0x30 // SLL
+	}
+	return !sm83Missing[code[0]]
+}
+
+// sm83First orders the patterns of a mnemonic with the SM83 ones first
+func sm83First(patterns []InstructionPattern) []InstructionPattern {
+	ordered := make([]InstructionPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		if pattern.SM83 {
+			ordered = append(ordered, pattern)
+		}
+	}
+	for _, pattern := range patterns {
+		if !pattern.SM83 {
+			ordered = append(ordered, pattern)
+		}
+	}
+	return ordered
+}
+
+// encodeLDH encodes LDH (n),A and LDH A,(n), whose address is in the $FF00
+// page: $FF00-$FFFF, or its low byte
+func encodeLDH(a *Assembler, pattern *InstructionPattern, values []interface{}) ([]byte, error) {
+	for _, v := range values {
+		if addr, ok := v.(uint16); ok {
+			if addr > 0xFF && addr < 0xFF00 {
+				return nil, fmt.Errorf("LDH address $%04X is not in $FF00-$FFFF", addr)
+			}
+			return append(append([]byte{}, pattern.Encoding...), byte(addr)), nil
+		}
+	}
+	return nil, fmt.Errorf("no address found")
+}
+
+// encodeSPOffset encodes ADD SP,e and LD HL,SP+e, whose offset is a signed
+// byte
+func encodeSPOffset(a *Assembler, pattern *InstructionPattern, values []interface{}) ([]byte, error) {
+	value, ok := values[1].(uint16)
+	if !ok {
+		return nil, fmt.Errorf("no offset found")
+	}
+	offset := int16(value)
+	if offset < -128 || offset > 127 {
+		return nil, fmt.Errorf("SP offset %d out of range (-128 to 127)", offset)
+	}
+	return append(append([]byte{}, pattern.Encoding...), byte(offset)), nil
+}
+
+// parseSPOffset parses the SP+e or SP-e operand of LD HL,SP+e
+func (a *Assembler) parseSPOffset(operand string) (uint16, bool) {
+	if len(operand) < 3 || !strings.EqualFold(operand[:2], "SP") {
+		return 0, false
+	}
+	rest := strings.TrimSpace(operand[2:])
+	if rest[0] != '+' && rest[0] != '-' {
+		return 0, false
+	}
+	value, err := a.resolveValue("0" + rest)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// sm83Error reports an SM83 instruction assembled without the SM83 option
+func sm83Error(line *Line) error {
+	return fmt.Errorf("%s: %w", strings.TrimSpace(line.Mnemonic+" "+strings.Join(line.Operands, ", ")), errSM83)
+}
+
+// notSM83Error reports a Z80 instruction the SM83 does not have
+func notSM83Error(line *Line) error {
+	return fmt.Errorf("%s: %w", strings.TrimSpace(line.Mnemonic+" "+strings.Join(line.Operands, ", ")), errNotSM83)
+}
@@ -0,0 +1,142 @@
+package z80asm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SelfModifyingWrite is a store whose constant target address lands on
+// assembled instruction bytes, i.e. a write into code
+type SelfModifyingWrite struct {
+	Line     int
+	Address  uint16 // Address of the storing instruction
+	Target   uint16 // First byte written
+	Function string // Label of the code being written into
+}
+
+func (w SelfModifyingWrite) String() string {
+	return fmt.Sprintf("line %d: store to $%04X writes into code of '%s' (not an SMC function)",
+		w.Line, w.Target, w.Function)
+}
+
+// codeLabel is a label placed in the code region
+type codeLabel struct {
+	name string
+	addr uint16
+}
+
+// findSelfModifyingWrites scans the assembled program for LD (nn),r stores
+// whose address resolves to instruction bytes. This is a heuristic: only
+// constant addresses are checked, and stores into a function that defines
+// SMC anchors (TRUE SMC x$immOP, SMC parameter x.op or smc_N labels) are
+// deliberate and not reported.
+func (a *Assembler) findSelfModifyingWrites() []SelfModifyingWrite {
+	var code [65536]bool
+	for _, inst := range a.instructions {
+		if inst.Line.Mnemonic == "" || inst.Line.Directive != "" {
+			continue // Data, not code
+		}
+		for i := range inst.Bytes {
+			code[inst.Address+uint16(i)] = true
+		}
+	}
+
+	functions, smcFunctions := a.codeFunctions()
+
+	var writes []SelfModifyingWrite
+	for _, inst := range a.instructions {
+		target, size, ok := a.constantStore(inst.Line)
+		if !ok {
+			continue
+		}
+		hit := false
+		for i := uint16(0); i < size; i++ {
+			hit = hit || code[target+i]
+		}
+		if !hit {
+			continue
+		}
+		function := enclosingLabel(functions, target)
+		if smcFunctions[function] {
+			continue
+		}
+		writes = append(writes, SelfModifyingWrite{
+			Line:     inst.Line.Number,
+			Address:  inst.Address,
+			Target:   target,
+			Function: function,
+		})
+	}
+	return writes
+}
+
+// constantStore returns the target address and width of an LD (nn),r store
+func (a *Assembler) constantStore(line *Line) (uint16, uint16, bool) {
+	if strings.ToUpper(line.Mnemonic) != "LD" || len(line.Operands) != 2 {
+		return 0, 0, false
+	}
+	dest := strings.TrimSpace(line.Operands[0])
+	if !isIndirect(dest) || isRegisterIndirect(dest) {
+		return 0, 0, false
+	}
+	target, err := a.resolveValue(strings.TrimSpace(dest[1 : len(dest)-1]))
+	if err != nil {
+		return 0, 0, false
+	}
+
+	size := uint16(1)
+	switch strings.ToUpper(strings.TrimSpace(line.Operands[1])) {
+	case "HL", "DE", "BC", "SP", "IX", "IY":
+		size = 2
+	}
+	return target, size, true
+}
+
+// codeFunctions returns the function labels sorted by address, and the set
+// of functions that contain an SMC anchor
+func (a *Assembler) codeFunctions() ([]codeLabel, map[string]bool) {
+	var functions, anchors []codeLabel
+	for _, line := range a.lines {
+		if line.Label == "" || line.Directive == "EQU" {
+			continue
+		}
+		name := a.symbolKey(line.Label)
+		sym, ok := a.symbols[name]
+		if !ok || !sym.Defined {
+			continue
+		}
+		label := codeLabel{name: name, addr: sym.Value}
+		switch {
+		case isSMCAnchor(name):
+			anchors = append(anchors, label)
+		case !strings.Contains(name, "."):
+			functions = append(functions, label)
+		}
+	}
+	sort.SliceStable(functions, func(i, j int) bool { return functions[i].addr < functions[j].addr })
+
+	smcFunctions := make(map[string]bool)
+	for _, anchor := range anchors {
+		smcFunctions[enclosingLabel(functions, anchor.addr)] = true
+	}
+	return functions, smcFunctions
+}
+
+// isSMCAnchor reports whether a label marks a patchable immediate
+func isSMCAnchor(name string) bool {
+	upper := strings.ToUpper(name)
+	return strings.Contains(upper, "$IMM") || strings.HasSuffix(upper, ".OP") || strings.HasPrefix(upper, "SMC_")
+}
+
+// enclosingLabel returns the last label at or below addr
+func enclosingLabel(labels []codeLabel, addr uint16) string {
+	name := ""
+	for _, label := range labels {
+		if label.addr > addr {
+			break
+		}
+		name = label.name
+	}
+	return name
+}
@@ -0,0 +1,198 @@
+package z80asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StructDef is a data structure declared with STRUCT ... ENDS.
+// Each field defines the symbol NAME.FIELD as its byte offset and the
+// struct name itself is defined as the total size (as in sjasmplus).
+type StructDef struct {
+	Name   string
+	Fields []StructField
+	Size   uint16
+}
+
+// StructField is a named field inside a STRUCT
+type StructField struct {
+	Name   string
+	Offset uint16
+	Size   uint16
+}
+
+// structDefinitionState tracks a STRUCT being defined
+type structDefinitionState struct {
+	def          *StructDef
+	pendingLabel string // Label on its own line, naming the next field
+}
+
+// handleSTRUCT begins a structure definition
+func (a *Assembler) handleSTRUCT(line *Line) error {
+	if a.structDefinition != nil {
+		return fmt.Errorf("nested STRUCT is not supported")
+	}
+	if len(line.Operands) != 1 || !isValidSymbol(line.Operands[0]) {
+		return fmt.Errorf("STRUCT requires a name")
+	}
+
+	a.structDefinition = &structDefinitionState{
+		def: &StructDef{Name: a.symbolKey(line.Operands[0])},
+	}
+	return nil
+}
+
+// handleENDS ends a structure definition and defines its symbols
+func (a *Assembler) handleENDS(line *Line) error {
+	state := a.structDefinition
+	if state == nil {
+		return fmt.Errorf("ENDS without matching STRUCT")
+	}
+	a.structDefinition = nil
+
+	def := state.def
+	if state.pendingLabel != "" {
+		// A trailing label marks the end of the struct (zero-size field)
+		def.Fields = append(def.Fields, StructField{Name: state.pendingLabel, Offset: def.Size})
+	}
+
+	// Symbols are defined once, in pass 1; pass 2 sees identical values
+	if a.pass != 1 {
+		return nil
+	}
+	if _, exists := a.structs[def.Name]; exists {
+		return fmt.Errorf("struct '%s' already defined", def.Name)
+	}
+	a.structs[def.Name] = def
+
+	if err := a.defineConstant(def.Name, def.Size); err != nil {
+		return err
+	}
+	for _, field := range def.Fields {
+		if err := a.defineConstant(def.Name+"."+field.Name, field.Offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processStructLine handles a line inside STRUCT ... ENDS.
+// Fields are written "name DB", "name DW", "name DS n" (or BYTE/WORD/BLOCK);
+// a directive without a name reserves anonymous padding.
+func (a *Assembler) processStructLine(line *Line) error {
+	state := a.structDefinition
+
+	if strings.ToUpper(line.Directive) == "ENDS" {
+		return a.handleENDS(line)
+	}
+
+	name := state.pendingLabel
+	var sizeSpec []string
+
+	switch {
+	case line.Label != "" && line.Directive == "" && line.Mnemonic == "":
+		// "name:" on its own line names the next field
+		state.pendingLabel = a.symbolKey(line.Label)
+		return nil
+	case line.Directive != "":
+		if line.Label != "" {
+			name = a.symbolKey(line.Label)
+		}
+		sizeSpec = append([]string{line.Directive}, line.Operands...)
+	case line.Mnemonic != "":
+		// "name DB" parses as mnemonic NAME with the size as its operand
+		name = a.symbolKey(line.Mnemonic)
+		if len(line.Operands) > 0 {
+			sizeSpec = strings.Fields(line.Operands[0])
+			sizeSpec = append(sizeSpec, line.Operands[1:]...)
+		}
+	default:
+		return nil
+	}
+	state.pendingLabel = ""
+
+	size, err := a.structFieldSize(sizeSpec)
+	if err != nil {
+		return err
+	}
+
+	def := state.def
+	if name != "" {
+		for _, field := range def.Fields {
+			if field.Name == name {
+				return fmt.Errorf("duplicate field '%s' in struct '%s'", name, def.Name)
+			}
+		}
+		def.Fields = append(def.Fields, StructField{Name: name, Offset: def.Size, Size: size})
+	}
+	def.Size += size
+	return nil
+}
+
+// structFieldSize returns the byte size of a field's type directive
+func (a *Assembler) structFieldSize(spec []string) (uint16, error) {
+	if len(spec) == 0 {
+		return 0, fmt.Errorf("struct field requires a size (DB, DW or DS n)")
+	}
+
+	switch strings.ToUpper(spec[0]) {
+	case "DB", "DEFB", "BYTE":
+		return 1, nil
+	case "DW", "DEFW", "WORD":
+		return 2, nil
+	case "DS", "DEFS", "BLOCK":
+		if len(spec) < 2 {
+			return 0, fmt.Errorf("%s requires a size", strings.ToUpper(spec[0]))
+		}
+		return a.resolveValue(spec[1])
+	default:
+		return 0, fmt.Errorf("unknown struct field type: %s", spec[0])
+	}
+}
+
+// handleINST reserves space for an instance of a struct
+func (a *Assembler) handleINST(line *Line) error {
+	if len(line.Operands) != 1 {
+		return fmt.Errorf("INST requires a struct name")
+	}
+
+	def, ok := a.structs[a.symbolKey(line.Operands[0])]
+	if !ok {
+		return fmt.Errorf("unknown struct: %s", line.Operands[0])
+	}
+
+	if a.pass == 2 {
+		bytes := make([]byte, def.Size)
+		inst := &AssembledInstruction{
+			Address: a.currentAddr,
+			Line:    line,
+			Bytes:   bytes,
+		}
+		a.instructions = append(a.instructions, inst)
+		a.output = append(a.output, bytes...)
+	}
+
+	a.currentAddr += def.Size
+	return nil
+}
+
+// symbolKey normalizes a symbol name for the symbol table
+func (a *Assembler) symbolKey(name string) string {
+	if a.CaseSensitive {
+		return name
+	}
+	return strings.ToUpper(name)
+}
+
+// defineConstant defines a symbol with a fixed value
+func (a *Assembler) defineConstant(name string, value uint16) error {
+	if sym, exists := a.symbols[name]; exists && sym.Defined {
+		return fmt.Errorf("symbol '%s' already defined", name)
+	}
+	a.symbols[name] = &Symbol{
+		Name:    name,
+		Value:   value,
+		Defined: true,
+	}
+	return nil
+}
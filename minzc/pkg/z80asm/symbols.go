@@ -0,0 +1,116 @@
+package z80asm
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Symbol files can be written for debuggers other than mze. Each format
+// has one symbol per line:
+//
+//	minz    main                 = $8000 (32768)      read by mze --dbg
+//	labels  02:8000 main                              sjasmplus LABELSLIST
+//	map     main                            = $8000 ; addr, public  (z88dk)
+//	sjasm   main: EQU 0x00008000                      sjasmplus --exp
+//	nocash  8000 main                                 NO$ZX, Fuse
+//
+// A labels line starts with the 128K page holding the address in the
+// standard memory map: 00 for the ROM and page 0, then 05 and 02.
+
+// SymbolFormat is a symbol file format
+type SymbolFormat struct {
+	Name        string
+	Extension   string // Files with this extension get the format by default
+	Description string
+	header      []string
+	line        func(name string, addr uint16) string
+}
+
+var symbolFormats = map[string]SymbolFormat{
+	"minz": {
+		Name: "minz", Extension: ".sym", Description: "MinZ symbol table, read by mze --dbg",
+		header: []string{"MinZ Z80 Assembler Symbol Table", "==============================", ""},
+		line: func(name string, addr uint16) string {
+			return fmt.Sprintf("%-20s = $%04X (%d)", name, addr, addr)
+		},
+	},
+	"labels": {
+		Name: "labels", Extension: ".labels", Description: "sjasmplus LABELSLIST (page:address name)",
+		line: func(name string, addr uint16) string {
+			return fmt.Sprintf("%02X:%04X %s", spectrumPage(addr), addr, name)
+		},
+	},
+	"map": {
+		Name: "map", Extension: ".map", Description: "z88dk map file",
+		line: func(name string, addr uint16) string {
+			return fmt.Sprintf("%-31s = $%04X ; addr, public", name, addr)
+		},
+	},
+	"sjasm": {
+		Name: "sjasm", Extension: ".exp", Description: "sjasmplus symbol export (name: EQU value)",
+		line: func(name string, addr uint16) string {
+			return fmt.Sprintf("%s: EQU 0x%08X", name, addr)
+		},
+	},
+	"nocash": {
+		Name: "nocash", Extension: ".nsym", Description: "NO$ZX and Fuse-style address/name list",
+		line: func(name string, addr uint16) string {
+			return fmt.Sprintf("%04X %s", addr, name)
+		},
+	},
+}
+
+// spectrumPage returns the 128K page at addr in the standard memory map
+func spectrumPage(addr uint16) int {
+	return [4]int{0, 5, 2, 0}[addr>>14]
+}
+
+// ParseSymbolFormat returns the symbol file format with the given name
+func ParseSymbolFormat(name string) (*SymbolFormat, error) {
+	format, ok := symbolFormats[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown symbol format: %s (formats: %s)", name, strings.Join(ListSymbolFormats(), ", "))
+	}
+	return &format, nil
+}
+
+// SymbolFormatForFile returns the format a symbol file is written in by
+// default, from its extension: the MinZ format unless another claims it
+func SymbolFormatForFile(filename string) *SymbolFormat {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, format := range symbolFormats {
+		if format.Extension == ext {
+			return &format
+		}
+	}
+	format := symbolFormats["minz"]
+	return &format
+}
+
+// ListSymbolFormats returns all symbol format names
+func ListSymbolFormats() []string {
+	var names []string
+	for name := range symbolFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GenerateSymbols writes the symbols of result in format, in alphabetical
+// order when sorted is set
+func GenerateSymbols(result *Result, format *SymbolFormat, sorted bool) []byte {
+	lines := append([]string(nil), format.header...)
+	if sorted {
+		for _, name := range result.SortedSymbolNames() {
+			lines = append(lines, format.line(name, result.Symbols[name]))
+		}
+	} else {
+		for name, addr := range result.Symbols {
+			lines = append(lines, format.line(name, addr))
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,103 @@
+package z80asm
+
+import (
+	"fmt"
+)
+
+// A .TAP file is a sequence of tape blocks, each stored as a little-endian
+// length followed by a flag byte, the data and an XOR checksum. A file on
+// tape is a header block (flag $00) describing it followed by a data block
+// (flag $FF) holding its contents.
+
+// TAP block flags and header file types
+const (
+	tapHeaderFlag = 0x00
+	tapDataFlag   = 0xFF
+
+	tapProgram = 0 // BASIC program
+	tapCode    = 3 // Bytes, loaded with LOAD ""CODE
+)
+
+// autorunLine is the line number of the BASIC loader, started automatically
+// after loading
+const autorunLine = 10
+
+// ZX Spectrum BASIC keyword tokens used by the loader
+const (
+	tokenVAL       = 0xB0
+	tokenCODE      = 0xAF
+	tokenUSR       = 0xC0
+	tokenLOAD      = 0xEF
+	tokenRANDOMIZE = 0xF9
+	tokenCLEAR     = 0xFD
+)
+
+// tapBlock encodes one tape block with its length and checksum
+func tapBlock(flag byte, data []byte) []byte {
+	checksum := flag
+	for _, b := range data {
+		checksum ^= b
+	}
+	length := len(data) + 2 // Flag and checksum
+	block := []byte{byte(length), byte(length >> 8), flag}
+	block = append(block, data...)
+	return append(block, checksum)
+}
+
+// tapHeader encodes the header block describing a file of the given type.
+// The name is padded or cut to 10 characters. param1 is the autostart line
+// of a program or the load address of code; param2 is the program length
+// or 32768 for code.
+func tapHeader(fileType byte, name string, length, param1, param2 uint16) []byte {
+	header := make([]byte, 17)
+	header[0] = fileType
+	copy(header[1:11], fmt.Sprintf("%-10.10s", name))
+	header[11], header[12] = byte(length), byte(length>>8)
+	header[13], header[14] = byte(param1), byte(param1>>8)
+	header[15], header[16] = byte(param2), byte(param2>>8)
+	return tapBlock(tapHeaderFlag, header)
+}
+
+// tapCodeFile encodes the binary as a CODE file loading at its origin
+func tapCodeFile(result *Result) []byte {
+	length := uint16(len(result.Binary))
+	tap := tapHeader(tapCode, "PROGRAM", length, result.Origin, 32768)
+	return append(tap, tapBlock(tapDataFlag, result.Binary)...)
+}
+
+// autorunLoader returns the tokenized BASIC loader
+//
+//	10 CLEAR VAL "clear": LOAD ""CODE : RANDOMIZE USR VAL "entry"
+//
+// Numbers are written as VAL strings so no hidden 5-byte numbers are needed.
+func autorunLoader(clear, entry uint16) []byte {
+	var text []byte
+	text = append(text, tokenCLEAR, tokenVAL)
+	text = append(text, fmt.Sprintf("%q", fmt.Sprint(clear))...)
+	text = append(text, ':', tokenLOAD, '"', '"', tokenCODE, ':')
+	text = append(text, tokenRANDOMIZE, tokenUSR, tokenVAL)
+	text = append(text, fmt.Sprintf("%q", fmt.Sprint(entry))...)
+	text = append(text, 0x0D) // ENTER ends the line
+
+	// Line number is big-endian, the text length little-endian
+	line := []byte{byte(autorunLine >> 8), byte(autorunLine), byte(len(text)), byte(len(text) >> 8)}
+	return append(line, text...)
+}
+
+// GenerateAutorunTAP creates a .TAP file that runs itself: a BASIC loader
+// that CLEARs just below the code, loads the CODE file that follows it and
+// jumps to the code with RANDOMIZE USR. The code loads at the origin of the
+// assembled binary, which is also the entry point.
+func GenerateAutorunTAP(result *Result) ([]byte, error) {
+	if result.Origin < 0x5D00 {
+		return nil, fmt.Errorf("code at $%04X overlaps BASIC; an autorun TAP needs an origin of at least $5D00",
+			result.Origin)
+	}
+	entry := result.Origin
+	clear := result.Origin - 1
+
+	loader := autorunLoader(clear, entry)
+	tap := tapHeader(tapProgram, "loader", uint16(len(loader)), autorunLine, uint16(len(loader)))
+	tap = append(tap, tapBlock(tapDataFlag, loader)...)
+	return append(tap, tapCodeFile(result)...), nil
+}
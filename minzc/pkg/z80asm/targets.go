@@ -2,6 +2,7 @@ package z80asm
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -12,6 +13,7 @@ const (
 	TargetGeneric    Target = "generic"    // Default Z80
 	TargetZXSpectrum Target = "zxspectrum" // ZX Spectrum 48K/128K
 	TargetZXTap      Target = "zxtap"      // ZX Spectrum .tap files
+	TargetZXNext     Target = "zxnext"     // ZX Spectrum Next (Z80N)
 	TargetCPM        Target = "cpm"        // CP/M systems
 	TargetMSX        Target = "msx"        // MSX computers
 	TargetGameBoy    Target = "gameboy"    // Game Boy (Z80-like)
@@ -36,6 +38,7 @@ type MemoryLayout struct {
 	ROMSize       uint16
 	ScreenBase    uint16
 	StackTop      uint16
+	Sections      []SectionSpec // Where SECTION places CODE, DATA and BSS
 }
 
 // OutputFormat defines how to generate platform-specific output files
@@ -45,6 +48,7 @@ type OutputFormat struct {
 	HeaderSize   int
 	Loader       bool
 	Compression  bool
+	ROM          bool // Burned to ROM: the program cannot write to its code
 	Generator    func(*Result) ([]byte, error)
 }
 
@@ -87,6 +91,11 @@ var targetRegistry = map[Target]*TargetConfig{
 			ROMSize:       16384,     // 16K ROM
 			ScreenBase:    0x4000,    // Screen memory start
 			StackTop:      0xFFFF,    // Top of memory
+			Sections: []SectionSpec{
+				{Name: "CODE", Base: 0x8000, Limit: 0xBFFF}, // Uncontended RAM
+				{Name: "DATA", Auto: true, Limit: 0xFFFF},
+				{Name: "BSS", Auto: true, Limit: 0xFFFF},
+			},
 		},
 		OutputFormat: OutputFormat{
 			Extension:   ".sna",
@@ -125,6 +134,11 @@ var targetRegistry = map[Target]*TargetConfig{
 			ROMSize:       16384,     // 16K ROM
 			ScreenBase:    0x4000,    // Screen memory start
 			StackTop:      0xFFFF,    // Top of memory
+			Sections: []SectionSpec{
+				{Name: "CODE", Base: 0x8000, Limit: 0xBFFF}, // Uncontended RAM
+				{Name: "DATA", Auto: true, Limit: 0xFFFF},
+				{Name: "BSS", Auto: true, Limit: 0xFFFF},
+			},
 		},
 		OutputFormat: OutputFormat{
 			Extension:   ".tap",
@@ -152,6 +166,45 @@ var targetRegistry = map[Target]*TargetConfig{
 		},
 	},
 
+	TargetZXNext: {
+		Name:        "ZX Spectrum Next",
+		Description: "ZX Spectrum Next with the Z80N CPU, run from NextZXOS",
+		MemoryLayout: MemoryLayout{
+			DefaultOrigin: 0x8000,    // Bank 2
+			RAMStart:      0x4000,    // Banks 5, 2 and 0
+			RAMSize:       49152,
+			ROMStart:      0x0000,
+			ROMSize:       16384,
+			ScreenBase:    0x4000,    // ULA screen in bank 5
+			StackTop:      0xFFFE,
+			Sections: []SectionSpec{
+				{Name: "CODE", Base: 0x8000, Limit: 0xBFFF}, // Bank 2
+				{Name: "DATA", Auto: true, Limit: 0xFFFE},
+				{Name: "BSS", Auto: true, Limit: 0xFFFE},
+			},
+		},
+		OutputFormat: OutputFormat{
+			Extension:   ".nex",
+			Description: "ZX Spectrum Next executable",
+			HeaderSize:  nexHeaderSize,
+			Generator:   generateNEXFile,
+		},
+		Conventions: PlatformConventions{
+			CallConvention: "Standard Z80",
+			RegisterUsage: map[string]string{
+				"IY": "System use - avoid",
+			},
+			CommonSymbols: map[string]uint16{
+				"ROM_CLS":        0x0DAF,  // Clear screen routine
+				"ROM_PRINT_A":    0x2B7E,  // Print character in A
+				"SCREEN_BASE":    0x4000,  // Screen memory start
+				"ATTR_BASE":      0x5800,  // Attribute memory start
+				"NEXTREG_SELECT": 0x243B,  // Port selecting a Next register
+				"NEXTREG_ACCESS": 0x253B,  // Port reading/writing it
+			},
+		},
+	},
+
 	TargetCPM: {
 		Name:        "CP/M",
 		Description: "CP/M 2.2 Operating System",
@@ -162,6 +215,11 @@ var targetRegistry = map[Target]*TargetConfig{
 			ROMStart:      0x0000,    // Boot/BIOS area
 			ROMSize:       256,       // System area
 			StackTop:      0xFEFF,    // Below BDOS
+			Sections: []SectionSpec{
+				{Name: "CODE", Base: 0x0100, Limit: 0xFEFF},
+				{Name: "DATA", Auto: true, Limit: 0xFEFF},
+				{Name: "BSS", Auto: true, Limit: 0xFEFF},
+			},
 		},
 		OutputFormat: OutputFormat{
 			Extension:   ".com",
@@ -200,11 +258,17 @@ var targetRegistry = map[Target]*TargetConfig{
 			ROMStart:      0x0000,    // BIOS/BASIC ROM
 			ROMSize:       32768,     // System ROM
 			StackTop:      0xF37F,    // Below system work area
+			Sections: []SectionSpec{
+				{Name: "CODE", Base: 0x8000, Limit: 0xBFFF}, // Cartridge page 2
+				{Name: "DATA", Base: 0xC000, Limit: 0xF37F}, // RAM in page 3
+				{Name: "BSS", Auto: true, Limit: 0xF37F},
+			},
 		},
 		OutputFormat: OutputFormat{
 			Extension:   ".rom",
 			Description: "MSX cartridge ROM",
 			HeaderSize:  16,          // ROM header
+			ROM:         true,
 			Generator:   generateMSXROM,
 		},
 		Conventions: PlatformConventions{
@@ -223,6 +287,56 @@ var targetRegistry = map[Target]*TargetConfig{
 			},
 		},
 	},
+
+	TargetGameBoy: {
+		Name:        "Game Boy",
+		Description: "Nintendo Game Boy with the SM83 CPU, as a 32K cartridge",
+		MemoryLayout: MemoryLayout{
+			DefaultOrigin: 0x0150,    // After the cartridge header
+			RAMStart:      0xC000,    // Work RAM
+			RAMSize:       8192,
+			ROMStart:      0x0000,    // Cartridge ROM
+			ROMSize:       32768,
+			ScreenBase:    0x9800,    // Background tile map
+			StackTop:      0xFFFE,    // Top of HRAM
+			Sections: []SectionSpec{
+				{Name: "CODE", Base: 0x0150, Limit: 0x7FFF},
+				{Name: "DATA", Auto: true, Limit: 0x7FFF},     // Also in ROM
+				{Name: "BSS", Base: 0xC000, Limit: 0xDFFF},    // Work RAM
+			},
+		},
+		OutputFormat: OutputFormat{
+			Extension:   ".gb",
+			Description: "Game Boy cartridge ROM",
+			HeaderSize:  gbHeaderEnd,
+			ROM:         true,
+			Generator:   generateGBROM,
+		},
+		Conventions: PlatformConventions{
+			CallConvention: "SM83",
+			CommonSymbols: map[string]uint16{
+				"VRAM":         0x8000,  // Tile data
+				"BG_MAP":       0x9800,  // Background tile map
+				"OAM":          0xFE00,  // Sprite attributes
+				"HRAM":         0xFF80,  // High RAM, reached with LDH
+				"JOYP":         0xFF00,  // Joypad
+				"DIV":          0xFF04,  // Divider
+				"IF_REG":       0xFF0F,  // Interrupts requested
+				"LCDC":         0xFF40,  // LCD control
+				"STAT":         0xFF41,  // LCD status
+				"SCY":          0xFF42,  // Background scroll
+				"SCX":          0xFF43,
+				"LY":           0xFF44,  // Line being drawn (144-153: VBlank)
+				"DMA":          0xFF46,  // OAM DMA
+				"BGP":          0xFF47,  // Background palette
+				"OBP0":         0xFF48,  // Sprite palettes
+				"OBP1":         0xFF49,
+				"WY":           0xFF4A,  // Window position
+				"WX":           0xFF4B,
+				"IE_REG":       0xFFFF,  // Interrupts enabled
+			},
+		},
+	},
 }
 
 // GetTargetConfig returns the configuration for a specific target
@@ -243,6 +357,56 @@ func ListTargets() []string {
 	return targets
 }
 
+// outputFormats are the formats -f selects, whatever the target
+var outputFormats = map[string]OutputFormat{
+	"bin": {Extension: ".bin", Description: "Raw binary file", Generator: generateBinaryFile},
+	"sna": {Extension: ".sna", Description: "ZX Spectrum snapshot", HeaderSize: 27, Generator: generateSNASnapshot},
+	"tap": {Extension: ".tap", Description: "ZX Spectrum tape with BASIC loader", Loader: true, Generator: GenerateAutorunTAP},
+	"hex": {Extension: ".hex", Description: "Intel HEX file", Generator: GenerateIntelHex},
+	"com": {Extension: ".com", Description: "CP/M executable", Generator: generateCOMFile},
+	"rom": {Extension: ".rom", Description: "MSX cartridge ROM", Generator: generateMSXROM},
+	"nex": {Extension: ".nex", Description: "ZX Spectrum Next executable", HeaderSize: nexHeaderSize, Generator: generateNEXFile},
+	"gb":  {Extension: ".gb", Description: "Game Boy cartridge ROM", HeaderSize: gbHeaderEnd, Generator: generateGBROM},
+}
+
+// ParseOutputFormat returns the output format with the given name
+func ParseOutputFormat(name string) (*OutputFormat, error) {
+	format, ok := outputFormats[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown output format: %s", name)
+	}
+	return &format, nil
+}
+
+// ListOutputFormats returns all output format names
+func ListOutputFormats() []string {
+	var names []string
+	for name := range outputFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ReadOnly returns the address ranges, inclusive, a program on the target
+// cannot write to: the machine's ROM and, when the output is a ROM, the
+// CODE section it is burned into
+func (c *TargetConfig) ReadOnly() [][2]uint16 {
+	var ranges [][2]uint16
+	layout := c.MemoryLayout
+	if layout.ROMSize > 0 {
+		ranges = append(ranges, [2]uint16{layout.ROMStart, layout.ROMStart + layout.ROMSize - 1})
+	}
+	if c.OutputFormat.ROM {
+		for _, section := range layout.Sections {
+			if section.Name == "CODE" && !section.Auto {
+				ranges = append(ranges, [2]uint16{section.Base, section.Limit})
+			}
+		}
+	}
+	return ranges
+}
+
 // ParseTarget parses a target string and returns the Target type
 func ParseTarget(targetStr string) (Target, error) {
 	target := Target(strings.ToLower(targetStr))
@@ -261,6 +425,12 @@ func (a *Assembler) SetTarget(target Target) error {
 
 	a.target = config
 	a.origin = config.MemoryLayout.DefaultOrigin
+	if target == TargetZXNext {
+		a.Z80N = true
+	}
+	if target == TargetGameBoy {
+		a.SM83 = true
+	}
 
 	// Add platform-specific symbols
 	for symbol, addr := range config.Conventions.CommonSymbols {
@@ -284,6 +454,9 @@ func (a *Assembler) ValidateMemoryLayout() error {
 	if a.target == nil {
 		return nil // Generic target - no validation
 	}
+	if a.relocatable {
+		return nil // An object module has no address until it is linked
+	}
 
 	layout := a.target.MemoryLayout
 	
@@ -303,7 +476,7 @@ func (a *Assembler) ValidateMemoryLayout() error {
 	
 	// Platform-specific warnings
 	switch a.target.Name {
-	case "ZX Spectrum":
+	case "ZX Spectrum", "ZX Spectrum Next":
 		if a.origin < 0x5B00 && codeEnd > 0x4000 {
 			warning := "Code overlaps with screen memory ($4000-$5AFF)"
 			a.warnings = append(a.warnings, warning)
@@ -424,65 +597,10 @@ func generateMSXROM(result *Result) ([]byte, error) {
 	return rom, nil
 }
 
-// generateTAPFile creates a ZX Spectrum .TAP tape file
+// generateTAPFile creates a ZX Spectrum .TAP tape file holding the binary
+// as a CODE file, loaded with LOAD ""CODE
 func generateTAPFile(result *Result) ([]byte, error) {
-	// TAP format consists of blocks with length headers
-	var tap []byte
-	
-	// Create a CODE block (machine code)
-	// TAP format: [length_lo][length_hi][data...]
-	
-	// Header block first (17 bytes + 2 byte length)
-	headerData := make([]byte, 17)
-	headerData[0] = 0x00    // Header flag
-	headerData[1] = 0x03    // CODE file type
-	
-	// Filename (10 bytes, padded with spaces)
-	filename := "PROGRAM   "
-	copy(headerData[2:12], []byte(filename))
-	
-	// Data length (little-endian)
-	dataLen := uint16(len(result.Binary))
-	headerData[12] = byte(dataLen)
-	headerData[13] = byte(dataLen >> 8)
-	
-	// Start address (little-endian)
-	headerData[14] = byte(result.Origin)
-	headerData[15] = byte(result.Origin >> 8)
-	
-	// Unused parameter for CODE blocks (same as data length)
-	headerData[16] = byte(dataLen)
-	
-	// Calculate header checksum
-	headerChecksum := byte(0)
-	for _, b := range headerData {
-		headerChecksum ^= b
-	}
-	
-	// Add header block to TAP
-	headerBlockLen := uint16(18) // 17 bytes + 1 checksum
-	tap = append(tap, byte(headerBlockLen), byte(headerBlockLen>>8))
-	tap = append(tap, headerData...)
-	tap = append(tap, headerChecksum)
-	
-	// Data block
-	dataBlock := make([]byte, len(result.Binary)+2)
-	dataBlock[0] = 0xFF // Data flag
-	copy(dataBlock[1:], result.Binary)
-	
-	// Calculate data checksum
-	dataChecksum := byte(0)
-	for _, b := range dataBlock[:len(dataBlock)-1] {
-		dataChecksum ^= b
-	}
-	dataBlock[len(dataBlock)-1] = dataChecksum
-	
-	// Add data block to TAP
-	dataBlockLen := uint16(len(dataBlock))
-	tap = append(tap, byte(dataBlockLen), byte(dataBlockLen>>8))
-	tap = append(tap, dataBlock...)
-	
-	return tap, nil
+	return tapCodeFile(result), nil
 }
 
 // Add target field to Assembler struct (this would go in assembler.go)
@@ -0,0 +1,149 @@
+package z80asm
+
+// baseTstates holds the T-states of the unprefixed opcodes. Conditional
+// jumps, calls and returns hold the count when the condition fails; the
+// prefixes CB, DD, ED and FD are 0.
+var baseTstates = [256]uint8{
+	//  0   1   2   3   4   5   6   7   8   9   A   B   C   D   E   F
+	4, 10, 7, 6, 4, 4, 7, 4, 4, 11, 7, 6, 4, 4, 7, 4, // 0x
+	8, 10, 7, 6, 4, 4, 7, 4, 12, 11, 7, 6, 4, 4, 7, 4, // 1x
+	7, 10, 16, 6, 4, 4, 7, 4, 7, 11, 16, 6, 4, 4, 7, 4, // 2x
+	7, 10, 13, 6, 11, 11, 10, 4, 7, 11, 13, 6, 4, 4, 7, 4, // 3x
+	4, 4, 4, 4, 4, 4, 7, 4, 4, 4, 4, 4, 4, 4, 7, 4, // 4x
+	4, 4, 4, 4, 4, 4, 7, 4, 4, 4, 4, 4, 4, 4, 7, 4, // 5x
+	4, 4, 4, 4, 4, 4, 7, 4, 4, 4, 4, 4, 4, 4, 7, 4, // 6x
+	7, 7, 7, 7, 7, 7, 4, 7, 4, 4, 4, 4, 4, 4, 7, 4, // 7x
+	4, 4, 4, 4, 4, 4, 7, 4, 4, 4, 4, 4, 4, 4, 7, 4, // 8x
+	4, 4, 4, 4, 4, 4, 7, 4, 4, 4, 4, 4, 4, 4, 7, 4, // 9x
+	4, 4, 4, 4, 4, 4, 7, 4, 4, 4, 4, 4, 4, 4, 7, 4, // Ax
+	4, 4, 4, 4, 4, 4, 7, 4, 4, 4, 4, 4, 4, 4, 7, 4, // Bx
+	5, 10, 10, 10, 10, 11, 7, 11, 5, 10, 10, 0, 10, 17, 7, 11, // Cx
+	5, 10, 10, 11, 10, 11, 7, 11, 5, 4, 10, 11, 10, 0, 7, 11, // Dx
+	5, 10, 10, 19, 10, 11, 7, 11, 5, 4, 10, 4, 10, 0, 7, 11, // Ex
+	5, 10, 10, 4, 10, 11, 7, 11, 5, 6, 10, 4, 10, 0, 7, 11, // Fx
+}
+
+// edTstates holds the T-states of the ED-prefixed opcodes that are not
+// the 8 T-state default; the block instructions hold the count of their
+// last iteration
+var edTstates = map[byte]int{
+	0x40: 12, 0x48: 12, 0x50: 12, 0x58: 12, 0x60: 12, 0x68: 12, 0x70: 12, 0x78: 12, // IN r, (C)
+	0x41: 12, 0x49: 12, 0x51: 12, 0x59: 12, 0x61: 12, 0x69: 12, 0x71: 12, 0x79: 12, // OUT (C), r
+	0x42: 15, 0x52: 15, 0x62: 15, 0x72: 15, 0x4A: 15, 0x5A: 15, 0x6A: 15, 0x7A: 15, // SBC/ADC HL, rr
+	0x43: 20, 0x53: 20, 0x63: 20, 0x73: 20, 0x4B: 20, 0x5B: 20, 0x6B: 20, 0x7B: 20, // LD (nn), rr / LD rr, (nn)
+	0x45: 14, 0x55: 14, 0x65: 14, 0x75: 14, 0x4D: 14, 0x5D: 14, 0x6D: 14, 0x7D: 14, // RETN, RETI
+	0x47: 9, 0x4F: 9, 0x57: 9, 0x5F: 9, // LD I/R, A and LD A, I/R
+	0x67: 18, 0x6F: 18, // RRD, RLD
+	0xA0: 16, 0xA1: 16, 0xA2: 16, 0xA3: 16, 0xA8: 16, 0xA9: 16, 0xAA: 16, 0xAB: 16,
+	0xB0: 16, 0xB1: 16, 0xB2: 16, 0xB3: 16, 0xB8: 16, 0xB9: 16, 0xBA: 16, 0xBB: 16,
+	// Z80N
+	0x27: 11, 0x34: 16, 0x35: 16, 0x36: 16, 0x8A: 23, 0x90: 16, 0x91: 20, 0x92: 17,
+	0x98: 13, 0xA4: 16, 0xA5: 14, 0xAC: 16, 0xB4: 16, 0xB7: 16, 0xBC: 16,
+}
+
+// edRepeats holds the ED block instructions that repeat, which take 21
+// T-states for every iteration but the last
+var edRepeats = map[byte]bool{
+	0xB0: true, 0xB1: true, 0xB2: true, 0xB3: true, 0xB8: true, 0xB9: true, 0xBA: true, 0xBB: true,
+	0xB4: true, 0xB7: true, 0xBC: true,
+}
+
+// takenTstates holds the T-states of the unprefixed conditional
+// instructions when the branch is taken
+var takenTstates = map[byte]int{
+	0x10: 13,                               // DJNZ
+	0x20: 12, 0x28: 12, 0x30: 12, 0x38: 12, // JR cc
+	0xC0: 11, 0xC8: 11, 0xD0: 11, 0xD8: 11, 0xE0: 11, 0xE8: 11, 0xF0: 11, 0xF8: 11, // RET cc
+	0xC4: 17, 0xCC: 17, 0xD4: 17, 0xDC: 17, 0xE4: 17, 0xEC: 17, 0xF4: 17, 0xFC: 17, // CALL cc
+}
+
+// Timing returns the T-states of the instruction at the start of code.
+// taken is the count when a conditional jump, call or return is taken or a
+// block instruction repeats; for other instructions it equals tstates.
+// Both are 0 if code does not start with a whole instruction.
+func Timing(code []byte) (tstates, taken int) {
+	if len(code) == 0 {
+		return 0, 0
+	}
+	switch op := code[0]; op {
+	case 0xCB:
+		if len(code) < 2 {
+			return 0, 0
+		}
+		t := 8
+		if code[1]&7 == 6 { // (HL)
+			t = 15
+			if code[1]&0xC0 == 0x40 { // BIT
+				t = 12
+			}
+		}
+		return t, t
+	case 0xED:
+		if len(code) < 2 {
+			return 0, 0
+		}
+		t, ok := edTstates[code[1]]
+		if !ok {
+			t = 8
+		}
+		if edRepeats[code[1]] {
+			return t, 21
+		}
+		return t, t
+	case 0xDD, 0xFD:
+		return indexedTiming(code)
+	default:
+		t := int(baseTstates[op])
+		if taken, ok := takenTstates[op]; ok {
+			return t, taken
+		}
+		return t, t
+	}
+}
+
+// indexedTiming returns the T-states of a DD- or FD-prefixed instruction.
+// IX and IY take 4 T-states more than HL, and 12 more where (HL) becomes
+// (IX+d).
+func indexedTiming(code []byte) (int, int) {
+	if len(code) < 2 {
+		return 0, 0
+	}
+	op := code[1]
+	if op == 0xCB {
+		if len(code) < 4 {
+			return 0, 0
+		}
+		if code[3]&0xC0 == 0x40 { // BIT b, (IX+d)
+			return 20, 20
+		}
+		return 23, 23
+	}
+	if op == 0xDD || op == 0xED || op == 0xFD {
+		// The first prefix acts as a NOP
+		t, taken := Timing(code[1:])
+		return 4 + t, 4 + taken
+	}
+	base, taken := Timing(code[1:])
+	if usesIndirectHL(op) {
+		if op == 0x36 { // LD (IX+d), n
+			return 19, 19
+		}
+		return base + 12, taken + 12
+	}
+	return base + 4, taken + 4
+}
+
+// usesIndirectHL reports whether an unprefixed opcode reads or writes (HL)
+func usesIndirectHL(op byte) bool {
+	switch {
+	case op == 0x34 || op == 0x35 || op == 0x36:
+		return true
+	case op == 0x76: // HALT
+		return false
+	case op >= 0x40 && op < 0x80:
+		return op&7 == 6 || op&0xF8 == 0x70
+	case op >= 0x80 && op < 0xC0:
+		return op&7 == 6
+	}
+	return false
+}
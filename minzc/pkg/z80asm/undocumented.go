@@ -87,7 +87,7 @@ func encodeSLLIndex(a *Assembler, line *Line, def *InstructionDef) ([]byte, erro
 	operand := line.Operands[0]
 	
 	if isIndexedOperand(operand, "IX") {
-		offset, err := getIndexOffset(operand)
+		offset, err := a.getIndexOffset(operand)
 		if err != nil {
 			return nil, err
 		}
@@ -95,7 +95,7 @@ func encodeSLLIndex(a *Assembler, line *Line, def *InstructionDef) ([]byte, erro
 	}
 	
 	if isIndexedOperand(operand, "IY") {
-		offset, err := getIndexOffset(operand)
+		offset, err := a.getIndexOffset(operand)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,106 @@
+package z80asm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The Z80N is the Z80 of the ZX Spectrum Next, which adds ED-prefixed
+// instructions for multiplication, 8-bit adds to register pairs, barrel
+// shifts, block copies with a transparent byte and screen address helpers.
+// They are only assembled with the Z80N option (set by the zxnext target),
+// as they run on no other Z80.
+
+// errZ80N is reported for a Z80N instruction when they are not enabled
+var errZ80N = errors.New("Z80N instruction needs the zxnext target (or --z80n)")
+
+// z80nInstructions are the Z80N instruction patterns. They come after the
+// Z80 ones, which take precedence for the same mnemonic.
+var z80nInstructions = []InstructionPattern{
+	{Mnemonic: "SWAPNIB", Encoding: []byte{0xED, 0x23}, Z80N: true},
+	{Mnemonic: "MIRROR", Encoding: []byte{0xED, 0x24}, Z80N: true},
+	{Mnemonic: "MIRROR", Operands: []OperandPattern{{OpTypeReg8, "A"}}, Encoding: []byte{0xED, 0x24}, Z80N: true},
+	{Mnemonic: "TEST", Operands: []OperandPattern{{OpTypeImm8, ""}}, EncodingFunc: encodeLD8Imm, Encoding: []byte{0xED, 0x27}, Z80N: true},
+
+	// Barrel shifts of DE by B
+	{Mnemonic: "BSLA", Operands: []OperandPattern{{OpTypeReg16, "DE"}, {OpTypeReg8, "B"}}, Encoding: []byte{0xED, 0x28}, Z80N: true},
+	{Mnemonic: "BSRA", Operands: []OperandPattern{{OpTypeReg16, "DE"}, {OpTypeReg8, "B"}}, Encoding: []byte{0xED, 0x29}, Z80N: true},
+	{Mnemonic: "BSRL", Operands: []OperandPattern{{OpTypeReg16, "DE"}, {OpTypeReg8, "B"}}, Encoding: []byte{0xED, 0x2A}, Z80N: true},
+	{Mnemonic: "BSRF", Operands: []OperandPattern{{OpTypeReg16, "DE"}, {OpTypeReg8, "B"}}, Encoding: []byte{0xED, 0x2B}, Z80N: true},
+	{Mnemonic: "BRLC", Operands: []OperandPattern{{OpTypeReg16, "DE"}, {OpTypeReg8, "B"}}, Encoding: []byte{0xED, 0x2C}, Z80N: true},
+
+	// DE = D * E
+	{Mnemonic: "MUL", Encoding: []byte{0xED, 0x30}, Z80N: true},
+	{Mnemonic: "MUL", Operands: []OperandPattern{{OpTypeReg8, "D"}, {OpTypeReg8, "E"}}, Encoding: []byte{0xED, 0x30}, Z80N: true},
+
+	// 8-bit and immediate adds to register pairs
+	{Mnemonic: "ADD", Operands: []OperandPattern{{OpTypeReg16, "HL"}, {OpTypeReg8, "A"}}, Encoding: []byte{0xED, 0x31}, Z80N: true},
+	{Mnemonic: "ADD", Operands: []OperandPattern{{OpTypeReg16, "DE"}, {OpTypeReg8, "A"}}, Encoding: []byte{0xED, 0x32}, Z80N: true},
+	{Mnemonic: "ADD", Operands: []OperandPattern{{OpTypeReg16, "BC"}, {OpTypeReg8, "A"}}, Encoding: []byte{0xED, 0x33}, Z80N: true},
+	{Mnemonic: "ADD", Operands: []OperandPattern{{OpTypeReg16, "HL"}, {OpTypeImm16, ""}}, EncodingFunc: encodeLD16Imm, Encoding: []byte{0xED, 0x34}, Z80N: true},
+	{Mnemonic: "ADD", Operands: []OperandPattern{{OpTypeReg16, "DE"}, {OpTypeImm16, ""}}, EncodingFunc: encodeLD16Imm, Encoding: []byte{0xED, 0x35}, Z80N: true},
+	{Mnemonic: "ADD", Operands: []OperandPattern{{OpTypeReg16, "BC"}, {OpTypeImm16, ""}}, EncodingFunc: encodeLD16Imm, Encoding: []byte{0xED, 0x36}, Z80N: true},
+
+	// PUSH nn stores its operand big-endian
+	{Mnemonic: "PUSH", Operands: []OperandPattern{{OpTypeImm16, ""}}, EncodingFunc: encodePushImm, Encoding: []byte{0xED, 0x8A}, Z80N: true},
+
+	{Mnemonic: "OUTINB", Encoding: []byte{0xED, 0x90}, Z80N: true},
+	{Mnemonic: "NEXTREG", Operands: []OperandPattern{{OpTypeImm8, ""}, {OpTypeReg8, "A"}}, EncodingFunc: encodeLD8Imm, Encoding: []byte{0xED, 0x92}, Z80N: true},
+	{Mnemonic: "NEXTREG", Operands: []OperandPattern{{OpTypeImm8, ""}, {OpTypeImm8, ""}}, EncodingFunc: encodeNextRegImm, Encoding: []byte{0xED, 0x91}, Z80N: true},
+
+	// Screen addresses: PIXELAD sets HL to the address of pixel (E, D),
+	// PIXELDN moves it a line down and SETAE sets A to the mask of pixel E
+	{Mnemonic: "PIXELDN", Encoding: []byte{0xED, 0x93}, Z80N: true},
+	{Mnemonic: "PIXELAD", Encoding: []byte{0xED, 0x94}, Z80N: true},
+	{Mnemonic: "SETAE", Encoding: []byte{0xED, 0x95}, Z80N: true},
+	{Mnemonic: "JP", Operands: []OperandPattern{{OpTypeIndReg, "(C)"}}, Encoding: []byte{0xED, 0x98}, Z80N: true},
+
+	// Block copies that skip bytes equal to A
+	{Mnemonic: "LDIX", Encoding: []byte{0xED, 0xA4}, Z80N: true},
+	{Mnemonic: "LDWS", Encoding: []byte{0xED, 0xA5}, Z80N: true},
+	{Mnemonic: "LDDX", Encoding: []byte{0xED, 0xAC}, Z80N: true},
+	{Mnemonic: "LDIRX", Encoding: []byte{0xED, 0xB4}, Z80N: true},
+	{Mnemonic: "LDPIRX", Encoding: []byte{0xED, 0xB7}, Z80N: true},
+	{Mnemonic: "LDDRX", Encoding: []byte{0xED, 0xBC}, Z80N: true},
+}
+
+// encodePushImm encodes PUSH nn, whose operand is stored high byte first
+func encodePushImm(a *Assembler, pattern *InstructionPattern, values []interface{}) ([]byte, error) {
+	value, ok := values[0].(uint16)
+	if !ok {
+		return nil, fmt.Errorf("no immediate value found")
+	}
+	return append(append([]byte{}, pattern.Encoding...), byte(value>>8), byte(value)), nil
+}
+
+// encodeNextRegImm encodes NEXTREG reg, value
+func encodeNextRegImm(a *Assembler, pattern *InstructionPattern, values []interface{}) ([]byte, error) {
+	register, ok1 := values[0].(uint16)
+	value, ok2 := values[1].(uint16)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("NEXTREG needs a register and a value")
+	}
+	return append(append([]byte{}, pattern.Encoding...), byte(register), byte(value)), nil
+}
+
+// takesRegisterAsImmediate reports whether pattern would read a register
+// operand of line as an immediate (a forward reference in pass 1), like
+// ADD HL,nn would read ADD HL,BC
+func takesRegisterAsImmediate(pattern InstructionPattern, line *Line) bool {
+	for i, operand := range pattern.Operands {
+		if i >= len(line.Operands) || operand.Type != OpTypeImm8 && operand.Type != OpTypeImm16 {
+			continue
+		}
+		op := strings.TrimSpace(line.Operands[i])
+		if parseReg8(op) != "" || parseReg16(op) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// z80nError reports a Z80N instruction assembled without the Z80N option
+func z80nError(line *Line) error {
+	return fmt.Errorf("%s: %w", strings.TrimSpace(line.Mnemonic+" "+strings.Join(line.Operands, ", ")), errZ80N)
+}
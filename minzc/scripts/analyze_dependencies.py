@@ -202,7 +202,7 @@ def generate_architecture_doc():
     
     print("### Entry Points")
     print("- `cmd/minzc/main.go` - Main compiler executable")
-    print("- `cmd/repl/main.go` - Interactive REPL")
+    print("- `cmd/mzr/main.go` - Interactive REPL")
     print("- `cmd/backend-info/main.go` - Backend information tool")
     print()
     